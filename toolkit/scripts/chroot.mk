@@ -7,14 +7,29 @@
 ######## CHROOT TOOLS ########
 
 chroot_worker = $(BUILD_DIR)/worker/worker_chroot.tar.gz
+worker_image_dir = $(OUT_DIR)/worker-image
+worker_image_tag = $(if $(WORKER_IMAGE_TAG),$(WORKER_IMAGE_TAG),$(RELEASE_VERSION))
 
-.PHONY: chroot-tools clean-chroot-tools validate-chroot
+.PHONY: chroot-tools clean-chroot-tools validate-chroot worker-image
 ##help:target:chroot-tools=Create the chroot working from the toolchain RPMs.
 chroot-tools: $(chroot_worker)
 
+##help:target:worker-image=Publish the worker chroot as a versioned OCI image in $(OUT_DIR)/worker-image, and optionally push it to WORKER_IMAGE_PUSH.
+worker-image: $(go-workerimage) $(chroot_worker)
+	$(go-workerimage) \
+		--worker-tar="$(chroot_worker)" \
+		--output-dir="$(worker_image_dir)" \
+		--tag="$(worker_image_tag)" \
+		$(if $(WORKER_IMAGE_PUSH),--push="$(WORKER_IMAGE_PUSH)") \
+		--log-file="$(LOGS_DIR)/worker/worker-image.log" \
+		--log-level="$(LOG_LEVEL)" \
+		--log-color="$(LOG_COLOR)" \
+		--log-format="$(LOG_FORMAT)"
+
 clean: clean-chroot-tools
 clean-chroot-tools:
 	rm -f $(chroot_worker)
+	rm -rf $(worker_image_dir)
 	@echo Verifying no mountpoints present in $(BUILD_DIR)/worker/
 	$(SCRIPTS_DIR)/safeunmount.sh "$(BUILD_DIR)/worker/" && \
 	$(SCRIPTS_DIR)/safeunmount.sh "$(BUILD_DIR)/validatechroot/" && \
@@ -49,10 +64,12 @@ validate-chroot: $(go-validatechroot) $(chroot_worker)
 	--rpm-dir="$(TOOLCHAIN_RPMS_DIR)" \
 	--tmp-dir="$(BUILD_DIR)/validatechroot" \
 	--worker-chroot="$(chroot_worker)" \
+	--rootless="$(ROOTLESS)" \
 	--worker-manifest="$(WORKER_CHROOT_MANIFEST)" \
 	--log-file="$(LOGS_DIR)/worker/validate.log" \
 	--log-level="$(LOG_LEVEL)" \
-	--log-color="$(LOG_COLOR)"
+	--log-color="$(LOG_COLOR)" \
+	--log-format="$(LOG_FORMAT)"
 
 ######## MACRO TOOLS ########
 
@@ -42,6 +42,8 @@ image_roaster_tmp_dir                = $(imggen_config_dir)/roaster_tmp
 validate-config                      = $(STATUS_FLAGS_DIR)/validate-image-config-$(config_name).flag
 meta_user_data_tmp_dir               = $(IMAGEGEN_DIR)/meta-user-data_tmp
 image_package_cache_summary          = $(imggen_config_dir)/image_deps.json
+image_package_lockfile               = $(imggen_config_dir)/image_deps.lock.json
+image_gpg_verification_report        = $(imggen_config_dir)/gpg_verification_report.json
 image_external_package_cache_summary = $(imggen_config_dir)/image_external_deps.json
 image_package_manifest               = $(imggen_config_dir)/image_pkg_manifest.json
 license_results_file_img             = $(imggen_config_dir)/license_check_results.json
@@ -62,7 +64,7 @@ $(call create_folder,$(imager_disk_output_dir))
 $(call create_folder,$(artifact_dir))
 $(call create_folder,$(meta_user_data_tmp_dir))
 
-.PHONY: fetch-image-packages fetch-external-image-packages make-raw-image image iso installer-initrd validate-image-config clean-imagegen
+.PHONY: fetch-image-packages fetch-external-image-packages image-gpg-keyring make-raw-image image iso installer-initrd validate-image-config clean-imagegen
 
 clean: clean-imagegen
 clean-imagegen:
@@ -90,6 +92,19 @@ fetch-image-packages: $(image_package_cache_summary)
 ##help:target:fetch-external-image-packages=Download all external packages required for an image build.
 fetch-external-image-packages: $(image_external_package_cache_summary)
 
+##help:target:image-gpg-keyring=Pin the keys of IMAGE_GPG_VALIDATION_KEYS in the IMAGE_GPG_KEYRING keyring, creating it if needed.
+# Use the gpgkeyring tool directly to rotate or remove keys.
+image-gpg-keyring: $(go-gpgkeyring)
+	$(if $(IMAGE_GPG_KEYRING),,$(error Must set IMAGE_GPG_KEYRING=<path>))
+	$(go-gpgkeyring) \
+		--keyring=$(IMAGE_GPG_KEYRING) \
+		--log-level=$(LOG_LEVEL) \
+		--log-file=$(LOGS_DIR)/imggen/gpgkeyring.log \
+		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
+		import \
+		$(foreach key,$(IMAGE_GPG_VALIDATION_KEYS),--key-file=$(key))
+
 ##help:target:validate-image-config=Validate the selected image config.
 # Validate the selected config file if any changes occur in the image config base directory.
 # Changes to files located outside the base directory will not be detected.
@@ -140,12 +155,20 @@ ifneq ($(REPO_SNAPSHOT_TIME),)
 imagepkgfetcher_extra_flags += --repo-snapshot-time=$(REPO_SNAPSHOT_TIME)
 endif
 
-ifeq ($(VALIDATE_IMAGE_GPG),y)
+ifneq ($(SHARED_PACKAGE_CACHE_DIR),)
+imagepkgfetcher_extra_flags += --package-cache-dir=$(SHARED_PACKAGE_CACHE_DIR)
+imagepkgfetcher_extra_flags += --package-cache-build-id=image-$(config_name)-$(SHARED_PACKAGE_CACHE_BUILD_ID)
+endif
+
+ifneq ($(IMAGE_GPG_KEYRING),)
+imagepkgfetcher_extra_flags += --gpg-keyring=$(IMAGE_GPG_KEYRING)
+imagepkgfetcher_extra_flags += --gpg-report-file=$(image_gpg_verification_report)
+else ifeq ($(VALIDATE_IMAGE_GPG),y)
 imagepkgfetcher_extra_flags += --enable-gpg-check
 imagepkgfetcher_extra_flags += $(foreach key,$(IMAGE_GPG_VALIDATION_KEYS),--gpg-key=$(key))
 endif
 
-$(image_package_cache_summary): $(go-imagepkgfetcher) $(chroot_worker) $(toolchain_rpms) $(imggen_local_repo) $(depend_REPO_LIST) $(REPO_LIST) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(validate-config) $(RPMS_DIR) $(imggen_rpms) $(depend_REPO_SNAPSHOT_TIME) $(depend_VALIDATE_IMAGE_GPG) $(depend_IMAGE_GPG_VALIDATION_KEYS) $(IMAGE_GPG_VALIDATION_KEYS) $(STATUS_FLAGS_DIR)/imagegen_cleanup.flag
+$(image_package_cache_summary): $(go-imagepkgfetcher) $(chroot_worker) $(toolchain_rpms) $(imggen_local_repo) $(depend_REPO_LIST) $(REPO_LIST) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(validate-config) $(RPMS_DIR) $(imggen_rpms) $(depend_REPO_SNAPSHOT_TIME) $(depend_VALIDATE_IMAGE_GPG) $(depend_IMAGE_GPG_VALIDATION_KEYS) $(IMAGE_GPG_VALIDATION_KEYS) $(depend_IMAGE_GPG_KEYRING) $(IMAGE_GPG_KEYRING) $(depend_IMAGE_LOCKFILE) $(IMAGE_LOCKFILE) $(STATUS_FLAGS_DIR)/imagegen_cleanup.flag
 	$(if $(CONFIG_FILE),,$(error Must set CONFIG_FILE=))
 	$(go-imagepkgfetcher) \
 		--input=$(CONFIG_FILE) \
@@ -153,16 +176,19 @@ $(image_package_cache_summary): $(go-imagepkgfetcher) $(chroot_worker) $(toolcha
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/imagepkgfetcher.log \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--rpm-dir=$(RPMS_DIR) \
 		--tmp-dir=$(image_fetcher_tmp_dir) \
 		--toolchain-rpms-dir="$(TOOLCHAIN_RPMS_DIR)" \
 		--tdnf-worker=$(chroot_worker) \
+		--rootless="$(ROOTLESS)" \
 		--tls-cert=$(TLS_CERT) \
 		--tls-key=$(TLS_KEY) \
 		$(foreach repo, $(imagefetcher_local_repo) $(imagefetcher_cloned_repo) $(REPO_LIST),--repo-file="$(repo)" ) \
 		$(imagepkgfetcher_extra_flags) \
-		--input-summary-file=$(IMAGE_CACHE_SUMMARY) \
+		$(if $(IMAGE_LOCKFILE),--input-lockfile=$(IMAGE_LOCKFILE),--input-summary-file=$(IMAGE_CACHE_SUMMARY)) \
 		--output-summary-file=$@ \
+		--output-lockfile=$(image_package_lockfile) \
 		--output-dir=$(local_and_external_rpm_cache) \
 		--cpu-prof-file=$(PROFILE_DIR)/imagepkgfetcher.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/imagepkgfetcher.mem.pprof \
@@ -189,6 +215,7 @@ $(STATUS_FLAGS_DIR)/imager_disk_output.flag: $(go-imager) $(image_package_cache_
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/imager.log \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--local-repo $(local_and_external_rpm_cache) \
 		--tdnf-worker $(chroot_worker) \
 		--repo-snapshot-time=$(REPO_SNAPSHOT_TIME) \
@@ -222,6 +249,7 @@ image: $(imager_disk_output_dir) $(imager_disk_output_files) $(go-roast) $(depen
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/roast.log \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--image-tag=$(IMAGE_TAG) \
 		--cpu-prof-file=$(PROFILE_DIR)/roast.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/roast.mem.pprof \
@@ -239,10 +267,12 @@ $(image_external_package_cache_summary): $(cached_file) $(go-imagepkgfetcher) $(
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/externalimagepkgfetcher.log \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--rpm-dir=$(RPMS_DIR) \
 		--tmp-dir=$(image_fetcher_tmp_dir) \
 		--toolchain-rpms-dir="$(TOOLCHAIN_RPMS_DIR)" \
 		--tdnf-worker=$(chroot_worker) \
+		--rootless="$(ROOTLESS)" \
 		--external-only \
 		--package-graph=$(graph_file) \
 		--tls-cert=$(TLS_CERT) \
@@ -268,12 +298,12 @@ initrd_bundled_files = $(go-liveinstaller) $(go-imager) $(assets_files) $(initrd
 
 $(initrd_img): $(initrd_bundled_files) $(initrd_config_json) $(INITRD_CACHE_SUMMARY) | $(iso_deps)
 	# Recursive make call to build the initrd image $(artifact_dir)/iso-initrd.img
-	$(MAKE) image MAKEOVERRIDES= CONFIG_FILE=$(initrd_config_json) IMAGE_CACHE_SUMMARY=$(INITRD_CACHE_SUMMARY) IMAGE_TAG= RELEASE_VERSION=$(RELEASE_VERSION) BUILD_NUMBER=$(BUILD_NUMBER)
+	$(MAKE) image MAKEOVERRIDES= CONFIG_FILE=$(initrd_config_json) IMAGE_CACHE_SUMMARY=$(INITRD_CACHE_SUMMARY) IMAGE_LOCKFILE=$(INITRD_LOCKFILE) IMAGE_GPG_KEYRING=$(IMAGE_GPG_KEYRING) IMAGE_TAG= RELEASE_VERSION=$(RELEASE_VERSION) BUILD_NUMBER=$(BUILD_NUMBER)
 
 ##help:target:installer-initrd=Create the initrd for the ISO installer.
 installer-initrd: $(initrd_img)
 
-##help:target:iso=Create an installable ISO.
+##help:target:iso=Create an installable ISO, or the PXE artifacts to network boot its installer. See ISO_OUTPUT_FORMAT.
 iso: $(initrd_img) $(iso_deps)
 	$(if $(CONFIG_FILE),,$(error Must set CONFIG_FILE=))
 	$(go-isomaker) \
@@ -288,8 +318,11 @@ iso: $(initrd_img) $(iso_deps)
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/isomaker.log \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		$(if $(filter y,$(UNATTENDED_INSTALLER)),--unattended-install) \
 		--output-dir $(artifact_dir) \
+		--output-format=$(ISO_OUTPUT_FORMAT) \
+		--pxe-base-url=$(ISO_PXE_BASE_URL) \
 		--image-tag=$(IMAGE_TAG)
 
 ##help:target:meta-user-data=Create a `meta-user-data.iso` file under `IMAGES_DIR` using `meta-data` and `user-data` from `META_USER_DATA_DIR`.
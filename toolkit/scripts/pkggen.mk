@@ -33,8 +33,12 @@ validate-pkggen-config = $(STATUS_FLAGS_DIR)/validate-image-config-pkggen.flag
 
 # Outputs
 specs_file               = $(PKGBUILD_DIR)/specs.json
+specs_parse_report_file  = $(PKGBUILD_DIR)/specs_parse_report.json
+macro_diagnostics_file   = $(PKGBUILD_DIR)/specs_macro_diagnostics.json
 rel_versions_macro_file  = $(PKGBUILD_DIR)/macros.releaseversions
 graph_file               = $(PKGBUILD_DIR)/graph.dot
+graph_specs_file         = $(PKGBUILD_DIR)/graph_specs.json
+graph_base_file          = $(PKGBUILD_DIR)/graph_base.dot
 cached_file              = $(PKGBUILD_DIR)/cached_graph.dot
 preprocessed_file        = $(PKGBUILD_DIR)/preprocessed_graph.dot
 built_file               = $(PKGBUILD_DIR)/built_graph.dot
@@ -42,11 +46,11 @@ output_csv_file          = $(PKGBUILD_DIR)/build_state.csv
 pkg_license_summary_file = $(PKGBUILD_DIR)/license_issues.txt
 pkg_license_results_file = $(PKGBUILD_DIR)/license_issues.json
 
-logging_command = --log-file=$(LOGS_DIR)/pkggen/workplan/$(notdir $@).log --log-level=$(LOG_LEVEL) --log-color=$(LOG_COLOR)
+logging_command = --log-file=$(LOGS_DIR)/pkggen/workplan/$(notdir $@).log --log-level=$(LOG_LEVEL) --log-color=$(LOG_COLOR) --log-format=$(LOG_FORMAT)
 $(call create_folder,$(LOGS_DIR)/pkggen/workplan)
 $(call create_folder,$(rpmbuilding_logs_dir))
 
-.PHONY: clean-workplan clean-cache clean-cache-worker clean-grapher-cache-worker clean-spec-parse clean-ccache graph graph-cache graph-preprocessed analyze-built-graph workplan
+.PHONY: clean-workplan clean-cache clean-cache-worker clean-grapher-cache-worker clean-spec-parse clean-ccache graph graph-cache graph-preprocessed analyze-built-graph workplan spec-macro-diagnostics
 ##help:target:parsed-specs=Parse package specs and generate a specs.json file encoding all dependency information.
 parse-specs: $(specs_file)
 ##help:target:graph-cache=Resolve package dependencies and cache the results.
@@ -77,6 +81,8 @@ clean-spec-parse:
 	$(SCRIPTS_DIR)/safeunmount.sh "$(parse_working_dir)" && \
 	rm -rf $(parse_working_dir)
 	rm -rf $(specs_file)
+	rm -rf $(specs_parse_report_file)
+	rm -rf $(macro_diagnostics_file)
 clean-ccache:
 	rm -rf $(CCACHE_DIR)
 
@@ -95,6 +101,7 @@ analyze-built-graph: $(go-graphanalytics)
 # Parse all SPECS in $(SPECS_DIR) and generate a release versions macros file containing macros of spec file versions and release.
 $(rel_versions_macro_file): $(chroot_worker) $(SPECS_DIR) $(build_specs) $(build_spec_dirs) $(go-versionsprocessor)
 	$(go-versionsprocessor) \
+		--rootless="$(ROOTLESS)" \
 		--dir $(SPECS_DIR) \
 		--dist-tag $(DIST_TAG) \
 		$(logging_command) \
@@ -114,8 +121,9 @@ $(rel_versions_macro_file): $(chroot_worker) $(SPECS_DIR) $(build_specs) $(build
 # Parse specs in $(SPECS_DIR) and generate a specs.json file encoding all dependency information
 # We look at the same pack list as the srpmpacker tool via the target $(SRPM_PACK_LIST) if it is set.
 # We only parse the spec files we will actually pack.
-$(specs_file): $(rel_versions_macro_file) $(chroot_worker) $(SPECS_DIR) $(build_specs) $(build_spec_dirs) $(go-specreader) $(depend_SPECS_DIR) $(depend_SRPM_PACK_LIST) $(depend_RUN_CHECK)
+$(specs_file): $(rel_versions_macro_file) $(chroot_worker) $(SPECS_DIR) $(build_specs) $(build_spec_dirs) $(go-specreader) $(depend_SPECS_DIR) $(depend_SRPM_PACK_LIST) $(depend_RUN_CHECK) $(depend_ALLOW_SPEC_PARSE_FAILURES)
 	$(go-specreader) \
+		--rootless="$(ROOTLESS)" \
 		--dir $(SPECS_DIR) \
 		$(if $(SRPM_PACK_LIST),--spec-list="$(SRPM_PACK_LIST)") \
 		--build-dir $(parse_working_dir) \
@@ -127,6 +135,8 @@ $(specs_file): $(rel_versions_macro_file) $(chroot_worker) $(SPECS_DIR) $(build_
 		--worker-tar $(chroot_worker) \
 		--versions-macro-file $(rel_versions_macro_file) \
 		$(if $(filter y,$(RUN_CHECK)),--run-check) \
+		--parse-report=$(specs_parse_report_file) \
+		$(if $(filter y,$(ALLOW_SPEC_PARSE_FAILURES)),--allow-parse-failures) \
 		$(logging_command) \
 		--cpu-prof-file=$(PROFILE_DIR)/specreader.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/specreader.mem.pprof \
@@ -138,6 +148,29 @@ $(specs_file): $(rel_versions_macro_file) $(chroot_worker) $(SPECS_DIR) $(build_
 		$(if $(TARGET_ARCH),--target-arch="$(TARGET_ARCH)") \
 		--output $@
 
+# Report the macros each spec in $(SPECS_DIR) depends on, which of them are undefined, and how parsing the specs for the
+# architectures in $(SPEC_DIAGNOSTICS_ARCHES) or with the macros in $(SPEC_DIAGNOSTICS_DEFINES) changes them.
+# Always regenerated, since it's only run on demand to debug the specs.
+##help:target:spec-macro-diagnostics=Report the macros used by the specs, the undefined ones, and how SPEC_DIAGNOSTICS_ARCHES and SPEC_DIAGNOSTICS_DEFINES change the parsed specs.
+spec-macro-diagnostics: $(rel_versions_macro_file) $(chroot_worker) $(go-specreader)
+	$(go-specreader) \
+		--rootless="$(ROOTLESS)" \
+		--macro-diagnostics \
+		--dir $(SPECS_DIR) \
+		$(if $(SRPM_PACK_LIST),--spec-list="$(SRPM_PACK_LIST)") \
+		--build-dir $(parse_working_dir) \
+		--srpm-dir $(BUILD_SRPMS_DIR) \
+		--rpm-dir $(RPMS_DIR) \
+		--toolchain-rpms-dir="$(TOOLCHAIN_RPMS_DIR)" \
+		--dist-tag $(DIST_TAG) \
+		--worker-tar $(chroot_worker) \
+		--versions-macro-file $(rel_versions_macro_file) \
+		$(foreach arch,$(SPEC_DIAGNOSTICS_ARCHES),--diagnostics-arch="$(arch)") \
+		$(foreach define,$(SPEC_DIAGNOSTICS_DEFINES),--diagnostics-define="$(define)") \
+		$(logging_command) \
+		$(if $(TARGET_ARCH),--target-arch="$(TARGET_ARCH)") \
+		--output $(macro_diagnostics_file)
+
 ifeq ($(RESOLVE_CYCLES_FROM_UPSTREAM),y)
    ifeq ($(DISABLE_UPSTREAM_REPOS),y)
       $(error RESOLVE_CYCLES_FROM_UPSTREAM requires upstream repos to be enabled. Please set DISABLE_UPSTREAM_REPOS=n)
@@ -146,6 +179,8 @@ endif
 
 # Convert the dependency information in the json file into a graph structure
 # We require all the toolchain RPMs to be available here to help resolve unfixable cyclic dependencies
+# With INCREMENTAL_GRAPH=y, the graph as it was before cycle resolution is saved along with the specs it was generated
+# from, so that the next run can update it. Otherwise, the saved pair would be out of date, so it is removed.
 $(graph_file): $(specs_file) $(go-grapher) $(toolchain_rpms) $(TOOLCHAIN_MANIFEST) $(pkggen_local_repo) $(graphpkgfetcher_cloned_repo) $(chroot_worker) $(depend_REPO_LIST) $(REPO_LIST) $(depend_REPO_SNAPSHOT_TIME)
 	$(go-grapher) \
 		--input $(specs_file) \
@@ -162,6 +197,7 @@ $(graph_file): $(specs_file) $(go-grapher) $(toolchain_rpms) $(TOOLCHAIN_MANIFES
 		$(if $(filter y,$(USE_PREVIEW_REPO)), --use-preview-repo) \
 		$(if $(filter y,$(DISABLE_DEFAULT_REPOS)), --disable-default-repos) \
 		$(if $(filter y,$(IGNORE_VERSION_TO_RESOLVE_SELFDEP)), --ignore-version-to-resolve-selfdep) \
+		$(if $(filter y,$(INCREMENTAL_GRAPH)), --previous-graph=$(graph_base_file) --previous-input=$(graph_specs_file) --base-graph-output=$(graph_base_file)) \
 		--output-dir=$(CACHED_RPMS_DIR)/cache \
 		--rpm-dir=$(RPMS_DIR) \
 		--toolchain-rpms-dir=$(TOOLCHAIN_RPMS_DIR) \
@@ -169,8 +205,10 @@ $(graph_file): $(specs_file) $(go-grapher) $(toolchain_rpms) $(TOOLCHAIN_MANIFES
 		--tls-key=$(TLS_KEY) \
 		--tmp-dir=$(grapher_working_dir) \
 		--tdnf-worker=$(chroot_worker) \
+		--rootless="$(ROOTLESS)" \
 		--repo-snapshot-time=$(REPO_SNAPSHOT_TIME) \
-		$(foreach repo, $(pkggen_local_repo) $(graphpkgfetcher_cloned_repo) $(REPO_LIST), --repo-file=$(repo))
+		$(foreach repo, $(pkggen_local_repo) $(graphpkgfetcher_cloned_repo) $(REPO_LIST), --repo-file=$(repo)) && \
+	$(if $(filter y,$(INCREMENTAL_GRAPH)),cp $(specs_file) $(graph_specs_file),rm -f $(graph_base_file) $(graph_specs_file))
 
 # We want to detect changes in the RPM cache, but we are not responsible for directly rebuilding any missing files.
 $(CACHED_RPMS_DIR)/%: ;
@@ -220,6 +258,11 @@ ifneq ($(REPO_SNAPSHOT_TIME),)
 graphpkgfetcher_extra_flags += --repo-snapshot-time=$(REPO_SNAPSHOT_TIME)
 endif
 
+ifneq ($(SHARED_PACKAGE_CACHE_DIR),)
+graphpkgfetcher_extra_flags += --package-cache-dir=$(SHARED_PACKAGE_CACHE_DIR)
+graphpkgfetcher_extra_flags += --package-cache-build-id=pkggen-$(SHARED_PACKAGE_CACHE_BUILD_ID)
+endif
+
 ifeq ($(PRECACHE),y)
 # Use highly parallel downlader to fully hydrate the cache before trying to use the package manager to download packages
 $(cached_file): $(STATUS_FLAGS_DIR)/precache.flag
@@ -250,6 +293,7 @@ $(cached_file): $(graph_file) $(go-graphpkgfetcher) $(chroot_worker) $(pkggen_lo
 		--toolchain-rpms-dir="$(TOOLCHAIN_RPMS_DIR)" \
 		--tmp-dir=$(cache_working_dir) \
 		--tdnf-worker=$(chroot_worker) \
+		--rootless="$(ROOTLESS)" \
 		--toolchain-manifest=$(TOOLCHAIN_MANIFEST) \
 		--extra-layers="$(EXTRA_BUILD_LAYERS)" \
 		--tls-cert=$(TLS_CERT) \
@@ -283,7 +327,7 @@ cache_archive	= $(OUT_DIR)/cache.tar.gz
 pkggen_archive	= $(OUT_DIR)/rpms.tar.gz
 srpms_archive  	= $(OUT_DIR)/srpms.tar.gz
 
-.PHONY: build-packages clean-build-packages hydrate-rpms compress-rpms clean-compress-rpms hydrate-srpms compress-srpms clean-compress-srpms clean-build-packages-workers
+.PHONY: build-packages clean-build-packages gc-package-cache hydrate-rpms compress-rpms clean-compress-rpms hydrate-srpms compress-srpms clean-compress-srpms clean-build-packages-workers
 
 ##help:target:build-packages=Build .rpm packages selected by PACKAGE_(RE)BUILD_LIST= and IMAGE_CONFIG=.
 # Execute the package build scheduler.
@@ -320,14 +364,26 @@ $(RPMS_DIR):
 	@touch $@
 endif
 
-$(STATUS_FLAGS_DIR)/build-rpms.flag: $(rel_versions_macro_file) $(no_repo_acl) $(preprocessed_file) $(chroot_worker) $(go-scheduler) $(go-pkgworker) $(depend_STOP_ON_PKG_FAIL) $(CONFIG_FILE) $(depend_CONFIG_FILE) $(depend_PACKAGE_BUILD_LIST) $(depend_PACKAGE_REBUILD_LIST) $(depend_PACKAGE_IGNORE_LIST) $(depend_MAX_CASCADING_REBUILDS) $(depend_TEST_RUN_LIST) $(depend_TEST_RERUN_LIST) $(depend_TEST_IGNORE_LIST) $(pkggen_rpms) $(srpms) $(BUILD_SRPMS_DIR) $(depend_EXTRA_BUILD_LAYERS) $(depend_LICENSE_CHECK_MODE)
+# The worker chroot is only needed when the packages aren't built in a worker image.
+$(STATUS_FLAGS_DIR)/build-rpms.flag: $(rel_versions_macro_file) $(no_repo_acl) $(preprocessed_file) $(if $(WORKER_IMAGE),,$(chroot_worker)) $(go-scheduler) $(go-pkgworker) $(depend_STOP_ON_PKG_FAIL) $(CONFIG_FILE) $(depend_CONFIG_FILE) $(depend_PACKAGE_BUILD_LIST) $(depend_PACKAGE_REBUILD_LIST) $(depend_PACKAGE_IGNORE_LIST) $(depend_MAX_CASCADING_REBUILDS) $(depend_TEST_RUN_LIST) $(depend_TEST_RERUN_LIST) $(depend_TEST_IGNORE_LIST) $(pkggen_rpms) $(srpms) $(BUILD_SRPMS_DIR) $(depend_EXTRA_BUILD_LAYERS) $(depend_LICENSE_CHECK_MODE) $(depend_WORKER_IMAGE)
 	$(go-scheduler) \
 		--input="$(preprocessed_file)" \
 		--output="$(built_file)" \
 		--output-build-state-csv-file="$(output_csv_file)" \
 		--workers="$(CONCURRENT_PACKAGE_BUILDS)" \
+		--scheduling-policy="$(PACKAGE_SCHEDULING_POLICY)" \
+		$(if $(PACKAGE_RESOURCE_CLASSES),--resource-classes-file="$(PACKAGE_RESOURCE_CLASSES)") \
+		--memory-budget="$(PACKAGE_BUILD_MEMORY_BUDGET)" \
+		--cpu-budget="$(PACKAGE_BUILD_CPU_BUDGET)" \
+		$(if $(SCHEDULER_STATUS_ADDRESS),--status-address="$(SCHEDULER_STATUS_ADDRESS)") \
+		$(if $(filter y,$(PACKAGE_BUILD_PROVENANCE)),--provenance) \
+		$(if $(PACKAGE_PROVENANCE_KEY),--provenance-key="$(PACKAGE_PROVENANCE_KEY)") \
+		$(if $(PACKAGE_PROVENANCE_BUILDER_ID),--provenance-builder-id="$(PACKAGE_PROVENANCE_BUILDER_ID)") \
+		--network-isolation="$(PACKAGE_BUILD_NETWORK)" \
+		$(foreach host,$(PACKAGE_BUILD_NETWORK_ALLOWLIST),--network-allowlist="$(host)" ) \
 		--work-dir="$(CHROOT_DIR)" \
-		--worker-tar="$(chroot_worker)" \
+		$(if $(WORKER_IMAGE),--worker-image="$(WORKER_IMAGE)",--worker-tar="$(chroot_worker)") \
+		--rootless="$(ROOTLESS)" \
 		--repo-file="$(pkggen_local_repo)" \
 		--rpm-dir="$(RPMS_DIR)" \
 		--toolchain-rpms-dir="$(TOOLCHAIN_RPMS_DIR)" \
@@ -341,9 +397,20 @@ $(STATUS_FLAGS_DIR)/build-rpms.flag: $(rel_versions_macro_file) $(no_repo_acl) $
 		--versions-macro-file="$(rel_versions_macro_file)" \
 		--build-attempts="$$(($(PACKAGE_BUILD_RETRIES)+1))" \
 		--check-attempts="$$(($(CHECK_BUILD_RETRIES)+1))" \
+		--transient-retries="$(PACKAGE_TRANSIENT_RETRIES)" \
+		--transient-retry-backoff="$(PACKAGE_TRANSIENT_RETRY_BACKOFF)" \
 		$(if $(MAX_CASCADING_REBUILDS),--max-cascading-rebuilds="$(MAX_CASCADING_REBUILDS)") \
 		--extra-layers="$(EXTRA_BUILD_LAYERS)" \
-		--build-agent="chroot-agent" \
+		$(if $(PACKAGE_BUILD_MEMORY_MAX),--chroot-memory-max="$(PACKAGE_BUILD_MEMORY_MAX)") \
+		$(if $(PACKAGE_BUILD_CPU_WEIGHT),--chroot-cpu-weight="$(PACKAGE_BUILD_CPU_WEIGHT)") \
+		$(if $(PACKAGE_BUILD_PIDS_MAX),--chroot-pids-max="$(PACKAGE_BUILD_PIDS_MAX)") \
+		$(if $(PACKAGE_BUILD_CGROUP_PARENT),--chroot-cgroup-parent="$(PACKAGE_BUILD_CGROUP_PARENT)") \
+		--build-agent="$(if $(REMOTE_BUILD_LISTEN_ADDRESS),remote-agent,chroot-agent)" \
+		$(if $(REMOTE_BUILD_LISTEN_ADDRESS),--remote-listen-address="$(REMOTE_BUILD_LISTEN_ADDRESS)") \
+		$(if $(REMOTE_BUILD_TLS_CERT),--remote-tls-cert="$(REMOTE_BUILD_TLS_CERT)") \
+		$(if $(REMOTE_BUILD_TLS_KEY),--remote-tls-key="$(REMOTE_BUILD_TLS_KEY)") \
+		$(if $(REMOTE_BUILD_TLS_CLIENT_CA),--remote-tls-client-ca="$(REMOTE_BUILD_TLS_CLIENT_CA)") \
+		$(if $(REMOTE_BUILD_TOKEN_FILE),--remote-token-file="$(REMOTE_BUILD_TOKEN_FILE)") \
 		--build-agent-program="$(go-pkgworker)" \
 		--ignored-packages="$(PACKAGE_IGNORE_LIST)" \
 		--packages="$(PACKAGE_BUILD_LIST)" \
@@ -397,6 +464,16 @@ compress-srpms:
 	tar -cvp -f $(BUILD_DIR)/temp_srpms_tarball.tar.gz -C $(SRPMS_DIR)/.. $(notdir $(SRPMS_DIR))
 	mv $(BUILD_DIR)/temp_srpms_tarball.tar.gz $(srpms_archive)
 
+##help:target:gc-package-cache=Remove the packages of the shared package cache (SHARED_PACKAGE_CACHE_DIR) which no build references, bounded by SHARED_PACKAGE_CACHE_MAX_AGE and SHARED_PACKAGE_CACHE_MAX_SIZE_MB.
+gc-package-cache: $(go-packagecache)
+	$(if $(SHARED_PACKAGE_CACHE_DIR),,$(error Must set SHARED_PACKAGE_CACHE_DIR=<path>))
+	$(go-packagecache) \
+		--cache-dir=$(SHARED_PACKAGE_CACHE_DIR) \
+		$(logging_command) \
+		gc \
+		$(if $(SHARED_PACKAGE_CACHE_MAX_AGE),--max-age=$(SHARED_PACKAGE_CACHE_MAX_AGE)) \
+		$(if $(SHARED_PACKAGE_CACHE_MAX_SIZE_MB),--max-size-mb=$(SHARED_PACKAGE_CACHE_MAX_SIZE_MB))
+
 ##help:target:hydrate-cached-rpms=Hydrates the external RPMs cache from the `CACHED_PACKAGES_ARCHIVE` file.
 # All of the '*.rpm' files inside the archive will be extracted into the cache directory in flat manner.
 # Any duplicates inside the archive's subdirectories will be overwritten by the last one.
@@ -32,6 +32,7 @@ $(STATUS_FLAGS_DIR)/precache.flag: $(go-precacher) $(chroot_worker) $(PRECACHER_
 		exit 1; \
 	fi
 	$(go-precacher) \
+		--rootless="$(ROOTLESS)" \
 		--snapshot "$(PRECACHER_SNAPSHOT)" \
 		--output-dir "$(remote_rpms_cache_dir)" \
 		--output-summary-file "$(precache_downloaded_files)" \
@@ -43,6 +44,7 @@ $(STATUS_FLAGS_DIR)/precache.flag: $(go-precacher) $(chroot_worker) $(PRECACHER_
 		--log-file=$(precache_logs_path) \
 		--log-level=$(LOG_LEVEL) \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--cpu-prof-file=$(PROFILE_DIR)/precacher.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/precacher.mem.pprof \
 		--trace-file=$(PROFILE_DIR)/precacher.trace \
@@ -37,6 +37,7 @@ $(STATUS_FLAGS_DIR)/repoquerywrapper.flag: $(go-repoquerywrapper) $(chroot_worke
 		cp $(QUERY_OUTPUT_FILE) $(QUERY_OUTPUT_FILE)-old; \
 	fi
 	$(go-repoquerywrapper) \
+		--rootless="$(ROOTLESS)" \
 		--query-input-file $(QUERY_INPUT_FILE) \
 		--query-cmd $(QUERY_CMD) \
 		--query-output-file $(QUERY_OUTPUT_FILE) \
@@ -47,6 +48,7 @@ $(STATUS_FLAGS_DIR)/repoquerywrapper.flag: $(go-repoquerywrapper) $(chroot_worke
 		--log-file=$(repoquerywrapper_logs_path) \
 		--log-level=$(LOG_LEVEL) \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--cpu-prof-file=$(PROFILE_DIR)/repoquerywrapper.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/repoquerywrapper.mem.pprof \
 		--trace-file=$(PROFILE_DIR)/repoquerywrapper.trace \
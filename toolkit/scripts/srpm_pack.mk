@@ -11,7 +11,15 @@
 # enforce - Source signatures must match those specified in a signatures file
 # skip    - Do not check signatures
 # update  - Check signatures and updating any mismatches in the signatures file
+# warn    - Check signatures, but only warn about mismatches
 SRPM_FILE_SIGNATURE_HANDLING ?= enforce
+##help:var:SRPM_SOURCE_KEYRING:<key_files>=Space separated list of GPG public key files to verify the detached signatures of sources against, as declared in the "GPGSignatures" of a "*.signatures.json" file. Required to pack SPECs that declare detached signatures, unless SRPM_FILE_SIGNATURE_HANDLING is "warn" or "skip".
+SRPM_SOURCE_KEYRING          ?=
+
+##help:var:SRPM_SOURCE_RETRY_BUDGET:<retries>=Number of retries shared by all source downloads while packing SRPMs. Once used up, SPECs with missing sources fail instead of retrying further.
+SRPM_SOURCE_RETRY_BUDGET     ?= 100
+##help:var:SRPM_SOURCE_DOWNLOAD_TIMEOUT:<duration>=Total time allowed for downloading sources while packing SRPMs, e.g. "30m". 0 for no limit.
+SRPM_SOURCE_DOWNLOAD_TIMEOUT ?= 0
 
 SRPM_BUILD_CHROOT_DIR   = $(BUILD_DIR)/SRPM_packaging
 SRPM_BUILD_LOGS_DIR     = $(LOGS_DIR)/pkggen/srpms
@@ -81,7 +89,9 @@ $(STATUS_FLAGS_DIR)/build_srpms.flag: $(rel_versions_macro_file) $(chroot_worker
 	GODEBUG=netdns=go $(go-srpmpacker) \
 		--dir=$(SPECS_DIR) \
 		--output-dir=$(BUILD_SRPMS_DIR) \
-		--source-url=$(SOURCE_URL) \
+		$(foreach url,$(SOURCE_URL),--source-url="$(url)") \
+		--source-retry-budget=$(SRPM_SOURCE_RETRY_BUDGET) \
+		--source-download-timeout=$(SRPM_SOURCE_DOWNLOAD_TIMEOUT) \
 		$(if $(SOURCE_AUTH_MODE),--source-auth-mode=$(SOURCE_AUTH_MODE)) \
 		--dist-tag=$(DIST_TAG) \
 		--ca-cert=$(CA_CERT) \
@@ -90,12 +100,15 @@ $(STATUS_FLAGS_DIR)/build_srpms.flag: $(rel_versions_macro_file) $(chroot_worker
 		--build-dir=$(SRPM_BUILD_CHROOT_DIR) \
 		--versions-macro-file=$(rel_versions_macro_file) \
 		--signature-handling=$(SRPM_FILE_SIGNATURE_HANDLING) \
+		$(foreach key,$(SRPM_SOURCE_KEYRING),--source-keyring="$(key)") \
 		--worker-tar=$(chroot_worker) \
+		--rootless="$(ROOTLESS)" \
 		$(if $(filter y,$(RUN_CHECK)),--run-check) \
 		$(if $(SRPM_PACK_LIST),--pack-list="$(SRPM_PACK_LIST)") \
 		--log-file=$(SRPM_BUILD_LOGS_DIR)/srpmpacker.log \
 		--log-level=$(LOG_LEVEL) \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--cpu-prof-file=$(PROFILE_DIR)/srpm_packer.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/srpm_packer.mem.pprof \
 		--trace-file=$(PROFILE_DIR)/srpm_packer.trace \
@@ -109,7 +122,9 @@ $(STATUS_FLAGS_DIR)/build_toolchain_srpms.flag: $(toolchain_files) $(go-srpmpack
 	GODEBUG=netdns=go $(go-srpmpacker) \
 		--dir=$(SPECS_DIR) \
 		--output-dir=$(BUILD_SRPMS_DIR) \
-		--source-url=$(SOURCE_URL) \
+		$(foreach url,$(SOURCE_URL),--source-url="$(url)") \
+		--source-retry-budget=$(SRPM_SOURCE_RETRY_BUDGET) \
+		--source-download-timeout=$(SRPM_SOURCE_DOWNLOAD_TIMEOUT) \
 		$(if $(SOURCE_AUTH_MODE),--source-auth-mode=$(SOURCE_AUTH_MODE)) \
 		--dist-tag=$(DIST_TAG) \
 		--ca-cert=$(CA_CERT) \
@@ -117,11 +132,13 @@ $(STATUS_FLAGS_DIR)/build_toolchain_srpms.flag: $(toolchain_files) $(go-srpmpack
 		--tls-key=$(TLS_KEY) \
 		--build-dir=$(SRPM_BUILD_CHROOT_DIR) \
 		--signature-handling=$(SRPM_FILE_SIGNATURE_HANDLING) \
+		$(foreach key,$(SRPM_SOURCE_KEYRING),--source-keyring="$(key)") \
 		--pack-list="$(toolchain_spec_list)" \
 		$(if $(filter y,$(RUN_CHECK)),--run-check) \
 		--log-file=$(LOGS_DIR)/toolchain/srpms/toolchain_srpmpacker.log \
 		--log-level=$(LOG_LEVEL) \
 		--log-color=$(LOG_COLOR) \
+		--log-format=$(LOG_FORMAT) \
 		--cpu-prof-file=$(PROFILE_DIR)/srpm_toolchain_packer.cpu.pprof \
 		--mem-prof-file=$(PROFILE_DIR)/srpm_toolchain_packer.mem.pprof \
 		--trace-file=$(PROFILE_DIR)/srpm_toolchain_packer.trace \
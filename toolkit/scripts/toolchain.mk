@@ -164,7 +164,7 @@ $(raw_toolchain): $(toolchain_files)
 		./create_toolchain_in_container.sh \
 			$(BUILD_DIR) \
 			$(SPECS_DIR) \
-			$(SOURCE_URL) \
+			$(firstword $(SOURCE_URL)) \
 			$(INCREMENTAL_TOOLCHAIN) \
 			$(ARCHIVE_TOOL) \
 			$(toolchain_raw_logs_dir) 2>&1 | tee $(toolchain_raw_logs_dir)/create_toolchain_in_container_full.log; \
@@ -106,7 +106,8 @@ $(rpms_snapshot_per_specs): $(go-rpmssnapshot) $(chroot_worker) $(local_specs) $
 		--worker-tar="$(chroot_worker)" \
 		--log-level=$(LOG_LEVEL) \
 		--log-file="$(rpms_snapshot_logs_path)" \
-		--log-color="$(LOG_COLOR)"
+		--log-color="$(LOG_COLOR)" \
+		--log-format="$(LOG_FORMAT)"
 
 print-build-summary:
 	sed -E -n 's:^.+level=info msg="Built \(([^\)]+)\) -> \[(.+)\].+$:\1\t\2:gp' $(LOGS_DIR)/pkggen/rpmbuilding/* | tee $(LOGS_DIR)/pkggen/build-summary.csv
@@ -128,9 +129,11 @@ $(valid_arch_spec_names): $(go-specarchchecker) $(chroot_worker) $(local_specs)
 		$(if $(filter y,$(RUN_CHECK)),--test-only) \
 		--dist-tag=$(DIST_TAG) \
 		--worker-tar="$(chroot_worker)" \
+		--rootless="$(ROOTLESS)" \
 		--log-level=$(LOG_LEVEL) \
 		--log-file="$(valid_arch_spec_names_logs_path)" \
-		--log-color="$(LOG_COLOR)"
+		--log-color="$(LOG_COLOR)" \
+		--log-format="$(LOG_FORMAT)"
 
 ##help:target:install-prereqs=Install basic build prerequisites automatically.
 install-prereqs:
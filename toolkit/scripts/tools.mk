@@ -39,9 +39,11 @@ endif
 go_tool_list = \
 	bldtracker \
 	boilerplate \
+	buildstatus \
 	containercheck \
 	depsearch \
 	downloader \
+	gpgkeyring \
 	grapher \
 	graphpkgfetcher \
 	graphanalytics \
@@ -49,13 +51,17 @@ go_tool_list = \
 	imageconfigvalidator \
 	imagecustomizer \
 	imagepkgfetcher \
+	imagetestrunner \
 	imager \
 	isomaker \
 	licensecheck \
 	liveinstaller \
 	osmodifier \
+	packagecache \
+	pkginfo \
 	pkgworker \
 	precacher \
+	remoteworker \
 	repoquerywrapper \
 	roast \
 	rpmssnapshot \
@@ -65,6 +71,7 @@ go_tool_list = \
 	versionsprocessor \
 	srpmpacker \
 	validatechroot \
+	workerimage \
 
 # For each utility "util", create a "out/tools/util" target which references code in "tools/util/"
 go_tool_targets = $(foreach target,$(go_tool_list),$(TOOL_BINS_DIR)/$(target))
@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// A terminal dashboard for the progress of a running package build
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/dashboard"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// ANSI escape sequence moving the cursor to the top left corner and clearing the terminal.
+	clearScreen = "\033[H\033[2J"
+)
+
+var (
+	app = kingpin.New("buildstatus", "Shows the progress of a running package build in the terminal")
+
+	address  = app.Flag("address", "Address ('<host>:<port>') the scheduler serves the build dashboard on (see the scheduler's --status-address).").Required().String()
+	interval = app.Flag("interval", "How often to refresh the progress.").Default("2s").Duration()
+	maxRows  = app.Flag("max-rows", "Maximum number of packages shown in each list.").Default("15").Int()
+	once     = app.Flag("once", "Print the progress once and exit instead of refreshing it.").Bool()
+
+	logFlags = exe.SetupLogFlags(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	client := &http.Client{Timeout: requestTimeout}
+	statusURL := fmt.Sprintf("http://%s%s", *address, dashboard.StatusPath)
+
+	for {
+		status, err := fetchStatus(client, statusURL)
+		if *once {
+			logger.FatalOnError(err, "Failed to get the build progress")
+			fmt.Print(dashboard.RenderText(status, *maxRows))
+			return
+		}
+
+		fmt.Print(clearScreen)
+		if err != nil {
+			fmt.Printf("Waiting for the scheduler (%s): %s\n", *address, err)
+		} else {
+			fmt.Print(dashboard.RenderText(status, *maxRows))
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+func fetchStatus(client *http.Client, statusURL string) (status dashboard.Status, err error) {
+	response, err := client.Get(statusURL)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected response (%s)", response.Status)
+		return
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&status)
+	return
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Tool to manage the GPG keys pinned for a build configuration, and to verify RPMs against them.
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/gpgkeyring"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	app = kingpin.New("gpgkeyring", "A tool to manage the GPG keys pinned for a build configuration, and to verify RPMs against them.")
+
+	keyringFile = app.Flag("keyring", "Path to the keyring file. The pinned key files are stored next to it.").Required().String()
+	logFlags    = exe.SetupLogFlags(app)
+
+	importCommand = app.Command("import", "Pin GPG keys, creating the keyring if needed.")
+	importKeys    = importCommand.Flag("key-file", "Path to a GPG key file holding a single key. May be specified multiple times.").Required().ExistingFiles()
+
+	rotateCommand = app.Command("rotate", "Pin a new GPG key and retire an old one after a grace period.")
+	rotateKey     = rotateCommand.Flag("key-file", "Path to the GPG key file of the new key.").Required().ExistingFile()
+	rotateOldKey  = rotateCommand.Flag("old-fingerprint", "Fingerprint of the pinned key to retire.").Required().String()
+	rotateGrace   = rotateCommand.Flag("grace-period", "How long packages signed by the old key are still trusted (e.g. 720h).").Default("0").Duration()
+	removeCommand = app.Command("remove", "Unpin a GPG key.")
+	removeKey     = removeCommand.Flag("fingerprint", "Fingerprint of the pinned key to remove.").Required().String()
+	listCommand   = app.Command("list", "List the pinned GPG keys.")
+	verifyCommand = app.Command("verify", "Verify that every RPM of a directory is signed by an active pinned key.")
+	verifyRpmDir  = verifyCommand.Flag("rpm-dir", "Directory of the RPMs to verify, searched recursively.").Required().ExistingDir()
+	verifyReport  = verifyCommand.Flag("report-file", "Path to save the per-package verification report to, as JSON.").String()
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	switch command {
+	case importCommand.FullCommand():
+		keyring, err := gpgkeyring.LoadOrNew(*keyringFile)
+		logger.PanicOnError(err, "Failed to open the GPG keyring")
+
+		for _, keyFile := range *importKeys {
+			_, err = keyring.Import(keyFile)
+			logger.PanicOnError(err, "Failed to import GPG key (%s)", keyFile)
+		}
+
+		err = keyring.Save()
+		logger.PanicOnError(err, "Failed to save the GPG keyring")
+
+	case rotateCommand.FullCommand():
+		keyring, err := gpgkeyring.Load(*keyringFile)
+		logger.PanicOnError(err, "Failed to open the GPG keyring")
+
+		err = keyring.Rotate(*rotateKey, *rotateOldKey, *rotateGrace)
+		logger.PanicOnError(err, "Failed to rotate GPG key (%s)", *rotateOldKey)
+
+		err = keyring.Save()
+		logger.PanicOnError(err, "Failed to save the GPG keyring")
+
+	case removeCommand.FullCommand():
+		keyring, err := gpgkeyring.Load(*keyringFile)
+		logger.PanicOnError(err, "Failed to open the GPG keyring")
+
+		err = keyring.Remove(*removeKey)
+		logger.PanicOnError(err, "Failed to remove GPG key (%s)", *removeKey)
+
+		err = keyring.Save()
+		logger.PanicOnError(err, "Failed to save the GPG keyring")
+
+	case listCommand.FullCommand():
+		keyring, err := gpgkeyring.Load(*keyringFile)
+		logger.PanicOnError(err, "Failed to open the GPG keyring")
+
+		now := time.Now()
+		for _, key := range keyring.Keys {
+			switch {
+			case key.IsRetired(now):
+				logger.Log.Infof("%s %v: retired on %s", key.Fingerprint, key.UserIDs, key.Retires.Format(time.RFC3339))
+			case key.Retires != nil:
+				logger.Log.Infof("%s %v: active, retiring on %s", key.Fingerprint, key.UserIDs, key.Retires.Format(time.RFC3339))
+			default:
+				logger.Log.Infof("%s %v: active", key.Fingerprint, key.UserIDs)
+			}
+		}
+
+	case verifyCommand.FullCommand():
+		err := gpgkeyring.LoadAndVerifyDirectory(*keyringFile, *verifyRpmDir, *verifyReport)
+		logger.PanicOnError(err, "Failed to verify the RPMs of (%s)", *verifyRpmDir)
+	}
+}
@@ -4,10 +4,14 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/depgraph"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/graphanalytics"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -15,23 +19,135 @@ import (
 
 const (
 	defaultMaxResults = "10"
+	defaultGoal       = "ALL"
+)
+
+const (
+	outputFormatTree = "tree"
+	outputFormatJSON = "json"
 )
 
 var (
 	app            = kingpin.New("graphanalytics", "A tool to print analytics of a given dependency graph.")
 	inputGraphFile = exe.InputFlag(app, "Path to the DOT graph file to analyze.")
-	maxResults     = app.Flag("max-results", "The number of results to print per category. Set 0 to print unlimited.").Default(defaultMaxResults).Int()
+	outputFormat   = app.Flag("output-format", "Format of the query results. Supported: tree, json.").Default(outputFormatTree).Enum(outputFormatTree, outputFormatJSON)
 	logFlags       = exe.SetupLogFlags(app)
+
+	analyzeCmd = app.Command("analyze", "Print the packages most blocking the build (default).").Default()
+	maxResults = analyzeCmd.Flag("max-results", "The number of results to print per category. Set 0 to print unlimited.").Default(defaultMaxResults).Int()
+
+	whyDependsCmd     = app.Command("why-depends", "Print the chains of dependents that cause a package to be built for a goal.")
+	whyDependsPackage = whyDependsCmd.Arg("package", "Name of the package.").Required().String()
+	whyDependsGoal    = whyDependsCmd.Flag("goal", "Name of the goal node.").Default(defaultGoal).String()
+
+	reverseDepsCmd      = app.Command("reverse-deps", "Print everything that depends on a package.")
+	reverseDepsPackage  = reverseDepsCmd.Arg("package", "Name of the package.").Required().String()
+	reverseDepsMaxDepth = reverseDepsCmd.Flag("max-depth", "Maximum depth of the dependents tree, -1 for unlimited.").Default("-1").Int()
+
+	criticalPathCmd     = app.Command("critical-path", "Print the longest chain of builds that must finish before a package is available.")
+	criticalPathPackage = criticalPathCmd.Arg("package", "Name of the package.").Required().String()
+
+	exportCmd          = app.Command("export", "Export the graph, or a part of it, for visualization tools (e.g. Graphviz or Gephi).")
+	exportFormat       = exportCmd.Flag("format", "Format of the exported graph. Supported: dot, graphml, json.").Default(depgraph.ExportFormatDOT).Enum(depgraph.ExportFormatDOT, depgraph.ExportFormatGraphML, depgraph.ExportFormatJSON)
+	exportOutput       = exportCmd.Flag("output", "Path to write the exported graph to.").Required().String()
+	exportRunNodesOnly = exportCmd.Flag("run-nodes-only", "Only export run nodes, connecting them directly where they depended on each other through other nodes.").Bool()
+	exportSRPMs        = exportCmd.Flag("srpms", "Space separated list of SRPMs or spec names. Only export their nodes and everything they depend on.").String()
+	exportMaxDepth     = exportCmd.Flag("max-depth", "Maximum number of edges away from the selected SRPMs' nodes to export, -1 for unlimited.").Default("-1").Int()
 )
 
 func main() {
 	app.Version(exe.ToolkitVersion)
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	logger.InitBestEffort(logFlags)
 
-	err := graphanalytics.AnalyzeGraph(*inputGraphFile, *maxResults)
+	if command == analyzeCmd.FullCommand() {
+		err := graphanalytics.AnalyzeGraph(*inputGraphFile, *maxResults)
+		if err != nil {
+			logger.Log.Fatalf("Unable to analyze dependency graph, error: %s", err)
+		}
+		return
+	}
+
+	var err error
+	if command == exportCmd.FullCommand() {
+		err = exportGraph()
+	} else {
+		err = runQuery(command)
+	}
+
 	if err != nil {
-		logger.Log.Fatalf("Unable to analyze dependency graph, error: %s", err)
+		logger.Log.Fatalf("%s failed:\n%v", command, err)
+	}
+}
+
+func exportGraph() (err error) {
+	pkgGraph, err := pkggraph.ReadDOTGraphFile(*inputGraphFile)
+	if err != nil {
+		return
+	}
+
+	options := depgraph.ExportOptions{
+		RunNodesOnly: *exportRunNodesOnly,
+		SRPMs:        exe.ParseListArgument(*exportSRPMs),
+		MaxDepth:     *exportMaxDepth,
 	}
+
+	err = depgraph.ExportGraphFile(pkgGraph, *exportFormat, options, *exportOutput)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Exported graph to (%s)", *exportOutput)
+	return
+}
+
+func runQuery(command string) (err error) {
+	pkgGraph, err := pkggraph.ReadDOTGraphFile(*inputGraphFile)
+	if err != nil {
+		return
+	}
+
+	var (
+		result interface{}
+		text   string
+	)
+
+	switch command {
+	case whyDependsCmd.FullCommand():
+		root, err := graphanalytics.WhyDepends(pkgGraph, *whyDependsPackage, *whyDependsGoal)
+		if err != nil {
+			return err
+		}
+		result, text = root, graphanalytics.FormatTree(root)
+
+	case reverseDepsCmd.FullCommand():
+		root, err := graphanalytics.ReverseDependencies(pkgGraph, *reverseDepsPackage, *reverseDepsMaxDepth)
+		if err != nil {
+			return err
+		}
+		result, text = root, graphanalytics.FormatTree(root)
+
+	case criticalPathCmd.FullCommand():
+		criticalPath, err := graphanalytics.FindCriticalPath(pkgGraph, *criticalPathPackage)
+		if err != nil {
+			return err
+		}
+		result, text = criticalPath, graphanalytics.FormatCriticalPath(criticalPath)
+	}
+
+	if *outputFormat == outputFormatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(result)
+		if err != nil {
+			return fmt.Errorf("failed to encode result:\n%w", err)
+		}
+		return nil
+	}
+
+	fmt.Print(text)
+	return nil
 }
@@ -12,11 +12,13 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner/rpmrepocloner"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repoutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/schedulerutils"
@@ -68,6 +70,10 @@ var (
 	inputSummaryFile  = app.Flag("input-summary-file", "Path to a file with the summary of packages cloned to be restored").String()
 	outputSummaryFile = app.Flag("output-summary-file", "Path to save the summary of packages cloned").String()
 
+	packageCacheDir     = app.Flag("package-cache-dir", "Optional: directory of the shared package cache to take the upstream packages from before downloading them, and to store the cloned packages in.").String()
+	packageCacheBuildID = app.Flag("package-cache-build-id", "ID of the build referencing the cloned packages in the shared package cache.").Default("pkggen").String()
+	rootless            = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
+
 	logFlags      = exe.SetupLogFlags(app)
 	profFlags     = exe.SetupProfileFlags(app)
 	timestampFile = app.Flag("timestamp-file", "File that stores timestamps for this program.").String()
@@ -78,6 +84,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
@@ -159,6 +170,14 @@ func fetchPackages(dependencyGraph *pkggraph.PkgGraph, hasUnresolvedNodes, tryDo
 		}
 	}
 
+	if strings.TrimSpace(*packageCacheDir) != "" {
+		err = repoutils.StoreClonedPackages(cloner, *packageCacheDir, *packageCacheBuildID)
+		if err != nil {
+			err = fmt.Errorf("failed to store cloned packages in the package cache:\n%w", err)
+			return
+		}
+	}
+
 	return
 }
 
@@ -181,6 +200,18 @@ func setupCloner() (cloner *rpmrepocloner.RpmRepoCloner, err error) {
 		enabledRepos = enabledRepos & ^rpmrepocloner.RepoFlagDistroDefaults
 	}
 	cloner.SetEnabledRepos(enabledRepos)
+
+	if strings.TrimSpace(*packageCacheDir) != "" {
+		var cache *packagecache.Cache
+
+		cache, err = packagecache.Open(*packageCacheDir)
+		if err != nil {
+			cloner.Close()
+			err = fmt.Errorf("failed to open the package cache:\n%w", err)
+			return
+		}
+		cloner.SetPackageCache(cache)
+	}
 	return
 }
 
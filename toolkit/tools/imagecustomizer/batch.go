@@ -0,0 +1,29 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	batchCommand = app.Command("batch", "Build multiple images at the same time, from a manifest of base images, config files and output images.")
+
+	batchManifestFile             = batchCommand.Flag("manifest", "Path of the batch manifest, which lists the images to build.").Required().String()
+	batchBuildDir                 = batchCommand.Flag("build-dir", "Directory to run the builds out of. Each image is built within its own subdirectory.").Required().String()
+	batchParallelism              = batchCommand.Flag("parallelism", "Maximum number of images to build at the same time.").Default("2").Int()
+	batchRpmSources               = batchCommand.Flag("rpm-source", "Path to a RPM repo config file or a directory containing RPMs. Applies to all the images.").Strings()
+	batchDisableBaseImageRpmRepos = batchCommand.Flag("disable-base-image-rpm-repos", "Disable the base images' RPM repos as an RPM source").Bool()
+	batchInputImageCacheDir       = batchCommand.Flag("input-image-cache-dir", "Directory to cache the raw conversions of the base images in. Defaults to a directory within the build directory.").String()
+	batchPackageCacheDir          = batchCommand.Flag("package-cache-dir", "Directory to cache images in once their packages are installed. Defaults to a directory within the build directory.").String()
+	batchReportFile               = batchCommand.Flag("report-file", "Path to write the report of the builds to. Defaults to 'batch-report.json' within the build directory.").String()
+	batchBaseImageSharing         = batchCommand.Flag("base-image-sharing", "How each build's writeable copy of its raw base image is created. Supported: auto, reflink, copy.").Default("auto").Enum("auto", "reflink", "copy")
+	batchPluginsDir               = batchCommand.Flag("plugins-dir", "Directory of plugins that provide output image formats, base image fetchers and signing providers. Applies to all the images.").String()
+)
+
+func checkBatchFlags() {
+	if *batchParallelism < 1 {
+		kingpin.Fatalf("--parallelism must be at least 1.")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/buildstate"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/tenant"
+)
+
+var (
+	buildStateCommand = app.Command("build-state", "Print the state of the builds recorded in a build state directory.")
+
+	queryBuildStateDir = buildStateCommand.Flag("build-state-dir", "Path of the build state directory.").Required().ExistingDir()
+	queryBuildId       = buildStateCommand.Flag("build-id", "Only print the state of this build.").String()
+	inFlightOnly       = buildStateCommand.Flag("in-flight", "Only print the builds that were started but never completed or failed.").Bool()
+	queryTenantName    = buildStateCommand.Flag("tenant", "Print the builds of this tenant, within the shared build state directory.").String()
+)
+
+func printBuildState() error {
+	stateDir := *queryBuildStateDir
+	if *queryTenantName != "" {
+		var err error
+		stateDir, err = tenant.Dir(stateDir, *queryTenantName)
+		if err != nil {
+			return err
+		}
+	}
+
+	events, err := buildstate.ReadEvents(stateDir)
+	if err != nil {
+		return err
+	}
+
+	builds := []*buildstate.BuildState{}
+	for _, build := range buildstate.BuildsFromEvents(events) {
+		if *queryBuildId != "" && build.BuildId != *queryBuildId {
+			continue
+		}
+
+		if *inFlightOnly && build.Status != buildstate.BuildStatusRunning {
+			continue
+		}
+
+		builds = append(builds, build)
+	}
+
+	if *queryBuildId != "" && len(builds) <= 0 && !*inFlightOnly {
+		return fmt.Errorf("build (%s) not found", *queryBuildId)
+	}
+
+	output, err := json.MarshalIndent(builds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+	return nil
+}
@@ -0,0 +1,11 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+var (
+	cleanupMountsCommand = app.Command("cleanup-mounts", "Unmount the mounts leaked by builds that crashed.")
+
+	mountJournalDir     = cleanupMountsCommand.Flag("mount-journal-dir", "Directory where the mounts of each build are recorded. Defaults to /run/azurelinux-toolkit/mounts.").String()
+	cleanupMountsDryRun = cleanupMountsCommand.Flag("dry-run", "Only print the leaked mounts, without unmounting them.").Bool()
+)
@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+)
+
+func cleanupMounts() error {
+	journalDir := *mountJournalDir
+	if journalDir == "" {
+		journalDir = safechroot.DefaultMountJournalDir
+	}
+
+	var (
+		staleMounts []safechroot.StaleMount
+		err         error
+	)
+	if *cleanupMountsDryRun {
+		staleMounts, err = safechroot.FindStaleMounts(journalDir)
+	} else {
+		staleMounts, err = safechroot.CleanupStale(journalDir)
+	}
+
+	// Print the mounts that were unmounted, even if some of the mounts couldn't be.
+	if staleMounts == nil {
+		staleMounts = []safechroot.StaleMount{}
+	}
+
+	output, marshalErr := json.MarshalIndent(staleMounts, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	fmt.Println(string(output))
+	return err
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/tenant"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	customizeCommand = app.Command("customize", "Customize an image. This is the default command.").Default()
+
+	buildDir                    = customizeCommand.Flag("build-dir", "Directory to run build out of.").Required().String()
+	imageFile                   = customizeCommand.Flag("image-file", "Path of the base Azure Linux image which the customization will be applied to, or the URL of a base image to fetch with a plugin.").Required().String()
+	outputImageFile             = customizeCommand.Flag("output-image-file", "Path to write the customized image to.").Required().String()
+	outputImageFormat           = customizeCommand.Flag("output-image-format", "Format of output image. Supported: vhd, vhd-fixed, vhdx, qcow2, qcow2-compressed, raw, raw-zst, iso, oci, docker-archive, wsl, and the formats provided by plugins.").String()
+	outputSplitPartitionsFormat = customizeCommand.Flag("output-split-partitions-format", "Format of partition files. Supported: raw, raw-zst").Enum("raw", "raw-zst")
+	configFile                  = customizeCommand.Flag("config-file", "Path of the image customization config file. If '--config-bundle' is specified, then this is the path of the config file within the bundle (default: config.yaml).").String()
+	configBundle                = customizeCommand.Flag("config-bundle", "Path of a signed tar archive containing the config file and the files it references.").String()
+	configBundleSignature       = customizeCommand.Flag("config-bundle-signature", "Path of the detached signature of the config bundle.").String()
+	configBundleKey             = customizeCommand.Flag("config-bundle-key", "Path of the public key that must have signed the config bundle.").String()
+	configBundleSignatureType   = customizeCommand.Flag("config-bundle-signature-type", "Type of the config bundle's signature. Supported: gpg, cosign.").Default("gpg").Enum("gpg", "cosign")
+	configProvenanceKey         = customizeCommand.Flag("config-provenance-key", "Path of the PEM encoded private key to sign the config provenance of the output image with. Required with '--config-bundle'.").String()
+	configFragments             = customizeCommand.Flag("config-fragment", "Path of a config file to layer on top of the config file. May be specified multiple times.").Strings()
+	rpmSources                  = customizeCommand.Flag("rpm-source", "Path to a RPM repo config file or a directory containing RPMs.").Strings()
+	disableBaseImageRpmRepos    = customizeCommand.Flag("disable-base-image-rpm-repos", "Disable the base image's RPM repos as an RPM source").Bool()
+	enableShrinkFilesystems     = customizeCommand.Flag("shrink-filesystems", "Enable shrinking of filesystems to minimum size. Supports ext2, ext3, ext4 filesystem types.").Bool()
+	outputPXEArtifactsDir       = customizeCommand.Flag("output-pxe-artifacts-dir", "Create a directory with customized image PXE booting artifacts. '--output-image-format' must be set to 'iso'.").String()
+	buildStateDir               = customizeCommand.Flag("build-state-dir", "Directory to record the build's state in, so that an interrupted build can be resumed.").String()
+	buildId                     = customizeCommand.Flag("build-id", "ID of the build within the build state directory. '--build-state-dir' must be specified.").String()
+	dryRun                      = customizeCommand.Flag("dry-run", "Validate the config and print the planned operations without modifying any image.").Bool()
+	tenantName                  = customizeCommand.Flag("tenant", "Name of the tenant to run the build as. The build and build state directories are shared by all tenants, with each tenant using its own namespace within them.").String()
+	tenantQuota                 = customizeCommand.Flag("tenant-quota", "Maximum disk space the tenant's build and build state directories, along with the build's outputs, may use (e.g. 100GB). The build is stopped as soon as it goes over the quota. '--tenant' must be specified.").Bytes()
+	matrixCells                 = customizeCommand.Flag("matrix-cell", "Name of a cell of the config file's matrix to build. May be specified multiple times. By default, all the cells are built.").Strings()
+	matrixParallelism           = customizeCommand.Flag("matrix-parallelism", "Maximum number of matrix cells to build at the same time.").Default("2").Int()
+	inputImageCacheDir          = customizeCommand.Flag("input-image-cache-dir", "Directory to cache the raw conversions of input images in, so that they can be shared between builds.").String()
+	packageCacheDir             = customizeCommand.Flag("package-cache-dir", "Directory to cache images in once their packages are installed, so that builds that only change later customizations skip installing the packages.").String()
+	outputArtifactStore         = customizeCommand.Flag("output-artifact-store", "Location of an artifact store to store the output image in, deduplicated against the images already in the store.").String()
+	summaryFile                 = customizeCommand.Flag("summary-file", "Path to write a machine-readable (JSON) summary of the build to, including its artifacts, package counts, warnings and validation results. The summary is written even if the build fails.").String()
+	stepSummaryFile             = customizeCommand.Flag("step-summary-file", "Path of a markdown file to append a summary of the build to (e.g. $GITHUB_STEP_SUMMARY).").String()
+	baseImageSharing            = customizeCommand.Flag("base-image-sharing", "How the build's writeable copy of a raw base image is created. 'auto' clones the image if the filesystem supports reflinks and copies it otherwise. Supported: auto, reflink, copy.").Default("auto").Enum("auto", "reflink", "copy")
+	pluginsDir                  = customizeCommand.Flag("plugins-dir", "Directory of plugins that provide output image formats, base image fetchers and signing providers.").String()
+	chrootMemoryMax             = customizeCommand.Flag("chroot-memory-max", "Maximum memory that each command run in the image's chroot (e.g. a package's scriptlet or a script), including its child processes, may use (e.g. 4GB).").Bytes()
+	chrootCPUWeight             = customizeCommand.Flag("chroot-cpu-weight", "Relative share of CPU time (1 to 10000) of each command run in the image's chroot. The default weight of a process is 100.").Uint64()
+	chrootPidsMax               = customizeCommand.Flag("chroot-pids-max", "Maximum number of processes and threads of each command run in the image's chroot.").Uint64()
+	chrootCommandTimeout        = customizeCommand.Flag("chroot-command-timeout", "Maximum time that each command run in the image's chroot may run for, after which it is killed (e.g. 30m).").Duration()
+	chrootCgroupParent          = customizeCommand.Flag("chroot-cgroup-parent", "cgroup v2 directory to create the cgroups of the commands run in the image's chroot in. By default, the image customizer's own cgroup is used.").String()
+)
+
+func checkCustomizeFlags() {
+	if *outputSplitPartitionsFormat == "" && *outputImageFormat == "" {
+		kingpin.Fatalf("Either --output-image-format or --output-split-partitions-format must be specified.")
+	}
+
+	if *configFile == "" && *configBundle == "" {
+		kingpin.Fatalf("Either --config-file or --config-bundle must be specified.")
+	}
+
+	if *configBundle != "" && (*configBundleSignature == "" || *configBundleKey == "") {
+		kingpin.Fatalf("--config-bundle-signature and --config-bundle-key must be specified to use --config-bundle.")
+	}
+
+	if *configBundle != "" && *configProvenanceKey == "" {
+		kingpin.Fatalf("--config-provenance-key must be specified to use --config-bundle.")
+	}
+
+	// A config bundle must be the only source of the build's config and of the files it references.
+	if *configBundle != "" && len(*configFragments) > 0 {
+		kingpin.Fatalf("--config-fragment cannot be used with --config-bundle.")
+	}
+
+	if *configBundle != "" && len(*rpmSources) > 0 {
+		kingpin.Fatalf("--rpm-source cannot be used with --config-bundle.")
+	}
+
+	if *enableShrinkFilesystems && *outputSplitPartitionsFormat == "" {
+		logger.Log.Fatalf("--output-split-partitions-format must be specified to use --shrink-filesystems.")
+	}
+
+	if *enableShrinkFilesystems && *outputImageFormat != "" {
+		logger.Log.Fatalf("--output-image-format cannot be used with --shrink-filesystems enabled.")
+	}
+
+	if (*buildStateDir == "") != (*buildId == "") {
+		kingpin.Fatalf("--build-state-dir and --build-id must be specified together.")
+	}
+
+	if *dryRun && *buildStateDir != "" {
+		kingpin.Fatalf("--build-state-dir cannot be used with --dry-run.")
+	}
+
+	if *tenantQuota != 0 && *tenantName == "" {
+		kingpin.Fatalf("--tenant must be specified to use --tenant-quota.")
+	}
+
+	if *tenantQuota < 0 {
+		kingpin.Fatalf("--tenant-quota must not be negative.")
+	}
+
+	if *outputArtifactStore != "" && *outputImageFormat == "" {
+		kingpin.Fatalf("--output-image-format must be specified to use --output-artifact-store.")
+	}
+
+	if *chrootMemoryMax < 0 {
+		kingpin.Fatalf("--chroot-memory-max must not be negative.")
+	}
+
+	if *chrootCommandTimeout < 0 {
+		kingpin.Fatalf("--chroot-command-timeout must not be negative.")
+	}
+
+	if *chrootCgroupParent != "" && *chrootMemoryMax == 0 && *chrootCPUWeight == 0 && *chrootPidsMax == 0 {
+		kingpin.Fatalf("--chroot-memory-max, --chroot-cpu-weight or --chroot-pids-max must be specified to use --chroot-cgroup-parent.")
+	}
+
+	if *matrixParallelism < 1 {
+		kingpin.Fatalf("--matrix-parallelism must be at least 1.")
+	}
+
+	if *tenantName != "" {
+		err := tenant.ValidateName(*tenantName)
+		if err != nil {
+			kingpin.Fatalf("invalid --tenant: %s", err)
+		}
+	}
+}
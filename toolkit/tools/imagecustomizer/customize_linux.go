@@ -0,0 +1,220 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagecustomizerlib"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/tenant"
+	"golang.org/x/sys/unix"
+)
+
+func toolVersion() string {
+	return imagecustomizerlib.ToolVersion
+}
+
+func customizeImage() (err error) {
+	if *outputImageFile != "" {
+		logger.SetContextField(logger.ImageField, filepath.Base(*outputImageFile))
+	}
+
+	customizeConfigFile, bundleProvenance, err := openConfigBundle()
+	if err != nil {
+		return err
+	}
+
+	matrix, err := imagecustomizerapi.LoadConfigFileMatrix(customizeConfigFile)
+	if err != nil {
+		return err
+	}
+
+	// A single matrix cell is built in-process, using the output paths as-is.
+	if matrix != nil && len(*matrixCells) != 1 {
+		return customizeMatrix(customizeConfigFile)
+	}
+
+	customizeBuildDir := *buildDir
+	options := imagecustomizerlib.CustomizeImageOptions{
+		BuildStateDir:       *buildStateDir,
+		BuildId:             *buildId,
+		ConfigFragmentFiles: *configFragments,
+		InputImageCacheDir:  *inputImageCacheDir,
+		BaseImageSharing:    imagecustomizerlib.BaseImageSharing(*baseImageSharing),
+		PackageCacheDir:     *packageCacheDir,
+		OutputArtifactStore: *outputArtifactStore,
+		ConfigBundle:        bundleProvenance,
+		ConfigProvenanceKey: *configProvenanceKey,
+		SummaryFile:         *summaryFile,
+		StepSummaryFile:     *stepSummaryFile,
+		PluginsDir:          *pluginsDir,
+	}
+
+	if len(*matrixCells) == 1 {
+		options.MatrixCell = (*matrixCells)[0]
+	}
+
+	if *tenantName != "" {
+		err = namespaceTenantCaches(&options, *tenantName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *dryRun {
+		if *tenantName != "" {
+			customizeBuildDir, err = tenant.Dir(customizeBuildDir, *tenantName)
+			if err != nil {
+				return err
+			}
+		}
+
+		plan, err := imagecustomizerlib.PlanCustomizationWithConfigFile(customizeBuildDir, customizeConfigFile,
+			*imageFile, *rpmSources, *outputImageFile, *outputImageFormat, *outputSplitPartitionsFormat,
+			*outputPXEArtifactsDir, !*disableBaseImageRpmRepos, *enableShrinkFilesystems, options)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(plan.String())
+		return nil
+	}
+
+	err = safechroot.SetCommandLimits(safechroot.CommandLimits{
+		Resources: cgroup.Limits{
+			MemoryMax: uint64(*chrootMemoryMax),
+			CPUWeight: *chrootCPUWeight,
+			PidsMax:   *chrootPidsMax,
+		},
+		CgroupParent: *chrootCgroupParent,
+		Timeout:      *chrootCommandTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set limits of chroot commands:\n%w", err)
+	}
+	defer func() {
+		resetErr := safechroot.ResetCommandLimits()
+		if resetErr != nil {
+			logger.Log.Warnf("Failed to reset limits of chroot commands:\n%v", resetErr)
+		}
+	}()
+
+	var quotaErr error
+	stopQuota := func() {}
+
+	if *tenantName != "" {
+		var workspace *tenant.Workspace
+		workspace, err = tenant.OpenWorkspace(customizeBuildDir, *buildStateDir, *tenantName, uint64(*tenantQuota))
+		if err != nil {
+			return err
+		}
+		defer workspace.Close()
+
+		// The outputs count against the quota. The output image's sidecar files (e.g. the split partitions and the
+		// reports) share its base name.
+		if *outputImageFile != "" {
+			workspace.Outputs = append(workspace.Outputs,
+				strings.TrimSuffix(*outputImageFile, filepath.Ext(*outputImageFile))+"*")
+		}
+		if *outputPXEArtifactsDir != "" {
+			workspace.Outputs = append(workspace.Outputs, *outputPXEArtifactsDir)
+		}
+
+		// Don't start a build that is already over the quota (e.g. due to the files left behind by a failed build).
+		err = workspace.CheckQuota()
+		if err != nil {
+			return err
+		}
+
+		customizeBuildDir = workspace.BuildDir
+		options.BuildStateDir = workspace.BuildStateDir
+
+		// Stop the build as soon as it goes over the quota, instead of letting it fill up the shared disk.
+		stopQuota = workspace.EnforceQuota(tenant.DefaultQuotaCheckInterval, func(exceededErr error) {
+			logger.Log.Errorf("Stopping the build: %s", exceededErr)
+			quotaErr = exceededErr
+			shell.StopAllChildProcesses(unix.SIGTERM)
+		})
+		defer stopQuota()
+	}
+
+	err = imagecustomizerlib.CustomizeImageWithConfigFileAndOptions(customizeBuildDir, customizeConfigFile,
+		*imageFile, *rpmSources, *outputImageFile, *outputImageFormat, *outputSplitPartitionsFormat,
+		*outputPXEArtifactsDir, !*disableBaseImageRpmRepos, *enableShrinkFilesystems, options)
+	stopQuota()
+
+	if err != nil {
+		if quotaErr != nil {
+			return fmt.Errorf("%w:\n%w", quotaErr, err)
+		}
+		return err
+	}
+
+	// A build that completed isn't failed after the fact, even if it went over the quota before it could be stopped.
+	if quotaErr != nil {
+		logger.Log.Warnf("Build completed even though %s", quotaErr)
+	}
+
+	return nil
+}
+
+// namespaceTenantCaches moves the caches that the build writes to into the tenant's namespace, so that tenants never
+// see each other's cached images.
+func namespaceTenantCaches(options *imagecustomizerlib.CustomizeImageOptions, name string) error {
+	var err error
+
+	if options.InputImageCacheDir != "" {
+		options.InputImageCacheDir, err = tenant.Dir(options.InputImageCacheDir, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.PackageCacheDir != "" {
+		options.PackageCacheDir, err = tenant.Dir(options.PackageCacheDir, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openConfigBundle verifies and extracts the config bundle (if one was specified). Returns the path of the config
+// file to customize the image with.
+func openConfigBundle() (string, *imagecustomizerlib.ConfigBundleProvenance, error) {
+	if *configBundle == "" {
+		return *configFile, nil, nil
+	}
+
+	bundleBuildDir := *buildDir
+	if *tenantName != "" {
+		var err error
+		bundleBuildDir, err = tenant.Dir(bundleBuildDir, *tenantName)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	bundleConfigFile, provenance, err := imagecustomizerlib.OpenConfigBundle(bundleBuildDir,
+		imagecustomizerlib.ConfigBundle{
+			BundleFile:    *configBundle,
+			SignatureFile: *configBundleSignature,
+			SignatureType: *configBundleSignatureType,
+			PublicKeyFile: *configBundleKey,
+			ConfigFile:    *configFile,
+		})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return bundleConfigFile, provenance, nil
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The version isn't embedded in non-Linux builds, since the version variable lives in a Linux-only package.
+func toolVersion() string {
+	return ""
+}
+
+func customizeImage() error {
+	return fmt.Errorf("the customize command is only supported on Linux (current OS: %s)", runtime.GOOS)
+}
+
+func customizeBatch() error {
+	return fmt.Errorf("the batch command is only supported on Linux (current OS: %s)", runtime.GOOS)
+}
+
+func cleanupMounts() error {
+	return fmt.Errorf("the cleanup-mounts command is only supported on Linux (current OS: %s)", runtime.GOOS)
+}
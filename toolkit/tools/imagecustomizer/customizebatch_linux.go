@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagecustomizerlib"
+)
+
+// customizeBatch builds the images of a batch manifest.
+//
+// Like the cells of a config matrix, each image is built by a separate imagecustomizer process. The builds share an
+// input image cache and a package stage cache, so that images with the same base image and packages only convert
+// the base image and install the packages once.
+func customizeBatch() error {
+	builds, err := imagecustomizerlib.PlanBatchBuilds(*batchBuildDir, *batchManifestFile)
+	if err != nil {
+		return err
+	}
+
+	inputCacheDir := *batchInputImageCacheDir
+	if inputCacheDir == "" {
+		inputCacheDir = imagecustomizerlib.GetBatchInputImageCacheDir(*batchBuildDir)
+	}
+
+	packageCacheDir := *batchPackageCacheDir
+	if packageCacheDir == "" {
+		packageCacheDir = imagecustomizerlib.GetBatchPackageCacheDir(*batchBuildDir)
+	}
+
+	reportFile := *batchReportFile
+	if reportFile == "" {
+		reportFile = imagecustomizerlib.GetBatchReportFile(*batchBuildDir)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find imagecustomizer executable:\n%w", err)
+	}
+
+	logger.Log.Infof("Building %d batch images (parallelism: %d)", len(builds), *batchParallelism)
+
+	results := imagecustomizerlib.RunBatchBuilds(builds, *batchParallelism,
+		func(build imagecustomizerlib.BatchBuild) error {
+			return runCustomizeProcess(executable, getBatchImageArgs(inputCacheDir, packageCacheDir, build),
+				build.LogFile)
+		})
+
+	for _, result := range results {
+		status := "succeeded"
+		if result.Err != nil {
+			status = "failed"
+		}
+		logger.Log.Infof("Batch image (%s): %s (%s)", result.Build.Name, status, result.Duration.Round(time.Second))
+	}
+
+	err = imagecustomizerlib.WriteBatchReport(reportFile, *batchManifestFile, results)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getBatchImageArgs returns the command-line arguments that build a single image of a batch, forwarding the flags
+// that apply to all the images.
+func getBatchImageArgs(inputCacheDir string, packageCacheDir string, build imagecustomizerlib.BatchBuild) []string {
+	args := []string{
+		customizeCommand.FullCommand(),
+		"--build-dir", build.BuildDir,
+		"--image-file", build.ImageFile,
+		"--config-file", build.ConfigFile,
+		"--output-image-file", build.OutputImageFile,
+		"--output-image-format", build.OutputImageFormat,
+		"--input-image-cache-dir", inputCacheDir,
+		"--package-cache-dir", packageCacheDir,
+		"--base-image-sharing", *batchBaseImageSharing,
+		"--log-file", build.LogFile,
+	}
+
+	for _, rpmSource := range *batchRpmSources {
+		args = append(args, "--rpm-source", rpmSource)
+	}
+
+	if *batchDisableBaseImageRpmRepos {
+		args = append(args, "--disable-base-image-rpm-repos")
+	}
+
+	if *batchPluginsDir != "" {
+		args = append(args, "--plugins-dir", *batchPluginsDir)
+	}
+
+	if *logFlags.LogLevel != "" {
+		args = append(args, "--log-level", *logFlags.LogLevel)
+	}
+
+	if *logFlags.LogFormat != "" {
+		args = append(args, "--log-format", *logFlags.LogFormat)
+	}
+
+	if *otlpEndpoint != "" {
+		args = append(args, "--otlp-endpoint", *otlpEndpoint)
+	}
+
+	if *timingSummary {
+		args = append(args, "--timing-summary")
+	}
+
+	return args
+}
@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagecustomizerlib"
+)
+
+const (
+	// The number of lines of a failed child build's output to include in the error.
+	childBuildErrorOutputLines = 20
+)
+
+// customizeMatrix builds the cells of the config file's matrix.
+//
+// Each cell is built by a separate imagecustomizer process, since a build changes process-wide state (e.g. the
+// current root directory while running commands within the image).
+func customizeMatrix(customizeConfigFile string) error {
+	if *tenantName != "" {
+		return fmt.Errorf("--tenant can't be used to build multiple matrix cells at once")
+	}
+
+	options := imagecustomizerlib.CustomizeImageOptions{
+		ConfigFragmentFiles: *configFragments,
+		SummaryFile:         *summaryFile,
+		PluginsDir:          *pluginsDir,
+	}
+
+	builds, err := imagecustomizerlib.PlanMatrixBuilds(*buildDir, customizeConfigFile, *imageFile, *outputImageFile,
+		*outputPXEArtifactsDir, *buildId, *matrixCells, options)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		for _, build := range builds {
+			cellOptions := options
+			cellOptions.MatrixCell = build.Cell.Name
+
+			plan, err := imagecustomizerlib.PlanCustomizationWithConfigFile(build.BuildDir, customizeConfigFile,
+				build.ImageFile, *rpmSources, build.OutputImageFile, *outputImageFormat, *outputSplitPartitionsFormat,
+				build.OutputPXEArtifactsDir, !*disableBaseImageRpmRepos, *enableShrinkFilesystems, cellOptions)
+			if err != nil {
+				return fmt.Errorf("failed to plan matrix cell (%s):\n%w", build.Cell.Name, err)
+			}
+
+			fmt.Printf("Matrix cell: %s\n", build.Cell.Name)
+			fmt.Print(plan.String())
+		}
+		return nil
+	}
+
+	cacheDir := *inputImageCacheDir
+	if cacheDir == "" {
+		cacheDir = imagecustomizerlib.GetMatrixInputImageCacheDir(*buildDir)
+	}
+
+	err = imagecustomizerlib.CacheMatrixInputImages(cacheDir, builds)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find imagecustomizer executable:\n%w", err)
+	}
+
+	results := imagecustomizerlib.RunMatrixBuilds(builds, *matrixParallelism,
+		func(build imagecustomizerlib.MatrixBuild) error {
+			return runCustomizeProcess(executable, getMatrixCellArgs(cacheDir, build), build.LogFile)
+		})
+
+	manifestFile, err := imagecustomizerlib.WriteMatrixManifest(*outputImageFile, customizeConfigFile, results)
+	if manifestFile != "" {
+		logger.Log.Infof("Matrix manifest: %s", manifestFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// runCustomizeProcess runs a single build in a child imagecustomizer process.
+func runCustomizeProcess(executable string, args []string, logFile string) error {
+	err := os.MkdirAll(filepath.Dir(logFile), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create log directory:\n%w", err)
+	}
+
+	cmd := exec.Command(executable, args...)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		if len(lines) > childBuildErrorOutputLines {
+			lines = lines[len(lines)-childBuildErrorOutputLines:]
+		}
+
+		return fmt.Errorf("%w:\n%s", err, strings.Join(lines, "\n"))
+	}
+
+	return nil
+}
+
+// getMatrixCellArgs returns the command-line arguments that build a single matrix cell, forwarding the flags that
+// apply to all the cells.
+func getMatrixCellArgs(cacheDir string, build imagecustomizerlib.MatrixBuild) []string {
+	args := []string{
+		customizeCommand.FullCommand(),
+		"--build-dir", build.BuildDir,
+		"--image-file", build.ImageFile,
+		"--output-image-file", build.OutputImageFile,
+		"--matrix-cell", build.Cell.Name,
+		"--input-image-cache-dir", cacheDir,
+		"--log-file", build.LogFile,
+	}
+
+	// Each cell verifies the config bundle itself, so that the bundle's provenance is recorded for each cell.
+	if *configFile != "" {
+		args = append(args, "--config-file", *configFile)
+	}
+
+	if *configBundle != "" {
+		args = append(args, "--config-bundle", *configBundle, "--config-bundle-signature", *configBundleSignature,
+			"--config-bundle-key", *configBundleKey, "--config-bundle-signature-type", *configBundleSignatureType,
+			"--config-provenance-key", *configProvenanceKey)
+	}
+
+	for _, configFragment := range *configFragments {
+		args = append(args, "--config-fragment", configFragment)
+	}
+
+	for _, rpmSource := range *rpmSources {
+		args = append(args, "--rpm-source", rpmSource)
+	}
+
+	if *outputImageFormat != "" {
+		args = append(args, "--output-image-format", *outputImageFormat)
+	}
+
+	if *outputSplitPartitionsFormat != "" {
+		args = append(args, "--output-split-partitions-format", *outputSplitPartitionsFormat)
+	}
+
+	if build.OutputPXEArtifactsDir != "" {
+		args = append(args, "--output-pxe-artifacts-dir", build.OutputPXEArtifactsDir)
+	}
+
+	if *disableBaseImageRpmRepos {
+		args = append(args, "--disable-base-image-rpm-repos")
+	}
+
+	if *enableShrinkFilesystems {
+		args = append(args, "--shrink-filesystems")
+	}
+
+	if *outputArtifactStore != "" {
+		args = append(args, "--output-artifact-store", *outputArtifactStore)
+	}
+
+	if *packageCacheDir != "" {
+		args = append(args, "--package-cache-dir", *packageCacheDir)
+	}
+
+	if *pluginsDir != "" {
+		args = append(args, "--plugins-dir", *pluginsDir)
+	}
+
+	args = append(args, "--base-image-sharing", *baseImageSharing)
+
+	if *chrootMemoryMax != 0 {
+		args = append(args, "--chroot-memory-max", chrootMemoryMax.String())
+	}
+
+	if *chrootCPUWeight != 0 {
+		args = append(args, "--chroot-cpu-weight", strconv.FormatUint(*chrootCPUWeight, 10))
+	}
+
+	if *chrootPidsMax != 0 {
+		args = append(args, "--chroot-pids-max", strconv.FormatUint(*chrootPidsMax, 10))
+	}
+
+	if *chrootCommandTimeout != 0 {
+		args = append(args, "--chroot-command-timeout", chrootCommandTimeout.String())
+	}
+
+	if *chrootCgroupParent != "" {
+		args = append(args, "--chroot-cgroup-parent", *chrootCgroupParent)
+	}
+
+	if *buildStateDir != "" {
+		args = append(args, "--build-state-dir", *buildStateDir, "--build-id", build.BuildId)
+	}
+
+	if build.SummaryFile != "" {
+		args = append(args, "--summary-file", build.SummaryFile)
+	}
+
+	// The cells append their summaries to the same step summary file.
+	if *stepSummaryFile != "" {
+		args = append(args, "--step-summary-file", *stepSummaryFile)
+	}
+
+	if *logFlags.LogLevel != "" {
+		args = append(args, "--log-level", *logFlags.LogLevel)
+	}
+
+	if *logFlags.LogFormat != "" {
+		args = append(args, "--log-format", *logFlags.LogFormat)
+	}
+
+	if *otlpEndpoint != "" {
+		args = append(args, "--otlp-endpoint", *otlpEndpoint)
+	}
+
+	if *timingSummary {
+		args = append(args, "--timing-summary")
+	}
+
+	return args
+}
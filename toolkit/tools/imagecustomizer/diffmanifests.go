@@ -0,0 +1,36 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+)
+
+var (
+	diffManifestsCommand = app.Command("diff-manifests", "Compare the change manifests of two customized images.")
+
+	firstManifestFile  = diffManifestsCommand.Arg("first", "Path of the first change manifest file.").Required().String()
+	secondManifestFile = diffManifestsCommand.Arg("second", "Path of the second change manifest file.").Required().String()
+)
+
+func diffManifests() error {
+	firstManifest, err := changemanifest.Read(*firstManifestFile)
+	if err != nil {
+		return err
+	}
+
+	secondManifest, err := changemanifest.Read(*secondManifestFile)
+	if err != nil {
+		return err
+	}
+
+	diff := changemanifest.Diff(firstManifest, secondManifest)
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/selinuxreport"
+)
+
+var (
+	diffSELinuxReportsCommand = app.Command("diff-selinux-reports", "Compare the SELinux reports of two customized images.")
+
+	firstSELinuxReportFile  = diffSELinuxReportsCommand.Arg("first", "Path of the first (e.g. current) SELinux report file.").Required().String()
+	secondSELinuxReportFile = diffSELinuxReportsCommand.Arg("second", "Path of the second (e.g. next) SELinux report file.").Required().String()
+	failOnRegression        = diffSELinuxReportsCommand.Flag("fail-on-regression", "Fail if the second image has files that were relabeled or that aren't covered by the policy, which the first image doesn't, or if the labels of the files of both images or the values of the booleans changed.").Bool()
+)
+
+func diffSELinuxReports() error {
+	firstReport, err := selinuxreport.Read(*firstSELinuxReportFile)
+	if err != nil {
+		return err
+	}
+
+	secondReport, err := selinuxreport.Read(*secondSELinuxReportFile)
+	if err != nil {
+		return err
+	}
+
+	diff := selinuxreport.Diff(firstReport, secondReport)
+	for _, line := range diff {
+		fmt.Println(line)
+	}
+
+	if *failOnRegression {
+		regressions := selinuxreport.Regressions(diff)
+		if len(regressions) > 0 {
+			return fmt.Errorf("found %d SELinux label regressions", len(regressions))
+		}
+	}
+
+	return nil
+}
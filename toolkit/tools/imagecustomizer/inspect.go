@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/partitiontable"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
+)
+
+var (
+	inspectCommand = app.Command("inspect", "Print the metadata and partition table of an image.")
+
+	inspectArtifactFile = inspectCommand.Arg("artifact", "Path of the image file.").Required().ExistingFile()
+	inspectVerify       = inspectCommand.Flag("verify", "Check that the image matches the digest recorded in its metadata.").Bool()
+)
+
+// artifactInspection is the output of the inspect subcommand.
+type artifactInspection struct {
+	Metadata       *artifactmetadata.Metadata `json:"metadata,omitempty"`
+	PartitionTable *partitiontable.Table      `json:"partitionTable,omitempty"`
+}
+
+func inspectArtifact() error {
+	inspection := artifactInspection{}
+
+	metadata, err := artifactmetadata.ReadSidecar(*inspectArtifactFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	inspection.Metadata = metadata
+
+	if *inspectVerify {
+		if metadata == nil {
+			return fmt.Errorf("can't verify image (%s):\nmetadata file (%s) doesn't exist", *inspectArtifactFile,
+				artifactmetadata.SidecarPath(*inspectArtifactFile))
+		}
+
+		err = metadata.Verify(*inspectArtifactFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	artifactFile, err := os.Open(*inspectArtifactFile)
+	if err != nil {
+		return fmt.Errorf("failed to open image (%s):\n%w", *inspectArtifactFile, err)
+	}
+	defer artifactFile.Close()
+
+	// Only raw disk images (and the formats that embed one as is, like vhd-fixed) have a readable partition table.
+	partitionTable, err := partitiontable.Read(artifactFile)
+	if err != nil && !errors.Is(err, partitiontable.ErrNoPartitionTable) {
+		return fmt.Errorf("failed to read partition table of image (%s):\n%w", *inspectArtifactFile, err)
+	}
+	inspection.PartitionTable = partitionTable
+
+	output, err := json.MarshalIndent(inspection, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+	return nil
+}
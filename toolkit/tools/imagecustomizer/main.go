@@ -10,7 +10,6 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
-	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagecustomizerlib"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -18,64 +17,105 @@ import (
 var (
 	app = kingpin.New("imagecustomizer", "Customizes a pre-built Azure Linux image")
 
-	buildDir                    = app.Flag("build-dir", "Directory to run build out of.").Required().String()
-	imageFile                   = app.Flag("image-file", "Path of the base Azure Linux image which the customization will be applied to.").Required().String()
-	outputImageFile             = app.Flag("output-image-file", "Path to write the customized image to.").Required().String()
-	outputImageFormat           = app.Flag("output-image-format", "Format of output image. Supported: vhd, vhdx, qcow2, raw, iso.").Enum("vhd", "vhd-fixed", "vhdx", "qcow2", "raw", "iso")
-	outputSplitPartitionsFormat = app.Flag("output-split-partitions-format", "Format of partition files. Supported: raw, raw-zst").Enum("raw", "raw-zst")
-	configFile                  = app.Flag("config-file", "Path of the image customization config file.").Required().String()
-	rpmSources                  = app.Flag("rpm-source", "Path to a RPM repo config file or a directory containing RPMs.").Strings()
-	disableBaseImageRpmRepos    = app.Flag("disable-base-image-rpm-repos", "Disable the base image's RPM repos as an RPM source").Bool()
-	enableShrinkFilesystems     = app.Flag("shrink-filesystems", "Enable shrinking of filesystems to minimum size. Supports ext2, ext3, ext4 filesystem types.").Bool()
-	outputPXEArtifactsDir       = app.Flag("output-pxe-artifacts-dir", "Create a directory with customized image PXE booting artifacts. '--output-image-format' must be set to 'iso'.").String()
-	logFlags                    = exe.SetupLogFlags(app)
-	profFlags                   = exe.SetupProfileFlags(app)
-	timestampFile               = app.Flag("timestamp-file", "File that stores timestamps for this program.").String()
+	logFlags      = exe.SetupLogFlags(app)
+	profFlags     = exe.SetupProfileFlags(app)
+	timestampFile = app.Flag("timestamp-file", "File that stores timestamps for this program.").String()
+	otlpEndpoint  = app.Flag("otlp-endpoint", "Base URL of an OTLP/HTTP collector to export the build's steps to as trace spans (e.g. http://localhost:4318).").String()
+	timingSummary = app.Flag("timing-summary", "Log a timeline of where the time was spent at the end of the run.").Bool()
 )
 
 func main() {
 	var err error
 
-	app.Version(imagecustomizerlib.ToolVersion)
-	kingpin.MustParse(app.Parse(os.Args[1:]))
-	if *outputSplitPartitionsFormat == "" && *outputImageFormat == "" {
-		kingpin.Fatalf("Either --output-image-format or --output-split-partitions-format must be specified.")
-	}
+	app.Version(toolVersion())
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	logger.InitBestEffort(logFlags)
 
-	if *enableShrinkFilesystems && *outputSplitPartitionsFormat == "" {
-		logger.Log.Fatalf("--output-split-partitions-format must be specified to use --shrink-filesystems.")
-	}
-
-	if *enableShrinkFilesystems && *outputImageFormat != "" {
-		logger.Log.Fatalf("--output-image-format cannot be used with --shrink-filesystems enabled.")
-	}
-
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
 	}
 	defer prof.StopProfiler()
 
+	timestamp.EnableTracing(timestamp.TraceOptions{
+		OtlpEndpoint: *otlpEndpoint,
+		PrintSummary: *timingSummary,
+	})
 	timestamp.BeginTiming("imagecustomizer", *timestampFile)
 	defer timestamp.CompleteTiming()
 
-	err = customizeImage()
-	if err != nil {
-		log.Fatalf("image customization failed:\n%v", err)
+	switch command {
+	case customizeCommand.FullCommand():
+		checkCustomizeFlags()
+
+		err = customizeImage()
+		if err != nil {
+			log.Fatalf("image customization failed:\n%v", err)
+		}
+
+	case batchCommand.FullCommand():
+		checkBatchFlags()
+
+		err = customizeBatch()
+		if err != nil {
+			log.Fatalf("batch build failed:\n%v", err)
+		}
+
+	case validateCommand.FullCommand():
+		err = validateConfig()
+		if err != nil {
+			log.Fatalf("config validation failed:\n%v", err)
+		}
+
+	case inspectCommand.FullCommand():
+		err = inspectArtifact()
+		if err != nil {
+			log.Fatalf("image inspection failed:\n%v", err)
+		}
+
+	case diffManifestsCommand.FullCommand():
+		err = diffManifests()
+		if err != nil {
+			log.Fatalf("change manifest diff failed:\n%v", err)
+		}
+
+	case diffSELinuxReportsCommand.FullCommand():
+		err = diffSELinuxReports()
+		if err != nil {
+			log.Fatalf("SELinux report diff failed:\n%v", err)
+		}
+
+	case buildStateCommand.FullCommand():
+		err = printBuildState()
+		if err != nil {
+			log.Fatalf("failed to query build state:\n%v", err)
+		}
+
+	case materializeCommand.FullCommand():
+		err = materializeArtifact()
+		if err != nil {
+			log.Fatalf("failed to materialize image:\n%v", err)
+		}
+
+	case cleanupMountsCommand.FullCommand():
+		err = cleanupMounts()
+		if err != nil {
+			log.Fatalf("failed to cleanup leaked mounts:\n%v", err)
+		}
+
+	case runCommand.FullCommand():
+		checkRunFlags()
+
+		err = runImage()
+		if err != nil {
+			log.Fatalf("failed to run image:\n%v", err)
+		}
+
+	case schemaCommand.FullCommand():
+		err = printSchema()
+		if err != nil {
+			log.Fatalf("failed to generate config schema:\n%v", err)
+		}
 	}
 }
-
-func customizeImage() error {
-	var err error
-
-	err = imagecustomizerlib.CustomizeImageWithConfigFile(*buildDir, *configFile, *imageFile,
-		*rpmSources, *outputImageFile, *outputImageFormat, *outputSplitPartitionsFormat, *outputPXEArtifactsDir,
-		!*disableBaseImageRpmRepos, *enableShrinkFilesystems)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
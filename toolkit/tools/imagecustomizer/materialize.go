@@ -0,0 +1,12 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+var (
+	materializeCommand = app.Command("materialize", "Reconstruct an image that was stored in an artifact store.")
+
+	materializeArtifactStore = materializeCommand.Flag("artifact-store", "Location of the artifact store.").Required().String()
+	materializeName          = materializeCommand.Flag("name", "Name of the image within the artifact store. If not specified, then the names of the stored images are listed.").String()
+	materializeOutputFile    = materializeCommand.Flag("output-file", "Path to write the image to. Defaults to the image's name, within the current directory.").String()
+)
@@ -0,0 +1,43 @@
+//go:build !windows
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/artifactstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/chunkstore"
+)
+
+func materializeArtifact() error {
+	store, err := artifactstore.Open(*materializeArtifactStore)
+	if err != nil {
+		return err
+	}
+
+	chunkStore := chunkstore.New(store)
+
+	if *materializeName == "" {
+		names, err := chunkStore.List(context.Background())
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	outputFile := *materializeOutputFile
+	if outputFile == "" {
+		outputFile = filepath.Base(*materializeName)
+	}
+
+	return chunkStore.Materialize(context.Background(), *materializeName, outputFile)
+}
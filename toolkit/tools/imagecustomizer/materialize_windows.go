@@ -0,0 +1,12 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+)
+
+func materializeArtifact() error {
+	return fmt.Errorf("the materialize command isn't supported on Windows")
+}
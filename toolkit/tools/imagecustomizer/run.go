@@ -0,0 +1,257 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/qemu"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	// snapshotPathHashLength is the number of hex digits of the image path's hash in the snapshot's file name.
+	snapshotPathHashLength = 12
+)
+
+var (
+	runCommand = app.Command("run", "Boot an image in a QEMU VM, with the VM's serial console attached to the terminal.")
+
+	runImageFile    = runCommand.Arg("image", "Path of the image to boot.").Required().String()
+	runImageFormat  = runCommand.Flag("image-format", "Format of the image. By default, the format is detected from the file extension. Supported: vhd, vhd-fixed, vhdx, qcow2, raw, iso.").String()
+	runConfigFile   = runCommand.Flag("config-file", "Path of an image customization config file to read the VM's options (i.e. the 'run' field) from.").String()
+	runWorkspaceDir = runCommand.Flag("workspace-dir", "Directory to keep a copy-on-write snapshot of the image in, so that the changes made within the VM are kept across runs. By default, the changes are discarded when the VM exits.").String()
+	runReset        = runCommand.Flag("reset", "Discard the changes kept in the workspace directory's snapshot of the image.").Bool()
+	runArch         = runCommand.Flag("arch", "Architecture of the image. Supported: amd64, arm64.").Default(runtime.GOARCH).String()
+	runFirmware     = runCommand.Flag("firmware", "Path of the UEFI firmware (e.g. OVMF) to boot the image with. Overrides the config file's firmware.").String()
+	runMemoryMiB    = runCommand.Flag("memory-mib", "The VM's memory size in MiB. Overrides the config file's memory size.").Int()
+	runCpus         = runCommand.Flag("cpus", "The VM's number of CPUs. Overrides the config file's number of CPUs.").Int()
+	runPortForwards = runCommand.Flag("port-forward", "Host port to forward to the VM, as HOST-PORT:GUEST-PORT[/udp] (e.g. 2222:22). Added to the config file's port forwards. May be specified multiple times.").Strings()
+)
+
+func checkRunFlags() {
+	if *runReset && *runWorkspaceDir == "" {
+		kingpin.Fatalf("--workspace-dir must be specified to use --reset.")
+	}
+
+	if *runMemoryMiB < 0 {
+		kingpin.Fatalf("--memory-mib must not be negative.")
+	}
+
+	if *runCpus < 0 {
+		kingpin.Fatalf("--cpus must not be negative.")
+	}
+}
+
+func runImage() error {
+	imageFormat := *runImageFormat
+	if imageFormat == "" {
+		var err error
+		imageFormat, err = detectImageFormat(*runImageFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	machine, err := runMachine()
+	if err != nil {
+		return err
+	}
+
+	bootFile := *runImageFile
+	bootFormat := imageFormat
+	keepChanges := false
+
+	// An ISO is read-only. So, there aren't any changes to keep.
+	if *runWorkspaceDir != "" && imageFormat != "iso" {
+		bootFile, err = prepareWorkspaceSnapshot(*runImageFile, imageFormat, *runWorkspaceDir, *runReset)
+		if err != nil {
+			return err
+		}
+
+		bootFormat = "qcow2"
+		keepChanges = true
+	}
+
+	return qemu.RunInteractive(bootFile, bootFormat, machine, keepChanges)
+}
+
+// runMachine returns the VM to boot the image in, using the config file's options overridden by the flags.
+func runMachine() (qemu.Machine, error) {
+	runConfig := imagecustomizerapi.Run{}
+	if *runConfigFile != "" {
+		var config imagecustomizerapi.Config
+		err := imagecustomizerapi.UnmarshalConfigFile(*runConfigFile, nil, &config)
+		if err != nil {
+			return qemu.Machine{}, err
+		}
+
+		if config.Run != nil {
+			runConfig = *config.Run
+		}
+
+		// Relative paths are relative to the config file's directory.
+		if runConfig.Firmware != "" && !filepath.IsAbs(runConfig.Firmware) {
+			runConfig.Firmware = filepath.Join(filepath.Dir(*runConfigFile), runConfig.Firmware)
+		}
+	}
+
+	if *runFirmware != "" {
+		runConfig.Firmware = *runFirmware
+	}
+
+	if *runMemoryMiB != 0 {
+		runConfig.MemoryMiB = *runMemoryMiB
+	}
+
+	if *runCpus != 0 {
+		runConfig.Cpus = *runCpus
+	}
+
+	for _, value := range *runPortForwards {
+		portForward, err := parsePortForward(value)
+		if err != nil {
+			return qemu.Machine{}, err
+		}
+
+		runConfig.PortForwards = append(runConfig.PortForwards, portForward)
+	}
+
+	err := runConfig.IsValid()
+	if err != nil {
+		return qemu.Machine{}, fmt.Errorf("invalid VM options:\n%w", err)
+	}
+
+	machine := qemu.Machine{
+		Arch:      *runArch,
+		Firmware:  runConfig.Firmware,
+		MemoryMiB: runConfig.MemoryMiB,
+		Cpus:      runConfig.Cpus,
+	}
+
+	for _, portForward := range runConfig.PortForwards {
+		machine.PortForwards = append(machine.PortForwards, qemu.PortForward{
+			Protocol:  portForward.GetProtocol(),
+			HostPort:  portForward.HostPort,
+			GuestPort: portForward.GuestPort,
+		})
+	}
+
+	return machine, nil
+}
+
+// parsePortForward parses a port forward of the form "HOST-PORT:GUEST-PORT[/PROTOCOL]".
+func parsePortForward(value string) (imagecustomizerapi.PortForward, error) {
+	ports, protocol, _ := strings.Cut(value, "/")
+	hostPort, guestPort, found := strings.Cut(ports, ":")
+	if !found {
+		return imagecustomizerapi.PortForward{}, fmt.Errorf("invalid --port-forward (%s): must be of the form "+
+			"HOST-PORT:GUEST-PORT[/udp]", value)
+	}
+
+	portForward := imagecustomizerapi.PortForward{
+		Protocol: protocol,
+	}
+
+	var err error
+	portForward.HostPort, err = strconv.Atoi(hostPort)
+	if err != nil {
+		return imagecustomizerapi.PortForward{}, fmt.Errorf("invalid host port of --port-forward (%s):\n%w", value, err)
+	}
+
+	portForward.GuestPort, err = strconv.Atoi(guestPort)
+	if err != nil {
+		return imagecustomizerapi.PortForward{}, fmt.Errorf("invalid guest port of --port-forward (%s):\n%w", value,
+			err)
+	}
+
+	return portForward, nil
+}
+
+// detectImageFormat returns the format of an image based on its file extension.
+func detectImageFormat(imageFile string) (string, error) {
+	extension := strings.ToLower(filepath.Ext(imageFile))
+	switch extension {
+	case ".vhd":
+		return "vhd", nil
+	case ".vhdx":
+		return "vhdx", nil
+	case ".qcow2":
+		return "qcow2", nil
+	case ".raw", ".img":
+		return "raw", nil
+	case ".iso":
+		return "iso", nil
+	default:
+		return "", fmt.Errorf("failed to detect format of image (%s) from its extension (%s), use --image-format",
+			imageFile, extension)
+	}
+}
+
+// prepareWorkspaceSnapshot returns the path of the workspace's copy-on-write snapshot of the image, creating it if
+// needed. The snapshot is recreated if the image changed since the snapshot was created (e.g. the image was rebuilt),
+// since a snapshot is only valid on top of the exact image that it was created from.
+func prepareWorkspaceSnapshot(imageFile string, imageFormat string, workspaceDir string, reset bool) (string, error) {
+	err := os.MkdirAll(workspaceDir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create workspace directory (%s):\n%w", workspaceDir, err)
+	}
+
+	absImageFile, err := filepath.Abs(imageFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path of image (%s):\n%w", imageFile, err)
+	}
+
+	// Include a hash of the image's path, so that images with the same file name (e.g. the outputs of different
+	// builds) don't share a snapshot.
+	pathHash := sha256.Sum256([]byte(absImageFile))
+	snapshotFile := filepath.Join(workspaceDir, fmt.Sprintf("%s.%s.snapshot.qcow2", filepath.Base(imageFile),
+		hex.EncodeToString(pathHash[:])[:snapshotPathHashLength]))
+
+	imageInfo, err := os.Stat(imageFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat image (%s):\n%w", imageFile, err)
+	}
+
+	snapshotInfo, err := os.Stat(snapshotFile)
+	switch {
+	case os.IsNotExist(err):
+		logger.Log.Infof("Creating snapshot (%s) of image", snapshotFile)
+
+	case err != nil:
+		return "", fmt.Errorf("failed to stat snapshot (%s):\n%w", snapshotFile, err)
+
+	case reset:
+		logger.Log.Infof("Discarding changes of snapshot (%s)", snapshotFile)
+
+	case imageInfo.ModTime().After(snapshotInfo.ModTime()):
+		logger.Log.Warnf("Image changed since snapshot (%s) was created, discarding the snapshot's changes",
+			snapshotFile)
+
+	default:
+		logger.Log.Infof("Reusing snapshot (%s) of image", snapshotFile)
+		return snapshotFile, nil
+	}
+
+	err = os.RemoveAll(snapshotFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove snapshot (%s):\n%w", snapshotFile, err)
+	}
+
+	err = qemu.CreateOverlay(imageFile, imageFormat, snapshotFile)
+	if err != nil {
+		return "", err
+	}
+
+	return snapshotFile, nil
+}
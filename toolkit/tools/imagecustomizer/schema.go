@@ -0,0 +1,24 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+)
+
+var (
+	schemaCommand = app.Command("schema", "Print the JSON Schema of the config file.")
+)
+
+func printSchema() error {
+	schema, err := imagecustomizerapi.GenerateConfigJsonSchema()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(schema))
+	return nil
+}
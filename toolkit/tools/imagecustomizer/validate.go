@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+var (
+	validateCommand = app.Command("validate", "Validate a config file without customizing an image.")
+
+	validateConfigFile      = validateCommand.Flag("config-file", "Path of the image customization config file.").Required().String()
+	validateConfigFragments = validateCommand.Flag("config-fragment", "Path of a config file to layer on top of the config file. May be specified multiple times.").Strings()
+	validateMatrixCells     = validateCommand.Flag("matrix-cell", "Name of a cell of the config file's matrix to validate. May be specified multiple times. By default, all the cells are validated.").Strings()
+)
+
+func validateConfig() error {
+	matrix, err := imagecustomizerapi.LoadConfigFileMatrix(*validateConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if matrix == nil && len(*validateMatrixCells) <= 0 {
+		var config imagecustomizerapi.Config
+		err = imagecustomizerapi.UnmarshalConfigFile(*validateConfigFile, *validateConfigFragments, &config)
+		if err != nil {
+			return err
+		}
+
+		logger.Log.Infof("Config file (%s) is valid", *validateConfigFile)
+		return nil
+	}
+
+	cellNames := *validateMatrixCells
+	if len(cellNames) <= 0 {
+		for _, cell := range matrix.Cells() {
+			cellNames = append(cellNames, cell.Name)
+		}
+	}
+
+	for _, cellName := range cellNames {
+		var config imagecustomizerapi.Config
+		_, err = imagecustomizerapi.UnmarshalConfigFileMatrixCell(*validateConfigFile, cellName,
+			*validateConfigFragments, &config)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Log.Infof("Config file (%s) is valid (matrix cells: %d)", *validateConfigFile, len(cellNames))
+	return nil
+}
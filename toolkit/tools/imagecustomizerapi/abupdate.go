@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path"
+)
+
+const (
+	// The suffix added to the root partition's ID and label, to form the ID and label of slot B's partition.
+	AbUpdateSlotBSuffix = "-b"
+
+	DefaultAbUpdateMetadataPath = "/etc/ab-update/slots.json"
+)
+
+// AbUpdate configures an A/B update partition layout. The root partition is duplicated to create a second slot. The
+// ESP and any other partitions are shared by both slots.
+type AbUpdate struct {
+	// MetadataPath is the path, within each slot's root filesystem, of the file that describes the slots.
+	MetadataPath string `yaml:"metadataPath"`
+
+	// The ID of the root partition (i.e. slot A).
+	// Value is filled in by Storage.IsValid().
+	SlotAPartitionId string
+	// The ID of the generated partition of slot B.
+	// Value is filled in by Storage.IsValid().
+	SlotBPartitionId string
+}
+
+func (a *AbUpdate) IsValid() error {
+	if a.MetadataPath != "" {
+		if err := validatePath(a.MetadataPath); err != nil {
+			return fmt.Errorf("invalid metadataPath (%s):\n%w", a.MetadataPath, err)
+		}
+
+		if path.Clean(a.MetadataPath) == "/" {
+			return fmt.Errorf("invalid metadataPath (%s): must be a file path", a.MetadataPath)
+		}
+	}
+
+	return nil
+}
+
+func (a *AbUpdate) GetMetadataPath() string {
+	if a.MetadataPath == "" {
+		return DefaultAbUpdateMetadataPath
+	}
+
+	return a.MetadataPath
+}
+
+// addAbUpdateSlotB adds slot B's partition to the disk, immediately after the root partition, with the same size
+// and type.
+func (s *Storage) addAbUpdateSlotB() error {
+	if s.AbUpdate.SlotBPartitionId != "" {
+		// Partition has already been added.
+		return nil
+	}
+
+	if len(s.Disks) <= 0 {
+		return fmt.Errorf("cannot specify 'abUpdate' without specifying 'disks'")
+	}
+
+	if s.BootType != BootTypeEfi {
+		return fmt.Errorf("'abUpdate' requires 'bootType' to be 'efi'")
+	}
+
+	if len(s.Verity) > 0 {
+		return fmt.Errorf("'abUpdate' cannot be combined with 'verity'")
+	}
+
+	rootDeviceId := ""
+	for _, fileSystem := range s.FileSystems {
+		if fileSystem.MountPoint == nil {
+			continue
+		}
+
+		switch path.Clean(fileSystem.MountPoint.Path) {
+		case "/":
+			rootDeviceId = fileSystem.DeviceId
+
+		case "/boot":
+			return fmt.Errorf("'abUpdate' doesn't support a separate /boot partition: each slot must have its own " +
+				"kernel")
+		}
+	}
+
+	if rootDeviceId == "" {
+		return fmt.Errorf("'abUpdate' requires a root filesystem (i.e. mountPoint.path equal to '/')")
+	}
+
+	disk := &s.Disks[0]
+
+	rootIndex := -1
+	for i, partition := range disk.Partitions {
+		if partition.Id == rootDeviceId {
+			rootIndex = i
+			break
+		}
+	}
+
+	if rootIndex < 0 {
+		return fmt.Errorf("'abUpdate' requires the root filesystem's 'deviceId' (%s) to be a partition", rootDeviceId)
+	}
+
+	root := disk.Partitions[rootIndex]
+	if root.Size.Type != PartitionSizeTypeExplicit {
+		return fmt.Errorf("'abUpdate' requires the root partition (%s) to have an explicit 'size'", root.Id)
+	}
+
+	slotB := Partition{
+		Id:   root.Id + AbUpdateSlotBSuffix,
+		Size: root.Size,
+		Type: root.Type,
+	}
+	if root.Label != "" {
+		slotB.Label = root.Label + AbUpdateSlotBSuffix
+	}
+
+	for _, partition := range disk.Partitions {
+		if partition.Id == slotB.Id {
+			return fmt.Errorf("partition ID (%s) is reserved for the A/B update slot B partition", slotB.Id)
+		}
+	}
+
+	partitions := append([]Partition(nil), disk.Partitions[:rootIndex+1]...)
+	partitions = append(partitions, slotB)
+	partitions = append(partitions, disk.Partitions[rootIndex+1:]...)
+	disk.Partitions = partitions
+
+	s.AbUpdate.SlotAPartitionId = root.Id
+	s.AbUpdate.SlotBPartitionId = slotB.Id
+	return nil
+}
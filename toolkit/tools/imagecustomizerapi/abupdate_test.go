@@ -0,0 +1,147 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func abUpdateTestStorage() Storage {
+	return Storage{
+		Disks: []Disk{{
+			PartitionTableType: "gpt",
+			Partitions: []Partition{
+				{
+					Id: "esp",
+					Size: PartitionSize{
+						Type: PartitionSizeTypeExplicit,
+						Size: 8 * diskutils.MiB,
+					},
+					Type: PartitionTypeESP,
+				},
+				{
+					Id:    "root",
+					Label: "rootfs",
+					Size: PartitionSize{
+						Type: PartitionSizeTypeExplicit,
+						Size: 2 * diskutils.GiB,
+					},
+					Type: PartitionTypeRoot,
+				},
+				{
+					Id: "data",
+					Size: PartitionSize{
+						Type: PartitionSizeTypeGrow,
+					},
+				},
+			},
+			MaxSize: ptrutils.PtrTo(DiskSize(8 * diskutils.GiB)),
+		}},
+		BootType: "efi",
+		FileSystems: []FileSystem{
+			{
+				DeviceId: "esp",
+				Type:     "vfat",
+				MountPoint: &MountPoint{
+					Path: "/boot/efi",
+				},
+			},
+			{
+				DeviceId: "root",
+				Type:     "ext4",
+				MountPoint: &MountPoint{
+					Path: "/",
+				},
+			},
+			{
+				DeviceId: "data",
+				Type:     "ext4",
+				MountPoint: &MountPoint{
+					Path: "/data",
+				},
+			},
+		},
+		AbUpdate: &AbUpdate{},
+	}
+}
+
+func TestStorageIsValidAbUpdate(t *testing.T) {
+	value := abUpdateTestStorage()
+
+	err := value.IsValid()
+	assert.NoError(t, err)
+
+	partitions := value.Disks[0].Partitions
+	assert.Len(t, partitions, 4)
+	assert.Equal(t, "root-b", partitions[2].Id)
+	assert.Equal(t, "rootfs-b", partitions[2].Label)
+	assert.Equal(t, PartitionTypeRoot, partitions[2].Type)
+	assert.Equal(t, DiskSize(9*diskutils.MiB+2*diskutils.GiB), *partitions[2].Start)
+	assert.Equal(t, DiskSize(9*diskutils.MiB+4*diskutils.GiB), *partitions[3].Start)
+	assert.Equal(t, "root", value.AbUpdate.SlotAPartitionId)
+	assert.Equal(t, "root-b", value.AbUpdate.SlotBPartitionId)
+	assert.Equal(t, DefaultAbUpdateMetadataPath, value.AbUpdate.GetMetadataPath())
+
+	// Validating again must not add another partition.
+	err = value.IsValid()
+	assert.NoError(t, err)
+	assert.Len(t, value.Disks[0].Partitions, 4)
+}
+
+func TestStorageIsValidAbUpdateGrowRoot(t *testing.T) {
+	value := abUpdateTestStorage()
+	value.Disks[0].Partitions[1].Size = PartitionSize{Type: PartitionSizeTypeGrow}
+	value.Disks[0].Partitions = value.Disks[0].Partitions[:2]
+	value.FileSystems = value.FileSystems[:2]
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "'abUpdate' requires the root partition (root) to have an explicit 'size'")
+}
+
+func TestStorageIsValidAbUpdateSeparateBoot(t *testing.T) {
+	value := abUpdateTestStorage()
+	value.FileSystems[2].MountPoint.Path = "/boot"
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "'abUpdate' doesn't support a separate /boot partition")
+}
+
+func TestStorageIsValidAbUpdateLegacyBoot(t *testing.T) {
+	value := abUpdateTestStorage()
+	value.BootType = BootTypeLegacy
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "'abUpdate' requires 'bootType' to be 'efi'")
+}
+
+func TestStorageIsValidAbUpdateReservedId(t *testing.T) {
+	value := abUpdateTestStorage()
+	value.Disks[0].Partitions[2].Id = "root-b"
+	value.FileSystems[2].DeviceId = "root-b"
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "partition ID (root-b) is reserved for the A/B update slot B partition")
+}
+
+func TestStorageIsValidAbUpdateNoDisks(t *testing.T) {
+	value := Storage{
+		AbUpdate: &AbUpdate{},
+	}
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "cannot specify 'abUpdate' without specifying 'disks'")
+}
+
+func TestAbUpdateIsValidBadMetadataPath(t *testing.T) {
+	value := AbUpdate{
+		MetadataPath: "slots.json",
+	}
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "invalid metadataPath (slots.json)")
+}
@@ -5,6 +5,7 @@ package imagecustomizerapi
 
 import (
 	"fmt"
+	"text/template"
 )
 
 type AdditionalFileList []AdditionalFile
@@ -23,6 +24,18 @@ type AdditionalFile struct {
 
 	// The file permissions to set on the file.
 	Permissions *FilePermissions `yaml:"permissions"`
+
+	// The user (name or ID) to set as the file's owner.
+	Owner string `yaml:"owner"`
+
+	// The group (name or ID) to set as the file's group.
+	Group string `yaml:"group"`
+
+	// The SELinux label to set on the file, instead of the label from the SELinux policy.
+	SELinuxLabel string `yaml:"selinuxLabel"`
+
+	// If true, then the file's contents are a Go text/template that is rendered with the OS's template variables.
+	Template bool `yaml:"template"`
 }
 
 func (l AdditionalFileList) IsValid() (err error) {
@@ -57,5 +70,22 @@ func (f *AdditionalFile) IsValid() (err error) {
 		}
 	}
 
+	err = validateFileOwnership(f.Owner, f.Group, f.SELinuxLabel)
+	if err != nil {
+		return err
+	}
+
+	if f.Template && f.Content != nil {
+		_, err = template.New(f.Destination).Parse(*f.Content)
+		if err != nil {
+			return fmt.Errorf("invalid template content:\n%w", err)
+		}
+	}
+
 	return nil
 }
+
+// hasOSOnlyFields returns true if the file uses fields that only apply to files that are placed in the OS.
+func (f *AdditionalFile) hasOSOnlyFields() bool {
+	return f.Owner != "" || f.Group != "" || f.SELinuxLabel != "" || f.Template
+}
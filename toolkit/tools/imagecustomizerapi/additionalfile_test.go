@@ -63,3 +63,39 @@ func TestAdditionalFilesIsValidBadPermissions(t *testing.T) {
 	assert.ErrorContains(t, err, "invalid permissions value")
 	assert.ErrorContains(t, err, "0o7000 contains non-permission bits")
 }
+
+func TestAdditionalFilesIsValidOwnership(t *testing.T) {
+	additionalFiles := AdditionalFileList{
+		{
+			Destination:  "/etc/app.conf",
+			Source:       "app.conf",
+			Owner:        "app",
+			Group:        "1000",
+			SELinuxLabel: "system_u:object_r:etc_t:s0",
+		},
+	}
+	err := additionalFiles.IsValid()
+	assert.NoError(t, err)
+
+	additionalFiles[0].Owner = "app:app"
+	err = additionalFiles.IsValid()
+	assert.ErrorContains(t, err, "invalid owner value (app:app)")
+
+	additionalFiles[0].Owner = ""
+	additionalFiles[0].SELinuxLabel = "etc_t"
+	err = additionalFiles.IsValid()
+	assert.ErrorContains(t, err, "invalid selinuxLabel value (etc_t)")
+}
+
+func TestAdditionalFilesIsValidBadTemplate(t *testing.T) {
+	additionalFiles := AdditionalFileList{
+		{
+			Destination: "/etc/app.conf",
+			Content:     ptrutils.PtrTo("region={{ .region"),
+			Template:    true,
+		},
+	}
+	err := additionalFiles.IsValid()
+	assert.ErrorContains(t, err, "invalid value at index 0")
+	assert.ErrorContains(t, err, "invalid template content")
+}
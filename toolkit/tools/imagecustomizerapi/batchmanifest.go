@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var batchImageNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// BatchManifest lists the images that are built together by the batch subcommand.
+type BatchManifest struct {
+	Images []BatchImage `yaml:"images"`
+}
+
+// BatchImage is a single image of a batch build.
+type BatchImage struct {
+	// Name identifies the image within the batch's build directory, logs and report. If not specified, then the name
+	// of the output image file (without its extension) is used.
+	Name string `yaml:"name"`
+	// ImageFile is the base image that is customized.
+	ImageFile string `yaml:"imageFile"`
+	// ConfigFile is the image customization config file.
+	ConfigFile string `yaml:"configFile"`
+	// OutputImageFile is the path to write the customized image to.
+	OutputImageFile string `yaml:"outputImageFile"`
+	// OutputImageFormat is the format of the customized image. If not specified, then the extension of the output
+	// image file is used.
+	OutputImageFormat string `yaml:"outputImageFormat"`
+}
+
+func (m *BatchManifest) IsValid() error {
+	if len(m.Images) == 0 {
+		return fmt.Errorf("'images' must not be empty")
+	}
+
+	for i := range m.Images {
+		err := m.Images[i].IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'images' item at index %d:\n%w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (i *BatchImage) IsValid() error {
+	if i.Name != "" && (!batchImageNameRegex.MatchString(i.Name) || i.Name == "." || i.Name == "..") {
+		return fmt.Errorf("invalid 'name' value (%s): may only contain letters, digits, '_', '.' and '-'", i.Name)
+	}
+
+	if i.ImageFile == "" {
+		return fmt.Errorf("'imageFile' must be specified")
+	}
+
+	if i.ConfigFile == "" {
+		return fmt.Errorf("'configFile' must be specified")
+	}
+
+	if i.OutputImageFile == "" {
+		return fmt.Errorf("'outputImageFile' must be specified")
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchManifestIsValid(t *testing.T) {
+	manifest := BatchManifest{
+		Images: []BatchImage{
+			{
+				Name:            "web",
+				ImageFile:       "base.vhdx",
+				ConfigFile:      "web.yaml",
+				OutputImageFile: "out/web.vhdx",
+			},
+		},
+	}
+	assert.NoError(t, manifest.IsValid())
+
+	manifest.Images[0].Name = "web/arm64"
+	assert.ErrorContains(t, manifest.IsValid(), "invalid 'images' item at index 0")
+	assert.ErrorContains(t, manifest.IsValid(), "invalid 'name' value (web/arm64)")
+
+	manifest.Images[0].Name = ".."
+	assert.ErrorContains(t, manifest.IsValid(), "invalid 'name' value (..)")
+
+	manifest.Images[0].Name = ""
+	manifest.Images[0].ConfigFile = ""
+	assert.ErrorContains(t, manifest.IsValid(), "'configFile' must be specified")
+}
+
+func TestBatchManifestIsValidEmpty(t *testing.T) {
+	manifest := BatchManifest{}
+	assert.ErrorContains(t, manifest.IsValid(), "'images' must not be empty")
+}
@@ -0,0 +1,25 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ChangeManifest enables recording the files and packages changed during customization into a JSON manifest.
+type ChangeManifest struct {
+	// ImagePath is an optional path within the OS image to also write the manifest to.
+	ImagePath string `yaml:"imagePath"`
+}
+
+func (c *ChangeManifest) IsValid() error {
+	if c.ImagePath != "" {
+		if !filepath.IsAbs(c.ImagePath) || filepath.Clean(c.ImagePath) != c.ImagePath {
+			return fmt.Errorf("invalid imagePath (%s): must be a clean absolute path", c.ImagePath)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeManifestIsValid(t *testing.T) {
+	changeManifest := ChangeManifest{
+		ImagePath: "/usr/share/image-customizer/changes.json",
+	}
+
+	err := changeManifest.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestChangeManifestIsValidNoImagePath(t *testing.T) {
+	changeManifest := ChangeManifest{}
+
+	err := changeManifest.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestChangeManifestIsValidRelativeImagePath(t *testing.T) {
+	changeManifest := ChangeManifest{
+		ImagePath: "changes.json",
+	}
+
+	err := changeManifest.IsValid()
+	assert.ErrorContains(t, err, "invalid imagePath (changes.json)")
+}
+
+func TestChangeManifestIsValidUncleanImagePath(t *testing.T) {
+	changeManifest := ChangeManifest{
+		ImagePath: "/usr/../changes.json",
+	}
+
+	err := changeManifest.IsValid()
+	assert.ErrorContains(t, err, "invalid imagePath (/usr/../changes.json)")
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// The name of the cloud-init datasource that reads the seed data written by 'noCloud'.
+	CloudInitDatasourceNoCloud = "NoCloud"
+)
+
+var (
+	// Matches the datasource names that cloud-init accepts (e.g. "NoCloud", "Azure", "None").
+	cloudInitDatasourceRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+)
+
+// CloudInitSeedLocation specifies where the NoCloud seed data is written to.
+type CloudInitSeedLocation string
+
+const (
+	// CloudInitSeedLocationDefault writes the seed data into the image.
+	CloudInitSeedLocationDefault CloudInitSeedLocation = ""
+	// CloudInitSeedLocationImage writes the seed data into the image.
+	CloudInitSeedLocationImage CloudInitSeedLocation = "image"
+	// CloudInitSeedLocationIso writes the seed data to a separate ISO file, with the 'cidata' volume label.
+	CloudInitSeedLocationIso CloudInitSeedLocation = "iso"
+)
+
+func (l CloudInitSeedLocation) IsValid() error {
+	switch l {
+	case CloudInitSeedLocationDefault, CloudInitSeedLocationImage, CloudInitSeedLocationIso:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("invalid seedLocation value (%v)", l)
+	}
+}
+
+// CloudInit configures cloud-init.
+type CloudInit struct {
+	// Disabled prevents cloud-init from running when the OS boots.
+	Disabled bool `yaml:"disabled"`
+	// DatasourceList is the list of datasources that cloud-init searches, in order.
+	DatasourceList []string `yaml:"datasourceList"`
+	// NoCloud is the seed data for the NoCloud datasource.
+	NoCloud *CloudInitNoCloud `yaml:"noCloud"`
+}
+
+// CloudInitNoCloud is the seed data for cloud-init's NoCloud datasource.
+type CloudInitNoCloud struct {
+	SeedLocation  CloudInitSeedLocation `yaml:"seedLocation"`
+	UserData      *CloudInitSeedFile    `yaml:"userData"`
+	MetaData      *CloudInitSeedFile    `yaml:"metaData"`
+	NetworkConfig *CloudInitSeedFile    `yaml:"networkConfig"`
+}
+
+// CloudInitSeedFile specifies the contents of a NoCloud seed file.
+type CloudInitSeedFile struct {
+	// The source file path of the file that will be copied.
+	// Mutually exclusive with 'content'.
+	Source string `yaml:"source"`
+
+	// A string that will be used as the contents of the file.
+	// Mutually exclusive with 'source'.
+	Content *string `yaml:"content"`
+}
+
+func (c *CloudInit) IsValid() error {
+	if c.Disabled && (len(c.DatasourceList) > 0 || c.NoCloud != nil) {
+		return fmt.Errorf("cannot specify 'datasourceList' or 'noCloud' when 'disabled' is true")
+	}
+
+	datasources := make(map[string]bool)
+	for i, datasource := range c.DatasourceList {
+		if !cloudInitDatasourceRegex.MatchString(datasource) {
+			return fmt.Errorf("invalid datasourceList item at index %d:\ninvalid datasource name (%s)", i,
+				datasource)
+		}
+
+		if datasources[datasource] {
+			return fmt.Errorf("invalid datasourceList item at index %d:\nduplicate datasource (%s)", i, datasource)
+		}
+		datasources[datasource] = true
+	}
+
+	if c.NoCloud != nil {
+		err := c.NoCloud.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid noCloud:\n%w", err)
+		}
+
+		if len(c.DatasourceList) > 0 && !datasources[CloudInitDatasourceNoCloud] {
+			return fmt.Errorf("'datasourceList' must include '%s' when 'noCloud' is specified",
+				CloudInitDatasourceNoCloud)
+		}
+	}
+
+	return nil
+}
+
+func (n *CloudInitNoCloud) IsValid() error {
+	err := n.SeedLocation.IsValid()
+	if err != nil {
+		return err
+	}
+
+	if n.UserData == nil && n.MetaData == nil && n.NetworkConfig == nil {
+		return fmt.Errorf("must specify at least one of 'userData', 'metaData', or 'networkConfig'")
+	}
+
+	seedFiles := []struct {
+		name string
+		file *CloudInitSeedFile
+	}{
+		{"userData", n.UserData},
+		{"metaData", n.MetaData},
+		{"networkConfig", n.NetworkConfig},
+	}
+
+	for _, seedFile := range seedFiles {
+		if seedFile.file == nil {
+			continue
+		}
+
+		err = seedFile.file.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid %s:\n%w", seedFile.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *CloudInitSeedFile) IsValid() error {
+	if f.Source == "" && f.Content == nil {
+		return fmt.Errorf("must specify either 'source' or 'content'")
+	}
+
+	if f.Source != "" && f.Content != nil {
+		return fmt.Errorf("cannot specify both 'source' and 'content'")
+	}
+
+	return nil
+}
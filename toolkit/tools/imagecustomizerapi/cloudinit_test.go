@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudInitIsValid(t *testing.T) {
+	metaData := "instance-id: test\n"
+	cloudInit := CloudInit{
+		DatasourceList: []string{"NoCloud", "None"},
+		NoCloud: &CloudInitNoCloud{
+			SeedLocation: CloudInitSeedLocationIso,
+			UserData:     &CloudInitSeedFile{Source: "files/user-data"},
+			MetaData:     &CloudInitSeedFile{Content: &metaData},
+		},
+	}
+
+	err := cloudInit.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestCloudInitIsValidDisabled(t *testing.T) {
+	cloudInit := CloudInit{
+		Disabled: true,
+	}
+
+	err := cloudInit.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestCloudInitIsValidDisabledWithDatasourceList(t *testing.T) {
+	cloudInit := CloudInit{
+		Disabled:       true,
+		DatasourceList: []string{"NoCloud"},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "cannot specify 'datasourceList' or 'noCloud' when 'disabled' is true")
+}
+
+func TestCloudInitIsValidInvalidDatasource(t *testing.T) {
+	cloudInit := CloudInit{
+		DatasourceList: []string{"NoCloud", "No Cloud"},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "invalid datasourceList item at index 1")
+	assert.ErrorContains(t, err, "invalid datasource name (No Cloud)")
+}
+
+func TestCloudInitIsValidDuplicateDatasource(t *testing.T) {
+	cloudInit := CloudInit{
+		DatasourceList: []string{"Azure", "Azure"},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "duplicate datasource (Azure)")
+}
+
+func TestCloudInitIsValidNoCloudNotInDatasourceList(t *testing.T) {
+	cloudInit := CloudInit{
+		DatasourceList: []string{"Azure"},
+		NoCloud: &CloudInitNoCloud{
+			UserData: &CloudInitSeedFile{Source: "files/user-data"},
+		},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "'datasourceList' must include 'NoCloud' when 'noCloud' is specified")
+}
+
+func TestCloudInitIsValidNoCloudEmpty(t *testing.T) {
+	cloudInit := CloudInit{
+		NoCloud: &CloudInitNoCloud{},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "must specify at least one of 'userData', 'metaData', or 'networkConfig'")
+}
+
+func TestCloudInitIsValidInvalidSeedLocation(t *testing.T) {
+	cloudInit := CloudInit{
+		NoCloud: &CloudInitNoCloud{
+			SeedLocation: "usb",
+			UserData:     &CloudInitSeedFile{Source: "files/user-data"},
+		},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "invalid seedLocation value (usb)")
+}
+
+func TestCloudInitIsValidSeedFileSourceAndContent(t *testing.T) {
+	content := ""
+	cloudInit := CloudInit{
+		NoCloud: &CloudInitNoCloud{
+			NetworkConfig: &CloudInitSeedFile{Source: "files/network-config", Content: &content},
+		},
+	}
+
+	err := cloudInit.IsValid()
+	assert.ErrorContains(t, err, "invalid networkConfig")
+	assert.ErrorContains(t, err, "cannot specify both 'source' and 'content'")
+}
@@ -11,6 +11,18 @@ type Config struct {
 	Pxe     *Pxe    `yaml:"pxe"`
 	OS      *OS     `yaml:"os"`
 	Scripts Scripts `yaml:"scripts"`
+
+	ContainerImage *ContainerImage `yaml:"containerImage"`
+	Wsl            *Wsl            `yaml:"wsl"`
+
+	ChangeManifest *ChangeManifest `yaml:"changeManifest"`
+	SELinuxReport  *SELinuxReport  `yaml:"selinuxReport"`
+	Hotfix         *Hotfix         `yaml:"hotfix"`
+	InitrdRebuild  *InitrdRebuild  `yaml:"initrdRebuild"`
+	Signing        *Signing        `yaml:"signing"`
+	Finalize       *Finalize       `yaml:"finalize"`
+	Validation     *Validation     `yaml:"validation"`
+	Run            *Run            `yaml:"run"`
 }
 
 func (c *Config) IsValid() (err error) {
@@ -49,10 +61,117 @@ func (c *Config) IsValid() (err error) {
 		return err
 	}
 
+	if c.ContainerImage != nil {
+		err = c.ContainerImage.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'containerImage' field:\n%w", err)
+		}
+	}
+
+	if c.Wsl != nil {
+		err = c.Wsl.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'wsl' field:\n%w", err)
+		}
+	}
+
+	if c.ChangeManifest != nil {
+		err = c.ChangeManifest.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'changeManifest' field:\n%w", err)
+		}
+	}
+
+	if c.SELinuxReport != nil {
+		err = c.SELinuxReport.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'selinuxReport' field:\n%w", err)
+		}
+	}
+
+	if c.Hotfix != nil {
+		err = c.Hotfix.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'hotfix' field:\n%w", err)
+		}
+
+		if c.CustomizePartitions() || hasResetPartitionsUuids || len(c.Storage.Verity) > 0 ||
+			len(c.Storage.EncryptedVolumes) > 0 || c.OS != nil ||
+			c.Scripts.HasScripts() || c.Iso != nil || c.Pxe != nil {
+			return fmt.Errorf("'hotfix' cannot be combined with 'storage', 'os', 'scripts', 'iso', or 'pxe'")
+		}
+
+		if c.ContainerImage != nil {
+			return fmt.Errorf("'hotfix' cannot be combined with 'containerImage'")
+		}
+
+		if c.Wsl != nil {
+			return fmt.Errorf("'hotfix' cannot be combined with 'wsl'")
+		}
+	}
+
+	if c.InitrdRebuild != nil {
+		err = c.InitrdRebuild.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'initrdRebuild' field:\n%w", err)
+		}
+
+		if c.CustomizePartitions() || hasResetPartitionsUuids || len(c.Storage.Verity) > 0 ||
+			len(c.Storage.EncryptedVolumes) > 0 || c.OS != nil ||
+			c.Scripts.HasScripts() || c.Iso != nil || c.Pxe != nil {
+			return fmt.Errorf("'initrdRebuild' cannot be combined with 'storage', 'os', 'scripts', 'iso', or 'pxe'")
+		}
+
+		if c.Hotfix != nil {
+			return fmt.Errorf("'initrdRebuild' cannot be combined with 'hotfix'")
+		}
+
+		if c.ContainerImage != nil {
+			return fmt.Errorf("'initrdRebuild' cannot be combined with 'containerImage'")
+		}
+
+		if c.Wsl != nil {
+			return fmt.Errorf("'initrdRebuild' cannot be combined with 'wsl'")
+		}
+	}
+
+	if c.Signing != nil {
+		err = c.Signing.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'signing' field:\n%w", err)
+		}
+	}
+
+	if c.Finalize != nil {
+		err = c.Finalize.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'finalize' field:\n%w", err)
+		}
+	}
+
+	if c.Validation != nil {
+		err = c.Validation.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'validation' field:\n%w", err)
+		}
+	}
+
+	if c.Run != nil {
+		err = c.Run.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'run' field:\n%w", err)
+		}
+	}
+
 	if c.CustomizePartitions() && !hasResetBootLoader {
 		return fmt.Errorf("'os.resetBootLoaderType' must be specified if 'storage.disks' is specified")
 	}
 
+	if len(c.Storage.EncryptedVolumes) > 0 && c.Scripts.OutputArtifactsDir == "" {
+		return fmt.Errorf("'scripts.outputArtifactsDir' must be specified if 'storage.encryptedVolumes' is " +
+			"specified, to receive the recovery keys")
+	}
+
 	if hasResetPartitionsUuids && !hasResetBootLoader {
 		return fmt.Errorf("'os.resetBootLoaderType' must be specified if 'storage.resetPartitionsUuidsType' is specified")
 	}
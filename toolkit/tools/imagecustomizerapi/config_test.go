@@ -519,3 +519,28 @@ func TestConfigIsValidVerityNoStorage(t *testing.T) {
 	err := config.IsValid()
 	assert.ErrorContains(t, err, "cannot specify 'verity' without specifying 'disks'")
 }
+
+func TestConfigIsValidEncryptedVolumes(t *testing.T) {
+	config := &Config{
+		Storage: encryptedVolumeTestStorage("/var"),
+		OS: &OS{
+			ResetBootLoaderType: "hard-reset",
+		},
+		Scripts: Scripts{
+			OutputArtifactsDir: "out",
+		},
+	}
+	err := config.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestConfigIsValidEncryptedVolumesNoOutputArtifactsDir(t *testing.T) {
+	config := &Config{
+		Storage: encryptedVolumeTestStorage("/var"),
+		OS: &OS{
+			ResetBootLoaderType: "hard-reset",
+		},
+	}
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "'scripts.outputArtifactsDir' must be specified if 'storage.encryptedVolumes' is specified")
+}
@@ -0,0 +1,533 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// The top-level key that lists the config files that a config file is layered on top of.
+	configIncludeKey = "include"
+
+	// A tag that causes a mapping or list to replace the one it is layered on top of, instead of being merged with it.
+	yamlReplaceTag = "!replace"
+
+	// A tag that causes a string to be appended (separated by a space) to the string it is layered on top of, instead
+	// of replacing it. For example, so that multiple files can each add kernel command-line arguments.
+	yamlAppendTag = "!append"
+)
+
+// UnmarshalConfigFile reads a config file, along with any files it includes, and then layers each of the fragment
+// files on top of it (in order).
+//
+// When a file is layered on top of another:
+//   - Mappings are merged key by key.
+//   - Lists are appended to.
+//   - All other values are replaced.
+//   - A mapping or list that has the "!replace" tag replaces the existing value, instead of being merged with it.
+//   - A string that has the "!append" tag is appended to the existing value (separated by a space).
+//
+// The feature modules that a file lists (see loadFeature) are layered underneath the file, before its includes.
+//
+// If the config file has a matrix, then UnmarshalConfigFileMatrixCell must be used instead.
+func UnmarshalConfigFile(configFile string, fragmentFiles []string, config *Config) error {
+	_, err := unmarshalConfigFile(&configComposer{}, configFile, "", fragmentFiles, config)
+	return err
+}
+
+// UnmarshalConfigFileMatrixCell is the same as UnmarshalConfigFile, except that the config of one of the cells of the
+// config file's matrix is layered on top of the config file, before the fragment files.
+func UnmarshalConfigFileMatrixCell(configFile string, cellName string, fragmentFiles []string, config *Config,
+) (MatrixCell, error) {
+	return unmarshalConfigFile(&configComposer{}, configFile, cellName, fragmentFiles, config)
+}
+
+// UnmarshalConfigFileWithinDir is the same as UnmarshalConfigFileMatrixCell, except that the config file, the files it
+// includes and the feature directories it lists must all be within rootDir (e.g. an extracted config bundle).
+func UnmarshalConfigFileWithinDir(configFile string, rootDir string, cellName string, fragmentFiles []string,
+	config *Config,
+) (MatrixCell, error) {
+	rootDirAbs, err := filepath.Abs(rootDir)
+	if err != nil {
+		return MatrixCell{}, err
+	}
+
+	return unmarshalConfigFile(&configComposer{rootDir: rootDirAbs}, configFile, cellName, fragmentFiles, config)
+}
+
+// LoadConfigFileMatrix returns the matrix of a config file. Returns nil if the config file doesn't have a matrix.
+func LoadConfigFileMatrix(configFile string) (*ConfigMatrix, error) {
+	composer := configComposer{}
+
+	_, err := composer.loadTopLevel(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return composer.matrix, nil
+}
+
+func unmarshalConfigFile(composer *configComposer, configFile string, cellName string, fragmentFiles []string,
+	config *Config,
+) (MatrixCell, error) {
+	document, err := composer.loadTopLevel(configFile)
+	if err != nil {
+		return MatrixCell{}, err
+	}
+
+	cell := MatrixCell{}
+	switch {
+	case composer.matrix != nil && cellName == "":
+		return MatrixCell{}, fmt.Errorf("config file (%s) has a matrix, so a matrix cell must be selected",
+			configFile)
+
+	case composer.matrix == nil && cellName != "":
+		return MatrixCell{}, fmt.Errorf("config file (%s) doesn't have a matrix, so matrix cell (%s) can't be selected",
+			configFile, cellName)
+
+	case composer.matrix != nil:
+		cell, err = composer.matrix.Cell(cellName)
+		if err != nil {
+			return MatrixCell{}, err
+		}
+
+		for _, cellConfig := range cell.configs {
+			cellDocument := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{cellConfig}}
+			document = mergeYamlNodes(document, cellDocument)
+		}
+	}
+
+	for _, fragmentFile := range fragmentFiles {
+		fragment, err := composer.load(fragmentFile)
+		if err != nil {
+			return MatrixCell{}, err
+		}
+
+		document = mergeYamlNodes(document, fragment)
+	}
+
+	clearYamlComposeTags(document, make(map[*yaml.Node]bool))
+
+	err = decodeYamlDocument(document, config)
+	if err != nil {
+		return MatrixCell{}, fmt.Errorf("failed to decode composed config file (%s):\n%w", configFile, err)
+	}
+
+	err = config.IsValid()
+	if err != nil {
+		if cellName != "" {
+			return MatrixCell{}, fmt.Errorf("invalid config for matrix cell (%s):\n%w", cellName, err)
+		}
+		return MatrixCell{}, err
+	}
+
+	return cell, nil
+}
+
+type configComposer struct {
+	// The files (and feature modules) that are currently being loaded, used to detect include cycles.
+	loadingStack []string
+	// The top-level config file. This is the only file that may have a matrix or feature directories.
+	topLevelFile string
+	// The matrix of the top-level config file.
+	matrix *ConfigMatrix
+	// The directories that feature modules are looked up in, before the toolkit's built-in feature modules.
+	featureDirs []string
+	// The feature modules that have already been layered in. Each feature module is only layered in once, even if
+	// multiple files list it.
+	loadedFeatures map[string]bool
+	// If set, the absolute path of the directory that all the loaded files and feature directories must be within.
+	rootDir string
+}
+
+// loadTopLevel reads the top-level config file and composes it with the files it includes.
+func (c *configComposer) loadTopLevel(configFile string) (*yaml.Node, error) {
+	configFileAbs, err := filepath.Abs(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c.topLevelFile = configFileAbs
+	return c.load(configFile)
+}
+
+// load reads a config file and composes it with the files it includes.
+func (c *configComposer) load(configFile string) (*yaml.Node, error) {
+	configFileAbs, err := filepath.Abs(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.checkWithinRootDir(configFileAbs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, loadingFile := range c.loadingStack {
+		if loadingFile == configFileAbs {
+			cycle := append(append([]string(nil), c.loadingStack[i:]...), configFileAbs)
+			return nil, fmt.Errorf("config include cycle:\n%s", strings.Join(cycle, " ->\n"))
+		}
+	}
+
+	c.loadingStack = append(c.loadingStack, configFileAbs)
+	defer func() {
+		c.loadingStack = c.loadingStack[:len(c.loadingStack)-1]
+	}()
+
+	parsed, err := parseConfigFile(configFileAbs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML file (%s):\n%w", configFile, err)
+	}
+
+	if parsed.matrix != nil {
+		if configFileAbs != c.topLevelFile {
+			return nil, fmt.Errorf("failed to parse YAML file (%s):\nline %d: %s can only be specified in the "+
+				"top-level config file", configFile, parsed.matrix.Line, configMatrixKey)
+		}
+
+		c.matrix, err = decodeConfigMatrix(parsed.matrix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' field in config file (%s):\n%w", configMatrixKey, configFile, err)
+		}
+	}
+
+	if parsed.featureDirsNode != nil {
+		if configFileAbs != c.topLevelFile {
+			return nil, fmt.Errorf("failed to parse YAML file (%s):\nline %d: %s can only be specified in the "+
+				"top-level config file", configFile, parsed.featureDirsNode.Line, configFeatureDirsKey)
+		}
+
+		for _, featureDir := range parsed.featureDirs {
+			if !filepath.IsAbs(featureDir) {
+				featureDir = filepath.Join(filepath.Dir(configFileAbs), featureDir)
+			}
+
+			err = c.checkWithinRootDir(featureDir)
+			if err != nil {
+				return nil, err
+			}
+
+			c.featureDirs = append(c.featureDirs, featureDir)
+		}
+	}
+
+	var composed *yaml.Node
+	for _, feature := range parsed.features {
+		featureDocument, err := c.loadFeature(feature)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load feature module (%s) of config file (%s):\n%w", feature,
+				configFile, err)
+		}
+
+		composed = mergeYamlNodes(composed, featureDocument)
+	}
+
+	for _, include := range parsed.includes {
+		includeFile := filepath.Join(filepath.Dir(configFileAbs), include)
+		if filepath.IsAbs(include) {
+			includeFile = include
+		}
+
+		included, err := c.load(includeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		composed = mergeYamlNodes(composed, included)
+	}
+
+	return mergeYamlNodes(composed, parsed.document), nil
+}
+
+// checkWithinRootDir checks that a path doesn't leave the composer's root directory (if it has one).
+func (c *configComposer) checkWithinRootDir(path string) error {
+	if c.rootDir == "" {
+		return nil
+	}
+
+	relativePath, err := filepath.Rel(c.rootDir, filepath.Clean(path))
+	if err != nil || !filepath.IsLocal(relativePath) {
+		return fmt.Errorf("path (%s) is outside of the directory (%s)", path, c.rootDir)
+	}
+
+	return nil
+}
+
+// parsedConfigFile is a config file, with the keys that are handled before the config is decoded removed.
+type parsedConfigFile struct {
+	document *yaml.Node
+	// The files that the config file is layered on top of.
+	includes []string
+	// The feature modules that the config file is layered on top of.
+	features []string
+	// The directories that feature modules are looked up in. featureDirsNode is nil if the key isn't specified.
+	featureDirs     []string
+	featureDirsNode *yaml.Node
+	// The config file's matrix (if any).
+	matrix *yaml.Node
+}
+
+// parseConfigFile parses a config file.
+func parseConfigFile(configFile string) (parsedConfigFile, error) {
+	file, err := os.Open(configFile)
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+	defer file.Close()
+
+	return parseConfig(file)
+}
+
+func parseConfig(reader io.Reader) (parsedConfigFile, error) {
+	document, err := parseYaml(reader)
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+
+	parsed := parsedConfigFile{
+		document: document,
+	}
+
+	parsed.includes, _, err = removeConfigStringList(document, configIncludeKey, "file paths")
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+
+	parsed.features, _, err = removeConfigStringList(document, configFeaturesKey, "feature module names")
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+
+	for _, feature := range parsed.features {
+		err = validateFeatureName(feature)
+		if err != nil {
+			return parsedConfigFile{}, err
+		}
+	}
+
+	parsed.featureDirs, parsed.featureDirsNode, err = removeConfigStringList(document, configFeatureDirsKey,
+		"directory paths")
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+
+	parsed.matrix = removeConfigMatrix(document)
+
+	// Check the fields of each file individually, so that errors report the line number within the correct file.
+	err = checkYamlFields(document, &Config{})
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+
+	err = checkYamlSchema(document, &Config{})
+	if err != nil {
+		return parsedConfigFile{}, err
+	}
+
+	return parsed, nil
+}
+
+// removeConfigStringList removes a top-level key, whose value is a list of strings, from the document and returns
+// its value, along with its key node (nil if the key isn't specified).
+func removeConfigStringList(document *yaml.Node, key string, itemsDescription string) ([]string, *yaml.Node,
+	error,
+) {
+	if len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, nil
+	}
+
+	root := document.Content[0]
+
+	values := []string(nil)
+	var foundKeyNode *yaml.Node
+	content := []*yaml.Node(nil)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode := root.Content[i]
+		valueNode := resolveYamlAlias(root.Content[i+1])
+
+		if keyNode.Kind != yaml.ScalarNode || keyNode.Value != key {
+			content = append(content, keyNode, root.Content[i+1])
+			continue
+		}
+
+		foundKeyNode = keyNode
+
+		if valueNode.Kind != yaml.SequenceNode {
+			return nil, nil, fmt.Errorf("line %d: %s must be a list of %s", keyNode.Line, key, itemsDescription)
+		}
+
+		for _, item := range valueNode.Content {
+			item = resolveYamlAlias(item)
+			if item.Kind != yaml.ScalarNode || item.Value == "" {
+				return nil, nil, fmt.Errorf("line %d: %s must be a list of %s", item.Line, key, itemsDescription)
+			}
+
+			values = append(values, item.Value)
+		}
+	}
+
+	root.Content = content
+	return values, foundKeyNode, nil
+}
+
+// mergeYamlNodes layers the override node on top of the base node. Neither of the input nodes are modified.
+func mergeYamlNodes(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	if base == nil {
+		return override
+	}
+
+	if override == nil {
+		return base
+	}
+
+	if base.Kind == yaml.DocumentNode && override.Kind == yaml.DocumentNode {
+		if len(base.Content) != 1 || len(override.Content) != 1 {
+			return override
+		}
+
+		merged := *base
+		merged.Content = []*yaml.Node{mergeYamlNodes(base.Content[0], override.Content[0])}
+		return &merged
+	}
+
+	base = resolveYamlAlias(base)
+	override = resolveYamlAlias(override)
+
+	if override.Tag == yamlAppendTag && override.Kind == yaml.ScalarNode && base.Kind == yaml.ScalarNode {
+		merged := *override
+		if base.Value != "" {
+			merged.Value = base.Value + " " + override.Value
+		}
+		return &merged
+	}
+
+	if override.Tag == yamlReplaceTag || base.Kind != override.Kind {
+		return override
+	}
+
+	switch override.Kind {
+	case yaml.MappingNode:
+		base = flattenYamlMapping(base)
+		override = flattenYamlMapping(override)
+
+		merged := *base
+		merged.Content = nil
+
+		overrideValues := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(override.Content); i += 2 {
+			overrideValues[override.Content[i].Value] = override.Content[i+1]
+		}
+
+		baseKeys := make(map[string]bool)
+		for i := 0; i+1 < len(base.Content); i += 2 {
+			keyNode := base.Content[i]
+			valueNode := base.Content[i+1]
+			baseKeys[keyNode.Value] = true
+
+			if overrideValue, found := overrideValues[keyNode.Value]; found {
+				valueNode = mergeYamlNodes(valueNode, overrideValue)
+			}
+
+			merged.Content = append(merged.Content, keyNode, valueNode)
+		}
+
+		for i := 0; i+1 < len(override.Content); i += 2 {
+			if !baseKeys[override.Content[i].Value] {
+				merged.Content = append(merged.Content, override.Content[i], override.Content[i+1])
+			}
+		}
+
+		return &merged
+
+	case yaml.SequenceNode:
+		merged := *base
+		merged.Content = append(append([]*yaml.Node(nil), base.Content...), override.Content...)
+		return &merged
+
+	default:
+		return override
+	}
+}
+
+// flattenYamlMapping returns a copy of the mapping with its merge keys (<<) expanded.
+func flattenYamlMapping(node *yaml.Node) *yaml.Node {
+	hasMergeKey := false
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if isYamlMergeKey(node.Content[i]) {
+			hasMergeKey = true
+			break
+		}
+	}
+
+	if !hasMergeKey {
+		return node
+	}
+
+	flattened := *node
+	flattened.Content = nil
+
+	keys := make(map[string]bool)
+	addEntries := func(mapping *yaml.Node) {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			keyNode := mapping.Content[i]
+			if isYamlMergeKey(keyNode) || keys[keyNode.Value] {
+				continue
+			}
+
+			keys[keyNode.Value] = true
+			flattened.Content = append(flattened.Content, keyNode, mapping.Content[i+1])
+		}
+	}
+
+	// Explicit keys take precedence over merged keys.
+	addEntries(node)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isYamlMergeKey(node.Content[i]) {
+			continue
+		}
+
+		mergeNode := resolveYamlAlias(node.Content[i+1])
+
+		mergeNodes := []*yaml.Node{mergeNode}
+		if mergeNode.Kind == yaml.SequenceNode {
+			mergeNodes = mergeNode.Content
+		}
+
+		// Within a list of merged mappings, earlier mappings take precedence.
+		for _, mergeNode := range mergeNodes {
+			addEntries(flattenYamlMapping(resolveYamlAlias(mergeNode)))
+		}
+	}
+
+	return &flattened
+}
+
+// clearYamlComposeTags removes the tags that control how files are layered, so that they don't affect decoding.
+func clearYamlComposeTags(node *yaml.Node, visited map[*yaml.Node]bool) {
+	if visited[node] {
+		return
+	}
+	visited[node] = true
+
+	if node.Tag == yamlReplaceTag || node.Tag == yamlAppendTag {
+		node.Tag = ""
+	}
+
+	if node.Alias != nil {
+		clearYamlComposeTags(node.Alias, visited)
+	}
+
+	for _, child := range node.Content {
+		clearYamlComposeTags(child, visited)
+	}
+}
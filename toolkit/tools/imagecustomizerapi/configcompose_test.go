@@ -0,0 +1,233 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(path, []byte(contents), 0o644)
+		require.NoError(t, err)
+	}
+	return dir
+}
+
+func TestUnmarshalConfigFileInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"shared/base.yaml": `
+include:
+- common.yaml
+os:
+  hostname: base
+  packages:
+    install: [openssh-server]
+`,
+		"shared/common.yaml": `
+os:
+  packages:
+    install: [vim]
+  services:
+    enable: [sshd]
+`,
+		"product.yaml": `
+include:
+- shared/base.yaml
+os:
+  hostname: product
+  packages:
+    install: [nginx]
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "product.yaml"), nil, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, "product", config.OS.Hostname)
+		assert.Equal(t, []string{"vim", "openssh-server", "nginx"}, config.OS.Packages.Install)
+		assert.Equal(t, []string{"sshd"}, config.OS.Services.Enable)
+	}
+}
+
+func TestUnmarshalConfigFileFragments(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+os:
+  hostname: base
+  packages:
+    install: [vim]
+  users:
+  - name: alice
+`,
+		"fragment1.yaml": `
+os:
+  packages:
+    install: [nginx]
+`,
+		"fragment2.yaml": `
+os:
+  hostname: fragment
+  packages:
+    install: !replace [curl]
+  users: !replace
+  - name: bob
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "base.yaml"),
+		[]string{filepath.Join(dir, "fragment1.yaml"), filepath.Join(dir, "fragment2.yaml")}, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, "fragment", config.OS.Hostname)
+		assert.Equal(t, []string{"curl"}, config.OS.Packages.Install)
+		if assert.Len(t, config.OS.Users, 1) {
+			assert.Equal(t, "bob", config.OS.Users[0].Name)
+		}
+	}
+}
+
+func TestUnmarshalConfigFileReplaceWithoutBase(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+os:
+  packages:
+    install: !replace [vim]
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "base.yaml"), nil, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, []string{"vim"}, config.OS.Packages.Install)
+	}
+}
+
+func TestUnmarshalConfigFileMergeKeys(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+x-user: &user
+  startupCommand: /usr/bin/bash
+os:
+  services: &services
+    enable: [sshd]
+  users:
+  - <<: *user
+    name: alice
+`,
+		"fragment.yaml": `
+x-services: &services
+  disable: [cron]
+os:
+  services:
+    <<: *services
+    enable: [nginx]
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "base.yaml"), []string{filepath.Join(dir, "fragment.yaml")},
+		&config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, []string{"sshd", "nginx"}, config.OS.Services.Enable)
+		assert.Equal(t, []string{"cron"}, config.OS.Services.Disable)
+		if assert.Len(t, config.OS.Users, 1) {
+			assert.Equal(t, "/usr/bin/bash", config.OS.Users[0].StartupCommand)
+		}
+	}
+}
+
+func TestUnmarshalConfigFileIncludeCycle(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": "include: [b.yaml]\n",
+		"b.yaml": "include: [a.yaml]\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "config include cycle")
+	assert.ErrorContains(t, err, filepath.Join(dir, "b.yaml")+" ->")
+}
+
+func TestUnmarshalConfigFileIncludeNotList(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": "include: b.yaml\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "line 1: include must be a list of file paths")
+}
+
+func TestUnmarshalConfigFileIncludeMissing(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": "include: [missing.yaml]\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "missing.yaml")
+}
+
+func TestUnmarshalConfigFileWithinDir(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"bundle/config.yaml":        "include: [shared/base.yaml]\nfeatureDirs: [features]\n",
+		"bundle/shared/base.yaml":   "os:\n  hostname: base\n",
+		"bundle/escape.yaml":        "include: [../outside.yaml]\n",
+		"bundle/escapefeature.yaml": "featureDirs: [../features]\n",
+		"outside.yaml":              "os:\n  hostname: outside\n",
+	})
+	bundleDir := filepath.Join(dir, "bundle")
+
+	var config Config
+	_, err := UnmarshalConfigFileWithinDir(filepath.Join(bundleDir, "config.yaml"), bundleDir, "", nil, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, "base", config.OS.Hostname)
+	}
+
+	_, err = UnmarshalConfigFileWithinDir(filepath.Join(bundleDir, "escape.yaml"), bundleDir, "", nil, &Config{})
+	assert.ErrorContains(t, err, "path ("+filepath.Join(dir, "outside.yaml")+") is outside of the directory")
+
+	_, err = UnmarshalConfigFileWithinDir(filepath.Join(bundleDir, "escapefeature.yaml"), bundleDir, "", nil,
+		&Config{})
+	assert.ErrorContains(t, err, "path ("+filepath.Join(dir, "features")+") is outside of the directory")
+}
+
+func TestUnmarshalConfigFileUnknownFieldInInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": "include: [b.yaml]\n",
+		"b.yaml": "os:\n  bogus: true\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "failed to parse YAML file ("+filepath.Join(dir, "b.yaml")+")")
+	assert.ErrorContains(t, err, "line 2: field bogus not found in type imagecustomizerapi.OS")
+}
+
+func TestUnmarshalConfigFileInvalidComposedConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml":     "os:\n  hostname: base\n",
+		"fragment.yaml": "os:\n  hostname: invalid_name\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "base.yaml"), []string{filepath.Join(dir, "fragment.yaml")},
+		&config)
+	assert.ErrorContains(t, err, "invalid hostname (invalid_name)")
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// The top-level key that lists the feature modules that a config file is layered on top of.
+	configFeaturesKey = "features"
+
+	// The top-level key that lists the directories that feature modules are looked up in, before the built-in feature
+	// modules.
+	configFeatureDirsKey = "featureDirs"
+
+	// The file extension of a feature module file.
+	featureFileExtension = ".yaml"
+
+	builtinFeaturesDir = "features"
+)
+
+var (
+	featureNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+	// The feature modules that are shipped with the toolkit.
+	//
+	//go:embed features/*.yaml
+	builtinFeatures embed.FS
+)
+
+func validateFeatureName(name string) error {
+	if !featureNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid feature module name (%s): must only contain lowercase letters, digits, and '-', "+
+			"and must start with a letter or digit", name)
+	}
+
+	return nil
+}
+
+// BuiltinFeatureNames returns the names of the feature modules that are shipped with the toolkit.
+func BuiltinFeatureNames() ([]string, error) {
+	entries, err := builtinFeatures.ReadDir(builtinFeaturesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string(nil)
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), featureFileExtension)
+		if found {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadFeature reads a feature module and composes it with the feature modules it depends on.
+//
+// A feature module is a config fragment that is looked up by name: first in the feature directories of the top-level
+// config file (in order) and then in the toolkit's built-in feature modules. So, a user can override a built-in
+// feature module by adding a file with the same name to one of their feature directories. A feature module may list
+// other feature modules that it depends on, but may not include files.
+//
+// Each feature module is only layered in once. Returns nil if the feature module has already been layered in.
+func (c *configComposer) loadFeature(name string) (*yaml.Node, error) {
+	stackEntry := configFeaturesKey + ":" + name
+	for i, loadingFile := range c.loadingStack {
+		if loadingFile == stackEntry {
+			cycle := append(append([]string(nil), c.loadingStack[i:]...), stackEntry)
+			return nil, fmt.Errorf("feature module cycle:\n%s", strings.Join(cycle, " ->\n"))
+		}
+	}
+
+	if c.loadedFeatures[name] {
+		return nil, nil
+	}
+
+	c.loadingStack = append(c.loadingStack, stackEntry)
+	defer func() {
+		c.loadingStack = c.loadingStack[:len(c.loadingStack)-1]
+	}()
+
+	parsed, featureFile, err := c.parseFeature(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(parsed.includes) > 0:
+		return nil, fmt.Errorf("feature module (%s) can't specify '%s'", featureFile, configIncludeKey)
+
+	case parsed.featureDirsNode != nil:
+		return nil, fmt.Errorf("feature module (%s) can't specify '%s'", featureFile, configFeatureDirsKey)
+
+	case parsed.matrix != nil:
+		return nil, fmt.Errorf("feature module (%s) can't specify '%s'", featureFile, configMatrixKey)
+	}
+
+	var composed *yaml.Node
+	for _, dependency := range parsed.features {
+		dependencyDocument, err := c.loadFeature(dependency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load feature module (%s) of feature module (%s):\n%w", dependency,
+				featureFile, err)
+		}
+
+		composed = mergeYamlNodes(composed, dependencyDocument)
+	}
+
+	if c.loadedFeatures == nil {
+		c.loadedFeatures = make(map[string]bool)
+	}
+	c.loadedFeatures[name] = true
+
+	return mergeYamlNodes(composed, parsed.document), nil
+}
+
+// parseFeature finds and parses a feature module. Also returns the path of the feature module's file, for error
+// messages.
+func (c *configComposer) parseFeature(name string) (parsedConfigFile, string, error) {
+	fileName := name + featureFileExtension
+
+	for _, featureDir := range c.featureDirs {
+		featureFile := filepath.Join(featureDir, fileName)
+
+		_, err := os.Stat(featureFile)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return parsedConfigFile{}, "", fmt.Errorf("failed to stat feature module (%s):\n%w", featureFile, err)
+		}
+
+		parsed, err := parseConfigFile(featureFile)
+		if err != nil {
+			return parsedConfigFile{}, "", fmt.Errorf("failed to parse YAML file (%s):\n%w", featureFile, err)
+		}
+
+		return parsed, featureFile, nil
+	}
+
+	featureFile := path.Join(builtinFeaturesDir, fileName)
+
+	file, err := builtinFeatures.Open(featureFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		builtinNames, _ := BuiltinFeatureNames()
+		return parsedConfigFile{}, "", fmt.Errorf("unknown feature module (%s) (built-in feature modules: %s)", name,
+			strings.Join(builtinNames, ", "))
+	}
+	if err != nil {
+		return parsedConfigFile{}, "", fmt.Errorf("failed to open built-in feature module (%s):\n%w", name, err)
+	}
+	defer file.Close()
+
+	featureFile = "builtin:" + featureFile
+
+	parsed, err := parseConfig(file)
+	if err != nil {
+		return parsedConfigFile{}, "", fmt.Errorf("failed to parse YAML file (%s):\n%w", featureFile, err)
+	}
+
+	return parsed, featureFile, nil
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestUnmarshalConfigFileBuiltinFeatures(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": `
+features:
+- azure-vm-agent
+- nvidia-gpu
+os:
+  packages:
+    install: [vim]
+  kernelCommandLine:
+    extraCommandLine: !append quiet
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, []string{"openssh-server", "WALinuxAgent", "nvidia-container-toolkit", "vim"},
+			config.OS.Packages.Install)
+		assert.Equal(t, []string{"sshd", "waagent"}, config.OS.Services.Enable)
+		assert.Equal(t, KernelExtraArguments("console=tty0 console=ttyS0,115200n8 rd.driver.blacklist=nouveau quiet"),
+			config.OS.KernelCommandLine.ExtraCommandLine)
+		assert.Equal(t, []Module{{Name: "nouveau", LoadMode: ModuleLoadModeDisable}}, config.OS.Modules)
+	}
+}
+
+func TestUnmarshalConfigFileFeatureOnlyLoadedOnce(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": `
+include: [b.yaml]
+features: [serial-console]
+`,
+		"b.yaml": `
+features: [serial-console, azure-vm-agent]
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, KernelExtraArguments("console=tty0 console=ttyS0,115200n8"),
+			config.OS.KernelCommandLine.ExtraCommandLine)
+		assert.Equal(t, []string{"openssh-server", "WALinuxAgent"}, config.OS.Packages.Install)
+	}
+}
+
+func TestUnmarshalConfigFileFeatureDirsOverride(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": `
+featureDirs: [features]
+features: [serial-console, local-tools]
+`,
+		"features/serial-console.yaml": `
+os:
+  kernelCommandLine:
+    extraCommandLine: !append console=ttyS1,9600
+`,
+		"features/local-tools.yaml": `
+os:
+  packages:
+    install: [jq]
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, KernelExtraArguments("console=ttyS1,9600"), config.OS.KernelCommandLine.ExtraCommandLine)
+		assert.Equal(t, []string{"jq"}, config.OS.Packages.Install)
+	}
+}
+
+func TestUnmarshalConfigFileFeatureDirsNotTopLevel(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml": "include: [b.yaml]\n",
+		"b.yaml": "featureDirs: [features]\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "line 1: featureDirs can only be specified in the top-level config file")
+}
+
+func TestUnmarshalConfigFileFeatureCycle(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.yaml":          "featureDirs: [features]\nfeatures: [x]\n",
+		"features/x.yaml": "features: [y]\n",
+		"features/y.yaml": "features: [x]\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "a.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "feature module cycle:\nfeatures:x ->\nfeatures:y ->\nfeatures:x")
+}
+
+func TestUnmarshalConfigFileFeatureInvalid(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"include.yaml":      "featureDirs: [features]\nfeatures: [include]\n",
+		"badname.yaml":      "featureDirs: [features]\nfeatures: [bad]\n",
+		"unknown.yaml":      "features: [bogus]\n",
+		"features/bad.yaml": "features: [Bad_Name]\n",
+		"features/include.yaml": `
+include: [../unknown.yaml]
+`,
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "include.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "feature module ("+filepath.Join(dir, "features/include.yaml")+
+		") can't specify 'include'")
+
+	err = UnmarshalConfigFile(filepath.Join(dir, "badname.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "invalid feature module name (Bad_Name)")
+
+	err = UnmarshalConfigFile(filepath.Join(dir, "unknown.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "unknown feature module (bogus) (built-in feature modules: azure-vm-agent,")
+}
+
+func TestBuiltinFeaturesAreValid(t *testing.T) {
+	names, err := BuiltinFeatureNames()
+	require.NoError(t, err)
+	assert.Contains(t, names, "serial-console")
+
+	for _, name := range names {
+		composer := configComposer{}
+		document, err := composer.loadFeature(name)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+
+		clearYamlComposeTags(document, make(map[*yaml.Node]bool))
+
+		var config Config
+		err = decodeYamlDocument(document, &config)
+		if assert.NoError(t, err, name) {
+			assert.NoError(t, config.IsValid(), name)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// The top-level key that declares the config file's matrix of flavors.
+	configMatrixKey = "matrix"
+
+	// The separator between the axis values in a matrix cell's name.
+	matrixCellNameSeparator = "-"
+)
+
+var (
+	matrixAxisNameRegex  = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	matrixValueNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+)
+
+// ConfigMatrix declares a set of image flavors that are built from a single config file. A flavor (i.e. a matrix
+// cell) is built for each combination of the values of the axes.
+type ConfigMatrix struct {
+	Axes []MatrixAxis `yaml:"axes"`
+	// Exclude lists the combinations of axis values that are not built. A cell is excluded if it matches all the
+	// values of an entry.
+	Exclude []map[string]string `yaml:"exclude"`
+	// Overrides are applied to the cells that match all the values of their 'match' field.
+	Overrides []MatrixOverride `yaml:"overrides"`
+}
+
+type MatrixAxis struct {
+	Name   string            `yaml:"name"`
+	Values []MatrixAxisValue `yaml:"values"`
+}
+
+type MatrixAxisValue struct {
+	Name string `yaml:"name"`
+	// ImageFile optionally replaces the base image of the cells that have this value.
+	ImageFile string `yaml:"imageFile"`
+	// Config is layered on top of the config file for the cells that have this value.
+	Config yaml.Node `yaml:"config"`
+}
+
+type MatrixOverride struct {
+	Match map[string]string `yaml:"match"`
+	// ImageFile optionally replaces the base image of the matching cells.
+	ImageFile string `yaml:"imageFile"`
+	// Config is layered on top of the config file for the matching cells.
+	Config yaml.Node `yaml:"config"`
+}
+
+// MatrixCell is a single flavor of a config matrix.
+type MatrixCell struct {
+	// Name is the cell's axis values joined by "-", in axis order (e.g. "full-arm64-on").
+	Name string
+	// Values maps each axis name to the cell's value.
+	Values map[string]string
+	// ImageFile is the base image of the cell, if it isn't the default one. Relative paths are relative to the config
+	// file's directory.
+	ImageFile string
+
+	// The config fragments that are layered on top of the config file, in order.
+	configs []*yaml.Node
+}
+
+func (m *ConfigMatrix) IsValid() error {
+	if len(m.Axes) <= 0 {
+		return fmt.Errorf("must have at least one axis")
+	}
+
+	axisNames := make(map[string]bool)
+	for i, axis := range m.Axes {
+		err := axis.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid axes item at index %d:\n%w", i, err)
+		}
+
+		if axisNames[axis.Name] {
+			return fmt.Errorf("duplicate axis name (%s)", axis.Name)
+		}
+		axisNames[axis.Name] = true
+	}
+
+	for i, exclude := range m.Exclude {
+		err := m.checkCellSelector(exclude)
+		if err != nil {
+			return fmt.Errorf("invalid exclude item at index %d:\n%w", i, err)
+		}
+	}
+
+	for i, override := range m.Overrides {
+		err := m.checkCellSelector(override.Match)
+		if err != nil {
+			return fmt.Errorf("invalid overrides item at index %d:\ninvalid match:\n%w", i, err)
+		}
+	}
+
+	if len(m.Cells()) <= 0 {
+		return fmt.Errorf("all cells are excluded")
+	}
+
+	return nil
+}
+
+func (a *MatrixAxis) IsValid() error {
+	if !matrixAxisNameRegex.MatchString(a.Name) {
+		return fmt.Errorf("invalid name (%s): must match %s", a.Name, matrixAxisNameRegex)
+	}
+
+	if len(a.Values) <= 0 {
+		return fmt.Errorf("axis (%s) must have at least one value", a.Name)
+	}
+
+	valueNames := make(map[string]bool)
+	for _, value := range a.Values {
+		if !matrixValueNameRegex.MatchString(value.Name) {
+			return fmt.Errorf("invalid value name (%s) in axis (%s): must match %s", value.Name, a.Name,
+				matrixValueNameRegex)
+		}
+
+		if valueNames[value.Name] {
+			return fmt.Errorf("duplicate value name (%s) in axis (%s)", value.Name, a.Name)
+		}
+		valueNames[value.Name] = true
+	}
+
+	return nil
+}
+
+// checkCellSelector checks that a set of axis values (e.g. an exclude entry) only references existing axes and values.
+func (m *ConfigMatrix) checkCellSelector(selector map[string]string) error {
+	if len(selector) <= 0 {
+		return fmt.Errorf("must specify at least one axis value")
+	}
+
+	for axisName, valueName := range selector {
+		axisIndex := slices.IndexFunc(m.Axes, func(axis MatrixAxis) bool { return axis.Name == axisName })
+		if axisIndex < 0 {
+			return fmt.Errorf("unknown axis (%s)", axisName)
+		}
+
+		valueIndex := slices.IndexFunc(m.Axes[axisIndex].Values,
+			func(value MatrixAxisValue) bool { return value.Name == valueName })
+		if valueIndex < 0 {
+			return fmt.Errorf("unknown value (%s) of axis (%s)", valueName, axisName)
+		}
+	}
+
+	return nil
+}
+
+// Cells returns the matrix's cells that aren't excluded. The cells are ordered so that the last axis changes the
+// fastest.
+func (m *ConfigMatrix) Cells() []MatrixCell {
+	cells := []MatrixCell(nil)
+
+	valueIndexes := make([]int, len(m.Axes))
+	for {
+		cell := m.createCell(valueIndexes)
+		if !slices.ContainsFunc(m.Exclude, cell.matches) {
+			cells = append(cells, cell)
+		}
+
+		// Move to the next combination.
+		axisIndex := len(m.Axes) - 1
+		for ; axisIndex >= 0; axisIndex-- {
+			valueIndexes[axisIndex]++
+			if valueIndexes[axisIndex] < len(m.Axes[axisIndex].Values) {
+				break
+			}
+			valueIndexes[axisIndex] = 0
+		}
+
+		if axisIndex < 0 {
+			return cells
+		}
+	}
+}
+
+// Cell returns the cell with the provided name.
+func (m *ConfigMatrix) Cell(name string) (MatrixCell, error) {
+	cells := m.Cells()
+
+	cellNames := []string(nil)
+	for _, cell := range cells {
+		if cell.Name == name {
+			return cell, nil
+		}
+		cellNames = append(cellNames, cell.Name)
+	}
+
+	return MatrixCell{}, fmt.Errorf("matrix cell (%s) not found (cells: %s)", name, strings.Join(cellNames, ", "))
+}
+
+func (m *ConfigMatrix) createCell(valueIndexes []int) MatrixCell {
+	cell := MatrixCell{
+		Values: make(map[string]string, len(m.Axes)),
+	}
+
+	names := []string(nil)
+	for axisIndex, axis := range m.Axes {
+		if len(axis.Values) <= 0 {
+			// Invalid matrix.
+			continue
+		}
+
+		value := &axis.Values[valueIndexes[axisIndex]]
+		cell.Values[axis.Name] = value.Name
+		names = append(names, value.Name)
+		cell.addLayer(value.ImageFile, &value.Config)
+	}
+
+	cell.Name = strings.Join(names, matrixCellNameSeparator)
+
+	for i := range m.Overrides {
+		override := &m.Overrides[i]
+		if cell.matches(override.Match) {
+			cell.addLayer(override.ImageFile, &override.Config)
+		}
+	}
+
+	return cell
+}
+
+func (c *MatrixCell) addLayer(imageFile string, config *yaml.Node) {
+	if imageFile != "" {
+		c.ImageFile = imageFile
+	}
+
+	if config.Kind != 0 {
+		c.configs = append(c.configs, config)
+	}
+}
+
+// matches returns true if the cell has all the axis values of the selector.
+func (c *MatrixCell) matches(selector map[string]string) bool {
+	for axisName, valueName := range selector {
+		if c.Values[axisName] != valueName {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkConfigs checks that all the matrix's config fragments are valid config files.
+func (m *ConfigMatrix) checkConfigs() error {
+	configs := []*yaml.Node(nil)
+	for i := range m.Axes {
+		for j := range m.Axes[i].Values {
+			configs = append(configs, &m.Axes[i].Values[j].Config)
+		}
+	}
+	for i := range m.Overrides {
+		configs = append(configs, &m.Overrides[i].Config)
+	}
+
+	for _, config := range configs {
+		if config.Kind == 0 {
+			continue
+		}
+
+		err := checkYamlFields(config, &Config{})
+		if err != nil {
+			return err
+		}
+
+		err = checkYamlSchema(config, &Config{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeConfigMatrix removes the top-level matrix key from the document and returns its value.
+func removeConfigMatrix(document *yaml.Node) *yaml.Node {
+	if len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	root := document.Content[0]
+
+	var matrix *yaml.Node
+	content := []*yaml.Node(nil)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode := root.Content[i]
+		if keyNode.Kind != yaml.ScalarNode || keyNode.Value != configMatrixKey {
+			content = append(content, keyNode, root.Content[i+1])
+			continue
+		}
+
+		matrix = root.Content[i+1]
+	}
+
+	root.Content = content
+	return matrix
+}
+
+// decodeConfigMatrix decodes and validates the value of a config file's matrix key.
+func decodeConfigMatrix(node *yaml.Node) (*ConfigMatrix, error) {
+	err := checkYamlFields(node, &ConfigMatrix{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkYamlSchema(node, &ConfigMatrix{})
+	if err != nil {
+		return nil, err
+	}
+
+	var matrix ConfigMatrix
+	err = node.Decode(&matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	err = matrix.IsValid()
+	if err != nil {
+		return nil, err
+	}
+
+	err = matrix.checkConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &matrix, nil
+}
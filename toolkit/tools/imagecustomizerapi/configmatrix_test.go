@@ -0,0 +1,209 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMatrixConfig = `
+os:
+  hostname: base
+  packages:
+    install: [openssh-server]
+
+matrix:
+  axes:
+  - name: variant
+    values:
+    - name: minimal
+    - name: full
+      config:
+        os:
+          packages:
+            install: [nginx]
+  - name: arch
+    values:
+    - name: x86_64
+      imageFile: base-x86_64.vhdx
+    - name: arm64
+      imageFile: base-arm64.vhdx
+  - name: selinux
+    values:
+    - name: "on"
+      config:
+        os:
+          selinux:
+            mode: enforcing
+    - name: "off"
+  exclude:
+  - variant: minimal
+    selinux: "on"
+  overrides:
+  - match:
+      variant: full
+      arch: arm64
+    imageFile: full-arm64.vhdx
+    config:
+      os:
+        hostname: full-arm64
+`
+
+func TestConfigMatrixCells(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{"config.yaml": testMatrixConfig})
+
+	matrix, err := LoadConfigFileMatrix(filepath.Join(dir, "config.yaml"))
+	if !assert.NoError(t, err) || !assert.NotNil(t, matrix) {
+		return
+	}
+
+	cellNames := []string(nil)
+	for _, cell := range matrix.Cells() {
+		cellNames = append(cellNames, cell.Name)
+	}
+
+	assert.Equal(t, []string{
+		"minimal-x86_64-off",
+		"minimal-arm64-off",
+		"full-x86_64-on",
+		"full-x86_64-off",
+		"full-arm64-on",
+		"full-arm64-off",
+	}, cellNames)
+
+	cell, err := matrix.Cell("full-arm64-on")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"variant": "full", "arch": "arm64", "selinux": "on"}, cell.Values)
+	assert.Equal(t, "full-arm64.vhdx", cell.ImageFile)
+
+	_, err = matrix.Cell("minimal-x86_64-on")
+	assert.ErrorContains(t, err, "matrix cell (minimal-x86_64-on) not found")
+}
+
+func TestUnmarshalConfigFileMatrixCell(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": testMatrixConfig,
+		"fragment.yaml": `
+os:
+  packages:
+    install: [vim]
+`,
+	})
+	configFile := filepath.Join(dir, "config.yaml")
+
+	var config Config
+	cell, err := UnmarshalConfigFileMatrixCell(configFile, "full-arm64-on", []string{filepath.Join(dir, "fragment.yaml")},
+		&config)
+	assert.NoError(t, err)
+	assert.Equal(t, "full-arm64-on", cell.Name)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, "full-arm64", config.OS.Hostname)
+		assert.Equal(t, []string{"openssh-server", "nginx", "vim"}, config.OS.Packages.Install)
+		assert.Equal(t, SELinuxModeEnforcing, config.OS.SELinux.Mode)
+	}
+
+	config = Config{}
+	cell, err = UnmarshalConfigFileMatrixCell(configFile, "minimal-x86_64-off", nil, &config)
+	assert.NoError(t, err)
+	assert.Equal(t, "base-x86_64.vhdx", cell.ImageFile)
+	if assert.NotNil(t, config.OS) {
+		assert.Equal(t, "base", config.OS.Hostname)
+		assert.Equal(t, []string{"openssh-server"}, config.OS.Packages.Install)
+		assert.Equal(t, SELinuxModeDefault, config.OS.SELinux.Mode)
+	}
+
+	config = Config{}
+	err = UnmarshalConfigFile(configFile, nil, &config)
+	assert.ErrorContains(t, err, "has a matrix, so a matrix cell must be selected")
+}
+
+func TestUnmarshalConfigFileMatrixCellNoMatrix(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{"config.yaml": "os:\n  hostname: base\n"})
+
+	var config Config
+	_, err := UnmarshalConfigFileMatrixCell(filepath.Join(dir, "config.yaml"), "a", nil, &config)
+	assert.ErrorContains(t, err, "doesn't have a matrix")
+
+	matrix, err := LoadConfigFileMatrix(filepath.Join(dir, "config.yaml"))
+	assert.NoError(t, err)
+	assert.Nil(t, matrix)
+}
+
+func TestConfigMatrixInIncludedFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": `
+matrix:
+  axes:
+  - name: variant
+    values:
+    - name: a
+`,
+		"config.yaml": "include: [base.yaml]\n",
+	})
+
+	var config Config
+	err := UnmarshalConfigFile(filepath.Join(dir, "config.yaml"), nil, &config)
+	assert.ErrorContains(t, err, "line 3: matrix can only be specified in the top-level config file")
+}
+
+func TestConfigMatrixInvalidConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": `
+matrix:
+  axes:
+  - name: variant
+    values:
+    - name: a
+      config:
+        os:
+          hostnam: a
+`,
+	})
+
+	_, err := LoadConfigFileMatrix(filepath.Join(dir, "config.yaml"))
+	assert.ErrorContains(t, err, "line 9: field hostnam not found in type imagecustomizerapi.OS")
+}
+
+func TestConfigMatrixIsValid(t *testing.T) {
+	validAxes := []MatrixAxis{
+		{Name: "variant", Values: []MatrixAxisValue{{Name: "minimal"}, {Name: "full"}}},
+		{Name: "arch", Values: []MatrixAxisValue{{Name: "x86_64"}}},
+	}
+
+	matrix := ConfigMatrix{Axes: validAxes}
+	assert.NoError(t, matrix.IsValid())
+
+	matrix = ConfigMatrix{}
+	assert.ErrorContains(t, matrix.IsValid(), "must have at least one axis")
+
+	matrix = ConfigMatrix{Axes: []MatrixAxis{{Name: "a-b", Values: []MatrixAxisValue{{Name: "x"}}}}}
+	assert.ErrorContains(t, matrix.IsValid(), "invalid name (a-b)")
+
+	matrix = ConfigMatrix{Axes: []MatrixAxis{{Name: "a"}}}
+	assert.ErrorContains(t, matrix.IsValid(), "axis (a) must have at least one value")
+
+	matrix = ConfigMatrix{Axes: []MatrixAxis{{Name: "a", Values: []MatrixAxisValue{{Name: "x-y"}}}}}
+	assert.ErrorContains(t, matrix.IsValid(), "invalid value name (x-y) in axis (a)")
+
+	matrix = ConfigMatrix{Axes: []MatrixAxis{{Name: "a", Values: []MatrixAxisValue{{Name: "x"}, {Name: "x"}}}}}
+	assert.ErrorContains(t, matrix.IsValid(), "duplicate value name (x) in axis (a)")
+
+	matrix = ConfigMatrix{Axes: append(validAxes, validAxes[1])}
+	assert.ErrorContains(t, matrix.IsValid(), "duplicate axis name (arch)")
+
+	matrix = ConfigMatrix{Axes: validAxes, Exclude: []map[string]string{{"os": "linux"}}}
+	assert.ErrorContains(t, matrix.IsValid(), "invalid exclude item at index 0:\nunknown axis (os)")
+
+	matrix = ConfigMatrix{Axes: validAxes, Overrides: []MatrixOverride{{Match: map[string]string{"arch": "arm64"}}}}
+	assert.ErrorContains(t, matrix.IsValid(), "unknown value (arm64) of axis (arch)")
+
+	matrix = ConfigMatrix{Axes: validAxes, Overrides: []MatrixOverride{{}}}
+	assert.ErrorContains(t, matrix.IsValid(), "must specify at least one axis value")
+
+	matrix = ConfigMatrix{Axes: validAxes, Exclude: []map[string]string{{"arch": "x86_64"}}}
+	assert.ErrorContains(t, matrix.IsValid(), "all cells are excluded")
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ContainerImage specifies the image config of the container image output formats (i.e. 'oci' and 'docker-archive').
+type ContainerImage struct {
+	// Tag is the optional reference name of the image (e.g. "my-image:1.0").
+	Tag string `yaml:"tag"`
+	// Entrypoint is the command that is run when the container starts.
+	Entrypoint []string `yaml:"entrypoint"`
+	// Cmd is the default arguments of the entrypoint (or the default command, if there is no entrypoint).
+	Cmd []string `yaml:"cmd"`
+	// WorkingDir is the working directory of the entrypoint.
+	WorkingDir string `yaml:"workingDir"`
+	// EnvironmentVariables are the environment variables of the container.
+	EnvironmentVariables map[string]string `yaml:"environmentVariables"`
+	// Labels are the image's labels (e.g. "org.opencontainers.image.source").
+	Labels map[string]string `yaml:"labels"`
+	// Squash puts the whole root filesystem into a single layer, instead of a layer for the base image and a layer
+	// for the customizations.
+	Squash bool `yaml:"squash"`
+}
+
+func (c *ContainerImage) IsValid() error {
+	if c.Tag != "" && (strings.ContainsAny(c.Tag, " \t\n") || strings.HasPrefix(c.Tag, "-")) {
+		return fmt.Errorf("invalid tag (%s)", c.Tag)
+	}
+
+	if c.WorkingDir != "" && !path.IsAbs(c.WorkingDir) {
+		return fmt.Errorf("invalid workingDir (%s): must be an absolute path", c.WorkingDir)
+	}
+
+	for name := range c.EnvironmentVariables {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return fmt.Errorf("invalid environmentVariables name (%s)", name)
+		}
+	}
+
+	for name := range c.Labels {
+		if name == "" {
+			return fmt.Errorf("invalid labels name: must not be empty")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerImageIsValid(t *testing.T) {
+	containerImage := ContainerImage{
+		Tag:        "nginx:1.25",
+		Entrypoint: []string{"/usr/sbin/nginx", "-g", "daemon off;"},
+		WorkingDir: "/var/www",
+		EnvironmentVariables: map[string]string{
+			"LANG": "C.UTF-8",
+		},
+		Labels: map[string]string{
+			"org.opencontainers.image.source": "https://github.com/microsoft/azurelinux",
+		},
+		Squash: true,
+	}
+	err := containerImage.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestContainerImageIsValidBadTag(t *testing.T) {
+	containerImage := ContainerImage{
+		Tag: "my image",
+	}
+	err := containerImage.IsValid()
+	assert.ErrorContains(t, err, "invalid tag (my image)")
+}
+
+func TestContainerImageIsValidRelativeWorkingDir(t *testing.T) {
+	containerImage := ContainerImage{
+		WorkingDir: "app",
+	}
+	err := containerImage.IsValid()
+	assert.ErrorContains(t, err, "invalid workingDir (app): must be an absolute path")
+}
+
+func TestContainerImageIsValidBadEnvironmentVariableName(t *testing.T) {
+	containerImage := ContainerImage{
+		EnvironmentVariables: map[string]string{
+			"A=B": "c",
+		},
+	}
+	err := containerImage.IsValid()
+	assert.ErrorContains(t, err, "invalid environmentVariables name (A=B)")
+}
+
+func TestContainerImageIsValidEmptyLabelName(t *testing.T) {
+	containerImage := ContainerImage{
+		Labels: map[string]string{
+			"": "a",
+		},
+	}
+	err := containerImage.IsValid()
+	assert.ErrorContains(t, err, "invalid labels name")
+}
@@ -29,6 +29,15 @@ type DirConfig struct {
 	// The permissions to set on the children file of the directory.
 	// Note: If this value is not specified in the config, the permissions for these directories will be set to 0755.
 	ChildFilePermissions *FilePermissions `yaml:"childFilePermissions"`
+
+	// The user (name or ID) to set as the owner of the copied files and directories.
+	Owner string `yaml:"owner"`
+
+	// The group (name or ID) to set as the group of the copied files and directories.
+	Group string `yaml:"group"`
+
+	// The SELinux label to set on the copied files and directories, instead of the labels from the SELinux policy.
+	SELinuxLabel string `yaml:"selinuxLabel"`
 }
 
 func (l *DirConfigList) IsValid() (err error) {
@@ -71,5 +80,10 @@ func (d *DirConfig) IsValid() (err error) {
 		}
 	}
 
+	err = validateFileOwnership(d.Owner, d.Group, d.SELinuxLabel)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
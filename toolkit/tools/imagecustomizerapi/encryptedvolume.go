@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// The highest PCR index of a TPM2 device.
+	maxTpm2Pcr = 23
+)
+
+var (
+	// The name is used in systemd unit names. So, avoid characters that systemd would need to escape.
+	encryptedVolumeNameRegex = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+	// The PCRs that the volume key is sealed against, if none are specified.
+	// PCR 7 holds the secure boot state.
+	DefaultTpm2Pcrs = []int{7}
+)
+
+type EncryptedVolume struct {
+	// ID is used to correlate `EncryptedVolume` objects with `FileSystem` objects.
+	Id string `yaml:"id"`
+	// The name of the mapper block device.
+	Name string `yaml:"name"`
+	// The ID of the 'Partition' to encrypt.
+	DeviceId string `yaml:"deviceId"`
+	// The TPM2 PCRs that the volume key is sealed against.
+	Tpm2Pcrs []int `yaml:"tpm2Pcrs"`
+
+	// The filesystem config that points to this encrypted volume.
+	// Value is filled in by Storage.IsValid().
+	FileSystem *FileSystem
+}
+
+func (v *EncryptedVolume) IsValid() error {
+	if v.Id == "" {
+		return fmt.Errorf("'id' may not be empty")
+	}
+
+	if !encryptedVolumeNameRegex.MatchString(v.Name) {
+		return fmt.Errorf("invalid 'name' value (%s)", v.Name)
+	}
+
+	if v.DeviceId == "" {
+		return fmt.Errorf("'deviceId' may not be empty")
+	}
+
+	pcrs := make(map[int]bool)
+	for _, pcr := range v.Tpm2Pcrs {
+		if pcr < 0 || pcr > maxTpm2Pcr {
+			return fmt.Errorf("invalid 'tpm2Pcrs' value (%d): must be between 0 and %d", pcr, maxTpm2Pcr)
+		}
+
+		if pcrs[pcr] {
+			return fmt.Errorf("invalid 'tpm2Pcrs' value: duplicate PCR (%d)", pcr)
+		}
+		pcrs[pcr] = true
+	}
+
+	return nil
+}
+
+// GetTpm2Pcrs returns the PCRs that the volume key is sealed against.
+func (v *EncryptedVolume) GetTpm2Pcrs() []int {
+	if len(v.Tpm2Pcrs) <= 0 {
+		return DefaultTpm2Pcrs
+	}
+
+	return v.Tpm2Pcrs
+}
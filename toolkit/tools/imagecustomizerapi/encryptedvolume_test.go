@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedVolumeIsValid(t *testing.T) {
+	validVolume := EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+		Tpm2Pcrs: []int{0, 7},
+	}
+
+	err := validVolume.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 7}, validVolume.GetTpm2Pcrs())
+}
+
+func TestEncryptedVolumeIsValidDefaultPcrs(t *testing.T) {
+	validVolume := EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+	}
+
+	err := validVolume.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{7}, validVolume.GetTpm2Pcrs())
+}
+
+func TestEncryptedVolumeIsValidMissingId(t *testing.T) {
+	invalidVolume := EncryptedVolume{
+		Name:     "var",
+		DeviceId: "var",
+	}
+
+	err := invalidVolume.IsValid()
+	assert.ErrorContains(t, err, "'id' may not be empty")
+}
+
+func TestEncryptedVolumeIsValidInvalidName(t *testing.T) {
+	invalidVolume := EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var-data",
+		DeviceId: "var",
+	}
+
+	err := invalidVolume.IsValid()
+	assert.ErrorContains(t, err, "invalid 'name' value (var-data)")
+}
+
+func TestEncryptedVolumeIsValidMissingDeviceId(t *testing.T) {
+	invalidVolume := EncryptedVolume{
+		Id:   "varcrypt",
+		Name: "var",
+	}
+
+	err := invalidVolume.IsValid()
+	assert.ErrorContains(t, err, "'deviceId' may not be empty")
+}
+
+func TestEncryptedVolumeIsValidPcrOutOfRange(t *testing.T) {
+	invalidVolume := EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+		Tpm2Pcrs: []int{24},
+	}
+
+	err := invalidVolume.IsValid()
+	assert.ErrorContains(t, err, "invalid 'tpm2Pcrs' value (24): must be between 0 and 23")
+}
+
+func TestEncryptedVolumeIsValidDuplicatePcr(t *testing.T) {
+	invalidVolume := EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+		Tpm2Pcrs: []int{7, 7},
+	}
+
+	err := invalidVolume.IsValid()
+	assert.ErrorContains(t, err, "duplicate PCR (7)")
+}
@@ -0,0 +1,34 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// A user or group name (e.g. "nginx") or a numeric ID (e.g. "1000").
+	fileOwnerRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+	// An SELinux security context (e.g. "system_u:object_r:etc_t:s0").
+	selinuxLabelRegex = regexp.MustCompile(`^[A-Za-z0-9_]+:[A-Za-z0-9_]+:[A-Za-z0-9_]+(:[A-Za-z0-9_.,:-]+)?$`)
+	// The name of a template variable (e.g. "region").
+	templateVariableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+func validateFileOwnership(owner string, group string, selinuxLabel string) error {
+	if owner != "" && !fileOwnerRegex.MatchString(owner) {
+		return fmt.Errorf("invalid owner value (%s)", owner)
+	}
+
+	if group != "" && !fileOwnerRegex.MatchString(group) {
+		return fmt.Errorf("invalid group value (%s)", group)
+	}
+
+	if selinuxLabel != "" && !selinuxLabelRegex.MatchString(selinuxLabel) {
+		return fmt.Errorf("invalid selinuxLabel value (%s)", selinuxLabel)
+	}
+
+	return nil
+}
@@ -17,6 +17,7 @@ type FileSystem struct {
 	MountPoint *MountPoint `yaml:"mountPoint"`
 
 	// If 'DeviceId' points at a verity device, this value is the 'Id' of the data partition.
+	// If 'DeviceId' points at an encrypted volume, this value is the 'Id' of the encrypted partition.
 	// Otherwise, it is the same as 'DeviceId'.
 	// Value is filled in by Storage.IsValid().
 	PartitionId string
@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// Finalize specifies the steps that reduce the size of the output image, which are run after the OS has been
+// customized.
+type Finalize struct {
+	// TrimFreeSpace discards the free space of the filesystems, so that it reads back as zeros.
+	TrimFreeSpace bool `yaml:"trimFreeSpace"`
+	// ShrinkFilesystems shrinks the filesystems, and their partitions, to their minimum size.
+	ShrinkFilesystems *FinalizeShrinkFilesystems `yaml:"shrinkFilesystems"`
+	// Sparse punches holes in the output image file wherever it contains zeros.
+	Sparse bool `yaml:"sparse"`
+}
+
+type FinalizeShrinkFilesystems struct {
+	// Headroom is the amount of free space to leave in each shrunk filesystem.
+	Headroom DiskSize `yaml:"headroom"`
+}
+
+func (f *Finalize) IsValid() error {
+	if f.ShrinkFilesystems != nil {
+		err := f.ShrinkFilesystems.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid shrinkFilesystems:\n%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *FinalizeShrinkFilesystems) IsValid() error {
+	err := s.Headroom.IsValid()
+	if err != nil {
+		return fmt.Errorf("invalid headroom:\n%w", err)
+	}
+
+	return nil
+}
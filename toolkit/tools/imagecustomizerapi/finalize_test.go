@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinalizeUnmarshal(t *testing.T) {
+	var config Config
+	err := UnmarshalYaml([]byte(`
+finalize:
+  trimFreeSpace: true
+  shrinkFilesystems:
+    headroom: 64M
+  sparse: true
+`), &config)
+	assert.NoError(t, err)
+
+	err = config.IsValid()
+	assert.NoError(t, err)
+
+	if assert.NotNil(t, config.Finalize) && assert.NotNil(t, config.Finalize.ShrinkFilesystems) {
+		assert.True(t, config.Finalize.TrimFreeSpace)
+		assert.True(t, config.Finalize.Sparse)
+		assert.Equal(t, DiskSize(64*1024*1024), config.Finalize.ShrinkFilesystems.Headroom)
+	}
+}
+
+func TestFinalizeUnmarshalInvalidHeadroom(t *testing.T) {
+	var config Config
+	err := UnmarshalYaml([]byte(`
+finalize:
+  shrinkFilesystems:
+    headroom: 64X
+`), &config)
+	assert.ErrorContains(t, err, "(64X) has incorrect format")
+}
+
+func TestFinalizeIsValidEmpty(t *testing.T) {
+	finalize := Finalize{}
+
+	err := finalize.IsValid()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+type GpuVendor string
+
+const (
+	GpuVendorNvidia GpuVendor = "nvidia"
+	GpuVendorAmd    GpuVendor = "amd"
+)
+
+func (v GpuVendor) IsValid() error {
+	switch v {
+	case GpuVendorNvidia, GpuVendorAmd:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("invalid vendor value (%s):\nvalid values: 'nvidia', 'amd'", v)
+	}
+}
+
+// Gpu installs the kernel driver of a GPU (or another accelerator), matched to the image's kernel, along with the
+// container toolkit of the GPU.
+type Gpu struct {
+	Vendor GpuVendor `yaml:"vendor"`
+	// DriverPackage is the name of the driver's package. Required for NVIDIA. For AMD, defaults to the in-tree amdgpu
+	// driver package of the image's kernel.
+	DriverPackage string `yaml:"driverPackage"`
+	// DriverVersion is the version of the driver's package (e.g. "550.54.15"). If not specified, then the latest
+	// version is installed.
+	DriverVersion string `yaml:"driverVersion"`
+	// ContainerToolkit installs the container toolkit of the GPU and configures the container runtimes to use it.
+	ContainerToolkit bool `yaml:"containerToolkit"`
+	// SecureBoot signs the driver's out-of-tree kernel modules, so that they can be loaded when secure boot is
+	// enabled. If 'moduleSigning' isn't specified, then an ephemeral key is used, with automatic MOK enrollment.
+	SecureBoot bool `yaml:"secureBoot"`
+}
+
+func (g *Gpu) IsValid() error {
+	err := g.Vendor.IsValid()
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(g.DriverPackage, " \t\n\r") {
+		return fmt.Errorf("invalid driverPackage (%s): must not contain whitespace characters", g.DriverPackage)
+	}
+
+	if strings.ContainsAny(g.DriverVersion, " \t\n\r") {
+		return fmt.Errorf("invalid driverVersion (%s): must not contain whitespace characters", g.DriverVersion)
+	}
+
+	switch g.Vendor {
+	case GpuVendorNvidia:
+		if g.DriverPackage == "" {
+			return fmt.Errorf("'driverPackage' must be specified for vendor (%s)", g.Vendor)
+		}
+
+	case GpuVendorAmd:
+		if g.DriverPackage == "" && g.DriverVersion != "" {
+			return fmt.Errorf("'driverVersion' can't be specified for the in-tree amdgpu driver, since its version " +
+				"is the kernel's version")
+		}
+
+		if g.ContainerToolkit {
+			return fmt.Errorf("'containerToolkit' is not supported for vendor (%s)", g.Vendor)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGpuIsValidNvidia(t *testing.T) {
+	gpu := Gpu{
+		Vendor:           GpuVendorNvidia,
+		DriverPackage:    "cuda-open",
+		DriverVersion:    "550.54.15",
+		ContainerToolkit: true,
+		SecureBoot:       true,
+	}
+
+	err := gpu.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestGpuIsValidAmd(t *testing.T) {
+	gpu := Gpu{
+		Vendor: GpuVendorAmd,
+	}
+
+	err := gpu.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestGpuIsValidBadVendor(t *testing.T) {
+	gpu := Gpu{
+		Vendor: "intel",
+	}
+
+	err := gpu.IsValid()
+	assert.ErrorContains(t, err, "invalid vendor value (intel)")
+}
+
+func TestGpuIsValidNvidiaMissingDriverPackage(t *testing.T) {
+	gpu := Gpu{
+		Vendor: GpuVendorNvidia,
+	}
+
+	err := gpu.IsValid()
+	assert.ErrorContains(t, err, "'driverPackage' must be specified for vendor (nvidia)")
+}
+
+func TestGpuIsValidAmdDriverVersion(t *testing.T) {
+	gpu := Gpu{
+		Vendor:        GpuVendorAmd,
+		DriverVersion: "6.6.57.1",
+	}
+
+	err := gpu.IsValid()
+	assert.ErrorContains(t, err, "'driverVersion' can't be specified for the in-tree amdgpu driver")
+}
+
+func TestGpuIsValidAmdContainerToolkit(t *testing.T) {
+	gpu := Gpu{
+		Vendor:           GpuVendorAmd,
+		ContainerToolkit: true,
+	}
+
+	err := gpu.IsValid()
+	assert.ErrorContains(t, err, "'containerToolkit' is not supported for vendor (amd)")
+}
+
+func TestGpuIsValidBadDriverPackage(t *testing.T) {
+	gpu := Gpu{
+		Vendor:        GpuVendorNvidia,
+		DriverPackage: "cuda open",
+	}
+
+	err := gpu.IsValid()
+	assert.ErrorContains(t, err, "invalid driverPackage (cuda open)")
+}
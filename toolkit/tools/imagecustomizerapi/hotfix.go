@@ -0,0 +1,29 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// Hotfix configures a hotfix respin, which applies a set of updated RPMs on top of a released image.
+// Only the boot artifacts affected by the updated RPMs are regenerated.
+type Hotfix struct {
+	// Rpms are the paths of RPM files, or directories of RPM files, to apply.
+	Rpms []string `yaml:"rpms"`
+}
+
+func (h *Hotfix) IsValid() error {
+	if len(h.Rpms) <= 0 {
+		return fmt.Errorf("rpms must not be empty")
+	}
+
+	for i, rpmPath := range h.Rpms {
+		if rpmPath == "" {
+			return fmt.Errorf("invalid rpms item at index %d: path must not be empty", i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotfixIsValid(t *testing.T) {
+	hotfix := Hotfix{
+		Rpms: []string{"rpms/kernel-6.6.51.1-1.azl3.x86_64.rpm", "rpms/openssl"},
+	}
+
+	err := hotfix.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestHotfixIsValidNoRpms(t *testing.T) {
+	hotfix := Hotfix{}
+
+	err := hotfix.IsValid()
+	assert.ErrorContains(t, err, "rpms must not be empty")
+}
+
+func TestHotfixIsValidEmptyPath(t *testing.T) {
+	hotfix := Hotfix{
+		Rpms: []string{"rpms", ""},
+	}
+
+	err := hotfix.IsValid()
+	assert.ErrorContains(t, err, "invalid rpms item at index 1: path must not be empty")
+}
+
+func TestConfigIsValidHotfix(t *testing.T) {
+	config := &Config{
+		Hotfix: &Hotfix{
+			Rpms: []string{"rpms"},
+		},
+		ChangeManifest: &ChangeManifest{},
+	}
+
+	err := config.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestConfigIsValidHotfixWithOS(t *testing.T) {
+	config := &Config{
+		Hotfix: &Hotfix{
+			Rpms: []string{"rpms"},
+		},
+		OS: &OS{
+			Hostname: "test",
+		},
+	}
+
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "'hotfix' cannot be combined with 'storage', 'os', 'scripts', 'iso', or 'pxe'")
+}
+
+func TestConfigIsValidHotfixInvalid(t *testing.T) {
+	config := &Config{
+		Hotfix: &Hotfix{},
+	}
+
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "invalid 'hotfix' field:\nrpms must not be empty")
+}
@@ -0,0 +1,25 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// IdLedger configures the UID/GID allocation ledger, which keeps the IDs of system users and groups stable across
+// image versions.
+type IdLedger struct {
+	// Path is the path of the ledger file. It is created if it doesn't exist.
+	Path string `yaml:"path"`
+	// ReadOnly fails the build instead of adding new users or groups to the ledger.
+	ReadOnly bool `yaml:"readOnly"`
+}
+
+func (l *IdLedger) IsValid() error {
+	if l.Path == "" {
+		return fmt.Errorf("'path' must not be empty")
+	}
+
+	return nil
+}
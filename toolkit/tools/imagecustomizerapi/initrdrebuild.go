@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// InitrdRebuild configures an initrd-only rebuild of an image that was already customized. Only the initramfs files
+// and the bootloader config are regenerated, so that kernel command-line and dracut changes can be tried quickly.
+type InitrdRebuild struct {
+	// KernelCommandLine are the changes to the kernel command-line args.
+	KernelCommandLine InitrdRebuildKernelCommandLine `yaml:"kernelCommandLine"`
+	// DracutConfigFiles are the paths of dracut config files to add to the image's /etc/dracut.conf.d directory.
+	DracutConfigFiles []string `yaml:"dracutConfigFiles"`
+}
+
+// InitrdRebuildKernelCommandLine lists the kernel command-line args to set and remove. Unlike the extraCommandLine
+// of the OS config, the changes are idempotent, so that an image can be rebuilt any number of times.
+type InitrdRebuildKernelCommandLine struct {
+	// SetArgs are the args to set. Each arg replaces all the existing args with the same name.
+	SetArgs []string `yaml:"setArgs"`
+	// RemoveArgs are the names of the args to remove.
+	RemoveArgs []string `yaml:"removeArgs"`
+}
+
+func (r *InitrdRebuild) IsValid() error {
+	err := r.KernelCommandLine.IsValid()
+	if err != nil {
+		return fmt.Errorf("invalid kernelCommandLine:\n%w", err)
+	}
+
+	fileNames := make(map[string]bool)
+	for i, configFile := range r.DracutConfigFiles {
+		if configFile == "" {
+			return fmt.Errorf("invalid dracutConfigFiles item at index %d: path must not be empty", i)
+		}
+
+		// dracut ignores the files in the config directory that don't end with ".conf".
+		fileName := filepath.Base(configFile)
+		if filepath.Ext(fileName) != ".conf" {
+			return fmt.Errorf("invalid dracutConfigFiles item at index %d: file name (%s) must end with '.conf'", i,
+				fileName)
+		}
+
+		if fileNames[fileName] {
+			return fmt.Errorf("invalid dracutConfigFiles item at index %d: duplicate file name (%s)", i, fileName)
+		}
+		fileNames[fileName] = true
+	}
+
+	return nil
+}
+
+func (c *InitrdRebuildKernelCommandLine) IsValid() error {
+	setNames := make(map[string]bool)
+	for i, arg := range c.SetArgs {
+		name := KernelArgName(arg)
+		if name == "" || strings.ContainsAny(name, " \t\n") {
+			return fmt.Errorf("invalid setArgs item at index %d: invalid arg (%s)", i, arg)
+		}
+
+		err := validateKernelArgumentsFormat(arg)
+		if err != nil {
+			return fmt.Errorf("invalid setArgs item at index %d:\n%w", i, err)
+		}
+
+		setNames[name] = true
+	}
+
+	for i, name := range c.RemoveArgs {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return fmt.Errorf("invalid removeArgs item at index %d: invalid arg name (%s)", i, name)
+		}
+
+		if setNames[name] {
+			return fmt.Errorf("invalid removeArgs item at index %d: arg (%s) is also in setArgs", i, name)
+		}
+	}
+
+	return nil
+}
+
+// KernelArgName returns the name of a kernel command-line arg (e.g. "console" for "console=ttyS0").
+func KernelArgName(arg string) string {
+	name, _, _ := strings.Cut(arg, "=")
+	return name
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitrdRebuildIsValid(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		KernelCommandLine: InitrdRebuildKernelCommandLine{
+			SetArgs:    []string{"console=ttyS0,115200", "rd.debug", "rd.break=pre-mount"},
+			RemoveArgs: []string{"quiet", "rhgb"},
+		},
+		DracutConfigFiles: []string{"files/debug.conf", "files/network.conf"},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestInitrdRebuildIsValidEmpty(t *testing.T) {
+	initrdRebuild := InitrdRebuild{}
+
+	err := initrdRebuild.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestInitrdRebuildIsValidInvalidSetArg(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		KernelCommandLine: InitrdRebuildKernelCommandLine{
+			SetArgs: []string{"rd.debug", "=ttyS0"},
+		},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.ErrorContains(t, err, "invalid kernelCommandLine:\ninvalid setArgs item at index 1: invalid arg (=ttyS0)")
+}
+
+func TestInitrdRebuildIsValidSetArgInvalidCharacters(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		KernelCommandLine: InitrdRebuildKernelCommandLine{
+			SetArgs: []string{"init=$init"},
+		},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.ErrorContains(t, err, "invalid setArgs item at index 0:\nthe extraCommandLine value contains invalid characters")
+}
+
+func TestInitrdRebuildIsValidInvalidRemoveArg(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		KernelCommandLine: InitrdRebuildKernelCommandLine{
+			RemoveArgs: []string{"console=ttyS0"},
+		},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.ErrorContains(t, err, "invalid removeArgs item at index 0: invalid arg name (console=ttyS0)")
+}
+
+func TestInitrdRebuildIsValidSetAndRemoveArg(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		KernelCommandLine: InitrdRebuildKernelCommandLine{
+			SetArgs:    []string{"console=ttyS0"},
+			RemoveArgs: []string{"console"},
+		},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.ErrorContains(t, err, "invalid removeArgs item at index 0: arg (console) is also in setArgs")
+}
+
+func TestInitrdRebuildIsValidDracutConfigFileExtension(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		DracutConfigFiles: []string{"files/debug.txt"},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.ErrorContains(t, err, "invalid dracutConfigFiles item at index 0: file name (debug.txt) must end with '.conf'")
+}
+
+func TestInitrdRebuildIsValidDracutConfigFileDuplicate(t *testing.T) {
+	initrdRebuild := InitrdRebuild{
+		DracutConfigFiles: []string{"a/debug.conf", "b/debug.conf"},
+	}
+
+	err := initrdRebuild.IsValid()
+	assert.ErrorContains(t, err, "invalid dracutConfigFiles item at index 1: duplicate file name (debug.conf)")
+}
+
+func TestKernelArgName(t *testing.T) {
+	assert.Equal(t, "console", KernelArgName("console=ttyS0,115200"))
+	assert.Equal(t, "rd.debug", KernelArgName("rd.debug"))
+	assert.Equal(t, "root", KernelArgName("root=PARTUUID=1234"))
+}
+
+func TestConfigIsValidInitrdRebuildWithOS(t *testing.T) {
+	config := &Config{
+		InitrdRebuild: &InitrdRebuild{},
+		OS: &OS{
+			Hostname: "test",
+		},
+	}
+
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "'initrdRebuild' cannot be combined with 'storage', 'os', 'scripts', 'iso', or 'pxe'")
+}
+
+func TestConfigIsValidInitrdRebuildWithHotfix(t *testing.T) {
+	config := &Config{
+		InitrdRebuild: &InitrdRebuild{},
+		Hotfix: &Hotfix{
+			Rpms: []string{"rpms"},
+		},
+	}
+
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "'initrdRebuild' cannot be combined with 'hotfix'")
+}
+
+func TestConfigIsValidInitrdRebuild(t *testing.T) {
+	config := &Config{
+		InitrdRebuild: &InitrdRebuild{
+			KernelCommandLine: InitrdRebuildKernelCommandLine{
+				SetArgs: []string{"rd.debug"},
+			},
+		},
+		Signing: &Signing{
+			Command: &Script{Path: "sign.sh"},
+		},
+	}
+
+	err := config.IsValid()
+	assert.NoError(t, err)
+}
@@ -9,8 +9,10 @@ import (
 
 // Iso defines how the generated iso media should be configured.
 type Iso struct {
-	KernelCommandLine KernelCommandLine  `yaml:"kernelCommandLine"`
-	AdditionalFiles   AdditionalFileList `yaml:"additionalFiles"`
+	KernelCommandLine KernelCommandLine     `yaml:"kernelCommandLine"`
+	AdditionalFiles   AdditionalFileList    `yaml:"additionalFiles"`
+	PersistentOverlay *IsoPersistentOverlay `yaml:"persistentOverlay"`
+	AnswerFile        *IsoAnswerFile        `yaml:"answerFile"`
 }
 
 func (i *Iso) IsValid() error {
@@ -24,5 +26,26 @@ func (i *Iso) IsValid() error {
 		return fmt.Errorf("invalid additionalFiles:\n%w", err)
 	}
 
+	for j, additionalFile := range i.AdditionalFiles {
+		if additionalFile.hasOSOnlyFields() {
+			return fmt.Errorf("invalid additionalFiles:\ninvalid value at index %d:\n"+
+				"'owner', 'group', 'selinuxLabel', and 'template' are not supported for iso files", j)
+		}
+	}
+
+	if i.PersistentOverlay != nil {
+		err = i.PersistentOverlay.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid persistentOverlay:\n%w", err)
+		}
+	}
+
+	if i.AnswerFile != nil {
+		err = i.AnswerFile.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid answerFile:\n%w", err)
+		}
+	}
+
 	return nil
 }
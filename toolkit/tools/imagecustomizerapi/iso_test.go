@@ -19,3 +19,97 @@ func TestIsoIsValid(t *testing.T) {
 	err := iso.IsValid()
 	assert.ErrorContains(t, err, "invalid kernelCommandLine")
 }
+
+func TestIsoIsValidPersistentOverlay(t *testing.T) {
+	iso := Iso{
+		PersistentOverlay: &IsoPersistentOverlay{
+			Device: "LABEL=azl-persist",
+			Path:   "/overlay",
+		},
+	}
+
+	err := iso.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestIsoIsValidPersistentOverlayMissingDevice(t *testing.T) {
+	iso := Iso{
+		PersistentOverlay: &IsoPersistentOverlay{},
+	}
+
+	err := iso.IsValid()
+	assert.ErrorContains(t, err, "invalid persistentOverlay")
+	assert.ErrorContains(t, err, "device must be specified")
+}
+
+func TestIsoIsValidPersistentOverlayBadDevice(t *testing.T) {
+	iso := Iso{
+		PersistentOverlay: &IsoPersistentOverlay{
+			Device: "azl-persist",
+		},
+	}
+
+	err := iso.IsValid()
+	assert.ErrorContains(t, err, "invalid device (azl-persist)")
+}
+
+func TestIsoIsValidPersistentOverlayRelativePath(t *testing.T) {
+	iso := Iso{
+		PersistentOverlay: &IsoPersistentOverlay{
+			Device: "UUID=1234",
+			Path:   "overlay",
+		},
+	}
+
+	err := iso.IsValid()
+	assert.ErrorContains(t, err, "must be an absolute path")
+}
+
+func TestIsoIsValidAnswerFile(t *testing.T) {
+	iso := Iso{
+		AnswerFile: &IsoAnswerFile{
+			Source:         "files/ks.cfg",
+			KernelArgument: "inst.ks",
+		},
+	}
+
+	err := iso.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestIsoIsValidAnswerFileMissingSource(t *testing.T) {
+	iso := Iso{
+		AnswerFile: &IsoAnswerFile{},
+	}
+
+	err := iso.IsValid()
+	assert.ErrorContains(t, err, "invalid answerFile")
+	assert.ErrorContains(t, err, "source must be specified")
+}
+
+func TestIsoIsValidAnswerFileBadKernelArgument(t *testing.T) {
+	iso := Iso{
+		AnswerFile: &IsoAnswerFile{
+			Source:         "files/ks.cfg",
+			KernelArgument: "inst.ks=",
+		},
+	}
+
+	err := iso.IsValid()
+	assert.ErrorContains(t, err, "invalid kernelArgument (inst.ks=)")
+}
+
+func TestIsoIsValidAdditionalFileOwner(t *testing.T) {
+	iso := Iso{
+		AdditionalFiles: AdditionalFileList{
+			{
+				Destination: "/a.txt",
+				Source:      "a.txt",
+				Owner:       "root",
+			},
+		},
+	}
+
+	err := iso.IsValid()
+	assert.ErrorContains(t, err, "'owner', 'group', 'selinuxLabel', and 'template' are not supported for iso files")
+}
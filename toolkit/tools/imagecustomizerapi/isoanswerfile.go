@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var kernelArgumentNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// IsoAnswerFile is an unattended install answer file (e.g. a kickstart file) to embed in the ISO.
+type IsoAnswerFile struct {
+	// Source is the path of the answer file on the build machine.
+	Source string `yaml:"source"`
+	// KernelArgument is the optional name of the kernel argument used to pass the answer file's location to the OS
+	// (e.g. "inst.ks").
+	KernelArgument string `yaml:"kernelArgument"`
+}
+
+func (a *IsoAnswerFile) IsValid() error {
+	if a.Source == "" {
+		return fmt.Errorf("source must be specified")
+	}
+
+	if a.KernelArgument != "" && !kernelArgumentNameRegex.MatchString(a.KernelArgument) {
+		return fmt.Errorf("invalid kernelArgument (%s)", a.KernelArgument)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+var isoPersistentOverlayDevicePrefixes = []string{"LABEL=", "UUID=", "PARTLABEL=", "PARTUUID=", "/dev/"}
+
+// IsoPersistentOverlay configures a writable device that the LiveOS keeps its changes on across reboots.
+type IsoPersistentOverlay struct {
+	// Device is the dracut device spec of the persistent storage (e.g. "LABEL=azl-persist").
+	Device string `yaml:"device"`
+	// Path is an optional directory on the device to hold the overlay.
+	Path string `yaml:"path"`
+}
+
+func (o *IsoPersistentOverlay) IsValid() error {
+	if o.Device == "" {
+		return fmt.Errorf("device must be specified")
+	}
+
+	prefixFound := false
+	for _, prefix := range isoPersistentOverlayDevicePrefixes {
+		if strings.HasPrefix(o.Device, prefix) && len(o.Device) > len(prefix) {
+			prefixFound = true
+			break
+		}
+	}
+	if !prefixFound {
+		return fmt.Errorf("invalid device (%s): must start with one of (%v)", o.Device, isoPersistentOverlayDevicePrefixes)
+	}
+
+	if strings.ContainsAny(o.Device, " \t\n\"'") {
+		return fmt.Errorf("invalid device (%s): must not contain whitespace or quotes", o.Device)
+	}
+
+	if o.Path != "" {
+		if !path.IsAbs(o.Path) {
+			return fmt.Errorf("invalid path (%s): must be an absolute path", o.Path)
+		}
+
+		if strings.ContainsAny(o.Path, " \t\n\"':") {
+			return fmt.Errorf("invalid path (%s): must not contain whitespace, quotes or ':'", o.Path)
+		}
+	}
+
+	return nil
+}
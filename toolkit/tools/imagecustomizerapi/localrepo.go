@@ -0,0 +1,37 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// LocalRepo is a directory of RPM files on the build host that packages can be installed from during customization.
+type LocalRepo struct {
+	// Path is the directory containing the RPM files. Relative paths are relative to the config file's directory.
+	Path string `yaml:"path"`
+	// GpgCheck enables checking the signatures of the packages installed from this repo.
+	GpgCheck bool `yaml:"gpgCheck"`
+	// GpgKeys are the paths of the public key files used to check the packages' signatures.
+	// Relative paths are relative to the config file's directory.
+	GpgKeys []string `yaml:"gpgKeys"`
+}
+
+func (r *LocalRepo) IsValid() error {
+	if r.Path == "" {
+		return fmt.Errorf("path must be specified")
+	}
+
+	for i, gpgKey := range r.GpgKeys {
+		if gpgKey == "" {
+			return fmt.Errorf("invalid gpgKeys item at index %d: must not be empty", i)
+		}
+	}
+
+	if len(r.GpgKeys) > 0 && !r.GpgCheck {
+		return fmt.Errorf("gpgKeys may only be specified when gpgCheck is enabled")
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalRepoIsValid(t *testing.T) {
+	localRepo := LocalRepo{
+		Path:     "rpms",
+		GpgCheck: true,
+		GpgKeys:  []string{"keys/contoso.asc"},
+	}
+
+	err := localRepo.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestLocalRepoIsValidMissingPath(t *testing.T) {
+	localRepo := LocalRepo{}
+
+	err := localRepo.IsValid()
+	assert.ErrorContains(t, err, "path must be specified")
+}
+
+func TestLocalRepoIsValidKeysWithoutGpgCheck(t *testing.T) {
+	localRepo := LocalRepo{
+		Path:    "rpms",
+		GpgKeys: []string{"keys/contoso.asc"},
+	}
+
+	err := localRepo.IsValid()
+	assert.ErrorContains(t, err, "gpgKeys may only be specified when gpgCheck is enabled")
+}
+
+func TestPackagesIsValidBadLocalRepo(t *testing.T) {
+	packages := Packages{
+		LocalRepos: []LocalRepo{
+			{},
+		},
+	}
+
+	err := packages.IsValid()
+	assert.ErrorContains(t, err, "invalid localRepos item at index 0")
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ModuleSigning configures the signing of the out-of-tree kernel modules that are added to the image during
+// customization (e.g. by packages or scripts).
+//
+// The signing certificate is placed in the ESP, so that it can be enrolled as a Machine Owner Key (MOK).
+type ModuleSigning struct {
+	// Key is the key to sign the modules with.
+	// If not specified, then an ephemeral key is generated for the build and discarded after the modules are signed.
+	Key *ModuleSigningKey `yaml:"key"`
+	// Enrollment configures the enrollment of the signing certificate on the first boot.
+	Enrollment *MokEnrollment `yaml:"enrollment"`
+}
+
+// ModuleSigningKey is a provided module signing key.
+type ModuleSigningKey struct {
+	// PrivateKeyPath is the path of the PEM private key.
+	PrivateKeyPath string `yaml:"privateKeyPath"`
+	// CertificatePath is the path of the X.509 certificate (PEM or DER) of the key.
+	CertificatePath string `yaml:"certificatePath"`
+}
+
+// MokEnrollment configures the automatic MOK enrollment request of the signing certificate.
+type MokEnrollment struct {
+	// Automatic requests the enrollment of the certificate on the first boot, using mokutil. The enrollment is then
+	// confirmed in MokManager, on the console, during the next boot.
+	Automatic bool `yaml:"automatic"`
+	// PasswordEnvironmentVariable is the name of a build host environment variable that holds the one-time password
+	// that MokManager asks for. If not specified, then a random password is generated.
+	PasswordEnvironmentVariable string `yaml:"passwordEnvironmentVariable"`
+}
+
+func (s *ModuleSigning) IsValid() error {
+	if s.Key != nil {
+		err := s.Key.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'key' field:\n%w", err)
+		}
+	}
+
+	if s.Enrollment != nil {
+		err := s.Enrollment.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'enrollment' field:\n%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (k *ModuleSigningKey) IsValid() error {
+	if k.PrivateKeyPath == "" {
+		return fmt.Errorf("'privateKeyPath' must be specified")
+	}
+
+	if k.CertificatePath == "" {
+		return fmt.Errorf("'certificatePath' must be specified")
+	}
+
+	return nil
+}
+
+func (e *MokEnrollment) IsValid() error {
+	if strings.ContainsAny(e.PasswordEnvironmentVariable, "= \t\n") {
+		return fmt.Errorf("invalid passwordEnvironmentVariable (%s)", e.PasswordEnvironmentVariable)
+	}
+
+	if e.PasswordEnvironmentVariable != "" && !e.Automatic {
+		return fmt.Errorf("'passwordEnvironmentVariable' requires 'automatic' to be true")
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleSigningIsValidEphemeralKey(t *testing.T) {
+	moduleSigning := ModuleSigning{
+		Enrollment: &MokEnrollment{
+			Automatic:                   true,
+			PasswordEnvironmentVariable: "MOK_PASSWORD",
+		},
+	}
+
+	err := moduleSigning.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestModuleSigningIsValidProvidedKey(t *testing.T) {
+	moduleSigning := ModuleSigning{
+		Key: &ModuleSigningKey{
+			PrivateKeyPath:  "keys/signing.key",
+			CertificatePath: "keys/signing.crt",
+		},
+	}
+
+	err := moduleSigning.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestModuleSigningIsValidMissingCertificate(t *testing.T) {
+	moduleSigning := ModuleSigning{
+		Key: &ModuleSigningKey{
+			PrivateKeyPath: "keys/signing.key",
+		},
+	}
+
+	err := moduleSigning.IsValid()
+	assert.ErrorContains(t, err, "invalid 'key' field")
+	assert.ErrorContains(t, err, "'certificatePath' must be specified")
+}
+
+func TestModuleSigningIsValidMissingPrivateKey(t *testing.T) {
+	moduleSigning := ModuleSigning{
+		Key: &ModuleSigningKey{
+			CertificatePath: "keys/signing.crt",
+		},
+	}
+
+	err := moduleSigning.IsValid()
+	assert.ErrorContains(t, err, "'privateKeyPath' must be specified")
+}
+
+func TestModuleSigningIsValidPasswordWithoutAutomatic(t *testing.T) {
+	moduleSigning := ModuleSigning{
+		Enrollment: &MokEnrollment{
+			PasswordEnvironmentVariable: "MOK_PASSWORD",
+		},
+	}
+
+	err := moduleSigning.IsValid()
+	assert.ErrorContains(t, err, "invalid 'enrollment' field")
+	assert.ErrorContains(t, err, "'passwordEnvironmentVariable' requires 'automatic' to be true")
+}
+
+func TestModuleSigningIsValidBadPasswordVariable(t *testing.T) {
+	moduleSigning := ModuleSigning{
+		Enrollment: &MokEnrollment{
+			Automatic:                   true,
+			PasswordEnvironmentVariable: "MOK PASSWORD",
+		},
+	}
+
+	err := moduleSigning.IsValid()
+	assert.ErrorContains(t, err, "invalid passwordEnvironmentVariable (MOK PASSWORD)")
+}
+
+func TestOSIsValidModuleSigning(t *testing.T) {
+	os := OS{
+		ModuleSigning: &ModuleSigning{
+			Key: &ModuleSigningKey{},
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid moduleSigning")
+	assert.ErrorContains(t, err, "'privateKeyPath' must be specified")
+}
@@ -0,0 +1,314 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+
+	"github.com/asaskevich/govalidator"
+)
+
+var (
+	// Linux interface names are limited to 15 characters and can't contain '/' or whitespace.
+	networkInterfaceNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,15}$`)
+)
+
+// NetworkRenderer specifies which network manager the network config is written for.
+type NetworkRenderer string
+
+const (
+	// NetworkRendererDefault writes the network config for systemd-networkd.
+	NetworkRendererDefault NetworkRenderer = ""
+	// NetworkRendererNetworkd writes the network config as systemd-networkd .network and .netdev files.
+	NetworkRendererNetworkd NetworkRenderer = "networkd"
+	// NetworkRendererNetworkManager writes the network config as NetworkManager keyfiles.
+	NetworkRendererNetworkManager NetworkRenderer = "networkManager"
+)
+
+func (r NetworkRenderer) IsValid() error {
+	switch r {
+	case NetworkRendererDefault, NetworkRendererNetworkd, NetworkRendererNetworkManager:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("invalid renderer value (%v)", r)
+	}
+}
+
+// NetworkBondMode is the bonding policy of a bond.
+type NetworkBondMode string
+
+const (
+	// NetworkBondModeDefault uses the kernel's default bonding policy (balance-rr).
+	NetworkBondModeDefault      NetworkBondMode = ""
+	NetworkBondModeBalanceRr    NetworkBondMode = "balance-rr"
+	NetworkBondModeActiveBackup NetworkBondMode = "active-backup"
+	NetworkBondModeBalanceXor   NetworkBondMode = "balance-xor"
+	NetworkBondModeBroadcast    NetworkBondMode = "broadcast"
+	NetworkBondMode8023ad       NetworkBondMode = "802.3ad"
+	NetworkBondModeBalanceTlb   NetworkBondMode = "balance-tlb"
+	NetworkBondModeBalanceAlb   NetworkBondMode = "balance-alb"
+)
+
+func (m NetworkBondMode) IsValid() error {
+	switch m {
+	case NetworkBondModeDefault, NetworkBondModeBalanceRr, NetworkBondModeActiveBackup, NetworkBondModeBalanceXor,
+		NetworkBondModeBroadcast, NetworkBondMode8023ad, NetworkBondModeBalanceTlb, NetworkBondModeBalanceAlb:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("invalid mode value (%v)", m)
+	}
+}
+
+// Network configures the network interfaces of the OS.
+type Network struct {
+	Renderer  NetworkRenderer   `yaml:"renderer"`
+	Ethernets []NetworkEthernet `yaml:"ethernets"`
+	Bonds     []NetworkBond     `yaml:"bonds"`
+	Vlans     []NetworkVlan     `yaml:"vlans"`
+}
+
+// NetworkIpConfig is the IP configuration of a network interface.
+type NetworkIpConfig struct {
+	Dhcp4 bool `yaml:"dhcp4"`
+	Dhcp6 bool `yaml:"dhcp6"`
+	// Addresses are the static IP addresses of the interface, in CIDR notation (e.g. "192.168.0.10/24").
+	Addresses     []string `yaml:"addresses"`
+	Gateways      []string `yaml:"gateways"`
+	Nameservers   []string `yaml:"nameservers"`
+	SearchDomains []string `yaml:"searchDomains"`
+}
+
+// NetworkEthernet configures a physical network interface.
+type NetworkEthernet struct {
+	Name string `yaml:"name"`
+	// MacAddress restricts the config to the interface with this MAC address.
+	MacAddress      string `yaml:"macAddress"`
+	NetworkIpConfig `yaml:",inline"`
+}
+
+// NetworkBond configures a bond of ethernet interfaces.
+type NetworkBond struct {
+	Name            string          `yaml:"name"`
+	Mode            NetworkBondMode `yaml:"mode"`
+	Interfaces      []string        `yaml:"interfaces"`
+	NetworkIpConfig `yaml:",inline"`
+}
+
+// NetworkVlan configures a VLAN on top of an ethernet interface or bond.
+type NetworkVlan struct {
+	Name            string `yaml:"name"`
+	Id              int    `yaml:"id"`
+	Link            string `yaml:"link"`
+	NetworkIpConfig `yaml:",inline"`
+}
+
+func (n *Network) IsValid() error {
+	err := n.Renderer.IsValid()
+	if err != nil {
+		return err
+	}
+
+	interfaceNames := make(map[string]bool)
+	ethernets := make(map[string]*NetworkEthernet)
+	bonds := make(map[string]bool)
+
+	for i := range n.Ethernets {
+		ethernet := &n.Ethernets[i]
+		err = ethernet.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid ethernets item at index %d:\n%w", i, err)
+		}
+
+		if interfaceNames[ethernet.Name] {
+			return fmt.Errorf("invalid ethernets item at index %d:\nduplicate interface name (%s)", i, ethernet.Name)
+		}
+		interfaceNames[ethernet.Name] = true
+		ethernets[ethernet.Name] = ethernet
+	}
+
+	bondMembers := make(map[string]bool)
+	for i, bond := range n.Bonds {
+		err = bond.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid bonds item at index %d:\n%w", i, err)
+		}
+
+		if interfaceNames[bond.Name] {
+			return fmt.Errorf("invalid bonds item at index %d:\nduplicate interface name (%s)", i, bond.Name)
+		}
+		interfaceNames[bond.Name] = true
+		bonds[bond.Name] = true
+
+		for _, member := range bond.Interfaces {
+			ethernet, ok := ethernets[member]
+			if !ok {
+				return fmt.Errorf("invalid bonds item at index %d:\ninterface (%s) is not in 'ethernets'", i, member)
+			}
+
+			if bondMembers[member] {
+				return fmt.Errorf("invalid bonds item at index %d:\ninterface (%s) is already a member of a bond", i,
+					member)
+			}
+			bondMembers[member] = true
+
+			if ethernet.NetworkIpConfig.isSpecified() {
+				return fmt.Errorf("invalid bonds item at index %d:\nmember interface (%s) cannot have an IP config",
+					i, member)
+			}
+		}
+	}
+
+	vlanIds := make(map[string]bool)
+	for i, vlan := range n.Vlans {
+		err = vlan.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid vlans item at index %d:\n%w", i, err)
+		}
+
+		if interfaceNames[vlan.Name] {
+			return fmt.Errorf("invalid vlans item at index %d:\nduplicate interface name (%s)", i, vlan.Name)
+		}
+		interfaceNames[vlan.Name] = true
+
+		if _, ok := ethernets[vlan.Link]; !ok && !bonds[vlan.Link] {
+			return fmt.Errorf("invalid vlans item at index %d:\nlink (%s) is not in 'ethernets' or 'bonds'", i,
+				vlan.Link)
+		}
+
+		if bondMembers[vlan.Link] {
+			return fmt.Errorf("invalid vlans item at index %d:\nlink (%s) is a member of a bond", i, vlan.Link)
+		}
+
+		linkId := fmt.Sprintf("%s.%d", vlan.Link, vlan.Id)
+		if vlanIds[linkId] {
+			return fmt.Errorf("invalid vlans item at index %d:\nduplicate VLAN ID (%d) on link (%s)", i, vlan.Id,
+				vlan.Link)
+		}
+		vlanIds[linkId] = true
+	}
+
+	return nil
+}
+
+func (e *NetworkEthernet) IsValid() error {
+	err := validateNetworkInterfaceName(e.Name)
+	if err != nil {
+		return err
+	}
+
+	if e.MacAddress != "" {
+		_, err := net.ParseMAC(e.MacAddress)
+		if err != nil {
+			return fmt.Errorf("invalid macAddress (%s)", e.MacAddress)
+		}
+	}
+
+	return e.NetworkIpConfig.IsValid()
+}
+
+func (b *NetworkBond) IsValid() error {
+	err := validateNetworkInterfaceName(b.Name)
+	if err != nil {
+		return err
+	}
+
+	err = b.Mode.IsValid()
+	if err != nil {
+		return err
+	}
+
+	if len(b.Interfaces) <= 0 {
+		return fmt.Errorf("must specify at least one item in 'interfaces'")
+	}
+
+	return b.NetworkIpConfig.IsValid()
+}
+
+func (v *NetworkVlan) IsValid() error {
+	err := validateNetworkInterfaceName(v.Name)
+	if err != nil {
+		return err
+	}
+
+	if v.Id < 1 || v.Id > 4094 {
+		return fmt.Errorf("invalid id (%d):\nmust be between 1 and 4094", v.Id)
+	}
+
+	if v.Link == "" {
+		return fmt.Errorf("must specify 'link'")
+	}
+
+	return v.NetworkIpConfig.IsValid()
+}
+
+func (c *NetworkIpConfig) IsValid() error {
+	for i, address := range c.Addresses {
+		_, err := netip.ParsePrefix(address)
+		if err != nil {
+			return fmt.Errorf("invalid addresses item at index %d:\ninvalid IP address in CIDR notation (%s)", i,
+				address)
+		}
+	}
+
+	hasIpv4Gateway := false
+	hasIpv6Gateway := false
+	for i, gateway := range c.Gateways {
+		addr, err := netip.ParseAddr(gateway)
+		if err != nil {
+			return fmt.Errorf("invalid gateways item at index %d:\ninvalid IP address (%s)", i, gateway)
+		}
+
+		// NetworkManager only supports a single gateway for each IP version.
+		if addr.Is4() {
+			if hasIpv4Gateway {
+				return fmt.Errorf("invalid gateways item at index %d:\nonly one IPv4 gateway may be specified", i)
+			}
+			hasIpv4Gateway = true
+		} else {
+			if hasIpv6Gateway {
+				return fmt.Errorf("invalid gateways item at index %d:\nonly one IPv6 gateway may be specified", i)
+			}
+			hasIpv6Gateway = true
+		}
+	}
+
+	for i, nameserver := range c.Nameservers {
+		_, err := netip.ParseAddr(nameserver)
+		if err != nil {
+			return fmt.Errorf("invalid nameservers item at index %d:\ninvalid IP address (%s)", i, nameserver)
+		}
+	}
+
+	for i, searchDomain := range c.SearchDomains {
+		if !govalidator.IsDNSName(searchDomain) {
+			return fmt.Errorf("invalid searchDomains item at index %d:\ninvalid domain name (%s)", i, searchDomain)
+		}
+	}
+
+	return nil
+}
+
+func (c *NetworkIpConfig) isSpecified() bool {
+	return c.Dhcp4 || c.Dhcp6 || len(c.Addresses) > 0 || len(c.Gateways) > 0 || len(c.Nameservers) > 0 ||
+		len(c.SearchDomains) > 0
+}
+
+func validateNetworkInterfaceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("must specify 'name'")
+	}
+
+	if !networkInterfaceNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid interface name (%s)", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkIsValid(t *testing.T) {
+	network := Network{
+		Renderer: NetworkRendererNetworkManager,
+		Ethernets: []NetworkEthernet{
+			{
+				Name:       "eth0",
+				MacAddress: "00:15:5d:01:02:03",
+				NetworkIpConfig: NetworkIpConfig{
+					Addresses:     []string{"192.168.0.10/24", "fd00::10/64"},
+					Gateways:      []string{"192.168.0.1", "fd00::1"},
+					Nameservers:   []string{"192.168.0.1"},
+					SearchDomains: []string{"example.com"},
+				},
+			},
+			{Name: "eth1"},
+			{Name: "eth2"},
+		},
+		Bonds: []NetworkBond{
+			{
+				Name:            "bond0",
+				Mode:            NetworkBondModeActiveBackup,
+				Interfaces:      []string{"eth1", "eth2"},
+				NetworkIpConfig: NetworkIpConfig{Dhcp4: true},
+			},
+		},
+		Vlans: []NetworkVlan{
+			{Name: "vlan10", Id: 10, Link: "bond0", NetworkIpConfig: NetworkIpConfig{Dhcp4: true}},
+			{Name: "vlan20", Id: 20, Link: "eth0", NetworkIpConfig: NetworkIpConfig{Dhcp6: true}},
+		},
+	}
+
+	err := network.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestNetworkIsValidInvalidRenderer(t *testing.T) {
+	network := Network{
+		Renderer: "netplan",
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "invalid renderer value (netplan)")
+}
+
+func TestNetworkIsValidDuplicateInterfaceName(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "eth0"},
+		},
+		Vlans: []NetworkVlan{
+			{Name: "eth0", Id: 10, Link: "eth0"},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "invalid vlans item at index 0")
+	assert.ErrorContains(t, err, "duplicate interface name (eth0)")
+}
+
+func TestNetworkIsValidInvalidInterfaceName(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "averyverylongname"},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "invalid interface name (averyverylongname)")
+}
+
+func TestNetworkIsValidInvalidMacAddress(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "eth0", MacAddress: "00:15:5d"},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "invalid macAddress (00:15:5d)")
+}
+
+func TestNetworkIsValidInvalidAddress(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "eth0", NetworkIpConfig: NetworkIpConfig{Addresses: []string{"192.168.0.10"}}},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "invalid addresses item at index 0")
+	assert.ErrorContains(t, err, "invalid IP address in CIDR notation (192.168.0.10)")
+}
+
+func TestNetworkIsValidTwoIpv4Gateways(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "eth0", NetworkIpConfig: NetworkIpConfig{Gateways: []string{"192.168.0.1", "192.168.0.2"}}},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "only one IPv4 gateway may be specified")
+}
+
+func TestNetworkIsValidBondMemberNotEthernet(t *testing.T) {
+	network := Network{
+		Bonds: []NetworkBond{
+			{Name: "bond0", Interfaces: []string{"eth1"}},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "interface (eth1) is not in 'ethernets'")
+}
+
+func TestNetworkIsValidBondMemberWithIpConfig(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "eth1", NetworkIpConfig: NetworkIpConfig{Dhcp4: true}},
+		},
+		Bonds: []NetworkBond{
+			{Name: "bond0", Interfaces: []string{"eth1"}},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "member interface (eth1) cannot have an IP config")
+}
+
+func TestNetworkIsValidVlanInvalidId(t *testing.T) {
+	network := Network{
+		Ethernets: []NetworkEthernet{
+			{Name: "eth0"},
+		},
+		Vlans: []NetworkVlan{
+			{Name: "vlan0", Id: 4095, Link: "eth0"},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "invalid id (4095)")
+}
+
+func TestNetworkIsValidVlanUnknownLink(t *testing.T) {
+	network := Network{
+		Vlans: []NetworkVlan{
+			{Name: "vlan10", Id: 10, Link: "eth0"},
+		},
+	}
+
+	err := network.IsValid()
+	assert.ErrorContains(t, err, "link (eth0) is not in 'ethernets' or 'bonds'")
+}
+
+func TestNetworkUnmarshalYaml(t *testing.T) {
+	var network Network
+	err := UnmarshalYaml([]byte(`
+ethernets:
+- name: eth0
+  dhcp4: true
+  addresses: [192.168.0.10/24]
+`), &network)
+	assert.NoError(t, err)
+	assert.Equal(t, Network{
+		Ethernets: []NetworkEthernet{
+			{
+				Name: "eth0",
+				NetworkIpConfig: NetworkIpConfig{
+					Dhcp4:     true,
+					Addresses: []string{"192.168.0.10/24"},
+				},
+			},
+		},
+	}, network)
+}
@@ -5,24 +5,50 @@ package imagecustomizerapi
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
 )
 
+var (
+	// A tz database name (e.g. "America/Los_Angeles" or "Etc/GMT+8").
+	timezoneRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+	// A locale name (e.g. "en_US.UTF-8" or "de_DE@euro").
+	localeRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+	// A console keymap name (e.g. "us" or "de-latin1-nodeadkeys").
+	keymapRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
 // OS defines how each system present on the image is supposed to be configured.
 type OS struct {
 	ResetBootLoaderType ResetBootLoaderType `yaml:"resetBootLoaderType"`
 	Hostname            string              `yaml:"hostname"`
+	Timezone            string              `yaml:"timezone"`
+	Locale              string              `yaml:"locale"`
+	Keymap              string              `yaml:"keymap"`
 	Packages            Packages            `yaml:"packages"`
+	PackageLocks        []PackageLock       `yaml:"packageLocks"`
+	Tdnf                *Tdnf               `yaml:"tdnf"`
+	Repos               *Repos              `yaml:"repos"`
 	SELinux             SELinux             `yaml:"selinux"`
 	KernelCommandLine   KernelCommandLine   `yaml:"kernelCommandLine"`
 	AdditionalFiles     AdditionalFileList  `yaml:"additionalFiles"`
+	TemplateVariables   map[string]string   `yaml:"templateVariables"`
 	AdditionalDirs      DirConfigList       `yaml:"additionalDirs"`
 	Users               []User              `yaml:"users"`
+	IdLedger            *IdLedger           `yaml:"idLedger"`
 	Services            Services            `yaml:"services"`
+	ScheduledTasks      []ScheduledTask     `yaml:"scheduledTasks"`
+	Network             *Network            `yaml:"network"`
+	Proxy               *Proxy              `yaml:"proxy"`
+	CloudInit           *CloudInit          `yaml:"cloudInit"`
 	Modules             []Module            `yaml:"modules"`
+	ModuleSigning       *ModuleSigning      `yaml:"moduleSigning"`
+	Gpu                 *Gpu                `yaml:"gpu"`
+	SelfTest            *SelfTest           `yaml:"selfTest"`
 	Overlays            *[]Overlay          `yaml:"overlays"`
+	WritableLayers      *WritableLayers     `yaml:"writableLayers"`
 }
 
 func (s *OS) IsValid() error {
@@ -38,6 +64,51 @@ func (s *OS) IsValid() error {
 		}
 	}
 
+	if s.Timezone != "" && !timezoneRegex.MatchString(s.Timezone) {
+		return fmt.Errorf("invalid timezone (%s)", s.Timezone)
+	}
+
+	if s.Locale != "" && !localeRegex.MatchString(s.Locale) {
+		return fmt.Errorf("invalid locale (%s)", s.Locale)
+	}
+
+	if s.Keymap != "" && !keymapRegex.MatchString(s.Keymap) {
+		return fmt.Errorf("invalid keymap (%s)", s.Keymap)
+	}
+
+	err = s.Packages.IsValid()
+	if err != nil {
+		return fmt.Errorf("invalid packages:\n%w", err)
+	}
+
+	packageLockNames := make(map[string]bool)
+	for i, packageLock := range s.PackageLocks {
+		err = packageLock.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid packageLocks item at index %d:\n%w", i, err)
+		}
+
+		if packageLockNames[packageLock.Name] {
+			return fmt.Errorf("invalid packageLocks item at index %d:\nduplicate package name (%s)", i,
+				packageLock.Name)
+		}
+		packageLockNames[packageLock.Name] = true
+	}
+
+	if s.Tdnf != nil {
+		err = s.Tdnf.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid tdnf:\n%w", err)
+		}
+	}
+
+	if s.Repos != nil {
+		err = s.Repos.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid repos:\n%w", err)
+		}
+	}
+
 	err = s.SELinux.IsValid()
 	if err != nil {
 		return fmt.Errorf("invalid selinux:\n%w", err)
@@ -53,6 +124,12 @@ func (s *OS) IsValid() error {
 		return fmt.Errorf("invalid additionalFiles:\n%w", err)
 	}
 
+	for name := range s.TemplateVariables {
+		if !templateVariableNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid templateVariables name (%s)", name)
+		}
+	}
+
 	err = s.AdditionalDirs.IsValid()
 	if err != nil {
 		return fmt.Errorf("invalid additionalDirs:\n%w", err)
@@ -65,10 +142,52 @@ func (s *OS) IsValid() error {
 		}
 	}
 
+	if s.IdLedger != nil {
+		err = s.IdLedger.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid idLedger:\n%w", err)
+		}
+	}
+
 	if err := s.Services.IsValid(); err != nil {
 		return err
 	}
 
+	scheduledTaskNames := make(map[string]bool)
+	for i, scheduledTask := range s.ScheduledTasks {
+		err = scheduledTask.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid scheduledTasks item at index %d:\n%w", i, err)
+		}
+
+		if scheduledTaskNames[scheduledTask.Name] {
+			return fmt.Errorf("invalid scheduledTasks item at index %d:\nduplicate name (%s)", i,
+				scheduledTask.Name)
+		}
+		scheduledTaskNames[scheduledTask.Name] = true
+	}
+
+	if s.Network != nil {
+		err = s.Network.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid network:\n%w", err)
+		}
+	}
+
+	if s.Proxy != nil {
+		err = s.Proxy.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid proxy:\n%w", err)
+		}
+	}
+
+	if s.CloudInit != nil {
+		err = s.CloudInit.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid cloudInit:\n%w", err)
+		}
+	}
+
 	moduleMap := make(map[string]int)
 	for i, module := range s.Modules {
 		// Check if module is duplicated to avoid conflicts with modules potentially having different LoadMode
@@ -82,6 +201,27 @@ func (s *OS) IsValid() error {
 		}
 	}
 
+	if s.ModuleSigning != nil {
+		err = s.ModuleSigning.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid moduleSigning:\n%w", err)
+		}
+	}
+
+	if s.Gpu != nil {
+		err = s.Gpu.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid gpu:\n%w", err)
+		}
+	}
+
+	if s.SelfTest != nil {
+		err = s.SelfTest.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid selfTest:\n%w", err)
+		}
+	}
+
 	if s.Overlays != nil {
 		mountPoints := make(map[string]bool)
 		upperDirs := make(map[string]bool)
@@ -114,5 +254,23 @@ func (s *OS) IsValid() error {
 		}
 	}
 
+	if s.WritableLayers != nil {
+		err = s.WritableLayers.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid writableLayers:\n%w", err)
+		}
+
+		if s.Overlays != nil {
+			for _, layerPath := range s.WritableLayers.Paths {
+				for i, overlay := range *s.Overlays {
+					if pathsOverlap(layerPath, overlay.MountPoint) {
+						return fmt.Errorf("writableLayers path (%s) overlaps with mountPoint (%s) of overlay at index %d",
+							layerPath, overlay.MountPoint, i)
+					}
+				}
+			}
+		}
+	}
+
 	return nil
 }
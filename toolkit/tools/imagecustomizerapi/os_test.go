@@ -27,6 +27,36 @@ func TestOSInvalidHostname(t *testing.T) {
 	assert.ErrorContains(t, err, "invalid hostname")
 }
 
+func TestOSValidTimezoneLocaleKeymap(t *testing.T) {
+	testValidYamlValue[*OS](t, "{ \"timezone\": \"America/Los_Angeles\", \"locale\": \"en_US.UTF-8\", "+
+		"\"keymap\": \"de-latin1-nodeadkeys\" }",
+		&OS{Timezone: "America/Los_Angeles", Locale: "en_US.UTF-8", Keymap: "de-latin1-nodeadkeys"})
+}
+
+func TestOSInvalidTimezone(t *testing.T) {
+	os := OS{
+		Timezone: "../../etc/passwd",
+	}
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid timezone (../../etc/passwd)")
+}
+
+func TestOSInvalidLocale(t *testing.T) {
+	os := OS{
+		Locale: "en US",
+	}
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid locale (en US)")
+}
+
+func TestOSInvalidKeymap(t *testing.T) {
+	os := OS{
+		Keymap: "../us",
+	}
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid keymap (../us)")
+}
+
 func TestOSIsValidInvalidAdditionalFilesSource(t *testing.T) {
 	os := OS{
 		AdditionalFiles: []AdditionalFile{
@@ -216,6 +246,37 @@ func TestOSIsValidOverlayDuplicateWorkDir(t *testing.T) {
 	assert.ErrorContains(t, err, "duplicate workDir (/work_root) found in overlay at index 1")
 }
 
+func TestOSIsValidInvalidWritableLayers(t *testing.T) {
+	os := OS{
+		WritableLayers: &WritableLayers{
+			PersistentMountPoint: "/persist",
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid writableLayers")
+}
+
+func TestOSIsValidWritableLayersOverlapsOverlay(t *testing.T) {
+	os := OS{
+		Overlays: &[]Overlay{
+			{
+				LowerDirs:  []string{"/etc"},
+				UpperDir:   "/var/overlays/etc/upper",
+				WorkDir:    "/var/overlays/etc/work",
+				MountPoint: "/etc",
+			},
+		},
+		WritableLayers: &WritableLayers{
+			PersistentMountPoint: "/persist",
+			Paths:                []string{"/etc"},
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "writableLayers path (/etc) overlaps with mountPoint (/etc) of overlay at index 0")
+}
+
 func TestOSIsValidInvalidKernelCommandLine(t *testing.T) {
 	os := OS{
 		KernelCommandLine: KernelCommandLine{
@@ -226,3 +287,25 @@ func TestOSIsValidInvalidKernelCommandLine(t *testing.T) {
 	err := os.IsValid()
 	assert.ErrorContains(t, err, "invalid kernelCommandLine")
 }
+
+func TestOSIsValidIdLedgerMissingPath(t *testing.T) {
+	os := OS{
+		IdLedger: &IdLedger{
+			ReadOnly: true,
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid idLedger")
+	assert.ErrorContains(t, err, "'path' must not be empty")
+}
+
+func TestOSInvalidTemplateVariableName(t *testing.T) {
+	os := OS{
+		TemplateVariables: map[string]string{
+			"region-name": "westus",
+		},
+	}
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid templateVariables name (region-name)")
+}
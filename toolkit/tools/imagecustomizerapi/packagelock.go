@@ -0,0 +1,37 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// Matches RPM package names.
+	packageNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+
+	// Matches "[epoch:]version[-release]".
+	packageLockVersionRegex = regexp.MustCompile(`^([0-9]+:)?[A-Za-z0-9_.+~^]+(-[A-Za-z0-9_.+~^]+)?$`)
+)
+
+// PackageLock pins an installed package to a specific version.
+type PackageLock struct {
+	// Name is the name of the package.
+	Name string `yaml:"name"`
+	// Version is the version the package must have, in the form "[epoch:]version[-release]".
+	Version string `yaml:"version"`
+}
+
+func (l *PackageLock) IsValid() error {
+	if !packageNameRegex.MatchString(l.Name) {
+		return fmt.Errorf("invalid name (%s): must be a valid package name", l.Name)
+	}
+
+	if !packageLockVersionRegex.MatchString(l.Version) {
+		return fmt.Errorf("invalid version (%s): must be in the form [epoch:]version[-release]", l.Version)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageLockIsValid(t *testing.T) {
+	for _, version := range []string{"3.3.2", "3.3.2-1.azl3", "1:3.3.2-1.azl3", "2.0~rc1"} {
+		lock := PackageLock{
+			Name:    "openssl-libs",
+			Version: version,
+		}
+
+		err := lock.IsValid()
+		assert.NoError(t, err, version)
+	}
+}
+
+func TestPackageLockIsValidBadName(t *testing.T) {
+	lock := PackageLock{
+		Name:    "openssl libs",
+		Version: "3.3.2",
+	}
+
+	err := lock.IsValid()
+	assert.ErrorContains(t, err, "invalid name (openssl libs)")
+}
+
+func TestPackageLockIsValidBadVersion(t *testing.T) {
+	for _, version := range []string{"", "a:3.3.2", "3.3.2-1-1", "3.3.2 "} {
+		lock := PackageLock{
+			Name:    "openssl",
+			Version: version,
+		}
+
+		err := lock.IsValid()
+		assert.ErrorContains(t, err, "invalid version", version)
+	}
+}
+
+func TestOSIsValidDuplicatePackageLock(t *testing.T) {
+	os := OS{
+		PackageLocks: []PackageLock{
+			{Name: "openssl", Version: "3.3.2"},
+			{Name: "openssl", Version: "3.3.3"},
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid packageLocks item at index 1:\nduplicate package name (openssl)")
+}
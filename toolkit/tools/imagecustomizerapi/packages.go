@@ -3,12 +3,42 @@
 
 package imagecustomizerapi
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
 type Packages struct {
-	UpdateExistingPackages bool     `yaml:"updateExistingPackages"`
-	InstallLists           []string `yaml:"installLists"`
-	Install                []string `yaml:"install"`
-	RemoveLists            []string `yaml:"removeLists"`
-	Remove                 []string `yaml:"remove"`
-	UpdateLists            []string `yaml:"updateLists"`
-	Update                 []string `yaml:"update"`
+	UpdateExistingPackages bool        `yaml:"updateExistingPackages"`
+	InstallLists           []string    `yaml:"installLists"`
+	Install                []string    `yaml:"install"`
+	RemoveLists            []string    `yaml:"removeLists"`
+	Remove                 []string    `yaml:"remove"`
+	RemoveOrphans          bool        `yaml:"removeOrphans"`
+	ProtectedPackages      []string    `yaml:"protectedPackages"`
+	UpdateLists            []string    `yaml:"updateLists"`
+	Update                 []string    `yaml:"update"`
+	LocalRepos             []LocalRepo `yaml:"localRepos"`
+}
+
+func (p *Packages) IsValid() error {
+	for _, protectedPackage := range p.ProtectedPackages {
+		if protectedPackage == "" || strings.ContainsAny(protectedPackage, " \t\n") {
+			return fmt.Errorf("invalid protectedPackages value (%s)", protectedPackage)
+		}
+
+		if slices.Contains(p.Remove, protectedPackage) {
+			return fmt.Errorf("package (%s) cannot be in both 'remove' and 'protectedPackages'", protectedPackage)
+		}
+	}
+
+	for i, localRepo := range p.LocalRepos {
+		err := localRepo.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid localRepos item at index %d:\n%w", i, err)
+		}
+	}
+
+	return nil
 }
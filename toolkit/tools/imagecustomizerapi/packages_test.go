@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackagesIsValidProtectedPackages(t *testing.T) {
+	packages := Packages{
+		Remove:            []string{"openssh-server"},
+		RemoveOrphans:     true,
+		ProtectedPackages: []string{"openssl", "cloud-init"},
+	}
+
+	err := packages.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestPackagesIsValidProtectedPackagesEmpty(t *testing.T) {
+	packages := Packages{
+		ProtectedPackages: []string{""},
+	}
+
+	err := packages.IsValid()
+	assert.ErrorContains(t, err, "invalid protectedPackages value ()")
+}
+
+func TestPackagesIsValidProtectedPackageRemoved(t *testing.T) {
+	packages := Packages{
+		Remove:            []string{"openssl"},
+		ProtectedPackages: []string{"openssl"},
+	}
+
+	err := packages.IsValid()
+	assert.ErrorContains(t, err, "package (openssl) cannot be in both 'remove' and 'protectedPackages'")
+}
@@ -22,6 +22,8 @@ type Partition struct {
 	Size PartitionSize `yaml:"size"`
 	// Type specifies the type of partition the partition is.
 	Type PartitionType `yaml:"type"`
+	// Flags are additional GPT attributes to set on the partition.
+	Flags []PartitionFlag `yaml:"flags"`
 }
 
 func (p *Partition) IsValid() error {
@@ -43,6 +45,13 @@ func (p *Partition) IsValid() error {
 		return err
 	}
 
+	for _, flag := range p.Flags {
+		err = flag.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid partition (%s) flags:\n%w", p.Id, err)
+		}
+	}
+
 	return nil
 }
 
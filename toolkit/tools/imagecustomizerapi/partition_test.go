@@ -167,3 +167,27 @@ func TestPartitionIsValidBadType(t *testing.T) {
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, "unknown partition type")
 }
+
+func TestPartitionIsValidTypeAndFlags(t *testing.T) {
+	partition := Partition{
+		Id:    "a",
+		Start: ptrutils.PtrTo(DiskSize(0)),
+		Type:  PartitionTypeHome,
+		Flags: []PartitionFlag{PartitionFlagNoAutomount},
+	}
+
+	err := partition.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestPartitionIsValidBadFlag(t *testing.T) {
+	partition := Partition{
+		Id:    "a",
+		Start: ptrutils.PtrTo(DiskSize(0)),
+		Flags: []PartitionFlag{"bad"},
+	}
+
+	err := partition.IsValid()
+	assert.ErrorContains(t, err, "invalid partition (a) flags")
+	assert.ErrorContains(t, err, "unknown partition flag (bad)")
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// PartitionFlag is a GPT partition attribute to set on a partition.
+type PartitionFlag string
+
+const (
+	// PartitionFlagLegacyBoot marks the partition as bootable by legacy BIOS firmware.
+	PartitionFlagLegacyBoot PartitionFlag = "legacy-boot"
+
+	// PartitionFlagHidden hides the partition from the OS's automatic discovery.
+	PartitionFlagHidden PartitionFlag = "hidden"
+
+	// PartitionFlagNoAutomount asks the OS not to automatically mount the partition.
+	PartitionFlagNoAutomount PartitionFlag = "no-automount"
+)
+
+func (f PartitionFlag) IsValid() error {
+	switch f {
+	case PartitionFlagLegacyBoot, PartitionFlagHidden, PartitionFlagNoAutomount:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("unknown partition flag (%s)", f)
+	}
+}
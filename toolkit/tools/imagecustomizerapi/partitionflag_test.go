@@ -0,0 +1,20 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionFlagIsValid(t *testing.T) {
+	err := PartitionFlagNoAutomount.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestPartitionFlagIsValidInvalid(t *testing.T) {
+	err := PartitionFlag("read-only").IsValid()
+	assert.ErrorContains(t, err, "unknown partition flag (read-only)")
+}
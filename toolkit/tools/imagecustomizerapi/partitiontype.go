@@ -23,11 +23,36 @@ const (
 	//
 	// See, https://en.wikipedia.org/wiki/BIOS_boot_partition
 	PartitionTypeBiosGrub PartitionType = "bios-grub"
+
+	// PartitionTypeLinuxGeneric indicates this is a generic Linux data partition.
+	PartitionTypeLinuxGeneric PartitionType = "linux-generic"
+
+	// PartitionTypeRoot indicates this is the root partition for the image's architecture.
+	PartitionTypeRoot PartitionType = "root"
+
+	// PartitionTypeXbootldr indicates this is an extended boot loader (/boot) partition.
+	PartitionTypeXbootldr PartitionType = "xbootldr"
+
+	// PartitionTypeSwap indicates this is a swap partition.
+	PartitionTypeSwap PartitionType = "swap"
+
+	// PartitionTypeHome indicates this is a /home partition.
+	PartitionTypeHome PartitionType = "home"
+
+	// PartitionTypeSrv indicates this is a /srv partition.
+	PartitionTypeSrv PartitionType = "srv"
+
+	// PartitionTypeVar indicates this is a /var partition.
+	PartitionTypeVar PartitionType = "var"
+
+	// PartitionTypeTmp indicates this is a /var/tmp partition.
+	PartitionTypeTmp PartitionType = "tmp"
 )
 
 func (p PartitionType) IsValid() (err error) {
 	switch p {
-	case PartitionTypeDefault, PartitionTypeESP, PartitionTypeBiosGrub:
+	case PartitionTypeDefault, PartitionTypeESP, PartitionTypeBiosGrub, PartitionTypeLinuxGeneric, PartitionTypeRoot,
+		PartitionTypeXbootldr, PartitionTypeSwap, PartitionTypeHome, PartitionTypeSrv, PartitionTypeVar, PartitionTypeTmp:
 		// All good.
 		return nil
 
@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
+)
+
+var (
+	proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+)
+
+// Proxy configures the OS to use an HTTP proxy, including a proxy that intercepts TLS connections.
+type Proxy struct {
+	// HttpProxy is the URL of the proxy for HTTP connections.
+	HttpProxy string `yaml:"httpProxy"`
+	// HttpsProxy is the URL of the proxy for HTTPS connections.
+	HttpsProxy string `yaml:"httpsProxy"`
+	// NoProxy lists the hosts, domains (e.g. ".example.com") and IP ranges that are connected to directly.
+	NoProxy []string `yaml:"noProxy"`
+	// CaCertificatePaths are PEM files of the CA certificates that the proxy signs the intercepted connections with.
+	CaCertificatePaths []string `yaml:"caCertificatePaths"`
+}
+
+func (p *Proxy) IsValid() error {
+	if p.HttpProxy == "" && p.HttpsProxy == "" && len(p.CaCertificatePaths) <= 0 {
+		return fmt.Errorf("must specify at least one of 'httpProxy', 'httpsProxy', or 'caCertificatePaths'")
+	}
+
+	err := validateProxyUrl(p.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("invalid httpProxy:\n%w", err)
+	}
+
+	err = validateProxyUrl(p.HttpsProxy)
+	if err != nil {
+		return fmt.Errorf("invalid httpsProxy:\n%w", err)
+	}
+
+	if len(p.NoProxy) > 0 && p.HttpProxy == "" && p.HttpsProxy == "" {
+		return fmt.Errorf("cannot specify 'noProxy' without 'httpProxy' or 'httpsProxy'")
+	}
+
+	for i, noProxy := range p.NoProxy {
+		if noProxy == "" || strings.ContainsAny(noProxy, " \t\n\r,\"'\\") {
+			return fmt.Errorf("invalid noProxy item at index %d (%s): must be non-empty and not contain whitespace, "+
+				"',', quotes, or '\\'", i, noProxy)
+		}
+	}
+
+	for i, caCertificatePath := range p.CaCertificatePaths {
+		if strings.TrimSpace(caCertificatePath) == "" {
+			return fmt.Errorf("invalid caCertificatePaths item at index %d: path may not be empty", i)
+		}
+	}
+
+	return nil
+}
+
+func validateProxyUrl(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	// The URL is written into shell scripts and systemd config files.
+	if strings.ContainsAny(value, " \t\n\r\"'\\") {
+		return fmt.Errorf("invalid proxy (%s): must not contain whitespace, quotes, or '\\'", value)
+	}
+
+	proxyUrl, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid proxy (%s):\n%w", value, err)
+	}
+
+	if !sliceutils.ContainsValue(proxySchemes, proxyUrl.Scheme) || proxyUrl.Host == "" {
+		return fmt.Errorf("invalid proxy (%s): must be a URL with one of the schemes (%v)", value, proxySchemes)
+	}
+
+	return nil
+}
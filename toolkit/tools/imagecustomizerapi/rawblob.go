@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// RawBlob is a file that is written, as is, into the disk image at a byte offset (e.g. firmware, bootloader stages,
+// or vendor metadata).
+type RawBlob struct {
+	// Source is the path of the file to write.
+	Source string `yaml:"source"`
+	// PartitionId is the ID of the partition to write the file into. The partition must not have a filesystem.
+	// If not specified, then the file is written into the disk's unpartitioned space (e.g. the gap between the
+	// partition table and the first partition).
+	PartitionId string `yaml:"partitionId"`
+	// Offset is the byte offset to write the file at, relative to the start of the partition (if 'partitionId' is
+	// specified) or the disk.
+	Offset uint64 `yaml:"offset"`
+}
+
+func (b *RawBlob) IsValid() error {
+	if b.Source == "" {
+		return fmt.Errorf("'source' must be specified")
+	}
+
+	return nil
+}
+
+// checkRawBlobPartition checks that the raw blob's partition exists and isn't used by a filesystem (or a verity or
+// encrypted device).
+func checkRawBlobPartition(blob *RawBlob, deviceMap map[string]any, deviceParents map[string]any) error {
+	if blob.PartitionId == "" {
+		return nil
+	}
+
+	_, isPartition := deviceMap[blob.PartitionId].(*Partition)
+	if !isPartition {
+		return fmt.Errorf("partition (%s) doesn't exist", blob.PartitionId)
+	}
+
+	parent, hasParent := deviceParents[blob.PartitionId]
+	if !hasParent {
+		return nil
+	}
+
+	fileSystem, isFileSystem := parent.(*FileSystem)
+	if !isFileSystem || fileSystem.Type != FileSystemTypeNone || fileSystem.MountPoint != nil {
+		return fmt.Errorf("partition (%s) is in use and can't have raw data written to it", blob.PartitionId)
+	}
+
+	return nil
+}
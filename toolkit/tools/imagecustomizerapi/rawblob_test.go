@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func getRawBlobTestStorage(rawBlobs []RawBlob) Storage {
+	return Storage{
+		Disks: []Disk{{
+			PartitionTableType: "gpt",
+			MaxSize:            ptrutils.PtrTo(DiskSize(4 * diskutils.GiB)),
+			Partitions: []Partition{
+				{
+					Id:    "esp",
+					Start: ptrutils.PtrTo(DiskSize(1 * diskutils.MiB)),
+					End:   ptrutils.PtrTo(DiskSize(9 * diskutils.MiB)),
+					Type:  PartitionTypeESP,
+				},
+				{
+					Id:    "firmware",
+					Start: ptrutils.PtrTo(DiskSize(9 * diskutils.MiB)),
+					End:   ptrutils.PtrTo(DiskSize(17 * diskutils.MiB)),
+				},
+				{
+					Id:    "rootfs",
+					Start: ptrutils.PtrTo(DiskSize(17 * diskutils.MiB)),
+				},
+			},
+		}},
+		BootType: "efi",
+		FileSystems: []FileSystem{
+			{
+				DeviceId:   "esp",
+				Type:       "vfat",
+				MountPoint: &MountPoint{Path: "/boot/efi"},
+			},
+			{
+				DeviceId:   "rootfs",
+				Type:       "ext4",
+				MountPoint: &MountPoint{Path: "/"},
+			},
+		},
+		RawBlobs: rawBlobs,
+	}
+}
+
+func TestStorageIsValidRawBlobs(t *testing.T) {
+	storage := getRawBlobTestStorage([]RawBlob{
+		{Source: "spl.bin", Offset: 32 * diskutils.KiB},
+		{Source: "firmware.bin", PartitionId: "firmware"},
+	})
+
+	err := storage.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestStorageIsValidRawBlobMissingSource(t *testing.T) {
+	storage := getRawBlobTestStorage([]RawBlob{
+		{Offset: 32 * diskutils.KiB},
+	})
+
+	err := storage.IsValid()
+	assert.ErrorContains(t, err, "invalid rawBlobs item at index 0")
+	assert.ErrorContains(t, err, "'source' must be specified")
+}
+
+func TestStorageIsValidRawBlobMissingPartition(t *testing.T) {
+	storage := getRawBlobTestStorage([]RawBlob{
+		{Source: "firmware.bin", PartitionId: "vendor"},
+	})
+
+	err := storage.IsValid()
+	assert.ErrorContains(t, err, "partition (vendor) doesn't exist")
+}
+
+func TestStorageIsValidRawBlobPartitionInUse(t *testing.T) {
+	storage := getRawBlobTestStorage([]RawBlob{
+		{Source: "firmware.bin", PartitionId: "rootfs"},
+	})
+
+	err := storage.IsValid()
+	assert.ErrorContains(t, err, "partition (rootfs) is in use and can't have raw data written to it")
+}
+
+func TestStorageIsValidRawBlobsWithoutDisks(t *testing.T) {
+	storage := Storage{
+		RawBlobs: []RawBlob{{Source: "spl.bin", Offset: 32 * diskutils.KiB}},
+	}
+
+	err := storage.IsValid()
+	assert.ErrorContains(t, err, "cannot specify 'rawBlobs' without specifying 'disks'")
+}
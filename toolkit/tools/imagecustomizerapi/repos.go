@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Matches the repo IDs that tdnf accepts, which are also used as the repo file names.
+	repoIdRegex = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+)
+
+// ExistingReposMode specifies what to do with the repos that are already configured in the OS image.
+type ExistingReposMode string
+
+const (
+	// ExistingReposModeDefault keeps the existing repos.
+	ExistingReposModeDefault ExistingReposMode = ""
+	// ExistingReposModeKeep keeps the existing repos.
+	ExistingReposModeKeep ExistingReposMode = "keep"
+	// ExistingReposModeDisable keeps the existing repo files but disables all of their repos.
+	ExistingReposModeDisable ExistingReposMode = "disable"
+	// ExistingReposModeRemove deletes the existing repo files.
+	ExistingReposModeRemove ExistingReposMode = "remove"
+)
+
+func (m ExistingReposMode) IsValid() error {
+	switch m {
+	case ExistingReposModeDefault, ExistingReposModeKeep, ExistingReposModeDisable, ExistingReposModeRemove:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("invalid existing value (%v)", m)
+	}
+}
+
+// Repos defines the package repos that are configured in the OS image.
+//
+// These are independent of the repos used to install packages during customization, which are specified by the
+// --rpm-source and --use-base-image-rpm-repos command-line args.
+type Repos struct {
+	// Existing specifies what to do with the repo files that are already in the image.
+	Existing ExistingReposMode `yaml:"existing"`
+	// Add lists the repos to add to the image.
+	Add []Repo `yaml:"add"`
+}
+
+// Repo defines a single package repo.
+type Repo struct {
+	Id       string   `yaml:"id"`
+	Name     string   `yaml:"name"`
+	BaseUrl  string   `yaml:"baseUrl"`
+	MetaLink string   `yaml:"metaLink"`
+	Enabled  *bool    `yaml:"enabled"`
+	GpgCheck *bool    `yaml:"gpgCheck"`
+	GpgKeys  []string `yaml:"gpgKeys"`
+}
+
+func (r *Repos) IsValid() error {
+	err := r.Existing.IsValid()
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool)
+	for i, repo := range r.Add {
+		err = repo.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid add item at index %d:\n%w", i, err)
+		}
+
+		if _, exists := ids[repo.Id]; exists {
+			return fmt.Errorf("duplicate repo id (%s) found at index %d", repo.Id, i)
+		}
+		ids[repo.Id] = true
+	}
+
+	return nil
+}
+
+func (r *Repo) IsValid() error {
+	if !repoIdRegex.MatchString(r.Id) {
+		return fmt.Errorf("invalid id (%s): must be non-empty and only contain letters, digits and '_', '.', ':', '-'",
+			r.Id)
+	}
+
+	if strings.ContainsAny(r.Name, "\n") {
+		return fmt.Errorf("invalid name (%s): must not contain newlines", r.Name)
+	}
+
+	if (r.BaseUrl == "") == (r.MetaLink == "") {
+		return fmt.Errorf("exactly one of 'baseUrl' or 'metaLink' must be specified")
+	}
+
+	if r.BaseUrl != "" {
+		err := validateRepoUrl(r.BaseUrl)
+		if err != nil {
+			return fmt.Errorf("invalid baseUrl:\n%w", err)
+		}
+	}
+
+	if r.MetaLink != "" {
+		err := validateRepoUrl(r.MetaLink)
+		if err != nil {
+			return fmt.Errorf("invalid metaLink:\n%w", err)
+		}
+	}
+
+	for i, gpgKey := range r.GpgKeys {
+		err := validateRepoUrl(gpgKey)
+		if err != nil {
+			return fmt.Errorf("invalid gpgKeys item at index %d:\n%w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func validateRepoUrl(value string) error {
+	// Repo files may reference tdnf variables (e.g. $releasever), which are valid URL characters.
+	repoUrl, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+
+	if repoUrl.Scheme == "" || strings.ContainsAny(value, " \t\n") {
+		return fmt.Errorf("(%s) must be a URL without whitespace", value)
+	}
+
+	return nil
+}
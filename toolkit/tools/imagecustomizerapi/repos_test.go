@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReposIsValid(t *testing.T) {
+	repos := Repos{
+		Existing: ExistingReposModeRemove,
+		Add: []Repo{
+			{
+				Id:       "azurelinux-official-base",
+				Name:     "Azure Linux Official Base $releasever $basearch",
+				BaseUrl:  "https://packages.microsoft.com/azurelinux/$releasever/prod/base/$basearch",
+				GpgCheck: ptrutils.PtrTo(true),
+				GpgKeys:  []string{"file:///etc/pki/rpm-gpg/MICROSOFT-RPM-GPG-KEY"},
+			},
+		},
+	}
+
+	err := repos.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestReposIsValidDisableAll(t *testing.T) {
+	repos := Repos{
+		Existing: ExistingReposModeDisable,
+	}
+
+	err := repos.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestReposIsValidBadExisting(t *testing.T) {
+	repos := Repos{
+		Existing: "delete",
+	}
+
+	err := repos.IsValid()
+	assert.ErrorContains(t, err, "invalid existing value (delete)")
+}
+
+func TestReposIsValidDuplicateId(t *testing.T) {
+	repos := Repos{
+		Add: []Repo{
+			{Id: "a", BaseUrl: "https://example.com/a"},
+			{Id: "a", BaseUrl: "https://example.com/b"},
+		},
+	}
+
+	err := repos.IsValid()
+	assert.ErrorContains(t, err, "duplicate repo id (a) found at index 1")
+}
+
+func TestRepoIsValidBadId(t *testing.T) {
+	repo := Repo{
+		Id:      "../a",
+		BaseUrl: "https://example.com/a",
+	}
+
+	err := repo.IsValid()
+	assert.ErrorContains(t, err, "invalid id (../a)")
+}
+
+func TestRepoIsValidMissingUrl(t *testing.T) {
+	repo := Repo{
+		Id: "a",
+	}
+
+	err := repo.IsValid()
+	assert.ErrorContains(t, err, "exactly one of 'baseUrl' or 'metaLink' must be specified")
+}
+
+func TestRepoIsValidBothUrls(t *testing.T) {
+	repo := Repo{
+		Id:       "a",
+		BaseUrl:  "https://example.com/a",
+		MetaLink: "https://example.com/metalink",
+	}
+
+	err := repo.IsValid()
+	assert.ErrorContains(t, err, "exactly one of 'baseUrl' or 'metaLink' must be specified")
+}
+
+func TestRepoIsValidBadGpgKey(t *testing.T) {
+	repo := Repo{
+		Id:      "a",
+		BaseUrl: "https://example.com/a",
+		GpgKeys: []string{"/etc/pki/rpm-gpg/key"},
+	}
+
+	err := repo.IsValid()
+	assert.ErrorContains(t, err, "invalid gpgKeys item at index 0")
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+const (
+	PortForwardProtocolTcp = "tcp"
+	PortForwardProtocolUdp = "udp"
+)
+
+// Run specifies how the `imagecustomizer run` command boots the output image in a QEMU VM. It doesn't affect the
+// customization of the image.
+type Run struct {
+	// MemoryMiB is the VM's memory size in MiB.
+	MemoryMiB int `yaml:"memoryMiB"`
+	// Cpus is the VM's number of CPUs.
+	Cpus int `yaml:"cpus"`
+	// Firmware is the path of the UEFI firmware (e.g. OVMF) to boot the image with. Required for images that boot
+	// using UEFI.
+	Firmware string `yaml:"firmware"`
+	// PortForwards are the host ports that are forwarded to the VM.
+	PortForwards []PortForward `yaml:"portForwards"`
+}
+
+// PortForward forwards a port of the host to a port of the VM.
+type PortForward struct {
+	// Protocol is either "tcp" (the default) or "udp".
+	Protocol string `yaml:"protocol"`
+	// HostPort is the port on the host (on localhost).
+	HostPort int `yaml:"hostPort"`
+	// GuestPort is the port within the VM.
+	GuestPort int `yaml:"guestPort"`
+}
+
+func (r *Run) IsValid() error {
+	if r.MemoryMiB < 0 {
+		return fmt.Errorf("invalid memoryMiB (%d): must not be negative", r.MemoryMiB)
+	}
+
+	if r.Cpus < 0 {
+		return fmt.Errorf("invalid cpus (%d): must not be negative", r.Cpus)
+	}
+
+	hostPorts := make(map[string]bool)
+	for i, portForward := range r.PortForwards {
+		err := portForward.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid portForwards item at index %d:\n%w", i, err)
+		}
+
+		hostPort := fmt.Sprintf("%s:%d", portForward.GetProtocol(), portForward.HostPort)
+		if hostPorts[hostPort] {
+			return fmt.Errorf("invalid portForwards item at index %d: duplicate host port (%s)", i, hostPort)
+		}
+		hostPorts[hostPort] = true
+	}
+
+	return nil
+}
+
+func (p *PortForward) IsValid() error {
+	switch p.Protocol {
+	case "", PortForwardProtocolTcp, PortForwardProtocolUdp:
+	default:
+		return fmt.Errorf("invalid protocol (%s): must be '%s' or '%s'", p.Protocol, PortForwardProtocolTcp,
+			PortForwardProtocolUdp)
+	}
+
+	err := validatePort(p.HostPort)
+	if err != nil {
+		return fmt.Errorf("invalid hostPort:\n%w", err)
+	}
+
+	err = validatePort(p.GuestPort)
+	if err != nil {
+		return fmt.Errorf("invalid guestPort:\n%w", err)
+	}
+
+	return nil
+}
+
+func (p *PortForward) GetProtocol() string {
+	if p.Protocol == "" {
+		return PortForwardProtocolTcp
+	}
+	return p.Protocol
+}
+
+func validatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port (%d) must be between 1 and 65535", port)
+	}
+	return nil
+}
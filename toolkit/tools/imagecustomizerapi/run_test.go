@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunIsValid(t *testing.T) {
+	run := Run{
+		MemoryMiB: 4096,
+		Cpus:      2,
+		PortForwards: []PortForward{
+			{HostPort: 2222, GuestPort: 22},
+			{Protocol: "udp", HostPort: 2222, GuestPort: 53},
+		},
+	}
+
+	err := run.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestRunIsValidNegativeMemory(t *testing.T) {
+	run := Run{
+		MemoryMiB: -1,
+	}
+
+	err := run.IsValid()
+	assert.ErrorContains(t, err, "invalid memoryMiB (-1): must not be negative")
+}
+
+func TestRunIsValidInvalidProtocol(t *testing.T) {
+	run := Run{
+		PortForwards: []PortForward{
+			{Protocol: "sctp", HostPort: 2222, GuestPort: 22},
+		},
+	}
+
+	err := run.IsValid()
+	assert.ErrorContains(t, err, "invalid portForwards item at index 0")
+	assert.ErrorContains(t, err, "invalid protocol (sctp)")
+}
+
+func TestRunIsValidInvalidPort(t *testing.T) {
+	run := Run{
+		PortForwards: []PortForward{
+			{HostPort: 2222, GuestPort: 70000},
+		},
+	}
+
+	err := run.IsValid()
+	assert.ErrorContains(t, err, "invalid guestPort")
+	assert.ErrorContains(t, err, "port (70000) must be between 1 and 65535")
+}
+
+func TestRunIsValidDuplicateHostPort(t *testing.T) {
+	run := Run{
+		PortForwards: []PortForward{
+			{HostPort: 8080, GuestPort: 80},
+			{Protocol: "tcp", HostPort: 8080, GuestPort: 8080},
+		},
+	}
+
+	err := run.IsValid()
+	assert.ErrorContains(t, err, "invalid portForwards item at index 1: duplicate host port (tcp:8080)")
+}
+
+func TestConfigIsValidRunInvalid(t *testing.T) {
+	config := &Config{
+		Run: &Run{
+			Cpus: -2,
+		},
+	}
+
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "invalid 'run' field")
+	assert.ErrorContains(t, err, "invalid cpus (-2): must not be negative")
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+)
+
+var (
+	// A systemd unit name prefix (e.g. "logrotate" or "backup-db"), which the ".service" and ".timer" suffixes are
+	// added to.
+	scheduledTaskNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]*$`)
+)
+
+// ScheduledTask is a command that is run on a schedule by a systemd timer.
+type ScheduledTask struct {
+	// Name is the name of the timer and service units, without the ".timer" and ".service" suffixes.
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Schedule is a systemd calendar event expression (e.g. "daily" or "Mon *-*-* 02:00:00").
+	Schedule string `yaml:"schedule"`
+	// Command is run by `/bin/sh`.
+	Command string `yaml:"command"`
+	// User is the user that the command is run as. By default, the command is run as root.
+	User string `yaml:"user"`
+	// Persistent runs the command on boot if a scheduled run was missed while the system was off.
+	Persistent bool `yaml:"persistent"`
+}
+
+func (t *ScheduledTask) IsValid() error {
+	if !scheduledTaskNameRegex.MatchString(t.Name) {
+		return fmt.Errorf("invalid name (%s): must only contain letters, digits, and the characters '_.:-', and "+
+			"must start with a letter or digit", t.Name)
+	}
+
+	if strings.HasSuffix(t.Name, ".service") || strings.HasSuffix(t.Name, ".timer") {
+		return fmt.Errorf("invalid name (%s): must not have a unit type suffix (.service or .timer)", t.Name)
+	}
+
+	if strings.ContainsAny(t.Description, "\n\r") {
+		return fmt.Errorf("invalid description (%s) of scheduled task (%s): must not contain newline characters",
+			t.Description, t.Name)
+	}
+
+	if strings.TrimSpace(t.Schedule) == "" {
+		return fmt.Errorf("invalid schedule of scheduled task (%s): schedule may not be empty", t.Name)
+	}
+
+	if strings.ContainsAny(t.Schedule, "\n\r") {
+		return fmt.Errorf("invalid schedule (%s) of scheduled task (%s): must not contain newline characters",
+			t.Schedule, t.Name)
+	}
+
+	if strings.TrimSpace(t.Command) == "" {
+		return fmt.Errorf("invalid command of scheduled task (%s): command may not be empty", t.Name)
+	}
+
+	if strings.ContainsAny(t.Command, "\n\r") {
+		return fmt.Errorf("invalid command of scheduled task (%s): must not contain newline characters (use a "+
+			"script file instead)", t.Name)
+	}
+
+	if t.User != "" {
+		err := userutils.NameIsValid(t.User)
+		if err != nil {
+			return fmt.Errorf("invalid user of scheduled task (%s):\n%w", t.Name, err)
+		}
+
+		if strings.ContainsAny(t.User, " \t\n\r") {
+			return fmt.Errorf("invalid user (%s) of scheduled task (%s): must not contain whitespace characters",
+				t.User, t.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledTaskIsValid(t *testing.T) {
+	scheduledTask := ScheduledTask{
+		Name:       "backup-db",
+		Schedule:   "Mon *-*-* 02:00:00",
+		Command:    "/usr/local/bin/backup --all",
+		User:       "backup",
+		Persistent: true,
+	}
+
+	err := scheduledTask.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestScheduledTaskIsValidBadName(t *testing.T) {
+	scheduledTask := ScheduledTask{
+		Name:     "backup db",
+		Schedule: "daily",
+		Command:  "backup",
+	}
+
+	err := scheduledTask.IsValid()
+	assert.ErrorContains(t, err, "invalid name (backup db)")
+}
+
+func TestScheduledTaskIsValidNameWithSuffix(t *testing.T) {
+	scheduledTask := ScheduledTask{
+		Name:     "backup.timer",
+		Schedule: "daily",
+		Command:  "backup",
+	}
+
+	err := scheduledTask.IsValid()
+	assert.ErrorContains(t, err, "invalid name (backup.timer): must not have a unit type suffix")
+}
+
+func TestScheduledTaskIsValidMissingSchedule(t *testing.T) {
+	scheduledTask := ScheduledTask{
+		Name:    "backup",
+		Command: "backup",
+	}
+
+	err := scheduledTask.IsValid()
+	assert.ErrorContains(t, err, "invalid schedule of scheduled task (backup): schedule may not be empty")
+}
+
+func TestScheduledTaskIsValidMultilineCommand(t *testing.T) {
+	scheduledTask := ScheduledTask{
+		Name:     "backup",
+		Schedule: "daily",
+		Command:  "backup\nrm -rf /tmp/backup",
+	}
+
+	err := scheduledTask.IsValid()
+	assert.ErrorContains(t, err, "invalid command of scheduled task (backup): must not contain newline characters")
+}
+
+func TestScheduledTaskIsValidBadUser(t *testing.T) {
+	scheduledTask := ScheduledTask{
+		Name:     "backup",
+		Schedule: "daily",
+		Command:  "backup",
+		User:     "bad user",
+	}
+
+	err := scheduledTask.IsValid()
+	assert.ErrorContains(t, err, "invalid user (bad user) of scheduled task (backup)")
+}
+
+func TestOSIsValidDuplicateScheduledTask(t *testing.T) {
+	os := OS{
+		ScheduledTasks: []ScheduledTask{
+			{Name: "backup", Schedule: "daily", Command: "backup"},
+			{Name: "backup", Schedule: "weekly", Command: "backup --full"},
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid scheduledTasks item at index 1:\nduplicate name (backup)")
+}
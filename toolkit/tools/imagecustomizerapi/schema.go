@@ -0,0 +1,273 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+)
+
+const (
+	jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	jsonSchemaDefsRef = "#/$defs/"
+
+	jsonSchemaTypeObject  = "object"
+	jsonSchemaTypeArray   = "array"
+	jsonSchemaTypeString  = "string"
+	jsonSchemaTypeInteger = "integer"
+	jsonSchemaTypeNumber  = "number"
+	jsonSchemaTypeBoolean = "boolean"
+)
+
+// jsonSchema is the subset of JSON Schema (draft 2020-12) that is needed to describe the config.
+type jsonSchema struct {
+	Schema      string   `json:"$schema,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Ref         string   `json:"$ref,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	// Properties are the known fields of an object.
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	// PatternProperties are the fields of an object whose names match a regex.
+	PatternProperties map[string]*jsonSchema `json:"patternProperties,omitempty"`
+	// AdditionalProperties is either false (unknown fields are not allowed) or the schema of the unknown fields.
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+var (
+	diskSizeJsonSchema = &jsonSchema{
+		Description: "<NUM>(K|M|G|T) (e.g. 100M, 1G)",
+		AnyOf: []*jsonSchema{
+			{Type: jsonSchemaTypeString, Pattern: diskSizeRegex.String()},
+			{Type: jsonSchemaTypeInteger},
+		},
+	}
+
+	// The values of the string enum types.
+	//
+	// The empty value (i.e. the default) is omitted, since it is the same as not specifying the field.
+	jsonSchemaStringEnums = map[reflect.Type][]string{
+		reflect.TypeOf(ResetBootLoaderType("")): {string(ResetBootLoaderTypeHard)},
+		reflect.TypeOf(BootType("")):            {string(BootTypeEfi), string(BootTypeLegacy)},
+		reflect.TypeOf(CloudInitSeedLocation("")): {string(CloudInitSeedLocationImage),
+			string(CloudInitSeedLocationIso)},
+		reflect.TypeOf(CorruptionOption("")): {string(CorruptionOptionIoError), string(CorruptionOptionIgnore),
+			string(CorruptionOptionPanic), string(CorruptionOptionRestart)},
+		reflect.TypeOf(FileSystemType("")): {string(FileSystemTypeExt4), string(FileSystemTypeXfs),
+			string(FileSystemTypeFat32), string(FileSystemTypeVfat)},
+		reflect.TypeOf(GpuVendor("")): {string(GpuVendorNvidia), string(GpuVendorAmd)},
+		reflect.TypeOf(IdType("")): {string(IdTypeId), string(IdTypePartLabel), string(IdTypeUuid),
+			string(IdTypePartUuid)},
+		reflect.TypeOf(ModuleLoadMode("")): {string(ModuleLoadModeAlways), string(ModuleLoadModeAuto),
+			string(ModuleLoadModeDisable), string(ModuleLoadModeInherit)},
+		reflect.TypeOf(MountIdentifierType("")): {string(MountIdentifierTypeUuid),
+			string(MountIdentifierTypePartUuid), string(MountIdentifierTypePartLabel)},
+		reflect.TypeOf(NetworkBondMode("")): {string(NetworkBondModeBalanceRr), string(NetworkBondModeActiveBackup),
+			string(NetworkBondModeBalanceXor), string(NetworkBondModeBroadcast), string(NetworkBondMode8023ad),
+			string(NetworkBondModeBalanceTlb), string(NetworkBondModeBalanceAlb)},
+		reflect.TypeOf(NetworkRenderer("")): {string(NetworkRendererNetworkd),
+			string(NetworkRendererNetworkManager)},
+		reflect.TypeOf(PartitionFlag("")): {string(PartitionFlagLegacyBoot), string(PartitionFlagHidden),
+			string(PartitionFlagNoAutomount)},
+		reflect.TypeOf(PartitionTableType("")): {string(PartitionTableTypeGpt)},
+		reflect.TypeOf(PartitionType("")): {string(PartitionTypeESP), string(PartitionTypeBiosGrub),
+			string(PartitionTypeLinuxGeneric), string(PartitionTypeRoot), string(PartitionTypeXbootldr),
+			string(PartitionTypeSwap), string(PartitionTypeHome), string(PartitionTypeSrv), string(PartitionTypeVar),
+			string(PartitionTypeTmp)},
+		reflect.TypeOf(PasswordType("")): {string(PasswordTypeLocked), string(PasswordTypePlainText),
+			string(PasswordTypeHashed), string(PasswordTypePlainTextFile), string(PasswordTypeHashedFile)},
+		reflect.TypeOf(ExistingReposMode("")): {string(ExistingReposModeKeep), string(ExistingReposModeDisable),
+			string(ExistingReposModeRemove)},
+		reflect.TypeOf(ResetPartitionsUuidsType("")): {string(ResetPartitionsUuidsTypeAll)},
+		reflect.TypeOf(SigningArtifactType("")): {string(SigningArtifactTypeShim),
+			string(SigningArtifactTypeBootloader), string(SigningArtifactTypeKernel)},
+		reflect.TypeOf(SELinuxMode("")): {string(SELinuxModeDisabled), string(SELinuxModeEnforcing),
+			string(SELinuxModePermissive), string(SELinuxModeForceEnforcing)},
+	}
+)
+
+// GenerateConfigJsonSchema returns the JSON Schema of the config file.
+// This can be used by editors to provide completion and validation of config files.
+func GenerateConfigJsonSchema() ([]byte, error) {
+	schema := generateJsonSchema(reflect.TypeOf(Config{}), false)
+	schema.Schema = jsonSchemaDialect
+	schema.Title = "Azure Linux Image Customizer config"
+
+	// Keys that are handled before the config is decoded.
+	for _, key := range []string{configIncludeKey, configFeaturesKey, configFeatureDirsKey} {
+		schema.Properties[key] = &jsonSchema{
+			Type:  jsonSchemaTypeArray,
+			Items: &jsonSchema{Type: jsonSchemaTypeString},
+		}
+	}
+	matrixSchema := generateJsonSchema(reflect.TypeOf(ConfigMatrix{}), false)
+	for name, def := range matrixSchema.Defs {
+		schema.Defs[name] = def
+	}
+	matrixSchema.Defs = nil
+	schema.Properties[configMatrixKey] = matrixSchema
+
+	// The config fragments of the matrix are config files themselves.
+	for _, name := range []string{"MatrixAxisValue", "MatrixOverride"} {
+		schema.Defs[name].Properties["config"] = &jsonSchema{Ref: "#"}
+	}
+
+	schema.PatternProperties = map[string]*jsonSchema{
+		"^" + regexp.QuoteMeta(yamlExtensionKeyPrefix): {},
+	}
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// generateJsonSchema generates the JSON schema of a type. The root type is inlined and all the other struct types are
+// placed in $defs.
+//
+// If allowCustomTypes is true, then the types that implement yaml.Unmarshaler accept any value.
+func generateJsonSchema(valueType reflect.Type, allowCustomTypes bool) *jsonSchema {
+	for valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+
+	g := &jsonSchemaGenerator{
+		defs:             make(map[string]*jsonSchema),
+		allowCustomTypes: allowCustomTypes,
+	}
+
+	var schema *jsonSchema
+	if valueType.Kind() == reflect.Struct && g.generateCustom(valueType) == nil {
+		schema = g.generateStruct(valueType, valueType.Name())
+	} else {
+		schema = g.generate(valueType)
+	}
+
+	if len(g.defs) > 0 {
+		schema.Defs = g.defs
+	}
+
+	return schema
+}
+
+type jsonSchemaGenerator struct {
+	defs             map[string]*jsonSchema
+	allowCustomTypes bool
+}
+
+func (g *jsonSchemaGenerator) generate(valueType reflect.Type) *jsonSchema {
+	for valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+
+	if schema := g.generateCustom(valueType); schema != nil {
+		if g.allowCustomTypes {
+			return &jsonSchema{}
+		}
+		return schema
+	}
+
+	if values, ok := jsonSchemaStringEnums[valueType]; ok {
+		return &jsonSchema{Type: jsonSchemaTypeString, Enum: values}
+	}
+
+	if valueType == yamlNodeType || valueType.Implements(yamlUnmarshalerType) ||
+		reflect.PointerTo(valueType).Implements(yamlUnmarshalerType) {
+		// Unknown custom format. So, allow anything.
+		return &jsonSchema{}
+	}
+
+	switch valueType.Kind() {
+	case reflect.Struct:
+		name := valueType.Name()
+		if _, found := g.defs[name]; !found {
+			// Add a placeholder first, in case the type references itself.
+			g.defs[name] = nil
+			g.defs[name] = g.generateStruct(valueType, name)
+		}
+		return &jsonSchema{Ref: jsonSchemaDefsRef + name}
+
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: jsonSchemaTypeArray, Items: g.generate(valueType.Elem())}
+
+	case reflect.Map:
+		return &jsonSchema{Type: jsonSchemaTypeObject, AdditionalProperties: g.generate(valueType.Elem())}
+
+	case reflect.String:
+		return &jsonSchema{Type: jsonSchemaTypeString}
+
+	case reflect.Bool:
+		return &jsonSchema{Type: jsonSchemaTypeBoolean}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: jsonSchemaTypeInteger}
+
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: jsonSchemaTypeNumber}
+
+	default:
+		return &jsonSchema{}
+	}
+}
+
+// generateCustom returns the JSON schema of a type that implements yaml.Unmarshaler. Returns nil for all other types.
+func (g *jsonSchemaGenerator) generateCustom(valueType reflect.Type) *jsonSchema {
+	switch valueType {
+	case reflect.TypeOf(DiskSize(0)):
+		return diskSizeJsonSchema
+
+	case reflect.TypeOf(PartitionSize{}):
+		return &jsonSchema{
+			Description: "grow | <NUM>(K|M|G|T) (e.g. grow, 100M, 1G)",
+			AnyOf: []*jsonSchema{
+				{Type: jsonSchemaTypeString, Enum: []string{PartitionSizeGrow}},
+				diskSizeJsonSchema,
+			},
+		}
+
+	case reflect.TypeOf(FilePermissions(0)):
+		return &jsonSchema{
+			Description: "octal string (e.g. 660)",
+			Type:        jsonSchemaTypeString,
+			Pattern:     `^[0-7]+$`,
+		}
+
+	case reflect.TypeOf(MountPoint{}):
+		// MountPoint accepts either just the path or the full object.
+		return &jsonSchema{
+			Description: "path string or MountPoint object",
+			AnyOf: []*jsonSchema{
+				{Type: jsonSchemaTypeString},
+				g.generateStruct(valueType, valueType.Name()),
+			},
+		}
+
+	default:
+		return nil
+	}
+}
+
+func (g *jsonSchemaGenerator) generateStruct(structType reflect.Type, name string) *jsonSchema {
+	fields, hasInlineMap := getYamlStructFields(structType)
+
+	schema := &jsonSchema{
+		Title:      name,
+		Type:       jsonSchemaTypeObject,
+		Properties: make(map[string]*jsonSchema, len(fields)),
+	}
+
+	for fieldName, fieldType := range fields {
+		schema.Properties[fieldName] = g.generate(fieldType)
+	}
+
+	if !hasInlineMap {
+		schema.AdditionalProperties = false
+	}
+
+	return schema
+}
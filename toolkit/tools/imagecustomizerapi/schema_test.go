@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateConfigJsonSchema(t *testing.T) {
+	schemaJson, err := GenerateConfigJsonSchema()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var schema map[string]any
+	err = json.Unmarshal(schemaJson, &schema)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, jsonSchemaDialect, schema["$schema"])
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, false, schema["additionalProperties"])
+	assert.Contains(t, schema["patternProperties"], "^x-")
+
+	properties := schema["properties"].(map[string]any)
+	assert.Contains(t, properties, "os")
+	assert.Contains(t, properties, "storage")
+	assert.Contains(t, properties, "include")
+	assert.Contains(t, properties, "features")
+	assert.Contains(t, properties, "featureDirs")
+	assert.Contains(t, properties, "matrix")
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/OS"}, properties["os"])
+
+	defs := schema["$defs"].(map[string]any)
+	osSchema := defs["OS"].(map[string]any)
+	osProperties := osSchema["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"type": "string"}, osProperties["hostname"])
+
+	matrixValueSchema := defs["MatrixAxisValue"].(map[string]any)
+	matrixValueProperties := matrixValueSchema["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"$ref": "#"}, matrixValueProperties["config"])
+
+	selinuxSchema := defs["SELinux"].(map[string]any)
+	assert.Equal(t, map[string]any{
+		"type": "string",
+		"enum": []any{"disabled", "enforcing", "permissive", "force-enforcing"},
+	}, selinuxSchema["properties"].(map[string]any)["mode"])
+
+	gpuSchema := defs["Gpu"].(map[string]any)
+	assert.Equal(t, map[string]any{
+		"type": "string",
+		"enum": []any{"nvidia", "amd"},
+	}, gpuSchema["properties"].(map[string]any)["vendor"])
+
+	fileSystemSchema := defs["FileSystem"].(map[string]any)
+	mountPointSchema := fileSystemSchema["properties"].(map[string]any)["mountPoint"].(map[string]any)
+	assert.Len(t, mountPointSchema["anyOf"], 2)
+
+	// All referenced types must be defined.
+	for name, def := range defs {
+		assert.NotNil(t, def, name)
+	}
+}
+
+func TestJsonSchemaStringEnumsAreValid(t *testing.T) {
+	for enumType, values := range jsonSchemaStringEnums {
+		for _, value := range values {
+			enumValue := reflect.New(enumType)
+			enumValue.Elem().SetString(value)
+
+			err := enumValue.Interface().(HasIsValid).IsValid()
+			assert.NoError(t, err, "%s (%s)", enumType, value)
+		}
+
+		enumValue := reflect.New(enumType)
+		enumValue.Elem().SetString("bogus")
+
+		err := enumValue.Interface().(HasIsValid).IsValid()
+		assert.Error(t, err, "%s", enumType)
+	}
+}
@@ -5,6 +5,7 @@ package imagecustomizerapi
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Script struct {
@@ -24,6 +25,18 @@ type Script struct {
 	EnvironmentVariables map[string]string `yaml:"environmentVariables"`
 	// Name is an optional value used to reference the script in the logs.
 	Name string `yaml:"name"`
+	// Order controls the order that the scripts within a phase are run in.
+	// Scripts are run in ascending order. Scripts with the same order are run in the order they are listed.
+	Order int `yaml:"order"`
+	// Container runs the script inside an OCI container on the build host, instead of within the image, with the
+	// image's root directory bind mounted at '/target'.
+	Container *ScriptContainer `yaml:"container"`
+}
+
+// ScriptContainer specifies the container that a script is run in.
+type ScriptContainer struct {
+	// Image is the reference of the OCI container image (e.g. "mcr.microsoft.com/azurelinux/base/python:3").
+	Image string `yaml:"image"`
 }
 
 func (s *Script) IsValid() error {
@@ -34,5 +47,30 @@ func (s *Script) IsValid() error {
 		return fmt.Errorf("path and content may not both have a value")
 	}
 
+	for name := range s.EnvironmentVariables {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return fmt.Errorf("invalid environmentVariables name (%s)", name)
+		}
+	}
+
+	if s.Container != nil {
+		err := s.Container.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid container:\n%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *ScriptContainer) IsValid() error {
+	if c.Image == "" {
+		return fmt.Errorf("must specify 'image'")
+	}
+
+	if strings.ContainsAny(c.Image, " \t\n") || strings.HasPrefix(c.Image, "-") {
+		return fmt.Errorf("invalid image (%s)", c.Image)
+	}
+
 	return nil
 }
@@ -25,3 +25,42 @@ func TestScriptIsValidBothPathAndContent(t *testing.T) {
 	err := script.IsValid()
 	assert.ErrorContains(t, err, "path and content may not both have a value")
 }
+
+func TestScriptIsValidBadEnvironmentVariableName(t *testing.T) {
+	script := Script{
+		Path: "a.sh",
+		EnvironmentVariables: map[string]string{
+			"a=b": "c",
+		},
+	}
+	err := script.IsValid()
+	assert.ErrorContains(t, err, "invalid environmentVariables name (a=b)")
+}
+
+func TestScriptIsValidContainer(t *testing.T) {
+	script := Script{
+		Content:   "npm ci --prefix /target/opt/app",
+		Container: &ScriptContainer{Image: "mcr.microsoft.com/azurelinux/base/nodejs:20"},
+	}
+	err := script.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestScriptIsValidContainerMissingImage(t *testing.T) {
+	script := Script{
+		Path:      "a.sh",
+		Container: &ScriptContainer{},
+	}
+	err := script.IsValid()
+	assert.ErrorContains(t, err, "invalid container")
+	assert.ErrorContains(t, err, "must specify 'image'")
+}
+
+func TestScriptIsValidContainerBadImage(t *testing.T) {
+	script := Script{
+		Path:      "a.sh",
+		Container: &ScriptContainer{Image: "--privileged"},
+	}
+	err := script.IsValid()
+	assert.ErrorContains(t, err, "invalid image (--privileged)")
+}
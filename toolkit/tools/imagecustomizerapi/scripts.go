@@ -5,14 +5,35 @@ package imagecustomizerapi
 
 import (
 	"fmt"
+	"path/filepath"
 )
 
 type Scripts struct {
+	PostPackageInstall    []Script `yaml:"postPackageInstall"`
+	PostConfig            []Script `yaml:"postConfig"`
 	PostCustomization     []Script `yaml:"postCustomization"`
 	FinalizeCustomization []Script `yaml:"finalizeCustomization"`
+	FinalizeOutsideChroot []Script `yaml:"finalizeOutsideChroot"`
+	// OutputArtifactsDir is a directory on the build host that scripts can write files to.
+	// Relative paths are relative to the config file's directory.
+	OutputArtifactsDir string `yaml:"outputArtifactsDir"`
 }
 
 func (s *Scripts) IsValid() error {
+	for i, script := range s.PostPackageInstall {
+		err := script.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid postPackageInstall script at index %d:\n%w", i, err)
+		}
+	}
+
+	for i, script := range s.PostConfig {
+		err := script.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid postConfig script at index %d:\n%w", i, err)
+		}
+	}
+
 	for i, script := range s.PostCustomization {
 		err := script.IsValid()
 		if err != nil {
@@ -27,5 +48,25 @@ func (s *Scripts) IsValid() error {
 		}
 	}
 
+	for i, script := range s.FinalizeOutsideChroot {
+		err := script.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid finalizeOutsideChroot script at index %d:\n%w", i, err)
+		}
+	}
+
+	if s.OutputArtifactsDir != "" && filepath.Clean(s.OutputArtifactsDir) == "/" {
+		return fmt.Errorf("invalid outputArtifactsDir (%s): must not be the root directory", s.OutputArtifactsDir)
+	}
+
 	return nil
 }
+
+// HasScripts returns true if any of the script phases contain scripts.
+func (s *Scripts) HasScripts() bool {
+	return len(s.PostPackageInstall) > 0 ||
+		len(s.PostConfig) > 0 ||
+		len(s.PostCustomization) > 0 ||
+		len(s.FinalizeCustomization) > 0 ||
+		len(s.FinalizeOutsideChroot) > 0
+}
@@ -50,3 +50,39 @@ func TestScriptsInvalidFinalizeCustomization(t *testing.T) {
 	assert.ErrorContains(t, err, "invalid finalizeCustomization script at index 0")
 	assert.ErrorContains(t, err, "path and content may not both have a value")
 }
+
+func TestScriptsInvalidPostPackageInstall(t *testing.T) {
+	scripts := Scripts{
+		PostPackageInstall: []Script{
+			{},
+		},
+	}
+	err := scripts.IsValid()
+	assert.ErrorContains(t, err, "invalid postPackageInstall script at index 0")
+}
+
+func TestScriptsInvalidFinalizeOutsideChroot(t *testing.T) {
+	scripts := Scripts{
+		FinalizeOutsideChroot: []Script{
+			{},
+		},
+	}
+	err := scripts.IsValid()
+	assert.ErrorContains(t, err, "invalid finalizeOutsideChroot script at index 0")
+}
+
+func TestScriptsInvalidOutputArtifactsDir(t *testing.T) {
+	scripts := Scripts{
+		OutputArtifactsDir: "/",
+	}
+	err := scripts.IsValid()
+	assert.ErrorContains(t, err, "invalid outputArtifactsDir (/)")
+}
+
+func TestScriptsHasScripts(t *testing.T) {
+	scripts := Scripts{}
+	assert.False(t, scripts.HasScripts())
+
+	scripts.PostConfig = []Script{{Content: "echo hello"}}
+	assert.True(t, scripts.HasScripts())
+}
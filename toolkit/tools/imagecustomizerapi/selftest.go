@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultSelfTestServiceTimeoutSeconds = 300
+)
+
+// SelfTest adds a self-test to the image that runs on the first boot and checks that the image matches its config
+// (e.g. the enabled services are running and the file systems are mounted).
+type SelfTest struct {
+	// ServiceTimeoutSeconds is how long to wait for the enabled services to start.
+	ServiceTimeoutSeconds *int `yaml:"serviceTimeoutSeconds"`
+	// Commands are additional checks. Each command is run by `/bin/sh` and passes if it exits with 0.
+	Commands []string `yaml:"commands"`
+}
+
+func (s *SelfTest) IsValid() error {
+	if s.ServiceTimeoutSeconds != nil && *s.ServiceTimeoutSeconds <= 0 {
+		return fmt.Errorf("invalid serviceTimeoutSeconds (%d): must be greater than 0", *s.ServiceTimeoutSeconds)
+	}
+
+	for i, command := range s.Commands {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("invalid commands item at index %d: command may not be empty", i)
+		}
+	}
+
+	return nil
+}
+
+func (s *SelfTest) GetServiceTimeoutSeconds() int {
+	if s.ServiceTimeoutSeconds == nil {
+		return DefaultSelfTestServiceTimeoutSeconds
+	}
+	return *s.ServiceTimeoutSeconds
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfTestIsValid(t *testing.T) {
+	selfTest := SelfTest{
+		ServiceTimeoutSeconds: ptrutils.PtrTo(60),
+		Commands:              []string{"test -f /etc/app.conf"},
+	}
+
+	err := selfTest.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, 60, selfTest.GetServiceTimeoutSeconds())
+}
+
+func TestSelfTestIsValidDefaultTimeout(t *testing.T) {
+	selfTest := SelfTest{}
+
+	err := selfTest.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultSelfTestServiceTimeoutSeconds, selfTest.GetServiceTimeoutSeconds())
+}
+
+func TestSelfTestIsValidBadTimeout(t *testing.T) {
+	selfTest := SelfTest{
+		ServiceTimeoutSeconds: ptrutils.PtrTo(0),
+	}
+
+	err := selfTest.IsValid()
+	assert.ErrorContains(t, err, "invalid serviceTimeoutSeconds (0): must be greater than 0")
+}
+
+func TestSelfTestIsValidEmptyCommand(t *testing.T) {
+	selfTest := SelfTest{
+		Commands: []string{"true", " "},
+	}
+
+	err := selfTest.IsValid()
+	assert.ErrorContains(t, err, "invalid commands item at index 1: command may not be empty")
+}
+
+func TestOSIsValidSelfTest(t *testing.T) {
+	os := OS{
+		SelfTest: &SelfTest{
+			Commands: []string{""},
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid selfTest")
+}
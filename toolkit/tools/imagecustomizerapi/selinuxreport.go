@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// SELinuxReport enables recording the SELinux labels of the OS's files, and the labels they would get if the files
+// were relabeled, into a JSON report.
+type SELinuxReport struct {
+	// ExcludePaths is a list of directories, within the OS image, to leave out of the report.
+	ExcludePaths []string `yaml:"excludePaths"`
+}
+
+func (s *SELinuxReport) IsValid() error {
+	for _, excludePath := range s.ExcludePaths {
+		if !filepath.IsAbs(excludePath) || filepath.Clean(excludePath) != excludePath {
+			return fmt.Errorf("invalid excludePaths value (%s): must be a clean absolute path", excludePath)
+		}
+
+		if excludePath == "/" {
+			return fmt.Errorf("invalid excludePaths value (%s): cannot exclude the root directory", excludePath)
+		}
+	}
+
+	return nil
+}
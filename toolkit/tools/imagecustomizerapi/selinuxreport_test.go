@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSELinuxReportIsValid(t *testing.T) {
+	selinuxReport := SELinuxReport{
+		ExcludePaths: []string{"/home", "/var/cache"},
+	}
+
+	err := selinuxReport.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestSELinuxReportIsValidEmpty(t *testing.T) {
+	selinuxReport := SELinuxReport{}
+
+	err := selinuxReport.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestSELinuxReportIsValidRelativeExcludePath(t *testing.T) {
+	selinuxReport := SELinuxReport{
+		ExcludePaths: []string{"home"},
+	}
+
+	err := selinuxReport.IsValid()
+	assert.ErrorContains(t, err, "invalid excludePaths value (home)")
+}
+
+func TestSELinuxReportIsValidUncleanExcludePath(t *testing.T) {
+	selinuxReport := SELinuxReport{
+		ExcludePaths: []string{"/var/../home/"},
+	}
+
+	err := selinuxReport.IsValid()
+	assert.ErrorContains(t, err, "invalid excludePaths value (/var/../home/)")
+}
+
+func TestSELinuxReportIsValidRootExcludePath(t *testing.T) {
+	selinuxReport := SELinuxReport{
+		ExcludePaths: []string{"/"},
+	}
+
+	err := selinuxReport.IsValid()
+	assert.ErrorContains(t, err, "cannot exclude the root directory")
+}
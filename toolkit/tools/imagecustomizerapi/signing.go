@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Signing configures the secure boot signing of the image's boot artifacts.
+//
+// The boot artifacts are exported from the image, signed by a command, a signing service, or a signing provider
+// plugin, and then the signed artifacts are written back into the image.
+type Signing struct {
+	// Artifacts are the types of boot artifacts to sign. Defaults to all the types.
+	Artifacts []SigningArtifactType `yaml:"artifacts"`
+	// Command is a script, run on the build host, that signs the artifacts.
+	// Mutually exclusive with 'Endpoint' and 'Provider'.
+	Command *Script `yaml:"command"`
+	// Endpoint is a signing service that signs the artifacts.
+	// Mutually exclusive with 'Command' and 'Provider'.
+	Endpoint *SigningEndpoint `yaml:"endpoint"`
+	// Provider is the name of a signing provider, supplied by a plugin, that signs the artifacts.
+	// Mutually exclusive with 'Command' and 'Endpoint'.
+	Provider string `yaml:"provider"`
+}
+
+// SigningEndpoint is a signing service.
+//
+// Each artifact is sent in the body of a POST request and the response's body is the signed artifact.
+type SigningEndpoint struct {
+	// Url is the URL of the signing service.
+	Url string `yaml:"url"`
+	// BearerTokenEnvironmentVariable is the name of a build host environment variable that holds the bearer token to
+	// authenticate to the signing service with.
+	BearerTokenEnvironmentVariable string `yaml:"bearerTokenEnvironmentVariable"`
+}
+
+func (s *Signing) IsValid() error {
+	for i, artifactType := range s.Artifacts {
+		err := artifactType.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid artifacts item at index %d:\n%w", i, err)
+		}
+	}
+
+	signers := 0
+	for _, isSet := range []bool{s.Command != nil, s.Endpoint != nil, s.Provider != ""} {
+		if isSet {
+			signers++
+		}
+	}
+
+	if signers != 1 {
+		return fmt.Errorf("exactly one of 'command', 'endpoint', or 'provider' must be specified")
+	}
+
+	if s.Command != nil {
+		err := s.Command.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'command' field:\n%w", err)
+		}
+	}
+
+	if s.Endpoint != nil {
+		err := s.Endpoint.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid 'endpoint' field:\n%w", err)
+		}
+	}
+
+	return nil
+}
+
+// ArtifactTypes returns the types of boot artifacts to sign.
+func (s *Signing) ArtifactTypes() []SigningArtifactType {
+	if len(s.Artifacts) <= 0 {
+		return []SigningArtifactType{SigningArtifactTypeShim, SigningArtifactTypeBootloader,
+			SigningArtifactTypeKernel}
+	}
+
+	return s.Artifacts
+}
+
+func (e *SigningEndpoint) IsValid() error {
+	endpointUrl, err := url.Parse(e.Url)
+	if err != nil {
+		return fmt.Errorf("invalid url (%s):\n%w", e.Url, err)
+	}
+
+	if (endpointUrl.Scheme != "https" && endpointUrl.Scheme != "http") || endpointUrl.Host == "" {
+		return fmt.Errorf("invalid url (%s): must be an http or https URL", e.Url)
+	}
+
+	if strings.ContainsAny(e.BearerTokenEnvironmentVariable, "= \t\n") {
+		return fmt.Errorf("invalid bearerTokenEnvironmentVariable (%s)", e.BearerTokenEnvironmentVariable)
+	}
+
+	// Don't send the token in the clear.
+	if e.BearerTokenEnvironmentVariable != "" && endpointUrl.Scheme != "https" {
+		return fmt.Errorf("invalid url (%s): must be an https URL when bearerTokenEnvironmentVariable is set", e.Url)
+	}
+
+	return nil
+}
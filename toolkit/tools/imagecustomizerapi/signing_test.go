@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningIsValidCommand(t *testing.T) {
+	signing := Signing{
+		Artifacts: []SigningArtifactType{SigningArtifactTypeKernel},
+		Command:   &Script{Path: "sign.sh"},
+	}
+
+	err := signing.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, []SigningArtifactType{SigningArtifactTypeKernel}, signing.ArtifactTypes())
+}
+
+func TestSigningIsValidEndpoint(t *testing.T) {
+	signing := Signing{
+		Endpoint: &SigningEndpoint{
+			Url:                            "https://signer.example.com/sign",
+			BearerTokenEnvironmentVariable: "SIGNER_TOKEN",
+		},
+	}
+
+	err := signing.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, []SigningArtifactType{SigningArtifactTypeShim, SigningArtifactTypeBootloader,
+		SigningArtifactTypeKernel}, signing.ArtifactTypes())
+}
+
+func TestSigningIsValidNoSigner(t *testing.T) {
+	signing := Signing{}
+
+	err := signing.IsValid()
+	assert.ErrorContains(t, err, "exactly one of 'command', 'endpoint', or 'provider' must be specified")
+}
+
+func TestSigningIsValidBothSigners(t *testing.T) {
+	signing := Signing{
+		Command:  &Script{Path: "sign.sh"},
+		Endpoint: &SigningEndpoint{Url: "https://signer.example.com/sign"},
+	}
+
+	err := signing.IsValid()
+	assert.ErrorContains(t, err, "exactly one of 'command', 'endpoint', or 'provider' must be specified")
+}
+
+func TestSigningIsValidProvider(t *testing.T) {
+	signing := Signing{
+		Provider: "hsm",
+	}
+
+	err := signing.IsValid()
+	assert.NoError(t, err)
+
+	signing.Command = &Script{Path: "sign.sh"}
+
+	err = signing.IsValid()
+	assert.ErrorContains(t, err, "exactly one of 'command', 'endpoint', or 'provider' must be specified")
+}
+
+func TestSigningIsValidBadArtifact(t *testing.T) {
+	signing := Signing{
+		Artifacts: []SigningArtifactType{SigningArtifactTypeShim, "initrd"},
+		Command:   &Script{Path: "sign.sh"},
+	}
+
+	err := signing.IsValid()
+	assert.ErrorContains(t, err, "invalid artifacts item at index 1")
+	assert.ErrorContains(t, err, "invalid signing artifact type (initrd)")
+}
+
+func TestSigningIsValidBadCommand(t *testing.T) {
+	signing := Signing{
+		Command: &Script{},
+	}
+
+	err := signing.IsValid()
+	assert.ErrorContains(t, err, "invalid 'command' field")
+	assert.ErrorContains(t, err, "either path or content must have a value")
+}
+
+func TestSigningIsValidBadUrl(t *testing.T) {
+	signing := Signing{
+		Endpoint: &SigningEndpoint{Url: "signer.example.com/sign"},
+	}
+
+	err := signing.IsValid()
+	assert.ErrorContains(t, err, "invalid 'endpoint' field")
+	assert.ErrorContains(t, err, "must be an http or https URL")
+}
+
+func TestSigningIsValidBearerTokenOverHttp(t *testing.T) {
+	signing := Signing{
+		Endpoint: &SigningEndpoint{
+			Url:                            "http://signer.example.com/sign",
+			BearerTokenEnvironmentVariable: "SIGNER_TOKEN",
+		},
+	}
+
+	err := signing.IsValid()
+	assert.ErrorContains(t, err, "invalid 'endpoint' field")
+	assert.ErrorContains(t, err, "must be an https URL when bearerTokenEnvironmentVariable is set")
+
+	signing.Endpoint.BearerTokenEnvironmentVariable = ""
+	err = signing.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestConfigIsValidSigning(t *testing.T) {
+	config := &Config{
+		Signing: &Signing{},
+	}
+
+	err := config.IsValid()
+	assert.ErrorContains(t, err, "invalid 'signing' field")
+}
+
+func TestUnmarshalYamlSigning(t *testing.T) {
+	yamlString := `
+signing:
+  artifacts: [shim, kernel]
+  command:
+    path: sign.sh
+    arguments: [--key, db.key]
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.Signing) && assert.NotNil(t, config.Signing.Command) {
+		assert.Equal(t, []SigningArtifactType{SigningArtifactTypeShim, SigningArtifactTypeKernel},
+			config.Signing.Artifacts)
+		assert.Equal(t, "sign.sh", config.Signing.Command.Path)
+		assert.Equal(t, []string{"--key", "db.key"}, config.Signing.Command.Arguments)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+)
+
+// SigningArtifactType is a type of boot artifact that requires a secure boot signature.
+type SigningArtifactType string
+
+const (
+	// SigningArtifactTypeShim is the shim, which is the first stage bootloader (e.g. /boot/efi/EFI/BOOT/bootx64.efi).
+	SigningArtifactTypeShim SigningArtifactType = "shim"
+	// SigningArtifactTypeBootloader is the grub bootloader (e.g. /boot/efi/EFI/BOOT/grubx64.efi).
+	SigningArtifactTypeBootloader SigningArtifactType = "bootloader"
+	// SigningArtifactTypeKernel is the kernel (e.g. /boot/vmlinuz-6.6.51.1-5.azl3).
+	SigningArtifactTypeKernel SigningArtifactType = "kernel"
+)
+
+func (t SigningArtifactType) IsValid() error {
+	switch t {
+	case SigningArtifactTypeShim, SigningArtifactTypeBootloader, SigningArtifactTypeKernel:
+		// All good.
+		return nil
+
+	default:
+		return fmt.Errorf("invalid signing artifact type (%s)", t)
+	}
+}
@@ -13,6 +13,9 @@ type Storage struct {
 	Disks                    []Disk                   `yaml:"disks"`
 	FileSystems              []FileSystem             `yaml:"filesystems"`
 	Verity                   []Verity                 `yaml:"verity"`
+	EncryptedVolumes         []EncryptedVolume        `yaml:"encryptedVolumes"`
+	AbUpdate                 *AbUpdate                `yaml:"abUpdate"`
+	RawBlobs                 []RawBlob                `yaml:"rawBlobs"`
 }
 
 func (s *Storage) IsValid() error {
@@ -32,6 +35,20 @@ func (s *Storage) IsValid() error {
 		return fmt.Errorf("defining multiple disks is not currently supported")
 	}
 
+	if s.AbUpdate != nil {
+		err = s.AbUpdate.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid abUpdate:\n%w", err)
+		}
+
+		// Generate slot B's partition before the disk is validated, so that the partition layout is validated with
+		// it included.
+		err = s.addAbUpdateSlotB()
+		if err != nil {
+			return err
+		}
+	}
+
 	for i := range s.Disks {
 		disk := &s.Disks[i]
 
@@ -48,6 +65,13 @@ func (s *Storage) IsValid() error {
 		}
 	}
 
+	for i, encryptedVolume := range s.EncryptedVolumes {
+		err = encryptedVolume.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid encryptedVolumes item at index %d:\n%w", i, err)
+		}
+	}
+
 	for i, fileSystem := range s.FileSystems {
 		err = fileSystem.IsValid()
 		if err != nil {
@@ -55,11 +79,20 @@ func (s *Storage) IsValid() error {
 		}
 	}
 
+	for i, rawBlob := range s.RawBlobs {
+		err = rawBlob.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid rawBlobs item at index %d:\n%w", i, err)
+		}
+	}
+
 	hasResetUuids := s.ResetPartitionsUuidsType != ResetPartitionsUuidsTypeDefault
 	hasBootType := s.BootType != BootTypeNone
 	hasDisks := len(s.Disks) > 0
 	hasFileSystems := len(s.FileSystems) > 0
 	hasVerity := len(s.Verity) > 0
+	hasEncryptedVolumes := len(s.EncryptedVolumes) > 0
+	hasRawBlobs := len(s.RawBlobs) > 0
 
 	if hasResetUuids && hasDisks {
 		return fmt.Errorf("cannot specify both 'resetPartitionsUuidsType' and 'disks'")
@@ -81,6 +114,14 @@ func (s *Storage) IsValid() error {
 		return fmt.Errorf("cannot specify 'verity' without specifying 'disks'")
 	}
 
+	if hasEncryptedVolumes && !hasDisks {
+		return fmt.Errorf("cannot specify 'encryptedVolumes' without specifying 'disks'")
+	}
+
+	if hasRawBlobs && !hasDisks {
+		return fmt.Errorf("cannot specify 'rawBlobs' without specifying 'disks'")
+	}
+
 	// Create a set of all block devices by their Id.
 	deviceMap, partitionLabelCounts, err := s.buildDeviceMap()
 	if err != nil {
@@ -93,6 +134,13 @@ func (s *Storage) IsValid() error {
 		return err
 	}
 
+	for i := range s.RawBlobs {
+		err = checkRawBlobPartition(&s.RawBlobs[i], deviceMap, deviceParents)
+		if err != nil {
+			return fmt.Errorf("invalid rawBlobs item at index %d:\n%w", i, err)
+		}
+	}
+
 	espPartitionExists := false
 	biosBootPartitionExists := false
 
@@ -160,6 +208,41 @@ func (s *Storage) IsValid() error {
 		}
 	}
 
+	// Validate encrypted volume settings.
+	mapperNames := make(map[string]bool)
+	for i := range s.EncryptedVolumes {
+		encryptedVolume := &s.EncryptedVolumes[i]
+
+		if mapperNames[encryptedVolume.Name] {
+			return fmt.Errorf("duplicate encrypted volume 'name' (%s)", encryptedVolume.Name)
+		}
+		mapperNames[encryptedVolume.Name] = true
+
+		for _, verity := range s.Verity {
+			if verity.Name == encryptedVolume.Name {
+				return fmt.Errorf("encrypted volume 'name' (%s) is also used by a verity device", encryptedVolume.Name)
+			}
+		}
+
+		filesystem, hasFileSystem := deviceParents[encryptedVolume.Id].(*FileSystem)
+		if !hasFileSystem || filesystem.Type == FileSystemTypeNone {
+			return fmt.Errorf("encrypted volume (%s) must have a filesystem with a 'type'", encryptedVolume.Id)
+		}
+
+		encryptedVolume.FileSystem = filesystem
+
+		// The volumes are unlocked using a key file that is stored on the root filesystem until the first boot
+		// replaces it (and, on machines without a TPM2, for good). So, the root filesystem and the partitions needed to boot to it
+		// can't be encrypted.
+		if filesystem.MountPoint != nil {
+			switch filesystem.MountPoint.Path {
+			case "/", "/boot", "/boot/efi", "/usr":
+				return fmt.Errorf("encrypting the (%s) filesystem is not currently supported",
+					filesystem.MountPoint.Path)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -197,6 +280,17 @@ func (s *Storage) buildDeviceMap() (map[string]any, map[string]int, error) {
 		deviceMap[verity.Id] = verity
 	}
 
+	for i := range s.EncryptedVolumes {
+		encryptedVolume := &s.EncryptedVolumes[i]
+
+		if _, existingName := deviceMap[encryptedVolume.Id]; existingName {
+			return nil, nil, fmt.Errorf("invalid encryptedVolumes item at index %d:\nduplicate id (%s)", i,
+				encryptedVolume.Id)
+		}
+
+		deviceMap[encryptedVolume.Id] = encryptedVolume
+	}
+
 	return deviceMap, partitionLabelCounts, nil
 }
 
@@ -213,6 +307,15 @@ func (s *Storage) checkDeviceTree(deviceMap map[string]any, partitionLabelCounts
 		}
 	}
 
+	for i := range s.EncryptedVolumes {
+		encryptedVolume := &s.EncryptedVolumes[i]
+
+		err := checkDeviceTreeEncryptedVolumeItem(encryptedVolume, deviceMap, deviceParents)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryptedVolumes item at index %d:\n%w", i, err)
+		}
+	}
+
 	mountPaths := make(map[string]bool)
 	for i := range s.FileSystems {
 		filesystem := &s.FileSystems[i]
@@ -257,6 +360,24 @@ func addVerityParentToDevice(deviceId string, deviceMap map[string]any, devicePa
 	return nil
 }
 
+func checkDeviceTreeEncryptedVolumeItem(encryptedVolume *EncryptedVolume, deviceMap map[string]any,
+	deviceParents map[string]any,
+) error {
+	device, err := addParentToDevice(encryptedVolume.DeviceId, deviceMap, deviceParents, encryptedVolume)
+	if err != nil {
+		return fmt.Errorf("invalid 'deviceId':\n%w", err)
+	}
+
+	switch device.(type) {
+	case *Partition:
+
+	default:
+		return fmt.Errorf("device (%s) must be a partition", encryptedVolume.DeviceId)
+	}
+
+	return nil
+}
+
 func checkDeviceTreeFileSystemItem(filesystem *FileSystem, deviceMap map[string]any, deviceParents map[string]any,
 	partitionLabelCounts map[string]int, mountPaths map[string]bool,
 ) error {
@@ -296,6 +417,14 @@ func checkDeviceTreeFileSystemItem(filesystem *FileSystem, deviceMap map[string]
 				filesystem.DeviceId)
 		}
 
+	case *EncryptedVolume:
+		filesystem.PartitionId = device.DeviceId
+
+		if filesystem.MountPoint != nil && filesystem.MountPoint.IdType != MountIdentifierTypeDefault {
+			return fmt.Errorf("filesystem for encrypted volume (%s) may not specify 'mountPoint.idType'",
+				filesystem.DeviceId)
+		}
+
 	default:
 
 	}
@@ -1247,3 +1247,120 @@ func TestStorageIsValidVerityTwoVerity(t *testing.T) {
 	assert.ErrorContains(t, err, "invalid 'dataDeviceId'")
 	assert.ErrorContains(t, err, "device (root) is used by multiple things")
 }
+
+func encryptedVolumeTestStorage(varMountPath string) Storage {
+	return Storage{
+		Disks: []Disk{{
+			PartitionTableType: "gpt",
+			Partitions: []Partition{
+				{
+					Id: "esp",
+					Size: PartitionSize{
+						Type: PartitionSizeTypeExplicit,
+						Size: 8 * diskutils.MiB,
+					},
+					Type: PartitionTypeESP,
+				},
+				{
+					Id: "root",
+					Size: PartitionSize{
+						Type: PartitionSizeTypeExplicit,
+						Size: 1 * diskutils.GiB,
+					},
+				},
+				{
+					Id: "var",
+					Size: PartitionSize{
+						Type: PartitionSizeTypeExplicit,
+						Size: 1 * diskutils.GiB,
+					},
+				},
+			},
+		}},
+		BootType: "efi",
+		FileSystems: []FileSystem{
+			{
+				DeviceId: "esp",
+				Type:     "vfat",
+				MountPoint: &MountPoint{
+					Path: "/boot/efi",
+				},
+			},
+			{
+				DeviceId: "root",
+				Type:     "ext4",
+				MountPoint: &MountPoint{
+					Path: "/",
+				},
+			},
+			{
+				DeviceId: "varcrypt",
+				Type:     "ext4",
+				MountPoint: &MountPoint{
+					Path: varMountPath,
+				},
+			},
+		},
+		EncryptedVolumes: []EncryptedVolume{
+			{
+				Id:       "varcrypt",
+				Name:     "var",
+				DeviceId: "var",
+			},
+		},
+	}
+}
+
+func TestStorageIsValidEncryptedVolume(t *testing.T) {
+	value := encryptedVolumeTestStorage("/var")
+
+	err := value.IsValid()
+	assert.NoError(t, err)
+	assert.Equal(t, &value.FileSystems[2], value.EncryptedVolumes[0].FileSystem)
+	assert.Equal(t, "var", value.FileSystems[2].PartitionId)
+}
+
+func TestStorageIsValidEncryptedVolumeRoot(t *testing.T) {
+	value := encryptedVolumeTestStorage("/var")
+	value.FileSystems[1].MountPoint.Path = "/data"
+	value.FileSystems[2].MountPoint.Path = "/"
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "encrypting the (/) filesystem is not currently supported")
+}
+
+func TestStorageIsValidEncryptedVolumeNoFileSystem(t *testing.T) {
+	value := encryptedVolumeTestStorage("/var")
+	value.FileSystems = value.FileSystems[:2]
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "encrypted volume (varcrypt) must have a filesystem with a 'type'")
+}
+
+func TestStorageIsValidEncryptedVolumeIdType(t *testing.T) {
+	value := encryptedVolumeTestStorage("/var")
+	value.FileSystems[2].MountPoint.IdType = MountIdentifierTypePartUuid
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "filesystem for encrypted volume (varcrypt) may not specify 'mountPoint.idType'")
+}
+
+func TestStorageIsValidEncryptedVolumeMissingPartition(t *testing.T) {
+	value := encryptedVolumeTestStorage("/var")
+	value.EncryptedVolumes[0].DeviceId = "data"
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "invalid encryptedVolumes item at index 0")
+	assert.ErrorContains(t, err, "device (data) not found")
+}
+
+func TestStorageIsValidEncryptedVolumeSharedPartition(t *testing.T) {
+	value := encryptedVolumeTestStorage("/var")
+	value.FileSystems = append(value.FileSystems, FileSystem{
+		DeviceId: "var",
+		Type:     "ext4",
+	})
+
+	err := value.IsValid()
+	assert.ErrorContains(t, err, "device (var) is used by multiple things")
+}
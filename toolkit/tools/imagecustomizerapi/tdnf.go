@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
+)
+
+var (
+	// Matches the names of tdnf variables, which are stored as files under /etc/tdnf/vars.
+	tdnfVarNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+	tdnfProxySchemes = []string{"http", "https", "socks5", "socks5h"}
+)
+
+const (
+	TdnfReleaseVerVarName = "releasever"
+)
+
+// Tdnf defines the settings to apply to the image's tdnf configuration.
+type Tdnf struct {
+	// Excludes lists package names (or globs) that tdnf should never install or update.
+	Excludes []string `yaml:"excludes"`
+	// Proxy is the URL of the proxy tdnf should use.
+	Proxy string `yaml:"proxy"`
+	// KeepCache sets whether tdnf keeps downloaded packages in its cache.
+	KeepCache *bool `yaml:"keepCache"`
+	// ReleaseVer overrides the $releasever variable.
+	ReleaseVer string `yaml:"releaseVer"`
+	// Vars are additional variables that can be referenced in repo files.
+	Vars map[string]string `yaml:"vars"`
+}
+
+func (t *Tdnf) IsValid() error {
+	for i, exclude := range t.Excludes {
+		if exclude == "" || strings.ContainsAny(exclude, " \t\n,") {
+			return fmt.Errorf("invalid excludes item at index %d (%s): must be non-empty and not contain whitespace or ','",
+				i, exclude)
+		}
+	}
+
+	if t.Proxy != "" {
+		proxyUrl, err := url.Parse(t.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy (%s):\n%w", t.Proxy, err)
+		}
+
+		if !sliceutils.ContainsValue(tdnfProxySchemes, proxyUrl.Scheme) || proxyUrl.Host == "" {
+			return fmt.Errorf("invalid proxy (%s): must be a URL with one of the schemes (%v)", t.Proxy,
+				tdnfProxySchemes)
+		}
+	}
+
+	if strings.ContainsAny(t.ReleaseVer, " \t\n") {
+		return fmt.Errorf("invalid releaseVer (%s): must not contain whitespace", t.ReleaseVer)
+	}
+
+	for name, value := range t.Vars {
+		if !tdnfVarNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid vars name (%s): must only contain letters, digits and '_'", name)
+		}
+
+		if strings.ContainsAny(value, "\n") {
+			return fmt.Errorf("invalid vars value for (%s): must not contain newlines", name)
+		}
+
+		if name == TdnfReleaseVerVarName && t.ReleaseVer != "" {
+			return fmt.Errorf("cannot specify both 'releaseVer' and the (%s) var", TdnfReleaseVerVarName)
+		}
+	}
+
+	return nil
+}
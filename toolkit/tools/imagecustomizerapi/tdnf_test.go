@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTdnfIsValid(t *testing.T) {
+	tdnf := Tdnf{
+		Excludes:   []string{"kernel*", "shim"},
+		Proxy:      "http://proxy.example.com:3128",
+		KeepCache:  ptrutils.PtrTo(true),
+		ReleaseVer: "3.0",
+		Vars: map[string]string{
+			"mirror": "mirror.example.com",
+		},
+	}
+
+	err := tdnf.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestTdnfIsValidBadExclude(t *testing.T) {
+	tdnf := Tdnf{
+		Excludes: []string{"kernel shim"},
+	}
+
+	err := tdnf.IsValid()
+	assert.ErrorContains(t, err, "invalid excludes item at index 0")
+}
+
+func TestTdnfIsValidBadProxy(t *testing.T) {
+	tdnf := Tdnf{
+		Proxy: "proxy.example.com:3128",
+	}
+
+	err := tdnf.IsValid()
+	assert.ErrorContains(t, err, "invalid proxy")
+}
+
+func TestTdnfIsValidBadVarName(t *testing.T) {
+	tdnf := Tdnf{
+		Vars: map[string]string{
+			"../a": "b",
+		},
+	}
+
+	err := tdnf.IsValid()
+	assert.ErrorContains(t, err, "invalid vars name (../a)")
+}
+
+func TestTdnfIsValidReleaseVerConflict(t *testing.T) {
+	tdnf := Tdnf{
+		ReleaseVer: "3.0",
+		Vars: map[string]string{
+			"releasever": "2.0",
+		},
+	}
+
+	err := tdnf.IsValid()
+	assert.ErrorContains(t, err, "cannot specify both 'releaseVer' and the (releasever) var")
+}
+
+func TestOSIsValidInvalidTdnf(t *testing.T) {
+	os := OS{
+		Tdnf: &Tdnf{
+			ReleaseVer: "3 0",
+		},
+	}
+
+	err := os.IsValid()
+	assert.ErrorContains(t, err, "invalid tdnf")
+	assert.ErrorContains(t, err, "invalid releaseVer")
+}
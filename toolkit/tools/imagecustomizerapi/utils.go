@@ -18,12 +18,18 @@ type HasIsValid interface {
 func UnmarshalYamlFile[ValueType HasIsValid](yamlFilePath string, value ValueType) error {
 	var err error
 
-	yamlFile, err := os.ReadFile(yamlFilePath)
+	yamlFile, err := os.Open(yamlFilePath)
 	if err != nil {
 		return err
 	}
+	defer yamlFile.Close()
 
-	err = UnmarshalYaml(yamlFile, value)
+	err = decodeYaml(yamlFile, value)
+	if err != nil {
+		return fmt.Errorf("failed to parse YAML file (%s):\n%w", yamlFilePath, err)
+	}
+
+	err = value.IsValid()
 	if err != nil {
 		return err
 	}
@@ -35,12 +41,8 @@ func UnmarshalYaml[ValueType HasIsValid](yamlData []byte, value ValueType) error
 	var err error
 
 	reader := bytes.NewReader(yamlData)
-	decoder := yaml.NewDecoder(reader)
-
-	// Ensure unknown fields result in an error.
-	decoder.KnownFields(true)
 
-	err = decoder.Decode(value)
+	err = decodeYaml(reader, value)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	DefaultBootSmokeTestTimeoutSeconds = 300
+	// DefaultBootSmokeTestPattern matches the serial console login prompt.
+	DefaultBootSmokeTestPattern = `login:`
+)
+
+// Validation specifies checks that are run against the customized image. The build fails if any of the checks fail.
+type Validation struct {
+	// RequiredFiles are the paths, within the image, that must exist.
+	RequiredFiles []string `yaml:"requiredFiles"`
+	// EnabledServices are the systemd services that must be enabled.
+	EnabledServices []string `yaml:"enabledServices"`
+	// KernelModules are the kernel modules that must be present (either as a module file or built into the kernel)
+	// for every kernel in the image.
+	KernelModules []string `yaml:"kernelModules"`
+	// NoWorldWritableFiles checks that the image doesn't have any world-writable files, or any world-writable
+	// directories without the sticky bit.
+	NoWorldWritableFiles *NoWorldWritableFiles `yaml:"noWorldWritableFiles"`
+	// BootSmokeTest boots the output image in a QEMU VM and waits for it to reach the console login prompt.
+	BootSmokeTest *BootSmokeTest `yaml:"bootSmokeTest"`
+}
+
+// NoWorldWritableFiles specifies the options of the world-writable files check.
+type NoWorldWritableFiles struct {
+	// ExcludePaths is a list of directories, within the OS image, to skip.
+	ExcludePaths []string `yaml:"excludePaths"`
+}
+
+// BootSmokeTest specifies the options of the boot smoke test.
+type BootSmokeTest struct {
+	// TimeoutSeconds is how long to wait for the console output to match the pattern.
+	TimeoutSeconds *int `yaml:"timeoutSeconds"`
+	// Pattern is the regex that the serial console output must match for the boot to be considered successful.
+	Pattern string `yaml:"pattern"`
+	// Firmware is the path of the UEFI firmware (e.g. OVMF) to boot the image with. Required for images that boot
+	// using UEFI.
+	Firmware string `yaml:"firmware"`
+}
+
+func (v *Validation) IsValid() error {
+	for _, requiredFile := range v.RequiredFiles {
+		if !filepath.IsAbs(requiredFile) || filepath.Clean(requiredFile) != requiredFile {
+			return fmt.Errorf("invalid requiredFiles value (%s): must be a clean absolute path", requiredFile)
+		}
+	}
+
+	for i, service := range v.EnabledServices {
+		if strings.TrimSpace(service) == "" {
+			return fmt.Errorf("invalid enabledServices item at index %d: service name may not be empty", i)
+		}
+	}
+
+	for i, module := range v.KernelModules {
+		if strings.TrimSpace(module) == "" || strings.ContainsAny(module, "/ ") {
+			return fmt.Errorf("invalid kernelModules item at index %d (%s): must be a module name", i, module)
+		}
+	}
+
+	if v.NoWorldWritableFiles != nil {
+		err := v.NoWorldWritableFiles.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid noWorldWritableFiles:\n%w", err)
+		}
+	}
+
+	if v.BootSmokeTest != nil {
+		err := v.BootSmokeTest.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid bootSmokeTest:\n%w", err)
+		}
+	}
+
+	return nil
+}
+
+// HasImageChecks returns true if any of the checks inspect the contents of the image's filesystems.
+func (v *Validation) HasImageChecks() bool {
+	return len(v.RequiredFiles) > 0 || len(v.EnabledServices) > 0 || len(v.KernelModules) > 0 ||
+		v.NoWorldWritableFiles != nil
+}
+
+func (n *NoWorldWritableFiles) IsValid() error {
+	for _, excludePath := range n.ExcludePaths {
+		if !filepath.IsAbs(excludePath) || filepath.Clean(excludePath) != excludePath {
+			return fmt.Errorf("invalid excludePaths value (%s): must be a clean absolute path", excludePath)
+		}
+
+		if excludePath == "/" {
+			return fmt.Errorf("invalid excludePaths value (%s): cannot exclude the root directory", excludePath)
+		}
+	}
+
+	return nil
+}
+
+func (b *BootSmokeTest) IsValid() error {
+	if b.TimeoutSeconds != nil && *b.TimeoutSeconds <= 0 {
+		return fmt.Errorf("invalid timeoutSeconds (%d): must be greater than 0", *b.TimeoutSeconds)
+	}
+
+	if b.Pattern != "" {
+		_, err := regexp.Compile(b.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern (%s):\n%w", b.Pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *BootSmokeTest) GetTimeoutSeconds() int {
+	if b.TimeoutSeconds == nil {
+		return DefaultBootSmokeTestTimeoutSeconds
+	}
+	return *b.TimeoutSeconds
+}
+
+func (b *BootSmokeTest) GetPattern() string {
+	if b.Pattern == "" {
+		return DefaultBootSmokeTestPattern
+	}
+	return b.Pattern
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationIsValid(t *testing.T) {
+	validation := Validation{
+		RequiredFiles:        []string{"/etc/app.conf"},
+		EnabledServices:      []string{"sshd"},
+		KernelModules:        []string{"nvme"},
+		NoWorldWritableFiles: &NoWorldWritableFiles{ExcludePaths: []string{"/var/cache"}},
+		BootSmokeTest:        &BootSmokeTest{TimeoutSeconds: ptrutils.PtrTo(60), Pattern: `login:`},
+	}
+	assert.NoError(t, validation.IsValid())
+	assert.True(t, validation.HasImageChecks())
+}
+
+func TestValidationIsValidInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		validation Validation
+		err        string
+	}{
+		{
+			name:       "relative required file",
+			validation: Validation{RequiredFiles: []string{"etc/app.conf"}},
+			err:        "invalid requiredFiles value (etc/app.conf)",
+		},
+		{
+			name:       "empty service",
+			validation: Validation{EnabledServices: []string{" "}},
+			err:        "invalid enabledServices item at index 0",
+		},
+		{
+			name:       "module path",
+			validation: Validation{KernelModules: []string{"kernel/nvme.ko"}},
+			err:        "invalid kernelModules item at index 0 (kernel/nvme.ko)",
+		},
+		{
+			name:       "exclude root",
+			validation: Validation{NoWorldWritableFiles: &NoWorldWritableFiles{ExcludePaths: []string{"/"}}},
+			err:        "cannot exclude the root directory",
+		},
+		{
+			name:       "zero timeout",
+			validation: Validation{BootSmokeTest: &BootSmokeTest{TimeoutSeconds: ptrutils.PtrTo(0)}},
+			err:        "invalid timeoutSeconds (0)",
+		},
+		{
+			name:       "bad pattern",
+			validation: Validation{BootSmokeTest: &BootSmokeTest{Pattern: "("}},
+			err:        "invalid pattern (()",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ErrorContains(t, test.validation.IsValid(), test.err)
+		})
+	}
+}
+
+func TestBootSmokeTestDefaults(t *testing.T) {
+	smokeTest := BootSmokeTest{}
+	assert.Equal(t, DefaultBootSmokeTestTimeoutSeconds, smokeTest.GetTimeoutSeconds())
+	assert.Equal(t, DefaultBootSmokeTestPattern, smokeTest.GetPattern())
+	assert.False(t, (&Validation{BootSmokeTest: &smokeTest}).HasImageChecks())
+}
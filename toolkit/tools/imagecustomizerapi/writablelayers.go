@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"path"
+)
+
+// WritableLayers configures writable overlay filesystems on top of directories of a (typically read-only) root
+// filesystem. The writable layers are stored on a dedicated persistence partition.
+type WritableLayers struct {
+	// PersistentMountPoint is the mount path of the partition that holds the writable layers.
+	PersistentMountPoint string `yaml:"persistentMountPoint"`
+	// Paths are the directories to make writable.
+	Paths []string `yaml:"paths"`
+	// MountOptions are additional mount options for each of the overlay mounts.
+	MountOptions string `yaml:"mountOptions"`
+}
+
+func (w *WritableLayers) IsValid() error {
+	if err := validatePath(w.PersistentMountPoint); err != nil {
+		return fmt.Errorf("invalid persistentMountPoint (%s):\n%w", w.PersistentMountPoint, err)
+	}
+
+	if path.Clean(w.PersistentMountPoint) == "/" {
+		return fmt.Errorf("invalid persistentMountPoint (%s): must not be the root directory", w.PersistentMountPoint)
+	}
+
+	if len(w.Paths) <= 0 {
+		return fmt.Errorf("paths must not be empty")
+	}
+
+	for i, layerPath := range w.Paths {
+		if err := validatePath(layerPath); err != nil {
+			return fmt.Errorf("invalid paths item at index %d:\n%w", i, err)
+		}
+
+		if path.Clean(layerPath) == "/" {
+			return fmt.Errorf("invalid paths item at index %d: path (%s) must not be the root directory", i, layerPath)
+		}
+
+		if pathsOverlap(layerPath, w.PersistentMountPoint) {
+			return fmt.Errorf("invalid paths item at index %d: path (%s) overlaps with persistentMountPoint (%s)", i,
+				layerPath, w.PersistentMountPoint)
+		}
+
+		for j := 0; j < i; j++ {
+			if pathsOverlap(layerPath, w.Paths[j]) {
+				return fmt.Errorf("invalid paths item at index %d: path (%s) overlaps with path (%s)", i, layerPath,
+					w.Paths[j])
+			}
+		}
+	}
+
+	if validateMountOptions(w.MountOptions) {
+		return fmt.Errorf("mountOptions (%s) contain spaces, tabs, or newlines are invalid", w.MountOptions)
+	}
+
+	return nil
+}
+
+// pathsOverlap checks if two paths are the same or if one is a subdirectory of the other.
+func pathsOverlap(path1 string, path2 string) bool {
+	path1 = path.Clean(path1)
+	path2 = path.Clean(path2)
+	return path1 == path2 || isSubDirString(path1, path2) || isSubDirString(path2, path1)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritableLayersIsValid(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "/persist",
+		Paths:                []string{"/etc", "/var"},
+		MountOptions:         "noatime",
+	}
+
+	err := writableLayers.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestWritableLayersIsValidInvalidPersistentMountPoint(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "persist",
+		Paths:                []string{"/etc"},
+	}
+
+	err := writableLayers.IsValid()
+	assert.ErrorContains(t, err, "invalid persistentMountPoint (persist)")
+
+	writableLayers.PersistentMountPoint = "/"
+	err = writableLayers.IsValid()
+	assert.ErrorContains(t, err, "must not be the root directory")
+}
+
+func TestWritableLayersIsValidEmptyPaths(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "/persist",
+	}
+
+	err := writableLayers.IsValid()
+	assert.ErrorContains(t, err, "paths must not be empty")
+}
+
+func TestWritableLayersIsValidRootPath(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "/persist",
+		Paths:                []string{"/"},
+	}
+
+	err := writableLayers.IsValid()
+	assert.ErrorContains(t, err, "path (/) must not be the root directory")
+}
+
+func TestWritableLayersIsValidOverlappingPaths(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "/persist",
+		Paths:                []string{"/var", "/var/lib"},
+	}
+
+	err := writableLayers.IsValid()
+	assert.ErrorContains(t, err, "path (/var/lib) overlaps with path (/var)")
+
+	writableLayers.Paths = []string{"/etc", "/etc/"}
+	err = writableLayers.IsValid()
+	assert.ErrorContains(t, err, "path (/etc/) overlaps with path (/etc)")
+}
+
+func TestWritableLayersIsValidPathOverlapsPersistentMountPoint(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "/var/persist",
+		Paths:                []string{"/var"},
+	}
+
+	err := writableLayers.IsValid()
+	assert.ErrorContains(t, err, "path (/var) overlaps with persistentMountPoint (/var/persist)")
+}
+
+func TestWritableLayersIsValidInvalidMountOptions(t *testing.T) {
+	writableLayers := WritableLayers{
+		PersistentMountPoint: "/persist",
+		Paths:                []string{"/etc"},
+		MountOptions:         "a b",
+	}
+
+	err := writableLayers.IsValid()
+	assert.ErrorContains(t, err, "mountOptions (a b) contain spaces")
+}
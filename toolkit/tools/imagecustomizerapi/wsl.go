@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// The wsl.conf section and key that set the default user.
+	wslConfUserSection    = "user"
+	wslConfDefaultUserKey = "default"
+)
+
+// Wsl specifies the settings of the WSL rootfs output format (i.e. 'wsl').
+type Wsl struct {
+	// DefaultUser is the user that WSL logs in as by default. The user must exist in the image.
+	DefaultUser string `yaml:"defaultUser"`
+	// Conf is the contents of the /etc/wsl.conf file, by section and then by key.
+	Conf map[string]map[string]string `yaml:"conf"`
+}
+
+func (w *Wsl) IsValid() error {
+	if w.DefaultUser != "" && strings.ContainsAny(w.DefaultUser, ": \t\n") {
+		return fmt.Errorf("invalid defaultUser (%s)", w.DefaultUser)
+	}
+
+	for section, keys := range w.Conf {
+		if !isValidWslConfName(section) {
+			return fmt.Errorf("invalid conf section name (%s)", section)
+		}
+
+		for key, value := range keys {
+			if !isValidWslConfName(key) {
+				return fmt.Errorf("invalid conf key name (%s) in section (%s)", key, section)
+			}
+
+			if strings.ContainsAny(value, "\n\r") {
+				return fmt.Errorf("invalid conf value of key (%s.%s): must not contain line breaks", section, key)
+			}
+		}
+	}
+
+	if _, found := w.Conf[wslConfUserSection][wslConfDefaultUserKey]; found && w.DefaultUser != "" {
+		return fmt.Errorf("defaultUser and conf key (%s.%s) cannot both be specified", wslConfUserSection,
+			wslConfDefaultUserKey)
+	}
+
+	return nil
+}
+
+func isValidWslConfName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "[]=#; \t\n\r")
+}
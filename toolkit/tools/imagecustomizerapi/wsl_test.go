@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWslIsValid(t *testing.T) {
+	wsl := Wsl{
+		DefaultUser: "alice",
+		Conf: map[string]map[string]string{
+			"boot":    {"systemd": "true"},
+			"network": {"hostname": "azurelinux"},
+		},
+	}
+	assert.NoError(t, wsl.IsValid())
+
+	wsl = Wsl{DefaultUser: "al ice"}
+	assert.ErrorContains(t, wsl.IsValid(), "invalid defaultUser (al ice)")
+
+	wsl = Wsl{Conf: map[string]map[string]string{"[boot]": {"systemd": "true"}}}
+	assert.ErrorContains(t, wsl.IsValid(), "invalid conf section name ([boot])")
+
+	wsl = Wsl{Conf: map[string]map[string]string{"boot": {"systemd=": "true"}}}
+	assert.ErrorContains(t, wsl.IsValid(), "invalid conf key name (systemd=) in section (boot)")
+
+	wsl = Wsl{Conf: map[string]map[string]string{"boot": {"command": "a\nb"}}}
+	assert.ErrorContains(t, wsl.IsValid(), "invalid conf value of key (boot.command)")
+
+	wsl = Wsl{DefaultUser: "alice", Conf: map[string]map[string]string{"user": {"default": "bob"}}}
+	assert.ErrorContains(t, wsl.IsValid(), "defaultUser and conf key (user.default) cannot both be specified")
+}
+
+func TestConfigIsValidWsl(t *testing.T) {
+	config := Config{
+		Wsl: &Wsl{DefaultUser: "al ice"},
+	}
+	assert.ErrorContains(t, config.IsValid(), "invalid 'wsl' field:\ninvalid defaultUser (al ice)")
+
+	config = Config{
+		Hotfix: &Hotfix{Rpms: []string{"kernel.rpm"}},
+		Wsl:    &Wsl{},
+	}
+	assert.ErrorContains(t, config.IsValid(), "'hotfix' cannot be combined with 'wsl'")
+}
@@ -0,0 +1,420 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Top-level keys with this prefix are ignored. This gives users a place to define YAML anchors that are then
+	// referenced elsewhere in the file.
+	yamlExtensionKeyPrefix = "x-"
+
+	yamlMergeKey = "<<"
+)
+
+var (
+	yamlNodeType        = reflect.TypeOf(yaml.Node{})
+	yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// decodeYaml parses a YAML document and decodes it into value.
+//
+// Unlike yaml.Decoder.Decode(), this:
+//   - Reports alias cycles and invalid merge keys along with their position.
+//   - Allows top-level "x-" keys, so that anchors can be defined outside of the schema.
+//   - Checks for unknown fields within merged mappings. And for aliased values, reports the position of both the
+//     anchored value and the alias.
+//   - Checks the document against the JSON schema of the value's type, reporting the line and column of each
+//     invalid value.
+//
+// Anchored values are only checked once, regardless of how many times they are referenced. This avoids the cost
+// (in both time and memory) of expanding heavily aliased documents.
+func decodeYaml(reader io.Reader, value interface{}) error {
+	document, err := parseYaml(reader)
+	if err != nil {
+		return err
+	}
+
+	err = checkYamlFields(document, value)
+	if err != nil {
+		return err
+	}
+
+	err = checkYamlSchema(document, value)
+	if err != nil {
+		return err
+	}
+
+	return decodeYamlDocument(document, value)
+}
+
+// parseYaml parses a YAML document, checks its aliases, and removes the top-level "x-" keys.
+func parseYaml(reader io.Reader) (*yaml.Node, error) {
+	var document yaml.Node
+
+	decoder := yaml.NewDecoder(reader)
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkYamlAliases(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	removeYamlExtensionKeys(&document)
+
+	return &document, nil
+}
+
+// checkYamlFields checks the document for fields that don't exist in the value's type.
+func checkYamlFields(document *yaml.Node, value interface{}) error {
+	checker := yamlFieldsChecker{
+		checked: make(map[yamlFieldsCheckKey]bool),
+	}
+	checker.check(document, reflect.TypeOf(value), nil)
+	if len(checker.errors) > 0 {
+		return &yaml.TypeError{Errors: checker.errors}
+	}
+
+	return nil
+}
+
+func decodeYamlDocument(document *yaml.Node, value interface{}) error {
+	// Note: yaml.Node.Decode() doesn't support the KnownFields() option. But unknown fields have already been
+	// checked by checkYamlFields().
+	err := document.Decode(value)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkYamlAliases ensures that no anchored value references itself and that all merge keys have valid values.
+func checkYamlAliases(document *yaml.Node) error {
+	visiting := make(map[*yaml.Node]bool)
+	visited := make(map[*yaml.Node]bool)
+	return checkYamlAliasesHelper(document, visiting, visited)
+}
+
+func checkYamlAliasesHelper(node *yaml.Node, visiting map[*yaml.Node]bool, visited map[*yaml.Node]bool) error {
+	if visited[node] {
+		return nil
+	}
+
+	if node.Kind == yaml.AliasNode {
+		if visiting[node.Alias] {
+			return fmt.Errorf("line %d, column %d: alias (*%s) references its own anchor (line %d), which creates a cycle",
+				node.Line, node.Column, node.Value, node.Alias.Line)
+		}
+
+		node = node.Alias
+		if visited[node] {
+			return nil
+		}
+	}
+
+	visiting[node] = true
+
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode := node.Content[i]
+			valueNode := node.Content[i+1]
+
+			if isYamlMergeKey(keyNode) && !isValidYamlMergeValue(valueNode) {
+				return fmt.Errorf("line %d, column %d: merge key (%s) value must be a mapping or a list of mappings",
+					keyNode.Line, keyNode.Column, yamlMergeKey)
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		err := checkYamlAliasesHelper(child, visiting, visited)
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(visiting, node)
+	visited[node] = true
+	return nil
+}
+
+func isYamlMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Value == yamlMergeKey &&
+		(node.Tag == "" || node.Tag == "!" || node.Tag == "!!merge" || node.Tag == "tag:yaml.org,2002:merge")
+}
+
+func isValidYamlMergeValue(node *yaml.Node) bool {
+	switch resolveYamlAlias(node).Kind {
+	case yaml.MappingNode:
+		return true
+
+	case yaml.SequenceNode:
+		for _, item := range resolveYamlAlias(node).Content {
+			if resolveYamlAlias(item).Kind != yaml.MappingNode {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return false
+	}
+}
+
+func resolveYamlAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// removeYamlExtensionKeys removes the top-level "x-" keys from the document.
+// Any aliases that reference anchors within the removed values remain valid, since they point directly to the nodes.
+func removeYamlExtensionKeys(document *yaml.Node) {
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 {
+		return
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+
+	content := []*yaml.Node(nil)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode := root.Content[i]
+		if keyNode.Kind == yaml.ScalarNode && strings.HasPrefix(keyNode.Value, yamlExtensionKeyPrefix) {
+			continue
+		}
+
+		content = append(content, keyNode, root.Content[i+1])
+	}
+
+	root.Content = content
+}
+
+type yamlFieldsCheckKey struct {
+	node      *yaml.Node
+	valueType reflect.Type
+}
+
+// yamlFieldsChecker checks a YAML document for fields that don't exist in the target type.
+type yamlFieldsChecker struct {
+	checked map[yamlFieldsCheckKey]bool
+	errors  []string
+}
+
+// check checks the node against the valueType. The alias is the alias node (if any) that the node was reached
+// through.
+func (c *yamlFieldsChecker) check(node *yaml.Node, valueType reflect.Type, alias *yaml.Node) {
+	for valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			c.check(child, valueType, alias)
+		}
+		return
+
+	case yaml.AliasNode:
+		c.check(node.Alias, valueType, node)
+		return
+	}
+
+	// Types with custom unmarshalling are responsible for checking their own fields.
+	if valueType == yamlNodeType || valueType.Implements(yamlUnmarshalerType) ||
+		reflect.PointerTo(valueType).Implements(yamlUnmarshalerType) {
+		return
+	}
+
+	key := yamlFieldsCheckKey{node, valueType}
+	if c.checked[key] {
+		return
+	}
+	c.checked[key] = true
+
+	switch {
+	case node.Kind == yaml.MappingNode && valueType.Kind() == reflect.Struct:
+		c.checkStruct(node, valueType, alias)
+
+	case node.Kind == yaml.MappingNode && valueType.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if isYamlMergeKey(node.Content[i]) {
+				c.checkMerge(node.Content[i+1], valueType, alias)
+				continue
+			}
+
+			c.check(node.Content[i+1], valueType.Elem(), alias)
+		}
+
+	case node.Kind == yaml.SequenceNode &&
+		(valueType.Kind() == reflect.Slice || valueType.Kind() == reflect.Array):
+		for _, item := range node.Content {
+			c.check(item, valueType.Elem(), alias)
+		}
+	}
+}
+
+func (c *yamlFieldsChecker) checkStruct(node *yaml.Node, valueType reflect.Type, alias *yaml.Node) {
+	fields, hasInlineMap := getYamlStructFields(valueType)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		valueNode := node.Content[i+1]
+
+		if isYamlMergeKey(keyNode) {
+			c.checkMerge(valueNode, valueType, alias)
+			continue
+		}
+
+		fieldType, found := fields[keyNode.Value]
+		if !found {
+			if !hasInlineMap {
+				c.addError(keyNode, alias, fmt.Sprintf("field %s not found in type %s", keyNode.Value, valueType))
+			}
+			continue
+		}
+
+		c.check(valueNode, fieldType, alias)
+	}
+}
+
+func (c *yamlFieldsChecker) checkMerge(node *yaml.Node, valueType reflect.Type, alias *yaml.Node) {
+	if node.Kind == yaml.AliasNode {
+		alias = node
+		node = node.Alias
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		for _, item := range node.Content {
+			c.check(item, valueType, alias)
+		}
+		return
+	}
+
+	c.check(node, valueType, alias)
+}
+
+func (c *yamlFieldsChecker) addError(node *yaml.Node, alias *yaml.Node, message string) {
+	if alias != nil {
+		c.errors = append(c.errors, fmt.Sprintf("line %d: %s (referenced by alias *%s at line %d)", node.Line, message,
+			alias.Value, alias.Line))
+		return
+	}
+
+	c.errors = append(c.errors, fmt.Sprintf("line %d: %s", node.Line, message))
+}
+
+// getYamlStructFields returns the YAML field names of a struct type, using the same rules as yaml.v3.
+func getYamlStructFields(structType reflect.Type) (map[string]reflect.Type, bool) {
+	fields := make(map[string]reflect.Type)
+	hasInlineMap := false
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			// Unexported field.
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		if tag == "" && !strings.Contains(string(field.Tag), ":") {
+			tag = string(field.Tag)
+		}
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+
+		inline := false
+		for _, option := range strings.Split(options, ",") {
+			if option == "inline" {
+				inline = true
+			}
+		}
+
+		if inline {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+
+			switch fieldType.Kind() {
+			case reflect.Map:
+				hasInlineMap = true
+
+			case reflect.Struct:
+				inlineFields, inlineHasInlineMap := getYamlStructFields(fieldType)
+				for inlineName, inlineType := range inlineFields {
+					fields[inlineName] = inlineType
+				}
+				hasInlineMap = hasInlineMap || inlineHasInlineMap
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields, hasInlineMap
+}
+
+// checkYamlMappingKeys checks that a mapping node only contains the provided keys, including any mappings merged into
+// it. This is intended for types that implement yaml.Unmarshaler.
+func checkYamlMappingKeys(node *yaml.Node, validKeys []string, typeName string) error {
+	node = resolveYamlAlias(node)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+
+		if isYamlMergeKey(keyNode) {
+			mergeNode := resolveYamlAlias(node.Content[i+1])
+
+			mergeNodes := []*yaml.Node{mergeNode}
+			if mergeNode.Kind == yaml.SequenceNode {
+				mergeNodes = mergeNode.Content
+			}
+
+			for _, mergeNode := range mergeNodes {
+				err := checkYamlMappingKeys(mergeNode, validKeys, typeName)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		found := false
+		for _, validKey := range validKeys {
+			if keyNode.Value == validKey {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("line %d: field %s not found in type %s", keyNode.Line, keyNode.Value, typeName)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalYamlAnchorsAndMergeKeys(t *testing.T) {
+	yamlString := `
+x-user: &user
+  startupCommand: /usr/bin/bash
+  secondaryGroups: [wheel]
+
+os:
+  users:
+  - <<: *user
+    name: alice
+  - <<: *user
+    name: bob
+    startupCommand: /usr/bin/sh
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) && assert.Len(t, config.OS.Users, 2) {
+		assert.Equal(t, "alice", config.OS.Users[0].Name)
+		assert.Equal(t, "/usr/bin/bash", config.OS.Users[0].StartupCommand)
+		assert.Equal(t, []string{"wheel"}, config.OS.Users[0].SecondaryGroups)
+		assert.Equal(t, "bob", config.OS.Users[1].Name)
+		assert.Equal(t, "/usr/bin/sh", config.OS.Users[1].StartupCommand)
+		assert.Equal(t, []string{"wheel"}, config.OS.Users[1].SecondaryGroups)
+	}
+}
+
+func TestUnmarshalYamlMergeKeyList(t *testing.T) {
+	yamlString := `
+x-shell: &shell {startupCommand: /usr/bin/bash}
+x-groups: &groups {secondaryGroups: [wheel]}
+
+os:
+  users:
+  - <<: [*shell, *groups]
+    name: alice
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.NoError(t, err)
+	if assert.NotNil(t, config.OS) && assert.Len(t, config.OS.Users, 1) {
+		assert.Equal(t, "/usr/bin/bash", config.OS.Users[0].StartupCommand)
+		assert.Equal(t, []string{"wheel"}, config.OS.Users[0].SecondaryGroups)
+	}
+}
+
+func TestUnmarshalYamlMergeKeyMountPoint(t *testing.T) {
+	yamlString := `
+x-mount: &mount
+  idType: part-uuid
+
+mountPoint:
+  <<: *mount
+  path: /
+`
+
+	var value struct {
+		MountPoint MountPoint `yaml:"mountPoint"`
+	}
+	err := decodeYaml(strings.NewReader(yamlString), &value)
+	assert.NoError(t, err)
+	assert.Equal(t, MountIdentifierTypePartUuid, value.MountPoint.IdType)
+	assert.Equal(t, "/", value.MountPoint.Path)
+
+	yamlString = `
+x-mount: &mount
+  idType: part-uuid
+  bogus: true
+
+mountPoint:
+  <<: *mount
+  path: /
+`
+
+	err = decodeYaml(strings.NewReader(yamlString), &value)
+	assert.ErrorContains(t, err, "line 4: field bogus not found in type MountPoint")
+}
+
+func TestUnmarshalYamlUnknownFieldInMerge(t *testing.T) {
+	yamlString := `
+x-user: &user
+  startupCommand: /usr/bin/bash
+  bogus: true
+
+os:
+  users:
+  - <<: *user
+    name: alice
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 4: field bogus not found in type imagecustomizerapi.User "+
+		"(referenced by alias *user at line 8)")
+}
+
+func TestUnmarshalYamlUnknownFieldInAliasReportedOnce(t *testing.T) {
+	yamlString := `
+x-user: &user
+  name: alice
+  bogus: true
+
+os:
+  users:
+  - *user
+  - *user
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	if assert.ErrorContains(t, err, "line 4: field bogus not found in type imagecustomizerapi.User "+
+		"(referenced by alias *user at line 8)") {
+		assert.NotContains(t, err.Error(), "line 9")
+	}
+}
+
+func TestUnmarshalYamlUnknownTopLevelField(t *testing.T) {
+	var config Config
+	err := UnmarshalYaml([]byte("bogus: {}\n"), &config)
+	assert.ErrorContains(t, err, "line 1: field bogus not found in type imagecustomizerapi.Config")
+}
+
+func TestUnmarshalYamlExtensionKeyOnlyTopLevel(t *testing.T) {
+	var config Config
+	err := UnmarshalYaml([]byte("os:\n  x-user: {}\n"), &config)
+	assert.ErrorContains(t, err, "line 2: field x-user not found in type imagecustomizerapi.OS")
+}
+
+func TestUnmarshalYamlAliasCycle(t *testing.T) {
+	yamlString := `
+x-users: &users
+- name: alice
+  secondaryGroups: *users
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 4, column 20: alias (*users) references its own anchor (line 2), "+
+		"which creates a cycle")
+}
+
+func TestUnmarshalYamlMergeKeyCycle(t *testing.T) {
+	yamlString := `
+x-user: &user
+  name: alice
+  <<: *user
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 4, column 7: alias (*user) references its own anchor (line 2)")
+}
+
+func TestUnmarshalYamlInvalidMergeValue(t *testing.T) {
+	yamlString := `
+x-name: &name alice
+
+os:
+  users:
+  - <<: *name
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 6, column 5: merge key (<<) value must be a mapping or a list of mappings")
+}
+
+func TestUnmarshalYamlDuplicateKey(t *testing.T) {
+	yamlString := `
+os:
+  hostname: a
+  hostname: b
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 4: mapping key \"hostname\" already defined at line 3")
+}
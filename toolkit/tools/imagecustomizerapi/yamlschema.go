@@ -0,0 +1,328 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	yamlNullTag  = "!!null"
+	yamlBoolTag  = "!!bool"
+	yamlIntTag   = "!!int"
+	yamlFloatTag = "!!float"
+)
+
+// checkYamlSchema checks the document against the JSON schema of the value's type. Each error includes the line and
+// column of the offending value.
+//
+// Null values are treated as unset, the same as yaml.v3 does. And types with custom unmarshalling are responsible for
+// checking their own values.
+func checkYamlSchema(document *yaml.Node, value interface{}) error {
+	schema := generateJsonSchema(reflect.TypeOf(value), true)
+
+	checker := yamlSchemaChecker{
+		defs:    schema.Defs,
+		checked: make(map[yamlSchemaCheckKey]bool),
+		regexes: make(map[string]*regexp.Regexp),
+	}
+	checker.check(document, schema, nil)
+	if len(checker.errors) > 0 {
+		return &yaml.TypeError{Errors: checker.errors}
+	}
+
+	return nil
+}
+
+type yamlSchemaCheckKey struct {
+	node   *yaml.Node
+	schema *jsonSchema
+}
+
+// yamlSchemaChecker checks a YAML document against a JSON schema.
+type yamlSchemaChecker struct {
+	defs    map[string]*jsonSchema
+	checked map[yamlSchemaCheckKey]bool
+	regexes map[string]*regexp.Regexp
+	errors  []string
+}
+
+// check checks the node against the schema. The alias is the alias node (if any) that the node was reached through.
+func (c *yamlSchemaChecker) check(node *yaml.Node, schema *jsonSchema, alias *yaml.Node) {
+	schema = c.resolveRef(schema)
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			c.check(child, schema, alias)
+		}
+		return
+
+	case yaml.AliasNode:
+		c.check(node.Alias, schema, node)
+		return
+	}
+
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == yamlNullTag {
+		return
+	}
+
+	key := yamlSchemaCheckKey{node, schema}
+	if c.checked[key] {
+		return
+	}
+	c.checked[key] = true
+
+	if len(schema.AnyOf) > 0 {
+		c.checkAnyOf(node, schema, alias)
+		return
+	}
+
+	switch schema.Type {
+	case jsonSchemaTypeObject:
+		if node.Kind != yaml.MappingNode {
+			c.addKindError(node, alias, schema)
+			return
+		}
+
+		c.checkObject(node, schema, alias)
+
+	case jsonSchemaTypeArray:
+		if node.Kind != yaml.SequenceNode {
+			c.addKindError(node, alias, schema)
+			return
+		}
+
+		for _, item := range node.Content {
+			c.check(item, schema.Items, alias)
+		}
+
+	case jsonSchemaTypeString:
+		// yaml.v3 decodes any scalar (e.g. 660) into a string.
+		if node.Kind != yaml.ScalarNode {
+			c.addKindError(node, alias, schema)
+			return
+		}
+
+		c.checkString(node, schema, alias)
+
+	case jsonSchemaTypeInteger:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != yamlIntTag {
+			c.addKindError(node, alias, schema)
+		}
+
+	case jsonSchemaTypeNumber:
+		if node.Kind != yaml.ScalarNode || (node.ShortTag() != yamlIntTag && node.ShortTag() != yamlFloatTag) {
+			c.addKindError(node, alias, schema)
+		}
+
+	case jsonSchemaTypeBoolean:
+		if node.Kind != yaml.ScalarNode || node.ShortTag() != yamlBoolTag {
+			c.addKindError(node, alias, schema)
+		}
+	}
+}
+
+func (c *yamlSchemaChecker) checkObject(node *yaml.Node, schema *jsonSchema, alias *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		valueNode := node.Content[i+1]
+
+		if isYamlMergeKey(keyNode) {
+			c.checkMerge(valueNode, schema, alias)
+			continue
+		}
+
+		propertySchema, found := schema.Properties[keyNode.Value]
+		if !found {
+			for pattern, patternSchema := range schema.PatternProperties {
+				if c.getRegex(pattern).MatchString(keyNode.Value) {
+					propertySchema, found = patternSchema, true
+					break
+				}
+			}
+		}
+
+		if !found {
+			switch additionalProperties := schema.AdditionalProperties.(type) {
+			case *jsonSchema:
+				propertySchema, found = additionalProperties, true
+
+			case bool:
+				if !additionalProperties {
+					c.addError(keyNode, alias, fmt.Sprintf("unknown field (%s) in %s", keyNode.Value,
+						describeJsonSchema(schema)))
+				}
+			}
+		}
+
+		if found {
+			c.check(valueNode, propertySchema, alias)
+		}
+	}
+}
+
+func (c *yamlSchemaChecker) checkMerge(node *yaml.Node, schema *jsonSchema, alias *yaml.Node) {
+	if node.Kind == yaml.AliasNode {
+		alias = node
+		node = node.Alias
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		for _, item := range node.Content {
+			c.check(item, schema, alias)
+		}
+		return
+	}
+
+	c.check(node, schema, alias)
+}
+
+func (c *yamlSchemaChecker) checkString(node *yaml.Node, schema *jsonSchema, alias *yaml.Node) {
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, value := range schema.Enum {
+			if node.Value == value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			c.addError(node, alias, fmt.Sprintf("invalid value (%s): must be one of: %s", node.Value,
+				strings.Join(schema.Enum, ", ")))
+			return
+		}
+	}
+
+	if schema.Pattern != "" && !c.getRegex(schema.Pattern).MatchString(node.Value) {
+		c.addError(node, alias, fmt.Sprintf("invalid value (%s): expected %s", node.Value,
+			describeJsonSchema(schema)))
+	}
+}
+
+// checkAnyOf checks that the node matches at least one of the schema's options.
+func (c *yamlSchemaChecker) checkAnyOf(node *yaml.Node, schema *jsonSchema, alias *yaml.Node) {
+	for _, option := range schema.AnyOf {
+		optionChecker := yamlSchemaChecker{
+			defs:    c.defs,
+			checked: make(map[yamlSchemaCheckKey]bool),
+			regexes: c.regexes,
+		}
+		optionChecker.check(node, option, alias)
+		if len(optionChecker.errors) <= 0 {
+			return
+		}
+
+		// If the node is a collection, then report the errors of the first option that accepts that kind of node,
+		// since they are likely more useful than a generic error.
+		if node.Kind != yaml.ScalarNode && jsonSchemaAcceptsKind(c.resolveRef(option), node.Kind) {
+			c.errors = append(c.errors, optionChecker.errors...)
+			return
+		}
+	}
+
+	value := node.Value
+	if node.Kind != yaml.ScalarNode {
+		value = describeYamlNodeKind(node.Kind)
+	}
+
+	c.addError(node, alias, fmt.Sprintf("invalid value (%s): expected %s", value, describeJsonSchema(schema)))
+}
+
+func (c *yamlSchemaChecker) resolveRef(schema *jsonSchema) *jsonSchema {
+	for schema.Ref != "" {
+		schema = c.defs[strings.TrimPrefix(schema.Ref, jsonSchemaDefsRef)]
+	}
+	return schema
+}
+
+func (c *yamlSchemaChecker) getRegex(pattern string) *regexp.Regexp {
+	regex, ok := c.regexes[pattern]
+	if !ok {
+		regex = regexp.MustCompile(pattern)
+		c.regexes[pattern] = regex
+	}
+	return regex
+}
+
+func (c *yamlSchemaChecker) addKindError(node *yaml.Node, alias *yaml.Node, schema *jsonSchema) {
+	c.addError(node, alias, fmt.Sprintf("expected %s, got %s", describeJsonSchema(schema),
+		describeYamlNodeKind(node.Kind)))
+}
+
+func (c *yamlSchemaChecker) addError(node *yaml.Node, alias *yaml.Node, message string) {
+	if alias != nil {
+		c.errors = append(c.errors, fmt.Sprintf("line %d, column %d: %s (referenced by alias *%s at line %d)",
+			node.Line, node.Column, message, alias.Value, alias.Line))
+		return
+	}
+
+	c.errors = append(c.errors, fmt.Sprintf("line %d, column %d: %s", node.Line, node.Column, message))
+}
+
+func jsonSchemaAcceptsKind(schema *jsonSchema, kind yaml.Kind) bool {
+	switch schema.Type {
+	case jsonSchemaTypeObject:
+		return kind == yaml.MappingNode
+
+	case jsonSchemaTypeArray:
+		return kind == yaml.SequenceNode
+
+	default:
+		return kind == yaml.ScalarNode
+	}
+}
+
+// describeJsonSchema returns a short, human readable description of the values that a schema accepts.
+func describeJsonSchema(schema *jsonSchema) string {
+	switch {
+	case schema.Description != "":
+		return schema.Description
+
+	case schema.Title != "":
+		return schema.Title + " object"
+	}
+
+	switch schema.Type {
+	case jsonSchemaTypeObject:
+		return "a mapping"
+
+	case jsonSchemaTypeArray:
+		return "a list"
+
+	case jsonSchemaTypeInteger:
+		return "an integer"
+
+	case jsonSchemaTypeNumber:
+		return "a number"
+
+	case jsonSchemaTypeBoolean:
+		return "a boolean (true or false)"
+
+	case jsonSchemaTypeString:
+		return "a string"
+
+	default:
+		return "a value"
+	}
+}
+
+func describeYamlNodeKind(kind yaml.Kind) string {
+	switch kind {
+	case yaml.MappingNode:
+		return "a mapping"
+
+	case yaml.SequenceNode:
+		return "a list"
+
+	default:
+		return "a scalar"
+	}
+}
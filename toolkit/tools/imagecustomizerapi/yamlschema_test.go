@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalYamlSchemaEnum(t *testing.T) {
+	yamlString := `
+os:
+  selinux:
+    mode: enforced
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 4, column 11: invalid value (enforced): must be one of: disabled, "+
+		"enforcing, permissive, force-enforcing")
+}
+
+func TestUnmarshalYamlSchemaTypes(t *testing.T) {
+	yamlString := `
+os:
+  hostname: [a, b]
+  users:
+  - name: test
+    uid: abc
+  packages:
+    install: jq
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 3, column 13: expected a string, got a list")
+	assert.ErrorContains(t, err, "line 6, column 10: expected an integer, got a scalar")
+	assert.ErrorContains(t, err, "line 8, column 14: expected a list, got a scalar")
+}
+
+func TestUnmarshalYamlSchemaStructKind(t *testing.T) {
+	yamlString := `
+os:
+  selinux: enforcing
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 3, column 12: expected SELinux object, got a scalar")
+}
+
+func TestUnmarshalYamlSchemaAlias(t *testing.T) {
+	yamlString := `
+x-mode: &mode bogus
+
+os:
+  selinux:
+    mode: *mode
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 2, column 9: invalid value (bogus): must be one of: disabled, enforcing, "+
+		"permissive, force-enforcing (referenced by alias *mode at line 6)")
+}
+
+func TestUnmarshalYamlSchemaNull(t *testing.T) {
+	yamlString := `
+os:
+  hostname:
+  selinux:
+    mode:
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.NoError(t, err)
+}
+
+func TestUnmarshalYamlSchemaMap(t *testing.T) {
+	yamlString := `
+os:
+  modules:
+  - name: vfio
+    options:
+      enable_unsafe_noiommu_mode: [Y]
+`
+
+	var config Config
+	err := UnmarshalYaml([]byte(yamlString), &config)
+	assert.ErrorContains(t, err, "line 6, column 35: expected a string, got a list")
+}
@@ -26,6 +26,12 @@ const (
 	PartitionFlagBoot PartitionFlag = "boot"
 	// PartitionFlagDeviceMapperRoot indicates this partition will be used for a device mapper root device
 	PartitionFlagDeviceMapperRoot PartitionFlag = "dmroot"
+	// PartitionFlagLegacyBoot indicates this partition is bootable by legacy BIOS firmware
+	PartitionFlagLegacyBoot PartitionFlag = "legacy_boot"
+	// PartitionFlagHidden indicates this partition should be hidden from the OS
+	PartitionFlagHidden PartitionFlag = "hidden"
+	// PartitionFlagNoAutomount indicates this partition should not be automatically mounted by the OS
+	PartitionFlagNoAutomount PartitionFlag = "no_automount"
 )
 
 func (p PartitionFlag) String() string {
@@ -42,6 +48,9 @@ func (p *PartitionFlag) GetValidPartitionFlags() (types []PartitionFlag) {
 		PartitionFlagBiosGrubLegacy,
 		PartitionFlagBoot,
 		PartitionFlagDeviceMapperRoot,
+		PartitionFlagLegacyBoot,
+		PartitionFlagHidden,
+		PartitionFlagNoAutomount,
 	}
 }
 
@@ -19,6 +19,9 @@ var (
 		PartitionFlag("bios-grub"),
 		PartitionFlag("boot"),
 		PartitionFlag("dmroot"),
+		PartitionFlag("legacy_boot"),
+		PartitionFlag("hidden"),
+		PartitionFlag("no_automount"),
 	}
 	invalidPartitionFlag     = PartitionFlag("not_a_partition_flag")
 	validPartitionFlagJSON   = `"esp"`
@@ -1,3 +1,5 @@
+//go:build linux
+
 // Copyright (c) Microsoft Corporation.
 // Licensed under the MIT License.
 
@@ -108,21 +110,6 @@ const (
 	logicalPartitionType  = "logical"
 )
 
-// Unit to byte conversion values
-// See https://www.gnu.org/software/parted/manual/parted.html#unit
-const (
-	B  = 1
-	KB = 1000
-	MB = 1000 * 1000
-	GB = 1000 * 1000 * 1000
-	TB = 1000 * 1000 * 1000 * 1000
-
-	KiB = 1024
-	MiB = 1024 * 1024
-	GiB = 1024 * 1024 * 1024
-	TiB = 1024 * 1024 * 1024 * 1024
-)
-
 var (
 	sizeAndUnitRegexp = regexp.MustCompile(`(\d+)((Ki?|Mi?|Gi?|Ti?)?B)`)
 	diskDevPathRegexp = regexp.MustCompile(`^/dev/(\w+)$`)
@@ -732,6 +719,12 @@ func InitializeSinglePartition(diskDevPath string, partitionNumber int,
 			flagToSet = "bios_grub"
 		case configuration.PartitionFlagBoot:
 			flagToSet = "boot"
+		case configuration.PartitionFlagLegacyBoot:
+			flagToSet = "legacy_boot"
+		case configuration.PartitionFlagHidden:
+			flagToSet = "hidden"
+		case configuration.PartitionFlagNoAutomount:
+			flagToSet = "no_automount"
 		case configuration.PartitionFlagDeviceMapperRoot:
 			//Ignore, only used for internal tooling
 		default:
@@ -813,7 +806,10 @@ func FormatSinglePartition(partDevPath string, partition configuration.Partition
 		mkfsArgs = append(mkfsArgs, partDevPath)
 
 		err = retry.Run(func() error {
-			_, stderr, err := shell.Execute("mkfs", mkfsArgs...)
+			// Formatting a large partition can take a while. So, log mkfs's progress as it happens.
+			_, stderr, err := shell.NewExecBuilder("mkfs", mkfsArgs...).
+				LogLevel(logrus.DebugLevel, logrus.DebugLevel).
+				ExecuteCaptureOuput()
 			if err != nil {
 				logger.Log.Warnf("Failed to format partition using mkfs: %v", stderr)
 				return err
@@ -1,3 +1,5 @@
+//go:build linux
+
 // Copyright (c) Microsoft Corporation.
 // Licensed under the MIT License.
 
@@ -14,6 +16,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/configuration"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -182,7 +185,9 @@ func encryptRootPartition(partDevPath string, partition configuration.Partition,
 	mkfsArgs = append(mkfsArgs, fullMappedPath)
 
 	// Create the file system
-	_, stderr, err = shell.Execute("mkfs", mkfsArgs...)
+	_, stderr, err = shell.NewExecBuilder("mkfs", mkfsArgs...).
+		LogLevel(logrus.DebugLevel, logrus.DebugLevel).
+		ExecuteCaptureOuput()
 	if err != nil {
 		err = fmt.Errorf("failed to mkfs for partition (%v):\n%v\n%w", partDevPath, stderr, err)
 	}
@@ -0,0 +1,19 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package diskutils
+
+// Unit to byte conversion values
+// See https://www.gnu.org/software/parted/manual/parted.html#unit
+const (
+	B  = 1
+	KB = 1000
+	MB = 1000 * 1000
+	GB = 1000 * 1000 * 1000
+	TB = 1000 * 1000 * 1000 * 1000
+
+	KiB = 1024
+	MiB = 1024 * 1024
+	GiB = 1024 * 1024 * 1024
+	TiB = 1024 * 1024 * 1024 * 1024
+)
@@ -26,6 +26,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/resources"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safemount"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
@@ -534,6 +535,12 @@ func PopulateInstallRoot(installChroot *safechroot.Chroot, packagesToInstall []s
 	}
 	totalPackages := len(installedPackages.Repo)
 
+	// Report all the packages of the wrong architecture up front, rather than failing midway through the install.
+	err = validateInstallArchitectures(installedPackages)
+	if err != nil {
+		return
+	}
+
 	// Write out JSON file with list of packages included in the image
 	packageManifestPath := filepath.Join("/", PackageManifestRelativePath)
 	err = jsonutils.WriteJSONFile(packageManifestPath, installedPackages)
@@ -816,65 +823,38 @@ func calculateTotalPackages(packages []string, installRoot string) (installedPac
 		releaseverCliArg string
 	)
 	installedPackages = &repocloner.RepoContents{}
-	const tdnfAssumeNoStdErr = "Error(1032) : Operation aborted.\n"
 
 	releaseverCliArg, err = tdnf.GetReleaseverCliArg()
 	if err != nil {
 		return
 	}
 
+	// Note: --nogpgcheck is safe here because the transaction is only resolved and packages are validated
+	// during fetching when VALIDATE_IMAGE_GPG=y is set.
+	resolveArgs := []string{releaseverCliArg, "--nogpgcheck", "--installroot", installRoot}
+
 	// For every package calculate what dependencies would also be installed from it.
 	// checkedPackageSet contains a mapping of all package IDs (name, version, etc) to avoid calculating duplicates
 	checkedPackageSet := make(map[string]bool)
 	for _, pkg := range packages {
-		var (
-			stdout string
-			stderr string
-		)
+		var transaction *tdnf.Transaction
 
-		// Issue an install request but stop right before actually performing the install (assumeno).
-		// Note: --nogpgcheck is safe here because this is a dry-run (--assumeno) and packages are validated
-		// during fetching when VALIDATE_IMAGE_GPG=y is set.
-		stdout, stderr, err = shell.Execute("tdnf", "install", releaseverCliArg, "--assumeno", "--nogpgcheck", pkg, "--installroot", installRoot)
+		transaction, err = tdnf.ResolveInstall(resolveArgs, pkg)
 		if err != nil {
-			// tdnf aborts the process when it detects an install with --assumeno.
-			if stderr == tdnfAssumeNoStdErr {
-				err = nil
-			} else {
-				err = fmt.Errorf("%v", stderr)
-				return
-			}
+			return
 		}
 
-		splitStdout := strings.Split(stdout, "\n")
-
-		// Search for the list of packages to be installed,
-		// it will be prefixed with a line "Installing:" and will
-		// end with an empty line.
-		for _, line := range splitStdout {
-			matches := tdnf.InstallPackageRegex.FindStringSubmatch(line)
-			if len(matches) != tdnf.InstallPackageMaxMatchLen {
-				// This line contains output other than a package information; skip it
-				continue
-			}
-
-			pkg := &repocloner.RepoPackage{
-				Name:         matches[tdnf.InstallPackageName],
-				Version:      matches[tdnf.InstallPackageVersion],
-				Architecture: matches[tdnf.InstallPackageArch],
-				Distribution: matches[tdnf.InstallPackageDist],
-			}
-
-			pkgID := pkg.ID()
+		for _, resolvedPackage := range repocloner.RepoPackagesFromTransaction(transaction) {
+			pkgID := resolvedPackage.ID()
 			if checkedPackageSet[pkgID] {
-				logger.Log.Tracef("Skipping duplicate package: %s", line)
+				logger.Log.Tracef("Skipping duplicate package: %s", pkgID)
 				continue
 			}
 			checkedPackageSet[pkgID] = true
 
 			logger.Log.Debugf("Added installedPackages entry for: %v", pkgID)
 
-			installedPackages.Repo = append(installedPackages.Repo, pkg)
+			installedPackages.Repo = append(installedPackages.Repo, resolvedPackage)
 		}
 	}
 
@@ -883,6 +863,23 @@ func calculateTotalPackages(packages []string, installRoot string) (installedPac
 	return
 }
 
+// validateInstallArchitectures checks that the packages to be installed match the architecture of the image, which is
+// always built for the host's architecture.
+func validateInstallArchitectures(installedPackages *repocloner.RepoContents) (err error) {
+	hostArch, err := rpm.GetRpmArch(runtime.GOARCH)
+	if err != nil {
+		return
+	}
+
+	err = repocloner.ValidatePackageArchitectures(installedPackages.Repo, hostArch)
+	if err != nil {
+		err = fmt.Errorf("image packages can't be installed:\n%w", err)
+		return
+	}
+
+	return
+}
+
 // clearSystemdState clears the systemd state files that should be unique to each instance of the image. This is
 // based on https://systemd.io/BUILDING_IMAGES/. Primarily, this function will ensure that /etc/machine-id is configured
 // correctly, and that random seed and credential files are removed if they exist.
@@ -5,18 +5,22 @@ package main
 
 import (
 	"os"
+	"runtime"
 	"strings"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/configuration"
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/gpgkeyring"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner/rpmrepocloner"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repoutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 
@@ -50,8 +54,20 @@ var (
 	inputSummaryFile  = app.Flag("input-summary-file", "Path to a file with the summary of packages cloned to be restored").String()
 	outputSummaryFile = app.Flag("output-summary-file", "Path to save the summary of packages cloned").String()
 
+	inputLockfile  = app.Flag("input-lockfile", "Path to a lockfile of the exact packages to fetch. The cloned packages are synchronized with it, even if the repos have newer versions.").ExistingFile()
+	outputLockfile = app.Flag("output-lockfile", "Path to save a lockfile of the exact packages cloned").String()
+
+	packageCacheDir     = app.Flag("package-cache-dir", "Optional: directory of the shared package cache to take the upstream and locked packages from before downloading them, and to store the cloned packages in.").String()
+	packageCacheBuildID = app.Flag("package-cache-build-id", "ID of the build referencing the cloned packages in the shared package cache.").Default("image").String()
+
 	enableGpgCheck = app.Flag("enable-gpg-check", "Enable RPM GPG signature verification for all repositories during package fetching.").Bool()
 	gpgKeyPaths    = app.Flag("gpg-key", "Path to a GPG key file for signature validation. May be specified multiple times. Required if enable-gpg-check is set.").ExistingFiles()
+	gpgKeyring     = app.Flag("gpg-keyring", "Path to a keyring managed by the gpgkeyring tool. Every fetched package must be signed by one of its active pinned keys.").ExistingFile()
+	gpgReportFile  = app.Flag("gpg-report-file", "Path to save the per-package GPG verification report to, as JSON. Requires gpg-keyring.").String()
+
+	targetArch       = app.Flag("target-arch", "RPM architecture (e.g. x86_64) that the image's packages must match. Defaults to the host's architecture.").String()
+	disableArchCheck = app.Flag("disable-arch-check", "Don't check that the image's packages match the target architecture before downloading them.").Bool()
+	rootless         = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 
 	logFlags      = exe.SetupLogFlags(app)
 	profFlags     = exe.SetupProfileFlags(app)
@@ -63,6 +79,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, profErr := profile.StartProfiling(profFlags)
 	if profErr != nil {
 		logger.Log.Warnf("Could not start profiling: %s", profErr)
@@ -77,10 +98,22 @@ func main() {
 		logger.Log.Fatal("input-graph must be provided if external-only is set.")
 	}
 
+	if strings.TrimSpace(*inputLockfile) != "" && strings.TrimSpace(*inputSummaryFile) != "" {
+		logger.Log.Fatal("input-lockfile and input-summary-file are mutually exclusive.")
+	}
+
 	if *enableGpgCheck && len(*gpgKeyPaths) == 0 {
 		logger.Log.Fatal("--enable-gpg-check requires at least one --gpg-key path")
 	}
 
+	if *enableGpgCheck && *gpgKeyring != "" {
+		logger.Log.Fatal("enable-gpg-check and gpg-keyring are mutually exclusive.")
+	}
+
+	if *gpgReportFile != "" && *gpgKeyring == "" {
+		logger.Log.Fatal("gpg-report-file requires gpg-keyring.")
+	}
+
 	timestamp.StartEvent("initialize and configure cloner", nil)
 
 	cloner, err := rpmrepocloner.ConstructCloner(*outDir, *tmpDir, *workertar, *existingRpmDir, *existingToolchainRpmDir, *tlsClientCert, *tlsClientKey, *repoFiles, *repoSnapshotTime)
@@ -101,9 +134,33 @@ func main() {
 	}
 	cloner.SetEnabledRepos(enabledRepos)
 
+	if !*disableArchCheck {
+		imageArch := *targetArch
+		if imageArch == "" {
+			imageArch, err = rpm.GetRpmArch(runtime.GOARCH)
+			if err != nil {
+				logger.Log.Panicf("Failed to get the host's RPM architecture. Error: %s", err)
+			}
+		}
+		cloner.SetTargetArch(imageArch)
+	}
+
+	if strings.TrimSpace(*packageCacheDir) != "" {
+		cache, err := packagecache.Open(*packageCacheDir)
+		logger.PanicOnError(err, "Failed to open the package cache")
+		cloner.SetPackageCache(cache)
+	}
+
 	timestamp.StopEvent(nil) // initialize and configure cloner
 
-	if strings.TrimSpace(*inputSummaryFile) != "" {
+	if strings.TrimSpace(*inputLockfile) != "" {
+		timestamp.StartEvent("sync packages from lockfile", nil)
+
+		// If a lockfile was provided, only fetch the locked packages missing from the cache.
+		err = repoutils.SyncFromLockfile(cloner, *inputLockfile, *packageCacheDir)
+
+		timestamp.StopEvent(nil) // sync packages from lockfile
+	} else if strings.TrimSpace(*inputSummaryFile) != "" {
 		timestamp.StartEvent("restore packages", nil)
 
 		// If an input summary file was provided, simply restore the cache using the file.
@@ -126,6 +183,14 @@ func main() {
 		}
 	}
 
+	// Verify every package against the pinned keys, regardless of the repos' GPG settings
+	if *gpgKeyring != "" {
+		err = gpgkeyring.LoadAndVerifyDirectory(*gpgKeyring, cloner.CloneDirectory(), *gpgReportFile)
+		if err != nil {
+			logger.Log.Panicf("Failed to verify RPM signatures against the pinned keys. Error: %s", err)
+		}
+	}
+
 	timestamp.StartEvent("finalize cloned packages", nil)
 
 	err = cloner.ConvertDownloadedPackagesIntoRepo()
@@ -138,6 +203,16 @@ func main() {
 		logger.PanicOnError(err, "Failed to save cloned repo contents")
 	}
 
+	if strings.TrimSpace(*outputLockfile) != "" {
+		err = repoutils.SaveLockfile(cloner, *outputLockfile, *existingRpmDir, *existingToolchainRpmDir)
+		logger.PanicOnError(err, "Failed to save lockfile")
+	}
+
+	if strings.TrimSpace(*packageCacheDir) != "" {
+		err = repoutils.StoreClonedPackages(cloner, *packageCacheDir, *packageCacheBuildID)
+		logger.PanicOnError(err, "Failed to store cloned packages in the package cache")
+	}
+
 	timestamp.StopEvent(nil) // finalize cloned packages
 }
 
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/configuration"
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
@@ -21,6 +22,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/tdnf"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -115,6 +117,61 @@ func main() {
 
 	err = buildSystemConfig(systemConfig, config.Disks, *outputDir, *buildDir, *imgContentFile)
 	logger.PanicOnError(err, "Failed to build system configuration")
+
+	err = writeArtifactMetadata(systemConfig, *outputDir)
+	logger.PanicOnError(err, "Failed to write artifact metadata")
+}
+
+// writeArtifactMetadata writes the metadata sidecar of each disk and partition file in the output directory.
+func writeArtifactMetadata(systemConfig configuration.SystemConfig, outputDir string) (err error) {
+	const diskFilesPattern = "disk*"
+
+	if outputDir == "" {
+		return
+	}
+
+	diskFiles, err := filepath.Glob(filepath.Join(outputDir, diskFilesPattern))
+	if err != nil {
+		return
+	}
+
+	for _, diskFile := range diskFiles {
+		isFile, statErr := file.IsFile(diskFile)
+		if statErr != nil {
+			return statErr
+		}
+
+		if !isFile || strings.HasSuffix(diskFile, artifactmetadata.FileSuffix) {
+			continue
+		}
+
+		format := strings.TrimPrefix(filepath.Ext(diskFile), ".")
+		metadata, err := artifactmetadata.New("imager", exe.ToolkitVersion, diskFile, format)
+		if err != nil {
+			return err
+		}
+
+		err = metadata.AddInput(artifactmetadata.InputRoleConfig, *configFile)
+		if err != nil {
+			return err
+		}
+
+		err = metadata.AddInput(artifactmetadata.InputRolePackageList, *imgContentFile)
+		if err != nil {
+			return err
+		}
+
+		metadata.SetParameter("systemConfig", systemConfig.Name)
+		metadata.SetParameter("buildNumber", *buildNumber)
+		metadata.SetParameter("repoSnapshotTime", *repoSnapshotTime)
+
+		err = artifactmetadata.WriteSidecar(metadata, diskFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return
 }
 
 func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configuration.Disk, outputDir, buildDir string, imgContentFile string) (err error) {
@@ -235,9 +292,14 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 	if isOfflineInstall {
 		timestamp.StartEvent("create offline install env", nil)
 		// Create setup chroot
-		additionalExtraMountPoints := []*safechroot.MountPoint{
-			safechroot.NewMountPoint(*localRepo, localRepoMountPoint, "", safechroot.BindMountPointFlags, ""),
-			safechroot.NewMountPoint(filepath.Dir(*repoFile), repoFileMountPoint, "", safechroot.BindMountPointFlags, ""),
+		var additionalExtraMountPoints []*safechroot.MountPoint
+		additionalExtraMountPoints, err = safechroot.NewMountPointsFromSpecs([]safechroot.MountSpec{
+			{Source: *localRepo, Target: localRepoMountPoint, Bind: true},
+			{Source: filepath.Dir(*repoFile), Target: repoFileMountPoint, Bind: true},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to create setup chroot mount points:\n%w", err)
+			return
 		}
 		extraMountPoints = append(extraMountPoints, additionalExtraMountPoints...)
 
@@ -358,8 +420,12 @@ func setupRootFS(outputDir, installRoot string) (extraMountPoints []*safechroot.
 	}
 
 	// For a rootfs, bind-mount the output directory to the chroot directory being installed to
-	rootFSMountPoint := safechroot.NewMountPoint(rootFSOutDir, installRoot, "", safechroot.BindMountPointFlags, "")
-	extraMountPoints = []*safechroot.MountPoint{rootFSMountPoint}
+	extraMountPoints, err = safechroot.NewMountPointsFromSpecs([]safechroot.MountSpec{
+		{Source: rootFSOutDir, Target: installRoot, Bind: true},
+	})
+	if err != nil {
+		return
+	}
 	extraDirectories = []string{installRoot}
 
 	return
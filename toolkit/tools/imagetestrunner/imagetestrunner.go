@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// A tool for building a directory of image customizer configs against a matrix of base images and reporting the
+// results as a JUnit report.
+
+package main
+
+import (
+	"os"
+	"regexp"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagecustomizerlib"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagetestrunner"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	app = kingpin.New("imagetestrunner", "Builds image customizer configs against a matrix of base images and writes a JUnit report.")
+
+	configDir                = app.Flag("config-dir", "Directory containing the image config files (*.yaml, *.yml) to test.").Required().ExistingDir()
+	matrixFile               = app.Flag("matrix-file", "Path of the YAML file listing the base images to build each config against.").Required().ExistingFile()
+	buildDir                 = app.Flag("build-dir", "Directory to run the builds out of.").Required().String()
+	outputDir                = app.Flag("output-dir", "Directory to write the built images to.").Required().String()
+	outputImageFormat        = app.Flag("output-image-format", "Format of the built images.").Default("qcow2").Enum("vhd", "vhd-fixed", "vhdx", "qcow2", "qcow2-compressed", "raw", "iso")
+	rpmSources               = app.Flag("rpm-source", "Path to a RPM repo config file or a directory containing RPMs.").Strings()
+	disableBaseImageRpmRepos = app.Flag("disable-base-image-rpm-repos", "Disable the base image's RPM repos as an RPM source").Bool()
+	keepOutputImages         = app.Flag("keep-output-images", "Keep the built images after they have been tested.").Bool()
+	smokeTest                = app.Flag("smoke-test", "Boot each built image in a QEMU VM and check that it reaches the expected console output.").Bool()
+	smokeTestTimeout         = app.Flag("smoke-test-timeout", "How long to wait for each VM to reach the expected console output.").Default(imagetestrunner.DefaultSmokeTestTimeout.String()).Duration()
+	smokeTestPattern         = app.Flag("smoke-test-pattern", "Regex that the VM's serial console output must match.").Default(imagetestrunner.DefaultSmokeTestPattern).String()
+	junitReport              = app.Flag("junit-report", "Path to write the JUnit report to.").Required().String()
+	logFlags                 = exe.SetupLogFlags(app)
+)
+
+func main() {
+	app.Version(imagecustomizerlib.ToolVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	pattern, err := regexp.Compile(*smokeTestPattern)
+	if err != nil {
+		logger.Log.Fatalf("Invalid --smoke-test-pattern (%s):\n%v", *smokeTestPattern, err)
+	}
+
+	matrix, err := imagetestrunner.LoadMatrix(*matrixFile)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+
+	configFiles, err := imagetestrunner.FindConfigs(*configDir)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+
+	options := imagetestrunner.Options{
+		BuildDir:             *buildDir,
+		OutputDir:            *outputDir,
+		OutputImageFormat:    *outputImageFormat,
+		RpmSources:           *rpmSources,
+		UseBaseImageRpmRepos: !*disableBaseImageRpmRepos,
+		KeepOutputImages:     *keepOutputImages,
+		SmokeTest: imagetestrunner.SmokeTestOptions{
+			Enabled: *smokeTest,
+			Timeout: *smokeTestTimeout,
+			Pattern: pattern,
+		},
+	}
+
+	results := imagetestrunner.Run(matrix, configFiles, options)
+
+	report := imagetestrunner.CreateJUnitReport(results)
+	err = imagetestrunner.WriteJUnitReport(report, *junitReport)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+
+	logger.Log.Infof("Tests: %d, failures: %d", report.Tests, report.Failures)
+
+	if report.Failures > 0 {
+		logger.Log.Fatalf("%d of %d tests failed. See (%s) for details.", report.Failures, report.Tests, *junitReport)
+	}
+}
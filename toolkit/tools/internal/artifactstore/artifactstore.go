@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package artifactstore abstracts the storage of build caches and artifacts, so that a fleet of builders can share
+// caches and publish artifacts through a local directory, an NFS share, or Azure Blob Storage.
+package artifactstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/azureblobstorage"
+)
+
+const (
+	// The URL schemes that are accepted by Open.
+	FileScheme  = "file"
+	NfsScheme   = "nfs"
+	HttpsScheme = "https"
+
+	// DefaultLeaseDuration is how long an NFS lock is valid for before it must be renewed. A lock whose holder stops
+	// renewing it (e.g. because the builder crashed) may be broken by another builder once its lease has expired.
+	DefaultLeaseDuration = 2 * time.Minute
+
+	// The suffix that is appended to a key to get the name of its lock.
+	lockSuffix = ".lock"
+
+	// How often a blocked Lock call retries acquiring the lock.
+	lockPollInterval = 500 * time.Millisecond
+)
+
+// ErrNotFound is returned (wrapped) when a key doesn't exist in the store.
+var ErrNotFound = errors.New("not found in artifact store")
+
+// Store is a flat key-value store of files.
+//
+// Keys are relative, slash separated paths (e.g. "x86_64/latest/kernel-ccache.tar.gz").
+type Store interface {
+	// Download copies the file stored under the key to a local file.
+	// Returns an error that wraps ErrNotFound if the key doesn't exist.
+	Download(ctx context.Context, key string, localPath string) error
+
+	// Upload copies a local file into the store under the key, replacing any existing file. Readers never see a
+	// partially uploaded file.
+	Upload(ctx context.Context, localPath string, key string) error
+
+	// Exists returns true if the key exists in the store.
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// Delete removes the key from the store. Deleting a key that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns the keys (sorted) that start with the prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Lock takes an exclusive lock on the key, blocking until the lock is acquired or the context is cancelled. The
+	// lock is advisory and is only honored by other callers of Lock.
+	Lock(ctx context.Context, key string) (Lock, error)
+
+	// String returns a description of the store, for logging.
+	String() string
+}
+
+// Lock is a held lock on a key of a Store.
+type Lock interface {
+	// Unlock releases the lock.
+	Unlock() error
+}
+
+// Open opens the store at a location:
+//
+//   - A local directory: /path/to/dir or file:///path/to/dir
+//   - A directory on an NFS share: nfs:///path/to/mounted/dir
+//   - An Azure Blob Storage container: https://<account>.blob.core.windows.net/<container>[/<prefix>]
+//
+// Azure Blob Storage is accessed using the Azure CLI's credentials.
+func Open(location string) (Store, error) {
+	if location == "" {
+		return nil, fmt.Errorf("artifact store location is empty")
+	}
+
+	if !strings.Contains(location, "://") {
+		return NewLocalStore(location)
+	}
+
+	parsedUrl, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse artifact store location (%s):\n%w", location, err)
+	}
+
+	switch parsedUrl.Scheme {
+	case FileScheme:
+		return NewLocalStore(parsedUrl.Path)
+
+	case NfsScheme:
+		return NewNfsStore(parsedUrl.Path, DefaultLeaseDuration)
+
+	case HttpsScheme:
+		_, containerName, prefix, err := azureblobstorage.ParseAzureBlobStorageURL(location)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := azureblobstorage.CreateFromURL(location)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewAzureBlobStore(client, containerName, prefix), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported artifact store location (%s): scheme must be one of: %s, %s, %s",
+			location, FileScheme, NfsScheme, HttpsScheme)
+	}
+}
+
+// validateKey checks that a key is a relative path that stays within the store.
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("artifact store key is empty")
+	}
+
+	if path.IsAbs(key) || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid artifact store key (%s): must be a clean, relative path", key)
+	}
+
+	if strings.HasSuffix(key, lockSuffix) {
+		return fmt.Errorf("invalid artifact store key (%s): must not end with (%s)", key, lockSuffix)
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package artifactstore
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/azureblobstorage"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// The duration of a blob lease. Azure Blob Storage only allows between 15 and 60 seconds (or infinite).
+const azureBlobLeaseSeconds = 60
+
+// AzureBlobStore is a Store that is backed by an Azure Blob Storage container.
+//
+// Uploads of block blobs are committed atomically by the service. Locks are blob leases on an empty lock blob, which
+// the service expires if the holder stops renewing them.
+type AzureBlobStore struct {
+	client        *azureblobstorage.AzureBlobStorage
+	containerName string
+	// The prefix that is prepended to all the keys, for sharing a container between multiple stores.
+	prefix string
+}
+
+// NewAzureBlobStore creates a store that is backed by an Azure Blob Storage container. The prefix may be empty.
+func NewAzureBlobStore(client *azureblobstorage.AzureBlobStorage, containerName string, prefix string,
+) *AzureBlobStore {
+	return &AzureBlobStore{
+		client:        client,
+		containerName: containerName,
+		prefix:        strings.Trim(prefix, "/"),
+	}
+}
+
+func (s *AzureBlobStore) String() string {
+	return path.Join(s.containerName, s.prefix)
+}
+
+func (s *AzureBlobStore) blobName(key string) (string, error) {
+	err := validateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(s.prefix, key), nil
+}
+
+func (s *AzureBlobStore) Download(ctx context.Context, key string, localPath string) error {
+	blobName, err := s.blobName(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.client.Download(ctx, s.containerName, blobName, localPath)
+	if azureblobstorage.IsNotFoundError(err) {
+		return fmt.Errorf("failed to download (%s) from (%s):\n%w", key, s, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download (%s) from (%s):\n%w", key, s, err)
+	}
+
+	return nil
+}
+
+func (s *AzureBlobStore) Upload(ctx context.Context, localPath string, key string) error {
+	blobName, err := s.blobName(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.client.Upload(ctx, localPath, s.containerName, blobName)
+	if err != nil {
+		return fmt.Errorf("failed to upload (%s) to (%s):\n%w", key, s, err)
+	}
+
+	return nil
+}
+
+func (s *AzureBlobStore) Exists(ctx context.Context, key string) (bool, error) {
+	blobName, err := s.blobName(key)
+	if err != nil {
+		return false, err
+	}
+
+	return s.client.Exists(ctx, s.containerName, blobName)
+}
+
+func (s *AzureBlobStore) Delete(ctx context.Context, key string) error {
+	blobName, err := s.blobName(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.client.Delete(ctx, s.containerName, blobName)
+	if err != nil && !azureblobstorage.IsNotFoundError(err) {
+		return fmt.Errorf("failed to delete (%s) from (%s):\n%w", key, s, err)
+	}
+
+	return nil
+}
+
+func (s *AzureBlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	storePrefix := ""
+	if s.prefix != "" {
+		storePrefix = s.prefix + "/"
+	}
+
+	blobNames, err := s.client.List(ctx, s.containerName, storePrefix+prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list (%s):\n%w", s, err)
+	}
+
+	keys := []string(nil)
+	for _, blobName := range blobNames {
+		if strings.HasSuffix(blobName, lockSuffix) {
+			continue
+		}
+
+		keys = append(keys, strings.TrimPrefix(blobName, storePrefix))
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *AzureBlobStore) Lock(ctx context.Context, key string) (Lock, error) {
+	blobName, err := s.blobName(key)
+	if err != nil {
+		return nil, err
+	}
+
+	lockBlobName := blobName + lockSuffix
+
+	// A lease can only be taken on a blob that exists.
+	exists, err := s.client.Exists(ctx, s.containerName, lockBlobName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock (%s) in (%s):\n%w", key, s, err)
+	}
+
+	if !exists {
+		err = s.client.UploadBuffer(ctx, nil, s.containerName, lockBlobName)
+		// If another builder created and leased the lock blob in the meantime, then the upload fails because the
+		// request doesn't have the lease.
+		if err != nil && !bloberror.HasCode(err, bloberror.LeaseIDMissing) {
+			return nil, fmt.Errorf("failed to create lock blob (%s):\n%w", lockBlobName, err)
+		}
+	}
+
+	leaseClient, err := lease.NewBlobClient(s.client.BlobClient(s.containerName, lockBlobName), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lease client:\n%w", err)
+	}
+
+	for {
+		_, err = leaseClient.AcquireLease(ctx, azureBlobLeaseSeconds, nil)
+		if err == nil {
+			break
+		}
+
+		if !bloberror.HasCode(err, bloberror.LeaseAlreadyPresent) {
+			return nil, fmt.Errorf("failed to lock (%s) in (%s):\n%w", key, s, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to lock (%s) in (%s):\n%w", key, s, ctx.Err())
+
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	lock := &azureBlobLock{
+		blobName:    lockBlobName,
+		leaseClient: leaseClient,
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go lock.renew()
+
+	return lock, nil
+}
+
+// azureBlobLock is a held blob lease, which is renewed in the background until the lock is released.
+type azureBlobLock struct {
+	blobName    string
+	leaseClient *lease.BlobClient
+	stop        chan struct{}
+	stopped     chan struct{}
+}
+
+func (l *azureBlobLock) renew() {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(azureBlobLeaseSeconds * time.Second / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+
+		case <-ticker.C:
+			_, err := l.leaseClient.RenewLease(context.Background(), nil)
+			if err != nil {
+				logger.Log.Warnf("Failed to renew lease on (%s):\n%v", l.blobName, err)
+			}
+		}
+	}
+}
+
+func (l *azureBlobLock) Unlock() error {
+	close(l.stop)
+	<-l.stopped
+
+	_, err := l.leaseClient.ReleaseLease(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to release lease on (%s):\n%w", l.blobName, err)
+	}
+
+	return nil
+}
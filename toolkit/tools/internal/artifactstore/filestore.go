@@ -0,0 +1,345 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package artifactstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/leaselock"
+	"golang.org/x/sys/unix"
+)
+
+// The prefix of the temporary files that uploads are written to, before they are renamed into place.
+const fileStoreTempPrefix = ".tmp-"
+
+// FileStore is a Store that is backed by a directory.
+//
+// Uploads are written to a temporary file in the destination directory and then renamed into place, which is atomic on
+// both local filesystems and NFS.
+//
+// Locks are flock(2) locks for local directories. flock(2) isn't reliable across NFS clients. So, for NFS shares, a
+// lock is a lease lock (see the leaselock package).
+type FileStore struct {
+	rootDir string
+	nfsSafe bool
+	// How long an NFS lock is valid for, without being renewed.
+	leaseDuration time.Duration
+}
+
+// NewLocalStore creates a store that is backed by a local directory.
+func NewLocalStore(rootDir string) (*FileStore, error) {
+	return newFileStore(rootDir, false, 0)
+}
+
+// NewNfsStore creates a store that is backed by a directory on an NFS share.
+//
+// Since a lock's lease expiry is compared against the local clock, the clocks of the builders that share the store
+// must be synchronized.
+func NewNfsStore(rootDir string, leaseDuration time.Duration) (*FileStore, error) {
+	if leaseDuration <= 0 {
+		return nil, fmt.Errorf("invalid NFS lock lease duration (%s): must be positive", leaseDuration)
+	}
+
+	return newFileStore(rootDir, true, leaseDuration)
+}
+
+func newFileStore(rootDir string, nfsSafe bool, leaseDuration time.Duration) (*FileStore, error) {
+	if rootDir == "" {
+		return nil, fmt.Errorf("artifact store directory is empty")
+	}
+
+	rootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of artifact store directory:\n%w", err)
+	}
+
+	err = os.MkdirAll(rootDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create artifact store directory (%s):\n%w", rootDir, err)
+	}
+
+	store := &FileStore{
+		rootDir:       rootDir,
+		nfsSafe:       nfsSafe,
+		leaseDuration: leaseDuration,
+	}
+	return store, nil
+}
+
+func (s *FileStore) String() string {
+	if s.nfsSafe {
+		return NfsScheme + "://" + s.rootDir
+	}
+	return FileScheme + "://" + s.rootDir
+}
+
+func (s *FileStore) keyPath(key string) (string, error) {
+	err := validateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.rootDir, filepath.FromSlash(key)), nil
+}
+
+func (s *FileStore) Download(ctx context.Context, key string, localPath string) error {
+	storePath, err := s.keyPath(key)
+	if err != nil {
+		return err
+	}
+
+	source, err := os.Open(storePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to download (%s) from (%s):\n%w", key, s, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open (%s):\n%w", storePath, err)
+	}
+	defer source.Close()
+
+	err = writeFileAtomic(localPath, source)
+	if err != nil {
+		return fmt.Errorf("failed to download (%s) from (%s):\n%w", key, s, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) Upload(ctx context.Context, localPath string, key string) error {
+	storePath, err := s.keyPath(key)
+	if err != nil {
+		return err
+	}
+
+	source, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file for upload:\n%w", err)
+	}
+	defer source.Close()
+
+	err = writeFileAtomic(storePath, source)
+	if err != nil {
+		return fmt.Errorf("failed to upload (%s) to (%s):\n%w", key, s, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) Exists(ctx context.Context, key string) (bool, error) {
+	storePath, err := s.keyPath(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(storePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat (%s):\n%w", storePath, err)
+	}
+
+	return true, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	storePath, err := s.keyPath(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(storePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete (%s) from (%s):\n%w", key, s, err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string(nil)
+	err := filepath.WalkDir(s.rootDir, func(walkPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		name := entry.Name()
+		if strings.HasPrefix(name, fileStoreTempPrefix) || strings.HasSuffix(name, lockSuffix) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.rootDir, walkPath)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relPath)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list (%s):\n%w", s, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *FileStore) Lock(ctx context.Context, key string) (Lock, error) {
+	storePath, err := s.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	lockPath := storePath + lockSuffix
+
+	err = os.MkdirAll(filepath.Dir(lockPath), os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock directory:\n%w", err)
+	}
+
+	var lock Lock
+	for {
+		if s.nfsSafe {
+			lock, err = s.tryLockNfs(lockPath)
+		} else {
+			lock, err = tryLockLocal(lockPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock (%s) in (%s):\n%w", key, s, err)
+		}
+		if lock != nil {
+			return lock, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to lock (%s) in (%s):\n%w", key, s, ctx.Err())
+
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// localLock is a flock(2) lock on a lock file.
+type localLock struct {
+	lockFile *os.File
+}
+
+// tryLockLocal tries to take a flock(2) lock on the lock file. Returns nil if the lock is held by someone else.
+func tryLockLocal(lockPath string) (Lock, error) {
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file (%s):\n%w", lockPath, err)
+	}
+
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		lockFile.Close()
+		return nil, nil
+	}
+	if err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to lock (%s):\n%w", lockPath, err)
+	}
+
+	return &localLock{lockFile: lockFile}, nil
+}
+
+func (l *localLock) Unlock() error {
+	// Closing the file releases the lock.
+	err := l.lockFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unlock (%s):\n%w", l.lockFile.Name(), err)
+	}
+
+	return nil
+}
+
+// nfsLock is a held NFS lock file.
+type nfsLock struct {
+	lock *leaselock.Lock
+}
+
+// tryLockNfs tries to create the lock file. Returns nil if the lock is held by someone else.
+//
+// If the existing lock's lease has expired or its holder has crashed, then the lock is broken and the lock file is
+// created again.
+func (s *FileStore) tryLockNfs(lockPath string) (Lock, error) {
+	lock, _, err := leaselock.TryAcquire(lockPath, s.leaseDuration)
+	if err != nil || lock == nil {
+		return nil, err
+	}
+
+	return &nfsLock{lock: lock}, nil
+}
+
+func (l *nfsLock) Unlock() error {
+	return l.lock.Release()
+}
+
+// writeFileAtomic writes the contents of the reader to a temporary file next to the destination and then renames it
+// into place.
+func writeFileAtomic(destinationPath string, source io.Reader) (err error) {
+	destinationDir := filepath.Dir(destinationPath)
+
+	err = os.MkdirAll(destinationDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create directory (%s):\n%w", destinationDir, err)
+	}
+
+	tempFile, err := os.CreateTemp(destinationDir, fileStoreTempPrefix+filepath.Base(destinationPath)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file:\n%w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	_, err = io.Copy(tempFile, source)
+	if err != nil {
+		return fmt.Errorf("failed to write (%s):\n%w", tempFile.Name(), err)
+	}
+
+	err = tempFile.Chmod(0o644)
+	if err != nil {
+		return err
+	}
+
+	err = tempFile.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync (%s):\n%w", tempFile.Name(), err)
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close (%s):\n%w", tempFile.Name(), err)
+	}
+
+	err = os.Rename(tempFile.Name(), destinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to rename (%s) to (%s):\n%w", tempFile.Name(), destinationPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package artifactstore
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/leaselock"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func testStores(t *testing.T) map[string]Store {
+	localStore, err := NewLocalStore(filepath.Join(t.TempDir(), "local"))
+	assert.NoError(t, err)
+
+	nfsStore, err := NewNfsStore(filepath.Join(t.TempDir(), "nfs"), time.Minute)
+	assert.NoError(t, err)
+
+	return map[string]Store{
+		"local": localStore,
+		"nfs":   nfsStore,
+	}
+}
+
+func TestFileStoreUploadDownload(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			localDir := t.TempDir()
+
+			sourcePath := filepath.Join(localDir, "source.txt")
+			err := os.WriteFile(sourcePath, []byte("contents"), 0o644)
+			assert.NoError(t, err)
+
+			exists, err := store.Exists(ctx, "x86_64/latest/kernel.tar.gz")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			err = store.Upload(ctx, sourcePath, "x86_64/latest/kernel.tar.gz")
+			assert.NoError(t, err)
+
+			exists, err = store.Exists(ctx, "x86_64/latest/kernel.tar.gz")
+			assert.NoError(t, err)
+			assert.True(t, exists)
+
+			downloadPath := filepath.Join(localDir, "downloads", "kernel.tar.gz")
+			err = store.Download(ctx, "x86_64/latest/kernel.tar.gz", downloadPath)
+			assert.NoError(t, err)
+
+			contents, err := os.ReadFile(downloadPath)
+			assert.NoError(t, err)
+			assert.Equal(t, "contents", string(contents))
+
+			err = store.Delete(ctx, "x86_64/latest/kernel.tar.gz")
+			assert.NoError(t, err)
+
+			// Deleting a missing key is not an error.
+			err = store.Delete(ctx, "x86_64/latest/kernel.tar.gz")
+			assert.NoError(t, err)
+
+			err = store.Download(ctx, "x86_64/latest/kernel.tar.gz", downloadPath)
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestFileStoreList(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			sourcePath := filepath.Join(t.TempDir(), "source.txt")
+			err := os.WriteFile(sourcePath, []byte("contents"), 0o644)
+			assert.NoError(t, err)
+
+			for _, key := range []string{"b/2", "a/1", "a/2", "c"} {
+				err = store.Upload(ctx, sourcePath, key)
+				assert.NoError(t, err)
+			}
+
+			lock, err := store.Lock(ctx, "a/3")
+			assert.NoError(t, err)
+			defer lock.Unlock()
+
+			keys, err := store.List(ctx, "")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a/1", "a/2", "b/2", "c"}, keys)
+
+			keys, err = store.List(ctx, "a/")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a/1", "a/2"}, keys)
+		})
+	}
+}
+
+func TestFileStoreInvalidKey(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, key := range []string{"", "/abs", "../escape", "a/../../b", "a//b", "a.lock"} {
+		_, err = store.Exists(context.Background(), key)
+		assert.Error(t, err, key)
+	}
+}
+
+func TestFileStoreLockIsExclusive(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			lock, err := store.Lock(context.Background(), "cache")
+			assert.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*lockPollInterval)
+			defer cancel()
+
+			_, err = store.Lock(ctx, "cache")
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+			err = lock.Unlock()
+			assert.NoError(t, err)
+
+			lock, err = store.Lock(context.Background(), "cache")
+			assert.NoError(t, err)
+
+			err = lock.Unlock()
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNfsStoreBreaksExpiredLock(t *testing.T) {
+	rootDir := t.TempDir()
+	store, err := NewNfsStore(rootDir, time.Minute)
+	assert.NoError(t, err)
+
+	// Simulate a builder that crashed while holding the lock.
+	leaseData, err := json.Marshal(leaselock.Lease{
+		Token:    "crashed",
+		Hostname: "builder",
+		Pid:      1,
+		Expires:  time.Now().Add(-time.Second),
+	})
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "cache"+lockSuffix), leaseData, 0o644)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lock, err := store.Lock(ctx, "cache")
+	assert.NoError(t, err)
+
+	err = lock.Unlock()
+	assert.NoError(t, err)
+}
+
+func TestNfsStoreKeepsUnexpiredLock(t *testing.T) {
+	rootDir := t.TempDir()
+	store, err := NewNfsStore(rootDir, time.Minute)
+	assert.NoError(t, err)
+
+	leaseData, err := json.Marshal(leaselock.Lease{
+		Token:    "other",
+		Hostname: "builder",
+		Pid:      1,
+		Expires:  time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "cache"+lockSuffix), leaseData, 0o644)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*lockPollInterval)
+	defer cancel()
+
+	_, err = store.Lock(ctx, "cache")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestOpen(t *testing.T) {
+	rootDir := t.TempDir()
+
+	store, err := Open(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "file://"+rootDir, store.String())
+
+	store, err = Open("nfs://" + rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "nfs://"+rootDir, store.String())
+
+	_, err = Open("ftp://example.com/cache")
+	assert.ErrorContains(t, err, "unsupported artifact store location")
+
+	_, err = Open("")
+	assert.Error(t, err)
+}
@@ -15,6 +15,8 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
@@ -163,6 +165,76 @@ func (abs *AzureBlobStorage) Delete(
 	return nil
 }
 
+// UploadBuffer uploads the contents of a buffer to a blob, overwriting the blob if it already exists.
+func (abs *AzureBlobStorage) UploadBuffer(
+	ctx context.Context,
+	buffer []byte,
+	containerName string,
+	blobName string) (err error) {
+
+	_, err = abs.theClient.UploadBuffer(ctx, containerName, blobName, buffer, nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload buffer to blob:\n%w", err)
+	}
+
+	return nil
+}
+
+// Exists returns true if the blob exists.
+func (abs *AzureBlobStorage) Exists(
+	ctx context.Context,
+	containerName string,
+	blobName string) (exists bool, err error) {
+
+	_, err = abs.BlobClient(containerName, blobName).GetProperties(ctx, nil)
+	if err != nil {
+		if IsNotFoundError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get blob properties:\n%w", err)
+	}
+
+	return true, nil
+}
+
+// List returns the names of all the blobs in the container whose names start with the prefix.
+func (abs *AzureBlobStorage) List(
+	ctx context.Context,
+	containerName string,
+	prefix string) (blobNames []string, err error) {
+
+	pager := abs.theClient.NewListBlobsFlatPager(containerName, &azblob.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs:\n%w", err)
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name != nil {
+				blobNames = append(blobNames, *item.Name)
+			}
+		}
+	}
+
+	return blobNames, nil
+}
+
+// BlobClient returns the SDK client of a single blob, for operations (e.g. leases) that are not wrapped by
+// AzureBlobStorage.
+func (abs *AzureBlobStorage) BlobClient(containerName string, blobName string) *blob.Client {
+	return abs.theClient.ServiceClient().NewContainerClient(containerName).NewBlobClient(blobName)
+}
+
+// IsNotFoundError returns true if the error was caused by a blob or container that doesn't exist.
+func IsNotFoundError(err error) bool {
+	return bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) ||
+		(err != nil && strings.Contains(err.Error(), AzureSDK404ErrorPattern))
+}
+
 func Create(tenantId string, userName string, password string, storageAccount string, authenticationType int) (abs *AzureBlobStorage, err error) {
 	url := "https://" + storageAccount + ".blob.core.windows.net/"
 
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/artifactstore"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/azureblobstorage"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/directory"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
@@ -92,6 +93,11 @@ const (
 	UninitializedGroupName         = "unknown"
 	UninitializedGroupSize         = 0
 	UninitializedGroupArchitecture = "unknown"
+
+	// The supported remote store types.
+	RemoteStoreTypeAzureBlobStorage = "azure-blob-storage"
+	RemoteStoreTypeLocal            = "local"
+	RemoteStoreTypeNfs              = "nfs"
 )
 
 // RemoveStoreConfig holds the following:
@@ -100,10 +106,15 @@ const (
 // - The behavior of the upload to the remote store.
 // - The clean-up policy of the remote store.
 type RemoteStoreConfig struct {
-	// The remote store type. Currently, there is only one type support;
-	// Azure blob storage.
+	// The remote store type. One of (defaults to azure-blob-storage):
+	// - azure-blob-storage: an Azure blob storage container.
+	// - local: a local directory.
+	// - nfs: a directory on an NFS share that is shared between builders.
 	Type string `json:"type"`
 
+	// The directory of the remote store, for the local and nfs types.
+	Path string `json:"path"`
+
 	// Azure subscription tenant id.
 	TenantId string `json:"tenantId"`
 
@@ -220,12 +231,14 @@ type CCacheManager struct {
 	// for uploading them.
 	LocalUploadsDir string
 
+	// Folder holding the ccache statistics of each package build.
+	StatsDir string
+
 	// Pointer to the current active pkg group state/configuration.
 	CurrentPkgGroup *CCachePkgGroup
 
-	// A utility helper for downloading/uploading archives from/to Azure blob
-	// storage.
-	AzureBlobStorage *azureblobstorage.AzureBlobStorage
+	// The store that archives are downloaded from and uploaded to.
+	RemoteStore artifactstore.Store
 }
 
 func buildRemotePath(arch, folder, name, suffix string) string {
@@ -274,14 +287,14 @@ func (g *CCachePkgGroup) UpdateTarPaths(remoteStoreConfig *RemoteStoreConfig, lo
 	g.TarFile = tarFile
 }
 
-func (g *CCachePkgGroup) getLatestTag(azureBlobStorage *azureblobstorage.AzureBlobStorage, containerName string) (string, error) {
+func (g *CCachePkgGroup) getLatestTag(remoteStore artifactstore.Store) (string, error) {
 
 	logger.Log.Infof("  checking if (%s) already exists...", g.TagFile.LocalSourcePath)
 	_, err := os.Stat(g.TagFile.LocalSourcePath)
 	if err != nil {
 		// If file is not available locally, try downloading it...
 		logger.Log.Infof("  downloading (%s) to (%s)...", g.TagFile.RemoteSourcePath, g.TagFile.LocalSourcePath)
-		err = azureBlobStorage.Download(context.Background(), containerName, g.TagFile.RemoteSourcePath, g.TagFile.LocalSourcePath)
+		err = remoteStore.Download(context.Background(), g.TagFile.RemoteSourcePath, g.TagFile.LocalSourcePath)
 		if err != nil {
 			return "", fmt.Errorf("Unable to download ccache tag file:\n%w", err)
 		}
@@ -335,7 +348,7 @@ func (m *CCacheManager) setCurrentPkgGroupInternal(groupName string, groupEnable
 		if m.Configuration.RemoteStoreConfig.DownloadLatest {
 
 			logger.Log.Infof("  ccache is configured to use the latest from the remote store...")
-			latestTag, err := ccachePkgGroup.getLatestTag(m.AzureBlobStorage, m.Configuration.RemoteStoreConfig.ContainerName)
+			latestTag, err := ccachePkgGroup.getLatestTag(m.RemoteStore)
 			if err == nil {
 				// Adjust the download folder from 'latest' to the tag loaded from the file...
 				logger.Log.Infof("  updating (%s) to (%s)...", m.Configuration.RemoteStoreConfig.DownloadFolder, latestTag)
@@ -433,6 +446,43 @@ func uncompressFile(archiveName string, targetDir string) (err error) {
 	return nil
 }
 
+func createRemoteStore(remoteStoreConfig *RemoteStoreConfig) (artifactstore.Store, error) {
+	switch remoteStoreConfig.Type {
+	case RemoteStoreTypeAzureBlobStorage, "":
+		logger.Log.Infof("  creating blob storage client...")
+		accessType := azureblobstorage.AnonymousAccess
+		if remoteStoreConfig.UploadEnabled {
+			accessType = azureblobstorage.AzureCLIAccess
+		}
+
+		azureBlobStorage, err := azureblobstorage.Create(remoteStoreConfig.TenantId, remoteStoreConfig.UserName, remoteStoreConfig.Password, remoteStoreConfig.StorageAccount, accessType)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to init azure blob storage client:\n%w", err)
+		}
+
+		return artifactstore.NewAzureBlobStore(azureBlobStorage, remoteStoreConfig.ContainerName, ""), nil
+
+	case RemoteStoreTypeLocal:
+		localStore, err := artifactstore.NewLocalStore(remoteStoreConfig.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to init local remote store:\n%w", err)
+		}
+
+		return localStore, nil
+
+	case RemoteStoreTypeNfs:
+		nfsStore, err := artifactstore.NewNfsStore(remoteStoreConfig.Path, artifactstore.DefaultLeaseDuration)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to init nfs remote store:\n%w", err)
+		}
+
+		return nfsStore, nil
+
+	default:
+		return nil, fmt.Errorf("Unsupported remote store type (%s).", remoteStoreConfig.Type)
+	}
+}
+
 func CreateManager(rootDir string, configFileName string) (m *CCacheManager, err error) {
 	logger.Log.Infof("* Creating a ccache manager instance *")
 	logger.Log.Infof("  ccache root folder         : (%s)", rootDir)
@@ -451,15 +501,9 @@ func CreateManager(rootDir string, configFileName string) (m *CCacheManager, err
 		return nil, fmt.Errorf("Failed to load remote store configuration:\n%w", err)
 	}
 
-	logger.Log.Infof("  creating blob storage client...")
-	accessType := azureblobstorage.AnonymousAccess
-	if configuration.RemoteStoreConfig.UploadEnabled {
-		accessType = azureblobstorage.AzureCLIAccess
-	}
-
-	azureBlobStorage, err := azureblobstorage.Create(configuration.RemoteStoreConfig.TenantId, configuration.RemoteStoreConfig.UserName, configuration.RemoteStoreConfig.Password, configuration.RemoteStoreConfig.StorageAccount, accessType)
+	remoteStore, err := createRemoteStore(configuration.RemoteStoreConfig)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to init azure blob storage client:\n%w", err)
+		return nil, err
 	}
 
 	err = directory.EnsureDirExists(rootDir)
@@ -491,7 +535,8 @@ func CreateManager(rootDir string, configFileName string) (m *CCacheManager, err
 		RootWorkDir:       rootWorkDir,
 		LocalDownloadsDir: localDownloadsDir,
 		LocalUploadsDir:   localUploadsDir,
-		AzureBlobStorage:  azureBlobStorage,
+		StatsDir:          rootDir + "/stats",
+		RemoteStore:       remoteStore,
 	}
 
 	ccacheManager.setCurrentPkgGroupInternal(UninitializedGroupName, false, UninitializedGroupSize, UninitializedGroupArchitecture)
@@ -579,7 +624,7 @@ func (m *CCacheManager) DownloadPkgGroupCCache() (err error) {
 	}
 
 	logger.Log.Infof("  downloading (%s) to (%s)...", m.CurrentPkgGroup.TarFile.RemoteSourcePath, m.CurrentPkgGroup.TarFile.LocalSourcePath)
-	err = m.AzureBlobStorage.Download(context.Background(), remoteStoreConfig.ContainerName, m.CurrentPkgGroup.TarFile.RemoteSourcePath, m.CurrentPkgGroup.TarFile.LocalSourcePath)
+	err = m.RemoteStore.Download(context.Background(), m.CurrentPkgGroup.TarFile.RemoteSourcePath, m.CurrentPkgGroup.TarFile.LocalSourcePath)
 	if err != nil {
 		return fmt.Errorf("Unable to download ccache archive:\n%w", err)
 	}
@@ -621,7 +666,7 @@ func (m *CCacheManager) UploadPkgGroupCCache() (err error) {
 
 	// Upload the ccache archive
 	logger.Log.Infof("  uploading ccache archive (%s) to (%s)...", m.CurrentPkgGroup.TarFile.LocalTargetPath, m.CurrentPkgGroup.TarFile.RemoteTargetPath)
-	err = m.AzureBlobStorage.Upload(context.Background(), m.CurrentPkgGroup.TarFile.LocalTargetPath, remoteStoreConfig.ContainerName, m.CurrentPkgGroup.TarFile.RemoteTargetPath)
+	err = m.RemoteStore.Upload(context.Background(), m.CurrentPkgGroup.TarFile.LocalTargetPath, m.CurrentPkgGroup.TarFile.RemoteTargetPath)
 	if err != nil {
 		return fmt.Errorf("Unable to upload ccache archive:\n%w", err)
 	}
@@ -642,7 +687,7 @@ func (m *CCacheManager) UploadPkgGroupCCache() (err error) {
 			// been downloaded and use it. If not, it will attempt to
 			// download it. If not, then there is no way to get to the
 			// previous latest tar (if it exists at all).
-			latestTag, err := m.CurrentPkgGroup.getLatestTag(m.AzureBlobStorage, m.Configuration.RemoteStoreConfig.ContainerName)
+			latestTag, err := m.CurrentPkgGroup.getLatestTag(m.RemoteStore)
 			if err == nil {
 				// build the archive remote path based on the latestTag.
 				previousLatestTarSourcePath = m.CurrentPkgGroup.buildTarRemotePath(latestTag)
@@ -661,7 +706,7 @@ func (m *CCacheManager) UploadPkgGroupCCache() (err error) {
 
 		// Upload the latest tag file...
 		logger.Log.Infof("  uploading tag file (%s) to (%s)...", m.CurrentPkgGroup.TagFile.LocalTargetPath, m.CurrentPkgGroup.TagFile.RemoteTargetPath)
-		err = m.AzureBlobStorage.Upload(context.Background(), m.CurrentPkgGroup.TagFile.LocalTargetPath, remoteStoreConfig.ContainerName, m.CurrentPkgGroup.TagFile.RemoteTargetPath)
+		err = m.RemoteStore.Upload(context.Background(), m.CurrentPkgGroup.TagFile.LocalTargetPath, m.CurrentPkgGroup.TagFile.RemoteTargetPath)
 		if err != nil {
 			return fmt.Errorf("Unable to upload ccache archive:\n%w", err)
 		}
@@ -676,7 +721,7 @@ func (m *CCacheManager) UploadPkgGroupCCache() (err error) {
 				logger.Log.Infof("  previous latest archive has been overwritten with the current latest archive. Nothing to remove.")
 			} else {
 				logger.Log.Infof("  removing ccache archive (%s) from remote store...", previousLatestTarSourcePath)
-				err = m.AzureBlobStorage.Delete(context.Background(), remoteStoreConfig.ContainerName, previousLatestTarSourcePath)
+				err = m.RemoteStore.Delete(context.Background(), previousLatestTarSourcePath)
 				if err != nil {
 					return fmt.Errorf("Unable to remove previous ccache archive:\n%w", err)
 				}
@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package ccachemanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	// StatsLogEnvironmentVariable makes ccache log the results of its compilations to a file of their own, on top
+	// of its statistics counters.
+	StatsLogEnvironmentVariable = "CCACHE_STATSLOG"
+	// statsFileSuffix is appended to the SRPM's file name to get the name of its statistics file.
+	statsFileSuffix = ".json"
+	// statsLogCommentPrefix starts the lines of a ccache statistics log naming the compiled source file.
+	statsLogCommentPrefix = "#"
+)
+
+// ccacheHitCounters and ccacheMissCounters are the ccache statistics summed up into hits and misses.
+var (
+	ccacheHitCounters  = []string{"direct_cache_hit", "preprocessed_cache_hit"}
+	ccacheMissCounters = []string{"cache_miss"}
+)
+
+// CCacheStats are the ccache statistics of a single package build.
+type CCacheStats struct {
+	Group string `json:"group"`
+	SRPM  string `json:"srpm"`
+	// Hits and Misses count the cacheable compilations that were and weren't found in the cache.
+	Hits       int64     `json:"hits"`
+	Misses     int64     `json:"misses"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// HitRate returns the share of the cacheable compilations that were found in the cache, in percent.
+func (s CCacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+
+	return 100 * float64(s.Hits) / float64(total)
+}
+
+// ReadStatsLog returns the statistics of the compilations logged to a ccache statistics log (see
+// StatsLogEnvironmentVariable).
+//
+// The statistics counters of a ccache directory are shared by all the packages of its group, which may build at the
+// same time, so each build logs its compilations to a file of its own instead. A missing log means that the build
+// didn't compile anything through ccache.
+func ReadStatsLog(statsLogFile string) (stats CCacheStats, err error) {
+	content, err := os.ReadFile(statsLogFile)
+	if os.IsNotExist(err) {
+		return stats, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read the ccache statistics log (%s):\n%w", statsLogFile, err)
+		return
+	}
+
+	return ParseStatsLog(string(content)), nil
+}
+
+// ParseStatsLog parses a ccache statistics log: a comment line with the source file of each compilation, followed by
+// one line for each statistic that the compilation counted towards.
+func ParseStatsLog(content string) (stats CCacheStats) {
+	counters := map[string]int64{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, statsLogCommentPrefix) {
+			continue
+		}
+
+		counters[line]++
+	}
+
+	for _, counter := range ccacheHitCounters {
+		stats.Hits += counters[counter]
+	}
+	for _, counter := range ccacheMissCounters {
+		stats.Misses += counters[counter]
+	}
+
+	return
+}
+
+// SaveStats saves the ccache statistics of a package build, so that they can be summarized at the end of the build.
+func (m *CCacheManager) SaveStats(stats CCacheStats) (err error) {
+	err = os.MkdirAll(m.StatsDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create the ccache statistics folder (%s):\n%w", m.StatsDir, err)
+	}
+
+	return jsonutils.WriteJSONFile(filepath.Join(m.StatsDir, stats.SRPM+statsFileSuffix), stats)
+}
+
+// LoadStats reads the ccache statistics of the package builds which finished after the given time, sorted by SRPM.
+func (m *CCacheManager) LoadStats(since time.Time) (allStats []CCacheStats, err error) {
+	statsFiles, err := filepath.Glob(filepath.Join(m.StatsDir, "*"+statsFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the ccache statistics:\n%w", err)
+	}
+
+	for _, statsFile := range statsFiles {
+		var stats CCacheStats
+		err = jsonutils.ReadJSONFile(statsFile, &stats)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the ccache statistics (%s):\n%w", statsFile, err)
+		}
+
+		if stats.FinishedAt.Before(since) {
+			continue
+		}
+		allStats = append(allStats, stats)
+	}
+
+	sort.Slice(allStats, func(i, j int) bool {
+		return allStats[i].SRPM < allStats[j].SRPM
+	})
+
+	return
+}
+
+// PrintStatsSummary prints the overall ccache hit rate of the given package builds, and the builds which missed the
+// cache most often.
+func PrintStatsSummary(allStats []CCacheStats, maxPackages int) {
+	total := CCacheStats{}
+	for _, stats := range allStats {
+		total.Hits += stats.Hits
+		total.Misses += stats.Misses
+	}
+
+	logger.Log.Info("--------- ccache ---------")
+	logger.Log.Infof("Builds using ccache: %d", len(allStats))
+	logger.Log.Infof("Cache hits: %d, misses: %d, hit rate: %.1f%%", total.Hits, total.Misses, total.HitRate())
+
+	byMisses := append([]CCacheStats{}, allStats...)
+	sort.SliceStable(byMisses, func(i, j int) bool {
+		return byMisses[i].Misses > byMisses[j].Misses
+	})
+
+	for i, stats := range byMisses {
+		if i >= maxPackages || stats.Misses == 0 {
+			break
+		}
+		logger.Log.Infof("--> %s (%s): %d hits, %d misses (%.1f%%)", stats.SRPM, stats.Group, stats.Hits, stats.Misses, stats.HitRate())
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package ccachemanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestParseStatsLog(t *testing.T) {
+	content := "# /usr/src/azl/BUILD/zlib-1.3/adler32.c\ndirect_cache_hit\n" +
+		"# /usr/src/azl/BUILD/zlib-1.3/crc32.c\npreprocessed_cache_hit\n" +
+		"# /usr/src/azl/BUILD/zlib-1.3/deflate.c\ncache_miss\n" +
+		"# /usr/src/azl/BUILD/zlib-1.3/inflate.c\ncache_miss\n" +
+		"# conftest.c\ncould_not_use_precompiled_header\n"
+
+	stats := ParseStatsLog(content)
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.InDelta(t, 50.0, stats.HitRate(), 0.01)
+}
+
+func TestReadStatsLogMissing(t *testing.T) {
+	stats, err := ReadStatsLog(filepath.Join(t.TempDir(), "ccache-stats.log"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Hits)
+	assert.Equal(t, int64(0), stats.Misses)
+	assert.Equal(t, 0.0, stats.HitRate())
+}
+
+func TestLoadStatsSince(t *testing.T) {
+	m := &CCacheManager{StatsDir: filepath.Join(t.TempDir(), "stats")}
+
+	start := time.Now()
+	require.NoError(t, m.SaveStats(CCacheStats{SRPM: "old-1.0.src.rpm", Hits: 1, FinishedAt: start.Add(-time.Hour)}))
+	require.NoError(t, m.SaveStats(CCacheStats{SRPM: "zlib-1.3.src.rpm", Hits: 2, FinishedAt: start.Add(time.Minute)}))
+	require.NoError(t, m.SaveStats(CCacheStats{SRPM: "bash-5.2.src.rpm", Misses: 3, FinishedAt: start.Add(time.Minute)}))
+	assert.FileExists(t, filepath.Join(m.StatsDir, "zlib-1.3.src.rpm.json"))
+
+	allStats, err := m.LoadStats(start)
+	require.NoError(t, err)
+	require.Len(t, allStats, 2)
+	assert.Equal(t, "bash-5.2.src.rpm", allStats[0].SRPM)
+	assert.Equal(t, "zlib-1.3.src.rpm", allStats[1].SRPM)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package cgroup creates cgroup v2 groups that limit the resources used by the processes that are started in them.
+package cgroup
+
+import (
+	"fmt"
+)
+
+const (
+	minCPUWeight = 1
+	maxCPUWeight = 10000
+)
+
+// Limits are the resource limits of a cgroup. A zero value leaves the corresponding resource unlimited.
+type Limits struct {
+	// MemoryMax is the maximum memory usage in bytes (memory.max). Processes that exceed it are OOM killed.
+	MemoryMax uint64
+	// CPUWeight is the relative share of CPU time (cpu.weight), between 1 and 10000. The default weight is 100.
+	CPUWeight uint64
+	// PidsMax is the maximum number of processes and threads (pids.max).
+	PidsMax uint64
+}
+
+// IsSet returns true if any of the limits are set.
+func (l Limits) IsSet() bool {
+	return l.MemoryMax != 0 || l.CPUWeight != 0 || l.PidsMax != 0
+}
+
+func (l Limits) IsValid() error {
+	if l.CPUWeight != 0 && (l.CPUWeight < minCPUWeight || l.CPUWeight > maxCPUWeight) {
+		return fmt.Errorf("invalid CPU weight (%d): must be between %d and %d", l.CPUWeight, minCPUWeight,
+			maxCPUWeight)
+	}
+
+	return nil
+}
+
+// controllers returns the names of the cgroup controllers that are needed to apply the limits.
+func (l Limits) controllers() []string {
+	controllers := []string(nil)
+	if l.CPUWeight != 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if l.MemoryMax != 0 {
+		controllers = append(controllers, "memory")
+	}
+	if l.PidsMax != 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
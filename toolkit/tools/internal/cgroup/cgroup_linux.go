@@ -0,0 +1,523 @@
+//go:build linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/moby/sys/mountinfo"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// leafCgroupName is the cgroup that the processes of a cgroup are moved into when the controllers can't be
+	// enabled in the cgroup, since a cgroup v2 group can't both have processes and delegate controllers.
+	leafCgroupName      = "azurelinux-toolkit"
+	maxLeafMoveAttempts = 3
+
+	drainTimeout      = 10 * time.Second
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// Parent is a cgroup v2 directory that cgroups are created in. Its directory is kept open, so that cgroups can still
+// be created in it once the current process has entered a chroot, which doesn't have the cgroup filesystem mounted.
+type Parent struct {
+	path string
+	dir  *os.File
+	// enabledControllers are the controllers that OpenParent enabled, which Close disables again.
+	enabledControllers []string
+	// movedProcesses is true if OpenParent moved the processes of the cgroup into the leaf cgroup.
+	movedProcesses bool
+}
+
+// Cgroup is a cgroup v2 group, which is removed (along with any processes still running in it) when closed.
+type Cgroup struct {
+	parent *Parent
+	name   string
+	dir    *os.File
+}
+
+// OpenParent opens a cgroup directory to create cgroups in, and enables the controllers that the limits need for its
+// child cgroups.
+//
+// A cgroup v2 group that has processes can't enable controllers for its children. So, the processes of the cgroup
+// (e.g. the current process) are moved into a leaf child cgroup first. Close moves them back.
+func OpenParent(path string, limits Limits) (*Parent, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup (%s):\n%w", path, err)
+	}
+
+	parent := &Parent{
+		path: path,
+		dir:  dir,
+	}
+
+	err = parent.enableControllers(limits.controllers())
+	if err != nil {
+		closeErr := parent.Close()
+		if closeErr != nil {
+			logger.Log.Warnf("Failed to restore cgroup (%s):\n%v", path, closeErr)
+		}
+		return nil, err
+	}
+
+	return parent, nil
+}
+
+// Path returns the cgroup's directory.
+func (p *Parent) Path() string {
+	return p.path
+}
+
+// New creates a cgroup named name within the parent cgroup and applies the limits to it.
+func (p *Parent) New(name string, limits Limits) (*Cgroup, error) {
+	path := filepath.Join(p.path, name)
+
+	err := unix.Mkdirat(int(p.dir.Fd()), name, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup (%s):\n%w", path, err)
+	}
+
+	dir, err := openAt(p.dir, name, unix.O_RDONLY|unix.O_DIRECTORY)
+	if err != nil {
+		unix.Unlinkat(int(p.dir.Fd()), name, unix.AT_REMOVEDIR)
+		return nil, fmt.Errorf("failed to open cgroup (%s):\n%w", path, err)
+	}
+
+	err = applyLimits(dir, path, limits)
+	if err != nil {
+		dir.Close()
+		unix.Unlinkat(int(p.dir.Fd()), name, unix.AT_REMOVEDIR)
+		return nil, err
+	}
+
+	return &Cgroup{
+		parent: p,
+		name:   name,
+		dir:    dir,
+	}, nil
+}
+
+// Close restores the cgroup as OpenParent found it: the controllers it enabled are disabled again, and the processes
+// it moved into the leaf cgroup are moved back.
+func (p *Parent) Close() error {
+	defer p.dir.Close()
+
+	var err error
+	if len(p.enabledControllers) > 0 {
+		err = writeControllerChanges(p.dir, p.path, "-", p.enabledControllers)
+	}
+
+	if p.movedProcesses {
+		leafPath := filepath.Join(p.path, leafCgroupName)
+
+		moveErr := moveProcesses(leafPath, p.path)
+		if moveErr == nil {
+			moveErr = unix.Unlinkat(int(p.dir.Fd()), leafCgroupName, unix.AT_REMOVEDIR)
+		}
+		if moveErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to move processes of cgroup (%s) back:\n%w", leafPath, moveErr))
+		}
+	}
+
+	return err
+}
+
+func (p *Parent) enableControllers(controllers []string) error {
+	if len(controllers) <= 0 {
+		return nil
+	}
+
+	availableControllers, err := readControllers(p.dir, p.path, "cgroup.controllers")
+	if err != nil {
+		return err
+	}
+
+	for _, controller := range controllers {
+		if !availableControllers[controller] {
+			return fmt.Errorf("cgroup controller (%s) isn't available in cgroup (%s)", controller, p.path)
+		}
+	}
+
+	enabledControllers, err := readControllers(p.dir, p.path, "cgroup.subtree_control")
+	if err != nil {
+		return err
+	}
+
+	changes := []string(nil)
+	for _, controller := range controllers {
+		if !enabledControllers[controller] {
+			changes = append(changes, controller)
+		}
+	}
+
+	if len(changes) <= 0 {
+		return nil
+	}
+
+	leafPath := filepath.Join(p.path, leafCgroupName)
+	for attempt := 0; ; attempt++ {
+		err = writeControllerChanges(p.dir, p.path, "+", changes)
+		if err == nil {
+			p.enabledControllers = changes
+			return nil
+		}
+
+		if !errors.Is(err, unix.EBUSY) || attempt >= maxLeafMoveAttempts {
+			return err
+		}
+
+		// Processes may be started in the cgroup while the others are being moved. So, retry a few times.
+		logger.Log.Debugf("Moving processes of cgroup (%s) into leaf cgroup (%s)", p.path, leafCgroupName)
+
+		p.movedProcesses = true
+		err = moveProcesses(p.path, leafPath)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Path returns the cgroup's directory.
+func (c *Cgroup) Path() string {
+	return filepath.Join(c.parent.path, c.name)
+}
+
+// SetProcAttr makes a process that is started with attr start in the cgroup.
+func (c *Cgroup) SetProcAttr(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(c.dir.Fd())
+}
+
+// OOMKilled returns true if any process in the cgroup was killed for exceeding the cgroup's memory limit.
+func (c *Cgroup) OOMKilled() (bool, error) {
+	events, err := readFileAt(c.dir, "memory.events")
+	if errors.Is(err, os.ErrNotExist) {
+		// The memory controller isn't enabled.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read memory events of cgroup (%s):\n%w", c.Path(), err)
+	}
+
+	count, err := readKeyedValue(events, "oom_kill")
+	if err != nil {
+		return false, fmt.Errorf("invalid memory events of cgroup (%s):\n%w", c.Path(), err)
+	}
+
+	return count > 0, nil
+}
+
+// Kill sends SIGKILL to all the processes in the cgroup, including the processes that left their process group.
+func (c *Cgroup) Kill() error {
+	err := writeFileAt(c.dir, "cgroup.kill", []byte("1"))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to kill processes of cgroup (%s):\n%w", c.Path(), err)
+	}
+
+	// cgroup.kill was added in Linux 5.14. So, fallback to killing the processes one at a time.
+	pids, err := readPids(c.dir, c.Path())
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		err = unix.Kill(pid, unix.SIGKILL)
+		if err != nil && !errors.Is(err, unix.ESRCH) {
+			return fmt.Errorf("failed to kill process (%d) of cgroup (%s):\n%w", pid, c.Path(), err)
+		}
+	}
+
+	return nil
+}
+
+// Close kills any processes that are still running in the cgroup and then removes the cgroup.
+func (c *Cgroup) Close() error {
+	defer c.dir.Close()
+
+	populated, err := c.populated()
+	if err != nil {
+		return err
+	}
+
+	if populated {
+		logger.Log.Debugf("Killing processes left behind in cgroup (%s)", c.Path())
+
+		err = c.Kill()
+		if err != nil {
+			return err
+		}
+
+		err = c.waitUntilEmpty()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = unix.Unlinkat(int(c.parent.dir.Fd()), c.name, unix.AT_REMOVEDIR)
+	if err != nil {
+		return fmt.Errorf("failed to remove cgroup (%s):\n%w", c.Path(), err)
+	}
+
+	return nil
+}
+
+func (c *Cgroup) waitUntilEmpty() error {
+	deadline := time.Now().Add(drainTimeout)
+	for {
+		populated, err := c.populated()
+		if err != nil {
+			return err
+		}
+
+		if !populated {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processes of cgroup (%s) didn't exit within %s", c.Path(), drainTimeout)
+		}
+
+		time.Sleep(drainPollInterval)
+	}
+}
+
+func (c *Cgroup) populated() (bool, error) {
+	events, err := readFileAt(c.dir, "cgroup.events")
+	if err != nil {
+		return false, fmt.Errorf("failed to read events of cgroup (%s):\n%w", c.Path(), err)
+	}
+
+	populated, err := readKeyedValue(events, "populated")
+	if err != nil {
+		return false, fmt.Errorf("invalid events of cgroup (%s):\n%w", c.Path(), err)
+	}
+
+	return populated != 0, nil
+}
+
+// CurrentPath returns the cgroup v2 directory of the current process.
+func CurrentPath() (string, error) {
+	mountPoint, err := mountPoint()
+	if err != nil {
+		return "", err
+	}
+
+	procCgroup, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("failed to read cgroups of current process:\n%w", err)
+	}
+
+	// The cgroup v2 entry is of the form "0::<path>".
+	for _, line := range strings.Split(string(procCgroup), "\n") {
+		path, found := strings.CutPrefix(line, "0::")
+		if found {
+			return filepath.Join(mountPoint, path), nil
+		}
+	}
+
+	return "", fmt.Errorf("current process isn't in a cgroup v2 hierarchy")
+}
+
+// moveProcesses moves all the processes of a cgroup into another cgroup, creating the other cgroup if needed.
+func moveProcesses(path string, destPath string) error {
+	err := os.Mkdir(destPath, 0o755)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create cgroup (%s):\n%w", destPath, err)
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup (%s):\n%w", path, err)
+	}
+	defer dir.Close()
+
+	pids, err := readPids(dir, path)
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		err = os.WriteFile(filepath.Join(destPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0)
+		if err != nil && !errors.Is(err, unix.ESRCH) {
+			return fmt.Errorf("failed to move process (%d) into cgroup (%s):\n%w", pid, destPath, err)
+		}
+	}
+
+	return nil
+}
+
+// writeControllerChanges enables ("+") or disables ("-") controllers for the child cgroups of a cgroup.
+func writeControllerChanges(dir *os.File, path string, change string, controllers []string) error {
+	changes := []string(nil)
+	for _, controller := range controllers {
+		changes = append(changes, change+controller)
+	}
+
+	err := writeFileAt(dir, "cgroup.subtree_control", []byte(strings.Join(changes, " ")))
+	if err != nil {
+		return fmt.Errorf("failed to change cgroup controllers (%s) in cgroup (%s):\n%w", strings.Join(changes, ", "),
+			path, err)
+	}
+
+	return nil
+}
+
+func applyLimits(dir *os.File, path string, limits Limits) error {
+	err := limits.IsValid()
+	if err != nil {
+		return err
+	}
+
+	values := []struct {
+		file  string
+		value uint64
+	}{
+		{"memory.max", limits.MemoryMax},
+		{"cpu.weight", limits.CPUWeight},
+		{"pids.max", limits.PidsMax},
+	}
+
+	for _, value := range values {
+		if value.value == 0 {
+			continue
+		}
+
+		err = writeFileAt(dir, value.file, []byte(strconv.FormatUint(value.value, 10)))
+		if err != nil {
+			return fmt.Errorf("failed to set (%s) of cgroup (%s):\n%w", value.file, path, err)
+		}
+	}
+
+	if limits.MemoryMax != 0 {
+		// Don't let the processes get around the memory limit by swapping. Not all kernels account for swap.
+		err = writeFileAt(dir, "memory.swap.max", []byte("0"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to set (memory.swap.max) of cgroup (%s):\n%w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func mountPoint() (string, error) {
+	mounts, err := mountinfo.GetMounts(mountinfo.FSTypeFilter("cgroup2"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read mounts:\n%w", err)
+	}
+
+	if len(mounts) <= 0 {
+		return "", fmt.Errorf("cgroup v2 filesystem isn't mounted")
+	}
+
+	return mounts[0].Mountpoint, nil
+}
+
+func readControllers(dir *os.File, path string, name string) (map[string]bool, error) {
+	content, err := readFileAt(dir, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup controllers (%s):\n%w", filepath.Join(path, name), err)
+	}
+
+	controllers := make(map[string]bool)
+	for _, controller := range strings.Fields(string(content)) {
+		controllers[controller] = true
+	}
+
+	return controllers, nil
+}
+
+func readPids(dir *os.File, path string) ([]int, error) {
+	procsPath := filepath.Join(path, "cgroup.procs")
+
+	content, err := readFileAt(dir, "cgroup.procs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read processes of cgroup (%s):\n%w", path, err)
+	}
+
+	pids := []int(nil)
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid process ID (%s) in (%s):\n%w", field, procsPath, err)
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// openAt opens a file of a cgroup directory. The cgroup's files are accessed relative to its open directory, since
+// the current process may be in a chroot.
+func openAt(dir *os.File, name string, flags int) (*os.File, error) {
+	fd, err := unix.Openat(int(dir.Fd()), name, flags|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name)), nil
+}
+
+func readFileAt(dir *os.File, name string) ([]byte, error) {
+	file, err := openAt(dir, name, unix.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+func writeFileAt(dir *os.File, name string, data []byte) error {
+	file, err := openAt(dir, name, unix.O_WRONLY)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// readKeyedValue reads a value from a cgroup file of "<key> <value>" lines (e.g. memory.events).
+func readKeyedValue(content []byte, key string) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value (%s) of key (%s):\n%w", fields[1], key, err)
+		}
+
+		return value, nil
+	}
+
+	return 0, fmt.Errorf("key (%s) not found", key)
+}
@@ -0,0 +1,57 @@
+//go:build !linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cgroup
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// Parent is a cgroup v2 directory that cgroups are created in. Cgroups are only supported on Linux.
+type Parent struct{}
+
+// Cgroup is a cgroup v2 group. Cgroups are only supported on Linux.
+type Cgroup struct{}
+
+func OpenParent(path string, limits Limits) (*Parent, error) {
+	return nil, fmt.Errorf("cgroups are only supported on Linux (current OS: %s)", runtime.GOOS)
+}
+
+func (p *Parent) Path() string {
+	return ""
+}
+
+func (p *Parent) New(name string, limits Limits) (*Cgroup, error) {
+	return nil, fmt.Errorf("cgroups are only supported on Linux (current OS: %s)", runtime.GOOS)
+}
+
+func (p *Parent) Close() error {
+	return nil
+}
+
+func (c *Cgroup) Path() string {
+	return ""
+}
+
+func (c *Cgroup) SetProcAttr(attr *syscall.SysProcAttr) {
+}
+
+func (c *Cgroup) OOMKilled() (bool, error) {
+	return false, nil
+}
+
+func (c *Cgroup) Kill() error {
+	return nil
+}
+
+func (c *Cgroup) Close() error {
+	return nil
+}
+
+func CurrentPath() (string, error) {
+	return "", fmt.Errorf("cgroups are only supported on Linux (current OS: %s)", runtime.GOOS)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func TestLimitsIsValid(t *testing.T) {
+	assert.NoError(t, Limits{}.IsValid())
+	assert.NoError(t, Limits{MemoryMax: 1024, CPUWeight: 10000, PidsMax: 100}.IsValid())
+
+	err := Limits{CPUWeight: 10001}.IsValid()
+	assert.ErrorContains(t, err, "invalid CPU weight (10001)")
+}
+
+func TestLimitsControllers(t *testing.T) {
+	assert.False(t, Limits{}.IsSet())
+	assert.Empty(t, Limits{}.controllers())
+
+	limits := Limits{MemoryMax: 1024, PidsMax: 100}
+	assert.True(t, limits.IsSet())
+	assert.Equal(t, []string{"memory", "pids"}, limits.controllers())
+}
+
+func TestReadKeyedValue(t *testing.T) {
+	events := []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 2\n")
+
+	value, err := readKeyedValue(events, "oom_kill")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), value)
+
+	_, err = readKeyedValue(events, "populated")
+	assert.ErrorContains(t, err, "key (populated) not found")
+}
+
+func TestCgroupCloseShouldKillLeftoverProcesses(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Test must be run as root because it creates cgroups")
+	}
+
+	parentPath, err := CurrentPath()
+	if err != nil {
+		t.Skipf("cgroup v2 isn't available: %v", err)
+	}
+
+	parent, err := OpenParent(parentPath, Limits{})
+	require.NoError(t, err)
+	defer parent.Close()
+
+	cgroup, err := parent.New(fmt.Sprintf("test-%d", os.Getpid()), Limits{})
+	require.NoError(t, err)
+
+	// A process that leaves a child process running in the background.
+	cmd := exec.Command("/bin/sh", "-c", "sleep 60 & echo")
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	cgroup.SetProcAttr(cmd.SysProcAttr)
+	err = cmd.Run()
+	require.NoError(t, err)
+
+	populated, err := cgroup.populated()
+	assert.NoError(t, err)
+	assert.True(t, populated)
+
+	oomKilled, err := cgroup.OOMKilled()
+	assert.NoError(t, err)
+	assert.False(t, oomKilled)
+
+	err = cgroup.Close()
+	assert.NoError(t, err)
+	assert.NoDirExists(t, cgroup.Path())
+}
+
+func TestParentCloseShouldRestoreProcesses(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Test must be run as root because it creates cgroups")
+	}
+
+	parentPath, err := CurrentPath()
+	if err != nil {
+		t.Skipf("cgroup v2 isn't available: %v", err)
+	}
+
+	parent, err := OpenParent(parentPath, Limits{PidsMax: 100})
+	if err != nil {
+		t.Skipf("cgroup (%s) can't delegate the pids controller: %v", parentPath, err)
+	}
+
+	err = parent.Close()
+	assert.NoError(t, err)
+
+	// The current process is back in its original cgroup.
+	currentPath, err := CurrentPath()
+	assert.NoError(t, err)
+	assert.Equal(t, parentPath, currentPath)
+	assert.NoDirExists(t, filepath.Join(parentPath, leafCgroupName))
+}
@@ -0,0 +1,389 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package chunkstore stores artifacts (e.g. disk images) as content-defined chunks within an artifact store. Each
+// unique chunk is only stored once, so storing many similar artifacts (e.g. the nightly variants of an image) costs
+// little more than storing one of them.
+package chunkstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/artifactstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	// The version of the manifest format.
+	ManifestVersion = 1
+
+	chunksPrefix    = "chunks"
+	manifestsPrefix = "manifests"
+	manifestSuffix  = ".json"
+)
+
+// Manifest lists the chunks that an artifact is made of, in order.
+type Manifest struct {
+	Version int     `json:"version"`
+	Name    string  `json:"name"`
+	Size    int64   `json:"size"`
+	Sha256  string  `json:"sha256"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+// Chunk is a chunk of an artifact.
+type Chunk struct {
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// PutStats describes how much of an artifact was already in the store.
+type PutStats struct {
+	Chunks    int
+	NewChunks int
+	Bytes     int64
+	NewBytes  int64
+}
+
+// Store is a content-defined chunk store within an artifact store.
+//
+// Chunks are stored under "chunks/<first 2 digits of sha256>/<sha256>" and the manifests of the artifacts under
+// "manifests/<name>.json".
+type Store struct {
+	store  artifactstore.Store
+	params ChunkerParams
+}
+
+// New creates a chunk store within the artifact store.
+func New(store artifactstore.Store) *Store {
+	return &Store{
+		store:  store,
+		params: DefaultChunkerParams(),
+	}
+}
+
+func (s *Store) String() string {
+	return s.store.String()
+}
+
+func chunkKey(digest string) string {
+	return path.Join(chunksPrefix, digest[:2], digest)
+}
+
+func manifestKey(name string) string {
+	return path.Join(manifestsPrefix, name+manifestSuffix)
+}
+
+// Put chunks a local file and stores the chunks that aren't already in the store, followed by the artifact's
+// manifest. An existing artifact with the same name is replaced.
+func (s *Store) Put(ctx context.Context, localPath string, name string) (*Manifest, PutStats, error) {
+	stats := PutStats{}
+
+	err := validateName(name)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	sourceFile, err := os.Open(localPath)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to open artifact (%s):\n%w", localPath, err)
+	}
+	defer sourceFile.Close()
+
+	tempDir, err := os.MkdirTemp("", "chunkstore-")
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to create temporary directory:\n%w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	chunker, err := NewChunker(sourceFile, s.params)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		Name:    name,
+	}
+
+	artifactDigest := sha256.New()
+	storedChunks := make(map[string]bool)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, stats, fmt.Errorf("failed to chunk artifact (%s):\n%w", localPath, err)
+		}
+
+		artifactDigest.Write(chunk)
+
+		chunkDigest := sha256.Sum256(chunk)
+		digest := hex.EncodeToString(chunkDigest[:])
+
+		manifest.Chunks = append(manifest.Chunks, Chunk{Sha256: digest, Size: int64(len(chunk))})
+		manifest.Size += int64(len(chunk))
+		stats.Chunks++
+		stats.Bytes += int64(len(chunk))
+
+		if storedChunks[digest] {
+			continue
+		}
+
+		uploaded, err := s.putChunk(ctx, tempDir, digest, chunk)
+		if err != nil {
+			return nil, stats, err
+		}
+
+		storedChunks[digest] = true
+		if uploaded {
+			stats.NewChunks++
+			stats.NewBytes += int64(len(chunk))
+		}
+	}
+
+	manifest.Sha256 = hex.EncodeToString(artifactDigest.Sum(nil))
+
+	// The manifest is uploaded last, so that the artifact only appears once all of its chunks are stored.
+	err = s.putManifest(ctx, tempDir, manifest)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	return manifest, stats, nil
+}
+
+// putChunk uploads a chunk, unless it is already in the store. Returns true if the chunk was uploaded.
+func (s *Store) putChunk(ctx context.Context, tempDir string, digest string, chunk []byte) (bool, error) {
+	key := chunkKey(digest)
+
+	exists, err := s.store.Exists(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for chunk (%s):\n%w", digest, err)
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	chunkFile := filepath.Join(tempDir, digest)
+	err = os.WriteFile(chunkFile, chunk, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("failed to write chunk (%s):\n%w", digest, err)
+	}
+	defer os.Remove(chunkFile)
+
+	err = s.store.Upload(ctx, chunkFile, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to store chunk (%s):\n%w", digest, err)
+	}
+
+	return true, nil
+}
+
+func (s *Store) putManifest(ctx context.Context, tempDir string, manifest *Manifest) error {
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize manifest of artifact (%s):\n%w", manifest.Name, err)
+	}
+
+	manifestFile := filepath.Join(tempDir, "manifest"+manifestSuffix)
+	err = os.WriteFile(manifestFile, manifestJson, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest of artifact (%s):\n%w", manifest.Name, err)
+	}
+
+	err = s.store.Upload(ctx, manifestFile, manifestKey(manifest.Name))
+	if err != nil {
+		return fmt.Errorf("failed to store manifest of artifact (%s):\n%w", manifest.Name, err)
+	}
+
+	return nil
+}
+
+// ReadManifest returns the manifest of an artifact. Returns an error that wraps artifactstore.ErrNotFound if the
+// artifact doesn't exist.
+func (s *Store) ReadManifest(ctx context.Context, name string) (*Manifest, error) {
+	err := validateName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "chunkstore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory:\n%w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	manifestFile := filepath.Join(tempDir, "manifest"+manifestSuffix)
+	err = s.store.Download(ctx, manifestKey(name), manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of artifact (%s):\n%w", name, err)
+	}
+
+	manifestJson, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of artifact (%s):\n%w", name, err)
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(manifestJson, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of artifact (%s):\n%w", name, err)
+	}
+
+	if manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version (%d) of artifact (%s)", manifest.Version, name)
+	}
+
+	return &manifest, nil
+}
+
+// List returns the names (sorted) of the artifacts in the store.
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	keys, err := s.store.List(ctx, manifestsPrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string(nil)
+	for _, key := range keys {
+		if !strings.HasSuffix(key, manifestSuffix) {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(key, manifestsPrefix+"/"), manifestSuffix)
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// Materialize reconstructs an artifact from its chunks into a local file. Each chunk, and the whole artifact, is
+// verified against the manifest's digests. Chunks of zeros are skipped over rather than written, so that disk images
+// are written as sparse files.
+func (s *Store) Materialize(ctx context.Context, name string, localPath string) error {
+	manifest, err := s.ReadManifest(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Materializing artifact (%s) from (%s) to (%s)", name, s, localPath)
+
+	tempDir, err := os.MkdirTemp("", "chunkstore-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory:\n%w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Write into a temporary file and then rename it, so that a partial artifact is never seen at the path.
+	outputFile, err := os.CreateTemp(filepath.Dir(localPath), filepath.Base(localPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create artifact file:\n%w", err)
+	}
+	defer os.Remove(outputFile.Name())
+	defer outputFile.Close()
+
+	artifactDigest := sha256.New()
+	for i, chunk := range manifest.Chunks {
+		chunkData, err := s.getChunk(ctx, tempDir, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %d of artifact (%s):\n%w", i, name, err)
+		}
+
+		artifactDigest.Write(chunkData)
+
+		if isZeros(chunkData) {
+			_, err = outputFile.Seek(int64(len(chunkData)), io.SeekCurrent)
+		} else {
+			_, err = outputFile.Write(chunkData)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write artifact file:\n%w", err)
+		}
+	}
+
+	// Extend the file over any trailing chunks of zeros that were skipped.
+	err = outputFile.Truncate(manifest.Size)
+	if err != nil {
+		return fmt.Errorf("failed to write artifact file:\n%w", err)
+	}
+
+	digest := hex.EncodeToString(artifactDigest.Sum(nil))
+	if digest != manifest.Sha256 {
+		return fmt.Errorf("artifact (%s) doesn't match its manifest's SHA-256 (%s != %s)", name, digest,
+			manifest.Sha256)
+	}
+
+	err = outputFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write artifact file:\n%w", err)
+	}
+
+	err = os.Rename(outputFile.Name(), localPath)
+	if err != nil {
+		return fmt.Errorf("failed to move artifact file into place:\n%w", err)
+	}
+
+	return nil
+}
+
+// getChunk downloads a chunk and verifies it against its digest.
+func (s *Store) getChunk(ctx context.Context, tempDir string, chunk Chunk) ([]byte, error) {
+	if len(chunk.Sha256) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk SHA-256 (%s)", chunk.Sha256)
+	}
+
+	chunkFile := filepath.Join(tempDir, chunk.Sha256)
+	err := s.store.Download(ctx, chunkKey(chunk.Sha256), chunkFile)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(chunkFile)
+
+	chunkData, err := os.ReadFile(chunkFile)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(chunkData)
+	if int64(len(chunkData)) != chunk.Size || hex.EncodeToString(digest[:]) != chunk.Sha256 {
+		return nil, fmt.Errorf("chunk (%s) is corrupt", chunk.Sha256)
+	}
+
+	return chunkData, nil
+}
+
+func isZeros(data []byte) bool {
+	const blockSize = 4096
+	var zeros [blockSize]byte
+
+	for len(data) > 0 {
+		size := min(len(data), blockSize)
+		if !bytes.Equal(data[:size], zeros[:size]) {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
+
+// validateName checks that an artifact name can be used within the store's keys.
+func validateName(name string) error {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid artifact name (%s): must be a clean, relative path", name)
+	}
+
+	return nil
+}
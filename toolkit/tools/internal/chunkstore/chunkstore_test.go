@@ -0,0 +1,141 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package chunkstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/artifactstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func newTestStore(t *testing.T) (*Store, string) {
+	storeDir := filepath.Join(t.TempDir(), "store")
+	fileStore, err := artifactstore.NewLocalStore(storeDir)
+	require.NoError(t, err)
+
+	store := New(fileStore)
+	store.params = testChunkerParams
+	return store, storeDir
+}
+
+func writeTestArtifact(t *testing.T, dir string, name string, data []byte) string {
+	artifactPath := filepath.Join(dir, name)
+	err := os.WriteFile(artifactPath, data, 0o644)
+	require.NoError(t, err)
+	return artifactPath
+}
+
+func TestStorePutAndMaterialize(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	testDir := t.TempDir()
+
+	// An image-like artifact, with data surrounding a large run of zeros.
+	data := append(append(randomData(4, 100*1024), make([]byte, 200*1024)...), randomData(5, 100*1024)...)
+	artifactPath := writeTestArtifact(t, testDir, "image.raw", data)
+
+	manifest, stats, err := store.Put(ctx, artifactPath, "nightly/image.raw")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), manifest.Size)
+	assert.Equal(t, int64(len(data)), stats.Bytes)
+	assert.Equal(t, len(manifest.Chunks), stats.Chunks)
+
+	// The zero chunks are only stored once.
+	assert.Less(t, stats.NewChunks, stats.Chunks)
+	assert.Less(t, stats.NewBytes, stats.Bytes)
+
+	names, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nightly/image.raw"}, names)
+
+	outputPath := filepath.Join(testDir, "materialized.raw")
+	err = store.Materialize(ctx, "nightly/image.raw", outputPath)
+	require.NoError(t, err)
+
+	materialized, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, data, materialized)
+
+	tempFiles, err := filepath.Glob(filepath.Join(testDir, "*.tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+}
+
+func TestStoreDeduplicatesSimilarArtifacts(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	testDir := t.TempDir()
+
+	data := randomData(6, 512*1024)
+	_, stats, err := store.Put(ctx, writeTestArtifact(t, testDir, "a.raw", data), "a.raw")
+	require.NoError(t, err)
+	assert.Equal(t, stats.Bytes, stats.NewBytes)
+
+	// A variant of the artifact with a small change in the middle.
+	variant := append([]byte(nil), data...)
+	copy(variant[300*1024:], []byte("variant"))
+
+	_, stats, err = store.Put(ctx, writeTestArtifact(t, testDir, "b.raw", variant), "b.raw")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, stats.NewBytes, int64(2*testChunkerParams.MaxSize))
+
+	// Storing the same artifact again doesn't store any chunks.
+	_, stats, err = store.Put(ctx, writeTestArtifact(t, testDir, "b.raw", variant), "b-copy.raw")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.NewChunks)
+
+	outputPath := filepath.Join(testDir, "b-materialized.raw")
+	err = store.Materialize(ctx, "b.raw", outputPath)
+	require.NoError(t, err)
+
+	materialized, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	assert.Equal(t, variant, materialized)
+}
+
+func TestStoreMaterializeCorruptChunk(t *testing.T) {
+	ctx := context.Background()
+	store, storeDir := newTestStore(t)
+	testDir := t.TempDir()
+
+	_, _, err := store.Put(ctx, writeTestArtifact(t, testDir, "a.raw", randomData(7, 64*1024)), "a.raw")
+	require.NoError(t, err)
+
+	manifest, err := store.ReadManifest(ctx, "a.raw")
+	require.NoError(t, err)
+
+	chunkPath := filepath.Join(storeDir, filepath.FromSlash(chunkKey(manifest.Chunks[0].Sha256)))
+	err = os.WriteFile(chunkPath, []byte("corrupt"), 0o644)
+	require.NoError(t, err)
+
+	outputPath := filepath.Join(testDir, "materialized.raw")
+	err = store.Materialize(ctx, "a.raw", outputPath)
+	assert.ErrorContains(t, err, "is corrupt")
+	assert.NoFileExists(t, outputPath)
+}
+
+func TestStoreMaterializeMissingArtifact(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	err := store.Materialize(context.Background(), "missing.raw", filepath.Join(t.TempDir(), "missing.raw"))
+	assert.ErrorIs(t, err, artifactstore.ErrNotFound)
+}
+
+func TestStoreInvalidName(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	_, _, err := store.Put(context.Background(), "a.raw", "../a.raw")
+	assert.ErrorContains(t, err, "invalid artifact name (../a.raw)")
+}
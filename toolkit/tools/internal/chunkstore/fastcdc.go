@@ -0,0 +1,184 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package chunkstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	// DefaultMinChunkSize is the smallest chunk that is cut, except for the last chunk of a file.
+	DefaultMinChunkSize = 256 * 1024
+	// DefaultAvgChunkSize is the chunk size that the cut points are normalized towards.
+	DefaultAvgChunkSize = 1024 * 1024
+	// DefaultMaxChunkSize is the largest chunk that is cut.
+	DefaultMaxChunkSize = 4 * 1024 * 1024
+
+	// The seed of the gear table. Changing it changes every cut point, which would stop new artifacts from sharing
+	// chunks with the existing artifacts.
+	gearSeed = 0x6a09e667f3bcc908
+)
+
+// gearTable maps each byte to a random value that is rolled into the fingerprint.
+var gearTable = newGearTable(gearSeed)
+
+// ChunkerParams are the chunk sizes of the content-defined chunker.
+type ChunkerParams struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// DefaultChunkerParams returns the default chunk sizes, which suit disk images.
+func DefaultChunkerParams() ChunkerParams {
+	return ChunkerParams{
+		MinSize: DefaultMinChunkSize,
+		AvgSize: DefaultAvgChunkSize,
+		MaxSize: DefaultMaxChunkSize,
+	}
+}
+
+func (p ChunkerParams) IsValid() error {
+	if p.MinSize <= 0 || p.MinSize > p.AvgSize || p.AvgSize > p.MaxSize {
+		return fmt.Errorf("invalid chunk sizes (min: %d, avg: %d, max: %d): must be 0 < min <= avg <= max",
+			p.MinSize, p.AvgSize, p.MaxSize)
+	}
+
+	if bits.OnesCount(uint(p.AvgSize)) != 1 || p.AvgSize < 4 {
+		return fmt.Errorf("invalid average chunk size (%d): must be a power of 2 that is at least 4", p.AvgSize)
+	}
+
+	return nil
+}
+
+// Chunker splits a stream into chunks using FastCDC (content-defined chunking with a gear-based rolling hash and
+// normalized chunk sizes). Since the cut points depend on the content rather than on offsets, inserting or removing
+// data only changes the chunks around the change, so that similar files share most of their chunks.
+type Chunker struct {
+	reader io.Reader
+	params ChunkerParams
+	// A fingerprint ends a chunk if it has none of the mask's bits set. The stricter mask (more bits) is used before
+	// the average size is reached, and the looser mask (fewer bits) after, which pulls the chunk sizes towards the
+	// average.
+	maskStrict uint64
+	maskLoose  uint64
+
+	buffer []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker creates a chunker that reads from the reader.
+func NewChunker(reader io.Reader, params ChunkerParams) (*Chunker, error) {
+	err := params.IsValid()
+	if err != nil {
+		return nil, err
+	}
+
+	avgBits := bits.TrailingZeros(uint(params.AvgSize))
+
+	chunker := &Chunker{
+		reader:     reader,
+		params:     params,
+		maskStrict: highBitsMask(avgBits + 1),
+		maskLoose:  highBitsMask(avgBits - 1),
+		buffer:     make([]byte, 2*params.MaxSize),
+	}
+	return chunker, nil
+}
+
+// Next returns the next chunk. The chunk is only valid until the next call. Returns io.EOF once the stream has been
+// fully chunked.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.params.MaxSize && !c.eof {
+		err := c.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	chunkSize := c.cutPoint(c.buffer[c.start:c.end])
+	chunk := c.buffer[c.start : c.start+chunkSize]
+	c.start += chunkSize
+	return chunk, nil
+}
+
+// fill moves the unchunked data to the start of the buffer and then reads until the buffer is full or the stream
+// ends.
+func (c *Chunker) fill() error {
+	copy(c.buffer, c.buffer[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	bytesRead, err := io.ReadFull(c.reader, c.buffer[c.end:])
+	c.end += bytesRead
+
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		c.eof = true
+		return nil
+
+	case err != nil:
+		return fmt.Errorf("failed to read data to chunk:\n%w", err)
+	}
+
+	return nil
+}
+
+// cutPoint returns the size of the chunk at the start of the data.
+func (c *Chunker) cutPoint(data []byte) int {
+	size := len(data)
+	if size <= c.params.MinSize {
+		return size
+	}
+
+	size = min(size, c.params.MaxSize)
+	normalSize := min(size, c.params.AvgSize)
+
+	fingerprint := uint64(0)
+	i := c.params.MinSize
+	for ; i < normalSize; i++ {
+		fingerprint = (fingerprint << 1) + gearTable[data[i]]
+		if fingerprint&c.maskStrict == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < size; i++ {
+		fingerprint = (fingerprint << 1) + gearTable[data[i]]
+		if fingerprint&c.maskLoose == 0 {
+			return i + 1
+		}
+	}
+
+	return size
+}
+
+// highBitsMask returns a mask of the highest bits of a uint64. The high bits of the gear fingerprint depend on the
+// last 64 bytes, while the low bits only depend on the last few bytes.
+func highBitsMask(count int) uint64 {
+	return ^uint64(0) << (64 - count)
+}
+
+// newGearTable generates the gear table using splitmix64, so that the table (and hence the cut points) is the same
+// for every build of the tool.
+func newGearTable(seed uint64) (table [256]uint64) {
+	state := seed
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		value := state
+		value = (value ^ (value >> 30)) * 0xbf58476d1ce4e5b9
+		value = (value ^ (value >> 27)) * 0x94d049bb133111eb
+		table[i] = value ^ (value >> 31)
+	}
+	return
+}
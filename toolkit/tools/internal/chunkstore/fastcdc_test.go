@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package chunkstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testChunkerParams = ChunkerParams{MinSize: 1024, AvgSize: 4096, MaxSize: 16384}
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkAll(t *testing.T, data []byte, params ChunkerParams) [][]byte {
+	chunker, err := NewChunker(bytes.NewReader(data), params)
+	require.NoError(t, err)
+
+	chunks := [][]byte(nil)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+	return chunks
+}
+
+func chunkDigests(chunks [][]byte) map[[sha256.Size]byte]bool {
+	digests := make(map[[sha256.Size]byte]bool)
+	for _, chunk := range chunks {
+		digests[sha256.Sum256(chunk)] = true
+	}
+	return digests
+}
+
+func TestChunkerParamsIsValid(t *testing.T) {
+	assert.NoError(t, DefaultChunkerParams().IsValid())
+	assert.NoError(t, testChunkerParams.IsValid())
+
+	assert.ErrorContains(t, ChunkerParams{MinSize: 0, AvgSize: 4096, MaxSize: 16384}.IsValid(),
+		"must be 0 < min <= avg <= max")
+	assert.ErrorContains(t, ChunkerParams{MinSize: 8192, AvgSize: 4096, MaxSize: 16384}.IsValid(),
+		"must be 0 < min <= avg <= max")
+	assert.ErrorContains(t, ChunkerParams{MinSize: 1024, AvgSize: 5000, MaxSize: 16384}.IsValid(),
+		"must be a power of 2")
+}
+
+func TestChunkerSizes(t *testing.T) {
+	data := randomData(1, 1024*1024+123)
+	chunks := chunkAll(t, data, testChunkerParams)
+
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), testChunkerParams.MaxSize)
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), testChunkerParams.MinSize)
+		}
+	}
+
+	// The sizes should be normalized towards the average.
+	averageSize := len(data) / len(chunks)
+	assert.Greater(t, averageSize, testChunkerParams.AvgSize/2)
+	assert.Less(t, averageSize, testChunkerParams.AvgSize*2)
+}
+
+func TestChunkerIsDeterministic(t *testing.T) {
+	data := randomData(2, 256*1024)
+	assert.Equal(t, chunkAll(t, data, testChunkerParams), chunkAll(t, data, testChunkerParams))
+}
+
+func TestChunkerEmpty(t *testing.T) {
+	assert.Empty(t, chunkAll(t, nil, testChunkerParams))
+}
+
+func TestChunkerResynchronizesAfterInsert(t *testing.T) {
+	data := randomData(3, 512*1024)
+
+	// Insert some bytes near the start, which shifts the offsets of all the following data.
+	edited := append(append(append([]byte(nil), data[:10000]...), []byte("inserted data")...), data[10000:]...)
+
+	original := chunkAll(t, data, testChunkerParams)
+	originalDigests := chunkDigests(original)
+
+	shared := 0
+	for digest := range chunkDigests(chunkAll(t, edited, testChunkerParams)) {
+		if originalDigests[digest] {
+			shared++
+		}
+	}
+
+	// Only the chunks around the insert should change.
+	assert.GreaterOrEqual(t, shared, len(original)-3)
+}
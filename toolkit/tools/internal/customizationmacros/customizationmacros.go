@@ -116,7 +116,7 @@ func AddMacroFile(macroDir string, macros map[string]string, macroFileName strin
 
 	// Add the header, followed by any additional comments to the top of the file
 	finalLines := append(header, macroLines...)
-	err = file.WriteLines(finalLines, macroFilePath)
+	err = file.WriteLinesAtomic(finalLines, macroFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to write macro file:\n%w", err)
 	}
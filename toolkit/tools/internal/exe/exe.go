@@ -50,10 +50,31 @@ func SetupLogFlags(k *kingpin.Application) *logger.LogFlags {
 	lf := &logger.LogFlags{}
 	lf.LogColor = k.Flag(logger.ColorFlag, logger.ColorFlagHelp).PlaceHolder(logger.ColorsPlaceholder).Enum(logger.Colors()...)
 	lf.LogFile = k.Flag(logger.FileFlag, logger.FileFlagHelp).String()
-	lf.LogLevel = k.Flag(logger.LevelsFlag, logger.LevelsHelp).PlaceHolder(logger.LevelsPlaceholder).Enum(logger.Levels()...)
+	lf.LogLevel = new(string)
+	k.Flag(logger.LevelsFlag, logger.LevelsHelp).PlaceHolder(logger.LevelsPlaceholder).SetValue(&logLevelValue{value: lf.LogLevel})
+	lf.LogFormat = k.Flag(logger.FormatFlag, logger.FormatFlagHelp).PlaceHolder(logger.FormatsPlaceholder).Enum(logger.Formats()...)
 	return lf
 }
 
+// logLevelValue is a kingpin value that accepts a log level, optionally followed by per-component log levels.
+type logLevelValue struct {
+	value *string
+}
+
+func (v *logLevelValue) Set(level string) error {
+	_, _, err := logger.ParseLevels(level)
+	if err != nil {
+		return err
+	}
+
+	*v.value = level
+	return nil
+}
+
+func (v *logLevelValue) String() string {
+	return *v.value
+}
+
 // PlaceHolderize takes a list of available inputs and returns a corresponding placeholder
 func PlaceHolderize(thing []string) string {
 	return fmt.Sprintf("(%s)", strings.Join(thing, "|"))
@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	// The permissions of a file that is created by WriteAtomic.
+	defaultAtomicWritePerm os.FileMode = 0o644
+)
+
+// WriteAtomic writes a string to the file dst, such that dst either has its old content or the complete new content,
+// even if the write is interrupted (e.g. the build is killed or the machine loses power).
+//
+// If dst already exists, then its permissions and owner are kept. Otherwise, dst is created with permissions 0644.
+// If dst is a symlink, then the symlink itself is replaced.
+func WriteAtomic(data string, dst string) error {
+	logger.Log.Debugf("Atomically writing to (%s)", dst)
+
+	return writeAtomicHelper(data, dst, nil)
+}
+
+// WriteAtomicWithPerm is the same as WriteAtomic, except that dst is always given the permissions perm.
+func WriteAtomicWithPerm(data string, dst string, perm os.FileMode) error {
+	logger.Log.Debugf("Atomically writing to (%s) with perm (%o)", dst, perm)
+
+	return writeAtomicHelper(data, dst, &perm)
+}
+
+// WriteLinesAtomic is the same as WriteAtomic, except that each string is written on its own line.
+func WriteLinesAtomic(dataLines []string, dst string) error {
+	logger.Log.Debugf("Atomically writing to (%s)", dst)
+
+	builder := strings.Builder{}
+	for _, line := range dataLines {
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+
+	return writeAtomicHelper(builder.String(), dst, nil)
+}
+
+// writeAtomicHelper writes the data to a temporary file next to dst (so that it is on the same filesystem), flushes
+// the temporary file to disk and then renames it to dst. A rename within a filesystem is atomic.
+func writeAtomicHelper(data string, dst string, perm *os.FileMode) (err error) {
+	dstDir := filepath.Dir(dst)
+
+	mode := defaultAtomicWritePerm
+	uid, gid := -1, -1
+
+	dstInfo, err := os.Lstat(dst)
+	switch {
+	case err == nil:
+		if dstInfo.Mode().IsRegular() {
+			mode = dstInfo.Mode().Perm()
+
+			// The temporary file is owned by the current user. So, only another owner needs to be restored.
+			stat, ok := dstInfo.Sys().(*syscall.Stat_t)
+			if ok && (int(stat.Uid) != os.Geteuid() || int(stat.Gid) != os.Getegid()) {
+				uid, gid = int(stat.Uid), int(stat.Gid)
+			}
+		}
+
+	case errors.Is(err, os.ErrNotExist):
+
+	default:
+		return fmt.Errorf("failed to stat (%s):\n%w", dst, err)
+	}
+
+	if perm != nil {
+		mode = *perm
+	}
+
+	tempFile, err := os.CreateTemp(dstDir, "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for (%s):\n%w", dst, err)
+	}
+
+	tempFilePath := tempFile.Name()
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFilePath)
+		}
+	}()
+
+	_, err = tempFile.WriteString(data)
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file (%s):\n%w", tempFilePath, err)
+	}
+
+	// The temporary file is created with permissions 0600, regardless of the umask.
+	err = tempFile.Chmod(mode)
+	if err != nil {
+		return fmt.Errorf("failed to set permissions of temporary file (%s):\n%w", tempFilePath, err)
+	}
+
+	if uid >= 0 {
+		err = tempFile.Chown(uid, gid)
+		if err != nil {
+			return fmt.Errorf("failed to set owner of temporary file (%s):\n%w", tempFilePath, err)
+		}
+	}
+
+	// Without the sync, the rename may reach the disk before the data does. So, after a crash, dst may be empty.
+	err = tempFile.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temporary file (%s):\n%w", tempFilePath, err)
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temporary file (%s):\n%w", tempFilePath, err)
+	}
+
+	err = os.Rename(tempFilePath, dst)
+	if err != nil {
+		return fmt.Errorf("failed to rename temporary file (%s) to (%s):\n%w", tempFilePath, dst, err)
+	}
+
+	// Make the rename itself durable.
+	err = syncDir(dstDir)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to open directory (%s):\n%w", dirPath, err)
+	}
+	defer dir.Close()
+
+	// Some filesystems don't support syncing directories.
+	err = dir.Sync()
+	if err != nil && !errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("failed to sync directory (%s):\n%w", dirPath, err)
+	}
+
+	return nil
+}
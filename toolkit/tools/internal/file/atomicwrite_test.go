@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAtomicNewFile(t *testing.T) {
+	fileName := testFileName(t)
+
+	err := WriteAtomic("content", fileName)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, defaultAtomicWritePerm, info.Mode().Perm())
+
+	// The temporary file is gone.
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteAtomicShouldKeepPermissions(t *testing.T) {
+	fileName := testFileName(t)
+
+	err := os.WriteFile(fileName, []byte("old content"), 0o600)
+	require.NoError(t, err)
+
+	err = WriteAtomic("new content", fileName)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestWriteAtomicWithPerm(t *testing.T) {
+	fileName := testFileName(t)
+
+	err := os.WriteFile(fileName, []byte("old content"), 0o600)
+	require.NoError(t, err)
+
+	err = WriteAtomicWithPerm("#!/bin/sh\n", fileName, 0o755)
+	require.NoError(t, err)
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+}
+
+func TestWriteAtomicShouldReplaceSymlink(t *testing.T) {
+	dir := t.TempDir()
+	targetPath := filepath.Join(dir, "target")
+	linkPath := filepath.Join(dir, "link")
+
+	err := os.WriteFile(targetPath, []byte("target content"), 0o644)
+	require.NoError(t, err)
+
+	err = os.Symlink(targetPath, linkPath)
+	require.NoError(t, err)
+
+	err = WriteAtomic("new content", linkPath)
+	require.NoError(t, err)
+
+	info, err := os.Lstat(linkPath)
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+
+	content, err := os.ReadFile(targetPath)
+	require.NoError(t, err)
+	assert.Equal(t, "target content", string(content))
+}
+
+func TestWriteAtomicMissingDir(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "missing", "file")
+
+	err := WriteAtomic("content", fileName)
+	assert.ErrorContains(t, err, "failed to create temporary file for")
+}
+
+func TestWriteLinesAtomic(t *testing.T) {
+	fileName := testFileName(t)
+
+	err := WriteLinesAtomic([]string{"a", "b"}, fileName)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\n", string(content))
+}
@@ -93,6 +93,20 @@ func Copy(src, dst string) (err error) {
 // CopyDir copies src directory to dst, creating the dst directory if needed.
 // dst is assumed to be a directory and not a file.
 func CopyDir(src, dst string, newDirPermissions, childFilePermissions fs.FileMode, mergedDirPermissions *fs.FileMode) (err error) {
+	return copyDirHelper(src, dst, newDirPermissions, childFilePermissions, mergedDirPermissions, XattrClassNone)
+}
+
+// CopyDirWithXattrs is the same as CopyDir, except that the selected classes of extended attributes of the files and
+// directories are also copied.
+func CopyDirWithXattrs(src, dst string, newDirPermissions, childFilePermissions fs.FileMode,
+	mergedDirPermissions *fs.FileMode, xattrClasses XattrClass,
+) (err error) {
+	return copyDirHelper(src, dst, newDirPermissions, childFilePermissions, mergedDirPermissions, xattrClasses)
+}
+
+func copyDirHelper(src, dst string, newDirPermissions, childFilePermissions fs.FileMode,
+	mergedDirPermissions *fs.FileMode, xattrClasses XattrClass,
+) (err error) {
 	isDstExist, err := PathExists(dst)
 	if err != nil {
 		return err
@@ -135,17 +149,29 @@ func CopyDir(src, dst string, newDirPermissions, childFilePermissions fs.FileMod
 
 		if entry.IsDir() {
 			// If it's a directory, recursively copy it
-			if err := CopyDir(srcPath, dstPath, newDirPermissions, childFilePermissions, mergedDirPermissions); err != nil {
+			err := copyDirHelper(srcPath, dstPath, newDirPermissions, childFilePermissions, mergedDirPermissions,
+				xattrClasses)
+			if err != nil {
 				return err
 			}
 		} else {
 			// If it's a file, copy it and set file permissions
-			if err := NewFileCopyBuilder(srcPath, dstPath).SetFileMode(childFilePermissions).Run(); err != nil {
+			err := NewFileCopyBuilder(srcPath, dstPath).
+				SetFileMode(childFilePermissions).
+				SetPreserveXattrs(xattrClasses).
+				Run()
+			if err != nil {
 				return fmt.Errorf("failed to copy file (%s) to (%s):\n%w", srcPath, dstPath, err)
 			}
 		}
 	}
 
+	// The directory's xattrs include its default ACL, which is inherited by the files that are later created in it.
+	err = CopyXattrs(src, dst, xattrClasses)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
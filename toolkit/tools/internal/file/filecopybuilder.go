@@ -19,6 +19,8 @@ type FileCopyBuilder struct {
 	ChangeFileMode bool
 	FileMode       os.FileMode
 	NoDereference  bool
+	// The classes of extended attributes of Src that are copied to Dst.
+	PreserveXattrs XattrClass
 }
 
 func NewFileCopyBuilder(src string, dst string) FileCopyBuilder {
@@ -29,6 +31,7 @@ func NewFileCopyBuilder(src string, dst string) FileCopyBuilder {
 		ChangeFileMode: false,
 		FileMode:       os.ModePerm,
 		NoDereference:  false,
+		PreserveXattrs: XattrClassNone,
 	}
 }
 
@@ -48,6 +51,13 @@ func (b FileCopyBuilder) SetNoDereference() FileCopyBuilder {
 	return b
 }
 
+// SetPreserveXattrs sets the classes of extended attributes (e.g. file capabilities, SELinux labels, and ACLs) that
+// are copied along with the file.
+func (b FileCopyBuilder) SetPreserveXattrs(classes XattrClass) FileCopyBuilder {
+	b.PreserveXattrs = classes
+	return b
+}
+
 func (b FileCopyBuilder) Run() (err error) {
 	logger.Log.Debugf("Copying (%s) to (%s)", b.Src, b.Dst)
 
@@ -101,5 +111,11 @@ func (b FileCopyBuilder) Run() (err error) {
 		}
 	}
 
+	// Copy the xattrs last, since changes to the file (e.g. chown) may clear its file capabilities.
+	err = CopyXattrs(b.Src, b.Dst, b.PreserveXattrs)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
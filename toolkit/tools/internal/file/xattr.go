@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"strings"
+)
+
+// XattrClass is a set of classes of extended attributes, which selects the extended attributes that are preserved
+// when a file is copied.
+type XattrClass uint
+
+const (
+	// XattrClassCapabilities is the file capabilities (security.capability).
+	XattrClassCapabilities XattrClass = 1 << iota
+	// XattrClassSELinux is the SELinux label (security.selinux).
+	XattrClassSELinux
+	// XattrClassAcls is the POSIX ACLs (system.posix_acl_access and system.posix_acl_default).
+	XattrClassAcls
+	// XattrClassSecurity is the other attributes of the security namespace (e.g. security.ima).
+	XattrClassSecurity
+	// XattrClassTrusted is the attributes of the trusted namespace. Requires CAP_SYS_ADMIN.
+	XattrClassTrusted
+	// XattrClassUser is the attributes of the user namespace.
+	XattrClassUser
+)
+
+const (
+	// XattrClassNone doesn't preserve any extended attributes.
+	XattrClassNone XattrClass = 0
+	// XattrClassAll preserves all the classes of extended attributes.
+	XattrClassAll = XattrClassCapabilities | XattrClassSELinux | XattrClassAcls | XattrClassSecurity |
+		XattrClassTrusted | XattrClassUser
+)
+
+const (
+	xattrNameCapability = "security.capability"
+	xattrNameSELinux    = "security.selinux"
+	xattrNameAclAccess  = "system.posix_acl_access"
+	xattrNameAclDefault = "system.posix_acl_default"
+	xattrPrefixSecurity = "security."
+	xattrPrefixTrusted  = "trusted."
+	xattrPrefixUser     = "user."
+)
+
+// xattrClassOf returns the class of an extended attribute. Returns XattrClassNone for attributes that are never
+// copied.
+func xattrClassOf(name string) XattrClass {
+	switch {
+	case name == xattrNameCapability:
+		return XattrClassCapabilities
+
+	case name == xattrNameSELinux:
+		return XattrClassSELinux
+
+	case name == xattrNameAclAccess || name == xattrNameAclDefault:
+		return XattrClassAcls
+
+	case strings.HasPrefix(name, xattrPrefixSecurity):
+		return XattrClassSecurity
+
+	case strings.HasPrefix(name, xattrPrefixTrusted):
+		return XattrClassTrusted
+
+	case strings.HasPrefix(name, xattrPrefixUser):
+		return XattrClassUser
+
+	default:
+		return XattrClassNone
+	}
+}
+
+// CopyXattrs copies the extended attributes of src, that are in one of the classes, to dst. Symlinks are not
+// followed.
+func CopyXattrs(src string, dst string, classes XattrClass) error {
+	if classes == XattrClassNone {
+		return nil
+	}
+
+	return copyXattrs(src, dst, classes)
+}
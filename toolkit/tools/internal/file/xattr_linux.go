@@ -0,0 +1,109 @@
+//go:build linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"golang.org/x/sys/unix"
+)
+
+func copyXattrs(src string, dst string, classes XattrClass) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return err
+	}
+
+	// The kernel only allows user.* attributes on regular files and directories.
+	dstInfo, err := os.Lstat(dst)
+	if err != nil {
+		return fmt.Errorf("failed to stat (%s):\n%w", dst, err)
+	}
+
+	if dstInfo.Mode()&os.ModeSymlink != 0 {
+		classes &^= XattrClassUser
+	}
+
+	for _, name := range names {
+		if xattrClassOf(name)&classes == 0 {
+			continue
+		}
+
+		value, err := getXattr(src, name)
+		if err != nil {
+			return err
+		}
+
+		logger.Log.Tracef("Copying xattr (%s) from (%s) to (%s)", name, src, dst)
+
+		err = unix.Lsetxattr(dst, name, value, 0)
+		if err != nil {
+			return fmt.Errorf("failed to set xattr (%s) of (%s):\n%w", name, dst, err)
+		}
+	}
+
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if errors.Is(err, unix.ENOTSUP) {
+			// The filesystem doesn't support extended attributes. So, there aren't any.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list xattrs of (%s):\n%w", path, err)
+		}
+
+		if size <= 0 {
+			return nil, nil
+		}
+
+		buffer := make([]byte, size)
+		size, err = unix.Llistxattr(path, buffer)
+		if errors.Is(err, unix.ERANGE) {
+			// An attribute was added since the size was queried.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list xattrs of (%s):\n%w", path, err)
+		}
+
+		names := []string(nil)
+		for _, name := range strings.Split(string(buffer[:size]), "\x00") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(path string, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get xattr (%s) of (%s):\n%w", name, path, err)
+		}
+
+		buffer := make([]byte, size)
+		size, err = unix.Lgetxattr(path, name, buffer)
+		if errors.Is(err, unix.ERANGE) {
+			// The attribute grew since the size was queried.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get xattr (%s) of (%s):\n%w", name, path, err)
+		}
+
+		return buffer[:size], nil
+	}
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// A version 2 file capability that grants cap_net_bind_service (bit 10).
+var testCapabilityValue = []byte{
+	0x00, 0x00, 0x00, 0x02,
+	0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func setTestXattr(t *testing.T, path string, name string, value []byte) {
+	err := unix.Lsetxattr(path, name, value, 0)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+		t.Skipf("can't set xattr (%s) in test environment: %s", name, err)
+	}
+	require.NoError(t, err)
+}
+
+func TestFileCopyPreserveXattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	err := os.WriteFile(src, []byte("test string"), 0o755)
+	require.NoError(t, err)
+
+	setTestXattr(t, src, "user.test", []byte("value"))
+	setTestXattr(t, src, xattrNameCapability, testCapabilityValue)
+
+	// Copy everything.
+	dstAll := filepath.Join(tempDir, "dst/all")
+	err = NewFileCopyBuilder(src, dstAll).
+		SetFileMode(0o750).
+		SetPreserveXattrs(XattrClassAll).
+		Run()
+	require.NoError(t, err)
+
+	value, err := getXattr(dstAll, "user.test")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	value, err = getXattr(dstAll, xattrNameCapability)
+	assert.NoError(t, err)
+	assert.Equal(t, testCapabilityValue, value)
+
+	// Only copy the file capabilities.
+	dstCapabilities := filepath.Join(tempDir, "dst/capabilities")
+	err = NewFileCopyBuilder(src, dstCapabilities).
+		SetPreserveXattrs(XattrClassCapabilities).
+		Run()
+	require.NoError(t, err)
+
+	names, err := listXattrs(dstCapabilities)
+	assert.NoError(t, err)
+	assert.Contains(t, names, xattrNameCapability)
+	assert.NotContains(t, names, "user.test")
+
+	// By default, no xattrs are copied.
+	dstDefault := filepath.Join(tempDir, "dst/default")
+	err = NewFileCopyBuilder(src, dstDefault).
+		Run()
+	require.NoError(t, err)
+
+	names, err = listXattrs(dstDefault)
+	assert.NoError(t, err)
+	assert.NotContains(t, names, xattrNameCapability)
+	assert.NotContains(t, names, "user.test")
+}
+
+func TestCopyDirWithXattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "src")
+	srcFile := filepath.Join(srcDir, "sub/file")
+	err := os.MkdirAll(filepath.Dir(srcFile), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(srcFile, []byte("test string"), 0o644)
+	require.NoError(t, err)
+
+	setTestXattr(t, srcFile, "user.file", []byte("file"))
+	setTestXattr(t, filepath.Join(srcDir, "sub"), "user.dir", []byte("dir"))
+
+	dstDir := filepath.Join(tempDir, "dst")
+	err = CopyDirWithXattrs(srcDir, dstDir, 0o755, 0o644, nil, XattrClassUser)
+	require.NoError(t, err)
+
+	value, err := getXattr(filepath.Join(dstDir, "sub/file"), "user.file")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("file"), value)
+
+	value, err = getXattr(filepath.Join(dstDir, "sub"), "user.dir")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("dir"), value)
+}
+
+func TestCopyXattrsSkipsUserXattrsOnSymlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src")
+	err := os.WriteFile(src, []byte("test string"), 0o644)
+	require.NoError(t, err)
+
+	setTestXattr(t, src, "user.test", []byte("value"))
+
+	dst := filepath.Join(tempDir, "dst")
+	err = os.Symlink(src, dst)
+	require.NoError(t, err)
+
+	err = CopyXattrs(src, dst, XattrClassAll)
+	assert.NoError(t, err)
+
+	names, err := listXattrs(dst)
+	assert.NoError(t, err)
+	assert.NotContains(t, names, "user.test")
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"fmt"
+)
+
+func copyXattrs(src string, dst string, classes XattrClass) error {
+	return fmt.Errorf("copying xattrs is only supported on Linux")
+}
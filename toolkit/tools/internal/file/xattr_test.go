@@ -0,0 +1,27 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXattrClassOf(t *testing.T) {
+	assert.Equal(t, XattrClassCapabilities, xattrClassOf("security.capability"))
+	assert.Equal(t, XattrClassSELinux, xattrClassOf("security.selinux"))
+	assert.Equal(t, XattrClassAcls, xattrClassOf("system.posix_acl_access"))
+	assert.Equal(t, XattrClassAcls, xattrClassOf("system.posix_acl_default"))
+	assert.Equal(t, XattrClassSecurity, xattrClassOf("security.ima"))
+	assert.Equal(t, XattrClassTrusted, xattrClassOf("trusted.overlay.opaque"))
+	assert.Equal(t, XattrClassUser, xattrClassOf("user.mime_type"))
+	assert.Equal(t, XattrClassNone, xattrClassOf("system.nfs4_acl"))
+}
+
+func TestCopyXattrsNone(t *testing.T) {
+	// Nothing is read when no classes are selected.
+	err := CopyXattrs("/does/not/exist", "/does/not/exist", XattrClassNone)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,262 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package gpgkeyring manages the GPG keys trusted to sign the packages of a build configuration, and verifies RPMs
+// against them.
+//
+// A keyring is a JSON file pinning the fingerprint of each trusted key, next to copies of the key files. Loading a
+// keyring fails if a key file no longer matches its pinned fingerprint. Rotating a key imports its replacement and
+// retires the old key after a grace period, past which packages signed by it fail verification.
+package gpgkeyring
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// KeyringVersion is the version of the keyring format written by Save.
+const KeyringVersion = 1
+
+// keyFileExtension is the extension of the key files copied into the keyring's directory.
+const keyFileExtension = ".key"
+
+// Keyring is a set of pinned GPG keys.
+type Keyring struct {
+	Version int          `json:"Version"`
+	Keys    []*PinnedKey `json:"Keys"`
+
+	path string
+}
+
+// PinnedKey is a GPG key trusted by a keyring.
+type PinnedKey struct {
+	// Fingerprint of the primary key, which the key file must match.
+	Fingerprint string `json:"Fingerprint"`
+	// File is the name of the key file, in the keyring's directory.
+	File     string    `json:"File"`
+	UserIDs  []string  `json:"UserIDs,omitempty"`
+	Imported time.Time `json:"Imported"`
+	// Retires is when a rotated out key stops being trusted. Nil for active keys.
+	Retires *time.Time `json:"Retires,omitempty"`
+
+	keyIDs []string
+}
+
+// IsRetired reports if the key is no longer trusted at a time.
+func (k *PinnedKey) IsRetired(now time.Time) bool {
+	return k.Retires != nil && !now.Before(*k.Retires)
+}
+
+// New returns an empty keyring, saved to keyringFile.
+func New(keyringFile string) *Keyring {
+	return &Keyring{Version: KeyringVersion, path: keyringFile}
+}
+
+// Load reads a keyring and checks that its key files match their pinned fingerprints.
+func Load(keyringFile string) (keyring *Keyring, err error) {
+	keyring = &Keyring{path: keyringFile}
+
+	err = jsonutils.ReadJSONFile(keyringFile, keyring)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG keyring (%s):\n%w", keyringFile, err)
+	}
+
+	if keyring.Version != KeyringVersion {
+		return nil, fmt.Errorf("unsupported GPG keyring version (%d) in (%s), expected (%d)", keyring.Version, keyringFile, KeyringVersion)
+	}
+
+	for _, key := range keyring.Keys {
+		var publicKey *PublicKey
+
+		publicKey, err = readSingleKey(keyring.KeyFilePath(key))
+		if err != nil {
+			return nil, err
+		}
+
+		if publicKey.Fingerprint != key.Fingerprint {
+			return nil, fmt.Errorf("GPG key file (%s) doesn't match its pinned fingerprint (%s), got (%s)", key.File, key.Fingerprint, publicKey.Fingerprint)
+		}
+
+		key.keyIDs = publicKey.KeyIDs
+	}
+
+	return
+}
+
+// LoadOrNew loads a keyring, or returns an empty one if keyringFile doesn't exist yet.
+func LoadOrNew(keyringFile string) (keyring *Keyring, err error) {
+	exists, err := file.PathExists(keyringFile)
+	if err != nil {
+		return
+	}
+
+	if !exists {
+		return New(keyringFile), nil
+	}
+
+	return Load(keyringFile)
+}
+
+// Save writes the keyring back to its file.
+func (k *Keyring) Save() (err error) {
+	sort.Slice(k.Keys, func(i, j int) bool {
+		return k.Keys[i].Imported.Before(k.Keys[j].Imported)
+	})
+
+	return jsonutils.WriteJSONFile(k.path, k)
+}
+
+// KeyFilePath returns the path of the copy of a key file in the keyring's directory.
+func (k *Keyring) KeyFilePath(key *PinnedKey) string {
+	return filepath.Join(filepath.Dir(k.path), key.File)
+}
+
+// Import pins the key of keyFile, copying the file next to the keyring. Importing a pinned key again is a no-op.
+func (k *Keyring) Import(keyFile string) (key *PinnedKey, err error) {
+	publicKey, err := readSingleKey(keyFile)
+	if err != nil {
+		return
+	}
+
+	key = k.findByFingerprint(publicKey.Fingerprint)
+	if key != nil {
+		logger.Log.Infof("GPG key (%s) is already pinned", key.Fingerprint)
+		return
+	}
+
+	key = &PinnedKey{
+		Fingerprint: publicKey.Fingerprint,
+		File:        publicKey.Fingerprint + keyFileExtension,
+		UserIDs:     publicKey.UserIDs,
+		Imported:    time.Now().UTC(),
+		keyIDs:      publicKey.KeyIDs,
+	}
+
+	err = os.MkdirAll(filepath.Dir(k.path), os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the GPG keyring directory:\n%w", err)
+	}
+
+	err = file.Copy(keyFile, k.KeyFilePath(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy GPG key file (%s) into the keyring:\n%w", keyFile, err)
+	}
+
+	logger.Log.Infof("Pinned GPG key (%s) %v", key.Fingerprint, key.UserIDs)
+	k.Keys = append(k.Keys, key)
+
+	return
+}
+
+// Rotate imports the key of newKeyFile and retires the key pinned as oldFingerprint once gracePeriod has passed.
+func (k *Keyring) Rotate(newKeyFile, oldFingerprint string, gracePeriod time.Duration) (err error) {
+	oldKey := k.findByFingerprint(NormalizeFingerprint(oldFingerprint))
+	if oldKey == nil {
+		return fmt.Errorf("GPG key (%s) isn't pinned", oldFingerprint)
+	}
+
+	newKey, err := k.Import(newKeyFile)
+	if err != nil {
+		return
+	}
+
+	if newKey == oldKey {
+		return fmt.Errorf("can't rotate GPG key (%s) to itself", oldKey.Fingerprint)
+	}
+
+	retires := time.Now().UTC().Add(gracePeriod)
+	if oldKey.Retires != nil && oldKey.Retires.Before(retires) {
+		retires = *oldKey.Retires
+	}
+	oldKey.Retires = &retires
+
+	logger.Log.Infof("GPG key (%s) replaced by (%s), retiring on %s", oldKey.Fingerprint, newKey.Fingerprint, retires.Format(time.RFC3339))
+
+	return
+}
+
+// Remove unpins a key and deletes its key file.
+func (k *Keyring) Remove(fingerprint string) (err error) {
+	fingerprint = NormalizeFingerprint(fingerprint)
+
+	for i, key := range k.Keys {
+		if key.Fingerprint != fingerprint {
+			continue
+		}
+
+		err = os.Remove(k.KeyFilePath(key))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove GPG key file (%s):\n%w", key.File, err)
+		}
+
+		k.Keys = append(k.Keys[:i], k.Keys[i+1:]...)
+		logger.Log.Infof("Removed GPG key (%s)", fingerprint)
+
+		return nil
+	}
+
+	return fmt.Errorf("GPG key (%s) isn't pinned", fingerprint)
+}
+
+// ActiveKeys returns the keys trusted at a time.
+func (k *Keyring) ActiveKeys(now time.Time) (keys []*PinnedKey) {
+	for _, key := range k.Keys {
+		if !key.IsRetired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return
+}
+
+// FindByKeyID returns the pinned key owning the primary key or subkey with a key ID, or nil.
+func (k *Keyring) FindByKeyID(keyID string) *PinnedKey {
+	keyID = strings.ToUpper(keyID)
+	for _, key := range k.Keys {
+		for _, pinnedKeyID := range key.keyIDs {
+			if pinnedKeyID == keyID {
+				return key
+			}
+		}
+	}
+
+	return nil
+}
+
+// findByFingerprint returns the pinned key with a fingerprint, or nil.
+func (k *Keyring) findByFingerprint(fingerprint string) *PinnedKey {
+	for _, key := range k.Keys {
+		if key.Fingerprint == fingerprint {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// NormalizeFingerprint formats a fingerprint the way keyrings store it: upper case hex without spaces.
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+}
+
+// readSingleKey reads a key file which must hold exactly one public key, so that each pinned key has its own file.
+func readSingleKey(keyFile string) (publicKey *PublicKey, err error) {
+	publicKeys, err := ReadPublicKeyFile(keyFile)
+	if err != nil {
+		return
+	}
+
+	if len(publicKeys) != 1 {
+		return nil, fmt.Errorf("GPG key file (%s) holds %d keys, expected exactly one", keyFile, len(publicKeys))
+	}
+
+	return publicKeys[0], nil
+}
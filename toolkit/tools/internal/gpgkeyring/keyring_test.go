@@ -0,0 +1,160 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package gpgkeyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func newTestKeyring(t *testing.T) *Keyring {
+	keyring, err := LoadOrNew(filepath.Join(t.TempDir(), "keyring", "keyring.json"))
+	require.NoError(t, err)
+
+	return keyring
+}
+
+func readTestSignature(t *testing.T, name string) []byte {
+	signature, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	return signature
+}
+
+func TestImportAndLoad(t *testing.T) {
+	keyring := newTestKeyring(t)
+
+	key, err := keyring.Import(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+	assert.Equal(t, testKeyFingerprint, key.Fingerprint)
+	assert.Equal(t, testKeyFingerprint+".key", key.File)
+
+	// Importing the same key again doesn't duplicate it.
+	_, err = keyring.Import(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+	require.NoError(t, keyring.Save())
+
+	loaded, err := Load(keyring.path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Keys, 1)
+	assert.Equal(t, key, loaded.FindByKeyID(testSubkeyID))
+	assert.Nil(t, loaded.FindByKeyID("0CD9FED33135CE90"))
+}
+
+func TestLoadDetectsReplacedKeyFile(t *testing.T) {
+	keyring := newTestKeyring(t)
+
+	key, err := keyring.Import(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+	require.NoError(t, keyring.Save())
+
+	replacement, err := os.ReadFile(filepath.Join("testdata", "rotated-signing-key.gpg"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyring.KeyFilePath(key), replacement, 0o644))
+
+	_, err = Load(keyring.path)
+	assert.ErrorContains(t, err, "doesn't match its pinned fingerprint ("+testKeyFingerprint+"), got ("+rotatedKeyFingerprint+")")
+}
+
+func TestRotate(t *testing.T) {
+	keyring := newTestKeyring(t)
+
+	oldKey, err := keyring.Import(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+
+	err = keyring.Rotate(filepath.Join("testdata", "rotated-signing-key.gpg"), "f96a b967 6b61 ac44 5432 7f31 246b 1a3a bb23 0a1c", time.Hour)
+	require.NoError(t, err)
+	require.Len(t, keyring.Keys, 2)
+	require.NotNil(t, oldKey.Retires)
+
+	assert.Len(t, keyring.ActiveKeys(time.Now()), 2)
+	assert.Equal(t, []*PinnedKey{keyring.Keys[1]}, keyring.ActiveKeys(time.Now().Add(2*time.Hour)))
+
+	// Rotating again can't extend the grace period.
+	retires := *oldKey.Retires
+	err = keyring.Rotate(filepath.Join("testdata", "MICROSOFT-RPM-GPG-KEY"), testKeyFingerprint, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, retires, *oldKey.Retires)
+
+	err = keyring.Rotate(filepath.Join("testdata", "rotated-signing-key.gpg"), rotatedKeyFingerprint, time.Hour)
+	assert.ErrorContains(t, err, "can't rotate GPG key")
+
+	err = keyring.Rotate(filepath.Join("testdata", "rotated-signing-key.gpg"), "0000", time.Hour)
+	assert.ErrorContains(t, err, "isn't pinned")
+}
+
+func TestRemove(t *testing.T) {
+	keyring := newTestKeyring(t)
+
+	key, err := keyring.Import(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+
+	require.NoError(t, keyring.Remove(testKeyFingerprint))
+	assert.Empty(t, keyring.Keys)
+	assert.NoFileExists(t, keyring.KeyFilePath(key))
+
+	assert.ErrorContains(t, keyring.Remove(testKeyFingerprint), "isn't pinned")
+}
+
+func TestClassifySignatures(t *testing.T) {
+	keyring := newTestKeyring(t)
+
+	key, err := keyring.Import(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+
+	now := time.Now()
+	primarySignature := readTestSignature(t, "test-signature.sig")
+	subkeySignature := readTestSignature(t, "test-subkey-signature.sig")
+
+	verification := &PackageVerification{}
+	keyring.classifySignatures(verification, [][]byte{primarySignature, subkeySignature}, now)
+	assert.Equal(t, StatusVerified, verification.Status)
+	assert.Equal(t, []string{testKeyID, testSubkeyID}, verification.KeyIDs)
+	assert.Equal(t, []string{testKeyFingerprint}, verification.Fingerprints)
+
+	verification = &PackageVerification{}
+	keyring.classifySignatures(verification, nil, now)
+	assert.Equal(t, StatusUnsigned, verification.Status)
+
+	verification = &PackageVerification{}
+	keyring.classifySignatures(verification, [][]byte{[]byte("garbage")}, now)
+	assert.Equal(t, StatusBadSignature, verification.Status)
+
+	retires := now.Add(-time.Minute)
+	key.Retires = &retires
+	verification = &PackageVerification{}
+	keyring.classifySignatures(verification, [][]byte{subkeySignature}, now)
+	assert.Equal(t, StatusRetiredKey, verification.Status)
+
+	require.NoError(t, keyring.Remove(testKeyFingerprint))
+	verification = &PackageVerification{}
+	keyring.classifySignatures(verification, [][]byte{primarySignature}, now)
+	assert.Equal(t, StatusUnknownKey, verification.Status)
+	assert.Equal(t, "signed by key ID ("+testKeyID+"), which isn't pinned", verification.Message)
+}
+
+func TestVerificationReportErr(t *testing.T) {
+	report := &VerificationReport{
+		Keyring: "keyring.json",
+		Packages: []*PackageVerification{
+			{FileName: "bash-5.2.15-3.azl3.x86_64.rpm", Status: StatusVerified},
+			{FileName: "local-1.0-1.azl3.x86_64.rpm", Status: StatusUnsigned, Message: "the package has no signature"},
+		},
+	}
+	assert.EqualError(t, report.Err(), "GPG verification against keyring (keyring.json) failed for 1 of 2 packages:\nlocal-1.0-1.azl3.x86_64.rpm: unsigned (the package has no signature)")
+
+	report.Packages = report.Packages[:1]
+	assert.NoError(t, report.Err())
+}
@@ -0,0 +1,415 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package gpgkeyring
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Just enough of OpenPGP (RFC 4880) to identify keys and the issuers of signatures. The signatures themselves are
+// verified by rpmkeys.
+
+// Tags of the OpenPGP packets.
+const (
+	packetTagSignature = 2
+	packetTagPublicKey = 6
+	packetTagUserID    = 13
+	packetTagSubkey    = 14
+)
+
+// Types of the signature subpackets.
+const (
+	subpacketIssuer            = 16
+	subpacketIssuerFingerprint = 33
+	subpacketTypeMask          = 0x7f
+)
+
+const (
+	armorBegin     = "-----BEGIN PGP "
+	armorEnd       = "-----END PGP "
+	armorChecksum  = "="
+	crc24Init      = 0xb704ce
+	crc24Poly      = 0x1864cfb
+	crc24Mask      = 0xffffff
+	keyIDLength    = 8
+	v4KeyVersion   = 4
+	v4KeyTimeStart = 1
+	v4KeyTimeEnd   = 5
+	v4HashPrefix   = 0x99
+)
+
+// PublicKey is an OpenPGP public key with its subkeys.
+type PublicKey struct {
+	// Fingerprint is the upper case hex encoded fingerprint of the primary key.
+	Fingerprint string
+	// KeyIDs are the upper case hex encoded IDs of the primary key and of its subkeys.
+	KeyIDs  []string
+	UserIDs []string
+	Created time.Time
+}
+
+// packet is an OpenPGP packet.
+type packet struct {
+	tag  int
+	body []byte
+}
+
+// ReadPublicKeyFile reads the OpenPGP public keys of an armored or binary key file.
+func ReadPublicKeyFile(keyFile string) (keys []*PublicKey, err error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG key file (%s):\n%w", keyFile, err)
+	}
+
+	keys, err = ParsePublicKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GPG key file (%s):\n%w", keyFile, err)
+	}
+
+	return
+}
+
+// ParsePublicKeys parses the OpenPGP public keys of armored or binary data.
+func ParsePublicKeys(data []byte) (keys []*PublicKey, err error) {
+	if bytes.Contains(data, []byte(armorBegin)) {
+		data, err = dearmor(data)
+		if err != nil {
+			return
+		}
+	}
+
+	packets, err := readPackets(data)
+	if err != nil {
+		return
+	}
+
+	var currentKey *PublicKey
+	for _, p := range packets {
+		switch p.tag {
+		case packetTagPublicKey:
+			currentKey = &PublicKey{}
+			keys = append(keys, currentKey)
+
+			var fingerprint []byte
+			fingerprint, currentKey.Created, err = v4Fingerprint(p.body)
+			if err != nil {
+				return nil, err
+			}
+			currentKey.Fingerprint = strings.ToUpper(hex.EncodeToString(fingerprint))
+			currentKey.KeyIDs = append(currentKey.KeyIDs, keyIDFromFingerprint(fingerprint))
+		case packetTagSubkey:
+			if currentKey == nil {
+				return nil, fmt.Errorf("subkey without a primary key")
+			}
+
+			var fingerprint []byte
+			fingerprint, _, err = v4Fingerprint(p.body)
+			if err != nil {
+				return nil, err
+			}
+			currentKey.KeyIDs = append(currentKey.KeyIDs, keyIDFromFingerprint(fingerprint))
+		case packetTagUserID:
+			if currentKey != nil {
+				currentKey.UserIDs = append(currentKey.UserIDs, string(p.body))
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key found")
+	}
+
+	return
+}
+
+// SignatureIssuer returns the upper case hex encoded ID of the key which made an OpenPGP signature packet.
+func SignatureIssuer(signature []byte) (keyID string, err error) {
+	packets, err := readPackets(signature)
+	if err != nil {
+		return
+	}
+
+	if len(packets) != 1 || packets[0].tag != packetTagSignature {
+		return "", fmt.Errorf("not a single OpenPGP signature packet")
+	}
+
+	body := packets[0].body
+	if len(body) == 0 {
+		return "", fmt.Errorf("empty signature packet")
+	}
+
+	switch body[0] {
+	case 3:
+		// Version, hashed length, type, creation time, then the key ID.
+		const keyIDStart = 7
+		if len(body) < keyIDStart+keyIDLength {
+			return "", fmt.Errorf("truncated version 3 signature")
+		}
+		return strings.ToUpper(hex.EncodeToString(body[keyIDStart : keyIDStart+keyIDLength])), nil
+	case 4:
+		return v4SignatureIssuer(body)
+	default:
+		return "", fmt.Errorf("unsupported signature version (%d)", body[0])
+	}
+}
+
+// v4SignatureIssuer looks for the issuer in the hashed, then the unhashed, subpackets of a version 4 signature.
+func v4SignatureIssuer(body []byte) (keyID string, err error) {
+	// Version, type, public key algorithm and hash algorithm precede the hashed subpackets.
+	offset := 4
+	for _, area := range []string{"hashed", "unhashed"} {
+		if len(body) < offset+2 {
+			return "", fmt.Errorf("truncated %s subpackets", area)
+		}
+		length := int(binary.BigEndian.Uint16(body[offset:]))
+		offset += 2
+		if len(body) < offset+length {
+			return "", fmt.Errorf("truncated %s subpackets", area)
+		}
+
+		keyID, err = subpacketsIssuer(body[offset : offset+length])
+		if err != nil || keyID != "" {
+			return
+		}
+		offset += length
+	}
+
+	return "", fmt.Errorf("signature has no issuer")
+}
+
+// subpacketsIssuer returns the key ID of the issuer or issuer fingerprint subpacket, if any.
+func subpacketsIssuer(subpackets []byte) (keyID string, err error) {
+	for len(subpackets) > 0 {
+		var length, lengthSize int
+
+		length, lengthSize, err = subpacketLength(subpackets)
+		if err != nil {
+			return
+		}
+
+		subpackets = subpackets[lengthSize:]
+		if length == 0 || len(subpackets) < length {
+			return "", fmt.Errorf("truncated signature subpacket")
+		}
+
+		subpacketType, data := subpackets[0]&subpacketTypeMask, subpackets[1:length]
+		switch {
+		case subpacketType == subpacketIssuer && len(data) == keyIDLength:
+			return strings.ToUpper(hex.EncodeToString(data)), nil
+		case subpacketType == subpacketIssuerFingerprint && len(data) == 1+sha1.Size && data[0] == v4KeyVersion:
+			return keyIDFromFingerprint(data[1:]), nil
+		}
+
+		subpackets = subpackets[length:]
+	}
+
+	return
+}
+
+// subpacketLength decodes the length of a signature subpacket, including its type.
+func subpacketLength(data []byte) (length, lengthSize int, err error) {
+	switch {
+	case data[0] < 192:
+		return int(data[0]), 1, nil
+	case data[0] < 255:
+		if len(data) < 2 {
+			break
+		}
+		return (int(data[0])-192)<<8 + int(data[1]) + 192, 2, nil
+	default:
+		if len(data) < 5 {
+			break
+		}
+		return int(binary.BigEndian.Uint32(data[1:5])), 5, nil
+	}
+
+	return 0, 0, fmt.Errorf("truncated signature subpacket length")
+}
+
+// v4Fingerprint returns the fingerprint and creation time of a version 4 public key or subkey packet.
+func v4Fingerprint(body []byte) (fingerprint []byte, created time.Time, err error) {
+	if len(body) == 0 {
+		return nil, created, fmt.Errorf("empty public key packet")
+	}
+
+	if body[0] != v4KeyVersion {
+		return nil, created, fmt.Errorf("unsupported public key version (%d)", body[0])
+	}
+
+	if len(body) < v4KeyTimeEnd {
+		return nil, created, fmt.Errorf("truncated public key packet")
+	}
+
+	hash := sha1.New()
+	hash.Write([]byte{v4HashPrefix, byte(len(body) >> 8), byte(len(body))})
+	hash.Write(body)
+
+	created = time.Unix(int64(binary.BigEndian.Uint32(body[v4KeyTimeStart:v4KeyTimeEnd])), 0).UTC()
+
+	return hash.Sum(nil), created, nil
+}
+
+// keyIDFromFingerprint returns the key ID of a version 4 fingerprint: its last 8 bytes.
+func keyIDFromFingerprint(fingerprint []byte) string {
+	return strings.ToUpper(hex.EncodeToString(fingerprint[len(fingerprint)-keyIDLength:]))
+}
+
+// readPackets splits data into OpenPGP packets, in the old or the new format.
+func readPackets(data []byte) (packets []packet, err error) {
+	for len(data) > 0 {
+		var (
+			tag        int
+			length     int
+			headerSize int
+		)
+
+		packetHeader := data[0]
+		if packetHeader&0x80 == 0 {
+			return nil, fmt.Errorf("invalid OpenPGP packet header (%#x)", packetHeader)
+		}
+
+		if packetHeader&0x40 != 0 {
+			tag = int(packetHeader & 0x3f)
+			length, headerSize, err = newFormatLength(data)
+		} else {
+			tag = int(packetHeader>>2) & 0xf
+			length, headerSize, err = oldFormatLength(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) < headerSize+length {
+			return nil, fmt.Errorf("truncated OpenPGP packet (tag %d)", tag)
+		}
+
+		packets = append(packets, packet{tag: tag, body: data[headerSize : headerSize+length]})
+		data = data[headerSize+length:]
+	}
+
+	return
+}
+
+// newFormatLength decodes the length of a new format packet.
+func newFormatLength(data []byte) (length, headerSize int, err error) {
+	if len(data) < 2 {
+		return 0, 0, fmt.Errorf("truncated OpenPGP packet header")
+	}
+
+	switch firstOctet := data[1]; {
+	case firstOctet < 192:
+		return int(firstOctet), 2, nil
+	case firstOctet < 224:
+		if len(data) < 3 {
+			return 0, 0, fmt.Errorf("truncated OpenPGP packet header")
+		}
+		return (int(firstOctet)-192)<<8 + int(data[2]) + 192, 3, nil
+	case firstOctet == 255:
+		if len(data) < 6 {
+			return 0, 0, fmt.Errorf("truncated OpenPGP packet header")
+		}
+		return int(binary.BigEndian.Uint32(data[2:6])), 6, nil
+	default:
+		return 0, 0, fmt.Errorf("partial OpenPGP packet lengths are not supported")
+	}
+}
+
+// oldFormatLength decodes the length of an old format packet. An indeterminate length extends to the end of data.
+func oldFormatLength(data []byte) (length, headerSize int, err error) {
+	switch lengthType := data[0] & 0x3; lengthType {
+	case 0, 1, 2:
+		lengthSize := 1 << lengthType
+		if len(data) < 1+lengthSize {
+			return 0, 0, fmt.Errorf("truncated OpenPGP packet header")
+		}
+		for _, b := range data[1 : 1+lengthSize] {
+			length = length<<8 | int(b)
+		}
+		return length, 1 + lengthSize, nil
+	default:
+		return len(data) - 1, 1, nil
+	}
+}
+
+// dearmor decodes the first ASCII armored block of data, checking its CRC24 checksum if present.
+func dearmor(data []byte) (decoded []byte, err error) {
+	var (
+		body      strings.Builder
+		checksum  string
+		inBlock   bool
+		inHeaders bool
+		ended     bool
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for !ended && scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case !inBlock:
+			if strings.HasPrefix(line, armorBegin) {
+				inBlock, inHeaders = true, true
+			}
+		case strings.HasPrefix(line, armorEnd):
+			ended = true
+		case inHeaders && line == "":
+			inHeaders = false
+		case inHeaders && strings.Contains(line, ": "):
+			// Armor header, e.g. "Version: GnuPG v2".
+		case strings.HasPrefix(line, armorChecksum):
+			checksum = strings.TrimPrefix(line, armorChecksum)
+		default:
+			inHeaders = false
+			body.WriteString(line)
+		}
+	}
+
+	if !ended {
+		return nil, fmt.Errorf("unterminated ASCII armor")
+	}
+
+	decoded, err = base64.StdEncoding.DecodeString(body.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASCII armor:\n%w", err)
+	}
+
+	if checksum != "" {
+		expected, decodeErr := base64.StdEncoding.DecodeString(checksum)
+		if decodeErr != nil || len(expected) != 3 {
+			return nil, fmt.Errorf("invalid ASCII armor checksum (%s)", checksum)
+		}
+
+		actual := crc24(decoded)
+		if uint32(expected[0])<<16|uint32(expected[1])<<8|uint32(expected[2]) != actual {
+			return nil, fmt.Errorf("ASCII armor checksum mismatch")
+		}
+	}
+
+	return
+}
+
+// crc24 computes the checksum of the ASCII armor.
+func crc24(data []byte) uint32 {
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+
+	return crc & crc24Mask
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package gpgkeyring
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	microsoftKeyFingerprint = "2BC94FFF7015A5F28F1537AD0CD9FED33135CE90"
+	testKeyFingerprint      = "F96AB9676B61AC4454327F31246B1A3ABB230A1C"
+	testKeyID               = "246B1A3ABB230A1C"
+	testSubkeyID            = "9C91C1872142A73E"
+	rotatedKeyFingerprint   = "C8F3DC2F59BA84802BFC9D49526BDD00D5450A2F"
+)
+
+func TestReadPublicKeyFileArmored(t *testing.T) {
+	keys, err := ReadPublicKeyFile(filepath.Join("testdata", "MICROSOFT-RPM-GPG-KEY"))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	assert.Equal(t, microsoftKeyFingerprint, keys[0].Fingerprint)
+	assert.Equal(t, []string{"0CD9FED33135CE90"}, keys[0].KeyIDs)
+	assert.Equal(t, int64(1584388724), keys[0].Created.Unix())
+}
+
+func TestReadPublicKeyFileWithSubkey(t *testing.T) {
+	keys, err := ReadPublicKeyFile(filepath.Join("testdata", "test-signing-key.asc"))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	assert.Equal(t, testKeyFingerprint, keys[0].Fingerprint)
+	assert.Equal(t, []string{testKeyID, testSubkeyID}, keys[0].KeyIDs)
+	assert.Equal(t, []string{"Test Signing Key <test@example.com>"}, keys[0].UserIDs)
+}
+
+func TestReadPublicKeyFileBinary(t *testing.T) {
+	keys, err := ReadPublicKeyFile(filepath.Join("testdata", "rotated-signing-key.gpg"))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, rotatedKeyFingerprint, keys[0].Fingerprint)
+}
+
+func TestParsePublicKeysInvalidArmor(t *testing.T) {
+	armored, err := os.ReadFile(filepath.Join("testdata", "MICROSOFT-RPM-GPG-KEY"))
+	require.NoError(t, err)
+
+	lines := strings.Split(string(armored), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "=") {
+			lines[i] = "=AAAA"
+		}
+	}
+	_, err = ParsePublicKeys([]byte(strings.Join(lines, "\n")))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	_, err = ParsePublicKeys([]byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQ==\n"))
+	assert.ErrorContains(t, err, "unterminated ASCII armor")
+
+	_, err = ParsePublicKeys([]byte("not a key"))
+	assert.ErrorContains(t, err, "invalid OpenPGP packet header")
+}
+
+func TestSignatureIssuer(t *testing.T) {
+	signature, err := os.ReadFile(filepath.Join("testdata", "test-signature.sig"))
+	require.NoError(t, err)
+
+	keyID, err := SignatureIssuer(signature)
+	require.NoError(t, err)
+	assert.Equal(t, testKeyID, keyID)
+
+	signature, err = os.ReadFile(filepath.Join("testdata", "test-subkey-signature.sig"))
+	require.NoError(t, err)
+
+	keyID, err = SignatureIssuer(signature)
+	require.NoError(t, err)
+	assert.Equal(t, testSubkeyID, keyID)
+}
+
+func TestSignatureIssuerV3(t *testing.T) {
+	// Old format signature packet: version, hashed length, type, creation time, key ID, then algorithms and data.
+	body := []byte{3, 5, 0, 0, 0, 0, 0, 0x24, 0x6b, 0x1a, 0x3a, 0xbb, 0x23, 0x0a, 0x1c, 1, 8}
+	signature := append([]byte{0x88, byte(len(body))}, body...)
+
+	keyID, err := SignatureIssuer(signature)
+	require.NoError(t, err)
+	assert.Equal(t, testKeyID, keyID)
+}
+
+func TestSignatureIssuerUnhashedIssuer(t *testing.T) {
+	// Version 4 signature with no hashed subpackets and an unhashed issuer subpacket.
+	body := []byte{4, 0, 1, 8, 0, 0, 0, 10, 9, subpacketIssuer, 0x9c, 0x91, 0xc1, 0x87, 0x21, 0x42, 0xa7, 0x3e}
+	signature := append([]byte{0xc2, byte(len(body))}, body...)
+
+	keyID, err := SignatureIssuer(signature)
+	require.NoError(t, err)
+	assert.Equal(t, testSubkeyID, keyID)
+
+	noIssuer := []byte{0xc2, 8, 4, 0, 1, 8, 0, 0, 0, 0}
+	_, err = SignatureIssuer(noIssuer)
+	assert.ErrorContains(t, err, "signature has no issuer")
+}
@@ -0,0 +1,238 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package gpgkeyring
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+)
+
+// VerificationStatus is the outcome of verifying a package against a keyring.
+type VerificationStatus string
+
+const (
+	// StatusVerified means every signature of the package was made by an active pinned key and is valid.
+	StatusVerified VerificationStatus = "verified"
+	// StatusUnsigned means the package has no signature.
+	StatusUnsigned VerificationStatus = "unsigned"
+	// StatusUnknownKey means the package was signed by a key the keyring doesn't pin.
+	StatusUnknownKey VerificationStatus = "unknown-key"
+	// StatusRetiredKey means the package was signed by a pinned key past its retirement.
+	StatusRetiredKey VerificationStatus = "retired-key"
+	// StatusBadSignature means a signature of the package is corrupted or doesn't match its content.
+	StatusBadSignature VerificationStatus = "bad-signature"
+	// StatusUnreadable means the package isn't a readable RPM.
+	StatusUnreadable VerificationStatus = "unreadable"
+)
+
+// PackageVerification is the verification result of a package.
+type PackageVerification struct {
+	FileName string             `json:"FileName"`
+	Status   VerificationStatus `json:"Status"`
+	// KeyIDs are the IDs of the keys which signed the package.
+	KeyIDs []string `json:"KeyIDs,omitempty"`
+	// Fingerprints are the fingerprints of the pinned keys which signed the package.
+	Fingerprints []string `json:"Fingerprints,omitempty"`
+	Message      string   `json:"Message,omitempty"`
+}
+
+// VerificationReport lists the verification result of each package.
+type VerificationReport struct {
+	Keyring  string                 `json:"Keyring"`
+	Packages []*PackageVerification `json:"Packages"`
+}
+
+// Failures returns the packages which failed verification.
+func (r *VerificationReport) Failures() (failures []*PackageVerification) {
+	for _, verification := range r.Packages {
+		if verification.Status != StatusVerified {
+			failures = append(failures, verification)
+		}
+	}
+
+	return
+}
+
+// Err returns an error listing each package which failed verification, or nil if all packages were verified.
+func (r *VerificationReport) Err() error {
+	failures := r.Failures()
+	if len(failures) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		lines = append(lines, fmt.Sprintf("%s: %s (%s)", failure.FileName, failure.Status, failure.Message))
+	}
+
+	return fmt.Errorf("GPG verification against keyring (%s) failed for %d of %d packages:\n%s", r.Keyring, len(failures), len(r.Packages), strings.Join(lines, "\n"))
+}
+
+// LoadAndVerifyDirectory verifies every RPM under rpmDir against the keyring of keyringFile, saving the report to
+// reportFile if set. The returned error lists the packages which failed verification.
+func LoadAndVerifyDirectory(keyringFile, rpmDir, reportFile string) (err error) {
+	timestamp.StartEvent("verifying GPG signatures", nil)
+	defer timestamp.StopEvent(nil)
+
+	keyring, err := Load(keyringFile)
+	if err != nil {
+		return
+	}
+
+	report, err := keyring.VerifyDirectory(rpmDir)
+	if err != nil {
+		return
+	}
+
+	if reportFile != "" {
+		err = jsonutils.WriteJSONFile(reportFile, report)
+		if err != nil {
+			return fmt.Errorf("failed to save the GPG verification report:\n%w", err)
+		}
+	}
+
+	return report.Err()
+}
+
+// VerifyDirectory verifies every RPM under rpmDir against the keyring.
+func (k *Keyring) VerifyDirectory(rpmDir string) (report *VerificationReport, err error) {
+	var rpmFiles []string
+
+	err = filepath.WalkDir(rpmDir, func(path string, d os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".rpm" {
+			rpmFiles = append(rpmFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find RPM files in (%s):\n%w", rpmDir, err)
+	}
+
+	return k.VerifyFiles(rpmFiles)
+}
+
+// VerifyFiles verifies RPMs against the keyring. Each RPM must only be signed by active pinned keys, and its
+// signatures are then checked by rpmkeys against a database holding only those keys, regardless of the settings of
+// the repos the RPM came from. The returned error is only set if the verification couldn't run; the verification
+// failures are listed by the report.
+func (k *Keyring) VerifyFiles(rpmFiles []string) (report *VerificationReport, err error) {
+	now := time.Now()
+	report = &VerificationReport{Keyring: k.path}
+
+	activeKeys := k.ActiveKeys(now)
+	if len(activeKeys) == 0 {
+		return nil, fmt.Errorf("GPG keyring (%s) has no active keys", k.path)
+	}
+
+	logger.Log.Infof("Verifying the GPG signatures of %d packages against keyring (%s)", len(rpmFiles), k.path)
+
+	rpmDbRoot, err := os.MkdirTemp("", "rpm-gpg-keyring-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory for RPM database:\n%w", err)
+	}
+	defer os.RemoveAll(rpmDbRoot)
+
+	keyFiles := make([]string, 0, len(activeKeys))
+	for _, key := range activeKeys {
+		keyFiles = append(keyFiles, k.KeyFilePath(key))
+	}
+
+	err = rpm.ImportGPGKeysToRPMDb(rpmDbRoot, keyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	sortedFiles := append([]string(nil), rpmFiles...)
+	sort.Strings(sortedFiles)
+
+	for _, rpmFile := range sortedFiles {
+		verification := k.checkSigners(rpmFile, now)
+		if verification.Status == StatusVerified {
+			checkErr := rpm.CheckRPMSignature(rpmFile, rpmDbRoot)
+			if checkErr != nil {
+				verification.Status = StatusBadSignature
+				verification.Message = strings.TrimSpace(checkErr.Error())
+			}
+		}
+
+		logger.Log.Debugf("GPG verification of (%s): %s", verification.FileName, verification.Status)
+		report.Packages = append(report.Packages, verification)
+	}
+
+	if len(report.Failures()) == 0 {
+		logger.Log.Infof("All %d packages are signed by pinned GPG keys", len(report.Packages))
+	}
+
+	return
+}
+
+// checkSigners reads the signatures of an RPM and checks they were made by active pinned keys.
+func (k *Keyring) checkSigners(rpmFile string, now time.Time) (verification *PackageVerification) {
+	verification = &PackageVerification{FileName: filepath.Base(rpmFile)}
+
+	packageHeader, err := rpm.ReadPackageHeader(rpmFile)
+	if err != nil {
+		verification.Status = StatusUnreadable
+		verification.Message = err.Error()
+		return
+	}
+
+	k.classifySignatures(verification, packageHeader.Signatures, now)
+
+	return
+}
+
+// classifySignatures sets the status of a package from the issuers of its signatures.
+func (k *Keyring) classifySignatures(verification *PackageVerification, signatures [][]byte, now time.Time) {
+	if len(signatures) == 0 {
+		verification.Status = StatusUnsigned
+		verification.Message = "the package has no signature"
+		return
+	}
+
+	for _, signature := range signatures {
+		keyID, err := SignatureIssuer(signature)
+		if err != nil {
+			verification.Status = StatusBadSignature
+			verification.Message = fmt.Sprintf("failed to read a signature: %s", err)
+			return
+		}
+
+		if !sliceutils.ContainsValue(verification.KeyIDs, keyID) {
+			verification.KeyIDs = append(verification.KeyIDs, keyID)
+		}
+
+		key := k.FindByKeyID(keyID)
+		if key == nil {
+			verification.Status = StatusUnknownKey
+			verification.Message = fmt.Sprintf("signed by key ID (%s), which isn't pinned", keyID)
+			return
+		}
+
+		if !sliceutils.ContainsValue(verification.Fingerprints, key.Fingerprint) {
+			verification.Fingerprints = append(verification.Fingerprints, key.Fingerprint)
+		}
+
+		if key.IsRetired(now) {
+			verification.Status = StatusRetiredKey
+			verification.Message = fmt.Sprintf("signed by key (%s), retired on %s", key.Fingerprint, key.Retires.Format(time.RFC3339))
+			return
+		}
+	}
+
+	verification.Status = StatusVerified
+}
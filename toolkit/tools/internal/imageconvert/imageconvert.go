@@ -0,0 +1,177 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package imageconvert converts raw disk images into the disk image formats supported by the toolkit.
+package imageconvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	FormatRaw             = "raw"
+	FormatRawZst          = "raw-zst"
+	FormatQcow2           = "qcow2"
+	FormatQcow2Compressed = "qcow2-compressed"
+	FormatVhd             = "vhd"
+	FormatVhdFixed        = "vhd-fixed"
+	FormatVhdx            = "vhdx"
+
+	// qemu-specific formats
+	qemuFormatVpc = "vpc"
+
+	// ChecksumFileExtension is the extension appended to an artifact's path to get the path of its checksum file.
+	ChecksumFileExtension = ".sha256"
+
+	// Block size used for VHDX, to match the block-sizes used for qcow2 and VHD.
+	vhdxBlockSize = 2 * 1024 * 1024
+)
+
+// SupportedFormats returns the list of formats that Convert accepts.
+func SupportedFormats() []string {
+	return []string{FormatVhd, FormatVhdFixed, FormatVhdx, FormatQcow2, FormatQcow2Compressed, FormatRaw, FormatRawZst}
+}
+
+// IsValidFormat returns an error if the format is not supported by Convert.
+func IsValidFormat(format string) error {
+	switch format {
+	case FormatVhd, FormatVhdFixed, FormatVhdx, FormatQcow2, FormatQcow2Compressed, FormatRaw, FormatRawZst:
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported image format (supported: vhd, vhd-fixed, vhdx, qcow2, qcow2-compressed, raw, "+
+			"raw-zst): %s", format)
+	}
+}
+
+// Convert converts the raw disk image at inputPath into the requested format and writes it to outputPath.
+func Convert(inputPath string, outputPath string, format string) error {
+	err := IsValidFormat(format)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatRawZst:
+		err = convertToRawZst(inputPath, outputPath)
+
+	default:
+		err = convertWithQemuImg(inputPath, outputPath, format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to convert image file to format: %s:\n%w", format, err)
+	}
+
+	return nil
+}
+
+// ConvertAndChecksum converts the raw disk image and then writes a checksum file next to the output image.
+// Returns the sha256 digest of the output image.
+func ConvertAndChecksum(inputPath string, outputPath string, format string) (string, error) {
+	err := Convert(inputPath, outputPath, format)
+	if err != nil {
+		return "", err
+	}
+
+	checksum, err := WriteChecksumFile(outputPath)
+	if err != nil {
+		return "", err
+	}
+
+	return checksum, nil
+}
+
+// WriteChecksumFile calculates the sha256 digest of the artifact and writes it to a file next to the artifact,
+// using the same format as the sha256sum tool.
+// Returns the sha256 digest of the artifact.
+func WriteChecksumFile(artifactPath string) (string, error) {
+	checksum, err := file.GenerateSHA256(artifactPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate checksum of (%s):\n%w", artifactPath, err)
+	}
+
+	checksumFilePath := artifactPath + ChecksumFileExtension
+	checksumLine := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(artifactPath))
+
+	err = file.Write(checksumLine, checksumFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to write checksum file (%s):\n%w", checksumFilePath, err)
+	}
+
+	logger.Log.Infof("Checksum file created: %s", checksumFilePath)
+	return checksum, nil
+}
+
+func convertWithQemuImg(inputPath string, outputPath string, format string) error {
+	qemuImgArgs := qemuImgConvertArgs(inputPath, outputPath, format)
+
+	err := shell.ExecuteLiveWithErr(1, "qemu-img", qemuImgArgs...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func qemuImgConvertArgs(inputPath string, outputPath string, format string) []string {
+	qemuImageFormat, qemuOptions, compress := toQemuImageFormat(format)
+
+	qemuImgArgs := []string{"convert", "-O", qemuImageFormat}
+	if compress {
+		qemuImgArgs = append(qemuImgArgs, "-c")
+	}
+	if qemuOptions != "" {
+		qemuImgArgs = append(qemuImgArgs, "-o", qemuOptions)
+	}
+	qemuImgArgs = append(qemuImgArgs, inputPath, outputPath)
+
+	return qemuImgArgs
+}
+
+func toQemuImageFormat(format string) (qemuFormat string, qemuOptions string, compress bool) {
+	switch format {
+	case FormatVhd:
+		return qemuFormatVpc, "", false
+
+	case FormatVhdFixed:
+		return qemuFormatVpc, "subformat=fixed,force_size", false
+
+	case FormatVhdx:
+		// For VHDX, qemu-img dynamically picks the block-size based on the size of the disk.
+		// However, this can result in a significantly larger file size than other formats.
+		// So, use a fixed block-size to match the block-sizes used for qcow2 and VHD.
+		return FormatVhdx, fmt.Sprintf("block_size=%d", vhdxBlockSize), false
+
+	case FormatQcow2Compressed:
+		return FormatQcow2, "", true
+
+	default:
+		return format, "", false
+	}
+}
+
+func convertToRawZst(inputPath string, outputPath string) error {
+	// Write the compressed file to a temporary path first so that an interrupted compression never leaves a
+	// truncated file at the output path.
+	tempOutputPath := outputPath + ".tmp"
+
+	// Using -f to overwrite a file with same name if it exists.
+	err := shell.ExecuteLiveWithErr(1, "zstd", "-f", "-9", "-T0", inputPath, "-o", tempOutputPath)
+	if err != nil {
+		os.Remove(tempOutputPath)
+		return err
+	}
+
+	err = os.Rename(tempOutputPath, outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to move (%s) to (%s):\n%w", tempOutputPath, outputPath, err)
+	}
+
+	return nil
+}
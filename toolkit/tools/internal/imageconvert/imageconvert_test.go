@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imageconvert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestIsValidFormat(t *testing.T) {
+	for _, format := range SupportedFormats() {
+		assert.NoError(t, IsValidFormat(format))
+	}
+}
+
+func TestIsValidFormatInvalid(t *testing.T) {
+	err := IsValidFormat("iso")
+	assert.ErrorContains(t, err, "unsupported image format")
+}
+
+func TestQemuImgConvertArgsVhdFixed(t *testing.T) {
+	args := qemuImgConvertArgs("in.raw", "out.vhd", FormatVhdFixed)
+	assert.Equal(t, []string{"convert", "-O", "vpc", "-o", "subformat=fixed,force_size", "in.raw", "out.vhd"}, args)
+}
+
+func TestQemuImgConvertArgsVhdx(t *testing.T) {
+	args := qemuImgConvertArgs("in.raw", "out.vhdx", FormatVhdx)
+	assert.Equal(t, []string{"convert", "-O", "vhdx", "-o", "block_size=2097152", "in.raw", "out.vhdx"}, args)
+}
+
+func TestQemuImgConvertArgsQcow2Compressed(t *testing.T) {
+	args := qemuImgConvertArgs("in.raw", "out.qcow2", FormatQcow2Compressed)
+	assert.Equal(t, []string{"convert", "-O", "qcow2", "-c", "in.raw", "out.qcow2"}, args)
+}
+
+func TestWriteChecksumFile(t *testing.T) {
+	artifactPath := filepath.Join(t.TempDir(), "image.raw")
+	err := os.WriteFile(artifactPath, []byte("hello"), 0o644)
+	assert.NoError(t, err)
+
+	checksum, err := WriteChecksumFile(artifactPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", checksum)
+
+	checksumFileContents, err := os.ReadFile(artifactPath + ChecksumFileExtension)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum+"  image.raw\n", string(checksumFileContents))
+}
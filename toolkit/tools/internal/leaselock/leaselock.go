@@ -0,0 +1,498 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package leaselock implements exclusive locks that are safe to use on shared (e.g. NFS) directories and that recover
+// from crashed holders.
+//
+// A lock is a lock file that is created with O_EXCL and that holds a lease, which the holder periodically renews. The
+// lease records its owner (hostname, boot ID, PID namespace and PID). A lock is broken when its lease expires. A lock
+// whose owner is known to have crashed is broken straight away:
+//   - The owner ran on this host during an earlier boot.
+//   - The owner ran on this host, during this boot and in this PID namespace, and its process no longer exists.
+//
+// A holder that can't renew its lease in time loses the lock. Holders must watch Lock.Lost (or use Lock.Context) to
+// abort their work, and check Lock.Err before committing it.
+package leaselock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/randomization"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultLeaseDuration is how long a lock is valid for, without being renewed.
+	DefaultLeaseDuration = 2 * time.Minute
+
+	// DefaultPollInterval is how often a blocked Acquire call retries acquiring the lock.
+	DefaultPollInterval = 500 * time.Millisecond
+
+	bootIdPath       = "/proc/sys/kernel/random/boot_id"
+	pidNamespacePath = "/proc/self/ns/pid"
+
+	// The prefix of the temporary files that lease renewals are written to, before they are renamed into place.
+	renewTempPrefix = ".tmp-"
+)
+
+// Lease is the content of a lock file.
+type Lease struct {
+	// Token uniquely identifies the holder of the lock.
+	Token        string    `json:"token"`
+	Hostname     string    `json:"hostname"`
+	BootId       string    `json:"bootId,omitempty"`
+	PidNamespace string    `json:"pidNamespace,omitempty"`
+	Pid          int       `json:"pid"`
+	Expires      time.Time `json:"expires"`
+}
+
+func (l Lease) String() string {
+	return fmt.Sprintf("%s:%d", l.Hostname, l.Pid)
+}
+
+// ErrLockLost is returned by Lock.Err once the lock has been lost.
+var ErrLockLost = errors.New("lock lost")
+
+// Lock is a held lock, whose lease is renewed in the background until the lock is released.
+type Lock struct {
+	path          string
+	lease         Lease
+	leaseDuration time.Duration
+	stop          chan struct{}
+	stopped       sync.WaitGroup
+	// lost is closed once the lock is no longer held: either another holder broke it or its lease expired because
+	// it couldn't be renewed.
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+// Path returns the path of the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Lost returns a channel that is closed once the lock is lost. The holder must then stop modifying what the lock
+// protects, since someone else may now hold the lock.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns ErrLockLost if the lock has been lost. The holder should check it before committing any change that the
+// lock protects.
+func (l *Lock) Err() error {
+	select {
+	case <-l.lost:
+		return fmt.Errorf("%w (%s)", ErrLockLost, l.path)
+
+	default:
+		return nil
+	}
+}
+
+// Context returns a copy of the parent context that is cancelled once the lock is lost, so that the work done while
+// holding the lock can be aborted.
+func (l *Lock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	go func() {
+		select {
+		case <-l.lost:
+			cancel(l.Err())
+
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() {
+		close(l.lost)
+	})
+}
+
+// Acquire takes the lock, blocking until the lock is acquired or the context is cancelled.
+//
+// Since a lease's expiry is compared against the local clock, the clocks of the hosts that share the lock must be
+// synchronized.
+func Acquire(ctx context.Context, path string, leaseDuration time.Duration, pollInterval time.Duration,
+) (*Lock, error) {
+	for {
+		lock, holder, err := TryAcquire(path, leaseDuration)
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			return lock, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to acquire lock (%s) held by (%s):\n%w", path, holder, ctx.Err())
+
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// TryAcquire tries to take the lock. If the lock is held by someone else, then returns a nil lock and the holder's
+// lease.
+//
+// If the existing lock's lease has expired or its owner has crashed, then the lock is broken and taken.
+func TryAcquire(path string, leaseDuration time.Duration) (*Lock, *Lease, error) {
+	if leaseDuration <= 0 {
+		return nil, nil, fmt.Errorf("invalid lock lease duration (%s): must be positive", leaseDuration)
+	}
+
+	token, err := randomization.RandomString(32, randomization.LegalCharactersAlphaNum)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create lock directory:\n%w", err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	lock := &Lock{
+		path: path,
+		lease: Lease{
+			Token:        token,
+			Hostname:     hostname,
+			BootId:       getBootId(),
+			PidNamespace: getPidNamespace(),
+			Pid:          os.Getpid(),
+			Expires:      time.Now().Add(leaseDuration),
+		},
+		leaseDuration: leaseDuration,
+		stop:          make(chan struct{}),
+		lost:          make(chan struct{}),
+	}
+
+	created, err := lock.create()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !created {
+		holder, broken, err := breakStaleLock(path, leaseDuration)
+		if err != nil || !broken {
+			return nil, holder, err
+		}
+
+		lock.lease.Expires = time.Now().Add(leaseDuration)
+		created, err = lock.create()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !created {
+			// Another host broke and took the lock first.
+			holder, _ := ReadLease(path)
+			return nil, holder, nil
+		}
+	}
+
+	lock.stopped.Add(1)
+	go lock.renew()
+
+	return lock, nil, nil
+}
+
+// create creates the lock file. Returns false if the lock file already exists.
+func (l *Lock) create() (bool, error) {
+	leaseData, err := json.Marshal(l.lease)
+	if err != nil {
+		return false, err
+	}
+
+	// O_EXCL is atomic on NFSv3 and later.
+	lockFile, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create lock file (%s):\n%w", l.path, err)
+	}
+	defer lockFile.Close()
+
+	_, err = lockFile.Write(leaseData)
+	if err == nil {
+		err = lockFile.Sync()
+	}
+	if err != nil {
+		os.Remove(l.path)
+		return false, fmt.Errorf("failed to write lock file (%s):\n%w", l.path, err)
+	}
+
+	return true, nil
+}
+
+// renew periodically extends the lock's lease until the lock is released. If the lock is taken by someone else, or
+// the lease expires before it could be renewed, then the lock is marked as lost.
+func (l *Lock) renew() {
+	defer l.stopped.Done()
+
+	ticker := time.NewTicker(l.leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+
+		case <-ticker.C:
+			lease, err := ReadLease(l.path)
+			if err == nil && lease.Token != l.lease.Token || errors.Is(err, fs.ErrNotExist) {
+				logger.Log.Warnf("Lost lock (%s)", l.path)
+				l.markLost()
+				return
+			}
+
+			if err == nil {
+				renewed := l.lease
+				renewed.Expires = time.Now().Add(l.leaseDuration)
+				err = writeLease(l.path, renewed)
+				if err == nil {
+					l.lease = renewed
+				}
+			}
+
+			if err != nil {
+				if !time.Now().Before(l.lease.Expires) {
+					logger.Log.Warnf("Lost lock (%s): lease expired before it could be renewed:\n%v", l.path, err)
+					l.markLost()
+					return
+				}
+
+				logger.Log.Warnf("Failed to renew lock (%s):\n%v", l.path, err)
+			}
+		}
+	}
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	close(l.stop)
+	l.stopped.Wait()
+
+	lease, err := ReadLease(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to release lock (%s):\n%w", l.path, err)
+	}
+
+	if lease.Token != l.lease.Token {
+		l.markLost()
+		return fmt.Errorf("failed to release lock (%s): lock was broken by (%s)", l.path, lease)
+	}
+
+	err = os.Remove(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to release lock (%s):\n%w", l.path, err)
+	}
+
+	return nil
+}
+
+// breakStaleLock removes the lock file if its lease has expired or its owner has crashed. Returns the lock's lease (if
+// it could be read) and true if the lock file was removed.
+//
+// The lock file is first renamed to a unique name, so that only one host can break a given lease. If the renamed lock
+// isn't the stale one (i.e. another host broke and re-took the lock in the meantime), then it is put back.
+func breakStaleLock(path string, leaseDuration time.Duration) (*Lease, bool, error) {
+	lease, err := ReadLease(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The lock was just released.
+		return nil, true, nil
+	}
+
+	reason := ""
+	if err != nil {
+		// The holder may still be writing the lock file. But a lock file that stays unreadable for longer than a
+		// lease (e.g. an empty lock file left behind by an older version) is abandoned.
+		stat, statErr := os.Stat(path)
+		if statErr != nil || time.Since(stat.ModTime()) < leaseDuration {
+			logger.Log.Debugf("Failed to read lock file (%s): %v", path, err)
+			return nil, false, nil
+		}
+
+		reason = "unreadable"
+	} else {
+		reason = getStaleReason(*lease)
+		if reason == "" {
+			return lease, false, nil
+		}
+	}
+
+	suffix, err := randomization.RandomString(8, randomization.LegalCharactersAlphaNum)
+	if err != nil {
+		return lease, false, err
+	}
+
+	stalePath := path + ".stale-" + suffix
+	err = os.Rename(path, stalePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return lease, false, fmt.Errorf("failed to break stale lock (%s):\n%w", path, err)
+	}
+	defer os.Remove(stalePath)
+
+	staleLease, err := ReadLease(stalePath)
+	if err == nil && (lease == nil || staleLease.Token != lease.Token) {
+		// Put back the lock that was taken in the meantime. Link fails if yet another lock has since been created, in
+		// which case the lock that was taken in the meantime is lost and its holder finds out when it next renews it.
+		err = os.Link(stalePath, path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrExist) {
+				return staleLease, false, fmt.Errorf("failed to restore lock (%s) held by (%s):\n%w", path,
+					staleLease, err)
+			}
+
+			logger.Log.Warnf("Failed to restore lock (%s) held by (%s): another lock was created", path, staleLease)
+		}
+		return staleLease, false, nil
+	}
+
+	if lease != nil {
+		logger.Log.Warnf("Broke %s lock (%s) held by (%s)", reason, path, lease)
+	} else {
+		logger.Log.Warnf("Broke %s lock (%s)", reason, path)
+	}
+	return lease, true, nil
+}
+
+// getStaleReason returns why the lease is stale. Returns an empty string if the lease is still valid.
+func getStaleReason(lease Lease) string {
+	if !time.Now().Before(lease.Expires) {
+		return "expired"
+	}
+
+	hostname, _ := os.Hostname()
+	bootId := getBootId()
+	if hostname == "" || lease.Hostname != hostname || lease.BootId == "" || bootId == "" {
+		// The owner can't be checked.
+		return ""
+	}
+
+	if lease.BootId != bootId {
+		return "crashed"
+	}
+
+	// PIDs can only be checked within the same PID namespace (e.g. not across containers that share a hostname).
+	pidNamespace := getPidNamespace()
+	if lease.PidNamespace == "" || lease.PidNamespace != pidNamespace || lease.Pid <= 0 {
+		return ""
+	}
+
+	if !isProcessRunning(lease.Pid) {
+		return "crashed"
+	}
+
+	return ""
+}
+
+// ReadLease reads the lease of a lock file.
+func ReadLease(path string) (*Lease, error) {
+	leaseData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lease Lease
+	err = json.Unmarshal(leaseData, &lease)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock file (%s):\n%w", path, err)
+	}
+
+	return &lease, nil
+}
+
+// writeLease writes the lease to a temporary file next to the lock file and then renames it into place.
+func writeLease(path string, lease Lease) (err error) {
+	leaseData, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), renewTempPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file:\n%w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	_, err = tempFile.Write(leaseData)
+	if err != nil {
+		return fmt.Errorf("failed to write (%s):\n%w", tempFile.Name(), err)
+	}
+
+	err = tempFile.Chmod(0o644)
+	if err != nil {
+		return err
+	}
+
+	err = tempFile.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync (%s):\n%w", tempFile.Name(), err)
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close (%s):\n%w", tempFile.Name(), err)
+	}
+
+	err = os.Rename(tempFile.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to rename (%s) to (%s):\n%w", tempFile.Name(), path, err)
+	}
+
+	return nil
+}
+
+// IsTempFile returns true if the file name is a temporary file of a lease renewal.
+func IsTempFile(name string) bool {
+	return strings.HasPrefix(name, renewTempPrefix)
+}
+
+func getBootId() string {
+	bootId, err := os.ReadFile(bootIdPath)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(bootId))
+}
+
+func getPidNamespace() string {
+	pidNamespace, err := os.Readlink(pidNamespacePath)
+	if err != nil {
+		return ""
+	}
+
+	return pidNamespace
+}
+
+func isProcessRunning(pid int) bool {
+	err := unix.Kill(pid, 0)
+	// EPERM means that the process exists but is owned by another user.
+	return err == nil || errors.Is(err, unix.EPERM)
+}
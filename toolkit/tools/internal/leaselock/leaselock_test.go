@@ -0,0 +1,253 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package leaselock
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func writeTestLease(t *testing.T, path string, lease Lease) {
+	leaseData, err := json.Marshal(lease)
+	require.NoError(t, err)
+
+	err = os.WriteFile(path, leaseData, 0o644)
+	require.NoError(t, err)
+}
+
+// getExitedPid returns the PID of a process that has exited.
+func getExitedPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	err := cmd.Run()
+	require.NoError(t, err)
+
+	return cmd.Process.Pid
+}
+
+func TestAcquireIsExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	lock, err := Acquire(context.Background(), path, time.Minute, DefaultPollInterval)
+	require.NoError(t, err)
+
+	lease, err := ReadLease(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), lease.Pid)
+	assert.Equal(t, getBootId(), lease.BootId)
+
+	_, holder, err := TryAcquire(path, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, lease, holder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*DefaultPollInterval)
+	defer cancel()
+
+	_, err = Acquire(ctx, path, time.Minute, DefaultPollInterval)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = lock.Release()
+	assert.NoError(t, err)
+
+	assert.NoFileExists(t, path)
+}
+
+func TestBreaksExpiredLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	writeTestLease(t, path, Lease{
+		Token:    "crashed",
+		Hostname: "other-builder",
+		Pid:      1,
+		Expires:  time.Now().Add(-time.Second),
+	})
+
+	lock, _, err := TryAcquire(path, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+
+	err = lock.Release()
+	assert.NoError(t, err)
+}
+
+func TestKeepsUnexpiredLockOfOtherHost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	writeTestLease(t, path, Lease{
+		Token:    "other",
+		Hostname: "other-builder",
+		BootId:   "other-boot",
+		Pid:      getExitedPid(t),
+		Expires:  time.Now().Add(time.Hour),
+	})
+
+	lock, holder, err := TryAcquire(path, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, lock)
+	assert.Equal(t, "other", holder.Token)
+}
+
+func TestBreaksLockOfCrashedProcess(t *testing.T) {
+	if getBootId() == "" || getPidNamespace() == "" {
+		t.Skip("boot ID or PID namespace isn't available")
+	}
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "cache.lock")
+	lease := Lease{
+		Token:        "crashed",
+		Hostname:     hostname,
+		BootId:       getBootId(),
+		PidNamespace: getPidNamespace(),
+		Pid:          getExitedPid(t),
+		Expires:      time.Now().Add(time.Hour),
+	}
+
+	writeTestLease(t, path, lease)
+
+	lock, _, err := TryAcquire(path, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+
+	err = lock.Release()
+	assert.NoError(t, err)
+
+	// A lock that was held during an earlier boot is also broken.
+	lease.BootId = "earlier-boot"
+	lease.Pid = os.Getpid()
+	writeTestLease(t, path, lease)
+
+	lock, _, err = TryAcquire(path, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+
+	err = lock.Release()
+	assert.NoError(t, err)
+}
+
+func TestKeepsLockOfRunningProcess(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "cache.lock")
+	writeTestLease(t, path, Lease{
+		Token:        "running",
+		Hostname:     hostname,
+		BootId:       getBootId(),
+		PidNamespace: getPidNamespace(),
+		Pid:          os.Getpid(),
+		Expires:      time.Now().Add(time.Hour),
+	})
+
+	lock, _, err := TryAcquire(path, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, lock)
+}
+
+func TestBreaksAbandonedUnreadableLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	err := os.WriteFile(path, nil, 0o644)
+	require.NoError(t, err)
+
+	// An unreadable lock file may still be being written.
+	lock, _, err := TryAcquire(path, time.Minute)
+	assert.NoError(t, err)
+	assert.Nil(t, lock)
+
+	oldTime := time.Now().Add(-2 * time.Minute)
+	err = os.Chtimes(path, oldTime, oldTime)
+	require.NoError(t, err)
+
+	lock, _, err = TryAcquire(path, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+
+	err = lock.Release()
+	assert.NoError(t, err)
+}
+
+func TestReleaseBrokenLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	lock, _, err := TryAcquire(path, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+
+	writeTestLease(t, path, Lease{
+		Token:    "other",
+		Hostname: "other-builder",
+		Pid:      1,
+		Expires:  time.Now().Add(time.Hour),
+	})
+
+	err = lock.Release()
+	assert.ErrorContains(t, err, "lock was broken by (other-builder:1)")
+}
+
+func TestLockIsLostWhenTakenByOther(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	lock, _, err := TryAcquire(path, 300*time.Millisecond)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+	assert.NoError(t, lock.Err())
+
+	ctx, cancel := lock.Context(context.Background())
+	defer cancel()
+
+	writeTestLease(t, path, Lease{
+		Token:    "other",
+		Hostname: "other-builder",
+		Pid:      1,
+		Expires:  time.Now().Add(time.Hour),
+	})
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "lock wasn't marked as lost")
+	}
+
+	assert.ErrorIs(t, lock.Err(), ErrLockLost)
+
+	<-ctx.Done()
+	assert.ErrorIs(t, context.Cause(ctx), ErrLockLost)
+
+	err = lock.Release()
+	assert.ErrorContains(t, err, "lock was broken by (other-builder:1)")
+}
+
+func TestLockContextIsCancelledByCaller(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.lock")
+
+	lock, _, err := TryAcquire(path, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, lock)
+
+	ctx, cancel := lock.Context(context.Background())
+	cancel()
+
+	<-ctx.Done()
+	assert.ErrorIs(t, context.Cause(ctx), context.Canceled)
+
+	err = lock.Release()
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Err())
+}
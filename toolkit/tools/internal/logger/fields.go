@@ -0,0 +1,191 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ComponentField is the field that names the component (i.e. subsystem) that logged an entry. If it isn't set, then
+	// the name of the Go package that logged the entry is used.
+	ComponentField = "component"
+
+	// ImageField is the field that names the image that is being built.
+	ImageField = "image"
+
+	// PackageField is the field that names the package that is being built.
+	PackageField = "package"
+
+	// ChrootField is the field that identifies the chroot that is active.
+	ChrootField = "chroot"
+
+	// ToolField is the field that names the tool that logged an entry.
+	ToolField = "tool"
+)
+
+var (
+	// componentLevels are the per-component overrides of the hooks' log levels.
+	componentLevels     map[string]logrus.Level
+	componentLevelsLock sync.RWMutex
+
+	// contextFields are added to every entry that is written in the JSON format.
+	contextFields     = logrus.Fields{}
+	contextFieldsLock sync.RWMutex
+
+	componentNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// Component returns a log entry that is tagged with a component, so that the component's log level can be set
+// independently (e.g. '--log-level=info,imagecustomizer=debug').
+func Component(name string) *logrus.Entry {
+	return Log.WithField(ComponentField, name)
+}
+
+// SetContextField sets a field that is added to every log entry that is written in the JSON format, so that the entries
+// can be correlated (e.g. by image or package).
+func SetContextField(key string, value interface{}) {
+	contextFieldsLock.Lock()
+	defer contextFieldsLock.Unlock()
+
+	contextFields[key] = value
+}
+
+// ClearContextField removes a field that was set by SetContextField.
+func ClearContextField(key string) {
+	contextFieldsLock.Lock()
+	defer contextFieldsLock.Unlock()
+
+	delete(contextFields, key)
+}
+
+// ParseLevels parses a log level specification, which is a comma-separated list of a default level and per-component
+// levels (e.g. 'info,safechroot=debug').
+//
+// Returns the default level (empty if not specified) and the per-component levels.
+func ParseLevels(spec string) (defaultLevel string, levels map[string]logrus.Level, err error) {
+	levels = make(map[string]logrus.Level)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		component, levelName, hasComponent := strings.Cut(item, "=")
+		if !hasComponent {
+			levelName = component
+		}
+
+		if !slices.Contains(levelsArray, levelName) {
+			return "", nil, fmt.Errorf("invalid log level (%s):\nvalid levels: %s", levelName, LevelsPlaceholder)
+		}
+
+		if !hasComponent {
+			if defaultLevel != "" {
+				return "", nil, fmt.Errorf("log level specified more than once (%s)", spec)
+			}
+			defaultLevel = levelName
+			continue
+		}
+
+		if !componentNameRegex.MatchString(component) {
+			return "", nil, fmt.Errorf("invalid log component name (%s)", component)
+		}
+
+		if _, found := levels[component]; found {
+			return "", nil, fmt.Errorf("log level of component (%s) specified more than once", component)
+		}
+
+		levels[component], err = logrus.ParseLevel(levelName)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return defaultLevel, levels, nil
+}
+
+// setComponentLogLevels replaces the per-component log levels.
+func setComponentLogLevels(levels map[string]logrus.Level) {
+	componentLevelsLock.Lock()
+	defer componentLevelsLock.Unlock()
+
+	componentLevels = levels
+
+	// The base logger must let through the entries of the most verbose component.
+	for _, level := range levels {
+		if level > Log.GetLevel() {
+			Log.SetLevel(level)
+		}
+	}
+}
+
+// componentLogLevel returns the log level of an entry's component, if it was set.
+func componentLogLevel(entry *logrus.Entry) (logrus.Level, bool) {
+	componentLevelsLock.RLock()
+	defer componentLevelsLock.RUnlock()
+
+	if len(componentLevels) == 0 {
+		return 0, false
+	}
+
+	level, found := componentLevels[entryComponent(entry)]
+	return level, found
+}
+
+// entryComponent returns the component of an entry: either its component field or the name of the Go package that
+// logged it.
+func entryComponent(entry *logrus.Entry) string {
+	if component, ok := entry.Data[ComponentField].(string); ok {
+		return component
+	}
+
+	if entry.HasCaller() {
+		return functionPackageName(entry.Caller.Function)
+	}
+
+	return ""
+}
+
+// functionPackageName returns the package name of a fully-qualified function name (e.g.
+// 'github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot.(*Chroot).Run' -> 'safechroot').
+func functionPackageName(function string) string {
+	name := function[strings.LastIndex(function, "/")+1:]
+	name, _, _ = strings.Cut(name, ".")
+	return name
+}
+
+// withContextFields returns a copy of an entry that includes the context fields, the tool name and the component.
+func withContextFields(entry *logrus.Entry, toolName string) *logrus.Entry {
+	contextFieldsLock.RLock()
+	data := make(logrus.Fields, len(contextFields)+len(entry.Data)+2)
+	for key, value := range contextFields {
+		data[key] = value
+	}
+	contextFieldsLock.RUnlock()
+
+	if toolName != "" {
+		data[ToolField] = toolName
+	}
+
+	component := entryComponent(entry)
+	if component != "" {
+		data[ComponentField] = component
+	}
+
+	for key, value := range entry.Data {
+		data[key] = value
+	}
+
+	newEntry := *entry
+	newEntry.Data = data
+	return &newEntry
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initJsonTestLog(t *testing.T) *bytes.Buffer {
+	initStderrLogInternal("/tools/testtool/testtool.go", colorModeNever, formatJson)
+
+	buffer := &bytes.Buffer{}
+	ReplaceStderrWriter(buffer)
+
+	t.Cleanup(func() {
+		setComponentLogLevels(nil)
+		ClearContextField(ImageField)
+		InitStderrLog()
+	})
+
+	return buffer
+}
+
+func readJsonTestLog(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	entries := []map[string]interface{}(nil)
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		entry := map[string]interface{}{}
+		err := json.Unmarshal([]byte(line), &entry)
+		require.NoError(t, err, line)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestParseLevels(t *testing.T) {
+	defaultLevel, levels, err := ParseLevels("info, safechroot=debug,shell=trace")
+	assert.NoError(t, err)
+	assert.Equal(t, "info", defaultLevel)
+	assert.Equal(t, map[string]logrus.Level{"safechroot": logrus.DebugLevel, "shell": logrus.TraceLevel}, levels)
+
+	defaultLevel, levels, err = ParseLevels("safechroot=warn")
+	assert.NoError(t, err)
+	assert.Equal(t, "", defaultLevel)
+	assert.Equal(t, map[string]logrus.Level{"safechroot": logrus.WarnLevel}, levels)
+
+	_, _, err = ParseLevels("verbose")
+	assert.ErrorContains(t, err, "invalid log level (verbose)")
+
+	_, _, err = ParseLevels("info,debug")
+	assert.ErrorContains(t, err, "log level specified more than once")
+
+	_, _, err = ParseLevels("safechroot=debug,safechroot=info")
+	assert.ErrorContains(t, err, "log level of component (safechroot) specified more than once")
+
+	_, _, err = ParseLevels("safe chroot=debug")
+	assert.ErrorContains(t, err, "invalid log component name (safe chroot)")
+}
+
+func TestFunctionPackageName(t *testing.T) {
+	assert.Equal(t, "safechroot",
+		functionPackageName("github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot.(*Chroot).Run"))
+	assert.Equal(t, "main", functionPackageName("main.main"))
+}
+
+func TestJsonFormatContextFields(t *testing.T) {
+	buffer := initJsonTestLog(t)
+
+	SetContextField(ImageField, "core.vhdx")
+	Log.WithField(PackageField, "vim").Infof("building")
+	Component("custom").Warnf("warning")
+
+	entries := readJsonTestLog(t, buffer)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "building", entries[0]["msg"])
+	assert.Equal(t, "info", entries[0]["level"])
+	assert.Equal(t, "core.vhdx", entries[0][ImageField])
+	assert.Equal(t, "vim", entries[0][PackageField])
+	assert.Equal(t, "testtool", entries[0][ToolField])
+	assert.Equal(t, "logger", entries[0][ComponentField])
+	assert.Contains(t, entries[0], "time")
+
+	assert.Equal(t, "custom", entries[1][ComponentField])
+}
+
+func TestComponentLogLevels(t *testing.T) {
+	buffer := initJsonTestLog(t)
+
+	err := SetStderrLogLevel("warn,custom=debug")
+	require.NoError(t, err)
+
+	Log.Infof("filtered")
+	Component("custom").Debugf("included")
+	Component("other").Debugf("filtered")
+	Log.Warnf("warning")
+
+	entries := readJsonTestLog(t, buffer)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "included", entries[0]["msg"])
+	assert.Equal(t, "warning", entries[1]["msg"])
+}
@@ -30,6 +30,9 @@ var (
 
 	// Valid log colors
 	colorsArray = []string{"always", "auto", "never"}
+
+	// Valid log formats
+	formatsArray = []string{formatText, formatJson}
 )
 
 const (
@@ -40,7 +43,8 @@ const (
 	LevelsFlag = "log-level"
 
 	// LevelsHelp is the suggested help message for the loglevel flag
-	LevelsHelp = "The minimum log level."
+	LevelsHelp = "The minimum log level. Can be followed by comma-separated per-component levels, where a component " +
+		"is a Go package name (e.g. 'info,safechroot=debug')."
 
 	// FileFlag is the suggested name for logfile flag
 	FileFlag = "log-file"
@@ -57,22 +61,39 @@ const (
 	// ColorFlagHelp is the suggested help message for the logcolor flag
 	ColorFlagHelp = "Color setting for log terminal output."
 
+	// FormatsPlaceholder are all valid log formats separated by '|' character.
+	FormatsPlaceholder = "(text|json)"
+
+	// FormatFlag is the suggested name for logformat flag
+	FormatFlag = "log-format"
+
+	// FormatFlagHelp is the suggested help message for the logformat flag
+	FormatFlagHelp = "Format of the log output. The json format writes one JSON object per line, including contextual " +
+		"fields (e.g. component, image, package, chroot)."
+
+	// AppendLogFileEnvVar is set for a tool that is re-executed by itself (e.g. within a user namespace), so that it
+	// appends to the log file opened by the original process instead of truncating it.
+	AppendLogFileEnvVar = "AZL_TOOLKIT_APPEND_LOG_FILE"
+
 	defaultLogFileLevel   = logrus.DebugLevel
 	defaultStderrLogLevel = logrus.InfoLevel
 	parentCallerLevel     = 1
 	colorModeAuto         = "auto"
 	colorModeAlways       = "always"
 	colorModeNever        = "never"
+	formatText            = "text"
+	formatJson            = "json"
 )
 
 type LogFlags struct {
-	LogColor *string
-	LogFile  *string
-	LogLevel *string
+	LogColor  *string
+	LogFile   *string
+	LogLevel  *string
+	LogFormat *string
 }
 
 // initLogFile initializes the common logger with a file
-func initLogFile(filePath string, color string) (err error) {
+func initLogFile(filePath string, color string, format string) (err error) {
 	useColors := false
 	if color == colorModeAlways {
 		useColors = true
@@ -86,12 +107,19 @@ func initLogFile(filePath string, color string) (err error) {
 		return
 	}
 
-	file, err := os.Create(filePath)
+	// The file is always written in append mode, so that the writes of a re-executed tool and of the original
+	// process don't overwrite each other.
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if os.Getenv(AppendLogFileEnvVar) == "" {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(filePath, flags, 0o666)
 	if err != nil {
 		return
 	}
 
-	fileHook = newWriterHook(file, defaultLogFileLevel, useColors, noToolName)
+	fileHook = newWriterHook(file, defaultLogFileLevel, useColors, noToolName, format)
 	Log.Hooks.Add(fileHook)
 	Log.SetLevel(defaultLogFileLevel)
 
@@ -105,7 +133,7 @@ func InitStderrLog() {
 		log.Panic("Failed to get caller info.")
 	}
 
-	initStderrLogInternal(callerFilePath, colorModeAuto)
+	initStderrLogInternal(callerFilePath, colorModeAuto, formatText)
 }
 
 // SetFileLogLevel sets the lowest log level for file output
@@ -113,9 +141,23 @@ func SetFileLogLevel(level string) (err error) {
 	return setHookLogLevel(fileHook, level)
 }
 
-// SetStderrLogLevel sets the lowest log level for stderr output
+// SetStderrLogLevel sets the lowest log level for stderr output. The level may be followed by per-component levels
+// (e.g. 'info,safechroot=debug'), which apply to both stderr and file output.
 func SetStderrLogLevel(level string) (err error) {
-	return setHookLogLevel(stderrHook, level)
+	defaultLevel, levels, err := ParseLevels(level)
+	if err != nil {
+		return
+	}
+
+	if defaultLevel != "" {
+		err = setHookLogLevel(stderrHook, defaultLevel)
+		if err != nil {
+			return
+		}
+	}
+
+	setComponentLogLevels(levels)
+	return
 }
 
 // InitBestEffort runs InitStderrLog always, and InitLogFile if path is not empty
@@ -124,6 +166,11 @@ func InitBestEffort(lf *LogFlags) {
 	color := *lf.LogColor
 	path := *lf.LogFile
 
+	format := formatText
+	if lf.LogFormat != nil && *lf.LogFormat != "" {
+		format = *lf.LogFormat
+	}
+
 	if level == "" {
 		level = defaultStderrLogLevel.String()
 	}
@@ -133,10 +180,10 @@ func InitBestEffort(lf *LogFlags) {
 		log.Panic("Failed to get caller info.")
 	}
 
-	initStderrLogInternal(callerFilePath, color)
+	initStderrLogInternal(callerFilePath, color, format)
 
 	if path != "" {
-		PanicOnError(initLogFile(path, color), "Failed while setting log file (%s).", path)
+		PanicOnError(initLogFile(path, color, format), "Failed while setting log file (%s).", path)
 	}
 
 	PanicOnError(SetStderrLogLevel(level), "Failed while setting log level.")
@@ -152,6 +199,11 @@ func Colors() []string {
 	return colorsArray
 }
 
+// Formats returns list of strings representing valid log formats.
+func Formats() []string {
+	return formatsArray
+}
+
 // PanicOnError logs the error and any message strings and then panics
 func PanicOnError(err interface{}, args ...interface{}) {
 	if err != nil {
@@ -193,7 +245,7 @@ func ReplaceStderrFormatter(newFormatter logrus.Formatter) (oldFormatter logrus.
 	return stderrHook.ReplaceFormatter(newFormatter)
 }
 
-func initStderrLogInternal(callerFilePath string, color string) {
+func initStderrLogInternal(callerFilePath string, color string, format string) {
 	useColors := true
 	if color == colorModeNever {
 		useColors = false
@@ -205,7 +257,7 @@ func initStderrLogInternal(callerFilePath string, color string) {
 	toolName := strings.TrimSuffix(filepath.Base(callerFilePath), ".go")
 
 	// By default send all log messages through stderrHook
-	stderrHook = newWriterHook(os.Stderr, defaultStderrLogLevel, useColors, toolName)
+	stderrHook = newWriterHook(os.Stderr, defaultStderrLogLevel, useColors, toolName, format)
 	Log.AddHook(stderrHook)
 	Log.SetLevel(defaultStderrLogLevel)
 	Log.SetOutput(io.Discard)
@@ -6,9 +6,12 @@
 package logger
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPadString(t *testing.T) {
@@ -131,3 +134,27 @@ func TestFormatWarningBox(t *testing.T) {
 		})
 	}
 }
+
+func TestInitLogFileAppend(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "tool.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("original process\n"), 0o644))
+
+	initStderrLogInternal("tool.go", colorModeNever, formatText)
+	defer InitStderrLog()
+
+	t.Setenv(AppendLogFileEnvVar, "1")
+	require.NoError(t, initLogFile(logFile, colorModeNever, formatText))
+	Log.Info("re-executed process")
+
+	contents, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(contents), "original process\n")
+	assert.Contains(t, string(contents), "re-executed process")
+
+	t.Setenv(AppendLogFileEnvVar, "")
+	require.NoError(t, initLogFile(logFile, colorModeNever, formatText))
+
+	contents, err = os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Empty(t, string(contents))
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package logger
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WarningCollector records the messages of the warnings that are logged while it is collecting.
+type WarningCollector struct {
+	lock     sync.Mutex
+	warnings []string
+}
+
+// StartCollectingWarnings starts recording the messages of the warnings that are logged to the shared logger.
+func StartCollectingWarnings() *WarningCollector {
+	collector := &WarningCollector{}
+	Log.AddHook(collector)
+	return collector
+}
+
+// Stop stops recording warnings and returns the messages of the warnings that were logged, in order.
+func (c *WarningCollector) Stop() []string {
+	hooks := make(logrus.LevelHooks)
+	for level, levelHooks := range Log.Hooks {
+		for _, hook := range levelHooks {
+			if hook != c {
+				hooks[level] = append(hooks[level], hook)
+			}
+		}
+	}
+	Log.ReplaceHooks(hooks)
+
+	return c.Warnings()
+}
+
+// Warnings returns the messages of the warnings that have been logged so far.
+func (c *WarningCollector) Warnings() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string(nil), c.warnings...)
+}
+
+// Levels returns the levels the collector fires on.
+func (c *WarningCollector) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel}
+}
+
+// Fire records the entry's message.
+func (c *WarningCollector) Fire(entry *logrus.Entry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.warnings = append(c.warnings, entry.Message)
+	return nil
+}
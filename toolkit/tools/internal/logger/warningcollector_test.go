@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarningCollector(t *testing.T) {
+	InitStderrLog()
+
+	Log.Warnf("before")
+
+	collector := StartCollectingWarnings()
+	Log.Infof("info")
+	Log.Warnf("first (%d)", 1)
+	Log.Errorf("error")
+	Log.Warnf("second")
+
+	assert.Equal(t, []string{"first (1)"}, collector.Warnings()[:1])
+
+	warnings := collector.Stop()
+	Log.Warnf("after")
+
+	assert.Equal(t, []string{"first (1)", "second"}, warnings)
+	assert.Equal(t, []string{"first (1)", "second"}, collector.Warnings())
+	for _, levelHooks := range Log.Hooks {
+		assert.NotContains(t, levelHooks, collector)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
@@ -21,6 +22,9 @@ type writerHook struct {
 	writer    io.Writer
 	formatter logrus.Formatter
 	useColors bool
+	// jsonFormat adds the context fields, tool name and component to the entries before they are formatted.
+	jsonFormat bool
+	toolName   string
 }
 
 var (
@@ -30,7 +34,22 @@ var (
 )
 
 // newWriterHook returns new writerHook
-func newWriterHook(writer io.Writer, level logrus.Level, useColors bool, toolName string) *writerHook {
+func newWriterHook(writer io.Writer, level logrus.Level, useColors bool, toolName string, format string) *writerHook {
+	if format == formatJson {
+		return &writerHook{
+			level:  level,
+			writer: writer,
+			formatter: &logrus.JSONFormatter{
+				TimestampFormat: time.RFC3339Nano,
+				CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
+					return
+				},
+			},
+			jsonFormat: true,
+			toolName:   toolName,
+		}
+	}
+
 	formatter := &logrus.TextFormatter{
 		ForceColors: useColors,
 		CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
@@ -60,7 +79,12 @@ func newWriterHook(writer io.Writer, level logrus.Level, useColors bool, toolNam
 // Fire writes the log entry to the writer
 func (h *writerHook) Fire(entry *logrus.Entry) (err error) {
 	// Filter out entries that are at a higher level (more verbose) than the current filter
-	if entry.Level > h.level {
+	level := h.level
+	if componentLevel, found := componentLogLevel(entry); found {
+		level = componentLevel
+	}
+
+	if entry.Level > level {
 		return
 	}
 
@@ -68,6 +92,10 @@ func (h *writerHook) Fire(entry *logrus.Entry) (err error) {
 		entry.Message = colorCodeRegex.ReplaceAllString(entry.Message, "")
 	}
 
+	if h.jsonFormat {
+		entry = withContextFields(entry, h.toolName)
+	}
+
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package netisolation runs package builds without network access, so that specs which download files at build time
+// fail, instead of silently producing packages that can't be rebuilt from their sources.
+//
+// The build runs in a new network namespace, which only has a loopback interface and no default route. Builds may
+// still reach an allowlist of hosts through an HTTP proxy, which listens on the namespace's loopback interface and
+// connects to the allowed hosts through the host's network. The proxy also records every request it denies, so
+// downloads are reported by their URL instead of as a build failure somewhere in the build's log.
+package netisolation
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"golang.org/x/sys/unix"
+)
+
+// Policy is the network access of package builds.
+type Policy string
+
+const (
+	// PolicyHost builds packages with the network of the host.
+	PolicyHost Policy = "host"
+	// PolicyIsolated builds packages in a network namespace without a default route, which may only reach the hosts
+	// on the allowlist, through a proxy.
+	PolicyIsolated Policy = "isolated"
+)
+
+const (
+	loopbackInterface = "lo"
+	// proxyListenAddress is the address the proxy listens on, inside the network namespace.
+	proxyListenAddress = "127.0.0.1:0"
+)
+
+// ValidPolicies returns the supported network policies.
+func ValidPolicies() []string {
+	return []string{string(PolicyHost), string(PolicyIsolated)}
+}
+
+// Sandbox runs commands in an isolated network namespace.
+type Sandbox struct {
+	// Allowlist are the hosts the commands may connect to through the proxy. An entry starting with "." (or "*.")
+	// also allows all of the host's subdomains.
+	Allowlist []string
+}
+
+// New creates a network sandbox which only allows the given hosts.
+func New(allowlist []string) *Sandbox {
+	return &Sandbox{Allowlist: allowlist}
+}
+
+// Run calls toRun on an OS thread which is in a new network namespace. The processes that toRun starts inherit the
+// namespace. toRun is passed the environment variables which point the processes at the proxy.
+//
+// Returns the requests the proxy denied, and the error of toRun.
+func (s *Sandbox) Run(toRun func(proxyEnv map[string]string) error) (denied []string, err error) {
+	type sandboxResult struct {
+		denied []string
+		err    error
+	}
+
+	results := make(chan sandboxResult)
+	go func() {
+		// The thread is never unlocked, so it's destroyed when the goroutine exits instead of going back to the
+		// scheduler while still in the namespace.
+		runtime.LockOSThread()
+
+		proxy, setupErr := enterNamespace(s.Allowlist)
+		if setupErr != nil {
+			results <- sandboxResult{err: setupErr}
+			return
+		}
+		defer proxy.Close()
+
+		runErr := toRun(proxy.Environment())
+		results <- sandboxResult{denied: proxy.Denied(), err: runErr}
+	}()
+
+	result := <-results
+	return result.denied, result.err
+}
+
+// enterNamespace moves the current thread into a new network namespace and starts the proxy in it. The thread must be
+// locked.
+func enterNamespace(allowlist []string) (proxy *Proxy, err error) {
+	err = unix.Unshare(unix.CLONE_NEWNET)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a network namespace:\n%w", err)
+	}
+
+	// Builds and their tests may use local sockets, which need the loopback interface.
+	err = setLinkUp(loopbackInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sockets belong to the namespace of the thread that created them, so the proxy's listener is only reachable from
+	// inside the namespace, while the connections it makes from other threads go through the host's network.
+	listener, err := net.Listen("tcp", proxyListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the network proxy:\n%w", err)
+	}
+
+	proxy = NewProxy(listener, allowlist)
+	logger.Log.Debugf("Network proxy listening on (%s), allowed hosts: %v", listener.Addr(), allowlist)
+
+	return
+}
+
+// setLinkUp brings up a network interface of the current thread's network namespace.
+func setLinkUp(name string) (err error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open a socket to configure (%s):\n%w", name, err)
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq(name)
+	if err != nil {
+		return fmt.Errorf("invalid interface name (%s):\n%w", name, err)
+	}
+
+	err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq)
+	if err != nil {
+		return fmt.Errorf("failed to get the flags of (%s):\n%w", name, err)
+	}
+
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	err = unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+	if err != nil {
+		return fmt.Errorf("failed to bring up (%s):\n%w", name, err)
+	}
+
+	return
+}
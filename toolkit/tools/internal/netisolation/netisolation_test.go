@@ -0,0 +1,190 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package netisolation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func newTestProxy(t *testing.T, allowlist []string) *Proxy {
+	listener, err := net.Listen("tcp", proxyListenAddress)
+	require.NoError(t, err)
+
+	proxy := NewProxy(listener, allowlist)
+	t.Cleanup(func() { proxy.Close() })
+
+	return proxy
+}
+
+func proxyClient(t *testing.T, proxy *Proxy, transport *http.Transport) *http.Client {
+	proxyURL, err := url.Parse(proxy.URL())
+	require.NoError(t, err)
+
+	if transport == nil {
+		transport = &http.Transport{}
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}
+
+func TestIsAllowed(t *testing.T) {
+	proxy := &Proxy{allowlist: []string{"github.com", ".pypi.org", "*.crates.io"}}
+
+	assert.True(t, proxy.IsAllowed("github.com"))
+	assert.True(t, proxy.IsAllowed("GitHub.com."))
+	assert.False(t, proxy.IsAllowed("api.github.com"))
+	assert.True(t, proxy.IsAllowed("pypi.org"))
+	assert.True(t, proxy.IsAllowed("files.pypi.org"))
+	assert.False(t, proxy.IsAllowed("notpypi.org"))
+	assert.True(t, proxy.IsAllowed("static.crates.io"))
+	assert.False(t, proxy.IsAllowed("example.com"))
+}
+
+func TestProxyForwardsAllowedRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "source tarball")
+	}))
+	defer server.Close()
+
+	proxy := newTestProxy(t, []string{"127.0.0.1"})
+	response, err := proxyClient(t, proxy, nil).Get(server.URL + "/source.tar.gz")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "source tarball", string(body))
+	assert.Empty(t, proxy.Denied())
+}
+
+func TestProxyTunnelsAllowedRequests(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "crate")
+	}))
+	defer server.Close()
+
+	proxy := newTestProxy(t, []string{"127.0.0.1"})
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	response, err := proxyClient(t, proxy, transport).Get(server.URL + "/crate")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "crate", string(body))
+}
+
+func TestProxyDeniesOtherHosts(t *testing.T) {
+	proxy := newTestProxy(t, []string{"example.com"})
+	client := proxyClient(t, proxy, nil)
+
+	response, err := client.Get("http://downloads.example.org/source.tar.gz")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	_, err = client.Get("https://github.com/org/repo/archive/v1.0.tar.gz")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"CONNECT github.com:443", "GET http://downloads.example.org/source.tar.gz"}, proxy.Denied())
+}
+
+// getThroughProxy sends a request through the proxy from the current thread. http.Client can't be used inside the
+// sandbox, since it connects from other threads.
+func getThroughProxy(proxyAddress, requestURL string) (response *http.Response, err error) {
+	conn, err := net.DialTimeout("tcp", proxyAddress, time.Second)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return
+	}
+
+	err = request.WriteProxy(conn)
+	if err != nil {
+		return
+	}
+
+	response, err = http.ReadResponse(bufio.NewReader(conn), request)
+	if err != nil {
+		return
+	}
+
+	// Read the body before the connection is closed.
+	_, err = io.ReadAll(response.Body)
+	return
+}
+
+func TestSandboxHasNoNetwork(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Test must be run as root to create a network namespace")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "allowed")
+	}))
+	defer server.Close()
+
+	sandbox := New([]string{"127.0.0.1"})
+	// toRun runs on another goroutine, so it must not stop the test with require.
+	denied, err := sandbox.Run(func(proxyEnv map[string]string) error {
+		// The host's loopback interface isn't reachable from the namespace.
+		_, dialErr := net.DialTimeout("tcp", server.Listener.Addr().String(), time.Second)
+		assert.Error(t, dialErr)
+
+		// But the namespace has its own loopback interface.
+		listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+		if listenErr != nil {
+			return listenErr
+		}
+		listener.Close()
+
+		proxyURL, parseErr := url.Parse(proxyEnv["http_proxy"])
+		if parseErr != nil {
+			return parseErr
+		}
+
+		for requestURL, expectedStatus := range map[string]int{
+			server.URL:                         http.StatusOK,
+			"http://example.com/source.tar.gz": http.StatusForbidden,
+		} {
+			response, getErr := getThroughProxy(proxyURL.Host, requestURL)
+			if getErr != nil {
+				return getErr
+			}
+			assert.Equal(t, expectedStatus, response.StatusCode, requestURL)
+		}
+
+		return nil
+	})
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("Network namespaces are not supported: %s", err)
+	}
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET http://example.com/source.tar.gz"}, denied)
+}
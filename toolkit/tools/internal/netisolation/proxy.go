@@ -0,0 +1,230 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package netisolation
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	proxyDialTimeout = 30 * time.Second
+	// noProxyHosts are never sent to the proxy, so builds can still use local servers, e.g. in their tests.
+	noProxyHosts = "localhost,127.0.0.1,::1"
+)
+
+// hopByHopHeaders only apply to a single connection, so they aren't forwarded by the proxy.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Proxy is an HTTP proxy which only forwards requests to an allowlist of hosts. It supports plain HTTP requests and
+// tunnels (CONNECT), which are used for HTTPS.
+type Proxy struct {
+	listener  net.Listener
+	server    *http.Server
+	transport *http.Transport
+	allowlist []string
+
+	deniedMutex sync.Mutex
+	denied      map[string]bool
+}
+
+// NewProxy starts a proxy which serves the listener, and only allows requests to the hosts on the allowlist.
+func NewProxy(listener net.Listener, allowlist []string) (p *Proxy) {
+	p = &Proxy{
+		listener:  listener,
+		allowlist: allowlist,
+		denied:    make(map[string]bool),
+		transport: &http.Transport{
+			// The proxy connects to the hosts directly, even if the host itself uses a proxy.
+			Proxy:       nil,
+			DialContext: (&net.Dialer{Timeout: proxyDialTimeout}).DialContext,
+		},
+	}
+	p.server = &http.Server{Handler: p}
+
+	go func() {
+		err := p.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Log.Warnf("Network proxy stopped:\n%s", err)
+		}
+	}()
+
+	return
+}
+
+// URL returns the address of the proxy.
+func (p *Proxy) URL() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// Environment returns the environment variables which point HTTP clients (e.g. curl, wget, pip, cargo, go) at the
+// proxy.
+func (p *Proxy) Environment() map[string]string {
+	return map[string]string{
+		"http_proxy":  p.URL(),
+		"https_proxy": p.URL(),
+		"HTTP_PROXY":  p.URL(),
+		"HTTPS_PROXY": p.URL(),
+		"no_proxy":    noProxyHosts,
+		"NO_PROXY":    noProxyHosts,
+	}
+}
+
+// Denied returns the requests the proxy denied so far, sorted.
+func (p *Proxy) Denied() (denied []string) {
+	p.deniedMutex.Lock()
+	defer p.deniedMutex.Unlock()
+
+	for request := range p.denied {
+		denied = append(denied, request)
+	}
+	sort.Strings(denied)
+
+	return
+}
+
+// Close stops the proxy, and closes all of its connections.
+func (p *Proxy) Close() error {
+	p.transport.CloseIdleConnections()
+	return p.server.Close()
+}
+
+// IsAllowed returns true if the host is on the allowlist.
+func (p *Proxy) IsAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range p.allowlist {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "*"))
+		if strings.HasPrefix(allowed, ".") {
+			if strings.HasSuffix(host, allowed) || host == allowed[1:] {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP handles a request to the proxy.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.serveTunnel(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "the network proxy only handles proxy requests", http.StatusBadRequest)
+		return
+	}
+
+	if !p.IsAllowed(r.URL.Hostname()) {
+		p.deny(w, fmt.Sprintf("%s %s", r.Method, r.URL.Redacted()))
+		return
+	}
+
+	outRequest := r.Clone(r.Context())
+	outRequest.RequestURI = ""
+	removeHopByHopHeaders(outRequest.Header)
+
+	response, err := p.transport.RoundTrip(outRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+
+	removeHopByHopHeaders(response.Header)
+	for name, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+}
+
+// serveTunnel connects the client to the requested host, if it's allowed.
+func (p *Proxy) serveTunnel(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	if !p.IsAllowed(host) {
+		p.deny(w, fmt.Sprintf("%s %s", r.Method, r.Host))
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "the network proxy does not support tunnels", http.StatusInternalServerError)
+		return
+	}
+
+	serverConn, err := net.DialTimeout("tcp", r.Host, proxyDialTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer serverConn.Close()
+
+	clientConn, clientBuffer, err := hijacker.Hijack()
+	if err != nil {
+		logger.Log.Warnf("Failed to open a tunnel to (%s):\n%s", r.Host, err)
+		return
+	}
+	defer clientConn.Close()
+
+	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	// Copy both directions until either side closes its connection.
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(serverConn, clientBuffer)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, serverConn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// deny records a denied request, and fails it with an explanation for the build's log.
+func (p *Proxy) deny(w http.ResponseWriter, request string) {
+	p.deniedMutex.Lock()
+	p.denied[request] = true
+	p.deniedMutex.Unlock()
+
+	logger.Log.Warnf("Network isolation: denied (%s)", request)
+	http.Error(w, fmt.Sprintf("network access is not allowed during the package build (%s)", request), http.StatusForbidden)
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
+)
+
+const (
+	// Upper bound on a single mirror probe.
+	DefaultMirrorProbeTimeout = time.Second * 10
+
+	// Number of bytes read from each mirror to sample its throughput.
+	mirrorProbeSampleSize = 256 * 1024
+
+	// Expected size of a download, used to weigh a mirror's latency against its throughput.
+	mirrorReferenceDownloadSize = 1024 * 1024
+
+	// A mirror's weight is halved for each consecutive failure, up to this many times.
+	maxMirrorFailurePenalty = 8
+
+	// An ordered mirror is only tried after the healthy mirrors once it failed this many times in a row.
+	unhealthyMirrorFailures = 3
+)
+
+// MirrorProbeResult is the result of probing a single mirror.
+type MirrorProbeResult struct {
+	// Mirror is the scheme and host of the mirror (see MirrorOf()).
+	Mirror string
+	// Latency is the time until the mirror started responding.
+	Latency time.Duration
+	// BytesPerSecond is the throughput of the sample download.
+	BytesPerSecond float64
+	// Err is set if the mirror could not be reached.
+	Err error
+}
+
+// weight returns how many reference sized downloads per second the mirror is expected to serve.
+func (r MirrorProbeResult) weight() float64 {
+	if r.Err != nil || r.BytesPerSecond <= 0 {
+		return 0
+	}
+
+	expectedDuration := r.Latency.Seconds() + mirrorReferenceDownloadSize/r.BytesPerSecond
+	if expectedDuration <= 0 {
+		return 0
+	}
+	return 1 / expectedDuration
+}
+
+// MirrorOf returns the mirror that serves a URL, i.e. its scheme and host. URLs that can't be parsed are their own
+// mirror.
+func MirrorOf(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return rawURL
+	}
+	return fmt.Sprintf("%s://%s", parsedURL.Scheme, parsedURL.Host)
+}
+
+// ProbeMirrors concurrently probes each mirror by downloading the start of a sample file from it.
+// sampleURLs: A map of mirror (see MirrorOf()) to the URL of a file hosted by that mirror.
+// returns: The probe results, sorted from the best mirror to the worst.
+func ProbeMirrors(ctx context.Context, sampleURLs map[string]string, caCerts *x509.CertPool, tlsCerts []tls.Certificate, timeout time.Duration) (results []MirrorProbeResult) {
+	wg := new(sync.WaitGroup)
+	resultsMutex := new(sync.Mutex)
+
+	for mirror, sampleURL := range sampleURLs {
+		wg.Add(1)
+		go func(mirror, sampleURL string) {
+			defer wg.Done()
+
+			result := probeMirror(ctx, sampleURL, caCerts, tlsCerts, timeout)
+			result.Mirror = mirror
+			if result.Err != nil {
+				logger.Log.Warnf("Failed to probe mirror (%s): %s", mirror, result.Err)
+			}
+
+			resultsMutex.Lock()
+			defer resultsMutex.Unlock()
+			results = append(results, result)
+		}(mirror, sampleURL)
+	}
+	wg.Wait()
+
+	sortMirrorProbeResults(results)
+	return
+}
+
+// sortMirrorProbeResults sorts the probe results from the best mirror to the worst.
+func sortMirrorProbeResults(results []MirrorProbeResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].weight() != results[j].weight() {
+			return results[i].weight() > results[j].weight()
+		}
+		return results[i].Mirror < results[j].Mirror
+	})
+}
+
+// probeMirror measures the latency and throughput of a mirror by downloading up to 'mirrorProbeSampleSize' bytes of
+// sampleURL.
+func probeMirror(ctx context.Context, sampleURL string, caCerts *x509.CertPool, tlsCerts []tls.Certificate, timeout time.Duration) (result MirrorProbeResult) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, sampleURL, nil)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to create request:\n%w", err)
+		return
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=0-%d", mirrorProbeSampleSize-1))
+
+	start := time.Now()
+	response, err := newHttpClient(caCerts, tlsCerts).Do(request)
+	if err != nil {
+		result.Err = fmt.Errorf("request failed:\n%w", err)
+		return
+	}
+	defer response.Body.Close()
+
+	result.Latency = time.Since(start)
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+		result.Err = buildResponseError(response.StatusCode)
+		return
+	}
+
+	bytesRead, err := io.Copy(io.Discard, io.LimitReader(response.Body, mirrorProbeSampleSize))
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read response:\n%w", err)
+		return
+	}
+
+	// Guard against a zero duration on very fast (e.g. local) mirrors.
+	transferDuration := max(time.Since(start)-result.Latency, time.Microsecond)
+	result.BytesPerSecond = float64(bytesRead) / transferDuration.Seconds()
+	return
+}
+
+// mirrorState tracks how a mirror is being used during a run.
+type mirrorState struct {
+	weight              float64
+	inFlight            int
+	consecutiveFailures int
+}
+
+func (m *mirrorState) effectiveWeight() float64 {
+	return m.weight / float64(uint(1)<<min(m.consecutiveFailures, maxMirrorFailurePenalty))
+}
+
+// MirrorSelector spreads downloads across mirrors in proportion to how fast each mirror is, and fails over to the
+// other mirrors when a download fails. It is safe for concurrent use.
+type MirrorSelector struct {
+	mutex         sync.Mutex
+	mirrors       map[string]*mirrorState
+	defaultWeight float64
+	// ordered selectors try the mirrors in the order of the URLs, instead of spreading the downloads.
+	ordered bool
+}
+
+// NewMirrorSelector creates a MirrorSelector from the results of ProbeMirrors(). Mirrors that failed their probe are
+// only used once every other mirror has failed. Mirrors that weren't probed are treated like the slowest reachable
+// mirror.
+func NewMirrorSelector(probeResults []MirrorProbeResult) (selector *MirrorSelector) {
+	selector = &MirrorSelector{
+		mirrors: make(map[string]*mirrorState),
+	}
+
+	for _, result := range probeResults {
+		weight := result.weight()
+		selector.mirrors[result.Mirror] = &mirrorState{weight: weight}
+
+		if weight > 0 && (selector.defaultWeight == 0 || weight < selector.defaultWeight) {
+			selector.defaultWeight = weight
+		}
+	}
+
+	if selector.defaultWeight == 0 {
+		selector.defaultWeight = 1
+	}
+
+	return
+}
+
+// NewOrderedMirrorSelector creates a MirrorSelector which tries mirrors in the order of the URLs it is given, e.g.
+// a primary source server and its fallbacks. A mirror which keeps failing is only tried after the healthy ones,
+// until it succeeds again.
+func NewOrderedMirrorSelector() (selector *MirrorSelector) {
+	selector = NewMirrorSelector(nil)
+	selector.ordered = true
+	return
+}
+
+// IsHealthy returns false if downloads from the mirror (see MirrorOf()) keep failing.
+func (s *MirrorSelector) IsHealthy(mirror string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.getMirrorState(mirror).consecutiveFailures < unhealthyMirrorFailures
+}
+
+// getMirrorState returns the state of a mirror, adding it if it wasn't probed. The caller must hold the mutex.
+func (s *MirrorSelector) getMirrorState(mirror string) *mirrorState {
+	state, found := s.mirrors[mirror]
+	if !found {
+		state = &mirrorState{weight: s.defaultWeight}
+		s.mirrors[mirror] = state
+	}
+	return state
+}
+
+// order returns the order that the URLs should be tried in. The first URL is on the mirror that is expected to finish
+// the download soonest, given the downloads already in flight. The rest are ordered from the fastest mirror to the
+// slowest.
+func (s *MirrorSelector) order(urls []string) (ordered []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ordered = append([]string(nil), urls...)
+	if s.ordered {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return s.getMirrorState(MirrorOf(ordered[i])).consecutiveFailures < unhealthyMirrorFailures &&
+				s.getMirrorState(MirrorOf(ordered[j])).consecutiveFailures >= unhealthyMirrorFailures
+		})
+		return
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.getMirrorState(MirrorOf(ordered[i])).effectiveWeight() > s.getMirrorState(MirrorOf(ordered[j])).effectiveWeight()
+	})
+
+	best := -1
+	bestFinish := 0.0
+	for i, candidateURL := range ordered {
+		state := s.getMirrorState(MirrorOf(candidateURL))
+		weight := state.effectiveWeight()
+		if weight <= 0 {
+			continue
+		}
+
+		finish := float64(state.inFlight+1) / weight
+		if best < 0 || finish < bestFinish {
+			best = i
+			bestFinish = finish
+		}
+	}
+
+	if best > 0 {
+		primary := ordered[best]
+		copy(ordered[1:best+1], ordered[:best])
+		ordered[0] = primary
+	}
+
+	return
+}
+
+// start records that a download from a URL has started.
+func (s *MirrorSelector) start(downloadURL string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.getMirrorState(MirrorOf(downloadURL)).inFlight++
+}
+
+// finish records the result of a download from a URL. A 404 isn't held against the mirror since the file may just be
+// missing from that one mirror.
+func (s *MirrorSelector) finish(downloadURL string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state := s.getMirrorState(MirrorOf(downloadURL))
+	state.inFlight--
+
+	switch {
+	case err == nil:
+		state.consecutiveFailures = 0
+	case errors.Is(err, ErrDownloadFileInvalidResponse404):
+	default:
+		state.consecutiveFailures++
+	}
+}
+
+// DownloadFileWithRetry downloads a file that is available from one or more mirrors. Each attempt tries every mirror,
+// starting with the one picked by the selector, before backing off and retrying. Gives up without retrying if every
+// mirror returns 404. See network.DownloadFileWithRetry() for the meaning of the other arguments and return values.
+func (s *MirrorSelector) DownloadFileWithRetry(ctx context.Context, srcUrls []string, dstFile string, caCerts *x509.CertPool, tlsCerts []tls.Certificate, timeout time.Duration) (wasCancelled bool, err error) {
+	var closeCtx context.CancelFunc
+
+	if ctx == nil {
+		return false, fmt.Errorf("context is nil")
+	}
+
+	if len(srcUrls) == 0 {
+		return false, fmt.Errorf("no URLs to download (%s) from", dstFile)
+	}
+
+	if timeout < 0 {
+		return false, fmt.Errorf("%w: %s", ErrDownloadFileInvalidTimeout, timeout)
+	}
+
+	if timeout == 0 {
+		ctx, closeCtx = context.WithCancel(ctx)
+	} else {
+		ctx, closeCtx = context.WithTimeout(ctx, timeout)
+	}
+	defer closeCtx()
+
+	retryNum := 1
+	errorWas404 := false
+	wasCancelled, err = retry.RunWithDefaultDownloadBackoff(ctx, func() (netErr error) {
+		all404 := false
+		all404, netErr = s.TryMirrors(ctx, srcUrls, func(srcUrl string) error {
+			downloadErr := DownloadFile(ctx, srcUrl, dstFile, caCerts, tlsCerts)
+			if downloadErr != nil {
+				logger.Log.Infof("Attempt %d/%d: Failed to download (%s) with error: (%s)", retryNum, retry.DefaultDownloadRetryAttempts, srcUrl, downloadErr)
+			}
+			return downloadErr
+		})
+
+		// 404's from every mirror are unlikely to fix themselves on retry, give up.
+		if all404 {
+			logger.Log.Warnf("Failed to download (%s) from any of %d mirror(s) with error: (%s)", dstFile, len(srcUrls), netErr)
+			logger.Log.Warnf("404 errors are likely unrecoverable, will not retry")
+			errorWas404 = true
+			closeCtx()
+		}
+
+		retryNum++
+		return netErr
+	})
+
+	// If the error was a 404, we should not consider the download as cancelled
+	if errorWas404 {
+		wasCancelled = false
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to download (%s) from any of %d mirror(s):\n%w", dstFile, len(srcUrls), err)
+	}
+	return
+}
+
+// TryMirrors makes a single attempt to download a file that is available from one or more mirrors. It calls download
+// with each URL, starting with the one picked by the selector, until a download succeeds, and records the results.
+// returns: all404: true if every mirror returned 404 (see ErrDownloadFileInvalidResponse404).
+// returns: err: The error of the last download, nil if a download succeeded.
+func (s *MirrorSelector) TryMirrors(ctx context.Context, srcUrls []string, download func(srcUrl string) error) (all404 bool, err error) {
+	all404 = len(srcUrls) > 0
+	for _, srcUrl := range s.order(srcUrls) {
+		s.start(srcUrl)
+		err = download(srcUrl)
+		s.finish(srcUrl, err)
+		if err == nil {
+			return false, nil
+		}
+
+		if !errors.Is(err, ErrDownloadFileInvalidResponse404) {
+			all404 = false
+		}
+
+		if ctx.Err() != nil {
+			all404 = false
+			break
+		}
+	}
+
+	return
+}
@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorOf(t *testing.T) {
+	assert.Equal(t, "https://packages.example.com", MirrorOf("https://packages.example.com/base/x86_64/a.rpm"))
+	assert.Equal(t, "http://127.0.0.1:8080", MirrorOf("http://127.0.0.1:8080/a.rpm"))
+	assert.Equal(t, "not a url", MirrorOf("not a url"))
+}
+
+func TestProbeMirrors(t *testing.T) {
+	const slowMirrorDelay = 200 * time.Millisecond
+
+	handler := func(delay time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			fmt.Fprintf(w, "package contents")
+		}
+	}
+
+	fastServer := httptest.NewServer(handler(0))
+	defer fastServer.Close()
+	slowServer := httptest.NewServer(handler(slowMirrorDelay))
+	defer slowServer.Close()
+	missingServer := httptest.NewServer(http.NotFoundHandler())
+	defer missingServer.Close()
+	closedServer := httptest.NewServer(handler(0))
+	closedServer.Close()
+
+	sampleURLs := map[string]string{
+		MirrorOf(slowServer.URL):    slowServer.URL + "/a.rpm",
+		MirrorOf(closedServer.URL):  closedServer.URL + "/a.rpm",
+		MirrorOf(fastServer.URL):    fastServer.URL + "/a.rpm",
+		MirrorOf(missingServer.URL): missingServer.URL + "/a.rpm",
+	}
+
+	results := ProbeMirrors(context.Background(), sampleURLs, nil, nil, DefaultMirrorProbeTimeout)
+	require.Len(t, results, 4)
+
+	assert.Equal(t, MirrorOf(fastServer.URL), results[0].Mirror)
+	assert.NoError(t, results[0].Err)
+	assert.Greater(t, results[0].BytesPerSecond, 0.0)
+
+	assert.Equal(t, MirrorOf(slowServer.URL), results[1].Mirror)
+	assert.NoError(t, results[1].Err)
+	assert.GreaterOrEqual(t, results[1].Latency, slowMirrorDelay)
+
+	assert.Error(t, results[2].Err)
+	assert.Error(t, results[3].Err)
+	assert.ErrorIs(t, results[findMirrorProbeResult(results, MirrorOf(missingServer.URL))].Err,
+		ErrDownloadFileInvalidResponse404)
+}
+
+func findMirrorProbeResult(results []MirrorProbeResult, mirror string) int {
+	for i, result := range results {
+		if result.Mirror == mirror {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestMirrorSelectorDistributesByWeight(t *testing.T) {
+	const (
+		fastURL        = "https://fast.example.com/a.rpm"
+		slowURL        = "https://slow.example.com/a.rpm"
+		unreachableURL = "https://unreachable.example.com/a.rpm"
+	)
+
+	selector := NewMirrorSelector([]MirrorProbeResult{
+		{Mirror: MirrorOf(fastURL), BytesPerSecond: 2 * mirrorReferenceDownloadSize},
+		{Mirror: MirrorOf(slowURL), BytesPerSecond: mirrorReferenceDownloadSize},
+		{Mirror: MirrorOf(unreachableURL), Err: errors.New("unreachable")},
+	})
+
+	urls := []string{unreachableURL, slowURL, fastURL}
+
+	// With nothing in flight the fastest mirror is used, and the unreachable mirror is always last.
+	assert.Equal(t, []string{fastURL, slowURL, unreachableURL}, selector.order(urls))
+
+	// The fast mirror should be given twice as many of the in-flight downloads as the slow mirror.
+	primaries := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		ordered := selector.order(urls)
+		assert.Equal(t, unreachableURL, ordered[2])
+
+		selector.start(ordered[0])
+		primaries[ordered[0]]++
+	}
+	assert.Equal(t, map[string]int{fastURL: 4, slowURL: 2}, primaries)
+}
+
+func TestMirrorSelectorPenalizesFailures(t *testing.T) {
+	const (
+		fastURL = "https://fast.example.com/a.rpm"
+		slowURL = "https://slow.example.com/a.rpm"
+	)
+
+	selector := NewMirrorSelector([]MirrorProbeResult{
+		{Mirror: MirrorOf(fastURL), BytesPerSecond: 2 * mirrorReferenceDownloadSize},
+		{Mirror: MirrorOf(slowURL), BytesPerSecond: mirrorReferenceDownloadSize},
+	})
+	urls := []string{fastURL, slowURL}
+
+	// A missing file isn't held against the mirror.
+	selector.start(fastURL)
+	selector.finish(fastURL, ErrDownloadFileInvalidResponse404)
+	assert.Equal(t, []string{fastURL, slowURL}, selector.order(urls))
+
+	selector.start(fastURL)
+	selector.finish(fastURL, ErrDownloadFileOther)
+	selector.start(fastURL)
+	selector.finish(fastURL, ErrDownloadFileOther)
+	assert.Equal(t, []string{slowURL, fastURL}, selector.order(urls))
+
+	// A success restores the mirror's rank.
+	selector.start(fastURL)
+	selector.finish(fastURL, nil)
+	assert.Equal(t, []string{fastURL, slowURL}, selector.order(urls))
+}
+
+func TestMirrorSelectorUnprobedMirrors(t *testing.T) {
+	selector := NewMirrorSelector(nil)
+
+	urls := []string{"https://a.example.com/a.rpm", "https://b.example.com/a.rpm"}
+	assert.Equal(t, urls, selector.order(urls))
+}
+
+func TestOrderedMirrorSelectorDemotesUnhealthyMirrors(t *testing.T) {
+	const (
+		primaryURL  = "https://primary.example.com/source.tar.gz"
+		fallbackURL = "https://fallback.example.com/source.tar.gz"
+	)
+
+	selector := NewOrderedMirrorSelector()
+	urls := []string{primaryURL, fallbackURL}
+
+	// The primary is kept first while it's only failing occasionally, and never for missing files.
+	for i := 0; i < unhealthyMirrorFailures-1; i++ {
+		selector.start(primaryURL)
+		selector.finish(primaryURL, ErrDownloadFileOther)
+	}
+	selector.start(primaryURL)
+	selector.finish(primaryURL, ErrDownloadFileInvalidResponse404)
+	assert.Equal(t, urls, selector.order(urls))
+	assert.True(t, selector.IsHealthy(MirrorOf(primaryURL)))
+
+	selector.start(primaryURL)
+	selector.finish(primaryURL, ErrDownloadFileOther)
+	assert.Equal(t, []string{fallbackURL, primaryURL}, selector.order(urls))
+	assert.False(t, selector.IsHealthy(MirrorOf(primaryURL)))
+
+	// A success makes the primary healthy again.
+	selector.start(primaryURL)
+	selector.finish(primaryURL, nil)
+	assert.Equal(t, urls, selector.order(urls))
+}
+
+func TestMirrorSelectorDownloadFileWithRetryFailover(t *testing.T) {
+	brokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer brokenServer.Close()
+	workingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "package contents")
+	}))
+	defer workingServer.Close()
+
+	brokenURL := brokenServer.URL + "/a.rpm"
+	workingURL := workingServer.URL + "/a.rpm"
+
+	// Rank the broken mirror first so that the download has to fail over.
+	selector := NewMirrorSelector([]MirrorProbeResult{
+		{Mirror: MirrorOf(brokenURL), BytesPerSecond: 2 * mirrorReferenceDownloadSize},
+		{Mirror: MirrorOf(workingURL), BytesPerSecond: mirrorReferenceDownloadSize},
+	})
+
+	dstFile := filepath.Join(t.TempDir(), "a.rpm")
+	start := time.Now()
+	wasCancelled, err := selector.DownloadFileWithRetry(context.Background(), []string{brokenURL, workingURL}, dstFile,
+		nil, nil, DefaultTimeout)
+	assert.NoError(t, err)
+	assert.False(t, wasCancelled)
+
+	// Failing over shouldn't wait for a retry.
+	assert.Less(t, time.Since(start), time.Second)
+
+	contents, err := os.ReadFile(dstFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "package contents", string(contents))
+
+	assert.Equal(t, 1, selector.mirrors[MirrorOf(brokenURL)].consecutiveFailures)
+	assert.Equal(t, 0, selector.mirrors[MirrorOf(brokenURL)].inFlight)
+	assert.Equal(t, 0, selector.mirrors[MirrorOf(workingURL)].consecutiveFailures)
+}
+
+func TestMirrorSelectorDownloadFileWithRetryAll404(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	otherServer := httptest.NewServer(http.NotFoundHandler())
+	defer otherServer.Close()
+
+	selector := NewMirrorSelector(nil)
+	dstFile := filepath.Join(t.TempDir(), "a.rpm")
+
+	start := time.Now()
+	wasCancelled, err := selector.DownloadFileWithRetry(context.Background(),
+		[]string{server.URL + "/a.rpm", otherServer.URL + "/a.rpm"}, dstFile, nil, nil, DefaultTimeout)
+	assert.ErrorIs(t, err, ErrDownloadFileInvalidResponse404)
+	assert.False(t, wasCancelled)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.NoFileExists(t, dstFile)
+}
+
+func TestMirrorSelectorDownloadFileWithRetryNoURLs(t *testing.T) {
+	selector := NewMirrorSelector(nil)
+
+	_, err := selector.DownloadFileWithRetry(context.Background(), nil, "a.rpm", nil, nil, DefaultTimeout)
+	assert.ErrorContains(t, err, "no URLs to download (a.rpm) from")
+}
@@ -24,6 +24,9 @@ import (
 const (
 	// Default upper bound on a single network operation, across all retries.
 	DefaultTimeout = time.Minute * 20
+
+	// PartialDownloadSuffix is appended to the name of a file while DownloadFileResume downloads it.
+	PartialDownloadSuffix = ".partial"
 )
 
 // ErrDownloadFileInvalidResponse404 is returned when the download response is 404.
@@ -139,18 +142,7 @@ func DownloadFile(ctx context.Context, url, dst string, caCerts *x509.CertPool,
 		dstFile.Close()
 	}()
 
-	tlsConfig := &tls.Config{
-		RootCAs:      caCerts,
-		Certificates: tlsCerts,
-	}
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.TLSClientConfig = tlsConfig
-	// Default is 10 seconds, we increase to 30 seconds to mitigate TLS handshake timeout errors
-	// we're seeing from some upstream RPM package sources
-	transport.TLSHandshakeTimeout = 30 * time.Second
-	client := &http.Client{
-		Transport: transport,
-	}
+	client := newHttpClient(caCerts, tlsCerts)
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
@@ -172,6 +164,110 @@ func DownloadFile(ctx context.Context, url, dst string, caCerts *x509.CertPool,
 	return
 }
 
+// DownloadFileResume downloads `url` into `dst` like DownloadFile, except that the data received so far is kept in
+// `dst`+PartialDownloadSuffix if the download fails. The next download of `dst`, which may be from another mirror,
+// then resumes where the previous one stopped if the server supports range requests. `dst` is only created once the
+// download is complete. `caCerts` may be nil.
+func DownloadFileResume(ctx context.Context, url, dst string, caCerts *x509.CertPool, tlsCerts []tls.Certificate) (err error) {
+	if ctx == nil {
+		return fmt.Errorf("context is nil")
+	}
+
+	partialFile := dst + PartialDownloadSuffix
+
+	var offset int64
+	partialInfo, err := os.Stat(partialFile)
+	if err == nil {
+		offset = partialInfo.Size()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("%w:\nfailed to stat partial download:\n%w", ErrDownloadFileOther, err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%w:\nfailed to create request:\n%w", ErrDownloadFileOther, err)
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		logger.Log.Debugf("Resuming (%s) -> (%s) from byte %d", url, dst, offset)
+	} else {
+		logger.Log.Debugf("Downloading (%s) -> (%s)", url, dst)
+	}
+
+	response, err := newHttpClient(caCerts, tlsCerts).Do(request)
+	if err != nil {
+		return fmt.Errorf("%w:\nrequest failed:\n%w", ErrDownloadFileOther, err)
+	}
+	defer response.Body.Close()
+
+	openFlags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case response.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(response) == offset:
+		openFlags |= os.O_APPEND
+	case response.StatusCode == http.StatusOK:
+		// The server doesn't support range requests, start over.
+		openFlags |= os.O_TRUNC
+	case response.StatusCode == http.StatusPartialContent || response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial download doesn't match the file on the server, so it can't be resumed.
+		removeErr := file.RemoveFileIfExists(partialFile)
+		if removeErr != nil {
+			logger.Log.Warnf("Failed to remove partial download (%s): %s", partialFile, removeErr)
+		}
+		return fmt.Errorf("%w: partial download of (%s) can't be resumed", ErrDownloadFileOther, url)
+	default:
+		return buildResponseError(response.StatusCode)
+	}
+
+	dstFile, err := os.OpenFile(partialFile, openFlags, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w:\nfailed to create file:\n%w", ErrDownloadFileOther, err)
+	}
+
+	_, err = io.Copy(dstFile, response.Body)
+	closeErr := dstFile.Close()
+	if err != nil {
+		return fmt.Errorf("%w:\nfailed to read response:\n%w", ErrDownloadFileOther, err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("%w:\nfailed to write file:\n%w", ErrDownloadFileOther, closeErr)
+	}
+
+	err = os.Rename(partialFile, dst)
+	if err != nil {
+		return fmt.Errorf("%w:\nfailed to move the finished download:\n%w", ErrDownloadFileOther, err)
+	}
+
+	return
+}
+
+// contentRangeStart returns the first byte of a partial response, or -1 if its Content-Range header is invalid.
+func contentRangeStart(response *http.Response) (start int64) {
+	var end int64
+	_, err := fmt.Sscanf(response.Header.Get("Content-Range"), "bytes %d-%d/", &start, &end)
+	if err != nil {
+		logger.Log.Debugf("Invalid Content-Range (%s): %s", response.Header.Get("Content-Range"), err)
+		return -1
+	}
+
+	return
+}
+
+// newHttpClient creates an HTTP client that uses the given certificates. `caCerts` may be nil.
+func newHttpClient(caCerts *x509.CertPool, tlsCerts []tls.Certificate) *http.Client {
+	tlsConfig := &tls.Config{
+		RootCAs:      caCerts,
+		Certificates: tlsCerts,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// Default is 10 seconds, we increase to 30 seconds to mitigate TLS handshake timeout errors
+	// we're seeing from some upstream RPM package sources
+	transport.TLSHandshakeTimeout = 30 * time.Second
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
 // CheckNetworkAccess checks whether the installer environment has network access
 // This function is only executed within the ISO installation environment for kickstart-like unattended installation
 func CheckNetworkAccess() (err error, hasNetworkAccess bool) {
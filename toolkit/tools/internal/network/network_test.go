@@ -13,10 +13,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
@@ -198,3 +201,65 @@ func TestDownloadWithRetryNilContext(t *testing.T) {
 		t.Errorf("DownloadFile() should have failed with nil context")
 	}
 }
+
+func TestDownloadFileResume(t *testing.T) {
+	const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+	rangeRequests := []string{}
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeRequests = append(rangeRequests, r.Header.Get("Range"))
+		http.ServeContent(w, r, "source.tar.gz", time.Time{}, strings.NewReader(content))
+	}))
+	defer fileServer.Close()
+
+	dstFile := filepath.Join(t.TempDir(), "source.tar.gz")
+	err := os.WriteFile(dstFile+PartialDownloadSuffix, []byte(content[:10]), 0o644)
+	require.NoError(t, err)
+
+	err = DownloadFileResume(context.Background(), fileServer.URL+"/source.tar.gz", dstFile, nil, nil)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(dstFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(downloaded))
+	assert.NoFileExists(t, dstFile+PartialDownloadSuffix)
+	assert.Equal(t, []string{"bytes=10-"}, rangeRequests)
+}
+
+func TestDownloadFileResumeWithoutRangeSupport(t *testing.T) {
+	const content = "full source tarball"
+
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer fileServer.Close()
+
+	dstFile := filepath.Join(t.TempDir(), "source.tar.gz")
+	err := os.WriteFile(dstFile+PartialDownloadSuffix, []byte("stale data from another mirror"), 0o644)
+	require.NoError(t, err)
+
+	err = DownloadFileResume(context.Background(), fileServer.URL+"/source.tar.gz", dstFile, nil, nil)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(dstFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(downloaded))
+}
+
+func TestDownloadFileResumeKeepsPartialDownload(t *testing.T) {
+	fileServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more data than is sent, so the client sees the connection drop mid-download.
+		w.Header().Set("Content-Length", "100")
+		fmt.Fprint(w, "first half")
+	}))
+	defer fileServer.Close()
+
+	dstFile := filepath.Join(t.TempDir(), "source.tar.gz")
+	err := DownloadFileResume(context.Background(), fileServer.URL+"/source.tar.gz", dstFile, nil, nil)
+	assert.ErrorIs(t, err, ErrDownloadFileOther)
+	assert.NoFileExists(t, dstFile)
+
+	partial, err := os.ReadFile(dstFile + PartialDownloadSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, "first half", string(partial))
+}
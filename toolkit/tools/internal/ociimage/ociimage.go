@@ -0,0 +1,630 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package ociimage reads and writes OCI image layouts containing root filesystems, such as the worker chroot.
+package ociimage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/pgzip"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/systemdependency"
+)
+
+const (
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayerGzip     = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	AnnotationVersion = "org.opencontainers.image.version"
+	AnnotationCreated = "org.opencontainers.image.created"
+
+	layoutFileName = "oci-layout"
+	indexFileName  = "index.json"
+	blobsDirName   = "blobs"
+	layoutVersion  = "1.0.0"
+
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+
+	dockerManifestFileName = "manifest.json"
+)
+
+// Descriptor references a blob in the image layout.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Index is the top-level index.json of an image layout.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest describes a single image.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageConfig is the subset of the OCI image configuration the toolkit writes.
+type ImageConfig struct {
+	Created      string          `json:"created"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// ContainerConfig holds the default execution parameters of the image.
+type ContainerConfig struct {
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+// History describes how a layer of the image was created.
+type History struct {
+	Created   string `json:"created,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// Layer is a gzipped layer tarball to add to an image.
+type Layer struct {
+	Path string
+	// CreatedBy optionally describes how the layer was created, for the image's history.
+	CreatedBy string
+}
+
+// dockerManifestEntry is an image entry in the manifest.json file of a 'docker save' archive.
+type dockerManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// RootFS lists the uncompressed digests of the image's layers.
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type layoutFile struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// Reference identifies an image within an image layout directory, using the "<layout-dir>[:<tag>]" syntax.
+type Reference struct {
+	LayoutDir string
+	Tag       string
+}
+
+// ParseReference parses a "<layout-dir>[:<tag>]" image reference.
+func ParseReference(ref string) (Reference, error) {
+	if isLayoutDir(ref) {
+		return Reference{LayoutDir: ref}, nil
+	}
+
+	separatorIndex := strings.LastIndex(ref, ":")
+	if separatorIndex > 0 && !strings.Contains(ref[separatorIndex:], "/") {
+		layoutDir := ref[:separatorIndex]
+		if isLayoutDir(layoutDir) {
+			return Reference{LayoutDir: layoutDir, Tag: ref[separatorIndex+1:]}, nil
+		}
+	}
+
+	return Reference{}, fmt.Errorf("(%s) is not an OCI image layout reference", ref)
+}
+
+// IsImageReference returns true if the string refers to an image in an OCI image layout directory.
+func IsImageReference(ref string) bool {
+	_, err := ParseReference(ref)
+	return err == nil
+}
+
+func (r Reference) String() string {
+	if r.Tag == "" {
+		return r.LayoutDir
+	}
+	return r.LayoutDir + ":" + r.Tag
+}
+
+func isLayoutDir(dir string) bool {
+	exists, _ := file.PathExists(filepath.Join(dir, layoutFileName))
+	return exists
+}
+
+// WriteRootfsImage adds a single layer image, built from a gzipped rootfs tarball, to the image layout directory.
+// The layout is created if it doesn't already exist. If the layout already contains an image with the same tag, it
+// is replaced. Returns the digest of the image manifest.
+func WriteRootfsImage(rootfsTarGz string, layoutDir string, tag string, labels map[string]string) (manifestDigest string, err error) {
+	containerConfig := ContainerConfig{
+		Cmd:    []string{"/bin/bash"},
+		Labels: labels,
+	}
+
+	return WriteImage(layoutDir, []Layer{{Path: rootfsTarGz}}, containerConfig, tag)
+}
+
+// WriteImage adds an image, built from gzipped layer tarballs (lowest layer first), to the image layout directory.
+// The layout is created if it doesn't already exist. If the layout already contains an image with the same tag, it
+// is replaced. Returns the digest of the image manifest.
+func WriteImage(layoutDir string, layers []Layer, containerConfig ContainerConfig, tag string) (manifestDigest string, err error) {
+	err = initLayout(layoutDir)
+	if err != nil {
+		return "", err
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	layerDescriptors := []Descriptor(nil)
+	diffIDs := []string(nil)
+	history := []History(nil)
+	for _, layer := range layers {
+		layerDescriptor, err := writeBlobFromFile(layoutDir, layer.Path, MediaTypeLayerGzip)
+		if err != nil {
+			return "", fmt.Errorf("failed to add layer (%s):\n%w", layer.Path, err)
+		}
+
+		diffID, err := gunzippedDigest(layer.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to calculate uncompressed digest of (%s):\n%w", layer.Path, err)
+		}
+
+		layerDescriptors = append(layerDescriptors, layerDescriptor)
+		diffIDs = append(diffIDs, diffID)
+
+		if layer.CreatedBy != "" {
+			history = append(history, History{
+				Created:   created,
+				CreatedBy: layer.CreatedBy,
+			})
+		}
+	}
+
+	config := ImageConfig{
+		Created:      created,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config:       containerConfig,
+		RootFS: RootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+		History: history,
+	}
+
+	configDescriptor, err := writeJSONBlob(layoutDir, config, MediaTypeImageConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to write image config:\n%w", err)
+	}
+
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        configDescriptor,
+		Layers:        layerDescriptors,
+		Annotations: map[string]string{
+			AnnotationCreated: created,
+		},
+	}
+	if tag != "" {
+		manifest.Annotations[AnnotationVersion] = tag
+	}
+
+	manifestDescriptor, err := writeJSONBlob(layoutDir, manifest, MediaTypeImageManifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to write image manifest:\n%w", err)
+	}
+
+	if tag != "" {
+		manifestDescriptor.Annotations = map[string]string{
+			AnnotationRefName: tag,
+		}
+	}
+
+	err = addToIndex(layoutDir, manifestDescriptor)
+	if err != nil {
+		return "", err
+	}
+
+	return manifestDescriptor.Digest, nil
+}
+
+// WriteArchive packs the image layout directory into an 'oci-archive' tarball.
+func WriteArchive(layoutDir string, archiveFile string) (err error) {
+	if !isLayoutDir(layoutDir) {
+		return fmt.Errorf("(%s) is not an OCI image layout", layoutDir)
+	}
+
+	_, _, err = shell.Execute("tar", "-cf", archiveFile, "-C", layoutDir, layoutFileName, indexFileName, blobsDirName)
+	if err != nil {
+		return fmt.Errorf("failed to write OCI archive (%s):\n%w", archiveFile, err)
+	}
+
+	return nil
+}
+
+// WriteDockerArchive writes the referenced image as a 'docker save' tarball, which can be loaded by 'docker load'.
+// The staging directory is used to hold the uncompressed layers while the archive is written.
+func WriteDockerArchive(ref string, archiveFile string, repoTag string, stagingDir string) (err error) {
+	reference, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(reference)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(stagingDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory (%s):\n%w", stagingDir, err)
+	}
+
+	configPath, err := blobPath(reference.LayoutDir, manifest.Config.Digest)
+	if err != nil {
+		return err
+	}
+
+	_, configHex, _ := strings.Cut(manifest.Config.Digest, ":")
+	configFileName := configHex + ".json"
+	err = file.Copy(configPath, filepath.Join(stagingDir, configFileName))
+	if err != nil {
+		return fmt.Errorf("failed to copy image config:\n%w", err)
+	}
+
+	entry := dockerManifestEntry{
+		Config:   configFileName,
+		RepoTags: []string{},
+		Layers:   []string{},
+	}
+	if repoTag != "" {
+		entry.RepoTags = append(entry.RepoTags, repoTag)
+	}
+
+	tarArgs := []string{"-cf", archiveFile, "-C", stagingDir, dockerManifestFileName, configFileName}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != MediaTypeLayerGzip {
+			return fmt.Errorf("unsupported layer media type (%s) in image (%s)", layer.MediaType, reference)
+		}
+
+		layerPath, err := blobPath(reference.LayoutDir, layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		// Docker archives contain uncompressed layers, which are named after their uncompressed digest.
+		diffID, err := gunzippedDigest(layerPath)
+		if err != nil {
+			return fmt.Errorf("failed to calculate uncompressed digest of layer (%s):\n%w", layer.Digest, err)
+		}
+
+		_, diffIDHex, _ := strings.Cut(diffID, ":")
+		layerFileName := path.Join(diffIDHex, "layer.tar")
+
+		err = gunzipFile(layerPath, filepath.Join(stagingDir, layerFileName))
+		if err != nil {
+			return fmt.Errorf("failed to decompress layer (%s):\n%w", layer.Digest, err)
+		}
+
+		entry.Layers = append(entry.Layers, layerFileName)
+		tarArgs = append(tarArgs, diffIDHex)
+	}
+
+	err = jsonutils.WriteJSONFile(filepath.Join(stagingDir, dockerManifestFileName), []dockerManifestEntry{entry})
+	if err != nil {
+		return fmt.Errorf("failed to write (%s):\n%w", dockerManifestFileName, err)
+	}
+
+	_, _, err = shell.Execute("tar", tarArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to write docker archive (%s):\n%w", archiveFile, err)
+	}
+
+	return nil
+}
+
+// ExtractRootfs extracts the layers of the referenced image, in order, into the destination directory.
+func ExtractRootfs(ref string, destDir string) (err error) {
+	reference, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readManifest(reference)
+	if err != nil {
+		return err
+	}
+
+	gzipTool, err := systemdependency.GzipTool()
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != MediaTypeLayerGzip {
+			return fmt.Errorf("unsupported layer media type (%s) in image (%s)", layer.MediaType, reference)
+		}
+
+		layerPath, err := blobPath(reference.LayoutDir, layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		logger.Log.Debugf("Extracting layer (%s) of image (%s)", layer.Digest, reference)
+		_, _, err = shell.Execute("tar", "-I", gzipTool, "-xf", layerPath, "-C", destDir)
+		if err != nil {
+			return fmt.Errorf("failed to extract layer (%s) of image (%s):\n%w", layer.Digest, reference, err)
+		}
+
+		err = applyWhiteouts(destDir)
+		if err != nil {
+			return fmt.Errorf("failed to apply whiteouts of layer (%s):\n%w", layer.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+func readManifest(reference Reference) (manifest Manifest, err error) {
+	var index Index
+	err = jsonutils.ReadJSONFile(filepath.Join(reference.LayoutDir, indexFileName), &index)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read image index of (%s):\n%w", reference.LayoutDir, err)
+	}
+
+	var selected *Descriptor
+	for i := range index.Manifests {
+		descriptor := &index.Manifests[i]
+		if reference.Tag == "" || descriptor.Annotations[AnnotationRefName] == reference.Tag {
+			if selected != nil {
+				return manifest, fmt.Errorf("image layout (%s) contains multiple images, a tag must be specified",
+					reference.LayoutDir)
+			}
+			selected = descriptor
+		}
+	}
+
+	if selected == nil {
+		return manifest, fmt.Errorf("image (%s) not found", reference)
+	}
+
+	manifestPath, err := blobPath(reference.LayoutDir, selected.Digest)
+	if err != nil {
+		return manifest, err
+	}
+
+	err = jsonutils.ReadJSONFile(manifestPath, &manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read image manifest (%s):\n%w", selected.Digest, err)
+	}
+
+	return manifest, nil
+}
+
+func initLayout(layoutDir string) error {
+	err := os.MkdirAll(filepath.Join(layoutDir, blobsDirName, "sha256"), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create image layout directory (%s):\n%w", layoutDir, err)
+	}
+
+	if isLayoutDir(layoutDir) {
+		return nil
+	}
+
+	err = jsonutils.WriteJSONFile(filepath.Join(layoutDir, layoutFileName), layoutFile{ImageLayoutVersion: layoutVersion})
+	if err != nil {
+		return fmt.Errorf("failed to write (%s):\n%w", layoutFileName, err)
+	}
+
+	return jsonutils.WriteJSONFile(filepath.Join(layoutDir, indexFileName), Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageIndex,
+		Manifests:     []Descriptor{},
+	})
+}
+
+func addToIndex(layoutDir string, manifestDescriptor Descriptor) (err error) {
+	indexPath := filepath.Join(layoutDir, indexFileName)
+
+	var index Index
+	err = jsonutils.ReadJSONFile(indexPath, &index)
+	if err != nil {
+		return fmt.Errorf("failed to read image index (%s):\n%w", indexPath, err)
+	}
+
+	tag := manifestDescriptor.Annotations[AnnotationRefName]
+	manifests := []Descriptor{}
+	for _, existing := range index.Manifests {
+		if tag != "" && existing.Annotations[AnnotationRefName] == tag {
+			logger.Log.Infof("Replacing existing image tagged (%s)", tag)
+			continue
+		}
+		manifests = append(manifests, existing)
+	}
+	index.Manifests = append(manifests, manifestDescriptor)
+
+	return jsonutils.WriteJSONFile(indexPath, index)
+}
+
+func blobPath(layoutDir string, digest string) (string, error) {
+	algorithm, encoded, found := strings.Cut(digest, ":")
+	if !found || algorithm != "sha256" || encoded == "" || strings.ContainsAny(encoded, "/.") {
+		return "", fmt.Errorf("invalid blob digest (%s)", digest)
+	}
+
+	return filepath.Join(layoutDir, blobsDirName, algorithm, encoded), nil
+}
+
+func writeJSONBlob(layoutDir string, value interface{}, mediaType string) (descriptor Descriptor, err error) {
+	tempFile, err := os.CreateTemp(layoutDir, "blob-")
+	if err != nil {
+		return
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+
+	err = jsonutils.WriteJSONDescriptor(tempFile, value)
+	closeErr := tempFile.Close()
+	if err != nil {
+		return
+	}
+	if closeErr != nil {
+		return descriptor, closeErr
+	}
+
+	return writeBlobFromFile(layoutDir, tempPath, mediaType)
+}
+
+func writeBlobFromFile(layoutDir string, sourcePath string, mediaType string) (descriptor Descriptor, err error) {
+	digest, err := file.GenerateSHA256(sourcePath)
+	if err != nil {
+		return
+	}
+
+	stat, err := os.Stat(sourcePath)
+	if err != nil {
+		return
+	}
+
+	descriptor = Descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + digest,
+		Size:      stat.Size(),
+	}
+
+	destPath, err := blobPath(layoutDir, descriptor.Digest)
+	if err != nil {
+		return
+	}
+
+	// Blobs are content addressed, so an existing blob with the same digest doesn't need to be copied again.
+	exists, err := file.PathExists(destPath)
+	if err != nil || exists {
+		return
+	}
+
+	err = file.Copy(sourcePath, destPath)
+	return
+}
+
+func gunzippedDigest(path string) (digest string, err error) {
+	gzFile, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer gzFile.Close()
+
+	reader, err := pgzip.NewReader(gzFile)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, reader)
+	if err != nil {
+		return
+	}
+
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func gunzipFile(sourcePath string, destPath string) (err error) {
+	err = os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	gzFile, err := os.Open(sourcePath)
+	if err != nil {
+		return
+	}
+	defer gzFile.Close()
+
+	reader, err := pgzip.NewReader(gzFile)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return
+	}
+
+	_, err = io.Copy(destFile, reader)
+	closeErr := destFile.Close()
+	if err != nil {
+		return
+	}
+
+	return closeErr
+}
+
+// applyWhiteouts removes the files hidden by an extracted layer's whiteout entries, along with the whiteout entries
+// themselves.
+func applyWhiteouts(rootDir string) (err error) {
+	// Collect the whiteouts first, since removing files while walking the tree would invalidate the walk.
+	var whiteouts []string
+	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), whiteoutPrefix) {
+			whiteouts = append(whiteouts, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for _, whiteout := range whiteouts {
+		name := filepath.Base(whiteout)
+		if name == opaqueWhiteout {
+			logger.Log.Warnf("Opaque whiteouts are not supported, ignoring (%s)", whiteout)
+		} else {
+			hiddenPath := filepath.Join(filepath.Dir(whiteout), strings.TrimPrefix(name, whiteoutPrefix))
+			err = os.RemoveAll(hiddenPath)
+			if err != nil {
+				return
+			}
+		}
+
+		err = os.RemoveAll(whiteout)
+		if err != nil {
+			return
+		}
+	}
+
+	return nil
+}
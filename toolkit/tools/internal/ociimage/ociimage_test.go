@@ -0,0 +1,271 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package ociimage
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/pgzip"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func writeTestRootfs(t *testing.T, path string, files map[string]string) {
+	outFile, err := os.Create(path)
+	assert.NoError(t, err)
+	defer outFile.Close()
+
+	gzipWriter := pgzip.NewWriter(outFile)
+	defer gzipWriter.Close()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+
+	for name, contents := range files {
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0o644,
+			Size: int64(len(contents)),
+		})
+		assert.NoError(t, err)
+
+		_, err = tarWriter.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+}
+
+func TestParseReferenceNotLayout(t *testing.T) {
+	_, err := ParseReference(filepath.Join(t.TempDir(), "worker_chroot.tar.gz"))
+	assert.ErrorContains(t, err, "is not an OCI image layout reference")
+}
+
+func TestWriteAndExtractRootfsImage(t *testing.T) {
+	tmpDir := t.TempDir()
+	rootfsPath := filepath.Join(tmpDir, "rootfs.tar.gz")
+	layoutDir := filepath.Join(tmpDir, "layout")
+	extractDir := filepath.Join(tmpDir, "extract")
+
+	writeTestRootfs(t, rootfsPath, map[string]string{"etc/os-release": "ID=azurelinux\n"})
+
+	digest, err := WriteRootfsImage(rootfsPath, layoutDir, "3.0.1", map[string]string{"purpose": "test"})
+	assert.NoError(t, err)
+	assert.Regexp(t, "^sha256:[0-9a-f]{64}$", digest)
+
+	reference, err := ParseReference(layoutDir + ":3.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, layoutDir, reference.LayoutDir)
+	assert.Equal(t, "3.0.1", reference.Tag)
+	assert.True(t, IsImageReference(layoutDir))
+
+	err = os.MkdirAll(extractDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	err = ExtractRootfs(reference.String(), extractDir)
+	assert.NoError(t, err)
+
+	contents, err := file.Read(filepath.Join(extractDir, "etc/os-release"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ID=azurelinux\n", contents)
+}
+
+func TestWriteRootfsImageReplacesTag(t *testing.T) {
+	tmpDir := t.TempDir()
+	layoutDir := filepath.Join(tmpDir, "layout")
+	firstRootfs := filepath.Join(tmpDir, "first.tar.gz")
+	secondRootfs := filepath.Join(tmpDir, "second.tar.gz")
+
+	writeTestRootfs(t, firstRootfs, map[string]string{"a": "1"})
+	writeTestRootfs(t, secondRootfs, map[string]string{"b": "2"})
+
+	_, err := WriteRootfsImage(firstRootfs, layoutDir, "latest", nil)
+	assert.NoError(t, err)
+	_, err = WriteRootfsImage(firstRootfs, layoutDir, "1.0", nil)
+	assert.NoError(t, err)
+	secondDigest, err := WriteRootfsImage(secondRootfs, layoutDir, "latest", nil)
+	assert.NoError(t, err)
+
+	var index Index
+	err = jsonutils.ReadJSONFile(filepath.Join(layoutDir, indexFileName), &index)
+	assert.NoError(t, err)
+	assert.Len(t, index.Manifests, 2)
+	assert.Equal(t, "1.0", index.Manifests[0].Annotations[AnnotationRefName])
+	assert.Equal(t, "latest", index.Manifests[1].Annotations[AnnotationRefName])
+	assert.Equal(t, secondDigest, index.Manifests[1].Digest)
+
+	// Without a tag, the image to use is ambiguous.
+	err = ExtractRootfs(layoutDir, t.TempDir())
+	assert.ErrorContains(t, err, "contains multiple images")
+
+	err = ExtractRootfs(layoutDir+":missing", t.TempDir())
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestWriteImageLayers(t *testing.T) {
+	tmpDir := t.TempDir()
+	layoutDir := filepath.Join(tmpDir, "layout")
+	baseLayer := filepath.Join(tmpDir, "base.tar.gz")
+	topLayer := filepath.Join(tmpDir, "top.tar.gz")
+	extractDir := filepath.Join(tmpDir, "extract")
+
+	writeTestRootfs(t, baseLayer, map[string]string{"etc/os-release": "ID=azurelinux\n", "etc/motd": "hello\n"})
+	writeTestRootfs(t, topLayer, map[string]string{"etc/.wh.motd": "", "etc/hostname": "web\n"})
+
+	containerConfig := ContainerConfig{
+		Env:        []string{"PATH=/usr/bin"},
+		Entrypoint: []string{"/usr/sbin/nginx"},
+		WorkingDir: "/var/www",
+	}
+	_, err := WriteImage(layoutDir, []Layer{
+		{Path: baseLayer, CreatedBy: "base"},
+		{Path: topLayer, CreatedBy: "top"},
+	}, containerConfig, "web")
+	assert.NoError(t, err)
+
+	manifest, err := readManifest(Reference{LayoutDir: layoutDir, Tag: "web"})
+	assert.NoError(t, err)
+	assert.Len(t, manifest.Layers, 2)
+
+	configPath, err := blobPath(layoutDir, manifest.Config.Digest)
+	assert.NoError(t, err)
+
+	var config ImageConfig
+	err = jsonutils.ReadJSONFile(configPath, &config)
+	assert.NoError(t, err)
+	assert.Equal(t, containerConfig, config.Config)
+	assert.Len(t, config.RootFS.DiffIDs, 2)
+	if assert.Len(t, config.History, 2) {
+		assert.Equal(t, "base", config.History[0].CreatedBy)
+		assert.Equal(t, "top", config.History[1].CreatedBy)
+	}
+
+	err = os.MkdirAll(extractDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	err = ExtractRootfs(layoutDir+":web", extractDir)
+	assert.NoError(t, err)
+
+	exists, _ := file.PathExists(filepath.Join(extractDir, "etc/motd"))
+	assert.False(t, exists)
+	exists, _ = file.PathExists(filepath.Join(extractDir, "etc/hostname"))
+	assert.True(t, exists)
+}
+
+func TestWriteDockerArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	layoutDir := filepath.Join(tmpDir, "layout")
+	rootfsPath := filepath.Join(tmpDir, "rootfs.tar.gz")
+	archiveFile := filepath.Join(tmpDir, "image.tar")
+	stagingDir := filepath.Join(tmpDir, "staging")
+
+	writeTestRootfs(t, rootfsPath, map[string]string{"etc/os-release": "ID=azurelinux\n"})
+
+	_, err := WriteRootfsImage(rootfsPath, layoutDir, "", nil)
+	assert.NoError(t, err)
+
+	err = WriteDockerArchive(layoutDir, archiveFile, "my-image:1.0", stagingDir)
+	assert.NoError(t, err)
+
+	var entries []dockerManifestEntry
+	err = jsonutils.ReadJSONFile(filepath.Join(stagingDir, dockerManifestFileName), &entries)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, []string{"my-image:1.0"}, entries[0].RepoTags)
+		assert.Regexp(t, "^[0-9a-f]{64}\\.json$", entries[0].Config)
+		if assert.Len(t, entries[0].Layers, 1) {
+			assert.Regexp(t, "^[0-9a-f]{64}/layer.tar$", entries[0].Layers[0])
+
+			// The layer is uncompressed.
+			layerFile, err := os.Open(filepath.Join(stagingDir, entries[0].Layers[0]))
+			if assert.NoError(t, err) {
+				defer layerFile.Close()
+
+				header, err := tar.NewReader(layerFile).Next()
+				assert.NoError(t, err)
+				assert.Equal(t, "etc/os-release", header.Name)
+			}
+		}
+	}
+
+	exists, _ := file.PathExists(archiveFile)
+	assert.True(t, exists)
+}
+
+func TestWriteArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	layoutDir := filepath.Join(tmpDir, "layout")
+	rootfsPath := filepath.Join(tmpDir, "rootfs.tar.gz")
+	archiveFile := filepath.Join(tmpDir, "image.tar")
+
+	writeTestRootfs(t, rootfsPath, map[string]string{"etc/os-release": "ID=azurelinux\n"})
+
+	_, err := WriteRootfsImage(rootfsPath, layoutDir, "", nil)
+	assert.NoError(t, err)
+
+	err = WriteArchive(layoutDir, archiveFile)
+	assert.NoError(t, err)
+
+	archive, err := os.Open(archiveFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer archive.Close()
+
+	names := []string(nil)
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+
+	assert.Contains(t, names, layoutFileName)
+	assert.Contains(t, names, indexFileName)
+
+	err = WriteArchive(tmpDir, archiveFile)
+	assert.ErrorContains(t, err, "is not an OCI image layout")
+}
+
+func TestApplyWhiteouts(t *testing.T) {
+	rootDir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(rootDir, "usr"), os.ModePerm)
+	assert.NoError(t, err)
+	err = file.Write("hidden", filepath.Join(rootDir, "usr", "removed"))
+	assert.NoError(t, err)
+	err = file.Write("", filepath.Join(rootDir, "usr", ".wh.removed"))
+	assert.NoError(t, err)
+	err = file.Write("kept", filepath.Join(rootDir, "usr", "kept"))
+	assert.NoError(t, err)
+
+	err = applyWhiteouts(rootDir)
+	assert.NoError(t, err)
+
+	exists, _ := file.PathExists(filepath.Join(rootDir, "usr", "removed"))
+	assert.False(t, exists)
+	exists, _ = file.PathExists(filepath.Join(rootDir, "usr", ".wh.removed"))
+	assert.False(t, exists)
+	exists, _ = file.PathExists(filepath.Join(rootDir, "usr", "kept"))
+	assert.True(t, exists)
+}
+
+func TestBlobPathRejectsInvalidDigest(t *testing.T) {
+	_, err := blobPath("layout", "sha256:../../etc")
+	assert.ErrorContains(t, err, "invalid blob digest")
+
+	_, err = blobPath("layout", "md5:abc")
+	assert.ErrorContains(t, err, "invalid blob digest")
+}
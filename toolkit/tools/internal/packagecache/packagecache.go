@@ -0,0 +1,586 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package packagecache is a content-addressed cache of RPMs shared by the package and image builds.
+//
+// RPMs are stored once, by the SHA256 checksum of their content, under "objects/<first 2 digits>/<sha256>.rpm". Each
+// build records the RPMs it uses in "refs/<build ID>.json", which keeps them from being garbage collected.
+//
+// All the processes sharing a cache take a flock(2) lock on "cache.lock": a shared lock to store, fetch and reference
+// RPMs, and an exclusive lock to garbage collect. Objects are written to a temporary file and renamed into place, so
+// concurrent builds never see partial RPMs.
+package packagecache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	objectsDir     = "objects"
+	refsDir        = "refs"
+	lockFile       = "cache.lock"
+	objectSuffix   = ".rpm"
+	refsSuffix     = ".json"
+	refsLockSuffix = ".lock"
+	tempPrefix     = ".tmp-"
+	// tempSuffix is used by the temporary copies of objects outside of the cache, so that they aren't taken for RPMs.
+	tempSuffix = ".tmp"
+)
+
+// Cache is a content-addressed cache of RPMs in a local directory.
+type Cache struct {
+	rootDir string
+}
+
+// References are the objects used by a build.
+type References struct {
+	BuildID string    `json:"BuildID"`
+	Updated time.Time `json:"Updated"`
+	Objects []string  `json:"Objects"`
+}
+
+// GCOptions bound the cache. Only objects no build references are removed.
+type GCOptions struct {
+	// MaxAge removes the objects which haven't been used for longer, and forgets the references of builds which
+	// haven't updated them for longer. Zero disables the age limit.
+	MaxAge time.Duration
+	// MaxSize removes the least recently used objects until the cache is at most this many bytes. Zero disables the
+	// size limit.
+	MaxSize int64
+}
+
+// GCReport describes what a garbage collection removed.
+type GCReport struct {
+	RemovedObjects    int
+	RemovedBytes      int64
+	ExpiredBuilds     []string
+	RemainingObjects  int
+	RemainingBytes    int64
+	ReferencedObjects int
+}
+
+// object is an RPM stored in the cache.
+type object struct {
+	digest   string
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// Open opens the cache in rootDir, creating it if needed.
+func Open(rootDir string) (cache *Cache, err error) {
+	if rootDir == "" {
+		return nil, fmt.Errorf("package cache directory is empty")
+	}
+
+	rootDir, err = filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of package cache directory:\n%w", err)
+	}
+
+	for _, dir := range []string{objectsDir, refsDir} {
+		err = os.MkdirAll(filepath.Join(rootDir, dir), os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create package cache directory (%s):\n%w", rootDir, err)
+		}
+	}
+
+	return &Cache{rootDir: rootDir}, nil
+}
+
+// Store adds an RPM to the cache and returns its digest. Storing an RPM already in the cache only marks it as used.
+func (c *Cache) Store(rpmPath string) (digest string, err error) {
+	unlock, err := c.lock(unix.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	return c.store(rpmPath)
+}
+
+// Fetch copies the object with the digest to destinationPath, as a reflink if the filesystem supports it. The copy is
+// checked against the digest, and a corrupted object is removed from the cache. Returns false if the cache doesn't
+// have a valid object.
+func (c *Cache) Fetch(digest, destinationPath string) (found bool, err error) {
+	unlock, err := c.lock(unix.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	objectPath, err := c.objectPath(digest)
+	if err != nil {
+		return
+	}
+
+	exists, err := file.PathExists(objectPath)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	err = touch(objectPath)
+	if err != nil {
+		return
+	}
+
+	valid, err := copyObject(objectPath, destinationPath, digest)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch (%s) from the package cache:\n%w", digest, err)
+	}
+
+	if !valid {
+		logger.Log.Warnf("Package (%s) of the package cache (%s) is corrupted, removing it.", digest, c.rootDir)
+
+		err = os.Remove(objectPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("failed to remove corrupted (%s) from the package cache:\n%w", digest, err)
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// StoreDirectory adds the RPMs of a directory to the cache and makes them the references of the build, replacing its
+// previous references.
+func (c *Cache) StoreDirectory(directory, buildID string) (digests []string, err error) {
+	unlock, err := c.lock(unix.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	rpmFiles, err := filepath.Glob(filepath.Join(directory, "*.rpm"))
+	if err != nil {
+		return
+	}
+
+	for _, rpmFile := range rpmFiles {
+		var digest string
+
+		digest, err = c.store(rpmFile)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+
+	err = c.setReferences(buildID, digests)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Infof("Stored %d packages in the package cache (%s) for build (%s).", len(digests), c.rootDir, buildID)
+
+	return
+}
+
+// SetReferences replaces the objects referenced by the build.
+func (c *Cache) SetReferences(buildID string, digests []string) (err error) {
+	unlock, err := c.lock(unix.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	return c.setReferences(buildID, digests)
+}
+
+// ReleaseReferences removes the references of the build, so that its objects may be garbage collected.
+func (c *Cache) ReleaseReferences(buildID string) (err error) {
+	unlock, err := c.lock(unix.LOCK_SH)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	refsPath, err := c.refsPath(buildID)
+	if err != nil {
+		return
+	}
+
+	err = os.Remove(refsPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to release references of build (%s):\n%w", buildID, err)
+	}
+
+	return nil
+}
+
+// GC removes the objects that no build references, as bounded by options.
+func (c *Cache) GC(options GCOptions) (report *GCReport, err error) {
+	unlock, err := c.lock(unix.LOCK_EX)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	now := time.Now()
+	report = &GCReport{}
+
+	referenceCounts, expiredBuilds, err := c.countReferences(now, options.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+	report.ExpiredBuilds = expiredBuilds
+
+	objects, err := c.objects()
+	if err != nil {
+		return nil, err
+	}
+
+	// Oldest first, so that the least recently used objects are removed to respect the size limit.
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].lastUsed.Before(objects[j].lastUsed)
+	})
+
+	var totalSize int64
+	for _, obj := range objects {
+		totalSize += obj.size
+	}
+
+	for _, obj := range objects {
+		if referenceCounts[obj.digest] > 0 {
+			report.ReferencedObjects++
+			report.RemainingObjects++
+			continue
+		}
+
+		expired := options.MaxAge > 0 && now.Sub(obj.lastUsed) > options.MaxAge
+		oversized := options.MaxSize > 0 && totalSize > options.MaxSize
+		if !expired && !oversized {
+			report.RemainingObjects++
+			continue
+		}
+
+		logger.Log.Debugf("Removing unreferenced package (%s) from the package cache.", obj.digest)
+
+		err = os.Remove(obj.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove (%s) from the package cache:\n%w", obj.digest, err)
+		}
+
+		totalSize -= obj.size
+		report.RemovedObjects++
+		report.RemovedBytes += obj.size
+	}
+
+	report.RemainingBytes = totalSize
+
+	if options.MaxSize > 0 && totalSize > options.MaxSize {
+		logger.Log.Warnf("Package cache (%s) is still %d bytes, over its limit of %d bytes, because builds reference its packages.", c.rootDir, totalSize, options.MaxSize)
+	}
+
+	return report, nil
+}
+
+// store adds an RPM to the cache. The caller must hold the cache lock.
+func (c *Cache) store(rpmPath string) (digest string, err error) {
+	digest, err = file.GenerateSHA256(rpmPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate the checksum of (%s):\n%w", rpmPath, err)
+	}
+
+	objectPath, err := c.objectPath(digest)
+	if err != nil {
+		return
+	}
+
+	exists, err := file.PathExists(objectPath)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		return digest, touch(objectPath)
+	}
+
+	err = writeObject(rpmPath, objectPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to store (%s) in the package cache:\n%w", rpmPath, err)
+	}
+
+	return
+}
+
+// setReferences writes the references of a build. The caller must hold the cache lock.
+func (c *Cache) setReferences(buildID string, digests []string) (err error) {
+	refsPath, err := c.refsPath(buildID)
+	if err != nil {
+		return
+	}
+
+	// Serialize the builds sharing an ID, since the shared cache lock doesn't.
+	buildLock, err := lockFilePath(refsPath+refsLockSuffix, unix.LOCK_EX)
+	if err != nil {
+		return
+	}
+	defer buildLock()
+
+	sortedDigests := append([]string(nil), digests...)
+	sort.Strings(sortedDigests)
+
+	references := &References{
+		BuildID: buildID,
+		Updated: time.Now().UTC(),
+		Objects: sortedDigests,
+	}
+
+	tempPath := filepath.Join(filepath.Dir(refsPath), tempPrefix+filepath.Base(refsPath))
+	err = jsonutils.WriteJSONFile(tempPath, references)
+	if err != nil {
+		return fmt.Errorf("failed to write references of build (%s):\n%w", buildID, err)
+	}
+
+	err = os.Rename(tempPath, refsPath)
+	if err != nil {
+		return fmt.Errorf("failed to write references of build (%s):\n%w", buildID, err)
+	}
+
+	return
+}
+
+// countReferences returns how many builds reference each object. The references of the builds which haven't updated
+// them for longer than maxAge are removed instead.
+func (c *Cache) countReferences(now time.Time, maxAge time.Duration) (referenceCounts map[string]int, expiredBuilds []string, err error) {
+	refsPaths, err := filepath.Glob(filepath.Join(c.rootDir, refsDir, "*"+refsSuffix))
+	if err != nil {
+		return
+	}
+
+	referenceCounts = make(map[string]int)
+	for _, refsPath := range refsPaths {
+		if strings.HasPrefix(filepath.Base(refsPath), tempPrefix) {
+			continue
+		}
+
+		references := &References{}
+		err = jsonutils.ReadJSONFile(refsPath, references)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read package cache references (%s):\n%w", refsPath, err)
+		}
+
+		if maxAge > 0 && now.Sub(references.Updated) > maxAge {
+			logger.Log.Infof("Build (%s) hasn't used the package cache since (%s), releasing its references.", references.BuildID, references.Updated)
+
+			err = os.Remove(refsPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to release references of build (%s):\n%w", references.BuildID, err)
+			}
+
+			expiredBuilds = append(expiredBuilds, references.BuildID)
+			continue
+		}
+
+		for _, digest := range references.Objects {
+			referenceCounts[digest]++
+		}
+	}
+
+	return
+}
+
+// objects lists the objects of the cache.
+func (c *Cache) objects() (objects []*object, err error) {
+	err = filepath.WalkDir(filepath.Join(c.rootDir, objectsDir), func(walkPath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, objectSuffix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		// Temporary files are left behind by interrupted builds. Nothing uses them once the exclusive lock is held.
+		if strings.HasPrefix(name, tempPrefix) {
+			return os.Remove(walkPath)
+		}
+
+		objects = append(objects, &object{
+			digest:   strings.TrimSuffix(name, objectSuffix),
+			path:     walkPath,
+			size:     info.Size(),
+			lastUsed: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the objects of the package cache (%s):\n%w", c.rootDir, err)
+	}
+
+	return
+}
+
+// lock takes a flock(2) lock on the cache and returns the function releasing it.
+func (c *Cache) lock(how int) (unlock func(), err error) {
+	return lockFilePath(filepath.Join(c.rootDir, lockFile), how)
+}
+
+func (c *Cache) objectPath(digest string) (string, error) {
+	if len(digest) < 2 || strings.ContainsAny(digest, `/\.`) {
+		return "", fmt.Errorf("invalid package cache digest (%s)", digest)
+	}
+
+	return filepath.Join(c.rootDir, objectsDir, digest[:2], digest+objectSuffix), nil
+}
+
+func (c *Cache) refsPath(buildID string) (string, error) {
+	if buildID == "" || strings.ContainsAny(buildID, `/\`) || strings.HasPrefix(buildID, ".") {
+		return "", fmt.Errorf("invalid package cache build ID (%s)", buildID)
+	}
+
+	return filepath.Join(c.rootDir, refsDir, buildID+refsSuffix), nil
+}
+
+// lockFilePath blocks until it takes a flock(2) lock on the file and returns the function releasing it.
+func lockFilePath(lockPath string, how int) (unlock func(), err error) {
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file (%s):\n%w", lockPath, err)
+	}
+
+	err = unix.Flock(int(lockFile.Fd()), how)
+	if err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to lock (%s):\n%w", lockPath, err)
+	}
+
+	// Closing the file releases the lock.
+	return func() { lockFile.Close() }, nil
+}
+
+// writeObject copies an RPM to a temporary file next to objectPath and renames it into place.
+func writeObject(rpmPath, objectPath string) (err error) {
+	objectDir := filepath.Dir(objectPath)
+
+	err = os.MkdirAll(objectDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	source, err := os.Open(rpmPath)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	tempFile, err := os.CreateTemp(objectDir, tempPrefix+"*"+objectSuffix)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	_, err = io.Copy(tempFile, source)
+	if err != nil {
+		return
+	}
+
+	// Objects must never be modified, since their names are their checksums.
+	err = tempFile.Chmod(0o444)
+	if err != nil {
+		return
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tempFile.Name(), objectPath)
+}
+
+// copyObject copies an object to a temporary file next to destinationPath and, if its content matches the digest,
+// renames it into place. The copy is a separate file, so that using the object doesn't change the destination's
+// modification time and the destination may be modified without corrupting the cache.
+func copyObject(objectPath, destinationPath, digest string) (valid bool, err error) {
+	source, err := os.Open(objectPath)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	tempFile, err := os.CreateTemp(filepath.Dir(destinationPath), tempPrefix+"*"+tempSuffix)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil || !valid {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	err = unix.IoctlFileClone(int(tempFile.Fd()), int(source.Fd()))
+	if err != nil {
+		logger.Log.Debugf("Failed to reflink (%s), copying it instead: %s", objectPath, err)
+
+		_, err = io.Copy(tempFile, source)
+		if err != nil {
+			return
+		}
+	}
+
+	err = tempFile.Chmod(0o644)
+	if err != nil {
+		return
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return
+	}
+
+	checksum, err := file.GenerateSHA256(tempFile.Name())
+	if err != nil {
+		return
+	}
+
+	if checksum != digest {
+		return false, nil
+	}
+
+	valid = true
+	err = os.Rename(tempFile.Name(), destinationPath)
+
+	return
+}
+
+// touch marks an object as used now, for the garbage collection of the least recently used objects.
+func touch(objectPath string) (err error) {
+	now := time.Now()
+
+	err = os.Chtimes(objectPath, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to update the time of (%s):\n%w", objectPath, err)
+	}
+
+	return
+}
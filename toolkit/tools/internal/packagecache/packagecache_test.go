@@ -0,0 +1,224 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package packagecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func writeTestRPM(t *testing.T, directory, name, content string) string {
+	rpmPath := filepath.Join(directory, name+".rpm")
+	err := os.WriteFile(rpmPath, []byte(content), 0o644)
+	require.NoError(t, err)
+
+	return rpmPath
+}
+
+// setLastUsed makes an object look like it was last used at a time.
+func setLastUsed(t *testing.T, cache *Cache, digest string, lastUsed time.Time) {
+	objectPath, err := cache.objectPath(digest)
+	require.NoError(t, err)
+
+	err = os.Chtimes(objectPath, lastUsed, lastUsed)
+	require.NoError(t, err)
+}
+
+func TestStoreAndFetch(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	buildDir := t.TempDir()
+	digest, err := cache.Store(writeTestRPM(t, buildDir, "bash", "bash"))
+	require.NoError(t, err)
+
+	// Storing the same content again doesn't duplicate it.
+	sameDigest, err := cache.Store(writeTestRPM(t, buildDir, "bash-copy", "bash"))
+	require.NoError(t, err)
+	assert.Equal(t, digest, sameDigest)
+
+	objects, err := cache.objects()
+	require.NoError(t, err)
+	assert.Len(t, objects, 1)
+
+	fetchedPath := filepath.Join(t.TempDir(), "bash.rpm")
+	found, err := cache.Fetch(digest, fetchedPath)
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	content, err := os.ReadFile(fetchedPath)
+	require.NoError(t, err)
+	assert.Equal(t, "bash", string(content))
+
+	found, err = cache.Fetch("0000", fetchedPath)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestFetchCopiesObject(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	digest, err := cache.Store(writeTestRPM(t, t.TempDir(), "bash", "bash"))
+	require.NoError(t, err)
+
+	fetchedPath := filepath.Join(t.TempDir(), "bash.rpm")
+	found, err := cache.Fetch(digest, fetchedPath)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	fetchedTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = os.Chtimes(fetchedPath, fetchedTime, fetchedTime)
+	require.NoError(t, err)
+
+	// Using the object again doesn't change the fetched file.
+	_, err = cache.Store(writeTestRPM(t, t.TempDir(), "bash", "bash"))
+	require.NoError(t, err)
+
+	info, err := os.Stat(fetchedPath)
+	require.NoError(t, err)
+	assert.Equal(t, fetchedTime, info.ModTime())
+
+	// Modifying the fetched file doesn't change the object.
+	err = os.WriteFile(fetchedPath, []byte("modified"), 0o644)
+	require.NoError(t, err)
+
+	found, err = cache.Fetch(digest, filepath.Join(t.TempDir(), "bash.rpm"))
+	require.NoError(t, err)
+	assert.True(t, found)
+}
+
+func TestFetchRemovesCorruptedObject(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	digest, err := cache.Store(writeTestRPM(t, t.TempDir(), "bash", "bash"))
+	require.NoError(t, err)
+
+	objectPath, err := cache.objectPath(digest)
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(objectPath, 0o644))
+	require.NoError(t, os.WriteFile(objectPath, []byte("corrupted"), 0o644))
+
+	fetchDir := t.TempDir()
+	found, err := cache.Fetch(digest, filepath.Join(fetchDir, "bash.rpm"))
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.NoFileExists(t, objectPath)
+
+	fetchedFiles, err := os.ReadDir(fetchDir)
+	require.NoError(t, err)
+	assert.Empty(t, fetchedFiles)
+}
+
+func TestInvalidDigestAndBuildID(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = cache.Fetch("../../etc/passwd", filepath.Join(t.TempDir(), "passwd"))
+	assert.ErrorContains(t, err, "invalid package cache digest")
+
+	err = cache.SetReferences("../build", nil)
+	assert.ErrorContains(t, err, "invalid package cache build ID")
+}
+
+func TestGCKeepsReferencedObjects(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	imageDir := t.TempDir()
+	writeTestRPM(t, imageDir, "bash", "bash")
+	writeTestRPM(t, imageDir, "shared", "shared")
+	imageDigests, err := cache.StoreDirectory(imageDir, "image")
+	require.NoError(t, err)
+
+	packageDir := t.TempDir()
+	writeTestRPM(t, packageDir, "shared", "shared")
+	writeTestRPM(t, packageDir, "gcc", "gcc")
+	packageDigests, err := cache.StoreDirectory(packageDir, "pkggen")
+	require.NoError(t, err)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, digest := range append(imageDigests, packageDigests...) {
+		setLastUsed(t, cache, digest, old)
+	}
+
+	// Releasing the image build only frees the object the package build doesn't share.
+	err = cache.ReleaseReferences("image")
+	require.NoError(t, err)
+
+	report, err := cache.GC(GCOptions{MaxAge: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.RemovedObjects)
+	assert.Equal(t, int64(len("bash")), report.RemovedBytes)
+	assert.Equal(t, 2, report.RemainingObjects)
+	assert.Equal(t, 2, report.ReferencedObjects)
+	assert.Empty(t, report.ExpiredBuilds)
+}
+
+func TestGCExpiresBuilds(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	buildDir := t.TempDir()
+	writeTestRPM(t, buildDir, "bash", "bash")
+	digests, err := cache.StoreDirectory(buildDir, "image")
+	require.NoError(t, err)
+
+	refsPath, err := cache.refsPath("image")
+	require.NoError(t, err)
+
+	old := time.Now().Add(-48 * time.Hour)
+	references := &References{BuildID: "image", Updated: old, Objects: digests}
+	err = jsonutils.WriteJSONFile(refsPath, references)
+	require.NoError(t, err)
+	setLastUsed(t, cache, digests[0], old)
+
+	report, err := cache.GC(GCOptions{MaxAge: 24 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"image"}, report.ExpiredBuilds)
+	assert.Equal(t, 1, report.RemovedObjects)
+	assert.Equal(t, 0, report.RemainingObjects)
+}
+
+func TestGCRemovesLeastRecentlyUsedOverMaxSize(t *testing.T) {
+	cache, err := Open(t.TempDir())
+	require.NoError(t, err)
+
+	buildDir := t.TempDir()
+	now := time.Now()
+
+	oldDigest, err := cache.Store(writeTestRPM(t, buildDir, "old", "aaaaaaaaaa"))
+	require.NoError(t, err)
+	setLastUsed(t, cache, oldDigest, now.Add(-2*time.Hour))
+
+	newDigest, err := cache.Store(writeTestRPM(t, buildDir, "new", "bbbbbbbbbb"))
+	require.NoError(t, err)
+	setLastUsed(t, cache, newDigest, now.Add(-1*time.Hour))
+
+	report, err := cache.GC(GCOptions{MaxSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.RemovedObjects)
+	assert.Equal(t, int64(10), report.RemainingBytes)
+
+	found, err := cache.Fetch(oldDigest, filepath.Join(t.TempDir(), "old.rpm"))
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	found, err = cache.Fetch(newDigest, filepath.Join(t.TempDir(), "new.rpm"))
+	require.NoError(t, err)
+	assert.True(t, found)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repocloner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/tdnf"
+)
+
+// NoArch is the architecture of the packages that can be installed on any architecture.
+const NoArch = "noarch"
+
+// RepoPackagesFromTransaction returns the packages that a transaction resolved by tdnf installs or upgrades.
+func RepoPackagesFromTransaction(transaction *tdnf.Transaction) []*RepoPackage {
+	packages := []*RepoPackage(nil)
+	foundPackages := map[string]bool{}
+
+	for _, transactionPackage := range transaction.Packages {
+		if transactionPackage.Action == tdnf.ActionRemove || transactionPackage.Action == tdnf.ActionObsolete {
+			continue
+		}
+
+		version, distTag := transactionPackage.VersionAndDistTag()
+		pkg := &RepoPackage{
+			Name:         transactionPackage.Name,
+			Version:      version,
+			Architecture: transactionPackage.Architecture,
+			Distribution: distTag,
+		}
+
+		if foundPackages[pkg.ID()] {
+			continue
+		}
+		foundPackages[pkg.ID()] = true
+
+		packages = append(packages, pkg)
+	}
+
+	return packages
+}
+
+// ValidatePackageArchitectures checks that the resolved packages can be installed on the target architecture, so that
+// a mismatch is reported before the packages are downloaded and installed, instead of rpm failing with "package is
+// for a different architecture". Every package must either be of the target architecture or be noarch, and a package
+// must not be resolved as both a noarch and an architecture-specific package.
+//
+// The error lists all the offending packages.
+func ValidatePackageArchitectures(packages []*RepoPackage, targetArch string) error {
+	mismatched := []string(nil)
+	archsByName := make(map[string]map[string]bool)
+
+	for _, pkg := range packages {
+		if pkg.Architecture != targetArch && pkg.Architecture != NoArch {
+			mismatched = append(mismatched, pkg.ID())
+		}
+
+		if archsByName[pkg.Name] == nil {
+			archsByName[pkg.Name] = make(map[string]bool)
+		}
+		archsByName[pkg.Name][pkg.Architecture] = true
+	}
+
+	inconsistent := []string(nil)
+	for name, archs := range archsByName {
+		if archs[NoArch] && len(archs) > 1 {
+			inconsistent = append(inconsistent, name)
+		}
+	}
+
+	if len(mismatched) == 0 && len(inconsistent) == 0 {
+		return nil
+	}
+
+	sort.Strings(mismatched)
+	sort.Strings(inconsistent)
+
+	lines := []string(nil)
+	if len(mismatched) > 0 {
+		lines = append(lines, fmt.Sprintf("%d package(s) don't match the target architecture (%s):", len(mismatched),
+			targetArch))
+		for _, id := range mismatched {
+			lines = append(lines, "  "+id)
+		}
+	}
+
+	if len(inconsistent) > 0 {
+		lines = append(lines, fmt.Sprintf("%d package(s) were resolved as both noarch and architecture-specific "+
+			"packages:", len(inconsistent)))
+		for _, name := range inconsistent {
+			lines = append(lines, "  "+name)
+		}
+	}
+
+	return fmt.Errorf("package architecture mismatch:\n%s", strings.Join(lines, "\n"))
+}
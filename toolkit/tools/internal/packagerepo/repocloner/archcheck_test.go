@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repocloner
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/tdnf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoPackagesFromTransaction(t *testing.T) {
+	const stdout = `Loaded plugin: tdnfrepogpgcheck
+
+Installing:
+bash                       x86_64       5.2.15-3.azl3         azurelinux-official-base   7.47M   1.72M
+ca-certificates-base       noarch       3.0.0-8.azl3          azurelinux-official-base 126.93k  57.05k
+bash                       x86_64       5.2.15-3.azl3         azurelinux-official-base   7.47M   1.72M
+
+Removing:
+bash-old                   x86_64       5.2.15-2.azl3         @System                    7.40M
+
+Total installed size:   7.60M
+Total download size:   1.78M
+`
+
+	transaction, err := tdnf.ParseTransaction(stdout)
+	require.NoError(t, err)
+
+	packages := RepoPackagesFromTransaction(transaction)
+	assert.Equal(t, []*RepoPackage{
+		{Name: "bash", Version: "5.2.15-3", Architecture: "x86_64", Distribution: "azl3"},
+		{Name: "ca-certificates-base", Version: "3.0.0-8", Architecture: "noarch", Distribution: "azl3"},
+	}, packages)
+}
+
+func TestValidatePackageArchitectures(t *testing.T) {
+	packages := []*RepoPackage{
+		{Name: "bash", Version: "5.2.15-3", Architecture: "x86_64", Distribution: "azl3"},
+		{Name: "ca-certificates-base", Version: "3.0.0-8", Architecture: "noarch", Distribution: "azl3"},
+	}
+
+	assert.NoError(t, ValidatePackageArchitectures(packages, "x86_64"))
+	assert.NoError(t, ValidatePackageArchitectures(packages[1:], "aarch64"))
+}
+
+func TestValidatePackageArchitecturesMismatch(t *testing.T) {
+	packages := []*RepoPackage{
+		{Name: "bash", Version: "5.2.15-3", Architecture: "x86_64", Distribution: "azl3"},
+		{Name: "zlib", Version: "1.3.1-1", Architecture: "aarch64", Distribution: "azl3"},
+		{Name: "glibc", Version: "2.38-8", Architecture: "aarch64", Distribution: "azl3"},
+		{Name: "python3-six", Version: "1.16.0-4", Architecture: "noarch", Distribution: "azl3"},
+		{Name: "python3-six", Version: "1.16.0-4", Architecture: "x86_64", Distribution: "azl3"},
+	}
+
+	err := ValidatePackageArchitectures(packages, "x86_64")
+	assert.EqualError(t, err, "package architecture mismatch:\n"+
+		"2 package(s) don't match the target architecture (x86_64):\n"+
+		"  glibc-2.38-8.azl3.aarch64\n"+
+		"  zlib-1.3.1-1.azl3.aarch64\n"+
+		"1 package(s) were resolved as both noarch and architecture-specific packages:\n"+
+		"  python3-six")
+}
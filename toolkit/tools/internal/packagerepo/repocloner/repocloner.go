@@ -16,10 +16,12 @@ type RepoContents struct {
 
 // RepoPackage represents a package in a repo.
 type RepoPackage struct {
-	Name         string `json:"Name"`         // Name of the package
-	Version      string `json:"Version"`      // Version number of the package
-	Architecture string `json:"Architecture"` // Architecture of the package
-	Distribution string `json:"Distribution"` // Distribution tag of the package
+	Name         string `json:"Name"`            // Name of the package
+	Version      string `json:"Version"`         // Version number of the package
+	Architecture string `json:"Architecture"`    // Architecture of the package
+	Distribution string `json:"Distribution"`    // Distribution tag of the package
+	Epoch        string `json:"Epoch,omitempty"` // Epoch of the package, empty if it has none
+	RepoID       string `json:"-"`               // ID of the repository the package was listed from, if known
 }
 
 // RepoCloner is an interface for a package repository cloner.
@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package rpmrepocloner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm/repodata"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/tdnf"
+)
+
+const (
+	// chrootTdnfCacheDir is where tdnf keeps the metadata of the repositories inside the chroot.
+	chrootTdnfCacheDir = "/var/cache/tdnf"
+	sha256ChecksumType = "sha256"
+)
+
+// SetPackageCache makes the cloner take the packages it would download from the upstream repositories from the shared
+// package cache first, if the cache has an RPM matching the checksum published by the repository.
+func (r *RpmRepoCloner) SetPackageCache(cache *packagecache.Cache) {
+	r.packageCache = cache
+	r.upstreamDigests = nil
+}
+
+// fetchCachedPackages resolves the packages that cloning packageNames would download and copies the ones found in the
+// package cache to the clone directory. tdnf doesn't download the packages already present in its download directory.
+// Failing to resolve the packages isn't an error: the download reports it.
+func (r *RpmRepoCloner) fetchCachedPackages(resolveArgs, packageNames []string) (err error) {
+	var transaction *tdnf.Transaction
+
+	// Gradually enable more repos the same way clonePackage() does, so that the packages are resolved from the repos
+	// the download will use.
+	err = r.chroot.Run(func() (chrootErr error) {
+		releaseverCliArg, chrootErr := tdnf.GetReleaseverCliArg()
+		if chrootErr != nil {
+			return
+		}
+
+		for _, reposArgs := range r.reposArgsList {
+			var resolveErr error
+
+			finalResolveArgs := append(append(append([]string(nil), resolveArgs...), releaseverCliArg), reposArgs...)
+			transaction, resolveErr = tdnf.ResolveInstall(finalResolveArgs, packageNames...)
+			if resolveErr == nil {
+				break
+			}
+			logger.Log.Debugf("Failed to resolve packages for the package cache: %s", resolveErr)
+		}
+		return
+	})
+	if err != nil || transaction == nil {
+		return
+	}
+
+	if r.upstreamDigests == nil {
+		r.upstreamDigests, err = readUpstreamDigests(filepath.Join(r.chroot.RootDir(), chrootTdnfCacheDir))
+		if err != nil {
+			return
+		}
+	}
+
+	downloadDir := filepath.Join(r.chroot.RootDir(), r.chrootCloneDir)
+	fetched := 0
+	for _, transactionPackage := range transaction.Packages {
+		if transactionPackage.Action == tdnf.ActionRemove || transactionPackage.Action == tdnf.ActionObsolete {
+			continue
+		}
+
+		if r.isLocalRepo(transactionPackage.Repo) {
+			continue
+		}
+
+		fileName := transactionPackageFileName(transactionPackage)
+		digest, found := r.upstreamDigests[fileName]
+		if !found {
+			continue
+		}
+
+		destinationPath := filepath.Join(downloadDir, fileName)
+
+		exists, err := file.PathExists(destinationPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		found, err = r.packageCache.Fetch(digest, destinationPath)
+		if err != nil {
+			return err
+		}
+		if found {
+			fetched++
+		}
+	}
+
+	logger.Log.Debugf("Took %d of %d resolved packages from the package cache.", fetched, len(transaction.Packages))
+
+	return
+}
+
+// isLocalRepo reports if a repository is one of the local repositories, which are never taken from the package cache.
+func (r *RpmRepoCloner) isLocalRepo(repoID string) bool {
+	return repoID == repoIDBuilt || repoID == repoIDToolchain || repoID == r.repoIDCache || repoID == repoIDCacheRegular
+}
+
+// readUpstreamDigests maps the file names of the packages of the repositories cached by tdnf to their SHA256 checksums.
+// File names published with different checksums by different repositories are left out, since the package tdnf picks
+// can't be told apart by its name.
+func readUpstreamDigests(tdnfCacheDir string) (digests map[string]string, err error) {
+	repoMDFiles, err := filepath.Glob(filepath.Join(tdnfCacheDir, "*", repodata.RepoDataDir, "repomd.xml"))
+	if err != nil {
+		return
+	}
+
+	digests = make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for _, repoMDFile := range repoMDFiles {
+		repoDir := filepath.Dir(filepath.Dir(repoMDFile))
+
+		repository, readErr := repodata.ReadRepo(repoDir)
+		if readErr != nil {
+			logger.Log.Debugf("Can't use the packages of (%s) from the package cache: %s", repoDir, readErr)
+			continue
+		}
+
+		for _, repoPackage := range repository.Primary.Packages {
+			if repoPackage.Location == nil || repoPackage.Checksum == nil || repoPackage.Checksum.Type != sha256ChecksumType {
+				continue
+			}
+
+			fileName := filepath.Base(repoPackage.Location.Href)
+			digest := strings.ToLower(repoPackage.Checksum.Value)
+
+			previous, found := digests[fileName]
+			if found && previous != digest {
+				ambiguous[fileName] = true
+			}
+			digests[fileName] = digest
+		}
+	}
+
+	for fileName := range ambiguous {
+		delete(digests, fileName)
+	}
+
+	logger.Log.Debugf("Found the checksums of %d upstream packages in (%s).", len(digests), tdnfCacheDir)
+
+	return
+}
+
+// transactionPackageFileName returns the file name of the RPM of a package resolved by tdnf.
+func transactionPackageFileName(transactionPackage *tdnf.TransactionPackage) string {
+	version := transactionPackage.Version
+	if index := strings.Index(version, ":"); index >= 0 {
+		version = version[index+1:]
+	}
+
+	return fmt.Sprintf("%s-%s.%s.rpm", transactionPackage.Name, version, transactionPackage.Architecture)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package rpmrepocloner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm/repodata"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/tdnf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func writeTestRepoMetadata(t *testing.T, repoDir string, checksums map[string]string) {
+	primary := &repodata.Primary{Packages: []*repodata.Package{}}
+	for fileName, checksum := range checksums {
+		primary.Packages = append(primary.Packages, &repodata.Package{
+			Type:     "rpm",
+			Location: &repodata.Location{Href: "Packages/" + fileName},
+			Checksum: &repodata.Checksum{Type: sha256ChecksumType, PkgID: "YES", Value: checksum},
+		})
+	}
+
+	err := repodata.WriteRepo(repoDir, &repodata.Repository{Primary: primary}, repodata.NoCompression)
+	require.NoError(t, err)
+}
+
+func TestReadUpstreamDigests(t *testing.T) {
+	tdnfCacheDir := t.TempDir()
+
+	writeTestRepoMetadata(t, filepath.Join(tdnfCacheDir, "base"), map[string]string{
+		"bash-5.2.15-3.azl3.x86_64.rpm": "AAAA",
+		"zlib-1.3.1-1.azl3.x86_64.rpm":  "bbbb",
+	})
+	writeTestRepoMetadata(t, filepath.Join(tdnfCacheDir, "extended"), map[string]string{
+		"bash-5.2.15-3.azl3.x86_64.rpm": "aaaa",
+		"zlib-1.3.1-1.azl3.x86_64.rpm":  "cccc",
+	})
+
+	digests, err := readUpstreamDigests(tdnfCacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"bash-5.2.15-3.azl3.x86_64.rpm": "aaaa"}, digests)
+}
+
+func TestTransactionPackageFileName(t *testing.T) {
+	assert.Equal(t, "bash-5.2.15-3.azl3.x86_64.rpm", transactionPackageFileName(&tdnf.TransactionPackage{
+		Name: "bash", Architecture: "x86_64", Version: "5.2.15-3.azl3",
+	}))
+	assert.Equal(t, "shadow-utils-4.14.3-1.azl3.x86_64.rpm", transactionPackageFileName(&tdnf.TransactionPackage{
+		Name: "shadow-utils", Architecture: "x86_64", Version: "2:4.14.3-1.azl3",
+	}))
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/buildpipeline"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repomanager/rpmrepomanager"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
@@ -70,6 +71,10 @@ type RpmRepoCloner struct {
 	repoIDCache              string
 	reposArgsList            [][]string
 	reposFlags               uint64
+	targetArch               string
+	packageCache             *packagecache.Cache
+	// upstreamDigests maps the file names of the upstream packages to their checksums, read on first use.
+	upstreamDigests map[string]string
 }
 
 // ConstructCloner constructs a new RpmRepoCloner.
@@ -114,9 +119,6 @@ func (r *RpmRepoCloner) initialize(destinationDir, tmpDir, workerTar, existingRp
 		isExistingDir          = false
 		leaveChrootFilesOnDisk = false
 
-		bindFsType = ""
-		bindData   = ""
-
 		chrootLocalRpmsDir      = "/localrpms"
 		chrootLocalToolchainDir = "/toolchainrpms"
 
@@ -163,10 +165,19 @@ func (r *RpmRepoCloner) initialize(destinationDir, tmpDir, workerTar, existingRp
 	//
 	// 2) Mount the directory to download RPMs into as a bind, allowing the chroot to write
 	// files into it.
+	cloneDirMount, err := safechroot.NewMountPointFromSpec(safechroot.MountSpec{
+		Source: destinationDir,
+		Target: chrootCloneDirRegular,
+		Bind:   true,
+	})
+	if err != nil {
+		return
+	}
+
 	outRpmsOverlayMount, overlayExtraDirs := safechroot.NewOverlayMountPoint(r.chroot.RootDir(), overlaySource, chrootLocalRpmsDir, existingRpmsDir, overlayUpperDirectoryRpms, overlayWorkDirectoryRpms)
 	extraMountPoints := []*safechroot.MountPoint{
 		outRpmsOverlayMount,
-		safechroot.NewMountPoint(destinationDir, chrootCloneDirRegular, bindFsType, safechroot.BindMountPointFlags, bindData),
+		cloneDirMount,
 	}
 
 	// Include the special toolchain packages directory.
@@ -406,8 +417,12 @@ func (r *RpmRepoCloner) cloneRawPackageNames(cloneDeps, singleTransaction bool,
 		r.chrootCloneDir,
 	}
 
+	// Resolves the packages without downloading them, so that their architectures can be checked first.
+	resolveArgs := []string{}
+
 	if r.GetRepoSnapshotTime() != "" {
 		constantArgs = append(constantArgs, r.GetRepoSnapshotArgs()...)
+		resolveArgs = append(resolveArgs, r.GetRepoSnapshotArgs()...)
 	}
 
 	logger.Log.Debugf("Will clone in total %d items.", len(rawPackageNames))
@@ -427,9 +442,16 @@ func (r *RpmRepoCloner) cloneRawPackageNames(cloneDeps, singleTransaction bool,
 	for _, packageNamesToClone := range transactions {
 		logger.Log.Debugf("Cloning raw names (%v).", packageNamesToClone)
 
+		if r.packageCache != nil && RepoFlagUpstream&r.reposFlags != 0 {
+			err = r.fetchCachedPackages(resolveArgs, packageNamesToClone)
+			if err != nil {
+				return
+			}
+		}
+
 		finalArgs := append(constantArgs, packageNamesToClone...)
 		err = r.chroot.Run(func() (chrootErr error) {
-			prebuilt, chrootErr := r.clonePackage(finalArgs)
+			prebuilt, chrootErr := r.clonePackage(finalArgs, resolveArgs, packageNamesToClone)
 			if !prebuilt {
 				allPackagesPrebuilt = false
 			}
@@ -579,6 +601,8 @@ func (r *RpmRepoCloner) ClonedRepoContents() (repoContents *repocloner.RepoConte
 			Version:      matches[tdnf.ListedPackageVersion],
 			Architecture: matches[tdnf.ListedPackageArch],
 			Distribution: matches[tdnf.ListedPackageDist],
+			Epoch:        matches[tdnf.ListedPackageEpoch],
+			RepoID:       matches[tdnf.ListedPackageRepoID],
 		}
 
 		pkgID := pkg.ID()
@@ -626,7 +650,9 @@ func (r *RpmRepoCloner) Close() error {
 
 // clonePackage clones a given package using pre-populated arguments.
 // It will gradually enable more repos to consider until the package is found.
-func (r *RpmRepoCloner) clonePackage(baseArgs []string) (preBuilt bool, err error) {
+// If a target architecture is set, packageNames are resolved with resolveArgs and their architectures are checked
+// before they are downloaded.
+func (r *RpmRepoCloner) clonePackage(baseArgs, resolveArgs, packageNames []string) (preBuilt bool, err error) {
 
 	releaseverCliArg, err := tdnf.GetReleaseverCliArg()
 	if err != nil {
@@ -640,6 +666,20 @@ func (r *RpmRepoCloner) clonePackage(baseArgs []string) (preBuilt bool, err erro
 
 		finalArgs := append(baseArgs, reposArgs...)
 
+		if r.targetArch != "" {
+			finalResolveArgs := append(append(append([]string(nil), resolveArgs...), releaseverCliArg), reposArgs...)
+			transaction, resolveErr := tdnf.ResolveInstall(finalResolveArgs, packageNames...)
+			if resolveErr != nil {
+				// The download will fail in the same way, which moves on to the next set of repos.
+				logger.Log.Debugf("Failed to resolve packages: %s", resolveErr)
+			} else {
+				err = repocloner.ValidatePackageArchitectures(repocloner.RepoPackagesFromTransaction(transaction), r.targetArch)
+				if err != nil {
+					return
+				}
+			}
+		}
+
 		// We run in a retry loop on errors deemed retriable.
 		ctx, closeCtx := context.WithCancel(context.Background())
 		defer closeCtx()
@@ -697,6 +737,12 @@ func convertPackageVersionToTdnfArg(pkgVer *pkgjson.PackageVer) (tdnfArg string)
 	return
 }
 
+// SetTargetArch sets the RPM architecture (e.g. "x86_64") that the cloned packages must match. If set, the packages
+// are resolved before they are downloaded, and the clone fails if any of them are for a different architecture.
+func (r *RpmRepoCloner) SetTargetArch(targetArch string) {
+	r.targetArch = targetArch
+}
+
 func (r *RpmRepoCloner) GetRepoSnapshotTime() string {
 	return r.repoSnapshotTime
 }
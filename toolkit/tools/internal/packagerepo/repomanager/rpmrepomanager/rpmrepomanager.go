@@ -5,71 +5,42 @@ package rpmrepomanager
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
-	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repoutils"
-	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm/repodata"
 )
 
 // CreateRepo will create an RPM repository at repoDir
 func CreateRepo(repoDir string) (err error) {
-	const (
-		repoDataSubDir = "repodata"
-		repoLockSubDir = ".repodata"
-	)
-
 	logger.Log.Debugf("Creating RPM repository in (%s)", repoDir)
 
-	repoDataPath := filepath.Join(repoDir, repoDataSubDir)
-	repoDataLockPath := filepath.Join(repoDir, repoLockSubDir)
-
-	// Remove the repodata (and the repolock) if exists
-	err = os.RemoveAll(repoDataPath)
-	if err != nil && !os.IsNotExist(err) {
-		return
-	}
-
-	err = os.RemoveAll(repoDataLockPath)
-	if err != nil && !os.IsNotExist(err) {
-		return
-	}
-
-	createRepoCmd, err := repoutils.FindCreateRepoCommand()
+	err = repodata.CreateRepo(repoDir, repodata.Options{Compression: repodata.GzipCompression})
 	if err != nil {
 		return fmt.Errorf("unable to create repo:\n%w", err)
 	}
 
-	// Create a new repodata
-	_, stderr, err := shell.Execute(createRepoCmd, "--compatibility", repoDir)
-	if err != nil {
-		logger.Log.Warn(stderr)
-	}
-
 	return
 }
 
 // CreateOrUpdateRepo will create an RPM repository at repoDir or update
 // it if the metadata files already exist.
 func CreateOrUpdateRepo(repoDir string) (err error) {
-	// Check if createrepo command is available
-	createRepoCmd, err := repoutils.FindCreateRepoCommand()
-	if err != nil {
-		return fmt.Errorf("unable to create repo:\n%w", err)
-	}
+	const update = true
+
+	logger.Log.Debugf("Creating or updating RPM repository in (%s)", repoDir)
 
-	// Create or update repodata
-	_, stderr, err := shell.Execute(createRepoCmd, "--compatibility", "--update", repoDir)
+	err = repodata.CreateRepo(repoDir, repodata.Options{Compression: repodata.GzipCompression, Update: update})
 	if err != nil {
-		logger.Log.Warn(stderr)
+		return fmt.Errorf("unable to update repo:\n%w", err)
 	}
 
 	return
 }
 
-// ValidateRpmPaths checks for any rpm filenames in the cache that don't match the expected output according to 'rpm -qp ...'.  It
+// ValidateRpmPaths checks for any rpm filenames in the cache that don't match the expected output according to their headers.  It
 // will return an error with all the mismatched pairs if it finds any.
 func ValidateRpmPaths(repoDir string) (err error) {
 	rpmSearch := filepath.Join(repoDir, "*.rpm")
@@ -83,13 +54,12 @@ func ValidateRpmPaths(repoDir string) (err error) {
 
 	for _, rpmFile := range rpmFiles {
 		// rpmFile is the real RPM filename on disk.
-		// use rpm cli to check the rpmFile's reported package name
+		// read the rpmFile's header to check its reported package name
 		// print a warning if the filename does not match the package name
-		var stdout, stderr string
-		stdout, stderr, err = shell.Execute("rpm", "-qp", rpmFile)
+		var packageHeader *rpm.PackageHeader
+		packageHeader, err = rpm.ReadPackageHeader(rpmFile)
 		if err == nil {
-			calculatedRpmName := strings.Split(stdout, "\n")
-			calculatedRpmFilename := fmt.Sprintf("%s.rpm", calculatedRpmName[0])
+			calculatedRpmFilename := packageHeader.FileName()
 			if calculatedRpmFilename != filepath.Base(rpmFile) {
 				logger.Log.Warnf("!!!!! Detected mismatched filename !!!!!!")
 				logger.Log.Warnf("---- filename   == '%s'", filepath.Base(rpmFile))
@@ -97,7 +67,7 @@ func ValidateRpmPaths(repoDir string) (err error) {
 				validationErrors = append(validationErrors, fmt.Sprintf("'%s' != '%s'", filepath.Base(rpmFile), calculatedRpmFilename))
 			}
 		} else {
-			err = fmt.Errorf("failed to validate rpm file '%s': '%s'", rpmFile, stderr)
+			err = fmt.Errorf("failed to validate rpm file '%s':\n%w", rpmFile, err)
 			return
 		}
 	}
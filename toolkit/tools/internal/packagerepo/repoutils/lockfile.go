@@ -0,0 +1,433 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repoutils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+)
+
+// LockfileVersion is the version of the lockfile format written by SaveLockfile.
+const LockfileVersion = 1
+
+// Lockfile pins the exact packages fetched for an image config.
+type Lockfile struct {
+	Version  int              `json:"Version"`
+	Packages []*LockedPackage `json:"Packages"`
+}
+
+// LockedPackage is a package pinned by a lockfile, down to the checksum of its RPM file.
+//
+// Local packages, built by the toolkit instead of downloaded from a repository, are rebuilt with different
+// checksums on every build, so they are only pinned by their NEVRA.
+type LockedPackage struct {
+	Name         string `json:"Name"`
+	Epoch        string `json:"Epoch,omitempty"`
+	Version      string `json:"Version"`
+	Release      string `json:"Release"`
+	Architecture string `json:"Architecture"`
+	FileName     string `json:"FileName"`
+	SHA256       string `json:"SHA256,omitempty"`
+	Local        bool   `json:"Local,omitempty"`
+}
+
+// NEVRA returns the name, epoch, version, release and architecture of the package.
+func (p *LockedPackage) NEVRA() string {
+	return fmt.Sprintf("%s-%s.%s", p.Name, p.evr(), p.Architecture)
+}
+
+// evr returns the version of the package in the format accepted by tdnf, including the epoch if it's set.
+func (p *LockedPackage) evr() string {
+	if p.Epoch == "" || p.Epoch == "0" {
+		return fmt.Sprintf("%s-%s", p.Version, p.Release)
+	}
+
+	return fmt.Sprintf("%s:%s-%s", p.Epoch, p.Version, p.Release)
+}
+
+// systemRepoID is the ID under which tdnf lists the packages installed in the chroot of the cloner.
+const systemRepoID = "@System"
+
+// SaveLockfile writes a lockfile of the packages resolved by the cloner to `dstFile`.
+//
+// Only the packages of the cloned repository are locked, leftover RPMs of the clone directory are ignored. The
+// packages whose RPMs are also found in `localRPMDirs` are pinned by their NEVRA only.
+func SaveLockfile(cloner repocloner.RepoCloner, dstFile string, localRPMDirs ...string) (err error) {
+	timestamp.StartEvent("saving lockfile", nil)
+	defer timestamp.StopEvent(nil)
+
+	repoContents, err := cloner.ClonedRepoContents()
+	if err != nil {
+		return fmt.Errorf("failed to list the cloned packages:\n%w", err)
+	}
+
+	localFiles, err := findLocalRPMFiles(localRPMDirs)
+	if err != nil {
+		return
+	}
+
+	clonedPackages, err := lockPackages(cloner.CloneDirectory(), localFiles)
+	if err != nil {
+		return
+	}
+
+	lockfile, err := buildLockfile(repoContents, clonedPackages)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Saving lockfile of %d packages to (%s).", len(lockfile.Packages), dstFile)
+
+	return jsonutils.WriteJSONFile(dstFile, lockfile)
+}
+
+// buildLockfile locks the cloned packages which belong to the packages of repoContents.
+//
+// The packages installed in the chroot of the cloner are also listed by tdnf, under the '@System' repository. They
+// are locked if their RPMs were cloned, but unlike the packages of the cloned repository they may have none.
+func buildLockfile(repoContents *repocloner.RepoContents, clonedPackages []*LockedPackage) (lockfile *Lockfile, err error) {
+	resolvedPackages := make(map[string]bool, len(repoContents.Repo))
+	for _, repoPackage := range repoContents.Repo {
+		resolvedPackages[repoPackageID(repoPackage)] = repoPackage.RepoID != systemRepoID
+	}
+
+	lockfile = &Lockfile{Version: LockfileVersion}
+	for _, lockedPackage := range clonedPackages {
+		packageID := lockedPackage.repoPackageID()
+
+		if _, found := resolvedPackages[packageID]; !found {
+			logger.Log.Debugf("Package (%s) isn't part of the cloned repository, not locking it.", lockedPackage.FileName)
+			continue
+		}
+		delete(resolvedPackages, packageID)
+
+		lockfile.Packages = append(lockfile.Packages, lockedPackage)
+	}
+
+	missingPackages := []string{}
+	for packageID, requiresRPM := range resolvedPackages {
+		if requiresRPM {
+			missingPackages = append(missingPackages, packageID)
+		}
+	}
+
+	if len(missingPackages) > 0 {
+		sort.Strings(missingPackages)
+		return nil, fmt.Errorf("no RPM found for the cloned packages:\n%s", strings.Join(missingPackages, "\n"))
+	}
+
+	return
+}
+
+// SyncFromLockfile makes the cloner's clone directory contain exactly the packages of the lockfile at `srcFile`,
+// even if the repositories have newer versions of them.
+//
+// Only the differences are synchronized: RPMs already present with the locked checksum are kept, RPMs which
+// aren't locked or don't match their checksum are removed, and missing packages are taken from the shared package
+// cache in `cacheDir` (if set) or downloaded by their exact version. The downloaded RPMs must match the locked
+// checksums. Local packages are only matched by their file name.
+func SyncFromLockfile(cloner repocloner.RepoCloner, srcFile, cacheDir string) (err error) {
+	const cloneDeps = false
+
+	timestamp.StartEvent("syncing from lockfile", nil)
+	defer timestamp.StopEvent(nil)
+
+	logger.Log.Infof("Synchronizing cloned packages with lockfile (%s).", srcFile)
+
+	lockfile := &Lockfile{}
+	err = jsonutils.ReadJSONFile(srcFile, lockfile)
+	if err != nil {
+		return
+	}
+
+	if lockfile.Version != LockfileVersion {
+		return fmt.Errorf("unsupported lockfile version (%d) in (%s), expected (%d)", lockfile.Version, srcFile, LockfileVersion)
+	}
+
+	missingPackages, err := pruneUnlockedPackages(lockfile, cloner.CloneDirectory())
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("%d of %d locked packages are missing.", len(missingPackages), len(lockfile.Packages))
+
+	if len(missingPackages) > 0 && cacheDir != "" {
+		missingPackages, err = fetchCachedPackages(missingPackages, cacheDir, cloner.CloneDirectory())
+		if err != nil {
+			return
+		}
+	}
+
+	if len(missingPackages) > 0 {
+		_, err = cloner.CloneByPackageVer(cloneDeps, lockedPackageVers(missingPackages)...)
+		if err != nil {
+			return
+		}
+	}
+
+	return verifyLockedPackages(lockfile, cloner.CloneDirectory())
+}
+
+// pruneUnlockedPackages removes the RPMs of cloneDirectory which don't match a package of the lockfile, and returns
+// the locked packages that must be downloaded.
+func pruneUnlockedPackages(lockfile *Lockfile, cloneDirectory string) (missingPackages []*LockedPackage, err error) {
+	rpmFiles, err := findRPMFiles(cloneDirectory)
+	if err != nil {
+		return
+	}
+
+	lockedFiles := make(map[string]*LockedPackage, len(lockfile.Packages))
+	for _, lockedPackage := range lockfile.Packages {
+		lockedFiles[lockedPackage.FileName] = lockedPackage
+	}
+
+	presentFiles := make(map[string]bool)
+	for _, rpmFile := range rpmFiles {
+		fileName := filepath.Base(rpmFile)
+
+		lockedPackage, found := lockedFiles[fileName]
+		if found && lockedPackage.Local {
+			logger.Log.Debugf("Local package (%s) matches the lockfile, keeping it.", fileName)
+			presentFiles[fileName] = true
+			continue
+		}
+
+		if found {
+			var checksum string
+
+			checksum, err = file.GenerateSHA256(rpmFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to calculate the checksum of (%s):\n%w", rpmFile, err)
+			}
+
+			if checksum == lockedPackage.SHA256 {
+				logger.Log.Debugf("Package (%s) matches the lockfile, keeping it.", fileName)
+				presentFiles[fileName] = true
+				continue
+			}
+
+			logger.Log.Infof("Package (%s) doesn't match the locked checksum, removing it.", fileName)
+		} else {
+			logger.Log.Infof("Package (%s) isn't locked, removing it.", fileName)
+		}
+
+		err = os.Remove(rpmFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove (%s):\n%w", rpmFile, err)
+		}
+	}
+
+	for _, lockedPackage := range lockfile.Packages {
+		if !presentFiles[lockedPackage.FileName] {
+			missingPackages = append(missingPackages, lockedPackage)
+		}
+	}
+
+	return
+}
+
+// verifyLockedPackages checks that cloneDirectory contains exactly the RPMs of the lockfile.
+func verifyLockedPackages(lockfile *Lockfile, cloneDirectory string) (err error) {
+	rpmFiles, err := findRPMFiles(cloneDirectory)
+	if err != nil {
+		return
+	}
+
+	checksums := make(map[string]string, len(rpmFiles))
+	for _, rpmFile := range rpmFiles {
+		checksums[filepath.Base(rpmFile)], err = file.GenerateSHA256(rpmFile)
+		if err != nil {
+			return fmt.Errorf("failed to calculate the checksum of (%s):\n%w", rpmFile, err)
+		}
+	}
+
+	problems := []string{}
+	for _, lockedPackage := range lockfile.Packages {
+		checksum, found := checksums[lockedPackage.FileName]
+		delete(checksums, lockedPackage.FileName)
+
+		switch {
+		case !found:
+			problems = append(problems, fmt.Sprintf("missing (%s)", lockedPackage.NEVRA()))
+		case lockedPackage.Local:
+			continue
+		case checksum != lockedPackage.SHA256:
+			problems = append(problems, fmt.Sprintf("checksum mismatch for (%s): expected (%s), got (%s)", lockedPackage.FileName, lockedPackage.SHA256, checksum))
+		}
+	}
+
+	for fileName := range checksums {
+		problems = append(problems, fmt.Sprintf("unexpected (%s)", fileName))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("cloned packages don't match the lockfile:\n%s", strings.Join(problems, "\n"))
+	}
+
+	logger.Log.Infof("Cloned packages match the lockfile.")
+
+	return
+}
+
+// fetchCachedPackages copies the locked packages found in the shared package cache to cloneDirectory, and returns the
+// packages which must still be downloaded.
+func fetchCachedPackages(lockedPackages []*LockedPackage, cacheDir, cloneDirectory string) (missingPackages []*LockedPackage, err error) {
+	cache, err := packagecache.Open(cacheDir)
+	if err != nil {
+		return
+	}
+
+	for _, lockedPackage := range lockedPackages {
+		var found bool
+
+		if lockedPackage.Local {
+			missingPackages = append(missingPackages, lockedPackage)
+			continue
+		}
+
+		found, err = cache.Fetch(lockedPackage.SHA256, filepath.Join(cloneDirectory, lockedPackage.FileName))
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			missingPackages = append(missingPackages, lockedPackage)
+		}
+	}
+
+	logger.Log.Infof("Took %d locked packages from the package cache (%s).", len(lockedPackages)-len(missingPackages), cacheDir)
+
+	return
+}
+
+// lockedPackageVers returns the PackageVers pointing at the exact versions of the locked packages.
+func lockedPackageVers(lockedPackages []*LockedPackage) (packageVers []*pkgjson.PackageVer) {
+	const packageCondition = "="
+
+	for _, lockedPackage := range lockedPackages {
+		packageVers = append(packageVers, &pkgjson.PackageVer{
+			Name:      lockedPackage.Name,
+			Version:   lockedPackage.evr(),
+			Condition: packageCondition,
+		})
+	}
+
+	return
+}
+
+// repoPackageID returns the ID of the package in the format of repoPackageID().
+func (p *LockedPackage) repoPackageID() string {
+	return fmt.Sprintf("%s-%s.%s", p.Name, p.evr(), p.Architecture)
+}
+
+// repoPackageID returns the ID of a package listed by the cloner. Unlike repocloner.RepoPackage.ID(), it includes the
+// epoch of the package.
+func repoPackageID(repoPackage *repocloner.RepoPackage) string {
+	version := fmt.Sprintf("%s.%s", repoPackage.Version, repoPackage.Distribution)
+	if repoPackage.Epoch != "" && repoPackage.Epoch != "0" {
+		version = fmt.Sprintf("%s:%s", repoPackage.Epoch, version)
+	}
+
+	return fmt.Sprintf("%s-%s.%s", repoPackage.Name, version, repoPackage.Architecture)
+}
+
+// lockPackages locks every RPM of directory, sorted by file name.
+func lockPackages(directory string, localFiles map[string]bool) (lockedPackages []*LockedPackage, err error) {
+	rpmFiles, err := findRPMFiles(directory)
+	if err != nil {
+		return
+	}
+
+	for _, rpmFile := range rpmFiles {
+		var lockedPackage *LockedPackage
+
+		lockedPackage, err = lockPackage(rpmFile, localFiles[filepath.Base(rpmFile)])
+		if err != nil {
+			return nil, err
+		}
+
+		lockedPackages = append(lockedPackages, lockedPackage)
+	}
+
+	return
+}
+
+// lockPackage reads the header of an RPM to pin its package. Local packages are pinned without their checksum.
+func lockPackage(rpmFile string, local bool) (lockedPackage *LockedPackage, err error) {
+	packageHeader, err := rpm.ReadPackageHeader(rpmFile)
+	if err != nil {
+		return
+	}
+
+	lockedPackage = &LockedPackage{
+		Name:         packageHeader.Name,
+		Epoch:        packageHeader.Epoch,
+		Version:      packageHeader.Version,
+		Release:      packageHeader.Release,
+		Architecture: packageHeader.Arch,
+		FileName:     filepath.Base(rpmFile),
+		Local:        local,
+	}
+
+	if local {
+		return
+	}
+
+	lockedPackage.SHA256, err = file.GenerateSHA256(rpmFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate the checksum of (%s):\n%w", rpmFile, err)
+	}
+
+	return
+}
+
+// findLocalRPMFiles returns the file names of the RPMs found anywhere under the local RPM directories.
+func findLocalRPMFiles(localRPMDirs []string) (localFiles map[string]bool, err error) {
+	localFiles = make(map[string]bool)
+
+	for _, localRPMDir := range localRPMDirs {
+		err = filepath.WalkDir(localRPMDir, func(path string, entry fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".rpm") {
+				localFiles[entry.Name()] = true
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the local RPMs of (%s):\n%w", localRPMDir, err)
+		}
+	}
+
+	return
+}
+
+// findRPMFiles returns the sorted paths of the RPMs in directory.
+func findRPMFiles(directory string) (rpmFiles []string, err error) {
+	rpmFiles, err = filepath.Glob(filepath.Join(directory, "*.rpm"))
+	if err != nil {
+		return
+	}
+
+	sort.Strings(rpmFiles)
+
+	return
+}
@@ -0,0 +1,166 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repoutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// writeTestRPM writes a fake RPM file and returns the package locking it.
+func writeTestRPM(t *testing.T, directory, name, content string) *LockedPackage {
+	rpmFile := filepath.Join(directory, name+"-1.0-1.azl3.x86_64.rpm")
+	err := os.WriteFile(rpmFile, []byte(content), 0o644)
+	require.NoError(t, err)
+
+	checksum, err := file.GenerateSHA256(rpmFile)
+	require.NoError(t, err)
+
+	return &LockedPackage{
+		Name:         name,
+		Version:      "1.0",
+		Release:      "1.azl3",
+		Architecture: "x86_64",
+		FileName:     filepath.Base(rpmFile),
+		SHA256:       checksum,
+	}
+}
+
+func TestPruneUnlockedPackages(t *testing.T) {
+	cloneDir := t.TempDir()
+
+	kept := writeTestRPM(t, cloneDir, "kept", "kept")
+	changed := writeTestRPM(t, cloneDir, "changed", "rebuilt")
+	changed.SHA256 = "0000"
+	unlocked := writeTestRPM(t, cloneDir, "unlocked", "unlocked")
+	missing := &LockedPackage{Name: "missing", Version: "1.0", Release: "1.azl3", Architecture: "noarch", FileName: "missing-1.0-1.azl3.noarch.rpm"}
+
+	lockfile := &Lockfile{Version: LockfileVersion, Packages: []*LockedPackage{kept, changed, missing}}
+
+	missingPackages, err := pruneUnlockedPackages(lockfile, cloneDir)
+	require.NoError(t, err)
+	assert.Equal(t, []*LockedPackage{changed, missing}, missingPackages)
+
+	exists, err := file.PathExists(filepath.Join(cloneDir, kept.FileName))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	for _, removed := range []*LockedPackage{changed, unlocked} {
+		exists, err = file.PathExists(filepath.Join(cloneDir, removed.FileName))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	}
+}
+
+func TestVerifyLockedPackages(t *testing.T) {
+	cloneDir := t.TempDir()
+
+	locked := writeTestRPM(t, cloneDir, "locked", "locked")
+	lockfile := &Lockfile{Version: LockfileVersion, Packages: []*LockedPackage{locked}}
+	assert.NoError(t, verifyLockedPackages(lockfile, cloneDir))
+
+	writeTestRPM(t, cloneDir, "extra", "extra")
+	lockfile.Packages = append(lockfile.Packages, &LockedPackage{Name: "missing", Epoch: "2", Version: "1.0", Release: "1.azl3", Architecture: "noarch", FileName: "missing-1.0-1.azl3.noarch.rpm"})
+	locked.SHA256 = "0000"
+
+	err := verifyLockedPackages(lockfile, cloneDir)
+	assert.ErrorContains(t, err, "checksum mismatch for (locked-1.0-1.azl3.x86_64.rpm)")
+	assert.ErrorContains(t, err, "missing (missing-2:1.0-1.azl3.noarch)")
+	assert.ErrorContains(t, err, "unexpected (extra-1.0-1.azl3.x86_64.rpm)")
+}
+
+func TestLocalPackagesArePinnedByNEVRA(t *testing.T) {
+	cloneDir := t.TempDir()
+
+	local := writeTestRPM(t, cloneDir, "local", "rebuilt")
+	local.SHA256 = ""
+	local.Local = true
+	lockfile := &Lockfile{Version: LockfileVersion, Packages: []*LockedPackage{local}}
+
+	missingPackages, err := pruneUnlockedPackages(lockfile, cloneDir)
+	require.NoError(t, err)
+	assert.Empty(t, missingPackages)
+	assert.NoError(t, verifyLockedPackages(lockfile, cloneDir))
+
+	missingPackages, err = fetchCachedPackages([]*LockedPackage{local}, t.TempDir(), cloneDir)
+	require.NoError(t, err)
+	assert.Equal(t, []*LockedPackage{local}, missingPackages)
+}
+
+func TestFindLocalRPMFiles(t *testing.T) {
+	rpmDir := t.TempDir()
+	toolchainDir := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(rpmDir, "x86_64"), 0o755))
+	writeTestRPM(t, filepath.Join(rpmDir, "x86_64"), "built", "built")
+	writeTestRPM(t, toolchainDir, "toolchain", "toolchain")
+	require.NoError(t, os.WriteFile(filepath.Join(rpmDir, "README"), nil, 0o644))
+
+	localFiles, err := findLocalRPMFiles([]string{rpmDir, toolchainDir})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"built-1.0-1.azl3.x86_64.rpm":     true,
+		"toolchain-1.0-1.azl3.x86_64.rpm": true,
+	}, localFiles)
+}
+
+func TestLockedPackageRepoPackageID(t *testing.T) {
+	lockedPackage := &LockedPackage{Name: "bash", Version: "5.2.15", Release: "3.azl3", Architecture: "x86_64"}
+	repoPackage := &repocloner.RepoPackage{Name: "bash", Version: "5.2.15-3", Distribution: "azl3", Architecture: "x86_64"}
+
+	assert.Equal(t, repoPackage.ID(), lockedPackage.repoPackageID())
+	assert.Equal(t, repoPackageID(repoPackage), lockedPackage.repoPackageID())
+
+	lockedPackage = &LockedPackage{Name: "shadow-utils", Epoch: "2", Version: "4.14.3", Release: "1.azl3", Architecture: "x86_64"}
+	repoPackage = &repocloner.RepoPackage{Name: "shadow-utils", Epoch: "2", Version: "4.14.3-1", Distribution: "azl3", Architecture: "x86_64"}
+
+	assert.Equal(t, "shadow-utils-2:4.14.3-1.azl3.x86_64", lockedPackage.repoPackageID())
+	assert.Equal(t, repoPackageID(repoPackage), lockedPackage.repoPackageID())
+}
+
+func TestBuildLockfile(t *testing.T) {
+	bash := &LockedPackage{Name: "bash", Version: "5.2.15", Release: "3.azl3", Architecture: "x86_64", FileName: "bash-5.2.15-3.azl3.x86_64.rpm", SHA256: "1111"}
+	shadowUtils := &LockedPackage{Name: "shadow-utils", Epoch: "2", Version: "4.14.3", Release: "1.azl3", Architecture: "x86_64", FileName: "shadow-utils-4.14.3-1.azl3.x86_64.rpm", SHA256: "2222"}
+	leftover := &LockedPackage{Name: "leftover", Version: "1.0", Release: "1.azl3", Architecture: "noarch", FileName: "leftover-1.0-1.azl3.noarch.rpm", SHA256: "3333"}
+
+	repoContents := &repocloner.RepoContents{Repo: []*repocloner.RepoPackage{
+		{Name: "bash", Version: "5.2.15-3", Distribution: "azl3", Architecture: "x86_64", RepoID: "fetcher-cloned-repo"},
+		{Name: "shadow-utils", Epoch: "2", Version: "4.14.3-1", Distribution: "azl3", Architecture: "x86_64", RepoID: "fetcher-cloned-repo"},
+		// Toolchain package installed in the chroot of the cloner, without an RPM in the clone directory.
+		{Name: "glibc", Version: "2.38-1", Distribution: "azl3", Architecture: "x86_64", RepoID: "@System"},
+	}}
+
+	lockfile, err := buildLockfile(repoContents, []*LockedPackage{bash, leftover, shadowUtils})
+	require.NoError(t, err)
+	assert.Equal(t, &Lockfile{Version: LockfileVersion, Packages: []*LockedPackage{bash, shadowUtils}}, lockfile)
+
+	// Packages of the cloned repository must have an RPM.
+	_, err = buildLockfile(repoContents, []*LockedPackage{bash})
+	assert.ErrorContains(t, err, "no RPM found for the cloned packages:\nshadow-utils-2:4.14.3-1.azl3.x86_64")
+}
+
+func TestLockedPackageVers(t *testing.T) {
+	packageVers := lockedPackageVers([]*LockedPackage{
+		{Name: "bash", Version: "5.2.15", Release: "3.azl3", Architecture: "x86_64"},
+		{Name: "shadow-utils", Epoch: "2", Version: "4.14.3", Release: "1.azl3", Architecture: "x86_64"},
+	})
+
+	assert.Equal(t, []*pkgjson.PackageVer{
+		{Name: "bash", Version: "5.2.15-3.azl3", Condition: "="},
+		{Name: "shadow-utils", Version: "2:4.14.3-1.azl3", Condition: "="},
+	}, packageVers)
+}
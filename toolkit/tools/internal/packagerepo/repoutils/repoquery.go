@@ -26,8 +26,10 @@ const (
 )
 
 // GetAllRepoData returns a map of package names to URLs for all packages
-// available in the given repos. It uses a chroot to run repoquery.
-func GetAllRepoData(repoURLs, repoFiles []string, workerTar, buildDir, repoUrlsFile string) (namesToURLs map[string]string, err error) {
+// available in the given repos. It uses a chroot to run repoquery. A package
+// that is available from several repos (e.g. mirrors) maps to all of its URLs,
+// in the order the repos were queried.
+func GetAllRepoData(repoURLs, repoFiles []string, workerTar, buildDir, repoUrlsFile string) (namesToURLs map[string][]string, err error) {
 	const (
 		leaveChrootOnDisk = false
 	)
@@ -41,7 +43,7 @@ func GetAllRepoData(repoURLs, repoFiles []string, workerTar, buildDir, repoUrlsF
 	}
 	defer queryChroot.Close(leaveChrootOnDisk)
 
-	namesToURLs = make(map[string]string)
+	namesToURLs = make(map[string][]string)
 	allPackageURLs := []string{}
 	for _, repoURL := range repoURLs {
 		// Use the chroot to query each repo for the packages it contains
@@ -88,7 +90,7 @@ func GetAllRepoData(repoURLs, repoFiles []string, workerTar, buildDir, repoUrlsF
 		packageName := path.Base(packageURL)
 		packageName = strings.TrimSuffix(packageName, ".rpm")
 
-		namesToURLs[packageName] = packageURL
+		namesToURLs[packageName] = append(namesToURLs[packageName], packageURL)
 	}
 
 	if repoUrlsFile != "" {
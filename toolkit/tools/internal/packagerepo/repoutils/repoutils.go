@@ -5,12 +5,12 @@ package repoutils
 
 import (
 	"fmt"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
@@ -73,6 +73,21 @@ func SaveClonedRepoContents(cloner repocloner.RepoCloner, dstFile string) (err e
 	return
 }
 
+// StoreClonedPackages stores the packages of the cloner in the shared package cache at `cacheDir`, referenced by
+// `buildID` so that they aren't garbage collected while the build uses them.
+func StoreClonedPackages(cloner repocloner.RepoCloner, cacheDir, buildID string) (err error) {
+	timestamp.StartEvent("storing packages in cache", nil)
+	defer timestamp.StopEvent(nil)
+
+	cache, err := packagecache.Open(cacheDir)
+	if err != nil {
+		return
+	}
+
+	_, err = cache.StoreDirectory(cloner.CloneDirectory(), buildID)
+	return
+}
+
 func removePackageDuplicates(packages []*repocloner.RepoPackage) []*repocloner.RepoPackage {
 	index := 0
 	seen := make(map[string]bool)
@@ -158,24 +173,3 @@ func verifyClonedRepoContents(clonedRepoContents, expectedPackages []*repocloner
 
 	return
 }
-
-// FindCreateRepoCommand searches for createrepo or createrepo_c in $PATH and returns the first one found
-func FindCreateRepoCommand() (cmd string, err error) {
-	creatrepo_cmds := []string{"createrepo_c", "createrepo"}
-
-	selectedCmd := ""
-	// Check if a command exists in the $PATH
-	for _, cmd := range creatrepo_cmds {
-		_, err = exec.LookPath(cmd)
-		if err == nil {
-			selectedCmd = cmd
-			break
-		}
-	}
-
-	if selectedCmd == "" {
-		return "", fmt.Errorf("failed to find a working createrepo command.\nattempted commands: %v", creatrepo_cmds)
-	}
-
-	return selectedCmd, nil
-}
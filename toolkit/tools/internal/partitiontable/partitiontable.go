@@ -0,0 +1,221 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package partitiontable reads the MBR or GPT partition table of a disk image.
+//
+// This package is deliberately free of Linux-only dependencies, so that disk images can be examined on any platform.
+package partitiontable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf16"
+)
+
+const (
+	// TypeMbr is an MBR (i.e. DOS) partition table.
+	TypeMbr = "mbr"
+	// TypeGpt is a GPT partition table.
+	TypeGpt = "gpt"
+
+	// SectorSize is the logical sector size of the disk images that the toolkit creates.
+	SectorSize = 512
+
+	mbrSignatureOffset       = 510
+	mbrPartitionsOffset      = 446
+	mbrPartitionEntrySize    = 16
+	mbrPartitionEntryCount   = 4
+	mbrProtectiveGptType     = 0xee
+	gptHeaderSignature       = "EFI PART"
+	gptMinHeaderSize         = 92
+	gptMinPartitionEntrySize = 128
+	gptMaxPartitionEntries   = 1024
+	gptPartitionNameSize     = 72
+)
+
+var (
+	// ErrNoPartitionTable is returned when a disk image doesn't have an MBR or GPT partition table (e.g. it is a VHDX
+	// or qcow2 file rather than a raw disk image).
+	ErrNoPartitionTable = errors.New("no partition table found")
+
+	mbrSignature = []byte{0x55, 0xaa}
+)
+
+// Table is the partition table of a disk image.
+type Table struct {
+	// Type is the type of the partition table ("mbr" or "gpt").
+	Type string `json:"type"`
+	// DiskGuid is the disk's GUID. Only set for GPT partition tables.
+	DiskGuid   string      `json:"diskGuid,omitempty"`
+	Partitions []Partition `json:"partitions"`
+}
+
+// Partition is a partition of a disk image. Offsets and sizes are in bytes.
+type Partition struct {
+	// Number is the partition's number (e.g. 1 for /dev/sda1).
+	Number int    `json:"number"`
+	Start  uint64 `json:"start"`
+	Size   uint64 `json:"size"`
+	// Type is the partition's type GUID (GPT) or type ID (MBR, e.g. "0x83").
+	Type string `json:"type"`
+	// Name and Guid are only set for GPT partitions.
+	Name string `json:"name,omitempty"`
+	Guid string `json:"guid,omitempty"`
+}
+
+// Read reads the partition table of a disk image. If the image has a protective MBR, then the GPT partition table
+// is read. Returns ErrNoPartitionTable if the image doesn't have a partition table.
+func Read(disk io.ReaderAt) (*Table, error) {
+	mbr := make([]byte, SectorSize)
+	_, err := disk.ReadAt(mbr, 0)
+	if errors.Is(err, io.EOF) {
+		return nil, ErrNoPartitionTable
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read MBR:\n%w", err)
+	}
+
+	if !bytes.Equal(mbr[mbrSignatureOffset:mbrSignatureOffset+len(mbrSignature)], mbrSignature) {
+		return nil, ErrNoPartitionTable
+	}
+
+	table := &Table{
+		Type:       TypeMbr,
+		Partitions: []Partition{},
+	}
+
+	for i := 0; i < mbrPartitionEntryCount; i++ {
+		entry := mbr[mbrPartitionsOffset+i*mbrPartitionEntrySize:][:mbrPartitionEntrySize]
+		partitionType := entry[4]
+		startSector := binary.LittleEndian.Uint32(entry[8:12])
+		sectorCount := binary.LittleEndian.Uint32(entry[12:16])
+
+		if partitionType == mbrProtectiveGptType {
+			return readGpt(disk)
+		}
+
+		if partitionType == 0 || sectorCount == 0 {
+			continue
+		}
+
+		table.Partitions = append(table.Partitions, Partition{
+			Number: i + 1,
+			Start:  uint64(startSector) * SectorSize,
+			Size:   uint64(sectorCount) * SectorSize,
+			Type:   fmt.Sprintf("0x%02x", partitionType),
+		})
+	}
+
+	return table, nil
+}
+
+// readGpt reads the primary GPT header (at LBA 1) and its partition entries.
+func readGpt(disk io.ReaderAt) (*Table, error) {
+	header := make([]byte, SectorSize)
+	_, err := disk.ReadAt(header, SectorSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPT header:\n%w", err)
+	}
+
+	if string(header[0:8]) != gptHeaderSignature {
+		return nil, fmt.Errorf("invalid GPT header:\nsignature not found")
+	}
+
+	headerSize := binary.LittleEndian.Uint32(header[12:16])
+	if headerSize < gptMinHeaderSize || headerSize > SectorSize {
+		return nil, fmt.Errorf("invalid GPT header:\nunsupported header size (%d)", headerSize)
+	}
+
+	// The header's CRC is calculated with the CRC field zeroed.
+	headerCrc := binary.LittleEndian.Uint32(header[16:20])
+	crcHeader := append([]byte(nil), header[:headerSize]...)
+	binary.LittleEndian.PutUint32(crcHeader[16:20], 0)
+	if crc32.ChecksumIEEE(crcHeader) != headerCrc {
+		return nil, fmt.Errorf("invalid GPT header:\nCRC mismatch")
+	}
+
+	entriesLba := binary.LittleEndian.Uint64(header[72:80])
+	entryCount := binary.LittleEndian.Uint32(header[80:84])
+	entrySize := binary.LittleEndian.Uint32(header[84:88])
+	entriesCrc := binary.LittleEndian.Uint32(header[88:92])
+
+	if entrySize < gptMinPartitionEntrySize || entrySize%gptMinPartitionEntrySize != 0 {
+		return nil, fmt.Errorf("invalid GPT header:\nunsupported partition entry size (%d)", entrySize)
+	}
+
+	if entryCount > gptMaxPartitionEntries {
+		return nil, fmt.Errorf("invalid GPT header:\ntoo many partition entries (%d)", entryCount)
+	}
+
+	entries := make([]byte, uint64(entryCount)*uint64(entrySize))
+	_, err = disk.ReadAt(entries, int64(entriesLba*SectorSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPT partition entries:\n%w", err)
+	}
+
+	if crc32.ChecksumIEEE(entries) != entriesCrc {
+		return nil, fmt.Errorf("invalid GPT partition entries:\nCRC mismatch")
+	}
+
+	table := &Table{
+		Type:       TypeGpt,
+		DiskGuid:   formatGuid(header[56:72]),
+		Partitions: []Partition{},
+	}
+
+	for i := uint32(0); i < entryCount; i++ {
+		entry := entries[i*entrySize:][:entrySize]
+
+		typeGuid := entry[0:16]
+		if bytes.Equal(typeGuid, make([]byte, 16)) {
+			// Unused entry.
+			continue
+		}
+
+		firstLba := binary.LittleEndian.Uint64(entry[32:40])
+		lastLba := binary.LittleEndian.Uint64(entry[40:48])
+		if lastLba < firstLba {
+			return nil, fmt.Errorf("invalid GPT partition entry (%d):\nlast LBA (%d) is before first LBA (%d)", i+1,
+				lastLba, firstLba)
+		}
+
+		table.Partitions = append(table.Partitions, Partition{
+			Number: int(i) + 1,
+			Start:  firstLba * SectorSize,
+			Size:   (lastLba - firstLba + 1) * SectorSize,
+			Type:   formatGuid(typeGuid),
+			Name:   decodeGptName(entry[56 : 56+gptPartitionNameSize]),
+			Guid:   formatGuid(entry[16:32]),
+		})
+	}
+
+	return table, nil
+}
+
+// formatGuid formats a GUID in its on-disk (mixed-endian) encoding, where the first three fields are little-endian.
+func formatGuid(guid []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(guid[0:4]),
+		binary.LittleEndian.Uint16(guid[4:6]),
+		binary.LittleEndian.Uint16(guid[6:8]),
+		guid[8:10],
+		guid[10:16])
+}
+
+// decodeGptName decodes a GPT partition name, which is null-terminated UTF-16LE.
+func decodeGptName(name []byte) string {
+	codeUnits := []uint16(nil)
+	for i := 0; i+1 < len(name); i += 2 {
+		codeUnit := binary.LittleEndian.Uint16(name[i : i+2])
+		if codeUnit == 0 {
+			break
+		}
+
+		codeUnits = append(codeUnits, codeUnit)
+	}
+
+	return string(utf16.Decode(codeUnits))
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package partitiontable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDiskSectors = 2048
+)
+
+var (
+	// The on-disk encodings of the EFI system partition type GUID (c12a7328-f81f-11d2-ba4b-00a0c93ec93b) and of a
+	// partition GUID (01020304-0506-0708-090a-0b0c0d0e0f10).
+	testEspTypeGuid   = []byte{0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b}
+	testPartitionGuid = []byte{0x04, 0x03, 0x02, 0x01, 0x06, 0x05, 0x08, 0x07, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+)
+
+func newTestMbrDisk(partitionType byte, startSector uint32, sectorCount uint32) []byte {
+	disk := make([]byte, testDiskSectors*SectorSize)
+	entry := disk[mbrPartitionsOffset:][:mbrPartitionEntrySize]
+	entry[4] = partitionType
+	binary.LittleEndian.PutUint32(entry[8:12], startSector)
+	binary.LittleEndian.PutUint32(entry[12:16], sectorCount)
+	copy(disk[mbrSignatureOffset:], mbrSignature)
+	return disk
+}
+
+func newTestGptDisk(name string, firstLba uint64, lastLba uint64) []byte {
+	const (
+		entriesLba = 2
+		entryCount = 128
+		entrySize  = 128
+	)
+
+	disk := newTestMbrDisk(mbrProtectiveGptType, 1, testDiskSectors-1)
+
+	entries := disk[entriesLba*SectorSize:][:entryCount*entrySize]
+	copy(entries[0:16], testEspTypeGuid)
+	copy(entries[16:32], testPartitionGuid)
+	binary.LittleEndian.PutUint64(entries[32:40], firstLba)
+	binary.LittleEndian.PutUint64(entries[40:48], lastLba)
+	for i, codeUnit := range utf16.Encode([]rune(name)) {
+		binary.LittleEndian.PutUint16(entries[56+i*2:], codeUnit)
+	}
+
+	header := disk[SectorSize:][:gptMinHeaderSize]
+	copy(header[0:8], gptHeaderSignature)
+	binary.LittleEndian.PutUint32(header[12:16], gptMinHeaderSize)
+	copy(header[56:72], testPartitionGuid)
+	binary.LittleEndian.PutUint64(header[72:80], entriesLba)
+	binary.LittleEndian.PutUint32(header[80:84], entryCount)
+	binary.LittleEndian.PutUint32(header[84:88], entrySize)
+	binary.LittleEndian.PutUint32(header[88:92], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header))
+
+	return disk
+}
+
+func TestReadMbr(t *testing.T) {
+	disk := newTestMbrDisk(0x83, 2048, 4096)
+
+	table, err := Read(bytes.NewReader(disk))
+	require.NoError(t, err)
+	assert.Equal(t, &Table{
+		Type: TypeMbr,
+		Partitions: []Partition{
+			{Number: 1, Start: 2048 * SectorSize, Size: 4096 * SectorSize, Type: "0x83"},
+		},
+	}, table)
+}
+
+func TestReadGpt(t *testing.T) {
+	disk := newTestGptDisk("esp", 34, 1057)
+
+	table, err := Read(bytes.NewReader(disk))
+	require.NoError(t, err)
+	assert.Equal(t, &Table{
+		Type:     TypeGpt,
+		DiskGuid: "01020304-0506-0708-090a-0b0c0d0e0f10",
+		Partitions: []Partition{
+			{
+				Number: 1,
+				Start:  34 * SectorSize,
+				Size:   1024 * SectorSize,
+				Type:   "c12a7328-f81f-11d2-ba4b-00a0c93ec93b",
+				Name:   "esp",
+				Guid:   "01020304-0506-0708-090a-0b0c0d0e0f10",
+			},
+		},
+	}, table)
+}
+
+func TestReadGptHeaderCrcMismatch(t *testing.T) {
+	disk := newTestGptDisk("esp", 34, 1057)
+	disk[SectorSize+72]++
+
+	_, err := Read(bytes.NewReader(disk))
+	assert.ErrorContains(t, err, "invalid GPT header:\nCRC mismatch")
+}
+
+func TestReadGptEntriesCrcMismatch(t *testing.T) {
+	disk := newTestGptDisk("esp", 34, 1057)
+	disk[2*SectorSize+32]++
+
+	_, err := Read(bytes.NewReader(disk))
+	assert.ErrorContains(t, err, "invalid GPT partition entries:\nCRC mismatch")
+}
+
+func TestReadNoPartitionTable(t *testing.T) {
+	_, err := Read(bytes.NewReader(make([]byte, testDiskSectors*SectorSize)))
+	assert.ErrorIs(t, err, ErrNoPartitionTable)
+
+	// Files smaller than a sector (e.g. not a disk image).
+	_, err = Read(bytes.NewReader([]byte("vhdxfile")))
+	assert.ErrorIs(t, err, ErrNoPartitionTable)
+}
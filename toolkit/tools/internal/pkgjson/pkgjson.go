@@ -39,7 +39,38 @@ var (
 
 // PackageRepo contains an array of SRPMs and relational dependencies
 type PackageRepo struct {
-	Repo []*Package `json:"Repo"`
+	Repo  []*Package      `json:"Repo"`
+	Specs []*SpecMetadata `json:"Specs,omitempty"` // Metadata of each spec the packages are built from
+}
+
+// SpecMetadata is the metadata of a spec, e.g. for license scanning and patch auditing
+type SpecMetadata struct {
+	SpecPath          string              `json:"SpecPath"`          // The path to the spec file
+	SrpmPath          string              `json:"SrpmPath"`          // Reconstructed name of the SRPM the spec is packed into
+	License           string              `json:"License"`           // License of the source package
+	URL               string              `json:"URL"`               // Upstream URL of the project
+	Patches           []*SpecPatch        `json:"Patches"`           // Patches applied to the sources
+	BuildConditionals []*BuildConditional `json:"BuildConditionals"` // Build conditionals (%bcond) of the spec
+	Subpackages       []*Subpackage       `json:"Subpackages"`       // Packages built by the spec, including the main package
+}
+
+// SpecPatch is a patch of a spec
+type SpecPatch struct {
+	Number int    `json:"Number"` // Number of the patch, as used by '%patch'
+	File   string `json:"File"`   // File name of the patch
+}
+
+// BuildConditional is a build conditional of a spec, which may be toggled with '--with' and '--without'
+type BuildConditional struct {
+	Name    string `json:"Name"`    // Name of the conditional
+	Default bool   `json:"Default"` // Whether the conditional is enabled by default
+}
+
+// Subpackage is a package built by a spec
+type Subpackage struct {
+	Name    string   `json:"Name"`    // Name of the package
+	License string   `json:"License"` // License of the package, defaults to the license of the source package
+	Files   []string `json:"Files"`   // Entries of the package's '%files' section, with their directives (e.g. '%license', '%dir')
 }
 
 // PackageVer is a representation of a package with name and version information
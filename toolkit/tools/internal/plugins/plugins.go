@@ -0,0 +1,377 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package plugins implements the toolkit's side of the plugin contract, which lets integrations (e.g. output image
+// converters, base image fetchers, and signing providers) be shipped separately from the toolkit.
+//
+// A plugin is an executable within a plugins directory. For each request, the toolkit runs the plugin with the
+// request's method as its only argument, writes the request as a JSON object to its stdin, and reads the response as a
+// JSON object from its stdout. The plugin's stderr is written to the toolkit's log. A plugin fails a request by
+// exiting with a non-zero exit code, or by responding with an "error" field.
+//
+// The contract is versioned by ABIVersion. Fields may be added to the requests and responses without changing the
+// version, so plugins must ignore the fields that they don't recognize.
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	// ABIVersion is the newest version of the plugin contract that the toolkit implements. It is only incremented for
+	// changes that existing plugins can't handle.
+	ABIVersion = 1
+	// MinABIVersion is the oldest version of the plugin contract that the toolkit still implements.
+	MinABIVersion = 1
+
+	// The methods of the plugin contract.
+	MethodDescribe = "describe"
+	MethodConvert  = "convert"
+	MethodFetch    = "fetch"
+	MethodSign     = "sign"
+
+	// The number of lines of a plugin's stderr to include in the error when a request fails.
+	errorStderrLines = 10
+)
+
+// Kind is a kind of integration that a plugin provides.
+type Kind string
+
+const (
+	// KindOutputConverter converts the customized raw image into an output image format.
+	KindOutputConverter Kind = "outputConverter"
+	// KindBaseImageFetcher downloads a base image from a URL.
+	KindBaseImageFetcher Kind = "baseImageFetcher"
+	// KindSigningProvider signs boot artifacts.
+	KindSigningProvider Kind = "signingProvider"
+)
+
+// Manifest is the response of the describe method, which lists what the plugin provides.
+type Manifest struct {
+	// AbiVersion is the version of the plugin contract that the plugin implements.
+	AbiVersion int `json:"abiVersion"`
+	// Name identifies the plugin in the logs and errors.
+	Name string `json:"name"`
+	// Version is the plugin's own version.
+	Version string `json:"version,omitempty"`
+	// Capabilities are the integrations that the plugin provides.
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// Capability is an integration that a plugin provides.
+type Capability struct {
+	Kind Kind `json:"kind"`
+	// Name is what the capability is selected by: the output format (outputConverter), the URL scheme
+	// (baseImageFetcher), or the provider name (signingProvider).
+	Name string `json:"name"`
+}
+
+// ConvertRequest is the request of the convert method.
+type ConvertRequest struct {
+	AbiVersion int `json:"abiVersion"`
+	// Format is the output format to convert the image to.
+	Format string `json:"format"`
+	// RawImageFile is the customized image, in the raw format. It must not be modified.
+	RawImageFile string `json:"rawImageFile"`
+	// OutputImageFile is the path to write the output image to.
+	OutputImageFile string `json:"outputImageFile"`
+}
+
+// FetchRequest is the request of the fetch method.
+type FetchRequest struct {
+	AbiVersion int `json:"abiVersion"`
+	// Url is the URL of the base image.
+	Url string `json:"url"`
+	// OutputDir is an empty directory to download the base image into.
+	OutputDir string `json:"outputDir"`
+}
+
+// FetchResponse is the response of the fetch method.
+type FetchResponse struct {
+	// ImageFile is the path of the downloaded base image, within the output directory. Its file extension is its
+	// format (e.g. "vhdx").
+	ImageFile string `json:"imageFile"`
+}
+
+// SignRequest is the request of the sign method.
+type SignRequest struct {
+	AbiVersion int `json:"abiVersion"`
+	// Provider is the name of the signing provider.
+	Provider string `json:"provider"`
+	// ManifestFile is a JSON file that lists the artifacts to sign.
+	ManifestFile string `json:"manifestFile"`
+	// UnsignedDir is the directory that contains the artifacts to sign.
+	UnsignedDir string `json:"unsignedDir"`
+	// SignedDir is the directory to write the signed artifacts to, at the same relative paths.
+	SignedDir string `json:"signedDir"`
+}
+
+// errorResponse is the part of a response that reports that the request failed.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Plugin is a plugin that was found within the plugins directory.
+type Plugin struct {
+	// Path is the path of the plugin's executable.
+	Path string
+	// Manifest is what the plugin provides.
+	Manifest Manifest
+}
+
+// Registry is the set of plugins that were found within a plugins directory.
+type Registry struct {
+	plugins      []*Plugin
+	capabilities map[Capability]*Plugin
+}
+
+// Discover finds the plugins within the plugins directory and asks each of them what it provides. If the directory
+// is empty, then the registry is empty.
+//
+// Plugins that implement a version of the plugin contract that the toolkit doesn't support are skipped, so that an
+// upgrade of the toolkit doesn't break the builds that don't use them.
+func Discover(pluginsDir string) (*Registry, error) {
+	registry := &Registry{
+		capabilities: make(map[Capability]*Plugin),
+	}
+
+	if pluginsDir == "" {
+		return registry, nil
+	}
+
+	entries, err := os.ReadDir(pluginsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugins directory (%s):\n%w", pluginsDir, err)
+	}
+
+	for _, entry := range entries {
+		pluginPath := filepath.Join(pluginsDir, entry.Name())
+
+		isPlugin, err := isPluginExecutable(pluginPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isPlugin {
+			logger.Log.Debugf("Skipping (%s) in plugins directory: not an executable", pluginPath)
+			continue
+		}
+
+		plugin, err := describePlugin(pluginPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if plugin.Manifest.AbiVersion < MinABIVersion || plugin.Manifest.AbiVersion > ABIVersion {
+			logger.Log.Warnf("Skipping plugin (%s): it implements plugin ABI version %d, but this version of the "+
+				"toolkit implements versions %d to %d", plugin.Manifest.Name, plugin.Manifest.AbiVersion,
+				MinABIVersion, ABIVersion)
+			continue
+		}
+
+		err = registry.add(plugin)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Log.Infof("Loaded plugin (%s) version (%s) from (%s)", plugin.Manifest.Name, plugin.Manifest.Version,
+			pluginPath)
+	}
+
+	return registry, nil
+}
+
+// isPluginExecutable returns true if the file is an executable that isn't hidden. Symlinks are followed.
+func isPluginExecutable(pluginPath string) (bool, error) {
+	if strings.HasPrefix(filepath.Base(pluginPath), ".") {
+		return false, nil
+	}
+
+	info, err := os.Stat(pluginPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat plugin (%s):\n%w", pluginPath, err)
+	}
+
+	return info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0, nil
+}
+
+// describePlugin asks a plugin what it provides.
+func describePlugin(pluginPath string) (*Plugin, error) {
+	plugin := &Plugin{
+		Path: pluginPath,
+	}
+
+	err := plugin.call(MethodDescribe, struct{}{}, &plugin.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	err = plugin.Manifest.IsValid()
+	if err != nil {
+		return nil, fmt.Errorf("plugin (%s) has an invalid manifest:\n%w", pluginPath, err)
+	}
+
+	return plugin, nil
+}
+
+func (m *Manifest) IsValid() error {
+	if m.Name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+
+	for i, capability := range m.Capabilities {
+		if capability.Name == "" {
+			return fmt.Errorf("invalid capabilities item at index %d:\nname must not be empty", i)
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) add(plugin *Plugin) error {
+	r.plugins = append(r.plugins, plugin)
+
+	for _, capability := range plugin.Manifest.Capabilities {
+		switch capability.Kind {
+		case KindOutputConverter, KindBaseImageFetcher, KindSigningProvider:
+
+		default:
+			// The capability may be from a newer toolkit that added a kind.
+			logger.Log.Debugf("Ignoring unknown capability kind (%s) of plugin (%s)", capability.Kind,
+				plugin.Manifest.Name)
+			continue
+		}
+
+		other, found := r.capabilities[capability]
+		if found {
+			return fmt.Errorf("plugins (%s) and (%s) both provide %s (%s)", other.Manifest.Name,
+				plugin.Manifest.Name, capability.Kind, capability.Name)
+		}
+
+		r.capabilities[capability] = plugin
+	}
+
+	return nil
+}
+
+// Find returns the plugin that provides the capability, or nil if none of the plugins provide it.
+func (r *Registry) Find(kind Kind, name string) *Plugin {
+	if r == nil {
+		return nil
+	}
+
+	return r.capabilities[Capability{Kind: kind, Name: name}]
+}
+
+// Names returns the sorted names that the plugins provide capabilities of the kind for.
+func (r *Registry) Names(kind Kind) []string {
+	if r == nil {
+		return nil
+	}
+
+	names := []string(nil)
+	for capability := range r.capabilities {
+		if capability.Kind == kind {
+			names = append(names, capability.Name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Plugins returns the plugins that were loaded.
+func (r *Registry) Plugins() []*Plugin {
+	if r == nil {
+		return nil
+	}
+
+	return append([]*Plugin(nil), r.plugins...)
+}
+
+// Convert converts the customized raw image into the output format.
+func (p *Plugin) Convert(request ConvertRequest) error {
+	request.AbiVersion = p.Manifest.AbiVersion
+	return p.call(MethodConvert, request, &struct{}{})
+}
+
+// Fetch downloads a base image.
+func (p *Plugin) Fetch(request FetchRequest) (*FetchResponse, error) {
+	request.AbiVersion = p.Manifest.AbiVersion
+
+	response := &FetchResponse{}
+	err := p.call(MethodFetch, request, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Sign signs the artifacts that are listed by the request's manifest file.
+func (p *Plugin) Sign(request SignRequest) error {
+	request.AbiVersion = p.Manifest.AbiVersion
+	return p.call(MethodSign, request, &struct{}{})
+}
+
+func (p *Plugin) String() string {
+	if p.Manifest.Name == "" {
+		return p.Path
+	}
+
+	return p.Manifest.Name
+}
+
+// call runs the plugin for a request and decodes its response.
+func (p *Plugin) call(method string, request interface{}, response interface{}) error {
+	requestJson, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode plugin (%s) request (%s):\n%w", p, method, err)
+	}
+
+	logger.Log.Debugf("Calling plugin (%s) method (%s)", p, method)
+
+	stdout, _, err := shell.NewExecBuilder(p.Path, method).
+		Stdin(string(requestJson)).
+		StdoutLogLevel(shell.LogDisabledLevel).
+		ErrorStderrLines(errorStderrLines).
+		ExecuteCaptureOuput()
+	if err != nil {
+		pluginError := decodeErrorResponse(stdout)
+		if pluginError != "" {
+			return fmt.Errorf("plugin (%s) failed request (%s):\n%s\n%w", p, method, pluginError, err)
+		}
+		return fmt.Errorf("plugin (%s) failed request (%s):\n%w", p, method, err)
+	}
+
+	pluginError := decodeErrorResponse(stdout)
+	if pluginError != "" {
+		return fmt.Errorf("plugin (%s) failed request (%s):\n%s", p, method, pluginError)
+	}
+
+	err = json.Unmarshal([]byte(stdout), response)
+	if err != nil {
+		return fmt.Errorf("plugin (%s) returned an invalid response to request (%s):\n%w", p, method, err)
+	}
+
+	return nil
+}
+
+// decodeErrorResponse returns the error that a plugin reported in its response, if any.
+func decodeErrorResponse(stdout string) string {
+	var response errorResponse
+	err := json.Unmarshal([]byte(stdout), &response)
+	if err != nil {
+		return ""
+	}
+
+	return response.Error
+}
@@ -0,0 +1,181 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// writePlugin writes a shell script plugin that responds to describe with the manifest, and to the other methods by
+// running the script.
+func writePlugin(t *testing.T, pluginsDir string, name string, manifest string, script string) string {
+	pluginPath := filepath.Join(pluginsDir, name)
+	content := fmt.Sprintf("#!/bin/sh\n"+
+		"if [ \"$1\" = describe ]; then\n"+
+		"  cat > /dev/null\n"+
+		"  echo '%s'\n"+
+		"  exit 0\n"+
+		"fi\n"+
+		"%s\n", manifest, script)
+
+	err := os.WriteFile(pluginPath, []byte(content), 0o755)
+	require.NoError(t, err)
+
+	return pluginPath
+}
+
+func TestDiscoverNoPluginsDir(t *testing.T) {
+	registry, err := Discover("")
+	assert.NoError(t, err)
+	assert.Empty(t, registry.Plugins())
+	assert.Nil(t, registry.Find(KindOutputConverter, "vmdk"))
+}
+
+func TestDiscoverMissingPluginsDir(t *testing.T) {
+	_, err := Discover(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "failed to read plugins directory")
+}
+
+func TestDiscover(t *testing.T) {
+	pluginsDir := t.TempDir()
+
+	vmdkPath := writePlugin(t, pluginsDir, "vmdk",
+		`{"abiVersion": 1, "name": "vmdk", "version": "2.1", "capabilities": [`+
+			`{"kind": "outputConverter", "name": "vmdk"}, {"kind": "futureKind", "name": "x"}], "extra": true}`, "")
+	writePlugin(t, pluginsDir, "fetcher",
+		`{"abiVersion": 1, "name": "fetcher", "capabilities": [`+
+			`{"kind": "baseImageFetcher", "name": "acr"}, {"kind": "signingProvider", "name": "hsm"}]}`, "")
+
+	// Plugins of an unsupported ABI version are skipped.
+	writePlugin(t, pluginsDir, "future",
+		`{"abiVersion": 99, "name": "future", "capabilities": [{"kind": "outputConverter", "name": "ova"}]}`, "")
+
+	// Files that aren't executables are skipped.
+	err := os.WriteFile(filepath.Join(pluginsDir, "README.md"), []byte("plugins"), 0o644)
+	require.NoError(t, err)
+	writePlugin(t, pluginsDir, ".hidden", "invalid", "")
+
+	registry, err := Discover(pluginsDir)
+	require.NoError(t, err)
+
+	assert.Len(t, registry.Plugins(), 2)
+
+	plugin := registry.Find(KindOutputConverter, "vmdk")
+	require.NotNil(t, plugin)
+	assert.Equal(t, vmdkPath, plugin.Path)
+	assert.Equal(t, "2.1", plugin.Manifest.Version)
+	assert.Equal(t, "vmdk", plugin.String())
+
+	assert.Equal(t, "fetcher", registry.Find(KindBaseImageFetcher, "acr").Manifest.Name)
+	assert.Equal(t, "fetcher", registry.Find(KindSigningProvider, "hsm").Manifest.Name)
+	assert.Nil(t, registry.Find(KindOutputConverter, "ova"))
+	assert.Nil(t, registry.Find(KindSigningProvider, "vmdk"))
+
+	assert.Equal(t, []string{"vmdk"}, registry.Names(KindOutputConverter))
+}
+
+func TestDiscoverDuplicateCapability(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writePlugin(t, pluginsDir, "a", `{"abiVersion": 1, "name": "a", "capabilities": `+
+		`[{"kind": "outputConverter", "name": "vmdk"}]}`, "")
+	writePlugin(t, pluginsDir, "b", `{"abiVersion": 1, "name": "b", "capabilities": `+
+		`[{"kind": "outputConverter", "name": "vmdk"}]}`, "")
+
+	_, err := Discover(pluginsDir)
+	assert.ErrorContains(t, err, "plugins (a) and (b) both provide outputConverter (vmdk)")
+}
+
+func TestDiscoverInvalidManifest(t *testing.T) {
+	pluginsDir := t.TempDir()
+	writePlugin(t, pluginsDir, "unnamed", `{"abiVersion": 1, "capabilities": []}`, "")
+
+	_, err := Discover(pluginsDir)
+	assert.ErrorContains(t, err, "has an invalid manifest:\nname must not be empty")
+
+	writePlugin(t, pluginsDir, "unnamed", `not json`, "")
+
+	_, err = Discover(pluginsDir)
+	assert.ErrorContains(t, err, "plugin ("+filepath.Join(pluginsDir, "unnamed")+
+		") returned an invalid response to request (describe)")
+}
+
+func TestPluginRequests(t *testing.T) {
+	pluginsDir := t.TempDir()
+	requestFile := filepath.Join(t.TempDir(), "request.json")
+
+	writePlugin(t, pluginsDir, "plugin", `{"abiVersion": 1, "name": "plugin", "capabilities": [`+
+		`{"kind": "baseImageFetcher", "name": "acr"}, {"kind": "signingProvider", "name": "hsm"}]}`,
+		fmt.Sprintf("echo \"$1\" > %[1]s.method\n"+
+			"cat > %[1]s\n"+
+			"echo downloading >&2\n"+
+			"if [ \"$1\" = fetch ]; then\n"+
+			"  echo '{\"imageFile\": \"/tmp/fetched/base.vhdx\"}'\n"+
+			"else\n"+
+			"  echo '{}'\n"+
+			"fi\n", requestFile))
+
+	registry, err := Discover(pluginsDir)
+	require.NoError(t, err)
+
+	fetcher := registry.Find(KindBaseImageFetcher, "acr")
+	require.NotNil(t, fetcher)
+
+	response, err := fetcher.Fetch(FetchRequest{Url: "acr://images/base:3.0", OutputDir: "/tmp/fetched"})
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/fetched/base.vhdx", response.ImageFile)
+
+	method, err := os.ReadFile(requestFile + ".method")
+	require.NoError(t, err)
+	assert.Equal(t, "fetch\n", string(method))
+
+	var request map[string]interface{}
+	requestJson, err := os.ReadFile(requestFile)
+	require.NoError(t, err)
+	err = json.Unmarshal(requestJson, &request)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"abiVersion": float64(1),
+		"url":        "acr://images/base:3.0",
+		"outputDir":  "/tmp/fetched",
+	}, request)
+
+	err = registry.Find(KindSigningProvider, "hsm").Sign(SignRequest{Provider: "hsm"})
+	assert.NoError(t, err)
+
+	method, err = os.ReadFile(requestFile + ".method")
+	require.NoError(t, err)
+	assert.Equal(t, "sign\n", string(method))
+}
+
+func TestPluginRequestFailures(t *testing.T) {
+	pluginsDir := t.TempDir()
+
+	manifest := `{"abiVersion": 1, "name": "%s", "capabilities": [{"kind": "outputConverter", "name": "%s"}]}`
+	writePlugin(t, pluginsDir, "reported", fmt.Sprintf(manifest, "reported", "ova"),
+		`echo '{"error": "unsupported firmware"}'`)
+	writePlugin(t, pluginsDir, "crashed", fmt.Sprintf(manifest, "crashed", "vmdk"),
+		"echo 'disk full' >&2\nexit 3")
+
+	registry, err := Discover(pluginsDir)
+	require.NoError(t, err)
+
+	err = registry.Find(KindOutputConverter, "ova").Convert(ConvertRequest{Format: "ova"})
+	assert.EqualError(t, err, "plugin (reported) failed request (convert):\nunsupported firmware")
+
+	err = registry.Find(KindOutputConverter, "vmdk").Convert(ConvertRequest{Format: "vmdk"})
+	assert.ErrorContains(t, err, "plugin (crashed) failed request (convert):\ndisk full\nexit status 3")
+}
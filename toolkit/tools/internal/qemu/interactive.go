@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package qemu
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// RunInteractive boots the image in a QEMU VM with the VM's serial console attached to the terminal, and waits for the
+// VM to exit. If keepChanges is false, then disk images are booted from a snapshot, so that the image file isn't
+// modified by booting it.
+func RunInteractive(imageFile string, imageFormat string, machine Machine, keepChanges bool) error {
+	qemuCommand, qemuArgs, err := buildCommand(imageFile, imageFormat, machine, commandOptions{
+		snapshot: !keepChanges,
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Booting (%s). Press Ctrl-A X to stop the VM.", imageFile)
+	for _, portForward := range machine.PortForwards {
+		logger.Log.Infof("Forwarding localhost:%d (%s) to VM port %d", portForward.HostPort, portForward.Protocol,
+			portForward.GuestPort)
+	}
+	logger.Log.Debugf("Running: %s %v", qemuCommand, qemuArgs)
+
+	cmd := exec.Command(qemuCommand, qemuArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("VM (%s) failed:\n%w", qemuCommand, err)
+	}
+
+	return nil
+}
+
+// CreateOverlay creates a qcow2 image that uses the image file as its read-only backing file, so that a VM can write
+// to the overlay without modifying the image file. The image file must not be modified while the overlay is in use.
+func CreateOverlay(imageFile string, imageFormat string, overlayFile string) error {
+	driveFormat, err := DriveFormat(imageFormat)
+	if err != nil {
+		return err
+	}
+
+	// The backing file's path is stored in the overlay, so it must not depend on the current directory.
+	absImageFile, err := filepath.Abs(imageFile)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of (%s):\n%w", imageFile, err)
+	}
+
+	output, err := exec.Command("qemu-img", "create", "-q", "-f", "qcow2", "-b", absImageFile, "-F", driveFormat,
+		overlayFile).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to create overlay (%s) of image (%s):\n%s\n%w", overlayFile, imageFile,
+			strings.TrimSpace(string(output)), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package qemu boots disk and ISO images in QEMU VMs.
+package qemu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	ArchAmd64 = "amd64"
+	ArchArm64 = "arm64"
+
+	DefaultSmokeTestTimeout = 5 * time.Minute
+	// DefaultSmokeTestPattern matches the serial console login prompt.
+	DefaultSmokeTestPattern = `login:`
+
+	defaultMemoryMiB = 2048
+
+	// The amount of console output kept for matching against the pattern and for error messages.
+	smokeTestOutputTailSize = 64 * 1024
+	smokeTestErrorTailSize  = 2 * 1024
+)
+
+// Machine describes the VM that an image is booted in.
+type Machine struct {
+	// Arch is the architecture of the image (e.g. "amd64").
+	Arch string
+	// Firmware is the path of the UEFI firmware to boot the image with. If empty, then the image is booted using the
+	// VM's default BIOS.
+	Firmware string
+	// MemoryMiB is the VM's memory size in MiB. If zero, then a default of 2 GiB is used.
+	MemoryMiB int
+	// Cpus is the VM's number of CPUs. If zero, then QEMU's default is used.
+	Cpus int
+	// PortForwards are the host ports that are forwarded to the VM.
+	PortForwards []PortForward
+}
+
+// PortForward forwards a port of the host to a port of the VM.
+type PortForward struct {
+	// Protocol is either "tcp" or "udp".
+	Protocol  string
+	HostPort  int
+	GuestPort int
+}
+
+// RunSmokeTest boots the image in a QEMU VM and waits for the serial console output to match the pattern.
+func RunSmokeTest(imageFile string, imageFormat string, machine Machine, timeout time.Duration,
+	pattern *regexp.Regexp,
+) error {
+	qemuCommand, qemuArgs, err := BuildCommand(imageFile, imageFormat, machine)
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Running smoke test for (%s)", imageFile)
+	logger.Log.Debugf("Running: %s %v", qemuCommand, qemuArgs)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, qemuCommand, qemuArgs...)
+	outputReader, outputWriter := io.Pipe()
+	cmd.Stdout = outputWriter
+	cmd.Stderr = outputWriter
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start VM (%s):\n%w", qemuCommand, err)
+	}
+
+	matchResult := make(chan matchOutputResult, 1)
+	go func() {
+		matched, tail := matchOutput(outputReader, pattern)
+		matchResult <- matchOutputResult{matched: matched, tail: tail}
+
+		// Keep draining the output so that the VM doesn't block on a full pipe.
+		_, _ = io.Copy(io.Discard, outputReader)
+	}()
+
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		outputWriter.Close()
+		waitErr <- err
+	}()
+
+	var result matchOutputResult
+	select {
+	case result = <-matchResult:
+	case <-ctx.Done():
+		result = <-matchResult
+	}
+
+	// The VM isn't needed anymore.
+	cancel()
+	<-waitErr
+
+	if !result.matched {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s waiting for console output matching (%s). Last output:\n%s",
+				timeout, pattern, result.tail)
+		}
+
+		return fmt.Errorf("VM exited without console output matching (%s). Last output:\n%s", pattern,
+			result.tail)
+	}
+
+	logger.Log.Infof("Smoke test passed")
+	return nil
+}
+
+type matchOutputResult struct {
+	matched bool
+	tail    string
+}
+
+// matchOutput reads the output until it matches the pattern or until the end of the output.
+// Returns whether the pattern was matched and the tail end of the output.
+func matchOutput(reader io.Reader, pattern *regexp.Regexp) (bool, string) {
+	output := []byte(nil)
+	buffer := make([]byte, 4096)
+
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			output = append(output, buffer[:n]...)
+			if len(output) > smokeTestOutputTailSize {
+				output = output[len(output)-smokeTestOutputTailSize:]
+			}
+
+			// Prompts (e.g. "login:") usually don't end with a newline. So, match against the raw output instead of
+			// line by line.
+			if pattern.Match(output) {
+				return true, outputTail(output)
+			}
+		}
+
+		if err != nil {
+			return false, outputTail(output)
+		}
+	}
+}
+
+func outputTail(output []byte) string {
+	if len(output) > smokeTestErrorTailSize {
+		output = output[len(output)-smokeTestErrorTailSize:]
+	}
+	return string(output)
+}
+
+// BuildCommand returns the QEMU command that boots the image with its serial console attached to stdio. Disk images
+// are booted from a snapshot, so that the image file isn't modified by booting it.
+func BuildCommand(imageFile string, imageFormat string, machine Machine) (string, []string, error) {
+	return buildCommand(imageFile, imageFormat, machine, commandOptions{
+		snapshot: true,
+		noReboot: true,
+	})
+}
+
+// commandOptions are the options of a QEMU command that depend on how the VM is used.
+type commandOptions struct {
+	// snapshot discards the writes to the disk image when the VM exits.
+	snapshot bool
+	// noReboot exits the VM when it reboots, instead of rebooting it.
+	noReboot bool
+}
+
+func buildCommand(imageFile string, imageFormat string, machine Machine, options commandOptions,
+) (string, []string, error) {
+	// Hardware acceleration is only possible when the VM's arch matches the host's arch.
+	accel := "tcg"
+	if machine.Arch == runtime.GOARCH {
+		accel = "kvm:tcg"
+	}
+
+	var qemuCommand string
+	var args []string
+	switch machine.Arch {
+	case ArchAmd64:
+		qemuCommand = "qemu-system-x86_64"
+		args = []string{"-machine", "q35,accel=" + accel}
+
+	case ArchArm64:
+		if machine.Firmware == "" {
+			return "", nil, fmt.Errorf("firmware must be specified to boot (%s) images", ArchArm64)
+		}
+
+		qemuCommand = "qemu-system-aarch64"
+		args = []string{"-machine", "virt,accel=" + accel, "-cpu", "max"}
+
+	default:
+		return "", nil, fmt.Errorf("unsupported arch (%s)", machine.Arch)
+	}
+
+	memoryMiB := machine.MemoryMiB
+	if memoryMiB <= 0 {
+		memoryMiB = defaultMemoryMiB
+	}
+
+	args = append(args,
+		"-m", strconv.Itoa(memoryMiB),
+		"-nographic",
+		"-serial", "mon:stdio",
+	)
+
+	if options.noReboot {
+		args = append(args, "-no-reboot")
+	}
+
+	if machine.Cpus > 0 {
+		args = append(args, "-smp", strconv.Itoa(machine.Cpus))
+	}
+
+	if machine.Firmware != "" {
+		args = append(args, "-bios", machine.Firmware)
+	}
+
+	if len(machine.PortForwards) > 0 {
+		netdev := "user,id=net0"
+		for _, portForward := range machine.PortForwards {
+			// Only listen on the host's loopback interface, so that the VM isn't reachable from other machines.
+			netdev += fmt.Sprintf(",hostfwd=%s:127.0.0.1:%d-:%d", portForward.Protocol, portForward.HostPort,
+				portForward.GuestPort)
+		}
+
+		args = append(args, "-netdev", netdev, "-device", "virtio-net-pci,netdev=net0")
+	}
+
+	switch imageFormat {
+	case "iso":
+		args = append(args, "-drive", fmt.Sprintf("file=%s,media=cdrom,readonly=on", imageFile))
+
+	default:
+		driveFormat, err := DriveFormat(imageFormat)
+		if err != nil {
+			return "", nil, err
+		}
+
+		drive := fmt.Sprintf("file=%s,format=%s,if=virtio", imageFile, driveFormat)
+		if options.snapshot {
+			drive += ",snapshot=on"
+		}
+
+		args = append(args, "-drive", drive)
+	}
+
+	return qemuCommand, args, nil
+}
+
+// DriveFormat returns QEMU's name for the format of a disk image.
+func DriveFormat(imageFormat string) (string, error) {
+	switch imageFormat {
+	case "vhd", "vhd-fixed":
+		return "vpc", nil
+	case "qcow2-compressed":
+		return "qcow2", nil
+	case "raw-zst":
+		return "", fmt.Errorf("QEMU doesn't support compressed (%s) images", imageFormat)
+	case "iso":
+		return "", fmt.Errorf("(%s) images aren't disk images", imageFormat)
+	default:
+		return imageFormat, nil
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package qemu
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchOutput(t *testing.T) {
+	pattern := regexp.MustCompile(DefaultSmokeTestPattern)
+
+	matched, tail := matchOutput(strings.NewReader("Booting...\nWelcome to Azure Linux\nazl login: "), pattern)
+	assert.True(t, matched)
+	assert.Contains(t, tail, "azl login:")
+
+	matched, tail = matchOutput(strings.NewReader("Booting...\nKernel panic"), pattern)
+	assert.False(t, matched)
+	assert.Equal(t, "Booting...\nKernel panic", tail)
+}
+
+func TestBuildCommand(t *testing.T) {
+	machine := Machine{Arch: ArchAmd64}
+
+	command, args, err := BuildCommand("/out/a.qcow2", "qcow2-compressed", machine)
+	assert.NoError(t, err)
+	assert.Equal(t, "qemu-system-x86_64", command)
+	assert.Contains(t, args, "file=/out/a.qcow2,format=qcow2,if=virtio,snapshot=on")
+	assert.NotContains(t, args, "-bios")
+
+	_, _, err = BuildCommand("/out/a.raw.zst", "raw-zst", machine)
+	assert.ErrorContains(t, err, "doesn't support compressed (raw-zst) images")
+
+	_, _, err = BuildCommand("/out/a.raw", "raw", Machine{Arch: "riscv64"})
+	assert.ErrorContains(t, err, "unsupported arch (riscv64)")
+}
+
+func TestBuildCommandVmOptions(t *testing.T) {
+	machine := Machine{
+		Arch:      ArchAmd64,
+		MemoryMiB: 4096,
+		Cpus:      4,
+		PortForwards: []PortForward{
+			{Protocol: "tcp", HostPort: 2222, GuestPort: 22},
+			{Protocol: "udp", HostPort: 5353, GuestPort: 53},
+		},
+	}
+
+	_, args, err := buildCommand("/out/a.vhd", "vhd", machine, commandOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Join(args, " "), "-m 4096")
+	assert.Contains(t, strings.Join(args, " "), "-smp 4")
+	assert.Contains(t, args, "user,id=net0,hostfwd=tcp:127.0.0.1:2222-:22,hostfwd=udp:127.0.0.1:5353-:53")
+	assert.Contains(t, args, "file=/out/a.vhd,format=vpc,if=virtio")
+	assert.NotContains(t, args, "-no-reboot")
+}
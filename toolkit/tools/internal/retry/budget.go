@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package retry
+
+import (
+	"sync/atomic"
+)
+
+// Budget is a number of retries shared by many operations, e.g. all the downloads of a run. A few flaky operations
+// can be retried many times, while a broken server can't make every operation retry until it gives up. It is safe for
+// concurrent use.
+type Budget struct {
+	remaining atomic.Int64
+}
+
+// NewBudget creates a budget of the given number of retries.
+func NewBudget(retries int) (budget *Budget) {
+	budget = &Budget{}
+	budget.remaining.Store(int64(max(retries, 0)))
+	return
+}
+
+// Take uses up one retry. Returns false if the budget is exhausted.
+func (b *Budget) Take() bool {
+	for {
+		remaining := b.remaining.Load()
+		if remaining <= 0 {
+			return false
+		}
+
+		if b.remaining.CompareAndSwap(remaining, remaining-1) {
+			return true
+		}
+	}
+}
+
+// Remaining returns the number of retries left.
+func (b *Budget) Remaining() int {
+	return int(b.remaining.Load())
+}
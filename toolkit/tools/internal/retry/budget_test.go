@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package retry
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetIsExhausted(t *testing.T) {
+	budget := NewBudget(2)
+
+	assert.True(t, budget.Take())
+	assert.True(t, budget.Take())
+	assert.False(t, budget.Take())
+	assert.Equal(t, 0, budget.Remaining())
+}
+
+func TestBudgetNegativeRetries(t *testing.T) {
+	budget := NewBudget(-1)
+
+	assert.False(t, budget.Take())
+	assert.Equal(t, 0, budget.Remaining())
+}
+
+func TestBudgetIsShared(t *testing.T) {
+	const (
+		retries = 50
+		takers  = 8
+	)
+
+	budget := NewBudget(retries)
+	taken := atomic.Int64{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < takers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for budget.Take() {
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(retries), taken.Load())
+}
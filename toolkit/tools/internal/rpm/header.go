@@ -0,0 +1,687 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package rpm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Layout of an RPM file, see https://rpm-software-management.github.io/rpm/manual/format.html.
+// An RPM file starts with a lead, followed by the signature header, the main header and the compressed payload.
+const (
+	leadSize            = 96
+	leadTypeSource      = 1
+	headerIntroSize     = 16
+	headerIndexSize     = 16
+	signatureAlignment  = 8
+	maxHeaderIndexCount = 0xffff
+	maxHeaderDataSize   = 256 * 1024 * 1024
+)
+
+var (
+	leadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// Types of the header entries.
+const (
+	headerTypeInt16       = 3
+	headerTypeInt32       = 4
+	headerTypeInt64       = 5
+	headerTypeString      = 6
+	headerTypeBinary      = 7
+	headerTypeStringArray = 8
+	headerTypeI18NString  = 9
+)
+
+// Tags of the main header.
+const (
+	tagName              = 1000
+	tagVersion           = 1001
+	tagRelease           = 1002
+	tagEpoch             = 1003
+	tagSummary           = 1004
+	tagDescription       = 1005
+	tagBuildTime         = 1006
+	tagBuildHost         = 1007
+	tagSize              = 1009
+	tagVendor            = 1011
+	tagLicense           = 1014
+	tagPackager          = 1015
+	tagGroup             = 1016
+	tagURL               = 1020
+	tagArch              = 1022
+	tagOldFileNames      = 1027
+	tagFileModes         = 1030
+	tagFileFlags         = 1037
+	tagSourceRPM         = 1044
+	tagArchiveSize       = 1046
+	tagProvideName       = 1047
+	tagRequireFlags      = 1048
+	tagRequireName       = 1049
+	tagRequireVersion    = 1050
+	tagConflictFlags     = 1053
+	tagConflictName      = 1054
+	tagConflictVersion   = 1055
+	tagObsoleteName      = 1090
+	tagProvideFlags      = 1112
+	tagProvideVersion    = 1113
+	tagObsoleteFlags     = 1114
+	tagObsoleteVersion   = 1115
+	tagDirIndexes        = 1116
+	tagBaseNames         = 1117
+	tagDirNames          = 1118
+	tagLongSize          = 5009
+	tagRecommendName     = 5046
+	tagRecommendVersion  = 5047
+	tagRecommendFlags    = 5048
+	tagSuggestName       = 5049
+	tagSuggestVersion    = 5050
+	tagSuggestFlags      = 5051
+	tagSupplementName    = 5052
+	tagSupplementVersion = 5053
+	tagSupplementFlags   = 5054
+	tagEnhanceName       = 5055
+	tagEnhanceVersion    = 5056
+	tagEnhanceFlags      = 5057
+	tagPayloadDigest     = 5092
+	tagPayloadDigestAlgo = 5093
+)
+
+// Tags of the signature header holding OpenPGP signatures, over the main header only or over the header and payload.
+const (
+	sigTagDSA = 267
+	sigTagRSA = 268
+	sigTagPGP = 1002
+	sigTagGPG = 1005
+)
+
+// signatureTags are the tags of the signature header read into PackageHeader.Signatures, in a stable order.
+var signatureTags = []uint32{sigTagRSA, sigTagDSA, sigTagPGP, sigTagGPG}
+
+// Flags of a dependency.
+const (
+	senseLess       = 0x02
+	senseGreater    = 0x04
+	senseEqual      = 0x08
+	sensePrereq     = 0x40
+	senseScriptPre  = 0x200
+	senseScriptPost = 0x400
+)
+
+// Types and flags of the files of a package.
+const (
+	fileTypeMask      = 0o170000
+	fileTypeDirectory = 0o040000
+	fileFlagGhost     = 0x40
+)
+
+// payloadDigestAlgorithms maps the OpenPGP hash algorithm IDs used by rpm to their names.
+var payloadDigestAlgorithms = map[int64]string{
+	1:  "md5",
+	2:  "sha1",
+	8:  "sha256",
+	9:  "sha384",
+	10: "sha512",
+	11: "sha224",
+}
+
+// Dependency is a provide or a require of a package.
+type Dependency struct {
+	Name    string
+	Flags   uint32
+	Version string
+}
+
+// IsPrerequisite reports if the dependency must be installed before the package's scripts run.
+func (d *Dependency) IsPrerequisite() bool {
+	return d.Flags&(sensePrereq|senseScriptPre|senseScriptPost) != 0
+}
+
+// Comparison returns the comparison of the dependency with its version, e.g. "GE", or an empty string if it has no
+// version. This is the format used by repository metadata.
+func (d *Dependency) Comparison() string {
+	if d.Version == "" {
+		return ""
+	}
+
+	switch d.Flags & (senseLess | senseGreater | senseEqual) {
+	case senseLess:
+		return "LT"
+	case senseGreater:
+		return "GT"
+	case senseEqual:
+		return "EQ"
+	case senseLess | senseEqual:
+		return "LE"
+	case senseGreater | senseEqual:
+		return "GE"
+	default:
+		return ""
+	}
+}
+
+// String returns the dependency the same way 'rpm -q --provides' and 'rpm -q --requires' do, e.g. "foo >= 1.0-1".
+func (d *Dependency) String() string {
+	operator := ""
+	if d.Flags&senseLess != 0 {
+		operator += "<"
+	}
+	if d.Flags&senseGreater != 0 {
+		operator += ">"
+	}
+	if d.Flags&senseEqual != 0 {
+		operator += "="
+	}
+
+	if operator == "" || d.Version == "" {
+		return d.Name
+	}
+
+	return fmt.Sprintf("%s %s %s", d.Name, operator, d.Version)
+}
+
+// PackageHeader holds the metadata of an RPM file, read from its header without calling rpm.
+type PackageHeader struct {
+	Name          string
+	Version       string
+	Release       string
+	Epoch         string
+	Arch          string
+	SourceRPM     string
+	IsSource      bool
+	Summary       string
+	Description   string
+	URL           string
+	License       string
+	Vendor        string
+	Group         string
+	Packager      string
+	BuildHost     string
+	BuildTime     int64
+	InstalledSize int64
+	ArchiveSize   int64
+	Provides      []*Dependency
+	Requires      []*Dependency
+	Conflicts     []*Dependency
+	Obsoletes     []*Dependency
+	Recommends    []*Dependency
+	Suggests      []*Dependency
+	Supplements   []*Dependency
+	Enhances      []*Dependency
+	Files         []string
+	// PayloadDigest is the hex encoded digest of the compressed payload. Empty for RPMs built before rpm 4.14.
+	PayloadDigest          string
+	PayloadDigestAlgorithm string
+	// Signatures are the OpenPGP signature packets of the signature header. Empty if the package isn't signed.
+	Signatures [][]byte
+	// HeaderStart and HeaderEnd are the offsets of the main header in the file.
+	HeaderStart int64
+	HeaderEnd   int64
+
+	fileModes []int64
+	fileFlags []int64
+}
+
+// FileIsDirectory reports if the file at the index of Files is a directory.
+func (h *PackageHeader) FileIsDirectory(index int) bool {
+	return index < len(h.fileModes) && h.fileModes[index]&fileTypeMask == fileTypeDirectory
+}
+
+// FileIsGhost reports if the file at the index of Files is a ghost, which isn't part of the payload.
+func (h *PackageHeader) FileIsGhost(index int) bool {
+	return index < len(h.fileFlags) && h.fileFlags[index]&fileFlagGhost != 0
+}
+
+// NVRA returns the name, version, release and architecture of the package, as printed by 'rpm -qp'.
+func (h *PackageHeader) NVRA() string {
+	arch := h.Arch
+	if h.IsSource {
+		arch = "src"
+	}
+
+	return fmt.Sprintf("%s-%s-%s.%s", h.Name, h.Version, h.Release, arch)
+}
+
+// FileName returns the conventional file name of the package.
+func (h *PackageHeader) FileName() string {
+	return fmt.Sprintf("%s.rpm", h.NVRA())
+}
+
+// headerEntry is an entry of the index of a header.
+type headerEntry struct {
+	Tag    uint32
+	Type   uint32
+	Offset uint32
+	Count  uint32
+}
+
+// header is a parsed RPM header structure.
+type header struct {
+	entries map[uint32]headerEntry
+	data    []byte
+	// size is the size of the whole structure in the file.
+	size int
+}
+
+// ReadPackageHeader reads the header of an RPM file. Only the beginning of the file is read, the payload is skipped.
+func ReadPackageHeader(rpmFile string) (packageHeader *PackageHeader, err error) {
+	rpmReader, err := os.Open(rpmFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RPM (%s):\n%w", rpmFile, err)
+	}
+	defer rpmReader.Close()
+
+	packageHeader, err = ParsePackageHeader(bufio.NewReader(rpmReader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header of RPM (%s):\n%w", rpmFile, err)
+	}
+
+	return
+}
+
+// ParsePackageHeader parses the lead, the signature header and the main header of an RPM.
+func ParsePackageHeader(reader io.Reader) (packageHeader *PackageHeader, err error) {
+	lead := make([]byte, leadSize)
+	_, err = io.ReadFull(reader, lead)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the lead:\n%w", err)
+	}
+
+	if !bytes.Equal(lead[:len(leadMagic)], leadMagic) {
+		return nil, fmt.Errorf("not an RPM file, invalid lead magic (%x)", lead[:len(leadMagic)])
+	}
+
+	signature, err := readHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the signature header:\n%w", err)
+	}
+
+	// The signature header is padded so the main header is aligned.
+	padding := (signatureAlignment - signature.size%signatureAlignment) % signatureAlignment
+	if padding != 0 {
+		_, err = io.CopyN(io.Discard, reader, int64(padding))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the signature header padding:\n%w", err)
+		}
+	}
+
+	main, err := readHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the main header:\n%w", err)
+	}
+
+	packageHeader, err = newPackageHeader(main, binary.BigEndian.Uint16(lead[6:8]) == leadTypeSource)
+	if err != nil {
+		return
+	}
+
+	packageHeader.Signatures, err = signature.signatures()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the signatures:\n%w", err)
+	}
+
+	packageHeader.HeaderStart = int64(leadSize + signature.size + padding)
+	packageHeader.HeaderEnd = packageHeader.HeaderStart + int64(main.size)
+
+	return
+}
+
+// readHeader reads a header structure: the intro, the index entries and the data store.
+func readHeader(reader io.Reader) (parsedHeader *header, err error) {
+	intro := make([]byte, headerIntroSize)
+	_, err = io.ReadFull(reader, intro)
+	if err != nil {
+		return
+	}
+
+	if !bytes.Equal(intro[:len(headerMagic)], headerMagic) {
+		return nil, fmt.Errorf("invalid header magic (%x)", intro[:len(headerMagic)])
+	}
+
+	indexCount := binary.BigEndian.Uint32(intro[8:12])
+	dataSize := binary.BigEndian.Uint32(intro[12:16])
+	if indexCount > maxHeaderIndexCount || dataSize > maxHeaderDataSize {
+		return nil, fmt.Errorf("header too large (%d entries, %d bytes)", indexCount, dataSize)
+	}
+
+	index := make([]byte, indexCount*headerIndexSize)
+	_, err = io.ReadFull(reader, index)
+	if err != nil {
+		return
+	}
+
+	parsedHeader = &header{
+		entries: make(map[uint32]headerEntry, indexCount),
+		data:    make([]byte, dataSize),
+		size:    headerIntroSize + len(index) + int(dataSize),
+	}
+
+	_, err = io.ReadFull(reader, parsedHeader.data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint32(0); i < indexCount; i++ {
+		rawEntry := index[i*headerIndexSize : (i+1)*headerIndexSize]
+		entry := headerEntry{
+			Tag:    binary.BigEndian.Uint32(rawEntry[0:4]),
+			Type:   binary.BigEndian.Uint32(rawEntry[4:8]),
+			Offset: binary.BigEndian.Uint32(rawEntry[8:12]),
+			Count:  binary.BigEndian.Uint32(rawEntry[12:16]),
+		}
+		// An empty entry may point at the end of the data, but never past it.
+		if entry.Offset > dataSize || (entry.Offset == dataSize && entry.Count != 0) {
+			return nil, fmt.Errorf("entry for tag (%d) is outside of the header", entry.Tag)
+		}
+		parsedHeader.entries[entry.Tag] = entry
+	}
+
+	return
+}
+
+// strings returns the strings of a tag, or nil if the header doesn't have it.
+func (h *header) strings(tag uint32) (values []string, err error) {
+	entry, found := h.entries[tag]
+	if !found {
+		return nil, nil
+	}
+
+	switch entry.Type {
+	case headerTypeString, headerTypeStringArray, headerTypeI18NString:
+	default:
+		return nil, fmt.Errorf("tag (%d) has type (%d), expected a string", tag, entry.Type)
+	}
+
+	data := h.data[entry.Offset:]
+	values = make([]string, 0, entry.Count)
+	for i := uint32(0); i < entry.Count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string in tag (%d)", tag)
+		}
+		values = append(values, string(data[:end]))
+		data = data[end+1:]
+	}
+
+	return
+}
+
+// string returns the string of a tag, or the first one for an array or an internationalized string.
+func (h *header) string(tag uint32) (value string, err error) {
+	values, err := h.strings(tag)
+	if err != nil || len(values) == 0 {
+		return
+	}
+
+	return values[0], nil
+}
+
+// integers returns the integers of a tag, of any size, or nil if the header doesn't have it.
+func (h *header) integers(tag uint32) (values []int64, err error) {
+	entry, found := h.entries[tag]
+	if !found {
+		return nil, nil
+	}
+
+	var size uint64
+	switch entry.Type {
+	case headerTypeInt16:
+		size = 2
+	case headerTypeInt32:
+		size = 4
+	case headerTypeInt64:
+		size = 8
+	default:
+		return nil, fmt.Errorf("tag (%d) has type (%d), expected an integer", tag, entry.Type)
+	}
+
+	end := uint64(entry.Offset) + uint64(entry.Count)*size
+	if end > uint64(len(h.data)) {
+		return nil, fmt.Errorf("entry for tag (%d) is outside of the header", tag)
+	}
+
+	values = make([]int64, entry.Count)
+	for i := range values {
+		data := h.data[uint64(entry.Offset)+uint64(i)*size:]
+		switch size {
+		case 2:
+			values[i] = int64(binary.BigEndian.Uint16(data))
+		case 4:
+			values[i] = int64(binary.BigEndian.Uint32(data))
+		case 8:
+			values[i] = int64(binary.BigEndian.Uint64(data))
+		}
+	}
+
+	return
+}
+
+// integer returns the first integer of a tag, or 0 if the header doesn't have it.
+func (h *header) integer(tag uint32) (value int64, err error) {
+	values, err := h.integers(tag)
+	if err != nil || len(values) == 0 {
+		return
+	}
+
+	return values[0], nil
+}
+
+// binary returns the raw data of a tag, or nil if the header doesn't have it.
+func (h *header) binary(tag uint32) (value []byte, err error) {
+	entry, found := h.entries[tag]
+	if !found {
+		return nil, nil
+	}
+
+	if entry.Type != headerTypeBinary {
+		return nil, fmt.Errorf("tag (%d) has type (%d), expected binary data", tag, entry.Type)
+	}
+
+	end := uint64(entry.Offset) + uint64(entry.Count)
+	if end > uint64(len(h.data)) {
+		return nil, fmt.Errorf("entry for tag (%d) is outside of the header", tag)
+	}
+
+	return h.data[entry.Offset:end], nil
+}
+
+// signatures returns the OpenPGP signatures of a signature header.
+func (h *header) signatures() (signatures [][]byte, err error) {
+	for _, tag := range signatureTags {
+		var signature []byte
+
+		signature, err = h.binary(tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if signature != nil {
+			signatures = append(signatures, signature)
+		}
+	}
+
+	return
+}
+
+// dependencies returns the dependencies stored in the name, flags and version tags.
+func (h *header) dependencies(nameTag, flagsTag, versionTag uint32) (dependencies []*Dependency, err error) {
+	names, err := h.strings(nameTag)
+	if err != nil {
+		return
+	}
+
+	flags, err := h.integers(flagsTag)
+	if err != nil {
+		return
+	}
+
+	versions, err := h.strings(versionTag)
+	if err != nil {
+		return
+	}
+
+	if len(flags) != len(names) || len(versions) != len(names) {
+		return nil, fmt.Errorf("mismatched number of names (%d), flags (%d) and versions (%d) of tag (%d)", len(names), len(flags), len(versions), nameTag)
+	}
+
+	dependencies = make([]*Dependency, len(names))
+	for i, name := range names {
+		dependencies[i] = &Dependency{Name: name, Flags: uint32(flags[i]), Version: versions[i]}
+	}
+
+	return
+}
+
+// files returns the full paths of the files of the package.
+func (h *header) files() (files []string, err error) {
+	baseNames, err := h.strings(tagBaseNames)
+	if err != nil {
+		return
+	}
+
+	// RPMs from before rpm 4.0 store the full paths.
+	if baseNames == nil {
+		return h.strings(tagOldFileNames)
+	}
+
+	dirNames, err := h.strings(tagDirNames)
+	if err != nil {
+		return
+	}
+
+	dirIndexes, err := h.integers(tagDirIndexes)
+	if err != nil {
+		return
+	}
+
+	if len(dirIndexes) != len(baseNames) {
+		return nil, fmt.Errorf("mismatched number of file names (%d) and directory indexes (%d)", len(baseNames), len(dirIndexes))
+	}
+
+	files = make([]string, len(baseNames))
+	for i, baseName := range baseNames {
+		dirIndex := dirIndexes[i]
+		if dirIndex < 0 || int(dirIndex) >= len(dirNames) {
+			return nil, fmt.Errorf("invalid directory index (%d) of file (%s)", dirIndex, baseName)
+		}
+		files[i] = dirNames[dirIndex] + baseName
+	}
+
+	return
+}
+
+// newPackageHeader extracts the metadata of a package from its main header.
+func newPackageHeader(main *header, isSource bool) (packageHeader *PackageHeader, err error) {
+	packageHeader = &PackageHeader{IsSource: isSource}
+
+	stringTags := map[uint32]*string{
+		tagName:          &packageHeader.Name,
+		tagVersion:       &packageHeader.Version,
+		tagRelease:       &packageHeader.Release,
+		tagArch:          &packageHeader.Arch,
+		tagSourceRPM:     &packageHeader.SourceRPM,
+		tagSummary:       &packageHeader.Summary,
+		tagDescription:   &packageHeader.Description,
+		tagURL:           &packageHeader.URL,
+		tagLicense:       &packageHeader.License,
+		tagVendor:        &packageHeader.Vendor,
+		tagGroup:         &packageHeader.Group,
+		tagPackager:      &packageHeader.Packager,
+		tagBuildHost:     &packageHeader.BuildHost,
+		tagPayloadDigest: &packageHeader.PayloadDigest,
+	}
+	for tag, value := range stringTags {
+		*value, err = main.string(tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if packageHeader.Name == "" {
+		return nil, fmt.Errorf("header has no package name")
+	}
+
+	integerTags := map[uint32]*int64{
+		tagBuildTime:   &packageHeader.BuildTime,
+		tagSize:        &packageHeader.InstalledSize,
+		tagArchiveSize: &packageHeader.ArchiveSize,
+	}
+	for tag, value := range integerTags {
+		*value, err = main.integer(tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Packages with more than 4GB of files store their size in a separate tag.
+	longSize, err := main.integer(tagLongSize)
+	if err != nil {
+		return nil, err
+	}
+	if longSize != 0 {
+		packageHeader.InstalledSize = longSize
+	}
+
+	epoch, err := main.integers(tagEpoch)
+	if err != nil {
+		return nil, err
+	}
+	if len(epoch) != 0 {
+		packageHeader.Epoch = strconv.FormatInt(epoch[0], 10)
+	}
+
+	dependencyTags := map[*[]*Dependency][3]uint32{
+		&packageHeader.Provides:  {tagProvideName, tagProvideFlags, tagProvideVersion},
+		&packageHeader.Requires:  {tagRequireName, tagRequireFlags, tagRequireVersion},
+		&packageHeader.Conflicts: {tagConflictName, tagConflictFlags, tagConflictVersion},
+		&packageHeader.Obsoletes: {tagObsoleteName, tagObsoleteFlags, tagObsoleteVersion},
+
+		&packageHeader.Recommends:  {tagRecommendName, tagRecommendFlags, tagRecommendVersion},
+		&packageHeader.Suggests:    {tagSuggestName, tagSuggestFlags, tagSuggestVersion},
+		&packageHeader.Supplements: {tagSupplementName, tagSupplementFlags, tagSupplementVersion},
+		&packageHeader.Enhances:    {tagEnhanceName, tagEnhanceFlags, tagEnhanceVersion},
+	}
+	for dependencies, tags := range dependencyTags {
+		*dependencies, err = main.dependencies(tags[0], tags[1], tags[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	packageHeader.Files, err = main.files()
+	if err != nil {
+		return nil, err
+	}
+
+	packageHeader.fileModes, err = main.integers(tagFileModes)
+	if err != nil {
+		return nil, err
+	}
+
+	packageHeader.fileFlags, err = main.integers(tagFileFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlgorithm, err := main.integers(tagPayloadDigestAlgo)
+	if err != nil {
+		return nil, err
+	}
+	if len(digestAlgorithm) != 0 {
+		algorithm, found := payloadDigestAlgorithms[digestAlgorithm[0]]
+		if !found {
+			algorithm = fmt.Sprintf("unknown(%d)", digestAlgorithm[0])
+		}
+		packageHeader.PayloadDigestAlgorithm = algorithm
+	}
+
+	return
+}
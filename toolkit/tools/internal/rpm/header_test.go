@@ -0,0 +1,269 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHeaderEntry is an entry of a header built by the tests.
+type testHeaderEntry struct {
+	tag       uint32
+	entryType uint32
+	value     interface{}
+}
+
+// buildTestHeader encodes a header structure with the given entries.
+func buildTestHeader(entries []testHeaderEntry) []byte {
+	var (
+		index bytes.Buffer
+		data  bytes.Buffer
+	)
+
+	for _, entry := range entries {
+		var count int
+
+		// Integers are aligned in the data store.
+		alignment := map[uint32]int{headerTypeInt16: 2, headerTypeInt32: 4, headerTypeInt64: 8}[entry.entryType]
+		for alignment != 0 && data.Len()%alignment != 0 {
+			data.WriteByte(0)
+		}
+		offset := data.Len()
+
+		switch value := entry.value.(type) {
+		case string:
+			data.WriteString(value)
+			data.WriteByte(0)
+			count = 1
+		case []string:
+			for _, s := range value {
+				data.WriteString(s)
+				data.WriteByte(0)
+			}
+			count = len(value)
+		case []uint16:
+			binary.Write(&data, binary.BigEndian, value)
+			count = len(value)
+		case []int32:
+			binary.Write(&data, binary.BigEndian, value)
+			count = len(value)
+		case []int64:
+			binary.Write(&data, binary.BigEndian, value)
+			count = len(value)
+		case []byte:
+			data.Write(value)
+			count = len(value)
+		}
+
+		binary.Write(&index, binary.BigEndian, []uint32{entry.tag, entry.entryType, uint32(offset), uint32(count)})
+	}
+
+	var result bytes.Buffer
+	result.Write(headerMagic)
+	result.Write([]byte{0, 0, 0, 0})
+	binary.Write(&result, binary.BigEndian, []uint32{uint32(len(entries)), uint32(data.Len())})
+	result.Write(index.Bytes())
+	result.Write(data.Bytes())
+
+	return result.Bytes()
+}
+
+// buildTestRPM encodes an RPM with a lead, a signature header, a main header and a fake payload.
+func buildTestRPM(leadType uint16, mainEntries []testHeaderEntry) []byte {
+	// A 5 byte string, so the signature header needs padding.
+	return buildSignedTestRPM(leadType, []testHeaderEntry{{tag: 1000, entryType: headerTypeString, value: "1234"}}, mainEntries)
+}
+
+// buildSignedTestRPM encodes an RPM like buildTestRPM, with the given signature header entries.
+func buildSignedTestRPM(leadType uint16, signatureEntries, mainEntries []testHeaderEntry) []byte {
+	var result bytes.Buffer
+
+	lead := make([]byte, leadSize)
+	copy(lead, leadMagic)
+	binary.BigEndian.PutUint16(lead[6:8], leadType)
+	result.Write(lead)
+
+	result.Write(buildTestHeader(signatureEntries))
+	for result.Len()%signatureAlignment != 0 {
+		result.WriteByte(0)
+	}
+
+	result.Write(buildTestHeader(mainEntries))
+	result.WriteString("payload")
+
+	return result.Bytes()
+}
+
+func testPackageEntries() []testHeaderEntry {
+	return []testHeaderEntry{
+		{tagName, headerTypeString, "zlib"},
+		{tagVersion, headerTypeString, "1.3.1"},
+		{tagRelease, headerTypeString, "1.azl3"},
+		{tagEpoch, headerTypeInt32, []int32{2}},
+		{tagSummary, headerTypeI18NString, "Compression library"},
+		{tagBuildTime, headerTypeInt32, []int32{1700000000}},
+		{tagSize, headerTypeInt32, []int32{1024}},
+		{tagLongSize, headerTypeInt64, []int64{5000000000}},
+		{tagArch, headerTypeString, "x86_64"},
+		{tagSourceRPM, headerTypeString, "zlib-1.3.1-1.azl3.src.rpm"},
+		{tagProvideName, headerTypeStringArray, []string{"libz.so.1()(64bit)", "zlib", "zlib(x86-64)"}},
+		{tagProvideFlags, headerTypeInt32, []int32{0, senseEqual, senseEqual}},
+		{tagProvideVersion, headerTypeStringArray, []string{"", "2:1.3.1-1.azl3", "2:1.3.1-1.azl3"}},
+		{tagRequireName, headerTypeStringArray, []string{"glibc", "rpmlib(PayloadIsZstd)"}},
+		{tagRequireFlags, headerTypeInt32, []int32{senseGreater | senseEqual, senseLess | senseEqual}},
+		{tagRequireVersion, headerTypeStringArray, []string{"2.38", "5.4.18-1"}},
+		{tagDirIndexes, headerTypeInt32, []int32{0, 1, 0}},
+		{tagBaseNames, headerTypeStringArray, []string{"libz.so.1", "LICENSE", "libz.so.1.3.1"}},
+		{tagDirNames, headerTypeStringArray, []string{"/usr/lib/", "/usr/share/licenses/zlib/"}},
+		{tagFileModes, headerTypeInt16, []uint16{0o100755, 0o100644, 0o40755}},
+		{tagFileFlags, headerTypeInt32, []int32{0, fileFlagGhost, 0}},
+		{tagPayloadDigest, headerTypeStringArray, []string{"0123456789abcdef"}},
+		{tagPayloadDigestAlgo, headerTypeInt32, []int32{8}},
+	}
+}
+
+func TestParsePackageHeader(t *testing.T) {
+	packageHeader, err := ParsePackageHeader(bytes.NewReader(buildTestRPM(0, testPackageEntries())))
+	require.NoError(t, err)
+
+	assert.Equal(t, "zlib", packageHeader.Name)
+	assert.Equal(t, "1.3.1", packageHeader.Version)
+	assert.Equal(t, "1.azl3", packageHeader.Release)
+	assert.Equal(t, "2", packageHeader.Epoch)
+	assert.Equal(t, "x86_64", packageHeader.Arch)
+	assert.Equal(t, "zlib-1.3.1-1.azl3.src.rpm", packageHeader.SourceRPM)
+	assert.False(t, packageHeader.IsSource)
+	assert.Equal(t, "zlib-1.3.1-1.azl3.x86_64.rpm", packageHeader.FileName())
+	assert.Equal(t, "0123456789abcdef", packageHeader.PayloadDigest)
+	assert.Equal(t, "sha256", packageHeader.PayloadDigestAlgorithm)
+	assert.Equal(t, "Compression library", packageHeader.Summary)
+	assert.Equal(t, int64(1700000000), packageHeader.BuildTime)
+	assert.Equal(t, int64(5000000000), packageHeader.InstalledSize)
+	assert.Equal(t, int64(leadSize+40), packageHeader.HeaderStart)
+	assert.Greater(t, packageHeader.HeaderEnd, packageHeader.HeaderStart)
+
+	assert.False(t, packageHeader.FileIsDirectory(0))
+	assert.True(t, packageHeader.FileIsGhost(1))
+	assert.True(t, packageHeader.FileIsDirectory(2))
+
+	assert.Equal(t, []string{"/usr/lib/libz.so.1", "/usr/share/licenses/zlib/LICENSE", "/usr/lib/libz.so.1.3.1"}, packageHeader.Files)
+
+	provides := []string{}
+	for _, provide := range packageHeader.Provides {
+		provides = append(provides, provide.String())
+	}
+	assert.Equal(t, []string{"libz.so.1()(64bit)", "zlib = 2:1.3.1-1.azl3", "zlib(x86-64) = 2:1.3.1-1.azl3"}, provides)
+
+	requires := []string{}
+	for _, require := range packageHeader.Requires {
+		requires = append(requires, require.String())
+	}
+	assert.Equal(t, []string{"glibc >= 2.38", "rpmlib(PayloadIsZstd) <= 5.4.18-1"}, requires)
+}
+
+func TestParsePackageHeaderSource(t *testing.T) {
+	entries := []testHeaderEntry{
+		{tagName, headerTypeString, "zlib"},
+		{tagVersion, headerTypeString, "1.3.1"},
+		{tagRelease, headerTypeString, "1.azl3"},
+		{tagArch, headerTypeString, "x86_64"},
+	}
+
+	packageHeader, err := ParsePackageHeader(bytes.NewReader(buildTestRPM(leadTypeSource, entries)))
+	require.NoError(t, err)
+
+	assert.True(t, packageHeader.IsSource)
+	assert.Equal(t, "", packageHeader.Epoch)
+	assert.Empty(t, packageHeader.Files)
+	assert.Empty(t, packageHeader.Provides)
+	assert.Equal(t, "zlib-1.3.1-1.azl3.src", packageHeader.NVRA())
+}
+
+func TestParsePackageHeaderSignatures(t *testing.T) {
+	packageHeader, err := ParsePackageHeader(bytes.NewReader(buildTestRPM(0, testPackageEntries())))
+	require.NoError(t, err)
+	assert.Empty(t, packageHeader.Signatures)
+
+	signatureEntries := []testHeaderEntry{
+		{sigTagPGP, headerTypeBinary, []byte("header and payload")},
+		{sigTagRSA, headerTypeBinary, []byte("header")},
+	}
+	packageHeader, err = ParsePackageHeader(bytes.NewReader(buildSignedTestRPM(0, signatureEntries, testPackageEntries())))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("header"), []byte("header and payload")}, packageHeader.Signatures)
+	assert.Equal(t, "zlib", packageHeader.Name)
+
+	invalidEntries := []testHeaderEntry{{sigTagRSA, headerTypeString, "header"}}
+	_, err = ParsePackageHeader(bytes.NewReader(buildSignedTestRPM(0, invalidEntries, testPackageEntries())))
+	assert.ErrorContains(t, err, "expected binary data")
+}
+
+func TestParsePackageHeaderInvalid(t *testing.T) {
+	_, err := ParsePackageHeader(bytes.NewReader([]byte("not an rpm file")))
+	assert.Error(t, err)
+
+	rpmData := buildTestRPM(0, testPackageEntries())
+	lead := make([]byte, leadSize)
+	copy(lead, "not an rpm file")
+	_, err = ParsePackageHeader(bytes.NewReader(append(lead, rpmData[leadSize:]...)))
+	assert.ErrorContains(t, err, "invalid lead magic")
+
+	_, err = ParsePackageHeader(bytes.NewReader(rpmData[:len(rpmData)/2]))
+	assert.Error(t, err)
+
+	mismatchedEntries := append(testPackageEntries(), testHeaderEntry{tagProvideFlags, headerTypeInt32, []int32{0}})
+	_, err = ParsePackageHeader(bytes.NewReader(buildTestRPM(0, mismatchedEntries)))
+	assert.ErrorContains(t, err, "mismatched number")
+}
+
+func TestReadHeaderEntryOutsideOfData(t *testing.T) {
+	const (
+		entryOffset = headerIntroSize + 8
+		entryCount  = headerIntroSize + 12
+	)
+
+	headerData := buildTestHeader([]testHeaderEntry{{tagName, headerTypeString, "bash"}})
+
+	// Even an empty entry must not point past the data.
+	binary.BigEndian.PutUint32(headerData[entryOffset:], 100)
+	binary.BigEndian.PutUint32(headerData[entryCount:], 0)
+	_, err := readHeader(bytes.NewReader(headerData))
+	assert.ErrorContains(t, err, "is outside of the header")
+
+	// An empty entry may point at the end of the data.
+	binary.BigEndian.PutUint32(headerData[entryOffset:], uint32(len("bash")+1))
+	parsedHeader, err := readHeader(bytes.NewReader(headerData))
+	require.NoError(t, err)
+
+	values, err := parsedHeader.strings(tagName)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	binary.BigEndian.PutUint32(headerData[entryCount:], 1)
+	_, err = readHeader(bytes.NewReader(headerData))
+	assert.ErrorContains(t, err, "is outside of the header")
+}
+
+func TestQueryRPMProvides(t *testing.T) {
+	rpmFile := filepath.Join(t.TempDir(), "zlib-1.3.1-1.azl3.x86_64.rpm")
+	require.NoError(t, os.WriteFile(rpmFile, buildTestRPM(0, testPackageEntries()), 0o644))
+
+	provides, err := QueryRPMProvides(rpmFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/usr/lib/libz.so.1",
+		"/usr/share/licenses/zlib/LICENSE",
+		"/usr/lib/libz.so.1.3.1",
+		"libz.so.1()(64bit)",
+		"zlib = 2:1.3.1-1.azl3",
+		"zlib(x86-64) = 2:1.3.1-1.azl3",
+	}, provides)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repodata
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is the compression of the metadata files.
+type Compression string
+
+const (
+	// GzipCompression is supported by all package managers.
+	GzipCompression Compression = "gz"
+	// ZstdCompression requires libsolv built with zstd support.
+	ZstdCompression Compression = "zst"
+	// NoCompression writes plain XML files.
+	NoCompression Compression = ""
+)
+
+// Compressions returns the supported compressions of the metadata files, to be used by CLI flags.
+func Compressions() []string {
+	return []string{string(GzipCompression), string(ZstdCompression)}
+}
+
+// extension returns the file extension of a metadata file with the compression.
+func (c Compression) extension() string {
+	if c == NoCompression {
+		return ""
+	}
+
+	return "." + string(c)
+}
+
+// newWriter compresses the data written to writer.
+func (c Compression) newWriter(writer io.Writer) (compressedWriter io.WriteCloser, err error) {
+	switch c {
+	case GzipCompression:
+		return gzip.NewWriter(writer), nil
+	case ZstdCompression:
+		return zstd.NewWriter(writer)
+	case NoCompression:
+		return nopWriteCloser{writer}, nil
+	default:
+		return nil, fmt.Errorf("unsupported metadata compression (%s)", c)
+	}
+}
+
+// newDecompressingReader decompresses a metadata file, based on the extension of its path.
+// Besides the compressions it can write, it reads the xz and bzip2 compressed files of other repositories.
+func newDecompressingReader(filePath string, reader io.Reader) (decompressedReader io.ReadCloser, err error) {
+	switch {
+	case strings.HasSuffix(filePath, ".gz"):
+		return gzip.NewReader(reader)
+	case strings.HasSuffix(filePath, ".zst"):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{decoder}, nil
+	case strings.HasSuffix(filePath, ".xz"):
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case strings.HasSuffix(filePath, ".bz2"):
+		return io.NopCloser(bzip2.NewReader(reader)), nil
+	default:
+		return io.NopCloser(reader), nil
+	}
+}
+
+// nopWriteCloser is a writer which does nothing on Close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// zstdReadCloser adapts the zstd decoder, whose Close doesn't return an error.
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
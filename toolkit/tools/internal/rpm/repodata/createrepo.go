@@ -0,0 +1,487 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repodata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+)
+
+const (
+	rpmExtension     = ".rpm"
+	repoDataLockDir  = ".repodata"
+	repoDataOldDir   = ".repodata.old"
+	checksumType     = "sha256"
+	rpmPackageType   = "rpm"
+	pkgIDYes         = "YES"
+	directoryFile    = "dir"
+	ghostFile        = "ghost"
+	rpmlibDependency = "rpmlib("
+)
+
+// Options configures the creation of the metadata of a repository.
+type Options struct {
+	// Compression of the metadata files.
+	Compression Compression
+	// Updates are written to the updateinfo metadata, which is skipped if there are none.
+	Updates []*Update
+	// Update reuses the existing metadata of the packages which didn't change, instead of reading them again.
+	Update bool
+}
+
+// CreateRepo creates the metadata of the repository in repoDir from all the RPMs found under it, the same way
+// 'createrepo' does. The previous metadata is replaced atomically.
+func CreateRepo(repoDir string, options Options) (err error) {
+	var previous *Repository
+
+	if options.Update {
+		previous, err = ReadRepo(repoDir)
+		if err != nil {
+			logger.Log.Debugf("Creating the metadata of (%s) from scratch, the existing metadata can't be reused:\n%s", repoDir, err)
+			previous, err = nil, nil
+		}
+	}
+
+	rpmFiles, err := findRPMs(repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to find the RPMs of repository (%s):\n%w", repoDir, err)
+	}
+
+	reusable := reusablePackages(previous)
+	repository := &Repository{
+		Primary:   &Primary{Packages: []*Package{}},
+		Filelists: &Filelists{Packages: []*FilelistsPackage{}},
+	}
+
+	for _, location := range rpmFiles {
+		var (
+			primaryPackage   *Package
+			filelistsPackage *FilelistsPackage
+		)
+
+		rpmFile := filepath.Join(repoDir, filepath.FromSlash(location))
+		fileInfo, err := os.Stat(rpmFile)
+		if err != nil {
+			return err
+		}
+
+		if old, found := reusable[location]; found && old.primary.Time.File == fileInfo.ModTime().Unix() && old.primary.Size.Package == fileInfo.Size() {
+			primaryPackage, filelistsPackage = old.primary, old.filelists
+		} else {
+			primaryPackage, filelistsPackage, err = readPackage(rpmFile, location, fileInfo)
+			if err != nil {
+				return err
+			}
+		}
+
+		repository.Primary.Packages = append(repository.Primary.Packages, primaryPackage)
+		repository.Filelists.Packages = append(repository.Filelists.Packages, filelistsPackage)
+	}
+
+	if len(options.Updates) != 0 {
+		repository.Updateinfo = &Updateinfo{Updates: options.Updates}
+	}
+
+	logger.Log.Debugf("Writing the metadata of (%d) packages to (%s)", len(rpmFiles), repoDir)
+
+	return WriteRepo(repoDir, repository, options.Compression)
+}
+
+// WriteRepo writes the metadata of a repository and its index (repomd.xml) to the repodata directory of repoDir.
+// The metadata is written to a temporary directory first, then swapped with the previous metadata, so that readers
+// never see a repository without metadata.
+func WriteRepo(repoDir string, repository *Repository, compression Compression) (err error) {
+	repoDataPath := filepath.Join(repoDir, RepoDataDir)
+	tempRepoDataPath := filepath.Join(repoDir, repoDataLockDir)
+	oldRepoDataPath := filepath.Join(repoDir, repoDataOldDir)
+
+	err = os.RemoveAll(tempRepoDataPath)
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(tempRepoDataPath, os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tempRepoDataPath)
+
+	repository.Primary.Xmlns = commonNamespace
+	repository.Primary.XmlnsRPM = rpmNamespace
+	repository.Primary.PackageCount = len(repository.Primary.Packages)
+
+	repoMD := &RepoMD{
+		Xmlns:    repoNamespace,
+		XmlnsRPM: rpmNamespace,
+		Revision: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+
+	metadataByType := map[string]interface{}{
+		PrimaryType: repository.Primary,
+	}
+
+	if repository.Filelists != nil {
+		repository.Filelists.Xmlns = filelistsNamespace
+		repository.Filelists.PackageCount = len(repository.Filelists.Packages)
+		metadataByType[FilelistsType] = repository.Filelists
+	}
+
+	if repository.Updateinfo != nil {
+		metadataByType[UpdateinfoType] = repository.Updateinfo
+	}
+
+	for _, dataType := range []string{PrimaryType, FilelistsType, UpdateinfoType} {
+		var data *RepoData
+
+		metadata, found := metadataByType[dataType]
+		if !found {
+			continue
+		}
+
+		data, err = writeMetadataFile(tempRepoDataPath, dataType, metadata, compression)
+		if err != nil {
+			return fmt.Errorf("failed to write (%s) metadata:\n%w", dataType, err)
+		}
+		repoMD.Data = append(repoMD.Data, data)
+	}
+
+	repoMDData, err := encodeXML(repoMD)
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(tempRepoDataPath, repoMDFile), repoMDData, 0o644)
+	if err != nil {
+		return
+	}
+
+	return swapRepoData(tempRepoDataPath, repoDataPath, oldRepoDataPath)
+}
+
+// swapRepoData replaces the metadata directory with the new one. The previous metadata is renamed aside first and
+// only deleted once the new metadata is in place, and is put back if the new metadata can't be moved in.
+//
+// Overlay filesystems can't rename directories of their lower layer (EXDEV), as with the repositories mounted in the
+// build chroots, so the previous metadata is deleted instead of being kept as a fallback there.
+func swapRepoData(newPath, repoDataPath, oldPath string) (err error) {
+	err = os.RemoveAll(oldPath)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(repoDataPath, oldPath)
+	switch {
+	case os.IsNotExist(err):
+		return os.Rename(newPath, repoDataPath)
+	case errors.Is(err, syscall.EXDEV):
+		logger.Log.Debugf("Can't move the previous metadata of (%s) aside, replacing it instead.", filepath.Dir(repoDataPath))
+
+		err = os.RemoveAll(repoDataPath)
+		if err != nil {
+			return fmt.Errorf("failed to remove the previous metadata:\n%w", err)
+		}
+
+		return os.Rename(newPath, repoDataPath)
+	case err != nil:
+		return fmt.Errorf("failed to move the previous metadata aside:\n%w", err)
+	}
+
+	err = os.Rename(newPath, repoDataPath)
+	if err != nil {
+		restoreErr := os.Rename(oldPath, repoDataPath)
+		if restoreErr != nil {
+			logger.Log.Warnf("Failed to restore the previous metadata of (%s): %s", filepath.Dir(repoDataPath), restoreErr)
+		}
+		return fmt.Errorf("failed to move the new metadata in place:\n%w", err)
+	}
+
+	return os.RemoveAll(oldPath)
+}
+
+// writeMetadataFile writes a compressed metadata file named after its checksum, and describes it for repomd.xml.
+func writeMetadataFile(dir, dataType string, metadata interface{}, compression Compression) (data *RepoData, err error) {
+	openData, err := encodeXML(metadata)
+	if err != nil {
+		return
+	}
+
+	var compressedData bytes.Buffer
+	writer, err := compression.newWriter(&compressedData)
+	if err != nil {
+		return
+	}
+
+	_, err = writer.Write(openData)
+	if err != nil {
+		writer.Close()
+		return
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return
+	}
+
+	openChecksum := sha256.Sum256(openData)
+	checksum := sha256.Sum256(compressedData.Bytes())
+	checksumHex := hex.EncodeToString(checksum[:])
+
+	fileName := fmt.Sprintf("%s-%s.xml%s", checksumHex, dataType, compression.extension())
+	err = os.WriteFile(filepath.Join(dir, fileName), compressedData.Bytes(), 0o644)
+	if err != nil {
+		return
+	}
+
+	data = &RepoData{
+		Type:         dataType,
+		Checksum:     &Checksum{Type: checksumType, Value: checksumHex},
+		OpenChecksum: &Checksum{Type: checksumType, Value: hex.EncodeToString(openChecksum[:])},
+		Location:     &Location{Href: path.Join(RepoDataDir, fileName)},
+		Timestamp:    time.Now().Unix(),
+		Size:         int64(compressedData.Len()),
+		OpenSize:     int64(len(openData)),
+	}
+
+	return
+}
+
+// findRPMs returns the paths of all RPMs under repoDir, relative to it and sorted.
+func findRPMs(repoDir string) (locations []string, err error) {
+	err = filepath.WalkDir(repoDir, func(filePath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == RepoDataDir || entry.Name() == repoDataLockDir || entry.Name() == repoDataOldDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(entry.Name(), rpmExtension) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(repoDir, filePath)
+		if err != nil {
+			return err
+		}
+		locations = append(locations, filepath.ToSlash(relativePath))
+
+		return nil
+	})
+
+	sort.Strings(locations)
+
+	return
+}
+
+// reusablePackage is the metadata of a package from a previous run.
+type reusablePackage struct {
+	primary   *Package
+	filelists *FilelistsPackage
+}
+
+// reusablePackages maps the location of each package with both primary and filelists metadata to that metadata.
+func reusablePackages(previous *Repository) (packages map[string]reusablePackage) {
+	packages = make(map[string]reusablePackage)
+	if previous == nil || previous.Filelists == nil {
+		return
+	}
+
+	filelistsByID := make(map[string]*FilelistsPackage)
+	for _, filelistsPackage := range previous.Filelists.Packages {
+		filelistsByID[filelistsPackage.PkgID] = filelistsPackage
+	}
+
+	for _, primaryPackage := range previous.Primary.Packages {
+		if primaryPackage.Location == nil || primaryPackage.Checksum == nil || primaryPackage.Time == nil || primaryPackage.Size == nil {
+			continue
+		}
+
+		filelistsPackage, found := filelistsByID[primaryPackage.Checksum.Value]
+		if found {
+			packages[primaryPackage.Location.Href] = reusablePackage{primary: primaryPackage, filelists: filelistsPackage}
+		}
+	}
+
+	return
+}
+
+// readPackage reads the metadata of an RPM file.
+func readPackage(rpmFile, location string, fileInfo os.FileInfo) (primaryPackage *Package, filelistsPackage *FilelistsPackage, err error) {
+	packageHeader, err := rpm.ReadPackageHeader(rpmFile)
+	if err != nil {
+		return
+	}
+
+	checksum, err := fileChecksum(rpmFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to calculate the checksum of (%s):\n%w", rpmFile, err)
+	}
+
+	primaryPackage, filelistsPackage = newPackage(packageHeader, location, checksum, fileInfo.ModTime().Unix(), fileInfo.Size())
+
+	return
+}
+
+// fileChecksum returns the hex encoded SHA-256 checksum of a file.
+func fileChecksum(filePath string) (checksum string, err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// newPackage converts the header of an RPM to its primary and filelists metadata.
+func newPackage(packageHeader *rpm.PackageHeader, location, checksum string, fileTime, fileSize int64) (primaryPackage *Package, filelistsPackage *FilelistsPackage) {
+	arch := packageHeader.Arch
+	if packageHeader.IsSource {
+		arch = "src"
+	}
+
+	epoch := packageHeader.Epoch
+	if epoch == "" {
+		epoch = "0"
+	}
+	version := &Version{Epoch: epoch, Version: packageHeader.Version, Release: packageHeader.Release}
+
+	primaryFiles := []*File{}
+	allFiles := []*File{}
+	for i, filePath := range packageHeader.Files {
+		file := &File{Path: filePath}
+		switch {
+		case packageHeader.FileIsDirectory(i):
+			file.Type = directoryFile
+		case packageHeader.FileIsGhost(i):
+			file.Type = ghostFile
+		}
+
+		allFiles = append(allFiles, file)
+		if isPrimaryFile(filePath) {
+			primaryFiles = append(primaryFiles, file)
+		}
+	}
+
+	primaryPackage = &Package{
+		Type:        rpmPackageType,
+		Name:        packageHeader.Name,
+		Arch:        arch,
+		Version:     version,
+		Checksum:    &Checksum{Type: checksumType, PkgID: pkgIDYes, Value: checksum},
+		Summary:     packageHeader.Summary,
+		Description: packageHeader.Description,
+		Packager:    packageHeader.Packager,
+		URL:         packageHeader.URL,
+		Time:        &Time{File: fileTime, Build: packageHeader.BuildTime},
+		Size:        &Size{Package: fileSize, Installed: packageHeader.InstalledSize, Archive: packageHeader.ArchiveSize},
+		Location:    &Location{Href: location},
+		Format: &Format{
+			License:     packageHeader.License,
+			Vendor:      packageHeader.Vendor,
+			Group:       packageHeader.Group,
+			BuildHost:   packageHeader.BuildHost,
+			SourceRPM:   packageHeader.SourceRPM,
+			HeaderRange: &HeaderRange{Start: packageHeader.HeaderStart, End: packageHeader.HeaderEnd},
+			Provides:    newEntries(packageHeader.Provides, false),
+			Requires:    newEntries(packageHeader.Requires, true),
+			Conflicts:   newEntries(packageHeader.Conflicts, false),
+			Obsoletes:   newEntries(packageHeader.Obsoletes, false),
+			Suggests:    newEntries(packageHeader.Suggests, false),
+			Enhances:    newEntries(packageHeader.Enhances, false),
+			Recommends:  newEntries(packageHeader.Recommends, false),
+			Supplements: newEntries(packageHeader.Supplements, false),
+			Files:       primaryFiles,
+		},
+	}
+
+	filelistsPackage = &FilelistsPackage{
+		PkgID:   checksum,
+		Name:    packageHeader.Name,
+		Arch:    arch,
+		Version: version,
+		Files:   allFiles,
+	}
+
+	return
+}
+
+// newEntries converts dependencies to their metadata, or nil if there are none. Like createrepo, duplicates and the
+// requires on rpm features ("rpmlib(...)") are dropped.
+func newEntries(dependencies []*rpm.Dependency, isRequires bool) (entries *Entries) {
+	seen := make(map[Entry]bool)
+	entries = &Entries{}
+
+	for _, dependency := range dependencies {
+		if isRequires && strings.HasPrefix(dependency.Name, rpmlibDependency) {
+			continue
+		}
+
+		entry := Entry{Name: dependency.Name, Flags: dependency.Comparison()}
+		if entry.Flags != "" {
+			entry.Epoch, entry.Version, entry.Release = splitEVR(dependency.Version)
+		}
+		if isRequires && dependency.IsPrerequisite() {
+			entry.Pre = "1"
+		}
+
+		if !seen[entry] {
+			seen[entry] = true
+			entries.Entries = append(entries.Entries, &entry)
+		}
+	}
+
+	if len(entries.Entries) == 0 {
+		return nil
+	}
+
+	return
+}
+
+// splitEVR splits an "[epoch:]version[-release]" string. The epoch defaults to "0".
+func splitEVR(evr string) (epoch, version, release string) {
+	epoch = "0"
+	if index := strings.Index(evr, ":"); index >= 0 {
+		epoch, evr = evr[:index], evr[index+1:]
+	}
+
+	version = evr
+	if index := strings.LastIndex(evr, "-"); index >= 0 {
+		version, release = evr[:index], evr[index+1:]
+	}
+
+	return
+}
+
+// isPrimaryFile reports if a file is listed in the primary metadata, in addition to the filelists metadata. These are
+// the files commonly required by other packages, as selected by createrepo.
+func isPrimaryFile(filePath string) bool {
+	return strings.HasPrefix(filePath, "/etc/") || strings.Contains(filePath, "bin/") || filePath == "/usr/lib/sendmail"
+}
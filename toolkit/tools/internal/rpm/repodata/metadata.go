@@ -0,0 +1,224 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package repodata reads and writes the metadata of RPM repositories, in the format generated by createrepo.
+
+package repodata
+
+import (
+	"encoding/xml"
+)
+
+// Namespaces of the metadata files.
+const (
+	repoNamespace      = "http://linux.duke.edu/metadata/repo"
+	commonNamespace    = "http://linux.duke.edu/metadata/common"
+	rpmNamespace       = "http://linux.duke.edu/metadata/rpm"
+	filelistsNamespace = "http://linux.duke.edu/metadata/filelists"
+
+	// rpmPrefix is the prefix of the elements in rpmNamespace. Package managers based on libsolv match the prefixed
+	// element names, so they must be written with this exact prefix.
+	rpmPrefix = "rpm"
+)
+
+// Types of the metadata files listed in repomd.xml.
+const (
+	PrimaryType    = "primary"
+	FilelistsType  = "filelists"
+	UpdateinfoType = "updateinfo"
+)
+
+// RepoMD is the index of the metadata files of a repository, stored in repodata/repomd.xml.
+type RepoMD struct {
+	XMLName  xml.Name    `xml:"repomd"`
+	Xmlns    string      `xml:"xmlns,attr,omitempty"`
+	XmlnsRPM string      `xml:"xmlns:rpm,attr,omitempty"`
+	Revision string      `xml:"revision"`
+	Data     []*RepoData `xml:"data"`
+}
+
+// RepoData describes a metadata file of a repository.
+type RepoData struct {
+	Type         string    `xml:"type,attr"`
+	Checksum     *Checksum `xml:"checksum"`
+	OpenChecksum *Checksum `xml:"open-checksum,omitempty"`
+	Location     *Location `xml:"location"`
+	Timestamp    int64     `xml:"timestamp"`
+	Size         int64     `xml:"size,omitempty"`
+	OpenSize     int64     `xml:"open-size,omitempty"`
+}
+
+// Checksum is a checksum of a file.
+type Checksum struct {
+	Type  string `xml:"type,attr"`
+	PkgID string `xml:"pkgid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// Location is the path of a file, relative to the root of the repository.
+type Location struct {
+	Href string `xml:"href,attr"`
+}
+
+// Primary is the list of packages of a repository, stored in the primary metadata file.
+type Primary struct {
+	XMLName      xml.Name   `xml:"metadata"`
+	Xmlns        string     `xml:"xmlns,attr,omitempty"`
+	XmlnsRPM     string     `xml:"xmlns:rpm,attr,omitempty"`
+	PackageCount int        `xml:"packages,attr"`
+	Packages     []*Package `xml:"package"`
+}
+
+// Package is a package of a repository.
+type Package struct {
+	Type        string    `xml:"type,attr"`
+	Name        string    `xml:"name"`
+	Arch        string    `xml:"arch"`
+	Version     *Version  `xml:"version"`
+	Checksum    *Checksum `xml:"checksum"`
+	Summary     string    `xml:"summary"`
+	Description string    `xml:"description"`
+	Packager    string    `xml:"packager"`
+	URL         string    `xml:"url"`
+	Time        *Time     `xml:"time"`
+	Size        *Size     `xml:"size"`
+	Location    *Location `xml:"location"`
+	Format      *Format   `xml:"format"`
+}
+
+// Version is the epoch, version and release of a package or a dependency.
+type Version struct {
+	Epoch   string `xml:"epoch,attr,omitempty"`
+	Version string `xml:"ver,attr,omitempty"`
+	Release string `xml:"rel,attr,omitempty"`
+}
+
+// Time holds the modification time of the package file and the build time of the package.
+type Time struct {
+	File  int64 `xml:"file,attr"`
+	Build int64 `xml:"build,attr"`
+}
+
+// Size holds the size of the package file, of its installed files and of its uncompressed payload.
+type Size struct {
+	Package   int64 `xml:"package,attr"`
+	Installed int64 `xml:"installed,attr"`
+	Archive   int64 `xml:"archive,attr"`
+}
+
+// Format holds the RPM specific metadata of a package.
+type Format struct {
+	License     string       `xml:"rpm:license"`
+	Vendor      string       `xml:"rpm:vendor"`
+	Group       string       `xml:"rpm:group"`
+	BuildHost   string       `xml:"rpm:buildhost"`
+	SourceRPM   string       `xml:"rpm:sourcerpm"`
+	HeaderRange *HeaderRange `xml:"rpm:header-range"`
+	Provides    *Entries     `xml:"rpm:provides,omitempty"`
+	Requires    *Entries     `xml:"rpm:requires,omitempty"`
+	Conflicts   *Entries     `xml:"rpm:conflicts,omitempty"`
+	Obsoletes   *Entries     `xml:"rpm:obsoletes,omitempty"`
+	Suggests    *Entries     `xml:"rpm:suggests,omitempty"`
+	Enhances    *Entries     `xml:"rpm:enhances,omitempty"`
+	Recommends  *Entries     `xml:"rpm:recommends,omitempty"`
+	Supplements *Entries     `xml:"rpm:supplements,omitempty"`
+	Files       []*File      `xml:"file"`
+}
+
+// HeaderRange is the range of the main header in the package file.
+type HeaderRange struct {
+	Start int64 `xml:"start,attr"`
+	End   int64 `xml:"end,attr"`
+}
+
+// Entries is a list of dependencies of a package.
+type Entries struct {
+	Entries []*Entry `xml:"rpm:entry"`
+}
+
+// Entry is a dependency of a package.
+type Entry struct {
+	Name    string `xml:"name,attr"`
+	Flags   string `xml:"flags,attr,omitempty"`
+	Epoch   string `xml:"epoch,attr,omitempty"`
+	Version string `xml:"ver,attr,omitempty"`
+	Release string `xml:"rel,attr,omitempty"`
+	Pre     string `xml:"pre,attr,omitempty"`
+}
+
+// File is a file of a package. Its type is empty for regular files.
+type File struct {
+	Type string `xml:"type,attr,omitempty"`
+	Path string `xml:",chardata"`
+}
+
+// Filelists is the list of files of all packages of a repository, stored in the filelists metadata file.
+type Filelists struct {
+	XMLName      xml.Name            `xml:"filelists"`
+	Xmlns        string              `xml:"xmlns,attr,omitempty"`
+	PackageCount int                 `xml:"packages,attr"`
+	Packages     []*FilelistsPackage `xml:"package"`
+}
+
+// FilelistsPackage is the list of files of a package.
+type FilelistsPackage struct {
+	PkgID   string   `xml:"pkgid,attr"`
+	Name    string   `xml:"name,attr"`
+	Arch    string   `xml:"arch,attr"`
+	Version *Version `xml:"version"`
+	Files   []*File  `xml:"file"`
+}
+
+// Updateinfo is the list of updates (advisories) of a repository, stored in the updateinfo metadata file.
+type Updateinfo struct {
+	XMLName xml.Name  `xml:"updates"`
+	Updates []*Update `xml:"update"`
+}
+
+// Update is an advisory for a set of packages.
+type Update struct {
+	From        string        `xml:"from,attr,omitempty"`
+	Status      string        `xml:"status,attr,omitempty"`
+	Type        string        `xml:"type,attr,omitempty"`
+	Version     string        `xml:"version,attr,omitempty"`
+	ID          string        `xml:"id"`
+	Title       string        `xml:"title"`
+	Issued      *UpdateDate   `xml:"issued,omitempty"`
+	Updated     *UpdateDate   `xml:"updated,omitempty"`
+	Severity    string        `xml:"severity,omitempty"`
+	Summary     string        `xml:"summary,omitempty"`
+	Description string        `xml:"description,omitempty"`
+	References  []*Reference  `xml:"references>reference"`
+	Collections []*Collection `xml:"pkglist>collection"`
+}
+
+// UpdateDate is the date an update was issued or updated.
+type UpdateDate struct {
+	Date string `xml:"date,attr"`
+}
+
+// Reference links an update to an external resource, e.g. a CVE.
+type Reference struct {
+	Href  string `xml:"href,attr,omitempty"`
+	ID    string `xml:"id,attr,omitempty"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+// Collection is a list of packages fixed by an update.
+type Collection struct {
+	Short    string           `xml:"short,attr,omitempty"`
+	Name     string           `xml:"name,omitempty"`
+	Packages []*UpdatePackage `xml:"package"`
+}
+
+// UpdatePackage is a package fixed by an update.
+type UpdatePackage struct {
+	Name     string `xml:"name,attr"`
+	Epoch    string `xml:"epoch,attr,omitempty"`
+	Version  string `xml:"version,attr"`
+	Release  string `xml:"release,attr"`
+	Arch     string `xml:"arch,attr"`
+	Src      string `xml:"src,attr,omitempty"`
+	Filename string `xml:"filename"`
+}
@@ -0,0 +1,260 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repodata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/network"
+)
+
+const (
+	// RepoDataDir is the directory of the metadata files, relative to the root of the repository.
+	RepoDataDir = "repodata"
+	repoMDFile  = "repomd.xml"
+)
+
+// Repository holds the metadata of a repository. Filelists and Updateinfo are nil if the repository doesn't have them.
+type Repository struct {
+	Primary    *Primary
+	Filelists  *Filelists
+	Updateinfo *Updateinfo
+}
+
+// checksumHashes maps the checksum types used by createrepo to their hash functions.
+var checksumHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha":    sha1.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// ReadRepo reads the primary, filelists and updateinfo metadata of the repository in repoDir.
+// The checksums of the metadata files are verified against repomd.xml.
+func ReadRepo(repoDir string) (repository *Repository, err error) {
+	repoMD := &RepoMD{}
+	err = readXMLFile(filepath.Join(repoDir, RepoDataDir, repoMDFile), nil, repoMD)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index of repository (%s):\n%w", repoDir, err)
+	}
+
+	repository = &Repository{}
+	for _, data := range repoMD.Data {
+		var metadata interface{}
+		switch data.Type {
+		case PrimaryType:
+			repository.Primary = &Primary{}
+			metadata = repository.Primary
+		case FilelistsType:
+			repository.Filelists = &Filelists{}
+			metadata = repository.Filelists
+		case UpdateinfoType:
+			repository.Updateinfo = &Updateinfo{}
+			metadata = repository.Updateinfo
+		default:
+			continue
+		}
+
+		if data.Location == nil {
+			return nil, fmt.Errorf("(%s) metadata of repository (%s) has no location", data.Type, repoDir)
+		}
+
+		err = readXMLFile(filepath.Join(repoDir, data.Location.Href), data.Checksum, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read (%s) metadata of repository (%s):\n%w", data.Type, repoDir, err)
+		}
+	}
+
+	if repository.Primary == nil {
+		return nil, fmt.Errorf("repository (%s) has no primary metadata", repoDir)
+	}
+
+	return
+}
+
+// ReadRemoteRepoMD downloads the index (repomd.xml) of the repository at baseUrl into tempDir and reads it.
+func ReadRemoteRepoMD(ctx context.Context, baseUrl string, tempDir string) (repoMD *RepoMD, err error) {
+	repoMDPath := filepath.Join(tempDir, RepoDataDir, repoMDFile)
+	err = os.MkdirAll(filepath.Dir(repoMDPath), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = network.DownloadFile(ctx, remoteUrl(baseUrl, path.Join(RepoDataDir, repoMDFile)), repoMDPath, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the index of repository (%s):\n%w", baseUrl, err)
+	}
+
+	repoMD = &RepoMD{}
+	err = readXMLFile(repoMDPath, nil, repoMD)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index of repository (%s):\n%w", baseUrl, err)
+	}
+
+	return repoMD, nil
+}
+
+// ReadRemotePrimary downloads the primary metadata of the repository at baseUrl into tempDir and reads it. The
+// checksum of the primary metadata file is verified against repomd.xml.
+func ReadRemotePrimary(ctx context.Context, baseUrl string, tempDir string) (primary *Primary, err error) {
+	repoMD, err := ReadRemoteRepoMD(ctx, baseUrl, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, data := range repoMD.Data {
+		if data.Type != PrimaryType {
+			continue
+		}
+
+		if data.Location == nil {
+			return nil, fmt.Errorf("(%s) metadata of repository (%s) has no location", data.Type, baseUrl)
+		}
+
+		// Keep the file within tempDir, whatever the location is.
+		href := path.Clean("/" + data.Location.Href)
+		primaryPath := filepath.Join(tempDir, filepath.FromSlash(href))
+		err = os.MkdirAll(filepath.Dir(primaryPath), os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+
+		err = network.DownloadFile(ctx, remoteUrl(baseUrl, href), primaryPath, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download (%s) metadata of repository (%s):\n%w", data.Type, baseUrl, err)
+		}
+
+		primary = &Primary{}
+		err = readXMLFile(primaryPath, data.Checksum, primary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read (%s) metadata of repository (%s):\n%w", data.Type, baseUrl, err)
+		}
+
+		return primary, nil
+	}
+
+	return nil, fmt.Errorf("repository (%s) has no primary metadata", baseUrl)
+}
+
+// remoteUrl joins a repository's base URL and the path of one of its files.
+func remoteUrl(baseUrl string, filePath string) string {
+	return strings.TrimSuffix(baseUrl, "/") + "/" + strings.TrimPrefix(filePath, "/")
+}
+
+// readXMLFile decodes a metadata file, decompressing it based on its extension. If checksum is set, the compressed
+// file must match it.
+func readXMLFile(filePath string, checksum *Checksum, metadata interface{}) (err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var (
+		reader io.Reader = bufio.NewReader(file)
+		hasher hash.Hash
+	)
+
+	if checksum != nil {
+		newHash, found := checksumHashes[checksum.Type]
+		if !found {
+			return fmt.Errorf("unsupported checksum type (%s)", checksum.Type)
+		}
+		hasher = newHash()
+		reader = io.TeeReader(reader, hasher)
+	}
+
+	decompressedReader, err := newDecompressingReader(filePath, reader)
+	if err != nil {
+		return fmt.Errorf("failed to decompress (%s):\n%w", filePath, err)
+	}
+	defer decompressedReader.Close()
+
+	decoder := xml.NewTokenDecoder(&rpmPrefixReader{decoder: xml.NewDecoder(decompressedReader)})
+	err = decoder.Decode(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to decode (%s):\n%w", filePath, err)
+	}
+
+	if hasher != nil {
+		// Hash the rest of the file, which the decoder may not have read.
+		_, err = io.Copy(io.Discard, reader)
+		if err != nil {
+			return
+		}
+
+		actualChecksum := hex.EncodeToString(hasher.Sum(nil))
+		if actualChecksum != checksum.Value {
+			return fmt.Errorf("checksum mismatch for (%s): expected (%s), got (%s)", filePath, checksum.Value, actualChecksum)
+		}
+	}
+
+	return
+}
+
+// rpmPrefixReader renames the elements in the rpm namespace to their prefixed names (e.g. "rpm:entry"), so the same
+// struct tags are used to read and write them.
+type rpmPrefixReader struct {
+	decoder *xml.Decoder
+}
+
+func (r *rpmPrefixReader) Token() (token xml.Token, err error) {
+	token, err = r.decoder.Token()
+
+	switch element := token.(type) {
+	case xml.StartElement:
+		element.Name = prefixedName(element.Name)
+		token = element
+	case xml.EndElement:
+		element.Name = prefixedName(element.Name)
+		token = element
+	}
+
+	return
+}
+
+// prefixedName returns the prefixed name of an element in the rpm namespace.
+func prefixedName(name xml.Name) xml.Name {
+	if name.Space != rpmNamespace && name.Space != rpmPrefix {
+		return name
+	}
+
+	return xml.Name{Local: fmt.Sprintf("%s:%s", rpmPrefix, name.Local)}
+}
+
+// encodeXML encodes metadata the way createrepo does, with an XML declaration and indentation.
+func encodeXML(metadata interface{}) (data []byte, err error) {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buffer)
+	encoder.Indent("", "  ")
+
+	err = encoder.Encode(metadata)
+	if err != nil {
+		return
+	}
+
+	buffer.WriteString("\n")
+
+	return buffer.Bytes(), nil
+}
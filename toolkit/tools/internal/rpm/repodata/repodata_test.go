@@ -0,0 +1,228 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package repodata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func testPackageHeader() *rpm.PackageHeader {
+	return &rpm.PackageHeader{
+		Name:      "zlib",
+		Version:   "1.3.1",
+		Release:   "1.azl3",
+		Arch:      "x86_64",
+		SourceRPM: "zlib-1.3.1-1.azl3.src.rpm",
+		Summary:   "Compression library",
+		License:   "Zlib",
+		BuildTime: 1700000000,
+		Provides: []*rpm.Dependency{
+			{Name: "libz.so.1()(64bit)"},
+			{Name: "zlib", Flags: 0x08, Version: "1.3.1-1.azl3"},
+		},
+		Requires: []*rpm.Dependency{
+			{Name: "glibc", Flags: 0x0c, Version: "2:2.38"},
+			{Name: "glibc", Flags: 0x0c, Version: "2:2.38"},
+			{Name: "/sbin/ldconfig", Flags: 0x400},
+			{Name: "rpmlib(PayloadIsZstd)", Flags: 0x0a, Version: "5.4.18-1"},
+		},
+		Recommends:  []*rpm.Dependency{{Name: "zlib-devel"}},
+		Supplements: []*rpm.Dependency{{Name: "(zlib and minizip)"}},
+		Files:       []string{"/etc/zlib.conf", "/usr/lib64/libz.so.1", "/usr/bin/zpipe"},
+		HeaderStart: 4504,
+		HeaderEnd:   9000,
+	}
+}
+
+func TestNewPackage(t *testing.T) {
+	primaryPackage, filelistsPackage := newPackage(testPackageHeader(), "Packages/zlib-1.3.1-1.azl3.x86_64.rpm", "abcd", 1700000100, 2048)
+
+	assert.Equal(t, "zlib", primaryPackage.Name)
+	assert.Equal(t, &Version{Epoch: "0", Version: "1.3.1", Release: "1.azl3"}, primaryPackage.Version)
+	assert.Equal(t, &Checksum{Type: "sha256", PkgID: "YES", Value: "abcd"}, primaryPackage.Checksum)
+	assert.Equal(t, &Time{File: 1700000100, Build: 1700000000}, primaryPackage.Time)
+	assert.Equal(t, int64(2048), primaryPackage.Size.Package)
+	assert.Equal(t, "Packages/zlib-1.3.1-1.azl3.x86_64.rpm", primaryPackage.Location.Href)
+	assert.Equal(t, &HeaderRange{Start: 4504, End: 9000}, primaryPackage.Format.HeaderRange)
+
+	assert.Equal(t, []*Entry{
+		{Name: "libz.so.1()(64bit)"},
+		{Name: "zlib", Flags: "EQ", Epoch: "0", Version: "1.3.1", Release: "1.azl3"},
+	}, primaryPackage.Format.Provides.Entries)
+	assert.Equal(t, []*Entry{
+		{Name: "glibc", Flags: "GE", Epoch: "2", Version: "2.38"},
+		{Name: "/sbin/ldconfig", Pre: "1"},
+	}, primaryPackage.Format.Requires.Entries)
+	assert.Nil(t, primaryPackage.Format.Conflicts)
+	assert.Equal(t, []*Entry{{Name: "zlib-devel"}}, primaryPackage.Format.Recommends.Entries)
+	assert.Equal(t, []*Entry{{Name: "(zlib and minizip)"}}, primaryPackage.Format.Supplements.Entries)
+	assert.Nil(t, primaryPackage.Format.Suggests)
+	assert.Nil(t, primaryPackage.Format.Enhances)
+
+	assert.Equal(t, []*File{{Path: "/etc/zlib.conf"}, {Path: "/usr/bin/zpipe"}}, primaryPackage.Format.Files)
+	assert.Equal(t, "abcd", filelistsPackage.PkgID)
+	assert.Len(t, filelistsPackage.Files, 3)
+}
+
+func TestSplitEVR(t *testing.T) {
+	epoch, version, release := splitEVR("1:2.3-4.azl3")
+	assert.Equal(t, []string{"1", "2.3", "4.azl3"}, []string{epoch, version, release})
+
+	epoch, version, release = splitEVR("2.3")
+	assert.Equal(t, []string{"0", "2.3", ""}, []string{epoch, version, release})
+}
+
+func writeTestRepo(t *testing.T, compression Compression) (repoDir string, repository *Repository) {
+	repoDir = t.TempDir()
+	primaryPackage, filelistsPackage := newPackage(testPackageHeader(), "zlib-1.3.1-1.azl3.x86_64.rpm", "abcd", 1700000100, 2048)
+
+	repository = &Repository{
+		Primary:   &Primary{Packages: []*Package{primaryPackage}},
+		Filelists: &Filelists{Packages: []*FilelistsPackage{filelistsPackage}},
+		Updateinfo: &Updateinfo{Updates: []*Update{{
+			Type:   "security",
+			ID:     "AZL-2024-0001",
+			Title:  "zlib security update",
+			Issued: &UpdateDate{Date: "2024-01-01 00:00:00"},
+			References: []*Reference{
+				{ID: "CVE-2023-45853", Type: "cve"},
+			},
+			Collections: []*Collection{{Packages: []*UpdatePackage{
+				{Name: "zlib", Version: "1.3.1", Release: "1.azl3", Arch: "x86_64", Filename: "zlib-1.3.1-1.azl3.x86_64.rpm"},
+			}}},
+		}}},
+	}
+
+	err := WriteRepo(repoDir, repository, compression)
+	require.NoError(t, err)
+
+	return
+}
+
+func TestWriteAndReadRepo(t *testing.T) {
+	for _, compression := range []Compression{GzipCompression, ZstdCompression, NoCompression} {
+		t.Run(string(compression)+"compression", func(t *testing.T) {
+			repoDir, repository := writeTestRepo(t, compression)
+
+			readRepository, err := ReadRepo(repoDir)
+			require.NoError(t, err)
+
+			assert.Equal(t, repository.Primary.Packages, readRepository.Primary.Packages)
+			assert.Equal(t, 1, readRepository.Primary.PackageCount)
+			assert.Equal(t, repository.Filelists.Packages, readRepository.Filelists.Packages)
+			assert.Equal(t, repository.Updateinfo.Updates, readRepository.Updateinfo.Updates)
+
+			_, err = os.Stat(filepath.Join(repoDir, repoDataLockDir))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestWriteRepoUsesRPMPrefix(t *testing.T) {
+	repoDir, _ := writeTestRepo(t, NoCompression)
+
+	primaryFiles, err := filepath.Glob(filepath.Join(repoDir, RepoDataDir, "*-primary.xml"))
+	require.NoError(t, err)
+	require.Len(t, primaryFiles, 1)
+
+	primary, err := os.ReadFile(primaryFiles[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(primary), `<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="1">`)
+	assert.Contains(t, string(primary), `<rpm:entry name="zlib" flags="EQ" epoch="0" ver="1.3.1" rel="1.azl3"></rpm:entry>`)
+	assert.Contains(t, string(primary), `<rpm:sourcerpm>zlib-1.3.1-1.azl3.src.rpm</rpm:sourcerpm>`)
+	assert.Contains(t, string(primary), "<rpm:recommends>\n        <rpm:entry name=\"zlib-devel\"></rpm:entry>\n      </rpm:recommends>")
+}
+
+func TestWriteRepoReplacesPreviousMetadata(t *testing.T) {
+	repoDir, repository := writeTestRepo(t, GzipCompression)
+
+	repository.Primary.Packages = []*Package{}
+	repository.Filelists.Packages = []*FilelistsPackage{}
+	err := WriteRepo(repoDir, repository, GzipCompression)
+	require.NoError(t, err)
+
+	readRepository, err := ReadRepo(repoDir)
+	require.NoError(t, err)
+	assert.Empty(t, readRepository.Primary.Packages)
+
+	primaryFiles, err := filepath.Glob(filepath.Join(repoDir, RepoDataDir, "*-primary.xml.gz"))
+	require.NoError(t, err)
+	assert.Len(t, primaryFiles, 1)
+	assert.NoDirExists(t, filepath.Join(repoDir, repoDataOldDir))
+	assert.NoDirExists(t, filepath.Join(repoDir, repoDataLockDir))
+}
+
+func TestReadRepoChecksumMismatch(t *testing.T) {
+	repoDir, _ := writeTestRepo(t, NoCompression)
+
+	primaryFiles, err := filepath.Glob(filepath.Join(repoDir, RepoDataDir, "*-primary.xml"))
+	require.NoError(t, err)
+	require.Len(t, primaryFiles, 1)
+
+	primary, err := os.ReadFile(primaryFiles[0])
+	require.NoError(t, err)
+	err = os.WriteFile(primaryFiles[0], []byte(strings.ReplaceAll(string(primary), "zlib", "zlob")), 0o644)
+	require.NoError(t, err)
+
+	_, err = ReadRepo(repoDir)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestReadRemotePrimary(t *testing.T) {
+	repoDir, repository := writeTestRepo(t, GzipCompression)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(repoDir)))
+	defer server.Close()
+
+	primary, err := ReadRemotePrimary(context.Background(), server.URL+"/", t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, repository.Primary.Packages, primary.Packages)
+}
+
+func TestReadRemotePrimaryNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := ReadRemotePrimary(context.Background(), server.URL, t.TempDir())
+	assert.ErrorContains(t, err, "failed to download the index of repository")
+}
+
+func TestReusablePackages(t *testing.T) {
+	_, repository := writeTestRepo(t, GzipCompression)
+
+	reusable := reusablePackages(repository)
+	require.Contains(t, reusable, "zlib-1.3.1-1.azl3.x86_64.rpm")
+	assert.Equal(t, repository.Primary.Packages[0], reusable["zlib-1.3.1-1.azl3.x86_64.rpm"].primary)
+	assert.Equal(t, repository.Filelists.Packages[0], reusable["zlib-1.3.1-1.azl3.x86_64.rpm"].filelists)
+
+	assert.Empty(t, reusablePackages(nil))
+}
+
+func TestCreateRepoWithoutPackages(t *testing.T) {
+	repoDir := t.TempDir()
+
+	err := CreateRepo(repoDir, Options{Compression: GzipCompression, Update: true})
+	require.NoError(t, err)
+
+	repository, err := ReadRepo(repoDir)
+	require.NoError(t, err)
+	assert.Empty(t, repository.Primary.Packages)
+	assert.Nil(t, repository.Updateinfo)
+}
@@ -368,6 +368,14 @@ func GetInstalledPackages() (result []string, err error) {
 	return executeRpmCommand(rpmProgram, queryArg)
 }
 
+// QueryInstalledPackages queries all packages installed on the system with queryFormat. Returns the output split by
+// line and trimmed.
+func QueryInstalledPackages(queryFormat string) (result []string, err error) {
+	const queryArg = "-qa"
+
+	return executeRpmCommand(rpmProgram, queryArg, "--qf", queryFormat)
+}
+
 // QuerySPEC queries a SPEC file with queryFormat. Returns the output split by line and trimmed.
 func QuerySPEC(specFile, sourceDir, queryFormat, arch string, defines map[string]string, extraArgs ...string) (result []string, err error) {
 	const queryArg = "-q"
@@ -505,11 +513,11 @@ func InstallRPM(rpmFile string) (err error) {
 
 const rpmKeysProgram = "rpmkeys"
 
-// importGPGKeysToRPMDb imports GPG keys into an RPM database for signature verification.
+// ImportGPGKeysToRPMDb imports GPG keys into an RPM database for signature verification.
 // - rpmDbRoot: path to a directory to use as the RPM database root (will be created if it doesn't exist)
 // - gpgKeyPaths: paths to GPG key files to import into the RPM database
-// This should be called once before validating multiple RPMs with checkRPMSignature.
-func importGPGKeysToRPMDb(rpmDbRoot string, gpgKeyPaths []string) (err error) {
+// This should be called once before validating multiple RPMs with CheckRPMSignature.
+func ImportGPGKeysToRPMDb(rpmDbRoot string, gpgKeyPaths []string) (err error) {
 	if _, err := exec.LookPath(rpmKeysProgram); err != nil {
 		return fmt.Errorf("%s command not found - explicit GPG signature enforcement requires this tool:\n%w", rpmKeysProgram, err)
 	}
@@ -522,11 +530,11 @@ func importGPGKeysToRPMDb(rpmDbRoot string, gpgKeyPaths []string) (err error) {
 	return nil
 }
 
-// checkRPMSignature validates the GPG signature of an RPM file.
+// CheckRPMSignature validates the GPG signature of an RPM file.
 // - rpmFile: path to the RPM file to validate
-// - rpmDbRoot: path to a directory used as the RPM database root (must have GPG keys already imported via importGPGKeysToRpmDb)
+// - rpmDbRoot: path to a directory used as the RPM database root (must have GPG keys already imported via ImportGPGKeysToRPMDb)
 // Returns an error if the RPM signature is missing or invalid.
-func checkRPMSignature(rpmFile string, rpmDbRoot string) (err error) {
+func CheckRPMSignature(rpmFile string, rpmDbRoot string) (err error) {
 	_, stderr, err := shell.Execute(rpmKeysProgram, "--root", rpmDbRoot, "--checksig", rpmFile, "-D", "%_pkgverify_level signature")
 	if err != nil {
 		return fmt.Errorf("RPM signature validation failed for (%s): %v\n%w", rpmFile, stderr, err)
@@ -548,7 +556,7 @@ func ValidateDirectoryRPMSignatures(rpmDir string, gpgKeyPaths []string) (err er
 	defer os.RemoveAll(rpmDbRoot)
 
 	// Import GPG keys once before validating all RPMs
-	err = importGPGKeysToRPMDb(rpmDbRoot, gpgKeyPaths)
+	err = ImportGPGKeysToRPMDb(rpmDbRoot, gpgKeyPaths)
 	if err != nil {
 		return err
 	}
@@ -578,7 +586,7 @@ func ValidateDirectoryRPMSignatures(rpmDir string, gpgKeyPaths []string) (err er
 	// Validate each RPM
 	for _, rpmFile := range rpmFiles {
 		logger.Log.Debugf("Validating signature of: %s", filepath.Base(rpmFile))
-		err = checkRPMSignature(rpmFile, rpmDbRoot)
+		err = CheckRPMSignature(rpmFile, rpmDbRoot)
 		if err != nil {
 			return fmt.Errorf("GPG signature validation failed:\n%w", err)
 		}
@@ -588,19 +596,21 @@ func ValidateDirectoryRPMSignatures(rpmDir string, gpgKeyPaths []string) (err er
 	return nil
 }
 
-// QueryRPMProvides returns what an RPM file provides.
+// QueryRPMProvides returns what an RPM file provides, in the same format as 'rpm -qlPp'.
 // This includes any provides made by a generator and files provided by the rpm.
+// The RPM header is read directly, without calling rpm.
 func QueryRPMProvides(rpmFile string) (provides []string, err error) {
-	const queryProvidesOption = "-qlPp"
-
 	logger.Log.Debugf("Querying RPM provides (%s)", rpmFile)
-	stdout, stderr, err := shell.Execute(rpmProgram, queryProvidesOption, rpmFile)
+	packageHeader, err := ReadPackageHeader(rpmFile)
 	if err != nil {
-		err = fmt.Errorf("%v\n%w", stderr, err)
 		return
 	}
 
-	provides = sanitizeOutput(stdout)
+	provides = append(provides, packageHeader.Files...)
+	for _, provide := range packageHeader.Provides {
+		provides = append(provides, provide.String())
+	}
+
 	return
 }
 
@@ -713,6 +723,13 @@ func SpecArchIsCompatible(specfile, sourcedir, arch string, defines map[string]s
 
 // SpecHasCheckSection verifies if the spec has the '%check' section.
 func SpecHasCheckSection(specFile, sourceDir, arch string, defines map[string]string) (hasCheckSection bool, err error) {
+	parsedSpec, err := ParseSPEC(specFile, sourceDir, arch, defines)
+
+	return checkSectionRegex.MatchString(parsedSpec), err
+}
+
+// ParseSPEC returns the contents of a spec file with all of its macros expanded.
+func ParseSPEC(specFile, sourceDir, arch string, defines map[string]string) (parsedSpec string, err error) {
 	const (
 		parseSwitch = "--parse"
 		queryFormat = ""
@@ -726,9 +743,45 @@ func SpecHasCheckSection(specFile, sourceDir, arch string, defines map[string]st
 	allDefines := updateSourceDirDefines(defines, sourceDir)
 	args := formatCommandArgs(basicArgs, specFile, queryFormat, allDefines)
 
-	stdout, err := executeRpmCommandRaw(rpmSpecProgram, args...)
+	return executeRpmCommandRaw(rpmSpecProgram, args...)
+}
+
+// DefinedMacros checks which of the macros are defined for the architecture, with the given defines and the macro
+// files of the system. Macros defined by a spec itself are only visible while parsing that spec, so they are never
+// reported as defined.
+func DefinedMacros(arch string, defines map[string]string, macros []string) (defined map[string]bool, err error) {
+	const (
+		evalArgument    = "--eval"
+		definedResult   = "1"
+		undefinedResult = "0"
+	)
+
+	defined = make(map[string]bool)
+	if len(macros) == 0 {
+		return
+	}
+
+	args := formatCommandArgs([]string{TargetArgument, arch}, "", "", defines)
+	// formatCommandArgs always adds the file as the last argument.
+	args = args[:len(args)-1]
+	for _, macro := range macros {
+		args = append(args, evalArgument, fmt.Sprintf("%%{?%[1]s:%[2]s}%%{!?%[1]s:%[3]s}", macro, definedResult, undefinedResult))
+	}
+
+	results, err := executeRpmCommand(rpmProgram, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate macros:\n%w", err)
+	}
+
+	if len(results) != len(macros) {
+		return nil, fmt.Errorf("unexpected number of results (%d) when evaluating (%d) macros", len(results), len(macros))
+	}
 
-	return checkSectionRegex.MatchString(stdout), err
+	for i, macro := range macros {
+		defined[macro] = results[i] == definedResult
+	}
+
+	return
 }
 
 // BuildCompatibleSpecsList builds a list of spec files in a directory that are compatible with the build arch. Paths
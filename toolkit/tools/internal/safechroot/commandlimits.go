@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+// CommandLimits are the limits of each command that is run inside a chroot (see Chroot.Run), so that a runaway
+// command (e.g. a package's scriptlet or a customization script) can't take down the build host.
+type CommandLimits struct {
+	// Resources are the resource limits of each command, including all of its child processes. Each command is run
+	// in its own cgroup v2 group, which is removed (along with any processes that are left behind) once the command
+	// exits.
+	Resources cgroup.Limits
+	// CgroupParent is the cgroup v2 directory to create the commands' cgroups in. If empty, then the cgroup of the
+	// current process is used.
+	CgroupParent string
+	// Timeout is the maximum time that each command may run for, after which it is killed. Zero for no timeout.
+	Timeout time.Duration
+}
+
+// chrootCommandLimits are the limits applied to the commands run inside all chroots.
+var chrootCommandLimits shell.ProcessLimits
+
+// SetCommandLimits sets the limits of the commands that are run inside all chroots. If resource limits are set, then
+// the cgroup controllers that they need are enabled in the parent cgroup, until ResetCommandLimits is called.
+func SetCommandLimits(limits CommandLimits) error {
+	err := limits.Resources.IsValid()
+	if err != nil {
+		return err
+	}
+
+	if limits.Timeout < 0 {
+		return fmt.Errorf("invalid command timeout (%s): must not be negative", limits.Timeout)
+	}
+
+	err = ResetCommandLimits()
+	if err != nil {
+		return err
+	}
+
+	processLimits := shell.ProcessLimits{
+		Timeout: limits.Timeout,
+	}
+
+	if limits.Resources.IsSet() {
+		cgroupParentPath := limits.CgroupParent
+		if cgroupParentPath == "" {
+			cgroupParentPath, err = cgroup.CurrentPath()
+			if err != nil {
+				return fmt.Errorf("failed to find cgroup of current process:\n%w", err)
+			}
+		}
+
+		// The parent cgroup is opened now, since the cgroup filesystem isn't mounted inside the chroots.
+		cgroupParent, err := cgroup.OpenParent(cgroupParentPath, limits.Resources)
+		if err != nil {
+			return fmt.Errorf("failed to prepare cgroup (%s) for chroot commands:\n%w", cgroupParentPath, err)
+		}
+
+		logger.Log.Debugf("Limiting resources of chroot commands (cgroup: %s, limits: %+v)", cgroupParentPath,
+			limits.Resources)
+
+		processLimits.CgroupParent = cgroupParent
+		processLimits.Resources = limits.Resources
+	}
+
+	chrootCommandLimits = processLimits
+	return nil
+}
+
+// ResetCommandLimits removes the limits of the commands that are run inside all chroots, and restores the parent
+// cgroup as SetCommandLimits found it (e.g. moves the current process back into it).
+func ResetCommandLimits() error {
+	cgroupParent := chrootCommandLimits.CgroupParent
+	chrootCommandLimits = shell.ProcessLimits{}
+
+	if cgroupParent == nil {
+		return nil
+	}
+
+	err := cgroupParent.Close()
+	if err != nil {
+		return fmt.Errorf("failed to restore cgroup (%s) of chroot commands:\n%w", cgroupParent.Path(), err)
+	}
+
+	return nil
+}
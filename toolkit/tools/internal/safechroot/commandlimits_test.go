@@ -0,0 +1,29 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCommandLimitsInvalid(t *testing.T) {
+	err := SetCommandLimits(CommandLimits{Resources: cgroup.Limits{CPUWeight: 20000}})
+	assert.ErrorContains(t, err, "invalid CPU weight (20000)")
+
+	err = SetCommandLimits(CommandLimits{Timeout: -time.Second})
+	assert.ErrorContains(t, err, "invalid command timeout (-1s)")
+}
+
+func TestSetCommandLimitsTimeoutOnly(t *testing.T) {
+	defer ResetCommandLimits()
+
+	err := SetCommandLimits(CommandLimits{Timeout: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, shell.ProcessLimits{Timeout: time.Minute}, chrootCommandLimits)
+}
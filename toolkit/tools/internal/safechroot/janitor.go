@@ -0,0 +1,465 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
+
+	"github.com/moby/sys/mountinfo"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultMountJournalDir is the directory where the mounts created by each run are recorded, so that the mounts
+// leaked by a run that crashed (e.g. it was killed with SIGKILL) can be found and unmounted. It is on a tmpfs, since
+// the mounts don't survive a reboot either.
+const DefaultMountJournalDir = "/run/azurelinux-toolkit/mounts"
+
+const (
+	mountJournalFileExt = ".json"
+)
+
+// StaleMount is a mount that was created by a run that is no longer running, but that is still mounted.
+type StaleMount struct {
+	// RunId is the ID of the run that created the mount.
+	RunId string `json:"runId"`
+	// Target is the path that is mounted.
+	Target string `json:"target"`
+	// MountId is the ID of the mount in the mount table.
+	MountId int `json:"mountId"`
+}
+
+// mountJournal is the record of the mounts of a run that are still mounted, in the order they were mounted.
+type mountJournal struct {
+	RunId            string         `json:"runId"`
+	Pid              int            `json:"pid"`
+	ProcessStartTime uint64         `json:"processStartTime"`
+	Mounts           []journalMount `json:"mounts"`
+}
+
+// journalMount is a mount recorded in a mount journal.
+type journalMount struct {
+	Target string `json:"target"`
+	// MountId is the ID of the mount in the mount table (see proc_pid_mountinfo(5)), so that a mount that was later
+	// made at the same path (e.g. by a new run reusing the build directory) is never mistaken for the recorded mount.
+	// 0 if the ID couldn't be read, in which case the mount is never cleaned up.
+	MountId int `json:"mountId"`
+}
+
+// mountJournalMutex guards the mount journal of the current run.
+var (
+	mountJournalMutex    sync.Mutex
+	mountJournalDir      = DefaultMountJournalDir
+	mountJournalDisabled bool
+	currentMountJournal  = newCurrentMountJournal()
+)
+
+// SetMountJournalDir sets the directory where the mounts of the current run are recorded. It must be called before
+// any chroot is initialized. If dir is empty, the mounts aren't recorded.
+func SetMountJournalDir(dir string) {
+	mountJournalMutex.Lock()
+	defer mountJournalMutex.Unlock()
+
+	mountJournalDir = dir
+	mountJournalDisabled = dir == ""
+}
+
+// RunId returns the ID that the mounts of the current run are recorded under.
+func RunId() string {
+	return currentMountJournal.RunId
+}
+
+// FindStaleMounts returns the mounts, recorded in the journal directory, whose run is no longer running but that are
+// still mounted. The mounts of each run are returned in the reverse order of mounting, which is the order that they
+// must be unmounted in.
+func FindStaleMounts(journalDir string) (staleMounts []StaleMount, err error) {
+	staleMounts, _, err = findStaleMounts(journalDir)
+	return
+}
+
+// CleanupStale force-unmounts the mounts leaked by runs that are no longer running, and removes the journals of those
+// runs. Only the mounts that were recorded by a run are unmounted, and only while the mount at the path is still the
+// recorded mount (i.e. it has the recorded mount ID). A path that a run that is still running has mounted is never
+// unmounted. A busy mount is lazily unmounted (i.e. detached), so that it doesn't damage the host if its directory is
+// deleted.
+//
+// Returns the mounts that were unmounted.
+func CleanupStale(journalDir string) (cleanedMounts []StaleMount, err error) {
+	const (
+		retryDuration = time.Second
+		totalAttempts = 3
+	)
+
+	staleMounts, deadRunIds, err := findStaleMounts(journalDir)
+	if err != nil {
+		return nil, err
+	}
+
+	failedRunIds := make(map[string]bool)
+	for _, staleMount := range staleMounts {
+		if failedRunIds[staleMount.RunId] {
+			continue
+		}
+
+		// A lazy unmount of a parent mount also detaches its submounts. And the path may have been mounted again since
+		// the mount table was read. So, check that the top mount of the path is still the recorded mount.
+		mountId, mountIdErr := topMountId(staleMount.Target)
+		if mountIdErr != nil || mountId != staleMount.MountId {
+			continue
+		}
+
+		logger.Log.Infof("Unmounting stale mount (%s) of run (%s)", staleMount.Target, staleMount.RunId)
+
+		_, unmountErr := retry.RunWithExpBackoff(context.Background(), func() error {
+			return unix.Unmount(staleMount.Target, 0)
+		}, totalAttempts, retryDuration, 2.0)
+		if unmountErr != nil {
+			logger.Log.Warnf("Stale mount (%s) is busy, detaching it: %s", staleMount.Target, unmountErr)
+			unmountErr = unix.Unmount(staleMount.Target, unix.MNT_DETACH)
+		}
+
+		if unmountErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to unmount stale mount (%s):\n%w", staleMount.Target,
+				unmountErr))
+			failedRunIds[staleMount.RunId] = true
+			continue
+		}
+
+		cleanedMounts = append(cleanedMounts, staleMount)
+	}
+
+	// The journal of a run is only removed once all of its mounts are gone, so that a failed cleanup can be retried.
+	for _, runId := range deadRunIds {
+		if failedRunIds[runId] {
+			continue
+		}
+
+		removeErr := os.Remove(mountJournalPath(journalDir, runId))
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			err = errors.Join(err, fmt.Errorf("failed to remove mount journal of run (%s):\n%w", runId, removeErr))
+		}
+	}
+
+	return cleanedMounts, err
+}
+
+// findStaleMounts returns the stale mounts and the IDs of all the runs, recorded in the journal directory, that are no
+// longer running.
+func findStaleMounts(journalDir string) (staleMounts []StaleMount, deadRunIds []string, err error) {
+	journals, err := readMountJournals(journalDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mounts, err := mountinfo.GetMounts(nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read mount table:\n%w", err)
+	}
+
+	// The mount table lists parent mounts before their children. So, the last mount of a path is its top mount, which
+	// is the one that an unmount of the path unmounts.
+	topMountIds := make(map[string]int)
+	for _, mount := range mounts {
+		topMountIds[mount.Mountpoint] = mount.ID
+	}
+
+	aliveRunIds := make(map[string]bool)
+	liveTargets := make(map[string]bool)
+	for _, journal := range journals {
+		if isRunAlive(journal) {
+			aliveRunIds[journal.RunId] = true
+			for _, mount := range journal.Mounts {
+				liveTargets[mount.Target] = true
+			}
+		}
+	}
+
+	for _, journal := range journals {
+		if aliveRunIds[journal.RunId] {
+			continue
+		}
+
+		deadRunIds = append(deadRunIds, journal.RunId)
+		staleMounts = append(staleMounts, journal.staleMounts(topMountIds, liveTargets)...)
+	}
+
+	return staleMounts, deadRunIds, nil
+}
+
+// staleMounts returns the journal's mounts that are still the top mounts of their paths, in the reverse order of
+// mounting. topMountIds maps each mounted path to the ID of its top mount, and liveTargets are the paths mounted by
+// the runs that are still running.
+func (j *mountJournal) staleMounts(topMountIds map[string]int, liveTargets map[string]bool,
+) (staleMounts []StaleMount) {
+	for i := len(j.Mounts) - 1; i >= 0; i-- {
+		mount := j.Mounts[i]
+
+		// Never unmount the host's root, whatever the journal contains.
+		if !filepath.IsAbs(mount.Target) || filepath.Clean(mount.Target) == "/" {
+			logger.Log.Warnf("Ignoring invalid mount (%s) in the mount journal of run (%s)", mount.Target, j.RunId)
+			continue
+		}
+
+		if liveTargets[mount.Target] {
+			logger.Log.Debugf("Ignoring mount (%s) of run (%s), since a running run mounted the same path",
+				mount.Target, j.RunId)
+			continue
+		}
+
+		mountId, mounted := topMountIds[mount.Target]
+		if !mounted {
+			continue
+		}
+
+		if mount.MountId == 0 {
+			logger.Log.Warnf("Ignoring mount (%s) of run (%s), since its mount ID wasn't recorded", mount.Target,
+				j.RunId)
+			continue
+		}
+
+		if mountId != mount.MountId {
+			logger.Log.Debugf("Ignoring mount (%s) of run (%s), since the path was mounted again", mount.Target,
+				j.RunId)
+			continue
+		}
+
+		staleMounts = append(staleMounts, StaleMount{RunId: j.RunId, Target: mount.Target, MountId: mount.MountId})
+	}
+
+	return
+}
+
+// topMountId returns the ID of the top mount of a path, or 0 if the path isn't mounted.
+func topMountId(target string) (int, error) {
+	mounts, err := mountinfo.GetMounts(func(info *mountinfo.Info) (skip, stop bool) {
+		return info.Mountpoint != target, false
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read mount table:\n%w", err)
+	}
+
+	if len(mounts) == 0 {
+		return 0, nil
+	}
+
+	return mounts[len(mounts)-1].ID, nil
+}
+
+// readMountJournals reads all the run journals in the journal directory, sorted by run ID.
+func readMountJournals(journalDir string) (journals []*mountJournal, err error) {
+	entries, err := os.ReadDir(journalDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount journal directory (%s):\n%w", journalDir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != mountJournalFileExt {
+			continue
+		}
+
+		journalPath := filepath.Join(journalDir, entry.Name())
+		journalData, err := os.ReadFile(journalPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mount journal (%s):\n%w", journalPath, err)
+		}
+
+		journal := &mountJournal{}
+		err = json.Unmarshal(journalData, journal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mount journal (%s):\n%w", journalPath, err)
+		}
+
+		journals = append(journals, journal)
+	}
+
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].RunId < journals[j].RunId
+	})
+
+	return journals, nil
+}
+
+// isRunAlive checks if the process of a run is still running. The process's start time is compared, in case its PID
+// was reused by another process.
+func isRunAlive(journal *mountJournal) bool {
+	startTime, err := processStartTime(journal.Pid)
+	if err != nil {
+		return false
+	}
+
+	return startTime == journal.ProcessStartTime
+}
+
+// processStartTime returns the time the process started at, in clock ticks after the system boot.
+func processStartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseProcessStartTime(stat)
+}
+
+// parseProcessStartTime returns the start time field of a /proc/<pid>/stat file (see proc(5)).
+func parseProcessStartTime(stat []byte) (uint64, error) {
+	// The process's name (the 2nd field) may contain spaces and parentheses, so the fields are counted from the name's
+	// closing parenthesis.
+	// The start time is the 22nd field, and the fields after the name start at the 3rd field.
+	const startTimeIndex = 22 - 3
+
+	nameEnd := bytes.LastIndexByte(stat, ')')
+	if nameEnd < 0 {
+		return 0, fmt.Errorf("invalid process stat (%s)", stat)
+	}
+
+	fields := strings.Fields(string(stat[nameEnd+1:]))
+	if len(fields) <= startTimeIndex {
+		return 0, fmt.Errorf("invalid process stat (%s)", stat)
+	}
+
+	startTime, err := strconv.ParseUint(fields[startTimeIndex], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid process start time (%s):\n%w", fields[startTimeIndex], err)
+	}
+
+	return startTime, nil
+}
+
+// newCurrentMountJournal creates the (empty) journal of the current run.
+func newCurrentMountJournal() *mountJournal {
+	pid := os.Getpid()
+
+	startTime, err := processStartTime(pid)
+	if err != nil {
+		// The journal can still be cleaned up once the PID is no longer in use.
+		startTime = 0
+	}
+
+	return &mountJournal{
+		RunId:            fmt.Sprintf("%d-%d", pid, startTime),
+		Pid:              pid,
+		ProcessStartTime: startTime,
+	}
+}
+
+// recordMount records a mount of the current run, so that it can be unmounted if the run crashes. Recording is best
+// effort: a failure is logged, but doesn't fail the mount.
+func recordMount(mountPoint *MountPoint, fullPath string) {
+	// The mount table lists the mounts by their absolute path, without symlinks.
+	target, err := filepath.Abs(fullPath)
+	if err == nil {
+		target, err = filepath.EvalSymlinks(target)
+	}
+	if err != nil {
+		logger.Log.Warnf("Failed to resolve mount path (%s), the mount won't be recorded:\n%s", fullPath, err)
+		return
+	}
+
+	mountId, err := topMountId(target)
+	if err != nil {
+		logger.Log.Warnf("Failed to find the mount ID of (%s), the mount won't be cleaned up if the run crashes:\n%s",
+			target, err)
+	} else if mountId == 0 {
+		logger.Log.Warnf("Mount (%s) isn't in the mount table, it won't be cleaned up if the run crashes", target)
+	}
+
+	mountJournalMutex.Lock()
+	defer mountJournalMutex.Unlock()
+
+	mountPoint.journalTarget = target
+	currentMountJournal.Mounts = append(currentMountJournal.Mounts, journalMount{Target: target, MountId: mountId})
+	writeCurrentMountJournal()
+}
+
+// recordUnmount removes a mount of the current run from the journal, once it was unmounted.
+func recordUnmount(target string) {
+	mountJournalMutex.Lock()
+	defer mountJournalMutex.Unlock()
+
+	mounts := currentMountJournal.Mounts
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if mounts[i].Target == target {
+			currentMountJournal.Mounts = append(mounts[:i:i], mounts[i+1:]...)
+			writeCurrentMountJournal()
+			return
+		}
+	}
+}
+
+// writeCurrentMountJournal writes the journal of the current run, or removes it if none of the run's mounts are
+// mounted. mountJournalMutex must be held.
+func writeCurrentMountJournal() {
+	if mountJournalDisabled {
+		return
+	}
+
+	journalPath := mountJournalPath(mountJournalDir, currentMountJournal.RunId)
+
+	err := func() error {
+		if len(currentMountJournal.Mounts) == 0 {
+			err := os.Remove(journalPath)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+
+		err := os.MkdirAll(mountJournalDir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		journalData, err := json.Marshal(currentMountJournal)
+		if err != nil {
+			return err
+		}
+
+		// Write the journal atomically, so that a crash never leaves a partial journal behind.
+		tempPath := journalPath + ".tmp"
+		err = os.WriteFile(tempPath, journalData, 0o644)
+		if err != nil {
+			return err
+		}
+
+		return os.Rename(tempPath, journalPath)
+	}()
+	if err != nil {
+		// Don't keep retrying (and warning) on every mount if the journal directory isn't writable.
+		logger.Log.Warnf("Failed to write mount journal (%s), leaked mounts won't be detected:\n%s", journalPath, err)
+		mountJournalDisabled = true
+	}
+}
+
+func mountJournalPath(journalDir string, runId string) string {
+	return filepath.Join(journalDir, runId+mountJournalFileExt)
+}
+
+// forgetMount marks a mount point as unmounted, and removes it from the journal of the current run.
+func forgetMount(mountPoint *MountPoint) {
+	if !mountPoint.isMounted {
+		return
+	}
+
+	mountPoint.isMounted = false
+	if mountPoint.journalTarget != "" {
+		recordUnmount(mountPoint.journalTarget)
+		mountPoint.journalTarget = ""
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moby/sys/mountinfo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseProcessStartTime(t *testing.T) {
+	stat := "1234 (my (weird) proc) S 1 1234 1234 0 -1 4194560 1000 0 0 0 10 5 0 0 20 0 1 0 98765 12345678 900"
+	startTime, err := parseProcessStartTime([]byte(stat))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(98765), startTime)
+}
+
+func TestParseProcessStartTimeInvalid(t *testing.T) {
+	_, err := parseProcessStartTime([]byte("1234 (proc) S 1"))
+	assert.ErrorContains(t, err, "invalid process stat")
+
+	_, err = parseProcessStartTime([]byte("garbage"))
+	assert.ErrorContains(t, err, "invalid process stat")
+}
+
+func TestIsRunAlive(t *testing.T) {
+	assert.True(t, isRunAlive(currentMountJournal))
+
+	// A PID that was reused by another process.
+	reusedPid := *currentMountJournal
+	reusedPid.ProcessStartTime++
+	assert.False(t, isRunAlive(&reusedPid))
+}
+
+func TestMountJournalStaleMounts(t *testing.T) {
+	journal := &mountJournal{
+		RunId: "1-1",
+		Mounts: []journalMount{
+			{Target: "/chroot/dev", MountId: 10},
+			{Target: "/", MountId: 1},
+			{Target: "relative/path", MountId: 11},
+			{Target: "/chroot/proc", MountId: 12},
+			{Target: "/chroot/run", MountId: 13},
+			{Target: "/chroot/sys", MountId: 14},
+			{Target: "/chroot/tmp", MountId: 0},
+			{Target: "/other/dev", MountId: 15},
+		},
+	}
+
+	topMountIds := map[string]int{
+		"/":            1,
+		"/chroot/dev":  10,
+		"/chroot/proc": 12,
+		// Mounted again after the run crashed.
+		"/chroot/sys": 20,
+		"/chroot/tmp": 21,
+		"/other/dev":  15,
+	}
+
+	// Mounted by a run that is still running.
+	liveTargets := map[string]bool{
+		"/other/dev": true,
+	}
+
+	assert.Equal(t, []StaleMount{
+		{RunId: "1-1", Target: "/chroot/proc", MountId: 12},
+		{RunId: "1-1", Target: "/chroot/dev", MountId: 10},
+	}, journal.staleMounts(topMountIds, liveTargets))
+}
+
+func TestRecordMountShouldWriteJournal(t *testing.T) {
+	journalDir := t.TempDir()
+	SetMountJournalDir(journalDir)
+	defer SetMountJournalDir(DefaultMountJournalDir)
+
+	target := t.TempDir()
+	mountPoint := &MountPoint{isMounted: true}
+
+	recordMount(mountPoint, target)
+
+	journals, err := readMountJournals(journalDir)
+	require.NoError(t, err)
+	require.Len(t, journals, 1)
+	assert.Equal(t, RunId(), journals[0].RunId)
+	if assert.Len(t, journals[0].Mounts, 1) {
+		assert.Equal(t, target, journals[0].Mounts[0].Target)
+	}
+
+	// The run is still alive, so its mounts aren't stale.
+	staleMounts, err := FindStaleMounts(journalDir)
+	assert.NoError(t, err)
+	assert.Empty(t, staleMounts)
+
+	forgetMount(mountPoint)
+	assert.False(t, mountPoint.isMounted)
+	assert.NoFileExists(t, mountJournalPath(journalDir, RunId()))
+}
+
+func TestCleanupStaleShouldUnmountLeakedMounts(t *testing.T) {
+	journalDir := t.TempDir()
+	leakedDir := t.TempDir()
+	notMountedDir := t.TempDir()
+	remountedDir := t.TempDir()
+
+	err := unix.Mount("tmpfs", leakedDir, "tmpfs", 0, "")
+	require.NoError(t, err)
+	defer unix.Unmount(leakedDir, unix.MNT_DETACH)
+
+	leakedMountId, err := topMountId(leakedDir)
+	require.NoError(t, err)
+
+	// A path that was mounted again since the crashed run mounted it.
+	err = unix.Mount("tmpfs", remountedDir, "tmpfs", 0, "")
+	require.NoError(t, err)
+	defer unix.Unmount(remountedDir, unix.MNT_DETACH)
+
+	remountedMountId, err := topMountId(remountedDir)
+	require.NoError(t, err)
+
+	// A journal left behind by a crashed run, whose PID is now used by this test.
+	journal := mountJournal{
+		RunId:            "crashed-run",
+		Pid:              os.Getpid(),
+		ProcessStartTime: currentMountJournal.ProcessStartTime + 1,
+		Mounts: []journalMount{
+			{Target: leakedDir, MountId: leakedMountId},
+			{Target: notMountedDir, MountId: leakedMountId + 1},
+			{Target: remountedDir, MountId: remountedMountId + 1},
+		},
+	}
+	journalData, err := json.Marshal(journal)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(journalDir, "crashed-run.json"), journalData, 0o644)
+	require.NoError(t, err)
+
+	staleMounts, err := FindStaleMounts(journalDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []StaleMount{{RunId: "crashed-run", Target: leakedDir, MountId: leakedMountId}}, staleMounts)
+
+	cleanedMounts, err := CleanupStale(journalDir)
+	assert.NoError(t, err)
+	assert.Equal(t, staleMounts, cleanedMounts)
+
+	mounted, err := mountinfo.Mounted(leakedDir)
+	assert.NoError(t, err)
+	assert.False(t, mounted)
+	assert.NoFileExists(t, filepath.Join(journalDir, "crashed-run.json"))
+
+	mounted, err = mountinfo.Mounted(remountedDir)
+	assert.NoError(t, err)
+	assert.True(t, mounted)
+}
@@ -0,0 +1,331 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// MountPropagation is the propagation type of a mount (see mount_namespaces(7)).
+type MountPropagation string
+
+const (
+	// MountPropagationDefault keeps the propagation type that the mount inherits.
+	MountPropagationDefault MountPropagation = ""
+	// MountPropagationPrivate doesn't propagate mount events to or from the mount's peers.
+	MountPropagationPrivate MountPropagation = "private"
+	// MountPropagationShared propagates mount events to and from the mount's peers.
+	MountPropagationShared MountPropagation = "shared"
+	// MountPropagationSlave receives mount events from the mount's peers, but doesn't propagate mount events to them.
+	MountPropagationSlave MountPropagation = "slave"
+	// MountPropagationUnbindable is private, and also can't be bind mounted.
+	MountPropagationUnbindable MountPropagation = "unbindable"
+)
+
+const (
+	tmpfsFsType = "tmpfs"
+)
+
+// MountSpec is a structured description of a mount within a chroot. Unlike the raw mount syscall arguments of
+// NewMountPoint, a MountSpec is validated before the chroot issues any mount syscalls.
+type MountSpec struct {
+	// Source is the path to bind mount, or the device (or name) of the filesystem to mount.
+	Source string
+	// Target is the path within the chroot to mount at.
+	Target string
+	// FSType is the type of the filesystem to mount. Must be empty for bind mounts.
+	FSType string
+	// Bind bind mounts the Source path.
+	Bind bool
+	// Recursive also bind mounts the mounts within the Source path. Only valid for bind mounts.
+	Recursive bool
+	// ReadOnly mounts read-only. Bind mounts are remounted read-only after they are created, since the kernel ignores
+	// the read-only flag when creating a bind mount.
+	ReadOnly bool
+	// Propagation is the propagation type to set on the mount (and its submounts, if Recursive) once it is mounted.
+	Propagation MountPropagation
+	// SizeLimit is the maximum size, in bytes, of a tmpfs mount. If 0, then the kernel's default (half of the RAM) is
+	// used.
+	SizeLimit uint64
+	// Options are the filesystem-specific mount options (e.g. "mode=0755"), separated by commas.
+	Options string
+	// IdMapUserNamespace is the path of a user namespace (e.g. "/proc/<pid>/ns/user") whose id mappings are applied to
+	// a bind mount (i.e. an idmapped mount), so that the files' owners within the chroot differ from their owners on
+	// the host. Requires Linux 5.12 or newer and a filesystem that supports idmapped mounts.
+	IdMapUserNamespace string
+	// BeforeDefaults mounts it before the chroot's default mounts (e.g. /dev and /proc).
+	BeforeDefaults bool
+}
+
+// IsValid checks that the mount spec is consistent, without touching the filesystem.
+func (s *MountSpec) IsValid() error {
+	err := validateMountTarget(s.Target)
+	if err != nil {
+		return err
+	}
+
+	if s.Bind {
+		if s.Source == "" {
+			return fmt.Errorf("invalid bind mount (%s):\nsource must not be empty", s.Target)
+		}
+
+		if s.FSType != "" {
+			return fmt.Errorf("invalid bind mount (%s):\nfilesystem type must be empty", s.Target)
+		}
+	} else {
+		if s.FSType == "" {
+			return fmt.Errorf("invalid mount (%s):\nfilesystem type must not be empty", s.Target)
+		}
+
+		if s.Recursive {
+			return fmt.Errorf("invalid mount (%s):\nonly bind mounts can be recursive", s.Target)
+		}
+
+		if s.IdMapUserNamespace != "" {
+			return fmt.Errorf("invalid mount (%s):\nonly bind mounts can be idmapped", s.Target)
+		}
+	}
+
+	if s.SizeLimit != 0 {
+		if s.FSType != tmpfsFsType {
+			return fmt.Errorf("invalid mount (%s):\nsize limit is only supported for tmpfs mounts", s.Target)
+		}
+
+		if hasMountOption(s.Options, "size") {
+			return fmt.Errorf("invalid mount (%s):\nsize limit and 'size' option must not both be specified",
+				s.Target)
+		}
+	}
+
+	_, err = s.Propagation.flags(s.Recursive)
+	if err != nil {
+		return fmt.Errorf("invalid mount (%s):\n%w", s.Target, err)
+	}
+
+	return nil
+}
+
+// NewMountPointFromSpec creates a new MountPoint struct, to be created by a Chroot, from a mount spec.
+func NewMountPointFromSpec(spec MountSpec) (*MountPoint, error) {
+	err := spec.IsValid()
+	if err != nil {
+		return nil, err
+	}
+
+	var flags uintptr
+	switch {
+	case spec.Bind && spec.Recursive:
+		flags = unix.MS_BIND | unix.MS_REC
+
+	case spec.Bind:
+		flags = unix.MS_BIND
+
+	case spec.ReadOnly:
+		flags = unix.MS_RDONLY
+	}
+
+	data := spec.Options
+	if spec.SizeLimit != 0 {
+		data = appendMountOption(data, fmt.Sprintf("size=%d", spec.SizeLimit))
+	}
+
+	propagationFlags, _ := spec.Propagation.flags(spec.Recursive)
+
+	return &MountPoint{
+		source:              spec.Source,
+		target:              spec.Target,
+		fstype:              spec.FSType,
+		flags:               flags,
+		data:                data,
+		readOnlyRemount:     spec.Bind && spec.ReadOnly,
+		propagationFlags:    propagationFlags,
+		idMapUserNamespace:  spec.IdMapUserNamespace,
+		mountBeforeDefaults: spec.BeforeDefaults,
+	}, nil
+}
+
+// NewMountPointsFromSpecs creates the MountPoint structs of a list of mount specs. All the specs are validated first,
+// so that no mount point is created if any of the specs are invalid.
+func NewMountPointsFromSpecs(specs []MountSpec) ([]*MountPoint, error) {
+	mountPoints := []*MountPoint(nil)
+	for i, spec := range specs {
+		mountPoint, err := NewMountPointFromSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount spec at index %d:\n%w", i, err)
+		}
+
+		mountPoints = append(mountPoints, mountPoint)
+	}
+
+	return mountPoints, nil
+}
+
+// mustNewMountPointsFromSpecs creates the MountPoint structs of a list of mount specs that are known to be valid.
+func mustNewMountPointsFromSpecs(specs []MountSpec) []*MountPoint {
+	mountPoints, err := NewMountPointsFromSpecs(specs)
+	if err != nil {
+		panic(err)
+	}
+
+	return mountPoints
+}
+
+// flags returns the mount flags that set the propagation type.
+func (p MountPropagation) flags(recursive bool) (uintptr, error) {
+	var flags uintptr
+	switch p {
+	case MountPropagationDefault:
+		return 0, nil
+
+	case MountPropagationPrivate:
+		flags = unix.MS_PRIVATE
+
+	case MountPropagationShared:
+		flags = unix.MS_SHARED
+
+	case MountPropagationSlave:
+		flags = unix.MS_SLAVE
+
+	case MountPropagationUnbindable:
+		flags = unix.MS_UNBINDABLE
+
+	default:
+		return 0, fmt.Errorf("invalid propagation type (%s)", p)
+	}
+
+	if recursive {
+		flags |= unix.MS_REC
+	}
+
+	return flags, nil
+}
+
+// validate checks that a mount point is consistent before any of the chroot's mount points are mounted.
+func (m *MountPoint) validate() error {
+	err := validateMountTarget(m.target)
+	if err != nil {
+		return err
+	}
+
+	if m.idMapUserNamespace != "" && m.flags&unix.MS_BIND == 0 {
+		return fmt.Errorf("invalid mount (%s):\nonly bind mounts can be idmapped", m.target)
+	}
+
+	return nil
+}
+
+// validateMountPoints checks all the mount points.
+func validateMountPoints(mountPoints []*MountPoint) error {
+	for _, mountPoint := range mountPoints {
+		err := mountPoint.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateMountTarget checks that a mount's target is within the chroot.
+func validateMountTarget(target string) error {
+	if target == "" {
+		return fmt.Errorf("invalid mount:\ntarget must not be empty")
+	}
+
+	// Absolute targets are always within the chroot, since they are joined to the chroot's root directory.
+	cleanTarget := filepath.Clean(target)
+	if cleanTarget == ".." || strings.HasPrefix(cleanTarget, "../") {
+		return fmt.Errorf("invalid mount (%s):\ntarget must be within the chroot", target)
+	}
+
+	return nil
+}
+
+// hasMountOption returns true if the comma-separated mount options contain the option (with or without a value).
+func hasMountOption(options string, name string) bool {
+	for _, option := range strings.Split(options, ",") {
+		optionName, _, _ := strings.Cut(option, "=")
+		if optionName == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendMountOption(options string, option string) string {
+	if options == "" {
+		return option
+	}
+
+	return options + "," + option
+}
+
+// mountIdMapped creates an idmapped bind mount, using the new mount API, since the mount syscall can't create one.
+func mountIdMapped(mountPoint *MountPoint, fullPath string) error {
+	var treeFlags uint = unix.OPEN_TREE_CLONE | unix.OPEN_TREE_CLOEXEC
+	var attrFlags uint = unix.AT_EMPTY_PATH
+	if mountPoint.flags&unix.MS_REC != 0 {
+		treeFlags |= unix.AT_RECURSIVE
+		attrFlags |= unix.AT_RECURSIVE
+	}
+
+	treeFd, err := unix.OpenTree(unix.AT_FDCWD, mountPoint.source, treeFlags)
+	if err != nil {
+		return fmt.Errorf("failed to clone mount tree (%s):\n%w", mountPoint.source, err)
+	}
+	defer unix.Close(treeFd)
+
+	userNamespace, err := os.Open(mountPoint.idMapUserNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to open user namespace (%s):\n%w", mountPoint.idMapUserNamespace, err)
+	}
+	defer userNamespace.Close()
+
+	attr := unix.MountAttr{
+		Attr_set:  unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(userNamespace.Fd()),
+	}
+
+	err = unix.MountSetattr(treeFd, "", attrFlags, &attr)
+	if err != nil {
+		return fmt.Errorf("failed to idmap mount (%s) with user namespace (%s):\n%w", mountPoint.source,
+			mountPoint.idMapUserNamespace, err)
+	}
+
+	err = unix.MoveMount(treeFd, "", unix.AT_FDCWD, fullPath, unix.MOVE_MOUNT_F_EMPTY_PATH)
+	if err != nil {
+		return fmt.Errorf("failed to attach idmapped mount (%s) to (%s):\n%w", mountPoint.source, fullPath, err)
+	}
+
+	return nil
+}
+
+// remountReadOnly makes a bind mount read-only. A recursive bind mount's submounts are also made read-only.
+func remountReadOnly(mountPoint *MountPoint, fullPath string) error {
+	if mountPoint.flags&unix.MS_REC == 0 {
+		err := unix.Mount("", fullPath, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, "")
+		if err != nil {
+			return fmt.Errorf("failed to remount (%s) read-only:\n%w", fullPath, err)
+		}
+
+		return nil
+	}
+
+	attr := unix.MountAttr{
+		Attr_set: unix.MOUNT_ATTR_RDONLY,
+	}
+
+	err := unix.MountSetattr(unix.AT_FDCWD, fullPath, unix.AT_RECURSIVE, &attr)
+	if err != nil {
+		return fmt.Errorf("failed to remount (%s) and its submounts read-only:\n%w", fullPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewMountPointFromSpecBind(t *testing.T) {
+	mountPoint, err := NewMountPointFromSpec(MountSpec{
+		Source:      "/var/cache",
+		Target:      "/cache",
+		Bind:        true,
+		Recursive:   true,
+		ReadOnly:    true,
+		Propagation: MountPropagationSlave,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.MS_BIND|unix.MS_REC), mountPoint.flags)
+	assert.True(t, mountPoint.readOnlyRemount)
+	assert.Equal(t, uintptr(unix.MS_SLAVE|unix.MS_REC), mountPoint.propagationFlags)
+}
+
+func TestNewMountPointFromSpecTmpfs(t *testing.T) {
+	mountPoint, err := NewMountPointFromSpec(MountSpec{
+		Source:    "tmpfs",
+		Target:    "/tmp",
+		FSType:    "tmpfs",
+		ReadOnly:  true,
+		SizeLimit: 1024 * 1024,
+		Options:   "mode=1777",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.MS_RDONLY), mountPoint.flags)
+	assert.Equal(t, "mode=1777,size=1048576", mountPoint.data)
+	assert.False(t, mountPoint.readOnlyRemount)
+	assert.Zero(t, mountPoint.propagationFlags)
+}
+
+func TestMountSpecIsValidInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec MountSpec
+		err  string
+	}{
+		{"no target", MountSpec{Source: "/src", Bind: true}, "target must not be empty"},
+		{"escaping target", MountSpec{Source: "/src", Target: "../../etc", Bind: true},
+			"target must be within the chroot"},
+		{"bind without source", MountSpec{Target: "/dst", Bind: true}, "source must not be empty"},
+		{"bind with fstype", MountSpec{Source: "/src", Target: "/dst", Bind: true, FSType: "ext4"},
+			"filesystem type must be empty"},
+		{"no fstype", MountSpec{Source: "/dev/sda1", Target: "/dst"}, "filesystem type must not be empty"},
+		{"recursive non-bind", MountSpec{Target: "/dst", FSType: "tmpfs", Recursive: true},
+			"only bind mounts can be recursive"},
+		{"idmapped non-bind", MountSpec{Target: "/dst", FSType: "tmpfs", IdMapUserNamespace: "/proc/1/ns/user"},
+			"only bind mounts can be idmapped"},
+		{"size limit of non-tmpfs", MountSpec{Target: "/dst", FSType: "proc", SizeLimit: 1},
+			"size limit is only supported for tmpfs mounts"},
+		{"size limit and size option", MountSpec{Target: "/dst", FSType: "tmpfs", SizeLimit: 1, Options: "size=2"},
+			"size limit and 'size' option must not both be specified"},
+		{"invalid propagation", MountSpec{Target: "/dst", FSType: "tmpfs", Propagation: "rshared"},
+			"invalid propagation type (rshared)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ErrorContains(t, test.spec.IsValid(), test.err)
+
+			_, err := NewMountPointFromSpec(test.spec)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewMountPointsFromSpecsInvalid(t *testing.T) {
+	_, err := NewMountPointsFromSpecs([]MountSpec{
+		{Source: "/src", Target: "/dst", Bind: true},
+		{Target: "/dst"},
+	})
+	assert.ErrorContains(t, err, "invalid mount spec at index 1:\ninvalid mount (/dst):\nfilesystem type must not be empty")
+}
@@ -17,10 +17,12 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/directory"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ociimage"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/systemdependency"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
 
 	"github.com/moby/sys/mountinfo"
 	"github.com/sirupsen/logrus"
@@ -28,6 +30,8 @@ import (
 )
 
 // BindMountPointFlags is a set of flags to do a bind mount.
+//
+// Deprecated: Use a MountSpec with Bind set instead.
 const BindMountPointFlags = unix.MS_BIND | unix.MS_MGC_VAL
 
 // FileToCopy represents a file to copy into a chroot using AddFiles. Dest is relative to the chroot directory.
@@ -43,6 +47,8 @@ type FileToCopy struct {
 	Permissions *os.FileMode
 	// Set to true to copy symlinks as symlinks.
 	NoDereference bool
+	// The classes of extended attributes (e.g. file capabilities) of Src to copy.
+	PreserveXattrs file.XattrClass
 }
 
 // DirToCopy represents a directory to copy into a chroot using AddDirs. Dest is relative to the chroot directory.
@@ -52,6 +58,8 @@ type DirToCopy struct {
 	NewDirPermissions    os.FileMode
 	ChildFilePermissions os.FileMode
 	MergedDirPermissions *os.FileMode
+	// The classes of extended attributes (e.g. file capabilities) of the files and directories to copy.
+	PreserveXattrs file.XattrClass
 }
 
 // MountPoint represents a system mount point used by a Chroot.
@@ -64,6 +72,16 @@ type MountPoint struct {
 	flags  uintptr
 	data   string
 
+	// readOnlyRemount remounts a bind mount read-only once it is mounted.
+	readOnlyRemount bool
+	// propagationFlags set the mount's propagation type once it is mounted.
+	propagationFlags uintptr
+	// idMapUserNamespace is the path of the user namespace whose id mappings are applied to an idmapped bind mount.
+	idMapUserNamespace string
+
+	// journalTarget is the path that the mount is recorded under in the mount journal of the current run.
+	journalTarget string
+
 	isMounted           bool
 	mountBeforeDefaults bool
 }
@@ -76,6 +94,11 @@ type Chroot struct {
 
 	isExistingDir        bool
 	includeDefaultMounts bool
+
+	// overlayBaseDir is the read-only base directory of an overlay chroot. Empty if the chroot isn't an overlay.
+	overlayBaseDir string
+	// overlayDir holds the upper and work directories of an overlay chroot.
+	overlayDir string
 }
 
 // inChrootMutex guards against multiple Chroots entering their respective Chroots
@@ -107,6 +130,10 @@ var defaultChrootEnv = []string{
 const (
 	unmountTypeLazy   = true
 	unmountTypeNormal = !unmountTypeLazy
+
+	overlayDirSuffix    = ".overlay"
+	overlayUpperDirName = "upper"
+	overlayWorkDirName  = "work"
 )
 
 // init will always be called if this package is loaded
@@ -115,7 +142,8 @@ func init() {
 	logrus.RegisterExitHandler(cleanupAllChroots)
 }
 
-// NewMountPoint creates a new MountPoint struct to be created by a Chroot
+// NewMountPoint creates a new MountPoint struct to be created by a Chroot from raw mount syscall arguments (e.g. an
+// fstab entry's). Prefer NewMountPointFromSpec, which validates the mount.
 func NewMountPoint(source, target, fstype string, flags uintptr, data string) (mountPoint *MountPoint) {
 	return &MountPoint{
 		source: source,
@@ -255,13 +283,31 @@ func NewChroot(rootDir string, isExistingDir bool) *Chroot {
 	return c
 }
 
+// NewOverlayChroot creates a new Chroot struct whose root directory is an overlay over a read-only base directory (e.g.
+// an extracted rootfs). The base directory isn't modified, so it can be shared by any number of concurrent chroots.
+// The chroot's changes are written to an upper directory next to the root directory ("<rootDir>.overlay"), which is
+// deleted when the chroot is closed.
+//
+// Overlay chroots are only supported in regular builds.
+func NewOverlayChroot(rootDir string, baseDir string) *Chroot {
+	return &Chroot{
+		rootDir:        rootDir,
+		overlayBaseDir: baseDir,
+		overlayDir:     rootDir + overlayDirSuffix,
+	}
+}
+
 // Initialize initializes a Chroot, creating directories and mount points.
-//   - tarPath is an optional path to a tar file that will be extracted at the root of the chroot.
+//   - tarPath is an optional path to a tar file that will be extracted at the root of the chroot. It may also be
+//     an OCI image layout reference ("<layout-dir>[:<tag>]"), in which case the image's layers are extracted.
 //   - extraDirectories is an optional slice of additional directories that should be created before attempting to
 //     mount inside the chroot.
 //   - extraMountPoints is an optional slice of additional mount points that should be created inside the chroot,
 //     they will automatically be unmounted on a Chroot Close.
 //
+// For an overlay chroot (see NewOverlayChroot), the tar is extracted and the directories are created within the
+// overlay, leaving the base directory untouched.
+//
 // This call will block until the chroot initializes successfully.
 // Only one Chroot will initialize at a given time.
 func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMountPoints []*MountPoint,
@@ -275,6 +321,30 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 	activeChrootsMutex.Lock()
 	defer activeChrootsMutex.Unlock()
 
+	setupTimestamp, _ := timestamp.StartEvent("chroot setup", nil)
+	defer timestamp.StopEvent(setupTimestamp)
+
+	// Check the mount points before anything is mounted, so that an invalid mount point doesn't leave a partially
+	// mounted chroot behind.
+	err = validateMountPoints(extraMountPoints)
+	if err != nil {
+		err = fmt.Errorf("invalid chroot mount points:\n%w", err)
+		return
+	}
+
+	if c.overlayBaseDir != "" {
+		if !buildpipeline.IsRegularBuild() {
+			err = fmt.Errorf("overlay chroots are only supported in regular builds")
+			return
+		}
+
+		_, err = os.Stat(c.overlayDir)
+		if !os.IsNotExist(err) {
+			err = fmt.Errorf("chroot overlay directory (%s) already exists", c.overlayDir)
+			return
+		}
+	}
+
 	if c.isExistingDir {
 		_, err = os.Stat(c.rootDir)
 		if os.IsNotExist(err) {
@@ -320,6 +390,14 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 		}
 	}()
 
+	if c.overlayBaseDir != "" {
+		err = c.mountOverlayRoot()
+		if err != nil {
+			err = fmt.Errorf("failed to mount chroot overlay:\n%w", err)
+			return
+		}
+	}
+
 	// Extract a given tarball if necessary
 	if tarPath != "" {
 		err = extractWorkerTar(c.rootDir, tarPath)
@@ -360,7 +438,8 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 		}
 
 		// Assign to `c.mountPoints` now since `Initialize` will call `unmountAndRemove` if an error occurs.
-		c.mountPoints = allMountPoints
+		// An overlay chroot's root mount is already in the list.
+		c.mountPoints = append(c.mountPoints, allMountPoints...)
 		c.includeDefaultMounts = includeDefaultMounts
 
 		// Mount with the original unsorted order. Assumes the order of mounts is important.
@@ -390,8 +469,9 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 
 // AddDirs copies each directory 'Src' to the relative path chrootRootDir/'Dest' in the chroot.
 func (c *Chroot) AddDirs(dirToCopy DirToCopy) (err error) {
-	return file.CopyDir(dirToCopy.Src, filepath.Join(c.rootDir, dirToCopy.Dest), dirToCopy.NewDirPermissions,
-		dirToCopy.ChildFilePermissions, dirToCopy.MergedDirPermissions)
+	return file.CopyDirWithXattrs(dirToCopy.Src, filepath.Join(c.rootDir, dirToCopy.Dest),
+		dirToCopy.NewDirPermissions, dirToCopy.ChildFilePermissions, dirToCopy.MergedDirPermissions,
+		dirToCopy.PreserveXattrs)
 }
 
 // AddFiles copies each file 'Src' to the relative path chrootRootDir/'Dest' in the chroot.
@@ -434,6 +514,7 @@ func copyFile(destDir string, f FileToCopy) error {
 	if f.Permissions != nil {
 		fileCopyOp = fileCopyOp.SetFileMode(*f.Permissions)
 	}
+	fileCopyOp = fileCopyOp.SetPreserveXattrs(f.PreserveXattrs)
 
 	err := fileCopyOp.Run()
 	if err != nil {
@@ -530,6 +611,15 @@ func (c *Chroot) UnsafeRun(toRun func() error) (err error) {
 	}
 	defer c.restoreRoot(originalRoot, originalWd)
 
+	// Limit the resources of the commands that are run inside the chroot, whichever way it was entered.
+	originalLimits := shell.CurrentProcessLimits()
+	shell.SetProcessLimits(chrootCommandLimits)
+	defer shell.SetProcessLimits(originalLimits)
+
+	// The process can only be in one chroot at a time, so the entries that are logged now belong to this chroot.
+	logger.SetContextField(logger.ChrootField, c.rootDir)
+	defer logger.ClearContextField(logger.ChrootField)
+
 	err = os.Chdir(fsRoot)
 	if err != nil {
 		return
@@ -703,6 +793,7 @@ func (c *Chroot) unmountAndRemove(leaveOnDisk, lazyUnmount bool) (err error) {
 		}
 		if !exists {
 			logger.Log.Debugf("Skipping unmount of (%s) because path doesn't exist", fullPath)
+			forgetMount(mountPoint)
 			continue
 		}
 
@@ -714,6 +805,7 @@ func (c *Chroot) unmountAndRemove(leaveOnDisk, lazyUnmount bool) (err error) {
 		}
 		if !isMounted {
 			logger.Log.Debugf("Skipping unmount of (%s) because it is not mounted", fullPath)
+			forgetMount(mountPoint)
 			continue
 		}
 
@@ -724,9 +816,15 @@ func (c *Chroot) unmountAndRemove(leaveOnDisk, lazyUnmount bool) (err error) {
 			continue
 		}
 
+		// A recursive bind mount (e.g. /dev within a user namespace) has submounts, so it can only be detached.
+		mountPointUnmountFlags := unmountFlags
+		if mountPoint.flags&unix.MS_REC != 0 {
+			mountPointUnmountFlags = unmountFlagsLazy
+		}
+
 		_, err = retry.RunWithExpBackoff(context.Background(), func() error {
-			logger.Log.Debugf("Calling unmount on path(%s) with flags (%v)", fullPath, unmountFlags)
-			umountErr := unix.Unmount(fullPath, unmountFlags)
+			logger.Log.Debugf("Calling unmount on path(%s) with flags (%v)", fullPath, mountPointUnmountFlags)
+			umountErr := unix.Unmount(fullPath, mountPointUnmountFlags)
 			return umountErr
 		}, totalAttempts, retryDuration, 2.0)
 
@@ -734,10 +832,20 @@ func (c *Chroot) unmountAndRemove(leaveOnDisk, lazyUnmount bool) (err error) {
 			err = fmt.Errorf("failed to unmount (%s):\n%w", fullPath, err)
 			return
 		}
+
+		forgetMount(mountPoint)
 	}
 
 	if !leaveOnDisk {
 		err = os.RemoveAll(c.rootDir)
+		if err != nil {
+			return
+		}
+
+		// Discard the overlay chroot's changes.
+		if c.overlayDir != "" {
+			err = os.RemoveAll(c.overlayDir)
+		}
 	}
 
 	return
@@ -745,29 +853,17 @@ func (c *Chroot) unmountAndRemove(leaveOnDisk, lazyUnmount bool) (err error) {
 
 // defaultMountPoints returns a new copy of the default mount points used by a functional chroot
 func defaultMountPoints() []*MountPoint {
-	return []*MountPoint{
-		&MountPoint{
-			target: "/dev",
-			fstype: "devtmpfs",
-		},
-		&MountPoint{
-			target: "/proc",
-			fstype: "proc",
-		},
-		&MountPoint{
-			target: "/sys",
-			fstype: "sysfs",
-		},
-		&MountPoint{
-			target: "/run",
-			fstype: "tmpfs",
-		},
-		&MountPoint{
-			target: "/dev/pts",
-			fstype: "devpts",
-			data:   "gid=5,mode=620",
-		},
+	if InUserNamespace() {
+		return userNamespaceMountPoints()
 	}
+
+	return mustNewMountPointsFromSpecs([]MountSpec{
+		{Target: "/dev", FSType: "devtmpfs"},
+		{Target: "/proc", FSType: "proc"},
+		{Target: "/sys", FSType: "sysfs"},
+		{Target: "/run", FSType: "tmpfs"},
+		{Target: "/dev/pts", FSType: "devpts", Options: "gid=5,mode=620"},
+	})
 }
 
 // restoreRoot will restore the original root of the GO application, cleaning up
@@ -793,9 +889,33 @@ func (c *Chroot) restoreRoot(originalRoot, originalWd *os.File) {
 	return
 }
 
+// mountOverlayRoot mounts the overlay of an overlay chroot at the chroot's root directory.
+func (c *Chroot) mountOverlayRoot() (err error) {
+	upperDir := filepath.Join(c.overlayDir, overlayUpperDirName)
+	workDir := filepath.Join(c.overlayDir, overlayWorkDirName)
+
+	for _, dir := range []string{upperDir, workDir} {
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create overlay directory (%s):\n%w", dir, err)
+		}
+	}
+
+	overlayData := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", c.overlayBaseDir, upperDir, workDir)
+	c.mountPoints = mustNewMountPointsFromSpecs([]MountSpec{
+		{Source: "overlay", Target: "/", FSType: "overlay", Options: overlayData},
+	})
+
+	return c.createMountPoints()
+}
+
 // createMountPoints will create a provided list of mount points
 func (c *Chroot) createMountPoints() (err error) {
 	for _, mountPoint := range c.mountPoints {
+		if mountPoint.isMounted {
+			continue
+		}
+
 		fullPath := filepath.Join(c.rootDir, mountPoint.target)
 		logger.Log.Debugf("Mounting: source: (%s), target: (%s), fstype: (%s), flags: (%#x), data: (%s)",
 			mountPoint.source, fullPath, mountPoint.fstype, mountPoint.flags, mountPoint.data)
@@ -805,26 +925,60 @@ func (c *Chroot) createMountPoints() (err error) {
 			return fmt.Errorf("failed to create directory (%s)", fullPath)
 		}
 
-		err = unix.Mount(mountPoint.source, fullPath, mountPoint.fstype, mountPoint.flags, mountPoint.data)
-		if err != nil {
-			return fmt.Errorf("failed to mount (%s) to (%s):\n%w", mountPoint.source, fullPath, err)
+		if mountPoint.idMapUserNamespace != "" {
+			err = mountIdMapped(mountPoint, fullPath)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = unix.Mount(mountPoint.source, fullPath, mountPoint.fstype, mountPoint.flags, mountPoint.data)
+			if err != nil {
+				return fmt.Errorf("failed to mount (%s) to (%s):\n%w", mountPoint.source, fullPath, err)
+			}
 		}
 
+		// The mount must be unmounted on cleanup, even if it can't be finished.
 		mountPoint.isMounted = true
+		recordMount(mountPoint, fullPath)
+
+		if mountPoint.readOnlyRemount {
+			err = remountReadOnly(mountPoint, fullPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		if mountPoint.propagationFlags != 0 {
+			err = unix.Mount("", fullPath, "", mountPoint.propagationFlags, "")
+			if err != nil {
+				return fmt.Errorf("failed to set propagation of mount (%s):\n%w", fullPath, err)
+			}
+		}
 	}
 
 	return
 }
 
-// extractWorkerTar uses tar with gzip or pigz to setup a chroot directory using a rootfs tar
+// extractWorkerTar uses tar with gzip or pigz to setup a chroot directory using a rootfs tar or an OCI image
 func extractWorkerTar(chroot string, workerTar string) (err error) {
+	if ociimage.IsImageReference(workerTar) {
+		logger.Log.Debugf("Extracting worker image (%s)", workerTar)
+		return ociimage.ExtractRootfs(workerTar, chroot)
+	}
+
 	gzipTool, err := systemdependency.GzipTool()
 	if err != nil {
 		return err
 	}
 
+	args := []string{"-I", gzipTool, "-xf", workerTar, "-C", chroot}
+	if InUserNamespace() && !userNamespaceHasSubordinateIds() {
+		// Only root is mapped within the user namespace, so the files can't keep their owners.
+		args = append(args, "--no-same-owner")
+	}
+
 	logger.Log.Debugf("Using (%s) to extract tar", gzipTool)
-	_, _, err = shell.Execute("tar", "-I", gzipTool, "-xf", workerTar, "-C", chroot)
+	_, _, err = shell.Execute("tar", args...)
 	return
 }
 
@@ -13,6 +13,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -276,3 +277,119 @@ func TestInitializeShouldCreateExtraDirectories(t *testing.T) {
 	_, err = os.Stat(fullPath)
 	assert.True(t, !os.IsNotExist(err))
 }
+
+func TestOverlayChrootShouldNotModifyBase(t *testing.T) {
+	if buildpipeline.IsRegularBuild() {
+		// this test only apply to "regular build" pipeline
+		const expectedExtraDirectory = "/testdir"
+
+		baseDir := filepath.Join(t.TempDir(), "base")
+		err := os.MkdirAll(filepath.Join(baseDir, "etc"), os.ModePerm)
+		assert.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(baseDir, "etc/hostname"), []byte("base\n"), 0o644)
+		assert.NoError(t, err)
+
+		// Two chroots share the same base.
+		dir := filepath.Join(t.TempDir(), "TestOverlayChrootShouldNotModifyBase")
+		chroot := NewOverlayChroot(dir, baseDir)
+		otherChroot := NewOverlayChroot(dir+"-other", baseDir)
+
+		err = chroot.Initialize(emptyPath, []string{expectedExtraDirectory}, []*MountPoint{}, true)
+		assert.NoError(t, err)
+		defer chroot.Close(defaultLeaveOnDisk)
+
+		err = otherChroot.Initialize(emptyPath, []string{}, []*MountPoint{}, true)
+		assert.NoError(t, err)
+		defer otherChroot.Close(defaultLeaveOnDisk)
+
+		err = chroot.UnsafeRun(func() error {
+			return os.WriteFile("/etc/hostname", []byte("changed\n"), 0o644)
+		})
+		assert.NoError(t, err)
+
+		assert.DirExists(t, filepath.Join(dir, expectedExtraDirectory))
+		assert.FileExists(t, filepath.Join(dir, "proc/cpuinfo"))
+
+		// The change is only visible within the chroot that made it.
+		for path, expected := range map[string]string{
+			filepath.Join(dir, "etc/hostname"):                   "changed\n",
+			filepath.Join(otherChroot.RootDir(), "etc/hostname"): "base\n",
+			filepath.Join(baseDir, "etc/hostname"):               "base\n",
+		} {
+			contents, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(contents), path)
+		}
+		assert.NoDirExists(t, filepath.Join(baseDir, expectedExtraDirectory))
+
+		err = chroot.Close(defaultLeaveOnDisk)
+		assert.NoError(t, err)
+
+		// Closing the chroot discards its changes.
+		assert.NoDirExists(t, dir)
+		assert.NoDirExists(t, dir+overlayDirSuffix)
+		assert.DirExists(t, otherChroot.RootDir())
+	}
+}
+
+func TestOverlayChrootShouldCleanupOnBadBase(t *testing.T) {
+	if buildpipeline.IsRegularBuild() {
+		// this test only apply to "regular build" pipeline
+		dir := filepath.Join(t.TempDir(), "TestOverlayChrootShouldCleanupOnBadBase")
+		chroot := NewOverlayChroot(dir, filepath.Join(t.TempDir(), "missing"))
+
+		err := chroot.Initialize(emptyPath, []string{}, []*MountPoint{}, true)
+		assert.ErrorContains(t, err, "failed to mount chroot overlay")
+
+		assert.NoDirExists(t, dir)
+		assert.NoDirExists(t, dir+overlayDirSuffix)
+	}
+}
+
+func TestInitializeShouldCreateMountSpecs(t *testing.T) {
+	if buildpipeline.IsRegularBuild() {
+		// this test only apply to "regular build" pipeline
+		const tmpfsSizeLimit = 4 * 1024 * 1024
+
+		srcMount := filepath.Join(testDir, "testmount")
+		extraMountPoints, err := NewMountPointsFromSpecs([]MountSpec{
+			{Source: srcMount, Target: "/readonly", Bind: true, ReadOnly: true, Propagation: MountPropagationPrivate},
+			{Source: "tmpfs", Target: "/scratch", FSType: "tmpfs", SizeLimit: tmpfsSizeLimit},
+		})
+		assert.NoError(t, err)
+
+		dir := filepath.Join(t.TempDir(), "TestInitializeShouldCreateMountSpecs")
+		chroot := NewChroot(dir, isExistingDir)
+
+		err = chroot.Initialize(emptyPath, []string{}, extraMountPoints, false)
+		assert.NoError(t, err)
+		defer chroot.Close(defaultLeaveOnDisk)
+
+		_, err = os.Stat(filepath.Join(dir, "/readonly/testfile.txt"))
+		assert.NoError(t, err)
+
+		err = os.WriteFile(filepath.Join(dir, "/readonly/new.txt"), nil, 0o644)
+		assert.ErrorIs(t, err, unix.EROFS)
+
+		var statfs unix.Statfs_t
+		err = unix.Statfs(filepath.Join(dir, "/scratch"), &statfs)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(tmpfsSizeLimit), statfs.Blocks*uint64(statfs.Bsize))
+	}
+}
+
+func TestInitializeShouldRejectInvalidMountPointBeforeMounting(t *testing.T) {
+	extraMountPoints := []*MountPoint{
+		NewMountPoint(filepath.Join(testDir, "testmount"), "../escape", "", BindMountPointFlags, emptyPath),
+	}
+
+	dir := filepath.Join(t.TempDir(), "TestInitializeShouldRejectInvalidMountPointBeforeMounting")
+	chroot := NewChroot(dir, isExistingDir)
+
+	err := chroot.Initialize(emptyPath, []string{}, extraMountPoints, true)
+	assert.ErrorContains(t, err, "invalid mount (../escape):\ntarget must be within the chroot")
+
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,430 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// RootlessMode is how a tool gets the privileges that its chroots need.
+type RootlessMode string
+
+const (
+	// RootlessModeNever requires the tool to be run as root.
+	RootlessModeNever RootlessMode = "never"
+	// RootlessModeAuto runs the tool within a user namespace if it isn't run as root.
+	RootlessModeAuto RootlessMode = "auto"
+	// RootlessModeAlways always runs the tool within a user namespace, even if it is run as root.
+	RootlessModeAlways RootlessMode = "always"
+)
+
+const (
+	// userNamespaceEnvVar is set for the process that is re-executed within a user namespace. Its value is the kind of
+	// uid/gid mappings of the namespace.
+	userNamespaceEnvVar = "AZL_TOOLKIT_USER_NAMESPACE"
+	// The user namespace only maps root (to the user that ran the tool).
+	userNamespaceMappingRoot = "root"
+	// The user namespace also maps the user's subordinate ids, so files can be owned by other users.
+	userNamespaceMappingSubordinate = "subordinate"
+
+	// The file descriptor that the re-executed process waits on until its uid/gid mappings have been written.
+	userNamespaceSyncFd = 3
+
+	subordinateUidsFile = "/etc/subuid"
+	subordinateGidsFile = "/etc/subgid"
+)
+
+// ErrUserNamespacesUnsupported is returned when the host's kernel doesn't allow the tool to create a user namespace.
+var ErrUserNamespacesUnsupported = errors.New("user namespaces are not supported")
+
+// subordinateIdRange is a range of ids that is delegated to a user (see subuid(5)).
+type subordinateIdRange struct {
+	Start int
+	Count int
+}
+
+// RootlessModes returns the valid values of RootlessMode, for command line flags.
+func RootlessModes() []string {
+	return []string{string(RootlessModeNever), string(RootlessModeAuto), string(RootlessModeAlways)}
+}
+
+// InUserNamespace returns true if the process was re-executed within a user namespace by EnterRootlessMode.
+func InUserNamespace() bool {
+	return os.Getenv(userNamespaceEnvVar) != ""
+}
+
+// userNamespaceHasSubordinateIds returns true if the process's user namespace maps more than just root, so files can
+// be owned by other users.
+func userNamespaceHasSubordinateIds() bool {
+	return os.Getenv(userNamespaceEnvVar) == userNamespaceMappingSubordinate
+}
+
+// EnterRootlessMode gives the tool the privileges that its chroots need, according to the rootless mode.
+//
+// To run within a user namespace, the tool is re-executed, with the same arguments, within a new user and mount
+// namespace in which the user is mapped to root. The original process waits for the re-executed process and then
+// exits with its exit code, so this must be called at the start of main, before the tool does any work. The
+// re-executed process appends to the original process's log file (see logger.AppendLogFileEnvVar), so the logger
+// may be initialized first.
+//
+// The mounts of the tool's chroots only exist within the mount namespace and are removed when the tool exits.
+func EnterRootlessMode(mode RootlessMode) error {
+	if InUserNamespace() {
+		return waitForUserNamespaceMappings()
+	}
+
+	switch mode {
+	case RootlessModeNever:
+		return nil
+
+	case RootlessModeAuto:
+		if os.Geteuid() == 0 {
+			return nil
+		}
+
+	case RootlessModeAlways:
+
+	default:
+		return fmt.Errorf("invalid rootless mode (%s)", mode)
+	}
+
+	err := CheckUserNamespaceSupport()
+	if err != nil {
+		if mode == RootlessModeAuto {
+			return fmt.Errorf("tool must be run as root, or in rootless mode:\n%w", err)
+		}
+		return err
+	}
+
+	exitCode, err := runInUserNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to run within user namespace:\n%w", err)
+	}
+
+	os.Exit(exitCode)
+	return nil
+}
+
+// CheckUserNamespaceSupport checks that the host's kernel allows the tool to create a user namespace. The error
+// explains why it doesn't, and wraps ErrUserNamespacesUnsupported.
+func CheckUserNamespaceSupport() error {
+	err := checkUserNamespaceSysctls("/proc", os.Geteuid())
+	if err != nil {
+		return err
+	}
+
+	// The sysctls don't cover everything (e.g. seccomp filters of container runtimes), so try to create one.
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER,
+	}
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w:\nfailed to create user namespace:\n%w", ErrUserNamespacesUnsupported, err)
+	}
+
+	return nil
+}
+
+// checkUserNamespaceSysctls checks the kernel's settings that restrict the creation of user namespaces.
+func checkUserNamespaceSysctls(procDir string, euid int) error {
+	_, err := os.Stat(filepath.Join(procDir, "self/ns/user"))
+	if err != nil {
+		return fmt.Errorf("%w:\nkernel was built without user namespaces (CONFIG_USER_NS)", ErrUserNamespacesUnsupported)
+	}
+
+	checks := []struct {
+		sysctl        string
+		disabledValue string
+		unprivileged  bool
+		reason        string
+	}{
+		{"user/max_user_namespaces", "0", false, "user namespaces are disabled"},
+		// Debian and older Ubuntu kernels.
+		{"kernel/unprivileged_userns_clone", "0", true, "unprivileged user namespaces are disabled"},
+		// Ubuntu 24.04 and later.
+		{"kernel/apparmor_restrict_unprivileged_userns", "1", true, "AppArmor restricts unprivileged user namespaces"},
+	}
+
+	for _, check := range checks {
+		if check.unprivileged && euid == 0 {
+			continue
+		}
+
+		value, err := os.ReadFile(filepath.Join(procDir, "sys", check.sysctl))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read sysctl (%s):\n%w", check.sysctl, err)
+		}
+
+		if strings.TrimSpace(string(value)) == check.disabledValue {
+			sysctlName := strings.ReplaceAll(check.sysctl, "/", ".")
+			return fmt.Errorf("%w:\n%s (%s = %s)", ErrUserNamespacesUnsupported, check.reason, sysctlName,
+				check.disabledValue)
+		}
+	}
+
+	return nil
+}
+
+// runInUserNamespace re-executes the tool within a new user and mount namespace and returns its exit code.
+func runInUserNamespace() (exitCode int, err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find tool's executable:\n%w", err)
+	}
+
+	uid := os.Getuid()
+	gid := os.Getgid()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		// Don't leave the re-executed process running if the tool is killed.
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	// Pdeathsig is sent when the thread that started the process exits, so stay on the thread until it has exited.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	uidRange, gidRange, err := getSubordinateIdRanges(uid)
+	if err != nil {
+		return 0, err
+	}
+
+	if uidRange == nil || gidRange == nil {
+		logger.Log.Warnf("No subordinate uids and gids (%s, %s) for user, or newuidmap and newgidmap aren't "+
+			"installed: only root is mapped within the user namespace, so files can't be owned by other users",
+			subordinateUidsFile, subordinateGidsFile)
+
+		cmd.Env = append(os.Environ(), userNamespaceEnvVar+"="+userNamespaceMappingRoot, logger.AppendLogFileEnvVar+"=1")
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+		// An unprivileged process may only map its own gid if it can't change its supplementary groups.
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+
+		err = cmd.Start()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		// The kernel only lets a process map its own ids. So, the setuid newuidmap and newgidmap tools write the
+		// mappings of the subordinate ids, once the process has been started.
+		syncReader, syncWriter, err := os.Pipe()
+		if err != nil {
+			return 0, fmt.Errorf("failed to create pipe:\n%w", err)
+		}
+		defer syncWriter.Close()
+
+		cmd.Env = append(os.Environ(), userNamespaceEnvVar+"="+userNamespaceMappingSubordinate,
+			logger.AppendLogFileEnvVar+"=1")
+		cmd.ExtraFiles = []*os.File{syncReader}
+
+		err = cmd.Start()
+		syncReader.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		err = writeSubordinateIdMappings(cmd.Process.Pid, uid, gid, *uidRange, *gidRange)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return 0, err
+		}
+
+		// Closing the pipe lets the process continue.
+		syncWriter.Close()
+	}
+
+	logger.Log.Debugf("Re-executed tool within user namespace (pid %d)", cmd.Process.Pid)
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// waitForUserNamespaceMappings waits, within the re-executed process, until its uid/gid mappings have been written.
+func waitForUserNamespaceMappings() error {
+	if userNamespaceHasSubordinateIds() {
+		syncFile := os.NewFile(userNamespaceSyncFd, "userns-sync")
+		_, err := io.Copy(io.Discard, syncFile)
+		syncFile.Close()
+		if err != nil {
+			return fmt.Errorf("failed to wait for user namespace's uid/gid mappings:\n%w", err)
+		}
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("user isn't mapped to root within user namespace (euid = %d)", os.Geteuid())
+	}
+
+	return nil
+}
+
+// getSubordinateIdRanges gets the user's subordinate uids and gids. Returns nil ranges if the user doesn't have any,
+// or if the tools to map them aren't installed.
+func getSubordinateIdRanges(uid int) (uidRange *subordinateIdRange, gidRange *subordinateIdRange, err error) {
+	for _, tool := range []string{"newuidmap", "newgidmap"} {
+		_, err = exec.LookPath(tool)
+		if err != nil {
+			return nil, nil, nil
+		}
+	}
+
+	userName := ""
+	currentUser, err := user.LookupId(strconv.Itoa(uid))
+	if err == nil {
+		userName = currentUser.Username
+	}
+
+	// newgidmap looks up the ranges of the user, not of the group.
+	uidRange, err = readSubordinateIdRange(subordinateUidsFile, userName, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gidRange, err = readSubordinateIdRange(subordinateGidsFile, userName, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Log.Debugf("Subordinate ids of user (%s): uids (%v), gids (%v)", userName, uidRange, gidRange)
+
+	return uidRange, gidRange, nil
+}
+
+// readSubordinateIdRange reads the first of the user's ranges from a subuid(5) or subgid(5) file.
+func readSubordinateIdRange(idsFile string, userName string, uid int) (*subordinateIdRange, error) {
+	file, err := os.Open(idsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open subordinate ids file (%s):\n%w", idsFile, err)
+	}
+	defer file.Close()
+
+	idRange, err := parseSubordinateIdRange(file, userName, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subordinate ids file (%s):\n%w", idsFile, err)
+	}
+
+	return idRange, nil
+}
+
+// parseSubordinateIdRange finds the first of the user's ranges in the contents of a subuid(5) or subgid(5) file. The
+// user may be listed by name or by uid.
+func parseSubordinateIdRange(reader io.Reader, userName string, uid int) (*subordinateIdRange, error) {
+	uidString := strconv.Itoa(uid)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line (%s)", line)
+		}
+
+		if fields[0] != uidString && (userName == "" || fields[0] != userName) {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start id on line (%s):\n%w", line, err)
+		}
+
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid id count on line (%s):\n%w", line, err)
+		}
+
+		if count <= 0 {
+			continue
+		}
+
+		return &subordinateIdRange{Start: start, Count: count}, nil
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// writeSubordinateIdMappings maps root to the user and ids 1 and up to the user's subordinate ids.
+func writeSubordinateIdMappings(pid int, uid int, gid int, uidRange subordinateIdRange,
+	gidRange subordinateIdRange,
+) error {
+	mappingTools := []struct {
+		tool    string
+		id      int
+		idRange subordinateIdRange
+	}{
+		{"newuidmap", uid, uidRange},
+		{"newgidmap", gid, gidRange},
+	}
+
+	for _, mappingTool := range mappingTools {
+		args := formatIdMappingArgs(pid, mappingTool.id, mappingTool.idRange)
+
+		output, err := exec.Command(mappingTool.tool, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to map ids of user namespace (%s %s):\n%s\n%w", mappingTool.tool,
+				strings.Join(args, " "), strings.TrimSpace(string(output)), err)
+		}
+	}
+
+	return nil
+}
+
+// formatIdMappingArgs formats the arguments of newuidmap(1) and newgidmap(1).
+func formatIdMappingArgs(pid int, id int, idRange subordinateIdRange) []string {
+	return []string{
+		strconv.Itoa(pid),
+		"0", strconv.Itoa(id), "1",
+		"1", strconv.Itoa(idRange.Start), strconv.Itoa(idRange.Count),
+	}
+}
+
+// userNamespaceMountPoints returns the default mount points of a chroot within a user namespace. The kernel doesn't
+// let a user namespace mount devtmpfs or devpts, or mount procfs and sysfs without also creating pid and network
+// namespaces, so the host's /dev, /proc, and /sys are bind mounted instead.
+func userNamespaceMountPoints() []*MountPoint {
+	return mustNewMountPointsFromSpecs([]MountSpec{
+		{Source: "/dev", Target: "/dev", Bind: true, Recursive: true},
+		{Source: "/proc", Target: "/proc", Bind: true, Recursive: true},
+		{Source: "/sys", Target: "/sys", Bind: true, Recursive: true},
+		{Source: "tmpfs", Target: "/run", FSType: "tmpfs"},
+	})
+}
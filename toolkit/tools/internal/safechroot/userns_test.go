@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFakeSysctl(t *testing.T, procDir string, sysctl string, value string) {
+	sysctlPath := filepath.Join(procDir, "sys", sysctl)
+	err := os.MkdirAll(filepath.Dir(sysctlPath), os.ModePerm)
+	require.NoError(t, err)
+
+	err = os.WriteFile(sysctlPath, []byte(value+"\n"), 0o644)
+	require.NoError(t, err)
+}
+
+func newFakeProcDir(t *testing.T) string {
+	procDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(procDir, "self/ns"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(procDir, "self/ns/user"), nil, 0o644)
+	require.NoError(t, err)
+
+	writeFakeSysctl(t, procDir, "user/max_user_namespaces", "63432")
+	return procDir
+}
+
+func TestCheckUserNamespaceSysctlsSupported(t *testing.T) {
+	procDir := newFakeProcDir(t)
+	writeFakeSysctl(t, procDir, "kernel/unprivileged_userns_clone", "1")
+	writeFakeSysctl(t, procDir, "kernel/apparmor_restrict_unprivileged_userns", "0")
+
+	assert.NoError(t, checkUserNamespaceSysctls(procDir, 1000))
+}
+
+func TestCheckUserNamespaceSysctlsNoKernelSupport(t *testing.T) {
+	err := checkUserNamespaceSysctls(t.TempDir(), 1000)
+	assert.ErrorIs(t, err, ErrUserNamespacesUnsupported)
+	assert.ErrorContains(t, err, "kernel was built without user namespaces (CONFIG_USER_NS)")
+}
+
+func TestCheckUserNamespaceSysctlsDisabled(t *testing.T) {
+	procDir := newFakeProcDir(t)
+	writeFakeSysctl(t, procDir, "user/max_user_namespaces", "0")
+
+	// Even root can't create user namespaces.
+	err := checkUserNamespaceSysctls(procDir, 0)
+	assert.ErrorIs(t, err, ErrUserNamespacesUnsupported)
+	assert.ErrorContains(t, err, "user namespaces are disabled (user.max_user_namespaces = 0)")
+}
+
+func TestCheckUserNamespaceSysctlsUnprivilegedDisabled(t *testing.T) {
+	procDir := newFakeProcDir(t)
+	writeFakeSysctl(t, procDir, "kernel/unprivileged_userns_clone", "0")
+
+	err := checkUserNamespaceSysctls(procDir, 1000)
+	assert.ErrorIs(t, err, ErrUserNamespacesUnsupported)
+	assert.ErrorContains(t, err,
+		"unprivileged user namespaces are disabled (kernel.unprivileged_userns_clone = 0)")
+
+	assert.NoError(t, checkUserNamespaceSysctls(procDir, 0))
+}
+
+func TestCheckUserNamespaceSysctlsAppArmorRestricted(t *testing.T) {
+	procDir := newFakeProcDir(t)
+	writeFakeSysctl(t, procDir, "kernel/apparmor_restrict_unprivileged_userns", "1")
+
+	err := checkUserNamespaceSysctls(procDir, 1000)
+	assert.ErrorIs(t, err, ErrUserNamespacesUnsupported)
+	assert.ErrorContains(t, err,
+		"AppArmor restricts unprivileged user namespaces (kernel.apparmor_restrict_unprivileged_userns = 1)")
+
+	assert.NoError(t, checkUserNamespaceSysctls(procDir, 0))
+}
+
+func TestParseSubordinateIdRange(t *testing.T) {
+	const subuid = "# Subordinate ids\n" +
+		"builder:100000:65536\n" +
+		"1001:165536:65536\n" +
+		"\n" +
+		"empty:231072:0\n" +
+		"empty:296608:1000\n"
+
+	idRange, err := parseSubordinateIdRange(strings.NewReader(subuid), "builder", 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, &subordinateIdRange{Start: 100000, Count: 65536}, idRange)
+
+	// A user may be listed by uid.
+	idRange, err = parseSubordinateIdRange(strings.NewReader(subuid), "", 1001)
+	assert.NoError(t, err)
+	assert.Equal(t, &subordinateIdRange{Start: 165536, Count: 65536}, idRange)
+
+	// Empty ranges are skipped.
+	idRange, err = parseSubordinateIdRange(strings.NewReader(subuid), "empty", 1002)
+	assert.NoError(t, err)
+	assert.Equal(t, &subordinateIdRange{Start: 296608, Count: 1000}, idRange)
+
+	idRange, err = parseSubordinateIdRange(strings.NewReader(subuid), "other", 1003)
+	assert.NoError(t, err)
+	assert.Nil(t, idRange)
+}
+
+func TestParseSubordinateIdRangeInvalid(t *testing.T) {
+	_, err := parseSubordinateIdRange(strings.NewReader("builder:100000\n"), "builder", 1000)
+	assert.ErrorContains(t, err, "invalid line (builder:100000)")
+
+	_, err = parseSubordinateIdRange(strings.NewReader("builder:abc:65536\n"), "builder", 1000)
+	assert.ErrorContains(t, err, "invalid start id on line (builder:abc:65536)")
+}
+
+func TestFormatIdMappingArgs(t *testing.T) {
+	args := formatIdMappingArgs(4242, 1000, subordinateIdRange{Start: 100000, Count: 65536})
+	assert.Equal(t, []string{"4242", "0", "1000", "1", "1", "100000", "65536"}, args)
+}
+
+func TestEnterRootlessModeInvalidMode(t *testing.T) {
+	t.Setenv(userNamespaceEnvVar, "")
+
+	assert.ErrorContains(t, EnterRootlessMode("sometimes"), "invalid rootless mode (sometimes)")
+	assert.NoError(t, EnterRootlessMode(RootlessModeNever))
+}
+
+func TestUserNamespaceMountPoints(t *testing.T) {
+	t.Setenv(userNamespaceEnvVar, userNamespaceMappingRoot)
+
+	mountPoints := defaultMountPoints()
+	targets := []string(nil)
+	for _, mountPoint := range mountPoints {
+		targets = append(targets, mountPoint.GetTarget())
+		assert.NotEqual(t, "devtmpfs", mountPoint.GetFSType())
+	}
+	assert.Equal(t, []string{"/dev", "/proc", "/sys", "/run"}, targets)
+}
@@ -19,6 +19,8 @@ type Mount struct {
 	target     string
 	isMounted  bool
 	dirCreated bool
+	// recursive is true if the mount has submounts, in which case it can only be unmounted by detaching it.
+	recursive bool
 }
 
 // Creates a new system mount.
@@ -66,6 +68,62 @@ func (m *Mount) newMountHelper(source, target, fstype string, flags uintptr, dat
 	return nil
 }
 
+// NewReadOnlyBindMount creates a read-only view of a directory, including the mounts under the directory. Changes that
+// are made to the source directory are visible through the view.
+//
+// Requires Linux 5.12 or later.
+func NewReadOnlyBindMount(source, target string, makeAndDeleteDir bool) (*Mount, error) {
+	mount := &Mount{
+		target:    target,
+		recursive: true,
+	}
+
+	err := mount.newReadOnlyBindMountHelper(source, target, makeAndDeleteDir)
+	if err != nil {
+		// Cleanup anything created during the failed mount.
+		mount.Close()
+		return nil, err
+	}
+
+	return mount, nil
+}
+
+func (m *Mount) newReadOnlyBindMountHelper(source, target string, makeAndDeleteDir bool) error {
+	logger.Log.Debugf("Read-only bind mounting: source: (%s), target: (%s)", source, target)
+
+	if makeAndDeleteDir {
+		err := os.MkdirAll(target, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create mount directory (%s):\n%w", target, err)
+		}
+
+		m.dirCreated = true
+	}
+
+	// Unlike mount(2), which only makes the top mount of a recursive bind mount read-only, mount_setattr(2) can make
+	// the whole tree read-only.
+	treeFd, err := unix.OpenTree(unix.AT_FDCWD, source,
+		unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE)
+	if err != nil {
+		return fmt.Errorf("failed to clone mount tree (%s):\n%w", source, err)
+	}
+	defer unix.Close(treeFd)
+
+	err = unix.MountSetattr(treeFd, "", unix.AT_EMPTY_PATH|unix.AT_RECURSIVE,
+		&unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY})
+	if err != nil {
+		return fmt.Errorf("failed to make mount tree (%s) read-only:\n%w", source, err)
+	}
+
+	err = unix.MoveMount(treeFd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH)
+	if err != nil {
+		return fmt.Errorf("failed to mount (%s) to (%s):\n%w", source, target, err)
+	}
+
+	m.isMounted = true
+	return nil
+}
+
 // Target returns the target directory of the mount.
 func (m *Mount) Target() string {
 	return m.target
@@ -93,7 +151,15 @@ func (m *Mount) close(async bool) error {
 	var err error
 
 	if m.isMounted {
-		if !async {
+		if m.recursive {
+			// A mount tree can't be unmounted in one go. But since a recursive mount is read-only, nothing is lost by
+			// detaching it.
+			logger.Log.Debugf("Detaching (%s)", m.target)
+			err = unix.Unmount(m.target, unix.MNT_DETACH)
+			if err != nil {
+				return fmt.Errorf("failed to detach mount (%s):\n%w", m.target, err)
+			}
+		} else if !async {
 			logger.Log.Debugf("Unmounting (%s)", m.target)
 			_, err = retry.RunWithExpBackoff(context.Background(),
 				func() error {
@@ -16,6 +16,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safeloopback"
 	"github.com/moby/sys/mountinfo"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -133,3 +134,64 @@ func TestResourceBusy(t *testing.T) {
 	}
 	assert.Equal(t, false, exists, "mount directory still exists")
 }
+
+func TestReadOnlyBindMount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Short mode enabled")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("Test must be run as root because it creates mounts")
+	}
+
+	buildDir := filepath.Join(tmpDir, "TestReadOnlyBindMount")
+	sourceDir := filepath.Join(buildDir, "source")
+	viewDir := filepath.Join(buildDir, "view")
+
+	// Use a tmpfs for the source and another for a submount, so that the test doesn't depend on the host's
+	// filesystems.
+	sourceMount, err := NewMount("tmpfs", sourceDir, "tmpfs", 0, "", true)
+	if !assert.NoError(t, err, "failed to mount source") {
+		return
+	}
+	defer sourceMount.Close()
+
+	subMount, err := NewMount("tmpfs", filepath.Join(sourceDir, "sub"), "tmpfs", 0, "", true)
+	if !assert.NoError(t, err, "failed to mount submount") {
+		return
+	}
+	defer subMount.Close()
+
+	view, err := NewReadOnlyBindMount(sourceDir, viewDir, true)
+	if !assert.NoError(t, err, "failed to create read-only view") {
+		return
+	}
+	defer view.Close()
+
+	// Changes to the source are visible through the view.
+	err = os.WriteFile(filepath.Join(sourceDir, "sub", "test"), []byte("test"), 0o644)
+	assert.NoError(t, err)
+
+	contents, err := os.ReadFile(filepath.Join(viewDir, "sub", "test"))
+	assert.NoError(t, err)
+	assert.Equal(t, "test", string(contents))
+
+	// The view and its submounts are read-only.
+	err = os.WriteFile(filepath.Join(viewDir, "test"), []byte("test"), 0o644)
+	assert.ErrorIs(t, err, unix.EROFS)
+
+	err = os.WriteFile(filepath.Join(viewDir, "sub", "test"), []byte("changed"), 0o644)
+	assert.ErrorIs(t, err, unix.EROFS)
+
+	err = view.CleanClose()
+	assert.NoError(t, err, "failed to close the view")
+
+	exists, err := file.PathExists(viewDir)
+	assert.NoError(t, err)
+	assert.False(t, exists, "view directory still exists")
+
+	// Closing the view doesn't affect the source's mounts.
+	isMounted, err := mountinfo.Mounted(filepath.Join(sourceDir, "sub"))
+	assert.NoError(t, err)
+	assert.True(t, isMounted, "submount was unmounted")
+}
@@ -5,17 +5,26 @@ package shell
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
+// processCgroupCount is used to give each process's cgroup a unique name.
+var processCgroupCount atomic.Uint64
+
 const (
 	// LogDisabledLevel is a fake logrus log level, that is used by ExecBuilder to represent that logging should be
 	// disabled.
@@ -28,6 +37,7 @@ const (
 type LogCallback func(line string)
 
 type ExecBuilder struct {
+	ctx                  context.Context
 	command              string
 	args                 []string
 	workingDirectory     string
@@ -39,6 +49,8 @@ type ExecBuilder struct {
 	stderrCallback       LogCallback
 	errorStderrLines     int
 	warnLogLines         int
+	usePty               bool
+	ptyWindowSize        WindowSize
 }
 
 // NewExecBuilder initializes a new execution builder object.
@@ -52,6 +64,13 @@ func NewExecBuilder(command string, args ...string) ExecBuilder {
 	return b
 }
 
+// Context sets the context of the command to be executed. If the context is canceled or its deadline passes, then
+// the command is killed, along with all of its child processes.
+func (b ExecBuilder) Context(ctx context.Context) ExecBuilder {
+	b.ctx = ctx
+	return b
+}
+
 // WorkingDirectory sets the working directory for the command to be executed.
 func (b ExecBuilder) WorkingDirectory(path string) ExecBuilder {
 	b.workingDirectory = path
@@ -70,6 +89,16 @@ func (b ExecBuilder) Stdin(value string) ExecBuilder {
 	return b
 }
 
+// Pty runs the command attached to a new pseudo-terminal with the window size, for tools that behave differently when
+// they aren't run in a terminal. A pseudo-terminal combines stdout and stderr. So, all the output is handled as stdout
+// (e.g. captured as stdout and logged at the stdout log level), except that it is also used for ErrorStderrLines. Like
+// with a terminal, the Stdin value is echoed to the output.
+func (b ExecBuilder) Pty(windowSize WindowSize) ExecBuilder {
+	b.usePty = true
+	b.ptyWindowSize = windowSize
+	return b
+}
+
 // Sets the log level for stdout lines.
 func (b ExecBuilder) StdoutLogLevel(stdoutLogLevel logrus.Level) ExecBuilder {
 	b.stdoutLogLevel = stdoutLogLevel
@@ -160,28 +189,100 @@ func (b ExecBuilder) executeHelper(captureOutput bool) (string, string, error) {
 		stderrResultChan = make(chan string, 1)
 	}
 
+	parentCtx := b.ctx
+	if parentCtx == nil {
+		parentCtx = context.Background()
+	}
+
+	limits := currentLimits
+
+	ctx := parentCtx
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+
 	// Setup process.
-	cmd := exec.Command(b.command, b.args...)
+	cmd := exec.CommandContext(ctx, b.command, b.args...)
 	cmd.Dir = b.workingDirectory
 	cmd.Env = b.environmentVariables
 
-	if b.stdinString != "" {
-		cmd.Stdin = strings.NewReader(b.stdinString)
+	cmd.SysProcAttr = &unix.SysProcAttr{}
+
+	var err error
+	var stdoutPipe, stderrPipe io.ReadCloser
+	var ptyMaster, ptySlave *os.File
+	if b.usePty {
+		ptyMaster, ptySlave, err = openPty(b.ptyWindowSize)
+		if err != nil {
+			err = fmt.Errorf("failed to open pty:\n%w", err)
+			return "", "", err
+		}
+		defer ptyMaster.Close()
+		defer ptySlave.Close()
+
+		cmd.Stdin = ptySlave
+		cmd.Stdout = ptySlave
+		cmd.Stderr = ptySlave
+
+		// Make the pty the process's controlling terminal.
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+
+		// The pty's output includes the process's stderr.
+		if errorChan != nil {
+			stdoutLinesChans = append(stdoutLinesChans, errorChan)
+		}
+
+		stdoutPipe = ptyMasterReader{ptyMaster}
+		stderrPipe = io.NopCloser(strings.NewReader(""))
+	} else {
+		if b.stdinString != "" {
+			cmd.Stdin = strings.NewReader(b.stdinString)
+		}
+
+		stdoutPipe, err = cmd.StdoutPipe()
+		if err != nil {
+			err = fmt.Errorf("failed to open stdout pipe:\n%w", err)
+			return "", "", err
+		}
+		defer stdoutPipe.Close()
+
+		stderrPipe, err = cmd.StderrPipe()
+		if err != nil {
+			err = fmt.Errorf("failed to open stderr pipe:\n%w", err)
+			return "", "", err
+		}
+		defer stderrPipe.Close()
 	}
 
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		err = fmt.Errorf("failed to open stdout pipe:\n%w", err)
-		return "", "", err
+	var processCgroup *cgroup.Cgroup
+	if limits.CgroupParent != nil {
+		cgroupName := fmt.Sprintf("cmd-%d-%d", os.Getpid(), processCgroupCount.Add(1))
+		processCgroup, err = limits.CgroupParent.New(cgroupName, limits.Resources)
+		if err != nil {
+			err = fmt.Errorf("failed to create cgroup of process:\n%w", err)
+			return "", "", err
+		}
+
+		// Kill any processes that the process left behind (e.g. daemons), so that they don't hold onto the chroot.
+		defer func() {
+			closeErr := processCgroup.Close()
+			if closeErr != nil {
+				logger.Log.Warnf("Failed to cleanup cgroup of process (%s):\n%v", b.command, closeErr)
+			}
+		}()
+
+		processCgroup.SetProcAttr(cmd.SysProcAttr)
 	}
-	defer stdoutPipe.Close()
 
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		err = fmt.Errorf("failed to open stderr pipe:\n%w", err)
-		return "", "", err
+	// Once the context is done, kill the whole process tree instead of only the process itself.
+	cmd.Cancel = func() error {
+		killProcessTree(cmd, processCgroup)
+		return nil
 	}
-	defer stderrPipe.Close()
 
 	// Start process.
 	err = trackAndStartProcess(cmd)
@@ -192,6 +293,16 @@ func (b ExecBuilder) executeHelper(captureOutput bool) (string, string, error) {
 
 	defer untrackProcess(cmd)
 
+	if ptySlave != nil {
+		// Only the process may keep the pty open, so that reading the output ends once the process (and any child
+		// processes) exit.
+		ptySlave.Close()
+
+		if b.stdinString != "" {
+			go ptyMaster.WriteString(b.stdinString)
+		}
+	}
+
 	// Read stdout and stderr.
 	wg := new(sync.WaitGroup)
 	wg.Add(2)
@@ -222,6 +333,8 @@ func (b ExecBuilder) executeHelper(captureOutput bool) (string, string, error) {
 	}
 
 	if err != nil {
+		err = explainStoppedProcessError(b.command, limits, processCgroup, parentCtx, ctx, err)
+
 		if warnLogChan != nil {
 			// Report last x lines of process's output (stdout and stderr) as warning logs.
 			logger.Log.Errorf("Call to %s returned error, last %d lines of output:", b.command, b.warnLogLines)
@@ -250,6 +363,53 @@ func (b ExecBuilder) executeHelper(captureOutput bool) (string, string, error) {
 	return stdout, stderr, err
 }
 
+// killProcessTree kills a process, along with all of its child processes.
+func killProcessTree(cmd *exec.Cmd, processCgroup *cgroup.Cgroup) {
+	logger.Log.Warnf("Killing process (%s)", cmd.Path)
+
+	// The cgroup also has the child processes that left the process group.
+	if processCgroup != nil {
+		err := processCgroup.Kill()
+		if err == nil {
+			return
+		}
+
+		logger.Log.Warnf("Failed to kill processes of cgroup:\n%v", err)
+	}
+
+	err := unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+	if err != nil {
+		logger.Log.Warnf("Failed to kill process (%s):\n%v", cmd.Path, err)
+	}
+}
+
+// explainStoppedProcessError adds the reason that a failed process was stopped (if any) to the process's error.
+// parentCtx is the caller's context and ctx is the process's context, which also has the process's timeout.
+func explainStoppedProcessError(command string, limits ProcessLimits, processCgroup *cgroup.Cgroup,
+	parentCtx context.Context, ctx context.Context, err error,
+) error {
+	if parentCtx.Err() != nil {
+		return fmt.Errorf("%s was stopped (%w):\n%w", command, parentCtx.Err(), err)
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s timed out after %s:\n%w", command, limits.Timeout, err)
+	}
+
+	if processCgroup != nil {
+		oomKilled, oomErr := processCgroup.OOMKilled()
+		if oomErr != nil {
+			logger.Log.Warnf("Failed to check if process (%s) ran out of memory:\n%v", command, oomErr)
+		}
+
+		if oomKilled {
+			return fmt.Errorf("%s exceeded its memory limit (%d bytes):\n%w", command, limits.Resources.MemoryMax, err)
+		}
+	}
+
+	return err
+}
+
 func execBuilderReadPipe(pipe io.Reader, wg *sync.WaitGroup, logCallback LogCallback, logLevel logrus.Level,
 	linesOutputChans []chan string, outputResultChan chan string,
 ) {
@@ -258,22 +418,18 @@ func execBuilderReadPipe(pipe io.Reader, wg *sync.WaitGroup, logCallback LogCall
 	outputBuilder := strings.Builder{}
 
 	reader := bufio.NewReader(pipe)
+	previousEndedWithCR := false
 	for {
 		// Read up to the next line.
-		bytes, err := reader.ReadBytes('\n')
-
-		// Drop \n or \r\n from line.
-		omitBytes := 0
-		if len(bytes) >= 1 && bytes[len(bytes)-1] == '\n' {
-			omitBytes = 1
-			if len(bytes) >= 2 && bytes[len(bytes)-2] == '\r' {
-				omitBytes = 2
-			}
-		}
+		bytes, err := readOutputLine(reader)
+
+		// The "\n" of a "\r\n" line ending that was split across reads isn't a line of its own.
+		isSplitLineEnding := previousEndedWithCR && len(bytes) == 1 && bytes[0] == '\n'
+		previousEndedWithCR = len(bytes) >= 1 && bytes[len(bytes)-1] == '\r'
 
-		line := string(bytes[:len(bytes)-omitBytes])
+		line := string(trimLineEnding(bytes))
 
-		if logCallback != nil {
+		if logCallback != nil && !isSplitLineEnding {
 			// Call user callback.
 			logCallback(line)
 		}
@@ -283,7 +439,7 @@ func execBuilderReadPipe(pipe io.Reader, wg *sync.WaitGroup, logCallback LogCall
 
 		// Most command-line tools will add a blank line at the of the stdout/stderr.
 		// We don't need such lines in our own logs.
-		if !lastLine || !lineIsBlank {
+		if (!lastLine || !lineIsBlank) && !isSplitLineEnding {
 			if logLevel <= logrus.TraceLevel {
 				// Log the line.
 				logger.Log.Log(logLevel, line)
@@ -312,6 +468,46 @@ func execBuilderReadPipe(pipe io.Reader, wg *sync.WaitGroup, logCallback LogCall
 	}
 }
 
+// readOutputLine reads up to and including the next line ending. Progress meters (e.g. of mksquashfs) redraw their
+// line by ending it with a lone carriage return ("\r"). So, a carriage return that isn't immediately followed by a
+// newline also ends a line, so that each update of the progress is passed on as it happens.
+func readOutputLine(reader *bufio.Reader) ([]byte, error) {
+	line := []byte(nil)
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return line, err
+		}
+
+		line = append(line, c)
+
+		switch c {
+		case '\n':
+			return line, nil
+
+		case '\r':
+			// Don't wait for more output to find out if this is a "\r\n" line ending, since the next update of a
+			// progress meter may be a while away.
+			if reader.Buffered() > 0 {
+				next, err := reader.Peek(1)
+				if err == nil && next[0] == '\n' {
+					reader.ReadByte()
+					line = append(line, '\n')
+				}
+			}
+
+			return line, nil
+		}
+	}
+}
+
+// trimLineEnding drops the "\n", "\r\n" or "\r" from the end of a line.
+func trimLineEnding(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return line
+}
+
 // channelDropAndPush treats a channel as a circular buffer.
 func channelDropAndPush(line string, outputChan chan string) {
 	const maxRetries = 8
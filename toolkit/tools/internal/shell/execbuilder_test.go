@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func TestExecuteShouldKillProcessAfterTimeout(t *testing.T) {
+	originalLimits := CurrentProcessLimits()
+	SetProcessLimits(ProcessLimits{Timeout: 100 * time.Millisecond})
+	defer SetProcessLimits(originalLimits)
+
+	start := time.Now()
+	err := NewExecBuilder("sleep", "60").Execute()
+	assert.ErrorContains(t, err, "sleep timed out after 100ms")
+	assert.Less(t, time.Since(start), 30*time.Second)
+
+	err = NewExecBuilder("true").Execute()
+	assert.NoError(t, err)
+}
+
+func TestExecuteContextShouldKillProcessWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, _, err := ExecuteContext(ctx, "sleep", "60")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "sleep was stopped")
+	assert.Less(t, time.Since(start), 30*time.Second)
+
+	// A context that is already done doesn't start the process at all.
+	_, _, err = ExecuteContext(ctx, "true")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestExecuteShouldStreamProgressLines(t *testing.T) {
+	lines := []string(nil)
+	stdout, _, err := NewExecBuilder("printf", "10%%\\r50%%\\r100%%\\r\\ndone\\n").
+		StdoutCallback(func(line string) {
+			lines = append(lines, line)
+		}).
+		ExecuteCaptureOuput()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10%", "50%", "100%", "done", ""}, lines)
+
+	// The captured output is left as-is.
+	assert.Equal(t, "10%\r50%\r100%\r\ndone\n", stdout)
+}
+
+func TestReadOutputLine(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("a\r\nb\rc\nd"))
+
+	expectedLines := []string{"a\r\n", "b\r", "c\n", "d"}
+	for _, expectedLine := range expectedLines {
+		line, _ := readOutputLine(reader)
+		assert.Equal(t, expectedLine, string(line))
+	}
+
+	_, err := readOutputLine(reader)
+	assert.Error(t, err)
+}
+
+func TestExecuteWithPty(t *testing.T) {
+	output, err := ExecuteWithPty(WindowSize{Rows: 30, Columns: 100}, "sh", "-c", "test -t 0 && test -t 1 && stty size")
+	assert.NoError(t, err)
+	assert.Equal(t, "30 100\r\n", output)
+}
+
+func TestExecuteWithPtyDefaultWindowSize(t *testing.T) {
+	output, err := ExecuteWithPty(WindowSize{}, "stty", "size")
+	assert.NoError(t, err)
+	assert.Equal(t, "24 80\r\n", output)
+}
+
+func TestExecuteWithPtyShouldCombineStdoutAndStderr(t *testing.T) {
+	stdout, stderr, err := NewExecBuilder("sh", "-c", "echo out; echo err >&2; exit 3").
+		Pty(WindowSize{}).
+		ErrorStderrLines(1).
+		ExecuteCaptureOuput()
+	assert.ErrorContains(t, err, "err\nexit status 3")
+	assert.Equal(t, "out\r\nerr\r\n", stdout)
+	assert.Equal(t, "", stderr)
+}
+
+func TestExecuteWithPtyShouldPassStdin(t *testing.T) {
+	stdout, _, err := NewExecBuilder("sh", "-c", "stty -echo; read answer; echo \"answer: $answer\"").
+		Pty(WindowSize{}).
+		Stdin("yes\n").
+		ExecuteCaptureOuput()
+	assert.NoError(t, err)
+	assert.Contains(t, stdout, "answer: yes\r\n")
+}
@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	defaultPtyRows    = 24
+	defaultPtyColumns = 80
+)
+
+// WindowSize is the size of a pseudo-terminal, in characters. A zero value uses the default size (24 rows by 80
+// columns).
+type WindowSize struct {
+	Rows    uint16
+	Columns uint16
+}
+
+func (s WindowSize) withDefaults() WindowSize {
+	if s.Rows == 0 {
+		s.Rows = defaultPtyRows
+	}
+	if s.Columns == 0 {
+		s.Columns = defaultPtyColumns
+	}
+	return s
+}
+
+// ptyMasterReader reads the output of the processes of a pseudo-terminal.
+type ptyMasterReader struct {
+	*os.File
+}
+
+// Read reads from the pseudo-terminal's master. Once all the processes have closed the pseudo-terminal, Linux fails
+// the reads with EIO instead of returning the end of the file. So, EIO is treated as the end of the output.
+func (r ptyMasterReader) Read(p []byte) (int, error) {
+	n, err := r.File.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		return n, io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const ptyMultiplexerPath = "/dev/ptmx"
+
+// openPty opens a new pseudo-terminal with the window size. The slave is what the process is attached to and the
+// master is what the process's output is read from.
+func openPty(windowSize WindowSize) (master *os.File, slave *os.File, err error) {
+	masterFd, err := unix.Open(ptyMultiplexerPath, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open (%s):\n%w", ptyMultiplexerPath, err)
+	}
+
+	closeMaster := true
+	defer func() {
+		if closeMaster {
+			unix.Close(masterFd)
+		}
+	}()
+
+	// Unlock the slave, so that it can be opened.
+	err = unix.IoctlSetPointerInt(masterFd, unix.TIOCSPTLCK, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unlock pty:\n%w", err)
+	}
+
+	ptyNumber, err := unix.IoctlGetUint32(masterFd, unix.TIOCGPTN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get pty number:\n%w", err)
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNumber)
+	slaveFd, err := unix.Open(slavePath, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open pty (%s):\n%w", slavePath, err)
+	}
+
+	windowSize = windowSize.withDefaults()
+	err = unix.IoctlSetWinsize(slaveFd, unix.TIOCSWINSZ, &unix.Winsize{
+		Row: windowSize.Rows,
+		Col: windowSize.Columns,
+	})
+	if err != nil {
+		unix.Close(slaveFd)
+		return nil, nil, fmt.Errorf("failed to set window size of pty (%s):\n%w", slavePath, err)
+	}
+
+	// In non-blocking mode, the master's reads are handled by the Go runtime's poller. So, closing the master stops
+	// any pending reads.
+	err = unix.SetNonblock(masterFd, true)
+	if err != nil {
+		unix.Close(slaveFd)
+		return nil, nil, fmt.Errorf("failed to set pty master to non-blocking mode:\n%w", err)
+	}
+
+	closeMaster = false
+	return os.NewFile(uintptr(masterFd), ptyMultiplexerPath), os.NewFile(uintptr(slaveFd), slavePath), nil
+}
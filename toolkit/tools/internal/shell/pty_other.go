@@ -0,0 +1,16 @@
+//go:build !linux
+
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func openPty(windowSize WindowSize) (master *os.File, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are only supported on Linux (current OS: %s)", runtime.GOOS)
+}
@@ -5,12 +5,15 @@ package shell
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/sirupsen/logrus"
 
@@ -27,8 +30,22 @@ var (
 	allowProcessCreation = true
 
 	currentEnv = os.Environ()
+
+	currentLimits ProcessLimits
 )
 
+// ProcessLimits are the limits applied to each process launched from this package.
+type ProcessLimits struct {
+	// CgroupParent is the cgroup v2 directory that a cgroup is created in for each process, with the Resources
+	// limits. If nil, then the processes are started in the cgroup of the current process, without any resource
+	// limits.
+	CgroupParent *cgroup.Parent
+	// Resources are the resource limits of each process, including all of its child processes.
+	Resources cgroup.Limits
+	// Timeout is the maximum time that each process may run for, after which it is killed. Zero for no timeout.
+	Timeout time.Duration
+}
+
 // SetEnvironment sets the default environment variables to be used for all processes launched from this package.
 func SetEnvironment(env []string) {
 	currentEnv = env
@@ -39,6 +56,16 @@ func CurrentEnvironment() []string {
 	return currentEnv
 }
 
+// SetProcessLimits sets the limits to be applied to all processes launched from this package.
+func SetProcessLimits(limits ProcessLimits) {
+	currentLimits = limits
+}
+
+// CurrentProcessLimits returns the limits that are being applied to all processes launched from this package.
+func CurrentProcessLimits() ProcessLimits {
+	return currentLimits
+}
+
 // PermanentlyStopAllChildProcesses will send the provided signal to all processes spawned by this package,
 // and all of those process's children.
 // Invoking this will also block future process creation, causing the Execute methods to return an error. Be aware that
@@ -86,14 +113,46 @@ func StopAllChildProcesses(signal unix.Signal) {
 
 // Execute runs the provided command.
 func Execute(program string, args ...string) (stdout, stderr string, err error) {
+	return ExecuteContext(context.Background(), program, args...)
+}
+
+// ExecuteContext runs the provided command, killing it if the context is done before the command exits.
+func ExecuteContext(ctx context.Context, program string, args ...string) (stdout, stderr string, err error) {
 	return NewExecBuilder(program, args...).
+		Context(ctx).
+		LogLevel(logrus.TraceLevel, logrus.DebugLevel).
+		ExecuteCaptureOuput()
+}
+
+// ExecuteWithPty runs the provided command attached to a new pseudo-terminal with the window size. The output is the
+// combined stdout and stderr of the command. Like on a terminal, the output's lines end with "\r\n".
+func ExecuteWithPty(windowSize WindowSize, program string, args ...string) (output string, err error) {
+	return ExecuteWithPtyContext(context.Background(), windowSize, program, args...)
+}
+
+// ExecuteWithPtyContext runs the provided command attached to a new pseudo-terminal with the window size, killing it
+// if the context is done before the command exits.
+func ExecuteWithPtyContext(ctx context.Context, windowSize WindowSize, program string, args ...string,
+) (output string, err error) {
+	output, _, err = NewExecBuilder(program, args...).
+		Context(ctx).
+		Pty(windowSize).
 		LogLevel(logrus.TraceLevel, logrus.DebugLevel).
 		ExecuteCaptureOuput()
+	return output, err
 }
 
 // ExecuteWithStdin - Run the command and use Stdin to pass input during execution
 func ExecuteWithStdin(input, program string, args ...string) (stdout, stderr string, err error) {
+	return ExecuteWithStdinContext(context.Background(), input, program, args...)
+}
+
+// ExecuteWithStdinContext is the same as ExecuteWithStdin, except that the command is killed if the context is done
+// before the command exits.
+func ExecuteWithStdinContext(ctx context.Context, input, program string, args ...string,
+) (stdout, stderr string, err error) {
 	return NewExecBuilder(program, args...).
+		Context(ctx).
 		LogLevel(logrus.TraceLevel, logrus.DebugLevel).
 		Stdin(input).
 		ExecuteCaptureOuput()
@@ -101,7 +160,14 @@ func ExecuteWithStdin(input, program string, args ...string) (stdout, stderr str
 
 // ExecuteLive runs a command in the shell and logs it in real-time
 func ExecuteLive(squashErrors bool, program string, args ...string) (err error) {
+	return ExecuteLiveContext(context.Background(), squashErrors, program, args...)
+}
+
+// ExecuteLiveContext is the same as ExecuteLive, except that the command is killed if the context is done before the
+// command exits.
+func ExecuteLiveContext(ctx context.Context, squashErrors bool, program string, args ...string) (err error) {
 	b := NewExecBuilder(program, args...).
+		Context(ctx).
 		LogLevel(logrus.DebugLevel, logrus.DebugLevel)
 
 	if !squashErrors {
@@ -114,7 +180,14 @@ func ExecuteLive(squashErrors bool, program string, args ...string) (err error)
 // ExecuteLiveWithErr runs a command in the shell and logs it in real-time.
 // In addition, if there is an error, the last x lines of stderr will be attached to the err object.
 func ExecuteLiveWithErr(stderrLines int, program string, args ...string) (err error) {
+	return ExecuteLiveWithErrContext(context.Background(), stderrLines, program, args...)
+}
+
+// ExecuteLiveWithErrContext is the same as ExecuteLiveWithErr, except that the command is killed if the context is
+// done before the command exits.
+func ExecuteLiveWithErrContext(ctx context.Context, stderrLines int, program string, args ...string) (err error) {
 	return NewExecBuilder(program, args...).
+		Context(ctx).
 		LogLevel(logrus.DebugLevel, logrus.DebugLevel).
 		ErrorStderrLines(stderrLines).
 		Execute()
@@ -172,7 +245,14 @@ func trackAndStartProcess(cmd *exec.Cmd) (err error) {
 	}
 
 	// Make the process, and any children it spawns, belong to a new process group
-	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &unix.SysProcAttr{}
+	}
+
+	// A new session already has a new process group and a session leader can't change its process group.
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
 
 	err = cmd.Start()
 	if err != nil {
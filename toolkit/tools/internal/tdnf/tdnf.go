@@ -51,17 +51,19 @@ var (
 	RepoIDRegex = regexp.MustCompile(`(?:\[)([^]]+)(?:\])`)
 	RepoIDIndex = 1
 
-	// Every valid line will be of the form: <package_name>.<architecture> <version>.<dist> <repo_id>
+	// Every valid line will be of the form: <package_name>.<architecture> [<epoch>:]<version>.<dist> <repo_id>
 	// For:
 	//
-	//		COOL_package2-extended++.aarch64	1.1b.8_X-22~rc1.azl3		fetcher-cloned-repo
+	//		COOL_package2-extended++.aarch64	2:1.1b.8_X-22~rc1.azl3		fetcher-cloned-repo
 	//
 	// We'd get:
 	//   - package_name:    COOL_package2-extended++
 	//   - architecture:    aarch64
+	//   - epoch:           2 (empty if the package has no epoch)
 	//   - version:         1.1b.8_X-22~rc1
 	//   - dist:            azl3
-	ListedPackageRegex = regexp.MustCompile(`^\s*([[:alnum:]_.+-]+)\.([[:alnum:]_+-]+)\s+([[:alnum:]._+~-]+)\.([[:alpha:]]+[[:digit:]]+)`)
+	//   - repo_id:         fetcher-cloned-repo (empty if the line has no repo ID)
+	ListedPackageRegex = regexp.MustCompile(`^\s*([[:alnum:]_.+-]+)\.([[:alnum:]_+-]+)\s+(?:([[:digit:]]+):)?([[:alnum:]._+~-]+)\.([[:alpha:]]+[[:digit:]]+)(?:\s+(\S+))?`)
 )
 
 const (
@@ -83,8 +85,10 @@ const (
 	ListedPackageMatchSubString = iota
 	ListedPackageName           = iota
 	ListedPackageArch           = iota
+	ListedPackageEpoch          = iota
 	ListedPackageVersion        = iota
 	ListedPackageDist           = iota
+	ListedPackageRepoID         = iota
 	ListedPackageMaxMatchLen    = iota
 )
 
@@ -157,6 +157,33 @@ func TestInstallPackageRegex_DoesNotMatchInvalidLine(t *testing.T) {
 	assert.False(t, InstallPackageRegex.MatchString(line))
 }
 
+func TestListedPackageRegex_MatchesPackageNoEpoch(t *testing.T) {
+	const line = "COOL_package2-extended++.aarch64	1.1b.8_X-22~rc1.azl3		fetcher-cloned-repo"
+
+	matches := ListedPackageRegex.FindStringSubmatch(line)
+
+	assert.Len(t, matches, ListedPackageMaxMatchLen)
+	assert.Equal(t, "COOL_package2-extended++", matches[ListedPackageName])
+	assert.Equal(t, "aarch64", matches[ListedPackageArch])
+	assert.Equal(t, "", matches[ListedPackageEpoch])
+	assert.Equal(t, "1.1b.8_X-22~rc1", matches[ListedPackageVersion])
+	assert.Equal(t, "azl3", matches[ListedPackageDist])
+	assert.Equal(t, "fetcher-cloned-repo", matches[ListedPackageRepoID])
+}
+
+func TestListedPackageRegex_MatchesPackageWithEpoch(t *testing.T) {
+	const line = "shadow-utils.x86_64	2:4.14.3-1.azl3		@System"
+
+	matches := ListedPackageRegex.FindStringSubmatch(line)
+
+	assert.Len(t, matches, ListedPackageMaxMatchLen)
+	assert.Equal(t, "shadow-utils", matches[ListedPackageName])
+	assert.Equal(t, "2", matches[ListedPackageEpoch])
+	assert.Equal(t, "4.14.3-1", matches[ListedPackageVersion])
+	assert.Equal(t, "azl3", matches[ListedPackageDist])
+	assert.Equal(t, "@System", matches[ListedPackageRepoID])
+}
+
 func TestPackageLookupNameMatchRegex_MatchesExternalRepo(t *testing.T) {
 	const line = "xz-devel-5.4.4-1.azl3.x86_64 : Header and development files for xz\nRepo : toolchain-repo"
 
@@ -0,0 +1,275 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package tdnf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+// Actions of the packages of a transaction.
+const (
+	ActionInstall   = "install"
+	ActionUpgrade   = "upgrade"
+	ActionReinstall = "reinstall"
+	ActionDowngrade = "downgrade"
+	ActionRemove    = "remove"
+	ActionObsolete  = "obsolete"
+)
+
+const (
+	// assumeNoError is the error tdnf exits with when a transaction is aborted by --assumeno.
+	assumeNoError = "Error(1032)"
+
+	unavailablePackagePrefix = "No package"
+	unavailablePackageSuffix = "available"
+)
+
+var (
+	// transactionSections maps the headers of the sections of a transaction summary to the action of their packages.
+	transactionSections = map[string]string{
+		"Installing:":   ActionInstall,
+		"Upgrading:":    ActionUpgrade,
+		"Reinstalling:": ActionReinstall,
+		"Downgrading:":  ActionDowngrade,
+		"Removing:":     ActionRemove,
+		"Obsoleting:":   ActionObsolete,
+	}
+
+	// Every package line of a transaction summary will be of the form:
+	//	<package_name> <architecture> <version> <repo_id> <install_size> [<download_size>]
+	// For:
+	//
+	//	bash	x86_64	5.2.15-3.azl3	azurelinux-official-base	7.47M	1.72M
+	//
+	// We'd get:
+	//   - package_name:  bash
+	//   - architecture:  x86_64
+	//   - version:       5.2.15-3.azl3
+	//   - repo_id:       azurelinux-official-base
+	//   - install_size:  7.47M
+	//   - download_size: 1.72M
+	transactionPackageRegex = regexp.MustCompile(`^\s*(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(\S+)(?:\s+(\S+))?\s*$`)
+
+	// Sizes are printed by tdnf with two decimals and a binary unit, e.g. "126.93k".
+	sizeRegex = regexp.MustCompile(`^([[:digit:]]+(?:\.[[:digit:]]+)?)([bkMGT]?)$`)
+
+	// Every tdnf error will be of the form: Error(<code>) : <message>
+	errorRegex = regexp.MustCompile(`Error\([[:digit:]]+\)\s*:.*`)
+
+	// The dist tag is the last component of a release, e.g. "azl3" in "5.2.15-3.azl3".
+	distTagRegex = regexp.MustCompile(`^(.+)\.([[:alpha:]]+[[:digit:]]+)$`)
+)
+
+const (
+	transactionPackageMatchSubString = iota
+	transactionPackageName           = iota
+	transactionPackageArch           = iota
+	transactionPackageVersion        = iota
+	transactionPackageRepo           = iota
+	transactionPackageInstallSize    = iota
+	transactionPackageDownloadSize   = iota
+	transactionPackageMaxMatchLen    = iota
+)
+
+var sizeUnits = map[string]float64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// TransactionPackage is a package of a transaction resolved by tdnf.
+// The sizes are in bytes, rounded by tdnf to three significant digits.
+type TransactionPackage struct {
+	Action       string `json:"Action"`
+	Name         string `json:"Name"`
+	Architecture string `json:"Architecture"`
+	Version      string `json:"Version"` // Version of the package, including the epoch and the dist tag
+	Repo         string `json:"Repo"`
+	InstallSize  int64  `json:"InstallSize"`
+	DownloadSize int64  `json:"DownloadSize"`
+}
+
+// Transaction is a transaction resolved by tdnf, without executing it.
+type Transaction struct {
+	Packages []*TransactionPackage `json:"Packages"`
+}
+
+// ResolveInstall resolves the transaction of installing packages, without downloading or installing anything.
+// The arguments are passed to "tdnf install" before the packages, e.g. to select the repositories or the install root.
+// If a package can't be resolved, the error lists all unavailable packages along with the error reported by tdnf.
+func ResolveInstall(args []string, packages ...string) (transaction *Transaction, err error) {
+	tdnfArgs := append([]string{"install", "--assumeno"}, args...)
+	tdnfArgs = append(tdnfArgs, packages...)
+
+	stdout, stderr, err := shell.Execute("tdnf", tdnfArgs...)
+
+	unavailable := unavailablePackages(stdout)
+	if len(unavailable) > 0 {
+		return nil, fmt.Errorf("failed to resolve (%s), unavailable packages:\n%s", strings.Join(packages, ", "),
+			strings.Join(unavailable, "\n"))
+	}
+
+	if err != nil {
+		if !strings.Contains(stderr, assumeNoError) {
+			return nil, fmt.Errorf("failed to resolve (%s):\n%s", strings.Join(packages, ", "), tdnfError(stderr, err))
+		}
+		err = nil
+	}
+
+	transaction, err = ParseTransaction(stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the transaction of (%s):\n%w", strings.Join(packages, ", "), err)
+	}
+
+	return
+}
+
+// ParseTransaction parses the transaction summary printed by tdnf before executing a transaction.
+// Packages listed more than once for the same action are only returned once.
+func ParseTransaction(stdout string) (transaction *Transaction, err error) {
+	transaction = &Transaction{}
+	foundPackages := make(map[string]bool)
+
+	action := ""
+	for _, line := range strings.Split(stdout, "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		if sectionAction, found := transactionSections[trimmedLine]; found {
+			action = sectionAction
+			continue
+		}
+
+		// Each section ends with an empty line.
+		if trimmedLine == "" {
+			action = ""
+			continue
+		}
+
+		if action == "" {
+			continue
+		}
+
+		matches := transactionPackageRegex.FindStringSubmatch(line)
+		if len(matches) != transactionPackageMaxMatchLen {
+			return nil, fmt.Errorf("unexpected line in the (%s) section of the transaction: %s", action, trimmedLine)
+		}
+
+		pkg := &TransactionPackage{
+			Action:       action,
+			Name:         matches[transactionPackageName],
+			Architecture: matches[transactionPackageArch],
+			Version:      matches[transactionPackageVersion],
+			Repo:         matches[transactionPackageRepo],
+		}
+
+		pkg.InstallSize, err = parseSize(matches[transactionPackageInstallSize])
+		if err != nil {
+			return nil, err
+		}
+
+		if matches[transactionPackageDownloadSize] != "" {
+			pkg.DownloadSize, err = parseSize(matches[transactionPackageDownloadSize])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		pkgID := strings.Join([]string{pkg.Action, pkg.Name, pkg.Architecture, pkg.Version}, " ")
+		if foundPackages[pkgID] {
+			continue
+		}
+		foundPackages[pkgID] = true
+
+		transaction.Packages = append(transaction.Packages, pkg)
+	}
+
+	return
+}
+
+// PackagesWithAction returns the packages of the transaction with the given action.
+func (t *Transaction) PackagesWithAction(action string) (packages []*TransactionPackage) {
+	for _, pkg := range t.Packages {
+		if pkg.Action == action {
+			packages = append(packages, pkg)
+		}
+	}
+
+	return
+}
+
+// DownloadSize returns the number of bytes downloaded by the transaction.
+func (t *Transaction) DownloadSize() (size int64) {
+	for _, pkg := range t.Packages {
+		size += pkg.DownloadSize
+	}
+
+	return
+}
+
+// InstallSize returns the number of bytes of the packages installed by the transaction.
+func (t *Transaction) InstallSize() (size int64) {
+	for _, pkg := range t.Packages {
+		if pkg.Action != ActionRemove && pkg.Action != ActionObsolete {
+			size += pkg.InstallSize
+		}
+	}
+
+	return
+}
+
+// VersionAndDistTag splits the version of the package into the version without the dist tag and the dist tag.
+// The dist tag is empty if the release doesn't end with one.
+func (p *TransactionPackage) VersionAndDistTag() (version, distTag string) {
+	matches := distTagRegex.FindStringSubmatch(p.Version)
+	if matches == nil {
+		return p.Version, ""
+	}
+
+	return matches[1], matches[2]
+}
+
+// parseSize converts a size printed by tdnf to bytes.
+func parseSize(size string) (bytes int64, err error) {
+	matches := sizeRegex.FindStringSubmatch(size)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid package size (%s)", size)
+	}
+
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid package size (%s):\n%w", size, err)
+	}
+
+	return int64(value * sizeUnits[matches[2]]), nil
+}
+
+// unavailablePackages returns the messages of tdnf about packages which it couldn't find. tdnf prints them to stdout
+// without failing when other packages could be resolved.
+func unavailablePackages(stdout string) (messages []string) {
+	for _, line := range strings.Split(stdout, "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmedLine, unavailablePackagePrefix) && strings.HasSuffix(trimmedLine, unavailablePackageSuffix) {
+			messages = append(messages, trimmedLine)
+		}
+	}
+
+	return
+}
+
+// tdnfError returns the errors reported by tdnf in stderr, falling back to the whole stderr and the exit error.
+func tdnfError(stderr string, exitErr error) error {
+	tdnfErrors := errorRegex.FindAllString(stderr, -1)
+	if len(tdnfErrors) == 0 {
+		return fmt.Errorf("%s\n%w", strings.TrimSpace(stderr), exitErr)
+	}
+
+	return fmt.Errorf("%s", strings.Join(tdnfErrors, "\n"))
+}
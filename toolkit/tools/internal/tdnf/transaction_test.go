@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package tdnf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTransactionOutput = `Loaded plugin: tdnfrepogpgcheck
+
+Upgrading:
+openssl                    x86_64       3.3.2-1.azl3          azurelinux-official-base   4.50M   1.25M
+
+Installing:
+bash                       x86_64       5.2.15-3.azl3         azurelinux-official-base   7.47M   1.72M
+ca-certificates-base       noarch       3.0.0-8.azl3          azurelinux-official-base 126.93k  57.05k
+bash                       x86_64       5.2.15-3.azl3         azurelinux-official-base   7.47M   1.72M
+
+Removing:
+openssl-libs               x86_64       3.3.0-1.azl3          @System                    6.00M
+
+Total installed size:   12.10M
+Total download size:   3.03M
+`
+
+func TestParseTransaction(t *testing.T) {
+	transaction, err := ParseTransaction(testTransactionOutput)
+	require.NoError(t, err)
+
+	assert.Equal(t, []*TransactionPackage{
+		{Action: ActionUpgrade, Name: "openssl", Architecture: "x86_64", Version: "3.3.2-1.azl3", Repo: "azurelinux-official-base", InstallSize: 4718592, DownloadSize: 1310720},
+		{Action: ActionInstall, Name: "bash", Architecture: "x86_64", Version: "5.2.15-3.azl3", Repo: "azurelinux-official-base", InstallSize: 7832862, DownloadSize: 1803550},
+		{Action: ActionInstall, Name: "ca-certificates-base", Architecture: "noarch", Version: "3.0.0-8.azl3", Repo: "azurelinux-official-base", InstallSize: 129976, DownloadSize: 58419},
+		{Action: ActionRemove, Name: "openssl-libs", Architecture: "x86_64", Version: "3.3.0-1.azl3", Repo: "@System", InstallSize: 6291456},
+	}, transaction.Packages)
+
+	assert.Len(t, transaction.PackagesWithAction(ActionInstall), 2)
+	assert.Equal(t, int64(1310720+1803550+58419), transaction.DownloadSize())
+	assert.Equal(t, int64(4718592+7832862+129976), transaction.InstallSize())
+}
+
+func TestParseTransactionNothingToDo(t *testing.T) {
+	transaction, err := ParseTransaction("Loaded plugin: tdnfrepogpgcheck\nNothing to do.\n")
+	require.NoError(t, err)
+	assert.Empty(t, transaction.Packages)
+}
+
+func TestParseTransactionInvalidSize(t *testing.T) {
+	_, err := ParseTransaction("Installing:\nbash x86_64 5.2.15-3.azl3 azurelinux-official-base 7.47X 1.72M\n")
+	assert.ErrorContains(t, err, "invalid package size (7.47X)")
+}
+
+func TestParseTransactionUnexpectedLine(t *testing.T) {
+	_, err := ParseTransaction("Installing:\nbash x86_64\n")
+	assert.ErrorContains(t, err, "unexpected line in the (install) section")
+}
+
+func TestVersionAndDistTag(t *testing.T) {
+	version, distTag := (&TransactionPackage{Version: "5:1.1b.8_X-22~rc1.azl3"}).VersionAndDistTag()
+	assert.Equal(t, "5:1.1b.8_X-22~rc1", version)
+	assert.Equal(t, "azl3", distTag)
+
+	version, distTag = (&TransactionPackage{Version: "1.0-1"}).VersionAndDistTag()
+	assert.Equal(t, "1.0-1", version)
+	assert.Empty(t, distTag)
+}
+
+func TestUnavailablePackages(t *testing.T) {
+	const stdout = "No package missing-one available\nNo package missing-two available\n"
+	assert.Equal(t, []string{"No package missing-one available", "No package missing-two available"}, unavailablePackages(stdout))
+}
+
+func TestTdnfError(t *testing.T) {
+	exitErr := fmt.Errorf("exit status 1")
+
+	err := tdnfError("Refreshing metadata\nError(1011) : No matching packages\n", exitErr)
+	assert.EqualError(t, err, "Error(1011) : No matching packages")
+
+	err = tdnfError("segmentation fault\n", exitErr)
+	assert.EqualError(t, err, "segmentation fault\nexit status 1")
+}
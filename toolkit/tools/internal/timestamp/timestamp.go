@@ -20,6 +20,7 @@ type TimeStamp struct {
 	ParentID        int64                 `json:"ParentID"`       // ID of the parent step
 	parentTimestamp *TimeStamp            // Pointer to parent step
 	subSteps        map[string]*TimeStamp // Map of step name -> timestamp of substep
+	children        []*TimeStamp          // All substeps, including the ones that share a name
 }
 
 const (
@@ -91,6 +92,7 @@ func getTimeStampFromPath(root *TimeStamp, path []string, nextIndex int) (ts *Ti
 // Adds a substep to the current timestamp node
 func (ts *TimeStamp) addSubStep(subStep *TimeStamp) {
 	ts.subSteps[subStep.Name] = subStep
+	ts.children = append(ts.children, subStep)
 	subStep.parentTimestamp = ts
 	subStep.ParentID = ts.ID
 }
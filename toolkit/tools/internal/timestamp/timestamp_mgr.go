@@ -135,8 +135,9 @@ func ensureManagerExists() error {
 }
 
 // Begins collecting timing data for a high level component 'toolName' into the file at 'outputFile'
+// If tracing is enabled (see EnableTracing), then the output file is optional.
 func BeginTiming(toolName, outputFile string) (*TimeStamp, error) {
-	if outputFile == "" {
+	if outputFile == "" && !isTracingEnabled() {
 		err := fmt.Errorf("timestamp output file is not specified, the feature will be turned off for %s", toolName)
 		logger.Log.Debug(err.Error())
 		return &TimeStamp{}, err
@@ -144,16 +145,20 @@ func BeginTiming(toolName, outputFile string) (*TimeStamp, error) {
 
 	initTimeStampManager()
 
-	outputFileDescriptor, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
-	if err != nil {
-		err = fmt.Errorf("unable to create file %s: %v", outputFile, err)
-		logger.Log.Warn(err.Error())
-		return &TimeStamp{}, err
+	if outputFile != "" {
+		outputFileDescriptor, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
+		if err != nil {
+			err = fmt.Errorf("unable to create file %s: %v", outputFile, err)
+			logger.Log.Warn(err.Error())
+			timestampMgr = nil
+			return &TimeStamp{}, err
+		}
+
+		timestampMgr.filePath = outputFile
+		timestampMgr.fileDescriptor = outputFileDescriptor
 	}
 
-	timestampMgr.filePath = outputFile
-	timestampMgr.fileDescriptor = outputFileDescriptor
-	_, err = StartEvent(toolName, nil)
+	_, err := StartEvent(toolName, nil)
 	if err != nil {
 		err = fmt.Errorf("unable to initialize root TimeStamp object for %s: %v", toolName, err)
 		logger.Log.Warn(err.Error())
@@ -202,7 +207,11 @@ func CompleteTiming() (err error) {
 
 	StopEvent(timestampMgr.root)
 	FlushAndCleanUpResources()
-	logger.Log.Debugf("Completed recording timestamp, results written to %s", timestampMgr.filePath)
+	if timestampMgr.fileDescriptor != nil {
+		logger.Log.Debugf("Completed recording timestamp, results written to %s", timestampMgr.filePath)
+	}
+
+	timestampMgr.completeTracing(time.Now())
 	timestampMgr = nil
 	return
 }
@@ -212,7 +221,9 @@ func FlushAndCleanUpResources() {
 	close(timestampMgr.EventQueue)
 	<-timestampMgr.eventProcessorFinished
 	timestampMgr.flush()
-	timestampMgr.fileDescriptor.Close()
+	if timestampMgr.fileDescriptor != nil {
+		timestampMgr.fileDescriptor.Close()
+	}
 }
 
 // Add an event that marks the start of a timestamped step, if parentTS is nil, use lastVisited as parentTS
@@ -326,6 +337,11 @@ func (mgr *TimeStampManager) processEventsInQueue() {
 
 // Append a timestamp record to file, and periodically flush to disk
 func (writeMgr *TimeStampWriteManager) writeToFile(record *TimeStampRecord) {
+	if writeMgr.fileDescriptor == nil {
+		// Only tracing is enabled.
+		return
+	}
+
 	outputBytes, err := json.Marshal(record)
 	if err != nil {
 		logger.Log.Warnf("Failed to marshal timestamp record: %v", err)
@@ -344,6 +360,10 @@ func (writeMgr *TimeStampWriteManager) writeToFile(record *TimeStampRecord) {
 
 // Flush write buffer to disk
 func (writeMgr *TimeStampWriteManager) flush() {
+	if writeMgr.fileDescriptor == nil {
+		return
+	}
+
 	for _, outputBytes := range writeMgr.writeBuffer {
 		_, err := writeMgr.fileDescriptor.WriteString(string(outputBytes) + "\n")
 		if err != nil {
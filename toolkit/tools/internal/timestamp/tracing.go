@@ -0,0 +1,341 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Exports the recorded steps as trace spans and summarizes where a run spent its time.
+
+package timestamp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	// The standard OpenTelemetry environment variables that configure the OTLP exporter.
+	otlpEndpointEnvVar       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	otlpTracesEndpointEnvVar = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	otlpHeadersEnvVar        = "OTEL_EXPORTER_OTLP_HEADERS"
+	otelServiceNameEnvVar    = "OTEL_SERVICE_NAME"
+
+	otlpTracesPath       = "/v1/traces"
+	otlpExportTimeout    = 10 * time.Second
+	otlpScopeName        = "github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+	otlpSpanKindInternal = 1
+
+	// Steps that took less than this fraction of the run are left out of the summary.
+	summaryMinFraction = 0.01
+	summaryIndent      = "  "
+)
+
+// TraceOptions configures what is done with the recorded steps when the timing completes.
+type TraceOptions struct {
+	// OtlpEndpoint is the base URL of an OTLP/HTTP collector (e.g. 'http://localhost:4318'), which the steps are
+	// exported to as spans. If empty, then the standard OTEL_EXPORTER_OTLP_* environment variables are used.
+	OtlpEndpoint string
+	// PrintSummary logs a timeline of the steps at the end of the run.
+	PrintSummary bool
+}
+
+var traceOptions TraceOptions
+
+// EnableTracing sets what is done with the recorded steps when the timing completes. If the steps are exported or
+// summarized, then the steps are recorded even if no timestamp output file is specified.
+//
+// Must be called before BeginTiming.
+func EnableTracing(options TraceOptions) {
+	traceOptions = options
+}
+
+// isTracingEnabled returns whether or not the recorded steps are used when the timing completes.
+func isTracingEnabled() bool {
+	return traceOptions.PrintSummary || getOtlpTracesEndpoint() != ""
+}
+
+// getOtlpTracesEndpoint returns the URL that spans are exported to, if any.
+func getOtlpTracesEndpoint() string {
+	if traceOptions.OtlpEndpoint != "" {
+		return strings.TrimSuffix(traceOptions.OtlpEndpoint, "/") + otlpTracesPath
+	}
+
+	if endpoint := os.Getenv(otlpTracesEndpointEnvVar); endpoint != "" {
+		return endpoint
+	}
+
+	if endpoint := os.Getenv(otlpEndpointEnvVar); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + otlpTracesPath
+	}
+
+	return ""
+}
+
+// completeTracing exports and summarizes the steps that were recorded.
+func (mgr *TimeStampManager) completeTracing(endTime time.Time) {
+	if mgr.root == nil {
+		return
+	}
+
+	if traceOptions.PrintSummary {
+		for _, line := range formatTimelineSummary(mgr.root, endTime) {
+			logger.Log.Info(line)
+		}
+	}
+
+	endpoint := getOtlpTracesEndpoint()
+	if endpoint != "" {
+		err := exportOtlpSpans(endpoint, mgr.root, endTime)
+		if err != nil {
+			logger.Log.Warnf("Failed to export trace spans to (%s): %v", endpoint, err)
+		} else {
+			logger.Log.Debugf("Exported trace spans to (%s)", endpoint)
+		}
+	}
+}
+
+// formatTimelineSummary returns the lines of a summary of where the time was spent, with the nested steps indented
+// under their parents.
+func formatTimelineSummary(root *TimeStamp, endTime time.Time) []string {
+	total := stepDuration(root, endTime)
+	lines := []string{fmt.Sprintf("Timeline of %s (%s):", root.Name, formatDuration(total))}
+
+	var addSteps func(parent *TimeStamp, depth int)
+	addSteps = func(parent *TimeStamp, depth int) {
+		for _, step := range sortedSubSteps(parent) {
+			duration := stepDuration(step, endTime)
+			if total > 0 && float64(duration) < float64(total)*summaryMinFraction {
+				continue
+			}
+
+			percent := 0.0
+			if total > 0 {
+				percent = float64(duration) / float64(total) * 100
+			}
+
+			offset := time.Duration(0)
+			if step.StartTime != nil && root.StartTime != nil {
+				offset = step.StartTime.Sub(*root.StartTime)
+			}
+
+			lines = append(lines, fmt.Sprintf("%s+%-8s %8s %5.1f%%  %s", strings.Repeat(summaryIndent, depth),
+				formatDuration(offset), formatDuration(duration), percent, step.Name))
+			addSteps(step, depth+1)
+		}
+	}
+	addSteps(root, 0)
+
+	return lines
+}
+
+// sortedSubSteps returns a step's sub-steps, sorted by their start time.
+func sortedSubSteps(step *TimeStamp) []*TimeStamp {
+	subSteps := slices.Clone(step.children)
+
+	sort.Slice(subSteps, func(i, j int) bool {
+		if subSteps[i].StartTime == nil || subSteps[j].StartTime == nil {
+			return subSteps[i].ID < subSteps[j].ID
+		}
+		return subSteps[i].StartTime.Before(*subSteps[j].StartTime)
+	})
+	return subSteps
+}
+
+// stepDuration returns how long a step took. A step that wasn't stopped is considered to end at endTime.
+func stepDuration(step *TimeStamp, endTime time.Time) time.Duration {
+	if step.StartTime == nil {
+		return 0
+	}
+
+	if step.EndTime == nil {
+		return endTime.Sub(*step.StartTime)
+	}
+
+	return step.ElapsedTime()
+}
+
+func formatDuration(duration time.Duration) string {
+	return duration.Round(100 * time.Millisecond).String()
+}
+
+// The OTLP/HTTP JSON encoding of a trace export request.
+// See: https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// exportOtlpSpans sends the steps to an OTLP/HTTP collector as the spans of a single trace.
+func exportOtlpSpans(endpoint string, root *TimeStamp, endTime time.Time) error {
+	request, err := buildOtlpTraceRequest(root, endTime)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to serialize spans:\n%w", err)
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	httpRequest.Header.Set("Content-Type", "application/json")
+	for key, value := range parseOtlpHeaders(os.Getenv(otlpHeadersEnvVar)) {
+		httpRequest.Header.Set(key, value)
+	}
+
+	client := http.Client{Timeout: otlpExportTimeout}
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with status (%s)", response.Status)
+	}
+
+	return nil
+}
+
+func buildOtlpTraceRequest(root *TimeStamp, endTime time.Time) (*otlpTraceRequest, error) {
+	traceId, err := randomHexId(16)
+	if err != nil {
+		return nil, err
+	}
+
+	var spans []otlpSpan
+	var addSpans func(step *TimeStamp, parentSpanId string) error
+	addSpans = func(step *TimeStamp, parentSpanId string) error {
+		if step.StartTime == nil {
+			return nil
+		}
+
+		spanId, err := randomHexId(8)
+		if err != nil {
+			return err
+		}
+
+		stepEndTime := endTime
+		if step.EndTime != nil {
+			stepEndTime = *step.EndTime
+		}
+
+		spans = append(spans, otlpSpan{
+			TraceId:           traceId,
+			SpanId:            spanId,
+			ParentSpanId:      parentSpanId,
+			Name:              step.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(step.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(stepEndTime.UnixNano(), 10),
+			Attributes: []otlpAttribute{
+				{Key: "step.path", Value: otlpAttributeValue{StringValue: step.DisplayName()}},
+			},
+		})
+
+		for _, subStep := range sortedSubSteps(step) {
+			err = addSpans(subStep, spanId)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = addSpans(root, "")
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := os.Getenv(otelServiceNameEnvVar)
+	if serviceName == "" {
+		serviceName = root.Name
+	}
+
+	return &otlpTraceRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{
+					{Key: "service.name", Value: otlpAttributeValue{StringValue: serviceName}},
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: otlpScopeName},
+				Spans: spans,
+			}},
+		}},
+	}, nil
+}
+
+// parseOtlpHeaders parses the value of OTEL_EXPORTER_OTLP_HEADERS (e.g. 'api-key=secret,tenant=build').
+func parseOtlpHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		key, headerValue, found := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			continue
+		}
+		headers[key] = strings.TrimSpace(headerValue)
+	}
+	return headers
+}
+
+func randomHexId(length int) (string, error) {
+	id := make([]byte, length)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate trace ID:\n%w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,175 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package timestamp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTracingTestTree constructs the following timestamp tree, with the start offsets and durations in seconds:
+//
+//	root (0, 100)
+//	  -> setup (0, 10)
+//	    -> mount (1, 5)
+//	    -> sync (6, 0.5)
+//	  -> install (10, 80)
+//	  -> install (90, 10)
+func newTracingTestTree() *TimeStamp {
+	newStep := func(name string, startSeconds float64, durationSeconds float64) *TimeStamp {
+		ts, _ := newTimeStamp(name, nil)
+		startTime := defaultStartTime.Add(time.Duration(startSeconds * float64(time.Second)))
+		ts.StartTime = &startTime
+		ts.complete(startTime.Add(time.Duration(durationSeconds * float64(time.Second))))
+		return ts
+	}
+
+	root := newStep("root", 0, 100)
+	setup := newStep("setup", 0, 10)
+	root.addSubStep(newStep("install", 90, 10))
+	root.addSubStep(setup)
+	root.addSubStep(newStep("install", 10, 80))
+	setup.addSubStep(newStep("sync", 6, 0.5))
+	setup.addSubStep(newStep("mount", 1, 5))
+	return root
+}
+
+func TestFormatTimelineSummary(t *testing.T) {
+	lines := formatTimelineSummary(newTracingTestTree(), defaultEndTime)
+	assert.Equal(t, []string{
+		"Timeline of root (1m40s):",
+		"+0s            10s  10.0%  setup",
+		"  +1s             5s   5.0%  mount",
+		"+10s         1m20s  80.0%  install",
+		"+1m30s         10s  10.0%  install",
+	}, lines)
+}
+
+func TestFormatTimelineSummaryUnfinishedStep(t *testing.T) {
+	root, _ := newTimeStamp("root", nil)
+	root.StartTime = &defaultStartTime
+
+	lines := formatTimelineSummary(root, defaultStartTime.Add(time.Minute))
+	assert.Equal(t, []string{"Timeline of root (1m0s):"}, lines)
+}
+
+func TestBuildOtlpTraceRequest(t *testing.T) {
+	t.Setenv(otelServiceNameEnvVar, "")
+
+	request, err := buildOtlpTraceRequest(newTracingTestTree(), defaultEndTime)
+	require.NoError(t, err)
+	require.Len(t, request.ResourceSpans, 1)
+
+	resourceSpans := request.ResourceSpans[0]
+	assert.Equal(t, []otlpAttribute{{Key: "service.name", Value: otlpAttributeValue{StringValue: "root"}}},
+		resourceSpans.Resource.Attributes)
+	require.Len(t, resourceSpans.ScopeSpans, 1)
+
+	spans := resourceSpans.ScopeSpans[0].Spans
+	require.Len(t, spans, 6)
+
+	names := []string(nil)
+	for _, span := range spans {
+		names = append(names, span.Name)
+		assert.Equal(t, spans[0].TraceId, span.TraceId)
+		assert.Len(t, span.TraceId, 32)
+		assert.Len(t, span.SpanId, 16)
+		assert.Equal(t, otlpSpanKindInternal, span.Kind)
+	}
+	assert.Equal(t, []string{"root", "setup", "mount", "sync", "install", "install"}, names)
+
+	assert.Empty(t, spans[0].ParentSpanId)
+	assert.Equal(t, spans[0].SpanId, spans[1].ParentSpanId)
+	assert.Equal(t, spans[1].SpanId, spans[2].ParentSpanId)
+	assert.Equal(t, spans[1].SpanId, spans[3].ParentSpanId)
+	assert.Equal(t, spans[0].SpanId, spans[5].ParentSpanId)
+	assert.Equal(t, "root/setup/mount", spans[2].Attributes[0].Value.StringValue)
+
+	assert.Equal(t, strconv.FormatInt(defaultStartTime.Add(10*time.Second).UnixNano(), 10),
+		spans[4].StartTimeUnixNano)
+	assert.Equal(t, strconv.FormatInt(defaultStartTime.Add(90*time.Second).UnixNano(), 10),
+		spans[4].EndTimeUnixNano)
+}
+
+func TestParseOtlpHeaders(t *testing.T) {
+	assert.Equal(t, map[string]string{"api-key": "secret", "tenant": "build"},
+		parseOtlpHeaders("api-key=secret, tenant = build,invalid,=value"))
+	assert.Empty(t, parseOtlpHeaders(""))
+}
+
+func TestGetOtlpTracesEndpoint(t *testing.T) {
+	t.Cleanup(func() { EnableTracing(TraceOptions{}) })
+	t.Setenv(otlpEndpointEnvVar, "")
+	t.Setenv(otlpTracesEndpointEnvVar, "")
+
+	EnableTracing(TraceOptions{})
+	assert.Equal(t, "", getOtlpTracesEndpoint())
+	assert.False(t, isTracingEnabled())
+
+	t.Setenv(otlpEndpointEnvVar, "http://collector:4318/")
+	assert.Equal(t, "http://collector:4318/v1/traces", getOtlpTracesEndpoint())
+
+	t.Setenv(otlpTracesEndpointEnvVar, "http://collector:4318/custom")
+	assert.Equal(t, "http://collector:4318/custom", getOtlpTracesEndpoint())
+
+	EnableTracing(TraceOptions{OtlpEndpoint: "http://localhost:4318"})
+	assert.Equal(t, "http://localhost:4318/v1/traces", getOtlpTracesEndpoint())
+	assert.True(t, isTracingEnabled())
+}
+
+func TestTracingExportWithoutTimestampFile(t *testing.T) {
+	t.Setenv(otlpHeadersEnvVar, "api-key=secret")
+	t.Setenv(otelServiceNameEnvVar, "")
+
+	requests := make(chan *otlpTraceRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, otlpTracesPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("api-key"))
+
+		request := &otlpTraceRequest{}
+		err := json.NewDecoder(r.Body).Decode(request)
+		assert.NoError(t, err)
+		requests <- request
+	}))
+	defer server.Close()
+
+	EnableTracing(TraceOptions{OtlpEndpoint: server.URL, PrintSummary: true})
+	t.Cleanup(func() { EnableTracing(TraceOptions{}) })
+
+	_, err := BeginTiming("test", "")
+	require.NoError(t, err)
+
+	ts, err := StartEvent("chroot setup", nil)
+	require.NoError(t, err)
+	StopEvent(ts)
+
+	err = CompleteTiming()
+	require.NoError(t, err)
+
+	require.Len(t, requests, 1)
+	request := <-requests
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "test", spans[0].Name)
+	assert.Equal(t, "chroot setup", spans[1].Name)
+	assert.Equal(t, spans[0].SpanId, spans[1].ParentSpanId)
+}
+
+func TestBeginTimingWithoutTracingOrFile(t *testing.T) {
+	EnableTracing(TraceOptions{})
+	t.Setenv(otlpEndpointEnvVar, "")
+	t.Setenv(otlpTracesEndpointEnvVar, "")
+
+	_, err := BeginTiming("test", "")
+	assert.Error(t, err)
+	assert.Nil(t, timestampMgr)
+}
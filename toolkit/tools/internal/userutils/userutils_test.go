@@ -1,3 +1,5 @@
+//go:build linux
+
 // Copyright Microsoft Corporation.
 // Licensed under the MIT License.
 
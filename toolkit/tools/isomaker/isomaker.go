@@ -5,9 +5,11 @@ package main
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/isomakerlib"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -28,6 +30,9 @@ var (
 
 	imageTag = app.Flag("image-tag", "Tag (text) appended to the image name. Empty by default.").String()
 
+	outputFormat = app.Flag("output-format", "Artifacts to generate: an ISO image, PXE artifacts (kernel, initrd, media squashfs and netboot configurations) or both.").Default(isomakerlib.OutputFormatIso).Enum(isomakerlib.OutputFormats()...)
+	pxeBaseUrl   = app.Flag("pxe-base-url", "Optional: HTTP URL the PXE artifacts will be served from. If empty, the netboot configurations use a placeholder URL.").String()
+
 	logFlags = exe.SetupLogFlags(app)
 )
 
@@ -52,8 +57,60 @@ func main() {
 	if err != nil {
 		logger.PanicOnError(err)
 	}
+
+	err = isoMaker.SetOutputFormat(*outputFormat, *pxeBaseUrl)
+	if err != nil {
+		logger.PanicOnError(err)
+	}
+
 	err = isoMaker.Make()
 	if err != nil {
 		logger.PanicOnError(err)
 	}
+
+	if *outputFormat != isomakerlib.OutputFormatPxe {
+		err = writeArtifactMetadata(isoMaker.IsoImageFilePath(), isomakerlib.OutputFormatIso)
+		if err != nil {
+			logger.PanicOnError(err)
+		}
+	}
+
+	if *outputFormat != isomakerlib.OutputFormatIso {
+		for _, artifactFilePath := range isoMaker.PxeArtifactFilePaths() {
+			err = writeArtifactMetadata(artifactFilePath, isomakerlib.OutputFormatPxe)
+			if err != nil {
+				logger.PanicOnError(err)
+			}
+		}
+	}
+}
+
+// writeArtifactMetadata writes the metadata sidecar of the ISO image or of one of the PXE artifacts.
+func writeArtifactMetadata(artifactFilePath, format string) (err error) {
+	metadata, err := artifactmetadata.New("isomaker", exe.ToolkitVersion, artifactFilePath, format)
+	if err != nil {
+		return
+	}
+
+	err = metadata.AddInput(artifactmetadata.InputRoleConfig, *configFilePath)
+	if err != nil {
+		return
+	}
+
+	err = metadata.AddInput(artifactmetadata.InputRoleInitrd, *initrdPath)
+	if err != nil {
+		return
+	}
+
+	err = metadata.AddInput(artifactmetadata.InputRoleRpmSource, *isoRepoDirPath)
+	if err != nil {
+		return
+	}
+
+	metadata.SetParameter("releaseVersion", *releaseVersion)
+	metadata.SetParameter("unattendedInstall", strconv.FormatBool(*unattendedInstall))
+	metadata.SetParameter("imageTag", *imageTag)
+	metadata.SetParameter("repoSnapshotTime", *repoSnapshotTime)
+
+	return artifactmetadata.WriteSidecar(metadata, artifactFilePath)
 }
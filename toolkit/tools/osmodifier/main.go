@@ -23,6 +23,18 @@ var (
 	profFlags     = exe.SetupProfileFlags(app)
 	timestampFile = app.Flag("timestamp-file", "File that stores timestamps for this program.").String()
 	updateGrub    = app.Flag("update-grub", "Update default GRUB.").Bool()
+
+	snapshotType = app.Flag("snapshot", "Take a snapshot of the OS before modifying it, so that the "+
+		"modification can be rolled back. The OS is rolled back automatically if the modification or the "+
+		"validation script fails.").Enum(snapshotTypeNames()...)
+	lvmSnapshotSize = app.Flag("lvm-snapshot-size", "The size of an 'lvm' snapshot, as accepted by "+
+		"'lvcreate --extents'.").Default(osmodifierlib.DefaultLvmSnapshotSize).String()
+	validationScript = app.Flag("validation-script", "A script that is run after the OS is modified. If it fails, "+
+		"then the OS is rolled back. Requires --snapshot.").ExistingFile()
+	rollback = app.Flag("rollback", "Roll back the OS to the snapshot that was taken before the last "+
+		"modification.").Bool()
+	stateDir = app.Flag("snapshot-state-dir", "The directory the snapshot records are kept in. Must not be on the "+
+		"root filesystem for 'lvm' and 'btrfs' snapshots.").Default(osmodifierlib.DefaultStateDir).String()
 )
 
 func main() {
@@ -41,6 +53,33 @@ func main() {
 	timestamp.BeginTiming("osmodifier", *timestampFile)
 	defer timestamp.CompleteTiming()
 
+	if *rollback {
+		rebootRequired, err := osmodifierlib.RollbackOS(*stateDir)
+		if err != nil {
+			log.Fatalf("rollback failed: %v", err)
+		}
+
+		if rebootRequired {
+			logger.Log.Warnf("The OS must be rebooted to complete the rollback")
+		}
+		return
+	}
+
+	if *validationScript != "" && *snapshotType == "" {
+		log.Fatalf("--validation-script requires --snapshot")
+	}
+
+	// With a snapshot, the default grub config is updated within the snapshot too, so that rolling back undoes it.
+	if *snapshotType != "" {
+		if *updateGrub || len(*configFile) > 0 {
+			err = modifyImageWithSnapshot()
+			if err != nil {
+				log.Fatalf("OS modification failed: %v", err)
+			}
+		}
+		return
+	}
+
 	// Check if the updateGrub flag is set
 	if *updateGrub {
 		err := osmodifierlib.ModifyDefaultGrub()
@@ -57,6 +96,17 @@ func main() {
 	}
 }
 
+func modifyImageWithSnapshot() error {
+	options := osmodifierlib.SnapshotOptions{
+		Type:             osmodifierlib.SnapshotType(*snapshotType),
+		StateDir:         *stateDir,
+		LvmSize:          *lvmSnapshotSize,
+		ValidationScript: *validationScript,
+	}
+
+	return osmodifierlib.ModifyOSWithConfigFileAndSnapshot(*configFile, *updateGrub, options)
+}
+
 func modifyImage() error {
 	err := osmodifierlib.ModifyOSWithConfigFile(*configFile)
 	if err != nil {
@@ -65,3 +115,11 @@ func modifyImage() error {
 
 	return nil
 }
+
+func snapshotTypeNames() []string {
+	names := []string(nil)
+	for _, snapshotType := range osmodifierlib.SnapshotTypes {
+		names = append(names, string(snapshotType))
+	}
+	return names
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Tool to garbage collect the shared package cache and to release the references of a build.
+
+package main
+
+import (
+	"os"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagecache"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const bytesPerMiB = 1024 * 1024
+
+var (
+	app = kingpin.New("packagecache", "A tool to manage the package cache shared by the package and image builds.")
+
+	cacheDir = app.Flag("cache-dir", "Directory of the shared package cache.").Required().String()
+	logFlags = exe.SetupLogFlags(app)
+
+	gcCommand = app.Command("gc", "Remove the packages no build references, bounded by age and size.")
+	maxAge    = gcCommand.Flag("max-age", "Remove the unreferenced packages unused for longer, and release the references of builds which haven't used the cache for longer (e.g. 720h). 0 disables the age limit.").Default("0").Duration()
+	maxSizeMB = gcCommand.Flag("max-size-mb", "Remove the least recently used unreferenced packages until the cache is at most this many MiB. 0 disables the size limit.").Default("0").Int64()
+
+	releaseCommand = app.Command("release", "Release the references of a build, so that its packages may be garbage collected.")
+	buildID        = releaseCommand.Flag("build-id", "ID of the build to release.").Required().String()
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	cache, err := packagecache.Open(*cacheDir)
+	if err != nil {
+		logger.Log.Fatalf("Failed to open the package cache:\n%v", err)
+	}
+
+	switch command {
+	case gcCommand.FullCommand():
+		options := packagecache.GCOptions{
+			MaxAge:  *maxAge,
+			MaxSize: *maxSizeMB * bytesPerMiB,
+		}
+
+		report, err := cache.GC(options)
+		if err != nil {
+			logger.Log.Fatalf("Failed to garbage collect the package cache:\n%v", err)
+		}
+
+		logger.Log.Infof("Removed %d packages (%d MiB) from the package cache.", report.RemovedObjects, report.RemovedBytes/bytesPerMiB)
+		logger.Log.Infof("Package cache holds %d packages (%d MiB), %d of them referenced by builds.", report.RemainingObjects, report.RemainingBytes/bytesPerMiB, report.ReferencedObjects)
+		if len(report.ExpiredBuilds) > 0 {
+			logger.Log.Infof("Released the references of inactive builds: %v", report.ExpiredBuilds)
+		}
+
+	case releaseCommand.FullCommand():
+		err = cache.ReleaseReferences(*buildID)
+		if err != nil {
+			logger.Log.Fatalf("Failed to release the references of build (%s):\n%v", *buildID, err)
+		}
+	}
+}
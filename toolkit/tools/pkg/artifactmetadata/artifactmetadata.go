@@ -0,0 +1,247 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package artifactmetadata defines the metadata sidecar file that the toolkit's image tools (imager, roast, isomaker
+// and the image customizer) write next to each artifact they produce. The sidecar records the artifact's digest and
+// format, the inputs it was created from, and the parameters it was created with, so that downstream tooling can
+// treat the artifacts of every tool the same way.
+//
+// This package is deliberately free of Linux-only dependencies, so that the metadata can be examined on any platform.
+package artifactmetadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+)
+
+const (
+	// Version is the version of the metadata's format.
+	Version = 1
+
+	// FileSuffix is appended to an artifact's file name to get the file name of its metadata sidecar
+	// (e.g. "core.vhdx" -> "core.vhdx.metadata.json").
+	FileSuffix = ".metadata.json"
+)
+
+// The roles of an artifact's inputs.
+const (
+	// InputRoleConfig is the config file that the artifact was built from.
+	InputRoleConfig = "config"
+	// InputRoleImage is an image that the artifact was created from (e.g. a base image or a raw disk image).
+	InputRoleImage = "image"
+	// InputRoleInitrd is the initrd that was embedded in the artifact.
+	InputRoleInitrd = "initrd"
+	// InputRolePackageList is the list of the packages that were installed into the artifact.
+	InputRolePackageList = "packageList"
+	// InputRoleRpmSource is a source of the RPMs that were installed into the artifact.
+	InputRoleRpmSource = "rpmSource"
+)
+
+// Metadata describes an artifact and how it was created.
+type Metadata struct {
+	Version int `json:"version"`
+	// Tool is the name of the tool that created the artifact (e.g. "imagecustomizer").
+	Tool        string    `json:"tool"`
+	ToolVersion string    `json:"toolVersion"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Artifact is the artifact itself. Its path is the artifact's file name, since the sidecar is next to it.
+	Artifact File `json:"artifact"`
+	// Format is the artifact's format (e.g. "vhdx", "iso" or "raw").
+	Format string  `json:"format"`
+	Inputs []Input `json:"inputs"`
+	// Parameters are the tool-specific settings that the artifact was created with.
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// File identifies a file by its path and digest.
+type File struct {
+	Path string `json:"path"`
+	// Size and Sha256 are only set for regular files (e.g. not for directories).
+	Size   int64  `json:"size,omitempty"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// Input is a file or directory that an artifact was created from.
+type Input struct {
+	// Role is what the input was used for (e.g. "config" or "image").
+	Role string `json:"role"`
+	File
+}
+
+// New creates the metadata of an artifact file, including its digest.
+func New(tool string, toolVersion string, artifactPath string, format string) (*Metadata, error) {
+	artifact, err := describeFile(artifactPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if artifact.Sha256 == "" {
+		return nil, fmt.Errorf("artifact (%s) isn't a regular file", artifactPath)
+	}
+	artifact.Path = filepath.Base(artifactPath)
+
+	metadata := &Metadata{
+		Version:     Version,
+		Tool:        tool,
+		ToolVersion: toolVersion,
+		CreatedAt:   time.Now().UTC(),
+		Artifact:    artifact,
+		Format:      format,
+		Inputs:      []Input{},
+	}
+	return metadata, nil
+}
+
+// AddInput records a file or directory that the artifact was created from. Empty paths are ignored, so that optional
+// inputs can be added unconditionally.
+func (m *Metadata) AddInput(role string, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	file, err := describeFile(path)
+	if err != nil {
+		return err
+	}
+
+	m.Inputs = append(m.Inputs, Input{Role: role, File: file})
+	return nil
+}
+
+// SetParameter records a setting that the artifact was created with. Empty values are ignored.
+func (m *Metadata) SetParameter(key string, value string) {
+	if value == "" {
+		return
+	}
+
+	if m.Parameters == nil {
+		m.Parameters = make(map[string]string)
+	}
+	m.Parameters[key] = value
+}
+
+// Verify checks that an artifact file matches its metadata.
+func (m *Metadata) Verify(artifactPath string) error {
+	artifact, err := describeFile(artifactPath)
+	if err != nil {
+		return err
+	}
+
+	if artifact.Size != m.Artifact.Size || artifact.Sha256 != m.Artifact.Sha256 {
+		return fmt.Errorf("artifact (%s) doesn't match its metadata:\nexpected sha256 (%s), got (%s)", artifactPath,
+			m.Artifact.Sha256, artifact.Sha256)
+	}
+
+	return nil
+}
+
+// SidecarPath returns the path of an artifact's metadata sidecar file.
+func SidecarPath(artifactPath string) string {
+	return artifactPath + FileSuffix
+}
+
+// WriteSidecar writes the metadata next to the artifact.
+func WriteSidecar(metadata *Metadata, artifactPath string) error {
+	return Write(metadata, SidecarPath(artifactPath))
+}
+
+// ReadSidecar reads the metadata of an artifact from its sidecar file.
+func ReadSidecar(artifactPath string) (*Metadata, error) {
+	return Read(SidecarPath(artifactPath))
+}
+
+// Read reads a metadata file.
+func Read(metadataFilePath string) (*Metadata, error) {
+	var metadata Metadata
+	err := jsonutils.ReadJSONFile(metadataFilePath, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact metadata (%s):\n%w", metadataFilePath, err)
+	}
+
+	err = metadata.IsValid()
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact metadata (%s):\n%w", metadataFilePath, err)
+	}
+
+	return &metadata, nil
+}
+
+// Write writes a metadata file.
+func Write(metadata *Metadata, metadataFilePath string) error {
+	err := metadata.IsValid()
+	if err != nil {
+		return fmt.Errorf("invalid artifact metadata (%s):\n%w", metadataFilePath, err)
+	}
+
+	err = jsonutils.WriteJSONFile(metadataFilePath, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to write artifact metadata (%s):\n%w", metadataFilePath, err)
+	}
+
+	return nil
+}
+
+// IsValid returns an error if the metadata is of an unsupported version or lacks required fields.
+func (m *Metadata) IsValid() error {
+	if m.Version < 1 || m.Version > Version {
+		return fmt.Errorf("unsupported version (%d), supported versions: 1-%d", m.Version, Version)
+	}
+
+	if m.Tool == "" {
+		return fmt.Errorf("tool must be specified")
+	}
+
+	if m.Artifact.Path == "" || m.Artifact.Sha256 == "" {
+		return fmt.Errorf("artifact's path and sha256 must be specified")
+	}
+
+	for i, input := range m.Inputs {
+		if input.Role == "" || input.Path == "" {
+			return fmt.Errorf("input (%d) must have a role and a path", i)
+		}
+	}
+
+	return nil
+}
+
+// describeFile returns a file's path, size and digest. Directories only have a path.
+func describeFile(path string) (File, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to stat (%s):\n%w", path, err)
+	}
+
+	if !stat.Mode().IsRegular() {
+		return File{Path: path}, nil
+	}
+
+	digest, err := fileSha256(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{Path: path, Size: stat.Size(), Sha256: digest}, nil
+}
+
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open (%s):\n%w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash (%s):\n%w", path, err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package artifactmetadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func TestWriteAndReadSidecar(t *testing.T) {
+	testDir := t.TempDir()
+	artifactFile := filepath.Join(testDir, "core.vhdx")
+	configFile := filepath.Join(testDir, "core.json")
+	rpmsDir := filepath.Join(testDir, "rpms")
+
+	require.NoError(t, os.WriteFile(artifactFile, []byte("image"), 0o644))
+	require.NoError(t, os.WriteFile(configFile, []byte("{}"), 0o644))
+	require.NoError(t, os.Mkdir(rpmsDir, 0o755))
+
+	metadata, err := New("roast", "3.0.1", artifactFile, "vhdx")
+	require.NoError(t, err)
+	assert.Equal(t, File{
+		Path:   "core.vhdx",
+		Size:   5,
+		Sha256: "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d",
+	}, metadata.Artifact)
+
+	require.NoError(t, metadata.AddInput(InputRoleConfig, configFile))
+	require.NoError(t, metadata.AddInput(InputRoleRpmSource, rpmsDir))
+	require.NoError(t, metadata.AddInput(InputRoleInitrd, ""))
+	metadata.SetParameter("releaseVersion", "3.0.1")
+	metadata.SetParameter("imageTag", "")
+
+	err = WriteSidecar(metadata, artifactFile)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(testDir, "core.vhdx.metadata.json"))
+
+	readMetadata, err := ReadSidecar(artifactFile)
+	require.NoError(t, err)
+	assert.Equal(t, metadata.CreatedAt.Unix(), readMetadata.CreatedAt.Unix())
+	readMetadata.CreatedAt = metadata.CreatedAt
+	assert.Equal(t, metadata, readMetadata)
+
+	assert.Equal(t, []Input{
+		{Role: InputRoleConfig, File: File{Path: configFile, Size: 2,
+			Sha256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}},
+		{Role: InputRoleRpmSource, File: File{Path: rpmsDir}},
+	}, readMetadata.Inputs)
+	assert.Equal(t, map[string]string{"releaseVersion": "3.0.1"}, readMetadata.Parameters)
+
+	assert.NoError(t, readMetadata.Verify(artifactFile))
+
+	require.NoError(t, os.WriteFile(artifactFile, []byte("changed"), 0o644))
+	assert.ErrorContains(t, readMetadata.Verify(artifactFile), "doesn't match its metadata")
+}
+
+func TestNewArtifactIsDirectory(t *testing.T) {
+	_, err := New("imager", "3.0.1", t.TempDir(), "rootfs")
+	assert.ErrorContains(t, err, "isn't a regular file")
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	metadataFile := filepath.Join(t.TempDir(), "core.vhdx.metadata.json")
+	err := os.WriteFile(metadataFile,
+		[]byte(`{"version": 2, "tool": "roast", "artifact": {"path": "core.vhdx", "sha256": "00"}}`), 0o644)
+	require.NoError(t, err)
+
+	_, err = Read(metadataFile)
+	assert.ErrorContains(t, err, "unsupported version (2)")
+}
+
+func TestIsValid(t *testing.T) {
+	metadata := Metadata{
+		Version:  Version,
+		Tool:     "isomaker",
+		Artifact: File{Path: "core.iso", Sha256: "00"},
+		Inputs:   []Input{{Role: InputRoleConfig, File: File{Path: "core.json"}}},
+	}
+	assert.NoError(t, metadata.IsValid())
+
+	metadata.Inputs = append(metadata.Inputs, Input{File: File{Path: "initrd.img"}})
+	assert.ErrorContains(t, metadata.IsValid(), "input (1) must have a role and a path")
+
+	metadata.Artifact.Sha256 = ""
+	assert.ErrorContains(t, metadata.IsValid(), "artifact's path and sha256 must be specified")
+
+	metadata.Tool = ""
+	assert.ErrorContains(t, metadata.IsValid(), "tool must be specified")
+}
@@ -0,0 +1,253 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package buildstate is an append-only store of build state transitions.
+//
+// Each build records when it starts, when each of its phases start and complete (along with the digests of the
+// phase's inputs and outputs), and when the build completes or fails. Since the store is append-only and each event is
+// synced to disk before the build continues, an external orchestrator can restart a builder that crashed and the
+// builder can then skip the phases that already completed with identical inputs.
+package buildstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	// EventsFileName is the name of the events file within the store directory.
+	EventsFileName = "events.jsonl"
+)
+
+type EventType string
+
+const (
+	EventTypeBuildStarted   EventType = "buildStarted"
+	EventTypePhaseStarted   EventType = "phaseStarted"
+	EventTypePhaseCompleted EventType = "phaseCompleted"
+	EventTypePhaseSkipped   EventType = "phaseSkipped"
+	EventTypeBuildCompleted EventType = "buildCompleted"
+	EventTypeBuildFailed    EventType = "buildFailed"
+)
+
+// Event is a single build state transition.
+type Event struct {
+	// Sequence is the position of the event within the store, starting from 1.
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	BuildId  string    `json:"buildId"`
+	Type     EventType `json:"type"`
+	// Phase is the name of the phase, for phase events.
+	Phase string `json:"phase,omitempty"`
+	// Inputs are the digests of the inputs of the build or phase.
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Outputs are the digests of the outputs of the phase.
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// Error is the error message, for buildFailed events.
+	Error string `json:"error,omitempty"`
+}
+
+// Store is an append-only store of build events, backed by a JSON lines file.
+//
+// Only a single process may write to a store at a time.
+type Store struct {
+	mutex        sync.Mutex
+	eventsFile   *os.File
+	events       []Event
+	nextSequence uint64
+}
+
+// Open opens a store, creating it if it doesn't exist.
+//
+// If the last event in the store was only partially written (e.g. because the machine crashed), then it is discarded.
+func Open(storeDir string) (*Store, error) {
+	err := os.MkdirAll(storeDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build state directory (%s):\n%w", storeDir, err)
+	}
+
+	eventsFilePath := filepath.Join(storeDir, EventsFileName)
+
+	events, validLength, err := readEvents(eventsFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	eventsFile, err := os.OpenFile(eventsFilePath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open build state file (%s):\n%w", eventsFilePath, err)
+	}
+
+	// Discard any partially written event.
+	err = eventsFile.Truncate(validLength)
+	if err != nil {
+		eventsFile.Close()
+		return nil, fmt.Errorf("failed to truncate build state file (%s):\n%w", eventsFilePath, err)
+	}
+
+	_, err = eventsFile.Seek(validLength, 0)
+	if err != nil {
+		eventsFile.Close()
+		return nil, fmt.Errorf("failed to seek build state file (%s):\n%w", eventsFilePath, err)
+	}
+
+	store := &Store{
+		eventsFile:   eventsFile,
+		events:       events,
+		nextSequence: uint64(len(events)) + 1,
+	}
+	return store, nil
+}
+
+// ReadEvents reads all the events of a store, without opening the store for writing.
+func ReadEvents(storeDir string) ([]Event, error) {
+	events, _, err := readEvents(filepath.Join(storeDir, EventsFileName))
+	return events, err
+}
+
+// readEvents reads the events file and returns the events along with the length of the file that contains complete
+// events.
+func readEvents(eventsFilePath string) ([]Event, int64, error) {
+	data, err := os.ReadFile(eventsFilePath)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read build state file (%s):\n%w", eventsFilePath, err)
+	}
+
+	events := []Event(nil)
+	validLength := int64(0)
+
+	remaining := data
+	for lineNum := 1; len(remaining) > 0; lineNum++ {
+		newline := bytes.IndexByte(remaining, '\n')
+		if newline < 0 {
+			logger.Log.Warnf("Discarding partially written build state event (%s:%d)", eventsFilePath, lineNum)
+			break
+		}
+
+		line := remaining[:newline]
+		remaining = remaining[newline+1:]
+
+		var event Event
+		err = json.Unmarshal(line, &event)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse build state event (%s:%d):\n%w", eventsFilePath, lineNum, err)
+		}
+
+		if event.Sequence != uint64(len(events))+1 {
+			return nil, 0, fmt.Errorf("build state event (%s:%d) has unexpected sequence number (%d)", eventsFilePath,
+				lineNum, event.Sequence)
+		}
+
+		events = append(events, event)
+		validLength += int64(newline) + 1
+	}
+
+	return events, validLength, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.eventsFile == nil {
+		return nil
+	}
+
+	err := s.eventsFile.Close()
+	s.eventsFile = nil
+	return err
+}
+
+// Append adds an event to the store. The event's sequence number and time are assigned by the store. The event is
+// synced to disk before this function returns.
+func (s *Store) Append(event Event) (Event, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.eventsFile == nil {
+		return Event{}, fmt.Errorf("build state store is closed")
+	}
+
+	event.Sequence = s.nextSequence
+	event.Time = time.Now().UTC()
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to serialize build state event:\n%w", err)
+	}
+
+	_, err = s.eventsFile.Write(append(line, '\n'))
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to write build state event:\n%w", err)
+	}
+
+	err = s.eventsFile.Sync()
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to sync build state file:\n%w", err)
+	}
+
+	s.events = append(s.events, event)
+	s.nextSequence++
+	return event, nil
+}
+
+// Events returns all the events in the store, in order.
+func (s *Store) Events() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]Event(nil), s.events...)
+}
+
+// Build returns the current state of a build. Returns nil if the build doesn't exist.
+func (s *Store) Build(buildId string) *BuildState {
+	return BuildFromEvents(s.Events(), buildId)
+}
+
+// Builds returns the current state of all the builds, sorted by build ID.
+func (s *Store) Builds() []*BuildState {
+	return BuildsFromEvents(s.Events())
+}
+
+// InFlightBuilds returns the builds that were started but never completed or failed, sorted by build ID.
+func (s *Store) InFlightBuilds() []*BuildState {
+	inFlight := []*BuildState(nil)
+	for _, build := range s.Builds() {
+		if build.Status == BuildStatusRunning {
+			inFlight = append(inFlight, build)
+		}
+	}
+	return inFlight
+}
+
+// BuildsFromEvents returns the state of all the builds in a list of events, sorted by build ID.
+func BuildsFromEvents(events []Event) []*BuildState {
+	buildIds := make(map[string]bool)
+	for _, event := range events {
+		buildIds[event.BuildId] = true
+	}
+
+	sortedBuildIds := []string(nil)
+	for buildId := range buildIds {
+		sortedBuildIds = append(sortedBuildIds, buildId)
+	}
+	sort.Strings(sortedBuildIds)
+
+	builds := []*BuildState(nil)
+	for _, buildId := range sortedBuildIds {
+		builds = append(builds, BuildFromEvents(events, buildId))
+	}
+	return builds
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package buildstate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestStoreAppendAndReopen(t *testing.T) {
+	storeDir := t.TempDir()
+
+	store, err := Open(storeDir)
+	require.NoError(t, err)
+
+	event, err := store.Append(Event{BuildId: "a", Type: EventTypeBuildStarted, Inputs: map[string]string{"x": "1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), event.Sequence)
+	assert.False(t, event.Time.IsZero())
+
+	_, err = store.Append(Event{BuildId: "a", Type: EventTypePhaseStarted, Phase: "p1"})
+	assert.NoError(t, err)
+	_, err = store.Append(Event{BuildId: "a", Type: EventTypePhaseCompleted, Phase: "p1",
+		Outputs: map[string]string{"y": "2"}})
+	assert.NoError(t, err)
+
+	err = store.Close()
+	assert.NoError(t, err)
+
+	_, err = store.Append(Event{BuildId: "a", Type: EventTypeBuildCompleted})
+	assert.ErrorContains(t, err, "closed")
+
+	store, err = Open(storeDir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	assert.Len(t, store.Events(), 3)
+
+	event, err = store.Append(Event{BuildId: "a", Type: EventTypeBuildCompleted})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), event.Sequence)
+
+	events, err := ReadEvents(storeDir)
+	assert.NoError(t, err)
+	assert.Len(t, events, 4)
+}
+
+func TestStoreDiscardsPartialEvent(t *testing.T) {
+	storeDir := t.TempDir()
+
+	store, err := Open(storeDir)
+	require.NoError(t, err)
+	_, err = store.Append(Event{BuildId: "a", Type: EventTypeBuildStarted})
+	assert.NoError(t, err)
+	err = store.Close()
+	assert.NoError(t, err)
+
+	// Simulate a crash while writing an event.
+	eventsFile, err := os.OpenFile(filepath.Join(storeDir, EventsFileName), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = eventsFile.WriteString(`{"sequence":2,"buildId":"a","ty`)
+	assert.NoError(t, err)
+	eventsFile.Close()
+
+	store, err = Open(storeDir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	assert.Len(t, store.Events(), 1)
+
+	event, err := store.Append(Event{BuildId: "a", Type: EventTypePhaseStarted, Phase: "p1"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), event.Sequence)
+
+	events, err := ReadEvents(storeDir)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func TestStoreCorruptEvent(t *testing.T) {
+	storeDir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(storeDir, EventsFileName), []byte("{bad}\n"), 0o644)
+	require.NoError(t, err)
+
+	_, err = Open(storeDir)
+	assert.ErrorContains(t, err, "failed to parse build state event")
+
+	err = os.WriteFile(filepath.Join(storeDir, EventsFileName), []byte(`{"sequence":2}`+"\n"), 0o644)
+	require.NoError(t, err)
+
+	_, err = Open(storeDir)
+	assert.ErrorContains(t, err, "unexpected sequence number (2)")
+}
+
+func TestStoreInFlightBuilds(t *testing.T) {
+	store, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, event := range []Event{
+		{BuildId: "b", Type: EventTypeBuildStarted},
+		{BuildId: "a", Type: EventTypeBuildStarted},
+		{BuildId: "c", Type: EventTypeBuildStarted},
+		{BuildId: "b", Type: EventTypeBuildCompleted},
+		{BuildId: "c", Type: EventTypeBuildFailed, Error: "boom"},
+	} {
+		_, err = store.Append(event)
+		require.NoError(t, err)
+	}
+
+	builds := store.Builds()
+	if assert.Len(t, builds, 3) {
+		assert.Equal(t, "a", builds[0].BuildId)
+		assert.Equal(t, BuildStatusCompleted, builds[1].Status)
+		assert.Equal(t, BuildStatusFailed, builds[2].Status)
+		assert.Equal(t, "boom", builds[2].Error)
+	}
+
+	inFlight := store.InFlightBuilds()
+	if assert.Len(t, inFlight, 1) {
+		assert.Equal(t, "a", inFlight[0].BuildId)
+	}
+
+	assert.Nil(t, store.Build("missing"))
+}
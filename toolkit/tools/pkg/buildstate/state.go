@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package buildstate
+
+import (
+	"maps"
+	"time"
+)
+
+type BuildStatus string
+
+const (
+	BuildStatusRunning   BuildStatus = "running"
+	BuildStatusCompleted BuildStatus = "completed"
+	BuildStatusFailed    BuildStatus = "failed"
+)
+
+type PhaseStatus string
+
+const (
+	PhaseStatusRunning   PhaseStatus = "running"
+	PhaseStatusCompleted PhaseStatus = "completed"
+)
+
+// BuildState is the state of a build, as derived from its events.
+type BuildState struct {
+	BuildId string      `json:"buildId"`
+	Status  BuildStatus `json:"status"`
+	// Attempts is the number of times the build was started.
+	Attempts  int               `json:"attempts"`
+	Inputs    map[string]string `json:"inputs,omitempty"`
+	StartTime time.Time         `json:"startTime"`
+	EndTime   *time.Time        `json:"endTime,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	// Phases are the phases of the build, in the order they were first started.
+	Phases []*PhaseState `json:"phases"`
+}
+
+// PhaseState is the state of a single phase of a build.
+type PhaseState struct {
+	Name    string            `json:"name"`
+	Status  PhaseStatus       `json:"status"`
+	Inputs  map[string]string `json:"inputs,omitempty"`
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// BuildFromEvents derives the state of a build from a list of events. Returns nil if the build doesn't exist.
+func BuildFromEvents(events []Event, buildId string) *BuildState {
+	var build *BuildState
+
+	for _, event := range events {
+		if event.BuildId != buildId {
+			continue
+		}
+
+		if build == nil {
+			build = &BuildState{
+				BuildId:   buildId,
+				StartTime: event.Time,
+			}
+		}
+
+		switch event.Type {
+		case EventTypeBuildStarted:
+			build.Status = BuildStatusRunning
+			build.Attempts++
+			build.Inputs = maps.Clone(event.Inputs)
+			build.EndTime = nil
+			build.Error = ""
+
+		case EventTypePhaseStarted:
+			phase := build.phase(event.Phase)
+			phase.Status = PhaseStatusRunning
+			phase.Inputs = maps.Clone(event.Inputs)
+			phase.Outputs = nil
+
+		case EventTypePhaseCompleted, EventTypePhaseSkipped:
+			phase := build.phase(event.Phase)
+			phase.Status = PhaseStatusCompleted
+			phase.Inputs = maps.Clone(event.Inputs)
+			phase.Outputs = maps.Clone(event.Outputs)
+
+		case EventTypeBuildCompleted:
+			build.Status = BuildStatusCompleted
+			build.EndTime = &event.Time
+
+		case EventTypeBuildFailed:
+			build.Status = BuildStatusFailed
+			build.EndTime = &event.Time
+			build.Error = event.Error
+		}
+	}
+
+	return build
+}
+
+func (b *BuildState) phase(name string) *PhaseState {
+	for _, phase := range b.Phases {
+		if phase.Name == name {
+			return phase
+		}
+	}
+
+	phase := &PhaseState{
+		Name: name,
+	}
+	b.Phases = append(b.Phases, phase)
+	return phase
+}
+
+// Phase returns the state of a phase. Returns nil if the phase was never started.
+func (b *BuildState) Phase(name string) *PhaseState {
+	for _, phase := range b.Phases {
+		if phase.Name == name {
+			return phase
+		}
+	}
+	return nil
+}
+
+// CompletedPhase returns the outputs of a phase if it completed with the same inputs.
+func (b *BuildState) CompletedPhase(name string, inputs map[string]string) (map[string]string, bool) {
+	phase := b.Phase(name)
+	if phase == nil || phase.Status != PhaseStatusCompleted || !maps.Equal(phase.Inputs, inputs) {
+		return nil, false
+	}
+
+	return maps.Clone(phase.Outputs), true
+}
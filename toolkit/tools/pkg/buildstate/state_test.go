@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package buildstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildFromEvents(t *testing.T) {
+	events := []Event{
+		{BuildId: "a", Type: EventTypeBuildStarted, Inputs: map[string]string{"config": "1"}},
+		{BuildId: "a", Type: EventTypePhaseStarted, Phase: "p1", Inputs: map[string]string{"in": "1"}},
+		{BuildId: "other", Type: EventTypeBuildStarted},
+		{BuildId: "a", Type: EventTypePhaseCompleted, Phase: "p1", Inputs: map[string]string{"in": "1"},
+			Outputs: map[string]string{"out": "2"}},
+		{BuildId: "a", Type: EventTypePhaseStarted, Phase: "p2", Inputs: map[string]string{"in": "2"}},
+		// Builder crashed and was restarted.
+		{BuildId: "a", Type: EventTypeBuildStarted, Inputs: map[string]string{"config": "1"}},
+		{BuildId: "a", Type: EventTypePhaseSkipped, Phase: "p1", Inputs: map[string]string{"in": "1"},
+			Outputs: map[string]string{"out": "2"}},
+	}
+
+	build := BuildFromEvents(events, "a")
+	if !assert.NotNil(t, build) {
+		return
+	}
+
+	assert.Equal(t, BuildStatusRunning, build.Status)
+	assert.Equal(t, 2, build.Attempts)
+	assert.Equal(t, map[string]string{"config": "1"}, build.Inputs)
+
+	if assert.Len(t, build.Phases, 2) {
+		assert.Equal(t, "p1", build.Phases[0].Name)
+		assert.Equal(t, PhaseStatusCompleted, build.Phases[0].Status)
+		assert.Equal(t, "p2", build.Phases[1].Name)
+		assert.Equal(t, PhaseStatusRunning, build.Phases[1].Status)
+	}
+
+	outputs, completed := build.CompletedPhase("p1", map[string]string{"in": "1"})
+	assert.True(t, completed)
+	assert.Equal(t, map[string]string{"out": "2"}, outputs)
+
+	_, completed = build.CompletedPhase("p1", map[string]string{"in": "changed"})
+	assert.False(t, completed)
+
+	_, completed = build.CompletedPhase("p2", map[string]string{"in": "2"})
+	assert.False(t, completed)
+
+	_, completed = build.CompletedPhase("p3", nil)
+	assert.False(t, completed)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package changemanifest defines the change manifest written by the image customizer, which lists the files and
+// packages changed during customization.
+//
+// This package is deliberately free of Linux-only dependencies, so that manifests can be examined on any platform.
+package changemanifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+)
+
+// Manifest lists the changes made to the OS during customization.
+type Manifest struct {
+	Files    Files    `json:"files"`
+	Packages Packages `json:"packages"`
+	// InjectedFiles lists the files that were copied into the OS from the config (e.g. additionalFiles), as opposed
+	// to being installed by packages.
+	InjectedFiles []InjectedFile `json:"injectedFiles"`
+	// Scripts lists the scripts that were run during customization.
+	Scripts []Script `json:"scripts"`
+}
+
+type Files struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+type Packages struct {
+	Installed []Package       `json:"installed"`
+	Removed   []Package       `json:"removed"`
+	Updated   []PackageUpdate `json:"updated"`
+	// OrphansRemoved lists the names of the packages in Removed that were removed because they were no longer
+	// required by any other package (see the 'removeOrphans' config option).
+	OrphansRemoved []string `json:"orphansRemoved"`
+}
+
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type PackageUpdate struct {
+	Name       string `json:"name"`
+	OldVersion string `json:"oldVersion"`
+	NewVersion string `json:"newVersion"`
+}
+
+type InjectedFile struct {
+	// Source is the path of the source file, relative to the config file's directory.
+	// Empty if the file's content was specified inline.
+	Source      string `json:"source,omitempty"`
+	Sha256      string `json:"sha256"`
+	Destination string `json:"destination"`
+	// Mode is the file's permissions, in octal (e.g. "0644").
+	Mode string `json:"mode"`
+}
+
+type Script struct {
+	// Phase is the scripts list that the script is in (e.g. "postConfig").
+	Phase string `json:"phase"`
+	Name  string `json:"name"`
+	// Source is the path of the script file, relative to the config file's directory.
+	// Empty if the script's content was specified inline.
+	Source string `json:"source,omitempty"`
+	// Sha256 is the digest of the script's body.
+	Sha256         string `json:"sha256"`
+	Interpreter    string `json:"interpreter,omitempty"`
+	ContainerImage string `json:"containerImage,omitempty"`
+}
+
+// Read reads a change manifest file.
+func Read(manifestFilePath string) (*Manifest, error) {
+	var manifest Manifest
+	err := jsonutils.ReadJSONFile(manifestFilePath, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change manifest (%s):\n%w", manifestFilePath, err)
+	}
+
+	return &manifest, nil
+}
+
+// Write writes a change manifest file, creating the parent directory if required.
+func Write(manifest *Manifest, manifestFilePath string) error {
+	err := os.MkdirAll(filepath.Dir(manifestFilePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = jsonutils.WriteJSONFile(manifestFilePath, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to write change manifest (%s):\n%w", manifestFilePath, err)
+	}
+
+	return nil
+}
+
+// Diff compares two change manifests (e.g. from two different builds of the same config).
+// Each returned line starts with "-" if the change is only in the first manifest or "+" if the change is only in the
+// second manifest.
+func Diff(first *Manifest, second *Manifest) []string {
+	firstChanges := manifestChanges(first)
+	secondChanges := manifestChanges(second)
+
+	diff := []string(nil)
+	for change := range firstChanges {
+		if !secondChanges[change] {
+			diff = append(diff, "- "+change)
+		}
+	}
+
+	for change := range secondChanges {
+		if !firstChanges[change] {
+			diff = append(diff, "+ "+change)
+		}
+	}
+
+	// Sort by the change, so that related lines are next to each other.
+	sort.Slice(diff, func(i, j int) bool {
+		if diff[i][2:] != diff[j][2:] {
+			return diff[i][2:] < diff[j][2:]
+		}
+		return diff[i] < diff[j]
+	})
+
+	return diff
+}
+
+// manifestChanges flattens a manifest into a set of single line descriptions of each change.
+func manifestChanges(manifest *Manifest) map[string]bool {
+	changes := make(map[string]bool)
+
+	for _, path := range manifest.Files.Added {
+		changes[fmt.Sprintf("file added: %s", path)] = true
+	}
+	for _, path := range manifest.Files.Modified {
+		changes[fmt.Sprintf("file modified: %s", path)] = true
+	}
+	for _, path := range manifest.Files.Removed {
+		changes[fmt.Sprintf("file removed: %s", path)] = true
+	}
+
+	for _, pkg := range manifest.Packages.Installed {
+		changes[fmt.Sprintf("package installed: %s %s", pkg.Name, pkg.Version)] = true
+	}
+	for _, pkg := range manifest.Packages.Removed {
+		changes[fmt.Sprintf("package removed: %s %s", pkg.Name, pkg.Version)] = true
+	}
+	for _, pkg := range manifest.Packages.Updated {
+		changes[fmt.Sprintf("package updated: %s %s -> %s", pkg.Name, pkg.OldVersion, pkg.NewVersion)] = true
+	}
+	for _, name := range manifest.Packages.OrphansRemoved {
+		changes[fmt.Sprintf("orphaned package removed: %s", name)] = true
+	}
+
+	for _, injectedFile := range manifest.InjectedFiles {
+		changes[fmt.Sprintf("file injected: %s %s sha256:%s", injectedFile.Destination, injectedFile.Mode,
+			injectedFile.Sha256)] = true
+	}
+	for _, script := range manifest.Scripts {
+		changes[fmt.Sprintf("script run: %s %s sha256:%s", script.Phase, script.Name, script.Sha256)] = true
+	}
+
+	return changes
+}
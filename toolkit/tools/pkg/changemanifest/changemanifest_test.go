@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package changemanifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func TestWriteAndRead(t *testing.T) {
+	manifestFilePath := filepath.Join(t.TempDir(), "out", "image.changes.json")
+
+	manifest := &Manifest{
+		Files: Files{
+			Added:    []string{"/etc/motd"},
+			Modified: []string{},
+			Removed:  []string{},
+		},
+		Packages: Packages{
+			Installed: []Package{{Name: "jq.x86_64", Version: "0:1.7.1-1.azl3"}},
+			Removed:   []Package{},
+			Updated:   []PackageUpdate{},
+		},
+	}
+
+	err := Write(manifest, manifestFilePath)
+	assert.NoError(t, err)
+
+	readManifest, err := Read(manifestFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, readManifest)
+}
+
+func TestReadMissing(t *testing.T) {
+	_, err := Read(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "failed to read change manifest")
+}
+
+func TestDiff(t *testing.T) {
+	first := &Manifest{
+		Files: Files{
+			Added:    []string{"/etc/motd", "/usr/bin/jq"},
+			Modified: []string{"/etc/passwd"},
+		},
+		Packages: Packages{
+			Installed: []Package{{Name: "jq.x86_64", Version: "0:1.7.1-1.azl3"}},
+			Updated:   []PackageUpdate{{Name: "openssl.x86_64", OldVersion: "0:3.3.1-1", NewVersion: "0:3.3.2-1"}},
+		},
+	}
+
+	second := &Manifest{
+		Files: Files{
+			Added:    []string{"/etc/motd"},
+			Modified: []string{"/etc/passwd", "/etc/group"},
+		},
+		Packages: Packages{
+			Installed: []Package{{Name: "jq.x86_64", Version: "0:1.7.1-2.azl3"}},
+			Updated:   []PackageUpdate{{Name: "openssl.x86_64", OldVersion: "0:3.3.1-1", NewVersion: "0:3.3.2-1"}},
+		},
+	}
+
+	diff := Diff(first, second)
+	assert.Equal(t, []string{
+		"- file added: /usr/bin/jq",
+		"+ file modified: /etc/group",
+		"- package installed: jq.x86_64 0:1.7.1-1.azl3",
+		"+ package installed: jq.x86_64 0:1.7.1-2.azl3",
+	}, diff)
+
+	assert.Empty(t, Diff(first, first))
+}
+
+func TestDiffInjectedFilesAndScripts(t *testing.T) {
+	first := &Manifest{
+		InjectedFiles: []InjectedFile{
+			{Source: "files/motd", Sha256: "aaaa", Destination: "/etc/motd", Mode: "0644"},
+		},
+		Scripts: []Script{
+			{Phase: "postConfig", Name: "scripts/setup.sh", Source: "scripts/setup.sh", Sha256: "cccc"},
+		},
+	}
+
+	second := &Manifest{
+		InjectedFiles: []InjectedFile{
+			{Source: "files/motd", Sha256: "bbbb", Destination: "/etc/motd", Mode: "0644"},
+		},
+		Scripts: []Script{
+			{Phase: "postConfig", Name: "scripts/setup.sh", Source: "scripts/setup.sh", Sha256: "cccc"},
+		},
+	}
+
+	diff := Diff(first, second)
+	assert.Equal(t, []string{
+		"- file injected: /etc/motd 0644 sha256:aaaa",
+		"+ file injected: /etc/motd 0644 sha256:bbbb",
+	}, diff)
+}
+
+func TestDiffOrphansRemoved(t *testing.T) {
+	first := &Manifest{
+		Packages: Packages{
+			Removed:        []Package{{Name: "libfoo.x86_64", Version: "0:1.0-1.azl3"}},
+			OrphansRemoved: []string{"libfoo"},
+		},
+	}
+
+	second := &Manifest{
+		Packages: Packages{
+			Removed: []Package{{Name: "libfoo.x86_64", Version: "0:1.0-1.azl3"}},
+		},
+	}
+
+	diff := Diff(first, second)
+	assert.Equal(t, []string{
+		"- orphaned package removed: libfoo",
+	}, diff)
+}
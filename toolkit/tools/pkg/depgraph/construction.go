@@ -0,0 +1,340 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"fmt"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+)
+
+// addUnresolvedPackage adds an unresolved node to the graph representing the
+// package described in the PackageVer structure. Returns an error if the node
+// could not be created.
+func addUnresolvedPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer, strictUnresolved bool) (newRunNode *pkggraph.PkgNode, err error) {
+	logger.Log.Debugf("Adding unresolved %s", pkgVer)
+	if strictUnresolved {
+		err = fmt.Errorf("strict-unresolved does not allow unresolved packages, attempting to add (%s)", pkgVer)
+		return
+	}
+
+	// Double check that the package is not already in the graph. A previous check should have already found the node
+	// and no call to addUnresolvedPackage() should have been made.
+	nodes, err := g.FindExactPkgNodeFromPkg(pkgVer)
+	if err != nil {
+		return
+	}
+	if nodes != nil {
+		err = fmt.Errorf("attempted to mark a local package %+v as unresolved", pkgVer)
+		return
+	}
+
+	// Create a new node
+	newRunNode, err = g.AddRemoteUnresolvedNode(pkgVer)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Adding unresolved node (%s)", newRunNode.FriendlyName())
+
+	return
+}
+
+// addNodesForPackage creates a "Run", "Build", and "Test" node for the package described
+// in the Package structure. Returns pointers to the build and run Nodes
+// created, or an error if one of the nodes could not be created.
+func addNodesForPackage(g *pkggraph.PkgGraph, pkg *pkgjson.Package) (foundDuplicate bool, err error) {
+	var (
+		newRunNode   *pkggraph.PkgNode
+		newBuildNode *pkggraph.PkgNode
+		newTestNode  *pkggraph.PkgNode
+	)
+
+	nodes, err := g.FindExactPkgNodeFromPkg(pkg.Provides)
+	if err != nil {
+		return
+	}
+
+	if nodes != nil {
+		logger.Log.Warnf("Skipping duplicate package name for package %+v read from SRPM (%s). Original: %+v", pkg.Provides, pkg.SrpmPath, nodes.RunNode)
+		foundDuplicate = true
+		return
+	}
+
+	newRunNode, err = g.AddPkgNode(pkg.Provides, pkggraph.StateMeta, pkggraph.TypeLocalRun, pkg.SrpmPath, pkg.RpmPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, pkggraph.LocalRepo)
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("Adding run node (%s) with id %d", newRunNode.FriendlyName(), newRunNode.ID())
+
+	newBuildNode, err = g.AddPkgNode(pkg.Provides, pkggraph.StateBuild, pkggraph.TypeLocalBuild, pkg.SrpmPath, pkg.RpmPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, pkggraph.LocalRepo)
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("Adding build node (%s) with id %d", newBuildNode.FriendlyName(), newBuildNode.ID())
+
+	// A "run" node has an implicit dependency on its corresponding "build" node, encode that here.
+	err = g.AddEdge(newRunNode, newBuildNode)
+	if err != nil {
+		err = fmt.Errorf("failed to add run -> build edge failed for %+v:\n%w", pkg.Provides, err)
+		return
+	}
+
+	if !pkg.RunTests {
+		logger.Log.Debugf("Skipping adding a test node for package %+v", pkg)
+		return
+	}
+
+	newTestNode, err = g.AddPkgNode(pkg.Provides, pkggraph.StateBuild, pkggraph.TypeTest, pkg.SrpmPath, pkggraph.NoRPMPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, pkggraph.LocalRepo)
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("Adding test node (%s) with id %d", newTestNode.FriendlyName(), newTestNode.ID())
+
+	// A "test" node has a dependency on its corresponding "build" node. This dependency is required
+	// to guarantee we will first check if the build node needs to be built or not before we make
+	// any decisions about running the tests.
+	err = g.AddEdge(newTestNode, newBuildNode)
+	if err != nil {
+		err = fmt.Errorf("failed to add test -> build edge for %+v:\n%w", pkg.Provides, err)
+		return
+	}
+
+	return
+}
+
+// findOrAddExactRemoteDependency ensures that a remote node is available in the graph for every unresolved dependency.
+// 1. Check if the exact dependency is already in the graph. If it is, reuse it.
+// 2. If it is not, create a new unresolved node for the dependency.
+// It is important that we only match on the exact dependency name and version. If we don't, we may end up with
+// unpredictable behavior in the scheduler. If two different remote dependencies are added to two different build
+// nodes of a single SRPM, then the scheduler may queue that node twice.
+func findOrAddExactRemoteDependency(g *pkggraph.PkgGraph, dependency *pkgjson.PackageVer, strictUnresolved bool) (selectedRemoteNode *pkggraph.PkgNode, err error) {
+	existingRemoteNode, err := g.FindExactPkgNodeFromPkg(dependency)
+	if err != nil {
+		err = fmt.Errorf("failed to check lookup list for exact remote %+v:\n%w", dependency, err)
+		return nil, err
+	}
+
+	if existingRemoteNode == nil {
+		// No exact match, add a new one.
+		selectedRemoteNode, err = addUnresolvedPackage(g, dependency, strictUnresolved)
+		if err != nil {
+			err = fmt.Errorf("failed to add a remote node (%s):\n%w", dependency.Name, err)
+			return nil, err
+		}
+		logger.Log.Debugf("Added new node: '%s' for dependency %+v", selectedRemoteNode.FriendlyName(), dependency)
+	} else {
+		// This exact dependency is already in the graph, so reuse it.
+		selectedRemoteNode = existingRemoteNode.RunNode
+		logger.Log.Debugf("Found existing exact remote node: '%s' for dependency %+v", selectedRemoteNode.FriendlyName(), dependency)
+	}
+
+	return selectedRemoteNode, nil
+}
+
+// addSingleDependency will add an edge between packageNode and the "Run" node for the
+// dependency described in the PackageVer structure. Returns an error if the
+// addition failed.
+func addSingleDependency(g *pkggraph.PkgGraph, packageNode *pkggraph.PkgNode, dependency *pkgjson.PackageVer, strictUnresolved bool) (err error) {
+	var dependentNode *pkggraph.PkgNode
+	logger.Log.Tracef("Adding a dependency from %+v to %+v", packageNode.VersionedPkg, dependency)
+	nodes, err := g.FindBestPkgNode(dependency)
+	if err != nil {
+		err = fmt.Errorf("failed to check lookup list for %+v:\n%w", dependency, err)
+		return err
+	}
+
+	// If we can't find the dependency in the graph, or it is a remote dependency, we need to do a bit of extra validation.
+	if nodes == nil || nodes.RunNode.Type != pkggraph.TypeLocalRun {
+		dependentNode, err = findOrAddExactRemoteDependency(g, dependency, strictUnresolved)
+		if err != nil {
+			err = fmt.Errorf("failed to handle remote dependency from %+v to %+v:\n%w", packageNode.VersionedPkg, dependency, err)
+			return err
+		}
+	} else {
+		// All dependencies are assumed to be "Run" dependencies
+		dependentNode = nodes.RunNode
+		logger.Log.Debugf("Found existing node: '%s' for dependency %+v", dependentNode.FriendlyName(), dependency)
+	}
+
+	if packageNode == dependentNode {
+		logger.Log.Debugf("Package %+v requires itself!", packageNode)
+		return nil
+	}
+
+	// Avoid creating runtime dependencies from an RPM to a different provide from the same RPM as the dependency will always be met on RPM installation.
+	// Creating these edges may cause non-problematic cycles that can significantly increase memory usage and runtime during cycle resolution.
+	// If there are enough of these cycles it can exhaust the system's memory when resolving them.
+	// - Only check run nodes. If a build node has a reflexive cycle then it cannot be built without a bootstrap version.
+	if packageNode.Type == pkggraph.TypeLocalRun &&
+		dependentNode.Type == pkggraph.TypeLocalRun &&
+		packageNode.RpmPath == dependentNode.RpmPath {
+
+		logger.Log.Debugf("%+v requires %+v which is provided by the same RPM", packageNode, dependentNode)
+		return nil
+	}
+
+	err = g.AddEdge(packageNode, dependentNode)
+	if err != nil {
+		err = fmt.Errorf("failed to add edge between %+v and %+v:\n%w", packageNode, dependency, err)
+	}
+
+	return err
+}
+
+// addPkgDependencies adds edges for run-, build-, and test-time requirements for the
+// package described in the Package structure. Returns an error if the edges
+// could not be created.
+func addPkgDependencies(g *pkggraph.PkgGraph, pkg *pkgjson.Package, strictUnresolved bool) (dependenciesAdded int, err error) {
+	// Find the current node in the lookup list.
+	logger.Log.Debugf("Adding dependencies for package (%s)", pkg.SrpmPath)
+	nodes, err := g.FindExactPkgNodeFromPkg(pkg.Provides)
+	if err != nil {
+		return
+	}
+	if nodes == nil {
+		return dependenciesAdded, fmt.Errorf("can't add dependencies to a missing package %+v", pkg)
+	}
+
+	// For each run-, build-, and test-time dependency, add the edges
+	logger.Log.Tracef("Adding run dependencies")
+	for _, dependency := range pkg.Requires {
+		err = addSingleDependency(g, nodes.RunNode, dependency, strictUnresolved)
+		if err != nil {
+			err = fmt.Errorf("failed to add run-time dependencies for %+v:\n%w", pkg, err)
+			return
+		}
+		dependenciesAdded++
+	}
+
+	logger.Log.Tracef("Adding build dependencies")
+	for _, dependency := range pkg.BuildRequires {
+		err = addSingleDependency(g, nodes.BuildNode, dependency, strictUnresolved)
+		if err != nil {
+			err = fmt.Errorf("failed to add build-time dependencies for %+v:\n%w", pkg, err)
+			return
+		}
+		dependenciesAdded++
+	}
+
+	if nodes.TestNode == nil {
+		logger.Log.Debugf("No test node for package %+v, skipping test dependencies", pkg)
+		return
+	}
+
+	logger.Log.Tracef("Adding test dependencies")
+	for _, dependency := range pkg.TestRequires {
+		err = addSingleDependency(g, nodes.TestNode, dependency, strictUnresolved)
+		if err != nil {
+			err = fmt.Errorf("failed to add test-time dependencies for %+v:\n%w", pkg, err)
+			return
+		}
+		dependenciesAdded++
+	}
+
+	return
+}
+
+// PopulateGraph adds all the data contained in the PackageRepo structure into
+// the graph. If strictUnresolved is set, then a requirement that isn't provided by
+// any of the packages results in an error instead of an unresolved node.
+func PopulateGraph(graph *pkggraph.PkgGraph, repo *pkgjson.PackageRepo, strictUnresolved bool) (err error) {
+	timestamp.StartEvent("populating graph", nil)
+	defer timestamp.StopEvent(nil)
+
+	packages := repo.Repo
+
+	timestamp.StartEvent("add package node", nil)
+
+	// Scan and add each package we know about
+	logger.Log.Infof("Adding all packages")
+	uniquePackages := make(map[*pkgjson.Package]bool)
+	for _, pkg := range packages {
+		foundDuplicate, err := addNodesForPackage(graph, pkg)
+		if err != nil {
+			err = fmt.Errorf("failed to add local package %+v:\n%w", pkg, err)
+			return err
+		}
+
+		if !foundDuplicate {
+			uniquePackages[pkg] = true
+		}
+	}
+	logger.Log.Infof("\tAdded %d packages", len(packages))
+
+	timestamp.StopEvent(nil) // add package nodes
+	timestamp.StartEvent("add dependencies", nil)
+
+	// Sort the map to ensure the order is deterministic
+	packageList := sliceutils.MapToSlice(uniquePackages)
+	pkgjson.SortPackageList(packageList)
+
+	// Rescan and add all the dependencies
+	logger.Log.Infof("Adding all dependencies")
+	dependenciesAdded := 0
+	for _, uniquePkg := range packageList {
+		num, err := addPkgDependencies(graph, uniquePkg, strictUnresolved)
+		if err != nil {
+			err = fmt.Errorf("failed to add dependency %+v:\n%w", uniquePkg, err)
+			return err
+		}
+		dependenciesAdded += num
+	}
+	logger.Log.Infof("\tAdded %d dependencies", dependenciesAdded)
+
+	timestamp.StopEvent(nil) // add dependencies
+
+	return err
+}
+
+// AddPackage adds a single package, along with its dependencies, to an existing graph.
+// Unlike PopulateGraph, other packages that are already in the graph won't be connected to this package, even if
+// they have a requirement it could satisfy.
+func AddPackage(graph *pkggraph.PkgGraph, pkg *pkgjson.Package, strictUnresolved bool) (err error) {
+	foundDuplicate, err := addNodesForPackage(graph, pkg)
+	if err != nil {
+		return fmt.Errorf("failed to add package %+v:\n%w", pkg.Provides, err)
+	}
+
+	if foundDuplicate {
+		return fmt.Errorf("package %+v already exists in the graph", pkg.Provides)
+	}
+
+	_, err = addPkgDependencies(graph, pkg, strictUnresolved)
+	if err != nil {
+		return fmt.Errorf("failed to add dependencies of package %+v:\n%w", pkg.Provides, err)
+	}
+
+	return nil
+}
+
+// AddRequirement adds a requirement to a local package that already exists in the graph.
+// If buildTime is set, then the requirement is added to the package's build node. Otherwise, it is added to its run
+// node.
+func AddRequirement(graph *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer, requirement *pkgjson.PackageVer,
+	buildTime bool, strictUnresolved bool,
+) (err error) {
+	nodes, err := graph.FindBestPkgNode(pkgVer)
+	if err != nil {
+		return err
+	}
+	if nodes == nil || nodes.RunNode.Type != pkggraph.TypeLocalRun {
+		return fmt.Errorf("local package %+v doesn't exist in the graph", pkgVer)
+	}
+
+	packageNode := nodes.RunNode
+	if buildTime {
+		if nodes.BuildNode == nil {
+			return fmt.Errorf("package %+v isn't built locally", pkgVer)
+		}
+		packageNode = nodes.BuildNode
+	}
+
+	return addSingleDependency(graph, packageNode, requirement, strictUnresolved)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package depgraph is the public API for constructing and analyzing package dependency graphs.
+//
+// The graph types are aliases of the types used by the build tools (grapher, scheduler, etc.), so graphs can be
+// freely passed between this package and the rest of the toolkit.
+package depgraph
+
+import (
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+)
+
+type (
+	// Graph is a package dependency graph.
+	Graph = pkggraph.PkgGraph
+	// Node is a node in a package dependency graph.
+	Node = pkggraph.PkgNode
+	// LookupNode groups the nodes that represent a single package.
+	LookupNode = pkggraph.LookupNode
+	// Package describes a package, along with its requirements.
+	Package = pkgjson.Package
+	// PackageRepo is a list of packages, as produced by the specreader tool.
+	PackageRepo = pkgjson.PackageRepo
+	// PackageVer is a package name, along with an optional version constraint.
+	PackageVer = pkgjson.PackageVer
+)
+
+// NewGraph creates an empty graph.
+func NewGraph() *Graph {
+	return pkggraph.NewPkgGraph()
+}
+
+// ReadGraphFile reads a graph from a DOT file, such as the one produced by the grapher tool.
+func ReadGraphFile(graphFilePath string) (*Graph, error) {
+	return pkggraph.ReadDOTGraphFile(graphFilePath)
+}
+
+// WriteGraphFile writes a graph to a DOT file.
+func WriteGraphFile(graph *Graph, graphFilePath string) error {
+	return pkggraph.WriteDOTGraphFile(graph, graphFilePath)
+}
@@ -0,0 +1,364 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+)
+
+// Supported export formats.
+const (
+	ExportFormatDOT     = "dot"
+	ExportFormatGraphML = "graphml"
+	ExportFormatJSON    = "json"
+)
+
+// ExportOptions selects the part of a graph to export.
+type ExportOptions struct {
+	// RunNodesOnly only exports run nodes (local and remote). The dependencies between the run nodes that went through
+	// other nodes (e.g. run -> build -> run) are exported as direct edges.
+	RunNodesOnly bool
+	// SRPMs limits the export to the nodes of these SRPMs (matched by the SRPM file name or the spec name) and
+	// everything they depend on. If empty, the whole graph is exported.
+	SRPMs []string
+	// MaxDepth limits how many edges away from the selected SRPMs' nodes the export goes. -1 means no limit.
+	// Ignored if no SRPMs are selected.
+	MaxDepth int
+}
+
+// ExportedGraph is a graph in a simple, tool-agnostic form.
+type ExportedGraph struct {
+	Nodes []ExportedNode `json:"nodes"`
+	Edges []ExportedEdge `json:"edges"`
+}
+
+// ExportedNode is a node of an exported graph.
+type ExportedNode struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+	SRPM  string `json:"srpm,omitempty"`
+	RPM   string `json:"rpm,omitempty"`
+	// Color is the graphviz color of the node's state.
+	Color string `json:"color"`
+}
+
+// ExportedEdge is a dependency from one exported node to another.
+type ExportedEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// SelectExportedGraph selects the nodes and edges of a graph to export.
+func SelectExportedGraph(pkgGraph *Graph, options ExportOptions) (*ExportedGraph, error) {
+	included := func(node *Node) bool {
+		return !options.RunNodesOnly || isRunNode(node)
+	}
+
+	selected, err := selectExportedNodes(pkgGraph, options)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := &ExportedGraph{
+		Nodes: []ExportedNode{},
+		Edges: []ExportedEdge{},
+	}
+
+	nodes := pkgGraph.AllNodes()
+	sortNodesByID(nodes)
+
+	for _, node := range nodes {
+		if !selected[node.ID()] || !included(node) {
+			continue
+		}
+
+		exported.Nodes = append(exported.Nodes, newExportedNode(node))
+
+		for _, dependency := range exportedDependencies(pkgGraph, node, selected, included) {
+			exported.Edges = append(exported.Edges, ExportedEdge{
+				From: exportedNodeID(node),
+				To:   exportedNodeID(dependency),
+			})
+		}
+	}
+
+	return exported, nil
+}
+
+// ExportGraphFile exports a graph to a file in one of the supported formats.
+func ExportGraphFile(pkgGraph *Graph, format string, options ExportOptions, outputFilePath string) (err error) {
+	exported, err := SelectExportedGraph(pkgGraph, options)
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(outputFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to create (%s):\n%w", outputFilePath, err)
+	}
+	defer func() {
+		closeErr := outputFile.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close (%s):\n%w", outputFilePath, closeErr)
+		}
+	}()
+
+	return WriteExportedGraph(exported, format, outputFile)
+}
+
+// WriteExportedGraph writes an exported graph in one of the supported formats.
+func WriteExportedGraph(exported *ExportedGraph, format string, output io.Writer) error {
+	switch format {
+	case ExportFormatDOT:
+		return writeExportedDOT(exported, output)
+	case ExportFormatGraphML:
+		return writeExportedGraphML(exported, output)
+	case ExportFormatJSON:
+		encoder := json.NewEncoder(output)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(exported)
+	default:
+		return fmt.Errorf("unsupported export format (%s), supported formats: %s, %s, %s", format, ExportFormatDOT,
+			ExportFormatGraphML, ExportFormatJSON)
+	}
+}
+
+// selectExportedNodes returns the IDs of the nodes selected by the options, before the node type filter is applied.
+func selectExportedNodes(pkgGraph *Graph, options ExportOptions) (map[int64]bool, error) {
+	selected := make(map[int64]bool)
+
+	if len(options.SRPMs) == 0 {
+		for _, node := range pkgGraph.AllNodes() {
+			selected[node.ID()] = true
+		}
+		return selected, nil
+	}
+
+	srpms := make(map[string]bool)
+	for _, srpm := range options.SRPMs {
+		srpms[srpm] = true
+	}
+
+	roots := []*Node(nil)
+	for _, node := range pkgGraph.AllNodes() {
+		if node.SrpmPath == "" || node.SrpmPath == pkggraph.NoSRPMPath {
+			continue
+		}
+
+		if srpms[node.SRPMFileName()] || srpms[node.SpecName()] {
+			roots = append(roots, node)
+		}
+	}
+
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no nodes found for SRPMs (%s)", strings.Join(options.SRPMs, ", "))
+	}
+
+	// Breadth-first search, so that each node is reached at its smallest depth.
+	depths := make(map[int64]int)
+	queue := roots
+	for _, root := range roots {
+		depths[root.ID()] = 0
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		selected[node.ID()] = true
+
+		if options.MaxDepth >= 0 && depths[node.ID()] >= options.MaxDepth {
+			continue
+		}
+
+		dependencies := pkgGraph.From(node.ID())
+		for dependencies.Next() {
+			dependency := dependencies.Node().(*Node).This
+			if _, found := depths[dependency.ID()]; found {
+				continue
+			}
+
+			depths[dependency.ID()] = depths[node.ID()] + 1
+			queue = append(queue, dependency)
+		}
+	}
+
+	return selected, nil
+}
+
+// exportedDependencies returns the selected and included nodes a node depends on. Nodes that are selected but not
+// included are stepped over, so that the dependencies through them are kept.
+func exportedDependencies(pkgGraph *Graph, node *Node, selected map[int64]bool, included func(*Node) bool,
+) (dependencies []*Node) {
+	visited := map[int64]bool{node.ID(): true}
+	queue := []*Node{node}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		neighbors := pkgGraph.From(current.ID())
+		for neighbors.Next() {
+			neighbor := neighbors.Node().(*Node).This
+			if visited[neighbor.ID()] || !selected[neighbor.ID()] {
+				continue
+			}
+			visited[neighbor.ID()] = true
+
+			if included(neighbor) {
+				dependencies = append(dependencies, neighbor)
+			} else {
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+
+	sortNodesByID(dependencies)
+	return dependencies
+}
+
+func sortNodesByID(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID() < nodes[j].ID()
+	})
+}
+
+func newExportedNode(node *Node) ExportedNode {
+	exported := ExportedNode{
+		ID:    exportedNodeID(node),
+		Label: node.FriendlyName(),
+		Type:  node.Type.String(),
+		State: node.State.String(),
+		Color: node.DOTColor(),
+	}
+
+	if node.SrpmPath != "" && node.SrpmPath != pkggraph.NoSRPMPath {
+		exported.SRPM = node.SRPMFileName()
+	}
+
+	if node.RpmPath != "" && node.RpmPath != pkggraph.NoRPMPath {
+		exported.RPM = node.RpmPath
+	}
+
+	return exported
+}
+
+func exportedNodeID(node *Node) string {
+	return "n" + strconv.FormatInt(node.ID(), 10)
+}
+
+// writeExportedDOT writes a plain graphviz graph, which unlike the toolkit's own DOT files doesn't embed the full node
+// data.
+func writeExportedDOT(exported *ExportedGraph, output io.Writer) (err error) {
+	var builder strings.Builder
+
+	builder.WriteString("digraph dependency_graph {\n")
+	for _, node := range exported.Nodes {
+		fmt.Fprintf(&builder, "\t%s [label=%s fillcolor=%s style=filled];\n", node.ID, strconv.Quote(node.Label),
+			node.Color)
+	}
+	for _, edge := range exported.Edges {
+		fmt.Fprintf(&builder, "\t%s -> %s;\n", edge.From, edge.To)
+	}
+	builder.WriteString("}\n")
+
+	_, err = io.WriteString(output, builder.String())
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// writeExportedGraphML writes a GraphML graph (e.g. for Gephi or yEd).
+func writeExportedGraphML(exported *ExportedGraph, output io.Writer) (err error) {
+	keys := []string{"label", "type", "state", "srpm", "rpm", "color"}
+
+	document := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{
+			ID:          "dependency_graph",
+			EdgeDefault: "directed",
+		},
+	}
+
+	for _, key := range keys {
+		document.Keys = append(document.Keys, graphMLKey{ID: key, For: "node", AttrName: key, AttrType: "string"})
+	}
+
+	for _, node := range exported.Nodes {
+		values := []string{node.Label, node.Type, node.State, node.SRPM, node.RPM, node.Color}
+
+		graphNode := graphMLNode{ID: node.ID}
+		for i, key := range keys {
+			if values[i] != "" {
+				graphNode.Data = append(graphNode.Data, graphMLData{Key: key, Value: values[i]})
+			}
+		}
+		document.Graph.Nodes = append(document.Graph.Nodes, graphNode)
+	}
+
+	for _, edge := range exported.Edges {
+		document.Graph.Edges = append(document.Graph.Edges, graphMLEdge{Source: edge.From, Target: edge.To})
+	}
+
+	_, err = io.WriteString(output, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(output)
+	encoder.Indent("", "  ")
+
+	err = encoder.Encode(document)
+	if err != nil {
+		return fmt.Errorf("failed to encode GraphML:\n%w", err)
+	}
+
+	_, err = io.WriteString(output, "\n")
+	return err
+}
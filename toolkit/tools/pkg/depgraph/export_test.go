@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportedEdgeLabels returns an exported graph's edges, using the node labels instead of the node IDs.
+func exportedEdgeLabels(exported *ExportedGraph) []string {
+	labels := make(map[string]string)
+	for _, node := range exported.Nodes {
+		labels[node.ID] = node.Label
+	}
+
+	edges := []string{}
+	for _, edge := range exported.Edges {
+		edges = append(edges, labels[edge.From]+" -> "+labels[edge.To])
+	}
+	return edges
+}
+
+func TestSelectExportedGraphRunNodesOnly(t *testing.T) {
+	graph := makeTestGraph(t)
+
+	exported, err := SelectExportedGraph(graph, ExportOptions{RunNodesOnly: true, SRPMs: []string{"a"}, MaxDepth: -1})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"a-1.0-RUN<Meta> -> b-1.0-RUN<Meta>",
+		"a-1.0-RUN<Meta> -> c-1.0-RUN<Meta>",
+	}, exportedEdgeLabels(exported))
+	assert.Len(t, exported.Nodes, 3)
+}
+
+func TestSelectExportedGraphMaxDepth(t *testing.T) {
+	graph := makeTestGraph(t)
+
+	exported, err := SelectExportedGraph(graph, ExportOptions{SRPMs: []string{"d.src.rpm"}, MaxDepth: 1})
+	require.NoError(t, err)
+
+	labels := []string{}
+	for _, node := range exported.Nodes {
+		labels = append(labels, node.Label)
+	}
+	assert.ElementsMatch(t, []string{
+		"d-1.0-RUN<Meta>", "d-1.0-BUILD<Build>", "e-1.0-RUN<Meta>", "gcc--REMOTE<Unresolved>",
+	}, labels)
+
+	_, err = SelectExportedGraph(graph, ExportOptions{SRPMs: []string{"missing"}, MaxDepth: -1})
+	assert.ErrorContains(t, err, "no nodes found")
+}
+
+func TestExportGraphFileFormats(t *testing.T) {
+	graph := makeTestGraph(t)
+	options := ExportOptions{SRPMs: []string{"a"}, MaxDepth: -1}
+
+	exported, err := SelectExportedGraph(graph, options)
+	require.NoError(t, err)
+
+	jsonFile := filepath.Join(t.TempDir(), "graph.json")
+	require.NoError(t, ExportGraphFile(graph, ExportFormatJSON, options, jsonFile))
+
+	var buffer bytes.Buffer
+	require.NoError(t, WriteExportedGraph(exported, ExportFormatGraphML, &buffer))
+
+	var document graphML
+	require.NoError(t, xml.Unmarshal(buffer.Bytes(), &document))
+	assert.Len(t, document.Graph.Nodes, len(exported.Nodes))
+	assert.Len(t, document.Graph.Edges, len(exported.Edges))
+
+	buffer.Reset()
+	require.NoError(t, WriteExportedGraph(exported, ExportFormatDOT, &buffer))
+	assert.Contains(t, buffer.String(), "digraph dependency_graph {")
+	assert.Contains(t, buffer.String(), `label="a-1.0-RUN<Meta>"`)
+
+	buffer.Reset()
+	require.NoError(t, WriteExportedGraph(exported, ExportFormatJSON, &buffer))
+	var decoded ExportedGraph
+	require.NoError(t, json.Unmarshal(buffer.Bytes(), &decoded))
+	assert.Equal(t, *exported, decoded)
+
+	assert.Error(t, WriteExportedGraph(exported, "svg", &buffer))
+}
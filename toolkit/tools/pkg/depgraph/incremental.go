@@ -0,0 +1,313 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+)
+
+// UpdateSummary describes what an incremental graph update changed.
+type UpdateSummary struct {
+	// ChangedSRPMs are the SRPMs that were added, removed or whose packages changed. Their nodes were recreated.
+	ChangedSRPMs []string `json:"changedSrpms"`
+	// RewiredSRPMs are the unchanged SRPMs that require a package provided by a changed SRPM. Their nodes were kept,
+	// but their dependencies were recalculated.
+	RewiredSRPMs []string `json:"rewiredSrpms"`
+	// ReusedNodes is the number of nodes that were carried over from the previous graph as-is.
+	ReusedNodes int `json:"reusedNodes"`
+}
+
+// UpdateGraph incrementally updates a graph that was generated from previousRepo so that it reflects currentRepo.
+// Only the nodes of the SRPMs whose packages changed are recreated, and only the dependencies of the packages that
+// could be affected by those changes are recalculated. All other nodes, including their state, are carried over.
+//
+// The previous graph must be the graph as it was populated, before MakeDAG() resolved its cycles: cycle resolution
+// rewrites edges and nodes, which can't be told apart from the packages' own dependencies.
+//
+// The previous graph itself is never modified. Goal nodes aren't carried over, since the packages they refer to may
+// have changed, so callers must add them again. The returned graph may contain cycles and should be passed through
+// MakeDAG() just like a freshly populated graph.
+func UpdateGraph(previous *Graph, previousRepo *PackageRepo, currentRepo *PackageRepo, strictUnresolved bool,
+) (graph *Graph, summary *UpdateSummary, err error) {
+	timestamp.StartEvent("updating graph", nil)
+	defer timestamp.StopEvent(nil)
+
+	graph, err = previous.DeepCopy()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to copy previous graph:\n%w", err)
+	}
+
+	previousPackages := packagesBySRPM(previousRepo)
+	currentPackages := packagesBySRPM(currentRepo)
+
+	changedSRPMs, err := findChangedSRPMs(previousPackages, currentPackages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rewiredSRPMs := findRewiredSRPMs(previousPackages, currentPackages, changedSRPMs)
+
+	logger.Log.Infof("Updating graph: %d changed SRPMs, %d SRPMs with affected dependencies", len(changedSRPMs),
+		len(rewiredSRPMs))
+
+	removeGoalNodes(graph)
+
+	for _, node := range graph.AllNodes() {
+		if changedSRPMs[node.SrpmPath] {
+			graph.RemovePkgNode(node)
+		}
+	}
+
+	rewiredPackages := []*Package(nil)
+	for srpmPath := range rewiredSRPMs {
+		packages, err := removeDependencyEdges(graph, currentPackages[srpmPath])
+		if err != nil {
+			return nil, nil, err
+		}
+		rewiredPackages = append(rewiredPackages, packages...)
+	}
+
+	changedPackages := []*Package(nil)
+	for srpmPath := range changedSRPMs {
+		changedPackages = append(changedPackages, currentPackages[srpmPath]...)
+	}
+	pkgjson.SortPackageList(changedPackages)
+
+	uniquePackages := []*Package(nil)
+	for _, pkg := range changedPackages {
+		foundDuplicate, err := addNodesForPackage(graph, pkg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to add local package %+v:\n%w", pkg, err)
+		}
+
+		if !foundDuplicate {
+			uniquePackages = append(uniquePackages, pkg)
+		}
+	}
+
+	uniquePackages = append(uniquePackages, rewiredPackages...)
+	pkgjson.SortPackageList(uniquePackages)
+
+	for _, pkg := range uniquePackages {
+		_, err = addPkgDependencies(graph, pkg, strictUnresolved)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to add dependency %+v:\n%w", pkg, err)
+		}
+	}
+
+	removeOrphanedNodes(graph)
+
+	summary = &UpdateSummary{
+		ChangedSRPMs: sortedKeys(changedSRPMs),
+		RewiredSRPMs: sortedKeys(rewiredSRPMs),
+		ReusedNodes:  countReusedNodes(previous, graph),
+	}
+	return graph, summary, nil
+}
+
+// packagesBySRPM groups a repo's packages by the SRPM they are built from.
+func packagesBySRPM(repo *PackageRepo) map[string][]*Package {
+	packages := make(map[string][]*Package)
+	for _, pkg := range repo.Repo {
+		packages[pkg.SrpmPath] = append(packages[pkg.SrpmPath], pkg)
+	}
+	return packages
+}
+
+// findChangedSRPMs returns the SRPMs that only exist in one of the repos, or whose packages differ between them.
+func findChangedSRPMs(previousPackages, currentPackages map[string][]*Package) (changed map[string]bool, err error) {
+	changed = make(map[string]bool)
+
+	for srpmPath, packages := range currentPackages {
+		previous, found := previousPackages[srpmPath]
+		if !found {
+			changed[srpmPath] = true
+			continue
+		}
+
+		equal, err := samePackages(previous, packages)
+		if err != nil {
+			return nil, err
+		}
+
+		if !equal {
+			changed[srpmPath] = true
+		}
+	}
+
+	for srpmPath := range previousPackages {
+		if _, found := currentPackages[srpmPath]; !found {
+			changed[srpmPath] = true
+		}
+	}
+
+	return changed, nil
+}
+
+// samePackages checks if two lists of packages are identical, ignoring their order.
+func samePackages(a, b []*Package) (bool, error) {
+	if len(a) != len(b) {
+		return false, nil
+	}
+
+	aEncoded, err := encodePackages(a)
+	if err != nil {
+		return false, err
+	}
+
+	bEncoded, err := encodePackages(b)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range aEncoded {
+		if aEncoded[i] != bEncoded[i] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func encodePackages(packages []*Package) ([]string, error) {
+	encoded := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		bytes, err := json.Marshal(pkg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode package %+v:\n%w", pkg.Provides, err)
+		}
+		encoded = append(encoded, string(bytes))
+	}
+
+	sort.Strings(encoded)
+	return encoded, nil
+}
+
+// findRewiredSRPMs returns the unchanged SRPMs with a requirement that either was or now is provided by one of the
+// changed SRPMs. The requirements of such packages may now resolve to a different node.
+func findRewiredSRPMs(previousPackages, currentPackages map[string][]*Package, changedSRPMs map[string]bool,
+) (rewired map[string]bool) {
+	changedProvides := make(map[string]bool)
+	for srpmPath := range changedSRPMs {
+		for _, pkg := range previousPackages[srpmPath] {
+			changedProvides[pkg.Provides.Name] = true
+		}
+		for _, pkg := range currentPackages[srpmPath] {
+			changedProvides[pkg.Provides.Name] = true
+		}
+	}
+
+	rewired = make(map[string]bool)
+	for srpmPath, packages := range currentPackages {
+		if changedSRPMs[srpmPath] {
+			continue
+		}
+
+		for _, pkg := range packages {
+			if requiresAny(pkg, changedProvides) {
+				rewired[srpmPath] = true
+				break
+			}
+		}
+	}
+
+	return rewired
+}
+
+func requiresAny(pkg *Package, provides map[string]bool) bool {
+	for _, requirements := range [][]*PackageVer{pkg.Requires, pkg.BuildRequires, pkg.TestRequires} {
+		for _, requirement := range requirements {
+			if provides[requirement.Name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeDependencyEdges removes the requirement edges of a list of packages, while keeping the edges between the
+// nodes of each package (i.e. run -> build and test -> build). Returns the packages whose edges were removed, which
+// excludes packages that were skipped as duplicates when the graph was populated.
+func removeDependencyEdges(graph *Graph, packages []*Package) (removed []*Package, err error) {
+	for _, pkg := range packages {
+		nodes, err := graph.FindExactPkgNodeFromPkg(pkg.Provides)
+		if err != nil {
+			return nil, err
+		}
+
+		if nodes == nil || nodes.RunNode.SrpmPath != pkg.SrpmPath {
+			continue
+		}
+		removed = append(removed, pkg)
+
+		for _, node := range []*Node{nodes.RunNode, nodes.BuildNode, nodes.TestNode} {
+			if node == nil {
+				continue
+			}
+
+			dependencyIDs := []int64(nil)
+			dependencies := graph.From(node.ID())
+			for dependencies.Next() {
+				dependency := dependencies.Node().(*Node).This
+				if dependency != nodes.BuildNode {
+					dependencyIDs = append(dependencyIDs, dependency.ID())
+				}
+			}
+
+			for _, dependencyID := range dependencyIDs {
+				graph.RemoveEdge(node.ID(), dependencyID)
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+// removeGoalNodes removes all the goal nodes from a graph. Goal nodes aren't in the lookup tables, so they are removed
+// from the graph directly.
+func removeGoalNodes(graph *Graph) {
+	for _, node := range graph.AllNodes() {
+		if node.Type == pkggraph.TypeGoal {
+			graph.RemoveNode(node.ID())
+		}
+	}
+}
+
+// removeOrphanedNodes removes the nodes that no longer serve a purpose after the graph was updated: remote nodes that
+// nothing requires anymore and meta nodes that no longer link to anything.
+func removeOrphanedNodes(graph *Graph) {
+	for _, node := range graph.AllNodes() {
+		switch node.Type {
+		case pkggraph.TypeRemoteRun:
+			if graph.To(node.ID()).Len() == 0 {
+				logger.Log.Debugf("Removing orphaned remote node (%s)", node.FriendlyName())
+				graph.RemovePkgNode(node)
+			}
+
+		case pkggraph.TypePureMeta:
+			if graph.From(node.ID()).Len() == 0 {
+				logger.Log.Debugf("Removing orphaned meta node (%s)", node.FriendlyName())
+				graph.RemoveNode(node.ID())
+			}
+		}
+	}
+}
+
+// countReusedNodes counts the nodes of the updated graph that were carried over from the previous graph.
+func countReusedNodes(previous *Graph, updated *Graph) (reused int) {
+	for _, node := range updated.AllNodes() {
+		previousNode := previous.Node(node.ID())
+		if previousNode != nil && previousNode.(*Node).Equal(node) {
+			reused++
+		}
+	}
+	return reused
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestRepo() *PackageRepo {
+	return &PackageRepo{
+		Repo: []*Package{
+			makePackage("a", "a", []*PackageVer{{Name: "b"}}, []*PackageVer{{Name: "c"}}),
+			makePackage("b", "b", nil, nil),
+			makePackage("c", "c", nil, nil),
+			makePackage("d", "d", []*PackageVer{{Name: "e"}}, []*PackageVer{{Name: "gcc"}}),
+			makePackage("e", "e", nil, []*PackageVer{{Name: "make"}}),
+		},
+	}
+}
+
+// graphEdges describes a graph's edges in a way that doesn't depend on the node IDs.
+func graphEdges(graph *Graph) []string {
+	edges := []string(nil)
+	for _, node := range graph.AllNodes() {
+		dependencies := graph.From(node.ID())
+		for dependencies.Next() {
+			dependency := dependencies.Node().(*Node)
+			edges = append(edges, fmt.Sprintf("%s -> %s", node.FriendlyName(), dependency.FriendlyName()))
+		}
+	}
+	sort.Strings(edges)
+	return edges
+}
+
+func TestUpdateGraphMatchesFullGeneration(t *testing.T) {
+	previousRepo := makeTestRepo()
+	previous := makeTestGraphFromRepo(t, previousRepo)
+
+	currentRepo := makeTestRepo()
+	// "c" now requires "gcc" at build time and "e" no longer requires "make".
+	currentRepo.Repo[2].BuildRequires = []*PackageVer{{Name: "gcc"}}
+	currentRepo.Repo[4].BuildRequires = nil
+
+	updated, summary, err := UpdateGraph(previous, previousRepo, currentRepo, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c.src.rpm", "e.src.rpm"}, summary.ChangedSRPMs)
+	assert.Equal(t, []string{"a.src.rpm", "d.src.rpm"}, summary.RewiredSRPMs)
+	assert.Greater(t, summary.ReusedNodes, 0)
+
+	expected := makeTestGraphFromRepo(t, currentRepo)
+
+	assert.Equal(t, graphEdges(expected), graphEdges(updated))
+	assert.Len(t, updated.AllNodes(), len(expected.AllNodes()))
+
+	// The previous graph must not be modified.
+	assert.Equal(t, graphEdges(makeTestGraphFromRepo(t, makeTestRepo())), graphEdges(previous))
+}
+
+func TestUpdateGraphPreservesUnaffectedState(t *testing.T) {
+	previousRepo := makeTestRepo()
+	previous := makeTestGraphFromRepo(t, previousRepo)
+	_, err := previous.AddGoalNode("ALL", nil, nil, false)
+	require.NoError(t, err)
+
+	gccNodes, err := previous.FindExactPkgNodeFromPkg(&PackageVer{Name: "gcc"})
+	require.NoError(t, err)
+	gccNodes.RunNode.State = pkggraph.StateCached
+
+	currentRepo := makeTestRepo()
+	currentRepo.Repo = append(currentRepo.Repo, makePackage("f", "f", []*PackageVer{{Name: "b"}}, nil))
+
+	updated, summary, err := UpdateGraph(previous, previousRepo, currentRepo, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"f.src.rpm"}, summary.ChangedSRPMs)
+	assert.Empty(t, summary.RewiredSRPMs)
+	assert.Nil(t, updated.FindGoalNode("ALL"))
+
+	gccNodes, err = updated.FindExactPkgNodeFromPkg(&PackageVer{Name: "gcc"})
+	require.NoError(t, err)
+	require.NotNil(t, gccNodes)
+	assert.Equal(t, pkggraph.StateCached, gccNodes.RunNode.State)
+
+	fNodes, err := updated.FindExactPkgNodeFromPkg(&PackageVer{Name: "f", Version: "1.0"})
+	require.NoError(t, err)
+	require.NotNil(t, fNodes)
+}
+
+func TestUpdateGraphRemovesDeletedPackages(t *testing.T) {
+	previousRepo := makeTestRepo()
+	previous := makeTestGraphFromRepo(t, previousRepo)
+
+	currentRepo := makeTestRepo()
+	// Remove "d", which is the only package that requires "gcc".
+	currentRepo.Repo = append(currentRepo.Repo[:3], currentRepo.Repo[4:]...)
+
+	updated, summary, err := UpdateGraph(previous, previousRepo, currentRepo, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d.src.rpm"}, summary.ChangedSRPMs)
+
+	gccNodes, err := updated.FindExactPkgNodeFromPkg(&PackageVer{Name: "gcc"})
+	require.NoError(t, err)
+	assert.Nil(t, gccNodes)
+
+	assert.Equal(t, graphEdges(makeTestGraphFromRepo(t, currentRepo)), graphEdges(updated))
+}
+
+func TestUpdateGraphWithCycleMatchesFullGeneration(t *testing.T) {
+	makeCycleRepo := func() *PackageRepo {
+		repo := makeTestRepo()
+		// "b" and "x" require each other at run time, which cycle resolution collapses into a meta node.
+		repo.Repo[1].Requires = []*PackageVer{{Name: "x"}}
+		repo.Repo = append(repo.Repo, makePackage("x", "x", []*PackageVer{{Name: "b"}}, nil))
+		return repo
+	}
+
+	// The incremental base is the graph before cycle resolution, which is what grapher saves.
+	previousRepo := makeCycleRepo()
+	previous := makeTestGraphFromRepo(t, previousRepo)
+
+	previousDAG, err := previous.DeepCopy()
+	require.NoError(t, err)
+	err = previousDAG.MakeDAG()
+	require.NoError(t, err)
+	require.NotEqual(t, graphEdges(previous), graphEdges(previousDAG))
+
+	currentRepo := makeCycleRepo()
+	currentRepo.Repo[2].BuildRequires = []*PackageVer{{Name: "gcc"}}
+
+	updated, summary, err := UpdateGraph(previous, previousRepo, currentRepo, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c.src.rpm"}, summary.ChangedSRPMs)
+
+	err = updated.MakeDAG()
+	require.NoError(t, err)
+
+	expected := makeTestGraphFromRepo(t, currentRepo)
+	err = expected.MakeDAG()
+	require.NoError(t, err)
+
+	assert.Equal(t, graphEdges(expected), graphEdges(updated))
+	assert.Len(t, updated.AllNodes(), len(expected.AllNodes()))
+}
+
+func makeTestGraphFromRepo(t *testing.T, repo *PackageRepo) *Graph {
+	graph := NewGraph()
+	err := PopulateGraph(graph, repo, false)
+	require.NoError(t, err)
+	return graph
+}
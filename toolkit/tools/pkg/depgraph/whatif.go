@@ -0,0 +1,232 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+)
+
+// Closure is the set of packages required to install a list of packages into an image and the set of SRPMs that must
+// be built to produce them.
+type Closure struct {
+	// Packages are the local and remote packages installed into the image.
+	Packages []string `json:"packages"`
+	// SRPMs are the SRPMs that must be built, including the SRPMs required to build other SRPMs.
+	SRPMs []string `json:"srpms"`
+	// Unresolved are the remote packages that haven't been downloaded yet, including build-time requirements.
+	Unresolved []string `json:"unresolved"`
+}
+
+// Delta is the difference between two closures.
+type Delta struct {
+	AddedPackages     []string `json:"addedPackages"`
+	RemovedPackages   []string `json:"removedPackages"`
+	AddedSRPMs        []string `json:"addedSrpms"`
+	RemovedSRPMs      []string `json:"removedSrpms"`
+	AddedUnresolved   []string `json:"addedUnresolved"`
+	RemovedUnresolved []string `json:"removedUnresolved"`
+}
+
+// Scenario is a copy of a graph that hypothetical changes can be made to, so that the cost of the changes can be
+// calculated without running a build.
+type Scenario struct {
+	base             *Graph
+	graph            *Graph
+	strictUnresolved bool
+}
+
+// NewScenario creates a scenario from a graph. The graph itself is never modified.
+// If strictUnresolved is set, then hypothetical requirements that can't be satisfied by a local package result in an
+// error.
+func NewScenario(base *Graph, strictUnresolved bool) (*Scenario, error) {
+	scenarioGraph, err := base.DeepCopy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy graph:\n%w", err)
+	}
+
+	scenario := &Scenario{
+		base:             base,
+		graph:            scenarioGraph,
+		strictUnresolved: strictUnresolved,
+	}
+	return scenario, nil
+}
+
+// Graph returns the scenario's graph, which includes the hypothetical changes.
+func (s *Scenario) Graph() *Graph {
+	return s.graph
+}
+
+// AddPackage adds a hypothetical local package, which will be built from its SRPM.
+func (s *Scenario) AddPackage(pkg *Package) error {
+	return AddPackage(s.graph, pkg, s.strictUnresolved)
+}
+
+// AddRequirement adds a hypothetical requirement (e.g. a new version constraint) to an existing package.
+func (s *Scenario) AddRequirement(pkgVer *PackageVer, requirement *PackageVer, buildTime bool) error {
+	return AddRequirement(s.graph, pkgVer, requirement, buildTime, s.strictUnresolved)
+}
+
+// Delta calculates how installing the extra packages (on top of the image's packages) within this scenario changes
+// the image and build sets, compared to installing only the image's packages using the original graph.
+func (s *Scenario) Delta(imagePackages []*PackageVer, extraPackages []*PackageVer) (*Delta, error) {
+	before, err := ComputeClosure(s.base, imagePackages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute closure of original graph:\n%w", err)
+	}
+
+	allPackages := append(append([]*PackageVer(nil), imagePackages...), extraPackages...)
+
+	after, err := ComputeClosure(s.graph, allPackages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute closure of scenario graph:\n%w", err)
+	}
+
+	return CompareClosures(before, after), nil
+}
+
+// ComputeClosure calculates the closure of a list of packages.
+// Requested packages that aren't in the graph are reported as unresolved.
+func ComputeClosure(pkgGraph *Graph, packages []*PackageVer) (*Closure, error) {
+	imageNodes := []*Node(nil)
+	unresolved := make(map[string]bool)
+
+	for _, pkgVer := range packages {
+		nodes, err := pkgGraph.FindBestPkgNode(pkgVer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find package %+v:\n%w", pkgVer, err)
+		}
+
+		if nodes == nil {
+			unresolved[packageVerFriendlyName(pkgVer)] = true
+			continue
+		}
+
+		imageNodes = append(imageNodes, nodes.RunNode)
+	}
+
+	// Packages installed into the image only include the run-time dependencies.
+	imagePackages := make(map[string]bool)
+	walkNodes(pkgGraph, imageNodes, isRunNode, func(node *Node) {
+		imagePackages[runNodeFriendlyName(node)] = true
+	})
+
+	// Building the packages includes both the run-time and build-time dependencies.
+	srpms := make(map[string]bool)
+	walkNodes(pkgGraph, imageNodes, nil, func(node *Node) {
+		switch {
+		case node.Type == pkggraph.TypeLocalBuild && node.State == pkggraph.StateBuild:
+			srpms[node.SRPMFileName()] = true
+
+		case node.Type == pkggraph.TypeRemoteRun && node.State == pkggraph.StateUnresolved:
+			unresolved[runNodeFriendlyName(node)] = true
+		}
+	})
+
+	closure := &Closure{
+		Packages:   sortedKeys(imagePackages),
+		SRPMs:      sortedKeys(srpms),
+		Unresolved: sortedKeys(unresolved),
+	}
+	return closure, nil
+}
+
+// CompareClosures calculates the difference between two closures.
+func CompareClosures(before *Closure, after *Closure) *Delta {
+	delta := &Delta{}
+	delta.AddedPackages, delta.RemovedPackages = diffLists(before.Packages, after.Packages)
+	delta.AddedSRPMs, delta.RemovedSRPMs = diffLists(before.SRPMs, after.SRPMs)
+	delta.AddedUnresolved, delta.RemovedUnresolved = diffLists(before.Unresolved, after.Unresolved)
+	return delta
+}
+
+func isRunNode(node *Node) bool {
+	return node.Type == pkggraph.TypeLocalRun || node.Type == pkggraph.TypeRemoteRun
+}
+
+// walkNodes visits every node reachable from the start nodes (including the start nodes themselves). If filter is
+// set, then nodes that don't match the filter are neither visited nor traversed.
+func walkNodes(pkgGraph *Graph, startNodes []*Node, filter func(*Node) bool, visit func(*Node)) {
+	visited := make(map[int64]bool)
+	queue := append([]*Node(nil), startNodes...)
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		if visited[node.ID()] || (filter != nil && !filter(node)) {
+			continue
+		}
+		visited[node.ID()] = true
+
+		visit(node)
+
+		dependencies := pkgGraph.From(node.ID())
+		for dependencies.Next() {
+			queue = append(queue, dependencies.Node().(*Node).This)
+		}
+	}
+}
+
+func runNodeFriendlyName(node *Node) string {
+	if node.Type == pkggraph.TypeRemoteRun {
+		return packageVerFriendlyName(node.VersionedPkg)
+	}
+
+	return fmt.Sprintf("%s-%s", node.VersionedPkg.Name, node.VersionedPkg.Version)
+}
+
+func packageVerFriendlyName(pkgVer *PackageVer) string {
+	name := pkgVer.Name
+	if pkgVer.Version != "" {
+		name += fmt.Sprintf(" %s %s", defaultCondition(pkgVer.Condition), pkgVer.Version)
+	}
+	if pkgVer.SVersion != "" {
+		name += fmt.Sprintf(", %s %s", defaultCondition(pkgVer.SCondition), pkgVer.SVersion)
+	}
+	return name
+}
+
+func defaultCondition(condition string) string {
+	if condition == "" {
+		return "="
+	}
+	return condition
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffLists returns the items only in the after list and the items only in the before list.
+func diffLists(before []string, after []string) (added []string, removed []string) {
+	beforeSet := make(map[string]bool, len(before))
+	for _, item := range before {
+		beforeSet[item] = true
+	}
+
+	afterSet := make(map[string]bool, len(after))
+	for _, item := range after {
+		afterSet[item] = true
+		if !beforeSet[item] {
+			added = append(added, item)
+		}
+	}
+
+	for _, item := range before {
+		if !afterSet[item] {
+			removed = append(removed, item)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package depgraph
+
+import (
+	"os"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func makePackage(srpmName, name string, requires []*PackageVer, buildRequires []*PackageVer) *Package {
+	return &Package{
+		Provides: &PackageVer{
+			Name:    name,
+			Version: "1.0",
+		},
+		SrpmPath:      srpmName + ".src.rpm",
+		RpmPath:       name + ".rpm",
+		SourceDir:     srpmName + "-src",
+		SpecPath:      srpmName + ".spec",
+		Architecture:  "x86_64",
+		Requires:      requires,
+		BuildRequires: buildRequires,
+	}
+}
+
+func makeTestGraph(t *testing.T) *Graph {
+	repo := &PackageRepo{
+		Repo: []*Package{
+			makePackage("a", "a", []*PackageVer{{Name: "b"}}, []*PackageVer{{Name: "c"}}),
+			makePackage("b", "b", nil, nil),
+			makePackage("c", "c", nil, nil),
+			makePackage("d", "d", []*PackageVer{{Name: "e"}}, []*PackageVer{{Name: "gcc"}}),
+			makePackage("e", "e", nil, nil),
+		},
+	}
+
+	graph := NewGraph()
+	err := PopulateGraph(graph, repo, false)
+	require.NoError(t, err)
+	return graph
+}
+
+func TestComputeClosure(t *testing.T) {
+	graph := makeTestGraph(t)
+
+	closure, err := ComputeClosure(graph, []*PackageVer{{Name: "a"}, {Name: "missing"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-1.0", "b-1.0"}, closure.Packages)
+	assert.Equal(t, []string{"a.src.rpm", "b.src.rpm", "c.src.rpm"}, closure.SRPMs)
+	assert.Equal(t, []string{"missing"}, closure.Unresolved)
+}
+
+func TestScenarioAddPackage(t *testing.T) {
+	graph := makeTestGraph(t)
+	nodeCount := graph.Nodes().Len()
+
+	scenario, err := NewScenario(graph, false)
+	require.NoError(t, err)
+
+	err = scenario.AddPackage(makePackage("x", "x", []*PackageVer{{Name: "d"}, {Name: "openssl", Condition: ">=",
+		Version: "3.0"}}, nil))
+	assert.NoError(t, err)
+
+	delta, err := scenario.Delta([]*PackageVer{{Name: "a"}}, []*PackageVer{{Name: "x"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d-1.0", "e-1.0", "openssl >= 3.0", "x-1.0"}, delta.AddedPackages)
+	assert.Empty(t, delta.RemovedPackages)
+	assert.Equal(t, []string{"d.src.rpm", "e.src.rpm", "x.src.rpm"}, delta.AddedSRPMs)
+	assert.Equal(t, []string{"gcc", "openssl >= 3.0"}, delta.AddedUnresolved)
+
+	// The original graph must not be modified.
+	assert.Equal(t, nodeCount, graph.Nodes().Len())
+}
+
+func TestScenarioAddPackageDuplicate(t *testing.T) {
+	scenario, err := NewScenario(makeTestGraph(t), false)
+	require.NoError(t, err)
+
+	err = scenario.AddPackage(makePackage("b", "b", nil, nil))
+	assert.ErrorContains(t, err, "already exists in the graph")
+}
+
+func TestScenarioAddRequirement(t *testing.T) {
+	scenario, err := NewScenario(makeTestGraph(t), false)
+	require.NoError(t, err)
+
+	err = scenario.AddRequirement(&PackageVer{Name: "b"}, &PackageVer{Name: "e"}, false)
+	assert.NoError(t, err)
+
+	err = scenario.AddRequirement(&PackageVer{Name: "c"}, &PackageVer{Name: "d"}, true)
+	assert.NoError(t, err)
+
+	delta, err := scenario.Delta([]*PackageVer{{Name: "a"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e-1.0"}, delta.AddedPackages)
+	assert.Equal(t, []string{"d.src.rpm", "e.src.rpm"}, delta.AddedSRPMs)
+	assert.Equal(t, []string{"gcc"}, delta.AddedUnresolved)
+
+	err = scenario.AddRequirement(&PackageVer{Name: "missing"}, &PackageVer{Name: "e"}, false)
+	assert.ErrorContains(t, err, "doesn't exist in the graph")
+}
+
+func TestScenarioStrictUnresolved(t *testing.T) {
+	scenario, err := NewScenario(makeTestGraph(t), true)
+	require.NoError(t, err)
+
+	err = scenario.AddRequirement(&PackageVer{Name: "b"}, &PackageVer{Name: "openssl"}, false)
+	assert.Error(t, err)
+}
+
+func TestCompareClosures(t *testing.T) {
+	before := &Closure{Packages: []string{"a", "b"}, SRPMs: []string{"a.src.rpm"}}
+	after := &Closure{Packages: []string{"b", "c"}, SRPMs: []string{"a.src.rpm"}, Unresolved: []string{"d"}}
+
+	delta := CompareClosures(before, after)
+	assert.Equal(t, []string{"c"}, delta.AddedPackages)
+	assert.Equal(t, []string{"a"}, delta.RemovedPackages)
+	assert.Empty(t, delta.AddedSRPMs)
+	assert.Empty(t, delta.RemovedSRPMs)
+	assert.Equal(t, []string{"d"}, delta.AddedUnresolved)
+}
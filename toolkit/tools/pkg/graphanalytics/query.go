@@ -0,0 +1,302 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package graphanalytics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/traverse"
+)
+
+// QueryNode is a node in the result of a graph query.
+type QueryNode struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+	SRPM  string `json:"srpm,omitempty"`
+	// Repeated is set if the node is already listed elsewhere in the tree, in which case its children are omitted.
+	Repeated bool         `json:"repeated,omitempty"`
+	Children []*QueryNode `json:"children,omitempty"`
+}
+
+// CriticalPath is the longest chain of builds that must happen one after another before a package is available.
+type CriticalPath struct {
+	// Builds is the number of SRPMs on the path that still need to be built.
+	Builds int          `json:"builds"`
+	Path   []*QueryNode `json:"path"`
+}
+
+// WhyDepends explains why a package is part of a goal (e.g. "ALL") by returning a tree of the package's dependents,
+// limited to the dependents that are themselves required by the goal. The tree's root is the package and its leaves
+// are the goal.
+func WhyDepends(pkgGraph *pkggraph.PkgGraph, packageName string, goalName string) (root *QueryNode, err error) {
+	goalNode := pkgGraph.FindGoalNode(goalName)
+	if goalNode == nil {
+		return nil, fmt.Errorf("goal (%s) not found in graph", goalName)
+	}
+
+	packageNode, err := findPackageNode(pkgGraph, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	requiredByGoal := make(map[int64]bool)
+	search := traverse.BreadthFirst{}
+	search.Walk(pkgGraph, goalNode, func(n graph.Node, d int) (stopSearch bool) {
+		requiredByGoal[n.ID()] = true
+		return
+	})
+
+	if !requiredByGoal[packageNode.ID()] {
+		return nil, fmt.Errorf("package (%s) isn't required by goal (%s)", packageName, goalName)
+	}
+
+	filter := func(node *pkggraph.PkgNode) bool {
+		return requiredByGoal[node.ID()]
+	}
+
+	return buildDependentsTree(pkgGraph, packageNode, filter, -1), nil
+}
+
+// ReverseDependencies returns a tree of everything that depends on a package, directly or indirectly, excluding goal
+// nodes. A maxDepth of -1 means no limit.
+func ReverseDependencies(pkgGraph *pkggraph.PkgGraph, packageName string, maxDepth int) (root *QueryNode, err error) {
+	packageNode, err := findPackageNode(pkgGraph, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := func(node *pkggraph.PkgNode) bool {
+		return node.Type != pkggraph.TypeGoal
+	}
+
+	return buildDependentsTree(pkgGraph, packageNode, filter, maxDepth), nil
+}
+
+// FindCriticalPath returns the longest chain of pending builds a package depends on. The graph must be acyclic.
+func FindCriticalPath(pkgGraph *pkggraph.PkgGraph, packageName string) (criticalPath *CriticalPath, err error) {
+	packageNode, err := findPackageNode(pkgGraph, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	finder := criticalPathFinder{
+		pkgGraph: pkgGraph,
+		builds:   make(map[int64]int),
+		next:     make(map[int64]*pkggraph.PkgNode),
+		visiting: make(map[int64]bool),
+	}
+
+	builds, err := finder.longestPath(packageNode)
+	if err != nil {
+		return nil, err
+	}
+
+	criticalPath = &CriticalPath{Builds: builds}
+	for node := packageNode; node != nil; node = finder.next[node.ID()] {
+		if node.Type == pkggraph.TypePureMeta {
+			continue
+		}
+		criticalPath.Path = append(criticalPath.Path, newQueryNode(node))
+	}
+
+	return criticalPath, nil
+}
+
+// FormatTree renders a query tree as indented text, one node per line.
+func FormatTree(root *QueryNode) string {
+	var builder strings.Builder
+	formatTreeNode(&builder, root, "", "")
+	return builder.String()
+}
+
+// FormatCriticalPath renders a critical path as text, one node per line.
+func FormatCriticalPath(criticalPath *CriticalPath) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "%d pending builds\n", criticalPath.Builds)
+	for i, node := range criticalPath.Path {
+		prefix := "-> "
+		if i == 0 {
+			prefix = ""
+		}
+		fmt.Fprintf(&builder, "%s%s\n", prefix, queryNodeLabel(node))
+	}
+
+	return builder.String()
+}
+
+func formatTreeNode(builder *strings.Builder, node *QueryNode, prefix string, childPrefix string) {
+	label := queryNodeLabel(node)
+	if node.Repeated {
+		label += " ..."
+	}
+	fmt.Fprintf(builder, "%s%s\n", prefix, label)
+
+	for i, child := range node.Children {
+		if i == len(node.Children)-1 {
+			formatTreeNode(builder, child, childPrefix+"`-- ", childPrefix+"    ")
+		} else {
+			formatTreeNode(builder, child, childPrefix+"|-- ", childPrefix+"|   ")
+		}
+	}
+}
+
+func queryNodeLabel(node *QueryNode) string {
+	if node.SRPM == "" {
+		return node.Name
+	}
+	return fmt.Sprintf("%s [%s]", node.Name, node.SRPM)
+}
+
+// findPackageNode returns the run node that best matches a package name.
+func findPackageNode(pkgGraph *pkggraph.PkgGraph, packageName string) (*pkggraph.PkgNode, error) {
+	nodes, err := pkgGraph.FindBestPkgNode(&pkgjson.PackageVer{Name: packageName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up package (%s):\n%w", packageName, err)
+	}
+
+	if nodes == nil {
+		return nil, fmt.Errorf("package (%s) not found in graph", packageName)
+	}
+
+	return nodes.RunNode, nil
+}
+
+// buildDependentsTree builds a tree of a node's dependents. Dependents that don't match the filter are omitted, and
+// meta nodes are stepped over. Each node's children are only listed the first time it appears in the tree.
+func buildDependentsTree(pkgGraph *pkggraph.PkgGraph, rootNode *pkggraph.PkgNode, filter func(*pkggraph.PkgNode) bool,
+	maxDepth int,
+) *QueryNode {
+	listed := make(map[int64]bool)
+
+	var build func(node *pkggraph.PkgNode, depth int) *QueryNode
+	build = func(node *pkggraph.PkgNode, depth int) *QueryNode {
+		queryNode := newQueryNode(node)
+		if listed[node.ID()] {
+			queryNode.Repeated = pkgGraph.To(node.ID()).Len() > 0
+			return queryNode
+		}
+		listed[node.ID()] = true
+
+		if maxDepth != -1 && depth >= maxDepth {
+			return queryNode
+		}
+
+		for _, dependent := range sortedDependents(pkgGraph, node, filter) {
+			queryNode.Children = append(queryNode.Children, build(dependent, depth+1))
+		}
+
+		return queryNode
+	}
+
+	return build(rootNode, 0)
+}
+
+// sortedDependents returns the nodes with an edge to a node, replacing meta nodes with their own dependents.
+func sortedDependents(pkgGraph *pkggraph.PkgGraph, node *pkggraph.PkgNode, filter func(*pkggraph.PkgNode) bool,
+) (dependents []*pkggraph.PkgNode) {
+	found := make(map[int64]bool)
+
+	var collect func(node *pkggraph.PkgNode)
+	collect = func(node *pkggraph.PkgNode) {
+		dependentNodes := pkgGraph.To(node.ID())
+		for dependentNodes.Next() {
+			dependent := dependentNodes.Node().(*pkggraph.PkgNode).This
+			if found[dependent.ID()] || !filter(dependent) {
+				continue
+			}
+			found[dependent.ID()] = true
+
+			if dependent.Type == pkggraph.TypePureMeta {
+				collect(dependent)
+				continue
+			}
+
+			dependents = append(dependents, dependent)
+		}
+	}
+	collect(node)
+
+	sort.Slice(dependents, func(i, j int) bool {
+		return dependents[i].FriendlyName() < dependents[j].FriendlyName()
+	})
+
+	return dependents
+}
+
+func newQueryNode(node *pkggraph.PkgNode) *QueryNode {
+	queryNode := &QueryNode{
+		Name:  node.FriendlyName(),
+		Type:  node.Type.String(),
+		State: node.State.String(),
+	}
+
+	if node.SrpmPath != "" && node.SrpmPath != pkggraph.NoSRPMPath {
+		queryNode.SRPM = node.SRPMFileName()
+	}
+
+	return queryNode
+}
+
+// criticalPathFinder finds the longest path of pending builds from a node, memoizing the result of every node.
+type criticalPathFinder struct {
+	pkgGraph *pkggraph.PkgGraph
+	// builds is the number of pending builds on the longest path starting at each node.
+	builds map[int64]int
+	// next is the dependency that continues the longest path starting at each node.
+	next     map[int64]*pkggraph.PkgNode
+	visiting map[int64]bool
+}
+
+func (f *criticalPathFinder) longestPath(node *pkggraph.PkgNode) (builds int, err error) {
+	if builds, found := f.builds[node.ID()]; found {
+		return builds, nil
+	}
+
+	if f.visiting[node.ID()] {
+		return 0, fmt.Errorf("graph has a cycle through (%s)", node.FriendlyName())
+	}
+	f.visiting[node.ID()] = true
+	defer delete(f.visiting, node.ID())
+
+	dependencies := graph.NodesOf(f.pkgGraph.From(node.ID()))
+	sort.Slice(dependencies, func(i, j int) bool {
+		return dependencies[i].(*pkggraph.PkgNode).FriendlyName() < dependencies[j].(*pkggraph.PkgNode).FriendlyName()
+	})
+
+	longest := 0
+	for _, dependency := range dependencies {
+		dependencyNode := dependency.(*pkggraph.PkgNode).This
+
+		dependencyBuilds, err := f.longestPath(dependencyNode)
+		if err != nil {
+			return 0, err
+		}
+
+		if f.next[node.ID()] == nil || dependencyBuilds > longest {
+			longest = dependencyBuilds
+			f.next[node.ID()] = dependencyNode
+		}
+	}
+
+	if isPendingBuild(node) {
+		longest++
+	}
+
+	f.builds[node.ID()] = longest
+	return longest, nil
+}
+
+// isPendingBuild checks if a node represents an SRPM that still has to be built.
+func isPendingBuild(node *pkggraph.PkgNode) bool {
+	return node.Type == pkggraph.TypeLocalBuild &&
+		(node.State == pkggraph.StateBuild || node.State == pkggraph.StateDelta || node.State == pkggraph.StateBuildError)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package graphanalytics
+
+import (
+	"os"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/depgraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func makePackage(name string, requires []*pkgjson.PackageVer, buildRequires []*pkgjson.PackageVer) *pkgjson.Package {
+	return &pkgjson.Package{
+		Provides:      &pkgjson.PackageVer{Name: name, Version: "1.0"},
+		SrpmPath:      name + ".src.rpm",
+		RpmPath:       name + ".rpm",
+		SpecPath:      name + ".spec",
+		SourceDir:     name + "-src",
+		Architecture:  "x86_64",
+		Requires:      requires,
+		BuildRequires: buildRequires,
+	}
+}
+
+// makeQueryGraph creates a graph where "app" requires "lib" and builds with "compiler", "compiler" builds with
+// "bootstrap", and "tool" builds with "lib". Only "app" and "lib" are part of the "image" goal.
+func makeQueryGraph(t *testing.T) *pkggraph.PkgGraph {
+	repo := &pkgjson.PackageRepo{
+		Repo: []*pkgjson.Package{
+			makePackage("app", []*pkgjson.PackageVer{{Name: "lib"}}, []*pkgjson.PackageVer{{Name: "compiler"}}),
+			makePackage("lib", nil, nil),
+			makePackage("compiler", nil, []*pkgjson.PackageVer{{Name: "bootstrap"}}),
+			makePackage("bootstrap", nil, nil),
+			makePackage("tool", nil, []*pkgjson.PackageVer{{Name: "lib"}}),
+		},
+	}
+
+	pkgGraph := depgraph.NewGraph()
+	require.NoError(t, depgraph.PopulateGraph(pkgGraph, repo, false))
+
+	_, err := pkgGraph.AddGoalNode("image", []*pkgjson.PackageVer{{Name: "app"}}, nil, true)
+	require.NoError(t, err)
+
+	return pkgGraph
+}
+
+func TestWhyDepends(t *testing.T) {
+	pkgGraph := makeQueryGraph(t)
+
+	root, err := WhyDepends(pkgGraph, "lib", "image")
+	require.NoError(t, err)
+
+	assert.Equal(t, "lib-1.0-RUN<Meta>", root.Name)
+	require.Len(t, root.Children, 1)
+	assert.Equal(t, "app-1.0-RUN<Meta>", root.Children[0].Name)
+	require.Len(t, root.Children[0].Children, 1)
+	assert.Equal(t, "image", root.Children[0].Children[0].Name)
+
+	_, err = WhyDepends(pkgGraph, "tool", "image")
+	assert.ErrorContains(t, err, "isn't required by goal")
+
+	_, err = WhyDepends(pkgGraph, "lib", "missing")
+	assert.ErrorContains(t, err, "goal (missing) not found")
+}
+
+func TestReverseDependencies(t *testing.T) {
+	pkgGraph := makeQueryGraph(t)
+
+	root, err := ReverseDependencies(pkgGraph, "lib", 1)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, child := range root.Children {
+		names = append(names, child.Name)
+		assert.Empty(t, child.Children)
+	}
+	assert.Equal(t, []string{"app-1.0-RUN<Meta>", "tool-1.0-BUILD<Build>"}, names)
+
+	_, err = ReverseDependencies(pkgGraph, "missing", -1)
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestFindCriticalPath(t *testing.T) {
+	pkgGraph := makeQueryGraph(t)
+
+	criticalPath, err := FindCriticalPath(pkgGraph, "app")
+	require.NoError(t, err)
+	assert.Equal(t, 3, criticalPath.Builds)
+
+	names := []string{}
+	for _, node := range criticalPath.Path {
+		names = append(names, node.Name)
+	}
+	assert.Equal(t, []string{
+		"app-1.0-RUN<Meta>", "app-1.0-BUILD<Build>", "compiler-1.0-RUN<Meta>", "compiler-1.0-BUILD<Build>",
+		"bootstrap-1.0-RUN<Meta>", "bootstrap-1.0-BUILD<Build>",
+	}, names)
+}
+
+func TestFormatTree(t *testing.T) {
+	root := &QueryNode{
+		Name: "a",
+		Children: []*QueryNode{
+			{Name: "b", SRPM: "b.src.rpm", Children: []*QueryNode{{Name: "d"}}},
+			{Name: "c", Repeated: true},
+		},
+	}
+
+	expected := "a\n" +
+		"|-- b [b.src.rpm]\n" +
+		"|   `-- d\n" +
+		"`-- c ...\n"
+	assert.Equal(t, expected, FormatTree(root))
+}
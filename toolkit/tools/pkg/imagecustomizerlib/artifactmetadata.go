@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
+)
+
+const (
+	artifactMetadataToolName = "imagecustomizer"
+)
+
+// writeArtifactMetadata writes the metadata sidecar of the output image, in the format shared with the toolkit's
+// other image tools.
+func writeArtifactMetadata(ic *ImageCustomizerParameters) error {
+	stat, err := os.Stat(ic.outputImageFile)
+	if err != nil || !stat.Mode().IsRegular() {
+		// The output is either a directory (e.g. PXE artifacts only) or wasn't written.
+		return nil
+	}
+
+	metadata, err := newArtifactMetadata(ic)
+	if err != nil {
+		return fmt.Errorf("failed to create output image's metadata:\n%w", err)
+	}
+
+	err = artifactmetadata.WriteSidecar(metadata, ic.outputImageFile)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func newArtifactMetadata(ic *ImageCustomizerParameters) (*artifactmetadata.Metadata, error) {
+	metadata, err := artifactmetadata.New(artifactMetadataToolName, ToolVersion, ic.outputImageFile,
+		ic.outputImageFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	err = metadata.AddInput(artifactmetadata.InputRoleImage, ic.inputImageFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rpmSource := range ic.rpmsSources {
+		err = metadata.AddInput(artifactmetadata.InputRoleRpmSource, rpmSource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configDigest, err := getConfigDigest(ic.config)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata.SetParameter("configSha256", configDigest)
+	metadata.SetParameter("useBaseImageRpmRepos", strconv.FormatBool(ic.useBaseImageRpmRepos))
+	metadata.SetParameter("outputSplitPartitionsFormat", ic.outputSplitPartitionsFormat)
+	if ic.enableShrinkFilesystems {
+		metadata.SetParameter("shrinkFilesystems", "true")
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteArtifactMetadata(t *testing.T) {
+	dir := t.TempDir()
+	inputImageFile := filepath.Join(dir, "base.vhdx")
+	outputImageFile := filepath.Join(dir, "out.vhdx")
+
+	require.NoError(t, os.WriteFile(inputImageFile, []byte("base"), 0o644))
+	require.NoError(t, os.WriteFile(outputImageFile, []byte("image"), 0o644))
+
+	ic := &ImageCustomizerParameters{
+		inputImageFile:    inputImageFile,
+		rpmsSources:       []string{dir},
+		config:            &imagecustomizerapi.Config{},
+		outputImageFile:   outputImageFile,
+		outputImageFormat: "vhdx",
+	}
+
+	err := writeArtifactMetadata(ic)
+	require.NoError(t, err)
+
+	metadata, err := artifactmetadata.ReadSidecar(outputImageFile)
+	require.NoError(t, err)
+	assert.Equal(t, "imagecustomizer", metadata.Tool)
+	assert.Equal(t, "vhdx", metadata.Format)
+	assert.Equal(t, "out.vhdx", metadata.Artifact.Path)
+	assert.NoError(t, metadata.Verify(outputImageFile))
+
+	require.Len(t, metadata.Inputs, 2)
+	assert.Equal(t, artifactmetadata.InputRoleImage, metadata.Inputs[0].Role)
+	assert.Equal(t, inputImageFile, metadata.Inputs[0].Path)
+	assert.Equal(t, artifactmetadata.InputRoleRpmSource, metadata.Inputs[1].Role)
+	assert.Equal(t, "false", metadata.Parameters["useBaseImageRpmRepos"])
+	assert.Len(t, metadata.Parameters["configSha256"], 64)
+
+	artifacts, err := getBuildArtifacts(outputImageFile)
+	require.NoError(t, err)
+	assert.Len(t, artifacts, 2)
+}
+
+func TestWriteArtifactMetadataOutputIsDirectory(t *testing.T) {
+	outputDir := t.TempDir()
+
+	err := writeArtifactMetadata(&ImageCustomizerParameters{outputImageFile: outputDir})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, artifactmetadata.SidecarPath(outputDir))
+}
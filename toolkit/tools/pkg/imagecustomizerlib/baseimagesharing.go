@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"golang.org/x/sys/unix"
+)
+
+// BaseImageSharing is how a build's writeable copy of the base image is created from a raw base image (either a raw
+// input image or the input image cache's conversion of the input image).
+type BaseImageSharing string
+
+const (
+	// BaseImageSharingAuto clones the raw base image when the filesystem supports it, and copies it otherwise.
+	BaseImageSharingAuto BaseImageSharing = "auto"
+	// BaseImageSharingReflink clones the raw base image, and fails if the filesystem doesn't support it.
+	BaseImageSharingReflink BaseImageSharing = "reflink"
+	// BaseImageSharingCopy always copies the raw base image.
+	BaseImageSharingCopy BaseImageSharing = "copy"
+)
+
+// BaseImageSharingModes returns the supported base image sharing modes.
+func BaseImageSharingModes() []string {
+	return []string{string(BaseImageSharingAuto), string(BaseImageSharingReflink), string(BaseImageSharingCopy)}
+}
+
+func (s BaseImageSharing) IsValid() error {
+	switch s {
+	case "", BaseImageSharingAuto, BaseImageSharingReflink, BaseImageSharingCopy:
+		return nil
+
+	default:
+		return fmt.Errorf("invalid base image sharing mode (%s) (supported: %s)", s,
+			strings.Join(BaseImageSharingModes(), ", "))
+	}
+}
+
+// createRawBaseImage creates the build's writeable raw image from the input image.
+//
+// A reflink clone shares the base image's blocks until the build writes to them. So, any number of concurrent builds
+// can use the same base image, without each of them copying the whole image into its build directory. The base image
+// itself is never written to.
+func createRawBaseImage(inputImageFile string, rawImageFile string, sharing BaseImageSharing) error {
+	isRaw := strings.TrimLeft(filepath.Ext(inputImageFile), ".") == ImageFormatRaw
+
+	switch {
+	case sharing == BaseImageSharingCopy:
+
+	case !isRaw && sharing == BaseImageSharingReflink:
+		return fmt.Errorf("base image sharing mode (%s) requires a raw base image or an input image cache",
+			sharing)
+
+	case isRaw:
+		err := cloneFile(inputImageFile, rawImageFile)
+		if err == nil {
+			logger.Log.Infof("Cloned raw base image (%s): %s", inputImageFile, rawImageFile)
+			return nil
+		}
+
+		if sharing == BaseImageSharingReflink || !isCloneUnsupported(err) {
+			return fmt.Errorf("failed to clone raw base image (%s):\n%w", inputImageFile, err)
+		}
+
+		logger.Log.Debugf("Can't clone raw base image (%s), so it will be copied: %s", inputImageFile, err)
+	}
+
+	logger.Log.Infof("Creating raw base image: %s", rawImageFile)
+	err := shell.ExecuteLiveWithErr(1, "qemu-img", "convert", "-O", "raw", inputImageFile, rawImageFile)
+	if err != nil {
+		return fmt.Errorf("failed to convert image file to raw format:\n%w", err)
+	}
+
+	return nil
+}
+
+// cloneFile creates a reflink clone of the source file, which shares the source file's blocks until either of the
+// files is written to.
+func cloneFile(src string, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+	closeErr := dstFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return nil
+}
+
+// isCloneUnsupported returns true if the clone failed because the files' filesystem doesn't support reflinks, or
+// because the files are on different filesystems.
+func isCloneUnsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseImageSharingIsValid(t *testing.T) {
+	assert.NoError(t, BaseImageSharing("").IsValid())
+	assert.NoError(t, BaseImageSharingAuto.IsValid())
+	assert.NoError(t, BaseImageSharingReflink.IsValid())
+	assert.NoError(t, BaseImageSharingCopy.IsValid())
+	assert.EqualError(t, BaseImageSharing("snapshot").IsValid(),
+		"invalid base image sharing mode (snapshot) (supported: auto, reflink, copy)")
+}
+
+func TestCreateRawBaseImageReflinkRequiresRawImage(t *testing.T) {
+	buildDir := t.TempDir()
+
+	err := createRawBaseImage(filepath.Join(buildDir, "base.vhdx"), filepath.Join(buildDir, "image.raw"),
+		BaseImageSharingReflink)
+	assert.EqualError(t, err, "base image sharing mode (reflink) requires a raw base image or an input image cache")
+}
+
+func TestCloneFile(t *testing.T) {
+	testDir := t.TempDir()
+	src := filepath.Join(testDir, "base.raw")
+	dst := filepath.Join(testDir, "image.raw")
+
+	err := os.WriteFile(src, []byte("base image"), 0o444)
+	require.NoError(t, err)
+
+	// Whether reflinks are supported depends on the filesystem that the tests are run on.
+	err = cloneFile(src, dst)
+	if err != nil {
+		assert.True(t, isCloneUnsupported(err), "unexpected clone error: %s", err)
+		assert.NoFileExists(t, dst)
+		return
+	}
+
+	// The clone is writeable, and writing to it doesn't change the base image.
+	err = os.WriteFile(dst, []byte("customized"), 0o644)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(src)
+	require.NoError(t, err)
+	assert.Equal(t, "base image", string(content))
+}
+
+func TestCreateRawBaseImageReflinkUnsupported(t *testing.T) {
+	testDir := t.TempDir()
+	src := filepath.Join(testDir, "base.raw")
+	dst := filepath.Join(testDir, "image.raw")
+
+	err := os.WriteFile(src, []byte("base image"), 0o444)
+	require.NoError(t, err)
+
+	err = cloneFile(src, dst)
+	if err == nil {
+		t.Skip("filesystem supports reflinks")
+	}
+
+	err = createRawBaseImage(src, dst, BaseImageSharingReflink)
+	assert.ErrorContains(t, err, "failed to clone raw base image ("+src+")")
+}
@@ -159,6 +159,73 @@ func (b *BootCustomizer) UpdateKernelCommandLineArgs(defaultGrubFileVarName defa
 	return nil
 }
 
+// Sets the kernel command-line args, replacing all the existing args with the same names, and removes the args whose
+// names are in argsToRemove. Unlike AddKernelCommandLine, setting the same args again doesn't change the config.
+func (b *BootCustomizer) SetKernelCommandLineArgs(newArgs []string, argsToRemove []string) error {
+	names := append([]string(nil), argsToRemove...)
+	for _, arg := range newArgs {
+		names = append(names, imagecustomizerapi.KernelArgName(arg))
+	}
+
+	if b.isGrubMkconfig {
+		// The new args are added to the same variable as the extra command-line args. But, the old args are removed
+		// from both variables.
+		_, linuxArgs, _, err := GetDefaultGrubFileLinuxArgs(b.defaultGrubFileContent,
+			defaultGrubFileVarNameCmdlineLinux)
+		if err != nil {
+			return err
+		}
+
+		if !kernelCommandLineArgsAreSet(linuxArgs, names, nil) {
+			err = b.UpdateKernelCommandLineArgs(defaultGrubFileVarNameCmdlineLinux, names, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, linuxDefaultArgs, _, err := GetDefaultGrubFileLinuxArgs(b.defaultGrubFileContent,
+			defaultGrubFileVarNameCmdlineLinuxDefault)
+		if err != nil {
+			return err
+		}
+
+		if kernelCommandLineArgsAreSet(linuxDefaultArgs, names, newArgs) {
+			return nil
+		}
+
+		return b.UpdateKernelCommandLineArgs(defaultGrubFileVarNameCmdlineLinuxDefault, names, newArgs)
+	}
+
+	args, _, err := getLinuxCommandLineArgs(b.grubCfgContent, true /*requireKernelOpts*/)
+	if err != nil {
+		return err
+	}
+
+	if kernelCommandLineArgsAreSet(args, names, newArgs) {
+		return nil
+	}
+
+	return b.UpdateKernelCommandLineArgs(defaultGrubFileVarNameCmdlineLinuxDefault, names, newArgs)
+}
+
+// Returns true if the args that match the names are exactly the new args, so that replacing them wouldn't change
+// anything.
+func kernelCommandLineArgsAreSet(args []grubConfigLinuxArg, names []string, newArgs []string) bool {
+	foundArgs := findMatchingCommandLineArgs(args, names)
+	if len(foundArgs) != len(newArgs) {
+		return false
+	}
+
+	for i, arg := range foundArgs {
+		name, value, _ := strings.Cut(newArgs[i], "=")
+		if arg.ValueHasVarExpansion || arg.Name != name || arg.Value != value {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Makes changes to the /etc/default/grub file that are needed/useful for enabling verity.
 func (b *BootCustomizer) PrepareForVerity() error {
 	if b.isGrubMkconfig {
@@ -48,6 +48,44 @@ func TestBootCustomizerAddKernelCommandLine30(t *testing.T) {
 	checkDiffs30(t, b, "", expectedDefaultGrubFileDiff)
 }
 
+func TestBootCustomizerSetKernelCommandLineArgs20(t *testing.T) {
+	b := createBootCustomizerFor20(t)
+	err := b.SetKernelCommandLineArgs([]string{"console=ttyS0", "lockdown=none"}, []string{"rd.auto"})
+	assert.NoError(t, err)
+
+	expectedGrubCfdDiff := `22c22
+< 	linux $bootprefix/$mariner_linux       rd.auto=1 root=$rootdevice $mariner_cmdline lockdown=integrity sysctl.kernel.unprivileged_bpf_disabled=1 $systemd_cmdline   $kernelopts
+---
+> 	linux $bootprefix/$mariner_linux        root=$rootdevice $mariner_cmdline console=ttyS0 lockdown=none sysctl.kernel.unprivileged_bpf_disabled=1 $systemd_cmdline   $kernelopts
+`
+	checkDiffs20(t, b, expectedGrubCfdDiff, "")
+
+	// Do it again to make sure there aren't any changes.
+	err = b.SetKernelCommandLineArgs([]string{"console=ttyS0", "lockdown=none"}, []string{"rd.auto"})
+	assert.NoError(t, err)
+	checkDiffs20(t, b, expectedGrubCfdDiff, "")
+}
+
+func TestBootCustomizerSetKernelCommandLineArgs30(t *testing.T) {
+	b := createBootCustomizerFor30(t)
+	err := b.SetKernelCommandLineArgs([]string{"console=ttyS0", "lockdown=none"}, []string{"net.ifnames"})
+	assert.NoError(t, err)
+
+	expectedDefaultGrubFileDiff := `5,6c5,6
+< GRUB_CMDLINE_LINUX="      rd.auto=1 net.ifnames=0 lockdown=integrity "
+< GRUB_CMDLINE_LINUX_DEFAULT=" $kernelopts"
+---
+> GRUB_CMDLINE_LINUX="      rd.auto=1   "
+> GRUB_CMDLINE_LINUX_DEFAULT="  console=ttyS0 lockdown=none \$kernelopts"
+`
+	checkDiffs30(t, b, "", expectedDefaultGrubFileDiff)
+
+	// Do it again to make sure there aren't any changes.
+	err = b.SetKernelCommandLineArgs([]string{"console=ttyS0", "lockdown=none"}, []string{"net.ifnames"})
+	assert.NoError(t, err)
+	checkDiffs30(t, b, "", expectedDefaultGrubFileDiff)
+}
+
 func TestBootCustomizerSELinuxMode20(t *testing.T) {
 	b := createBootCustomizerFor20(t)
 	selinuxMode, err := b.getSELinuxModeFromGrub()
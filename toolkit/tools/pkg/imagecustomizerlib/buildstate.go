@@ -0,0 +1,408 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/buildstate"
+)
+
+const (
+	buildPhaseConvertInput  = "convertInput"
+	buildPhaseCustomizeOS   = "customizeOS"
+	buildPhaseConvertOutput = "convertOutput"
+)
+
+// The phases of a build, in the order they run.
+var buildPhases = []string{
+	buildPhaseConvertInput,
+	buildPhaseCustomizeOS,
+	buildPhaseConvertOutput,
+}
+
+// buildStateRecorder records the phases of a build into the build state store. And if the build was previously
+// interrupted, it skips the phases that had already completed.
+//
+// A nil recorder is valid and simply runs each phase.
+type buildStateRecorder struct {
+	store   *buildstate.Store
+	buildId string
+	ic      *ImageCustomizerParameters
+
+	// The phases that will be skipped, along with their recorded outputs.
+	skipPhases map[string]map[string]string
+	// The outputs of the last phase that completed (or was skipped).
+	lastOutputs map[string]string
+}
+
+func newBuildStateRecorder(options CustomizeImageOptions, ic *ImageCustomizerParameters,
+) (*buildStateRecorder, error) {
+	if options.BuildStateDir == "" {
+		return nil, nil
+	}
+
+	if options.BuildId == "" {
+		return nil, fmt.Errorf("a build ID must be provided when a build state directory is specified")
+	}
+
+	buildInputs, err := getBuildInputs(ic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate build inputs:\n%w", err)
+	}
+
+	store, err := buildstate.Open(options.BuildStateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := &buildStateRecorder{
+		store:   store,
+		buildId: options.BuildId,
+		ic:      ic,
+	}
+
+	previousBuild := store.Build(options.BuildId)
+	if previousBuild != nil {
+		if !maps.Equal(previousBuild.Inputs, buildInputs) {
+			store.Close()
+			return nil, fmt.Errorf("build (%s) was previously started with different inputs", options.BuildId)
+		}
+
+		recorder.skipPhases = getResumablePhases(previousBuild, isBuildResumable(ic))
+	}
+
+	_, err = store.Append(buildstate.Event{
+		BuildId: options.BuildId,
+		Type:    buildstate.EventTypeBuildStarted,
+		Inputs:  buildInputs,
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	if len(recorder.skipPhases) > 0 {
+		logger.Log.Infof("Resuming build (%s)", options.BuildId)
+	}
+
+	return recorder, nil
+}
+
+// getBuildInputs returns the values that identify a build. Restarting a build with the same ID is only allowed if
+// these match.
+func getBuildInputs(ic *ImageCustomizerParameters) (map[string]string, error) {
+	inputImageDigest, err := fileDigest(ic.inputImageFile)
+	if err != nil {
+		return nil, err
+	}
+
+	configDigest, err := getConfigDigest(ic.config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The config only holds the paths of the files it references, so their contents are hashed separately.
+	configFilesDigest, err := pathsDigest(ic.configPath, getConfigFilePaths(ic.config))
+	if err != nil {
+		return nil, err
+	}
+
+	rpmSourcesDigest, err := pathsDigest("", ic.rpmsSources)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := map[string]string{
+		"inputImage":                  inputImageDigest,
+		"config":                      "sha256:" + configDigest,
+		"configDir":                   ic.configPath,
+		"configFiles":                 configFilesDigest,
+		"toolVersion":                 ToolVersion,
+		"rpmSources":                  strings.Join(ic.rpmsSources, ":"),
+		"rpmSourcesContent":           rpmSourcesDigest,
+		"useBaseImageRpmRepos":        strconv.FormatBool(ic.useBaseImageRpmRepos),
+		"enableShrinkFilesystems":     strconv.FormatBool(ic.enableShrinkFilesystems),
+		"outputImageFile":             ic.outputImageFile,
+		"outputImageFormat":           ic.outputImageFormat,
+		"outputSplitPartitionsFormat": ic.outputSplitPartitionsFormat,
+		"outputPXEArtifactsDir":       ic.outputPXEArtifactsDir,
+	}
+	return inputs, nil
+}
+
+// isBuildResumable checks if all the state passed between phases is recorded in the build state store.
+// The iso builder keeps its state in memory and split partitions are written to files that aren't tracked. So, those
+// builds always start from the beginning.
+func isBuildResumable(ic *ImageCustomizerParameters) bool {
+	return !ic.inputIsIso && !ic.outputIsIso && ic.outputSplitPartitionsFormat == ""
+}
+
+// getResumablePhases finds the latest phase of the previous build whose outputs are still intact. That phase and all
+// the phases before it can be skipped.
+func getResumablePhases(previousBuild *buildstate.BuildState, resumable bool) map[string]map[string]string {
+	if !resumable {
+		return nil
+	}
+
+	for i := len(buildPhases) - 1; i >= 0; i-- {
+		phase := previousBuild.Phase(buildPhases[i])
+		if phase == nil || phase.Status != buildstate.PhaseStatusCompleted {
+			continue
+		}
+
+		if !verifyPhaseOutputs(phase.Outputs) {
+			logger.Log.Debugf("Outputs of phase (%s) have changed", phase.Name)
+			continue
+		}
+
+		skipPhases := make(map[string]map[string]string)
+		for _, phaseName := range buildPhases[:i+1] {
+			skipPhases[phaseName] = maps.Clone(previousBuild.Phase(phaseName).Outputs)
+		}
+		return skipPhases
+	}
+
+	return nil
+}
+
+func verifyPhaseOutputs(outputs map[string]string) bool {
+	for outputPath, expectedDigest := range outputs {
+		digest, err := fileDigest(outputPath)
+		if err != nil || digest != expectedDigest {
+			return false
+		}
+	}
+	return true
+}
+
+// runPhase runs a phase of the build (or skips it, if it was already completed) and records its state.
+func (r *buildStateRecorder) runPhase(phase string, run func() error) error {
+	phaseTimestamp, _ := timestamp.StartEvent(phase, nil)
+	defer timestamp.StopEvent(phaseTimestamp)
+
+	if r == nil {
+		return run()
+	}
+
+	if outputs, skip := r.skipPhases[phase]; skip {
+		logger.Log.Infof("Skipping completed build phase (%s)", phase)
+
+		r.restorePhaseState(phase, outputs)
+
+		_, err := r.store.Append(buildstate.Event{
+			BuildId: r.buildId,
+			Type:    buildstate.EventTypePhaseSkipped,
+			Phase:   phase,
+			Inputs:  r.lastOutputs,
+			Outputs: outputs,
+		})
+		if err != nil {
+			return err
+		}
+
+		r.lastOutputs = outputs
+		return nil
+	}
+
+	_, err := r.store.Append(buildstate.Event{
+		BuildId: r.buildId,
+		Type:    buildstate.EventTypePhaseStarted,
+		Phase:   phase,
+		Inputs:  r.lastOutputs,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = run()
+	if err != nil {
+		return err
+	}
+
+	outputs, err := r.getPhaseOutputs(phase)
+	if err != nil {
+		return fmt.Errorf("failed to calculate outputs of build phase (%s):\n%w", phase, err)
+	}
+
+	_, err = r.store.Append(buildstate.Event{
+		BuildId: r.buildId,
+		Type:    buildstate.EventTypePhaseCompleted,
+		Phase:   phase,
+		Inputs:  r.lastOutputs,
+		Outputs: outputs,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.lastOutputs = outputs
+	return nil
+}
+
+// getPhaseOutputs returns the digests of the files produced by a phase.
+func (r *buildStateRecorder) getPhaseOutputs(phase string) (map[string]string, error) {
+	outputPaths := []string(nil)
+
+	switch phase {
+	case buildPhaseConvertInput, buildPhaseCustomizeOS:
+		outputPaths = append(outputPaths, r.ic.rawImageFile)
+
+		if phase == buildPhaseCustomizeOS && r.ic.config.ChangeManifest != nil {
+			outputPaths = append(outputPaths,
+				filepath.Join(r.ic.outputImageDir, r.ic.outputImageBase+changeManifestFileSuffix))
+		}
+
+		if phase == buildPhaseCustomizeOS && r.ic.outputIsContainer {
+			for _, layer := range getContainerImageLayers(r.ic.buildDirAbs, r.ic.config.ContainerImage) {
+				outputPaths = append(outputPaths, layer.Path)
+			}
+		}
+
+		if phase == buildPhaseCustomizeOS && r.ic.outputIsWsl {
+			outputPaths = append(outputPaths, getWslRootfsFile(r.ic.buildDirAbs))
+		}
+
+	case buildPhaseConvertOutput:
+		if r.ic.outputImageFormat != "" {
+			outputPaths = append(outputPaths, r.ic.outputImageFile)
+		}
+	}
+
+	outputs := make(map[string]string)
+	for _, outputPath := range outputPaths {
+		exists, err := file.PathExists(outputPath)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		digest, err := fileDigest(outputPath)
+		if err != nil {
+			return nil, err
+		}
+		outputs[outputPath] = digest
+	}
+
+	return outputs, nil
+}
+
+// restorePhaseState restores the in-memory state that a skipped phase would have produced.
+func (r *buildStateRecorder) restorePhaseState(phase string, outputs map[string]string) {
+	switch phase {
+	case buildPhaseCustomizeOS:
+		// Customizing the partitions may have produced a new raw image file.
+		for outputPath := range outputs {
+			if filepath.Dir(outputPath) == r.ic.buildDirAbs {
+				r.ic.rawImageFile = outputPath
+			}
+		}
+	}
+}
+
+// finish records the result of the build and closes the store.
+func (r *buildStateRecorder) finish(buildErr error) {
+	if r == nil {
+		return
+	}
+
+	event := buildstate.Event{
+		BuildId: r.buildId,
+		Type:    buildstate.EventTypeBuildCompleted,
+	}
+	if buildErr != nil {
+		event.Type = buildstate.EventTypeBuildFailed
+		event.Error = buildErr.Error()
+	}
+
+	_, err := r.store.Append(event)
+	if err != nil {
+		logger.Log.Warnf("Failed to record build result:\n%v", err)
+	}
+
+	err = r.store.Close()
+	if err != nil {
+		logger.Log.Warnf("Failed to close build state store:\n%v", err)
+	}
+}
+
+// pathsDigest returns a digest of the contents of a list of files and directories. A directory's digest covers the
+// names, types and contents of everything within it. Relative paths are relative to baseDir. Paths that don't exist are
+// included as such, so that creating them changes the digest.
+func pathsDigest(baseDir string, paths []string) (string, error) {
+	hash := sha256.New()
+
+	for _, path := range paths {
+		fmt.Fprintf(hash, "path %s\n", path)
+
+		// Follow a symlink to the file or directory itself, since that is what the build reads.
+		fullPath, err := filepath.EvalSymlinks(file.GetAbsPathWithBase(baseDir, path))
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(hash, "missing %s\n", path)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve path (%s):\n%w", path, err)
+		}
+
+		err = filepath.WalkDir(fullPath, func(walkPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relativePath, err := filepath.Rel(fullPath, walkPath)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case entry.Type().IsRegular():
+				digest, err := file.GenerateSHA256(walkPath)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "file %s %s\n", relativePath, digest)
+
+			case entry.Type()&fs.ModeSymlink != 0:
+				target, err := os.Readlink(walkPath)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "symlink %s %s\n", relativePath, target)
+
+			default:
+				fmt.Fprintf(hash, "%s %s\n", entry.Type(), relativePath)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to calculate digest of (%s):\n%w", fullPath, err)
+		}
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func fileDigest(path string) (string, error) {
+	digest, err := file.GenerateSHA256(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate digest of file (%s):\n%w", path, err)
+	}
+	return "sha256:" + digest, nil
+}
@@ -0,0 +1,228 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/buildstate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createBuildStateTestParameters(t *testing.T, testTmpDir string) *ImageCustomizerParameters {
+	err := os.RemoveAll(testTmpDir)
+	require.NoError(t, err)
+
+	buildDir := filepath.Join(testTmpDir, "build")
+	err = os.MkdirAll(buildDir, os.ModePerm)
+	require.NoError(t, err)
+
+	inputImageFile := filepath.Join(testTmpDir, "input.vhdx")
+	err = file.Write("input", inputImageFile)
+	require.NoError(t, err)
+
+	ic, err := createImageCustomizerParameters(buildDir, inputImageFile, testTmpDir, &imagecustomizerapi.Config{},
+		true /*useBaseImageRpmRepos*/, nil /*rpmsSources*/, false /*enableShrinkFilesystems*/, "", "raw",
+		filepath.Join(testTmpDir, "out", "image.raw"), "" /*outputPXEArtifactsDir*/, nil /*pluginRegistry*/)
+	require.NoError(t, err)
+	return ic
+}
+
+// runTestBuild runs fake build phases that write the raw and output images. The build fails at the failPhase.
+func runTestBuild(t *testing.T, ic *ImageCustomizerParameters, options CustomizeImageOptions, failPhase string,
+) ([]string, error) {
+	recorder, err := newBuildStateRecorder(options, ic)
+	require.NoError(t, err)
+
+	ranPhases := []string(nil)
+	phases := map[string]func() error{
+		buildPhaseConvertInput: func() error {
+			return file.Write("raw", ic.rawImageFile)
+		},
+		buildPhaseCustomizeOS: func() error {
+			return file.Append("-customized", ic.rawImageFile)
+		},
+		buildPhaseConvertOutput: func() error {
+			err := os.MkdirAll(ic.outputImageDir, os.ModePerm)
+			if err != nil {
+				return err
+			}
+			return file.Copy(ic.rawImageFile, ic.outputImageFile)
+		},
+	}
+
+	for _, phase := range buildPhases {
+		err = recorder.runPhase(phase, func() error {
+			ranPhases = append(ranPhases, phase)
+			if phase == failPhase {
+				return fmt.Errorf("phase failed")
+			}
+			return phases[phase]()
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	recorder.finish(err)
+	return ranPhases, err
+}
+
+func TestBuildStateResume(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestBuildStateResume")
+	ic := createBuildStateTestParameters(t, testTmpDir)
+	options := CustomizeImageOptions{
+		BuildStateDir: filepath.Join(testTmpDir, "state"),
+		BuildId:       "build1",
+	}
+
+	ranPhases, err := runTestBuild(t, ic, options, buildPhaseConvertOutput)
+	assert.Error(t, err)
+	assert.Equal(t, buildPhases, ranPhases)
+
+	// Restarting the build skips the phases that completed.
+	ranPhases, err = runTestBuild(t, ic, options, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{buildPhaseConvertOutput}, ranPhases)
+
+	contents, err := file.Read(ic.outputImageFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "raw-customized", contents)
+
+	events, err := buildstate.ReadEvents(options.BuildStateDir)
+	require.NoError(t, err)
+	build := buildstate.BuildFromEvents(events, "build1")
+	if assert.NotNil(t, build) {
+		assert.Equal(t, buildstate.BuildStatusCompleted, build.Status)
+		assert.Equal(t, 2, build.Attempts)
+	}
+}
+
+func TestBuildStateResumeModifiedOutput(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestBuildStateResumeModifiedOutput")
+	ic := createBuildStateTestParameters(t, testTmpDir)
+	options := CustomizeImageOptions{
+		BuildStateDir: filepath.Join(testTmpDir, "state"),
+		BuildId:       "build1",
+	}
+
+	_, err := runTestBuild(t, ic, options, buildPhaseConvertOutput)
+	assert.Error(t, err)
+
+	// Simulate a crash part way through customizing the OS.
+	err = file.Write("raw-partial", ic.rawImageFile)
+	require.NoError(t, err)
+
+	ranPhases, err := runTestBuild(t, ic, options, "")
+	assert.NoError(t, err)
+	assert.Equal(t, buildPhases, ranPhases)
+}
+
+func TestBuildStateDifferentInputs(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestBuildStateDifferentInputs")
+	ic := createBuildStateTestParameters(t, testTmpDir)
+	options := CustomizeImageOptions{
+		BuildStateDir: filepath.Join(testTmpDir, "state"),
+		BuildId:       "build1",
+	}
+
+	_, err := runTestBuild(t, ic, options, buildPhaseConvertOutput)
+	assert.Error(t, err)
+
+	err = file.Write("changed", ic.inputImageFile)
+	require.NoError(t, err)
+
+	_, err = newBuildStateRecorder(options, ic)
+	assert.ErrorContains(t, err, "build (build1) was previously started with different inputs")
+}
+
+func TestBuildStateDifferentConfigFileContents(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestBuildStateDifferentConfigFileContents")
+	ic := createBuildStateTestParameters(t, testTmpDir)
+	options := CustomizeImageOptions{
+		BuildStateDir: filepath.Join(testTmpDir, "state"),
+		BuildId:       "build1",
+	}
+
+	scriptFile := filepath.Join(testTmpDir, "scripts", "setup.sh")
+	err := os.MkdirAll(filepath.Dir(scriptFile), os.ModePerm)
+	require.NoError(t, err)
+
+	err = file.Write("echo one", scriptFile)
+	require.NoError(t, err)
+
+	ic.config.Scripts.PostCustomization = []imagecustomizerapi.Script{{Path: "scripts/setup.sh"}}
+
+	_, err = runTestBuild(t, ic, options, buildPhaseConvertOutput)
+	assert.Error(t, err)
+
+	// The config is unchanged, but a file that it references was modified.
+	err = file.Write("echo two", scriptFile)
+	require.NoError(t, err)
+
+	_, err = newBuildStateRecorder(options, ic)
+	assert.ErrorContains(t, err, "build (build1) was previously started with different inputs")
+}
+
+func TestPathsDigest(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestPathsDigest")
+	err := os.RemoveAll(testTmpDir)
+	require.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Join(testTmpDir, "dir"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = file.Write("a", filepath.Join(testTmpDir, "dir", "a.txt"))
+	require.NoError(t, err)
+
+	digest, err := pathsDigest(testTmpDir, []string{"dir", "missing.txt"})
+	require.NoError(t, err)
+
+	sameDigest, err := pathsDigest(testTmpDir, []string{"dir", "missing.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, digest, sameDigest)
+
+	// Adding a file to a directory changes the digest.
+	err = file.Write("b", filepath.Join(testTmpDir, "dir", "b.txt"))
+	require.NoError(t, err)
+
+	addedDigest, err := pathsDigest(testTmpDir, []string{"dir", "missing.txt"})
+	require.NoError(t, err)
+	assert.NotEqual(t, digest, addedDigest)
+
+	// Creating a missing file changes the digest.
+	err = file.Write("", filepath.Join(testTmpDir, "missing.txt"))
+	require.NoError(t, err)
+
+	createdDigest, err := pathsDigest(testTmpDir, []string{"dir", "missing.txt"})
+	require.NoError(t, err)
+	assert.NotEqual(t, addedDigest, createdDigest)
+}
+
+func TestBuildStateMissingBuildId(t *testing.T) {
+	_, err := newBuildStateRecorder(CustomizeImageOptions{BuildStateDir: "state"}, nil)
+	assert.ErrorContains(t, err, "a build ID must be provided")
+}
+
+func TestBuildStateNilRecorder(t *testing.T) {
+	recorder, err := newBuildStateRecorder(CustomizeImageOptions{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, recorder)
+
+	ran := false
+	err = recorder.runPhase(buildPhaseCustomizeOS, func() error {
+		ran = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, ran)
+
+	recorder.finish(nil)
+}
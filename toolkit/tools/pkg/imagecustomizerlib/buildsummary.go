@@ -0,0 +1,273 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+)
+
+const (
+	// The version of the build summary's format.
+	buildSummaryVersion = 1
+
+	buildSummaryStatusSucceeded = "succeeded"
+	buildSummaryStatusFailed    = "failed"
+)
+
+// buildSummary is a machine-readable summary of a build, for CI pipelines to publish and gate on.
+type buildSummary struct {
+	Version           int                   `json:"version"`
+	Status            string                `json:"status"`
+	Error             string                `json:"error,omitempty"`
+	ToolVersion       string                `json:"toolVersion"`
+	ImageFile         string                `json:"imageFile"`
+	OutputImageFile   string                `json:"outputImageFile"`
+	OutputImageFormat string                `json:"outputImageFormat,omitempty"`
+	StartTime         time.Time             `json:"startTime"`
+	DurationSeconds   float64               `json:"durationSeconds"`
+	Artifacts         []buildArtifact       `json:"artifacts"`
+	Packages          *buildSummaryPackages `json:"packages,omitempty"`
+	Warnings          []string              `json:"warnings"`
+	Validation        *validationReport     `json:"validation,omitempty"`
+}
+
+// buildSummaryPackages counts the packages of the customized image.
+type buildSummaryPackages struct {
+	Total int `json:"total"`
+	// Changes counts the package changes. Only set if the config enables the change manifest.
+	Changes *buildSummaryPackageChanges `json:"changes,omitempty"`
+}
+
+type buildSummaryPackageChanges struct {
+	Installed int `json:"installed"`
+	Removed   int `json:"removed"`
+	Updated   int `json:"updated"`
+}
+
+// buildSummaryTracker collects a build's summary while the build runs.
+type buildSummaryTracker struct {
+	summaryFile     string
+	stepSummaryFile string
+	summary         buildSummary
+	warnings        *logger.WarningCollector
+}
+
+// newBuildSummaryTracker starts collecting the build's summary. Returns nil if the options don't request a summary.
+func newBuildSummaryTracker(options CustomizeImageOptions, imageFile string, outputImageFile string,
+	outputImageFormat string,
+) *buildSummaryTracker {
+	if options.SummaryFile == "" && options.StepSummaryFile == "" {
+		return nil
+	}
+
+	return &buildSummaryTracker{
+		summaryFile:     options.SummaryFile,
+		stepSummaryFile: options.StepSummaryFile,
+		summary: buildSummary{
+			Version:           buildSummaryVersion,
+			ToolVersion:       ToolVersion,
+			ImageFile:         imageFile,
+			OutputImageFile:   outputImageFile,
+			OutputImageFormat: outputImageFormat,
+			StartTime:         time.Now().UTC(),
+		},
+		warnings: logger.StartCollectingWarnings(),
+	}
+}
+
+// finish completes the build's summary and writes it. The image customizer parameters are nil if the build failed
+// before they were created.
+func (t *buildSummaryTracker) finish(ic *ImageCustomizerParameters, buildErr error) error {
+	summary := &t.summary
+	summary.DurationSeconds = time.Since(summary.StartTime).Seconds()
+	summary.Warnings = t.warnings.Stop()
+	summary.Artifacts = []buildArtifact{}
+
+	if ic != nil {
+		summary.OutputImageFile = ic.outputImageFile
+		summary.Packages = ic.summaryPackages
+
+		if len(ic.validationResults) > 0 {
+			summary.Validation = &validationReport{
+				Passed: !slices.ContainsFunc(ic.validationResults,
+					func(r validationCheckResult) bool { return !r.Passed }),
+				Checks: ic.validationResults,
+			}
+		}
+	}
+
+	if buildErr != nil {
+		summary.Status = buildSummaryStatusFailed
+		summary.Error = buildErr.Error()
+	} else {
+		summary.Status = buildSummaryStatusSucceeded
+
+		artifacts, err := getBuildArtifacts(summary.OutputImageFile)
+		if err != nil {
+			return err
+		}
+		summary.Artifacts = artifacts
+	}
+
+	if t.summaryFile != "" {
+		err := jsonutils.WriteJSONFile(t.summaryFile, summary)
+		if err != nil {
+			return fmt.Errorf("failed to write build summary (%s):\n%w", t.summaryFile, err)
+		}
+
+		logger.Log.Infof("Build summary: %s", t.summaryFile)
+	}
+
+	if t.stepSummaryFile != "" {
+		err := appendStepSummary(t.stepSummaryFile, formatStepSummary(summary))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getBuildSummaryPackages counts the packages of the customized image.
+func getBuildSummaryPackages(buildDir string, rawImageFile string, changeManifest *changemanifest.Manifest,
+) (*buildSummaryPackages, error) {
+	imageConnection, err := connectToExistingImage(rawImageFile, buildDir, "imageroot", true)
+	if err != nil {
+		return nil, err
+	}
+	defer imageConnection.Close()
+
+	installedPackages, err := getInstalledPackages(imageConnection.Chroot())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed packages:\n%w", err)
+	}
+
+	err = imageConnection.CleanClose()
+	if err != nil {
+		return nil, err
+	}
+
+	packages := &buildSummaryPackages{
+		Total: len(installedPackages),
+	}
+
+	if changeManifest != nil {
+		packages.Changes = &buildSummaryPackageChanges{
+			Installed: len(changeManifest.Packages.Installed),
+			Removed:   len(changeManifest.Packages.Removed),
+			Updated:   len(changeManifest.Packages.Updated),
+		}
+	}
+
+	return packages, nil
+}
+
+// appendStepSummary appends to the step summary file, rather than replacing it, since CI systems (e.g. GitHub
+// Actions' $GITHUB_STEP_SUMMARY) share the file between all the commands of a step.
+func appendStepSummary(stepSummaryFile string, stepSummary string) error {
+	summaryFile, err := os.OpenFile(stepSummaryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open step summary file (%s):\n%w", stepSummaryFile, err)
+	}
+	defer summaryFile.Close()
+
+	// Write the summary in a single write, so that it isn't interleaved with the summaries of concurrent builds.
+	_, err = summaryFile.WriteString(stepSummary)
+	if err != nil {
+		return fmt.Errorf("failed to write step summary file (%s):\n%w", stepSummaryFile, err)
+	}
+
+	err = summaryFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write step summary file (%s):\n%w", stepSummaryFile, err)
+	}
+
+	return nil
+}
+
+// formatStepSummary formats the build summary as markdown.
+func formatStepSummary(summary *buildSummary) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "### Image customization %s: %s\n\n", summary.Status,
+		markdownCode(filepath.Base(summary.OutputImageFile)))
+
+	sb.WriteString("| | |\n|---|---|\n")
+	fmt.Fprintf(&sb, "| Input image | %s |\n", markdownCode(summary.ImageFile))
+	fmt.Fprintf(&sb, "| Output image | %s |\n", markdownCode(summary.OutputImageFile))
+	if summary.OutputImageFormat != "" {
+		fmt.Fprintf(&sb, "| Format | %s |\n", summary.OutputImageFormat)
+	}
+	duration := time.Duration(summary.DurationSeconds * float64(time.Second))
+	fmt.Fprintf(&sb, "| Duration | %s |\n", duration.Round(time.Second))
+	if summary.Packages != nil {
+		packages := fmt.Sprintf("%d", summary.Packages.Total)
+		if changes := summary.Packages.Changes; changes != nil {
+			packages += fmt.Sprintf(" (%d installed, %d removed, %d updated)", changes.Installed, changes.Removed,
+				changes.Updated)
+		}
+		fmt.Fprintf(&sb, "| Packages | %s |\n", packages)
+	}
+	fmt.Fprintf(&sb, "| Tool version | %s |\n", markdownTableCell(summary.ToolVersion))
+
+	if summary.Error != "" {
+		fmt.Fprintf(&sb, "\n#### Error\n\n```\n%s\n```\n", strings.ReplaceAll(summary.Error, "```", "'''"))
+	}
+
+	if len(summary.Artifacts) > 0 {
+		sb.WriteString("\n#### Artifacts\n\n| File | Size | SHA-256 |\n|---|---|---|\n")
+		for _, artifact := range summary.Artifacts {
+			fmt.Fprintf(&sb, "| %s | %s | %s |\n", markdownCode(filepath.Base(artifact.Path)),
+				humanReadableDiskSize(artifact.Size), markdownCode(artifact.Sha256))
+		}
+	}
+
+	if summary.Validation != nil {
+		result := "passed"
+		if !summary.Validation.Passed {
+			result = "failed"
+		}
+
+		fmt.Fprintf(&sb, "\n#### Validation (%s)\n\n| Check | Target | Result | Message |\n|---|---|---|---|\n", result)
+		for _, check := range summary.Validation.Checks {
+			result := "passed"
+			if !check.Passed {
+				result = "**failed**"
+			}
+
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", check.Check, markdownTableCell(check.Target), result,
+				markdownTableCell(check.Message))
+		}
+	}
+
+	if len(summary.Warnings) > 0 {
+		fmt.Fprintf(&sb, "\n#### Warnings (%d)\n\n", len(summary.Warnings))
+		for _, warning := range summary.Warnings {
+			fmt.Fprintf(&sb, "- %s\n", markdownTableCell(warning))
+		}
+	}
+
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// markdownCode formats the text as inline code that can be used within a table cell.
+func markdownCode(text string) string {
+	return "`" + markdownTableCell(strings.ReplaceAll(text, "`", "'")) + "`"
+}
+
+// markdownTableCell flattens the text onto a single line and escapes the table separators within it.
+func markdownTableCell(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	return strings.ReplaceAll(text, "|", "\\|")
+}
@@ -0,0 +1,170 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readBuildSummary(t *testing.T, summaryFile string) buildSummary {
+	summaryJson, err := os.ReadFile(summaryFile)
+	require.NoError(t, err)
+
+	var summary buildSummary
+	err = json.Unmarshal(summaryJson, &summary)
+	require.NoError(t, err)
+
+	return summary
+}
+
+func TestNewBuildSummaryTrackerNotRequested(t *testing.T) {
+	tracker := newBuildSummaryTracker(CustomizeImageOptions{}, "base.vhdx", "out.vhdx", "vhdx")
+	assert.Nil(t, tracker)
+}
+
+func TestBuildSummaryTrackerSucceeded(t *testing.T) {
+	dir := t.TempDir()
+	outputImageFile := filepath.Join(dir, "out.vhdx")
+	summaryFile := filepath.Join(dir, "summary.json")
+	stepSummaryFile := filepath.Join(dir, "step-summary.md")
+
+	err := os.WriteFile(outputImageFile, []byte("image"), 0o644)
+	require.NoError(t, err)
+
+	err = os.WriteFile(stepSummaryFile, []byte("previous step\n"), 0o644)
+	require.NoError(t, err)
+
+	options := CustomizeImageOptions{
+		SummaryFile:     summaryFile,
+		StepSummaryFile: stepSummaryFile,
+	}
+	tracker := newBuildSummaryTracker(options, "base.vhdx", "out.vhdx", "vhdx")
+	require.NotNil(t, tracker)
+
+	logger.Log.Warnf("low free disk space")
+
+	ic := &ImageCustomizerParameters{
+		outputImageFile: outputImageFile,
+		summaryPackages: &buildSummaryPackages{
+			Total:   100,
+			Changes: &buildSummaryPackageChanges{Installed: 2},
+		},
+		validationResults: []validationCheckResult{
+			newValidationCheckResult(validationCheckRequiredFile, "/etc/os-release", ""),
+		},
+	}
+
+	err = tracker.finish(ic, nil)
+	require.NoError(t, err)
+
+	summary := readBuildSummary(t, summaryFile)
+	assert.Equal(t, buildSummaryVersion, summary.Version)
+	assert.Equal(t, buildSummaryStatusSucceeded, summary.Status)
+	assert.Empty(t, summary.Error)
+	assert.Equal(t, "base.vhdx", summary.ImageFile)
+	assert.Equal(t, outputImageFile, summary.OutputImageFile)
+	assert.Equal(t, "vhdx", summary.OutputImageFormat)
+	assert.Equal(t, []string{"low free disk space"}, summary.Warnings)
+	assert.Equal(t, ic.summaryPackages, summary.Packages)
+	assert.Equal(t, &validationReport{Passed: true, Checks: ic.validationResults}, summary.Validation)
+	assert.Equal(t, []buildArtifact{{
+		Path:   outputImageFile,
+		Size:   5,
+		Sha256: "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d",
+	}}, summary.Artifacts)
+
+	stepSummary, err := os.ReadFile(stepSummaryFile)
+	require.NoError(t, err)
+	assert.Regexp(t, "^previous step\n### Image customization succeeded: `out.vhdx`\n", string(stepSummary))
+}
+
+func TestBuildSummaryTrackerFailed(t *testing.T) {
+	summaryFile := filepath.Join(t.TempDir(), "summary.json")
+
+	tracker := newBuildSummaryTracker(CustomizeImageOptions{SummaryFile: summaryFile}, "base.vhdx", "out.vhdx", "")
+	require.NotNil(t, tracker)
+
+	err := tracker.finish(nil, fmt.Errorf("invalid image config"))
+	require.NoError(t, err)
+
+	summary := readBuildSummary(t, summaryFile)
+	assert.Equal(t, buildSummaryStatusFailed, summary.Status)
+	assert.Equal(t, "invalid image config", summary.Error)
+	assert.Equal(t, "out.vhdx", summary.OutputImageFile)
+	assert.Empty(t, summary.Artifacts)
+	assert.Nil(t, summary.Packages)
+	assert.Nil(t, summary.Validation)
+}
+
+func TestFormatStepSummary(t *testing.T) {
+	summary := &buildSummary{
+		Status:            buildSummaryStatusFailed,
+		Error:             "image validation failed",
+		ToolVersion:       "1.0.0",
+		ImageFile:         "/images/base.vhdx",
+		OutputImageFile:   "/out/web.vhdx",
+		OutputImageFormat: "vhdx",
+		DurationSeconds:   61.4,
+		Artifacts: []buildArtifact{
+			{Path: "/out/web.vhdx", Size: 2048, Sha256: "abc"},
+		},
+		Packages: &buildSummaryPackages{
+			Total:   200,
+			Changes: &buildSummaryPackageChanges{Installed: 3, Removed: 1, Updated: 2},
+		},
+		Warnings: []string{"low free disk space"},
+		Validation: &validationReport{
+			Passed: false,
+			Checks: []validationCheckResult{
+				newValidationCheckResult(validationCheckEnabledService, "sshd", ""),
+				newValidationCheckResult(validationCheckRequiredFile, "/etc/a|b", "file doesn't\nexist"),
+			},
+		},
+	}
+
+	expected := "### Image customization failed: `web.vhdx`\n" +
+		"\n" +
+		"| | |\n" +
+		"|---|---|\n" +
+		"| Input image | `/images/base.vhdx` |\n" +
+		"| Output image | `/out/web.vhdx` |\n" +
+		"| Format | vhdx |\n" +
+		"| Duration | 1m1s |\n" +
+		"| Packages | 200 (3 installed, 1 removed, 2 updated) |\n" +
+		"| Tool version | 1.0.0 |\n" +
+		"\n" +
+		"#### Error\n" +
+		"\n" +
+		"```\n" +
+		"image validation failed\n" +
+		"```\n" +
+		"\n" +
+		"#### Artifacts\n" +
+		"\n" +
+		"| File | Size | SHA-256 |\n" +
+		"|---|---|---|\n" +
+		"| `web.vhdx` | 2 KiB | `abc` |\n" +
+		"\n" +
+		"#### Validation (failed)\n" +
+		"\n" +
+		"| Check | Target | Result | Message |\n" +
+		"|---|---|---|---|\n" +
+		"| enabledService | sshd | passed |  |\n" +
+		"| requiredFile | /etc/a\\|b | **failed** | file doesn't exist |\n" +
+		"\n" +
+		"#### Warnings (1)\n" +
+		"\n" +
+		"- low free disk space\n" +
+		"\n"
+
+	assert.Equal(t, expected, formatStepSummary(summary))
+}
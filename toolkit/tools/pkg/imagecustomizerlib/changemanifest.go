@@ -0,0 +1,272 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+)
+
+const (
+	changeManifestFileSuffix = ".changes.json"
+
+	// Format of each line of the installed packages list. The key includes the arch so that multilib packages are
+	// tracked separately.
+	installedPackagesQueryFormat = "%{NAME}.%{ARCH}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\n"
+)
+
+var (
+	// Directories that contain either virtual filesystems or temporary mounts created by the customizer.
+	changeManifestExcludedDirs = []string{
+		"/dev",
+		"/proc",
+		"/run",
+		"/sys",
+		rpmsMountParentDirInChroot,
+	}
+)
+
+// fileSnapshotEntry contains the file metadata used to detect if a file was changed.
+type fileSnapshotEntry struct {
+	mode       fs.FileMode
+	size       int64
+	modTime    int64
+	uid        uint32
+	gid        uint32
+	linkTarget string
+}
+
+// changeTracker records the state of the OS before customization, so that it can be compared against the state after
+// customization.
+type changeTracker struct {
+	files    map[string]fileSnapshotEntry
+	packages map[string]string
+}
+
+func newChangeTracker(imageChroot *safechroot.Chroot) (*changeTracker, error) {
+	logger.Log.Infof("Recording OS state for change manifest")
+
+	files, err := takeFileSnapshot(imageChroot.RootDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to record files:\n%w", err)
+	}
+
+	packages, err := getInstalledPackages(imageChroot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record installed packages:\n%w", err)
+	}
+
+	tracker := &changeTracker{
+		files:    files,
+		packages: packages,
+	}
+	return tracker, nil
+}
+
+// createManifest compares the current state of the OS against the recorded state.
+func (t *changeTracker) createManifest(imageChroot *safechroot.Chroot) (*changemanifest.Manifest, error) {
+	logger.Log.Infof("Creating change manifest")
+
+	files, err := takeFileSnapshot(imageChroot.RootDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to record files:\n%w", err)
+	}
+
+	packages, err := getInstalledPackages(imageChroot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record installed packages:\n%w", err)
+	}
+
+	manifest := &changemanifest.Manifest{
+		Files:    diffFileSnapshots(t.files, files),
+		Packages: diffInstalledPackages(t.packages, packages),
+		// Filled in by the caller, since they come from the config.
+		InjectedFiles: []changemanifest.InjectedFile{},
+		Scripts:       []changemanifest.Script{},
+	}
+
+	logger.Log.Infof("Files added: %d, modified: %d, removed: %d", len(manifest.Files.Added),
+		len(manifest.Files.Modified), len(manifest.Files.Removed))
+	logger.Log.Infof("Packages installed: %d, removed: %d, updated: %d", len(manifest.Packages.Installed),
+		len(manifest.Packages.Removed), len(manifest.Packages.Updated))
+
+	return manifest, nil
+}
+
+func takeFileSnapshot(rootDir string) (map[string]fileSnapshotEntry, error) {
+	snapshot := make(map[string]fileSnapshotEntry)
+
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+
+		imagePath := filepath.Join("/", relPath)
+		if imagePath == "/" {
+			return nil
+		}
+
+		for _, excludedDir := range changeManifestExcludedDirs {
+			if imagePath == excludedDir {
+				return filepath.SkipDir
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := fileSnapshotEntry{
+			mode: info.Mode(),
+		}
+
+		// A directory's size and modification time change whenever one of its entries change. So, ignore them to
+		// avoid reporting every parent directory of a changed file.
+		if !info.IsDir() {
+			entry.size = info.Size()
+			entry.modTime = info.ModTime().UnixNano()
+		}
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.uid = stat.Uid
+			entry.gid = stat.Gid
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			entry.linkTarget, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		snapshot[imagePath] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+func diffFileSnapshots(before map[string]fileSnapshotEntry, after map[string]fileSnapshotEntry,
+) changemanifest.Files {
+	files := changemanifest.Files{
+		Added:    []string{},
+		Modified: []string{},
+		Removed:  []string{},
+	}
+
+	for path, afterEntry := range after {
+		beforeEntry, exists := before[path]
+		switch {
+		case !exists:
+			files.Added = append(files.Added, path)
+
+		case beforeEntry != afterEntry:
+			files.Modified = append(files.Modified, path)
+		}
+	}
+
+	for path := range before {
+		if _, exists := after[path]; !exists {
+			files.Removed = append(files.Removed, path)
+		}
+	}
+
+	sort.Strings(files.Added)
+	sort.Strings(files.Modified)
+	sort.Strings(files.Removed)
+
+	return files
+}
+
+func getInstalledPackages(imageChroot *safechroot.Chroot) (map[string]string, error) {
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		var err error
+		stdout, _, err = shell.Execute("rpm", "-qa", "--queryformat", installedPackagesQueryFormat)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseInstalledPackages(stdout), nil
+}
+
+func parseInstalledPackages(rpmOutput string) map[string]string {
+	packages := make(map[string]string)
+
+	for _, line := range strings.Split(rpmOutput, "\n") {
+		name, version, found := strings.Cut(strings.TrimSpace(line), "\t")
+		if !found {
+			continue
+		}
+
+		packages[name] = version
+	}
+
+	return packages
+}
+
+func diffInstalledPackages(before map[string]string, after map[string]string) changemanifest.Packages {
+	packages := changemanifest.Packages{
+		Installed: []changemanifest.Package{},
+		Removed:   []changemanifest.Package{},
+		Updated:   []changemanifest.PackageUpdate{},
+		// Filled in by the caller, since it isn't possible to tell from the installed packages why a package was
+		// removed.
+		OrphansRemoved: []string{},
+	}
+
+	for name, afterVersion := range after {
+		beforeVersion, exists := before[name]
+		switch {
+		case !exists:
+			packages.Installed = append(packages.Installed, changemanifest.Package{Name: name, Version: afterVersion})
+
+		case beforeVersion != afterVersion:
+			packages.Updated = append(packages.Updated, changemanifest.PackageUpdate{
+				Name:       name,
+				OldVersion: beforeVersion,
+				NewVersion: afterVersion,
+			})
+		}
+	}
+
+	for name, beforeVersion := range before {
+		if _, exists := after[name]; !exists {
+			packages.Removed = append(packages.Removed, changemanifest.Package{Name: name, Version: beforeVersion})
+		}
+	}
+
+	sort.Slice(packages.Installed, func(i, j int) bool {
+		return packages.Installed[i].Name < packages.Installed[j].Name
+	})
+	sort.Slice(packages.Removed, func(i, j int) bool {
+		return packages.Removed[i].Name < packages.Removed[j].Name
+	})
+	sort.Slice(packages.Updated, func(i, j int) bool {
+		return packages.Updated[i].Name < packages.Updated[j].Name
+	})
+
+	return packages
+}
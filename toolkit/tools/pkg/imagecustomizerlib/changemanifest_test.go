@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSnapshotDiff(t *testing.T) {
+	rootDir := filepath.Join(tmpDir, "TestFileSnapshotDiff")
+	defer os.RemoveAll(rootDir)
+
+	err := os.MkdirAll(filepath.Join(rootDir, "etc"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(rootDir, "proc"), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = file.Write("a", filepath.Join(rootDir, "etc/modified"))
+	assert.NoError(t, err)
+	err = file.Write("a", filepath.Join(rootDir, "etc/removed"))
+	assert.NoError(t, err)
+	err = file.Write("a", filepath.Join(rootDir, "etc/unchanged"))
+	assert.NoError(t, err)
+
+	before, err := takeFileSnapshot(rootDir)
+	assert.NoError(t, err)
+
+	err = file.Write("ab", filepath.Join(rootDir, "etc/modified"))
+	assert.NoError(t, err)
+	err = os.Remove(filepath.Join(rootDir, "etc/removed"))
+	assert.NoError(t, err)
+	err = file.Write("a", filepath.Join(rootDir, "etc/added"))
+	assert.NoError(t, err)
+	err = os.Symlink("added", filepath.Join(rootDir, "etc/link"))
+	assert.NoError(t, err)
+	err = file.Write("1", filepath.Join(rootDir, "proc/ignored"))
+	assert.NoError(t, err)
+
+	// Ensure the directory's modification time changes, to check that it isn't reported.
+	err = os.Chtimes(filepath.Join(rootDir, "etc"), time.Now(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	after, err := takeFileSnapshot(rootDir)
+	assert.NoError(t, err)
+
+	files := diffFileSnapshots(before, after)
+	assert.Equal(t, []string{"/etc/added", "/etc/link"}, files.Added)
+	assert.Equal(t, []string{"/etc/modified"}, files.Modified)
+	assert.Equal(t, []string{"/etc/removed"}, files.Removed)
+}
+
+func TestInstalledPackagesDiff(t *testing.T) {
+	before := parseInstalledPackages("bash.x86_64\t0:5.2.15-1.azl3\n" +
+		"openssl.x86_64\t0:3.3.0-1.azl3\n" +
+		"vim.x86_64\t0:9.0.2190-1.azl3\n")
+	after := parseInstalledPackages("bash.x86_64\t0:5.2.15-1.azl3\n" +
+		"openssl.x86_64\t0:3.3.2-1.azl3\n" +
+		"nginx.x86_64\t1:1.25.4-1.azl3\n")
+
+	packages := diffInstalledPackages(before, after)
+	assert.Equal(t, []changemanifest.Package{{Name: "nginx.x86_64", Version: "1:1.25.4-1.azl3"}}, packages.Installed)
+	assert.Equal(t, []changemanifest.Package{{Name: "vim.x86_64", Version: "0:9.0.2190-1.azl3"}}, packages.Removed)
+	assert.Equal(t, []changemanifest.PackageUpdate{
+		{Name: "openssl.x86_64", OldVersion: "0:3.3.0-1.azl3", NewVersion: "0:3.3.2-1.azl3"},
+	}, packages.Updated)
+}
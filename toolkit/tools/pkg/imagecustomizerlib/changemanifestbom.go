@@ -0,0 +1,231 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+)
+
+// createInjectedFilesBom lists the files that the config copies into the OS, outside of packages, along with the
+// digests of their sources.
+func createInjectedFilesBom(baseConfigPath string, osConfig *imagecustomizerapi.OS, imageRootDir string,
+) ([]changemanifest.InjectedFile, error) {
+	injectedFiles := []changemanifest.InjectedFile{}
+	if osConfig == nil {
+		return injectedFiles, nil
+	}
+
+	for _, dirConfig := range osConfig.AdditionalDirs {
+		absSourceDir := file.GetAbsPathWithBase(baseConfigPath, dirConfig.Source)
+
+		childFilePermissions := fs.FileMode(defaultFilePermissions)
+		if dirConfig.ChildFilePermissions != nil {
+			childFilePermissions = fs.FileMode(*dirConfig.ChildFilePermissions)
+		}
+
+		err := filepath.WalkDir(absSourceDir, func(sourcePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(absSourceDir, sourcePath)
+			if err != nil {
+				return err
+			}
+
+			injectedFile, err := newInjectedFileFromSource(baseConfigPath, sourcePath,
+				path.Join(dirConfig.Destination, filepath.ToSlash(relPath)), childFilePermissions)
+			if err != nil {
+				return err
+			}
+
+			injectedFiles = append(injectedFiles, injectedFile)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list additional directory (%s):\n%w", dirConfig.Source, err)
+		}
+	}
+
+	for _, additionalFile := range osConfig.AdditionalFiles {
+		var mode fs.FileMode
+		if additionalFile.Permissions != nil {
+			mode = fs.FileMode(*additionalFile.Permissions)
+		} else {
+			// The permissions weren't specified. So, use the permissions the file was given.
+			stat, err := os.Stat(filepath.Join(imageRootDir, additionalFile.Destination))
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat additional file (%s):\n%w", additionalFile.Destination, err)
+			}
+			mode = stat.Mode().Perm()
+		}
+
+		var injectedFile changemanifest.InjectedFile
+		switch {
+		case additionalFile.Template:
+			// The digest is of the rendered file.
+			content, err := renderAdditionalFileTemplate(baseConfigPath, additionalFile, osConfig.TemplateVariables)
+			if err != nil {
+				return nil, err
+			}
+
+			if additionalFile.Source != "" {
+				injectedFile, err = newInjectedFileFromSource(baseConfigPath,
+					file.GetAbsPathWithBase(baseConfigPath, additionalFile.Source), additionalFile.Destination, mode)
+				if err != nil {
+					return nil, err
+				}
+				injectedFile.Sha256 = sha256String(content)
+			} else {
+				injectedFile = newInjectedFileFromContent(content, additionalFile.Destination, mode)
+			}
+
+		case additionalFile.Source != "":
+			var err error
+			injectedFile, err = newInjectedFileFromSource(baseConfigPath,
+				file.GetAbsPathWithBase(baseConfigPath, additionalFile.Source), additionalFile.Destination, mode)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			injectedFile = newInjectedFileFromContent(*additionalFile.Content, additionalFile.Destination, mode)
+		}
+
+		injectedFiles = append(injectedFiles, injectedFile)
+	}
+
+	cloudInit := osConfig.CloudInit
+	if cloudInit != nil && cloudInit.NoCloud != nil &&
+		cloudInit.NoCloud.SeedLocation != imagecustomizerapi.CloudInitSeedLocationIso {
+		for _, seedFile := range getCloudInitSeedFiles(cloudInit.NoCloud) {
+			destination := path.Join(cloudInitNoCloudSeedDir, seedFile.name)
+
+			var injectedFile changemanifest.InjectedFile
+			switch {
+			case seedFile.seedFile == nil:
+				if seedFile.name == cloudInitNetworkConfigFileName {
+					continue
+				}
+				injectedFile = newInjectedFileFromContent("", destination, seedFile.permissions)
+
+			case seedFile.seedFile.Source != "":
+				var err error
+				injectedFile, err = newInjectedFileFromSource(baseConfigPath,
+					file.GetAbsPathWithBase(baseConfigPath, seedFile.seedFile.Source), destination,
+					seedFile.permissions)
+				if err != nil {
+					return nil, err
+				}
+
+			default:
+				injectedFile = newInjectedFileFromContent(*seedFile.seedFile.Content, destination,
+					seedFile.permissions)
+			}
+
+			injectedFiles = append(injectedFiles, injectedFile)
+		}
+	}
+
+	return injectedFiles, nil
+}
+
+// createScriptsBom lists the scripts that the config runs, in the order they are run, along with the digests of their
+// bodies.
+func createScriptsBom(baseConfigPath string, scripts imagecustomizerapi.Scripts) ([]changemanifest.Script, error) {
+	phases := []struct {
+		name    string
+		scripts []imagecustomizerapi.Script
+	}{
+		{"postPackageInstall", scripts.PostPackageInstall},
+		{"postConfig", scripts.PostConfig},
+		{"postCustomization", scripts.PostCustomization},
+		{"finalizeCustomization", scripts.FinalizeCustomization},
+		{"finalizeOutsideChroot", scripts.FinalizeOutsideChroot},
+	}
+
+	scriptsBom := []changemanifest.Script{}
+	for _, phase := range phases {
+		for _, i := range orderScripts(phase.scripts) {
+			script := phase.scripts[i]
+
+			entry := changemanifest.Script{
+				Phase:       phase.name,
+				Name:        createScriptLogName(i, script, phase.name),
+				Source:      script.Path,
+				Interpreter: script.Interpreter,
+			}
+
+			if script.Container != nil {
+				entry.ContainerImage = script.Container.Image
+			}
+
+			if script.Path != "" {
+				scriptPath := filepath.Join(baseConfigPath, script.Path)
+				digest, err := file.GenerateSHA256(scriptPath)
+				if err != nil {
+					return nil, fmt.Errorf("failed to hash script (%s):\n%w", scriptPath, err)
+				}
+				entry.Sha256 = digest
+			} else {
+				entry.Sha256 = sha256String(script.Content)
+			}
+
+			scriptsBom = append(scriptsBom, entry)
+		}
+	}
+
+	return scriptsBom, nil
+}
+
+func newInjectedFileFromSource(baseConfigPath string, absSourcePath string, destination string, mode fs.FileMode,
+) (changemanifest.InjectedFile, error) {
+	digest, err := file.GenerateSHA256(absSourcePath)
+	if err != nil {
+		return changemanifest.InjectedFile{}, fmt.Errorf("failed to hash file (%s):\n%w", absSourcePath, err)
+	}
+
+	// Record the source relative to the config file's directory, so that the manifest doesn't depend on where the
+	// config was checked out.
+	source := absSourcePath
+	relSource, err := filepath.Rel(baseConfigPath, absSourcePath)
+	if err == nil && filepath.IsLocal(relSource) {
+		source = filepath.ToSlash(relSource)
+	}
+
+	injectedFile := changemanifest.InjectedFile{
+		Source:      source,
+		Sha256:      digest,
+		Destination: destination,
+		Mode:        fmt.Sprintf("%04o", mode.Perm()),
+	}
+	return injectedFile, nil
+}
+
+func newInjectedFileFromContent(content string, destination string, mode fs.FileMode) changemanifest.InjectedFile {
+	return changemanifest.InjectedFile{
+		Sha256:      sha256String(content),
+		Destination: destination,
+		Mode:        fmt.Sprintf("%04o", mode.Perm()),
+	}
+}
+
+func sha256String(content string) string {
+	digest := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateInjectedFilesBom(t *testing.T) {
+	imageRootDir := t.TempDir()
+
+	// The permissions of additional files without 'permissions' are read from the image.
+	err := os.MkdirAll(filepath.Join(imageRootDir, "etc"), 0o755)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = os.WriteFile(filepath.Join(imageRootDir, "etc/a.txt"), []byte{}, 0o640)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	content := "hello"
+	permissions := imagecustomizerapi.FilePermissions(0o600)
+	osConfig := &imagecustomizerapi.OS{
+		AdditionalDirs: imagecustomizerapi.DirConfigList{
+			{Source: "dirs/a", Destination: "/"},
+		},
+		AdditionalFiles: imagecustomizerapi.AdditionalFileList{
+			{Source: "files/a.txt", Destination: "/etc/a.txt"},
+			{Content: &content, Destination: "/etc/hello", Permissions: &permissions},
+		},
+	}
+
+	injectedFiles, err := createInjectedFilesBom(testDir, osConfig, imageRootDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	animalsDigest, err := file.GenerateSHA256(filepath.Join(testDir, "dirs/a/usr/local/bin/animals.sh"))
+	assert.NoError(t, err)
+	aDigest, err := file.GenerateSHA256(filepath.Join(testDir, "files/a.txt"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []changemanifest.InjectedFile{
+		{
+			Source:      "dirs/a/usr/local/bin/animals.sh",
+			Sha256:      animalsDigest,
+			Destination: "/usr/local/bin/animals.sh",
+			Mode:        "0755",
+		},
+		{
+			Source:      "files/a.txt",
+			Sha256:      aDigest,
+			Destination: "/etc/a.txt",
+			Mode:        "0640",
+		},
+		{
+			Sha256:      "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			Destination: "/etc/hello",
+			Mode:        "0600",
+		},
+	}, injectedFiles)
+}
+
+func TestCreateInjectedFilesBomCloudInit(t *testing.T) {
+	osConfig := &imagecustomizerapi.OS{
+		CloudInit: &imagecustomizerapi.CloudInit{
+			NoCloud: &imagecustomizerapi.CloudInitNoCloud{
+				UserData: &imagecustomizerapi.CloudInitSeedFile{Source: "files/cloud-init/user-data"},
+			},
+		},
+	}
+
+	injectedFiles, err := createInjectedFilesBom(testDir, osConfig, t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, injectedFiles, 2) {
+		assert.Equal(t, "files/cloud-init/user-data", injectedFiles[0].Source)
+		assert.Equal(t, "/var/lib/cloud/seed/nocloud/user-data", injectedFiles[0].Destination)
+		assert.Equal(t, "0600", injectedFiles[0].Mode)
+
+		// The empty meta-data file.
+		assert.Equal(t, changemanifest.InjectedFile{
+			Sha256:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			Destination: "/var/lib/cloud/seed/nocloud/meta-data",
+			Mode:        "0644",
+		}, injectedFiles[1])
+	}
+}
+
+func TestCreateScriptsBom(t *testing.T) {
+	scripts := imagecustomizerapi.Scripts{
+		PostConfig: []imagecustomizerapi.Script{
+			{Path: "scripts/kangaroo.sh", Order: 10},
+			{
+				Content:   "echo hello",
+				Name:      "hello",
+				Container: &imagecustomizerapi.ScriptContainer{Image: "node:20"},
+			},
+		},
+		FinalizeCustomization: []imagecustomizerapi.Script{
+			{Content: "print('hello')", Interpreter: "python3"},
+		},
+	}
+
+	scriptsBom, err := createScriptsBom(testDir, scripts)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	kangarooDigest, err := file.GenerateSHA256(filepath.Join(testDir, "scripts/kangaroo.sh"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []changemanifest.Script{
+		{
+			Phase:          "postConfig",
+			Name:           "hello",
+			Sha256:         sha256String("echo hello"),
+			ContainerImage: "node:20",
+		},
+		{
+			Phase:  "postConfig",
+			Name:   "scripts/kangaroo.sh",
+			Source: "scripts/kangaroo.sh",
+			Sha256: kangarooDigest,
+		},
+		{
+			Phase:       "finalizeCustomization",
+			Name:        "finalizeCustomization[0]",
+			Sha256:      sha256String("print('hello')"),
+			Interpreter: "python3",
+		},
+	}, scriptsBom)
+}
@@ -0,0 +1,560 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+)
+
+const (
+	ConfigBundleSignatureTypeGpg    = "gpg"
+	ConfigBundleSignatureTypeCosign = "cosign"
+
+	// The default path of the config file within a config bundle.
+	DefaultConfigBundleConfigFile = "config.yaml"
+
+	configBundleDirName        = "configbundle"
+	configBundleFileName       = "bundle.tar"
+	configBundleContentDirName = "content"
+	configBundleGnupgDirName   = "gnupg"
+
+	configProvenanceFileSuffix = ".provenance.json"
+	// The DSSE payload type of the signed config provenance.
+	configProvenancePayloadType = "application/vnd.azurelinux.imagecustomizer.config-provenance+json"
+
+	// The prefix of the lines of gpg's machine readable status output.
+	gpgStatusPrefix = "[GNUPG:] "
+)
+
+// ConfigBundle is a signed archive of a config file and the files it references (e.g. scripts and additional
+// files).
+type ConfigBundle struct {
+	// BundleFile is a tar (or gzipped tar) archive.
+	BundleFile string
+	// SignatureFile is the detached signature of the bundle file.
+	SignatureFile string
+	// SignatureType is the tool that created the signature: "gpg" or "cosign".
+	SignatureType string
+	// PublicKeyFile is the public key that the signature must be made by: a GPG public key (for "gpg") or a cosign
+	// public key (for "cosign").
+	PublicKeyFile string
+	// ConfigFile is the path of the config file within the bundle.
+	ConfigFile string
+}
+
+// ConfigBundleProvenance identifies a verified config bundle and the key that signed it.
+type ConfigBundleProvenance struct {
+	// ConfigFile is the path of the config file within the bundle.
+	ConfigFile      string `json:"configFile"`
+	Sha256          string `json:"sha256"`
+	SignatureType   string `json:"signatureType"`
+	SignatureSha256 string `json:"signatureSha256"`
+	// SignerIdentity is the fingerprint of the GPG key or the sha256 digest of the cosign public key.
+	SignerIdentity string `json:"signerIdentity"`
+	// SignerName is the user ID of the GPG key. Empty for cosign.
+	SignerName string `json:"signerName,omitempty"`
+	// ContentDir is the directory the bundle was extracted to. The config and the files it references must all be
+	// within this directory.
+	ContentDir string `json:"-"`
+}
+
+// configProvenance records the configuration that produced an image.
+type configProvenance struct {
+	// ConfigSha256 is the digest of the composed config (i.e. after the includes, fragments, and matrix cell have
+	// been applied).
+	ConfigSha256 string `json:"configSha256"`
+	// InputImageSha256 is the digest of the base image that the config was applied to.
+	InputImageSha256 string                  `json:"inputImageSha256"`
+	ToolVersion      string                  `json:"toolVersion"`
+	Bundle           *ConfigBundleProvenance `json:"bundle"`
+}
+
+// OpenConfigBundle verifies the config bundle's signature and extracts it within the build directory.
+// Returns the path of the extracted config file and the bundle's provenance.
+//
+// The bundle is copied into the build directory before it is verified, so that the files that are extracted are
+// the same files whose signature was verified.
+func OpenConfigBundle(buildDir string, bundle ConfigBundle) (string, *ConfigBundleProvenance, error) {
+	logger.Log.Infof("Opening config bundle (%s)", bundle.BundleFile)
+
+	configFile := bundle.ConfigFile
+	if configFile == "" {
+		configFile = DefaultConfigBundleConfigFile
+	}
+
+	configFile, err := cleanConfigBundlePath(configFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid config bundle config file path:\n%w", err)
+	}
+
+	bundleDir := filepath.Join(buildDir, configBundleDirName)
+	err = os.RemoveAll(bundleDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to remove old config bundle directory:\n%w", err)
+	}
+
+	err = os.MkdirAll(bundleDir, os.ModePerm)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create config bundle directory:\n%w", err)
+	}
+
+	bundleFile := filepath.Join(bundleDir, configBundleFileName)
+	err = file.Copy(bundle.BundleFile, bundleFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to copy config bundle:\n%w", err)
+	}
+
+	provenance, err := verifyConfigBundle(bundleDir, bundleFile, bundle)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to verify config bundle (%s):\n%w", bundle.BundleFile, err)
+	}
+
+	provenance.ConfigFile = configFile
+
+	contentDir := filepath.Join(bundleDir, configBundleContentDirName)
+	err = extractConfigBundle(bundleFile, contentDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to extract config bundle (%s):\n%w", bundle.BundleFile, err)
+	}
+
+	extractedConfigFile := filepath.Join(contentDir, configFile)
+	exists, err := file.PathExists(extractedConfigFile)
+	if err != nil {
+		return "", nil, err
+	}
+	if !exists {
+		return "", nil, fmt.Errorf("config bundle (%s) doesn't contain config file (%s)", bundle.BundleFile,
+			configFile)
+	}
+
+	provenance.ContentDir = contentDir
+
+	logger.Log.Infof("Verified config bundle (signer: %s)", provenance.SignerIdentity)
+
+	return extractedConfigFile, provenance, nil
+}
+
+func verifyConfigBundle(bundleDir string, bundleFile string, bundle ConfigBundle) (*ConfigBundleProvenance, error) {
+	if bundle.SignatureFile == "" || bundle.PublicKeyFile == "" {
+		return nil, fmt.Errorf("a signature and a public key are required")
+	}
+
+	provenance := &ConfigBundleProvenance{
+		SignatureType: bundle.SignatureType,
+	}
+
+	var err error
+	switch bundle.SignatureType {
+	case ConfigBundleSignatureTypeGpg:
+		provenance.SignerIdentity, provenance.SignerName, err = verifyGpgSignature(
+			filepath.Join(bundleDir, configBundleGnupgDirName), bundleFile, bundle.SignatureFile, bundle.PublicKeyFile)
+
+	case ConfigBundleSignatureTypeCosign:
+		provenance.SignerIdentity, err = verifyCosignSignature(bundleFile, bundle.SignatureFile,
+			bundle.PublicKeyFile)
+
+	default:
+		err = fmt.Errorf("unsupported signature type (%s) (supported: %s, %s)", bundle.SignatureType,
+			ConfigBundleSignatureTypeGpg, ConfigBundleSignatureTypeCosign)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provenance.Sha256, err = file.GenerateSHA256(bundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash config bundle:\n%w", err)
+	}
+
+	provenance.SignatureSha256, err = file.GenerateSHA256(bundle.SignatureFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash config bundle signature:\n%w", err)
+	}
+
+	return provenance, nil
+}
+
+// verifyGpgSignature verifies a detached GPG signature using a temporary keyring that only contains the public key.
+// Returns the fingerprint and user ID of the key that made the signature.
+func verifyGpgSignature(gnupgDir string, bundleFile string, signatureFile string, publicKeyFile string,
+) (string, string, error) {
+	err := os.MkdirAll(gnupgDir, 0o700)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create gpg home directory:\n%w", err)
+	}
+	defer func() {
+		// Stop any gpg daemons that were started, so that they don't keep the directory busy.
+		_, _, _ = shell.Execute("gpgconf", "--homedir", gnupgDir, "--kill", "all")
+		os.RemoveAll(gnupgDir)
+	}()
+
+	_, stderr, err := shell.Execute("gpg", "--homedir", gnupgDir, "--batch", "--import", publicKeyFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to import public key (%s):\n%v", publicKeyFile, stderr)
+	}
+
+	stdout, stderr, err := shell.Execute("gpg", "--homedir", gnupgDir, "--batch", "--status-fd", "1", "--verify",
+		signatureFile, bundleFile)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid gpg signature (%s):\n%v", signatureFile, stderr)
+	}
+
+	return parseGpgVerifyStatus(stdout)
+}
+
+// parseGpgVerifyStatus parses the status output of 'gpg --status-fd 1 --verify'.
+//
+// Example:
+//
+//	[GNUPG:] GOODSIG 3AA5C34371567BD2 Image Config Signer <signer@example.com>
+//	[GNUPG:] VALIDSIG 4D6F1D3E2C2B8A9E7F0A31213AA5C34371567BD2 2024-01-01 1704067200 0 4 0 22 10 00 4D6F...
+func parseGpgVerifyStatus(stdout string) (string, string, error) {
+	fingerprint := ""
+	userId := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		line, found := strings.CutPrefix(scanner.Text(), gpgStatusPrefix)
+		if !found {
+			continue
+		}
+
+		keyword, args, _ := strings.Cut(line, " ")
+		switch keyword {
+		case "VALIDSIG":
+			fingerprint, _, _ = strings.Cut(args, " ")
+
+		case "GOODSIG":
+			_, userId, _ = strings.Cut(args, " ")
+
+		case "BADSIG", "ERRSIG", "EXPSIG", "EXPKEYSIG", "REVKEYSIG":
+			return "", "", fmt.Errorf("gpg signature not valid (%s)", keyword)
+		}
+	}
+
+	if fingerprint == "" {
+		return "", "", fmt.Errorf("gpg didn't report a valid signature")
+	}
+
+	return fingerprint, userId, nil
+}
+
+// verifyCosignSignature verifies a cosign blob signature. Returns the sha256 digest of the public key, which
+// identifies the signer.
+func verifyCosignSignature(bundleFile string, signatureFile string, publicKeyFile string) (string, error) {
+	_, stderr, err := shell.Execute("cosign", "verify-blob", "--key", publicKeyFile, "--signature", signatureFile,
+		bundleFile)
+	if err != nil {
+		return "", fmt.Errorf("invalid cosign signature (%s):\n%v", signatureFile, stderr)
+	}
+
+	keyDigest, err := file.GenerateSHA256(publicKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash public key:\n%w", err)
+	}
+
+	return "sha256:" + keyDigest, nil
+}
+
+// extractConfigBundle extracts the regular files and directories of the bundle. Any other type of entry (e.g.
+// symlinks) or any path that leaves the extraction directory is an error.
+func extractConfigBundle(bundleFile string, extractDir string) error {
+	bundle, err := os.Open(bundleFile)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+
+	bufferedBundle := bufio.NewReader(bundle)
+	reader := io.Reader(bufferedBundle)
+
+	// Gzipped tarballs start with the gzip magic number.
+	magic, err := bufferedBundle.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bufferedBundle)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip header:\n%w", err)
+		}
+		defer gzipReader.Close()
+
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry:\n%w", err)
+		}
+
+		name, err := cleanConfigBundlePath(header.Name)
+		if err != nil {
+			return err
+		}
+
+		if name == "." {
+			continue
+		}
+
+		path := filepath.Join(extractDir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, os.ModePerm)
+			if err != nil {
+				return fmt.Errorf("failed to create directory (%s):\n%w", name, err)
+			}
+
+		case tar.TypeReg:
+			err = extractConfigBundleFile(tarReader, path, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return fmt.Errorf("failed to extract file (%s):\n%w", name, err)
+			}
+
+		default:
+			return fmt.Errorf("unsupported entry (%s): only regular files and directories are allowed", name)
+		}
+	}
+
+	return nil
+}
+
+func extractConfigBundleFile(reader io.Reader, path string, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	destination, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	_, err = io.Copy(destination, reader)
+	if err != nil {
+		return err
+	}
+
+	return destination.Close()
+}
+
+// cleanConfigBundlePath cleans a relative path within a config bundle, ensuring that it stays within the bundle.
+func cleanConfigBundlePath(path string) (string, error) {
+	cleanPath := filepath.Clean(path)
+	if filepath.IsAbs(cleanPath) || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+		return "", fmt.Errorf("path (%s) is outside of the config bundle", path)
+	}
+
+	return cleanPath, nil
+}
+
+// validateConfigBundleInputs checks that a config from a config bundle only uses files from within the bundle: it
+// can't be layered with fragments, use extra RPM sources, or reference files outside of the bundle.
+func validateConfigBundleInputs(baseConfigPath string, config *imagecustomizerapi.Config, rpmsSources []string,
+	options CustomizeImageOptions,
+) error {
+	if len(options.ConfigFragmentFiles) > 0 {
+		return fmt.Errorf("config fragments can't be used with a config bundle")
+	}
+
+	if len(rpmsSources) > 0 {
+		return fmt.Errorf("RPM sources can't be used with a config bundle")
+	}
+
+	if options.ConfigProvenanceKey == "" {
+		return fmt.Errorf("a config provenance signing key is required to use a config bundle")
+	}
+
+	for _, configPath := range getConfigFilePaths(config) {
+		fullPath := file.GetAbsPathWithBase(baseConfigPath, configPath)
+
+		relativePath, err := filepath.Rel(options.ConfigBundle.ContentDir, fullPath)
+		if err != nil || !filepath.IsLocal(relativePath) {
+			return fmt.Errorf("path (%s) is outside of the config bundle", configPath)
+		}
+	}
+
+	return nil
+}
+
+// getConfigFilePaths returns the paths of the files and directories on the build machine that the config references.
+// Relative paths are relative to the config file's directory.
+func getConfigFilePaths(config *imagecustomizerapi.Config) []string {
+	var paths []string
+	addScripts := func(scripts []imagecustomizerapi.Script) {
+		for _, script := range scripts {
+			if script.Path != "" {
+				paths = append(paths, script.Path)
+			}
+		}
+	}
+	addAdditionalFiles := func(additionalFiles imagecustomizerapi.AdditionalFileList) {
+		for _, additionalFile := range additionalFiles {
+			if additionalFile.Source != "" {
+				paths = append(paths, additionalFile.Source)
+			}
+		}
+	}
+
+	addScripts(config.Scripts.PostPackageInstall)
+	addScripts(config.Scripts.PostConfig)
+	addScripts(config.Scripts.PostCustomization)
+	addScripts(config.Scripts.FinalizeCustomization)
+	addScripts(config.Scripts.FinalizeOutsideChroot)
+	if config.Scripts.OutputArtifactsDir != "" {
+		paths = append(paths, config.Scripts.OutputArtifactsDir)
+	}
+
+	for _, rawBlob := range config.Storage.RawBlobs {
+		paths = append(paths, rawBlob.Source)
+	}
+
+	if config.OS != nil {
+		addAdditionalFiles(config.OS.AdditionalFiles)
+
+		for _, additionalDir := range config.OS.AdditionalDirs {
+			paths = append(paths, additionalDir.Source)
+		}
+
+		paths = append(paths, config.OS.Packages.InstallLists...)
+		paths = append(paths, config.OS.Packages.RemoveLists...)
+		paths = append(paths, config.OS.Packages.UpdateLists...)
+		for _, localRepo := range config.OS.Packages.LocalRepos {
+			paths = append(paths, localRepo.Path)
+			paths = append(paths, localRepo.GpgKeys...)
+		}
+
+		for _, user := range config.OS.Users {
+			if user.Password != nil && (user.Password.Type == imagecustomizerapi.PasswordTypePlainTextFile ||
+				user.Password.Type == imagecustomizerapi.PasswordTypeHashedFile) {
+				paths = append(paths, user.Password.Value)
+			}
+			paths = append(paths, user.SSHPublicKeyPaths...)
+		}
+
+		if config.OS.IdLedger != nil {
+			paths = append(paths, config.OS.IdLedger.Path)
+		}
+
+		if config.OS.Proxy != nil {
+			paths = append(paths, config.OS.Proxy.CaCertificatePaths...)
+		}
+
+		if config.OS.ModuleSigning != nil && config.OS.ModuleSigning.Key != nil {
+			paths = append(paths, config.OS.ModuleSigning.Key.PrivateKeyPath,
+				config.OS.ModuleSigning.Key.CertificatePath)
+		}
+
+		if config.OS.CloudInit != nil && config.OS.CloudInit.NoCloud != nil {
+			for _, seedFile := range getCloudInitSeedFiles(config.OS.CloudInit.NoCloud) {
+				if seedFile.seedFile.Source != "" {
+					paths = append(paths, seedFile.seedFile.Source)
+				}
+			}
+		}
+	}
+
+	if config.Iso != nil {
+		addAdditionalFiles(config.Iso.AdditionalFiles)
+
+		if config.Iso.AnswerFile != nil {
+			paths = append(paths, config.Iso.AnswerFile.Source)
+		}
+	}
+
+	if config.Hotfix != nil {
+		paths = append(paths, config.Hotfix.Rpms...)
+	}
+
+	if config.InitrdRebuild != nil {
+		paths = append(paths, config.InitrdRebuild.DracutConfigFiles...)
+	}
+
+	if config.Signing != nil && config.Signing.Command != nil {
+		addScripts([]imagecustomizerapi.Script{*config.Signing.Command})
+	}
+
+	if config.Validation != nil && config.Validation.BootSmokeTest != nil &&
+		config.Validation.BootSmokeTest.Firmware != "" {
+		paths = append(paths, config.Validation.BootSmokeTest.Firmware)
+	}
+
+	return paths
+}
+
+// writeConfigProvenance records the config, the config bundle and the base image that produced the output image, next
+// to the output image. The record is wrapped in a DSSE envelope signed with the given key.
+func writeConfigProvenance(ic *ImageCustomizerParameters, bundle *ConfigBundleProvenance, signingKeyFile string,
+) error {
+	configDigest, err := getConfigDigest(ic.config)
+	if err != nil {
+		return err
+	}
+
+	inputImageDigest, err := file.GenerateSHA256(ic.inputImageFile)
+	if err != nil {
+		return fmt.Errorf("failed to hash input image (%s):\n%w", ic.inputImageFile, err)
+	}
+
+	signer, err := provenance.LoadSigningKey(signingKeyFile)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(configProvenance{
+		ConfigSha256:     configDigest,
+		InputImageSha256: inputImageDigest,
+		ToolVersion:      ToolVersion,
+		Bundle:           bundle,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize config provenance:\n%w", err)
+	}
+
+	envelope, err := provenance.SignPayload(configProvenancePayloadType, payload, signer)
+	if err != nil {
+		return err
+	}
+
+	provenanceFile := filepath.Join(ic.outputImageDir, ic.outputImageBase+configProvenanceFileSuffix)
+	err = jsonutils.WriteJSONFile(provenanceFile, envelope)
+	if err != nil {
+		return fmt.Errorf("failed to write config provenance (%s):\n%w", provenanceFile, err)
+	}
+
+	return nil
+}
+
+// getConfigDigest returns the sha256 digest of the composed config's JSON form.
+func getConfigDigest(config *imagecustomizerapi.Config) (string, error) {
+	configJson, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize config:\n%w", err)
+	}
+
+	configDigest := sha256.Sum256(configJson)
+	return hex.EncodeToString(configDigest[:]), nil
+}
@@ -0,0 +1,311 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBundleEntry struct {
+	name     string
+	typeflag byte
+	content  string
+}
+
+func writeTestConfigBundle(t *testing.T, path string, compress bool, entries []testBundleEntry) {
+	bundleFile, err := os.Create(path)
+	require.NoError(t, err)
+	defer bundleFile.Close()
+
+	writer := io.Writer(bundleFile)
+	if compress {
+		gzipWriter := gzip.NewWriter(bundleFile)
+		defer gzipWriter.Close()
+		writer = gzipWriter
+	}
+
+	tarWriter := tar.NewWriter(writer)
+	defer tarWriter.Close()
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Mode:     0o644,
+			Size:     int64(len(entry.content)),
+		}
+
+		switch entry.typeflag {
+		case tar.TypeDir:
+			header.Mode = 0o755
+			header.Size = 0
+
+		case tar.TypeSymlink:
+			header.Linkname = entry.content
+			header.Size = 0
+		}
+
+		err = tarWriter.WriteHeader(header)
+		require.NoError(t, err)
+
+		if entry.typeflag == tar.TypeReg {
+			_, err = tarWriter.Write([]byte(entry.content))
+			require.NoError(t, err)
+		}
+	}
+}
+
+func TestParseGpgVerifyStatus(t *testing.T) {
+	stdout := "" +
+		"[GNUPG:] NEWSIG\n" +
+		"[GNUPG:] GOODSIG 3AA5C34371567BD2 Image Config Signer <signer@example.com>\n" +
+		"[GNUPG:] VALIDSIG 4D6F1D3E2C2B8A9E7F0A31213AA5C34371567BD2 2024-01-01 1704067200 0 4 0 22 10 00 " +
+		"4D6F1D3E2C2B8A9E7F0A31213AA5C34371567BD2\n" +
+		"[GNUPG:] TRUST_UNDEFINED 0 pgp\n"
+
+	fingerprint, userId, err := parseGpgVerifyStatus(stdout)
+	assert.NoError(t, err)
+	assert.Equal(t, "4D6F1D3E2C2B8A9E7F0A31213AA5C34371567BD2", fingerprint)
+	assert.Equal(t, "Image Config Signer <signer@example.com>", userId)
+
+	_, _, err = parseGpgVerifyStatus("[GNUPG:] NEWSIG\n[GNUPG:] EXPKEYSIG 3AA5C34371567BD2 Old Signer\n")
+	assert.ErrorContains(t, err, "gpg signature not valid (EXPKEYSIG)")
+
+	_, _, err = parseGpgVerifyStatus("")
+	assert.ErrorContains(t, err, "gpg didn't report a valid signature")
+}
+
+func TestCleanConfigBundlePath(t *testing.T) {
+	path, err := cleanConfigBundlePath("./configs//config.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "configs/config.yaml", path)
+
+	_, err = cleanConfigBundlePath("configs/../../config.yaml")
+	assert.ErrorContains(t, err, "path (configs/../../config.yaml) is outside of the config bundle")
+
+	_, err = cleanConfigBundlePath("/etc/passwd")
+	assert.ErrorContains(t, err, "path (/etc/passwd) is outside of the config bundle")
+
+	// A file name that merely starts with ".." is fine.
+	path, err = cleanConfigBundlePath("..config.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "..config.yaml", path)
+}
+
+func TestExtractConfigBundle(t *testing.T) {
+	testDir := t.TempDir()
+	bundleFile := filepath.Join(testDir, "bundle.tar.gz")
+	extractDir := filepath.Join(testDir, "content")
+
+	writeTestConfigBundle(t, bundleFile, true, []testBundleEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "config.yaml", typeflag: tar.TypeReg, content: "os:\n  hostname: test\n"},
+		{name: "scripts/setup.sh", typeflag: tar.TypeReg, content: "#!/bin/sh\n"},
+	})
+
+	err := extractConfigBundle(bundleFile, extractDir)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(extractDir, "config.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "os:\n  hostname: test\n", string(content))
+
+	content, err = os.ReadFile(filepath.Join(extractDir, "scripts/setup.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\n", string(content))
+}
+
+func TestExtractConfigBundleUnsafeEntries(t *testing.T) {
+	testDir := t.TempDir()
+	bundleFile := filepath.Join(testDir, "bundle.tar")
+
+	writeTestConfigBundle(t, bundleFile, false, []testBundleEntry{
+		{name: "../escape.sh", typeflag: tar.TypeReg, content: "#!/bin/sh\n"},
+	})
+
+	err := extractConfigBundle(bundleFile, filepath.Join(testDir, "content1"))
+	assert.ErrorContains(t, err, "path (../escape.sh) is outside of the config bundle")
+
+	writeTestConfigBundle(t, bundleFile, false, []testBundleEntry{
+		{name: "passwd", typeflag: tar.TypeSymlink, content: "/etc/passwd"},
+	})
+
+	err = extractConfigBundle(bundleFile, filepath.Join(testDir, "content2"))
+	assert.ErrorContains(t, err, "unsupported entry (passwd)")
+}
+
+func TestOpenConfigBundleGpg(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	testDir := t.TempDir()
+	signerDir := filepath.Join(testDir, "signer")
+	bundleFile := filepath.Join(testDir, "bundle.tar")
+	signatureFile := bundleFile + ".sig"
+	publicKeyFile := filepath.Join(testDir, "signer.asc")
+
+	writeTestConfigBundle(t, bundleFile, false, []testBundleEntry{
+		{name: "configs/image.yaml", typeflag: tar.TypeReg, content: "os:\n  hostname: test\n"},
+	})
+
+	err := os.MkdirAll(signerDir, 0o700)
+	require.NoError(t, err)
+	defer shell.Execute("gpgconf", "--homedir", signerDir, "--kill", "all")
+
+	_, stderr, err := shell.Execute("gpg", "--homedir", signerDir, "--batch", "--passphrase", "",
+		"--quick-generate-key", "Image Config Signer <signer@example.com>", "ed25519", "sign", "never")
+	require.NoError(t, err, stderr)
+
+	_, stderr, err = shell.Execute("gpg", "--homedir", signerDir, "--batch", "--armor", "--output", publicKeyFile,
+		"--export")
+	require.NoError(t, err, stderr)
+
+	_, stderr, err = shell.Execute("gpg", "--homedir", signerDir, "--batch", "--output", signatureFile,
+		"--detach-sign", bundleFile)
+	require.NoError(t, err, stderr)
+
+	bundle := ConfigBundle{
+		BundleFile:    bundleFile,
+		SignatureFile: signatureFile,
+		SignatureType: ConfigBundleSignatureTypeGpg,
+		PublicKeyFile: publicKeyFile,
+		ConfigFile:    "configs/image.yaml",
+	}
+
+	buildDir := filepath.Join(testDir, "build")
+	configFile, provenance, err := OpenConfigBundle(buildDir, bundle)
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(buildDir, "configbundle/content/configs/image.yaml"), configFile)
+		assert.Equal(t, "configs/image.yaml", provenance.ConfigFile)
+		assert.Equal(t, ConfigBundleSignatureTypeGpg, provenance.SignatureType)
+		assert.Regexp(t, `^[0-9A-F]{40}$`, provenance.SignerIdentity)
+		assert.Equal(t, "Image Config Signer <signer@example.com>", provenance.SignerName)
+		assert.Regexp(t, `^[0-9a-f]{64}$`, provenance.Sha256)
+	}
+
+	// The config file must be in the bundle.
+	bundle.ConfigFile = ""
+	_, _, err = OpenConfigBundle(buildDir, bundle)
+	assert.ErrorContains(t, err, "doesn't contain config file (config.yaml)")
+
+	// A modified bundle doesn't match the signature.
+	writeTestConfigBundle(t, bundleFile, false, []testBundleEntry{
+		{name: "configs/image.yaml", typeflag: tar.TypeReg, content: "os:\n  hostname: evil\n"},
+	})
+
+	bundle.ConfigFile = "configs/image.yaml"
+	_, _, err = OpenConfigBundle(buildDir, bundle)
+	assert.ErrorContains(t, err, "invalid gpg signature")
+}
+
+func TestValidateConfigBundleInputs(t *testing.T) {
+	contentDir := filepath.Join(t.TempDir(), "content")
+	baseConfigPath := filepath.Join(contentDir, "configs")
+	options := CustomizeImageOptions{
+		ConfigBundle:        &ConfigBundleProvenance{ContentDir: contentDir},
+		ConfigProvenanceKey: "provenance.key",
+	}
+
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{
+			AdditionalFiles: imagecustomizerapi.AdditionalFileList{
+				{Source: "files/motd", Destination: "/etc/motd"},
+				{Source: "../scripts/banner", Destination: "/etc/banner"},
+			},
+		},
+	}
+
+	err := validateConfigBundleInputs(baseConfigPath, config, nil, options)
+	assert.NoError(t, err)
+
+	config.OS.Users = []imagecustomizerapi.User{
+		{Name: "test", SSHPublicKeyPaths: []string{"../../id_rsa.pub"}},
+	}
+	err = validateConfigBundleInputs(baseConfigPath, config, nil, options)
+	assert.ErrorContains(t, err, "path (../../id_rsa.pub) is outside of the config bundle")
+
+	config.OS.Users = nil
+	config.Scripts.PostCustomization = []imagecustomizerapi.Script{{Path: "/usr/local/bin/setup.sh"}}
+	err = validateConfigBundleInputs(baseConfigPath, config, nil, options)
+	assert.ErrorContains(t, err, "path (/usr/local/bin/setup.sh) is outside of the config bundle")
+
+	config.Scripts.PostCustomization = nil
+	err = validateConfigBundleInputs(baseConfigPath, config, []string{"/repos"}, options)
+	assert.ErrorContains(t, err, "RPM sources can't be used with a config bundle")
+
+	options.ConfigFragmentFiles = []string{"fragment.yaml"}
+	err = validateConfigBundleInputs(baseConfigPath, config, nil, options)
+	assert.ErrorContains(t, err, "config fragments can't be used with a config bundle")
+}
+
+func TestWriteConfigProvenanceIsSigned(t *testing.T) {
+	testDir := t.TempDir()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	keyFile := filepath.Join(testDir, "provenance.key")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	require.NoError(t, err)
+
+	inputImageFile := filepath.Join(testDir, "base.raw")
+	err = os.WriteFile(inputImageFile, []byte("base image"), 0o644)
+	require.NoError(t, err)
+
+	ic := &ImageCustomizerParameters{
+		config:          &imagecustomizerapi.Config{},
+		inputImageFile:  inputImageFile,
+		outputImageDir:  testDir,
+		outputImageBase: "image",
+	}
+	bundle := &ConfigBundleProvenance{ConfigFile: "config.yaml", ContentDir: testDir}
+
+	err = writeConfigProvenance(ic, bundle, keyFile)
+	require.NoError(t, err)
+
+	envelope := &provenance.Envelope{}
+	err = jsonutils.ReadJSONFile(filepath.Join(testDir, "image.provenance.json"), envelope)
+	require.NoError(t, err)
+
+	payload, err := envelope.VerifyPayload(configProvenancePayloadType, privateKey.Public())
+	require.NoError(t, err)
+
+	var record configProvenance
+	err = json.Unmarshal(payload, &record)
+	require.NoError(t, err)
+
+	imageDigest := sha256.Sum256([]byte("base image"))
+	assert.Equal(t, hex.EncodeToString(imageDigest[:]), record.InputImageSha256)
+	assert.Equal(t, "config.yaml", record.Bundle.ConfigFile)
+	assert.NotContains(t, string(payload), testDir)
+
+	// Tampering with the record invalidates the signature.
+	envelope.Payload = []byte(strings.Replace(string(envelope.Payload), record.InputImageSha256, "0", 1))
+	_, err = envelope.VerifyPayload(configProvenancePayloadType, privateKey.Public())
+	assert.Error(t, err)
+}
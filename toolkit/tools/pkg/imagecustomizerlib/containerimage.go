@@ -0,0 +1,456 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/klauspost/pgzip"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ociimage"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	containerLayersDirName  = "containerlayers"
+	containerLayoutDirName  = "containerlayout"
+	containerStagingDirName = "containerstaging"
+
+	containerBaseLayerFileName           = "base.tar.gz"
+	containerCustomizationsLayerFileName = "customizations.tar.gz"
+	containerRootfsLayerFileName         = "rootfs.tar.gz"
+
+	containerDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	whiteoutPrefix = ".wh."
+)
+
+var (
+	// Directories whose contents aren't included in container images, since they are either virtual filesystems or
+	// are provided by the container runtime. The directories themselves are kept, so that they can be mounted over.
+	containerExcludedDirContents = []string{
+		"/dev",
+		"/proc",
+		"/run",
+		"/sys",
+	}
+)
+
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+func isContainerImageFormat(imageFormat string) bool {
+	return imageFormat == ImageFormatOci || imageFormat == ImageFormatDockerArchive
+}
+
+// getContainerImageLayers returns the layers of the container image, lowest layer first.
+func getContainerImageLayers(buildDir string, containerImage *imagecustomizerapi.ContainerImage) []ociimage.Layer {
+	layersDir := filepath.Join(buildDir, containerLayersDirName)
+
+	if containerImage != nil && containerImage.Squash {
+		return []ociimage.Layer{
+			{
+				Path:      filepath.Join(layersDir, containerRootfsLayerFileName),
+				CreatedBy: "imagecustomizer: base image and customizations",
+			},
+		}
+	}
+
+	return []ociimage.Layer{
+		{
+			Path:      filepath.Join(layersDir, containerBaseLayerFileName),
+			CreatedBy: "imagecustomizer: base image",
+		},
+		{
+			Path:      filepath.Join(layersDir, containerCustomizationsLayerFileName),
+			CreatedBy: "imagecustomizer: customizations",
+		},
+	}
+}
+
+// writeContainerBaseLayer writes the base image's root filesystem to the container image's base layer, unless the
+// layers are squashed. Returns a snapshot of the root filesystem, which is used to find the files changed by the
+// customizations.
+func writeContainerBaseLayer(buildDir string, containerImage *imagecustomizerapi.ContainerImage, rootDir string,
+) (map[string]fileSnapshotEntry, error) {
+	if containerImage != nil && containerImage.Squash {
+		return nil, nil
+	}
+
+	logger.Log.Infof("Writing container image base layer")
+
+	layersDir := filepath.Join(buildDir, containerLayersDirName)
+	err := os.MkdirAll(layersDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container layers directory:\n%w", err)
+	}
+
+	paths, err := listContainerRootfsPaths(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list root filesystem files:\n%w", err)
+	}
+
+	err = writeContainerLayer(rootDir, filepath.Join(layersDir, containerBaseLayerFileName), paths, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write container image base layer:\n%w", err)
+	}
+
+	snapshot, err := takeFileSnapshot(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record files:\n%w", err)
+	}
+
+	return snapshot, nil
+}
+
+// writeContainerTopLayer writes the customized root filesystem to the container image's top layer. If the layers
+// aren't squashed, then only the files changed by the customizations are written, along with whiteouts for the
+// removed files.
+func writeContainerTopLayer(buildDir string, containerImage *imagecustomizerapi.ContainerImage, rootDir string,
+	baseSnapshot map[string]fileSnapshotEntry,
+) error {
+	layersDir := filepath.Join(buildDir, containerLayersDirName)
+	err := os.MkdirAll(layersDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create container layers directory:\n%w", err)
+	}
+
+	if containerImage != nil && containerImage.Squash {
+		logger.Log.Infof("Writing container image layer")
+
+		paths, err := listContainerRootfsPaths(rootDir)
+		if err != nil {
+			return fmt.Errorf("failed to list root filesystem files:\n%w", err)
+		}
+
+		err = writeContainerLayer(rootDir, filepath.Join(layersDir, containerRootfsLayerFileName), paths, nil)
+		if err != nil {
+			return fmt.Errorf("failed to write container image layer:\n%w", err)
+		}
+
+		return nil
+	}
+
+	logger.Log.Infof("Writing container image customizations layer")
+
+	snapshot, err := takeFileSnapshot(rootDir)
+	if err != nil {
+		return fmt.Errorf("failed to record files:\n%w", err)
+	}
+
+	changes := diffFileSnapshots(baseSnapshot, snapshot)
+
+	paths := append(append([]string(nil), changes.Added...), changes.Modified...)
+	whiteouts := getContainerLayerWhiteouts(changes.Removed)
+
+	err = writeContainerLayer(rootDir, filepath.Join(layersDir, containerCustomizationsLayerFileName), paths,
+		whiteouts)
+	if err != nil {
+		return fmt.Errorf("failed to write container image customizations layer:\n%w", err)
+	}
+
+	return nil
+}
+
+// createContainerImageArchive assembles the container image from its layers and writes it to the output file, as
+// either an 'oci-archive' or a 'docker-archive' tarball.
+func createContainerImageArchive(buildDir string, containerImage *imagecustomizerapi.ContainerImage,
+	imageFormat string, outputImageFile string,
+) error {
+	if containerImage == nil {
+		containerImage = &imagecustomizerapi.ContainerImage{}
+	}
+
+	layoutDir := filepath.Join(buildDir, containerLayoutDirName)
+	stagingDir := filepath.Join(buildDir, containerStagingDirName)
+
+	// Start from an empty layout, so that images from previous runs aren't included.
+	for _, dir := range []string{layoutDir, stagingDir} {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return fmt.Errorf("failed to clean directory (%s):\n%w", dir, err)
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	layers := getContainerImageLayers(buildDir, containerImage)
+
+	_, err := ociimage.WriteImage(layoutDir, layers, createContainerConfig(containerImage), containerImage.Tag)
+	if err != nil {
+		return fmt.Errorf("failed to create container image:\n%w", err)
+	}
+
+	logger.Log.Infof("Writing: %s", outputImageFile)
+
+	switch imageFormat {
+	case ImageFormatOci:
+		err = ociimage.WriteArchive(layoutDir, outputImageFile)
+
+	case ImageFormatDockerArchive:
+		err = ociimage.WriteDockerArchive(layoutDir, outputImageFile, containerImage.Tag, stagingDir)
+
+	default:
+		err = fmt.Errorf("unsupported container image format (%s)", imageFormat)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func createContainerConfig(containerImage *imagecustomizerapi.ContainerImage) ociimage.ContainerConfig {
+	env := []string(nil)
+	if _, hasPath := containerImage.EnvironmentVariables["PATH"]; !hasPath {
+		env = append(env, "PATH="+containerDefaultPath)
+	}
+
+	// Sort the environment variables, so that the image config is consistent between builds.
+	envNames := make([]string, 0, len(containerImage.EnvironmentVariables))
+	for name := range containerImage.EnvironmentVariables {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+
+	for _, name := range envNames {
+		env = append(env, fmt.Sprintf("%s=%s", name, containerImage.EnvironmentVariables[name]))
+	}
+
+	cmd := containerImage.Cmd
+	if len(containerImage.Entrypoint) <= 0 && len(cmd) <= 0 {
+		cmd = []string{"/bin/bash"}
+	}
+
+	return ociimage.ContainerConfig{
+		Env:        env,
+		Entrypoint: containerImage.Entrypoint,
+		Cmd:        cmd,
+		WorkingDir: containerImage.WorkingDir,
+		Labels:     containerImage.Labels,
+	}
+}
+
+// listContainerRootfsPaths lists the paths of the root filesystem that are included in the container image.
+func listContainerRootfsPaths(rootDir string) ([]string, error) {
+	return listRootfsPaths(rootDir, containerExcludedDirContents)
+}
+
+// listRootfsPaths lists the paths of the root filesystem, excluding the contents of the excluded directories.
+func listRootfsPaths(rootDir string, excludedDirContents []string) ([]string, error) {
+	paths := []string(nil)
+	err := filepath.WalkDir(rootDir, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+
+		imagePath := filepath.Join("/", relPath)
+		if imagePath == "/" {
+			return nil
+		}
+
+		if imagePath == rpmsMountParentDirInChroot {
+			return filepath.SkipDir
+		}
+
+		paths = append(paths, imagePath)
+
+		for _, excludedDir := range excludedDirContents {
+			if imagePath == excludedDir && d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// getContainerLayerWhiteouts returns the whiteout entries for the removed paths. A removed directory's whiteout
+// hides its contents as well, so the removed paths within a removed directory don't need their own whiteouts.
+func getContainerLayerWhiteouts(removedPaths []string) []string {
+	removed := make(map[string]bool)
+	for _, removedPath := range removedPaths {
+		removed[removedPath] = true
+	}
+
+	whiteouts := []string(nil)
+	for _, removedPath := range removedPaths {
+		parentRemoved := false
+		for dir := path.Dir(removedPath); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if removed[dir] {
+				parentRemoved = true
+				break
+			}
+		}
+
+		if !parentRemoved {
+			whiteouts = append(whiteouts, path.Join(path.Dir(removedPath), whiteoutPrefix+path.Base(removedPath)))
+		}
+	}
+
+	sort.Strings(whiteouts)
+	return whiteouts
+}
+
+// writeContainerLayer writes a gzipped layer tarball containing the files at the paths and the whiteout entries.
+func writeContainerLayer(rootDir string, layerFile string, paths []string, whiteouts []string) error {
+	outFile, err := os.Create(layerFile)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	gzipWriter := pgzip.NewWriter(outFile)
+	defer gzipWriter.Close()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+
+	entries := append(append([]string(nil), paths...), whiteouts...)
+	sort.Strings(entries)
+
+	isWhiteout := make(map[string]bool)
+	for _, whiteout := range whiteouts {
+		isWhiteout[whiteout] = true
+	}
+
+	hardlinks := make(map[inodeKey]string)
+	for _, entry := range entries {
+		if isWhiteout[entry] {
+			err = tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     strings.TrimPrefix(entry, "/"),
+				Format:   tar.FormatPAX,
+			})
+		} else {
+			err = addContainerLayerFile(tarWriter, rootDir, entry, hardlinks)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add (%s) to layer:\n%w", entry, err)
+		}
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return outFile.Close()
+}
+
+func addContainerLayerFile(tarWriter *tar.Writer, rootDir string, imagePath string, hardlinks map[inodeKey]string,
+) error {
+	fullPath := filepath.Join(rootDir, imagePath)
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSocket != 0 {
+		// Sockets can't be stored in tarballs and are recreated by the services that own them.
+		return nil
+	}
+
+	linkTarget := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		linkTarget, err = os.Readlink(fullPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return err
+	}
+
+	header.Name = strings.TrimPrefix(imagePath, "/")
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	// The user and group names are looked up on the build host, which may not match the image's.
+	header.Uname = ""
+	header.Gname = ""
+	header.Format = tar.FormatPAX
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		header.Uid = int(stat.Uid)
+		header.Gid = int(stat.Gid)
+
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inodeKey{dev: uint64(stat.Dev), ino: stat.Ino}
+			if firstPath, seen := hardlinks[key]; seen {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = firstPath
+				header.Size = 0
+			} else {
+				hardlinks[key] = header.Name
+			}
+		}
+	}
+
+	if info.Mode().IsRegular() {
+		// File capabilities (e.g. for ping) are lost if they aren't copied.
+		size, err := unix.Lgetxattr(fullPath, capabilityXattr, nil)
+		if err == nil && size > 0 {
+			capabilities := make([]byte, size)
+			_, err = unix.Lgetxattr(fullPath, capabilityXattr, capabilities)
+			if err != nil {
+				return fmt.Errorf("failed to read capabilities:\n%w", err)
+			}
+
+			header.PAXRecords = map[string]string{
+				"SCHILY.xattr." + capabilityXattr: string(capabilities),
+			}
+		}
+	}
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeReg {
+		sourceFile, err := os.Open(fullPath)
+		if err != nil {
+			return err
+		}
+		defer sourceFile.Close()
+
+		_, err = io.Copy(tarWriter, sourceFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
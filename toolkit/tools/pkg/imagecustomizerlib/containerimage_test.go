@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/pgzip"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListContainerRootfsPaths(t *testing.T) {
+	rootDir := t.TempDir()
+
+	for _, dir := range []string{"dev/pts", "etc", "proc/1"} {
+		err := os.MkdirAll(filepath.Join(rootDir, dir), 0o755)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	err := os.WriteFile(filepath.Join(rootDir, "etc/hostname"), []byte("web\n"), 0o644)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	paths, err := listContainerRootfsPaths(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/dev", "/etc", "/etc/hostname", "/proc"}, paths)
+}
+
+func TestGetContainerLayerWhiteouts(t *testing.T) {
+	removedPaths := []string{"/etc/motd", "/usr/share/doc", "/usr/share/doc/bash", "/usr/share/doc/bash/README"}
+
+	whiteouts := getContainerLayerWhiteouts(removedPaths)
+	assert.Equal(t, []string{"/etc/.wh.motd", "/usr/share/.wh.doc"}, whiteouts)
+}
+
+func TestWriteContainerLayer(t *testing.T) {
+	rootDir := t.TempDir()
+	layerFile := filepath.Join(t.TempDir(), "layer.tar.gz")
+
+	err := os.MkdirAll(filepath.Join(rootDir, "usr/bin"), 0o755)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(rootDir, "usr/bin/gzip"), []byte("gzip"), 0o755)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = os.Link(filepath.Join(rootDir, "usr/bin/gzip"), filepath.Join(rootDir, "usr/bin/gunzip"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = os.Symlink("gzip", filepath.Join(rootDir, "usr/bin/zcat"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	paths := []string{"/usr", "/usr/bin", "/usr/bin/gunzip", "/usr/bin/gzip", "/usr/bin/zcat"}
+	err = writeContainerLayer(rootDir, layerFile, paths, []string{"/etc/.wh.motd"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	layer, err := os.Open(layerFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer layer.Close()
+
+	gzipReader, err := pgzip.NewReader(layer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer gzipReader.Close()
+
+	headers := make(map[string]*tar.Header)
+	names := []string(nil)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		headers[header.Name] = header
+		names = append(names, header.Name)
+	}
+
+	assert.Equal(t, []string{
+		"etc/.wh.motd",
+		"usr/",
+		"usr/bin/",
+		"usr/bin/gunzip",
+		"usr/bin/gzip",
+		"usr/bin/zcat",
+	}, names)
+
+	assert.Equal(t, byte(tar.TypeReg), headers["usr/bin/gunzip"].Typeflag)
+	assert.Equal(t, int64(4), headers["usr/bin/gunzip"].Size)
+	assert.Equal(t, byte(tar.TypeLink), headers["usr/bin/gzip"].Typeflag)
+	assert.Equal(t, "usr/bin/gunzip", headers["usr/bin/gzip"].Linkname)
+	assert.Equal(t, byte(tar.TypeSymlink), headers["usr/bin/zcat"].Typeflag)
+	assert.Equal(t, "gzip", headers["usr/bin/zcat"].Linkname)
+	assert.Empty(t, headers["usr/bin/zcat"].Uname)
+}
+
+func TestCreateContainerConfig(t *testing.T) {
+	containerConfig := createContainerConfig(&imagecustomizerapi.ContainerImage{
+		Entrypoint: []string{"/usr/sbin/nginx"},
+		EnvironmentVariables: map[string]string{
+			"LANG": "C.UTF-8",
+			"A":    "b",
+		},
+	})
+
+	assert.Equal(t, []string{"PATH=" + containerDefaultPath, "A=b", "LANG=C.UTF-8"}, containerConfig.Env)
+	assert.Equal(t, []string{"/usr/sbin/nginx"}, containerConfig.Entrypoint)
+	assert.Empty(t, containerConfig.Cmd)
+
+	// Without an entrypoint or cmd, the container runs a shell.
+	containerConfig = createContainerConfig(&imagecustomizerapi.ContainerImage{
+		EnvironmentVariables: map[string]string{
+			"PATH": "/usr/bin",
+		},
+	})
+
+	assert.Equal(t, []string{"PATH=/usr/bin"}, containerConfig.Env)
+	assert.Equal(t, []string{"/bin/bash"}, containerConfig.Cmd)
+}
+
+func TestGetContainerImageLayers(t *testing.T) {
+	layers := getContainerImageLayers("/build", nil)
+	if assert.Len(t, layers, 2) {
+		assert.Equal(t, "/build/containerlayers/base.tar.gz", layers[0].Path)
+		assert.Equal(t, "/build/containerlayers/customizations.tar.gz", layers[1].Path)
+	}
+
+	layers = getContainerImageLayers("/build", &imagecustomizerapi.ContainerImage{Squash: true})
+	if assert.Len(t, layers, 1) {
+		assert.Equal(t, "/build/containerlayers/rootfs.tar.gz", layers[0].Path)
+	}
+}
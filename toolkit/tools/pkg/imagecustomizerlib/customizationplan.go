@@ -0,0 +1,858 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/plugins"
+)
+
+// CustomizationPlan is the list of operations that customizing an image would perform.
+type CustomizationPlan struct {
+	Steps []CustomizationPlanStep
+}
+
+// CustomizationPlanStep is a single operation of a customization plan.
+type CustomizationPlanStep struct {
+	Name    string
+	Details []string
+}
+
+func (p *CustomizationPlan) addStep(name string, details ...string) {
+	p.Steps = append(p.Steps, CustomizationPlanStep{
+		Name:    name,
+		Details: details,
+	})
+}
+
+// String formats the plan as a numbered list of steps.
+func (p *CustomizationPlan) String() string {
+	builder := strings.Builder{}
+	for i, step := range p.Steps {
+		fmt.Fprintf(&builder, "%d. %s\n", i+1, step.Name)
+		for _, detail := range step.Details {
+			fmt.Fprintf(&builder, "   - %s\n", detail)
+		}
+	}
+	return builder.String()
+}
+
+// PlanCustomizationWithConfigFile is the dry-run equivalent of CustomizeImageWithConfigFileAndOptions.
+func PlanCustomizationWithConfigFile(buildDir string, configFile string, imageFile string,
+	rpmsSources []string, outputImageFile string, outputImageFormat string,
+	outputSplitPartitionsFormat string, outputPXEArtifactsDir string,
+	useBaseImageRpmRepos bool, enableShrinkFilesystems bool, options CustomizeImageOptions,
+) (*CustomizationPlan, error) {
+	config, absBaseConfigPath, imageFile, err := loadConfigFile(configFile, imageFile, options)
+	if err != nil {
+		return nil, err
+	}
+
+	pluginRegistry, err := plugins.Discover(options.PluginsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugins:\n%w", err)
+	}
+
+	return planCustomization(buildDir, absBaseConfigPath, config, imageFile, rpmsSources, outputImageFile,
+		outputImageFormat, outputSplitPartitionsFormat, outputPXEArtifactsDir, useBaseImageRpmRepos,
+		enableShrinkFilesystems, pluginRegistry)
+}
+
+// PlanCustomization validates the config and calculates the operations that CustomizeImageWithOptions would perform,
+// without mounting or modifying any image. The packages to install or update are resolved against the RPM sources
+// that are directories.
+func PlanCustomization(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
+	imageFile string, rpmsSources []string, outputImageFile string, outputImageFormat string,
+	outputSplitPartitionsFormat string, outputPXEArtifactsDir string, useBaseImageRpmRepos bool,
+	enableShrinkFilesystems bool,
+) (*CustomizationPlan, error) {
+	return planCustomization(buildDir, baseConfigPath, config, imageFile, rpmsSources, outputImageFile,
+		outputImageFormat, outputSplitPartitionsFormat, outputPXEArtifactsDir, useBaseImageRpmRepos,
+		enableShrinkFilesystems, nil)
+}
+
+func planCustomization(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
+	imageFile string, rpmsSources []string, outputImageFile string, outputImageFormat string,
+	outputSplitPartitionsFormat string, outputPXEArtifactsDir string, useBaseImageRpmRepos bool,
+	enableShrinkFilesystems bool, pluginRegistry *plugins.Registry,
+) (*CustomizationPlan, error) {
+	err := validateConfig(baseConfigPath, config, rpmsSources, useBaseImageRpmRepos)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image config:\n%w", err)
+	}
+
+	ic, err := createImageCustomizerParameters(buildDir, imageFile, baseConfigPath, config, useBaseImageRpmRepos,
+		rpmsSources, enableShrinkFilesystems, outputSplitPartitionsFormat, outputImageFormat, outputImageFile,
+		outputPXEArtifactsDir, pluginRegistry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image customizer parameters object:\n%w", err)
+	}
+
+	plan := &CustomizationPlan{}
+
+	if isImageUrl(imageFile) {
+		fetcher, err := findBaseImageFetcher(pluginRegistry, imageFile)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.addStep("Fetch base image", fmt.Sprintf("url: %s", imageFile), fmt.Sprintf("plugin: %s", fetcher))
+	} else {
+		imageExists, err := file.PathExists(imageFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if base image (%s) exists:\n%w", imageFile, err)
+		}
+
+		if !imageExists {
+			return nil, fmt.Errorf("base image (%s) doesn't exist", imageFile)
+		}
+	}
+
+	plan.addStep("Convert input image", fmt.Sprintf("file: %s", imageFile),
+		fmt.Sprintf("format: %s", ic.inputImageFormat))
+
+	if ic.customizeOSPartitions || !ic.inputIsIso {
+		err = planOSCustomizations(plan, ic)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	planOutput(plan, ic)
+
+	return plan, nil
+}
+
+func planOSCustomizations(plan *CustomizationPlan, ic *ImageCustomizerParameters) error {
+	config := ic.config
+	osConfig := config.OS
+	if osConfig == nil {
+		osConfig = &imagecustomizerapi.OS{}
+	}
+
+	if config.Hotfix != nil {
+		return planHotfix(plan, ic)
+	}
+
+	if config.InitrdRebuild != nil {
+		return planInitrdRebuild(plan, ic)
+	}
+
+	if !ic.inputIsIso && config.OS != nil {
+		plan.addStep("Check base image compatibility")
+	}
+
+	if config.CustomizePartitions() {
+		plan.addStep("Customize partitions", planStorageDetails(&config.Storage)...)
+	}
+
+	for _, stage := range osCustomizationStages() {
+		if stage.plan == nil {
+			continue
+		}
+
+		err := stage.plan(plan, ic, osConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	planSigning(plan, config.Signing)
+
+	if ic.enableShrinkFilesystems {
+		plan.addStep("Shrink filesystems")
+	}
+
+	planFinalize(plan, ic.config.Finalize)
+
+	if len(config.Storage.Verity) > 0 {
+		plan.addStep("Calculate verity hashes")
+	}
+
+	if config.Storage.AbUpdate != nil {
+		plan.addStep("Create A/B update slot B", fmt.Sprintf("copy partition (%s) to partition (%s)",
+			config.Storage.AbUpdate.SlotAPartitionId, config.Storage.AbUpdate.SlotBPartitionId))
+	}
+
+	plan.addStep("Check filesystems")
+
+	if len(config.Storage.EncryptedVolumes) > 0 {
+		plan.addStep("Encrypt partitions and write recovery keys")
+	}
+
+	if len(config.Storage.RawBlobs) > 0 {
+		details := []string(nil)
+		for _, blob := range config.Storage.RawBlobs {
+			location := "disk"
+			if blob.PartitionId != "" {
+				location = fmt.Sprintf("partition (%s)", blob.PartitionId)
+			}
+			details = append(details, fmt.Sprintf("%s: %s offset %d", blob.Source, location, blob.Offset))
+		}
+		plan.addStep("Write raw blobs", details...)
+	}
+
+	return nil
+}
+
+// planPackagesStage plans the package operations, in the order that addRemoveAndUpdatePackages performs them.
+func planPackagesStage(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	err := planPackages(plan, ic, osConfig)
+	if err != nil {
+		return err
+	}
+
+	if osConfig.Gpu != nil {
+		details := []string{fmt.Sprintf("vendor: %s", osConfig.Gpu.Vendor)}
+		if osConfig.Gpu.DriverPackage != "" {
+			details = append(details, fmt.Sprintf("driver package: %s", osConfig.Gpu.DriverPackage))
+		}
+		if osConfig.Gpu.DriverVersion != "" {
+			details = append(details, fmt.Sprintf("driver version: %s", osConfig.Gpu.DriverVersion))
+		}
+		if osConfig.Gpu.ContainerToolkit {
+			details = append(details, "container toolkit")
+		}
+		plan.addStep("Install GPU driver", details...)
+	}
+
+	if osConfig.Packages.RemoveOrphans && len(osConfig.Packages.Remove) > 0 {
+		plan.addStep("Remove orphaned dependencies of removed packages")
+	}
+
+	return nil
+}
+
+func planPackageLocks(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	if len(osConfig.PackageLocks) > 0 {
+		details := []string(nil)
+		for _, packageLock := range osConfig.PackageLocks {
+			details = append(details, fmt.Sprintf("%s: %s", packageLock.Name, packageLock.Version))
+		}
+		plan.addStep("Lock package versions", details...)
+	}
+	return nil
+}
+
+func planRepos(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.Repos != nil {
+		details := []string{fmt.Sprintf("existing repos: %s", osConfig.Repos.Existing)}
+		for _, repo := range osConfig.Repos.Add {
+			details = append(details, fmt.Sprintf("add repo: %s", repo.Id))
+		}
+		plan.addStep("Configure repos", details...)
+	}
+	return nil
+}
+
+func planAdditionalDirs(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	if len(osConfig.AdditionalDirs) > 0 {
+		details := []string(nil)
+		for _, dirConfig := range osConfig.AdditionalDirs {
+			details = append(details, fmt.Sprintf("%s -> %s", dirConfig.Source, dirConfig.Destination))
+		}
+		plan.addStep("Copy additional directories", details...)
+	}
+	return nil
+}
+
+func planAdditionalFiles(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	if len(osConfig.AdditionalFiles) > 0 {
+		details := []string(nil)
+		for _, additionalFile := range osConfig.AdditionalFiles {
+			source := additionalFile.Source
+			if additionalFile.Content != nil {
+				source = "(inline content)"
+			}
+			if additionalFile.Template {
+				source += " (template)"
+			}
+			details = append(details, fmt.Sprintf("%s -> %s", source, additionalFile.Destination))
+		}
+		plan.addStep("Copy additional files", details...)
+	}
+	return nil
+}
+
+func planUsers(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if len(osConfig.Users) > 0 {
+		details := []string(nil)
+		for _, user := range osConfig.Users {
+			details = append(details, user.Name)
+		}
+		plan.addStep("Add or update users", details...)
+	}
+	return nil
+}
+
+func planNetwork(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.Network != nil {
+		renderer := osConfig.Network.Renderer
+		if renderer == imagecustomizerapi.NetworkRendererDefault {
+			renderer = imagecustomizerapi.NetworkRendererNetworkd
+		}
+
+		details := []string{fmt.Sprintf("renderer: %s", renderer)}
+		for _, ethernet := range osConfig.Network.Ethernets {
+			details = append(details, fmt.Sprintf("ethernet: %s", ethernet.Name))
+		}
+		for _, bond := range osConfig.Network.Bonds {
+			details = append(details, fmt.Sprintf("bond: %s (%s)", bond.Name, strings.Join(bond.Interfaces, ", ")))
+		}
+		for _, vlan := range osConfig.Network.Vlans {
+			details = append(details, fmt.Sprintf("vlan: %s (%s, id %d)", vlan.Name, vlan.Link, vlan.Id))
+		}
+		plan.addStep("Configure network", details...)
+	}
+	return nil
+}
+
+func planProxy(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.Proxy != nil {
+		details := []string(nil)
+		if osConfig.Proxy.HttpProxy != "" {
+			details = append(details, fmt.Sprintf("httpProxy: %s", redactProxyUrl(osConfig.Proxy.HttpProxy)))
+		}
+		if osConfig.Proxy.HttpsProxy != "" {
+			details = append(details, fmt.Sprintf("httpsProxy: %s", redactProxyUrl(osConfig.Proxy.HttpsProxy)))
+		}
+		for _, caCertificatePath := range osConfig.Proxy.CaCertificatePaths {
+			details = append(details, fmt.Sprintf("CA certificate: %s", caCertificatePath))
+		}
+		plan.addStep("Configure proxy", details...)
+	}
+	return nil
+}
+
+func planServices(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if len(osConfig.Services.Enable) > 0 || len(osConfig.Services.Disable) > 0 {
+		details := []string(nil)
+		for _, service := range osConfig.Services.Enable {
+			details = append(details, fmt.Sprintf("enable: %s", service))
+		}
+		for _, service := range osConfig.Services.Disable {
+			details = append(details, fmt.Sprintf("disable: %s", service))
+		}
+		plan.addStep("Enable or disable services", details...)
+	}
+	return nil
+}
+
+func planScheduledTasks(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	if len(osConfig.ScheduledTasks) > 0 {
+		details := []string(nil)
+		for _, scheduledTask := range osConfig.ScheduledTasks {
+			details = append(details, fmt.Sprintf("%s: %s", scheduledTask.Name, scheduledTask.Schedule))
+		}
+		plan.addStep("Add scheduled tasks", details...)
+	}
+	return nil
+}
+
+func planCloudInit(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.CloudInit != nil {
+		details := []string(nil)
+		if osConfig.CloudInit.Disabled {
+			details = append(details, "disabled")
+		}
+		if len(osConfig.CloudInit.DatasourceList) > 0 {
+			details = append(details, fmt.Sprintf("datasources: %s",
+				strings.Join(osConfig.CloudInit.DatasourceList, ", ")))
+		}
+		if osConfig.CloudInit.NoCloud != nil {
+			seedLocation := osConfig.CloudInit.NoCloud.SeedLocation
+			if seedLocation == imagecustomizerapi.CloudInitSeedLocationDefault {
+				seedLocation = imagecustomizerapi.CloudInitSeedLocationImage
+			}
+			details = append(details, fmt.Sprintf("NoCloud seed: %s", seedLocation))
+		}
+		plan.addStep("Configure cloud-init", details...)
+	}
+	return nil
+}
+
+func planModules(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if len(osConfig.Modules) > 0 {
+		details := []string(nil)
+		for _, module := range osConfig.Modules {
+			loadMode := string(module.LoadMode)
+			if loadMode == "" {
+				loadMode = "auto"
+			}
+			details = append(details, fmt.Sprintf("%s: %s", module.Name, loadMode))
+		}
+		plan.addStep("Configure kernel modules", details...)
+	}
+	return nil
+}
+
+func planSelfTest(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.SelfTest != nil {
+		details := []string(nil)
+		for _, check := range getSelfTestChecks(ic.config) {
+			details = append(details, check.name)
+		}
+		plan.addStep("Add first-boot self-test", details...)
+	}
+	return nil
+}
+
+func planIdLedger(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.IdLedger != nil {
+		details := []string{fmt.Sprintf("path: %s", osConfig.IdLedger.Path)}
+		if osConfig.IdLedger.ReadOnly {
+			details = append(details, "read-only")
+		}
+		plan.addStep("Apply UID/GID ledger", details...)
+	}
+	return nil
+}
+
+func planBootLoader(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.ResetBootLoaderType != imagecustomizerapi.ResetBootLoaderTypeDefault {
+		plan.addStep("Reset bootloader", fmt.Sprintf("type: %s", osConfig.ResetBootLoaderType))
+	}
+
+	if osConfig.KernelCommandLine.ExtraCommandLine != "" {
+		plan.addStep("Add kernel command-line arguments", string(osConfig.KernelCommandLine.ExtraCommandLine))
+	}
+	return nil
+}
+
+func planOverlays(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if osConfig.Overlays != nil && len(*osConfig.Overlays) > 0 {
+		details := []string(nil)
+		for _, overlay := range *osConfig.Overlays {
+			details = append(details, overlay.MountPoint)
+		}
+		plan.addStep("Enable overlays", details...)
+	}
+	return nil
+}
+
+func planWritableLayers(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	if osConfig.WritableLayers != nil {
+		details := []string{fmt.Sprintf("persistent mount point: %s", osConfig.WritableLayers.PersistentMountPoint)}
+		details = append(details, osConfig.WritableLayers.Paths...)
+		plan.addStep("Enable writable layers", details...)
+	}
+	return nil
+}
+
+func planVerity(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	if len(ic.config.Storage.Verity) > 0 {
+		details := []string(nil)
+		for _, verity := range ic.config.Storage.Verity {
+			details = append(details, fmt.Sprintf("%s: data (%s), hash (%s)", verity.Name, verity.DataDeviceId,
+				verity.HashDeviceId))
+		}
+		plan.addStep("Enable verity", details...)
+	}
+	return nil
+}
+
+func planEncryptedVolumes(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	if len(ic.config.Storage.EncryptedVolumes) > 0 {
+		details := []string(nil)
+		for _, encryptedVolume := range ic.config.Storage.EncryptedVolumes {
+			details = append(details, fmt.Sprintf("%s: partition (%s)", encryptedVolume.Name,
+				encryptedVolume.DeviceId))
+		}
+		plan.addStep("Configure encrypted volumes and first-boot per-device keys", details...)
+	}
+	return nil
+}
+
+func planRegenerateInitrd(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	config := ic.config
+	if config.CustomizePartitions() || (osConfig.Overlays != nil && len(*osConfig.Overlays) > 0) ||
+		osConfig.WritableLayers != nil || len(config.Storage.Verity) > 0 || len(config.Storage.EncryptedVolumes) > 0 {
+		plan.addStep("Regenerate initramfs")
+	}
+	return nil
+}
+
+func planModuleSigning(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS,
+) error {
+	moduleSigning := getModuleSigning(osConfig)
+	if moduleSigning != nil {
+		details := []string{"key: ephemeral"}
+		if moduleSigning.Key != nil {
+			details = []string{fmt.Sprintf("key: %s", moduleSigning.Key.PrivateKeyPath)}
+		}
+		if moduleSigning.Enrollment != nil && moduleSigning.Enrollment.Automatic {
+			details = append(details, "automatic MOK enrollment")
+		}
+		plan.addStep("Sign out-of-tree kernel modules and regenerate their initramfs", details...)
+	}
+	return nil
+}
+
+func planHotfix(plan *CustomizationPlan, ic *ImageCustomizerParameters) error {
+	rpmFiles, err := collectHotfixRpms(ic.configPath, ic.config.Hotfix.Rpms)
+	if err != nil {
+		return err
+	}
+
+	plan.addStep("Apply hotfix RPMs", rpmFiles...)
+	plan.addStep("Regenerate affected initramfs files and grub.cfg")
+	plan.addStep("Write customizer release file")
+	plan.addStep("Apply SELinux labels of hotfix files")
+	planSigning(plan, ic.config.Signing)
+
+	if ic.enableShrinkFilesystems {
+		plan.addStep("Shrink filesystems")
+	}
+
+	planFinalize(plan, ic.config.Finalize)
+
+	plan.addStep("Check filesystems")
+
+	return nil
+}
+
+func planInitrdRebuild(plan *CustomizationPlan, ic *ImageCustomizerParameters) error {
+	initrdRebuild := ic.config.InitrdRebuild
+
+	if len(initrdRebuild.DracutConfigFiles) > 0 {
+		details := []string(nil)
+		for _, configFile := range initrdRebuild.DracutConfigFiles {
+			details = append(details, fmt.Sprintf("%s: %s", file.GetAbsPathWithBase(ic.configPath, configFile),
+				filepath.Join(dracutConfigDirInChroot, filepath.Base(configFile))))
+		}
+		plan.addStep("Add dracut config files", details...)
+	}
+
+	plan.addStep("Regenerate initramfs files of all kernels")
+
+	details := []string(nil)
+	for _, arg := range initrdRebuild.KernelCommandLine.SetArgs {
+		details = append(details, fmt.Sprintf("set: %s", arg))
+	}
+	for _, name := range initrdRebuild.KernelCommandLine.RemoveArgs {
+		details = append(details, fmt.Sprintf("remove: %s", name))
+	}
+	plan.addStep("Update kernel command-line and grub.cfg", details...)
+
+	plan.addStep("Write customizer release file")
+	plan.addStep("Apply SELinux labels of changed boot files")
+	planSigning(plan, ic.config.Signing)
+
+	if ic.enableShrinkFilesystems {
+		plan.addStep("Shrink filesystems")
+	}
+
+	planFinalize(plan, ic.config.Finalize)
+
+	plan.addStep("Check filesystems")
+
+	return nil
+}
+
+func planSigning(plan *CustomizationPlan, signing *imagecustomizerapi.Signing) {
+	if signing == nil {
+		return
+	}
+
+	details := []string(nil)
+	for _, artifactType := range signing.ArtifactTypes() {
+		details = append(details, fmt.Sprintf("artifact: %s", artifactType))
+	}
+
+	switch {
+	case signing.Command != nil:
+		details = append(details, fmt.Sprintf("command: %s",
+			createScriptLogName(0, *signing.Command, "signing")))
+
+	case signing.Endpoint != nil:
+		details = append(details, fmt.Sprintf("endpoint: %s", signing.Endpoint.Url))
+
+	case signing.Provider != "":
+		details = append(details, fmt.Sprintf("provider: %s", signing.Provider))
+	}
+
+	plan.addStep("Sign boot artifacts", details...)
+}
+
+func planFinalize(plan *CustomizationPlan, finalize *imagecustomizerapi.Finalize) {
+	if finalize == nil {
+		return
+	}
+
+	if finalize.ShrinkFilesystems != nil {
+		details := []string(nil)
+		if finalize.ShrinkFilesystems.Headroom > 0 {
+			details = append(details,
+				fmt.Sprintf("headroom: %s", finalize.ShrinkFilesystems.Headroom.HumanReadable()))
+		}
+
+		plan.addStep("Shrink filesystems", details...)
+	}
+
+	if finalize.TrimFreeSpace {
+		plan.addStep("Trim filesystems")
+	}
+}
+
+func planStorageDetails(storage *imagecustomizerapi.Storage) []string {
+	details := []string{fmt.Sprintf("boot type: %s", storage.BootType)}
+
+	for _, disk := range storage.Disks {
+		details = append(details, fmt.Sprintf("disk: %s partition table", disk.PartitionTableType))
+
+		for _, partition := range disk.Partitions {
+			size := "grow"
+			if partition.Size.Type == imagecustomizerapi.PartitionSizeTypeExplicit {
+				size = partition.Size.Size.HumanReadable()
+			} else if partition.Size.Type == imagecustomizerapi.PartitionSizeTypeUnset && partition.End != nil {
+				size = fmt.Sprintf("end %s", partition.End.HumanReadable())
+			}
+
+			details = append(details, fmt.Sprintf("partition: %s (%s)", partition.Id, size))
+		}
+	}
+
+	for _, fileSystem := range storage.FileSystems {
+		mountPath := "not mounted"
+		if fileSystem.MountPoint != nil {
+			mountPath = fileSystem.MountPoint.Path
+		}
+
+		details = append(details, fmt.Sprintf("filesystem: %s (%s, %s)", fileSystem.DeviceId, fileSystem.Type,
+			mountPath))
+	}
+
+	return details
+}
+
+func planPackages(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+	// Note: validateConfig has already merged the package list files into the inline package lists.
+	packages := &osConfig.Packages
+
+	if len(packages.Remove) > 0 {
+		plan.addStep("Remove packages", packages.Remove...)
+	}
+
+	if packages.UpdateExistingPackages {
+		plan.addStep("Update existing packages")
+	}
+
+	if len(packages.Install) <= 0 && len(packages.Update) <= 0 {
+		return nil
+	}
+
+	sources, err := getPlanRpmSources(ic.configPath, ic.rpmsSources, packages.LocalRepos)
+	if err != nil {
+		return err
+	}
+
+	otherSources := sources.Other
+	if ic.useBaseImageRpmRepos {
+		otherSources = append(otherSources, "(base image's repos)")
+	}
+
+	available, err := readRpmDirs(sources.Dirs)
+	if err != nil {
+		return err
+	}
+
+	remotePackages, err := readRemoteRepos(ic.buildDirAbs, sources.RemoteRepoUrls)
+	if err != nil {
+		return err
+	}
+	available = append(available, remotePackages...)
+
+	installDetails, err := planPackageTransaction(packages.Install, available, otherSources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve packages to install:\n%w", err)
+	}
+
+	if len(packages.Install) > 0 {
+		plan.addStep("Install packages", installDetails...)
+	}
+
+	updateDetails, err := planPackageTransaction(packages.Update, available, otherSources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve packages to update:\n%w", err)
+	}
+
+	if len(packages.Update) > 0 {
+		plan.addStep("Update packages", updateDetails...)
+	}
+
+	return nil
+}
+
+// planPackageTransaction resolves the requested packages and describes the result. Packages that the RPM directories
+// don't provide are only an error if there aren't any other RPM sources that might provide them.
+func planPackageTransaction(requested []string, available []*rpmPackageInfo, otherSources []string,
+) ([]string, error) {
+	transaction := resolvePackageTransaction(requested, available)
+
+	if len(transaction.Unresolved) > 0 && len(otherSources) <= 0 {
+		return nil, fmt.Errorf("packages not provided by any RPM source: %s",
+			strings.Join(transaction.Unresolved, ", "))
+	}
+
+	details := []string(nil)
+	for _, name := range requested {
+		info, found := transaction.Resolved[name]
+		if !found {
+			details = append(details, fmt.Sprintf("%s (from %s)", name, strings.Join(otherSources, ", ")))
+			continue
+		}
+
+		details = append(details, fmt.Sprintf("%s -> %s", name, info.fullName()))
+	}
+
+	for _, info := range transaction.Dependencies {
+		details = append(details, fmt.Sprintf("dependency: %s", info.fullName()))
+	}
+
+	for _, require := range transaction.ExternalRequires {
+		details = append(details, fmt.Sprintf("requires from image or other sources: %s", require))
+	}
+
+	return details, nil
+}
+
+func planScripts(plan *CustomizationPlan, listName string, scripts []imagecustomizerapi.Script) {
+	if len(scripts) <= 0 {
+		return
+	}
+
+	details := []string(nil)
+	for _, i := range orderScripts(scripts) {
+		script := scripts[i]
+
+		name := script.Name
+		switch {
+		case name != "":
+		case script.Path != "":
+			name = script.Path
+		default:
+			name = fmt.Sprintf("(inline script at index %d)", i)
+		}
+
+		if script.Container != nil {
+			name = fmt.Sprintf("%s (container: %s)", name, script.Container.Image)
+		}
+
+		details = append(details, name)
+	}
+
+	plan.addStep(fmt.Sprintf("Run %s scripts", listName), details...)
+}
+
+func planOutput(plan *CustomizationPlan, ic *ImageCustomizerParameters) {
+	if ic.outputSplitPartitionsFormat != "" {
+		plan.addStep("Extract partitions", fmt.Sprintf("format: %s", ic.outputSplitPartitionsFormat),
+			fmt.Sprintf("directory: %s", ic.outputImageDir))
+	}
+
+	if ic.outputImageFormat != "" {
+		details := []string{
+			fmt.Sprintf("file: %s", ic.outputImageFile),
+			fmt.Sprintf("format: %s", ic.outputImageFormat),
+		}
+
+		if ic.outputPXEArtifactsDir != "" {
+			details = append(details, fmt.Sprintf("PXE artifacts: %s", ic.outputPXEArtifactsDir))
+		}
+
+		if ic.outputConverter != nil {
+			details = append(details, fmt.Sprintf("plugin: %s", ic.outputConverter))
+		}
+
+		if ic.outputIsContainer {
+			details = append(details, planContainerImageDetails(ic.config.ContainerImage)...)
+		}
+
+		if ic.config.Finalize != nil && ic.config.Finalize.Sparse && isSparseImageFormat(ic.outputImageFormat) {
+			details = append(details, "sparse: true")
+		}
+
+		if ic.outputIsWsl && ic.config.Wsl != nil && ic.config.Wsl.DefaultUser != "" {
+			details = append(details, fmt.Sprintf("default user: %s", ic.config.Wsl.DefaultUser))
+		}
+
+		plan.addStep("Write output image", details...)
+	}
+
+	if ic.config.ChangeManifest != nil {
+		plan.addStep("Write change manifest")
+	}
+
+	if ic.config.OS != nil && ic.config.OS.CloudInit != nil && ic.config.OS.CloudInit.NoCloud != nil &&
+		ic.config.OS.CloudInit.NoCloud.SeedLocation == imagecustomizerapi.CloudInitSeedLocationIso {
+		plan.addStep("Write cloud-init seed ISO",
+			fmt.Sprintf("file: %s", filepath.Join(ic.outputImageDir, ic.outputImageBase+cloudInitSeedIsoFileSuffix)))
+	}
+
+	if getModuleSigning(ic.config.OS) != nil {
+		plan.addStep("Write MOK certificate and enrollment instructions",
+			fmt.Sprintf("file: %s", filepath.Join(ic.outputImageDir, ic.outputImageBase+mokCertificateFileSuffix)),
+			fmt.Sprintf("file: %s", filepath.Join(ic.outputImageDir, ic.outputImageBase+mokInstructionsFileSuffix)))
+	}
+
+	if ic.config.SELinuxReport != nil {
+		plan.addStep("Write SELinux report",
+			fmt.Sprintf("file: %s", filepath.Join(ic.outputImageDir, ic.outputImageBase+selinuxReportFileSuffix)))
+	}
+
+	if ic.config.Validation != nil {
+		details := []string(nil)
+		if ic.config.Validation.HasImageChecks() {
+			details = append(details, "image checks")
+		}
+		if ic.config.Validation.BootSmokeTest != nil {
+			details = append(details, "boot smoke test")
+		}
+		details = append(details, fmt.Sprintf("file: %s",
+			filepath.Join(ic.outputImageDir, ic.outputImageBase+validationReportFileSuffix)))
+
+		plan.addStep("Validate image", details...)
+	}
+
+	if ic.config.Hotfix != nil {
+		plan.addStep("Write hotfix report",
+			fmt.Sprintf("file: %s", filepath.Join(ic.outputImageDir, ic.outputImageBase+hotfixReportFileSuffix)))
+	}
+}
+
+func planContainerImageDetails(containerImage *imagecustomizerapi.ContainerImage) []string {
+	if containerImage == nil {
+		containerImage = &imagecustomizerapi.ContainerImage{}
+	}
+
+	details := []string(nil)
+	if containerImage.Tag != "" {
+		details = append(details, fmt.Sprintf("tag: %s", containerImage.Tag))
+	}
+
+	if containerImage.Squash {
+		details = append(details, "layers: squashed")
+	} else {
+		details = append(details, "layers: base image, customizations")
+	}
+
+	if len(containerImage.Entrypoint) > 0 {
+		details = append(details, fmt.Sprintf("entrypoint: %v", containerImage.Entrypoint))
+	}
+
+	return details
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanCustomization(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestPlanCustomization")
+	defer os.RemoveAll(testTmpDir)
+
+	buildDir := filepath.Join(testTmpDir, "build")
+	imageFile := filepath.Join(testTmpDir, "base.vhdx")
+	outImageFilePath := filepath.Join(testTmpDir, "image.qcow2")
+
+	err := os.MkdirAll(testTmpDir, os.ModePerm)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = file.Write("", imageFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{
+			Hostname: "testname",
+			Packages: imagecustomizerapi.Packages{
+				Remove: []string{"jq"},
+			},
+			Services: imagecustomizerapi.Services{
+				Enable: []string{"sshd"},
+			},
+		},
+		Scripts: imagecustomizerapi.Scripts{
+			PostConfig: []imagecustomizerapi.Script{
+				{Name: "second", Content: "true", Order: 2},
+				{Content: "true", Order: 1},
+			},
+		},
+	}
+
+	plan, err := PlanCustomization(buildDir, testDir, config, imageFile, nil, outImageFilePath, "qcow2", "", "",
+		false, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, ""+
+		"1. Convert input image\n"+
+		"   - file: "+imageFile+"\n"+
+		"   - format: vhdx\n"+
+		"2. Check base image compatibility\n"+
+		"3. Remove packages\n"+
+		"   - jq\n"+
+		"4. Set hostname\n"+
+		"   - testname\n"+
+		"5. Enable or disable services\n"+
+		"   - enable: sshd\n"+
+		"6. Run postConfig scripts\n"+
+		"   - (inline script at index 1)\n"+
+		"   - second\n"+
+		"7. Write customizer release file\n"+
+		"8. Apply SELinux file labels\n"+
+		"9. Check filesystems\n"+
+		"10. Write output image\n"+
+		"   - file: "+outImageFilePath+"\n"+
+		"   - format: qcow2\n",
+		plan.String())
+
+	// Nothing should have been written.
+	exists, err := file.PathExists(buildDir)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = file.PathExists(outImageFilePath)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPlanCustomizationUnresolvedPackages(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestPlanCustomizationUnresolvedPackages")
+	defer os.RemoveAll(testTmpDir)
+
+	imageFile := filepath.Join(testTmpDir, "base.vhdx")
+	rpmsDir := filepath.Join(testTmpDir, "rpms")
+
+	err := os.MkdirAll(rpmsDir, os.ModePerm)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = file.Write("", imageFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{
+			Packages: imagecustomizerapi.Packages{
+				Install: []string{"jq"},
+			},
+		},
+	}
+
+	_, err = PlanCustomization(filepath.Join(testTmpDir, "build"), testDir, config, imageFile, []string{rpmsDir},
+		filepath.Join(testTmpDir, "image.vhdx"), "vhdx", "", "", false, false)
+	assert.ErrorContains(t, err, "packages not provided by any RPM source: jq")
+}
+
+func TestPlanCustomizationMissingImage(t *testing.T) {
+	config := &imagecustomizerapi.Config{}
+
+	_, err := PlanCustomization(filepath.Join(tmpDir, "build"), testDir, config,
+		filepath.Join(tmpDir, "missing.vhdx"), nil, filepath.Join(tmpDir, "image.vhdx"), "vhdx", "", "", false,
+		false)
+	assert.ErrorContains(t, err, "base image ("+filepath.Join(tmpDir, "missing.vhdx")+") doesn't exist")
+}
@@ -0,0 +1,361 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safeloopback"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	// The grubenv file, on the ESP, that selects which slot to boot.
+	abUpdateSlotEnvFileName = "ab-slot.env"
+	abUpdateSlotEnvFilePath = "/boot/grub2/" + abUpdateSlotEnvFileName
+	// The grubenv variable that selects which slot to boot.
+	abUpdateSlotEnvVariable = "ab_slot"
+
+	abUpdateSlotA = "a"
+	abUpdateSlotB = "b"
+
+	// The size of a grubenv file. grub requires the file to be exactly this size.
+	grubEnvSize   = 1024
+	grubEnvHeader = "# GRUB Environment Block\n"
+)
+
+var (
+	// The files, within a slot's root filesystem, that may reference the slot's partition.
+	abUpdateSlotReferenceFiles = []string{
+		"/etc/fstab",
+		"/etc/default/grub",
+		installutils.GrubCfgFile,
+		"/boot/grub2/grubenv",
+	}
+)
+
+// abUpdateMetadata is the file that describes the A/B update slots to update agents.
+type abUpdateMetadata struct {
+	// The slot that the root filesystem containing this file belongs to.
+	CurrentSlot string          `json:"currentSlot"`
+	Slots       []abUpdateSlot  `json:"slots"`
+	BootEnv     abUpdateBootEnv `json:"bootEnv"`
+}
+
+type abUpdateSlot struct {
+	Name        string `json:"name"`
+	PartitionId string `json:"partitionId"`
+	PartUuid    string `json:"partUuid"`
+	PartLabel   string `json:"partLabel,omitempty"`
+	FsUuid      string `json:"fsUuid"`
+}
+
+// abUpdateBootEnv describes how to select the slot that is booted.
+type abUpdateBootEnv struct {
+	// The PARTUUID of the ESP.
+	EspPartUuid string `json:"espPartUuid"`
+	// The path of the grubenv file, relative to the root of the ESP.
+	Path string `json:"path"`
+	// The grubenv variable. Set to "a" or "b".
+	Variable string `json:"variable"`
+}
+
+// prepareAbUpdateMetadataFile creates an empty metadata file, so that the file gets its SELinux label along with
+// the rest of the OS. The contents are filled in by createAbUpdateSlotHelper, once the slot UUIDs are known.
+func prepareAbUpdateMetadataFile(abUpdate *imagecustomizerapi.AbUpdate, imageChroot *safechroot.Chroot) error {
+	if abUpdate == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Prepare A/B update metadata file")
+
+	metadataFilePath := filepath.Join(imageChroot.RootDir(), abUpdate.GetMetadataPath())
+
+	err := os.MkdirAll(filepath.Dir(metadataFilePath), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create A/B update metadata directory:\n%w", err)
+	}
+
+	err = os.WriteFile(metadataFilePath, []byte("{}\n"), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write A/B update metadata file (%s):\n%w", metadataFilePath, err)
+	}
+
+	return nil
+}
+
+// createAbUpdateSlotHelper creates slot B by copying slot A's partition. Slot B is given a new filesystem UUID and
+// its references to slot A are updated. Then the ESP's grub.cfg is updated to boot the slot selected by the
+// ESP's grubenv file.
+func createAbUpdateSlotHelper(buildDir string, config *imagecustomizerapi.Config, buildImageFile string,
+	partIdToPartUuid map[string]string,
+) error {
+	logger.Log.Infof("Creating A/B update slot B")
+
+	abUpdate := config.Storage.AbUpdate
+
+	loopback, err := safeloopback.NewLoopback(buildImageFile)
+	if err != nil {
+		return fmt.Errorf("failed to connect to image file to create A/B update slot:\n%w", err)
+	}
+	defer loopback.Close()
+
+	diskPartitions, err := diskutils.GetDiskPartitions(loopback.DevicePath())
+	if err != nil {
+		return err
+	}
+
+	slotA, err := findPartitionByDeviceId(abUpdate.SlotAPartitionId, diskPartitions, partIdToPartUuid)
+	if err != nil {
+		return err
+	}
+
+	slotB, err := findPartitionByDeviceId(abUpdate.SlotBPartitionId, diskPartitions, partIdToPartUuid)
+	if err != nil {
+		return err
+	}
+
+	esp, err := findSystemBootPartition(diskPartitions)
+	if err != nil {
+		return err
+	}
+
+	ddArgs := []string{
+		fmt.Sprintf("if=%s", slotA.Path),
+		fmt.Sprintf("of=%s", slotB.Path),
+		"bs=1M",
+		"conv=fsync",
+	}
+
+	err = shell.ExecuteLive(true /*squashErrors*/, "dd", ddArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to copy partition (%s) to (%s):\n%w", slotA.Path, slotB.Path, err)
+	}
+
+	// The two slots must not share a filesystem UUID.
+	slotB.FileSystemType = slotA.FileSystemType
+	slotB.Uuid, err = resetFileSystemUuid(slotB)
+	if err != nil {
+		return fmt.Errorf("failed to reset slot B's filesystem UUID:\n%w", err)
+	}
+
+	metadata := abUpdateMetadata{
+		Slots: []abUpdateSlot{
+			newAbUpdateSlot(abUpdateSlotA, abUpdate.SlotAPartitionId, slotA),
+			newAbUpdateSlot(abUpdateSlotB, abUpdate.SlotBPartitionId, slotB),
+		},
+		BootEnv: abUpdateBootEnv{
+			EspPartUuid: esp.PartUuid,
+			Path:        abUpdateSlotEnvFilePath,
+			Variable:    abUpdateSlotEnvVariable,
+		},
+	}
+
+	mountDir := filepath.Join(buildDir, tmpParitionDirName)
+
+	err = withMountedDevice(slotA.Path, slotA.FileSystemType, mountDir, func() error {
+		return writeAbUpdateMetadata(mountDir, abUpdate.GetMetadataPath(), metadata, abUpdateSlotA)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update slot A:\n%w", err)
+	}
+
+	err = withMountedDevice(slotB.Path, slotB.FileSystemType, mountDir, func() error {
+		err := updateAbUpdateSlotReferences(mountDir, slotA, slotB)
+		if err != nil {
+			return err
+		}
+
+		return writeAbUpdateMetadata(mountDir, abUpdate.GetMetadataPath(), metadata, abUpdateSlotB)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update slot B:\n%w", err)
+	}
+
+	err = withMountedDevice(esp.Path, esp.FileSystemType, mountDir, func() error {
+		return updateEspForAbUpdate(mountDir, slotA.Uuid, slotB.Uuid)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update ESP for A/B update:\n%w", err)
+	}
+
+	err = loopback.CleanClose()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func findPartitionByDeviceId(configDeviceId string, diskPartitions []diskutils.PartitionInfo,
+	partIdToPartUuid map[string]string,
+) (diskutils.PartitionInfo, error) {
+	for _, partition := range diskPartitions {
+		if partition.Type == "part" && partitionMatchesDeviceId(configDeviceId, partition, partIdToPartUuid) {
+			return partition, nil
+		}
+	}
+
+	return diskutils.PartitionInfo{}, fmt.Errorf("no partition found with id (%s)", configDeviceId)
+}
+
+func newAbUpdateSlot(name string, partitionId string, partition diskutils.PartitionInfo) abUpdateSlot {
+	return abUpdateSlot{
+		Name:        name,
+		PartitionId: partitionId,
+		PartUuid:    partition.PartUuid,
+		PartLabel:   partition.PartLabel,
+		FsUuid:      partition.Uuid,
+	}
+}
+
+func writeAbUpdateMetadata(rootDir string, metadataPath string, metadata abUpdateMetadata, currentSlot string,
+) error {
+	metadata.CurrentSlot = currentSlot
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize A/B update metadata:\n%w", err)
+	}
+
+	// Overwrite the existing file in place, to keep its SELinux label.
+	metadataFilePath := filepath.Join(rootDir, metadataPath)
+	err = os.WriteFile(metadataFilePath, append(data, '\n'), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write A/B update metadata file (%s):\n%w", metadataFilePath, err)
+	}
+
+	return nil
+}
+
+// updateAbUpdateSlotReferences replaces slot A's identifiers with slot B's in the copy of the OS in slot B, so that
+// slot B mounts and boots itself.
+func updateAbUpdateSlotReferences(rootDir string, slotA diskutils.PartitionInfo, slotB diskutils.PartitionInfo,
+) error {
+	for _, referenceFile := range abUpdateSlotReferenceFiles {
+		filePath := filepath.Join(rootDir, referenceFile)
+
+		content, err := os.ReadFile(filePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file (%s):\n%w", filePath, err)
+		}
+
+		newContent := replaceAbUpdateSlotReferences(string(content), slotA, slotB)
+		if newContent == string(content) {
+			continue
+		}
+
+		if strings.HasPrefix(newContent, grubEnvHeader) {
+			newContent, err = padGrubEnv(newContent)
+			if err != nil {
+				return fmt.Errorf("failed to update file (%s):\n%w", filePath, err)
+			}
+		}
+
+		// Overwrite the existing file in place, to keep its permissions and SELinux label.
+		err = os.WriteFile(filePath, []byte(newContent), 0)
+		if err != nil {
+			return fmt.Errorf("failed to write file (%s):\n%w", filePath, err)
+		}
+	}
+
+	return nil
+}
+
+func replaceAbUpdateSlotReferences(content string, slotA diskutils.PartitionInfo, slotB diskutils.PartitionInfo,
+) string {
+	content = strings.ReplaceAll(content, slotA.Uuid, slotB.Uuid)
+	content = strings.ReplaceAll(content, slotA.PartUuid, slotB.PartUuid)
+
+	if slotA.PartLabel != "" {
+		partLabelRegex := regexp.MustCompile(`PARTLABEL=` + regexp.QuoteMeta(slotA.PartLabel) + `(\s|"|$)`)
+		content = partLabelRegex.ReplaceAllString(content, "PARTLABEL="+slotB.PartLabel+"${1}")
+	}
+
+	return content
+}
+
+// updateEspForAbUpdate updates the ESP's grub.cfg, so that it loads the grub.cfg of the slot selected by the ESP's
+// grubenv file. Slot A remains the first search command, so that the image can still be customized as a base image.
+func updateEspForAbUpdate(espDir string, slotAUuid string, slotBUuid string) error {
+	grubCfgFilePath := filepath.Join(espDir, installutils.GrubCfgFile)
+
+	grubCfgContent, err := os.ReadFile(grubCfgFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read ESP's grub.cfg file:\n%w", err)
+	}
+
+	newGrubCfgContent, err := addAbUpdateSlotSelection(string(grubCfgContent), slotAUuid, slotBUuid)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(grubCfgFilePath, []byte(newGrubCfgContent), 0)
+	if err != nil {
+		return fmt.Errorf("failed to write ESP's grub.cfg file:\n%w", err)
+	}
+
+	slotEnvFilePath := filepath.Join(espDir, abUpdateSlotEnvFilePath)
+	slotEnvContent, err := padGrubEnv(grubEnvHeader + abUpdateSlotEnvVariable + "=" + abUpdateSlotA + "\n")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(slotEnvFilePath, []byte(slotEnvContent), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write A/B update slot env file (%s):\n%w", slotEnvFilePath, err)
+	}
+
+	return nil
+}
+
+func addAbUpdateSlotSelection(grubCfgContent string, slotAUuid string, slotBUuid string) (string, error) {
+	match := bootPartitionRegex.FindStringSubmatchIndex(grubCfgContent)
+	if match == nil {
+		return "", fmt.Errorf("failed to find boot partition in ESP's grub.cfg file")
+	}
+
+	bootUuid := grubCfgContent[match[2]:match[3]]
+	if bootUuid != slotAUuid {
+		return "", fmt.Errorf("ESP's grub.cfg file boots from (%s) instead of slot A (%s)", bootUuid, slotAUuid)
+	}
+
+	slotSelection := []string{
+		"# A/B update slot selection. Generated by the Azure Linux Image Customizer.",
+		"set " + abUpdateSlotEnvVariable + "=" + abUpdateSlotA,
+		"if [ -f $prefix/" + abUpdateSlotEnvFileName + " ]; then",
+		"\tload_env -f $prefix/" + abUpdateSlotEnvFileName + " " + abUpdateSlotEnvVariable,
+		"fi",
+		grubCfgContent[match[0]:match[1]],
+		"if [ \"$" + abUpdateSlotEnvVariable + "\" = \"" + abUpdateSlotB + "\" ]; then",
+		"\tsearch -n -u " + slotBUuid + " -s",
+		"fi",
+	}
+
+	newGrubCfgContent := grubCfgContent[:match[0]] + strings.Join(slotSelection, "\n") + grubCfgContent[match[1]:]
+	return newGrubCfgContent, nil
+}
+
+// padGrubEnv pads a grubenv file's content to the size that grub requires.
+func padGrubEnv(content string) (string, error) {
+	content = strings.TrimRight(content, "#")
+	if len(content) > grubEnvSize {
+		return "", fmt.Errorf("grubenv content is larger than %d bytes", grubEnvSize)
+	}
+
+	return content + strings.Repeat("#", grubEnvSize-len(content)), nil
+}
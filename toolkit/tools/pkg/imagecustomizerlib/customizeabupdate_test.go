@@ -0,0 +1,162 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testAbUpdateSlotA = diskutils.PartitionInfo{
+		Uuid:      "aaaaaaaa-0000-0000-0000-000000000000",
+		PartUuid:  "aaaaaaaa-1111-1111-1111-111111111111",
+		PartLabel: "rootfs",
+	}
+	testAbUpdateSlotB = diskutils.PartitionInfo{
+		Uuid:      "bbbbbbbb-0000-0000-0000-000000000000",
+		PartUuid:  "bbbbbbbb-1111-1111-1111-111111111111",
+		PartLabel: "rootfs-b",
+	}
+)
+
+func TestPadGrubEnv(t *testing.T) {
+	content, err := padGrubEnv(grubEnvHeader + "ab_slot=a\n")
+	assert.NoError(t, err)
+	assert.Len(t, content, grubEnvSize)
+	assert.True(t, strings.HasPrefix(content, grubEnvHeader+"ab_slot=a\n#"))
+
+	// Padding an already padded file doesn't change it.
+	repadded, err := padGrubEnv(content)
+	assert.NoError(t, err)
+	assert.Equal(t, content, repadded)
+}
+
+func TestPadGrubEnvTooLarge(t *testing.T) {
+	_, err := padGrubEnv(strings.Repeat("a", grubEnvSize+1))
+	assert.ErrorContains(t, err, "grubenv content is larger than 1024 bytes")
+}
+
+func TestReplaceAbUpdateSlotReferences(t *testing.T) {
+	content := "UUID=aaaaaaaa-0000-0000-0000-000000000000 / ext4 defaults 0 1\n" +
+		"PARTUUID=aaaaaaaa-1111-1111-1111-111111111111 /mnt ext4 defaults 0 2\n" +
+		"PARTLABEL=rootfs /mnt2 ext4 defaults 0 2\n" +
+		"PARTLABEL=rootfs2 /mnt3 ext4 defaults 0 2\n" +
+		"kernelopts=\"root=PARTLABEL=rootfs\"\n"
+
+	expected := "UUID=bbbbbbbb-0000-0000-0000-000000000000 / ext4 defaults 0 1\n" +
+		"PARTUUID=bbbbbbbb-1111-1111-1111-111111111111 /mnt ext4 defaults 0 2\n" +
+		"PARTLABEL=rootfs-b /mnt2 ext4 defaults 0 2\n" +
+		"PARTLABEL=rootfs2 /mnt3 ext4 defaults 0 2\n" +
+		"kernelopts=\"root=PARTLABEL=rootfs-b\"\n"
+
+	assert.Equal(t, expected, replaceAbUpdateSlotReferences(content, testAbUpdateSlotA, testAbUpdateSlotB))
+}
+
+func TestUpdateAbUpdateSlotReferencesGrubEnv(t *testing.T) {
+	rootDir := filepath.Join(t.TempDir(), "root")
+	grubEnvPath := filepath.Join(rootDir, "boot/grub2/grubenv")
+
+	grubEnv, err := padGrubEnv(grubEnvHeader + "kernelopts=root=PARTUUID=aaaaaaaa-1111-1111-1111-111111111111\n")
+	assert.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Dir(grubEnvPath), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(grubEnvPath, []byte(grubEnv), 0o600)
+	assert.NoError(t, err)
+
+	err = updateAbUpdateSlotReferences(rootDir, testAbUpdateSlotA, testAbUpdateSlotB)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(grubEnvPath)
+	assert.NoError(t, err)
+	assert.Len(t, content, grubEnvSize)
+	assert.True(t, strings.HasPrefix(string(content),
+		grubEnvHeader+"kernelopts=root=PARTUUID=bbbbbbbb-1111-1111-1111-111111111111\n#"))
+
+	// The file's permissions are kept.
+	stat, err := os.Stat(grubEnvPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), stat.Mode().Perm())
+}
+
+func TestAddAbUpdateSlotSelection(t *testing.T) {
+	grubCfg := "search -n -u aaaaaaaa-0000-0000-0000-000000000000 -s\n" +
+		"set prefix=($root)\"/boot/grub2\"\n" +
+		"configfile $prefix/grub.cfg\n"
+
+	newGrubCfg, err := addAbUpdateSlotSelection(grubCfg, testAbUpdateSlotA.Uuid, testAbUpdateSlotB.Uuid)
+	assert.NoError(t, err)
+	assert.Equal(t, "# A/B update slot selection. Generated by the Azure Linux Image Customizer.\n"+
+		"set ab_slot=a\n"+
+		"if [ -f $prefix/ab-slot.env ]; then\n"+
+		"\tload_env -f $prefix/ab-slot.env ab_slot\n"+
+		"fi\n"+
+		"search -n -u aaaaaaaa-0000-0000-0000-000000000000 -s\n"+
+		"if [ \"$ab_slot\" = \"b\" ]; then\n"+
+		"\tsearch -n -u bbbbbbbb-0000-0000-0000-000000000000 -s\n"+
+		"fi\n"+
+		"set prefix=($root)\"/boot/grub2\"\n"+
+		"configfile $prefix/grub.cfg\n", newGrubCfg)
+
+	// Slot A's search command can still be found, for when the image is used as a base image.
+	match := bootPartitionRegex.FindStringSubmatch(newGrubCfg)
+	assert.Equal(t, testAbUpdateSlotA.Uuid, match[1])
+}
+
+func TestAddAbUpdateSlotSelectionWrongBootPartition(t *testing.T) {
+	grubCfg := "search -n -u cccccccc-0000-0000-0000-000000000000 -s\n"
+
+	_, err := addAbUpdateSlotSelection(grubCfg, testAbUpdateSlotA.Uuid, testAbUpdateSlotB.Uuid)
+	assert.ErrorContains(t, err, "boots from (cccccccc-0000-0000-0000-000000000000) instead of slot A")
+}
+
+func TestAddAbUpdateSlotSelectionMissingSearch(t *testing.T) {
+	_, err := addAbUpdateSlotSelection("configfile $prefix/grub.cfg\n", testAbUpdateSlotA.Uuid,
+		testAbUpdateSlotB.Uuid)
+	assert.ErrorContains(t, err, "failed to find boot partition in ESP's grub.cfg file")
+}
+
+func TestWriteAbUpdateMetadata(t *testing.T) {
+	rootDir := t.TempDir()
+	metadataPath := "/etc/ab-update/slots.json"
+
+	err := os.MkdirAll(filepath.Join(rootDir, "etc/ab-update"), os.ModePerm)
+	assert.NoError(t, err)
+
+	metadata := abUpdateMetadata{
+		Slots: []abUpdateSlot{
+			newAbUpdateSlot(abUpdateSlotA, "rootfs", testAbUpdateSlotA),
+			newAbUpdateSlot(abUpdateSlotB, "rootfs-b", testAbUpdateSlotB),
+		},
+		BootEnv: abUpdateBootEnv{
+			EspPartUuid: "eeeeeeee-1111-1111-1111-111111111111",
+			Path:        abUpdateSlotEnvFilePath,
+			Variable:    abUpdateSlotEnvVariable,
+		},
+	}
+
+	err = writeAbUpdateMetadata(rootDir, metadataPath, metadata, abUpdateSlotB)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(rootDir, metadataPath))
+	assert.NoError(t, err)
+
+	var actual abUpdateMetadata
+	err = json.Unmarshal(content, &actual)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", actual.CurrentSlot)
+	assert.Len(t, actual.Slots, 2)
+	assert.Equal(t, "rootfs-b", actual.Slots[1].PartitionId)
+	assert.Equal(t, testAbUpdateSlotB.Uuid, actual.Slots[1].FsUuid)
+	assert.Equal(t, "/boot/grub2/ab-slot.env", actual.BootEnv.Path)
+	assert.Equal(t, "ab_slot", actual.BootEnv.Variable)
+}
@@ -0,0 +1,233 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	batchBuildDirName        = "batch"
+	batchInputCacheDirName   = "batch-input-cache"
+	batchPackageCacheDirName = "batch-package-cache"
+	batchReportFileName      = "batch-report.json"
+)
+
+// BatchBuild is the build of a single image of a batch manifest.
+type BatchBuild struct {
+	Name string
+	// BuildDir is the image's own build directory.
+	BuildDir          string
+	ImageFile         string
+	ConfigFile        string
+	OutputImageFile   string
+	OutputImageFormat string
+	// LogFile is the file that the image's build writes its log to.
+	LogFile string
+}
+
+// BatchBuildResult is the outcome of a BatchBuild.
+type BatchBuildResult struct {
+	Build    BatchBuild
+	Duration time.Duration
+	Err      error
+}
+
+type batchReport struct {
+	ManifestFile string             `json:"manifestFile"`
+	Images       []batchReportImage `json:"images"`
+}
+
+type batchReportImage struct {
+	Name            string          `json:"name"`
+	ImageFile       string          `json:"imageFile"`
+	ConfigFile      string          `json:"configFile"`
+	Succeeded       bool            `json:"succeeded"`
+	Error           string          `json:"error,omitempty"`
+	DurationSeconds float64         `json:"durationSeconds"`
+	LogFile         string          `json:"logFile"`
+	Artifacts       []buildArtifact `json:"artifacts"`
+}
+
+// GetBatchInputImageCacheDir returns the directory that a batch build shares its converted input images in.
+func GetBatchInputImageCacheDir(buildDir string) string {
+	return filepath.Join(buildDir, batchInputCacheDirName)
+}
+
+// GetBatchPackageCacheDir returns the directory that a batch build shares its package stage cache in.
+func GetBatchPackageCacheDir(buildDir string) string {
+	return filepath.Join(buildDir, batchPackageCacheDirName)
+}
+
+// GetBatchReportFile returns the default path of a batch build's report.
+func GetBatchReportFile(buildDir string) string {
+	return filepath.Join(buildDir, batchReportFileName)
+}
+
+// PlanBatchBuilds loads a batch manifest and plans a build for each of its images. The relative paths within the
+// manifest are relative to the manifest's directory.
+//
+// Each image is built within its own subdirectory of the build directory. The config of each image is loaded up
+// front, so that a bad config doesn't fail the batch after the other images have been built.
+func PlanBatchBuilds(buildDir string, manifestFile string) ([]BatchBuild, error) {
+	var manifest imagecustomizerapi.BatchManifest
+	err := imagecustomizerapi.UnmarshalYamlFile(manifestFile, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batch manifest (%s):\n%w", manifestFile, err)
+	}
+
+	absManifestFile, err := filepath.Abs(manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of batch manifest:\n%w", err)
+	}
+	baseDir := filepath.Dir(absManifestFile)
+
+	builds := []BatchBuild(nil)
+	nameIndexes := make(map[string]int)
+	outputImageFileNames := make(map[string]string)
+	artifactsDirNames := make(map[string]string)
+	for i, image := range manifest.Images {
+		build := BatchBuild{
+			Name:              image.Name,
+			ImageFile:         image.ImageFile,
+			ConfigFile:        file.GetAbsPathWithBase(baseDir, image.ConfigFile),
+			OutputImageFile:   file.GetAbsPathWithBase(baseDir, image.OutputImageFile),
+			OutputImageFormat: image.OutputImageFormat,
+		}
+
+		if !isImageUrl(build.ImageFile) {
+			build.ImageFile = file.GetAbsPathWithBase(baseDir, build.ImageFile)
+		}
+
+		if build.Name == "" {
+			build.Name = strings.TrimSuffix(filepath.Base(build.OutputImageFile), filepath.Ext(build.OutputImageFile))
+		}
+
+		if build.OutputImageFormat == "" {
+			build.OutputImageFormat = strings.TrimPrefix(filepath.Ext(build.OutputImageFile), ".")
+			if build.OutputImageFormat == "" {
+				return nil, fmt.Errorf("batch image (%s) must specify 'outputImageFormat', since its output image "+
+					"file (%s) doesn't have an extension", build.Name, build.OutputImageFile)
+			}
+		}
+
+		if otherIndex, found := nameIndexes[build.Name]; found {
+			return nil, fmt.Errorf("batch images at index %d and %d have the same name (%s):\nspecify 'name' for "+
+				"each image", otherIndex, i, build.Name)
+		}
+		nameIndexes[build.Name] = i
+
+		if otherName, found := outputImageFileNames[build.OutputImageFile]; found {
+			return nil, fmt.Errorf("batch images (%s) and (%s) have the same output image file (%s)", otherName,
+				build.Name, build.OutputImageFile)
+		}
+		outputImageFileNames[build.OutputImageFile] = build.Name
+
+		config, baseConfigPath, _, err := loadConfigFile(build.ConfigFile, build.ImageFile, CustomizeImageOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config of batch image (%s):\n%w", build.Name, err)
+		}
+
+		if config.Scripts.OutputArtifactsDir != "" {
+			artifactsDir := file.GetAbsPathWithBase(baseConfigPath, config.Scripts.OutputArtifactsDir)
+			if otherName, found := artifactsDirNames[artifactsDir]; found {
+				return nil, fmt.Errorf("batch images (%s) and (%s) have the same scripts output artifacts directory "+
+					"(%s)", otherName, build.Name, artifactsDir)
+			}
+			artifactsDirNames[artifactsDir] = build.Name
+		}
+
+		build.BuildDir = filepath.Join(buildDir, batchBuildDirName, build.Name)
+		build.LogFile = filepath.Join(buildDir, batchBuildDirName, build.Name+".log")
+
+		builds = append(builds, build)
+	}
+
+	return builds, nil
+}
+
+// RunBatchBuilds calls runBuild for each of the builds, with up to parallelism builds running at the same time.
+// The results are in the same order as the builds.
+func RunBatchBuilds(builds []BatchBuild, parallelism int, runBuild func(build BatchBuild) error,
+) []BatchBuildResult {
+	results := make([]BatchBuildResult, len(builds))
+	runInParallel(len(builds), parallelism, func(i int) {
+		build := builds[i]
+
+		logger.Log.Infof("Building batch image (%s)", build.Name)
+
+		startTime := time.Now()
+		err := runBuild(build)
+		results[i] = BatchBuildResult{
+			Build:    build,
+			Duration: time.Since(startTime),
+			Err:      err,
+		}
+
+		if err != nil {
+			logger.Log.Errorf("Batch image (%s) failed (log: %s):\n%v", build.Name, build.LogFile, err)
+		} else {
+			logger.Log.Infof("Batch image (%s) succeeded (%s)", build.Name, results[i].Duration.Round(time.Second))
+		}
+	})
+
+	return results
+}
+
+// WriteBatchReport writes a report of the results of the batch builds. Returns an error that lists the images that
+// failed (if any).
+func WriteBatchReport(reportFile string, manifestFile string, results []BatchBuildResult) error {
+	report := batchReport{
+		ManifestFile: manifestFile,
+		Images:       []batchReportImage{},
+	}
+
+	failedImages := []string(nil)
+	for _, result := range results {
+		image := batchReportImage{
+			Name:            result.Build.Name,
+			ImageFile:       result.Build.ImageFile,
+			ConfigFile:      result.Build.ConfigFile,
+			Succeeded:       result.Err == nil,
+			DurationSeconds: result.Duration.Seconds(),
+			LogFile:         result.Build.LogFile,
+			Artifacts:       []buildArtifact{},
+		}
+
+		if result.Err != nil {
+			image.Error = result.Err.Error()
+			failedImages = append(failedImages, image.Name)
+		} else {
+			artifacts, err := getBuildArtifacts(result.Build.OutputImageFile)
+			if err != nil {
+				return fmt.Errorf("failed to list artifacts of batch image (%s):\n%w", image.Name, err)
+			}
+			image.Artifacts = artifacts
+		}
+
+		report.Images = append(report.Images, image)
+	}
+
+	err := jsonutils.WriteJSONFile(reportFile, report)
+	if err != nil {
+		return fmt.Errorf("failed to write batch report (%s):\n%w", reportFile, err)
+	}
+
+	logger.Log.Infof("Batch report: %s", reportFile)
+
+	if len(failedImages) > 0 {
+		return fmt.Errorf("%d of %d batch images failed: %s", len(failedImages), len(results),
+			strings.Join(failedImages, ", "))
+	}
+
+	return nil
+}
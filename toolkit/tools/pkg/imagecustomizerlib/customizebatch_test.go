@@ -0,0 +1,199 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeBatchTestFiles(t *testing.T, manifest string) string {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "web.yaml"), []byte("os:\n  hostname: web\n"), 0o644)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "db.yaml"), []byte("os:\n  hostname: db\n"), 0o644)
+	require.NoError(t, err)
+
+	manifestFile := filepath.Join(dir, "batch.yaml")
+	err = os.WriteFile(manifestFile, []byte(manifest), 0o644)
+	require.NoError(t, err)
+
+	return manifestFile
+}
+
+func TestPlanBatchBuilds(t *testing.T) {
+	manifestFile := writeBatchTestFiles(t, `
+images:
+- imageFile: base.vhdx
+  configFile: web.yaml
+  outputImageFile: out/web.vhdx
+- name: db-qcow2
+  imageFile: /images/base.vhdx
+  configFile: db.yaml
+  outputImageFile: out/db.img
+  outputImageFormat: qcow2
+`)
+	dir := filepath.Dir(manifestFile)
+
+	builds, err := PlanBatchBuilds("/build", manifestFile)
+	if !assert.NoError(t, err) || !assert.Len(t, builds, 2) {
+		return
+	}
+
+	assert.Equal(t, BatchBuild{
+		Name:              "web",
+		BuildDir:          "/build/batch/web",
+		ImageFile:         filepath.Join(dir, "base.vhdx"),
+		ConfigFile:        filepath.Join(dir, "web.yaml"),
+		OutputImageFile:   filepath.Join(dir, "out/web.vhdx"),
+		OutputImageFormat: "vhdx",
+		LogFile:           "/build/batch/web.log",
+	}, builds[0])
+
+	assert.Equal(t, "db-qcow2", builds[1].Name)
+	assert.Equal(t, "/images/base.vhdx", builds[1].ImageFile)
+	assert.Equal(t, "qcow2", builds[1].OutputImageFormat)
+	assert.Equal(t, "/build/batch/db-qcow2", builds[1].BuildDir)
+}
+
+func TestPlanBatchBuildsDuplicateName(t *testing.T) {
+	manifestFile := writeBatchTestFiles(t, `
+images:
+- imageFile: base.vhdx
+  configFile: web.yaml
+  outputImageFile: x86_64/image.vhdx
+- imageFile: base-arm64.vhdx
+  configFile: web.yaml
+  outputImageFile: arm64/image.vhdx
+`)
+
+	_, err := PlanBatchBuilds("/build", manifestFile)
+	assert.ErrorContains(t, err, "batch images at index 0 and 1 have the same name (image)")
+}
+
+func TestPlanBatchBuildsDuplicateOutputImageFile(t *testing.T) {
+	manifestFile := writeBatchTestFiles(t, `
+images:
+- name: web
+  imageFile: base.vhdx
+  configFile: web.yaml
+  outputImageFile: out/image.vhdx
+- name: db
+  imageFile: base.vhdx
+  configFile: db.yaml
+  outputImageFile: out/image.vhdx
+`)
+
+	_, err := PlanBatchBuilds("/build", manifestFile)
+	assert.ErrorContains(t, err, "batch images (web) and (db) have the same output image file")
+}
+
+func TestPlanBatchBuildsBadConfig(t *testing.T) {
+	manifestFile := writeBatchTestFiles(t, `
+images:
+- imageFile: base.vhdx
+  configFile: missing.yaml
+  outputImageFile: out/web.vhdx
+`)
+
+	_, err := PlanBatchBuilds("/build", manifestFile)
+	assert.ErrorContains(t, err, "failed to load config of batch image (web)")
+}
+
+func TestPlanBatchBuildsNoOutputImageFormat(t *testing.T) {
+	manifestFile := writeBatchTestFiles(t, `
+images:
+- imageFile: base.vhdx
+  configFile: web.yaml
+  outputImageFile: out/web
+`)
+
+	_, err := PlanBatchBuilds("/build", manifestFile)
+	assert.ErrorContains(t, err, "batch image (web) must specify 'outputImageFormat'")
+}
+
+func TestRunBatchBuilds(t *testing.T) {
+	builds := []BatchBuild{{Name: "web"}, {Name: "db"}, {Name: "cache"}}
+
+	results := RunBatchBuilds(builds, 2, func(build BatchBuild) error {
+		if build.Name == "db" {
+			return fmt.Errorf("build failed")
+		}
+		return nil
+	})
+
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "web", results[0].Build.Name)
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, "db", results[1].Build.Name)
+		assert.EqualError(t, results[1].Err, "build failed")
+		assert.Equal(t, "cache", results[2].Build.Name)
+		assert.NoError(t, results[2].Err)
+	}
+}
+
+func TestWriteBatchReport(t *testing.T) {
+	outputDir := t.TempDir()
+
+	build := BatchBuild{
+		Name:            "web",
+		ImageFile:       "/images/base.vhdx",
+		ConfigFile:      "/configs/web.yaml",
+		OutputImageFile: filepath.Join(outputDir, "web.vhdx"),
+		LogFile:         "/build/batch/web.log",
+	}
+
+	err := os.WriteFile(build.OutputImageFile, []byte("image"), 0o644)
+	require.NoError(t, err)
+
+	failedBuild := BatchBuild{
+		Name:            "db",
+		OutputImageFile: filepath.Join(outputDir, "db.vhdx"),
+	}
+
+	results := []BatchBuildResult{
+		{Build: build, Duration: 3 * time.Second},
+		{Build: failedBuild, Err: fmt.Errorf("exit status 1")},
+	}
+
+	reportFile := filepath.Join(outputDir, "batch-report.json")
+	err = WriteBatchReport(reportFile, "batch.yaml", results)
+	assert.EqualError(t, err, "1 of 2 batch images failed: db")
+
+	reportJson, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+
+	var report batchReport
+	err = json.Unmarshal(reportJson, &report)
+	require.NoError(t, err)
+
+	assert.Equal(t, "batch.yaml", report.ManifestFile)
+	if assert.Len(t, report.Images, 2) {
+		image := report.Images[0]
+		assert.Equal(t, "web", image.Name)
+		assert.True(t, image.Succeeded)
+		assert.Equal(t, float64(3), image.DurationSeconds)
+		assert.Equal(t, "/configs/web.yaml", image.ConfigFile)
+		assert.Equal(t, []buildArtifact{
+			{
+				Path:   build.OutputImageFile,
+				Size:   5,
+				Sha256: "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d",
+			},
+		}, image.Artifacts)
+
+		assert.False(t, report.Images[1].Succeeded)
+		assert.Equal(t, "exit status 1", report.Images[1].Error)
+		assert.Empty(t, report.Images[1].Artifacts)
+	}
+}
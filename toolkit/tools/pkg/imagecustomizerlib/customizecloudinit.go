@@ -0,0 +1,180 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	cloudInitSeedIsoFileSuffix = ".cidata.iso"
+	// The volume label that cloud-init's NoCloud datasource looks for.
+	cloudInitSeedIsoVolumeId = "cidata"
+	// The directory, under the build directory, that the seed ISO's files are staged in.
+	cloudInitSeedStagingDirName = "cloudinitseed"
+
+	// The presence of this file prevents cloud-init from running.
+	cloudInitDisabledFilePath     = "/etc/cloud/cloud-init.disabled"
+	cloudInitDatasourceConfigPath = "/etc/cloud/cloud.cfg.d/90_image_customizer_datasource.cfg"
+	// The seed directory of the NoCloud datasource.
+	cloudInitNoCloudSeedDir = "/var/lib/cloud/seed/nocloud"
+
+	cloudInitUserDataFileName      = "user-data"
+	cloudInitMetaDataFileName      = "meta-data"
+	cloudInitNetworkConfigFileName = "network-config"
+)
+
+func customizeCloudInit(baseConfigPath string, cloudInit *imagecustomizerapi.CloudInit,
+	imageChroot *safechroot.Chroot,
+) error {
+	if cloudInit == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Configuring cloud-init")
+
+	if cloudInit.Disabled {
+		err := writeCloudInitConfigFile("", filepath.Join(imageChroot.RootDir(), cloudInitDisabledFilePath))
+		if err != nil {
+			return fmt.Errorf("failed to disable cloud-init:\n%w", err)
+		}
+
+		return nil
+	}
+
+	if !isPackageInstalled(imageChroot, "cloud-init") {
+		return fmt.Errorf("package (cloud-init) must be installed to configure cloud-init")
+	}
+
+	if len(cloudInit.DatasourceList) > 0 {
+		datasourceConfigFilePath := filepath.Join(imageChroot.RootDir(), cloudInitDatasourceConfigPath)
+		err := writeCloudInitConfigFile(generateCloudInitDatasourceConfig(cloudInit.DatasourceList),
+			datasourceConfigFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to write cloud-init datasource config:\n%w", err)
+		}
+	}
+
+	if cloudInit.NoCloud != nil && cloudInit.NoCloud.SeedLocation != imagecustomizerapi.CloudInitSeedLocationIso {
+		err := writeCloudInitSeedFiles(baseConfigPath, cloudInit.NoCloud,
+			filepath.Join(imageChroot.RootDir(), cloudInitNoCloudSeedDir))
+		if err != nil {
+			return fmt.Errorf("failed to write cloud-init NoCloud seed data:\n%w", err)
+		}
+	}
+
+	return nil
+}
+
+func writeCloudInitConfigFile(content string, filePath string) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return file.Write(content, filePath)
+}
+
+func generateCloudInitDatasourceConfig(datasourceList []string) string {
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"datasource_list: [ " + strings.Join(datasourceList, ", ") + " ]",
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeCloudInitSeedFiles writes the NoCloud seed files to a directory.
+// The user-data file is only readable by root, since it often contains secrets.
+func writeCloudInitSeedFiles(baseConfigPath string, noCloud *imagecustomizerapi.CloudInitNoCloud, seedDir string,
+) error {
+	err := os.MkdirAll(seedDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	emptyContent := ""
+	for _, seedFile := range getCloudInitSeedFiles(noCloud) {
+		fileToCopy := safechroot.FileToCopy{
+			Dest:        seedFile.name,
+			Permissions: &seedFile.permissions,
+		}
+
+		switch {
+		case seedFile.seedFile == nil:
+			if seedFile.name == cloudInitNetworkConfigFileName {
+				// The network-config file is optional.
+				continue
+			}
+
+			// The NoCloud datasource requires both the user-data and meta-data files to exist.
+			fileToCopy.Content = &emptyContent
+
+		case seedFile.seedFile.Source != "":
+			fileToCopy.Src = file.GetAbsPathWithBase(baseConfigPath, seedFile.seedFile.Source)
+
+		default:
+			fileToCopy.Content = seedFile.seedFile.Content
+		}
+
+		err = safechroot.AddFilesToDestination(seedDir, fileToCopy)
+		if err != nil {
+			return fmt.Errorf("failed to write seed file (%s):\n%w", seedFile.name, err)
+		}
+	}
+
+	return nil
+}
+
+type cloudInitSeedFile struct {
+	name        string
+	seedFile    *imagecustomizerapi.CloudInitSeedFile
+	permissions os.FileMode
+}
+
+func getCloudInitSeedFiles(noCloud *imagecustomizerapi.CloudInitNoCloud) []cloudInitSeedFile {
+	return []cloudInitSeedFile{
+		{cloudInitUserDataFileName, noCloud.UserData, 0o600},
+		{cloudInitMetaDataFileName, noCloud.MetaData, 0o644},
+		{cloudInitNetworkConfigFileName, noCloud.NetworkConfig, 0o644},
+	}
+}
+
+// createCloudInitSeedIso writes the NoCloud seed files to an ISO file that can be attached to the VM.
+func createCloudInitSeedIso(buildDir string, baseConfigPath string, noCloud *imagecustomizerapi.CloudInitNoCloud,
+	seedIsoFile string,
+) error {
+	logger.Log.Infof("Creating cloud-init seed ISO (%s)", seedIsoFile)
+
+	stagingDir := filepath.Join(buildDir, cloudInitSeedStagingDirName)
+
+	err := os.RemoveAll(stagingDir)
+	if err != nil {
+		return fmt.Errorf("failed to clean cloud-init seed staging directory (%s):\n%w", stagingDir, err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	err = writeCloudInitSeedFiles(baseConfigPath, noCloud, stagingDir)
+	if err != nil {
+		return fmt.Errorf("failed to write cloud-init NoCloud seed data:\n%w", err)
+	}
+
+	// Note: genisoimage has a noisy stderr.
+	err = shell.ExecuteLive(true /*squashErrors*/, "genisoimage", "-output", seedIsoFile, "-volid",
+		cloudInitSeedIsoVolumeId, "-joliet", "-rock", stagingDir)
+	if err != nil {
+		return fmt.Errorf("failed to create cloud-init seed ISO (%s):\n%w", seedIsoFile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCloudInitDatasourceConfig(t *testing.T) {
+	config := generateCloudInitDatasourceConfig([]string{"NoCloud", "None"})
+	assert.Equal(t, "# Generated by the Azure Linux Image Customizer.\n"+
+		"datasource_list: [ NoCloud, None ]\n", config)
+}
+
+func TestWriteCloudInitSeedFiles(t *testing.T) {
+	seedDir := filepath.Join(t.TempDir(), "nocloud")
+	metaData := "instance-id: test\n"
+
+	noCloud := &imagecustomizerapi.CloudInitNoCloud{
+		UserData: &imagecustomizerapi.CloudInitSeedFile{Source: "files/cloud-init/user-data"},
+		MetaData: &imagecustomizerapi.CloudInitSeedFile{Content: &metaData},
+	}
+
+	err := writeCloudInitSeedFiles(testDir, noCloud, seedDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expectedUserData, err := os.ReadFile(filepath.Join(testDir, "files/cloud-init/user-data"))
+	assert.NoError(t, err)
+
+	userData, err := os.ReadFile(filepath.Join(seedDir, "user-data"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(expectedUserData), string(userData))
+
+	userDataStat, err := os.Stat(filepath.Join(seedDir, "user-data"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), userDataStat.Mode().Perm())
+
+	actualMetaData, err := os.ReadFile(filepath.Join(seedDir, "meta-data"))
+	assert.NoError(t, err)
+	assert.Equal(t, metaData, string(actualMetaData))
+
+	// network-config is optional.
+	assert.NoFileExists(t, filepath.Join(seedDir, "network-config"))
+}
+
+func TestWriteCloudInitSeedFilesOnlyNetworkConfig(t *testing.T) {
+	seedDir := filepath.Join(t.TempDir(), "nocloud")
+
+	noCloud := &imagecustomizerapi.CloudInitNoCloud{
+		NetworkConfig: &imagecustomizerapi.CloudInitSeedFile{Source: "files/cloud-init/network-config"},
+	}
+
+	err := writeCloudInitSeedFiles(testDir, noCloud, seedDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The NoCloud datasource requires user-data and meta-data to exist.
+	for _, fileName := range []string{"user-data", "meta-data"} {
+		content, err := os.ReadFile(filepath.Join(seedDir, fileName))
+		assert.NoError(t, err)
+		assert.Empty(t, content)
+	}
+
+	assert.FileExists(t, filepath.Join(seedDir, "network-config"))
+}
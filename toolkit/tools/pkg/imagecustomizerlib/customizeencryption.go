@@ -0,0 +1,534 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/configuration"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safeloopback"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safemount"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	// The directory, under the build directory, that holds the volume keys between the OS customization and the
+	// encryption steps.
+	encryptionKeysDirName = "encryptionkeys"
+	// The directory, under the build directory, that holds a volume's files while its partition is reformatted.
+	encryptionStagingDirName = "encryptionstaging"
+	// The directory, under the scripts' output artifacts directory, that the recovery keys are written to.
+	recoveryKeysDirName = "recovery-keys"
+
+	// The size of the randomly generated volume keys.
+	encryptionKeySize = 64
+
+	encryptionKeysDir                = "/etc/cryptsetup-keys.d"
+	crypttabPath                     = "/etc/crypttab"
+	encryptionDracutConfigPath       = "/etc/dracut.conf.d/encrypted-volumes.conf"
+	firstBootKeysServiceNamePrefix   = "luks-first-boot-keys-"
+	firstBootKeysServiceDirPath      = "/usr/lib/systemd/system"
+	firstBootKeysScriptDirPath       = "/usr/libexec/luks-first-boot-keys"
+	firstBootRecoveryKeysDir         = "/run/cryptsetup-recovery-keys"
+	encryptedVolumeBuildMapperPrefix = "ic-"
+)
+
+// enableEncryptedVolumes configures the OS to unlock the encrypted volumes during boot. The volumes are initially
+// unlocked using a key file stored on the root filesystem, which is shared by every deployment of the image. On first
+// boot, a service re-encrypts each volume with a per-device volume key, enrolls the TPM2 (or a per-device key file, if
+// the machine has no TPM2) and a per-device recovery key, and then removes the image's key file.
+//
+// The partitions themselves are encrypted later by encryptVolumesHelper, after all the changes to the filesystems
+// have been made.
+func enableEncryptedVolumes(buildDir string, encryptedVolumes []imagecustomizerapi.EncryptedVolume,
+	partIdToPartUuid map[string]string, imageChroot *safechroot.Chroot,
+) (bool, error) {
+	var err error
+
+	if len(encryptedVolumes) <= 0 {
+		return false, nil
+	}
+
+	logger.Log.Infof("Enable encrypted volumes")
+
+	err = validateEncryptionDependencies(imageChroot)
+	if err != nil {
+		return false, fmt.Errorf("failed to validate package dependencies for encrypted volumes:\n%w", err)
+	}
+
+	err = writeEncryptionKeys(buildDir, encryptedVolumes, imageChroot.RootDir())
+	if err != nil {
+		return false, fmt.Errorf("failed to write encrypted volume keys:\n%w", err)
+	}
+
+	crypttabEntries, err := generateCrypttabEntries(encryptedVolumes, partIdToPartUuid)
+	if err != nil {
+		return false, err
+	}
+
+	err = file.Append(strings.Join(crypttabEntries, "\n")+"\n", filepath.Join(imageChroot.RootDir(), crypttabPath))
+	if err != nil {
+		return false, fmt.Errorf("failed to update crypttab file:\n%w", err)
+	}
+
+	err = updateFstabForEncryptedVolumes(encryptedVolumes, imageChroot)
+	if err != nil {
+		return false, fmt.Errorf("failed to update fstab file for encrypted volumes:\n%w", err)
+	}
+
+	// Include the LUKS and TPM2 support in the initrd, so that volumes needed by the initrd can be unlocked.
+	err = addDracutConfig(filepath.Join(imageChroot.RootDir(), encryptionDracutConfigPath), []string{
+		"add_dracutmodules+=\" crypt tpm2-tss \"",
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = addFirstBootKeyServices(encryptedVolumes, partIdToPartUuid, imageChroot)
+	if err != nil {
+		return false, fmt.Errorf("failed to add first-boot key services:\n%w", err)
+	}
+
+	return true, nil
+}
+
+func validateEncryptionDependencies(imageChroot *safechroot.Chroot) error {
+	requiredRpms := []string{"cryptsetup", "tpm2-tss"}
+
+	for _, pkg := range requiredRpms {
+		logger.Log.Debugf("Checking if package (%s) is installed", pkg)
+		if !isPackageInstalled(imageChroot, pkg) {
+			return fmt.Errorf("package (%s) is not installed:\n"+
+				"the following packages must be installed to use encrypted volumes: %v", pkg, requiredRpms)
+		}
+	}
+
+	return nil
+}
+
+// writeEncryptionKeys generates a random key for each volume and writes it to both the build directory (for
+// encryptVolumesHelper) and the image (for unlocking the volume until the TPM2 is enrolled).
+func writeEncryptionKeys(buildDir string, encryptedVolumes []imagecustomizerapi.EncryptedVolume,
+	imageRootDir string,
+) error {
+	buildKeysDir := filepath.Join(buildDir, encryptionKeysDirName)
+	imageKeysDir := filepath.Join(imageRootDir, encryptionKeysDir)
+
+	for _, dir := range []string{buildKeysDir, imageKeysDir} {
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return fmt.Errorf("failed to create keys directory (%s):\n%w", dir, err)
+		}
+	}
+
+	for _, encryptedVolume := range encryptedVolumes {
+		key := make([]byte, encryptionKeySize)
+		_, err := rand.Read(key)
+		if err != nil {
+			return fmt.Errorf("failed to generate key for encrypted volume (%s):\n%w", encryptedVolume.Name, err)
+		}
+
+		keyFileName := encryptedVolume.Name + ".key"
+		for _, dir := range []string{buildKeysDir, imageKeysDir} {
+			keyFilePath := filepath.Join(dir, keyFileName)
+			err = os.WriteFile(keyFilePath, key, 0o400)
+			if err != nil {
+				return fmt.Errorf("failed to write key file (%s):\n%w", keyFilePath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func generateCrypttabEntries(encryptedVolumes []imagecustomizerapi.EncryptedVolume,
+	partIdToPartUuid map[string]string,
+) ([]string, error) {
+	lines := []string(nil)
+	for _, encryptedVolume := range encryptedVolumes {
+		partUuid, found := partIdToPartUuid[encryptedVolume.DeviceId]
+		if !found {
+			return nil, fmt.Errorf("failed to find PARTUUID of partition (%s)", encryptedVolume.DeviceId)
+		}
+
+		line := fmt.Sprintf("%s PARTUUID=%s %s luks,tpm2-device=auto", encryptedVolume.Name, partUuid,
+			encryptionKeyImagePath(encryptedVolume))
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+func updateFstabForEncryptedVolumes(encryptedVolumes []imagecustomizerapi.EncryptedVolume,
+	imageChroot *safechroot.Chroot,
+) error {
+	fstabFile := filepath.Join(imageChroot.RootDir(), "etc", "fstab")
+	fstabEntries, err := diskutils.ReadFstabFile(fstabFile)
+	if err != nil {
+		return fmt.Errorf("failed to read fstab file:\n%w", err)
+	}
+
+	// Update fstab entries so that encrypted volume mounts point to the unlocked device paths.
+	for _, encryptedVolume := range encryptedVolumes {
+		if encryptedVolume.FileSystem == nil || encryptedVolume.FileSystem.MountPoint == nil {
+			// No mount point assigned to encrypted volume.
+			continue
+		}
+
+		mountPath := encryptedVolume.FileSystem.MountPoint.Path
+
+		for j := range fstabEntries {
+			entry := &fstabEntries[j]
+			if entry.Target == mountPath {
+				entry.Source = encryptedVolumeDevicePath(encryptedVolume)
+			}
+		}
+	}
+
+	err = diskutils.WriteFstabFile(fstabEntries, fstabFile)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func addFirstBootKeyServices(encryptedVolumes []imagecustomizerapi.EncryptedVolume,
+	partIdToPartUuid map[string]string, imageChroot *safechroot.Chroot,
+) error {
+	for _, encryptedVolume := range encryptedVolumes {
+		partUuid := partIdToPartUuid[encryptedVolume.DeviceId]
+
+		scriptPath := filepath.Join(imageChroot.RootDir(), firstBootKeysScriptPath(encryptedVolume))
+		err := os.MkdirAll(filepath.Dir(scriptPath), 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = file.WriteWithPerm(generateFirstBootKeysScript(encryptedVolume, partUuid), scriptPath, 0o700)
+		if err != nil {
+			return fmt.Errorf("failed to write script file (%s):\n%w", scriptPath, err)
+		}
+
+		serviceName := firstBootKeysServiceNamePrefix + encryptedVolume.Name + ".service"
+		serviceFilePath := filepath.Join(imageChroot.RootDir(), firstBootKeysServiceDirPath, serviceName)
+
+		err = os.MkdirAll(filepath.Dir(serviceFilePath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		err = file.Write(generateFirstBootKeysService(encryptedVolume), serviceFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to write service file (%s):\n%w", serviceFilePath, err)
+		}
+
+		err = imageChroot.UnsafeRun(func() error {
+			return shell.ExecuteLiveWithErr(1, "systemctl", "enable", serviceName)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to enable service (%s):\n%w", serviceName, err)
+		}
+	}
+
+	return nil
+}
+
+// generateFirstBootKeysService generates the first-boot service that replaces the keys shipped in the image with
+// per-device keys.
+func generateFirstBootKeysService(encryptedVolume imagecustomizerapi.EncryptedVolume) string {
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Unit]",
+		"Description=Replace the image's keys of encrypted volume (" + encryptedVolume.Name + ") with per-device keys",
+		"After=systemd-cryptsetup@" + encryptedVolume.Name + ".service",
+		"ConditionPathExists=" + encryptionKeyImagePath(encryptedVolume),
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"ExecStart=" + firstBootKeysScriptPath(encryptedVolume),
+		// Re-encrypting a large volume can take a long time.
+		"TimeoutStartSec=infinity",
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// generateFirstBootKeysScript generates the script run by the first-boot service. Every deployment of the image
+// starts with the same volume key, key file and recovery key, so the script:
+//
+//  1. Re-encrypts the volume (online) with a new, per-device volume key.
+//  2. Replaces the recovery key with a per-device one, written to /run so that the provisioning can escrow it
+//     before the next reboot.
+//  3. Seals the volume key against the TPM2. If the machine doesn't have a TPM2, then a per-device key file is
+//     added instead.
+//  4. Removes the image's key file and its LUKS key slot.
+func generateFirstBootKeysScript(encryptedVolume imagecustomizerapi.EncryptedVolume, partUuid string) string {
+	pcrs := []string(nil)
+	for _, pcr := range encryptedVolume.GetTpm2Pcrs() {
+		pcrs = append(pcrs, strconv.Itoa(pcr))
+	}
+
+	lines := []string{
+		"#!/bin/bash",
+		"# Generated by the Azure Linux Image Customizer.",
+		"set -euo pipefail",
+		"",
+		"volume_name=" + shellQuote(encryptedVolume.Name),
+		"device=" + shellQuote("/dev/disk/by-partuuid/"+partUuid),
+		"image_key_file=" + shellQuote(encryptionKeyImagePath(encryptedVolume)),
+		"device_key_file=" + shellQuote(encryptionDeviceKeyImagePath(encryptedVolume)),
+		"recovery_key_dir=" + shellQuote(firstBootRecoveryKeysDir),
+		"tpm2_pcrs=" + shellQuote(strings.Join(pcrs, "+")),
+		"",
+		"# Give the volume a per-device volume key. An interrupted re-encryption is resumed.",
+		`cryptsetup reencrypt --batch-mode --key-file "$image_key_file" "$device"`,
+		"",
+		"# Replace the image's recovery key. The new key is only kept in memory, until it is escrowed.",
+		`mkdir -p -m 0700 "$recovery_key_dir"`,
+		`(umask 077; systemd-cryptenroll --unlock-key-file="$image_key_file" --recovery-key --wipe-slot=recovery \`,
+		`    "$device" > "$recovery_key_dir/$volume_name.txt")`,
+		"",
+		"if systemd-analyze has-tpm2 > /dev/null 2>&1; then",
+		`    systemd-cryptenroll --unlock-key-file="$image_key_file" --tpm2-device=auto --tpm2-pcrs="$tpm2_pcrs" \`,
+		`        --wipe-slot=tpm2 "$device"`,
+		"    new_key_file=none",
+		"else",
+		`    echo "No TPM2 found, volume ($volume_name) will be unlocked by a per-device key file" >&2`,
+		`    (umask 077; head -c ` + strconv.Itoa(encryptionKeySize) + ` /dev/urandom > "$device_key_file")`,
+		`    cryptsetup luksAddKey --batch-mode --key-file "$image_key_file" "$device" "$device_key_file"`,
+		`    new_key_file="$device_key_file"`,
+		"fi",
+		"",
+		"# Remove the image's key, so that it can't unlock the volume.",
+		`cryptsetup luksRemoveKey --batch-mode "$device" "$image_key_file"`,
+		`sed -i "s|$image_key_file|$new_key_file|" ` + crypttabPath,
+		`rm -f "$image_key_file"`,
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// encryptVolumesHelper encrypts the partitions of the encrypted volumes. The files of each partition are copied out,
+// the partition is formatted as LUKS2, and then the files are copied back into a new filesystem inside the LUKS
+// volume. A recovery key is enrolled for each volume and written to the scripts' output artifacts directory. The
+// first-boot service replaces it with a per-device recovery key, so it is only needed until the first boot completes.
+func encryptVolumesHelper(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
+	buildImageFile string, partIdToPartUuid map[string]string,
+) error {
+	logger.Log.Infof("Encrypting volumes")
+
+	outputArtifactsDir, err := prepareScriptsOutputArtifactsDir(baseConfigPath, config.Scripts)
+	if err != nil {
+		return err
+	}
+
+	recoveryKeysDir := filepath.Join(outputArtifactsDir, recoveryKeysDirName)
+	err = os.MkdirAll(recoveryKeysDir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create recovery keys directory (%s):\n%w", recoveryKeysDir, err)
+	}
+
+	loopback, err := safeloopback.NewLoopback(buildImageFile)
+	if err != nil {
+		return fmt.Errorf("failed to connect to image file to encrypt volumes:\n%w", err)
+	}
+	defer loopback.Close()
+
+	diskPartitions, err := diskutils.GetDiskPartitions(loopback.DevicePath())
+	if err != nil {
+		return err
+	}
+
+	for _, encryptedVolume := range config.Storage.EncryptedVolumes {
+		partitionPath, err := idToPartitionBlockDevicePath(encryptedVolume.DeviceId, diskPartitions,
+			partIdToPartUuid)
+		if err != nil {
+			return err
+		}
+
+		err = encryptVolume(buildDir, encryptedVolume, partitionPath, recoveryKeysDir)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt volume (%s):\n%w", encryptedVolume.Name, err)
+		}
+	}
+
+	err = loopback.CleanClose()
+	if err != nil {
+		return err
+	}
+
+	// The key files are no longer needed on the build host.
+	err = os.RemoveAll(filepath.Join(buildDir, encryptionKeysDirName))
+	if err != nil {
+		return fmt.Errorf("failed to remove encrypted volume keys:\n%w", err)
+	}
+
+	return nil
+}
+
+func encryptVolume(buildDir string, encryptedVolume imagecustomizerapi.EncryptedVolume, partitionPath string,
+	recoveryKeysDir string,
+) error {
+	logger.Log.Infof("Encrypting partition (%s) as (%s)", partitionPath, encryptedVolume.Name)
+
+	fileSystemType := string(encryptedVolume.FileSystem.Type)
+	keyFilePath := filepath.Join(buildDir, encryptionKeysDirName, encryptedVolume.Name+".key")
+	partitionMountDir := filepath.Join(buildDir, tmpParitionDirName)
+	stagingDir := filepath.Join(buildDir, encryptionStagingDirName)
+
+	err := os.RemoveAll(stagingDir)
+	if err != nil {
+		return fmt.Errorf("failed to clean staging directory (%s):\n%w", stagingDir, err)
+	}
+
+	err = os.MkdirAll(stagingDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory (%s):\n%w", stagingDir, err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	// Copy the files out of the partition.
+	err = withMountedDevice(partitionPath, fileSystemType, partitionMountDir, func() error {
+		return copyPartitionFiles(partitionMountDir+"/.", stagingDir)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = shell.ExecuteLiveWithErr(1, "cryptsetup", "luksFormat", "--type", "luks2", "--batch-mode",
+		"--key-file", keyFilePath, partitionPath)
+	if err != nil {
+		return fmt.Errorf("failed to format partition (%s) as LUKS2:\n%w", partitionPath, err)
+	}
+
+	err = enrollRecoveryKey(keyFilePath, partitionPath, filepath.Join(recoveryKeysDir, encryptedVolume.Name+".txt"))
+	if err != nil {
+		return err
+	}
+
+	// Use the partition's device name in the mapper name, so that it is unique on the build host.
+	mapperName := encryptedVolumeBuildMapperPrefix + filepath.Base(partitionPath)
+	mapperPath := imagecustomizerapi.DeviceMapperPath + "/" + mapperName
+
+	err = shell.ExecuteLiveWithErr(1, "cryptsetup", "open", "--key-file", keyFilePath, partitionPath, mapperName)
+	if err != nil {
+		return fmt.Errorf("failed to open LUKS volume (%s):\n%w", partitionPath, err)
+	}
+
+	volumeOpen := true
+	defer func() {
+		if volumeOpen {
+			closeErr := shell.ExecuteLiveWithErr(1, "cryptsetup", "close", mapperName)
+			if closeErr != nil {
+				logger.Log.Warnf("Failed to close LUKS volume (%s):\n%v", mapperName, closeErr)
+			}
+		}
+	}()
+
+	_, err = diskutils.FormatSinglePartition(mapperPath, configuration.Partition{FsType: fileSystemType})
+	if err != nil {
+		return fmt.Errorf("failed to format LUKS volume (%s):\n%w", mapperPath, err)
+	}
+
+	// Copy the files into the encrypted filesystem.
+	err = withMountedDevice(mapperPath, fileSystemType, partitionMountDir, func() error {
+		return copyPartitionFiles(stagingDir+"/.", partitionMountDir)
+	})
+	if err != nil {
+		return err
+	}
+
+	volumeOpen = false
+	err = shell.ExecuteLiveWithErr(1, "cryptsetup", "close", mapperName)
+	if err != nil {
+		return fmt.Errorf("failed to close LUKS volume (%s):\n%w", mapperName, err)
+	}
+
+	return nil
+}
+
+// withMountedDevice temporarily mounts a device while running a function.
+func withMountedDevice(devicePath string, fileSystemType string, mountDir string, function func() error) error {
+	mount, err := safemount.NewMount(devicePath, mountDir, fileSystemType, 0, "", true)
+	if err != nil {
+		return fmt.Errorf("failed to mount (%s):\n%w", devicePath, err)
+	}
+	defer mount.Close()
+
+	err = function()
+	if err != nil {
+		return err
+	}
+
+	err = mount.CleanClose()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// enrollRecoveryKey adds a recovery key to the LUKS volume and writes it to the build host.
+func enrollRecoveryKey(keyFilePath string, partitionPath string, recoveryKeyPath string) error {
+	stdout, stderr, err := shell.Execute("systemd-cryptenroll", "--unlock-key-file="+keyFilePath, "--recovery-key",
+		partitionPath)
+	if err != nil {
+		return fmt.Errorf("failed to enroll recovery key for (%s):\n%v\n%w", partitionPath, stderr, err)
+	}
+
+	recoveryKey := strings.TrimSpace(stdout)
+	if recoveryKey == "" {
+		return fmt.Errorf("failed to enroll recovery key for (%s):\nsystemd-cryptenroll didn't output a key",
+			partitionPath)
+	}
+
+	err = os.WriteFile(recoveryKeyPath, []byte(recoveryKey+"\n"), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write recovery key file (%s):\n%w", recoveryKeyPath, err)
+	}
+
+	return nil
+}
+
+func encryptionKeyImagePath(encryptedVolume imagecustomizerapi.EncryptedVolume) string {
+	return encryptionKeysDir + "/" + encryptedVolume.Name + ".key"
+}
+
+func encryptionDeviceKeyImagePath(encryptedVolume imagecustomizerapi.EncryptedVolume) string {
+	return encryptionKeysDir + "/" + encryptedVolume.Name + ".device.key"
+}
+
+func firstBootKeysScriptPath(encryptedVolume imagecustomizerapi.EncryptedVolume) string {
+	return firstBootKeysScriptDirPath + "/" + encryptedVolume.Name
+}
+
+func encryptedVolumeDevicePath(encryptedVolume imagecustomizerapi.EncryptedVolume) string {
+	return imagecustomizerapi.DeviceMapperPath + "/" + encryptedVolume.Name
+}
+
+func isEncryptedPartition(encryptedVolumes []imagecustomizerapi.EncryptedVolume, partition diskutils.PartitionInfo,
+	partIdToPartUuid map[string]string,
+) bool {
+	for _, encryptedVolume := range encryptedVolumes {
+		if partitionMatchesDeviceId(encryptedVolume.DeviceId, partition, partIdToPartUuid) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCrypttabEntries(t *testing.T) {
+	encryptedVolumes := []imagecustomizerapi.EncryptedVolume{
+		{Id: "varcrypt", Name: "var", DeviceId: "var"},
+		{Id: "homecrypt", Name: "home", DeviceId: "home"},
+	}
+	partIdToPartUuid := map[string]string{
+		"var":  "11111111-2222-3333-4444-555555555555",
+		"home": "66666666-7777-8888-9999-000000000000",
+	}
+
+	lines, err := generateCrypttabEntries(encryptedVolumes, partIdToPartUuid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"var PARTUUID=11111111-2222-3333-4444-555555555555 /etc/cryptsetup-keys.d/var.key luks,tpm2-device=auto",
+		"home PARTUUID=66666666-7777-8888-9999-000000000000 /etc/cryptsetup-keys.d/home.key luks,tpm2-device=auto",
+	}, lines)
+}
+
+func TestGenerateCrypttabEntriesMissingPartition(t *testing.T) {
+	encryptedVolumes := []imagecustomizerapi.EncryptedVolume{
+		{Id: "varcrypt", Name: "var", DeviceId: "var"},
+	}
+
+	_, err := generateCrypttabEntries(encryptedVolumes, map[string]string{})
+	assert.ErrorContains(t, err, "failed to find PARTUUID of partition (var)")
+}
+
+func TestGenerateFirstBootKeysService(t *testing.T) {
+	encryptedVolume := imagecustomizerapi.EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+	}
+
+	service := generateFirstBootKeysService(encryptedVolume)
+	assert.Contains(t, service, "After=systemd-cryptsetup@var.service\n")
+	assert.Contains(t, service, "ConditionPathExists=/etc/cryptsetup-keys.d/var.key\n")
+	assert.Contains(t, service, "ExecStart=/usr/libexec/luks-first-boot-keys/var\n")
+	assert.NotContains(t, service, "ConditionSecurity=")
+}
+
+func TestGenerateFirstBootKeysScript(t *testing.T) {
+	encryptedVolume := imagecustomizerapi.EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+		Tpm2Pcrs: []int{0, 7},
+	}
+
+	script := generateFirstBootKeysScript(encryptedVolume, "11111111-2222-3333-4444-555555555555")
+	assert.Contains(t, script, "device='/dev/disk/by-partuuid/11111111-2222-3333-4444-555555555555'\n")
+	assert.Contains(t, script, "image_key_file='/etc/cryptsetup-keys.d/var.key'\n")
+	assert.Contains(t, script, "device_key_file='/etc/cryptsetup-keys.d/var.device.key'\n")
+	assert.Contains(t, script, "tpm2_pcrs='0+7'\n")
+
+	// The volume key is replaced before any new key is enrolled, and the image's key is removed last.
+	reencrypt := strings.Index(script, "cryptsetup reencrypt ")
+	recoveryKey := strings.Index(script, "--recovery-key --wipe-slot=recovery")
+	tpm2 := strings.Index(script, "--tpm2-device=auto")
+	deviceKey := strings.Index(script, "cryptsetup luksAddKey ")
+	removeKey := strings.Index(script, "cryptsetup luksRemoveKey ")
+	assert.True(t, reencrypt >= 0 && reencrypt < recoveryKey)
+	assert.True(t, recoveryKey < tpm2 && tpm2 < deviceKey && deviceKey < removeKey)
+	assert.True(t, removeKey < strings.Index(script, `rm -f "$image_key_file"`))
+}
+
+func TestGenerateFirstBootKeysScriptDefaultPcrs(t *testing.T) {
+	encryptedVolume := imagecustomizerapi.EncryptedVolume{
+		Id:       "varcrypt",
+		Name:     "var",
+		DeviceId: "var",
+	}
+
+	script := generateFirstBootKeysScript(encryptedVolume, "11111111-2222-3333-4444-555555555555")
+	assert.Contains(t, script, "tpm2_pcrs='7'\n")
+}
+
+func TestWriteEncryptionKeys(t *testing.T) {
+	testDir := t.TempDir()
+	buildDir := filepath.Join(testDir, "build")
+	imageRootDir := filepath.Join(testDir, "root")
+
+	encryptedVolumes := []imagecustomizerapi.EncryptedVolume{
+		{Id: "varcrypt", Name: "var", DeviceId: "var"},
+		{Id: "homecrypt", Name: "home", DeviceId: "home"},
+	}
+
+	err := writeEncryptionKeys(buildDir, encryptedVolumes, imageRootDir)
+	assert.NoError(t, err)
+
+	varBuildKey, err := os.ReadFile(filepath.Join(buildDir, encryptionKeysDirName, "var.key"))
+	assert.NoError(t, err)
+	assert.Len(t, varBuildKey, encryptionKeySize)
+
+	varImageKey, err := os.ReadFile(filepath.Join(imageRootDir, "etc/cryptsetup-keys.d/var.key"))
+	assert.NoError(t, err)
+	assert.Equal(t, varBuildKey, varImageKey)
+
+	homeImageKey, err := os.ReadFile(filepath.Join(imageRootDir, "etc/cryptsetup-keys.d/home.key"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, varImageKey, homeImageKey)
+
+	info, err := os.Stat(filepath.Join(imageRootDir, "etc/cryptsetup-keys.d/var.key"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o400), info.Mode().Perm())
+}
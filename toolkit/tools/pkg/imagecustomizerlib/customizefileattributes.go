@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+	"golang.org/x/sys/unix"
+)
+
+// additionalPathAttributes are the ownership and SELinux label to set on a copied file or directory.
+type additionalPathAttributes struct {
+	// The path within the image.
+	path         string
+	owner        string
+	group        string
+	selinuxLabel string
+}
+
+// getAdditionalPathAttributes lists the paths that the additional files and directories were copied to, along with
+// the attributes to set on them. The paths of a directory are the destination directory and each of the copied
+// files and directories within it.
+func getAdditionalPathAttributes(baseConfigPath string, additionalDirs imagecustomizerapi.DirConfigList,
+	additionalFiles imagecustomizerapi.AdditionalFileList,
+) ([]additionalPathAttributes, error) {
+	paths := []additionalPathAttributes(nil)
+
+	for _, dirConfig := range additionalDirs {
+		if dirConfig.Owner == "" && dirConfig.Group == "" && dirConfig.SELinuxLabel == "" {
+			continue
+		}
+
+		absSourceDir := file.GetAbsPathWithBase(baseConfigPath, dirConfig.Source)
+		err := filepath.WalkDir(absSourceDir, func(sourcePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(absSourceDir, sourcePath)
+			if err != nil {
+				return err
+			}
+
+			paths = append(paths, additionalPathAttributes{
+				path:         filepath.Join(dirConfig.Destination, relPath),
+				owner:        dirConfig.Owner,
+				group:        dirConfig.Group,
+				selinuxLabel: dirConfig.SELinuxLabel,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list additional directory (%s):\n%w", dirConfig.Source, err)
+		}
+	}
+
+	for _, additionalFile := range additionalFiles {
+		if additionalFile.Owner == "" && additionalFile.Group == "" && additionalFile.SELinuxLabel == "" {
+			continue
+		}
+
+		paths = append(paths, additionalPathAttributes{
+			path:         additionalFile.Destination,
+			owner:        additionalFile.Owner,
+			group:        additionalFile.Group,
+			selinuxLabel: additionalFile.SELinuxLabel,
+		})
+	}
+
+	return paths, nil
+}
+
+// setAdditionalFilesOwnership sets the owners and groups of the additional files and directories. User and group
+// names are looked up in the image.
+func setAdditionalFilesOwnership(baseConfigPath string, additionalDirs imagecustomizerapi.DirConfigList,
+	additionalFiles imagecustomizerapi.AdditionalFileList, imageChroot *safechroot.Chroot,
+) error {
+	paths, err := getAdditionalPathAttributes(baseConfigPath, additionalDirs, additionalFiles)
+	if err != nil {
+		return err
+	}
+
+	var passwdEntries []userutils.PasswdEntry
+	var groupEntries []userutils.GroupEntry
+
+	for _, pathAttributes := range paths {
+		if pathAttributes.owner == "" && pathAttributes.group == "" {
+			continue
+		}
+
+		if passwdEntries == nil {
+			logger.Log.Infof("Setting additional files ownership")
+
+			passwdEntries, err = userutils.ReadPasswdFile(imageChroot.RootDir())
+			if err != nil {
+				return err
+			}
+
+			groupEntries, err = userutils.ReadGroupFile(imageChroot.RootDir())
+			if err != nil {
+				return err
+			}
+		}
+
+		uid, err := resolveFileOwner(pathAttributes.owner, passwdEntries)
+		if err != nil {
+			return fmt.Errorf("failed to set owner of (%s):\n%w", pathAttributes.path, err)
+		}
+
+		gid, err := resolveFileGroup(pathAttributes.group, groupEntries)
+		if err != nil {
+			return fmt.Errorf("failed to set group of (%s):\n%w", pathAttributes.path, err)
+		}
+
+		fullPath := filepath.Join(imageChroot.RootDir(), pathAttributes.path)
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat (%s):\n%w", pathAttributes.path, err)
+		}
+
+		err = chownKeepingAttributes(fullPath, info, uid, gid)
+		if err != nil {
+			return fmt.Errorf("failed to set ownership of (%s):\n%w", pathAttributes.path, err)
+		}
+	}
+
+	return nil
+}
+
+// resolveFileOwner returns the UID of a user name or ID. Returns -1 (i.e. unchanged) for an empty owner.
+func resolveFileOwner(owner string, passwdEntries []userutils.PasswdEntry) (int, error) {
+	if owner == "" {
+		return -1, nil
+	}
+
+	uid, err := strconv.Atoi(owner)
+	if err == nil {
+		return uid, nil
+	}
+
+	for _, entry := range passwdEntries {
+		if entry.Name == owner {
+			return entry.Uid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("user (%s) doesn't exist in the image", owner)
+}
+
+// resolveFileGroup returns the GID of a group name or ID. Returns -1 (i.e. unchanged) for an empty group.
+func resolveFileGroup(group string, groupEntries []userutils.GroupEntry) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+
+	gid, err := strconv.Atoi(group)
+	if err == nil {
+		return gid, nil
+	}
+
+	for _, entry := range groupEntries {
+		if entry.Name == group {
+			return entry.GID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("group (%s) doesn't exist in the image", group)
+}
+
+// setAdditionalFilesSELinuxLabels sets the explicit SELinux labels of the additional files and directories.
+func setAdditionalFilesSELinuxLabels(baseConfigPath string, additionalDirs imagecustomizerapi.DirConfigList,
+	additionalFiles imagecustomizerapi.AdditionalFileList, imageChroot *safechroot.Chroot,
+) error {
+	paths, err := getAdditionalPathAttributes(baseConfigPath, additionalDirs, additionalFiles)
+	if err != nil {
+		return err
+	}
+
+	for _, pathAttributes := range paths {
+		if pathAttributes.selinuxLabel == "" {
+			continue
+		}
+
+		logger.Log.Debugf("Setting SELinux label of (%s) to (%s)", pathAttributes.path, pathAttributes.selinuxLabel)
+
+		fullPath := filepath.Join(imageChroot.RootDir(), pathAttributes.path)
+		err = unix.Lsetxattr(fullPath, selinuxLabelXattrName, []byte(pathAttributes.selinuxLabel), 0)
+		if err != nil {
+			return fmt.Errorf("failed to set SELinux label of (%s) to (%s):\n%w", pathAttributes.path,
+				pathAttributes.selinuxLabel, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderAdditionalFileTemplate(t *testing.T) {
+	baseConfigPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(baseConfigPath, "app.conf.tmpl"), []byte("region={{ .region }}\n"), 0o644)
+	require.NoError(t, err)
+
+	variables := map[string]string{"region": "westus"}
+
+	content, err := renderAdditionalFileTemplate(baseConfigPath, imagecustomizerapi.AdditionalFile{
+		Source:      "app.conf.tmpl",
+		Destination: "/etc/app.conf",
+		Template:    true,
+	}, variables)
+	assert.NoError(t, err)
+	assert.Equal(t, "region=westus\n", content)
+
+	content, err = renderAdditionalFileTemplate(baseConfigPath, imagecustomizerapi.AdditionalFile{
+		Content:     ptrutils.PtrTo("{{ .region }}-{{ .region }}"),
+		Destination: "/etc/region",
+		Template:    true,
+	}, variables)
+	assert.NoError(t, err)
+	assert.Equal(t, "westus-westus", content)
+
+	_, err = renderAdditionalFileTemplate(baseConfigPath, imagecustomizerapi.AdditionalFile{
+		Content:     ptrutils.PtrTo("zone={{ .zone }}"),
+		Destination: "/etc/zone",
+		Template:    true,
+	}, nil)
+	assert.ErrorContains(t, err, "failed to render template for additional file (/etc/zone)")
+	assert.ErrorContains(t, err, "map has no entry for key \"zone\"")
+}
+
+func TestResolveFileOwnerAndGroup(t *testing.T) {
+	passwdEntries := []userutils.PasswdEntry{{Name: "root", Uid: 0}, {Name: "app", Uid: 1001}}
+	groupEntries := []userutils.GroupEntry{{Name: "root", GID: 0}, {Name: "app", GID: 1002}}
+
+	uid, err := resolveFileOwner("app", passwdEntries)
+	assert.NoError(t, err)
+	assert.Equal(t, 1001, uid)
+
+	uid, err = resolveFileOwner("2000", passwdEntries)
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, uid)
+
+	uid, err = resolveFileOwner("", passwdEntries)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, uid)
+
+	_, err = resolveFileOwner("nobody", passwdEntries)
+	assert.ErrorContains(t, err, "user (nobody) doesn't exist in the image")
+
+	gid, err := resolveFileGroup("app", groupEntries)
+	assert.NoError(t, err)
+	assert.Equal(t, 1002, gid)
+
+	_, err = resolveFileGroup("wheel", groupEntries)
+	assert.ErrorContains(t, err, "group (wheel) doesn't exist in the image")
+}
+
+func TestGetAdditionalPathAttributes(t *testing.T) {
+	baseConfigPath := t.TempDir()
+	err := os.MkdirAll(filepath.Join(baseConfigPath, "app/conf.d"), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(baseConfigPath, "app/conf.d/a.conf"), nil, 0o644)
+	require.NoError(t, err)
+
+	paths, err := getAdditionalPathAttributes(baseConfigPath,
+		imagecustomizerapi.DirConfigList{
+			{Source: "app", Destination: "/opt/app", Owner: "app"},
+			{Source: "app", Destination: "/opt/other"},
+		},
+		imagecustomizerapi.AdditionalFileList{
+			{Source: "a.txt", Destination: "/etc/a.txt", SELinuxLabel: "system_u:object_r:etc_t:s0"},
+			{Source: "b.txt", Destination: "/etc/b.txt"},
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []additionalPathAttributes{
+		{path: "/opt/app", owner: "app"},
+		{path: "/opt/app/conf.d", owner: "app"},
+		{path: "/opt/app/conf.d/a.conf", owner: "app"},
+		{path: "/etc/a.txt", selinuxLabel: "system_u:object_r:etc_t:s0"},
+	}, paths)
+}
@@ -6,6 +6,9 @@ package imagecustomizerlib
 import (
 	"fmt"
 	"io/fs"
+	"os"
+	"strings"
+	"text/template"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
@@ -15,10 +18,14 @@ import (
 
 const (
 	defaultFilePermissions = 0o755
+
+	// The file capabilities of the additional files (e.g. granted by setcap) are kept, so that a copied binary keeps
+	// its privileges. The other extended attributes (e.g. SELinux labels) belong to the build host.
+	additionalFilesPreserveXattrs = file.XattrClassCapabilities
 )
 
 func copyAdditionalFiles(baseConfigPath string, additionalFiles imagecustomizerapi.AdditionalFileList,
-	imageChroot *safechroot.Chroot,
+	templateVariables map[string]string, imageChroot *safechroot.Chroot,
 ) error {
 	for _, additionalFile := range additionalFiles {
 		logger.Log.Infof("Copying: %s", additionalFile.Destination)
@@ -29,10 +36,32 @@ func copyAdditionalFiles(baseConfigPath string, additionalFiles imagecustomizera
 		}
 
 		fileToCopy := safechroot.FileToCopy{
-			Src:         absSourceFile,
-			Content:     additionalFile.Content,
-			Dest:        additionalFile.Destination,
-			Permissions: (*fs.FileMode)(additionalFile.Permissions),
+			Src:            absSourceFile,
+			Content:        additionalFile.Content,
+			Dest:           additionalFile.Destination,
+			Permissions:    (*fs.FileMode)(additionalFile.Permissions),
+			PreserveXattrs: additionalFilesPreserveXattrs,
+		}
+
+		if additionalFile.Template {
+			content, err := renderAdditionalFileTemplate(baseConfigPath, additionalFile, templateVariables)
+			if err != nil {
+				return err
+			}
+
+			if fileToCopy.Src != "" && fileToCopy.Permissions == nil {
+				// Keep the source file's permissions, like a copied file does.
+				stat, err := os.Stat(fileToCopy.Src)
+				if err != nil {
+					return fmt.Errorf("failed to stat additional file (%s):\n%w", additionalFile.Source, err)
+				}
+
+				permissions := stat.Mode().Perm()
+				fileToCopy.Permissions = &permissions
+			}
+
+			fileToCopy.Src = ""
+			fileToCopy.Content = &content
 		}
 
 		err := imageChroot.AddFiles(fileToCopy)
@@ -44,6 +73,42 @@ func copyAdditionalFiles(baseConfigPath string, additionalFiles imagecustomizera
 	return nil
 }
 
+// renderAdditionalFileTemplate renders the contents of a templated additional file with the template variables.
+func renderAdditionalFileTemplate(baseConfigPath string, additionalFile imagecustomizerapi.AdditionalFile,
+	templateVariables map[string]string,
+) (string, error) {
+	content := ""
+	if additionalFile.Content != nil {
+		content = *additionalFile.Content
+	} else {
+		absSourceFile := file.GetAbsPathWithBase(baseConfigPath, additionalFile.Source)
+		contentBytes, err := os.ReadFile(absSourceFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read additional file template (%s):\n%w", additionalFile.Source, err)
+		}
+		content = string(contentBytes)
+	}
+
+	// Referencing a variable that doesn't exist is an error, instead of silently producing an empty value.
+	fileTemplate, err := template.New(additionalFile.Destination).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("invalid template for additional file (%s):\n%w", additionalFile.Destination, err)
+	}
+
+	if templateVariables == nil {
+		templateVariables = map[string]string{}
+	}
+
+	var rendered strings.Builder
+	err = fileTemplate.Execute(&rendered, templateVariables)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template for additional file (%s):\n%w", additionalFile.Destination,
+			err)
+	}
+
+	return rendered.String(), nil
+}
+
 func copyAdditionalDirs(baseConfigPath string, additionalDirs imagecustomizerapi.DirConfigList, imageChroot *safechroot.Chroot) error {
 	for _, dirConfigElement := range additionalDirs {
 		absSourceDir := file.GetAbsPathWithBase(baseConfigPath, dirConfigElement.Source)
@@ -65,6 +130,7 @@ func copyAdditionalDirs(baseConfigPath string, additionalDirs imagecustomizerapi
 			NewDirPermissions:    newDirPermissionsValue,
 			ChildFilePermissions: childFilePermissionsValue,
 			MergedDirPermissions: (*fs.FileMode)(dirConfigElement.MergedDirPermissions),
+			PreserveXattrs:       additionalFilesPreserveXattrs,
 		}
 		err := imageChroot.AddDirs(dirToCopy)
 		if err != nil {
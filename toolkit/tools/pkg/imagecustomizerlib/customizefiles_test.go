@@ -40,7 +40,7 @@ func TestCopyAdditionalFiles(t *testing.T) {
 			Destination: "/copy_2.txt",
 			Permissions: ptrutils.PtrTo(imagecustomizerapi.FilePermissions(copy_2_filemode)),
 		},
-	}, chroot)
+	}, nil, chroot)
 	assert.NoError(t, err)
 
 	a_orig_path := filepath.Join(baseConfigPath, "files/a.txt")
@@ -61,7 +61,7 @@ func TestCopyAdditionalFiles(t *testing.T) {
 			Source:      "files/b.txt",
 			Destination: "/copy_1.txt",
 		},
-	}, chroot)
+	}, nil, chroot)
 	assert.NoError(t, err)
 
 	b_orig_path := filepath.Join(baseConfigPath, "files/b.txt")
@@ -0,0 +1,314 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	nvidiaKernelModuleName        = "nvidia"
+	nvidiaContainerToolkitPackage = "nvidia-container-toolkit"
+	nvidiaCtkPath                 = "/usr/bin/nvidia-ctk"
+	nouveauModprobeConfPath       = "/etc/modprobe.d/nouveau-blacklist.conf"
+
+	amdKernelModuleName             = "amdgpu"
+	amdInTreeDriverSubpackageSuffix = "-drivers-intree-amdgpu"
+)
+
+// gpuContainerRuntime is a container runtime that the NVIDIA container toolkit can be configured for.
+type gpuContainerRuntime struct {
+	name       string
+	binaryPath string
+}
+
+var gpuContainerRuntimes = []gpuContainerRuntime{
+	{name: "docker", binaryPath: "/usr/bin/dockerd"},
+	{name: "containerd", binaryPath: "/usr/bin/containerd"},
+}
+
+// getModuleSigning returns the module signing config of the OS. If the GPU's driver must be signed for secure boot and
+// module signing wasn't configured explicitly, then the out-of-tree kernel modules are signed with an ephemeral key.
+func getModuleSigning(config *imagecustomizerapi.OS) *imagecustomizerapi.ModuleSigning {
+	if config == nil {
+		return nil
+	}
+
+	if config.Gpu == nil || !config.Gpu.SecureBoot || config.ModuleSigning != nil {
+		return config.ModuleSigning
+	}
+
+	if config.Gpu.Vendor == imagecustomizerapi.GpuVendorAmd && config.Gpu.DriverPackage == "" {
+		// The in-tree driver is signed by the kernel's build.
+		return nil
+	}
+
+	return &imagecustomizerapi.ModuleSigning{
+		Enrollment: &imagecustomizerapi.MokEnrollment{
+			Automatic: true,
+		},
+	}
+}
+
+// installGpuDriver installs the GPU's driver for the image's kernel, checks that the driver's kernel modules match
+// the kernel, and installs the GPU's container toolkit. The RPM sources must already be mounted.
+//
+// Returns the names of the packages that were installed.
+func installGpuDriver(gpu *imagecustomizerapi.Gpu, imageChroot *safechroot.Chroot) ([]string, error) {
+	if gpu == nil {
+		return nil, nil
+	}
+
+	kernelVersion, err := getGpuKernelVersion(imageChroot.RootDir())
+	if err != nil {
+		return nil, err
+	}
+
+	driverPackageName, driverPackageSpec, err := getGpuDriverPackage(gpu, kernelVersion, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Infof("Installing %s GPU driver (%s) for kernel (%s)", gpu.Vendor, driverPackageSpec, kernelVersion)
+
+	err = installOrUpdatePackages("install", []string{driverPackageSpec}, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateGpuDriver(gpu, kernelVersion, imageChroot)
+	if err != nil {
+		return nil, fmt.Errorf("GPU driver (%s) is incompatible with the image:\n%w", driverPackageSpec, err)
+	}
+
+	installedPackages := []string{driverPackageName}
+
+	if gpu.Vendor == imagecustomizerapi.GpuVendorNvidia {
+		// Keep the open-source driver from claiming the GPUs.
+		err = file.WriteWithPerm("blacklist nouveau\noptions nouveau modeset=0\n",
+			filepath.Join(imageChroot.RootDir(), nouveauModprobeConfPath), 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write (%s):\n%w", nouveauModprobeConfPath, err)
+		}
+	}
+
+	if gpu.ContainerToolkit {
+		err = installNvidiaContainerToolkit(imageChroot)
+		if err != nil {
+			return nil, err
+		}
+
+		installedPackages = append(installedPackages, nvidiaContainerToolkitPackage)
+	}
+
+	return installedPackages, nil
+}
+
+// getGpuKernelVersion returns the version of the image's kernel. The image must have exactly one kernel, so that the
+// driver can be matched to it.
+func getGpuKernelVersion(rootDir string) (string, error) {
+	kernelVersions, err := getImageKernelVersions(rootDir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(kernelVersions) != 1 {
+		return "", fmt.Errorf("the image must have exactly one kernel to install a GPU driver for, found (%d): %v",
+			len(kernelVersions), kernelVersions)
+	}
+
+	return kernelVersions[0], nil
+}
+
+// getGpuDriverPackage returns the name of the driver's package and the package spec that is passed to tdnf.
+func getGpuDriverPackage(gpu *imagecustomizerapi.Gpu, kernelVersion string, imageChroot *safechroot.Chroot,
+) (string, string, error) {
+	if gpu.DriverPackage != "" {
+		spec := gpu.DriverPackage
+		if gpu.DriverVersion != "" {
+			spec += "-" + gpu.DriverVersion
+		}
+		return gpu.DriverPackage, spec, nil
+	}
+
+	// The in-tree amdgpu driver is a subpackage of the kernel. So, pin it to the version of the installed kernel.
+	// Otherwise, tdnf would install the latest version, along with the kernel it requires.
+	kernelPackageName, kernelPackageVersion, err := getKernelPackage(kernelVersion, imageChroot)
+	if err != nil {
+		return "", "", err
+	}
+
+	name := kernelPackageName + amdInTreeDriverSubpackageSuffix
+	return name, name + "-" + kernelPackageVersion, nil
+}
+
+// getKernelPackage returns the name and version-release of the package that installed a kernel.
+func getKernelPackage(kernelVersion string, imageChroot *safechroot.Chroot) (string, string, error) {
+	kernelImagePath := filepath.Join(kernelModulesDir, kernelVersion, "vmlinuz")
+
+	exists, err := file.PathExists(filepath.Join(imageChroot.RootDir(), kernelImagePath))
+	if err != nil {
+		return "", "", err
+	}
+
+	if !exists {
+		kernelImagePath = filepath.Join("/boot", "vmlinuz-"+kernelVersion)
+	}
+
+	var stdout string
+	err = imageChroot.UnsafeRun(func() error {
+		var stderr string
+		stdout, stderr, err = shell.Execute("rpm", "--query", "--file", "--queryformat",
+			"%{NAME} %{VERSION}-%{RELEASE}\n", kernelImagePath)
+		if err != nil {
+			return fmt.Errorf("%s\n%w", strings.TrimSpace(stderr), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find package of kernel (%s):\n%w", kernelVersion, err)
+	}
+
+	fields := strings.Fields(stdout)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected rpm output for kernel (%s): %s", kernelVersion,
+			strings.TrimSpace(stdout))
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// validateGpuDriver checks that installing the driver didn't change the image's kernel and that the driver's kernel
+// module was built for the image's kernel.
+func validateGpuDriver(gpu *imagecustomizerapi.Gpu, kernelVersion string, imageChroot *safechroot.Chroot) error {
+	kernelVersions, err := getImageKernelVersions(imageChroot.RootDir())
+	if err != nil {
+		return err
+	}
+
+	if len(kernelVersions) != 1 || kernelVersions[0] != kernelVersion {
+		return fmt.Errorf("installing the driver changed the image's kernels from (%s) to (%v)", kernelVersion,
+			kernelVersions)
+	}
+
+	moduleName := nvidiaKernelModuleName
+	if gpu.Vendor == imagecustomizerapi.GpuVendorAmd {
+		moduleName = amdKernelModuleName
+	}
+
+	modulePath, err := findKernelModule(imageChroot.RootDir(), kernelVersion, moduleName)
+	if err != nil {
+		return err
+	}
+
+	var stdout string
+	err = imageChroot.UnsafeRun(func() error {
+		var stderr string
+		stdout, stderr, err = shell.Execute("modinfo", "--field", "vermagic", modulePath)
+		if err != nil {
+			return fmt.Errorf("%s\n%w", strings.TrimSpace(stderr), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read vermagic of kernel module (%s):\n%w", modulePath, err)
+	}
+
+	moduleKernelVersion := parseVermagicKernelVersion(stdout)
+	if moduleKernelVersion != kernelVersion {
+		return fmt.Errorf("kernel module (%s) was built for kernel (%s), not the image's kernel (%s)", moduleName,
+			moduleKernelVersion, kernelVersion)
+	}
+
+	return nil
+}
+
+// findKernelModule returns the path, relative to the image's root directory, of a kernel's module.
+func findKernelModule(rootDir string, kernelVersion string, moduleName string) (string, error) {
+	modulesDir := filepath.Join(rootDir, kernelModulesDir, kernelVersion)
+
+	modulePath := ""
+	err := filepath.WalkDir(modulesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		compression := getKernelModuleCompression(path)
+		if !d.Type().IsRegular() || compression == nil {
+			return nil
+		}
+
+		if strings.TrimSuffix(d.Name(), compression.extension) == moduleName {
+			relPath, err := filepath.Rel(rootDir, path)
+			if err != nil {
+				return err
+			}
+
+			modulePath = "/" + relPath
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to search for kernel module (%s):\n%w", moduleName, err)
+	}
+
+	if modulePath == "" {
+		return "", fmt.Errorf("kernel module (%s) wasn't found for kernel (%s)", moduleName, kernelVersion)
+	}
+
+	return modulePath, nil
+}
+
+// parseVermagicKernelVersion returns the kernel version of a module's vermagic (e.g.
+// "6.6.57.1-1.azl3 SMP preempt mod_unload modversions").
+func parseVermagicKernelVersion(vermagic string) string {
+	fields := strings.Fields(vermagic)
+	if len(fields) <= 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// installNvidiaContainerToolkit installs the NVIDIA container toolkit and configures the installed container
+// runtimes to use it.
+func installNvidiaContainerToolkit(imageChroot *safechroot.Chroot) error {
+	logger.Log.Infof("Installing NVIDIA container toolkit")
+
+	err := installOrUpdatePackages("install", []string{nvidiaContainerToolkitPackage}, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	for _, runtime := range gpuContainerRuntimes {
+		exists, err := file.PathExists(filepath.Join(imageChroot.RootDir(), runtime.binaryPath))
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			continue
+		}
+
+		logger.Log.Infof("Configuring container runtime (%s) for NVIDIA GPUs", runtime.name)
+
+		err = imageChroot.UnsafeRun(func() error {
+			return shell.ExecuteLiveWithErr(1, nvidiaCtkPath, "runtime", "configure", "--runtime="+runtime.name)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure container runtime (%s) for NVIDIA GPUs:\n%w", runtime.name, err)
+		}
+	}
+
+	return nil
+}
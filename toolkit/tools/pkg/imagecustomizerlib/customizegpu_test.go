@@ -0,0 +1,108 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetModuleSigningGpu(t *testing.T) {
+	config := imagecustomizerapi.OS{
+		Gpu: &imagecustomizerapi.Gpu{
+			Vendor:        imagecustomizerapi.GpuVendorNvidia,
+			DriverPackage: "cuda-open",
+			SecureBoot:    true,
+		},
+	}
+
+	moduleSigning := getModuleSigning(&config)
+	if assert.NotNil(t, moduleSigning) {
+		assert.Nil(t, moduleSigning.Key)
+		assert.Equal(t, &imagecustomizerapi.MokEnrollment{Automatic: true}, moduleSigning.Enrollment)
+	}
+
+	// The config itself isn't modified.
+	assert.Nil(t, config.ModuleSigning)
+}
+
+func TestGetModuleSigningGpuExplicit(t *testing.T) {
+	moduleSigning := &imagecustomizerapi.ModuleSigning{
+		Key: &imagecustomizerapi.ModuleSigningKey{
+			PrivateKeyPath:  "key.pem",
+			CertificatePath: "cert.pem",
+		},
+	}
+	config := imagecustomizerapi.OS{
+		Gpu: &imagecustomizerapi.Gpu{
+			Vendor:        imagecustomizerapi.GpuVendorNvidia,
+			DriverPackage: "cuda-open",
+			SecureBoot:    true,
+		},
+		ModuleSigning: moduleSigning,
+	}
+
+	assert.Same(t, moduleSigning, getModuleSigning(&config))
+}
+
+func TestGetModuleSigningGpuNotNeeded(t *testing.T) {
+	// The in-tree amdgpu driver is already signed.
+	config := imagecustomizerapi.OS{
+		Gpu: &imagecustomizerapi.Gpu{
+			Vendor:     imagecustomizerapi.GpuVendorAmd,
+			SecureBoot: true,
+		},
+	}
+
+	assert.Nil(t, getModuleSigning(&config))
+
+	config = imagecustomizerapi.OS{
+		Gpu: &imagecustomizerapi.Gpu{
+			Vendor:        imagecustomizerapi.GpuVendorNvidia,
+			DriverPackage: "cuda-open",
+		},
+	}
+
+	assert.Nil(t, getModuleSigning(&config))
+}
+
+func TestGetGpuDriverPackageExplicit(t *testing.T) {
+	gpu := &imagecustomizerapi.Gpu{
+		Vendor:        imagecustomizerapi.GpuVendorNvidia,
+		DriverPackage: "cuda-open",
+		DriverVersion: "550.54.15",
+	}
+
+	name, spec, err := getGpuDriverPackage(gpu, "6.6.57.1-1.azl3", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "cuda-open", name)
+	assert.Equal(t, "cuda-open-550.54.15", spec)
+}
+
+func TestFindKernelModule(t *testing.T) {
+	rootDir := t.TempDir()
+	modulePath := filepath.Join(rootDir, "lib/modules/6.6.57.1-1.azl3/extra/nvidia.ko.xz")
+	err := os.MkdirAll(filepath.Dir(modulePath), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(modulePath, nil, 0o644)
+	require.NoError(t, err)
+
+	path, err := findKernelModule(rootDir, "6.6.57.1-1.azl3", "nvidia")
+	assert.NoError(t, err)
+	assert.Equal(t, "/lib/modules/6.6.57.1-1.azl3/extra/nvidia.ko.xz", path)
+
+	_, err = findKernelModule(rootDir, "6.6.57.1-1.azl3", "amdgpu")
+	assert.ErrorContains(t, err, "kernel module (amdgpu) wasn't found for kernel (6.6.57.1-1.azl3)")
+}
+
+func TestParseVermagicKernelVersion(t *testing.T) {
+	assert.Equal(t, "6.6.57.1-1.azl3",
+		parseVermagicKernelVersion("6.6.57.1-1.azl3 SMP preempt mod_unload modversions\n"))
+	assert.Equal(t, "", parseVermagicKernelVersion("\n"))
+}
@@ -0,0 +1,568 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+)
+
+const (
+	hotfixReportFileSuffix = ".hotfix.json"
+
+	hotfixRpmsDirInChroot         = "/_hotfixrpms"
+	hotfixRelabelListFileInChroot = "/_hotfixrelabel.list"
+)
+
+var (
+	// Matches the files that belong to a specific kernel version.
+	kernelModulesPathRegex = regexp.MustCompile(`^(?:/usr)?/lib/modules/([^/]+)/`)
+	kernelImagePathRegex   = regexp.MustCompile(`^(?:/boot/vmlinuz-[^/]+|(?:/usr)?/lib/modules/[^/]+/vmlinuz)$`)
+
+	// Matches the initramfs files generated by dracut (Azure Linux 3.0) and mkinitrd (Azure Linux 2.0).
+	initrdFileNameRegex = regexp.MustCompile(`^(?:initramfs-(.+)\.img|initrd\.img-(.+))$`)
+)
+
+// hotfixReport is the minimal change report of a hotfix respin.
+type hotfixReport struct {
+	Packages changemanifest.Packages `json:"packages"`
+	// InitrdKernels are the kernel versions whose initramfs was regenerated.
+	InitrdKernels []string `json:"initrdKernels"`
+	// RemovedInitrdKernels are the kernel versions that were removed, along with their initramfs.
+	RemovedInitrdKernels []string `json:"removedInitrdKernels"`
+	// GrubConfigRegenerated is true if the grub.cfg file was regenerated.
+	GrubConfigRegenerated bool `json:"grubConfigRegenerated"`
+}
+
+// hotfixBootImpact lists the boot artifacts that a set of changed files affects.
+type hotfixBootImpact struct {
+	// KernelVersions are the kernels whose modules were changed.
+	KernelVersions map[string]bool
+	// KernelsChanged is true if a kernel image was added or changed.
+	KernelsChanged bool
+}
+
+// applyHotfix applies a set of updated RPMs on top of the image. Unlike the general OS customization steps, this
+// only regenerates the boot artifacts affected by the updated RPMs, so that emergency respins are quick.
+func applyHotfix(baseConfigPath string, hotfix *imagecustomizerapi.Hotfix, imageChroot *safechroot.Chroot,
+	imageUuid string,
+) (*hotfixReport, error) {
+	logger.Log.Infof("Applying hotfix RPMs")
+
+	buildTime := time.Now().Format("2006-01-02T15:04:05Z")
+
+	rpmFiles, err := collectHotfixRpms(baseConfigPath, hotfix.Rpms)
+	if err != nil {
+		return nil, err
+	}
+
+	installedBefore, err := getInstalledPackages(imageChroot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installed packages:\n%w", err)
+	}
+
+	err = installHotfixRpms(rpmFiles, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	installedAfter, err := getInstalledPackages(imageChroot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installed packages:\n%w", err)
+	}
+
+	report := &hotfixReport{
+		Packages:             diffInstalledPackages(installedBefore, installedAfter),
+		InitrdKernels:        []string{},
+		RemovedInitrdKernels: []string{},
+	}
+
+	changedFiles, err := getHotfixChangedFiles(report.Packages, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	impact := getHotfixBootImpact(changedFiles)
+
+	report.RemovedInitrdKernels, err = removeStaleInitrds(imageChroot.RootDir())
+	if err != nil {
+		return nil, err
+	}
+
+	var initrdPaths []string
+	report.InitrdKernels, initrdPaths, err = regenerateAffectedInitrds(impact, changedFiles, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	if impact.KernelsChanged || len(report.RemovedInitrdKernels) > 0 {
+		bootCustomizer, err := NewBootCustomizer(imageChroot)
+		if err != nil {
+			return nil, err
+		}
+
+		if bootCustomizer.IsGrubMkconfigImage() {
+			err = installutils.CallGrubMkconfig(imageChroot)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate grub.cfg via grub2-mkconfig:\n%w", err)
+			}
+
+			report.GrubConfigRegenerated = true
+		}
+	}
+
+	err = addCustomizerRelease(imageChroot, ToolVersion, buildTime, imageUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	relabelFiles := append([]string(nil), changedFiles...)
+	relabelFiles = append(relabelFiles, "/etc/image-customizer-release")
+	relabelFiles = append(relabelFiles, initrdPaths...)
+	if report.GrubConfigRegenerated {
+		relabelFiles = append(relabelFiles, installutils.GrubCfgFile)
+	}
+
+	err = relabelHotfixFiles(relabelFiles, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Infof("Hotfix packages installed: %d, removed: %d, updated: %d", len(report.Packages.Installed),
+		len(report.Packages.Removed), len(report.Packages.Updated))
+
+	return report, nil
+}
+
+// collectHotfixRpms returns the RPM files listed in the hotfix config, expanding any directories.
+func collectHotfixRpms(baseConfigPath string, rpmPaths []string) ([]string, error) {
+	rpmFiles := []string(nil)
+
+	for _, rpmPath := range rpmPaths {
+		fullPath := file.GetAbsPathWithBase(baseConfigPath, rpmPath)
+
+		isDir, err := file.IsDir(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hotfix RPM path (%s):\n%w", rpmPath, err)
+		}
+
+		if !isDir {
+			if !strings.HasSuffix(fullPath, ".rpm") {
+				return nil, fmt.Errorf("invalid hotfix RPM path (%s): must be an RPM file or a directory", rpmPath)
+			}
+
+			rpmFiles = append(rpmFiles, fullPath)
+			continue
+		}
+
+		dirRpmFiles := []string(nil)
+		err = filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.Type().IsRegular() && strings.HasSuffix(d.Name(), ".rpm") {
+				dirRpmFiles = append(dirRpmFiles, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hotfix RPM directory (%s):\n%w", rpmPath, err)
+		}
+
+		if len(dirRpmFiles) <= 0 {
+			return nil, fmt.Errorf("hotfix RPM directory (%s) doesn't contain any RPMs", rpmPath)
+		}
+
+		sort.Strings(dirRpmFiles)
+		rpmFiles = append(rpmFiles, dirRpmFiles...)
+	}
+
+	return rpmFiles, nil
+}
+
+// installHotfixRpms installs the RPMs as a single transaction. No repos are used. So, the RPMs must not have any
+// dependencies that aren't already installed or in the set itself.
+func installHotfixRpms(rpmFiles []string, imageChroot *safechroot.Chroot) error {
+	rpmsDir := filepath.Join(imageChroot.RootDir(), hotfixRpmsDirInChroot)
+
+	err := os.MkdirAll(rpmsDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create hotfix RPMs directory:\n%w", err)
+	}
+	defer os.RemoveAll(rpmsDir)
+
+	rpmArgs := []string{"-U", "-v"}
+	for i, rpmFile := range rpmFiles {
+		// Prefix the index to avoid collisions between RPMs with the same file name from different directories.
+		fileName := fmt.Sprintf("%d-%s", i, filepath.Base(rpmFile))
+
+		err = file.Copy(rpmFile, filepath.Join(rpmsDir, fileName))
+		if err != nil {
+			return fmt.Errorf("failed to copy hotfix RPM (%s):\n%w", rpmFile, err)
+		}
+
+		rpmArgs = append(rpmArgs, filepath.Join(hotfixRpmsDirInChroot, fileName))
+	}
+
+	err = imageChroot.UnsafeRun(func() error {
+		return shell.ExecuteLiveWithErr(1, "rpm", rpmArgs...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to install hotfix RPMs:\n%w", err)
+	}
+
+	return os.RemoveAll(rpmsDir)
+}
+
+// getHotfixChangedFiles lists the files owned by the installed and updated packages.
+func getHotfixChangedFiles(packages changemanifest.Packages, imageChroot *safechroot.Chroot) ([]string, error) {
+	packageNames := []string(nil)
+	for _, pkg := range packages.Installed {
+		packageNames = append(packageNames, installedPackageFullName(pkg.Name, pkg.Version))
+	}
+	for _, pkg := range packages.Updated {
+		packageNames = append(packageNames, installedPackageFullName(pkg.Name, pkg.NewVersion))
+	}
+
+	if len(packageNames) <= 0 {
+		return nil, nil
+	}
+
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		var err error
+		stdout, _, err = shell.Execute("rpm", append([]string{"-ql"}, packageNames...)...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of hotfix packages:\n%w", err)
+	}
+
+	files := []string(nil)
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "/") {
+			files = append(files, line)
+		}
+	}
+
+	return files, nil
+}
+
+// installedPackageFullName converts a "name.arch" and "epoch:version-release" pair into a "name-version-release.arch"
+// string that rpm can query.
+func installedPackageFullName(nameArch string, version string) string {
+	name, arch := splitPackageNameArch(nameArch)
+	_, versionRelease, found := strings.Cut(version, ":")
+	if !found {
+		versionRelease = version
+	}
+
+	return fmt.Sprintf("%s-%s.%s", name, versionRelease, arch)
+}
+
+func getHotfixBootImpact(changedFiles []string) hotfixBootImpact {
+	impact := hotfixBootImpact{
+		KernelVersions: make(map[string]bool),
+	}
+
+	for _, changedFile := range changedFiles {
+		if match := kernelModulesPathRegex.FindStringSubmatch(changedFile); match != nil {
+			impact.KernelVersions[match[1]] = true
+		}
+
+		if kernelImagePathRegex.MatchString(changedFile) {
+			impact.KernelsChanged = true
+		}
+	}
+
+	return impact
+}
+
+// getImageKernelVersions returns the versions of the kernels installed in the image.
+func getImageKernelVersions(rootDir string) ([]string, error) {
+	modulesDir := filepath.Join(rootDir, "/lib/modules")
+
+	entries, err := os.ReadDir(modulesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kernel modules directory:\n%w", err)
+	}
+
+	kernelVersions := []string(nil)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// A kernel's modules directory can be left behind after the kernel package is removed (e.g. if it contains
+		// files that were created after the package was installed). So, only count directories with a kernel image.
+		kernelExists, err := file.PathExists(filepath.Join(modulesDir, entry.Name(), "vmlinuz"))
+		if err != nil {
+			return nil, err
+		}
+
+		if !kernelExists {
+			kernelExists, err = file.PathExists(filepath.Join(rootDir, "/boot", "vmlinuz-"+entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if kernelExists {
+			kernelVersions = append(kernelVersions, entry.Name())
+		}
+	}
+
+	return kernelVersions, nil
+}
+
+// getInitrdFiles returns the existing initramfs files, indexed by kernel version.
+func getInitrdFiles(rootDir string) (map[string]string, error) {
+	bootDir := filepath.Join(rootDir, "/boot")
+
+	entries, err := os.ReadDir(bootDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read boot directory:\n%w", err)
+	}
+
+	initrdFiles := make(map[string]string)
+	for _, entry := range entries {
+		match := initrdFileNameRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		kernelVersion := match[1] + match[2]
+		initrdFiles[kernelVersion] = filepath.Join("/boot", entry.Name())
+	}
+
+	return initrdFiles, nil
+}
+
+// removeStaleInitrds removes the initramfs files of kernels that are no longer installed.
+func removeStaleInitrds(rootDir string) ([]string, error) {
+	initrdFiles, err := getInitrdFiles(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	kernelVersions, err := getImageKernelVersions(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	installedKernels := make(map[string]bool)
+	for _, kernelVersion := range kernelVersions {
+		installedKernels[kernelVersion] = true
+	}
+
+	removed := []string{}
+	for kernelVersion, initrdPath := range initrdFiles {
+		if installedKernels[kernelVersion] {
+			continue
+		}
+
+		logger.Log.Infof("Removing initramfs of removed kernel (%s)", kernelVersion)
+
+		err = os.Remove(filepath.Join(rootDir, initrdPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove stale initramfs (%s):\n%w", initrdPath, err)
+		}
+
+		removed = append(removed, kernelVersion)
+	}
+
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// regenerateAffectedInitrds regenerates the initramfs of each kernel whose modules were changed or whose existing
+// initramfs contains a changed file. Kernels without an initramfs (i.e. newly installed kernels) also get one.
+//
+// Returns the regenerated kernel versions and the paths of their initramfs files.
+func regenerateAffectedInitrds(impact hotfixBootImpact, changedFiles []string, imageChroot *safechroot.Chroot,
+) ([]string, []string, error) {
+	kernelVersions, err := getImageKernelVersions(imageChroot.RootDir())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initrdFiles, err := getInitrdFiles(imageChroot.RootDir())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changedFilesSet := make(map[string]bool, len(changedFiles))
+	for _, changedFile := range changedFiles {
+		changedFilesSet[changedFile] = true
+	}
+
+	// Name new initramfs files the same way as the existing ones.
+	initrdFileNameFormat := "initramfs-%s.img"
+	for _, initrdPath := range initrdFiles {
+		if strings.HasPrefix(filepath.Base(initrdPath), "initrd.img-") {
+			initrdFileNameFormat = "initrd.img-%s"
+		}
+	}
+
+	regenerated := []string{}
+	regeneratedPaths := []string(nil)
+	for _, kernelVersion := range kernelVersions {
+		initrdPath, hasInitrd := initrdFiles[kernelVersion]
+
+		regenerate := !hasInitrd || impact.KernelVersions[kernelVersion]
+		if !regenerate && len(changedFilesSet) > 0 {
+			initrdContents, err := listInitrdFiles(initrdPath, imageChroot)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, initrdFile := range initrdContents {
+				if changedFilesSet[initrdFile] {
+					logger.Log.Debugf("Initramfs of kernel (%s) contains changed file (%s)", kernelVersion, initrdFile)
+					regenerate = true
+					break
+				}
+			}
+		}
+
+		if !regenerate {
+			continue
+		}
+
+		if !hasInitrd {
+			initrdPath = filepath.Join("/boot", fmt.Sprintf(initrdFileNameFormat, kernelVersion))
+		}
+
+		logger.Log.Infof("Regenerating initramfs of kernel (%s)", kernelVersion)
+
+		err = imageChroot.UnsafeRun(func() error {
+			return shell.ExecuteLiveWithErr(1, "dracut", "--force", initrdPath, kernelVersion)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to regenerate initramfs of kernel (%s):\n%w", kernelVersion, err)
+		}
+
+		regenerated = append(regenerated, kernelVersion)
+		regeneratedPaths = append(regeneratedPaths, initrdPath)
+	}
+
+	return regenerated, regeneratedPaths, nil
+}
+
+// listInitrdFiles lists the files within an initramfs file.
+func listInitrdFiles(initrdPath string, imageChroot *safechroot.Chroot) ([]string, error) {
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		var err error
+		stdout, _, err = shell.Execute("lsinitrd", initrdPath)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contents of initramfs (%s):\n%w", initrdPath, err)
+	}
+
+	return parseLsinitrdOutput(stdout), nil
+}
+
+// parseLsinitrdOutput extracts the file paths from the "ls -l" style listing printed by lsinitrd.
+func parseLsinitrdOutput(output string) []string {
+	files := []string(nil)
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || len(fields[0]) != 10 || !strings.ContainsAny(fields[0][:1], "-dlcbps") {
+			continue
+		}
+
+		path := strings.Join(fields[8:], " ")
+		if fields[0][0] == 'l' {
+			// Symlinks are printed as "path -> target".
+			path, _, _ = strings.Cut(path, " -> ")
+		}
+
+		if path == "." {
+			continue
+		}
+
+		files = append(files, "/"+strings.TrimPrefix(path, "/"))
+	}
+
+	return files
+}
+
+// relabelHotfixFiles resets the SELinux labels of the files changed by the hotfix, instead of relabeling the entire
+// filesystem.
+func relabelHotfixFiles(files []string, imageChroot *safechroot.Chroot) error {
+	bootCustomizer, err := NewBootCustomizer(imageChroot)
+	if err != nil {
+		return err
+	}
+
+	selinuxMode, err := bootCustomizer.GetSELinuxMode(imageChroot)
+	if err != nil {
+		return fmt.Errorf("failed to get current SELinux mode:\n%w", err)
+	}
+
+	if selinuxMode == imagecustomizerapi.SELinuxModeDisabled {
+		return nil
+	}
+
+	// Skip the paths that no longer exist (e.g. files of a package that were removed by a later package).
+	existingFiles := []string(nil)
+	for _, path := range files {
+		_, err := os.Lstat(filepath.Join(imageChroot.RootDir(), path))
+		if err == nil {
+			existingFiles = append(existingFiles, path)
+		}
+	}
+
+	if len(existingFiles) <= 0 {
+		return nil
+	}
+
+	logger.Log.Infof("Setting SELinux labels of hotfix files")
+
+	selinuxConfigPath := filepath.Join(imageChroot.RootDir(), installutils.SELinuxConfigFile)
+	stdout, _, err := shell.Execute("sed", "-n", "s/^SELINUXTYPE=\\(.*\\)$/\\1/p", selinuxConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to find an SELINUXTYPE in (%s):\n%w", selinuxConfigPath, err)
+	}
+
+	fileContextsPath := fmt.Sprintf("/etc/selinux/%s/contexts/files/file_contexts", strings.TrimSpace(stdout))
+
+	listFilePath := filepath.Join(imageChroot.RootDir(), hotfixRelabelListFileInChroot)
+	err = file.WriteLines(existingFiles, listFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write SELinux relabel list:\n%w", err)
+	}
+	defer os.Remove(listFilePath)
+
+	err = imageChroot.UnsafeRun(func() error {
+		return shell.ExecuteLiveWithErr(1, "setfiles", "-F", "-f", hotfixRelabelListFileInChroot, fileContextsPath)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set SELinux labels of hotfix files:\n%w", err)
+	}
+
+	return nil
+}
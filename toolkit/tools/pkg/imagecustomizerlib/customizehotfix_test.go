@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectHotfixRpms(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestCollectHotfixRpms")
+	defer os.RemoveAll(testTmpDir)
+
+	files := []string{
+		"kernel.rpm",
+		"openssl/openssl-libs.rpm",
+		"openssl/openssl.rpm",
+		"openssl/README.md",
+		"empty/README.md",
+		"notes.txt",
+	}
+	for _, path := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(testTmpDir, path)), os.ModePerm)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = file.Write("", filepath.Join(testTmpDir, path))
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	rpmFiles, err := collectHotfixRpms(testTmpDir, []string{"kernel.rpm", "openssl"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(testTmpDir, "kernel.rpm"),
+		filepath.Join(testTmpDir, "openssl/openssl-libs.rpm"),
+		filepath.Join(testTmpDir, "openssl/openssl.rpm"),
+	}, rpmFiles)
+
+	_, err = collectHotfixRpms(testTmpDir, []string{"empty"})
+	assert.ErrorContains(t, err, "hotfix RPM directory (empty) doesn't contain any RPMs")
+
+	_, err = collectHotfixRpms(testTmpDir, []string{"notes.txt"})
+	assert.ErrorContains(t, err, "invalid hotfix RPM path (notes.txt): must be an RPM file or a directory")
+
+	_, err = collectHotfixRpms(testTmpDir, []string{"missing.rpm"})
+	assert.ErrorContains(t, err, "invalid hotfix RPM path (missing.rpm)")
+}
+
+func TestInstalledPackageFullName(t *testing.T) {
+	assert.Equal(t, "openssl-3.3.2-1.azl3.x86_64", installedPackageFullName("openssl.x86_64", "0:3.3.2-1.azl3"))
+	assert.Equal(t, "python3.12-3.12.3-4.azl3.x86_64",
+		installedPackageFullName("python3.12.x86_64", "3.12.3-4.azl3"))
+}
+
+func TestGetHotfixBootImpact(t *testing.T) {
+	impact := getHotfixBootImpact([]string{
+		"/usr/lib/libssl.so.3",
+		"/lib/modules/6.6.51.1-1.azl3/kernel/drivers/net/foo.ko.xz",
+		"/usr/lib/modules/6.6.47.1-1.azl3/extra/livepatch.ko",
+	})
+	assert.Equal(t, map[string]bool{"6.6.51.1-1.azl3": true, "6.6.47.1-1.azl3": true}, impact.KernelVersions)
+	assert.False(t, impact.KernelsChanged)
+
+	impact = getHotfixBootImpact([]string{"/boot/vmlinuz-6.6.51.1-1.azl3"})
+	assert.True(t, impact.KernelsChanged)
+
+	impact = getHotfixBootImpact([]string{"/lib/modules/6.6.51.1-1.azl3/vmlinuz"})
+	assert.True(t, impact.KernelsChanged)
+	assert.Equal(t, map[string]bool{"6.6.51.1-1.azl3": true}, impact.KernelVersions)
+}
+
+func TestParseLsinitrdOutput(t *testing.T) {
+	output := `Image: /boot/initramfs-6.6.51.1-1.azl3.img: 28M
+========================================================================
+Version: dracut-102-7.azl3
+
+Arguments:  --force
+
+dracut modules:
+systemd
+========================================================================
+drwxr-xr-x  12 root     root            0 Sep 20 10:00 .
+lrwxrwxrwx   1 root     root            7 Sep 20 10:00 bin -> usr/bin
+-rwxr-xr-x   1 root     root       123456 Sep 20 10:00 usr/lib64/libssl.so.3
+-rw-r--r--   1 root     root         1234 Sep 20 10:00 etc/my file.conf
+========================================================================
+`
+
+	files := parseLsinitrdOutput(output)
+	assert.Equal(t, []string{
+		"/bin",
+		"/usr/lib64/libssl.so.3",
+		"/etc/my file.conf",
+	}, files)
+}
+
+func TestRemoveStaleInitrds(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestRemoveStaleInitrds")
+	defer os.RemoveAll(testTmpDir)
+
+	files := []string{
+		"/boot/initramfs-6.6.47.1-1.azl3.img",
+		"/boot/initramfs-6.6.51.1-1.azl3.img",
+		"/boot/vmlinuz-6.6.51.1-1.azl3",
+		"/boot/initrd.img-5.15.0-1.cm2",
+		"/lib/modules/6.6.51.1-1.azl3/vmlinuz",
+		"/lib/modules/6.6.47.1-1.azl3/modules.dep",
+	}
+	for _, path := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(testTmpDir, path)), os.ModePerm)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = file.Write("", filepath.Join(testTmpDir, path))
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	removed, err := removeStaleInitrds(testTmpDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5.15.0-1.cm2", "6.6.47.1-1.azl3"}, removed)
+
+	initrdFiles, err := getInitrdFiles(testTmpDir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"6.6.51.1-1.azl3": "/boot/initramfs-6.6.51.1-1.azl3.img"}, initrdFiles)
+}
+
+func TestPlanCustomizationHotfix(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestPlanCustomizationHotfix")
+	defer os.RemoveAll(testTmpDir)
+
+	imageFile := filepath.Join(testTmpDir, "base.vhdx")
+	rpmFile := filepath.Join(testTmpDir, "rpms/openssl.rpm")
+	outImageFilePath := filepath.Join(testTmpDir, "image.vhdx")
+
+	for _, path := range []string{imageFile, rpmFile} {
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = file.Write("", path)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+
+	config := &imagecustomizerapi.Config{
+		Hotfix: &imagecustomizerapi.Hotfix{
+			Rpms: []string{"rpms"},
+		},
+	}
+
+	plan, err := PlanCustomization(filepath.Join(testTmpDir, "build"), testTmpDir, config, imageFile, nil,
+		outImageFilePath, "vhdx", "", "", false, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, ""+
+		"1. Convert input image\n"+
+		"   - file: "+imageFile+"\n"+
+		"   - format: vhdx\n"+
+		"2. Apply hotfix RPMs\n"+
+		"   - "+rpmFile+"\n"+
+		"3. Regenerate affected initramfs files and grub.cfg\n"+
+		"4. Write customizer release file\n"+
+		"5. Apply SELinux labels of hotfix files\n"+
+		"6. Check filesystems\n"+
+		"7. Write output image\n"+
+		"   - file: "+outImageFilePath+"\n"+
+		"   - format: vhdx\n"+
+		"8. Write hotfix report\n"+
+		"   - file: "+filepath.Join(testTmpDir, "image.hotfix.json")+"\n",
+		plan.String())
+}
@@ -0,0 +1,496 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// The range of system user and group IDs that are tracked by the ledger.
+	// See SYS_UID_MIN and SYS_UID_MAX in /etc/login.defs.
+	systemIdMin = 1
+	systemIdMax = 999
+
+	idLedgerHeader = "# UID/GID allocation ledger, maintained by the image customizer.\n" +
+		"# Entries may be edited, but an ID must not be reused for a different name.\n"
+
+	// The extended attribute that holds a file's capabilities, which the kernel clears when the file's owner is
+	// changed.
+	capabilityXattr = "security.capability"
+)
+
+var (
+	// The directories of the image that are not walked when updating file ownership, since they are pseudo
+	// filesystems that are mounted from the host.
+	idLedgerSkipDirs = []string{"/dev", "/proc", "/sys", "/run"}
+)
+
+// idLedger is the content of a UID/GID allocation ledger file.
+type idLedger struct {
+	Users  map[string]int `yaml:"users"`
+	Groups map[string]int `yaml:"groups"`
+}
+
+// idAllocation is the result of reconciling the users or groups of an image with the ledger.
+type idAllocation struct {
+	// Remap is the ID changes (old ID to new ID) that must be applied to the image.
+	Remap map[int]int
+	// Added is the names that were added to the ledger.
+	Added []string
+}
+
+func readIdLedger(ledgerPath string) (*idLedger, error) {
+	ledger := &idLedger{}
+
+	ledgerData, err := os.ReadFile(ledgerPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		ledger.Users = make(map[string]int)
+		ledger.Groups = make(map[string]int)
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ID ledger (%s):\n%w", ledgerPath, err)
+	}
+
+	err = yaml.Unmarshal(ledgerData, ledger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ID ledger (%s):\n%w", ledgerPath, err)
+	}
+
+	if ledger.Users == nil {
+		ledger.Users = make(map[string]int)
+	}
+	if ledger.Groups == nil {
+		ledger.Groups = make(map[string]int)
+	}
+
+	err = validateIdLedgerEntries("users", ledger.Users)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID ledger (%s):\n%w", ledgerPath, err)
+	}
+
+	err = validateIdLedgerEntries("groups", ledger.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID ledger (%s):\n%w", ledgerPath, err)
+	}
+
+	return ledger, nil
+}
+
+func validateIdLedgerEntries(listName string, entries map[string]int) error {
+	names := make(map[int]string)
+	for _, name := range sortedIdNames(entries) {
+		id := entries[name]
+		if id < systemIdMin || id > systemIdMax {
+			return fmt.Errorf("invalid %s entry (%s): ID (%d) is not within [%d, %d]", listName, name, id,
+				systemIdMin, systemIdMax)
+		}
+
+		if otherName, found := names[id]; found {
+			return fmt.Errorf("invalid %s entry (%s): ID (%d) is already assigned to (%s)", listName, name, id,
+				otherName)
+		}
+		names[id] = name
+	}
+
+	return nil
+}
+
+func writeIdLedger(ledgerPath string, ledger *idLedger) error {
+	ledgerData, err := yaml.Marshal(ledger)
+	if err != nil {
+		return fmt.Errorf("failed to serialize ID ledger:\n%w", err)
+	}
+
+	err = os.WriteFile(ledgerPath, append([]byte(idLedgerHeader), ledgerData...), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write ID ledger (%s):\n%w", ledgerPath, err)
+	}
+
+	return nil
+}
+
+func validateIdLedger(baseConfigPath string, config *imagecustomizerapi.OS) error {
+	if config == nil || config.IdLedger == nil {
+		return nil
+	}
+
+	ledgerPath := file.GetAbsPathWithBase(baseConfigPath, config.IdLedger.Path)
+
+	if config.IdLedger.ReadOnly {
+		exists, err := file.PathExists(ledgerPath)
+		if err != nil {
+			return fmt.Errorf("invalid idLedger path (%s):\n%w", config.IdLedger.Path, err)
+		}
+
+		if !exists {
+			return fmt.Errorf("invalid idLedger path (%s):\nfile doesn't exist and readOnly is set",
+				config.IdLedger.Path)
+		}
+	}
+
+	ledger, err := readIdLedger(ledgerPath)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range config.Users {
+		if user.UID == nil {
+			continue
+		}
+
+		ledgerUid, found := ledger.Users[user.Name]
+		if found && ledgerUid != *user.UID {
+			return fmt.Errorf("user (%s) has UID (%d) but the ID ledger assigns it UID (%d)", user.Name, *user.UID,
+				ledgerUid)
+		}
+	}
+
+	return nil
+}
+
+// applyIdLedger gives the system users and groups of the image the IDs that are recorded in the ledger, and records
+// the IDs of new users and groups in the ledger.
+func applyIdLedger(baseConfigPath string, config *imagecustomizerapi.IdLedger,
+	imageChroot safechroot.ChrootInterface,
+) error {
+	if config == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Applying UID/GID ledger")
+
+	ledgerPath := file.GetAbsPathWithBase(baseConfigPath, config.Path)
+	rootDir := imageChroot.RootDir()
+
+	ledger, err := readIdLedger(ledgerPath)
+	if err != nil {
+		return err
+	}
+
+	passwdEntries, err := userutils.ReadPasswdFile(rootDir)
+	if err != nil {
+		return err
+	}
+
+	groupEntries, err := userutils.ReadGroupFile(rootDir)
+	if err != nil {
+		return err
+	}
+
+	userIds := make(map[string]int)
+	for _, entry := range passwdEntries {
+		userIds[entry.Name] = entry.Uid
+	}
+
+	groupIds := make(map[string]int)
+	for _, entry := range groupEntries {
+		groupIds[entry.Name] = entry.GID
+	}
+
+	users, err := allocateIds("user", userIds, ledger.Users)
+	if err != nil {
+		return err
+	}
+
+	groups, err := allocateIds("group", groupIds, ledger.Groups)
+	if err != nil {
+		return err
+	}
+
+	if config.ReadOnly && (len(users.Added) > 0 || len(groups.Added) > 0) {
+		return fmt.Errorf("ID ledger (%s) is read-only but the image has users (%s) and groups (%s) that aren't in it",
+			config.Path, strings.Join(users.Added, ", "), strings.Join(groups.Added, ", "))
+	}
+
+	if len(users.Remap) > 0 || len(groups.Remap) > 0 {
+		err = remapIdFiles(rootDir, users.Remap, groups.Remap)
+		if err != nil {
+			return err
+		}
+
+		err = remapFileOwners(rootDir, users.Remap, groups.Remap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(users.Added) > 0 || len(groups.Added) > 0 {
+		logger.Log.Infof("Adding users (%s) and groups (%s) to ID ledger", strings.Join(users.Added, ", "),
+			strings.Join(groups.Added, ", "))
+
+		err = writeIdLedger(ledgerPath, ledger)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allocateIds reconciles the IDs of an image's users (or groups) with the ledger:
+//
+//   - Names that are in the ledger get the ledger's ID.
+//   - Other names keep their current ID, unless the ledger reserves it for a different name.
+//   - The remaining names get the highest free system ID (the same as useradd does for system users).
+//
+// The new names are added to the ledger.
+func allocateIds(kind string, currentIds map[string]int, ledgerIds map[string]int) (idAllocation, error) {
+	allocation := idAllocation{
+		Remap: make(map[int]int),
+	}
+
+	names := []string(nil)
+	currentNames := make(map[int][]string)
+	for _, name := range sortedIdNames(currentIds) {
+		id := currentIds[name]
+		if id < systemIdMin || id > systemIdMax {
+			continue
+		}
+
+		names = append(names, name)
+		currentNames[id] = append(currentNames[id], name)
+	}
+
+	reserved := make(map[int]bool)
+	for _, id := range ledgerIds {
+		reserved[id] = true
+	}
+
+	finalIds := make(map[string]int)
+	used := make(map[int]bool)
+
+	for _, name := range names {
+		if id, found := ledgerIds[name]; found {
+			finalIds[name] = id
+			used[id] = true
+		}
+	}
+
+	unallocated := []string(nil)
+	for _, name := range names {
+		if _, found := finalIds[name]; found {
+			continue
+		}
+
+		id := currentIds[name]
+		if !reserved[id] && !used[id] {
+			finalIds[name] = id
+			used[id] = true
+		} else {
+			unallocated = append(unallocated, name)
+		}
+
+		allocation.Added = append(allocation.Added, name)
+	}
+
+	for _, name := range unallocated {
+		id := systemIdMax
+		for ; id >= systemIdMin; id-- {
+			if !reserved[id] && !used[id] {
+				break
+			}
+		}
+
+		if id < systemIdMin {
+			return idAllocation{}, fmt.Errorf("no free system %s IDs left for %s (%s)", kind, kind, name)
+		}
+
+		finalIds[name] = id
+		used[id] = true
+	}
+
+	for _, name := range names {
+		ledgerIds[name] = finalIds[name]
+
+		currentId := currentIds[name]
+		if currentId == finalIds[name] {
+			continue
+		}
+
+		// Files are owned by IDs, not names. So, names that share an ID can't be given different IDs.
+		if len(currentNames[currentId]) > 1 {
+			return idAllocation{}, fmt.Errorf("cannot change ID of %s (%s): ID (%d) is shared with (%s)", kind, name,
+				currentId, strings.Join(currentNames[currentId], ", "))
+		}
+
+		logger.Log.Debugf("Changing ID of %s (%s) from (%d) to (%d)", kind, name, currentId, finalIds[name])
+		allocation.Remap[currentId] = finalIds[name]
+	}
+
+	return allocation, nil
+}
+
+// remapIdFiles changes the IDs within the /etc/passwd and /etc/group files.
+func remapIdFiles(rootDir string, userRemap map[int]int, groupRemap map[int]int) error {
+	const (
+		passwdUidField = 2
+		passwdGidField = 3
+		groupGidField  = 2
+	)
+
+	err := remapIdFileFields(filepath.Join(rootDir, userutils.PasswdFile), map[int]map[int]int{
+		passwdUidField: userRemap,
+		passwdGidField: groupRemap,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = remapIdFileFields(filepath.Join(rootDir, userutils.GroupFile), map[int]map[int]int{
+		groupGidField: groupRemap,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func remapIdFileFields(path string, fieldRemaps map[int]map[int]int) error {
+	lines, err := file.ReadLines(path)
+	if err != nil {
+		return fmt.Errorf("failed to read (%s):\n%w", path, err)
+	}
+
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		for fieldIndex, remap := range fieldRemaps {
+			if fieldIndex >= len(fields) {
+				continue
+			}
+
+			id, err := strconv.Atoi(fields[fieldIndex])
+			if err != nil {
+				continue
+			}
+
+			if newId, found := remap[id]; found {
+				fields[fieldIndex] = strconv.Itoa(newId)
+			}
+		}
+		lines[i] = strings.Join(fields, ":")
+	}
+
+	// Overwrite the file in place, to keep its permissions and SELinux label.
+	err = os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write (%s):\n%w", path, err)
+	}
+
+	return nil
+}
+
+// remapFileOwners changes the owner and group of all the files in the image that are owned by a remapped ID.
+func remapFileOwners(rootDir string, userRemap map[int]int, groupRemap map[int]int) error {
+	skipDirs := make(map[string]bool)
+	for _, dir := range idLedgerSkipDirs {
+		skipDirs[filepath.Join(rootDir, dir)] = true
+	}
+
+	err := filepath.WalkDir(rootDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() && skipDirs[path] {
+			return filepath.SkipDir
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		newUid, uidChanged := userRemap[int(stat.Uid)]
+		newGid, gidChanged := groupRemap[int(stat.Gid)]
+		if !uidChanged && !gidChanged {
+			return nil
+		}
+
+		if !uidChanged {
+			newUid = -1
+		}
+		if !gidChanged {
+			newGid = -1
+		}
+
+		return chownKeepingAttributes(path, info, newUid, newGid)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update file owners:\n%w", err)
+	}
+
+	return nil
+}
+
+// chownKeepingAttributes changes a file's owner and group, and then restores the setuid/setgid bits and capabilities
+// that the kernel clears when a file's owner changes.
+func chownKeepingAttributes(path string, info fs.FileInfo, uid int, gid int) error {
+	isSymlink := info.Mode()&fs.ModeSymlink != 0
+
+	capabilities := []byte(nil)
+	if info.Mode().IsRegular() {
+		size, err := unix.Lgetxattr(path, capabilityXattr, nil)
+		if err == nil && size > 0 {
+			capabilities = make([]byte, size)
+			_, err = unix.Lgetxattr(path, capabilityXattr, capabilities)
+			if err != nil {
+				return fmt.Errorf("failed to read capabilities of (%s):\n%w", path, err)
+			}
+		}
+	}
+
+	err := os.Lchown(path, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	if !isSymlink && info.Mode()&(fs.ModeSetuid|fs.ModeSetgid) != 0 {
+		err = os.Chmod(path, info.Mode())
+		if err != nil {
+			return err
+		}
+	}
+
+	if capabilities != nil {
+		err = unix.Lsetxattr(path, capabilityXattr, capabilities, 0)
+		if err != nil {
+			return fmt.Errorf("failed to restore capabilities of (%s):\n%w", path, err)
+		}
+	}
+
+	return nil
+}
+
+func sortedIdNames(ids map[string]int) []string {
+	names := make([]string, 0, len(ids))
+	for name := range ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
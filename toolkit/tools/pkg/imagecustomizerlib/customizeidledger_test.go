@@ -0,0 +1,216 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateIdsKeepsLedgerIds(t *testing.T) {
+	ledgerIds := map[string]int{
+		"sshd":    74,
+		"systemd": 998,
+	}
+	currentIds := map[string]int{
+		"root":    0,
+		"sshd":    997,
+		"systemd": 998,
+		"nobody":  65534,
+	}
+
+	allocation, err := allocateIds("user", currentIds, ledgerIds)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{997: 74}, allocation.Remap)
+	assert.Empty(t, allocation.Added)
+	assert.Equal(t, map[string]int{"sshd": 74, "systemd": 998}, ledgerIds)
+}
+
+func TestAllocateIdsAddsNewNames(t *testing.T) {
+	ledgerIds := map[string]int{
+		"chrony": 999,
+		"sshd":   74,
+	}
+	currentIds := map[string]int{
+		// Keeps its ID, since it isn't reserved.
+		"dbus": 81,
+		// Moves, since 999 is reserved for chrony (even though chrony isn't in the image).
+		"polkitd": 999,
+		"sshd":    74,
+	}
+
+	allocation, err := allocateIds("user", currentIds, ledgerIds)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{999: 998}, allocation.Remap)
+	assert.Equal(t, []string{"dbus", "polkitd"}, allocation.Added)
+	assert.Equal(t, map[string]int{"chrony": 999, "dbus": 81, "polkitd": 998, "sshd": 74}, ledgerIds)
+}
+
+func TestAllocateIdsSwap(t *testing.T) {
+	ledgerIds := map[string]int{
+		"a": 100,
+		"b": 101,
+	}
+	currentIds := map[string]int{
+		"a": 101,
+		"b": 100,
+	}
+
+	allocation, err := allocateIds("group", currentIds, ledgerIds)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{100: 101, 101: 100}, allocation.Remap)
+}
+
+func TestAllocateIdsSharedId(t *testing.T) {
+	ledgerIds := map[string]int{
+		"a": 100,
+	}
+	currentIds := map[string]int{
+		"a": 200,
+		"b": 200,
+	}
+
+	_, err := allocateIds("user", currentIds, ledgerIds)
+	assert.ErrorContains(t, err, "cannot change ID of user (a): ID (200) is shared with (a, b)")
+}
+
+func TestReadIdLedgerDuplicateId(t *testing.T) {
+	ledgerPath := filepath.Join(t.TempDir(), "id-ledger.yaml")
+	err := os.WriteFile(ledgerPath, []byte("users:\n  a: 100\n  b: 100\n"), 0o644)
+	assert.NoError(t, err)
+
+	_, err = readIdLedger(ledgerPath)
+	assert.ErrorContains(t, err, "invalid users entry (b): ID (100) is already assigned to (a)")
+}
+
+func TestReadIdLedgerOutOfRange(t *testing.T) {
+	ledgerPath := filepath.Join(t.TempDir(), "id-ledger.yaml")
+	err := os.WriteFile(ledgerPath, []byte("groups:\n  users: 1000\n"), 0o644)
+	assert.NoError(t, err)
+
+	_, err = readIdLedger(ledgerPath)
+	assert.ErrorContains(t, err, "invalid groups entry (users): ID (1000) is not within [1, 999]")
+}
+
+func TestWriteIdLedgerRoundTrip(t *testing.T) {
+	ledgerPath := filepath.Join(t.TempDir(), "id-ledger.yaml")
+
+	ledger, err := readIdLedger(ledgerPath)
+	assert.NoError(t, err)
+
+	ledger.Users["sshd"] = 74
+	ledger.Groups["sshd"] = 74
+
+	err = writeIdLedger(ledgerPath, ledger)
+	assert.NoError(t, err)
+
+	readLedger, err := readIdLedger(ledgerPath)
+	assert.NoError(t, err)
+	assert.Equal(t, ledger, readLedger)
+}
+
+func TestValidateIdLedgerUidConflict(t *testing.T) {
+	ledgerDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(ledgerDir, "id-ledger.yaml"), []byte("users:\n  app: 500\n"), 0o644)
+	assert.NoError(t, err)
+
+	uid := 501
+	config := &imagecustomizerapi.OS{
+		IdLedger: &imagecustomizerapi.IdLedger{
+			Path: "id-ledger.yaml",
+		},
+		Users: []imagecustomizerapi.User{
+			{Name: "app", UID: &uid},
+		},
+	}
+
+	err = validateIdLedger(ledgerDir, config)
+	assert.ErrorContains(t, err, "user (app) has UID (501) but the ID ledger assigns it UID (500)")
+}
+
+func TestValidateIdLedgerReadOnlyMissing(t *testing.T) {
+	config := &imagecustomizerapi.OS{
+		IdLedger: &imagecustomizerapi.IdLedger{
+			Path:     "id-ledger.yaml",
+			ReadOnly: true,
+		},
+	}
+
+	err := validateIdLedger(t.TempDir(), config)
+	assert.ErrorContains(t, err, "file doesn't exist and readOnly is set")
+}
+
+func TestRemapIdFiles(t *testing.T) {
+	rootDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(rootDir, "etc"), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "etc/passwd"), []byte(
+		"root:x:0:0:root:/root:/bin/bash\n"+
+			"sshd:x:997:996:sshd:/var/empty:/sbin/nologin\n"), 0o644)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "etc/group"), []byte(
+		"root:x:0:\n"+
+			"sshd:x:996:\n"), 0o644)
+	assert.NoError(t, err)
+
+	err = remapIdFiles(rootDir, map[int]int{997: 74}, map[int]int{996: 74})
+	assert.NoError(t, err)
+
+	passwd, err := os.ReadFile(filepath.Join(rootDir, "etc/passwd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "root:x:0:0:root:/root:/bin/bash\nsshd:x:74:74:sshd:/var/empty:/sbin/nologin\n", string(passwd))
+
+	group, err := os.ReadFile(filepath.Join(rootDir, "etc/group"))
+	assert.NoError(t, err)
+	assert.Equal(t, "root:x:0:\nsshd:x:74:\n", string(group))
+}
+
+func TestRemapFileOwners(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Test must be run as root because it changes file owners")
+	}
+
+	rootDir := t.TempDir()
+
+	setuidPath := filepath.Join(rootDir, "usr/bin/tool")
+	err := os.MkdirAll(filepath.Dir(setuidPath), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(setuidPath, nil, 0o755)
+	assert.NoError(t, err)
+
+	err = os.Chown(setuidPath, 997, 996)
+	assert.NoError(t, err)
+
+	err = os.Chmod(setuidPath, 0o755|os.ModeSetuid)
+	assert.NoError(t, err)
+
+	otherPath := filepath.Join(rootDir, "usr/bin/other")
+	err = os.WriteFile(otherPath, nil, 0o644)
+	assert.NoError(t, err)
+
+	err = os.Chown(otherPath, 500, 996)
+	assert.NoError(t, err)
+
+	err = remapFileOwners(rootDir, map[int]int{997: 74}, map[int]int{996: 75})
+	assert.NoError(t, err)
+
+	info, err := os.Stat(setuidPath)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(74), info.Sys().(*syscall.Stat_t).Uid)
+	assert.Equal(t, uint32(75), info.Sys().(*syscall.Stat_t).Gid)
+	assert.Equal(t, 0o755|os.ModeSetuid, info.Mode())
+
+	info, err = os.Stat(otherPath)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(500), info.Sys().(*syscall.Stat_t).Uid)
+	assert.Equal(t, uint32(75), info.Sys().(*syscall.Stat_t).Gid)
+}
@@ -0,0 +1,141 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+)
+
+const (
+	dracutConfigDirInChroot = "/etc/dracut.conf.d"
+)
+
+// rebuildInitrd applies the kernel command-line and dracut changes of an initrd-only rebuild, and then regenerates the
+// initramfs of each kernel and the grub config. Unlike the general OS customization steps, the packages, partitions
+// and filesystems of the image are left as they are, so that boot issues can be debugged in quick iterations.
+func rebuildInitrd(baseConfigPath string, initrdRebuild *imagecustomizerapi.InitrdRebuild,
+	imageChroot *safechroot.Chroot, imageUuid string,
+) error {
+	logger.Log.Infof("Rebuilding initramfs and bootloader config")
+
+	buildTime := time.Now().Format("2006-01-02T15:04:05Z")
+
+	dracutConfigFiles, err := copyDracutConfigFiles(baseConfigPath, initrdRebuild.DracutConfigFiles, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	kernelVersions, err := getImageKernelVersions(imageChroot.RootDir())
+	if err != nil {
+		return err
+	}
+
+	if len(kernelVersions) <= 0 {
+		return fmt.Errorf("no kernels were found in the image")
+	}
+
+	// Regenerate the initramfs of every kernel, since a dracut config change can affect all of them.
+	impact := hotfixBootImpact{
+		KernelVersions: make(map[string]bool),
+	}
+	for _, kernelVersion := range kernelVersions {
+		impact.KernelVersions[kernelVersion] = true
+	}
+
+	_, initrdPaths, err := regenerateAffectedInitrds(impact, nil, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	bootCustomizer, err := NewBootCustomizer(imageChroot)
+	if err != nil {
+		return err
+	}
+
+	err = bootCustomizer.SetKernelCommandLineArgs(initrdRebuild.KernelCommandLine.SetArgs,
+		initrdRebuild.KernelCommandLine.RemoveArgs)
+	if err != nil {
+		return fmt.Errorf("failed to update kernel command-line args:\n%w", err)
+	}
+
+	// Always write the grub config, so that the boot entries of images that use grub2-mkconfig are regenerated.
+	err = bootCustomizer.WriteToFile(imageChroot)
+	if err != nil {
+		return err
+	}
+
+	err = addCustomizerRelease(imageChroot, ToolVersion, buildTime, imageUuid)
+	if err != nil {
+		return err
+	}
+
+	relabelFiles := append([]string(nil), dracutConfigFiles...)
+	relabelFiles = append(relabelFiles, initrdPaths...)
+	relabelFiles = append(relabelFiles, "/etc/image-customizer-release", installutils.GrubCfgFile,
+		installutils.GrubDefFile)
+
+	err = relabelHotfixFiles(relabelFiles, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Initramfs rebuilt for kernels: %v", kernelVersions)
+
+	return nil
+}
+
+// copyDracutConfigFiles copies the dracut config files into the image's dracut config directory, replacing any
+// existing files of the same names.
+//
+// Returns the paths of the files within the image.
+func copyDracutConfigFiles(baseConfigPath string, configFiles []string, imageChroot *safechroot.Chroot,
+) ([]string, error) {
+	pathsInChroot := []string(nil)
+	for _, configFile := range configFiles {
+		sourcePath := file.GetAbsPathWithBase(baseConfigPath, configFile)
+		pathInChroot := filepath.Join(dracutConfigDirInChroot, filepath.Base(configFile))
+		destPath := filepath.Join(imageChroot.RootDir(), pathInChroot)
+
+		logger.Log.Infof("Adding dracut config file (%s)", pathInChroot)
+
+		err := file.Copy(sourcePath, destPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy dracut config file (%s):\n%w", configFile, err)
+		}
+
+		err = os.Chmod(destPath, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set permissions of dracut config file (%s):\n%w", pathInChroot, err)
+		}
+
+		pathsInChroot = append(pathsInChroot, pathInChroot)
+	}
+
+	return pathsInChroot, nil
+}
+
+// validateInitrdRebuild checks that the files referenced by an initrd rebuild exist.
+func validateInitrdRebuild(baseConfigPath string, initrdRebuild *imagecustomizerapi.InitrdRebuild) error {
+	for _, configFile := range initrdRebuild.DracutConfigFiles {
+		isFile, err := file.IsFile(file.GetAbsPathWithBase(baseConfigPath, configFile))
+		if err != nil {
+			return fmt.Errorf("invalid dracut config file (%s):\n%w", configFile, err)
+		}
+
+		if !isFile {
+			return fmt.Errorf("invalid dracut config file (%s): not a file", configFile)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	zoneinfoDir        = "/usr/share/zoneinfo"
+	localtimeFilePath  = "/etc/localtime"
+	localeConfFilePath = "/etc/locale.conf"
+	vconsoleFilePath   = "/etc/vconsole.conf"
+	keymapsDir         = "/usr/lib/kbd/keymaps"
+)
+
+// updateTimezone points the /etc/localtime symlink at the timezone's zoneinfo file.
+func updateTimezone(timezone string, imageChroot safechroot.ChrootInterface) error {
+	if timezone == "" {
+		return nil
+	}
+
+	logger.Log.Infof("Setting timezone (%s)", timezone)
+
+	zoneinfoFilePath := path.Join(zoneinfoDir, timezone)
+	stat, err := os.Stat(filepath.Join(imageChroot.RootDir(), zoneinfoFilePath))
+	if err != nil || !stat.Mode().IsRegular() {
+		return fmt.Errorf("timezone (%s) not found in image (%s):\nis the tzdata package installed?", timezone,
+			zoneinfoFilePath)
+	}
+
+	localtimeFullPath := filepath.Join(imageChroot.RootDir(), localtimeFilePath)
+	err = os.Remove(localtimeFullPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove existing localtime file:\n%w", err)
+	}
+
+	// Use a relative symlink, like systemd-firstboot and timedatectl do.
+	err = os.Symlink(path.Join("..", zoneinfoFilePath), localtimeFullPath)
+	if err != nil {
+		return fmt.Errorf("failed to create localtime symlink:\n%w", err)
+	}
+
+	return nil
+}
+
+// updateLocale sets the LANG variable in /etc/locale.conf.
+func updateLocale(locale string, imageChroot safechroot.ChrootInterface) error {
+	if locale == "" {
+		return nil
+	}
+
+	logger.Log.Infof("Setting locale (%s)", locale)
+
+	var availableLocales string
+	err := imageChroot.UnsafeRun(func() error {
+		var err error
+		availableLocales, _, err = shell.Execute("locale", "-a")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list locales in image:\n%w", err)
+	}
+
+	if !isLocaleAvailable(locale, strings.Split(availableLocales, "\n")) {
+		return fmt.Errorf("locale (%s) not found in image:\nis the locale's glibc langpack installed?", locale)
+	}
+
+	err = updateShellVarFile(filepath.Join(imageChroot.RootDir(), localeConfFilePath), "LANG", locale)
+	if err != nil {
+		return fmt.Errorf("failed to write locale file:\n%w", err)
+	}
+
+	return nil
+}
+
+// updateKeymap sets the KEYMAP variable in /etc/vconsole.conf.
+func updateKeymap(keymap string, imageChroot safechroot.ChrootInterface) error {
+	if keymap == "" {
+		return nil
+	}
+
+	logger.Log.Infof("Setting keymap (%s)", keymap)
+
+	found, err := isKeymapAvailable(keymap, filepath.Join(imageChroot.RootDir(), keymapsDir))
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("keymap (%s) not found in image (%s):\nis the kbd package installed?", keymap, keymapsDir)
+	}
+
+	err = updateShellVarFile(filepath.Join(imageChroot.RootDir(), vconsoleFilePath), "KEYMAP", keymap)
+	if err != nil {
+		return fmt.Errorf("failed to write vconsole file:\n%w", err)
+	}
+
+	return nil
+}
+
+// isLocaleAvailable checks if a locale is in the list of locales printed by `locale -a`.
+// `locale -a` prints the normalized names of the locales (e.g. "en_US.utf8" for "en_US.UTF-8").
+func isLocaleAvailable(locale string, availableLocales []string) bool {
+	normalizedLocale := normalizeLocaleName(locale)
+	for _, availableLocale := range availableLocales {
+		availableLocale = strings.TrimSpace(availableLocale)
+		if availableLocale != "" && normalizeLocaleName(availableLocale) == normalizedLocale {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeLocaleName normalizes the codeset of a locale name, in the same way as glibc.
+// For example, "en_US.UTF-8" is normalized to "en_US.utf8".
+func normalizeLocaleName(locale string) string {
+	name, modifier, hasModifier := strings.Cut(locale, "@")
+	language, codeset, hasCodeset := strings.Cut(name, ".")
+
+	normalized := language
+	if hasCodeset {
+		codeset = strings.ToLower(codeset)
+		codeset = strings.NewReplacer("-", "", "_", "").Replace(codeset)
+		normalized += "." + codeset
+	}
+	if hasModifier {
+		normalized += "@" + modifier
+	}
+
+	return normalized
+}
+
+func isKeymapAvailable(keymap string, keymapsFullDir string) (bool, error) {
+	found := false
+	err := filepath.WalkDir(keymapsFullDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := d.Name()
+		if !d.IsDir() && (name == keymap+".map" || name == keymap+".map.gz") {
+			found = true
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to search for keymap (%s):\n%w", keymap, err)
+	}
+
+	return found, nil
+}
+
+// updateShellVarFile sets a variable in a file of shell-style variable assignments (e.g. /etc/locale.conf), while
+// keeping the file's other variables.
+func updateShellVarFile(filePath string, name string, value string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	newContent := setShellVar(string(content), name, value)
+	return file.Write(newContent, filePath)
+}
+
+func setShellVar(content string, name string, value string) string {
+	assignment := name + "=" + value
+
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if content == "" {
+		lines = nil
+	}
+
+	found := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), name+"=") {
+			lines[i] = assignment
+			found = true
+		}
+	}
+
+	if !found {
+		lines = append(lines, assignment)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
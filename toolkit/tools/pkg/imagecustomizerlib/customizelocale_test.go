@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetShellVar(t *testing.T) {
+	assert.Equal(t, "LANG=en_US.UTF-8\n", setShellVar("", "LANG", "en_US.UTF-8"))
+	assert.Equal(t, "LANG=de_DE.UTF-8\nLC_TIME=C\n",
+		setShellVar("LANG=en_US.UTF-8\nLC_TIME=C\n", "LANG", "de_DE.UTF-8"))
+	assert.Equal(t, "FONT=eurlatgr\nKEYMAP=de\n", setShellVar("FONT=eurlatgr", "KEYMAP", "de"))
+}
+
+func TestIsLocaleAvailable(t *testing.T) {
+	availableLocales := []string{"C", "C.utf8", "POSIX", "en_US.utf8", "de_DE@euro", ""}
+
+	assert.True(t, isLocaleAvailable("en_US.UTF-8", availableLocales))
+	assert.True(t, isLocaleAvailable("en_US.utf8", availableLocales))
+	assert.True(t, isLocaleAvailable("C.UTF-8", availableLocales))
+	assert.True(t, isLocaleAvailable("de_DE@euro", availableLocales))
+	assert.False(t, isLocaleAvailable("de_DE.UTF-8", availableLocales))
+	assert.False(t, isLocaleAvailable("en_US", availableLocales))
+}
+
+func TestIsKeymapAvailable(t *testing.T) {
+	keymapsFullDir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(keymapsFullDir, "xkb"), 0o755)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(keymapsFullDir, "xkb", "de.map.gz"), []byte{}, 0o644)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	found, err := isKeymapAvailable("de", keymapsFullDir)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = isKeymapAvailable("fr", keymapsFullDir)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// The kbd package isn't installed.
+	found, err = isKeymapAvailable("de", filepath.Join(keymapsFullDir, "missing"))
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,322 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
+)
+
+const (
+	matrixBuildDirName       = "matrix"
+	matrixInputCacheDirName  = "matrix-input-cache"
+	matrixManifestFileSuffix = ".matrix.json"
+
+	// The separator between the output file names and build IDs and the cell names.
+	matrixCellNameSeparator = "-"
+)
+
+// MatrixBuild is the build of a single cell of a config matrix.
+type MatrixBuild struct {
+	Cell imagecustomizerapi.MatrixCell
+	// BuildDir is the cell's own build directory.
+	BuildDir string
+	// ImageFile is the cell's base image.
+	ImageFile             string
+	OutputImageFile       string
+	OutputPXEArtifactsDir string
+	// BuildId is the ID of the cell's build within the build state store. Empty if the build state isn't recorded.
+	BuildId string
+	// LogFile is the file that the cell's build writes its log to.
+	LogFile string
+	// SummaryFile is the file that the cell's build writes its summary to. Empty if the summary isn't written.
+	SummaryFile string
+}
+
+// MatrixBuildResult is the outcome of a MatrixBuild.
+type MatrixBuildResult struct {
+	Build    MatrixBuild
+	Duration time.Duration
+	Err      error
+}
+
+type matrixManifest struct {
+	ConfigFile string               `json:"configFile"`
+	Cells      []matrixManifestCell `json:"cells"`
+}
+
+type matrixManifestCell struct {
+	Name            string            `json:"name"`
+	Values          map[string]string `json:"values"`
+	ImageFile       string            `json:"imageFile"`
+	Succeeded       bool              `json:"succeeded"`
+	Error           string            `json:"error,omitempty"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	LogFile         string            `json:"logFile"`
+	Artifacts       []buildArtifact   `json:"artifacts"`
+}
+
+// buildArtifact is a file that a build produced.
+type buildArtifact struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// GetMatrixInputImageCacheDir returns the directory that a matrix build shares its converted input images in.
+func GetMatrixInputImageCacheDir(buildDir string) string {
+	return filepath.Join(buildDir, matrixInputCacheDirName)
+}
+
+// PlanMatrixBuilds expands the config file's matrix into a build for each cell. If cellNames isn't empty, then only
+// those cells are built.
+//
+// Each cell is built within its own subdirectory of the build directory. And the cell's name is inserted before the
+// extension of the output image file (e.g. "image.vhdx" -> "image-full-arm64.vhdx"), so that each cell writes its own
+// output files.
+func PlanMatrixBuilds(buildDir string, configFile string, imageFile string, outputImageFile string,
+	outputPXEArtifactsDir string, buildId string, cellNames []string, options CustomizeImageOptions,
+) ([]MatrixBuild, error) {
+	matrix, err := imagecustomizerapi.LoadConfigFileMatrix(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if matrix == nil {
+		return nil, fmt.Errorf("config file (%s) doesn't have a matrix", configFile)
+	}
+
+	cells := matrix.Cells()
+	if len(cellNames) > 0 {
+		cells = nil
+		for _, cellName := range cellNames {
+			cell, err := matrix.Cell(cellName)
+			if err != nil {
+				return nil, err
+			}
+
+			if !slices.ContainsFunc(cells, func(c imagecustomizerapi.MatrixCell) bool { return c.Name == cellName }) {
+				cells = append(cells, cell)
+			}
+		}
+	}
+
+	builds := []MatrixBuild(nil)
+	artifactsDirCells := make(map[string]string)
+	for _, cell := range cells {
+		cellOptions := options
+		cellOptions.MatrixCell = cell.Name
+
+		// Check the cell's composed config up front, so that a bad cell doesn't fail the matrix after the other cells
+		// have been built.
+		config, baseConfigPath, cellImageFile, err := loadConfigFile(configFile, imageFile, cellOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.Scripts.OutputArtifactsDir != "" {
+			artifactsDir := file.GetAbsPathWithBase(baseConfigPath, config.Scripts.OutputArtifactsDir)
+			if otherCell, found := artifactsDirCells[artifactsDir]; found {
+				return nil, fmt.Errorf("matrix cells (%s) and (%s) have the same scripts output artifacts directory "+
+					"(%s):\noverride 'scripts.outputArtifactsDir' for each cell", otherCell, cell.Name, artifactsDir)
+			}
+			artifactsDirCells[artifactsDir] = cell.Name
+		}
+
+		build := MatrixBuild{
+			Cell:            cell,
+			BuildDir:        filepath.Join(buildDir, matrixBuildDirName, cell.Name),
+			ImageFile:       cellImageFile,
+			OutputImageFile: insertMatrixCellName(outputImageFile, cell.Name),
+			LogFile:         filepath.Join(buildDir, matrixBuildDirName, cell.Name+".log"),
+		}
+
+		if outputPXEArtifactsDir != "" {
+			build.OutputPXEArtifactsDir = filepath.Clean(outputPXEArtifactsDir) + matrixCellNameSeparator + cell.Name
+		}
+
+		if buildId != "" {
+			build.BuildId = buildId + matrixCellNameSeparator + cell.Name
+		}
+
+		if options.SummaryFile != "" {
+			build.SummaryFile = insertMatrixCellName(options.SummaryFile, cell.Name)
+		}
+
+		builds = append(builds, build)
+	}
+
+	return builds, nil
+}
+
+// insertMatrixCellName inserts the cell's name before the extension of the file name.
+func insertMatrixCellName(path string, cellName string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + matrixCellNameSeparator + cellName + ext
+}
+
+// CacheMatrixInputImages converts each of the builds' distinct input images into the cache directory, so that the
+// builds that share a base image don't each convert it.
+func CacheMatrixInputImages(cacheDir string, builds []MatrixBuild) error {
+	cached := make(map[string]bool)
+	for _, build := range builds {
+		// Base images that are fetched by plugins are fetched by each build.
+		if cached[build.ImageFile] || isImageUrl(build.ImageFile) {
+			continue
+		}
+		cached[build.ImageFile] = true
+
+		_, err := CacheInputImage(cacheDir, build.ImageFile)
+		if err != nil {
+			return fmt.Errorf("failed to cache input image of matrix cell (%s):\n%w", build.Cell.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// RunMatrixBuilds calls runBuild for each of the builds, with up to parallelism builds running at the same time.
+// The results are in the same order as the builds.
+func RunMatrixBuilds(builds []MatrixBuild, parallelism int, runBuild func(build MatrixBuild) error,
+) []MatrixBuildResult {
+	results := make([]MatrixBuildResult, len(builds))
+	runInParallel(len(builds), parallelism, func(i int) {
+		build := builds[i]
+
+		logger.Log.Infof("Building matrix cell (%s)", build.Cell.Name)
+
+		startTime := time.Now()
+		err := runBuild(build)
+		results[i] = MatrixBuildResult{
+			Build:    build,
+			Duration: time.Since(startTime),
+			Err:      err,
+		}
+
+		if err != nil {
+			logger.Log.Errorf("Matrix cell (%s) failed (log: %s):\n%v", build.Cell.Name, build.LogFile, err)
+		} else {
+			logger.Log.Infof("Matrix cell (%s) succeeded", build.Cell.Name)
+		}
+	})
+
+	return results
+}
+
+// runInParallel calls run for each index in [0, count), with up to parallelism calls running at the same time.
+func runInParallel(count int, parallelism int, run func(i int)) {
+	parallelism = max(parallelism, 1)
+
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			run(i)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// WriteMatrixManifest writes a manifest of the results of the matrix builds, next to the output image file.
+// Returns the manifest's path and an error that lists the cells that failed (if any).
+func WriteMatrixManifest(outputImageFile string, configFile string, results []MatrixBuildResult) (string, error) {
+	manifest := matrixManifest{
+		ConfigFile: configFile,
+		Cells:      []matrixManifestCell{},
+	}
+
+	failedCells := []string(nil)
+	for _, result := range results {
+		cell := matrixManifestCell{
+			Name:            result.Build.Cell.Name,
+			Values:          result.Build.Cell.Values,
+			ImageFile:       result.Build.ImageFile,
+			Succeeded:       result.Err == nil,
+			DurationSeconds: result.Duration.Seconds(),
+			LogFile:         result.Build.LogFile,
+			Artifacts:       []buildArtifact{},
+		}
+
+		if result.Err != nil {
+			cell.Error = result.Err.Error()
+			failedCells = append(failedCells, cell.Name)
+		} else {
+			artifacts, err := getBuildArtifacts(result.Build.OutputImageFile)
+			if err != nil {
+				return "", err
+			}
+			cell.Artifacts = artifacts
+		}
+
+		manifest.Cells = append(manifest.Cells, cell)
+	}
+
+	manifestFile := strings.TrimSuffix(outputImageFile, filepath.Ext(outputImageFile)) + matrixManifestFileSuffix
+	err := jsonutils.WriteJSONFile(manifestFile, manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to write matrix manifest (%s):\n%w", manifestFile, err)
+	}
+
+	if len(failedCells) > 0 {
+		return manifestFile, fmt.Errorf("matrix cells failed: %s", strings.Join(failedCells, ", "))
+	}
+
+	return manifestFile, nil
+}
+
+// getBuildArtifacts returns the output image file and the files that are written next to it (e.g. the change
+// manifest) that a build produced.
+func getBuildArtifacts(outputImageFile string) ([]buildArtifact, error) {
+	outputBase := strings.TrimSuffix(outputImageFile, filepath.Ext(outputImageFile))
+
+	paths := []string{outputImageFile, artifactmetadata.SidecarPath(outputImageFile)}
+	for _, suffix := range []string{changeManifestFileSuffix, selinuxReportFileSuffix, hotfixReportFileSuffix,
+		cloudInitSeedIsoFileSuffix, configProvenanceFileSuffix, mokCertificateFileSuffix, mokInstructionsFileSuffix,
+		validationReportFileSuffix} {
+		paths = append(paths, outputBase+suffix)
+	}
+
+	artifacts := []buildArtifact{}
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if os.IsNotExist(err) || (err == nil && !stat.Mode().IsRegular()) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat build artifact (%s):\n%w", path, err)
+		}
+
+		sha256, err := file.GenerateSHA256(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash build artifact (%s):\n%w", path, err)
+		}
+
+		artifacts = append(artifacts, buildArtifact{
+			Path:   path,
+			Size:   stat.Size(),
+			Sha256: sha256,
+		})
+	}
+
+	return artifacts, nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMatrixConfigFile = `
+os:
+  hostname: base
+
+matrix:
+  axes:
+  - name: variant
+    values:
+    - name: minimal
+    - name: full
+  - name: arch
+    values:
+    - name: x86_64
+    - name: arm64
+      imageFile: base-arm64.vhdx
+  exclude:
+  - variant: minimal
+    arch: arm64
+`
+
+func TestInsertMatrixCellName(t *testing.T) {
+	assert.Equal(t, "out/image-full-arm64.vhdx", insertMatrixCellName("out/image.vhdx", "full-arm64"))
+	assert.Equal(t, "out/image-full", insertMatrixCellName("out/image", "full"))
+}
+
+func TestPlanMatrixBuilds(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(configFile, []byte(testMatrixConfigFile), 0o644)
+	require.NoError(t, err)
+
+	builds, err := PlanMatrixBuilds("/build", configFile, "/images/base.vhdx", "/out/image.vhdx", "/out/pxe",
+		"nightly", nil, CustomizeImageOptions{SummaryFile: "/out/summary.json"})
+	if !assert.NoError(t, err) || !assert.Len(t, builds, 3) {
+		return
+	}
+
+	assert.Equal(t, "minimal-x86_64", builds[0].Cell.Name)
+	assert.Equal(t, "/build/matrix/minimal-x86_64", builds[0].BuildDir)
+	assert.Equal(t, "/images/base.vhdx", builds[0].ImageFile)
+	assert.Equal(t, "/out/image-minimal-x86_64.vhdx", builds[0].OutputImageFile)
+	assert.Equal(t, "/out/pxe-minimal-x86_64", builds[0].OutputPXEArtifactsDir)
+	assert.Equal(t, "nightly-minimal-x86_64", builds[0].BuildId)
+	assert.Equal(t, "/build/matrix/minimal-x86_64.log", builds[0].LogFile)
+	assert.Equal(t, "/out/summary-minimal-x86_64.json", builds[0].SummaryFile)
+
+	assert.Equal(t, "full-arm64", builds[2].Cell.Name)
+	assert.Equal(t, filepath.Join(dir, "base-arm64.vhdx"), builds[2].ImageFile)
+
+	builds, err = PlanMatrixBuilds("/build", configFile, "/images/base.vhdx", "/out/image.vhdx", "", "",
+		[]string{"full-arm64", "full-arm64"}, CustomizeImageOptions{})
+	if assert.NoError(t, err) && assert.Len(t, builds, 1) {
+		assert.Equal(t, "full-arm64", builds[0].Cell.Name)
+		assert.Empty(t, builds[0].OutputPXEArtifactsDir)
+		assert.Empty(t, builds[0].BuildId)
+		assert.Empty(t, builds[0].SummaryFile)
+	}
+
+	_, err = PlanMatrixBuilds("/build", configFile, "/images/base.vhdx", "/out/image.vhdx", "", "",
+		[]string{"minimal-arm64"}, CustomizeImageOptions{})
+	assert.ErrorContains(t, err, "matrix cell (minimal-arm64) not found")
+}
+
+func TestPlanMatrixBuildsSharedArtifactsDir(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(configFile, []byte(testMatrixConfigFile+"\nscripts:\n  outputArtifactsDir: artifacts\n"),
+		0o644)
+	require.NoError(t, err)
+
+	_, err = PlanMatrixBuilds("/build", configFile, "/images/base.vhdx", "/out/image.vhdx", "", "", nil,
+		CustomizeImageOptions{})
+	assert.ErrorContains(t, err, "matrix cells (minimal-x86_64) and (full-x86_64) have the same scripts output "+
+		"artifacts directory")
+}
+
+func TestRunMatrixBuilds(t *testing.T) {
+	builds := []MatrixBuild(nil)
+	for i := 0; i < 6; i++ {
+		builds = append(builds, MatrixBuild{Cell: imagecustomizerapi.MatrixCell{Name: fmt.Sprintf("cell%d", i)}})
+	}
+
+	var running, maxRunning atomic.Int32
+	results := RunMatrixBuilds(builds, 2, func(build MatrixBuild) error {
+		current := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			previous := maxRunning.Load()
+			if current <= previous || maxRunning.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		if build.Cell.Name == "cell3" {
+			return fmt.Errorf("build failed")
+		}
+		return nil
+	})
+
+	assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+	if assert.Len(t, results, 6) {
+		for i, result := range results {
+			assert.Equal(t, builds[i].Cell.Name, result.Build.Cell.Name)
+			if i == 3 {
+				assert.EqualError(t, result.Err, "build failed")
+			} else {
+				assert.NoError(t, result.Err)
+			}
+		}
+	}
+}
+
+func TestWriteMatrixManifest(t *testing.T) {
+	outputDir := t.TempDir()
+
+	build := MatrixBuild{
+		Cell: imagecustomizerapi.MatrixCell{
+			Name:   "full-arm64",
+			Values: map[string]string{"variant": "full", "arch": "arm64"},
+		},
+		ImageFile:       "/images/base-arm64.vhdx",
+		OutputImageFile: filepath.Join(outputDir, "image-full-arm64.vhdx"),
+		LogFile:         "/build/matrix/full-arm64.log",
+	}
+
+	err := os.WriteFile(build.OutputImageFile, []byte("image"), 0o644)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(outputDir, "image-full-arm64.changes.json"), []byte("{}"), 0o644)
+	require.NoError(t, err)
+
+	failedBuild := MatrixBuild{
+		Cell:            imagecustomizerapi.MatrixCell{Name: "minimal-arm64"},
+		OutputImageFile: filepath.Join(outputDir, "image-minimal-arm64.vhdx"),
+	}
+
+	results := []MatrixBuildResult{
+		{Build: build, Duration: 2 * time.Second},
+		{Build: failedBuild, Err: fmt.Errorf("exit status 1")},
+	}
+
+	manifestFile, err := WriteMatrixManifest(filepath.Join(outputDir, "image.vhdx"), "config.yaml", results)
+	assert.EqualError(t, err, "matrix cells failed: minimal-arm64")
+	assert.Equal(t, filepath.Join(outputDir, "image.matrix.json"), manifestFile)
+
+	manifestJson, err := os.ReadFile(manifestFile)
+	require.NoError(t, err)
+
+	var manifest matrixManifest
+	err = json.Unmarshal(manifestJson, &manifest)
+	require.NoError(t, err)
+
+	assert.Equal(t, "config.yaml", manifest.ConfigFile)
+	if assert.Len(t, manifest.Cells, 2) {
+		cell := manifest.Cells[0]
+		assert.True(t, cell.Succeeded)
+		assert.Equal(t, float64(2), cell.DurationSeconds)
+		assert.Equal(t, map[string]string{"variant": "full", "arch": "arm64"}, cell.Values)
+		assert.Equal(t, []buildArtifact{
+			{
+				Path:   build.OutputImageFile,
+				Size:   5,
+				Sha256: "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d",
+			},
+			{
+				Path:   filepath.Join(outputDir, "image-full-arm64.changes.json"),
+				Size:   2,
+				Sha256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			},
+		}, cell.Artifacts)
+
+		assert.False(t, manifest.Cells[1].Succeeded)
+		assert.Equal(t, "exit status 1", manifest.Cells[1].Error)
+		assert.Empty(t, manifest.Cells[1].Artifacts)
+	}
+}
+
+func TestGetInputImageCacheFile(t *testing.T) {
+	imageFile := filepath.Join(t.TempDir(), "base.vhdx")
+	err := os.WriteFile(imageFile, []byte("image"), 0o644)
+	require.NoError(t, err)
+
+	cacheFile, err := getInputImageCacheFile("/cache", imageFile)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^/cache/[0-9a-f]{32}\.raw$`, cacheFile)
+
+	// A replaced image doesn't match the old cached conversion.
+	err = os.WriteFile(imageFile, []byte("new image"), 0o644)
+	require.NoError(t, err)
+
+	newCacheFile, err := getInputImageCacheFile("/cache", imageFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, cacheFile, newCacheFile)
+
+	// Raw images don't need to be converted.
+	rawImageFile, err := CacheInputImage("/cache", "/images/base.raw")
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/base.raw", rawImageFile)
+}
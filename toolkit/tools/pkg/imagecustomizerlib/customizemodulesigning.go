@@ -0,0 +1,718 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/randomization"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+)
+
+const (
+	// The directory, under the build directory, that holds the signing key and the modules while they are signed.
+	moduleSigningDirName = "modulesigning"
+	// The directory, under the build directory, that holds the enrollment files that are written next to the output
+	// image.
+	moduleSigningArtifactsDirName = "modulesigning-artifacts"
+
+	// The trailer that the kernel looks for at the end of a signed module.
+	moduleSignatureMagic = "~Module signature appended~\n"
+	// PKEY_ID_PKCS7 in the kernel's struct module_signature.
+	moduleSignatureIdTypePkcs7 = 2
+
+	ephemeralModuleSigningKeyBits     = 4096
+	ephemeralModuleSigningKeyValidity = 100 * 365 * 24 * time.Hour
+	ephemeralModuleSigningKeySubject  = "Azure Linux Image Customizer ephemeral module signing key"
+
+	mokPasswordLength = 16
+
+	kernelModulesDir        = "/lib/modules"
+	mokEspDir               = "/boot/efi/EFI/mok"
+	mokCertificateFileName  = "module-signing.der"
+	mokInstructionsFileName = "ENROLL.txt"
+	mokutilPath             = "/usr/bin/mokutil"
+	mokEnrollPasswordFile   = "/etc/mok-enroll/password.hash"
+	mokEnrollServiceName    = "mok-enroll.service"
+	mokEnrollServiceDirPath = "/usr/lib/systemd/system"
+	// The directory that 'systemctl enable' links the service into, as per its WantedBy.
+	mokEnrollServiceWantsDirPath = "/etc/systemd/system/multi-user.target.wants"
+	mokCertificateFileSuffix     = ".mok.der"
+	mokInstructionsFileSuffix    = ".mok.txt"
+)
+
+// kernelModuleCompression is the compression of a kernel module file.
+type kernelModuleCompression struct {
+	extension string
+	// The commands that decompress and compress the file in place, replacing the original file.
+	decompressCommand []string
+	compressCommand   []string
+}
+
+var kernelModuleCompressions = []kernelModuleCompression{
+	{
+		extension:         ".ko",
+		decompressCommand: nil,
+		compressCommand:   nil,
+	},
+	{
+		extension:         ".ko.xz",
+		decompressCommand: []string{"xz", "--decompress", "--force"},
+		// The kernel's xz decompressor only supports CRC32 checks.
+		compressCommand: []string{"xz", "--check=crc32", "--lzma2=dict=1MiB", "--force"},
+	},
+	{
+		extension:         ".ko.zst",
+		decompressCommand: []string{"zstd", "--decompress", "--quiet", "--force", "--rm"},
+		compressCommand:   []string{"zstd", "--quiet", "--force", "--rm"},
+	},
+}
+
+// getKernelModuleCompression returns the compression of a kernel module file, or nil if the file isn't a kernel
+// module.
+func getKernelModuleCompression(path string) *kernelModuleCompression {
+	for i := range kernelModuleCompressions {
+		if strings.HasSuffix(path, kernelModuleCompressions[i].extension) {
+			return &kernelModuleCompressions[i]
+		}
+	}
+	return nil
+}
+
+// listKernelModules returns the kernel module files of the image along with their modification times. The paths are
+// relative to the image's root directory.
+func listKernelModules(imageRootDir string) (map[string]time.Time, error) {
+	modules := make(map[string]time.Time)
+
+	modulesDir := filepath.Join(imageRootDir, kernelModulesDir)
+	err := filepath.WalkDir(modulesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == modulesDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !d.Type().IsRegular() || getKernelModuleCompression(path) == nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(imageRootDir, path)
+		if err != nil {
+			return err
+		}
+
+		modules[relPath] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list kernel modules:\n%w", err)
+	}
+
+	return modules, nil
+}
+
+// signKernelModulesAndUpdateInitrds signs the kernel modules (see signKernelModules) and then regenerates the
+// initramfs of each kernel that had modules signed. Since this runs after the image's files were relabeled, the
+// SELinux labels of the changed files are reset.
+func signKernelModulesAndUpdateInitrds(buildDir string, baseConfigPath string,
+	moduleSigning *imagecustomizerapi.ModuleSigning, baseModules map[string]time.Time, imageChroot *safechroot.Chroot,
+) error {
+	signedModules, err := signKernelModules(buildDir, baseConfigPath, moduleSigning, baseModules, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	if len(signedModules) <= 0 {
+		return nil
+	}
+
+	_, initrdPaths, err := regenerateAffectedInitrds(getHotfixBootImpact(signedModules), nil, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	relabelFiles := append([]string(nil), signedModules...)
+	relabelFiles = append(relabelFiles, initrdPaths...)
+	if moduleSigning.Enrollment != nil && moduleSigning.Enrollment.Automatic {
+		relabelFiles = append(relabelFiles, filepath.Dir(mokEnrollPasswordFile), mokEnrollPasswordFile,
+			filepath.Join(mokEnrollServiceDirPath, mokEnrollServiceName),
+			filepath.Join(mokEnrollServiceWantsDirPath, mokEnrollServiceName))
+	}
+
+	err = relabelHotfixFiles(relabelFiles, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// signKernelModules signs the kernel modules that were added or replaced since the baseModules list was taken and
+// that aren't already signed (e.g. the out-of-tree modules built by DKMS). The signing certificate is then placed in
+// the ESP, along with instructions for enrolling it as a Machine Owner Key (MOK).
+//
+// Returns the paths (within the image) of the modules that were signed.
+func signKernelModules(buildDir string, baseConfigPath string, moduleSigning *imagecustomizerapi.ModuleSigning,
+	baseModules map[string]time.Time, imageChroot *safechroot.Chroot,
+) ([]string, error) {
+	artifactsDir := filepath.Join(buildDir, moduleSigningArtifactsDirName)
+
+	// Don't publish the enrollment files of a previous build.
+	err := os.RemoveAll(artifactsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove old module signing artifacts directory (%s):\n%w", artifactsDir, err)
+	}
+
+	if moduleSigning == nil {
+		return nil, nil
+	}
+
+	logger.Log.Infof("Signing out-of-tree kernel modules")
+
+	modules, err := listKernelModules(imageChroot.RootDir())
+	if err != nil {
+		return nil, err
+	}
+
+	newModules := []string(nil)
+	for module, modTime := range modules {
+		baseModTime, found := baseModules[module]
+		if !found || !baseModTime.Equal(modTime) {
+			newModules = append(newModules, module)
+		}
+	}
+	sort.Strings(newModules)
+
+	if len(newModules) <= 0 {
+		logger.Log.Infof("No kernel modules were added to the image")
+		return nil, nil
+	}
+
+	signingDir := filepath.Join(buildDir, moduleSigningDirName)
+	err = os.MkdirAll(signingDir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create module signing directory (%s):\n%w", signingDir, err)
+	}
+	defer os.RemoveAll(signingDir)
+
+	keyPath, certificatePath, certificateDer, err := prepareModuleSigningKey(baseConfigPath, moduleSigning.Key,
+		signingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	signedModules := []string(nil)
+	for _, module := range newModules {
+		signed, err := signKernelModule(filepath.Join(imageChroot.RootDir(), module), keyPath, certificatePath,
+			signingDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign kernel module (%s):\n%w", module, err)
+		}
+
+		if signed {
+			logger.Log.Debugf("Signed kernel module (%s)", module)
+			signedModules = append(signedModules, "/"+module)
+		}
+	}
+
+	if len(signedModules) <= 0 {
+		logger.Log.Infof("No unsigned kernel modules were added to the image")
+		return nil, nil
+	}
+
+	logger.Log.Infof("Signed %d kernel modules", len(signedModules))
+
+	err = addMokEnrollmentFiles(moduleSigning.Enrollment, certificateDer, artifactsDir, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	return signedModules, nil
+}
+
+// prepareModuleSigningKey returns the paths of the PEM private key and certificate to sign the modules with, along
+// with the DER form of the certificate. If a key isn't provided, then an ephemeral key is generated in signingDir.
+func prepareModuleSigningKey(baseConfigPath string, key *imagecustomizerapi.ModuleSigningKey, signingDir string,
+) (string, string, []byte, error) {
+	if key == nil {
+		return generateModuleSigningKey(signingDir)
+	}
+
+	keyPath := file.GetAbsPathWithBase(baseConfigPath, key.PrivateKeyPath)
+	certificatePath := file.GetAbsPathWithBase(baseConfigPath, key.CertificatePath)
+
+	certificateDer, isPem, err := readModuleSigningCertificate(certificatePath)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if !isPem {
+		// openssl is given the certificate in PEM form.
+		certificatePath = filepath.Join(signingDir, "signing.crt")
+		err = writePemFile(certificatePath, "CERTIFICATE", certificateDer)
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	return keyPath, certificatePath, certificateDer, nil
+}
+
+// readModuleSigningCertificate reads a PEM or DER certificate and returns its DER form.
+func readModuleSigningCertificate(path string) ([]byte, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read module signing certificate (%s):\n%w", path, err)
+	}
+
+	certificateDer := data
+	isPem := false
+
+	block, _ := pem.Decode(data)
+	if block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, false, fmt.Errorf("module signing certificate (%s) has unexpected PEM type (%s)", path,
+				block.Type)
+		}
+
+		certificateDer = block.Bytes
+		isPem = true
+	}
+
+	_, err = x509.ParseCertificate(certificateDer)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid module signing certificate (%s):\n%w", path, err)
+	}
+
+	return certificateDer, isPem, nil
+}
+
+// generateModuleSigningKey generates an ephemeral key and self-signed certificate for signing kernel modules.
+func generateModuleSigningKey(signingDir string) (string, string, []byte, error) {
+	logger.Log.Debugf("Generating ephemeral module signing key")
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, ephemeralModuleSigningKeyBits)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to generate module signing key:\n%w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to generate module signing certificate serial number:\n%w", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: ephemeralModuleSigningKeySubject},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(ephemeralModuleSigningKeyValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		BasicConstraintsValid: true,
+	}
+
+	certificateDer, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to create module signing certificate:\n%w", err)
+	}
+
+	keyPath := filepath.Join(signingDir, "signing.key")
+	err = writePemFile(keyPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privateKey))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	certificatePath := filepath.Join(signingDir, "signing.crt")
+	err = writePemFile(certificatePath, "CERTIFICATE", certificateDer)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return keyPath, certificatePath, certificateDer, nil
+}
+
+func writePemFile(path string, blockType string, data []byte) error {
+	pemData := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+
+	err := os.WriteFile(path, pemData, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write (%s):\n%w", path, err)
+	}
+
+	return nil
+}
+
+// signKernelModule signs a kernel module file, if it isn't already signed. Compressed modules are decompressed,
+// signed and then compressed again.
+//
+// Returns true if the module was signed.
+func signKernelModule(modulePath string, keyPath string, certificatePath string, workDir string) (bool, error) {
+	compression := getKernelModuleCompression(modulePath)
+	if compression == nil {
+		return false, fmt.Errorf("unknown kernel module file type")
+	}
+
+	moduleInfo, err := os.Stat(modulePath)
+	if err != nil {
+		return false, err
+	}
+
+	compressedWorkFile := filepath.Join(workDir, filepath.Base(modulePath))
+	workFile := strings.TrimSuffix(compressedWorkFile, compression.extension) + ".ko"
+	signatureFile := workFile + ".p7s"
+	defer os.Remove(compressedWorkFile)
+	defer os.Remove(workFile)
+	defer os.Remove(signatureFile)
+
+	err = file.Copy(modulePath, compressedWorkFile)
+	if err != nil {
+		return false, err
+	}
+
+	if compression.decompressCommand != nil {
+		err = runModuleCompressionCommand(compression.decompressCommand, compressedWorkFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to decompress module:\n%w", err)
+		}
+	}
+
+	module, err := os.ReadFile(workFile)
+	if err != nil {
+		return false, err
+	}
+
+	if isKernelModuleSigned(module) {
+		return false, nil
+	}
+
+	err = shell.ExecuteLiveWithErr(1, "openssl", "cms", "-sign", "-binary", "-noattr", "-nocerts", "-nosmimecap",
+		"-outform", "DER", "-md", "sha256", "-signer", certificatePath, "-inkey", keyPath,
+		"-in", workFile, "-out", signatureFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to create module signature:\n%w", err)
+	}
+
+	signature, err := os.ReadFile(signatureFile)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.WriteFile(workFile, appendModuleSignature(module, signature), 0o600)
+	if err != nil {
+		return false, err
+	}
+
+	if compression.compressCommand != nil {
+		err = runModuleCompressionCommand(compression.compressCommand, workFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to compress module:\n%w", err)
+		}
+	}
+
+	signedModule, err := os.ReadFile(compressedWorkFile)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.WriteFile(modulePath, signedModule, moduleInfo.Mode().Perm())
+	if err != nil {
+		return false, fmt.Errorf("failed to write signed module:\n%w", err)
+	}
+
+	return true, nil
+}
+
+func runModuleCompressionCommand(command []string, path string) error {
+	args := append(append([]string(nil), command[1:]...), path)
+	return shell.ExecuteLiveWithErr(1, command[0], args...)
+}
+
+// isKernelModuleSigned returns true if the (decompressed) kernel module has an appended signature.
+func isKernelModuleSigned(module []byte) bool {
+	return bytes.HasSuffix(module, []byte(moduleSignatureMagic))
+}
+
+// appendModuleSignature appends a PKCS#7 signature to a kernel module, in the format that the kernel's module loader
+// expects: the signature, followed by a struct module_signature and the magic string.
+func appendModuleSignature(module []byte, signature []byte) []byte {
+	signedModule := bytes.NewBuffer(nil)
+	signedModule.Write(module)
+	signedModule.Write(signature)
+
+	// struct module_signature: algo, hash, id_type, signer_len, key_id_len, pad[3], sig_len (big endian).
+	// For PKCS#7 signatures, the other fields are unused and are zero.
+	signatureInfo := make([]byte, 12)
+	signatureInfo[2] = moduleSignatureIdTypePkcs7
+	binary.BigEndian.PutUint32(signatureInfo[8:], uint32(len(signature)))
+
+	signedModule.Write(signatureInfo)
+	signedModule.WriteString(moduleSignatureMagic)
+	return signedModule.Bytes()
+}
+
+// addMokEnrollmentFiles places the signing certificate and the enrollment instructions in the ESP and, optionally,
+// the first-boot service that requests the enrollment. The certificate and the instructions (including the one-time
+// password) are also staged in artifactsDir, to be written next to the output image.
+func addMokEnrollmentFiles(enrollment *imagecustomizerapi.MokEnrollment, certificateDer []byte,
+	artifactsDir string, imageChroot *safechroot.Chroot,
+) error {
+	espDir := filepath.Join(imageChroot.RootDir(), filepath.Dir(mokEspDir))
+	exists, err := file.DirExists(espDir)
+	if err != nil {
+		return fmt.Errorf("failed to check for ESP directory (%s):\n%w", filepath.Dir(mokEspDir), err)
+	}
+	if !exists {
+		return fmt.Errorf("module signing requires an ESP mounted at (/boot/efi)")
+	}
+
+	automatic := enrollment != nil && enrollment.Automatic
+
+	password := ""
+	passwordDescription := ""
+	if automatic {
+		exists, err := file.PathExists(filepath.Join(imageChroot.RootDir(), mokutilPath))
+		if err != nil {
+			return fmt.Errorf("failed to check for (%s):\n%w", mokutilPath, err)
+		}
+		if !exists {
+			return fmt.Errorf("automatic MOK enrollment requires the mokutil package to be installed")
+		}
+
+		password, passwordDescription, err = getMokEnrollmentPassword(enrollment)
+		if err != nil {
+			return err
+		}
+	}
+
+	fingerprint := sha256.Sum256(certificateDer)
+	instructions := generateMokEnrollmentInstructions(automatic, hex.EncodeToString(fingerprint[:]))
+
+	imageEspDir := filepath.Join(imageChroot.RootDir(), mokEspDir)
+	err = os.MkdirAll(imageEspDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create MOK directory (%s):\n%w", mokEspDir, err)
+	}
+
+	err = os.WriteFile(filepath.Join(imageEspDir, mokCertificateFileName), certificateDer, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write MOK certificate:\n%w", err)
+	}
+
+	err = file.Write(instructions, filepath.Join(imageEspDir, mokInstructionsFileName))
+	if err != nil {
+		return fmt.Errorf("failed to write MOK enrollment instructions:\n%w", err)
+	}
+
+	if automatic {
+		err = addMokEnrollService(password, imageChroot)
+		if err != nil {
+			return err
+		}
+
+		instructions += "\nOne-time enrollment password: " + passwordDescription + "\n"
+	}
+
+	err = os.MkdirAll(artifactsDir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create module signing artifacts directory (%s):\n%w", artifactsDir, err)
+	}
+
+	err = os.WriteFile(filepath.Join(artifactsDir, mokCertificateFileName), certificateDer, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to stage MOK certificate:\n%w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(artifactsDir, mokInstructionsFileName), []byte(instructions), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to stage MOK enrollment instructions:\n%w", err)
+	}
+
+	return nil
+}
+
+// getMokEnrollmentPassword returns the one-time MOK enrollment password, along with how the password is described in
+// the instructions that are written next to the output image. A provided password isn't written out.
+func getMokEnrollmentPassword(enrollment *imagecustomizerapi.MokEnrollment) (string, string, error) {
+	if enrollment.PasswordEnvironmentVariable != "" {
+		password, found := os.LookupEnv(enrollment.PasswordEnvironmentVariable)
+		if !found || password == "" {
+			return "", "", fmt.Errorf("MOK enrollment password environment variable (%s) is not set",
+				enrollment.PasswordEnvironmentVariable)
+		}
+
+		description := fmt.Sprintf("the value of the (%s) environment variable", enrollment.PasswordEnvironmentVariable)
+		return password, description, nil
+	}
+
+	password, err := randomization.RandomString(mokPasswordLength, randomization.LegalCharactersAlphaNum)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate MOK enrollment password:\n%w", err)
+	}
+
+	return password, password, nil
+}
+
+// addMokEnrollService adds the first-boot service that requests the enrollment of the signing certificate. The
+// request is confirmed in MokManager, on the console, during the next boot.
+func addMokEnrollService(password string, imageChroot *safechroot.Chroot) error {
+	passwordHash, err := userutils.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash MOK enrollment password:\n%w", err)
+	}
+
+	passwordFilePath := filepath.Join(imageChroot.RootDir(), mokEnrollPasswordFile)
+	err = os.MkdirAll(filepath.Dir(passwordFilePath), 0o700)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(passwordFilePath, []byte(passwordHash+"\n"), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write MOK enrollment password file (%s):\n%w", mokEnrollPasswordFile, err)
+	}
+
+	serviceFilePath := filepath.Join(imageChroot.RootDir(), mokEnrollServiceDirPath, mokEnrollServiceName)
+	err = file.Write(generateMokEnrollService(), serviceFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write service file (%s):\n%w", serviceFilePath, err)
+	}
+
+	err = imageChroot.UnsafeRun(func() error {
+		return shell.ExecuteLiveWithErr(1, "systemctl", "enable", mokEnrollServiceName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable service (%s):\n%w", mokEnrollServiceName, err)
+	}
+
+	return nil
+}
+
+// generateMokEnrollService generates the first-boot service that requests the MOK enrollment and then removes the
+// password file. If the machine wasn't booted with UEFI, then the service is skipped.
+func generateMokEnrollService() string {
+	certificatePath := filepath.Join(mokEspDir, mokCertificateFileName)
+
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Unit]",
+		"Description=Request the enrollment of the kernel module signing certificate",
+		"RequiresMountsFor=/boot/efi",
+		"ConditionPathExists=" + mokEnrollPasswordFile,
+		"ConditionPathExists=/sys/firmware/efi",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"ExecStart=-" + mokutilPath + " --import " + certificatePath + " --hash-file " + mokEnrollPasswordFile,
+		"ExecStartPost=/usr/bin/rm -f " + mokEnrollPasswordFile,
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// generateMokEnrollmentInstructions generates the instructions for enrolling the signing certificate.
+func generateMokEnrollmentInstructions(automatic bool, fingerprint string) string {
+	certificatePath := filepath.Join(mokEspDir, mokCertificateFileName)
+
+	lines := []string{
+		"Kernel module signing certificate",
+		"",
+		"The out-of-tree kernel modules of this image are signed with the certificate:",
+		"  " + certificatePath,
+		"  SHA-256 fingerprint: " + fingerprint,
+		"",
+		"When Secure Boot is enabled, the modules can only be loaded after the certificate is enrolled as a",
+		"Machine Owner Key (MOK):",
+		"",
+	}
+
+	if automatic {
+		lines = append(lines,
+			"1. On the first boot, the "+mokEnrollServiceName+" service requests the enrollment.",
+		)
+	} else {
+		lines = append(lines,
+			"1. Request the enrollment and choose a one-time password:",
+			"     mokutil --import "+certificatePath,
+		)
+	}
+
+	lines = append(lines,
+		"2. Reboot. MokManager starts on the console. Select \"Enroll MOK\", check the fingerprint of the key,",
+		"   confirm the enrollment, and enter the one-time password.",
+		"3. Verify the enrollment:",
+		"     mokutil --test-key "+certificatePath,
+		"",
+	)
+
+	return strings.Join(lines, "\n")
+}
+
+// writeModuleSigningArtifacts writes the staged MOK certificate and enrollment instructions next to the output
+// image.
+func writeModuleSigningArtifacts(buildDir string, outputImageDir string, outputImageBase string) error {
+	artifactsDir := filepath.Join(buildDir, moduleSigningArtifactsDirName)
+
+	exists, err := file.DirExists(artifactsDir)
+	if err != nil {
+		return fmt.Errorf("failed to check for module signing artifacts directory (%s):\n%w", artifactsDir, err)
+	}
+	if !exists {
+		// No modules were signed.
+		return nil
+	}
+
+	artifacts := []struct {
+		source string
+		suffix string
+	}{
+		{mokCertificateFileName, mokCertificateFileSuffix},
+		{mokInstructionsFileName, mokInstructionsFileSuffix},
+	}
+
+	for _, artifact := range artifacts {
+		outputFile := filepath.Join(outputImageDir, outputImageBase+artifact.suffix)
+		err = file.Copy(filepath.Join(artifactsDir, artifact.source), outputFile)
+		if err != nil {
+			return fmt.Errorf("failed to write module signing artifact (%s):\n%w", outputFile, err)
+		}
+	}
+
+	err = os.RemoveAll(artifactsDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove module signing artifacts directory (%s):\n%w", artifactsDir, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendModuleSignature(t *testing.T) {
+	module := []byte("\x7fELF module")
+	signature := []byte("signature")
+
+	signedModule := appendModuleSignature(module, signature)
+	assert.True(t, isKernelModuleSigned(signedModule))
+	assert.False(t, isKernelModuleSigned(module))
+
+	trailer := signedModule[len(signedModule)-len(moduleSignatureMagic)-12:]
+	assert.Equal(t, []byte{0, 0, moduleSignatureIdTypePkcs7, 0, 0, 0, 0, 0}, trailer[:8])
+	assert.Equal(t, uint32(len(signature)), binary.BigEndian.Uint32(trailer[8:12]))
+	assert.Equal(t, append(append([]byte(nil), module...), signature...), signedModule[:len(module)+len(signature)])
+}
+
+func TestListKernelModules(t *testing.T) {
+	rootDir := t.TempDir()
+	modulesDir := filepath.Join(rootDir, "lib/modules/6.6.0/extra")
+	err := os.MkdirAll(modulesDir, 0o755)
+	require.NoError(t, err)
+
+	for _, name := range []string{"a.ko", "b.ko.xz", "c.ko.zst", "modules.dep"} {
+		err = os.WriteFile(filepath.Join(modulesDir, name), nil, 0o644)
+		require.NoError(t, err)
+	}
+
+	modules, err := listKernelModules(rootDir)
+	assert.NoError(t, err)
+	assert.Len(t, modules, 3)
+	assert.Contains(t, modules, "lib/modules/6.6.0/extra/a.ko")
+	assert.Contains(t, modules, "lib/modules/6.6.0/extra/b.ko.xz")
+	assert.Contains(t, modules, "lib/modules/6.6.0/extra/c.ko.zst")
+
+	// An image without any kernel modules.
+	modules, err = listKernelModules(t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, modules)
+}
+
+func TestSignKernelModule(t *testing.T) {
+	for _, tool := range []string{"openssl", "xz", "zstd"} {
+		_, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s isn't installed", tool)
+		}
+	}
+
+	workDir := t.TempDir()
+	keyPath, certificatePath, certificateDer, err := generateModuleSigningKey(workDir)
+	require.NoError(t, err)
+
+	// The certificate is accepted by the provided key validation, in both PEM and DER forms.
+	readCertificateDer, isPem, err := readModuleSigningCertificate(certificatePath)
+	assert.NoError(t, err)
+	assert.True(t, isPem)
+	assert.Equal(t, certificateDer, readCertificateDer)
+
+	modulesDir := t.TempDir()
+	module := []byte("\x7fELF " + strings.Repeat("module ", 100))
+
+	plainModulePath := filepath.Join(modulesDir, "plain.ko")
+	err = os.WriteFile(plainModulePath, module, 0o644)
+	require.NoError(t, err)
+
+	signed, err := signKernelModule(plainModulePath, keyPath, certificatePath, workDir)
+	assert.NoError(t, err)
+	assert.True(t, signed)
+
+	signedModule, err := os.ReadFile(plainModulePath)
+	require.NoError(t, err)
+	assert.True(t, isKernelModuleSigned(signedModule))
+	assert.Equal(t, module, signedModule[:len(module)])
+
+	// A signed module isn't signed again.
+	signed, err = signKernelModule(plainModulePath, keyPath, certificatePath, workDir)
+	assert.NoError(t, err)
+	assert.False(t, signed)
+
+	// Compressed modules stay compressed.
+	for _, compression := range []string{"xz", "zstd"} {
+		uncompressedPath := filepath.Join(modulesDir, compression+".ko")
+		err = os.WriteFile(uncompressedPath, module, 0o644)
+		require.NoError(t, err)
+
+		// Both tools replace the original file.
+		compressedPath := uncompressedPath + ".xz"
+		compressArgs := []string{"--quiet", uncompressedPath}
+		if compression == "zstd" {
+			compressedPath = uncompressedPath + ".zst"
+			compressArgs = []string{"--quiet", "--rm", uncompressedPath}
+		}
+
+		err = exec.Command(compression, compressArgs...).Run()
+		require.NoError(t, err)
+
+		signed, err = signKernelModule(compressedPath, keyPath, certificatePath, workDir)
+		assert.NoError(t, err, compression)
+		assert.True(t, signed, compression)
+
+		err = exec.Command(compression, "--decompress", "--quiet", "--force", compressedPath).Run()
+		require.NoError(t, err)
+
+		signedModule, err = os.ReadFile(uncompressedPath)
+		require.NoError(t, err)
+		assert.True(t, isKernelModuleSigned(signedModule), compression)
+	}
+
+	// The work directory only holds the key.
+	entries, err := os.ReadDir(workDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestWriteModuleSigningArtifacts(t *testing.T) {
+	buildDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	// Nothing is written if no modules were signed.
+	err := writeModuleSigningArtifacts(buildDir, outputDir, "image")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(outputDir, "image"+mokCertificateFileSuffix))
+
+	artifactsDir := filepath.Join(buildDir, moduleSigningArtifactsDirName)
+	err = os.MkdirAll(artifactsDir, 0o700)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(artifactsDir, mokCertificateFileName), []byte("certificate"), 0o644)
+	require.NoError(t, err)
+
+	instructions := generateMokEnrollmentInstructions(true, "00ff")
+	err = os.WriteFile(filepath.Join(artifactsDir, mokInstructionsFileName), []byte(instructions), 0o600)
+	require.NoError(t, err)
+
+	err = writeModuleSigningArtifacts(buildDir, outputDir, "image")
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(outputDir, "image"+mokCertificateFileSuffix))
+	assert.NoDirExists(t, artifactsDir)
+
+	writtenInstructions, err := os.ReadFile(filepath.Join(outputDir, "image"+mokInstructionsFileSuffix))
+	assert.NoError(t, err)
+	assert.Contains(t, string(writtenInstructions), "SHA-256 fingerprint: 00ff")
+	assert.Contains(t, string(writtenInstructions), mokEnrollServiceName)
+}
+
+func TestGenerateMokEnrollService(t *testing.T) {
+	service := generateMokEnrollService()
+	assert.Contains(t, service, "ConditionPathExists="+mokEnrollPasswordFile)
+	assert.Contains(t, service,
+		"ExecStart=-/usr/bin/mokutil --import /boot/efi/EFI/mok/module-signing.der --hash-file "+mokEnrollPasswordFile)
+	assert.Contains(t, service, "ExecStartPost=/usr/bin/rm -f "+mokEnrollPasswordFile)
+}
@@ -0,0 +1,329 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/systemd"
+)
+
+const (
+	networkdConfigDir = "/etc/systemd/network"
+	// systemd-networkd uses the first .network file (in lexical order) that matches an interface. So, use a low
+	// number to take precedence over the base image's catch-all files (e.g. 99-dhcp-en.network).
+	networkdConfigFilePrefix = "10-imagecustomizer-"
+	networkdServiceName      = "systemd-networkd"
+
+	networkManagerConfigDir        = "/etc/NetworkManager/system-connections"
+	networkManagerConfigFilePrefix = "imagecustomizer-"
+	networkManagerServiceName      = "NetworkManager"
+	networkManagerPackageName      = "NetworkManager"
+
+	networkConfigFileHeader = "# Generated by the Azure Linux Image Customizer."
+)
+
+type networkConfigFile struct {
+	// The path of the file within the image.
+	path        string
+	content     string
+	permissions os.FileMode
+}
+
+func customizeNetwork(network *imagecustomizerapi.Network, imageChroot *safechroot.Chroot) error {
+	if network == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Configuring network")
+
+	var configFiles []networkConfigFile
+	var services imagecustomizerapi.Services
+	var otherService string
+
+	switch network.Renderer {
+	case imagecustomizerapi.NetworkRendererNetworkManager:
+		if !isPackageInstalled(imageChroot, networkManagerPackageName) {
+			return fmt.Errorf("package (%s) must be installed to use the (%s) network renderer",
+				networkManagerPackageName, network.Renderer)
+		}
+
+		configFiles = renderNetworkManagerConfig(network)
+		services.Enable = []string{networkManagerServiceName}
+		otherService = networkdServiceName
+
+	default:
+		_, err := systemd.IsServiceEnabled(networkdServiceName, imageChroot)
+		if err != nil {
+			return fmt.Errorf("systemd-networkd must be installed to use the (%s) network renderer:\n%w",
+				imagecustomizerapi.NetworkRendererNetworkd, err)
+		}
+
+		configFiles = renderNetworkdConfig(network)
+		services.Enable = []string{networkdServiceName}
+		otherService = networkManagerServiceName
+	}
+
+	for _, configFile := range configFiles {
+		err := os.MkdirAll(filepath.Join(imageChroot.RootDir(), path.Dir(configFile.path)), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create network config directory:\n%w", err)
+		}
+
+		err = safechroot.AddFilesToDestination(imageChroot.RootDir(), safechroot.FileToCopy{
+			Content:     &configFile.content,
+			Dest:        configFile.path,
+			Permissions: &configFile.permissions,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write network config file (%s):\n%w", configFile.path, err)
+		}
+	}
+
+	// Ensure the two network managers don't fight over the interfaces.
+	otherServiceEnabled, err := systemd.IsServiceEnabled(otherService, imageChroot)
+	if err == nil && otherServiceEnabled {
+		services.Disable = []string{otherService}
+	}
+
+	err = enableOrDisableServices(services, imageChroot)
+	if err != nil {
+		return fmt.Errorf("failed to configure network services:\n%w", err)
+	}
+
+	return nil
+}
+
+// networkMemberships returns which bond each interface is a member of and which VLANs each interface is the link of.
+func networkMemberships(network *imagecustomizerapi.Network) (map[string]string, map[string][]string) {
+	bondOf := make(map[string]string)
+	for _, bond := range network.Bonds {
+		for _, member := range bond.Interfaces {
+			bondOf[member] = bond.Name
+		}
+	}
+
+	vlansOf := make(map[string][]string)
+	for _, vlan := range network.Vlans {
+		vlansOf[vlan.Link] = append(vlansOf[vlan.Link], vlan.Name)
+	}
+
+	return bondOf, vlansOf
+}
+
+func renderNetworkdConfig(network *imagecustomizerapi.Network) []networkConfigFile {
+	bondOf, vlansOf := networkMemberships(network)
+
+	var configFiles []networkConfigFile
+	addFile := func(name string, extension string, lines []string) {
+		configFiles = append(configFiles, networkConfigFile{
+			path:        path.Join(networkdConfigDir, networkdConfigFilePrefix+name+extension),
+			content:     strings.Join(append([]string{networkConfigFileHeader}, lines...), "\n") + "\n",
+			permissions: 0o644,
+		})
+	}
+
+	for _, ethernet := range network.Ethernets {
+		lines := []string{"[Match]", "Name=" + ethernet.Name}
+		if ethernet.MacAddress != "" {
+			lines = append(lines, "MACAddress="+ethernet.MacAddress)
+		}
+		lines = append(lines, "", "[Network]")
+		lines = append(lines, networkdNetworkLines(ethernet.NetworkIpConfig, bondOf[ethernet.Name],
+			vlansOf[ethernet.Name])...)
+		addFile(ethernet.Name, ".network", lines)
+	}
+
+	for _, bond := range network.Bonds {
+		netdevLines := []string{"[NetDev]", "Name=" + bond.Name, "Kind=bond"}
+		if bond.Mode != imagecustomizerapi.NetworkBondModeDefault {
+			netdevLines = append(netdevLines, "", "[Bond]", "Mode="+string(bond.Mode))
+		}
+		addFile(bond.Name, ".netdev", netdevLines)
+
+		lines := []string{"[Match]", "Name=" + bond.Name, "", "[Network]"}
+		lines = append(lines, networkdNetworkLines(bond.NetworkIpConfig, "", vlansOf[bond.Name])...)
+		addFile(bond.Name, ".network", lines)
+	}
+
+	for _, vlan := range network.Vlans {
+		addFile(vlan.Name, ".netdev", []string{
+			"[NetDev]", "Name=" + vlan.Name, "Kind=vlan",
+			"",
+			"[VLAN]", fmt.Sprintf("Id=%d", vlan.Id),
+		})
+
+		lines := []string{"[Match]", "Name=" + vlan.Name, "", "[Network]"}
+		lines = append(lines, networkdNetworkLines(vlan.NetworkIpConfig, "", nil)...)
+		addFile(vlan.Name, ".network", lines)
+	}
+
+	return configFiles
+}
+
+func networkdNetworkLines(ipConfig imagecustomizerapi.NetworkIpConfig, bond string, vlans []string) []string {
+	var lines []string
+
+	switch {
+	case ipConfig.Dhcp4 && ipConfig.Dhcp6:
+		lines = append(lines, "DHCP=yes")
+	case ipConfig.Dhcp4:
+		lines = append(lines, "DHCP=ipv4")
+	case ipConfig.Dhcp6:
+		lines = append(lines, "DHCP=ipv6")
+	}
+
+	for _, address := range ipConfig.Addresses {
+		lines = append(lines, "Address="+address)
+	}
+	for _, gateway := range ipConfig.Gateways {
+		lines = append(lines, "Gateway="+gateway)
+	}
+	for _, nameserver := range ipConfig.Nameservers {
+		lines = append(lines, "DNS="+nameserver)
+	}
+	if len(ipConfig.SearchDomains) > 0 {
+		lines = append(lines, "Domains="+strings.Join(ipConfig.SearchDomains, " "))
+	}
+	if bond != "" {
+		lines = append(lines, "Bond="+bond)
+	}
+	for _, vlan := range vlans {
+		lines = append(lines, "VLAN="+vlan)
+	}
+
+	return lines
+}
+
+func renderNetworkManagerConfig(network *imagecustomizerapi.Network) []networkConfigFile {
+	bondOf, _ := networkMemberships(network)
+
+	var configFiles []networkConfigFile
+	addFile := func(name string, lines []string) {
+		// NetworkManager ignores keyfiles that are readable by other users.
+		configFiles = append(configFiles, networkConfigFile{
+			path:        path.Join(networkManagerConfigDir, networkManagerConfigFilePrefix+name+".nmconnection"),
+			content:     strings.Join(append([]string{networkConfigFileHeader}, lines...), "\n") + "\n",
+			permissions: 0o600,
+		})
+	}
+
+	for _, ethernet := range network.Ethernets {
+		lines := []string{"[connection]", "id=" + ethernet.Name, "type=ethernet", "interface-name=" + ethernet.Name}
+		if bond, ok := bondOf[ethernet.Name]; ok {
+			lines = append(lines, "master="+bond, "slave-type=bond")
+		}
+		if ethernet.MacAddress != "" {
+			lines = append(lines, "", "[ethernet]", "mac-address="+ethernet.MacAddress)
+		}
+		if _, ok := bondOf[ethernet.Name]; !ok {
+			lines = append(lines, networkManagerIpLines(ethernet.NetworkIpConfig)...)
+		}
+		addFile(ethernet.Name, lines)
+	}
+
+	for _, bond := range network.Bonds {
+		lines := []string{"[connection]", "id=" + bond.Name, "type=bond", "interface-name=" + bond.Name}
+		if bond.Mode != imagecustomizerapi.NetworkBondModeDefault {
+			lines = append(lines, "", "[bond]", "mode="+string(bond.Mode))
+		}
+		lines = append(lines, networkManagerIpLines(bond.NetworkIpConfig)...)
+		addFile(bond.Name, lines)
+	}
+
+	for _, vlan := range network.Vlans {
+		lines := []string{
+			"[connection]", "id=" + vlan.Name, "type=vlan", "interface-name=" + vlan.Name,
+			"",
+			"[vlan]", fmt.Sprintf("id=%d", vlan.Id), "parent=" + vlan.Link,
+		}
+		lines = append(lines, networkManagerIpLines(vlan.NetworkIpConfig)...)
+		addFile(vlan.Name, lines)
+	}
+
+	return configFiles
+}
+
+func networkManagerIpLines(ipConfig imagecustomizerapi.NetworkIpConfig) []string {
+	var ipv4Addresses, ipv6Addresses, ipv4Gateway, ipv6Gateway, ipv4Dns, ipv6Dns []string
+
+	for _, address := range ipConfig.Addresses {
+		prefix := netip.MustParsePrefix(address)
+		if prefix.Addr().Is4() {
+			ipv4Addresses = append(ipv4Addresses, address)
+		} else {
+			ipv6Addresses = append(ipv6Addresses, address)
+		}
+	}
+	for _, gateway := range ipConfig.Gateways {
+		if netip.MustParseAddr(gateway).Is4() {
+			ipv4Gateway = append(ipv4Gateway, gateway)
+		} else {
+			ipv6Gateway = append(ipv6Gateway, gateway)
+		}
+	}
+	for _, nameserver := range ipConfig.Nameservers {
+		if netip.MustParseAddr(nameserver).Is4() {
+			ipv4Dns = append(ipv4Dns, nameserver)
+		} else {
+			ipv6Dns = append(ipv6Dns, nameserver)
+		}
+	}
+
+	ipv4Method := "disabled"
+	switch {
+	case ipConfig.Dhcp4:
+		ipv4Method = "auto"
+	case len(ipv4Addresses) > 0:
+		ipv4Method = "manual"
+	}
+
+	// Leave IPv6 to the kernel (e.g. SLAAC), unless it is configured.
+	ipv6Method := "ignore"
+	switch {
+	case ipConfig.Dhcp6:
+		ipv6Method = "auto"
+	case len(ipv6Addresses) > 0:
+		ipv6Method = "manual"
+	}
+
+	// Search domains apply to the whole connection. So, they only need to be specified once.
+	ipv4SearchDomains := ipConfig.SearchDomains
+	var ipv6SearchDomains []string
+	if ipv4Method == "disabled" {
+		ipv4SearchDomains, ipv6SearchDomains = nil, ipConfig.SearchDomains
+	}
+
+	lines := []string{"", "[ipv4]", "method=" + ipv4Method}
+	lines = append(lines, networkManagerAddressLines(ipv4Addresses, ipv4Gateway, ipv4Dns, ipv4SearchDomains)...)
+	lines = append(lines, "", "[ipv6]", "method="+ipv6Method)
+	lines = append(lines, networkManagerAddressLines(ipv6Addresses, ipv6Gateway, ipv6Dns, ipv6SearchDomains)...)
+	return lines
+}
+
+func networkManagerAddressLines(addresses []string, gateway []string, dns []string, searchDomains []string,
+) []string {
+	var lines []string
+	for i, address := range addresses {
+		lines = append(lines, fmt.Sprintf("address%d=%s", i+1, address))
+	}
+	if len(gateway) > 0 {
+		lines = append(lines, "gateway="+gateway[0])
+	}
+	if len(dns) > 0 {
+		lines = append(lines, "dns="+strings.Join(dns, ";")+";")
+	}
+	if len(searchDomains) > 0 {
+		lines = append(lines, "dns-search="+strings.Join(searchDomains, ";")+";")
+	}
+	return lines
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+var testNetworkConfig = imagecustomizerapi.Network{
+	Ethernets: []imagecustomizerapi.NetworkEthernet{
+		{
+			Name:       "eth0",
+			MacAddress: "00:15:5d:01:02:03",
+			NetworkIpConfig: imagecustomizerapi.NetworkIpConfig{
+				Addresses:     []string{"192.168.0.10/24", "fd00::10/64"},
+				Gateways:      []string{"192.168.0.1"},
+				Nameservers:   []string{"192.168.0.1", "fd00::1"},
+				SearchDomains: []string{"example.com"},
+			},
+		},
+		{Name: "eth1"},
+		{Name: "eth2"},
+	},
+	Bonds: []imagecustomizerapi.NetworkBond{
+		{
+			Name:            "bond0",
+			Mode:            imagecustomizerapi.NetworkBondModeActiveBackup,
+			Interfaces:      []string{"eth1", "eth2"},
+			NetworkIpConfig: imagecustomizerapi.NetworkIpConfig{Dhcp4: true, Dhcp6: true},
+		},
+	},
+	Vlans: []imagecustomizerapi.NetworkVlan{
+		{
+			Name:            "vlan10",
+			Id:              10,
+			Link:            "bond0",
+			NetworkIpConfig: imagecustomizerapi.NetworkIpConfig{Dhcp4: true},
+		},
+	},
+}
+
+func TestRenderNetworkdConfig(t *testing.T) {
+	configFiles := renderNetworkdConfig(&testNetworkConfig)
+
+	assert.Equal(t, []networkConfigFile{
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-eth0.network",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[Match]\nName=eth0\nMACAddress=00:15:5d:01:02:03\n\n" +
+				"[Network]\nAddress=192.168.0.10/24\nAddress=fd00::10/64\nGateway=192.168.0.1\n" +
+				"DNS=192.168.0.1\nDNS=fd00::1\nDomains=example.com\n",
+			permissions: 0o644,
+		},
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-eth1.network",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[Match]\nName=eth1\n\n[Network]\nBond=bond0\n",
+			permissions: 0o644,
+		},
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-eth2.network",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[Match]\nName=eth2\n\n[Network]\nBond=bond0\n",
+			permissions: 0o644,
+		},
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-bond0.netdev",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[NetDev]\nName=bond0\nKind=bond\n\n[Bond]\nMode=active-backup\n",
+			permissions: 0o644,
+		},
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-bond0.network",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[Match]\nName=bond0\n\n[Network]\nDHCP=yes\nVLAN=vlan10\n",
+			permissions: 0o644,
+		},
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-vlan10.netdev",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[NetDev]\nName=vlan10\nKind=vlan\n\n[VLAN]\nId=10\n",
+			permissions: 0o644,
+		},
+		{
+			path: "/etc/systemd/network/10-imagecustomizer-vlan10.network",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[Match]\nName=vlan10\n\n[Network]\nDHCP=ipv4\n",
+			permissions: 0o644,
+		},
+	}, configFiles)
+}
+
+func TestRenderNetworkManagerConfig(t *testing.T) {
+	configFiles := renderNetworkManagerConfig(&testNetworkConfig)
+
+	assert.Equal(t, []networkConfigFile{
+		{
+			path: "/etc/NetworkManager/system-connections/imagecustomizer-eth0.nmconnection",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[connection]\nid=eth0\ntype=ethernet\ninterface-name=eth0\n\n" +
+				"[ethernet]\nmac-address=00:15:5d:01:02:03\n\n" +
+				"[ipv4]\nmethod=manual\naddress1=192.168.0.10/24\ngateway=192.168.0.1\ndns=192.168.0.1;\n" +
+				"dns-search=example.com;\n\n" +
+				"[ipv6]\nmethod=manual\naddress1=fd00::10/64\ndns=fd00::1;\n",
+			permissions: 0o600,
+		},
+		{
+			path: "/etc/NetworkManager/system-connections/imagecustomizer-eth1.nmconnection",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[connection]\nid=eth1\ntype=ethernet\ninterface-name=eth1\nmaster=bond0\nslave-type=bond\n",
+			permissions: 0o600,
+		},
+		{
+			path: "/etc/NetworkManager/system-connections/imagecustomizer-eth2.nmconnection",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[connection]\nid=eth2\ntype=ethernet\ninterface-name=eth2\nmaster=bond0\nslave-type=bond\n",
+			permissions: 0o600,
+		},
+		{
+			path: "/etc/NetworkManager/system-connections/imagecustomizer-bond0.nmconnection",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[connection]\nid=bond0\ntype=bond\ninterface-name=bond0\n\n" +
+				"[bond]\nmode=active-backup\n\n" +
+				"[ipv4]\nmethod=auto\n\n" +
+				"[ipv6]\nmethod=auto\n",
+			permissions: 0o600,
+		},
+		{
+			path: "/etc/NetworkManager/system-connections/imagecustomizer-vlan10.nmconnection",
+			content: "# Generated by the Azure Linux Image Customizer.\n" +
+				"[connection]\nid=vlan10\ntype=vlan\ninterface-name=vlan10\n\n" +
+				"[vlan]\nid=10\nparent=bond0\n\n" +
+				"[ipv4]\nmethod=auto\n\n" +
+				"[ipv6]\nmethod=ignore\n",
+			permissions: 0o600,
+		},
+	}, configFiles)
+}
+
+func TestNetworkManagerIpLinesSearchDomainsWithoutIpv4(t *testing.T) {
+	lines := networkManagerIpLines(imagecustomizerapi.NetworkIpConfig{
+		Dhcp6:         true,
+		SearchDomains: []string{"example.com"},
+	})
+
+	assert.Equal(t, []string{
+		"", "[ipv4]", "method=disabled",
+		"", "[ipv6]", "method=auto", "dns-search=example.com;",
+	}, lines)
+}
@@ -7,112 +7,447 @@ import (
 	"time"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 )
 
-func doOsCustomizations(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
-	imageConnection *ImageConnection, rpmsSources []string, useBaseImageRpmRepos bool, partitionsCustomized bool,
-	imageUuid string) error {
-	var err error
-
-	imageChroot := imageConnection.Chroot()
-
-	buildTime := time.Now().Format("2006-01-02T15:04:05Z")
-
-	resolvConf, err := overrideResolvConf(imageChroot)
-	if err != nil {
-		return err
-	}
+// osCustomizationContext is the state that is shared between the stages of the OS customization.
+type osCustomizationContext struct {
+	buildDir             string
+	baseConfigPath       string
+	config               *imagecustomizerapi.Config
+	imageConnection      *ImageConnection
+	imageChroot          *safechroot.Chroot
+	rpmsSources          []string
+	useBaseImageRpmRepos bool
+	partitionsCustomized bool
+	partIdToPartUuid     map[string]string
+	stage                *packageStage
+	imageUuid            string
+	phaseValidators      []PhaseValidator
+	selinuxReportBuilder *selinuxReportBuilder
+	buildTime            string
 
-	err = addRemoveAndUpdatePackages(buildDir, baseConfigPath, config.OS, imageChroot, rpmsSources,
-		useBaseImageRpmRepos)
-	if err != nil {
-		return err
-	}
-
-	err = UpdateHostname(config.OS.Hostname, imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = copyAdditionalDirs(baseConfigPath, config.OS.AdditionalDirs, imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = copyAdditionalFiles(baseConfigPath, config.OS.AdditionalFiles, imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = AddOrUpdateUsers(config.OS.Users, baseConfigPath, imageChroot)
-	if err != nil {
-		return err
-	}
+	// Set by the stages.
+	resolvConf         resolvConfInfo
+	outputArtifactsDir string
+	baseKernelModules  map[string]time.Time
+	orphansRemoved     []string
+	selinuxMode        imagecustomizerapi.SELinuxMode
+	initrdOutdated     bool
+}
 
-	err = enableOrDisableServices(config.OS.Services, imageChroot)
-	if err != nil {
-		return err
-	}
+// osCustomizationStage is a step of the OS customization.
+type osCustomizationStage struct {
+	// run applies the stage to the image.
+	run func(c *osCustomizationContext) error
+	// plan adds the stage's operations to a dry-run plan (see planOSCustomizations). nil for stages that don't change
+	// the image (e.g. validators).
+	plan func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error
+}
 
-	err = loadOrDisableModules(config.OS.Modules, imageChroot.RootDir())
-	if err != nil {
-		return err
+// osCustomizationStages returns the stages of the OS customization, in the order they are run. Both the customization
+// and its dry-run plan are derived from this list, so that the plan can't drift from what is actually done.
+func osCustomizationStages() []osCustomizationStage {
+	return []osCustomizationStage{
+		{
+			run: func(c *osCustomizationContext) (err error) {
+				c.resolvConf, err = overrideResolvConf(c.imageChroot)
+				return err
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) (err error) {
+				c.outputArtifactsDir, err = prepareScriptsOutputArtifactsDir(c.baseConfigPath, c.config.Scripts)
+				return err
+			},
+		},
+		{
+			run:  customizePackagesStage,
+			plan: planPackagesStage,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runPhaseValidators(c.buildDir, c.phaseValidators, ValidationPhaseAfterPackages,
+					c.imageChroot.RootDir(), c.config)
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runUserScripts(c.baseConfigPath, c.config.Scripts.PostPackageInstall, "postPackageInstall",
+					c.outputArtifactsDir, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				planScripts(plan, "postPackageInstall", ic.config.Scripts.PostPackageInstall)
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return lockPackageVersions(c.config.OS.PackageLocks, c.imageChroot)
+			},
+			plan: planPackageLocks,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return customizeTdnf(c.config.OS.Tdnf, c.imageChroot.RootDir())
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if osConfig.Tdnf != nil {
+					plan.addStep("Configure tdnf")
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return customizeRepos(c.config.OS.Repos, c.imageChroot.RootDir())
+			},
+			plan: planRepos,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return UpdateHostname(c.config.OS.Hostname, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if osConfig.Hostname != "" {
+					plan.addStep("Set hostname", osConfig.Hostname)
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return updateTimezone(c.config.OS.Timezone, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if osConfig.Timezone != "" {
+					plan.addStep("Set timezone", osConfig.Timezone)
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return updateLocale(c.config.OS.Locale, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if osConfig.Locale != "" {
+					plan.addStep("Set locale", osConfig.Locale)
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return updateKeymap(c.config.OS.Keymap, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if osConfig.Keymap != "" {
+					plan.addStep("Set keymap", osConfig.Keymap)
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return copyAdditionalDirs(c.baseConfigPath, c.config.OS.AdditionalDirs, c.imageChroot)
+			},
+			plan: planAdditionalDirs,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return copyAdditionalFiles(c.baseConfigPath, c.config.OS.AdditionalFiles, c.config.OS.TemplateVariables,
+					c.imageChroot)
+			},
+			plan: planAdditionalFiles,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return AddOrUpdateUsers(c.config.OS.Users, c.baseConfigPath, c.imageChroot)
+			},
+			plan: planUsers,
+		},
+		{
+			// The owners may be users that were just added.
+			run: func(c *osCustomizationContext) error {
+				return setAdditionalFilesOwnership(c.baseConfigPath, c.config.OS.AdditionalDirs,
+					c.config.OS.AdditionalFiles, c.imageChroot)
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return customizeNetwork(c.config.OS.Network, c.imageChroot)
+			},
+			plan: planNetwork,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return customizeProxy(c.baseConfigPath, c.config.OS.Proxy, c.imageChroot)
+			},
+			plan: planProxy,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return enableOrDisableServices(c.config.OS.Services, c.imageChroot)
+			},
+			plan: planServices,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return addScheduledTasks(c.config.OS.ScheduledTasks, c.imageChroot)
+			},
+			plan: planScheduledTasks,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return customizeCloudInit(c.baseConfigPath, c.config.OS.CloudInit, c.imageChroot)
+			},
+			plan: planCloudInit,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return loadOrDisableModules(c.config.OS.Modules, c.imageChroot.RootDir())
+			},
+			plan: planModules,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return addSelfTest(c.config, c.imageChroot)
+			},
+			plan: planSelfTest,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runUserScripts(c.baseConfigPath, c.config.Scripts.PostConfig, "postConfig",
+					c.outputArtifactsDir, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				planScripts(plan, "postConfig", ic.config.Scripts.PostConfig)
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return applyIdLedger(c.baseConfigPath, c.config.OS.IdLedger, c.imageChroot)
+			},
+			plan: planIdLedger,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return addCustomizerRelease(c.imageChroot, ToolVersion, c.buildTime, c.imageUuid)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				plan.addStep("Write customizer release file")
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return handleBootLoader(c.baseConfigPath, c.config, c.imageConnection)
+			},
+			plan: planBootLoader,
+		},
+		{
+			run: func(c *osCustomizationContext) (err error) {
+				c.selinuxMode, err = handleSELinux(c.config.OS.SELinux.Mode, c.config.OS.ResetBootLoaderType,
+					c.imageChroot)
+				return err
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if osConfig.SELinux.Mode != imagecustomizerapi.SELinuxModeDefault {
+					plan.addStep("Set SELinux mode", string(osConfig.SELinux.Mode))
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				updated, err := enableOverlays(c.config.OS.Overlays, c.selinuxMode, c.imageChroot)
+				c.initrdOutdated = c.initrdOutdated || updated
+				return err
+			},
+			plan: planOverlays,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				updated, err := enableWritableLayers(c.config.OS.WritableLayers, c.selinuxMode, c.imageChroot)
+				c.initrdOutdated = c.initrdOutdated || updated
+				return err
+			},
+			plan: planWritableLayers,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				updated, err := enableVerityPartition(c.config.Storage.Verity, c.imageChroot)
+				c.initrdOutdated = c.initrdOutdated || updated
+				return err
+			},
+			plan: planVerity,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				updated, err := enableEncryptedVolumes(c.buildDir, c.config.Storage.EncryptedVolumes,
+					c.partIdToPartUuid, c.imageChroot)
+				c.initrdOutdated = c.initrdOutdated || updated
+				return err
+			},
+			plan: planEncryptedVolumes,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return prepareAbUpdateMetadataFile(c.config.Storage.AbUpdate, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				if ic.config.Storage.AbUpdate != nil {
+					plan.addStep("Prepare A/B update metadata file", ic.config.Storage.AbUpdate.GetMetadataPath())
+				}
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				if !c.partitionsCustomized && !c.initrdOutdated {
+					return nil
+				}
+				return regenerateInitrd(c.imageChroot)
+			},
+			plan: planRegenerateInitrd,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runUserScripts(c.baseConfigPath, c.config.Scripts.PostCustomization, "postCustomization",
+					c.outputArtifactsDir, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				planScripts(plan, "postCustomization", ic.config.Scripts.PostCustomization)
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return restoreResolvConf(c.resolvConf, c.imageChroot)
+			},
+		},
+		{
+			// The SELinux report records the files that the customization left mislabeled, which relabeling fixes.
+			run: func(c *osCustomizationContext) error {
+				return c.selinuxReportBuilder.captureRelabels(c.imageChroot)
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return selinuxSetFiles(c.selinuxMode, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				plan.addStep("Apply SELinux file labels")
+				return nil
+			},
+		},
+		{
+			// The explicit labels override the labels that were just set from the SELinux policy.
+			run: func(c *osCustomizationContext) error {
+				return setAdditionalFilesSELinuxLabels(c.baseConfigPath, c.config.OS.AdditionalDirs,
+					c.config.OS.AdditionalFiles, c.imageChroot)
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runUserScripts(c.baseConfigPath, c.config.Scripts.FinalizeCustomization,
+					"finalizeCustomization", c.outputArtifactsDir, c.imageChroot)
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				planScripts(plan, "finalizeCustomization", ic.config.Scripts.FinalizeCustomization)
+				return nil
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runUserScriptsOutsideChroot(c.buildDir, c.baseConfigPath, c.config.Scripts.FinalizeOutsideChroot,
+					"finalizeOutsideChroot", c.outputArtifactsDir, c.imageChroot.RootDir())
+			},
+			plan: func(plan *CustomizationPlan, ic *ImageCustomizerParameters, osConfig *imagecustomizerapi.OS) error {
+				planScripts(plan, "finalizeOutsideChroot", ic.config.Scripts.FinalizeOutsideChroot)
+				return nil
+			},
+		},
+		{
+			// The modules are signed after all of the scripts have run, so that the modules that the scripts add or
+			// rebuild are signed too.
+			run: func(c *osCustomizationContext) error {
+				return signKernelModulesAndUpdateInitrds(c.buildDir, c.baseConfigPath, getModuleSigning(c.config.OS),
+					c.baseKernelModules, c.imageChroot)
+			},
+			plan: planModuleSigning,
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return runPhaseValidators(c.buildDir, c.phaseValidators, ValidationPhaseAfterScripts,
+					c.imageChroot.RootDir(), c.config)
+			},
+		},
+		{
+			run: func(c *osCustomizationContext) error {
+				return checkForInstalledKernel(c.imageChroot)
+			},
+		},
 	}
+}
 
-	err = addCustomizerRelease(imageChroot, ToolVersion, buildTime, imageUuid)
-	if err != nil {
-		return err
+// doOsCustomizations applies the OS customizations of the config to the image. If the package stage has already been
+// run (see runPackageStage), then the packages aren't customized again.
+//
+// Returns the names of the orphaned packages that were removed.
+func doOsCustomizations(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
+	imageConnection *ImageConnection, rpmsSources []string, useBaseImageRpmRepos bool, partitionsCustomized bool,
+	partIdToPartUuid map[string]string, stage *packageStage, imageUuid string, phaseValidators []PhaseValidator,
+	selinuxReportBuilder *selinuxReportBuilder,
+) ([]string, error) {
+	c := &osCustomizationContext{
+		buildDir:             buildDir,
+		baseConfigPath:       baseConfigPath,
+		config:               config,
+		imageConnection:      imageConnection,
+		imageChroot:          imageConnection.Chroot(),
+		rpmsSources:          rpmsSources,
+		useBaseImageRpmRepos: useBaseImageRpmRepos,
+		partitionsCustomized: partitionsCustomized,
+		partIdToPartUuid:     partIdToPartUuid,
+		stage:                stage,
+		imageUuid:            imageUuid,
+		phaseValidators:      phaseValidators,
+		selinuxReportBuilder: selinuxReportBuilder,
+		buildTime:            time.Now().Format("2006-01-02T15:04:05Z"),
 	}
 
-	err = handleBootLoader(baseConfigPath, config, imageConnection)
-	if err != nil {
-		return err
+	for _, stage := range osCustomizationStages() {
+		err := stage.run(c)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	selinuxMode, err := handleSELinux(config.OS.SELinux.Mode, config.OS.ResetBootLoaderType,
-		imageChroot)
-	if err != nil {
-		return err
-	}
+	return c.orphansRemoved, nil
+}
 
-	overlayUpdated, err := enableOverlays(config.OS.Overlays, selinuxMode, imageChroot)
-	if err != nil {
-		return err
-	}
+func customizePackagesStage(c *osCustomizationContext) error {
+	var err error
 
-	verityUpdated, err := enableVerityPartition(config.Storage.Verity, imageChroot)
-	if err != nil {
-		return err
+	// The modules that are added or replaced from here on (e.g. by packages or DKMS) are signed.
+	if c.stage != nil {
+		c.baseKernelModules = c.stage.BaseKernelModules
+		c.orphansRemoved = c.stage.OrphansRemoved
+		return nil
 	}
 
-	if partitionsCustomized || overlayUpdated || verityUpdated {
-		err = regenerateInitrd(imageChroot)
+	if getModuleSigning(c.config.OS) != nil {
+		c.baseKernelModules, err = listKernelModules(c.imageChroot.RootDir())
 		if err != nil {
 			return err
 		}
 	}
 
-	err = runUserScripts(baseConfigPath, config.Scripts.PostCustomization, "postCustomization", imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = restoreResolvConf(resolvConf, imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = selinuxSetFiles(selinuxMode, imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = runUserScripts(baseConfigPath, config.Scripts.FinalizeCustomization, "finalizeCustomization", imageChroot)
-	if err != nil {
-		return err
-	}
-
-	err = checkForInstalledKernel(imageChroot)
+	c.orphansRemoved, err = addRemoveAndUpdatePackages(c.buildDir, c.baseConfigPath, c.config.OS, c.imageChroot,
+		c.rpmsSources, c.useBaseImageRpmRepos)
 	if err != nil {
 		return err
 	}
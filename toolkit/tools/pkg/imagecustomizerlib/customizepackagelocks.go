@@ -0,0 +1,174 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+)
+
+const (
+	tdnfLocksDir               = "/etc/tdnf/locks.d"
+	dnfConfigDir               = "/etc/dnf"
+	dnfVersionLockListFilePath = "/etc/dnf/plugins/versionlock.list"
+
+	packageLocksFileName = "imagecustomizer.conf"
+	packageLocksHeader   = "# Generated by the Azure Linux Image Customizer.\n"
+)
+
+// lockPackageVersions verifies that the locked packages were installed with the requested versions and then writes
+// the package manager config that prevents those packages from being changed.
+func lockPackageVersions(packageLocks []imagecustomizerapi.PackageLock, imageChroot *safechroot.Chroot) error {
+	if len(packageLocks) <= 0 {
+		return nil
+	}
+
+	logger.Log.Infof("Locking package versions")
+
+	installedPackages, err := getInstalledPackages(imageChroot)
+	if err != nil {
+		return fmt.Errorf("failed to get installed packages:\n%w", err)
+	}
+
+	versionLocks, err := checkPackageLocks(packageLocks, installedPackages)
+	if err != nil {
+		return err
+	}
+
+	err = writePackageLockFiles(packageLocks, versionLocks, imageChroot.RootDir())
+	if err != nil {
+		return fmt.Errorf("failed to write package lock files:\n%w", err)
+	}
+
+	return nil
+}
+
+// checkPackageLocks ensures each locked package is installed with the requested version.
+// installedPackages is a map of "name.arch" to "epoch:version-release".
+// Returns the dnf versionlock entries of the locked packages.
+func checkPackageLocks(packageLocks []imagecustomizerapi.PackageLock, installedPackages map[string]string,
+) ([]string, error) {
+	versionLocks := []string(nil)
+	mismatches := []string(nil)
+
+	for _, packageLock := range packageLocks {
+		found := false
+		for nameArch, installedVersion := range installedPackages {
+			name, arch := splitPackageNameArch(nameArch)
+			if name != packageLock.Name {
+				continue
+			}
+
+			found = true
+
+			if !packageVersionMatches(packageLock.Version, installedVersion) {
+				mismatches = append(mismatches, fmt.Sprintf("%s: requested (%s), installed (%s)", nameArch,
+					packageLock.Version, installedVersion))
+				continue
+			}
+
+			versionLocks = append(versionLocks, fmt.Sprintf("%s-%s.%s", name, installedVersion, arch))
+		}
+
+		if !found {
+			mismatches = append(mismatches, fmt.Sprintf("%s: requested (%s), not installed", packageLock.Name,
+				packageLock.Version))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return nil, fmt.Errorf("locked packages don't have the requested versions:\n%s", strings.Join(mismatches, "\n"))
+	}
+
+	sort.Strings(versionLocks)
+	return versionLocks, nil
+}
+
+// splitPackageNameArch splits a "name.arch" string. Package names may contain '.' but the arch never does.
+func splitPackageNameArch(nameArch string) (string, string) {
+	index := strings.LastIndex(nameArch, ".")
+	if index < 0 {
+		return nameArch, ""
+	}
+
+	return nameArch[:index], nameArch[index+1:]
+}
+
+// packageVersionMatches checks if an installed "epoch:version-release" matches a requested
+// "[epoch:]version[-release]". The epoch and release are only compared if they were requested.
+func packageVersionMatches(requested string, installed string) bool {
+	installedEpoch, installedVersionRelease, _ := strings.Cut(installed, ":")
+	installedVersion, installedRelease, _ := strings.Cut(installedVersionRelease, "-")
+
+	requestedVersionRelease := requested
+	if epoch, versionRelease, found := strings.Cut(requested, ":"); found {
+		if epoch != installedEpoch {
+			return false
+		}
+		requestedVersionRelease = versionRelease
+	}
+
+	requestedVersion, requestedRelease, hasRelease := strings.Cut(requestedVersionRelease, "-")
+	if requestedVersion != installedVersion {
+		return false
+	}
+
+	if hasRelease && requestedRelease != installedRelease {
+		return false
+	}
+
+	return true
+}
+
+// writePackageLockFiles writes the tdnf locks file and, if dnf is configured in the image, the dnf versionlock list.
+func writePackageLockFiles(packageLocks []imagecustomizerapi.PackageLock, versionLocks []string,
+	imageRootDir string,
+) error {
+	names := []string(nil)
+	for _, packageLock := range packageLocks {
+		names = append(names, packageLock.Name)
+	}
+
+	locksFilePath := filepath.Join(imageRootDir, tdnfLocksDir, packageLocksFileName)
+
+	err := os.MkdirAll(filepath.Dir(locksFilePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = file.Write(packageLocksHeader+strings.Join(names, "\n")+"\n", locksFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write tdnf locks file (%s):\n%w", locksFilePath, err)
+	}
+
+	dnfConfigDirExists, err := file.DirExists(filepath.Join(imageRootDir, dnfConfigDir))
+	if err != nil {
+		return err
+	}
+
+	if dnfConfigDirExists {
+		versionLockListFilePath := filepath.Join(imageRootDir, dnfVersionLockListFilePath)
+
+		err = os.MkdirAll(filepath.Dir(versionLockListFilePath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		err = file.Write(packageLocksHeader+strings.Join(versionLocks, "\n")+"\n", versionLockListFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to write dnf versionlock list (%s):\n%w", versionLockListFilePath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageVersionMatches(t *testing.T) {
+	assert.True(t, packageVersionMatches("3.3.2", "0:3.3.2-1.azl3"))
+	assert.True(t, packageVersionMatches("3.3.2-1.azl3", "0:3.3.2-1.azl3"))
+	assert.True(t, packageVersionMatches("0:3.3.2-1.azl3", "0:3.3.2-1.azl3"))
+	assert.True(t, packageVersionMatches("1:3.3.2", "1:3.3.2-1.azl3"))
+
+	assert.False(t, packageVersionMatches("3.3", "0:3.3.2-1.azl3"))
+	assert.False(t, packageVersionMatches("3.3.2-2.azl3", "0:3.3.2-1.azl3"))
+	assert.False(t, packageVersionMatches("1:3.3.2", "0:3.3.2-1.azl3"))
+}
+
+func TestCheckPackageLocks(t *testing.T) {
+	installedPackages := map[string]string{
+		"openssl.x86_64":    "0:3.3.2-1.azl3",
+		"glibc.x86_64":      "0:2.38-8.azl3",
+		"glibc.i686":        "0:2.38-8.azl3",
+		"python3.12.x86_64": "0:3.12.3-4.azl3",
+	}
+
+	packageLocks := []imagecustomizerapi.PackageLock{
+		{Name: "openssl", Version: "3.3.2-1.azl3"},
+		{Name: "glibc", Version: "2.38"},
+		{Name: "python3.12", Version: "3.12.3"},
+	}
+
+	versionLocks, err := checkPackageLocks(packageLocks, installedPackages)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"glibc-0:2.38-8.azl3.i686",
+		"glibc-0:2.38-8.azl3.x86_64",
+		"openssl-0:3.3.2-1.azl3.x86_64",
+		"python3.12-0:3.12.3-4.azl3.x86_64",
+	}, versionLocks)
+}
+
+func TestCheckPackageLocksMismatch(t *testing.T) {
+	installedPackages := map[string]string{
+		"openssl.x86_64": "0:3.3.3-1.azl3",
+	}
+
+	packageLocks := []imagecustomizerapi.PackageLock{
+		{Name: "openssl", Version: "3.3.2"},
+		{Name: "jq", Version: "1.7.1"},
+	}
+
+	_, err := checkPackageLocks(packageLocks, installedPackages)
+	assert.ErrorContains(t, err, "locked packages don't have the requested versions:\n"+
+		"jq: requested (1.7.1), not installed\n"+
+		"openssl.x86_64: requested (3.3.2), installed (0:3.3.3-1.azl3)")
+}
+
+func TestWritePackageLockFiles(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestWritePackageLockFiles")
+	rootDir := filepath.Join(testTmpDir, "root")
+
+	err := os.RemoveAll(testTmpDir)
+	assert.NoError(t, err)
+
+	packageLocks := []imagecustomizerapi.PackageLock{
+		{Name: "openssl", Version: "3.3.2"},
+	}
+	versionLocks := []string{"openssl-0:3.3.2-1.azl3.x86_64"}
+
+	// dnf isn't configured in the image.
+	err = writePackageLockFiles(packageLocks, versionLocks, rootDir)
+	assert.NoError(t, err)
+
+	locks, err := file.Read(filepath.Join(rootDir, tdnfLocksDir, packageLocksFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, packageLocksHeader+"openssl\n", locks)
+
+	exists, _ := file.PathExists(filepath.Join(rootDir, dnfVersionLockListFilePath))
+	assert.False(t, exists)
+
+	// dnf is configured in the image.
+	err = os.MkdirAll(filepath.Join(rootDir, dnfConfigDir), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = writePackageLockFiles(packageLocks, versionLocks, rootDir)
+	assert.NoError(t, err)
+
+	versionLockList, err := file.Read(filepath.Join(rootDir, dnfVersionLockListFilePath))
+	assert.NoError(t, err)
+	assert.Equal(t, packageLocksHeader+"openssl-0:3.3.2-1.azl3.x86_64\n", versionLockList)
+}
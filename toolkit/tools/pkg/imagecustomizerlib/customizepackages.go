@@ -14,6 +14,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
 	"github.com/sirupsen/logrus"
 )
 
@@ -33,71 +34,120 @@ type packageInformation struct {
 	distroVersion  uint32
 }
 
+// addRemoveAndUpdatePackages removes, updates, and installs packages.
+//
+// Returns the names of the orphaned packages that were removed, if 'removeOrphans' is enabled.
 func addRemoveAndUpdatePackages(buildDir string, baseConfigPath string, config *imagecustomizerapi.OS,
 	imageChroot *safechroot.Chroot, rpmsSources []string, useBaseImageRpmRepos bool,
-) error {
+) ([]string, error) {
 	var err error
 
+	packagesTimestamp, _ := timestamp.StartEvent("packages", nil)
+	defer timestamp.StopEvent(packagesTimestamp)
+
 	// Note: The 'validatePackageLists' function read the PackageLists files and merged them into the inline package lists.
 	needRpmsSources := len(config.Packages.Install) > 0 || len(config.Packages.Update) > 0 ||
-		config.Packages.UpdateExistingPackages
+		config.Packages.UpdateExistingPackages || config.Gpu != nil
 
 	var mounts *rpmSourcesMounts
 	if needRpmsSources {
 		// Mount RPM sources.
-		mounts, err = mountRpmSources(buildDir, imageChroot, rpmsSources, useBaseImageRpmRepos)
+		mounts, err = mountRpmSources(buildDir, baseConfigPath, imageChroot, rpmsSources,
+			config.Packages.LocalRepos, useBaseImageRpmRepos)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer mounts.close()
 
 		// Refresh metadata.
 		err = refreshTdnfMetadata(imageChroot)
 		if err != nil {
-			return err
+			return nil, err
+		}
+	}
+
+	// The requirements of the packages must be recorded before they are removed.
+	var orphanCandidates map[string]bool
+	var userInstalledPackages []string
+	if config.Packages.RemoveOrphans && len(config.Packages.Remove) > 0 {
+		orphanCandidates, err = getPackageDependencies(config.Packages.Remove, imageChroot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find dependencies of packages to remove:\n%w", err)
+		}
+
+		userInstalledPackages, err = getUserInstalledPackages(imageChroot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Packages.Remove) > 0 {
+		err = checkProtectedPackagesRemoval(config.Packages.Remove, config.Packages.ProtectedPackages, imageChroot)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	err = removePackages(config.Packages.Remove, imageChroot)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if config.Packages.UpdateExistingPackages {
 		err = updateAllPackages(imageChroot)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	logger.Log.Infof("Installing packages: %v", config.Packages.Install)
 	err = installOrUpdatePackages("install", config.Packages.Install, imageChroot)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	logger.Log.Infof("Updating packages: %v", config.Packages.Update)
 	err = installOrUpdatePackages("update", config.Packages.Update, imageChroot)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Install the GPU driver after the packages are updated, so that it matches the final kernel.
+	gpuPackages, err := installGpuDriver(config.Gpu, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the orphans after the packages are installed and updated, so that the dependencies of the new packages are
+	// kept.
+	var orphansRemoved []string
+	if orphanCandidates != nil {
+		keepPackages := append(append([]string(nil), config.Packages.Install...), config.Packages.Update...)
+		keepPackages = append(keepPackages, config.Packages.ProtectedPackages...)
+		keepPackages = append(keepPackages, gpuPackages...)
+		keepPackages = append(keepPackages, userInstalledPackages...)
+		orphansRemoved, err = removeOrphanedPackages(orphanCandidates, keepPackages, imageChroot)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Unmount RPM sources.
 	if mounts != nil {
 		err = mounts.close()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	if needRpmsSources {
 		err = cleanTdnfCache(imageChroot)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return orphansRemoved, nil
 }
 
 func refreshTdnfMetadata(imageChroot *safechroot.Chroot) error {
@@ -166,11 +216,16 @@ func updateAllPackages(imageChroot *safechroot.Chroot) error {
 	logger.Log.Infof("Updating base image packages")
 
 	tdnfUpdateArgs := []string{
-		"-v", "update", "--nogpgcheck", "--assumeyes", "--cacheonly",
+		"-v", "update", "--assumeyes", "--cacheonly",
 		"--setopt", fmt.Sprintf("reposdir=%s", rpmsMountParentDirInChroot),
 	}
 
-	err := callTdnf(tdnfUpdateArgs, tdnfInstallPrefix, imageChroot)
+	err := checkPackageFileConflicts(tdnfUpdateArgs, imageChroot)
+	if err != nil {
+		return fmt.Errorf("failed to update packages:\n%w", err)
+	}
+
+	err = callTdnf(tdnfUpdateArgs, tdnfInstallPrefix, imageChroot)
 	if err != nil {
 		return fmt.Errorf("failed to update packages:\n%w", err)
 	}
@@ -179,11 +234,15 @@ func updateAllPackages(imageChroot *safechroot.Chroot) error {
 }
 
 func installOrUpdatePackages(action string, allPackagesToAdd []string, imageChroot *safechroot.Chroot) error {
+	actionTimestamp, _ := timestamp.StartEvent(action+" packages", nil)
+	defer timestamp.StopEvent(actionTimestamp)
+
 	// Create tdnf command args.
 	// Note: When using `--repofromdir`, tdnf will not use any default repos and will only use the last
 	// `--repofromdir` specified.
+	// Note: GPG checks are configured per repo in the allrepos.repo file.
 	tdnfInstallArgs := []string{
-		"-v", action, "--nogpgcheck", "--assumeyes", "--cacheonly",
+		"-v", action, "--assumeyes", "--cacheonly",
 		"--setopt", fmt.Sprintf("reposdir=%s", rpmsMountParentDirInChroot),
 		// Placeholder for package name.
 		"",
@@ -194,7 +253,12 @@ func installOrUpdatePackages(action string, allPackagesToAdd []string, imageChro
 	for _, packageName := range allPackagesToAdd {
 		tdnfInstallArgs[len(tdnfInstallArgs)-1] = packageName
 
-		err := callTdnf(tdnfInstallArgs, tdnfInstallPrefix, imageChroot)
+		err := checkPackageFileConflicts(tdnfInstallArgs, imageChroot)
+		if err != nil {
+			return fmt.Errorf("failed to %s package (%s):\n%w", action, packageName, err)
+		}
+
+		err = callTdnf(tdnfInstallArgs, tdnfInstallPrefix, imageChroot)
 		if err != nil {
 			return fmt.Errorf("failed to %s package (%s):\n%w", action, packageName, err)
 		}
@@ -23,12 +23,18 @@ func customizePartitionsUsingFileCopy(buildDir string, baseConfigPath string, co
 
 	diskConfig := config.Storage.Disks[0]
 
+	// The partition types depend on the architecture of the image, rather than the host's.
+	imageArch, err := getImageArch(existingImageConnection.Chroot().RootDir())
+	if err != nil {
+		return nil, err
+	}
+
 	installOSFunc := func(imageChroot *safechroot.Chroot) error {
 		return copyFilesIntoNewDisk(existingImageConnection.Chroot(), imageChroot)
 	}
 
 	partIdToPartUuid, err := createNewImage(newBuildImageFile, diskConfig, config.Storage.FileSystems,
-		buildDir, "newimageroot", installOSFunc)
+		buildDir, "newimageroot", imageArch, installOSFunc)
 	if err != nil {
 		return nil, err
 	}
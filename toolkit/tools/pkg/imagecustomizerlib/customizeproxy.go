@@ -0,0 +1,221 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	proxyProfileScriptPath      = "/etc/profile.d/proxy.sh"
+	proxySystemdManagerConfPath = "/etc/systemd/system.conf.d/10-proxy.conf"
+	proxyCaAnchorsDir           = "/etc/pki/ca-trust/source/anchors"
+	updateCaTrustBinPath        = "/usr/bin/update-ca-trust"
+)
+
+// proxyEnvironmentVariable is an environment variable that tells programs to use the proxy.
+type proxyEnvironmentVariable struct {
+	name  string
+	value string
+}
+
+// customizeProxy configures the image to use the proxy. The proxy's environment variables are set for login shells
+// (profile.d) and for the services (systemd manager's default environment). The proxy's CA certificates are added to
+// the system's trust store.
+func customizeProxy(baseConfigPath string, proxy *imagecustomizerapi.Proxy, imageChroot *safechroot.Chroot) error {
+	if proxy == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Configuring proxy")
+
+	variables := getProxyEnvironmentVariables(proxy)
+	if len(variables) > 0 {
+		profileScriptPath := filepath.Join(imageChroot.RootDir(), proxyProfileScriptPath)
+		err := os.MkdirAll(filepath.Dir(profileScriptPath), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create profile.d directory:\n%w", err)
+		}
+
+		err = file.WriteAtomicWithPerm(generateProxyProfileScript(variables), profileScriptPath, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write proxy profile script (%s):\n%w", proxyProfileScriptPath, err)
+		}
+
+		managerConfPath := filepath.Join(imageChroot.RootDir(), proxySystemdManagerConfPath)
+		err = os.MkdirAll(filepath.Dir(managerConfPath), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create systemd manager config directory:\n%w", err)
+		}
+
+		err = file.WriteAtomicWithPerm(generateProxySystemdManagerConf(variables), managerConfPath, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write systemd manager config (%s):\n%w", proxySystemdManagerConfPath, err)
+		}
+	}
+
+	err := addProxyCaCertificates(baseConfigPath, proxy.CaCertificatePaths, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getProxyEnvironmentVariables returns the environment variables for the proxy. Programs disagree on whether the
+// variable names are lowercase or uppercase. So, both are set.
+func getProxyEnvironmentVariables(proxy *imagecustomizerapi.Proxy) []proxyEnvironmentVariable {
+	variables := []proxyEnvironmentVariable(nil)
+	addVariable := func(name string, value string) {
+		if value == "" {
+			return
+		}
+
+		variables = append(variables,
+			proxyEnvironmentVariable{name: name, value: value},
+			proxyEnvironmentVariable{name: strings.ToUpper(name), value: value},
+		)
+	}
+
+	addVariable("http_proxy", proxy.HttpProxy)
+	addVariable("https_proxy", proxy.HttpsProxy)
+	addVariable("no_proxy", strings.Join(proxy.NoProxy, ","))
+	return variables
+}
+
+// redactProxyUrl hides the password of the proxy's URL (if any), so that the URL can be logged.
+func redactProxyUrl(value string) string {
+	proxyUrl, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	return proxyUrl.Redacted()
+}
+
+func generateProxyProfileScript(variables []proxyEnvironmentVariable) string {
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+	}
+
+	for _, variable := range variables {
+		lines = append(lines, fmt.Sprintf("export %s=%s", variable.name, shellQuote(variable.value)))
+	}
+
+	lines = append(lines, "")
+	return strings.Join(lines, "\n")
+}
+
+// generateProxySystemdManagerConf generates the systemd manager config that sets the proxy's environment variables
+// for all the services. The values were validated to not contain any quotes or whitespace.
+func generateProxySystemdManagerConf(variables []proxyEnvironmentVariable) string {
+	assignments := []string(nil)
+	for _, variable := range variables {
+		assignments = append(assignments, fmt.Sprintf("\"%s=%s\"", variable.name, variable.value))
+	}
+
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Manager]",
+		"DefaultEnvironment=" + strings.Join(assignments, " "),
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addProxyCaCertificates adds the CA certificates to the system's trust store.
+func addProxyCaCertificates(baseConfigPath string, caCertificatePaths []string, imageChroot *safechroot.Chroot,
+) error {
+	if len(caCertificatePaths) <= 0 {
+		return nil
+	}
+
+	updateCaTrustExists, err := file.PathExists(filepath.Join(imageChroot.RootDir(), updateCaTrustBinPath))
+	if err != nil {
+		return fmt.Errorf("failed to check for (%s):\n%w", updateCaTrustBinPath, err)
+	}
+
+	if !updateCaTrustExists {
+		return fmt.Errorf("the 'ca-certificates' package must be installed to add the proxy's CA certificates")
+	}
+
+	anchorsDir := filepath.Join(imageChroot.RootDir(), proxyCaAnchorsDir)
+	err = os.MkdirAll(anchorsDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create CA anchors directory:\n%w", err)
+	}
+
+	for i, caCertificatePath := range caCertificatePaths {
+		sourcePath := file.GetAbsPathWithBase(baseConfigPath, caCertificatePath)
+
+		certificatesPem, err := readProxyCaCertificates(sourcePath)
+		if err != nil {
+			return err
+		}
+
+		anchorPath := filepath.Join(anchorsDir, fmt.Sprintf("proxy-ca-%d.pem", i))
+		err = file.WriteAtomicWithPerm(certificatesPem, anchorPath, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write proxy CA certificate (%s):\n%w", anchorPath, err)
+		}
+	}
+
+	err = imageChroot.UnsafeRun(func() error {
+		return shell.ExecuteLiveWithErr(1, updateCaTrustBinPath, "extract")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update CA trust store:\n%w", err)
+	}
+
+	return nil
+}
+
+// readProxyCaCertificates reads a PEM file of one or more CA certificates and returns the certificates as PEM. Any
+// other content of the file (e.g. comments) is dropped.
+func readProxyCaCertificates(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read proxy CA certificate (%s):\n%w", path, err)
+	}
+
+	builder := strings.Builder{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			return "", fmt.Errorf("proxy CA certificate (%s) has unexpected PEM type (%s)", path, block.Type)
+		}
+
+		_, err = x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("invalid proxy CA certificate (%s):\n%w", path, err)
+		}
+
+		err = pem.Encode(&builder, block)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode proxy CA certificate (%s):\n%w", path, err)
+		}
+	}
+
+	if builder.Len() <= 0 {
+		return "", fmt.Errorf("proxy CA certificate (%s) doesn't contain any PEM certificates", path)
+	}
+
+	return builder.String(), nil
+}
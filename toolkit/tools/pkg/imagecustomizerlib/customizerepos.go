@@ -0,0 +1,165 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	reposDir          = "/etc/yum.repos.d"
+	repoFileExtension = ".repo"
+)
+
+// customizeRepos configures the package repos that ship in the OS image.
+//
+// This runs after the package customizations, so it has no effect on the repos used to install packages.
+func customizeRepos(reposConfig *imagecustomizerapi.Repos, imageRootDir string) error {
+	if reposConfig == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Configuring repos")
+
+	reposFullDir := filepath.Join(imageRootDir, reposDir)
+
+	err := updateExistingRepoFiles(reposConfig.Existing, reposFullDir)
+	if err != nil {
+		return fmt.Errorf("failed to update existing repo files:\n%w", err)
+	}
+
+	if len(reposConfig.Add) > 0 {
+		err = os.MkdirAll(reposFullDir, os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to create repos directory (%s):\n%w", reposDir, err)
+		}
+	}
+
+	for _, repo := range reposConfig.Add {
+		repoFilePath := filepath.Join(reposFullDir, repo.Id+repoFileExtension)
+
+		logger.Log.Debugf("Adding repo (%s)", repo.Id)
+
+		err = writeRepoFile(repo, repoFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to write repo file for repo (%s):\n%w", repo.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func updateExistingRepoFiles(mode imagecustomizerapi.ExistingReposMode, reposFullDir string) error {
+	switch mode {
+	case imagecustomizerapi.ExistingReposModeDisable, imagecustomizerapi.ExistingReposModeRemove:
+		// Continue.
+
+	default:
+		return nil
+	}
+
+	repoFilePaths, err := filepath.Glob(filepath.Join(reposFullDir, "*"+repoFileExtension))
+	if err != nil {
+		return err
+	}
+
+	for _, repoFilePath := range repoFilePaths {
+		switch mode {
+		case imagecustomizerapi.ExistingReposModeRemove:
+			logger.Log.Debugf("Removing repo file (%s)", filepath.Base(repoFilePath))
+
+			err = os.Remove(repoFilePath)
+			if err != nil {
+				return err
+			}
+
+		case imagecustomizerapi.ExistingReposModeDisable:
+			logger.Log.Debugf("Disabling repos in repo file (%s)", filepath.Base(repoFilePath))
+
+			err = disableRepoFile(repoFilePath)
+			if err != nil {
+				return fmt.Errorf("failed to disable repos in (%s):\n%w", repoFilePath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func disableRepoFile(repoFilePath string) error {
+	repoFile, err := ini.Load(repoFilePath)
+	if err != nil {
+		return err
+	}
+
+	for _, section := range repoFile.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+
+		section.Key("enabled").SetValue("0")
+	}
+
+	return repoFile.SaveTo(repoFilePath)
+}
+
+func writeRepoFile(repo imagecustomizerapi.Repo, repoFilePath string) error {
+	repoFile := ini.Empty()
+
+	section, err := repoFile.NewSection(repo.Id)
+	if err != nil {
+		return err
+	}
+
+	name := repo.Name
+	if name == "" {
+		name = repo.Id
+	}
+	section.Key("name").SetValue(name)
+
+	if repo.BaseUrl != "" {
+		section.Key("baseurl").SetValue(repo.BaseUrl)
+	}
+
+	if repo.MetaLink != "" {
+		section.Key("metalink").SetValue(repo.MetaLink)
+	}
+
+	section.Key("enabled").SetValue(repoBoolValue(repo.Enabled, true))
+	section.Key("gpgcheck").SetValue(repoBoolValue(repo.GpgCheck, true))
+
+	if len(repo.GpgKeys) > 0 {
+		section.Key("gpgkey").SetValue(strings.Join(repo.GpgKeys, " "))
+	}
+
+	exists, err := file.PathExists(repoFilePath)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		logger.Log.Debugf("Replacing existing repo file (%s)", filepath.Base(repoFilePath))
+	}
+
+	return repoFile.SaveTo(repoFilePath)
+}
+
+func repoBoolValue(value *bool, defaultValue bool) string {
+	if value != nil {
+		defaultValue = *value
+	}
+
+	if defaultValue {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	testBuildRepoFileContents = "[internal-mirror]\nname=Internal mirror\nbaseurl=https://mirror.internal/base\nenabled=1\n\n" +
+		"[internal-mirror-extended]\nname=Internal mirror extended\nbaseurl=https://mirror.internal/extended\n"
+)
+
+func writeTestRepoFile(t *testing.T, rootDir string) string {
+	repoFilePath := filepath.Join(rootDir, reposDir, "internal.repo")
+	err := os.MkdirAll(filepath.Dir(repoFilePath), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = file.Write(testBuildRepoFileContents, repoFilePath)
+	assert.NoError(t, err)
+
+	return repoFilePath
+}
+
+func TestCustomizeReposDisableExisting(t *testing.T) {
+	rootDir := filepath.Join(tmpDir, "TestCustomizeReposDisableExisting")
+	defer os.RemoveAll(rootDir)
+
+	repoFilePath := writeTestRepoFile(t, rootDir)
+
+	reposConfig := &imagecustomizerapi.Repos{
+		Existing: imagecustomizerapi.ExistingReposModeDisable,
+	}
+
+	err := customizeRepos(reposConfig, rootDir)
+	assert.NoError(t, err)
+
+	repoFile, err := ini.Load(repoFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", repoFile.Section("internal-mirror").Key("enabled").String())
+	assert.Equal(t, "0", repoFile.Section("internal-mirror-extended").Key("enabled").String())
+	assert.Equal(t, "https://mirror.internal/base", repoFile.Section("internal-mirror").Key("baseurl").String())
+}
+
+func TestCustomizeReposRemoveExistingAndAdd(t *testing.T) {
+	rootDir := filepath.Join(tmpDir, "TestCustomizeReposRemoveExistingAndAdd")
+	defer os.RemoveAll(rootDir)
+
+	repoFilePath := writeTestRepoFile(t, rootDir)
+
+	reposConfig := &imagecustomizerapi.Repos{
+		Existing: imagecustomizerapi.ExistingReposModeRemove,
+		Add: []imagecustomizerapi.Repo{
+			{
+				Id:      "prod-base",
+				BaseUrl: "https://packages.example.com/$releasever/base/$basearch",
+				GpgKeys: []string{"file:///etc/pki/rpm-gpg/a", "file:///etc/pki/rpm-gpg/b"},
+			},
+			{
+				Id:       "prod-extras",
+				Name:     "Extras",
+				MetaLink: "https://packages.example.com/metalink?repo=extras",
+				Enabled:  ptrutils.PtrTo(false),
+				GpgCheck: ptrutils.PtrTo(false),
+			},
+		},
+	}
+
+	err := customizeRepos(reposConfig, rootDir)
+	assert.NoError(t, err)
+
+	exists, err := file.PathExists(repoFilePath)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	baseRepoFile, err := ini.Load(filepath.Join(rootDir, reposDir, "prod-base.repo"))
+	assert.NoError(t, err)
+
+	baseSection := baseRepoFile.Section("prod-base")
+	assert.Equal(t, "prod-base", baseSection.Key("name").String())
+	assert.Equal(t, "https://packages.example.com/$releasever/base/$basearch", baseSection.Key("baseurl").String())
+	assert.Equal(t, "1", baseSection.Key("enabled").String())
+	assert.Equal(t, "1", baseSection.Key("gpgcheck").String())
+	assert.Equal(t, "file:///etc/pki/rpm-gpg/a file:///etc/pki/rpm-gpg/b", baseSection.Key("gpgkey").String())
+
+	extrasRepoFile, err := ini.Load(filepath.Join(rootDir, reposDir, "prod-extras.repo"))
+	assert.NoError(t, err)
+
+	extrasSection := extrasRepoFile.Section("prod-extras")
+	assert.Equal(t, "Extras", extrasSection.Key("name").String())
+	assert.Equal(t, "https://packages.example.com/metalink?repo=extras", extrasSection.Key("metalink").String())
+	assert.False(t, extrasSection.HasKey("baseurl"))
+	assert.Equal(t, "0", extrasSection.Key("enabled").String())
+	assert.Equal(t, "0", extrasSection.Key("gpgcheck").String())
+}
+
+func TestCustomizeReposKeepExisting(t *testing.T) {
+	rootDir := filepath.Join(tmpDir, "TestCustomizeReposKeepExisting")
+	defer os.RemoveAll(rootDir)
+
+	repoFilePath := writeTestRepoFile(t, rootDir)
+
+	err := customizeRepos(&imagecustomizerapi.Repos{}, rootDir)
+	assert.NoError(t, err)
+
+	contents, err := file.Read(repoFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, testBuildRepoFileContents, contents)
+}
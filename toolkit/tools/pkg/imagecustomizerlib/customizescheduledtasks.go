@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+)
+
+const (
+	scheduledTaskUnitDirPath = "/etc/systemd/system"
+)
+
+// addScheduledTasks adds a systemd timer and service unit pair for each of the scheduled tasks and enables the
+// timers.
+func addScheduledTasks(scheduledTasks []imagecustomizerapi.ScheduledTask, imageChroot *safechroot.Chroot) error {
+	for _, scheduledTask := range scheduledTasks {
+		err := addScheduledTask(scheduledTask, imageChroot)
+		if err != nil {
+			return fmt.Errorf("failed to add scheduled task (%s):\n%w", scheduledTask.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func addScheduledTask(scheduledTask imagecustomizerapi.ScheduledTask, imageChroot *safechroot.Chroot) error {
+	logger.Log.Infof("Adding scheduled task (%s)", scheduledTask.Name)
+
+	if scheduledTask.User != "" {
+		userExists, err := userutils.UserExists(scheduledTask.User, imageChroot)
+		if err != nil {
+			return err
+		}
+
+		if !userExists {
+			return fmt.Errorf("user (%s) doesn't exist", scheduledTask.User)
+		}
+	}
+
+	serviceName := scheduledTask.Name + ".service"
+	timerName := scheduledTask.Name + ".timer"
+
+	serviceFilePath := filepath.Join(imageChroot.RootDir(), scheduledTaskUnitDirPath, serviceName)
+	err := file.Write(generateScheduledTaskService(scheduledTask), serviceFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write service file (%s):\n%w", serviceFilePath, err)
+	}
+
+	timerFilePath := filepath.Join(imageChroot.RootDir(), scheduledTaskUnitDirPath, timerName)
+	err = file.Write(generateScheduledTaskTimer(scheduledTask), timerFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write timer file (%s):\n%w", timerFilePath, err)
+	}
+
+	// Catch invalid schedules now, instead of when the timer is first started.
+	err = imageChroot.UnsafeRun(func() error {
+		_, stderr, err := shell.Execute("systemd-analyze", "calendar", scheduledTask.Schedule)
+		if err != nil {
+			return fmt.Errorf("invalid schedule (%s):\n%s\n%w", scheduledTask.Schedule, strings.TrimSpace(stderr), err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = imageChroot.UnsafeRun(func() error {
+		return shell.ExecuteLiveWithErr(1, "systemctl", "enable", timerName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable timer (%s):\n%w", timerName, err)
+	}
+
+	return nil
+}
+
+// generateScheduledTaskService generates the service that runs the scheduled task's command.
+func generateScheduledTaskService(scheduledTask imagecustomizerapi.ScheduledTask) string {
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Unit]",
+		"Description=" + scheduledTaskDescription(scheduledTask),
+		"",
+		"[Service]",
+		"Type=oneshot",
+	}
+
+	if scheduledTask.User != "" {
+		lines = append(lines, "User="+scheduledTask.User)
+	}
+
+	lines = append(lines,
+		"ExecStart=/bin/sh -c "+systemdQuote(scheduledTask.Command),
+		"",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// generateScheduledTaskTimer generates the timer that starts the scheduled task's service on the schedule.
+func generateScheduledTaskTimer(scheduledTask imagecustomizerapi.ScheduledTask) string {
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Unit]",
+		"Description=" + scheduledTaskDescription(scheduledTask) + " (timer)",
+		"",
+		"[Timer]",
+		"OnCalendar=" + scheduledTask.Schedule,
+	}
+
+	if scheduledTask.Persistent {
+		lines = append(lines, "Persistent=true")
+	}
+
+	lines = append(lines,
+		"",
+		"[Install]",
+		"WantedBy=timers.target",
+		"",
+	)
+	return strings.Join(lines, "\n")
+}
+
+func scheduledTaskDescription(scheduledTask imagecustomizerapi.ScheduledTask) string {
+	description := fmt.Sprintf("Scheduled task (%s)", scheduledTask.Name)
+	if scheduledTask.Description != "" {
+		description = scheduledTask.Description
+	}
+
+	// Don't let systemd expand '%' specifiers in the description.
+	return strings.ReplaceAll(description, "%", "%%")
+}
+
+// systemdQuote quotes a value as a single argument of a systemd Exec*= command line. Besides the shell-like quoting,
+// the '%' specifiers and the '$' environment variable references of systemd are escaped, so that the value is passed
+// as is.
+func systemdQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "%", "%%")
+	value = strings.ReplaceAll(value, "$", "$$")
+	return `"` + value + `"`
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateScheduledTaskUnits(t *testing.T) {
+	scheduledTask := imagecustomizerapi.ScheduledTask{
+		Name:       "backup-db",
+		Schedule:   "Mon *-*-* 02:00:00",
+		Command:    `pg_dump "$DB" > /var/backup/db-$(date +%F).sql`,
+		User:       "postgres",
+		Persistent: true,
+	}
+
+	assert.Equal(t, "# Generated by the Azure Linux Image Customizer.\n"+
+		"[Unit]\n"+
+		"Description=Scheduled task (backup-db)\n"+
+		"\n"+
+		"[Service]\n"+
+		"Type=oneshot\n"+
+		"User=postgres\n"+
+		`ExecStart=/bin/sh -c "pg_dump \"$$DB\" > /var/backup/db-$$(date +%%F).sql"`+"\n",
+		generateScheduledTaskService(scheduledTask))
+
+	assert.Equal(t, "# Generated by the Azure Linux Image Customizer.\n"+
+		"[Unit]\n"+
+		"Description=Scheduled task (backup-db) (timer)\n"+
+		"\n"+
+		"[Timer]\n"+
+		"OnCalendar=Mon *-*-* 02:00:00\n"+
+		"Persistent=true\n"+
+		"\n"+
+		"[Install]\n"+
+		"WantedBy=timers.target\n",
+		generateScheduledTaskTimer(scheduledTask))
+}
+
+func TestGenerateScheduledTaskUnitsDefaults(t *testing.T) {
+	scheduledTask := imagecustomizerapi.ScheduledTask{
+		Name:        "cleanup",
+		Description: "Remove 100% of the temp files",
+		Schedule:    "daily",
+		Command:     "rm -rf /var/tmp/app",
+	}
+
+	service := generateScheduledTaskService(scheduledTask)
+	assert.Contains(t, service, "Description=Remove 100%% of the temp files\n")
+	assert.NotContains(t, service, "User=")
+
+	timer := generateScheduledTaskTimer(scheduledTask)
+	assert.NotContains(t, timer, "Persistent=")
+}
@@ -0,0 +1,283 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	selfTestScriptPath        = "/usr/libexec/image-self-test"
+	selfTestServiceName       = "image-self-test.service"
+	selfTestServiceDirPath    = "/usr/lib/systemd/system"
+	selfTestResultDir         = "/var/lib/image-self-test"
+	selfTestCloudInitHookPath = "/var/lib/cloud/scripts/per-instance/image-self-test"
+	cloudInitBinPath          = "/usr/bin/cloud-init"
+
+	// How much longer than the service timeout the cloud-init hook waits for the self-test to finish.
+	selfTestCloudInitExtraWaitSeconds = 300
+)
+
+// selfTestCheck is a single check of the first-boot self-test.
+type selfTestCheck struct {
+	name string
+	// The shell command that passes if it exits with 0.
+	command string
+}
+
+// getSelfTestChecks returns the checks that the config's expectations translate to.
+func getSelfTestChecks(config *imagecustomizerapi.Config) []selfTestCheck {
+	checks := []selfTestCheck(nil)
+
+	for _, service := range config.OS.Services.Enable {
+		checks = append(checks, selfTestCheck{
+			name:    fmt.Sprintf("service (%s) is running", service),
+			command: "wait_for_service " + shellQuote(service),
+		})
+	}
+
+	for _, fileSystem := range config.Storage.FileSystems {
+		if fileSystem.MountPoint == nil || !filepath.IsAbs(fileSystem.MountPoint.Path) ||
+			hasMountOption(fileSystem.MountPoint.Options, "noauto") {
+			continue
+		}
+
+		checks = append(checks, selfTestCheck{
+			name:    fmt.Sprintf("(%s) is mounted", fileSystem.MountPoint.Path),
+			command: "findmnt --mountpoint " + shellQuote(fileSystem.MountPoint.Path),
+		})
+	}
+
+	for _, verity := range config.Storage.Verity {
+		checks = append(checks, selfTestCheck{
+			name:    fmt.Sprintf("verity device (%s) is verified", verity.Name),
+			command: "veritysetup status " + shellQuote(verity.Name) + " | grep -q 'status:.*verified'",
+		})
+	}
+
+	expectedSELinuxMode := ""
+	switch config.OS.SELinux.Mode {
+	case imagecustomizerapi.SELinuxModeEnforcing, imagecustomizerapi.SELinuxModeForceEnforcing:
+		expectedSELinuxMode = "Enforcing"
+
+	case imagecustomizerapi.SELinuxModePermissive:
+		expectedSELinuxMode = "Permissive"
+
+	case imagecustomizerapi.SELinuxModeDisabled:
+		expectedSELinuxMode = "Disabled"
+	}
+
+	if expectedSELinuxMode != "" {
+		checks = append(checks, selfTestCheck{
+			name:    fmt.Sprintf("SELinux is %s", strings.ToLower(expectedSELinuxMode)),
+			command: fmt.Sprintf("[ \"$(getenforce 2>/dev/null || echo Disabled)\" = %s ]", expectedSELinuxMode),
+		})
+	}
+
+	for _, command := range config.OS.SelfTest.Commands {
+		checks = append(checks, selfTestCheck{
+			name:    fmt.Sprintf("command (%s) succeeds", command),
+			command: command,
+		})
+	}
+
+	return checks
+}
+
+// hasMountOption returns true if the comma-separated mount options include the option.
+func hasMountOption(options string, option string) bool {
+	for _, value := range strings.Split(options, ",") {
+		if strings.TrimSpace(value) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes a value as a single-quoted shell string.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// addSelfTest adds the first-boot self-test service to the image. The results are written to the console (e.g. the
+// serial port) and, if cloud-init is installed, also reported in the cloud-init status.
+func addSelfTest(config *imagecustomizerapi.Config, imageChroot *safechroot.Chroot) error {
+	if config.OS.SelfTest == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Adding first-boot self-test")
+
+	checks := getSelfTestChecks(config)
+	serviceTimeout := config.OS.SelfTest.GetServiceTimeoutSeconds()
+
+	scriptPath := filepath.Join(imageChroot.RootDir(), selfTestScriptPath)
+	err := os.MkdirAll(filepath.Dir(scriptPath), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create self-test script directory:\n%w", err)
+	}
+
+	err = file.WriteWithPerm(generateSelfTestScript(checks, serviceTimeout), scriptPath, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to write self-test script (%s):\n%w", selfTestScriptPath, err)
+	}
+
+	serviceFilePath := filepath.Join(imageChroot.RootDir(), selfTestServiceDirPath, selfTestServiceName)
+	err = file.Write(generateSelfTestService(), serviceFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write service file (%s):\n%w", serviceFilePath, err)
+	}
+
+	err = imageChroot.UnsafeRun(func() error {
+		return shell.ExecuteLiveWithErr(1, "systemctl", "enable", selfTestServiceName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable service (%s):\n%w", selfTestServiceName, err)
+	}
+
+	cloudInitInstalled, err := file.PathExists(filepath.Join(imageChroot.RootDir(), cloudInitBinPath))
+	if err != nil {
+		return fmt.Errorf("failed to check for (%s):\n%w", cloudInitBinPath, err)
+	}
+
+	if cloudInitInstalled {
+		hookPath := filepath.Join(imageChroot.RootDir(), selfTestCloudInitHookPath)
+		err = os.MkdirAll(filepath.Dir(hookPath), 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create cloud-init scripts directory:\n%w", err)
+		}
+
+		err = file.WriteWithPerm(generateSelfTestCloudInitHook(serviceTimeout+selfTestCloudInitExtraWaitSeconds),
+			hookPath, 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to write self-test cloud-init hook (%s):\n%w", selfTestCloudInitHookPath, err)
+		}
+	}
+
+	return nil
+}
+
+// generateSelfTestScript generates the script that runs the self-test checks. Each result is written to stdout and
+// to a report file. The overall result ("pass" or "fail") is written to the result file last, which also stops the
+// self-test from running on later boots.
+func generateSelfTestScript(checks []selfTestCheck, serviceTimeout int) string {
+	lines := []string{
+		"#!/bin/sh",
+		"# Generated by the Azure Linux Image Customizer.",
+		"",
+		"result_dir=" + selfTestResultDir,
+		"deadline=$(($(date +%s) + " + strconv.Itoa(serviceTimeout) + "))",
+		"failures=0",
+		"",
+		"log() {",
+		"    echo \"image-self-test: $*\" | tee -a \"$result_dir/report\"",
+		"}",
+		"",
+		"# Waits for a service to start. A oneshot service passes once it has exited successfully.",
+		"wait_for_service() {",
+		"    while :; do",
+		"        case \"$(systemctl show --property=ActiveState --value \"$1\")\" in",
+		"        active) return 0 ;;",
+		"        failed) return 1 ;;",
+		"        inactive)",
+		"            if [ \"$(systemctl show --property=Type --value \"$1\")\" = oneshot ] &&",
+		"                [ \"$(systemctl show --property=Result --value \"$1\")\" = success ] &&",
+		"                [ \"$(systemctl show --property=ExecMainExitTimestampMonotonic --value \"$1\")\" != 0 ]; then",
+		"                return 0",
+		"            fi",
+		"            ;;",
+		"        esac",
+		"",
+		"        if [ \"$(date +%s)\" -ge \"$deadline\" ]; then",
+		"            return 1",
+		"        fi",
+		"        sleep 1",
+		"    done",
+		"}",
+		"",
+		"check() {",
+		"    if (eval \"$2\") >/dev/null 2>&1; then",
+		"        log \"PASS: $1\"",
+		"    else",
+		"        log \"FAIL: $1\"",
+		"        failures=$((failures + 1))",
+		"    fi",
+		"}",
+		"",
+		"mkdir -p \"$result_dir\"",
+		"rm -f \"$result_dir/report\"",
+		"",
+	}
+
+	for _, check := range checks {
+		lines = append(lines, "check "+shellQuote(check.name)+" "+shellQuote(check.command))
+	}
+
+	lines = append(lines,
+		"",
+		"if [ \"$failures\" -eq 0 ]; then",
+		"    log \"RESULT: pass\"",
+		"    echo pass >\"$result_dir/result\"",
+		"    exit 0",
+		"fi",
+		"",
+		"log \"RESULT: fail ($failures failed)\"",
+		"echo fail >\"$result_dir/result\"",
+		"exit 1",
+		"",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// generateSelfTestService generates the service that runs the self-test once, after the boot has finished.
+func generateSelfTestService() string {
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Unit]",
+		"Description=Image self-test",
+		"After=multi-user.target",
+		"ConditionPathExists=!" + selfTestResultDir + "/result",
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"ExecStart=" + selfTestScriptPath,
+		"StandardOutput=journal+console",
+		"",
+		"[Install]",
+		"WantedBy=multi-user.target",
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
+
+// generateSelfTestCloudInitHook generates the cloud-init per-instance script that waits for the self-test and fails
+// if the self-test failed, so that the failure shows in `cloud-init status`.
+func generateSelfTestCloudInitHook(timeout int) string {
+	lines := []string{
+		"#!/bin/sh",
+		"# Generated by the Azure Linux Image Customizer.",
+		"",
+		"result_dir=" + selfTestResultDir,
+		"deadline=$(($(date +%s) + " + strconv.Itoa(timeout) + "))",
+		"",
+		"while [ ! -f \"$result_dir/result\" ] && [ \"$(date +%s)\" -lt \"$deadline\" ]; do",
+		"    sleep 1",
+		"done",
+		"",
+		"cat \"$result_dir/report\" 2>/dev/null",
+		"[ \"$(cat \"$result_dir/result\" 2>/dev/null)\" = pass ]",
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSelfTestChecks(t *testing.T) {
+	config := &imagecustomizerapi.Config{
+		Storage: imagecustomizerapi.Storage{
+			FileSystems: []imagecustomizerapi.FileSystem{
+				{DeviceId: "root", MountPoint: &imagecustomizerapi.MountPoint{Path: "/"}},
+				{DeviceId: "data", MountPoint: &imagecustomizerapi.MountPoint{Path: "/data", Options: "defaults,noauto"}},
+				{DeviceId: "swap"},
+			},
+			Verity: []imagecustomizerapi.Verity{
+				{Id: "rootverity", Name: "root"},
+			},
+		},
+		OS: &imagecustomizerapi.OS{
+			Services: imagecustomizerapi.Services{
+				Enable: []string{"sshd"},
+			},
+			SELinux: imagecustomizerapi.SELinux{
+				Mode: imagecustomizerapi.SELinuxModeForceEnforcing,
+			},
+			SelfTest: &imagecustomizerapi.SelfTest{
+				Commands: []string{"test -f /etc/app.conf"},
+			},
+		},
+	}
+
+	checks := getSelfTestChecks(config)
+	assert.Equal(t, []selfTestCheck{
+		{name: "service (sshd) is running", command: "wait_for_service 'sshd'"},
+		{name: "(/) is mounted", command: "findmnt --mountpoint '/'"},
+		{name: "verity device (root) is verified", command: "veritysetup status 'root' | grep -q 'status:.*verified'"},
+		{name: "SELinux is enforcing", command: "[ \"$(getenforce 2>/dev/null || echo Disabled)\" = Enforcing ]"},
+		{name: "command (test -f /etc/app.conf) succeeds", command: "test -f /etc/app.conf"},
+	}, checks)
+}
+
+func TestShellQuote(t *testing.T) {
+	value := `it's a "$value"`
+
+	output, err := exec.Command("sh", "-c", "printf %s "+shellQuote(value)).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, value, string(output))
+}
+
+func TestSelfTestScript(t *testing.T) {
+	resultDir := t.TempDir()
+
+	checks := []selfTestCheck{
+		{name: "passing check", command: "true"},
+		{name: "failing check", command: "exit 3"},
+		{name: "pipeline check", command: "echo verified | grep -q verified"},
+	}
+
+	script := generateSelfTestScript(checks, 10)
+	script = strings.ReplaceAll(script, selfTestResultDir, resultDir)
+
+	scriptPath := filepath.Join(t.TempDir(), "self-test")
+	err := os.WriteFile(scriptPath, []byte(script), 0o755)
+	require.NoError(t, err)
+
+	output, err := exec.Command("sh", scriptPath).Output()
+	assert.Error(t, err)
+	assert.Contains(t, string(output), "image-self-test: PASS: passing check\n")
+	assert.Contains(t, string(output), "image-self-test: FAIL: failing check\n")
+	assert.Contains(t, string(output), "image-self-test: PASS: pipeline check\n")
+	assert.Contains(t, string(output), "image-self-test: RESULT: fail (1 failed)\n")
+
+	result, err := os.ReadFile(filepath.Join(resultDir, "result"))
+	assert.NoError(t, err)
+	assert.Equal(t, "fail\n", string(result))
+
+	report, err := os.ReadFile(filepath.Join(resultDir, "report"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(output), string(report))
+}
@@ -0,0 +1,366 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/plugins"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
+)
+
+const (
+	signingDirName          = "signing"
+	signingUnsignedDirName  = "unsigned"
+	signingSignedDirName    = "signed"
+	signingManifestFileName = "artifacts.json"
+
+	// Environment variables that are set for the signing command.
+	signingUnsignedDirEnvVar = "SIGNING_UNSIGNED_DIR"
+	signingSignedDirEnvVar   = "SIGNING_SIGNED_DIR"
+	signingManifestEnvVar    = "SIGNING_MANIFEST"
+
+	// HTTP headers that are sent to the signing endpoint.
+	signingArtifactTypeHeader = "X-Signing-Artifact-Type"
+	signingArtifactPathHeader = "X-Signing-Artifact-Path"
+
+	signingEndpointTimeout  = 10 * time.Minute
+	signingEndpointAttempts = 3
+	signingEndpointRetry    = 5 * time.Second
+
+	// The index of the certificate table (i.e. the Authenticode signatures) within a PE file's data directories.
+	peCertificateTableIndex = 4
+)
+
+var (
+	// The glob patterns of each type of boot artifact, relative to the image's root directory.
+	signingArtifactGlobs = map[imagecustomizerapi.SigningArtifactType][]string{
+		imagecustomizerapi.SigningArtifactTypeShim:       {"/boot/efi/EFI/BOOT/boot*.efi"},
+		imagecustomizerapi.SigningArtifactTypeBootloader: {"/boot/efi/EFI/BOOT/grub*.efi"},
+		imagecustomizerapi.SigningArtifactTypeKernel:     {"/boot/vmlinuz-*"},
+	}
+)
+
+// signingArtifact is a boot artifact that requires a signature.
+type signingArtifact struct {
+	Type imagecustomizerapi.SigningArtifactType `json:"type"`
+	// Path is the path of the artifact within the image. This is also the path of the artifact within the unsigned
+	// and signed directories.
+	Path string `json:"path"`
+	// Sha256 is the digest of the unsigned artifact.
+	Sha256 string `json:"sha256"`
+}
+
+// signingManifest is the list of artifacts that is passed to the signing command.
+type signingManifest struct {
+	Artifacts []signingArtifact `json:"artifacts"`
+}
+
+// signBootArtifacts exports the boot artifacts that require a signature from the image, signs them, and then writes
+// the signed artifacts back into the image.
+func signBootArtifacts(buildDir string, baseConfigPath string, signing *imagecustomizerapi.Signing,
+	pluginRegistry *plugins.Registry, imageRootDir string,
+) error {
+	if signing == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Signing boot artifacts")
+
+	artifacts, err := findSigningArtifacts(imageRootDir, signing.ArtifactTypes())
+	if err != nil {
+		return err
+	}
+
+	if len(artifacts) <= 0 {
+		return fmt.Errorf("no boot artifacts to sign were found in the image")
+	}
+
+	signingDir := filepath.Join(buildDir, signingDirName)
+	unsignedDir := filepath.Join(signingDir, signingUnsignedDirName)
+	signedDir := filepath.Join(signingDir, signingSignedDirName)
+	manifestPath := filepath.Join(signingDir, signingManifestFileName)
+
+	err = os.RemoveAll(signingDir)
+	if err != nil {
+		return fmt.Errorf("failed to clean signing directory (%s):\n%w", signingDir, err)
+	}
+	defer os.RemoveAll(signingDir)
+
+	err = os.MkdirAll(signedDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create signing directory (%s):\n%w", signedDir, err)
+	}
+
+	for i := range artifacts {
+		artifact := &artifacts[i]
+
+		unsignedPath := filepath.Join(unsignedDir, artifact.Path)
+		err = file.Copy(filepath.Join(imageRootDir, artifact.Path), unsignedPath)
+		if err != nil {
+			return fmt.Errorf("failed to export boot artifact (%s):\n%w", artifact.Path, err)
+		}
+
+		artifact.Sha256, err = file.GenerateSHA256(unsignedPath)
+		if err != nil {
+			return fmt.Errorf("failed to hash boot artifact (%s):\n%w", artifact.Path, err)
+		}
+	}
+
+	err = jsonutils.WriteJSONFile(manifestPath, signingManifest{Artifacts: artifacts})
+	if err != nil {
+		return fmt.Errorf("failed to write signing manifest:\n%w", err)
+	}
+
+	switch {
+	case signing.Command != nil:
+		extraEnvVars := []string{
+			fmt.Sprintf("%s=%s", signingUnsignedDirEnvVar, unsignedDir),
+			fmt.Sprintf("%s=%s", signingSignedDirEnvVar, signedDir),
+			fmt.Sprintf("%s=%s", signingManifestEnvVar, manifestPath),
+		}
+
+		err = runUserScriptOutsideChroot(buildDir, baseConfigPath, 0, *signing.Command, "signing", extraEnvVars)
+		if err != nil {
+			return err
+		}
+
+	case signing.Endpoint != nil:
+		err = signWithEndpoint(signing.Endpoint, artifacts, unsignedDir, signedDir)
+		if err != nil {
+			return err
+		}
+
+	case signing.Provider != "":
+		err = signWithPlugin(pluginRegistry, signing.Provider, manifestPath, unsignedDir, signedDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, artifact := range artifacts {
+		err = injectSignedArtifact(imageRootDir, signedDir, artifact)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findSigningArtifacts finds the boot artifacts of the requested types within the image.
+func findSigningArtifacts(imageRootDir string, artifactTypes []imagecustomizerapi.SigningArtifactType,
+) ([]signingArtifact, error) {
+	artifacts := []signingArtifact(nil)
+	found := make(map[string]bool)
+
+	for _, artifactType := range artifactTypes {
+		paths := []string(nil)
+		for _, pattern := range signingArtifactGlobs[artifactType] {
+			matches, err := filepath.Glob(filepath.Join(imageRootDir, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("failed to find %s boot artifacts:\n%w", artifactType, err)
+			}
+
+			for _, match := range matches {
+				isFile, err := file.IsFile(match)
+				if err != nil {
+					return nil, err
+				}
+
+				if !isFile {
+					continue
+				}
+
+				relPath, err := filepath.Rel(imageRootDir, match)
+				if err != nil {
+					return nil, err
+				}
+
+				paths = append(paths, "/"+relPath)
+			}
+		}
+
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			if found[path] {
+				continue
+			}
+			found[path] = true
+
+			artifacts = append(artifacts, signingArtifact{
+				Type: artifactType,
+				Path: path,
+			})
+		}
+	}
+
+	return artifacts, nil
+}
+
+// signWithEndpoint sends each artifact to a signing service and writes the signed artifacts to the signed directory.
+func signWithEndpoint(endpoint *imagecustomizerapi.SigningEndpoint, artifacts []signingArtifact,
+	unsignedDir string, signedDir string,
+) error {
+	token := ""
+	if endpoint.BearerTokenEnvironmentVariable != "" {
+		token = os.Getenv(endpoint.BearerTokenEnvironmentVariable)
+	}
+
+	client := &http.Client{
+		Timeout: signingEndpointTimeout,
+	}
+
+	for _, artifact := range artifacts {
+		logger.Log.Infof("Signing boot artifact (%s) with signing service", artifact.Path)
+
+		unsigned, err := os.ReadFile(filepath.Join(unsignedDir, artifact.Path))
+		if err != nil {
+			return fmt.Errorf("failed to read boot artifact (%s):\n%w", artifact.Path, err)
+		}
+
+		var signed []byte
+		err = retry.Run(func() error {
+			var requestErr error
+			signed, requestErr = requestSignature(client, endpoint.Url, token, artifact, unsigned)
+			return requestErr
+		}, signingEndpointAttempts, signingEndpointRetry)
+		if err != nil {
+			return fmt.Errorf("failed to sign boot artifact (%s) with signing service (%s):\n%w", artifact.Path,
+				endpoint.Url, err)
+		}
+
+		signedPath := filepath.Join(signedDir, artifact.Path)
+
+		err = os.MkdirAll(filepath.Dir(signedPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(signedPath, signed, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write signed boot artifact (%s):\n%w", artifact.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func requestSignature(client *http.Client, url string, token string, artifact signingArtifact, unsigned []byte,
+) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(unsigned))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set(signingArtifactTypeHeader, string(artifact.Type))
+	request.Header.Set(signingArtifactPathHeader, artifact.Path)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response:\n%w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signing service returned status (%s): %s", response.Status,
+			strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// injectSignedArtifact checks that the signed artifact has a signature and then writes it into the image.
+//
+// The existing file is overwritten in place, so that its permissions and SELinux label are kept.
+func injectSignedArtifact(imageRootDir string, signedDir string, artifact signingArtifact) error {
+	signedPath := filepath.Join(signedDir, artifact.Path)
+
+	signed, err := isSignedPeFile(signedPath)
+	if err != nil {
+		return fmt.Errorf("invalid signed boot artifact (%s):\n%w", artifact.Path, err)
+	}
+
+	if !signed {
+		return fmt.Errorf("signed boot artifact (%s) doesn't have a signature", artifact.Path)
+	}
+
+	source, err := os.Open(signedPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(filepath.Join(imageRootDir, artifact.Path), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open boot artifact (%s):\n%w", artifact.Path, err)
+	}
+	defer destination.Close()
+
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		return fmt.Errorf("failed to write signed boot artifact (%s):\n%w", artifact.Path, err)
+	}
+
+	err = destination.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write signed boot artifact (%s):\n%w", artifact.Path, err)
+	}
+
+	logger.Log.Debugf("Injected signed boot artifact (%s)", artifact.Path)
+	return nil
+}
+
+// isSignedPeFile returns true if the file is a PE file that contains an Authenticode signature.
+func isSignedPeFile(path string) (bool, error) {
+	peFile, err := pe.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("not a PE file:\n%w", err)
+	}
+	defer peFile.Close()
+
+	var dataDirectories []pe.DataDirectory
+	var numDataDirectories uint32
+	switch optionalHeader := peFile.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dataDirectories = optionalHeader.DataDirectory[:]
+		numDataDirectories = optionalHeader.NumberOfRvaAndSizes
+
+	case *pe.OptionalHeader64:
+		dataDirectories = optionalHeader.DataDirectory[:]
+		numDataDirectories = optionalHeader.NumberOfRvaAndSizes
+
+	default:
+		return false, fmt.Errorf("PE file has no optional header")
+	}
+
+	if numDataDirectories <= peCertificateTableIndex {
+		return false, nil
+	}
+
+	return dataDirectories[peCertificateTableIndex].Size > 0, nil
+}
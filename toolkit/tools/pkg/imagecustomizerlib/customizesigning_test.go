@@ -0,0 +1,241 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestPeFile creates a minimal 64-bit PE file, with or without a (fake) certificate table.
+func createTestPeFile(t *testing.T, path string, signed bool) {
+	buffer := &bytes.Buffer{}
+
+	// DOS header, with the PE header's offset at 0x3c.
+	dosHeader := make([]byte, 64)
+	copy(dosHeader, "MZ")
+	binary.LittleEndian.PutUint32(dosHeader[0x3c:], 64)
+	buffer.Write(dosHeader)
+
+	buffer.WriteString("PE\x00\x00")
+
+	optionalHeader := pe.OptionalHeader64{
+		Magic:               0x20b,
+		NumberOfRvaAndSizes: 16,
+	}
+	if signed {
+		optionalHeader.DataDirectory[peCertificateTableIndex] = pe.DataDirectory{VirtualAddress: 512, Size: 8}
+	}
+
+	fileHeader := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		SizeOfOptionalHeader: uint16(binary.Size(optionalHeader)),
+	}
+
+	err := binary.Write(buffer, binary.LittleEndian, fileHeader)
+	assert.NoError(t, err)
+	err = binary.Write(buffer, binary.LittleEndian, optionalHeader)
+	assert.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(path, buffer.Bytes(), 0o644)
+	assert.NoError(t, err)
+}
+
+func createTestSigningImage(t *testing.T, imageRootDir string) {
+	createTestPeFile(t, filepath.Join(imageRootDir, "boot/efi/EFI/BOOT/bootx64.efi"), false)
+	createTestPeFile(t, filepath.Join(imageRootDir, "boot/efi/EFI/BOOT/grubx64.efi"), false)
+	createTestPeFile(t, filepath.Join(imageRootDir, "boot/vmlinuz-6.6.51.1-5.azl3"), false)
+	createTestPeFile(t, filepath.Join(imageRootDir, "boot/vmlinuz-6.6.57.1-1.azl3"), false)
+}
+
+func TestIsSignedPeFile(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestIsSignedPeFile")
+	defer os.RemoveAll(testTmpDir)
+
+	signedPath := filepath.Join(testTmpDir, "signed.efi")
+	unsignedPath := filepath.Join(testTmpDir, "unsigned.efi")
+	textPath := filepath.Join(testTmpDir, "text.efi")
+
+	createTestPeFile(t, signedPath, true)
+	createTestPeFile(t, unsignedPath, false)
+	err := os.WriteFile(textPath, []byte("not a PE file"), 0o644)
+	assert.NoError(t, err)
+
+	signed, err := isSignedPeFile(signedPath)
+	assert.NoError(t, err)
+	assert.True(t, signed)
+
+	signed, err = isSignedPeFile(unsignedPath)
+	assert.NoError(t, err)
+	assert.False(t, signed)
+
+	_, err = isSignedPeFile(textPath)
+	assert.ErrorContains(t, err, "not a PE file")
+}
+
+func TestFindSigningArtifacts(t *testing.T) {
+	imageRootDir := filepath.Join(tmpDir, "TestFindSigningArtifacts")
+	defer os.RemoveAll(imageRootDir)
+
+	createTestSigningImage(t, imageRootDir)
+
+	artifacts, err := findSigningArtifacts(imageRootDir, []imagecustomizerapi.SigningArtifactType{
+		imagecustomizerapi.SigningArtifactTypeKernel,
+		imagecustomizerapi.SigningArtifactTypeShim,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []signingArtifact{
+		{Type: imagecustomizerapi.SigningArtifactTypeKernel, Path: "/boot/vmlinuz-6.6.51.1-5.azl3"},
+		{Type: imagecustomizerapi.SigningArtifactTypeKernel, Path: "/boot/vmlinuz-6.6.57.1-1.azl3"},
+		{Type: imagecustomizerapi.SigningArtifactTypeShim, Path: "/boot/efi/EFI/BOOT/bootx64.efi"},
+	}, artifacts)
+}
+
+func TestSignBootArtifactsCommand(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestSignBootArtifactsCommand")
+	defer os.RemoveAll(testTmpDir)
+
+	buildDir := filepath.Join(testTmpDir, "build")
+	imageRootDir := filepath.Join(testTmpDir, "rootfs")
+	signedTemplatePath := filepath.Join(testTmpDir, "signed.efi")
+
+	createTestSigningImage(t, imageRootDir)
+	createTestPeFile(t, signedTemplatePath, true)
+
+	err := os.MkdirAll(buildDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	// A fake signer that replaces each artifact with a signed PE file.
+	signing := &imagecustomizerapi.Signing{
+		Artifacts: []imagecustomizerapi.SigningArtifactType{imagecustomizerapi.SigningArtifactTypeShim},
+		Command: &imagecustomizerapi.Script{
+			Content: `set -e
+test -f "$SIGNING_MANIFEST"
+cd "$SIGNING_UNSIGNED_DIR"
+find . -type f | while read -r f; do
+  mkdir -p "$SIGNING_SIGNED_DIR/$(dirname "$f")"
+  cp "$1" "$SIGNING_SIGNED_DIR/$f"
+done
+`,
+			Arguments: []string{signedTemplatePath},
+		},
+	}
+
+	err = signBootArtifacts(buildDir, testTmpDir, signing, nil, imageRootDir)
+	assert.NoError(t, err)
+
+	signed, err := isSignedPeFile(filepath.Join(imageRootDir, "boot/efi/EFI/BOOT/bootx64.efi"))
+	assert.NoError(t, err)
+	assert.True(t, signed)
+
+	// Other artifact types are left alone.
+	signed, err = isSignedPeFile(filepath.Join(imageRootDir, "boot/efi/EFI/BOOT/grubx64.efi"))
+	assert.NoError(t, err)
+	assert.False(t, signed)
+
+	// The signing directory is cleaned up.
+	assert.NoDirExists(t, filepath.Join(buildDir, signingDirName))
+}
+
+func TestSignBootArtifactsCommandUnsigned(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestSignBootArtifactsCommandUnsigned")
+	defer os.RemoveAll(testTmpDir)
+
+	buildDir := filepath.Join(testTmpDir, "build")
+	imageRootDir := filepath.Join(testTmpDir, "rootfs")
+
+	createTestSigningImage(t, imageRootDir)
+
+	err := os.MkdirAll(buildDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	// A broken signer that copies the artifacts without signing them.
+	signing := &imagecustomizerapi.Signing{
+		Command: &imagecustomizerapi.Script{
+			Content: `cp -r "$SIGNING_UNSIGNED_DIR/." "$SIGNING_SIGNED_DIR"`,
+		},
+	}
+
+	err = signBootArtifacts(buildDir, testTmpDir, signing, nil, imageRootDir)
+	assert.ErrorContains(t, err, "signed boot artifact (/boot/efi/EFI/BOOT/bootx64.efi) doesn't have a signature")
+}
+
+func TestSignBootArtifactsEndpoint(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestSignBootArtifactsEndpoint")
+	defer os.RemoveAll(testTmpDir)
+
+	buildDir := filepath.Join(testTmpDir, "build")
+	imageRootDir := filepath.Join(testTmpDir, "rootfs")
+	signedTemplatePath := filepath.Join(testTmpDir, "signed.efi")
+
+	createTestSigningImage(t, imageRootDir)
+	createTestPeFile(t, signedTemplatePath, true)
+
+	signedTemplate, err := os.ReadFile(signedTemplatePath)
+	assert.NoError(t, err)
+
+	requests := []string(nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		requests = append(requests, r.Header.Get(signingArtifactTypeHeader)+":"+
+			r.Header.Get(signingArtifactPathHeader))
+		w.Write(signedTemplate)
+	}))
+	defer server.Close()
+
+	t.Setenv("TEST_SIGNER_TOKEN", "secret")
+
+	signing := &imagecustomizerapi.Signing{
+		Artifacts: []imagecustomizerapi.SigningArtifactType{imagecustomizerapi.SigningArtifactTypeKernel},
+		Endpoint: &imagecustomizerapi.SigningEndpoint{
+			Url:                            server.URL,
+			BearerTokenEnvironmentVariable: "TEST_SIGNER_TOKEN",
+		},
+	}
+
+	err = signBootArtifacts(buildDir, testTmpDir, signing, nil, imageRootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"kernel:/boot/vmlinuz-6.6.51.1-5.azl3",
+		"kernel:/boot/vmlinuz-6.6.57.1-1.azl3",
+	}, requests)
+
+	signed, err := isSignedPeFile(filepath.Join(imageRootDir, "boot/vmlinuz-6.6.57.1-1.azl3"))
+	assert.NoError(t, err)
+	assert.True(t, signed)
+}
+
+func TestSignBootArtifactsNoArtifacts(t *testing.T) {
+	imageRootDir := filepath.Join(tmpDir, "TestSignBootArtifactsNoArtifacts")
+	defer os.RemoveAll(imageRootDir)
+
+	err := os.MkdirAll(imageRootDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	signing := &imagecustomizerapi.Signing{
+		Command: &imagecustomizerapi.Script{Content: "true"},
+	}
+
+	err = signBootArtifacts(filepath.Join(imageRootDir, "build"), imageRootDir, signing, nil, imageRootDir)
+	assert.ErrorContains(t, err, "no boot artifacts to sign were found in the image")
+}
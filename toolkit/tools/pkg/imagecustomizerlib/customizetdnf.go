@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	tdnfConfigFilePath  = "/etc/tdnf/tdnf.conf"
+	tdnfVarsDir         = "/etc/tdnf/vars"
+	tdnfMainSectionName = "main"
+)
+
+// customizeTdnf applies the tdnf settings to the image's tdnf.conf file and vars directory.
+func customizeTdnf(tdnfConfig *imagecustomizerapi.Tdnf, imageRootDir string) error {
+	if tdnfConfig == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Configuring tdnf")
+
+	err := updateTdnfConfigFile(tdnfConfig, filepath.Join(imageRootDir, tdnfConfigFilePath))
+	if err != nil {
+		return fmt.Errorf("failed to update tdnf config file (%s):\n%w", tdnfConfigFilePath, err)
+	}
+
+	vars := make(map[string]string, len(tdnfConfig.Vars)+1)
+	for name, value := range tdnfConfig.Vars {
+		vars[name] = value
+	}
+	if tdnfConfig.ReleaseVer != "" {
+		vars[imagecustomizerapi.TdnfReleaseVerVarName] = tdnfConfig.ReleaseVer
+	}
+
+	err = writeTdnfVars(vars, filepath.Join(imageRootDir, tdnfVarsDir))
+	if err != nil {
+		return fmt.Errorf("failed to write tdnf vars:\n%w", err)
+	}
+
+	return nil
+}
+
+func updateTdnfConfigFile(tdnfConfig *imagecustomizerapi.Tdnf, configFilePath string) error {
+	exists, err := file.PathExists(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	config := ini.Empty()
+	if exists {
+		config, err = ini.Load(configFilePath)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = os.MkdirAll(filepath.Dir(configFilePath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	mainSection := config.Section(tdnfMainSectionName)
+
+	if len(tdnfConfig.Excludes) > 0 {
+		mainSection.Key("excludepkgs").SetValue(strings.Join(tdnfConfig.Excludes, " "))
+	}
+
+	if tdnfConfig.Proxy != "" {
+		mainSection.Key("proxy").SetValue(tdnfConfig.Proxy)
+	}
+
+	if tdnfConfig.KeepCache != nil {
+		keepCache := "0"
+		if *tdnfConfig.KeepCache {
+			keepCache = "1"
+		}
+		mainSection.Key("keepcache").SetValue(keepCache)
+	}
+
+	return config.SaveTo(configFilePath)
+}
+
+func writeTdnfVars(vars map[string]string, varsDir string) error {
+	if len(vars) == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(varsDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		logger.Log.Debugf("Setting tdnf var (%s=%s)", name, vars[name])
+
+		err = file.Write(vars[name]+"\n", filepath.Join(varsDir, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
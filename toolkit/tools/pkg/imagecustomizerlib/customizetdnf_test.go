@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestCustomizeTdnf(t *testing.T) {
+	rootDir := filepath.Join(tmpDir, "TestCustomizeTdnf")
+	defer os.RemoveAll(rootDir)
+
+	configFilePath := filepath.Join(rootDir, tdnfConfigFilePath)
+	err := os.MkdirAll(filepath.Dir(configFilePath), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = file.Write("[main]\ngpgcheck=1\nkeepcache=1\nrepodir=/etc/yum.repos.d\n", configFilePath)
+	assert.NoError(t, err)
+
+	tdnfConfig := &imagecustomizerapi.Tdnf{
+		Excludes:   []string{"kernel*", "shim"},
+		Proxy:      "http://proxy.example.com:3128",
+		KeepCache:  ptrutils.PtrTo(false),
+		ReleaseVer: "3.0",
+		Vars: map[string]string{
+			"mirror": "mirror.example.com",
+		},
+	}
+
+	err = customizeTdnf(tdnfConfig, rootDir)
+	assert.NoError(t, err)
+
+	config, err := ini.Load(configFilePath)
+	assert.NoError(t, err)
+
+	mainSection := config.Section("main")
+	assert.Equal(t, "1", mainSection.Key("gpgcheck").String())
+	assert.Equal(t, "/etc/yum.repos.d", mainSection.Key("repodir").String())
+	assert.Equal(t, "0", mainSection.Key("keepcache").String())
+	assert.Equal(t, "kernel* shim", mainSection.Key("excludepkgs").String())
+	assert.Equal(t, "http://proxy.example.com:3128", mainSection.Key("proxy").String())
+
+	releaseVer, err := file.Read(filepath.Join(rootDir, tdnfVarsDir, "releasever"))
+	assert.NoError(t, err)
+	assert.Equal(t, "3.0\n", releaseVer)
+
+	mirror, err := file.Read(filepath.Join(rootDir, tdnfVarsDir, "mirror"))
+	assert.NoError(t, err)
+	assert.Equal(t, "mirror.example.com\n", mirror)
+}
+
+func TestCustomizeTdnfNil(t *testing.T) {
+	err := customizeTdnf(nil, filepath.Join(tmpDir, "TestCustomizeTdnfNil"))
+	assert.NoError(t, err)
+}
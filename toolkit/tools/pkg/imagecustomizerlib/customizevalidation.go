@@ -0,0 +1,424 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/qemu"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/systemd"
+)
+
+const (
+	validationReportFileSuffix = ".validation.json"
+
+	validationCheckRequiredFile         = "requiredFile"
+	validationCheckEnabledService       = "enabledService"
+	validationCheckKernelModule         = "kernelModule"
+	validationCheckNoWorldWritableFiles = "noWorldWritableFiles"
+	validationCheckBootSmokeTest        = "bootSmokeTest"
+
+	// The number of world-writable files to list in the report. The rest are only counted.
+	maxReportedWorldWritableFiles = 20
+
+	kernelModulesBuiltinFileName = "modules.builtin"
+)
+
+// validationReport is the outcome of the config's validation checks.
+type validationReport struct {
+	Passed bool                    `json:"passed"`
+	Checks []validationCheckResult `json:"checks"`
+}
+
+// validationCheckResult is the outcome of a single validation check.
+type validationCheckResult struct {
+	// Check is the type of check (e.g. "requiredFile").
+	Check string `json:"check"`
+	// Target is what was checked (e.g. the file's path). Empty for checks of the whole image.
+	Target  string `json:"target,omitempty"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+func newValidationCheckResult(check string, target string, failure string) validationCheckResult {
+	return validationCheckResult{
+		Check:   check,
+		Target:  target,
+		Passed:  failure == "",
+		Message: failure,
+	}
+}
+
+// checkValidationSupported checks that the validation checks can be run against the build's input and output.
+func checkValidationSupported(ic *ImageCustomizerParameters) error {
+	validation := ic.config.Validation
+	if validation == nil {
+		return nil
+	}
+
+	if validation.HasImageChecks() && ic.inputIsIso && !ic.customizeOSPartitions {
+		return fmt.Errorf("'validation' image checks require OS customizations when the input image is an iso image")
+	}
+
+	if validation.BootSmokeTest != nil {
+		switch {
+		case ic.outputImageFormat == "":
+			return fmt.Errorf("'validation.bootSmokeTest' requires an output image format")
+
+		case ic.inputIsIso && !ic.customizeOSPartitions:
+			// The VM's architecture is read from the OS's files.
+			return fmt.Errorf("'validation.bootSmokeTest' requires OS customizations when the input image is an iso image")
+
+		case ic.outputIsContainer || ic.outputIsWsl || ic.outputImageFormat == ImageFormatRawZst ||
+			ic.outputConverter != nil:
+			return fmt.Errorf("'validation.bootSmokeTest' doesn't support the (%s) output image format",
+				ic.outputImageFormat)
+		}
+	}
+
+	return nil
+}
+
+// validateImageContents runs the config's checks that inspect the contents of the customized image's filesystems.
+func validateImageContents(buildDir string, validation *imagecustomizerapi.Validation, rawImageFile string,
+) ([]validationCheckResult, error) {
+	logger.Log.Infof("Validating image contents")
+
+	imageConnection, err := connectToExistingImage(rawImageFile, buildDir, "imageroot", true)
+	if err != nil {
+		return nil, err
+	}
+	defer imageConnection.Close()
+
+	imageChroot := imageConnection.Chroot()
+	rootDir := imageChroot.RootDir()
+
+	results := checkRequiredFiles(rootDir, validation.RequiredFiles)
+
+	serviceResults, err := checkEnabledServices(validation.EnabledServices, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, serviceResults...)
+
+	moduleResults, err := checkKernelModules(rootDir, validation.KernelModules)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, moduleResults...)
+
+	if validation.NoWorldWritableFiles != nil {
+		result, err := checkNoWorldWritableFiles(rootDir, validation.NoWorldWritableFiles)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	err = imageConnection.CleanClose()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func checkRequiredFiles(rootDir string, requiredFiles []string) []validationCheckResult {
+	results := []validationCheckResult(nil)
+	for _, requiredFile := range requiredFiles {
+		failure := ""
+		_, err := os.Lstat(filepath.Join(rootDir, requiredFile))
+		if os.IsNotExist(err) {
+			failure = "file doesn't exist"
+		} else if err != nil {
+			failure = err.Error()
+		}
+
+		results = append(results, newValidationCheckResult(validationCheckRequiredFile, requiredFile, failure))
+	}
+
+	return results
+}
+
+func checkEnabledServices(services []string, imageChroot *safechroot.Chroot) ([]validationCheckResult, error) {
+	results := []validationCheckResult(nil)
+	for _, service := range services {
+		enabled, err := systemd.IsServiceEnabled(service, imageChroot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if service (%s) is enabled:\n%w", service, err)
+		}
+
+		failure := ""
+		if !enabled {
+			failure = "service isn't enabled"
+		}
+
+		results = append(results, newValidationCheckResult(validationCheckEnabledService, service, failure))
+	}
+
+	return results, nil
+}
+
+// checkKernelModules checks that each of the modules is available to every kernel in the image, either as a module
+// file or built into the kernel.
+func checkKernelModules(rootDir string, modules []string) ([]validationCheckResult, error) {
+	if len(modules) == 0 {
+		return nil, nil
+	}
+
+	kernelModules, err := readKernelModuleNames(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	kernels := []string(nil)
+	for kernel := range kernelModules {
+		kernels = append(kernels, kernel)
+	}
+	slices.Sort(kernels)
+
+	results := []validationCheckResult(nil)
+	for _, module := range modules {
+		failure := ""
+		if len(kernels) == 0 {
+			failure = "image doesn't have any kernels"
+		} else {
+			missingKernels := []string(nil)
+			for _, kernel := range kernels {
+				if !kernelModules[kernel][normalizeKernelModuleName(module)] {
+					missingKernels = append(missingKernels, kernel)
+				}
+			}
+
+			if len(missingKernels) > 0 {
+				failure = fmt.Sprintf("module is missing for kernels: %s", strings.Join(missingKernels, ", "))
+			}
+		}
+
+		results = append(results, newValidationCheckResult(validationCheckKernelModule, module, failure))
+	}
+
+	return results, nil
+}
+
+// readKernelModuleNames returns the (normalized) names of the modules of each kernel in the image, including the
+// modules that are built into the kernel.
+func readKernelModuleNames(rootDir string) (map[string]map[string]bool, error) {
+	kernelModules := make(map[string]map[string]bool)
+
+	modulesDir := filepath.Join(rootDir, kernelModulesDir)
+	entries, err := os.ReadDir(modulesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list kernels:\n%w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			kernelModules[entry.Name()] = make(map[string]bool)
+		}
+	}
+
+	moduleFiles, err := listKernelModules(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for moduleFile := range moduleFiles {
+		// e.g. lib/modules/6.6.51.1-1.azl3/kernel/drivers/nvme/host/nvme.ko.xz
+		relPath, err := filepath.Rel(strings.TrimPrefix(kernelModulesDir, "/"), moduleFile)
+		if err != nil {
+			return nil, err
+		}
+
+		kernel, _, _ := strings.Cut(relPath, string(filepath.Separator))
+		if names, found := kernelModules[kernel]; found {
+			names[kernelModuleFileName(moduleFile)] = true
+		}
+	}
+
+	for kernel, names := range kernelModules {
+		err := readBuiltinKernelModules(filepath.Join(modulesDir, kernel, kernelModulesBuiltinFileName), names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return kernelModules, nil
+}
+
+// readBuiltinKernelModules adds the names of the modules that are listed in a kernel's modules.builtin file.
+func readBuiltinKernelModules(builtinFile string, names map[string]bool) error {
+	builtin, err := os.Open(builtinFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read built-in kernel modules (%s):\n%w", builtinFile, err)
+	}
+	defer builtin.Close()
+
+	// Each line is the module's path within the kernel's source tree (e.g. kernel/drivers/nvme/host/nvme.ko).
+	scanner := bufio.NewScanner(builtin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			names[kernelModuleFileName(line)] = true
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("failed to read built-in kernel modules (%s):\n%w", builtinFile, err)
+	}
+
+	return nil
+}
+
+// kernelModuleFileName returns the normalized module name of a kernel module file.
+func kernelModuleFileName(modulePath string) string {
+	name := path.Base(filepath.ToSlash(modulePath))
+	compression := getKernelModuleCompression(name)
+	if compression != nil {
+		name = strings.TrimSuffix(name, compression.extension)
+	}
+	return normalizeKernelModuleName(name)
+}
+
+// normalizeKernelModuleName normalizes a module name, since the kernel treats '-' and '_' in module names as the same.
+func normalizeKernelModuleName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// checkNoWorldWritableFiles checks that the image doesn't have any world-writable files, or world-writable
+// directories without the sticky bit (e.g. /tmp is fine).
+func checkNoWorldWritableFiles(rootDir string, options *imagecustomizerapi.NoWorldWritableFiles,
+) (validationCheckResult, error) {
+	excludedDirs := append([]string(nil), changeManifestExcludedDirs...)
+	excludedDirs = append(excludedDirs, options.ExcludePaths...)
+
+	worldWritable := []string(nil)
+	err := filepath.WalkDir(rootDir, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+
+		imagePath := filepath.Join("/", relPath)
+		if isSELinuxReportExcludedPath(imagePath, excludedDirs) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symlinks are always 0777, but their permissions aren't used.
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		mode := info.Mode()
+		if mode.Perm()&0o002 != 0 && !(mode.IsDir() && mode&fs.ModeSticky != 0) {
+			worldWritable = append(worldWritable, imagePath)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return validationCheckResult{}, fmt.Errorf("failed to check for world-writable files:\n%w", err)
+	}
+
+	failure := ""
+	if len(worldWritable) > 0 {
+		listed := worldWritable[:min(len(worldWritable), maxReportedWorldWritableFiles)]
+		failure = fmt.Sprintf("%d world-writable files: %s", len(worldWritable), strings.Join(listed, ", "))
+		if len(worldWritable) > len(listed) {
+			failure += ", ..."
+		}
+	}
+
+	return newValidationCheckResult(validationCheckNoWorldWritableFiles, "", failure), nil
+}
+
+// runBootSmokeTest boots the output image, in a VM of the image's architecture, and waits for the console output to
+// match the pattern.
+func runBootSmokeTest(baseConfigPath string, smokeTest *imagecustomizerapi.BootSmokeTest, outputImageFile string,
+	outputImageFormat string, imageArch string,
+) validationCheckResult {
+	machine := qemu.Machine{
+		Arch: imageArch,
+	}
+
+	if smokeTest.Firmware != "" {
+		machine.Firmware = file.GetAbsPathWithBase(baseConfigPath, smokeTest.Firmware)
+	}
+
+	failure := ""
+	err := qemu.RunSmokeTest(outputImageFile, outputImageFormat, machine,
+		time.Duration(smokeTest.GetTimeoutSeconds())*time.Second, regexp.MustCompile(smokeTest.GetPattern()))
+	if err != nil {
+		failure = err.Error()
+	}
+
+	return newValidationCheckResult(validationCheckBootSmokeTest, "", failure)
+}
+
+// writeValidationReport writes the validation report alongside the output image. Returns an error that lists the
+// failed checks (if any).
+func writeValidationReport(outputImageDir string, outputImageBase string, results []validationCheckResult) error {
+	report := validationReport{
+		Passed: true,
+		Checks: []validationCheckResult{},
+	}
+
+	failedChecks := []string(nil)
+	for _, result := range results {
+		report.Checks = append(report.Checks, result)
+
+		if !result.Passed {
+			report.Passed = false
+
+			failedCheck := result.Check
+			if result.Target != "" {
+				failedCheck += fmt.Sprintf(" (%s)", result.Target)
+			}
+			failedChecks = append(failedChecks, fmt.Sprintf("%s: %s", failedCheck, result.Message))
+		}
+	}
+
+	reportFile := filepath.Join(outputImageDir, outputImageBase+validationReportFileSuffix)
+	err := jsonutils.WriteJSONFile(reportFile, report)
+	if err != nil {
+		return fmt.Errorf("failed to write validation report (%s):\n%w", reportFile, err)
+	}
+
+	if len(failedChecks) > 0 {
+		return fmt.Errorf("image validation failed (report: %s):\n%s", reportFile, strings.Join(failedChecks, "\n"))
+	}
+
+	logger.Log.Infof("Image validation passed (%d checks)", len(report.Checks))
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeValidationTestFile(t *testing.T, rootDir string, imagePath string, contents string, perm os.FileMode) {
+	fullPath := filepath.Join(rootDir, imagePath)
+	err := os.MkdirAll(filepath.Dir(fullPath), 0o755)
+	require.NoError(t, err)
+
+	err = os.WriteFile(fullPath, []byte(contents), perm)
+	require.NoError(t, err)
+
+	// Undo the umask.
+	err = os.Chmod(fullPath, perm)
+	require.NoError(t, err)
+}
+
+func TestCheckRequiredFiles(t *testing.T) {
+	rootDir := t.TempDir()
+	writeValidationTestFile(t, rootDir, "/etc/app.conf", "", 0o644)
+
+	results := checkRequiredFiles(rootDir, []string{"/etc/app.conf", "/etc/missing.conf"})
+	assert.Equal(t, []validationCheckResult{
+		{Check: validationCheckRequiredFile, Target: "/etc/app.conf", Passed: true},
+		{Check: validationCheckRequiredFile, Target: "/etc/missing.conf", Message: "file doesn't exist"},
+	}, results)
+}
+
+func TestCheckKernelModules(t *testing.T) {
+	rootDir := t.TempDir()
+	writeValidationTestFile(t, rootDir, "/lib/modules/6.6.1/kernel/drivers/nvme/host/nvme.ko.xz", "", 0o644)
+	writeValidationTestFile(t, rootDir, "/lib/modules/6.6.1/extra/nvidia-drm.ko", "", 0o644)
+	writeValidationTestFile(t, rootDir, "/lib/modules/6.6.1/modules.builtin", "kernel/fs/ext4/ext4.ko\n", 0o644)
+	writeValidationTestFile(t, rootDir, "/lib/modules/6.6.2/kernel/drivers/nvme/host/nvme.ko.zst", "", 0o644)
+	writeValidationTestFile(t, rootDir, "/lib/modules/6.6.2/modules.builtin", "kernel/fs/ext4/ext4.ko\n", 0o644)
+
+	results, err := checkKernelModules(rootDir, []string{"nvme", "ext4", "nvidia_drm", "vfio"})
+	require.NoError(t, err)
+	assert.Equal(t, []validationCheckResult{
+		{Check: validationCheckKernelModule, Target: "nvme", Passed: true},
+		{Check: validationCheckKernelModule, Target: "ext4", Passed: true},
+		{Check: validationCheckKernelModule, Target: "nvidia_drm", Message: "module is missing for kernels: 6.6.2"},
+		{Check: validationCheckKernelModule, Target: "vfio", Message: "module is missing for kernels: 6.6.1, 6.6.2"},
+	}, results)
+}
+
+func TestCheckKernelModulesNoKernels(t *testing.T) {
+	results, err := checkKernelModules(t.TempDir(), []string{"nvme"})
+	require.NoError(t, err)
+	assert.Equal(t, []validationCheckResult{
+		{Check: validationCheckKernelModule, Target: "nvme", Message: "image doesn't have any kernels"},
+	}, results)
+}
+
+func TestCheckNoWorldWritableFiles(t *testing.T) {
+	rootDir := t.TempDir()
+	writeValidationTestFile(t, rootDir, "/etc/app.conf", "", 0o644)
+	writeValidationTestFile(t, rootDir, "/proc/ignored", "", 0o666)
+	writeValidationTestFile(t, rootDir, "/var/cache/app/ignored", "", 0o666)
+
+	err := os.Symlink("app.conf", filepath.Join(rootDir, "etc/app-link.conf"))
+	require.NoError(t, err)
+
+	err = os.MkdirAll(filepath.Join(rootDir, "tmp"), 0o755)
+	require.NoError(t, err)
+	err = os.Chmod(filepath.Join(rootDir, "tmp"), 0o777|os.ModeSticky)
+	require.NoError(t, err)
+
+	options := &imagecustomizerapi.NoWorldWritableFiles{ExcludePaths: []string{"/var/cache"}}
+
+	result, err := checkNoWorldWritableFiles(rootDir, options)
+	require.NoError(t, err)
+	assert.Equal(t, validationCheckResult{Check: validationCheckNoWorldWritableFiles, Passed: true}, result)
+
+	writeValidationTestFile(t, rootDir, "/etc/secret.conf", "", 0o666)
+	err = os.MkdirAll(filepath.Join(rootDir, "srv/upload"), 0o755)
+	require.NoError(t, err)
+	err = os.Chmod(filepath.Join(rootDir, "srv/upload"), 0o777)
+	require.NoError(t, err)
+
+	result, err = checkNoWorldWritableFiles(rootDir, options)
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.Equal(t, "2 world-writable files: /etc/secret.conf, /srv/upload", result.Message)
+}
+
+func TestWriteValidationReport(t *testing.T) {
+	outputDir := t.TempDir()
+
+	results := []validationCheckResult{
+		{Check: validationCheckRequiredFile, Target: "/etc/app.conf", Passed: true},
+		{Check: validationCheckEnabledService, Target: "app.service", Message: "service isn't enabled"},
+	}
+
+	err := writeValidationReport(outputDir, "image", results)
+	assert.ErrorContains(t, err, "image validation failed")
+	assert.ErrorContains(t, err, "enabledService (app.service): service isn't enabled")
+
+	reportJson, err := os.ReadFile(filepath.Join(outputDir, "image.validation.json"))
+	require.NoError(t, err)
+
+	var report validationReport
+	err = json.Unmarshal(reportJson, &report)
+	require.NoError(t, err)
+	assert.False(t, report.Passed)
+	assert.Equal(t, results, report.Checks)
+
+	err = writeValidationReport(outputDir, "image", results[:1])
+	assert.NoError(t, err)
+}
+
+func TestCheckValidationSupported(t *testing.T) {
+	ic := &ImageCustomizerParameters{
+		config: &imagecustomizerapi.Config{
+			Validation: &imagecustomizerapi.Validation{
+				BootSmokeTest: &imagecustomizerapi.BootSmokeTest{},
+			},
+		},
+		outputImageFormat: ImageFormatVhdx,
+	}
+	assert.NoError(t, checkValidationSupported(ic))
+
+	ic.outputImageFormat = ImageFormatRawZst
+	assert.ErrorContains(t, checkValidationSupported(ic), "doesn't support the (raw-zst) output image format")
+
+	ic.outputImageFormat = ""
+	assert.ErrorContains(t, checkValidationSupported(ic), "requires an output image format")
+
+	ic.outputImageFormat = ImageFormatIso
+	ic.inputIsIso = true
+	assert.ErrorContains(t, checkValidationSupported(ic), "'validation.bootSmokeTest' requires OS customizations")
+
+	ic.customizeOSPartitions = true
+	assert.NoError(t, checkValidationSupported(ic))
+	ic.customizeOSPartitions = false
+
+	ic.config.Validation = &imagecustomizerapi.Validation{RequiredFiles: []string{"/etc/app.conf"}}
+	ic.inputIsIso = true
+	assert.ErrorContains(t, checkValidationSupported(ic), "image checks require OS customizations")
+}
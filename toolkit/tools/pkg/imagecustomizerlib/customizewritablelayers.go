@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+)
+
+const (
+	// The directory, relative to the persistence partition, that holds the writable layers.
+	writableLayersDirName = "overlays"
+
+	writableLayersSetupServiceName = "writable-layers-setup.service"
+	writableLayersSetupServicePath = "/usr/lib/systemd/system/" + writableLayersSetupServiceName
+	writableLayersDracutConfigPath = "/etc/dracut.conf.d/writable-layers.conf"
+)
+
+// enableWritableLayers mounts a writable overlay over each of the writable layer paths. The overlays are mounted in
+// the initrd, so that they are in place before any of the root filesystem's services start.
+func enableWritableLayers(writableLayers *imagecustomizerapi.WritableLayers,
+	selinuxMode imagecustomizerapi.SELinuxMode, imageChroot *safechroot.Chroot,
+) (bool, error) {
+	if writableLayers == nil {
+		return false, nil
+	}
+
+	logger.Log.Infof("Enable writable layers")
+
+	overlays := writableLayersToOverlays(writableLayers)
+
+	err := writeWritableLayersSetupFiles(overlays, writableLayers.PersistentMountPoint, imageChroot.RootDir())
+	if err != nil {
+		return false, fmt.Errorf("failed to write writable layers setup files:\n%w", err)
+	}
+
+	err = updateFstabForOverlays(overlays, imageChroot)
+	if err != nil {
+		return false, fmt.Errorf("failed to update fstab file for writable layers:\n%w", err)
+	}
+
+	err = createOverlayDirectories(overlays, imageChroot)
+	if err != nil {
+		return false, fmt.Errorf("failed to create writable layer directories:\n%w", err)
+	}
+
+	err = addEquivalencyRules(selinuxMode, overlays, imageChroot)
+	if err != nil {
+		return false, fmt.Errorf("failed to add equivalency rules for writable layers:\n%w", err)
+	}
+
+	return true, nil
+}
+
+// writableLayersToOverlays converts the writable layers into the equivalent initrd overlays.
+func writableLayersToOverlays(writableLayers *imagecustomizerapi.WritableLayers) []imagecustomizerapi.Overlay {
+	mountOptions := "x-systemd.requires=" + writableLayersSetupServiceName
+	if writableLayers.MountOptions != "" {
+		mountOptions += "," + writableLayers.MountOptions
+	}
+
+	overlays := []imagecustomizerapi.Overlay(nil)
+	for _, layerPath := range writableLayers.Paths {
+		layerPath = path.Clean(layerPath)
+		layerDir := path.Join(writableLayers.PersistentMountPoint, writableLayersDirName, layerPath)
+
+		overlay := imagecustomizerapi.Overlay{
+			LowerDirs:         []string{layerPath},
+			UpperDir:          path.Join(layerDir, "upper"),
+			WorkDir:           path.Join(layerDir, "work"),
+			MountPoint:        layerPath,
+			IsInitrdOverlay:   true,
+			MountDependencies: []string{writableLayers.PersistentMountPoint},
+			MountOptions:      mountOptions,
+		}
+		overlays = append(overlays, overlay)
+	}
+
+	return overlays
+}
+
+// writeWritableLayersSetupFiles writes the systemd service that (re)creates the upper and work directories during
+// boot, in case the persistence partition has been wiped. And adds the service to the initrd.
+func writeWritableLayersSetupFiles(overlays []imagecustomizerapi.Overlay, persistentMountPoint string,
+	imageRootDir string,
+) error {
+	serviceFilePath := filepath.Join(imageRootDir, writableLayersSetupServicePath)
+	dracutConfigFilePath := filepath.Join(imageRootDir, writableLayersDracutConfigPath)
+
+	err := os.MkdirAll(filepath.Dir(serviceFilePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = file.Write(generateWritableLayersSetupService(overlays, persistentMountPoint), serviceFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write service file (%s):\n%w", serviceFilePath, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(dracutConfigFilePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = addDracutConfig(dracutConfigFilePath, []string{
+		"add_drivers+=\" overlay \"",
+		"install_items+=\" " + writableLayersSetupServicePath + " \"",
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func generateWritableLayersSetupService(overlays []imagecustomizerapi.Overlay, persistentMountPoint string) string {
+	dirs := []string(nil)
+	for _, overlay := range overlays {
+		dirs = append(dirs, path.Join("/sysroot", overlay.UpperDir), path.Join("/sysroot", overlay.WorkDir))
+	}
+
+	lines := []string{
+		"# Generated by the Azure Linux Image Customizer.",
+		"[Unit]",
+		"Description=Create writable layer directories",
+		"DefaultDependencies=no",
+		"ConditionPathExists=/etc/initrd-release",
+		"RequiresMountsFor=" + path.Join("/sysroot", persistentMountPoint),
+		"",
+		"[Service]",
+		"Type=oneshot",
+		"RemainAfterExit=yes",
+		"ExecStart=/usr/bin/mkdir -p " + strings.Join(dirs, " "),
+		"",
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomizeImageWritableLayers(t *testing.T) {
+	baseImage := checkSkipForCustomizeImage(t, baseImageTypeCoreEfi, baseImageVersionDefault)
+
+	testTempDir := filepath.Join(tmpDir, "TestCustomizeImageWritableLayers")
+	buildDir := filepath.Join(testTempDir, "build")
+	outImageFilePath := filepath.Join(testTempDir, "image.raw")
+	configFile := filepath.Join(testDir, "writable-layers-config.yaml")
+
+	// Customize image.
+	err := CustomizeImageWithConfigFile(buildDir, configFile, baseImage, nil, outImageFilePath, "raw", "",
+		"" /*outputPXEArtifactsDir*/, true /*useBaseImageRpmRepos*/, false /*enableShrinkFilesystems*/)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mountPoints := []mountPoint{
+		{
+			PartitionNum:   3,
+			Path:           "/",
+			FileSystemType: "ext4",
+		},
+		{
+			PartitionNum:   2,
+			Path:           "/boot",
+			FileSystemType: "ext4",
+		},
+		{
+			PartitionNum:   1,
+			Path:           "/boot/efi",
+			FileSystemType: "vfat",
+		},
+		{
+			PartitionNum:   4,
+			Path:           "/persist",
+			FileSystemType: "ext4",
+		},
+	}
+
+	// Connect to customized image.
+	imageConnection, err := connectToImage(buildDir, outImageFilePath, false /*includeDefaultMounts*/, mountPoints)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer imageConnection.Close()
+
+	rootDir := imageConnection.chroot.RootDir()
+
+	fstabContents, err := file.Read(filepath.Join(rootDir, "etc/fstab"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Contains(t, fstabContents,
+		"overlay /etc overlay lowerdir=/sysroot/etc,"+
+			"upperdir=/sysroot/persist/overlays/etc/upper,workdir=/sysroot/persist/overlays/etc/work,"+
+			"x-systemd.requires=/sysroot/persist,x-initrd.mount,x-systemd.wanted-by=initrd-fs.target,"+
+			"x-systemd.requires=writable-layers-setup.service 0 0")
+
+	for _, dir := range []string{"etc/upper", "etc/work", "var/upper", "var/work"} {
+		exists, err := file.DirExists(filepath.Join(rootDir, "persist/overlays", dir))
+		assert.NoError(t, err)
+		assert.True(t, exists, "writable layer directory (%s) should exist", dir)
+	}
+
+	exists, err := file.PathExists(filepath.Join(rootDir, writableLayersSetupServicePath))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestWritableLayersToOverlays(t *testing.T) {
+	overlays := writableLayersToOverlays(&imagecustomizerapi.WritableLayers{
+		PersistentMountPoint: "/persist",
+		Paths:                []string{"/etc", "/var/lib/"},
+		MountOptions:         "noatime",
+	})
+
+	assert.Equal(t, []imagecustomizerapi.Overlay{
+		{
+			LowerDirs:         []string{"/etc"},
+			UpperDir:          "/persist/overlays/etc/upper",
+			WorkDir:           "/persist/overlays/etc/work",
+			MountPoint:        "/etc",
+			IsInitrdOverlay:   true,
+			MountDependencies: []string{"/persist"},
+			MountOptions:      "x-systemd.requires=writable-layers-setup.service,noatime",
+		},
+		{
+			LowerDirs:         []string{"/var/lib"},
+			UpperDir:          "/persist/overlays/var/lib/upper",
+			WorkDir:           "/persist/overlays/var/lib/work",
+			MountPoint:        "/var/lib",
+			IsInitrdOverlay:   true,
+			MountDependencies: []string{"/persist"},
+			MountOptions:      "x-systemd.requires=writable-layers-setup.service,noatime",
+		},
+	}, overlays)
+}
+
+func TestWriteWritableLayersSetupFiles(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestWriteWritableLayersSetupFiles")
+
+	err := os.RemoveAll(testTmpDir)
+	assert.NoError(t, err)
+
+	overlays := writableLayersToOverlays(&imagecustomizerapi.WritableLayers{
+		PersistentMountPoint: "/persist",
+		Paths:                []string{"/etc", "/var"},
+	})
+
+	err = writeWritableLayersSetupFiles(overlays, "/persist", testTmpDir)
+	assert.NoError(t, err)
+
+	serviceContents, err := file.Read(filepath.Join(testTmpDir, writableLayersSetupServicePath))
+	assert.NoError(t, err)
+	assert.Contains(t, serviceContents, "RequiresMountsFor=/sysroot/persist\n")
+	assert.Contains(t, serviceContents, "ExecStart=/usr/bin/mkdir -p "+
+		"/sysroot/persist/overlays/etc/upper /sysroot/persist/overlays/etc/work "+
+		"/sysroot/persist/overlays/var/upper /sysroot/persist/overlays/var/work\n")
+
+	dracutContents, err := file.Read(filepath.Join(testTmpDir, writableLayersDracutConfigPath))
+	assert.NoError(t, err)
+	assert.Contains(t, dracutContents, "add_drivers+=\" overlay \"")
+	assert.Contains(t, dracutContents, "install_items+=\" /usr/lib/systemd/system/writable-layers-setup.service \"")
+}
@@ -357,7 +357,7 @@ func WriteDefaultGrubFile(grub2Config string, imageChroot safechroot.ChrootInter
 	grub2ConfigFilePath := getDefaultGrubFilePath(imageChroot)
 
 	// Update grub.cfg file.
-	err := file.Write(grub2Config, grub2ConfigFilePath)
+	err := file.WriteAtomic(grub2Config, grub2ConfigFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to write grub file (%s):\n%w", installutils.GrubDefFile, err)
 	}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/imageconvert"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
@@ -85,6 +86,11 @@ func extractPartitions(imageLoopDevice string, outDir string, basename string, p
 			return fmt.Errorf("unsupported partition format (supported: raw, raw-zst): %s", partitionFormat)
 		}
 
+		_, err = imageconvert.WriteChecksumFile(partitionFilepath)
+		if err != nil {
+			return err
+		}
+
 		partitionMetadata, err := constructOutputPartitionMetadata(partition, partitionNum, partitionFilepath)
 		if err != nil {
 			return fmt.Errorf("failed to construct partition metadata:\n%w", err)
@@ -0,0 +1,163 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safeloopback"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	trimMountDirName = "trimmount"
+)
+
+// finalizeImageHelper runs the finalize steps that reduce the size of the image: shrinking the filesystems and
+// trimming their free space.
+func finalizeImageHelper(buildDir string, buildImageFile string, storage *imagecustomizerapi.Storage,
+	finalize *imagecustomizerapi.Finalize, partIdToPartUuid map[string]string,
+) error {
+	if finalize.ShrinkFilesystems == nil && !finalize.TrimFreeSpace {
+		return nil
+	}
+
+	imageLoopback, err := safeloopback.NewLoopback(buildImageFile)
+	if err != nil {
+		return err
+	}
+	defer imageLoopback.Close()
+
+	if finalize.ShrinkFilesystems != nil {
+		err = shrinkFilesystems(imageLoopback.DevicePath(), storage, partIdToPartUuid,
+			uint64(finalize.ShrinkFilesystems.Headroom), finalize.TrimFreeSpace)
+		if err != nil {
+			return fmt.Errorf("failed to shrink filesystems:\n%w", err)
+		}
+	}
+
+	if finalize.TrimFreeSpace {
+		err = trimFilesystems(buildDir, imageLoopback.DevicePath(), storage, partIdToPartUuid)
+		if err != nil {
+			return fmt.Errorf("failed to trim filesystems:\n%w", err)
+		}
+	}
+
+	err = imageLoopback.CleanClose()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// trimFilesystems discards the free space of each of the image's filesystems. Since the image is attached using a
+// loop device, the discarded blocks are punched out of the image file.
+func trimFilesystems(buildDir string, imageLoopDevice string, storage *imagecustomizerapi.Storage,
+	partIdToPartUuid map[string]string,
+) error {
+	logger.Log.Infof("Trimming filesystems")
+
+	diskPartitions, err := diskutils.GetDiskPartitions(imageLoopDevice)
+	if err != nil {
+		return err
+	}
+
+	mountDir := filepath.Join(buildDir, trimMountDirName)
+
+	for _, diskPartition := range diskPartitions {
+		if diskPartition.Type != "part" {
+			continue
+		}
+
+		if !supportedTrimFsType(diskPartition.FileSystemType) {
+			logger.Log.Debugf("Trimming partition (%s): unsupported filesystem type (%s)", diskPartition.Path,
+				diskPartition.FileSystemType)
+			continue
+		}
+
+		// The encrypted partitions are reformatted when they are encrypted.
+		if isEncryptedPartition(storage.EncryptedVolumes, diskPartition, partIdToPartUuid) {
+			logger.Log.Debugf("Trimming partition (%s): skipping encrypted partition", diskPartition.Path)
+			continue
+		}
+
+		err = withMountedDevice(diskPartition.Path, diskPartition.FileSystemType, mountDir, func() error {
+			stdout, stderr, err := shell.Execute("fstrim", "--verbose", mountDir)
+			if err != nil {
+				return fmt.Errorf("failed to trim partition (%s):\n%v", diskPartition.Path, stderr)
+			}
+
+			logger.Log.Debugf("Trimmed partition (%s): %s", diskPartition.Path, stdout)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks if the provided fstype is supported by trim filesystems.
+func supportedTrimFsType(fstype string) bool {
+	switch fstype {
+	case "ext2", "ext3", "ext4", "xfs", "vfat":
+		return true
+	default:
+		return false
+	}
+}
+
+// isSparseImageFormat returns true if the output image format is a plain copy of the disk, which can have holes
+// punched in it.
+func isSparseImageFormat(imageFormat string) bool {
+	switch imageFormat {
+	case ImageFormatRaw, ImageFormatVhdFixed:
+		return true
+	default:
+		return false
+	}
+}
+
+// makeFileSparse punches holes in the file wherever it contains blocks of zeros. The file's contents (and therefore
+// its checksum) don't change.
+func makeFileSparse(path string) error {
+	logger.Log.Infof("Making file sparse (%s)", path)
+
+	err := shell.ExecuteLiveWithErr(1, "fallocate", "--dig-holes", path)
+	if err != nil {
+		return fmt.Errorf("failed to punch holes in file (%s):\n%w", path, err)
+	}
+
+	size, allocated, err := getFileAllocation(path)
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Sparse file size: %d bytes, allocated: %d bytes", size, allocated)
+	return nil
+}
+
+// getFileAllocation returns the apparent size of the file and the number of bytes allocated to it.
+func getFileAllocation(path string) (size int64, allocated int64, err error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat file (%s):\n%w", path, err)
+	}
+
+	size = stat.Size()
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		// st_blocks is always in 512-byte units.
+		allocated = sys.Blocks * 512
+	}
+
+	return size, allocated, nil
+}
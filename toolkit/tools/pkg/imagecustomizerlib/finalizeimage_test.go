@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResize2fsMinSize(t *testing.T) {
+	minBlockCount, err := parseResize2fsMinSize("Estimated minimum size of the filesystem: 21015\n")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(21015), minBlockCount)
+
+	_, err = parseResize2fsMinSize("resize2fs 1.47.0 (5-Feb-2023)\n")
+	assert.ErrorContains(t, err, "failed to parse min size from resize2fs output")
+}
+
+func TestParseDumpe2fsBlocks(t *testing.T) {
+	stdout := "" +
+		"Filesystem volume name:   <none>\n" +
+		"Block count:              262144\n" +
+		"Reserved block count:     13107\n" +
+		"Free blocks:              249189\n" +
+		"Block size:               4096\n"
+
+	blockCount, blockSize, err := parseDumpe2fsBlocks(stdout)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(262144), blockCount)
+	assert.Equal(t, uint64(4096), blockSize)
+
+	_, _, err = parseDumpe2fsBlocks("Block count:              262144\n")
+	assert.ErrorContains(t, err, "failed to parse block count and size from dumpe2fs output")
+}
+
+func TestCalculateShrinkTargetBlockCount(t *testing.T) {
+	// The headroom is rounded up to whole blocks.
+	assert.Equal(t, uint64(1000+257), calculateShrinkTargetBlockCount(1000, 4000, 4096, 1024*1024+1))
+	assert.Equal(t, uint64(1000), calculateShrinkTargetBlockCount(1000, 4000, 4096, 0))
+
+	// The filesystem is already within the headroom.
+	assert.Equal(t, uint64(0), calculateShrinkTargetBlockCount(1000, 1256, 4096, 1024*1024))
+}
+
+func TestMakeFileSparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.raw")
+
+	contents := make([]byte, 4*1024*1024)
+	copy(contents, []byte("data"))
+	err := os.WriteFile(path, contents, 0o644)
+	require.NoError(t, err)
+
+	err = makeFileSparse(path)
+	assert.NoError(t, err)
+
+	size, allocated, err := getFileAllocation(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), size)
+	assert.Less(t, allocated, size)
+
+	// The contents don't change.
+	sparseContents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, contents, sparseContents)
+}
+
+func TestPlanFinalize(t *testing.T) {
+	plan := &CustomizationPlan{}
+	planFinalize(plan, &imagecustomizerapi.Finalize{
+		TrimFreeSpace: true,
+		ShrinkFilesystems: &imagecustomizerapi.FinalizeShrinkFilesystems{
+			Headroom: 64 * 1024 * 1024,
+		},
+		Sparse: true,
+	})
+
+	assert.Equal(t, ""+
+		"1. Shrink filesystems\n"+
+		"   - headroom: 64 MiB\n"+
+		"2. Trim filesystems\n",
+		plan.String())
+}
@@ -786,7 +786,7 @@ func writeGrub2ConfigFile(grub2Config string, imageChroot safechroot.ChrootInter
 	grub2ConfigFilePath := getGrub2ConfigFilePath(imageChroot)
 
 	// Update grub.cfg file.
-	err := file.Write(grub2Config, grub2ConfigFilePath)
+	err := file.WriteAtomic(grub2Config, grub2ConfigFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to write grub2 config file (%s):\n%w", installutils.GrubCfgFile, err)
 	}
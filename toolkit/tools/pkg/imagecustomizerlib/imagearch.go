@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// Executables that every image is expected to have one of, used to find the image's architecture.
+	imageArchProbeFiles = []string{
+		"usr/bin/bash",
+		"usr/lib/systemd/systemd",
+		"usr/bin/busybox",
+	}
+
+	elfMachineToGoArch = map[elf.Machine]string{
+		elf.EM_X86_64:  "amd64",
+		elf.EM_AARCH64: "arm64",
+	}
+)
+
+// getImageArch returns the architecture (as a GOARCH value) of the OS under rootDir, which may differ from the
+// host's.
+func getImageArch(rootDir string) (string, error) {
+	for _, probeFile := range imageArchProbeFiles {
+		probePath := filepath.Join(rootDir, probeFile)
+
+		// Symlinks are skipped, since absolute symlinks would resolve to the host's files.
+		stat, err := os.Lstat(probePath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("failed to stat (%s):\n%w", probePath, err)
+		}
+
+		if !stat.Mode().IsRegular() {
+			continue
+		}
+
+		elfFile, err := elf.Open(probePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ELF header of (%s):\n%w", probePath, err)
+		}
+		machine := elfFile.Machine
+		elfFile.Close()
+
+		arch, found := elfMachineToGoArch[machine]
+		if !found {
+			return "", fmt.Errorf("unsupported image architecture (%s) of (%s)", machine, probePath)
+		}
+
+		return arch, nil
+	}
+
+	return "", fmt.Errorf("failed to find the image's architecture, none of (%v) exist", imageArchProbeFiles)
+}
+
+// getRawImageArch returns the architecture (as a GOARCH value) of the OS of a raw disk image.
+func getRawImageArch(buildDir string, rawImageFile string) (string, error) {
+	imageConnection, err := connectToExistingImage(rawImageFile, buildDir, "imageroot", false)
+	if err != nil {
+		return "", err
+	}
+	defer imageConnection.Close()
+
+	imageArch, err := getImageArch(imageConnection.Chroot().RootDir())
+	if err != nil {
+		return "", err
+	}
+
+	err = imageConnection.CleanClose()
+	if err != nil {
+		return "", err
+	}
+
+	return imageArch, nil
+}
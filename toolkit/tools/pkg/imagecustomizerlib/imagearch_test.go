@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetImageArch(t *testing.T) {
+	rootDir := t.TempDir()
+
+	// The test binary is an ELF executable of the host's architecture.
+	testExecutable, err := os.Executable()
+	require.NoError(t, err)
+
+	err = file.Copy(testExecutable, filepath.Join(rootDir, "usr/lib/systemd/systemd"))
+	require.NoError(t, err)
+
+	arch, err := getImageArch(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, runtime.GOARCH, arch)
+}
+
+func TestGetImageArchSkipsSymlinks(t *testing.T) {
+	rootDir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(rootDir, "usr/bin"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = os.Symlink("/usr/bin/bash", filepath.Join(rootDir, "usr/bin/bash"))
+	require.NoError(t, err)
+
+	_, err = getImageArch(rootDir)
+	assert.ErrorContains(t, err, "failed to find the image's architecture")
+}
+
+func TestGetImageArchNotElf(t *testing.T) {
+	rootDir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(rootDir, "usr/bin"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = file.Write("#!/bin/sh\n", filepath.Join(rootDir, "usr/bin/bash"))
+	require.NoError(t, err)
+
+	_, err = getImageArch(rootDir)
+	assert.ErrorContains(t, err, "failed to read ELF header")
+}
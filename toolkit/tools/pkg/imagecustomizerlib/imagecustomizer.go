@@ -9,15 +9,21 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/imageconvert"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/plugins"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safeloopback"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safemount"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/changemanifest"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/selinuxreport"
 	"golang.org/x/sys/unix"
 )
 
@@ -25,14 +31,21 @@ const (
 	tmpParitionDirName = "tmppartition"
 
 	// supported input formats
-	ImageFormatVhd      = "vhd"
-	ImageFormatVhdFixed = "vhd-fixed"
-	ImageFormatVhdx     = "vhdx"
-	ImageFormatQCow2    = "qcow2"
-	ImageFormatIso      = "iso"
-	ImageFormatRaw      = "raw"
+	ImageFormatVhd             = imageconvert.FormatVhd
+	ImageFormatVhdFixed        = imageconvert.FormatVhdFixed
+	ImageFormatVhdx            = imageconvert.FormatVhdx
+	ImageFormatQCow2           = imageconvert.FormatQcow2
+	ImageFormatQCow2Compressed = imageconvert.FormatQcow2Compressed
+	ImageFormatIso             = "iso"
+	ImageFormatRaw             = imageconvert.FormatRaw
+	ImageFormatRawZst          = imageconvert.FormatRawZst
+	ImageFormatOci             = "oci"
+	ImageFormatDockerArchive   = "docker-archive"
+	ImageFormatWsl             = "wsl"
 
 	// qemu-specific formats
+	//
+	// Deprecated: The qemu-img formats are an implementation detail of the image conversion. Use ImageFormatVhd.
 	QemuFormatVpc = "vpc"
 
 	BaseImageName                = "image.raw"
@@ -54,9 +67,11 @@ type ImageCustomizerParameters struct {
 	buildDirAbs string
 
 	// input image
-	inputImageFile   string
-	inputImageFormat string
-	inputIsIso       bool
+	inputImageFile     string
+	inputImageFormat   string
+	inputIsIso         bool
+	inputImageCacheDir string
+	baseImageSharing   BaseImageSharing
 
 	// configurations
 	configPath                  string
@@ -64,6 +79,7 @@ type ImageCustomizerParameters struct {
 	customizeOSPartitions       bool
 	useBaseImageRpmRepos        bool
 	rpmsSources                 []string
+	packageCacheDir             string
 	enableShrinkFilesystems     bool
 	outputSplitPartitionsFormat string
 
@@ -73,17 +89,37 @@ type ImageCustomizerParameters struct {
 	// output image
 	outputImageFormat     string
 	outputIsIso           bool
+	outputIsContainer     bool
+	outputIsWsl           bool
 	outputImageFile       string
 	outputImageDir        string
 	outputImageBase       string
 	outputPXEArtifactsDir string
+
+	// The plugins that were found within the plugins directory.
+	plugins *plugins.Registry
+	// The plugin that converts the output image, if the output format isn't one of the toolkit's own.
+	outputConverter *plugins.Plugin
+
+	// The results of the validation checks that have been run so far.
+	validationResults []validationCheckResult
+	// The architecture (as a GOARCH value) of the customized OS. Only set when the boot smoke test is enabled.
+	imageArch string
+
+	// Checks that the library's caller runs at points within the build.
+	phaseValidators []PhaseValidator
+
+	// build summary
+	collectBuildSummary bool
+	summaryPackages     *buildSummaryPackages
 }
 
 func createImageCustomizerParameters(buildDir string,
 	inputImageFile string,
 	configPath string, config *imagecustomizerapi.Config,
 	useBaseImageRpmRepos bool, rpmsSources []string, enableShrinkFilesystems bool, outputSplitPartitionsFormat string,
-	outputImageFormat string, outputImageFile string, outputPXEArtifactsDir string) (*ImageCustomizerParameters, error) {
+	outputImageFormat string, outputImageFile string, outputPXEArtifactsDir string, pluginRegistry *plugins.Registry,
+) (*ImageCustomizerParameters, error) {
 
 	ic := &ImageCustomizerParameters{}
 
@@ -106,8 +142,7 @@ func createImageCustomizerParameters(buildDir string,
 	ic.configPath = configPath
 	ic.config = config
 	ic.customizeOSPartitions = config.CustomizePartitions() || config.OS != nil ||
-		len(config.Scripts.PostCustomization) > 0 ||
-		len(config.Scripts.FinalizeCustomization) > 0
+		config.Scripts.HasScripts() || config.Hotfix != nil || config.InitrdRebuild != nil
 
 	ic.useBaseImageRpmRepos = useBaseImageRpmRepos
 	ic.rpmsSources = rpmsSources
@@ -125,23 +160,66 @@ func createImageCustomizerParameters(buildDir string,
 	// output image
 	ic.outputImageFormat = outputImageFormat
 	ic.outputIsIso = ic.outputImageFormat == ImageFormatIso
+	ic.outputIsContainer = isContainerImageFormat(ic.outputImageFormat)
+	ic.outputIsWsl = ic.outputImageFormat == ImageFormatWsl
 	ic.outputImageFile = outputImageFile
 	ic.outputImageBase = strings.TrimSuffix(filepath.Base(outputImageFile), filepath.Ext(outputImageFile))
 	ic.outputImageDir = filepath.Dir(outputImageFile)
 	ic.outputPXEArtifactsDir = outputPXEArtifactsDir
 
-	if ic.outputImageFormat != "" && !ic.outputIsIso {
+	ic.plugins = pluginRegistry
+
+	if ic.outputImageFormat != "" && !ic.outputIsIso && !ic.outputIsContainer && !ic.outputIsWsl {
 		err = validateImageFormat(ic.outputImageFormat)
 		if err != nil {
-			return nil, err
+			// The toolkit's own formats take precedence over the formats of plugins.
+			ic.outputConverter = pluginRegistry.Find(plugins.KindOutputConverter, ic.outputImageFormat)
+			if ic.outputConverter == nil {
+				return nil, err
+			}
 		}
 	}
 
+	if config.Signing != nil && config.Signing.Provider != "" &&
+		pluginRegistry.Find(plugins.KindSigningProvider, config.Signing.Provider) == nil {
+		return nil, fmt.Errorf("none of the plugins provide the signing provider (%s)", config.Signing.Provider)
+	}
+
 	if ic.outputPXEArtifactsDir != "" && !ic.outputIsIso {
 		return nil, fmt.Errorf("the output PXE artifacts directory ('--output-pxe-artifacts-dir') can be specified only if the output format is an iso image.")
 	}
 
+	if ic.outputIsIso && len(config.Storage.EncryptedVolumes) > 0 {
+		return nil, fmt.Errorf("encrypted volumes are not supported when the output image is an iso image")
+	}
+
+	if ic.outputIsIso && config.Storage.AbUpdate != nil {
+		return nil, fmt.Errorf("A/B update layouts are not supported when the output image is an iso image")
+	}
+
+	if ic.outputIsContainer && config.Hotfix != nil {
+		return nil, fmt.Errorf("hotfixes are not supported when the output image is a container image")
+	}
+
+	if ic.outputIsWsl && config.Hotfix != nil {
+		return nil, fmt.Errorf("hotfixes are not supported when the output image is a WSL rootfs")
+	}
+
+	if (ic.outputIsContainer || ic.outputIsWsl) && config.InitrdRebuild != nil {
+		return nil, fmt.Errorf("initrd rebuilds are not supported when the output image is a container image or a " +
+			"WSL rootfs")
+	}
+
+	if ic.enableShrinkFilesystems && config.Finalize != nil && config.Finalize.ShrinkFilesystems != nil {
+		return nil, fmt.Errorf("--shrink-filesystems cannot be combined with 'finalize.shrinkFilesystems'")
+	}
+
 	if ic.inputIsIso {
+		// The finalize steps operate on the disk image's file systems, which an iso image doesn't have.
+		if config.Finalize != nil {
+			return nil, fmt.Errorf("'finalize' is not supported when the input image is an iso image")
+		}
+
 		// When the input is an iso image, there's only one file system: the
 		// suqash file system and it has no empty space since it's a read-only
 		// file system. So, shrinking it does not make sense.
@@ -170,38 +248,125 @@ func createImageCustomizerParameters(buildDir string,
 		}
 	}
 
+	err = checkValidationSupported(ic)
+	if err != nil {
+		return nil, err
+	}
+
 	return ic, nil
 }
 
+// CustomizeImageOptions contains the optional settings for customizing an image.
+type CustomizeImageOptions struct {
+	// BuildStateDir is the directory of the build state store. If empty, then the build's state isn't recorded.
+	BuildStateDir string
+	// BuildId identifies the build within the build state store. Restarting a build with the same ID resumes it.
+	BuildId string
+	// ConfigFragmentFiles are config files that are layered on top of the config file, in order.
+	ConfigFragmentFiles []string
+	// MatrixCell is the name of the cell of the config file's matrix to build. The cell's base image (if it has one)
+	// replaces the image file.
+	MatrixCell string
+	// InputImageCacheDir is a directory to cache the raw conversions of input images in, so that they can be shared
+	// between builds. If empty, then the input image is converted for each build.
+	InputImageCacheDir string
+	// BaseImageSharing is how the build's writeable copy of a raw base image is created. If empty, then the base
+	// image is cloned if the filesystem supports it (BaseImageSharingAuto).
+	BaseImageSharing BaseImageSharing
+	// PackageCacheDir is a directory to cache the image in once its packages are installed, so that builds that only
+	// change the later customizations skip installing the packages. If empty, then the packages are always installed.
+	PackageCacheDir string
+	// OutputArtifactStore is the location of an artifact store (see artifactstore.Open) to store the output image in,
+	// split into content-defined chunks so that similar images share their storage. If empty, then the output image
+	// isn't stored.
+	OutputArtifactStore string
+	// ConfigBundle is the provenance of the config bundle that the config file was extracted from (see
+	// OpenConfigBundle). If set, then the config and every file it references must be within the bundle, and the
+	// provenance is recorded next to the output image.
+	ConfigBundle *ConfigBundleProvenance
+	// ConfigProvenanceKey is the PEM encoded private key that the config provenance is signed with. Required if
+	// ConfigBundle is set.
+	ConfigProvenanceKey string
+	// SummaryFile is the path to write a machine-readable (JSON) summary of the build to. The summary is written even
+	// if the build fails. If empty, then no summary is written.
+	SummaryFile string
+	// StepSummaryFile is the path of a markdown file to append a summary of the build to (e.g. GitHub Actions'
+	// $GITHUB_STEP_SUMMARY). If empty, then no step summary is written.
+	StepSummaryFile string
+	// PhaseValidators are checks that are run against the image at points within the build. If any of them fail,
+	// then the build fails.
+	PhaseValidators []PhaseValidator
+	// PluginsDir is a directory of plugins (see the plugins package) that provide output formats, base image fetchers,
+	// and signing providers. If empty, then no plugins are loaded.
+	PluginsDir string
+}
+
 func CustomizeImageWithConfigFile(buildDir string, configFile string, imageFile string,
 	rpmsSources []string, outputImageFile string, outputImageFormat string,
 	outputSplitPartitionsFormat string, outputPXEArtifactsDir string,
 	useBaseImageRpmRepos bool, enableShrinkFilesystems bool,
 ) error {
-	var err error
+	return CustomizeImageWithConfigFileAndOptions(buildDir, configFile, imageFile, rpmsSources, outputImageFile,
+		outputImageFormat, outputSplitPartitionsFormat, outputPXEArtifactsDir, useBaseImageRpmRepos,
+		enableShrinkFilesystems, CustomizeImageOptions{})
+}
 
+func CustomizeImageWithConfigFileAndOptions(buildDir string, configFile string, imageFile string,
+	rpmsSources []string, outputImageFile string, outputImageFormat string,
+	outputSplitPartitionsFormat string, outputPXEArtifactsDir string,
+	useBaseImageRpmRepos bool, enableShrinkFilesystems bool, options CustomizeImageOptions,
+) error {
 	logVersionsOfToolDeps()
 
-	var config imagecustomizerapi.Config
-	err = imagecustomizerapi.UnmarshalYamlFile(configFile, &config)
+	config, absBaseConfigPath, imageFile, err := loadConfigFile(configFile, imageFile, options)
 	if err != nil {
 		return err
 	}
 
+	err = CustomizeImageWithOptions(buildDir, absBaseConfigPath, config, imageFile, rpmsSources, outputImageFile,
+		outputImageFormat, outputSplitPartitionsFormat, outputPXEArtifactsDir, useBaseImageRpmRepos,
+		enableShrinkFilesystems, options)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loadConfigFile reads the config file (along with its fragments and matrix cell) and returns the config, the
+// absolute path of the config file's directory, and the image file to customize.
+func loadConfigFile(configFile string, imageFile string, options CustomizeImageOptions,
+) (*imagecustomizerapi.Config, string, string, error) {
+	var config imagecustomizerapi.Config
+	var cell imagecustomizerapi.MatrixCell
+	var err error
+	if options.ConfigBundle != nil {
+		cell, err = imagecustomizerapi.UnmarshalConfigFileWithinDir(configFile, options.ConfigBundle.ContentDir,
+			options.MatrixCell, options.ConfigFragmentFiles, &config)
+	} else {
+		cell, err = imagecustomizerapi.UnmarshalConfigFileMatrixCell(configFile, options.MatrixCell,
+			options.ConfigFragmentFiles, &config)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
 	baseConfigPath, _ := filepath.Split(configFile)
 
 	absBaseConfigPath, err := filepath.Abs(baseConfigPath)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path of config file directory:\n%w", err)
+		return nil, "", "", fmt.Errorf("failed to get absolute path of config file directory:\n%w", err)
 	}
 
-	err = CustomizeImage(buildDir, absBaseConfigPath, &config, imageFile, rpmsSources, outputImageFile, outputImageFormat,
-		outputSplitPartitionsFormat, outputPXEArtifactsDir, useBaseImageRpmRepos, enableShrinkFilesystems)
-	if err != nil {
-		return err
+	if cell.ImageFile != "" {
+		imageFile = cell.ImageFile
+		if !isImageUrl(imageFile) {
+			imageFile = file.GetAbsPathWithBase(absBaseConfigPath, imageFile)
+		}
+		logger.Log.Infof("Using base image of matrix cell (%s): %s", cell.Name, imageFile)
 	}
 
-	return nil
+	return &config, absBaseConfigPath, imageFile, nil
 }
 
 func cleanUp(ic *ImageCustomizerParameters) error {
@@ -217,18 +382,81 @@ func CustomizeImage(buildDir string, baseConfigPath string, config *imagecustomi
 	rpmsSources []string, outputImageFile string, outputImageFormat string, outputSplitPartitionsFormat string,
 	outputPXEArtifactsDir string, useBaseImageRpmRepos bool, enableShrinkFilesystems bool,
 ) error {
-	err := validateConfig(baseConfigPath, config, rpmsSources, useBaseImageRpmRepos)
+	return CustomizeImageWithOptions(buildDir, baseConfigPath, config, imageFile, rpmsSources, outputImageFile,
+		outputImageFormat, outputSplitPartitionsFormat, outputPXEArtifactsDir, useBaseImageRpmRepos,
+		enableShrinkFilesystems, CustomizeImageOptions{})
+}
+
+func CustomizeImageWithOptions(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
+	imageFile string, rpmsSources []string, outputImageFile string, outputImageFormat string,
+	outputSplitPartitionsFormat string, outputPXEArtifactsDir string, useBaseImageRpmRepos bool,
+	enableShrinkFilesystems bool, options CustomizeImageOptions,
+) (err error) {
+	// Track the build's summary first, so that the summary covers every way in which the build can fail.
+	var imageCustomizerParameters *ImageCustomizerParameters
+	summaryTracker := newBuildSummaryTracker(options, imageFile, outputImageFile, outputImageFormat)
+	if summaryTracker != nil {
+		defer func() {
+			summaryErr := summaryTracker.finish(imageCustomizerParameters, err)
+			if summaryErr != nil {
+				if err != nil {
+					err = fmt.Errorf("%w:\nfailed to write build summary:\n%w", err, summaryErr)
+				} else {
+					err = summaryErr
+				}
+			}
+		}()
+	}
+
+	err = validateConfig(baseConfigPath, config, rpmsSources, useBaseImageRpmRepos)
 	if err != nil {
 		return fmt.Errorf("invalid image config:\n%w", err)
 	}
 
-	imageCustomizerParameters, err := createImageCustomizerParameters(buildDir, imageFile,
+	if options.ConfigBundle != nil {
+		err = validateConfigBundleInputs(baseConfigPath, config, rpmsSources, options)
+		if err != nil {
+			return fmt.Errorf("invalid config bundle:\n%w", err)
+		}
+	}
+
+	err = options.BaseImageSharing.IsValid()
+	if err != nil {
+		return err
+	}
+
+	pluginRegistry, err := plugins.Discover(options.PluginsDir)
+	if err != nil {
+		return fmt.Errorf("failed to load plugins:\n%w", err)
+	}
+
+	if isImageUrl(imageFile) {
+		fetchDir := filepath.Join(buildDir, fetchedImageDirName)
+		defer os.RemoveAll(fetchDir)
+
+		imageFile, err = fetchBaseImage(pluginRegistry, fetchDir, imageFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	imageCustomizerParameters, err = createImageCustomizerParameters(buildDir, imageFile,
 		baseConfigPath, config,
 		useBaseImageRpmRepos, rpmsSources, enableShrinkFilesystems, outputSplitPartitionsFormat,
-		outputImageFormat, outputImageFile, outputPXEArtifactsDir)
+		outputImageFormat, outputImageFile, outputPXEArtifactsDir, pluginRegistry)
 	if err != nil {
 		return fmt.Errorf("failed to create image customizer parameters object:\n%w", err)
 	}
+	imageCustomizerParameters.inputImageCacheDir = options.InputImageCacheDir
+	imageCustomizerParameters.baseImageSharing = options.BaseImageSharing
+	imageCustomizerParameters.packageCacheDir = options.PackageCacheDir
+	imageCustomizerParameters.collectBuildSummary = summaryTracker != nil
+	imageCustomizerParameters.phaseValidators = options.PhaseValidators
+
+	err = checkPhaseValidators(imageCustomizerParameters)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		cleanupErr := cleanUp(imageCustomizerParameters)
 		if cleanupErr != nil {
@@ -256,7 +484,20 @@ func CustomizeImage(buildDir string, baseConfigPath string, config *imagecustomi
 		return err
 	}
 
-	inputIsoArtifacts, err := convertInputImageToWriteableFormat(imageCustomizerParameters)
+	recorder, err := newBuildStateRecorder(options, imageCustomizerParameters)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		recorder.finish(err)
+	}()
+
+	var inputIsoArtifacts *LiveOSIsoBuilder
+	err = recorder.runPhase(buildPhaseConvertInput, func() error {
+		var convertErr error
+		inputIsoArtifacts, convertErr = convertInputImageToWriteableFormat(imageCustomizerParameters)
+		return convertErr
+	})
 	if err != nil {
 		return fmt.Errorf("failed to convert input image to a raw image:\n%w", err)
 	}
@@ -273,16 +514,54 @@ func CustomizeImage(buildDir string, baseConfigPath string, config *imagecustomi
 		}
 	}()
 
-	err = customizeOSContents(imageCustomizerParameters)
+	err = recorder.runPhase(buildPhaseCustomizeOS, func() error {
+		return customizeOSContents(imageCustomizerParameters)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to customize raw image:\n%w", err)
 	}
 
-	err = convertWriteableFormatToOutputImage(imageCustomizerParameters, inputIsoArtifacts)
+	err = recorder.runPhase(buildPhaseConvertOutput, func() error {
+		return convertWriteableFormatToOutputImage(imageCustomizerParameters, inputIsoArtifacts)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to convert customized raw image to output format:\n%w", err)
 	}
 
+	if config.Validation != nil {
+		if config.Validation.BootSmokeTest != nil {
+			result := runBootSmokeTest(baseConfigPath, config.Validation.BootSmokeTest,
+				imageCustomizerParameters.outputImageFile, imageCustomizerParameters.outputImageFormat,
+				imageCustomizerParameters.imageArch)
+			imageCustomizerParameters.validationResults = append(imageCustomizerParameters.validationResults, result)
+		}
+
+		err = writeValidationReport(imageCustomizerParameters.outputImageDir,
+			imageCustomizerParameters.outputImageBase, imageCustomizerParameters.validationResults)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeArtifactMetadata(imageCustomizerParameters)
+	if err != nil {
+		return err
+	}
+
+	if options.OutputArtifactStore != "" {
+		err = storeOutputArtifact(options.OutputArtifactStore, imageCustomizerParameters.outputImageFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.ConfigBundle != nil {
+		err = writeConfigProvenance(imageCustomizerParameters, options.ConfigBundle, options.ConfigProvenanceKey)
+		if err != nil {
+			return err
+		}
+	}
+
 	logger.Log.Infof("Success!")
 
 	return nil
@@ -310,10 +589,18 @@ func convertInputImageToWriteableFormat(ic *ImageCustomizerParameters) (*LiveOSI
 
 		return inputIsoArtifacts, nil
 	} else {
-		logger.Log.Infof("Creating raw base image: %s", ic.rawImageFile)
-		err := shell.ExecuteLiveWithErr(1, "qemu-img", "convert", "-O", "raw", ic.inputImageFile, ic.rawImageFile)
+		inputImageFile := ic.inputImageFile
+		if ic.inputImageCacheDir != "" {
+			var err error
+			inputImageFile, err = CacheInputImage(ic.inputImageCacheDir, ic.inputImageFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := createRawBaseImage(inputImageFile, ic.rawImageFile, ic.baseImageSharing)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert image file to raw format:\n%w", err)
+			return nil, err
 		}
 
 		return nil, nil
@@ -353,13 +640,56 @@ func customizeOSContents(ic *ImageCustomizerParameters) error {
 		return err
 	}
 
-	// Customize the partitions.
-	partitionsCustomized, newRawImageFile, partIdToPartUuid, err := customizePartitions(ic.buildDirAbs,
-		ic.configPath, ic.config, ic.rawImageFile)
+	// Check the config's assumptions about the base image before the image is modified.
+	if !ic.inputIsIso {
+		err = checkBaseImageCompatibility(ic.buildDirAbs, ic.config, ic.rawImageFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	packageCache, err := newPackageStageCache(ic)
 	if err != nil {
 		return err
 	}
-	ic.rawImageFile = newRawImageFile
+
+	// A cached package stage already has its partitions customized and its packages installed.
+	var stage *packageStage
+	if packageCache != nil {
+		stage, err = packageCache.restore(ic.rawImageFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	var partitionsCustomized bool
+	var partIdToPartUuid map[string]string
+	if stage != nil {
+		partitionsCustomized = stage.PartitionsCustomized
+		partIdToPartUuid = stage.PartIdToPartUuid
+	} else {
+		// Customize the partitions.
+		var newRawImageFile string
+		partitionsCustomized, newRawImageFile, partIdToPartUuid, err = customizePartitions(ic.buildDirAbs,
+			ic.configPath, ic.config, ic.rawImageFile)
+		if err != nil {
+			return err
+		}
+		ic.rawImageFile = newRawImageFile
+
+		if packageCache != nil {
+			stage, err = runPackageStage(ic.buildDirAbs, ic.configPath, ic.config, ic.rawImageFile, ic.rpmsSources,
+				ic.useBaseImageRpmRepos, partitionsCustomized, partIdToPartUuid)
+			if err != nil {
+				return err
+			}
+
+			err = packageCache.save(ic.rawImageFile, stage)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
 	// Create a uuid for the image
 	imageUuid, imageUuidStr, err := createUuid()
@@ -368,20 +698,106 @@ func customizeOSContents(ic *ImageCustomizerParameters) error {
 	}
 
 	// Customize the raw image file.
-	err = customizeImageHelper(ic.buildDirAbs, ic.configPath, ic.config, ic.rawImageFile, ic.rpmsSources,
-		ic.useBaseImageRpmRepos, partitionsCustomized, imageUuidStr)
+	changeManifest, selinuxReport, hotfixReport, err := customizeImageHelper(ic.buildDirAbs, ic.configPath,
+		ic.config, ic.rawImageFile, ic.rpmsSources, ic.useBaseImageRpmRepos, partitionsCustomized, partIdToPartUuid,
+		stage, imageUuidStr, ic.outputImageFormat, ic.phaseValidators, ic.plugins)
 	if err != nil {
 		return err
 	}
 
+	if hotfixReport != nil {
+		hotfixReportFile := filepath.Join(ic.outputImageDir, ic.outputImageBase+hotfixReportFileSuffix)
+		err = jsonutils.WriteJSONFile(hotfixReportFile, hotfixReport)
+		if err != nil {
+			return fmt.Errorf("failed to write hotfix report (%s):\n%w", hotfixReportFile, err)
+		}
+	}
+
+	if changeManifest != nil {
+		changeManifestFile := filepath.Join(ic.outputImageDir, ic.outputImageBase+changeManifestFileSuffix)
+		err = changemanifest.Write(changeManifest, changeManifestFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if selinuxReport != nil {
+		selinuxReportFile := filepath.Join(ic.outputImageDir, ic.outputImageBase+selinuxReportFileSuffix)
+		err = selinuxreport.Write(selinuxReport, selinuxReportFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ic.collectBuildSummary {
+		ic.summaryPackages, err = getBuildSummaryPackages(ic.buildDirAbs, ic.rawImageFile, changeManifest)
+		if err != nil {
+			return fmt.Errorf("failed to count packages for build summary:\n%w", err)
+		}
+	}
+
+	// The boot smoke test's VM must match the image's architecture, which is read before the steps that might make the
+	// filesystems unreadable (e.g. encryption).
+	if ic.config.Validation != nil && ic.config.Validation.BootSmokeTest != nil {
+		ic.imageArch, err = getRawImageArch(ic.buildDirAbs, ic.rawImageFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Validate the image's contents once the OS has been customized, before the steps that might make the filesystems
+	// unreadable (e.g. encryption).
+	if ic.config.Validation != nil && ic.config.Validation.HasImageChecks() {
+		ic.validationResults, err = validateImageContents(ic.buildDirAbs, ic.config.Validation, ic.rawImageFile)
+		if err != nil {
+			return fmt.Errorf("failed to validate image:\n%w", err)
+		}
+
+		// Fail early, rather than after the output image has been written.
+		if slices.ContainsFunc(ic.validationResults, func(r validationCheckResult) bool { return !r.Passed }) {
+			return writeValidationReport(ic.outputImageDir, ic.outputImageBase, ic.validationResults)
+		}
+	}
+
+	err = runBeforeOutputValidators(ic)
+	if err != nil {
+		return err
+	}
+
+	if getModuleSigning(ic.config.OS) != nil {
+		err = writeModuleSigningArtifacts(ic.buildDirAbs, ic.outputImageDir, ic.outputImageBase)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ic.config.OS != nil && ic.config.OS.CloudInit != nil && ic.config.OS.CloudInit.NoCloud != nil &&
+		ic.config.OS.CloudInit.NoCloud.SeedLocation == imagecustomizerapi.CloudInitSeedLocationIso {
+		seedIsoFile := filepath.Join(ic.outputImageDir, ic.outputImageBase+cloudInitSeedIsoFileSuffix)
+		err = createCloudInitSeedIso(ic.buildDirAbs, ic.configPath, ic.config.OS.CloudInit.NoCloud, seedIsoFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Shrink the filesystems.
 	if ic.enableShrinkFilesystems {
-		err = shrinkFilesystemsHelper(ic.rawImageFile, ic.config.Storage.Verity, partIdToPartUuid)
+		err = shrinkFilesystemsHelper(ic.rawImageFile, &ic.config.Storage, partIdToPartUuid)
 		if err != nil {
 			return fmt.Errorf("failed to shrink filesystems:\n%w", err)
 		}
 	}
 
+	// Shrink and trim the filesystems before the verity hash trees are calculated and the A/B update slot is copied,
+	// since both depend on the final contents of the partitions.
+	if ic.config.Finalize != nil {
+		err = finalizeImageHelper(ic.buildDirAbs, ic.rawImageFile, &ic.config.Storage, ic.config.Finalize,
+			partIdToPartUuid)
+		if err != nil {
+			return err
+		}
+	}
+
 	if len(ic.config.Storage.Verity) > 0 {
 		// Customize image for dm-verity, setting up verity metadata and security features.
 		err = customizeVerityImageHelper(ic.buildDirAbs, ic.configPath, ic.config, ic.rawImageFile, partIdToPartUuid)
@@ -390,12 +806,36 @@ func customizeOSContents(ic *ImageCustomizerParameters) error {
 		}
 	}
 
+	if ic.config.Storage.AbUpdate != nil {
+		// Create slot B from the finished slot A.
+		err = createAbUpdateSlotHelper(ic.buildDirAbs, ic.config, ic.rawImageFile, partIdToPartUuid)
+		if err != nil {
+			return fmt.Errorf("failed to create A/B update slot:\n%w", err)
+		}
+	}
+
 	// Check file systems for corruption.
 	err = checkFileSystems(ic.rawImageFile)
 	if err != nil {
 		return fmt.Errorf("failed to check filesystems:\n%w", err)
 	}
 
+	if len(ic.config.Storage.EncryptedVolumes) > 0 {
+		// Encrypt the partitions last, since the earlier steps need to access the filesystems.
+		err = encryptVolumesHelper(ic.buildDirAbs, ic.configPath, ic.config, ic.rawImageFile, partIdToPartUuid)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt volumes:\n%w", err)
+		}
+	}
+
+	if len(ic.config.Storage.RawBlobs) > 0 {
+		// Write the raw blobs last, so that they aren't disturbed by the changes to the partitions.
+		err = writeRawBlobs(ic.configPath, &ic.config.Storage, ic.rawImageFile)
+		if err != nil {
+			return fmt.Errorf("failed to write raw blobs:\n%w", err)
+		}
+	}
+
 	// If outputSplitPartitionsFormat is specified, extract the partition files.
 	if ic.outputSplitPartitionsFormat != "" {
 		logger.Log.Infof("Extracting partition files")
@@ -411,16 +851,44 @@ func customizeOSContents(ic *ImageCustomizerParameters) error {
 func convertWriteableFormatToOutputImage(ic *ImageCustomizerParameters, inputIsoArtifacts *LiveOSIsoBuilder) error {
 	logger.Log.Infof("Converting customized OS partitions into the final image")
 
+	if ic.outputConverter != nil {
+		return convertOutputImageWithPlugin(ic.outputConverter, ic.rawImageFile, ic.outputImageFile,
+			ic.outputImageFormat)
+	}
+
 	// Create final output image file if requested.
 	switch ic.outputImageFormat {
-	case ImageFormatVhd, ImageFormatVhdFixed, ImageFormatVhdx, ImageFormatQCow2, ImageFormatRaw:
+	case ImageFormatVhd, ImageFormatVhdFixed, ImageFormatVhdx, ImageFormatQCow2, ImageFormatQCow2Compressed,
+		ImageFormatRaw, ImageFormatRawZst:
 		logger.Log.Infof("Writing: %s", ic.outputImageFile)
 
-		err := convertImageFile(ic.rawImageFile, ic.outputImageFile, ic.outputImageFormat)
+		_, err := imageconvert.ConvertAndChecksum(ic.rawImageFile, ic.outputImageFile, ic.outputImageFormat)
 		if err != nil {
 			return err
 		}
 
+		if ic.config.Finalize != nil && ic.config.Finalize.Sparse && isSparseImageFormat(ic.outputImageFormat) {
+			err = makeFileSparse(ic.outputImageFile)
+			if err != nil {
+				return err
+			}
+		}
+
+	case ImageFormatOci, ImageFormatDockerArchive:
+		err := createContainerImageArchive(ic.buildDirAbs, ic.config.ContainerImage, ic.outputImageFormat,
+			ic.outputImageFile)
+		if err != nil {
+			return fmt.Errorf("failed to create container image:\n%w", err)
+		}
+
+	case ImageFormatWsl:
+		logger.Log.Infof("Writing: %s", ic.outputImageFile)
+
+		err := file.Copy(getWslRootfsFile(ic.buildDirAbs), ic.outputImageFile)
+		if err != nil {
+			return fmt.Errorf("failed to write WSL rootfs:\n%w", err)
+		}
+
 	case ImageFormatIso:
 		if ic.customizeOSPartitions || inputIsoArtifacts == nil {
 			err := createLiveOSIsoImage(ic.buildDir, ic.configPath, inputIsoArtifacts, ic.config.Iso, ic.config.Pxe, ic.rawImageFile,
@@ -440,50 +908,8 @@ func convertWriteableFormatToOutputImage(ic *ImageCustomizerParameters, inputIso
 	return nil
 }
 
-func convertImageFile(inputPath string, outputPath string, format string) error {
-	qemuImageFormat, qemuOptions := toQemuImageFormat(format)
-
-	qemuImgArgs := []string{"convert", "-O", qemuImageFormat}
-	if qemuOptions != "" {
-		qemuImgArgs = append(qemuImgArgs, "-o", qemuOptions)
-	}
-	qemuImgArgs = append(qemuImgArgs, inputPath, outputPath)
-
-	err := shell.ExecuteLiveWithErr(1, "qemu-img", qemuImgArgs...)
-	if err != nil {
-		return fmt.Errorf("failed to convert image file to format: %s:\n%w", format, err)
-	}
-
-	return nil
-}
-
 func validateImageFormat(imageFormat string) error {
-	switch imageFormat {
-	case ImageFormatVhd, ImageFormatVhdFixed, ImageFormatVhdx, ImageFormatRaw, ImageFormatQCow2:
-		return nil
-
-	default:
-		return fmt.Errorf("unsupported image format (supported: vhd, vhd-fixed, vhdx, raw, qcow2): %s", imageFormat)
-	}
-}
-
-func toQemuImageFormat(imageFormat string) (string, string) {
-	switch imageFormat {
-	case ImageFormatVhd:
-		return QemuFormatVpc, ""
-
-	case ImageFormatVhdFixed:
-		return QemuFormatVpc, "subformat=fixed,force_size"
-
-	case ImageFormatVhdx:
-		// For VHDX, qemu-img dynamically picks the block-size based on the size of the disk.
-		// However, this can result in a significantly larger file size than other formats.
-		// So, use a fixed block-size of 2 MiB to match the block-sizes used for qcow2 and VHD.
-		return ImageFormatVhdx, "block_size=2097152"
-
-	default:
-		return imageFormat, ""
-	}
+	return imageconvert.IsValidFormat(imageFormat)
 }
 
 func validateSplitPartitionsFormat(partitionFormat string) error {
@@ -520,6 +946,30 @@ func validateConfig(baseConfigPath string, config *imagecustomizerapi.Config, rp
 		return err
 	}
 
+	if config.Hotfix != nil {
+		_, err = collectHotfixRpms(baseConfigPath, config.Hotfix.Rpms)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.InitrdRebuild != nil {
+		err = validateInitrdRebuild(baseConfigPath, config.InitrdRebuild)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateSigning(baseConfigPath, config.Signing)
+	if err != nil {
+		return err
+	}
+
+	_, err = getRawBlobWrites(baseConfigPath, &config.Storage)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -577,6 +1027,31 @@ func validateSystemConfig(baseConfigPath string, config *imagecustomizerapi.OS,
 		return err
 	}
 
+	// Render the templates up front, so that a missing variable fails the build before the image is customized.
+	for _, additionalFile := range config.AdditionalFiles {
+		if additionalFile.Template {
+			_, err = renderAdditionalFileTemplate(baseConfigPath, additionalFile, config.TemplateVariables)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = validateIdLedger(baseConfigPath, config)
+	if err != nil {
+		return err
+	}
+
+	err = validateModuleSigning(baseConfigPath, config.ModuleSigning)
+	if err != nil {
+		return err
+	}
+
+	err = validateProxy(baseConfigPath, config.Proxy)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -585,6 +1060,20 @@ func validateScripts(baseConfigPath string, scripts *imagecustomizerapi.Scripts)
 		return nil
 	}
 
+	for i, script := range scripts.PostPackageInstall {
+		err := validateScript(baseConfigPath, &script)
+		if err != nil {
+			return fmt.Errorf("invalid postPackageInstall item at index %d:\n%w", i, err)
+		}
+	}
+
+	for i, script := range scripts.PostConfig {
+		err := validateScript(baseConfigPath, &script)
+		if err != nil {
+			return fmt.Errorf("invalid postConfig item at index %d:\n%w", i, err)
+		}
+	}
+
 	for i, script := range scripts.PostCustomization {
 		err := validateScript(baseConfigPath, &script)
 		if err != nil {
@@ -599,6 +1088,81 @@ func validateScripts(baseConfigPath string, scripts *imagecustomizerapi.Scripts)
 		}
 	}
 
+	for i, script := range scripts.FinalizeOutsideChroot {
+		err := validateScript(baseConfigPath, &script)
+		if err != nil {
+			return fmt.Errorf("invalid finalizeOutsideChroot item at index %d:\n%w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func validateSigning(baseConfigPath string, signing *imagecustomizerapi.Signing) error {
+	if signing == nil {
+		return nil
+	}
+
+	if signing.Command != nil {
+		err := validateScript(baseConfigPath, signing.Command)
+		if err != nil {
+			return fmt.Errorf("invalid signing command:\n%w", err)
+		}
+	}
+
+	if signing.Endpoint != nil && signing.Endpoint.BearerTokenEnvironmentVariable != "" {
+		_, found := os.LookupEnv(signing.Endpoint.BearerTokenEnvironmentVariable)
+		if !found {
+			return fmt.Errorf("signing endpoint's bearer token environment variable (%s) is not set",
+				signing.Endpoint.BearerTokenEnvironmentVariable)
+		}
+	}
+
+	return nil
+}
+
+func validateModuleSigning(baseConfigPath string, moduleSigning *imagecustomizerapi.ModuleSigning) error {
+	if moduleSigning == nil {
+		return nil
+	}
+
+	if moduleSigning.Key != nil {
+		keyPath := file.GetAbsPathWithBase(baseConfigPath, moduleSigning.Key.PrivateKeyPath)
+		isFile, err := file.IsFile(keyPath)
+		if err != nil || !isFile {
+			return fmt.Errorf("invalid module signing private key file (%s)", moduleSigning.Key.PrivateKeyPath)
+		}
+
+		certificatePath := file.GetAbsPathWithBase(baseConfigPath, moduleSigning.Key.CertificatePath)
+		_, _, err = readModuleSigningCertificate(certificatePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if moduleSigning.Enrollment != nil && moduleSigning.Enrollment.PasswordEnvironmentVariable != "" {
+		password, found := os.LookupEnv(moduleSigning.Enrollment.PasswordEnvironmentVariable)
+		if !found || password == "" {
+			return fmt.Errorf("MOK enrollment password environment variable (%s) is not set",
+				moduleSigning.Enrollment.PasswordEnvironmentVariable)
+		}
+	}
+
+	return nil
+}
+
+func validateProxy(baseConfigPath string, proxy *imagecustomizerapi.Proxy) error {
+	if proxy == nil {
+		return nil
+	}
+
+	for _, caCertificatePath := range proxy.CaCertificatePaths {
+		_, err := readProxyCaCertificates(file.GetAbsPathWithBase(baseConfigPath, caCertificatePath))
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -622,6 +1186,31 @@ func validateScript(baseConfigPath string, script *imagecustomizerapi.Script) er
 	return nil
 }
 
+func validateLocalRepos(baseConfigPath string, localRepos []imagecustomizerapi.LocalRepo) error {
+	for i, localRepo := range localRepos {
+		repoPath := file.GetAbsPathWithBase(baseConfigPath, localRepo.Path)
+
+		isDir, err := file.IsDir(repoPath)
+		if err != nil {
+			return fmt.Errorf("invalid localRepos item at index %d:\ncouldn't read directory (%s):\n%w", i, localRepo.Path, err)
+		}
+		if !isDir {
+			return fmt.Errorf("invalid localRepos item at index %d:\npath (%s) is not a directory", i, localRepo.Path)
+		}
+
+		for _, gpgKey := range localRepo.GpgKeys {
+			gpgKeyPath := file.GetAbsPathWithBase(baseConfigPath, gpgKey)
+
+			_, err := os.Stat(gpgKeyPath)
+			if err != nil {
+				return fmt.Errorf("invalid localRepos item at index %d:\ncouldn't read GPG key file (%s):\n%w", i, gpgKey, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func validatePackageLists(baseConfigPath string, config *imagecustomizerapi.OS, rpmsSources []string,
 	useBaseImageRpmRepos bool,
 ) error {
@@ -645,7 +1234,18 @@ func validatePackageLists(baseConfigPath string, config *imagecustomizerapi.OS,
 		return err
 	}
 
-	hasRpmSources := len(rpmsSources) > 0 || useBaseImageRpmRepos
+	for _, packageName := range allPackagesRemove {
+		if slices.Contains(config.Packages.ProtectedPackages, packageName) {
+			return fmt.Errorf("package (%s) cannot be both removed and in 'protectedPackages'", packageName)
+		}
+	}
+
+	err = validateLocalRepos(baseConfigPath, config.Packages.LocalRepos)
+	if err != nil {
+		return err
+	}
+
+	hasRpmSources := len(rpmsSources) > 0 || len(config.Packages.LocalRepos) > 0 || useBaseImageRpmRepos
 
 	if !hasRpmSources {
 		needRpmsSources := len(allPackagesInstall) > 0 || len(allPackagesUpdate) > 0 ||
@@ -669,34 +1269,148 @@ func validatePackageLists(baseConfigPath string, config *imagecustomizerapi.OS,
 
 func customizeImageHelper(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
 	rawImageFile string, rpmsSources []string, useBaseImageRpmRepos bool, partitionsCustomized bool,
-	imageUuidStr string,
-) error {
+	partIdToPartUuid map[string]string, stage *packageStage, imageUuidStr string, outputImageFormat string,
+	phaseValidators []PhaseValidator, pluginRegistry *plugins.Registry,
+) (*changemanifest.Manifest, *selinuxreport.Report, *hotfixReport, error) {
 	logger.Log.Debugf("Customizing OS")
 
+	writeContainerLayers := isContainerImageFormat(outputImageFormat)
+	writeWslRootfs := outputImageFormat == ImageFormatWsl
+
 	imageConnection, err := connectToExistingImage(rawImageFile, buildDir, "imageroot", true)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	defer imageConnection.Close()
 
+	var containerBaseSnapshot map[string]fileSnapshotEntry
+	if writeContainerLayers {
+		containerBaseSnapshot, err = writeContainerBaseLayer(buildDir, config.ContainerImage,
+			imageConnection.Chroot().RootDir())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var tracker *changeTracker
+	if config.ChangeManifest != nil {
+		tracker, err = newChangeTracker(imageConnection.Chroot())
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to record OS state for change manifest:\n%w", err)
+		}
+	}
+
+	selinuxReportBuilder, err := newSELinuxReportBuilder(config.SELinuxReport, imageConnection.Chroot())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to record OS state for SELinux report:\n%w", err)
+	}
+
 	// Do the actual customizations.
-	err = doOsCustomizations(buildDir, baseConfigPath, config, imageConnection, rpmsSources,
-		useBaseImageRpmRepos, partitionsCustomized, imageUuidStr)
+	var report *hotfixReport
+	var orphansRemoved []string
+	switch {
+	case config.Hotfix != nil:
+		report, err = applyHotfix(baseConfigPath, config.Hotfix, imageConnection.Chroot(), imageUuidStr)
+		if err == nil {
+			err = runHotfixPhaseValidators(buildDir, phaseValidators, imageConnection.Chroot().RootDir(), config)
+		}
+
+	case config.InitrdRebuild != nil:
+		err = rebuildInitrd(baseConfigPath, config.InitrdRebuild, imageConnection.Chroot(), imageUuidStr)
+		if err == nil {
+			err = runHotfixPhaseValidators(buildDir, phaseValidators, imageConnection.Chroot().RootDir(), config)
+		}
+
+	default:
+		orphansRemoved, err = doOsCustomizations(buildDir, baseConfigPath, config, imageConnection, rpmsSources,
+			useBaseImageRpmRepos, partitionsCustomized, partIdToPartUuid, stage, imageUuidStr, phaseValidators,
+			selinuxReportBuilder)
+	}
 
 	// Out of disk space errors can be difficult to diagnose.
 	// So, warn about any partitions with low free space.
 	warnOnLowFreeSpace(buildDir, imageConnection)
 
 	if err != nil {
-		return err
+		return nil, nil, nil, err
+	}
+
+	err = signBootArtifacts(buildDir, baseConfigPath, config.Signing, pluginRegistry,
+		imageConnection.Chroot().RootDir())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if writeWslRootfs {
+		err = customizeWsl(config.Wsl, imageConnection.Chroot().RootDir())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var changeManifest *changemanifest.Manifest
+	if tracker != nil {
+		changeManifest, err = tracker.createManifest(imageConnection.Chroot())
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create change manifest:\n%w", err)
+		}
+
+		// A hotfix or an initrd rebuild doesn't copy any files, run any scripts, or remove any packages.
+		if config.Hotfix == nil && config.InitrdRebuild == nil {
+			if orphansRemoved != nil {
+				changeManifest.Packages.OrphansRemoved = orphansRemoved
+			}
+
+			changeManifest.InjectedFiles, err = createInjectedFilesBom(baseConfigPath, config.OS,
+				imageConnection.Chroot().RootDir())
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to list injected files for change manifest:\n%w", err)
+			}
+
+			changeManifest.Scripts, err = createScriptsBom(baseConfigPath, config.Scripts)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to list scripts for change manifest:\n%w", err)
+			}
+		}
+
+		if config.ChangeManifest.ImagePath != "" {
+			err = changemanifest.Write(changeManifest,
+				filepath.Join(imageConnection.Chroot().RootDir(), config.ChangeManifest.ImagePath))
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	var selinuxReport *selinuxreport.Report
+	if selinuxReportBuilder != nil {
+		selinuxReport, err = selinuxReportBuilder.createReport(imageConnection.Chroot())
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create SELinux report:\n%w", err)
+		}
+	}
+
+	if writeContainerLayers {
+		err = writeContainerTopLayer(buildDir, config.ContainerImage, imageConnection.Chroot().RootDir(),
+			containerBaseSnapshot)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if writeWslRootfs {
+		err = writeWslRootfsFile(buildDir, imageConnection.Chroot().RootDir())
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
 
 	err = imageConnection.CleanClose()
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
-	return nil
+	return changeManifest, selinuxReport, report, nil
 }
 
 func extractPartitionsHelper(rawImageFile string, outputDir string, outputBasename string, outputSplitPartitionsFormat string, imageUuid [UuidSize]byte) error {
@@ -720,7 +1434,7 @@ func extractPartitionsHelper(rawImageFile string, outputDir string, outputBasena
 	return nil
 }
 
-func shrinkFilesystemsHelper(buildImageFile string, verity []imagecustomizerapi.Verity,
+func shrinkFilesystemsHelper(buildImageFile string, storage *imagecustomizerapi.Storage,
 	partIdToPartUuid map[string]string,
 ) error {
 	imageLoopback, err := safeloopback.NewLoopback(buildImageFile)
@@ -730,7 +1444,7 @@ func shrinkFilesystemsHelper(buildImageFile string, verity []imagecustomizerapi.
 	defer imageLoopback.Close()
 
 	// Shrink the filesystems.
-	err = shrinkFilesystems(imageLoopback.DevicePath(), verity, partIdToPartUuid)
+	err = shrinkFilesystems(imageLoopback.DevicePath(), storage, partIdToPartUuid, 0, false)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/plugins"
+)
+
+const (
+	fetchedImageDirName = "fetchedimage"
+)
+
+// isImageUrl returns true if the image file is a URL (e.g. "acr://registry/images/base:3.0") that a base image
+// fetcher plugin downloads, rather than a path.
+func isImageUrl(imageFile string) bool {
+	if !strings.Contains(imageFile, "://") {
+		return false
+	}
+
+	imageUrl, err := url.Parse(imageFile)
+	return err == nil && imageUrl.Scheme != ""
+}
+
+// findBaseImageFetcher finds the plugin that fetches the base image URL.
+func findBaseImageFetcher(pluginRegistry *plugins.Registry, imageUrl string) (*plugins.Plugin, error) {
+	parsedUrl, err := url.Parse(imageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base image URL (%s):\n%w", imageUrl, err)
+	}
+
+	fetcher := pluginRegistry.Find(plugins.KindBaseImageFetcher, parsedUrl.Scheme)
+	if fetcher == nil {
+		return nil, fmt.Errorf("none of the plugins fetch base images with the URL scheme (%s) (supported: %s)",
+			parsedUrl.Scheme, strings.Join(pluginRegistry.Names(plugins.KindBaseImageFetcher), ", "))
+	}
+
+	return fetcher, nil
+}
+
+// fetchBaseImage downloads the base image into the fetch directory, using the plugin for the URL's scheme. Returns the
+// path of the downloaded image.
+func fetchBaseImage(pluginRegistry *plugins.Registry, fetchDir string, imageUrl string) (string, error) {
+	fetcher, err := findBaseImageFetcher(pluginRegistry, imageUrl)
+	if err != nil {
+		return "", err
+	}
+
+	fetchDirAbs, err := filepath.Abs(fetchDir)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.RemoveAll(fetchDirAbs)
+	if err != nil {
+		return "", fmt.Errorf("failed to clean base image fetch directory (%s):\n%w", fetchDirAbs, err)
+	}
+
+	err = os.MkdirAll(fetchDirAbs, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create base image fetch directory (%s):\n%w", fetchDirAbs, err)
+	}
+
+	logger.Log.Infof("Fetching base image (%s) with plugin (%s)", imageUrl, fetcher)
+
+	response, err := fetcher.Fetch(plugins.FetchRequest{
+		Url:       imageUrl,
+		OutputDir: fetchDirAbs,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch base image (%s):\n%w", imageUrl, err)
+	}
+
+	imageFile := file.GetAbsPathWithBase(fetchDirAbs, response.ImageFile)
+
+	exists, err := file.PathExists(imageFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to check if fetched base image (%s) exists:\n%w", imageFile, err)
+	}
+
+	if !exists {
+		return "", fmt.Errorf("plugin (%s) didn't fetch base image (%s) to (%s)", fetcher, imageUrl, imageFile)
+	}
+
+	logger.Log.Infof("Fetched base image: %s", imageFile)
+	return imageFile, nil
+}
+
+// convertOutputImageWithPlugin converts the customized raw image into an output format that a plugin provides.
+func convertOutputImageWithPlugin(converter *plugins.Plugin, rawImageFile string, outputImageFile string,
+	outputImageFormat string,
+) error {
+	outputImageFileAbs, err := filepath.Abs(outputImageFile)
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Writing: %s (with plugin (%s))", outputImageFile, converter)
+
+	err = converter.Convert(plugins.ConvertRequest{
+		Format:          outputImageFormat,
+		RawImageFile:    rawImageFile,
+		OutputImageFile: outputImageFileAbs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to convert image to (%s) format:\n%w", outputImageFormat, err)
+	}
+
+	exists, err := file.PathExists(outputImageFileAbs)
+	if err != nil {
+		return fmt.Errorf("failed to check if output image (%s) exists:\n%w", outputImageFileAbs, err)
+	}
+
+	if !exists {
+		return fmt.Errorf("plugin (%s) didn't write output image (%s)", converter, outputImageFileAbs)
+	}
+
+	return nil
+}
+
+// signWithPlugin signs the boot artifacts with a signing provider plugin.
+func signWithPlugin(pluginRegistry *plugins.Registry, provider string, manifestPath string, unsignedDir string,
+	signedDir string,
+) error {
+	signer := pluginRegistry.Find(plugins.KindSigningProvider, provider)
+	if signer == nil {
+		return fmt.Errorf("none of the plugins provide the signing provider (%s)", provider)
+	}
+
+	logger.Log.Infof("Signing boot artifacts with signing provider (%s) of plugin (%s)", provider, signer)
+
+	err := signer.Sign(plugins.SignRequest{
+		Provider:     provider,
+		ManifestFile: manifestPath,
+		UnsignedDir:  unsignedDir,
+		SignedDir:    signedDir,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sign boot artifacts:\n%w", err)
+	}
+
+	return nil
+}
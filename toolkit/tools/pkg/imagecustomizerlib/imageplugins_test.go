@@ -0,0 +1,176 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/plugins"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestPluginRegistry writes a shell script plugin with the capability and loads it. The script is run for the
+// requests other than describe, with the request's JSON in $request.
+func createTestPluginRegistry(t *testing.T, kind plugins.Kind, name string, script string) *plugins.Registry {
+	pluginsDir := t.TempDir()
+	content := fmt.Sprintf("#!/bin/sh\n"+
+		"request=\"$(cat)\"\n"+
+		"if [ \"$1\" = describe ]; then\n"+
+		"  echo '{\"abiVersion\": 1, \"name\": \"test\", \"capabilities\": [{\"kind\": \"%s\", \"name\": \"%s\"}]}'\n"+
+		"  exit 0\n"+
+		"fi\n"+
+		"%s\n", kind, name, script)
+
+	err := os.WriteFile(filepath.Join(pluginsDir, "test"), []byte(content), 0o755)
+	require.NoError(t, err)
+
+	pluginRegistry, err := plugins.Discover(pluginsDir)
+	require.NoError(t, err)
+
+	return pluginRegistry
+}
+
+func TestIsImageUrl(t *testing.T) {
+	assert.True(t, isImageUrl("acr://registry.example.com/images/base:3.0"))
+	assert.True(t, isImageUrl("https://example.com/base.vhdx"))
+	assert.False(t, isImageUrl("/images/base.vhdx"))
+	assert.False(t, isImageUrl("base.vhdx"))
+	assert.False(t, isImageUrl("images/a:b.vhdx"))
+}
+
+func TestFetchBaseImage(t *testing.T) {
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindBaseImageFetcher, "acr",
+		`outputDir="$(echo "$request" | sed 's/.*"outputDir":"\([^"]*\)".*/\1/')"
+echo "$request" > "$outputDir/request.json"
+echo image > "$outputDir/base.vhdx"
+echo '{"imageFile": "base.vhdx"}'`)
+
+	fetchDir := filepath.Join(t.TempDir(), fetchedImageDirName)
+
+	imageFile, err := fetchBaseImage(pluginRegistry, fetchDir, "acr://registry.example.com/base:3.0")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(fetchDir, "base.vhdx"), imageFile)
+	assert.FileExists(t, imageFile)
+
+	request, err := os.ReadFile(filepath.Join(fetchDir, "request.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"abiVersion": 1, "url": "acr://registry.example.com/base:3.0", "outputDir": "%s"}`,
+		fetchDir), string(request))
+}
+
+func TestFetchBaseImageNoFetcher(t *testing.T) {
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindBaseImageFetcher, "acr", "")
+
+	_, err := fetchBaseImage(pluginRegistry, t.TempDir(), "oras://registry.example.com/base:3.0")
+	assert.ErrorContains(t, err, "none of the plugins fetch base images with the URL scheme (oras) (supported: acr)")
+}
+
+func TestFetchBaseImageMissingImage(t *testing.T) {
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindBaseImageFetcher, "acr",
+		`echo '{"imageFile": "base.vhdx"}'`)
+
+	fetchDir := t.TempDir()
+	_, err := fetchBaseImage(pluginRegistry, fetchDir, "acr://registry.example.com/base:3.0")
+	assert.ErrorContains(t, err, "plugin (test) didn't fetch base image (acr://registry.example.com/base:3.0) to ("+
+		filepath.Join(fetchDir, "base.vhdx")+")")
+}
+
+func TestConvertOutputImageWithPlugin(t *testing.T) {
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindOutputConverter, "vmdk",
+		`outputImageFile="$(echo "$request" | sed 's/.*"outputImageFile":"\([^"]*\)".*/\1/')"
+echo "$request" > "$outputImageFile"
+echo '{}'`)
+
+	outputImageFile := filepath.Join(t.TempDir(), "image.vmdk")
+
+	err := convertOutputImageWithPlugin(pluginRegistry.Find(plugins.KindOutputConverter, "vmdk"), "/build/image.raw",
+		outputImageFile, "vmdk")
+	require.NoError(t, err)
+
+	request, err := os.ReadFile(outputImageFile)
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"abiVersion": 1, "format": "vmdk", "rawImageFile": "/build/image.raw", `+
+		`"outputImageFile": "%s"}`, outputImageFile), string(request))
+}
+
+func TestConvertOutputImageWithPluginNoOutput(t *testing.T) {
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindOutputConverter, "vmdk", `echo '{}'`)
+
+	outputImageFile := filepath.Join(t.TempDir(), "image.vmdk")
+
+	err := convertOutputImageWithPlugin(pluginRegistry.Find(plugins.KindOutputConverter, "vmdk"), "/build/image.raw",
+		outputImageFile, "vmdk")
+	assert.ErrorContains(t, err, "plugin (test) didn't write output image ("+outputImageFile+")")
+}
+
+func TestCreateImageCustomizerParametersPluginOutputFormat(t *testing.T) {
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindOutputConverter, "vmdk", "")
+	buildDir := t.TempDir()
+	outputImageFile := filepath.Join(buildDir, "out", "image.vmdk")
+
+	ic, err := createImageCustomizerParameters(buildDir, "base.vhdx", buildDir, &imagecustomizerapi.Config{},
+		true /*useBaseImageRpmRepos*/, nil /*rpmsSources*/, false /*enableShrinkFilesystems*/, "", "vmdk",
+		outputImageFile, "" /*outputPXEArtifactsDir*/, pluginRegistry)
+	require.NoError(t, err)
+	assert.Same(t, pluginRegistry.Find(plugins.KindOutputConverter, "vmdk"), ic.outputConverter)
+
+	// The toolkit's own formats don't use plugins.
+	ic, err = createImageCustomizerParameters(buildDir, "base.vhdx", buildDir, &imagecustomizerapi.Config{},
+		true /*useBaseImageRpmRepos*/, nil /*rpmsSources*/, false /*enableShrinkFilesystems*/, "", "vhdx",
+		outputImageFile, "" /*outputPXEArtifactsDir*/, pluginRegistry)
+	require.NoError(t, err)
+	assert.Nil(t, ic.outputConverter)
+
+	_, err = createImageCustomizerParameters(buildDir, "base.vhdx", buildDir, &imagecustomizerapi.Config{},
+		true /*useBaseImageRpmRepos*/, nil /*rpmsSources*/, false /*enableShrinkFilesystems*/, "", "ova",
+		outputImageFile, "" /*outputPXEArtifactsDir*/, pluginRegistry)
+	assert.Error(t, err)
+}
+
+func TestCreateImageCustomizerParametersMissingSigningProvider(t *testing.T) {
+	buildDir := t.TempDir()
+	config := &imagecustomizerapi.Config{
+		Signing: &imagecustomizerapi.Signing{Provider: "hsm"},
+	}
+
+	_, err := createImageCustomizerParameters(buildDir, "base.vhdx", buildDir, config,
+		true /*useBaseImageRpmRepos*/, nil /*rpmsSources*/, false /*enableShrinkFilesystems*/, "", "vhdx",
+		filepath.Join(buildDir, "image.vhdx"), "" /*outputPXEArtifactsDir*/, nil /*pluginRegistry*/)
+	assert.ErrorContains(t, err, "none of the plugins provide the signing provider (hsm)")
+}
+
+func TestSignBootArtifactsPlugin(t *testing.T) {
+	testTmpDir := t.TempDir()
+	buildDir := filepath.Join(testTmpDir, "build")
+	imageRootDir := filepath.Join(testTmpDir, "rootfs")
+	signedTemplatePath := filepath.Join(testTmpDir, "signed.efi")
+
+	createTestSigningImage(t, imageRootDir)
+	createTestPeFile(t, signedTemplatePath, true)
+
+	// A fake signing provider that replaces each artifact with a signed PE file.
+	pluginRegistry := createTestPluginRegistry(t, plugins.KindSigningProvider, "hsm",
+		fmt.Sprintf(`set -e
+signedDir="$(echo "$request" | sed 's/.*"signedDir":"\([^"]*\)".*/\1/')"
+mkdir -p "$signedDir/boot/efi/EFI/BOOT"
+cp %s "$signedDir/boot/efi/EFI/BOOT/bootx64.efi"
+echo '{}'`, signedTemplatePath))
+
+	signing := &imagecustomizerapi.Signing{
+		Artifacts: []imagecustomizerapi.SigningArtifactType{imagecustomizerapi.SigningArtifactTypeShim},
+		Provider:  "hsm",
+	}
+
+	err := signBootArtifacts(buildDir, testTmpDir, signing, pluginRegistry, imageRootDir)
+	require.NoError(t, err)
+
+	signed, err := isSignedPeFile(filepath.Join(imageRootDir, "boot/efi/EFI/BOOT/bootx64.efi"))
+	assert.NoError(t, err)
+	assert.True(t, signed)
+}
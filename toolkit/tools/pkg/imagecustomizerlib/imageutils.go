@@ -58,14 +58,14 @@ func connectToExistingImageHelper(imageConnection *ImageConnection, imageFilePat
 }
 
 func createNewImage(filename string, diskConfig imagecustomizerapi.Disk,
-	fileSystems []imagecustomizerapi.FileSystem, buildDir string, chrootDirName string,
+	fileSystems []imagecustomizerapi.FileSystem, buildDir string, chrootDirName string, imageArch string,
 	installOS installOSFunc,
 ) (map[string]string, error) {
 	imageConnection := NewImageConnection()
 	defer imageConnection.Close()
 
 	partIdToPartUuid, err := createNewImageHelper(imageConnection, filename, diskConfig, fileSystems, buildDir, chrootDirName,
-		installOS)
+		imageArch, installOS)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new image:\n%w", err)
 	}
@@ -80,12 +80,12 @@ func createNewImage(filename string, diskConfig imagecustomizerapi.Disk,
 }
 
 func createNewImageHelper(imageConnection *ImageConnection, filename string, diskConfig imagecustomizerapi.Disk,
-	fileSystems []imagecustomizerapi.FileSystem, buildDir string, chrootDirName string,
+	fileSystems []imagecustomizerapi.FileSystem, buildDir string, chrootDirName string, imageArch string,
 	installOS installOSFunc,
 ) (map[string]string, error) {
 
 	// Convert config to image config types, so that the imager's utils can be used.
-	imagerDiskConfig, err := diskConfigToImager(diskConfig, fileSystems)
+	imagerDiskConfig, err := diskConfigToImager(diskConfig, fileSystems, imageArch)
 	if err != nil {
 		return nil, err
 	}
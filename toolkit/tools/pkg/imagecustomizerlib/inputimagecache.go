@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/leaselock"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	inputImageCacheLockSuffix = ".lock"
+	inputImageCacheTempSuffix = ".tmp"
+)
+
+// CacheInputImage converts an input image to a raw image within the cache directory, unless it has already been
+// converted. Returns the path of the raw image.
+//
+// Input images that are already raw images or that are isos are returned as-is.
+func CacheInputImage(cacheDir string, imageFile string) (string, error) {
+	imageFormat := strings.TrimLeft(filepath.Ext(imageFile), ".")
+	if imageFormat == ImageFormatRaw || imageFormat == ImageFormatIso {
+		return imageFile, nil
+	}
+
+	cacheFile, err := getInputImageCacheFile(cacheDir, imageFile)
+	if err != nil {
+		return "", err
+	}
+
+	exists, err := file.PathExists(cacheFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to check input image cache (%s):\n%w", cacheFile, err)
+	}
+
+	if exists {
+		logger.Log.Debugf("Using cached raw image (%s) of input image (%s)", cacheFile, imageFile)
+		return cacheFile, nil
+	}
+
+	err = os.MkdirAll(cacheDir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create input image cache directory (%s):\n%w", cacheDir, err)
+	}
+
+	// Only a single build converts a given image. The other builds wait for it and then use its conversion.
+	lock, err := leaselock.Acquire(context.Background(), cacheFile+inputImageCacheLockSuffix,
+		leaselock.DefaultLeaseDuration, leaselock.DefaultPollInterval)
+	if err != nil {
+		return "", fmt.Errorf("failed to lock input image cache (%s):\n%w", cacheFile, err)
+	}
+	defer lock.Release()
+
+	exists, err = file.PathExists(cacheFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to check input image cache (%s):\n%w", cacheFile, err)
+	}
+
+	if exists {
+		logger.Log.Debugf("Using cached raw image (%s) of input image (%s)", cacheFile, imageFile)
+		return cacheFile, nil
+	}
+
+	// While the lock is held, any temporary files of this image were left behind by builds that crashed.
+	err = removeInputImageCacheTempFiles(cacheFile)
+	if err != nil {
+		return "", err
+	}
+
+	// Convert into a temporary file and then rename it, so that concurrent builds never see a partial image.
+	tempFile, err := os.CreateTemp(cacheDir, filepath.Base(cacheFile)+".*"+inputImageCacheTempSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file in input image cache:\n%w", err)
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	// Abort the conversion if the lock is lost, since another build may now be converting the image.
+	ctx, cancel := lock.Context(context.Background())
+	defer cancel()
+
+	logger.Log.Infof("Caching raw image of input image (%s): %s", imageFile, cacheFile)
+	err = shell.ExecuteLiveWithErrContext(ctx, 1, "qemu-img", "convert", "-O", "raw", imageFile, tempFile.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to convert image file to raw format:\n%w", err)
+	}
+
+	// The cached image is shared by concurrent builds, which only ever clone or copy it.
+	err = os.Chmod(tempFile.Name(), 0o444)
+	if err != nil {
+		return "", fmt.Errorf("failed to make cached raw image read-only:\n%w", err)
+	}
+
+	err = lock.Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to cache raw image of input image (%s):\n%w", imageFile, err)
+	}
+
+	err = os.Rename(tempFile.Name(), cacheFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to move raw image into input image cache:\n%w", err)
+	}
+
+	return cacheFile, nil
+}
+
+// getInputImageCacheFile returns the path within the cache directory of the raw conversion of the input image.
+//
+// The image's size and modification time are part of the key, so that a replaced image doesn't match the cached
+// conversion of the old image.
+func getInputImageCacheFile(cacheDir string, imageFile string) (string, error) {
+	imageFileAbs, err := filepath.Abs(imageFile)
+	if err != nil {
+		return "", err
+	}
+
+	stat, err := os.Stat(imageFileAbs)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat input image (%s):\n%w", imageFile, err)
+	}
+
+	key := fmt.Sprintf("%s\n%d\n%d", imageFileAbs, stat.Size(), stat.ModTime().UnixNano())
+	digest := sha256.Sum256([]byte(key))
+
+	return filepath.Join(cacheDir, hex.EncodeToString(digest[:16])+".raw"), nil
+}
+
+// removeInputImageCacheTempFiles removes the temporary files of partial conversions of a cached image.
+func removeInputImageCacheTempFiles(cacheFile string) error {
+	tempFiles, err := filepath.Glob(cacheFile + ".*" + inputImageCacheTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, tempFile := range tempFiles {
+		logger.Log.Infof("Removing partial conversion (%s) of crashed build", tempFile)
+
+		err = os.Remove(tempFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove partial conversion (%s):\n%w", tempFile, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveInputImageCacheTempFiles(t *testing.T) {
+	cacheDir := t.TempDir()
+	cacheFile := filepath.Join(cacheDir, "0123.raw")
+
+	for _, name := range []string{"0123.raw.111.tmp", "0123.raw.222.tmp", "4567.raw.333.tmp", "0123.raw.lock"} {
+		err := os.WriteFile(filepath.Join(cacheDir, name), nil, 0o644)
+		require.NoError(t, err)
+	}
+
+	err := removeInputImageCacheTempFiles(cacheFile)
+	assert.NoError(t, err)
+
+	assert.NoFileExists(t, filepath.Join(cacheDir, "0123.raw.111.tmp"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "0123.raw.222.tmp"))
+
+	// The partial conversions of other images and the lock are left alone.
+	assert.FileExists(t, filepath.Join(cacheDir, "4567.raw.333.tmp"))
+	assert.FileExists(t, filepath.Join(cacheDir, "0123.raw.lock"))
+}
@@ -54,6 +54,15 @@ const (
 	liveOSDir   = "liveos"
 	liveOSImage = "rootfs.img"
 
+	// dracut reads the last occurrence of a kernel argument, so appending rd.live.overlay after the template above
+	// replaces its default (non-persistent) value.
+	persistentOverlayKernelArgTemplate = "rd.live.overlay=%s"
+
+	// location on the output iso where the answer file is placed.
+	isoAnswerFileDir = "/answerfile"
+	// value of the answer file kernel argument, pointing to the answer file on the iso media.
+	answerFileKernelArgValueTemplate = "hd:LABEL=%s:%s"
+
 	// location on output iso where some of the input mic configuration will be
 	// saved for future iso-to-iso customizations.
 	savedConfigsDir = "azl-image-customizer"
@@ -1132,6 +1141,9 @@ func (b *LiveOSIsoBuilder) createIsoImage(additionalIsoFiles []safechroot.FileTo
 // outputs:
 //   - 'additionalIsoFiles'
 //     list of files to copy from the build machine to the iso media.
+//   - 'extraCommandLine'
+//     extra kernel arguments, including those implied by the persistent
+//     overlay and answer file settings.
 func micIsoConfigToIsoMakerConfig(baseConfigPath string, isoConfig *imagecustomizerapi.Iso) (additionalIsoFiles []safechroot.FileToCopy, extraCommandLine imagecustomizerapi.KernelExtraArguments, err error) {
 
 	if isoConfig == nil {
@@ -1154,7 +1166,40 @@ func micIsoConfigToIsoMakerConfig(baseConfigPath string, isoConfig *imagecustomi
 		additionalIsoFiles = append(additionalIsoFiles, fileToCopy)
 	}
 
-	return additionalIsoFiles, isoConfig.KernelCommandLine.ExtraCommandLine, nil
+	extraArgs := []string{}
+	if isoConfig.KernelCommandLine.ExtraCommandLine != "" {
+		extraArgs = append(extraArgs, strings.TrimSpace(string(isoConfig.KernelCommandLine.ExtraCommandLine)))
+	}
+
+	if isoConfig.PersistentOverlay != nil {
+		extraArgs = append(extraArgs, persistentOverlayKernelArg(isoConfig.PersistentOverlay))
+	}
+
+	if isoConfig.AnswerFile != nil {
+		answerFileIsoPath := filepath.Join(isoAnswerFileDir, filepath.Base(isoConfig.AnswerFile.Source))
+		additionalIsoFiles = append(additionalIsoFiles, safechroot.FileToCopy{
+			Src:  file.GetAbsPathWithBase(baseConfigPath, isoConfig.AnswerFile.Source),
+			Dest: answerFileIsoPath,
+		})
+
+		if isoConfig.AnswerFile.KernelArgument != "" {
+			extraArgs = append(extraArgs, fmt.Sprintf("%s="+answerFileKernelArgValueTemplate,
+				isoConfig.AnswerFile.KernelArgument, isomakerlib.DefaultVolumeId, answerFileIsoPath))
+		}
+	}
+
+	return additionalIsoFiles, imagecustomizerapi.KernelExtraArguments(strings.Join(extraArgs, " ")), nil
+}
+
+// persistentOverlayKernelArg returns the dracut kernel argument that places the LiveOS overlay on the configured
+// persistent device.
+func persistentOverlayKernelArg(overlay *imagecustomizerapi.IsoPersistentOverlay) string {
+	overlayValue := overlay.Device
+	if overlay.Path != "" {
+		overlayValue += ":" + overlay.Path
+	}
+
+	return fmt.Sprintf(persistentOverlayKernelArgTemplate, overlayValue)
 }
 
 // createLiveOSIsoImage
@@ -1859,9 +1904,15 @@ func (b *LiveOSIsoBuilder) createWriteableImageFromSquashfs(buildDir, rawImageFi
 		return err
 	}
 
+	imageArch, err := getImageArch(squashMountDir)
+	if err != nil {
+		return err
+	}
+
 	// create the new raw disk image
 	writeableChrootDir := "writeable-raw-image"
-	_, err = createNewImage(rawImageFile, diskConfig, fileSystemConfigs, buildDir, writeableChrootDir, installOSFunc)
+	_, err = createNewImage(rawImageFile, diskConfig, fileSystemConfigs, buildDir, writeableChrootDir, imageArch,
+		installOSFunc)
 	if err != nil {
 		return fmt.Errorf("failed to copy squashfs into new writeable image (%s):\n%w", rawImageFile, err)
 	}
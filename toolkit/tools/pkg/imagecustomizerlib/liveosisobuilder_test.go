@@ -307,3 +307,47 @@ func TestCustomizeImageLiveCdIsoNoGrubEfi(t *testing.T) {
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, "failed to find the grub efi file")
 }
+
+func TestMicIsoConfigToIsoMakerConfigPersistenceAndAnswerFile(t *testing.T) {
+	isoConfig := &imagecustomizerapi.Iso{
+		KernelCommandLine: imagecustomizerapi.KernelCommandLine{
+			ExtraCommandLine: "console=ttyS0",
+		},
+		PersistentOverlay: &imagecustomizerapi.IsoPersistentOverlay{
+			Device: "LABEL=azl-persist",
+			Path:   "/overlay",
+		},
+		AnswerFile: &imagecustomizerapi.IsoAnswerFile{
+			Source:         "files/ks.cfg",
+			KernelArgument: "inst.ks",
+		},
+	}
+
+	additionalIsoFiles, extraCommandLine, err := micIsoConfigToIsoMakerConfig("/config", isoConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, imagecustomizerapi.KernelExtraArguments(
+		"console=ttyS0 rd.live.overlay=LABEL=azl-persist:/overlay inst.ks=hd:LABEL=CDROM:/answerfile/ks.cfg"),
+		extraCommandLine)
+
+	if assert.Len(t, additionalIsoFiles, 1) {
+		assert.Equal(t, "/config/files/ks.cfg", additionalIsoFiles[0].Src)
+		assert.Equal(t, "/answerfile/ks.cfg", additionalIsoFiles[0].Dest)
+	}
+}
+
+func TestMicIsoConfigToIsoMakerConfigAnswerFileNoKernelArgument(t *testing.T) {
+	isoConfig := &imagecustomizerapi.Iso{
+		AnswerFile: &imagecustomizerapi.IsoAnswerFile{
+			Source: "/abs/answers.yaml",
+		},
+	}
+
+	additionalIsoFiles, extraCommandLine, err := micIsoConfigToIsoMakerConfig("/config", isoConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, imagecustomizerapi.KernelExtraArguments(""), extraCommandLine)
+
+	if assert.Len(t, additionalIsoFiles, 1) {
+		assert.Equal(t, "/abs/answers.yaml", additionalIsoFiles[0].Src)
+		assert.Equal(t, "/answerfile/answers.yaml", additionalIsoFiles[0].Dest)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/artifactstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/chunkstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// storeOutputArtifact stores the output image in a chunk store within the artifact store, under the image's SHA-256
+// digest followed by its file name (i.e. "<sha256>/<file name>"), so that variants of an image with the same file
+// name don't replace each other. Only the chunks that aren't already in the store (e.g. from other variants of the
+// image) are uploaded.
+func storeOutputArtifact(storeLocation string, outputImageFile string) error {
+	store, err := artifactstore.Open(storeLocation)
+	if err != nil {
+		return fmt.Errorf("failed to open output artifact store:\n%w", err)
+	}
+
+	imageSha256, err := file.GenerateSHA256(outputImageFile)
+	if err != nil {
+		return fmt.Errorf("failed to hash output image (%s):\n%w", outputImageFile, err)
+	}
+
+	chunkStore := chunkstore.New(store)
+	name := path.Join(imageSha256, filepath.Base(outputImageFile))
+
+	logger.Log.Infof("Storing output image in artifact store (%s) as (%s)", chunkStore, name)
+
+	_, stats, err := chunkStore.Put(context.Background(), outputImageFile, name)
+	if err != nil {
+		return fmt.Errorf("failed to store output image in artifact store:\n%w", err)
+	}
+
+	logger.Log.Infof("Stored %d new chunks of %d (%s of %s)", stats.NewChunks, stats.Chunks,
+		humanReadableDiskSize(stats.NewBytes), humanReadableDiskSize(stats.Bytes))
+
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/artifactstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/chunkstore"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreOutputArtifactSameFileName(t *testing.T) {
+	testDir := t.TempDir()
+	storeDir := filepath.Join(testDir, "store")
+
+	// Two variants of an image, with the same file name.
+	imageFiles := []string{
+		filepath.Join(testDir, "a", "image.raw"),
+		filepath.Join(testDir, "b", "image.raw"),
+	}
+
+	expectedNames := []string(nil)
+	for i, imageFile := range imageFiles {
+		err := os.MkdirAll(filepath.Dir(imageFile), os.ModePerm)
+		require.NoError(t, err)
+
+		err = os.WriteFile(imageFile, []byte{byte(i), 1, 2, 3}, 0o644)
+		require.NoError(t, err)
+
+		err = storeOutputArtifact(storeDir, imageFile)
+		require.NoError(t, err)
+
+		imageSha256, err := file.GenerateSHA256(imageFile)
+		require.NoError(t, err)
+
+		expectedNames = append(expectedNames, imageSha256+"/image.raw")
+	}
+
+	store, err := artifactstore.Open(storeDir)
+	require.NoError(t, err)
+
+	names, err := chunkstore.New(store).List(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, expectedNames, names)
+}
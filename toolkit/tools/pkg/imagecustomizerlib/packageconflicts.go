@@ -0,0 +1,209 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	// The directory, within the chroot, that the RPMs of a transaction are downloaded to, so that they can be checked
+	// for file conflicts before the transaction is run.
+	transactionRpmsDirInChroot = "/_transactionrpms"
+)
+
+var (
+	// For example:
+	//   file /usr/bin/foo from install of foo-1.0-1.azl3.x86_64 conflicts with file from package bar-2.0-1.azl3.x86_64
+	rpmInstalledFileConflictRegex = regexp.MustCompile(
+		`^\s*file (\S+) from install of (\S+) conflicts with file from package (\S+)\s*$`)
+
+	// For example:
+	//   file /usr/bin/foo conflicts between attempted installs of foo-1.0-1.azl3.x86_64 and bar-2.0-1.azl3.x86_64
+	rpmTransactionFileConflictRegex = regexp.MustCompile(
+		`^\s*file (\S+) conflicts between attempted installs of (\S+) and (\S+)\s*$`)
+)
+
+type packageFileConflict struct {
+	path string
+	// The package that the transaction installs.
+	installingPackage string
+	// The package that also owns the path. This is either an installed package or another package that the
+	// transaction installs.
+	conflictingPackage string
+	// Whether the conflicting package is also installed by the transaction.
+	conflictingPackageInTransaction bool
+}
+
+// checkPackageFileConflicts resolves the packages that a tdnf transaction would install, without running the
+// transaction, and checks them for file conflicts with each other and with the installed packages.
+//
+// This provides a clear report of which packages own the conflicting paths, instead of rpm failing in the middle of
+// the transaction.
+func checkPackageFileConflicts(tdnfArgs []string, imageChroot *safechroot.Chroot) error {
+	rpmsDir := filepath.Join(imageChroot.RootDir(), transactionRpmsDirInChroot)
+
+	err := os.RemoveAll(rpmsDir)
+	if err != nil {
+		return fmt.Errorf("failed to clean transaction RPMs directory (%s):\n%w", rpmsDir, err)
+	}
+
+	err = os.MkdirAll(rpmsDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create transaction RPMs directory (%s):\n%w", rpmsDir, err)
+	}
+	defer os.RemoveAll(rpmsDir)
+
+	downloadArgs := append([]string(nil), tdnfArgs...)
+	downloadArgs = append(downloadArgs, "--downloadonly", "--downloaddir", transactionRpmsDirInChroot)
+
+	err = callTdnf(downloadArgs, tdnfInstallPrefix, imageChroot)
+	if err != nil {
+		return fmt.Errorf("failed to resolve packages of transaction:\n%w", err)
+	}
+
+	rpmFiles, err := filepath.Glob(filepath.Join(rpmsDir, "*.rpm"))
+	if err != nil {
+		return fmt.Errorf("failed to list transaction RPMs:\n%w", err)
+	}
+
+	if len(rpmFiles) == 0 {
+		// Nothing to install.
+		return nil
+	}
+
+	rpmFilesInChroot := make([]string, len(rpmFiles))
+	for i, rpmFile := range rpmFiles {
+		rpmFilesInChroot[i] = path.Join(transactionRpmsDirInChroot, filepath.Base(rpmFile))
+	}
+
+	// Only check for file conflicts. Dependencies and disk space are already checked by tdnf.
+	testArgs := []string{"-U", "--test", "--nodeps", "--ignoresize"}
+	testArgs = append(testArgs, rpmFilesInChroot...)
+
+	var stdout, stderr string
+	testErr := imageChroot.UnsafeRun(func() error {
+		var err error
+		stdout, stderr, err = shell.Execute("rpm", testArgs...)
+		return err
+	})
+
+	conflicts := parseRpmFileConflicts(stdout + "\n" + stderr)
+	if len(conflicts) <= 0 {
+		if testErr != nil {
+			// Leave it to the transaction itself to report any other problems.
+			logger.Log.Debugf("Transaction check failed without file conflicts:\n%s", stderr)
+		}
+		return nil
+	}
+
+	obsoletes, err := getTransactionObsoletes(rpmFilesInChroot, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%s", formatPackageFileConflicts(conflicts, obsoletes))
+}
+
+func parseRpmFileConflicts(output string) []packageFileConflict {
+	conflicts := []packageFileConflict(nil)
+	for _, line := range strings.Split(output, "\n") {
+		match := rpmInstalledFileConflictRegex.FindStringSubmatch(line)
+		if match != nil {
+			conflicts = append(conflicts, packageFileConflict{
+				path:               match[1],
+				installingPackage:  match[2],
+				conflictingPackage: match[3],
+			})
+			continue
+		}
+
+		match = rpmTransactionFileConflictRegex.FindStringSubmatch(line)
+		if match != nil {
+			conflicts = append(conflicts, packageFileConflict{
+				path:                            match[1],
+				installingPackage:               match[2],
+				conflictingPackage:              match[3],
+				conflictingPackageInTransaction: true,
+			})
+		}
+	}
+
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].path < conflicts[j].path
+	})
+
+	return conflicts
+}
+
+// getTransactionObsoletes returns the packages that each of the transaction's RPMs obsoletes.
+func getTransactionObsoletes(rpmFilesInChroot []string, imageChroot *safechroot.Chroot) (map[string][]string, error) {
+	obsoletes := make(map[string][]string)
+	for _, rpmFile := range rpmFilesInChroot {
+		var stdout string
+		err := imageChroot.UnsafeRun(func() error {
+			var err error
+			stdout, _, err = shell.Execute("rpm", "-qp", "--queryformat",
+				"%{NAME}-%{VERSION}-%{RELEASE}.%{ARCH}\n[%{OBSOLETENAME}\n]", rpmFile)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query obsoletes of RPM (%s):\n%w", rpmFile, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		if len(lines) > 1 {
+			obsoletes[lines[0]] = lines[1:]
+		}
+	}
+
+	return obsoletes, nil
+}
+
+func formatPackageFileConflicts(conflicts []packageFileConflict, obsoletes map[string][]string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "found %d file conflicts between packages:", len(conflicts))
+
+	installingPackages := make(map[string]bool)
+	for _, conflict := range conflicts {
+		conflictingPackageKind := "installed package"
+		if conflict.conflictingPackageInTransaction {
+			conflictingPackageKind = "package"
+		}
+
+		fmt.Fprintf(&sb, "\n  %s: owned by package (%s) and %s (%s)", conflict.path, conflict.installingPackage,
+			conflictingPackageKind, conflict.conflictingPackage)
+
+		installingPackages[conflict.installingPackage] = true
+	}
+
+	// A package can only replace the files of an installed package by obsoleting it. So, list what the conflicting
+	// packages do obsolete, to help find a missing or mismatched obsoletes.
+	installingPackageNames := make([]string, 0, len(installingPackages))
+	for installingPackage := range installingPackages {
+		installingPackageNames = append(installingPackageNames, installingPackage)
+	}
+	sort.Strings(installingPackageNames)
+
+	for _, installingPackage := range installingPackageNames {
+		packageObsoletes := obsoletes[installingPackage]
+		if len(packageObsoletes) <= 0 {
+			fmt.Fprintf(&sb, "\n  package (%s) doesn't obsolete any packages", installingPackage)
+		} else {
+			fmt.Fprintf(&sb, "\n  package (%s) obsoletes: %s", installingPackage, strings.Join(packageObsoletes, ", "))
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRpmFileConflicts(t *testing.T) {
+	output := "error: Transaction check error:\n" +
+		"  file /usr/bin/foo from install of foo-1.0-1.azl3.x86_64 conflicts with file from package bar-2.0-1.azl3.x86_64\n" +
+		"  file /etc/baz.conf conflicts between attempted installs of baz-1.0-1.azl3.noarch and foo-1.0-1.azl3.x86_64\n" +
+		"\n"
+
+	conflicts := parseRpmFileConflicts(output)
+	assert.Equal(t, []packageFileConflict{
+		{
+			path:                            "/etc/baz.conf",
+			installingPackage:               "baz-1.0-1.azl3.noarch",
+			conflictingPackage:              "foo-1.0-1.azl3.x86_64",
+			conflictingPackageInTransaction: true,
+		},
+		{
+			path:               "/usr/bin/foo",
+			installingPackage:  "foo-1.0-1.azl3.x86_64",
+			conflictingPackage: "bar-2.0-1.azl3.x86_64",
+		},
+	}, conflicts)
+}
+
+func TestParseRpmFileConflictsNone(t *testing.T) {
+	output := "error: Transaction check error:\n" +
+		"  package foo-1.0-1.azl3.x86_64 is already installed\n"
+
+	conflicts := parseRpmFileConflicts(output)
+	assert.Empty(t, conflicts)
+}
+
+func TestFormatPackageFileConflicts(t *testing.T) {
+	conflicts := []packageFileConflict{
+		{
+			path:                            "/etc/baz.conf",
+			installingPackage:               "baz-1.0-1.azl3.noarch",
+			conflictingPackage:              "foo-1.0-1.azl3.x86_64",
+			conflictingPackageInTransaction: true,
+		},
+		{
+			path:               "/usr/bin/foo",
+			installingPackage:  "foo-1.0-1.azl3.x86_64",
+			conflictingPackage: "bar-2.0-1.azl3.x86_64",
+		},
+	}
+
+	obsoletes := map[string][]string{
+		"foo-1.0-1.azl3.x86_64": {"bar-compat", "oldfoo"},
+	}
+
+	report := formatPackageFileConflicts(conflicts, obsoletes)
+	assert.Equal(t, "found 2 file conflicts between packages:\n"+
+		"  /etc/baz.conf: owned by package (baz-1.0-1.azl3.noarch) and package (foo-1.0-1.azl3.x86_64)\n"+
+		"  /usr/bin/foo: owned by package (foo-1.0-1.azl3.x86_64) and installed package (bar-2.0-1.azl3.x86_64)\n"+
+		"  package (baz-1.0-1.azl3.noarch) doesn't obsolete any packages\n"+
+		"  package (foo-1.0-1.azl3.x86_64) obsoletes: bar-compat, oldfoo", report)
+}
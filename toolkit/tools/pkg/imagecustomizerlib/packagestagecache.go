@@ -0,0 +1,437 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/leaselock"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm/repodata"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Bump when the contents of a cached package stage change, so that old entries are no longer used.
+	packageStageCacheVersion = 1
+
+	packageStageCacheImageSuffix    = ".raw"
+	packageStageCacheMetadataSuffix = ".json"
+	packageStageCacheLockSuffix     = ".lock"
+	packageStageCacheTempSuffix     = ".tmp"
+)
+
+// packageStage is the state of the image after the partitions are customized and the packages are added, removed,
+// and updated. It is what the package stage cache stores, along with a copy of the image.
+type packageStage struct {
+	PartitionsCustomized bool              `json:"partitionsCustomized"`
+	PartIdToPartUuid     map[string]string `json:"partIdToPartUuid"`
+	OrphansRemoved       []string          `json:"orphansRemoved"`
+	// BaseKernelModules are the kernel modules that were in the image before the packages were installed. Only
+	// recorded if 'moduleSigning' is specified.
+	BaseKernelModules map[string]time.Time `json:"baseKernelModules"`
+}
+
+// packageStageCache caches the image after the package stage, keyed by everything that the package stage depends on,
+// so that builds that only change the later customizations skip installing the packages.
+type packageStageCache struct {
+	cacheDir string
+	key      string
+}
+
+// newPackageStageCache returns the package stage cache of the build. Returns nil if the cache is disabled or if the
+// build can't use it.
+func newPackageStageCache(ic *ImageCustomizerParameters) (*packageStageCache, error) {
+	if ic.packageCacheDir == "" {
+		return nil, nil
+	}
+
+	config := ic.config
+	packages := &config.OS.Packages
+
+	switch {
+	case config.Hotfix != nil || config.InitrdRebuild != nil:
+		return nil, nil
+
+	case len(packages.Install) <= 0 && len(packages.Update) <= 0 && len(packages.Remove) <= 0 &&
+		!packages.UpdateExistingPackages:
+		return nil, nil
+
+	// These record the state of the image before it is customized, which a cached image has already moved past.
+	case config.ChangeManifest != nil:
+		logger.Log.Infof("Not using the package cache since 'changeManifest' is specified")
+		return nil, nil
+
+	case config.SELinuxReport != nil:
+		logger.Log.Infof("Not using the package cache since 'selinuxReport' is specified")
+		return nil, nil
+
+	case ic.outputIsContainer:
+		logger.Log.Infof("Not using the package cache since the output is a container image")
+		return nil, nil
+	}
+
+	key, err := getPackageStageCacheKey(ic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate package cache key:\n%w", err)
+	}
+
+	cache := &packageStageCache{
+		cacheDir: ic.packageCacheDir,
+		key:      key,
+	}
+	return cache, nil
+}
+
+func (c *packageStageCache) imageFile() string {
+	return filepath.Join(c.cacheDir, c.key+packageStageCacheImageSuffix)
+}
+
+func (c *packageStageCache) metadataFile() string {
+	return filepath.Join(c.cacheDir, c.key+packageStageCacheMetadataSuffix)
+}
+
+// restore copies the cached image over the build's image. Returns nil if the package stage isn't cached.
+func (c *packageStageCache) restore(buildImageFile string) (*packageStage, error) {
+	// The image is moved into place last, so an entry is only complete once its image exists.
+	exists, err := file.PathExists(c.imageFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check package cache (%s):\n%w", c.imageFile(), err)
+	}
+
+	if !exists {
+		logger.Log.Infof("Package stage isn't cached (%s)", c.key)
+		return nil, nil
+	}
+
+	var stage packageStage
+	err = jsonutils.ReadJSONFile(c.metadataFile(), &stage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package cache metadata (%s):\n%w", c.metadataFile(), err)
+	}
+
+	logger.Log.Infof("Using cached package stage (%s)", c.imageFile())
+	err = copySparseFile(context.Background(), c.imageFile(), buildImageFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy cached package stage image:\n%w", err)
+	}
+
+	return &stage, nil
+}
+
+// save adds the build's image, as of the end of the package stage, to the cache.
+func (c *packageStageCache) save(buildImageFile string, stage *packageStage) error {
+	err := os.MkdirAll(c.cacheDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create package cache directory (%s):\n%w", c.cacheDir, err)
+	}
+
+	lock, err := leaselock.Acquire(context.Background(), c.imageFile()+packageStageCacheLockSuffix,
+		leaselock.DefaultLeaseDuration, leaselock.DefaultPollInterval)
+	if err != nil {
+		return fmt.Errorf("failed to lock package cache (%s):\n%w", c.imageFile(), err)
+	}
+	defer lock.Release()
+
+	// Another build with the same key may have finished first.
+	exists, err := file.PathExists(c.imageFile())
+	if err != nil {
+		return fmt.Errorf("failed to check package cache (%s):\n%w", c.imageFile(), err)
+	}
+
+	if exists {
+		return nil
+	}
+
+	// While the lock is held, any temporary files of this entry were left behind by builds that crashed.
+	tempFiles, err := filepath.Glob(c.imageFile() + ".*" + packageStageCacheTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, tempFile := range tempFiles {
+		logger.Log.Infof("Removing partial package cache entry (%s) of crashed build", tempFile)
+
+		err = os.Remove(tempFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove partial package cache entry (%s):\n%w", tempFile, err)
+		}
+	}
+
+	err = jsonutils.WriteJSONFile(c.metadataFile(), stage)
+	if err != nil {
+		return fmt.Errorf("failed to write package cache metadata (%s):\n%w", c.metadataFile(), err)
+	}
+
+	// Copy into a temporary file and then rename it, so that concurrent builds never see a partial image.
+	tempFile, err := os.CreateTemp(c.cacheDir, filepath.Base(c.imageFile())+".*"+packageStageCacheTempSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in package cache:\n%w", err)
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	// Abort the copy if the lock is lost, since another build may now be saving the same entry.
+	ctx, cancel := lock.Context(context.Background())
+	defer cancel()
+
+	logger.Log.Infof("Caching package stage: %s", c.imageFile())
+	err = copySparseFile(ctx, buildImageFile, tempFile.Name())
+	if err != nil {
+		return fmt.Errorf("failed to copy image into package cache:\n%w", err)
+	}
+
+	err = lock.Err()
+	if err != nil {
+		return fmt.Errorf("failed to save package cache entry (%s):\n%w", c.imageFile(), err)
+	}
+
+	err = os.Rename(tempFile.Name(), c.imageFile())
+	if err != nil {
+		return fmt.Errorf("failed to move image into package cache:\n%w", err)
+	}
+
+	return nil
+}
+
+// copySparseFile copies a disk image, without allocating space for the image's holes.
+func copySparseFile(ctx context.Context, src string, dst string) error {
+	return shell.ExecuteLiveWithErrContext(ctx, 1, "cp", "--sparse=always", src, dst)
+}
+
+// getPackageStageCacheKey returns the hash of everything that the package stage depends on: the base image, the
+// storage config, the packages config, the RPM sources, and the current revision of the remote repos.
+//
+// The repos whose URLs can only be resolved within the image (e.g. ones that use tdnf variables) and the base image's
+// repos aren't part of the key. So, a cached package stage doesn't pick up new package versions that are published
+// to those repos.
+func getPackageStageCacheKey(ic *ImageCustomizerParameters) (string, error) {
+	digest := sha256.New()
+
+	fmt.Fprintf(digest, "version: %d\ntool: %s\n", packageStageCacheVersion, ToolVersion)
+
+	inputImageFileAbs, err := filepath.Abs(ic.inputImageFile)
+	if err != nil {
+		return "", err
+	}
+
+	stat, err := os.Stat(inputImageFileAbs)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat input image (%s):\n%w", ic.inputImageFile, err)
+	}
+
+	fmt.Fprintf(digest, "image: %s %d %d\n", inputImageFileAbs, stat.Size(), stat.ModTime().UnixNano())
+
+	for _, section := range []any{&ic.config.Storage, &ic.config.OS.Packages} {
+		sectionYaml, err := yaml.Marshal(section)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(digest, "section:\n%s\n", sectionYaml)
+	}
+
+	fmt.Fprintf(digest, "moduleSigning: %t\nbaseImageRpmRepos: %t\n", getModuleSigning(ic.config.OS) != nil,
+		ic.useBaseImageRpmRepos)
+
+	if ic.config.OS.Gpu != nil {
+		gpuYaml, err := yaml.Marshal(ic.config.OS.Gpu)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(digest, "gpu:\n%s\n", gpuYaml)
+	}
+
+	rpmSources := append([]string(nil), ic.rpmsSources...)
+	for _, localRepo := range ic.config.OS.Packages.LocalRepos {
+		rpmSources = append(rpmSources, file.GetAbsPathWithBase(ic.configPath, localRepo.Path))
+	}
+
+	for _, rpmSource := range rpmSources {
+		err = hashRpmSource(digest, rpmSource)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = hashRemoteRepos(digest, ic)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)[:16]), nil
+}
+
+// hashRemoteRepos adds the remote repos of the repo files to the hash. The repo files themselves are hashed by
+// hashRpmSource, so each repo is hashed by its current index, which changes whenever packages are published to it.
+func hashRemoteRepos(digest hash.Hash, ic *ImageCustomizerParameters) error {
+	sources, err := getPlanRpmSources(ic.configPath, ic.rpmsSources, ic.config.OS.Packages.LocalRepos)
+	if err != nil {
+		return err
+	}
+
+	if len(sources.RemoteRepoUrls) <= 0 {
+		return nil
+	}
+
+	err = os.MkdirAll(ic.buildDirAbs, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create build directory (%s):\n%w", ic.buildDirAbs, err)
+	}
+
+	tempDir, err := os.MkdirTemp(ic.buildDirAbs, "package-cache-repodata-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory for repo metadata:\n%w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for i, repoUrl := range sources.RemoteRepoUrls {
+		repoMD, err := repodata.ReadRemoteRepoMD(context.Background(), repoUrl,
+			filepath.Join(tempDir, strconv.Itoa(i)))
+		if err != nil {
+			return fmt.Errorf("failed to read remote repo (%s):\n%w", repoUrl, err)
+		}
+
+		fmt.Fprintf(digest, "remote repo: %s %s\n", repoUrl, repoMD.Revision)
+		for _, data := range repoMD.Data {
+			if data.Checksum != nil {
+				fmt.Fprintf(digest, "%s %s:%s\n", data.Type, data.Checksum.Type, data.Checksum.Value)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hashRpmSource adds an RPM source to the hash. Repo files are hashed by their contents and directories by the path,
+// size, and modification time of their files.
+func hashRpmSource(digest hash.Hash, rpmSource string) error {
+	rpmSourceAbs, err := filepath.Abs(rpmSource)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(rpmSourceAbs)
+	if err != nil {
+		return fmt.Errorf("failed to stat RPM source (%s):\n%w", rpmSource, err)
+	}
+
+	if !stat.IsDir() {
+		fmt.Fprintf(digest, "rpm source file: %s\n", rpmSourceAbs)
+
+		sourceFile, err := os.Open(rpmSourceAbs)
+		if err != nil {
+			return fmt.Errorf("failed to open RPM source (%s):\n%w", rpmSource, err)
+		}
+		defer sourceFile.Close()
+
+		_, err = io.Copy(digest, sourceFile)
+		if err != nil {
+			return fmt.Errorf("failed to read RPM source (%s):\n%w", rpmSource, err)
+		}
+
+		fmt.Fprintln(digest)
+		return nil
+	}
+
+	fmt.Fprintf(digest, "rpm source dir: %s\n", rpmSourceAbs)
+
+	err = filepath.WalkDir(rpmSourceAbs, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rpmSourceAbs, path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(digest, "%s %d %d\n", relPath, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list RPM source (%s):\n%w", rpmSource, err)
+	}
+
+	return nil
+}
+
+// runPackageStage customizes the packages of the image, in its own connection to the image, so that the image can be
+// cached once the packages are installed.
+func runPackageStage(buildDir string, baseConfigPath string, config *imagecustomizerapi.Config,
+	buildImageFile string, rpmsSources []string, useBaseImageRpmRepos bool, partitionsCustomized bool,
+	partIdToPartUuid map[string]string,
+) (*packageStage, error) {
+	stage := &packageStage{
+		PartitionsCustomized: partitionsCustomized,
+		PartIdToPartUuid:     partIdToPartUuid,
+	}
+
+	imageConnection, err := connectToExistingImage(buildImageFile, buildDir, "imageroot", true)
+	if err != nil {
+		return nil, err
+	}
+	defer imageConnection.Close()
+
+	imageChroot := imageConnection.Chroot()
+
+	resolvConf, err := overrideResolvConf(imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	if getModuleSigning(config.OS) != nil {
+		stage.BaseKernelModules, err = listKernelModules(imageChroot.RootDir())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stage.OrphansRemoved, err = addRemoveAndUpdatePackages(buildDir, baseConfigPath, config.OS, imageChroot,
+		rpmsSources, useBaseImageRpmRepos)
+
+	// Out of disk space errors can be difficult to diagnose.
+	// So, warn about any partitions with low free space.
+	warnOnLowFreeSpace(buildDir, imageConnection)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = restoreResolvConf(resolvConf, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = imageConnection.CleanClose()
+	if err != nil {
+		return nil, err
+	}
+
+	return stage, nil
+}
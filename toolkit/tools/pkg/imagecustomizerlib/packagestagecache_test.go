@@ -0,0 +1,217 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getPackageStageCacheTestParameters(t *testing.T) *ImageCustomizerParameters {
+	testDir := t.TempDir()
+
+	inputImageFile := filepath.Join(testDir, "image.vhdx")
+	err := os.WriteFile(inputImageFile, []byte("image"), 0o644)
+	require.NoError(t, err)
+
+	rpmsDir := filepath.Join(testDir, "rpms")
+	err = os.MkdirAll(rpmsDir, os.ModePerm)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rpmsDir, "jq.rpm"), []byte("jq"), 0o644)
+	require.NoError(t, err)
+
+	return &ImageCustomizerParameters{
+		inputImageFile:  inputImageFile,
+		configPath:      testDir,
+		rpmsSources:     []string{rpmsDir},
+		packageCacheDir: filepath.Join(testDir, "cache"),
+		config: &imagecustomizerapi.Config{
+			OS: &imagecustomizerapi.OS{
+				Packages: imagecustomizerapi.Packages{
+					Install: []string{"jq"},
+				},
+			},
+		},
+	}
+}
+
+func TestGetPackageStageCacheKey(t *testing.T) {
+	ic := getPackageStageCacheTestParameters(t)
+
+	key, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	sameKey, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.Equal(t, key, sameKey)
+
+	// The customizations after the package stage don't change the key.
+	ic.config.OS.Hostname = "test"
+	ic.config.OS.AdditionalFiles = imagecustomizerapi.AdditionalFileList{
+		{Source: "files/a.txt", Destination: "/a.txt"},
+	}
+
+	sameKey, err = getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.Equal(t, key, sameKey)
+
+	// The packages do.
+	ic.config.OS.Packages.Install = []string{"jq", "git"}
+
+	packagesKey, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, packagesKey)
+
+	// As do the RPMs.
+	err = os.WriteFile(filepath.Join(ic.rpmsSources[0], "git.rpm"), []byte("git"), 0o644)
+	require.NoError(t, err)
+
+	rpmsKey, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.NotEqual(t, packagesKey, rpmsKey)
+
+	// And the base image.
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(ic.inputImageFile, later, later)
+	require.NoError(t, err)
+
+	imageKey, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.NotEqual(t, rpmsKey, imageKey)
+}
+
+func TestGetPackageStageCacheKeyRepoFile(t *testing.T) {
+	ic := getPackageStageCacheTestParameters(t)
+	ic.buildDirAbs = filepath.Join(ic.configPath, "build")
+
+	revision := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<repomd><revision>%s</revision></repomd>", revision)
+	}))
+	defer server.Close()
+
+	repoFile := filepath.Join(ic.configPath, "test.repo")
+	err := os.WriteFile(repoFile, []byte("[test]\nbaseurl="+server.URL+"/a\n"), 0o644)
+	require.NoError(t, err)
+	ic.rpmsSources = []string{repoFile}
+
+	key, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+
+	// The repo definitions are part of the key.
+	err = os.WriteFile(repoFile, []byte("[test]\nbaseurl="+server.URL+"/b\n"), 0o644)
+	require.NoError(t, err)
+
+	repoFileKey, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, repoFileKey)
+
+	// As are the current contents of the repos.
+	revision = "2"
+
+	revisionKey, err := getPackageStageCacheKey(ic)
+	require.NoError(t, err)
+	assert.NotEqual(t, repoFileKey, revisionKey)
+}
+
+func TestNewPackageStageCacheDisabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(ic *ImageCustomizerParameters)
+	}{
+		{
+			name:   "no cache dir",
+			update: func(ic *ImageCustomizerParameters) { ic.packageCacheDir = "" },
+		},
+		{
+			name:   "no packages",
+			update: func(ic *ImageCustomizerParameters) { ic.config.OS.Packages = imagecustomizerapi.Packages{} },
+		},
+		{
+			name: "change manifest",
+			update: func(ic *ImageCustomizerParameters) {
+				ic.config.ChangeManifest = &imagecustomizerapi.ChangeManifest{}
+			},
+		},
+		{
+			name: "selinux report",
+			update: func(ic *ImageCustomizerParameters) {
+				ic.config.SELinuxReport = &imagecustomizerapi.SELinuxReport{}
+			},
+		},
+		{
+			name:   "container output",
+			update: func(ic *ImageCustomizerParameters) { ic.outputIsContainer = true },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ic := getPackageStageCacheTestParameters(t)
+			test.update(ic)
+
+			cache, err := newPackageStageCache(ic)
+			assert.NoError(t, err)
+			assert.Nil(t, cache)
+		})
+	}
+}
+
+func TestPackageStageCacheSaveAndRestore(t *testing.T) {
+	ic := getPackageStageCacheTestParameters(t)
+
+	cache, err := newPackageStageCache(ic)
+	require.NoError(t, err)
+	require.NotNil(t, cache)
+
+	buildImageFile := filepath.Join(t.TempDir(), "image.raw")
+
+	stage, err := cache.restore(buildImageFile)
+	assert.NoError(t, err)
+	assert.Nil(t, stage)
+
+	err = os.WriteFile(buildImageFile, []byte("customized image"), 0o644)
+	require.NoError(t, err)
+
+	savedStage := &packageStage{
+		PartitionsCustomized: true,
+		PartIdToPartUuid:     map[string]string{"rootfs": "f8a5c3a5-7d2b-4c8e-9a1e-3b6f2d1c0e9a"},
+		OrphansRemoved:       []string{"libfoo"},
+	}
+	err = cache.save(buildImageFile, savedStage)
+	require.NoError(t, err)
+
+	// Saving an existing entry keeps the existing entry.
+	err = os.WriteFile(buildImageFile, []byte("other image"), 0o644)
+	require.NoError(t, err)
+
+	err = cache.save(buildImageFile, &packageStage{})
+	require.NoError(t, err)
+
+	err = os.WriteFile(buildImageFile, []byte("base image"), 0o644)
+	require.NoError(t, err)
+
+	stage, err = cache.restore(buildImageFile)
+	require.NoError(t, err)
+	assert.Equal(t, savedStage, stage)
+
+	contents, err := os.ReadFile(buildImageFile)
+	require.NoError(t, err)
+	assert.Equal(t, "customized image", string(contents))
+
+	tempFiles, err := filepath.Glob(filepath.Join(ic.packageCacheDir, "*"+packageStageCacheTempSuffix))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+}
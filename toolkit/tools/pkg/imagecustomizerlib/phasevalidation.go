@@ -0,0 +1,182 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safemount"
+)
+
+// ValidationPhase is a point within a build at which the PhaseValidators are called.
+type ValidationPhase string
+
+const (
+	// ValidationPhaseAfterPackages is after the packages have been added, removed, and updated, before the
+	// postPackageInstall scripts are run.
+	ValidationPhaseAfterPackages ValidationPhase = "afterPackages"
+	// ValidationPhaseAfterScripts is after the last of the scripts (finalizeOutsideChroot) has been run, once the OS
+	// customizations are complete.
+	ValidationPhaseAfterScripts ValidationPhase = "afterScripts"
+	// ValidationPhaseBeforeOutput is once the image's contents are final, before the filesystems are shrunk and
+	// protected (e.g. verity, encryption) and the output image is written.
+	ValidationPhaseBeforeOutput ValidationPhase = "beforeOutput"
+
+	phaseValidationRootDirName = "validationroot"
+)
+
+// PhaseValidator is a check that is run against the image at a point within the build (e.g. to enforce an
+// organization's policies). If the check fails, then the build fails.
+type PhaseValidator struct {
+	// Name identifies the validator in the logs and errors.
+	Name string
+	// Phase is the point within the build at which the validator is called.
+	Phase ValidationPhase
+	// Validate checks the image. Returning an error stops the build.
+	Validate func(validationContext PhaseValidationContext) error
+}
+
+// PhaseValidationContext is the state of the build that is passed to a PhaseValidator.
+type PhaseValidationContext struct {
+	Phase ValidationPhase
+	// RootDir is a read-only view of the image's filesystems, mounted as they are within the image. The view is only
+	// valid until the validator returns.
+	RootDir string
+	// Config is the build's config. It must not be modified.
+	Config *imagecustomizerapi.Config
+}
+
+func (p ValidationPhase) IsValid() error {
+	switch p {
+	case ValidationPhaseAfterPackages, ValidationPhaseAfterScripts, ValidationPhaseBeforeOutput:
+		return nil
+
+	default:
+		return fmt.Errorf("invalid validation phase (%s)", p)
+	}
+}
+
+func (v *PhaseValidator) IsValid() error {
+	if v.Name == "" {
+		return fmt.Errorf("validator name must not be empty")
+	}
+
+	err := v.Phase.IsValid()
+	if err != nil {
+		return fmt.Errorf("invalid validator (%s):\n%w", v.Name, err)
+	}
+
+	if v.Validate == nil {
+		return fmt.Errorf("validator (%s) must have a Validate function", v.Name)
+	}
+
+	return nil
+}
+
+// checkPhaseValidators checks that the phase validators are valid and that the build calls them.
+func checkPhaseValidators(ic *ImageCustomizerParameters) error {
+	for i := range ic.phaseValidators {
+		err := ic.phaseValidators[i].IsValid()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(ic.phaseValidators) > 0 && ic.inputIsIso && !ic.customizeOSPartitions {
+		return fmt.Errorf("phase validators require OS customizations when the input image is an iso image")
+	}
+
+	return nil
+}
+
+// runPhaseValidators calls the validators of the phase with a read-only view of the image's root directory.
+func runPhaseValidators(buildDir string, validators []PhaseValidator, phase ValidationPhase, rootDir string,
+	config *imagecustomizerapi.Config,
+) error {
+	if !hasPhaseValidators(validators, phase) {
+		return nil
+	}
+
+	viewDir := filepath.Join(buildDir, phaseValidationRootDirName)
+	view, err := safemount.NewReadOnlyBindMount(rootDir, viewDir, true)
+	if err != nil {
+		return fmt.Errorf("failed to create read-only view of image for validators:\n%w", err)
+	}
+	defer view.Close()
+
+	validationContext := PhaseValidationContext{
+		Phase:   phase,
+		RootDir: viewDir,
+		Config:  config,
+	}
+
+	for _, validator := range validators {
+		if validator.Phase != phase {
+			continue
+		}
+
+		logger.Log.Infof("Running validator (%s) at phase (%s)", validator.Name, phase)
+
+		err = validator.Validate(validationContext)
+		if err != nil {
+			return fmt.Errorf("validator (%s) failed at phase (%s):\n%w", validator.Name, phase, err)
+		}
+	}
+
+	err = view.CleanClose()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// runHotfixPhaseValidators calls the validators of the phases that a hotfix (or an initrd rebuild) passes through. A
+// hotfix updates packages but doesn't run any scripts, so both of its phases are at the same point.
+func runHotfixPhaseValidators(buildDir string, validators []PhaseValidator, rootDir string,
+	config *imagecustomizerapi.Config,
+) error {
+	for _, phase := range []ValidationPhase{ValidationPhaseAfterPackages, ValidationPhaseAfterScripts} {
+		err := runPhaseValidators(buildDir, validators, phase, rootDir, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runBeforeOutputValidators calls the validators of the beforeOutput phase, connecting to the image for them.
+func runBeforeOutputValidators(ic *ImageCustomizerParameters) error {
+	if !hasPhaseValidators(ic.phaseValidators, ValidationPhaseBeforeOutput) {
+		return nil
+	}
+
+	imageConnection, err := connectToExistingImage(ic.rawImageFile, ic.buildDirAbs, "imageroot", false)
+	if err != nil {
+		return err
+	}
+	defer imageConnection.Close()
+
+	err = runPhaseValidators(ic.buildDirAbs, ic.phaseValidators, ValidationPhaseBeforeOutput,
+		imageConnection.Chroot().RootDir(), ic.config)
+	if err != nil {
+		return err
+	}
+
+	err = imageConnection.CleanClose()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func hasPhaseValidators(validators []PhaseValidator, phase ValidationPhase) bool {
+	return slices.ContainsFunc(validators, func(v PhaseValidator) bool { return v.Phase == phase })
+}
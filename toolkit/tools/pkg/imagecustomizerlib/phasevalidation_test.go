@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestPhaseValidatorIsValid(t *testing.T) {
+	validate := func(PhaseValidationContext) error { return nil }
+
+	validator := PhaseValidator{Name: "policy", Phase: ValidationPhaseAfterPackages, Validate: validate}
+	assert.NoError(t, validator.IsValid())
+
+	validator = PhaseValidator{Phase: ValidationPhaseAfterPackages, Validate: validate}
+	assert.ErrorContains(t, validator.IsValid(), "validator name must not be empty")
+
+	validator = PhaseValidator{Name: "policy", Phase: "afterBoot", Validate: validate}
+	assert.ErrorContains(t, validator.IsValid(), "invalid validation phase (afterBoot)")
+
+	validator = PhaseValidator{Name: "policy", Phase: ValidationPhaseBeforeOutput}
+	assert.ErrorContains(t, validator.IsValid(), "validator (policy) must have a Validate function")
+}
+
+func TestCheckPhaseValidatorsIsoWithoutOSCustomizations(t *testing.T) {
+	ic := &ImageCustomizerParameters{
+		inputIsIso: true,
+		phaseValidators: []PhaseValidator{{
+			Name:     "policy",
+			Phase:    ValidationPhaseAfterScripts,
+			Validate: func(PhaseValidationContext) error { return nil },
+		}},
+	}
+	assert.ErrorContains(t, checkPhaseValidators(ic), "phase validators require OS customizations")
+
+	ic.customizeOSPartitions = true
+	assert.NoError(t, checkPhaseValidators(ic))
+}
+
+func TestRunPhaseValidatorsNoneForPhase(t *testing.T) {
+	called := false
+	validators := []PhaseValidator{{
+		Name:  "policy",
+		Phase: ValidationPhaseBeforeOutput,
+		Validate: func(PhaseValidationContext) error {
+			called = true
+			return nil
+		},
+	}}
+
+	// No view is created when none of the validators are for the phase, so this doesn't need root.
+	err := runPhaseValidators(t.TempDir(), validators, ValidationPhaseAfterPackages, "/nonexistent", nil)
+	assert.NoError(t, err)
+	assert.False(t, called)
+}
+
+func TestRunPhaseValidators(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Test must be run as root because it creates mounts")
+	}
+
+	buildDir := t.TempDir()
+	rootDir := filepath.Join(buildDir, "imageroot")
+	err := os.MkdirAll(filepath.Join(rootDir, "etc"), os.ModePerm)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "etc/hostname"), []byte("web\n"), 0o644)
+	require.NoError(t, err)
+
+	config := &imagecustomizerapi.Config{}
+	calls := []string(nil)
+	validators := []PhaseValidator{
+		{
+			Name:  "hostname",
+			Phase: ValidationPhaseAfterScripts,
+			Validate: func(validationContext PhaseValidationContext) error {
+				calls = append(calls, "hostname")
+
+				assert.Equal(t, ValidationPhaseAfterScripts, validationContext.Phase)
+				assert.Same(t, config, validationContext.Config)
+
+				hostname, err := os.ReadFile(filepath.Join(validationContext.RootDir, "etc/hostname"))
+				assert.NoError(t, err)
+				assert.Equal(t, "web\n", string(hostname))
+
+				err = os.WriteFile(filepath.Join(validationContext.RootDir, "etc/hostname"), []byte("db\n"), 0o644)
+				assert.ErrorIs(t, err, unix.EROFS)
+				return nil
+			},
+		},
+		{
+			Name:  "packages",
+			Phase: ValidationPhaseAfterPackages,
+			Validate: func(PhaseValidationContext) error {
+				calls = append(calls, "packages")
+				return nil
+			},
+		},
+		{
+			Name:  "no-telnet",
+			Phase: ValidationPhaseAfterScripts,
+			Validate: func(PhaseValidationContext) error {
+				calls = append(calls, "no-telnet")
+				return fmt.Errorf("telnet is enabled")
+			},
+		},
+	}
+
+	err = runPhaseValidators(buildDir, validators, ValidationPhaseAfterScripts, rootDir, config)
+	assert.ErrorContains(t, err, "validator (no-telnet) failed at phase (afterScripts):\ntelnet is enabled")
+	assert.Equal(t, []string{"hostname", "no-telnet"}, calls)
+
+	// The view is removed, even though a validator failed.
+	assert.NoDirExists(t, filepath.Join(buildDir, phaseValidationRootDirName))
+}
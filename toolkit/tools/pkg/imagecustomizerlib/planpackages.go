@@ -0,0 +1,450 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm/repodata"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/versioncompare"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// The first line holds the package's identity. The remaining lines hold its provides and requires.
+	rpmPlanQueryFormat = "%{NAME}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\t%{ARCH}\n[P\t%{PROVIDENAME}\n][R\t%{REQUIRENAME}\n]"
+)
+
+// rpmPackageInfo is the subset of an RPM's header that is needed to resolve a package transaction.
+type rpmPackageInfo struct {
+	Name string
+	// Version is "epoch:version-release".
+	Version  string
+	Arch     string
+	Path     string
+	Provides []string
+	Requires []string
+}
+
+// fullName returns the package's "name-[epoch:]version-release.arch" string, where the epoch is omitted if it is 0.
+func (p *rpmPackageInfo) fullName() string {
+	version := p.Version
+	if epoch, versionRelease, found := strings.Cut(version, ":"); found && epoch == "0" {
+		version = versionRelease
+	}
+
+	return fmt.Sprintf("%s-%s.%s", p.Name, version, p.Arch)
+}
+
+// packageTransaction is the result of resolving the requested packages against the RPM directories.
+type packageTransaction struct {
+	// Resolved maps each requested package to the RPM that provides it.
+	Resolved map[string]*rpmPackageInfo
+	// Unresolved are the requested packages that none of the RPM directories provide.
+	Unresolved []string
+	// Dependencies are the RPMs that the requested packages pull in.
+	Dependencies []*rpmPackageInfo
+	// ExternalRequires are the requirements that none of the RPM directories provide. These are expected to be
+	// satisfied by the base image or by the other RPM sources.
+	ExternalRequires []string
+}
+
+// planRpmSources are the RPM sources of a customization, grouped by how the plan can read their packages.
+type planRpmSources struct {
+	// Dirs are the directories of RPMs, including the config's local repos and the repos with a file:// baseurl.
+	Dirs []string
+	// RemoteRepoUrls are the base URLs of the remote (http:// or https://) repos.
+	RemoteRepoUrls []string
+	// Other are the sources whose packages can only be queried from within the image (e.g. repos whose baseurl uses
+	// tdnf variables, like $releasever).
+	Other []string
+}
+
+// getPlanRpmSources groups the RPM sources (including the repos of the repo files and the config's local repos) by
+// how their packages can be read.
+func getPlanRpmSources(baseConfigPath string, rpmsSources []string, localRepos []imagecustomizerapi.LocalRepo,
+) (*planRpmSources, error) {
+	sources := &planRpmSources{}
+
+	for _, rpmSource := range rpmsSources {
+		fileType, err := getRpmSourceFileType(rpmSource)
+		if err != nil {
+			return nil, err
+		}
+
+		switch fileType {
+		case "dir":
+			sources.Dirs = append(sources.Dirs, rpmSource)
+
+		case "repo":
+			err = sources.addRepoFile(rpmSource)
+			if err != nil {
+				return nil, err
+			}
+
+		default:
+			sources.Other = append(sources.Other, rpmSource)
+		}
+	}
+
+	for _, localRepo := range localRepos {
+		sources.Dirs = append(sources.Dirs, file.GetAbsPathWithBase(baseConfigPath, localRepo.Path))
+	}
+
+	return sources, nil
+}
+
+// addRepoFile adds the enabled repos of a repo file, in the same way that createRepoFromRepoConfig adds them to the
+// image's repo config.
+func (s *planRpmSources) addRepoFile(repoFilePath string) error {
+	reposConfig, err := ini.Load(repoFilePath)
+	if err != nil {
+		return fmt.Errorf("failed load repo config file (%s):\n%w", repoFilePath, err)
+	}
+
+	for _, repoConfig := range reposConfig.Sections() {
+		if repoConfig.Name() == ini.DefaultSection {
+			continue
+		}
+
+		if repoConfig.HasKey("enabled") && !repoConfig.Key("enabled").MustBool(true) {
+			continue
+		}
+
+		repoName := fmt.Sprintf("%s [%s]", repoFilePath, repoConfig.Name())
+
+		baseUrl := repoConfig.Key("baseurl").String()
+		switch {
+		case strings.Contains(baseUrl, "$"):
+			s.Other = append(s.Other, repoName)
+
+		case strings.HasPrefix(baseUrl, "file://"):
+			s.Dirs = append(s.Dirs, strings.TrimPrefix(baseUrl, "file://"))
+
+		case strings.HasPrefix(baseUrl, "http://"), strings.HasPrefix(baseUrl, "https://"):
+			s.RemoteRepoUrls = append(s.RemoteRepoUrls, baseUrl)
+
+		default:
+			// For example, repos that only have a metalink or a mirrorlist.
+			s.Other = append(s.Other, repoName)
+		}
+	}
+
+	return nil
+}
+
+// readRemoteRepos reads the packages of the remote repos from their primary metadata.
+func readRemoteRepos(buildDir string, repoUrls []string) ([]*rpmPackageInfo, error) {
+	if len(repoUrls) <= 0 {
+		return nil, nil
+	}
+
+	err := os.MkdirAll(buildDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build directory (%s):\n%w", buildDir, err)
+	}
+
+	tempDir, err := os.MkdirTemp(buildDir, "plan-repodata-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory for repo metadata:\n%w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	packages := []*rpmPackageInfo(nil)
+	for i, repoUrl := range repoUrls {
+		primary, err := repodata.ReadRemotePrimary(context.Background(), repoUrl,
+			filepath.Join(tempDir, strconv.Itoa(i)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote repo (%s):\n%w", repoUrl, err)
+		}
+
+		for _, primaryPackage := range primary.Packages {
+			packages = append(packages, primaryPackageToPlanInfo(repoUrl, primaryPackage))
+		}
+	}
+
+	return packages, nil
+}
+
+// primaryPackageToPlanInfo converts a package of a repo's primary metadata to the same form as the RPMs of the RPM
+// directories.
+func primaryPackageToPlanInfo(repoUrl string, primaryPackage *repodata.Package) *rpmPackageInfo {
+	info := &rpmPackageInfo{
+		Name: primaryPackage.Name,
+		Arch: primaryPackage.Arch,
+	}
+
+	if primaryPackage.Version != nil {
+		epoch := primaryPackage.Version.Epoch
+		if epoch == "" {
+			epoch = "0"
+		}
+
+		info.Version = fmt.Sprintf("%s:%s-%s", epoch, primaryPackage.Version.Version, primaryPackage.Version.Release)
+	}
+
+	if primaryPackage.Location != nil {
+		info.Path = strings.TrimSuffix(repoUrl, "/") + "/" + primaryPackage.Location.Href
+	}
+
+	if primaryPackage.Format != nil {
+		if primaryPackage.Format.Provides != nil {
+			for _, entry := range primaryPackage.Format.Provides.Entries {
+				info.Provides = append(info.Provides, entry.Name)
+			}
+		}
+
+		if primaryPackage.Format.Requires != nil {
+			for _, entry := range primaryPackage.Format.Requires.Entries {
+				info.Requires = append(info.Requires, entry.Name)
+			}
+		}
+	}
+
+	return info
+}
+
+// readRpmDirs reads the headers of all the RPMs within the directories.
+func readRpmDirs(rpmDirs []string) ([]*rpmPackageInfo, error) {
+	packages := []*rpmPackageInfo(nil)
+
+	for _, rpmDir := range rpmDirs {
+		err := filepath.WalkDir(rpmDir, func(rpmPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				// Skip any existing repo metadata.
+				if d.Name() == "repodata" || d.Name() == ".repodata" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), ".rpm") {
+				return nil
+			}
+
+			lines, err := rpm.QueryPackage(rpmPath, rpmPlanQueryFormat, nil, "-p")
+			if err != nil {
+				return fmt.Errorf("failed to query RPM (%s):\n%w", rpmPath, err)
+			}
+
+			info, err := parseRpmPlanQuery(rpmPath, lines)
+			if err != nil {
+				return err
+			}
+
+			packages = append(packages, info)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RPM directory (%s):\n%w", rpmDir, err)
+		}
+	}
+
+	return packages, nil
+}
+
+// parseRpmPlanQuery parses the output of an RPM query that used rpmPlanQueryFormat.
+func parseRpmPlanQuery(rpmPath string, lines []string) (*rpmPackageInfo, error) {
+	if len(lines) <= 0 {
+		return nil, fmt.Errorf("RPM query of (%s) returned no output", rpmPath)
+	}
+
+	fields := strings.Split(lines[0], "\t")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("RPM query of (%s) returned an invalid header line (%s)", rpmPath, lines[0])
+	}
+
+	info := &rpmPackageInfo{
+		Name:    fields[0],
+		Version: fields[1],
+		Arch:    fields[2],
+		Path:    rpmPath,
+	}
+
+	for _, line := range lines[1:] {
+		kind, value, found := strings.Cut(line, "\t")
+		if !found {
+			return nil, fmt.Errorf("RPM query of (%s) returned an invalid line (%s)", rpmPath, line)
+		}
+
+		switch kind {
+		case "P":
+			info.Provides = append(info.Provides, value)
+
+		case "R":
+			info.Requires = append(info.Requires, value)
+
+		default:
+			return nil, fmt.Errorf("RPM query of (%s) returned an invalid line (%s)", rpmPath, line)
+		}
+	}
+
+	return info, nil
+}
+
+// resolvePackageTransaction finds the RPMs that provide the requested packages, along with all the RPMs they depend
+// on. A requested package may be a package name, a "name-version[-release][.arch]" string, or a capability (e.g.
+// "/usr/bin/vim").
+//
+// Unlike tdnf, this doesn't know which packages are already installed in the image. So, requirements that the
+// RPMs don't provide are only reported, rather than treated as errors.
+func resolvePackageTransaction(requested []string, available []*rpmPackageInfo) *packageTransaction {
+	transaction := &packageTransaction{
+		Resolved: make(map[string]*rpmPackageInfo),
+	}
+
+	selected := make(map[*rpmPackageInfo]bool)
+	queue := []*rpmPackageInfo(nil)
+
+	for _, name := range requested {
+		info := findRequestedPackage(name, available)
+		if info == nil {
+			transaction.Unresolved = append(transaction.Unresolved, name)
+			continue
+		}
+
+		transaction.Resolved[name] = info
+		if !selected[info] {
+			selected[info] = true
+			queue = append(queue, info)
+		}
+	}
+
+	requestedPackages := make(map[*rpmPackageInfo]bool, len(selected))
+	for info := range selected {
+		requestedPackages[info] = true
+	}
+
+	externalRequires := make(map[string]bool)
+
+	for len(queue) > 0 {
+		info := queue[0]
+		queue = queue[1:]
+
+		for _, require := range info.Requires {
+			if strings.HasPrefix(require, "rpmlib(") {
+				// Provided by rpm itself.
+				continue
+			}
+
+			provider := findPackageProvider(require, available)
+			if provider == nil {
+				externalRequires[require] = true
+				continue
+			}
+
+			if !selected[provider] {
+				selected[provider] = true
+				queue = append(queue, provider)
+			}
+		}
+	}
+
+	for info := range selected {
+		if !requestedPackages[info] {
+			transaction.Dependencies = append(transaction.Dependencies, info)
+		}
+	}
+
+	sort.Slice(transaction.Dependencies, func(i, j int) bool {
+		return transaction.Dependencies[i].fullName() < transaction.Dependencies[j].fullName()
+	})
+
+	for require := range externalRequires {
+		transaction.ExternalRequires = append(transaction.ExternalRequires, require)
+	}
+	sort.Strings(transaction.ExternalRequires)
+
+	return transaction
+}
+
+// findRequestedPackage finds the newest RPM that matches a package requested in the config.
+func findRequestedPackage(requested string, available []*rpmPackageInfo) *rpmPackageInfo {
+	best := (*rpmPackageInfo)(nil)
+
+	for _, info := range available {
+		if !packageMatchesRequest(info, requested) {
+			continue
+		}
+
+		best = newerPackage(best, info)
+	}
+
+	if best != nil {
+		return best
+	}
+
+	return findPackageProvider(requested, available)
+}
+
+func packageMatchesRequest(info *rpmPackageInfo, requested string) bool {
+	if info.Name == requested {
+		return true
+	}
+
+	_, versionRelease, _ := strings.Cut(info.Version, ":")
+	version, _, _ := strings.Cut(versionRelease, "-")
+
+	candidates := []string{
+		fmt.Sprintf("%s-%s", info.Name, version),
+		fmt.Sprintf("%s-%s", info.Name, versionRelease),
+		fmt.Sprintf("%s-%s.%s", info.Name, versionRelease, info.Arch),
+		fmt.Sprintf("%s.%s", info.Name, info.Arch),
+	}
+
+	for _, candidate := range candidates {
+		if candidate == requested {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findPackageProvider finds the newest RPM that provides a capability.
+func findPackageProvider(capability string, available []*rpmPackageInfo) *rpmPackageInfo {
+	best := (*rpmPackageInfo)(nil)
+
+	for _, info := range available {
+		provided := info.Name == capability
+		for _, provide := range info.Provides {
+			if provide == capability {
+				provided = true
+				break
+			}
+		}
+
+		if provided {
+			best = newerPackage(best, info)
+		}
+	}
+
+	return best
+}
+
+func newerPackage(a *rpmPackageInfo, b *rpmPackageInfo) *rpmPackageInfo {
+	if a == nil {
+		return b
+	}
+
+	if versioncompare.New(b.Version).Compare(versioncompare.New(a.Version)) > 0 {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm/repodata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRpmPlanQuery(t *testing.T) {
+	lines := []string{
+		"jq\t0:1.7.1-2.azl3\tx86_64",
+		"P\tjq",
+		"P\tjq(x86-64)",
+		"R\tlibonig.so.5()(64bit)",
+		"R\trpmlib(CompressedFileNames)",
+	}
+
+	info, err := parseRpmPlanQuery("/rpms/jq.rpm", lines)
+	assert.NoError(t, err)
+	assert.Equal(t, &rpmPackageInfo{
+		Name:     "jq",
+		Version:  "0:1.7.1-2.azl3",
+		Arch:     "x86_64",
+		Path:     "/rpms/jq.rpm",
+		Provides: []string{"jq", "jq(x86-64)"},
+		Requires: []string{"libonig.so.5()(64bit)", "rpmlib(CompressedFileNames)"},
+	}, info)
+
+	_, err = parseRpmPlanQuery("/rpms/jq.rpm", nil)
+	assert.ErrorContains(t, err, "RPM query of (/rpms/jq.rpm) returned no output")
+
+	_, err = parseRpmPlanQuery("/rpms/jq.rpm", []string{"jq\t0:1.7.1-2.azl3\tx86_64", "X\tbogus"})
+	assert.ErrorContains(t, err, "returned an invalid line (X\tbogus)")
+}
+
+func TestResolvePackageTransaction(t *testing.T) {
+	jqOld := &rpmPackageInfo{
+		Name: "jq", Version: "0:1.7.0-1.azl3", Arch: "x86_64",
+		Provides: []string{"jq"},
+		Requires: []string{"libonig.so.5()(64bit)"},
+	}
+	jq := &rpmPackageInfo{
+		Name: "jq", Version: "0:1.7.1-2.azl3", Arch: "x86_64",
+		Provides: []string{"jq", "/usr/bin/jq"},
+		Requires: []string{"libonig.so.5()(64bit)", "libc.so.6()(64bit)", "rpmlib(CompressedFileNames)"},
+	}
+	oniguruma := &rpmPackageInfo{
+		Name: "oniguruma", Version: "0:6.9.9-1.azl3", Arch: "x86_64",
+		Provides: []string{"oniguruma", "libonig.so.5()(64bit)"},
+		Requires: []string{"libc.so.6()(64bit)"},
+	}
+	available := []*rpmPackageInfo{jqOld, jq, oniguruma}
+
+	transaction := resolvePackageTransaction([]string{"jq", "/usr/bin/jq", "jq-1.7.0", "golang"}, available)
+	assert.Equal(t, map[string]*rpmPackageInfo{
+		"jq":          jq,
+		"/usr/bin/jq": jq,
+		"jq-1.7.0":    jqOld,
+	}, transaction.Resolved)
+	assert.Equal(t, []string{"golang"}, transaction.Unresolved)
+	assert.Equal(t, []*rpmPackageInfo{oniguruma}, transaction.Dependencies)
+	assert.Equal(t, []string{"libc.so.6()(64bit)"}, transaction.ExternalRequires)
+}
+
+func TestPackageMatchesRequest(t *testing.T) {
+	info := &rpmPackageInfo{Name: "python3.12", Version: "0:3.12.3-4.azl3", Arch: "x86_64"}
+
+	assert.True(t, packageMatchesRequest(info, "python3.12"))
+	assert.True(t, packageMatchesRequest(info, "python3.12-3.12.3"))
+	assert.True(t, packageMatchesRequest(info, "python3.12-3.12.3-4.azl3"))
+	assert.True(t, packageMatchesRequest(info, "python3.12-3.12.3-4.azl3.x86_64"))
+	assert.True(t, packageMatchesRequest(info, "python3.12.x86_64"))
+
+	assert.False(t, packageMatchesRequest(info, "python3"))
+	assert.False(t, packageMatchesRequest(info, "python3.12-3.12.2"))
+	assert.False(t, packageMatchesRequest(info, "python3.12.aarch64"))
+}
+
+func TestPlanPackageTransaction(t *testing.T) {
+	jq := &rpmPackageInfo{Name: "jq", Version: "0:1.7.1-2.azl3", Arch: "x86_64"}
+
+	details, err := planPackageTransaction([]string{"jq", "golang"}, []*rpmPackageInfo{jq},
+		[]string{"azl.repo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"jq -> jq-1.7.1-2.azl3.x86_64",
+		"golang (from azl.repo)",
+	}, details)
+
+	_, err = planPackageTransaction([]string{"jq", "golang"}, []*rpmPackageInfo{jq}, nil)
+	assert.ErrorContains(t, err, "packages not provided by any RPM source: golang")
+}
+
+func TestGetPlanRpmSources(t *testing.T) {
+	testDir := t.TempDir()
+	rpmsDir := filepath.Join(testDir, "rpms")
+	repoFile := filepath.Join(testDir, "azl.repo")
+
+	err := os.MkdirAll(rpmsDir, os.ModePerm)
+	require.NoError(t, err)
+
+	err = file.Write(`[local]
+baseurl=file:///srv/rpms
+
+[remote]
+baseurl=https://packages.example.com/azl/3.0/base/x86_64/
+
+[templated]
+baseurl=https://packages.example.com/azl/$releasever/base/$basearch/
+
+[mirrors]
+metalink=https://packages.example.com/metalink
+
+[disabled]
+baseurl=https://packages.example.com/disabled/
+enabled=0
+`, repoFile)
+	require.NoError(t, err)
+
+	sources, err := getPlanRpmSources(testDir, []string{rpmsDir, repoFile},
+		[]imagecustomizerapi.LocalRepo{{Path: "localrepo"}})
+	require.NoError(t, err)
+	assert.Equal(t, &planRpmSources{
+		Dirs:           []string{rpmsDir, "/srv/rpms", filepath.Join(testDir, "localrepo")},
+		RemoteRepoUrls: []string{"https://packages.example.com/azl/3.0/base/x86_64/"},
+		Other:          []string{repoFile + " [templated]", repoFile + " [mirrors]"},
+	}, sources)
+}
+
+func TestPrimaryPackageToPlanInfo(t *testing.T) {
+	primaryPackage := &repodata.Package{
+		Name:     "jq",
+		Arch:     "x86_64",
+		Version:  &repodata.Version{Epoch: "0", Version: "1.7.1", Release: "2.azl3"},
+		Location: &repodata.Location{Href: "Packages/j/jq-1.7.1-2.azl3.x86_64.rpm"},
+		Format: &repodata.Format{
+			Provides: &repodata.Entries{Entries: []*repodata.Entry{{Name: "jq"}}},
+			Requires: &repodata.Entries{Entries: []*repodata.Entry{{Name: "libonig.so.5()(64bit)"}}},
+		},
+	}
+
+	info := primaryPackageToPlanInfo("https://packages.example.com/azl/", primaryPackage)
+	assert.Equal(t, &rpmPackageInfo{
+		Name:     "jq",
+		Version:  "0:1.7.1-2.azl3",
+		Arch:     "x86_64",
+		Path:     "https://packages.example.com/azl/Packages/j/jq-1.7.1-2.azl3.x86_64.rpm",
+		Provides: []string{"jq"},
+		Requires: []string{"libonig.so.5()(64bit)"},
+	}, info)
+}
@@ -0,0 +1,434 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	preflightChrootDir = "preflightroot"
+
+	osReleaseFile    = "/etc/os-release"
+	dracutModulesDir = "/usr/lib/dracut/modules.d"
+	dracutBinaryPath = "/usr/bin/dracut"
+)
+
+// The OS release IDs of the distros that the customizer supports.
+var supportedOsReleaseIds = []string{"azurelinux", "mariner"}
+
+// The directories that are mounted over the image's contents, which aren't copied into new partitions.
+var preflightSkippedDirs = []string{"/dev", "/proc", "/sys", "/run"}
+
+// preflightRequirement is something that a customization needs from the base image.
+type preflightRequirement struct {
+	// The customization that has the requirement (e.g. "verity").
+	customization string
+	// packages that must be installed.
+	packages []string
+	// dracutModules that must be available, so that the customization can add them to the initramfs.
+	dracutModules []string
+}
+
+// baseImageInfo describes the contents of the base image that the config's customizations depend on.
+type baseImageInfo struct {
+	osReleaseId        string
+	osReleaseVersionId string
+	hasGrubCfg         bool
+	hasSELinuxPolicy   bool
+	hasDracut          bool
+	dracutModules      map[string]bool
+	kernelVersions     []string
+	// The installed state of the packages that the config's requirements name.
+	installedPackages map[string]bool
+	// The space used by the image's files, keyed by the mount point of the config's partition that they are copied to.
+	usedBytes map[string]int64
+}
+
+// checkBaseImageCompatibility inspects the base image and checks that the config's customizations can be applied to
+// it, so that incompatibilities are reported before the image is modified instead of by a late-stage failure.
+func checkBaseImageCompatibility(buildDir string, config *imagecustomizerapi.Config, rawImageFile string) error {
+	if config.Hotfix != nil || config.InitrdRebuild != nil || config.OS == nil {
+		return nil
+	}
+
+	logger.Log.Infof("Checking base image compatibility")
+
+	info, err := inspectBaseImage(buildDir, config, rawImageFile)
+	if err != nil {
+		return fmt.Errorf("failed to inspect base image:\n%w", err)
+	}
+
+	warnings, err := checkBaseImageInfo(config, info)
+	for _, warning := range warnings {
+		logger.Log.Warnf("%s", warning)
+	}
+	if err != nil {
+		return fmt.Errorf("config is incompatible with the base image:\n%w", err)
+	}
+
+	return nil
+}
+
+func inspectBaseImage(buildDir string, config *imagecustomizerapi.Config, rawImageFile string) (*baseImageInfo, error) {
+	imageConnection, err := connectToExistingImage(rawImageFile, buildDir, preflightChrootDir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer imageConnection.Close()
+
+	rootDir := imageConnection.Chroot().RootDir()
+
+	info := &baseImageInfo{
+		installedPackages: make(map[string]bool),
+	}
+
+	info.osReleaseId, info.osReleaseVersionId, err = readOsRelease(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	info.hasGrubCfg, err = file.PathExists(filepath.Join(rootDir, installutils.GrubCfgFile))
+	if err != nil {
+		return nil, err
+	}
+
+	info.hasSELinuxPolicy, err = file.PathExists(filepath.Join(rootDir, installutils.SELinuxConfigFile))
+	if err != nil {
+		return nil, err
+	}
+
+	info.hasDracut, err = file.PathExists(filepath.Join(rootDir, dracutBinaryPath))
+	if err != nil {
+		return nil, err
+	}
+
+	info.dracutModules, err = readDracutModules(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	info.kernelVersions, err = getImageKernelVersions(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, requirement := range getPreflightRequirements(config) {
+		for _, packageName := range requirement.packages {
+			if _, found := info.installedPackages[packageName]; !found {
+				info.installedPackages[packageName] = isPackageInstalled(imageConnection.Chroot(), packageName)
+			}
+		}
+	}
+
+	if config.CustomizePartitions() {
+		info.usedBytes, err = getBaseImageUsedBytes(rootDir, getPartitionSizes(&config.Storage))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Log.Debugf("Base image: os=%s-%s, grub.cfg=%t, selinux-policy=%t, dracut=%t, kernels=%v",
+		info.osReleaseId, info.osReleaseVersionId, info.hasGrubCfg, info.hasSELinuxPolicy, info.hasDracut,
+		info.kernelVersions)
+
+	err = imageConnection.CleanClose()
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// checkBaseImageInfo checks the config against the base image's contents.
+//
+// A requirement that the base image doesn't meet is an error if the config doesn't install any packages. Otherwise,
+// it is a warning, since the requirement might be met by the dependencies of the installed packages.
+func checkBaseImageInfo(config *imagecustomizerapi.Config, info *baseImageInfo) ([]string, error) {
+	var warnings []string
+	var errs []error
+
+	if !slices.Contains(supportedOsReleaseIds, info.osReleaseId) {
+		warnings = append(warnings, fmt.Sprintf("base image's OS (%s %s) isn't Azure Linux, so customizations may fail",
+			info.osReleaseId, info.osReleaseVersionId))
+	}
+
+	installsPackages := len(config.OS.Packages.Install) > 0 || len(config.OS.Packages.Update) > 0 ||
+		config.OS.Packages.UpdateExistingPackages
+
+	reportMissing := func(err error) {
+		if installsPackages {
+			warnings = append(warnings, fmt.Sprintf("%s (unless it is installed as a dependency)", err))
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if !info.hasGrubCfg && config.OS.ResetBootLoaderType != imagecustomizerapi.ResetBootLoaderTypeHard {
+		errs = append(errs, fmt.Errorf("base image lacks a grub config file (%s):\n"+
+			"set 'os.resetBootLoaderType' to 'hard-reset' to create one", installutils.GrubCfgFile))
+	}
+
+	switch config.OS.SELinux.Mode {
+	case imagecustomizerapi.SELinuxModeDefault, imagecustomizerapi.SELinuxModeDisabled:
+
+	default:
+		if !info.hasSELinuxPolicy && !installsAnyPackage(config.OS, "selinux-policy") {
+			reportMissing(fmt.Errorf("SELinux mode (%s) requested but base image lacks an SELinux policy "+
+				"(install 'selinux-policy')", config.OS.SELinux.Mode))
+		}
+	}
+
+	for _, requirement := range getPreflightRequirements(config) {
+		for _, packageName := range requirement.packages {
+			if !info.installedPackages[packageName] && !installsAnyPackage(config.OS, packageName) {
+				reportMissing(fmt.Errorf("%s requested but base image lacks package (%s)", requirement.customization,
+					packageName))
+			}
+		}
+
+		if len(requirement.dracutModules) > 0 && !info.hasDracut {
+			if !installsAnyPackage(config.OS, "dracut") {
+				reportMissing(fmt.Errorf("%s requested but base image lacks dracut (%s) to rebuild the initramfs",
+					requirement.customization, dracutBinaryPath))
+			}
+			continue
+		}
+
+		for _, module := range requirement.dracutModules {
+			if !info.dracutModules[module] {
+				reportMissing(fmt.Errorf("%s requested but base image lacks dracut module (%s) in its initrd tooling",
+					requirement.customization, module))
+			}
+		}
+	}
+
+	if config.OS.Gpu != nil && len(info.kernelVersions) != 1 && !installsAnyPackage(config.OS, "kernel") {
+		errs = append(errs, fmt.Errorf("gpu requested but base image has %d kernels (%v), instead of exactly one",
+			len(info.kernelVersions), info.kernelVersions))
+	}
+
+	partitionSizes := getPartitionSizes(&config.Storage)
+	for _, mountPoint := range sortedKeys(partitionSizes) {
+		used := info.usedBytes[mountPoint]
+		size := int64(partitionSizes[mountPoint])
+		if used > size {
+			errs = append(errs, fmt.Errorf("partition for (%s) is too small for the base image's files (%s)",
+				mountPoint, humanReadableDiskSizeRatio(used, size)))
+		}
+	}
+
+	return warnings, errors.Join(errs...)
+}
+
+// getPreflightRequirements returns what the config's customizations need from the base image.
+func getPreflightRequirements(config *imagecustomizerapi.Config) []preflightRequirement {
+	var requirements []preflightRequirement
+
+	if len(config.Storage.Verity) > 0 {
+		requirements = append(requirements, preflightRequirement{
+			customization: "verity",
+			packages:      []string{"lvm2"},
+			dracutModules: []string{"systemd-veritysetup"},
+		})
+	}
+
+	if len(config.Storage.EncryptedVolumes) > 0 {
+		requirements = append(requirements, preflightRequirement{
+			customization: "encrypted volumes",
+			packages:      []string{"cryptsetup", "tpm2-tss"},
+			dracutModules: []string{"crypt", "tpm2-tss"},
+		})
+	}
+
+	return requirements
+}
+
+// installsAnyPackage returns whether or not the config installs a package (or one of its subpackages).
+func installsAnyPackage(config *imagecustomizerapi.OS, packageName string) bool {
+	return slices.ContainsFunc(config.Packages.Install, func(name string) bool {
+		return name == packageName || strings.HasPrefix(name, packageName+"-")
+	})
+}
+
+// getPartitionSizes returns the sizes of the config's partitions that have a fixed size, keyed by mount point.
+func getPartitionSizes(storage *imagecustomizerapi.Storage) map[string]imagecustomizerapi.DiskSize {
+	partitions := make(map[string]imagecustomizerapi.Partition)
+	for _, disk := range storage.Disks {
+		for _, partition := range disk.Partitions {
+			partitions[partition.Id] = partition
+		}
+	}
+
+	sizes := make(map[string]imagecustomizerapi.DiskSize)
+	for _, fileSystem := range storage.FileSystems {
+		if fileSystem.MountPoint == nil {
+			continue
+		}
+
+		partition, found := partitions[fileSystem.PartitionId]
+		if !found || partition.Start == nil {
+			continue
+		}
+
+		end, hasEnd := partition.GetEnd()
+		if !hasEnd {
+			// The partition grows to fill the disk.
+			continue
+		}
+
+		sizes[fileSystem.MountPoint.Path] = end - *partition.Start
+	}
+
+	return sizes
+}
+
+// getBaseImageUsedBytes returns the space that is used by the base image's files, grouped by the mount point that
+// they would be copied to.
+func getBaseImageUsedBytes(rootDir string, partitionSizes map[string]imagecustomizerapi.DiskSize,
+) (map[string]int64, error) {
+	mountPoints := sortedKeys(partitionSizes)
+	usedBytes := make(map[string]int64)
+	if len(mountPoints) <= 0 {
+		return usedBytes, nil
+	}
+
+	type inodeKey struct {
+		dev uint64
+		ino uint64
+	}
+	seenInodes := make(map[inodeKey]bool)
+
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		imagePath := filepath.Join("/", relPath)
+
+		if d.IsDir() && slices.Contains(preflightSkippedDirs, imagePath) {
+			return filepath.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+
+		if stat.Nlink > 1 && !d.IsDir() {
+			key := inodeKey{dev: uint64(stat.Dev), ino: stat.Ino}
+			if seenInodes[key] {
+				return nil
+			}
+			seenInodes[key] = true
+		}
+
+		mountPoint := findMountPoint(mountPoints, imagePath)
+		if mountPoint != "" {
+			usedBytes[mountPoint] += stat.Blocks * 512
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to measure base image's files:\n%w", err)
+	}
+
+	return usedBytes, nil
+}
+
+// findMountPoint returns the most nested mount point that contains a path.
+func findMountPoint(mountPoints []string, path string) string {
+	found := ""
+	for _, mountPoint := range mountPoints {
+		if (mountPoint == "/" || path == mountPoint || strings.HasPrefix(path, mountPoint+"/")) &&
+			len(mountPoint) > len(found) {
+			found = mountPoint
+		}
+	}
+	return found
+}
+
+// readOsRelease returns the ID and VERSION_ID values of the image's os-release file.
+func readOsRelease(rootDir string) (string, string, error) {
+	content, err := os.ReadFile(filepath.Join(rootDir, osReleaseFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read (%s):\n%w", osReleaseFile, err)
+	}
+
+	id, versionId := parseOsRelease(string(content))
+	return id, versionId, nil
+}
+
+func parseOsRelease(content string) (string, string) {
+	id := ""
+	versionId := ""
+	for _, line := range strings.Split(content, "\n") {
+		name, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+
+		value = strings.Trim(value, "\"'")
+		switch name {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			versionId = value
+		}
+	}
+	return id, versionId
+}
+
+// readDracutModules returns the names of the image's dracut modules, without their ordering prefix (e.g.
+// "90crypt" -> "crypt").
+func readDracutModules(rootDir string) (map[string]bool, error) {
+	modules := make(map[string]bool)
+
+	entries, err := os.ReadDir(filepath.Join(rootDir, dracutModulesDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return modules, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dracut modules directory (%s):\n%w", dracutModulesDir, err)
+	}
+
+	for _, entry := range entries {
+		modules[strings.TrimLeft(entry.Name(), "0123456789")] = true
+	}
+
+	return modules, nil
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
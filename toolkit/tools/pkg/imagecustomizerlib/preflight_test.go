@@ -0,0 +1,178 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPreflightTestBaseImageInfo() *baseImageInfo {
+	return &baseImageInfo{
+		osReleaseId:        "azurelinux",
+		osReleaseVersionId: "3.0",
+		hasGrubCfg:         true,
+		hasSELinuxPolicy:   true,
+		hasDracut:          true,
+		dracutModules:      map[string]bool{"crypt": true},
+		kernelVersions:     []string{"6.6.57.1-1.azl3"},
+		installedPackages:  map[string]bool{},
+	}
+}
+
+func TestCheckBaseImageInfoCompatible(t *testing.T) {
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{
+			SELinux: imagecustomizerapi.SELinux{Mode: imagecustomizerapi.SELinuxModeEnforcing},
+		},
+	}
+
+	warnings, err := checkBaseImageInfo(config, newPreflightTestBaseImageInfo())
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestCheckBaseImageInfoVerityLacksTooling(t *testing.T) {
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{},
+		Storage: imagecustomizerapi.Storage{
+			Verity: []imagecustomizerapi.Verity{{Id: "rootverity", Name: "root"}},
+		},
+	}
+
+	info := newPreflightTestBaseImageInfo()
+	info.installedPackages["lvm2"] = true
+
+	_, err := checkBaseImageInfo(config, info)
+	assert.ErrorContains(t, err,
+		"verity requested but base image lacks dracut module (systemd-veritysetup) in its initrd tooling")
+
+	// Installing packages may provide the tooling.
+	config.OS.Packages.Install = []string{"vim"}
+	warnings, err := checkBaseImageInfo(config, info)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"verity requested but base image lacks dracut module (systemd-veritysetup) in its " +
+		"initrd tooling (unless it is installed as a dependency)"}, warnings)
+}
+
+func TestCheckBaseImageInfoEncryptionLacksPackages(t *testing.T) {
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{},
+		Storage: imagecustomizerapi.Storage{
+			EncryptedVolumes: []imagecustomizerapi.EncryptedVolume{{}},
+		},
+	}
+
+	info := newPreflightTestBaseImageInfo()
+	info.installedPackages["cryptsetup"] = true
+	info.dracutModules["tpm2-tss"] = true
+
+	_, err := checkBaseImageInfo(config, info)
+	assert.ErrorContains(t, err, "encrypted volumes requested but base image lacks package (tpm2-tss)")
+
+	config.OS.Packages.Install = []string{"tpm2-tss"}
+	warnings, err := checkBaseImageInfo(config, info)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestCheckBaseImageInfoBootLoaderAndSELinux(t *testing.T) {
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{
+			SELinux: imagecustomizerapi.SELinux{Mode: imagecustomizerapi.SELinuxModePermissive},
+		},
+	}
+
+	info := newPreflightTestBaseImageInfo()
+	info.osReleaseId = "ubuntu"
+	info.hasGrubCfg = false
+	info.hasSELinuxPolicy = false
+
+	warnings, err := checkBaseImageInfo(config, info)
+	assert.ErrorContains(t, err, "base image lacks a grub config file (/boot/grub2/grub.cfg)")
+	assert.ErrorContains(t, err, "SELinux mode (permissive) requested but base image lacks an SELinux policy")
+	assert.Equal(t, []string{"base image's OS (ubuntu 3.0) isn't Azure Linux, so customizations may fail"}, warnings)
+
+	config.OS.ResetBootLoaderType = imagecustomizerapi.ResetBootLoaderTypeHard
+	config.OS.Packages.Install = []string{"selinux-policy"}
+	_, err = checkBaseImageInfo(config, info)
+	assert.NoError(t, err)
+}
+
+func TestCheckBaseImageInfoGpuKernels(t *testing.T) {
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{
+			Gpu: &imagecustomizerapi.Gpu{Vendor: imagecustomizerapi.GpuVendorAmd},
+		},
+	}
+
+	info := newPreflightTestBaseImageInfo()
+	info.kernelVersions = []string{"6.6.57.1-1.azl3", "6.6.64.2-1.azl3"}
+
+	_, err := checkBaseImageInfo(config, info)
+	assert.ErrorContains(t, err, "gpu requested but base image has 2 kernels")
+}
+
+func TestCheckBaseImageInfoPartitionTooSmall(t *testing.T) {
+	start := imagecustomizerapi.DiskSize(imagecustomizerapi.DefaultPartitionAlignment)
+	end := imagecustomizerapi.DiskSize(101 * imagecustomizerapi.DefaultPartitionAlignment)
+	config := &imagecustomizerapi.Config{
+		OS: &imagecustomizerapi.OS{},
+		Storage: imagecustomizerapi.Storage{
+			Disks: []imagecustomizerapi.Disk{{
+				Partitions: []imagecustomizerapi.Partition{
+					{Id: "boot", Start: &start, End: &end},
+					{Id: "rootfs", Start: &end},
+				},
+			}},
+			FileSystems: []imagecustomizerapi.FileSystem{
+				{DeviceId: "boot", PartitionId: "boot", MountPoint: &imagecustomizerapi.MountPoint{Path: "/boot"}},
+				{DeviceId: "rootfs", PartitionId: "rootfs", MountPoint: &imagecustomizerapi.MountPoint{Path: "/"}},
+			},
+		},
+	}
+
+	sizes := getPartitionSizes(&config.Storage)
+	assert.Equal(t, map[string]imagecustomizerapi.DiskSize{"/boot": end - start}, sizes)
+
+	info := newPreflightTestBaseImageInfo()
+	info.usedBytes = map[string]int64{"/boot": int64(end-start) + 1}
+
+	_, err := checkBaseImageInfo(config, info)
+	assert.ErrorContains(t, err, "partition for (/boot) is too small for the base image's files")
+}
+
+func TestGetBaseImageUsedBytes(t *testing.T) {
+	rootDir := t.TempDir()
+	for path, size := range map[string]int{"boot/vmlinuz": 64 * 1024, "usr/bin/tool": 128 * 1024,
+		"proc/ignored": 64 * 1024} {
+		fullPath := filepath.Join(rootDir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0o755))
+		require.NoError(t, os.WriteFile(fullPath, make([]byte, size), 0o644))
+	}
+
+	usedBytes, err := getBaseImageUsedBytes(rootDir, map[string]imagecustomizerapi.DiskSize{"/boot": 1})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, usedBytes["/boot"], int64(64*1024))
+	assert.Less(t, usedBytes["/boot"], int64(128*1024))
+}
+
+func TestParseOsRelease(t *testing.T) {
+	id, versionId := parseOsRelease("NAME=\"Microsoft Azure Linux\"\nVERSION_ID=\"3.0\"\nID=azurelinux\n")
+	assert.Equal(t, "azurelinux", id)
+	assert.Equal(t, "3.0", versionId)
+}
+
+func TestFindMountPoint(t *testing.T) {
+	mountPoints := []string{"/", "/boot", "/boot/efi"}
+	assert.Equal(t, "/boot/efi", findMountPoint(mountPoints, "/boot/efi/EFI/BOOT"))
+	assert.Equal(t, "/boot", findMountPoint(mountPoints, "/boot/vmlinuz"))
+	assert.Equal(t, "/", findMountPoint(mountPoints, "/bootx"))
+	assert.Equal(t, "", findMountPoint([]string{"/boot"}, "/usr"))
+}
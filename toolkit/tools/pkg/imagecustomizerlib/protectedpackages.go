@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+var (
+	// Packages that are never removed as a side effect of removing other packages (i.e. as dependents or as
+	// orphans). They can still be removed by listing them explicitly (e.g. removing the kernel from a container
+	// image).
+	defaultProtectedPackages = []string{
+		"azurelinux-release",
+		"bash",
+		"filesystem",
+		"glibc",
+		"kernel",
+		"rpm",
+		"systemd",
+		"tdnf",
+	}
+)
+
+// checkProtectedPackagesRemoval checks that removing the packages won't also remove any of the protected packages.
+// Removing a package also removes the installed packages that require it, directly or indirectly.
+func checkProtectedPackagesRemoval(packageNames []string, protectedPackages []string,
+	imageChroot *safechroot.Chroot,
+) error {
+	dependents, err := getPackageDependents(packageNames, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	protected := getProtectedPackages(protectedPackages, packageNames)
+
+	removedProtected := []string(nil)
+	for _, dependent := range dependents {
+		if protected[dependent] {
+			removedProtected = append(removedProtected, dependent)
+		}
+	}
+
+	if len(removedProtected) > 0 {
+		return fmt.Errorf("removing packages (%s) would also remove protected packages (%s), which require them",
+			strings.Join(packageNames, ", "), strings.Join(removedProtected, ", "))
+	}
+
+	return nil
+}
+
+// getProtectedPackages returns the set of default and configured protected packages, excluding the packages that are
+// explicitly removed.
+func getProtectedPackages(protectedPackages []string, removedPackages []string) map[string]bool {
+	protected := make(map[string]bool)
+	for _, packageName := range defaultProtectedPackages {
+		protected[packageName] = true
+	}
+	for _, packageName := range protectedPackages {
+		protected[packageName] = true
+	}
+	for _, packageName := range removedPackages {
+		delete(protected, packageName)
+	}
+
+	return protected
+}
+
+// getPackageDependents returns the names of the installed packages that require the packages, directly or
+// indirectly, and so would be removed along with them. The returned list is sorted.
+func getPackageDependents(packageNames []string, imageChroot *safechroot.Chroot) ([]string, error) {
+	installedPackages, err := getInstalledPackageNames(packageNames, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	removal := make(map[string]bool)
+	for _, packageName := range installedPackages {
+		removal[packageName] = true
+	}
+
+	// Repeatedly test the removal, adding the packages that would be broken by it, until the removal is
+	// self-contained.
+	for len(removal) > 0 {
+		args := []string{"-e", "--test", "--allmatches"}
+		args = append(args, slices.Sorted(maps.Keys(removal))...)
+
+		var stdout, stderr string
+		testErr := imageChroot.UnsafeRun(func() error {
+			var err error
+			stdout, stderr, err = shell.Execute("rpm", args...)
+			return err
+		})
+		if testErr == nil {
+			break
+		}
+
+		neededBy := parseRpmNeededByPackages(stdout + "\n" + stderr)
+		if len(neededBy) <= 0 {
+			return nil, fmt.Errorf("failed to check removal of packages (%v):\n%w", packageNames, testErr)
+		}
+
+		neededByNames, err := getInstalledPackageNames(neededBy, imageChroot)
+		if err != nil {
+			return nil, err
+		}
+
+		added := false
+		for _, packageName := range neededByNames {
+			if !removal[packageName] {
+				removal[packageName] = true
+				added = true
+			}
+		}
+
+		if !added {
+			return nil, fmt.Errorf("failed to find the dependents of packages (%v):\n%w", packageNames, testErr)
+		}
+	}
+
+	for _, packageName := range installedPackages {
+		delete(removal, packageName)
+	}
+
+	return slices.Sorted(maps.Keys(removal)), nil
+}
+
+// parseRpmNeededByPackages parses the installed packages (as name-version-release.arch) that an rpm erase
+// transaction would break.
+func parseRpmNeededByPackages(output string) []string {
+	seen := make(map[string]bool)
+	packages := []string(nil)
+	for _, line := range strings.Split(output, "\n") {
+		match := rpmNeededByRegex.FindStringSubmatch(line)
+		if match == nil || seen[match[2]] {
+			continue
+		}
+
+		seen[match[2]] = true
+		packages = append(packages, match[2])
+	}
+
+	return packages
+}
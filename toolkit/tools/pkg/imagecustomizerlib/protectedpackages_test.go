@@ -0,0 +1,34 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRpmNeededByPackages(t *testing.T) {
+	output := "" +
+		"error: Failed dependencies:\n" +
+		"\tlibfoo.so.1()(64bit) is needed by (installed) bar-1.0-1.azl3.x86_64\n" +
+		"\tlibfoo.so.1()(64bit) is needed by (installed) baz-2.0-1.azl3.x86_64\n" +
+		"\tfoo >= 1.2 is needed by (installed) bar-1.0-1.azl3.x86_64\n"
+
+	packages := parseRpmNeededByPackages(output)
+	assert.Equal(t, []string{"bar-1.0-1.azl3.x86_64", "baz-2.0-1.azl3.x86_64"}, packages)
+
+	assert.Empty(t, parseRpmNeededByPackages(""))
+}
+
+func TestGetProtectedPackages(t *testing.T) {
+	protected := getProtectedPackages([]string{"openssh-server"}, []string{"kernel", "vim"})
+
+	assert.True(t, protected["systemd"])
+	assert.True(t, protected["openssh-server"])
+
+	// Explicitly removed packages aren't protected.
+	assert.False(t, protected["kernel"])
+	assert.False(t, protected["vim"])
+}
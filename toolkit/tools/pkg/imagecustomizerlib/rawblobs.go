@@ -0,0 +1,186 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/configuration"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	// The protective MBR's disk signature and partition table. The boot code before it may be overwritten.
+	mbrPartitionTableStart = 440
+	mbrPartitionTableEnd   = 512
+
+	// The largest block size that raw blobs are written with.
+	rawBlobMaxBlockSize = diskutils.MiB
+)
+
+// diskRange is a byte range, [start, end), of the disk.
+type diskRange struct {
+	name  string
+	start uint64
+	end   uint64
+}
+
+func (r diskRange) overlaps(other diskRange) bool {
+	return r.start < other.end && other.start < r.end
+}
+
+func (r diskRange) String() string {
+	return fmt.Sprintf("%s range [%d, %d)", r.name, r.start, r.end)
+}
+
+// rawBlobWrite is a raw blob and the disk range that it is written to.
+type rawBlobWrite struct {
+	sourcePath string
+	diskRange  diskRange
+}
+
+// getRawBlobWrites returns where each raw blob is written to on the disk. Returns an error if a blob doesn't fit in
+// its partition or if a blob overlaps the partition table (including the GPT header and footer on GPT disks), a
+// partition, or another blob.
+func getRawBlobWrites(baseConfigPath string, storage *imagecustomizerapi.Storage) ([]rawBlobWrite, error) {
+	if len(storage.RawBlobs) <= 0 {
+		return nil, nil
+	}
+
+	disk := storage.Disks[0]
+	diskSize := uint64(*disk.MaxSize)
+
+	// The MBR's partition table is always reserved, since GPT disks have a protective MBR.
+	reservedRanges := []diskRange{
+		{name: "partition table", start: mbrPartitionTableStart, end: mbrPartitionTableEnd},
+	}
+
+	// On GPT disks, the partitions end before the backup GPT at the end of the disk.
+	partitionsEnd := diskSize
+	if disk.PartitionTableType == imagecustomizerapi.PartitionTableTypeGpt {
+		partitionsEnd = diskSize - imagecustomizerapi.GptFooterSectorNum*imagecustomizerapi.DefaultSectorSize
+
+		reservedRanges = append(reservedRanges,
+			diskRange{
+				name:  "GPT header",
+				start: imagecustomizerapi.DefaultSectorSize,
+				end:   imagecustomizerapi.GptHeaderSectorNum * imagecustomizerapi.DefaultSectorSize,
+			},
+			diskRange{name: "GPT footer", start: partitionsEnd, end: diskSize},
+		)
+	}
+
+	partitionRanges := make(map[string]diskRange)
+	for _, partition := range disk.Partitions {
+		end, hasEnd := partition.GetEnd()
+		partitionEnd := uint64(end)
+		if !hasEnd {
+			partitionEnd = partitionsEnd
+		}
+
+		partitionRange := diskRange{
+			name:  fmt.Sprintf("partition (%s)", partition.Id),
+			start: uint64(*partition.Start),
+			end:   partitionEnd,
+		}
+
+		partitionRanges[partition.Id] = partitionRange
+		reservedRanges = append(reservedRanges, partitionRange)
+	}
+
+	writes := []rawBlobWrite(nil)
+	for _, blob := range storage.RawBlobs {
+		sourcePath := file.GetAbsPathWithBase(baseConfigPath, blob.Source)
+
+		stat, err := os.Stat(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat raw blob (%s):\n%w", blob.Source, err)
+		}
+
+		if !stat.Mode().IsRegular() {
+			return nil, fmt.Errorf("raw blob (%s) is not a file", blob.Source)
+		}
+
+		blobRange := diskRange{
+			name:  fmt.Sprintf("raw blob (%s)", blob.Source),
+			start: blob.Offset,
+			end:   blob.Offset + uint64(stat.Size()),
+		}
+
+		if blob.PartitionId != "" {
+			partitionRange := partitionRanges[blob.PartitionId]
+			blobRange.start += partitionRange.start
+			blobRange.end += partitionRange.start
+
+			if blobRange.end > partitionRange.end {
+				return nil, fmt.Errorf("%s doesn't fit in %s", blobRange, partitionRange)
+			}
+		} else {
+			if blobRange.end > diskSize {
+				return nil, fmt.Errorf("%s doesn't fit in the disk (%d bytes)", blobRange, diskSize)
+			}
+
+			for _, reservedRange := range reservedRanges {
+				if blobRange.overlaps(reservedRange) {
+					return nil, fmt.Errorf("%s overlaps %s", blobRange, reservedRange)
+				}
+			}
+		}
+
+		for _, write := range writes {
+			if blobRange.overlaps(write.diskRange) {
+				return nil, fmt.Errorf("%s overlaps %s", blobRange, write.diskRange)
+			}
+		}
+
+		writes = append(writes, rawBlobWrite{
+			sourcePath: sourcePath,
+			diskRange:  blobRange,
+		})
+	}
+
+	sort.Slice(writes, func(i, j int) bool {
+		return writes[i].diskRange.start < writes[j].diskRange.start
+	})
+
+	return writes, nil
+}
+
+// writeRawBlobs writes the raw blobs into the disk image.
+func writeRawBlobs(baseConfigPath string, storage *imagecustomizerapi.Storage, buildImageFile string) error {
+	writes, err := getRawBlobWrites(baseConfigPath, storage)
+	if err != nil {
+		return err
+	}
+
+	for _, write := range writes {
+		logger.Log.Infof("Writing %s", write.diskRange)
+
+		blockSize := getRawBlobBlockSize(write.diskRange.start)
+		err = diskutils.ApplyRawBinary(buildImageFile, configuration.RawBinary{
+			BinPath:   write.sourcePath,
+			BlockSize: blockSize,
+			Seek:      write.diskRange.start / blockSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write %s:\n%w", write.diskRange.name, err)
+		}
+	}
+
+	return nil
+}
+
+// getRawBlobBlockSize returns the largest power of 2 block size (up to 1 MiB) that the offset is a multiple of.
+func getRawBlobBlockSize(offset uint64) uint64 {
+	blockSize := uint64(rawBlobMaxBlockSize)
+	for blockSize > 1 && offset%blockSize != 0 {
+		blockSize /= 2
+	}
+	return blockSize
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/diskutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ptrutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getRawBlobTestStorage(rawBlobs []imagecustomizerapi.RawBlob) *imagecustomizerapi.Storage {
+	return &imagecustomizerapi.Storage{
+		Disks: []imagecustomizerapi.Disk{{
+			PartitionTableType: imagecustomizerapi.PartitionTableTypeGpt,
+			MaxSize:            ptrutils.PtrTo(imagecustomizerapi.DiskSize(4 * diskutils.MiB)),
+			Partitions: []imagecustomizerapi.Partition{
+				{
+					Id:    "firmware",
+					Start: ptrutils.PtrTo(imagecustomizerapi.DiskSize(1 * diskutils.MiB)),
+					End:   ptrutils.PtrTo(imagecustomizerapi.DiskSize(2 * diskutils.MiB)),
+				},
+				{
+					Id:    "rootfs",
+					Start: ptrutils.PtrTo(imagecustomizerapi.DiskSize(2 * diskutils.MiB)),
+				},
+			},
+		}},
+		RawBlobs: rawBlobs,
+	}
+}
+
+func writeRawBlobTestFile(t *testing.T, dir string, name string, size int, value byte) {
+	err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte{value}, size), 0o644)
+	require.NoError(t, err)
+}
+
+func TestGetRawBlobWrites(t *testing.T) {
+	baseConfigPath := t.TempDir()
+	writeRawBlobTestFile(t, baseConfigPath, "spl.bin", 4096, 1)
+	writeRawBlobTestFile(t, baseConfigPath, "firmware.bin", 4096, 2)
+
+	storage := getRawBlobTestStorage([]imagecustomizerapi.RawBlob{
+		{Source: "firmware.bin", PartitionId: "firmware", Offset: 512},
+		{Source: "spl.bin", Offset: 32 * diskutils.KiB},
+	})
+
+	writes, err := getRawBlobWrites(baseConfigPath, storage)
+	assert.NoError(t, err)
+	assert.Equal(t, []rawBlobWrite{
+		{
+			sourcePath: filepath.Join(baseConfigPath, "spl.bin"),
+			diskRange:  diskRange{name: "raw blob (spl.bin)", start: 32 * diskutils.KiB, end: 36 * diskutils.KiB},
+		},
+		{
+			sourcePath: filepath.Join(baseConfigPath, "firmware.bin"),
+			diskRange: diskRange{
+				name:  "raw blob (firmware.bin)",
+				start: diskutils.MiB + 512,
+				end:   diskutils.MiB + 512 + 4096,
+			},
+		},
+	}, writes)
+}
+
+func TestGetRawBlobWritesOverlaps(t *testing.T) {
+	baseConfigPath := t.TempDir()
+	writeRawBlobTestFile(t, baseConfigPath, "blob.bin", 4096, 1)
+	writeRawBlobTestFile(t, baseConfigPath, "large.bin", 2*diskutils.MiB, 1)
+
+	tests := []struct {
+		name          string
+		rawBlobs      []imagecustomizerapi.RawBlob
+		expectedError string
+	}{
+		{
+			name:          "partition table",
+			rawBlobs:      []imagecustomizerapi.RawBlob{{Source: "blob.bin", Offset: 0}},
+			expectedError: "raw blob (blob.bin) range [0, 4096) overlaps partition table range [440, 512)",
+		},
+		{
+			name:          "GPT header",
+			rawBlobs:      []imagecustomizerapi.RawBlob{{Source: "blob.bin", Offset: 8192}},
+			expectedError: "overlaps GPT header range [512, 17408)",
+		},
+		{
+			name:          "partition",
+			rawBlobs:      []imagecustomizerapi.RawBlob{{Source: "blob.bin", Offset: diskutils.MiB - 1024}},
+			expectedError: "overlaps partition (firmware) range [1048576, 2097152)",
+		},
+		{
+			name:          "GPT footer",
+			rawBlobs:      []imagecustomizerapi.RawBlob{{Source: "blob.bin", Offset: 4*diskutils.MiB - 8192}},
+			expectedError: "overlaps GPT footer range [4177408, 4194304)",
+		},
+		{
+			name:          "outside disk",
+			rawBlobs:      []imagecustomizerapi.RawBlob{{Source: "blob.bin", Offset: 4 * diskutils.MiB}},
+			expectedError: "doesn't fit in the disk (4194304 bytes)",
+		},
+		{
+			name: "partition too small",
+			rawBlobs: []imagecustomizerapi.RawBlob{
+				{Source: "large.bin", PartitionId: "firmware"},
+			},
+			expectedError: "raw blob (large.bin) range [1048576, 3145728) doesn't fit in partition (firmware)",
+		},
+		{
+			name: "other blob",
+			rawBlobs: []imagecustomizerapi.RawBlob{
+				{Source: "blob.bin", PartitionId: "firmware"},
+				{Source: "blob.bin", PartitionId: "firmware", Offset: 2048},
+			},
+			expectedError: "raw blob (blob.bin) range [1050624, 1054720) overlaps raw blob (blob.bin) range " +
+				"[1048576, 1052672)",
+		},
+		{
+			name:          "missing file",
+			rawBlobs:      []imagecustomizerapi.RawBlob{{Source: "missing.bin", Offset: 32 * diskutils.KiB}},
+			expectedError: "failed to stat raw blob (missing.bin)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := getRawBlobWrites(baseConfigPath, getRawBlobTestStorage(test.rawBlobs))
+			assert.ErrorContains(t, err, test.expectedError)
+		})
+	}
+}
+
+func TestGetRawBlobWritesNonGpt(t *testing.T) {
+	baseConfigPath := t.TempDir()
+	writeRawBlobTestFile(t, baseConfigPath, "spl.bin", 4096, 1)
+	writeRawBlobTestFile(t, baseConfigPath, "env.bin", 4096, 2)
+
+	storage := getRawBlobTestStorage([]imagecustomizerapi.RawBlob{
+		{Source: "spl.bin", Offset: 8192},
+		{Source: "env.bin", PartitionId: "rootfs", Offset: 2*diskutils.MiB - 4096},
+	})
+	storage.Disks[0].PartitionTableType = imagecustomizerapi.PartitionTableType("mbr")
+
+	// Without a GPT, neither the GPT header nor the GPT footer are reserved.
+	writes, err := getRawBlobWrites(baseConfigPath, storage)
+	assert.NoError(t, err)
+	assert.Equal(t, []diskRange{
+		{name: "raw blob (spl.bin)", start: 8192, end: 12288},
+		{name: "raw blob (env.bin)", start: 4*diskutils.MiB - 4096, end: 4 * diskutils.MiB},
+	}, []diskRange{writes[0].diskRange, writes[1].diskRange})
+
+	storage.RawBlobs = []imagecustomizerapi.RawBlob{{Source: "spl.bin", Offset: 0}}
+	_, err = getRawBlobWrites(baseConfigPath, storage)
+	assert.ErrorContains(t, err, "overlaps partition table range [440, 512)")
+}
+
+func TestWriteRawBlobs(t *testing.T) {
+	baseConfigPath := t.TempDir()
+	writeRawBlobTestFile(t, baseConfigPath, "spl.bin", 1000, 1)
+	writeRawBlobTestFile(t, baseConfigPath, "firmware.bin", 4096, 2)
+
+	imageFile := filepath.Join(t.TempDir(), "image.raw")
+	err := os.WriteFile(imageFile, make([]byte, 4*diskutils.MiB), 0o644)
+	require.NoError(t, err)
+
+	storage := getRawBlobTestStorage([]imagecustomizerapi.RawBlob{
+		{Source: "spl.bin", Offset: 20000},
+		{Source: "firmware.bin", PartitionId: "firmware", Offset: 512},
+	})
+
+	err = writeRawBlobs(baseConfigPath, storage, imageFile)
+	require.NoError(t, err)
+
+	image, err := os.ReadFile(imageFile)
+	require.NoError(t, err)
+	assert.Len(t, image, 4*diskutils.MiB)
+
+	assert.Equal(t, bytes.Repeat([]byte{0}, 20000), image[:20000])
+	assert.Equal(t, bytes.Repeat([]byte{1}, 1000), image[20000:21000])
+	assert.Equal(t, byte(0), image[21000])
+	assert.Equal(t, byte(0), image[diskutils.MiB+511])
+	assert.Equal(t, bytes.Repeat([]byte{2}, 4096), image[diskutils.MiB+512:diskutils.MiB+512+4096])
+	assert.Equal(t, byte(0), image[diskutils.MiB+512+4096])
+}
+
+func TestGetRawBlobBlockSize(t *testing.T) {
+	assert.Equal(t, uint64(diskutils.MiB), getRawBlobBlockSize(0))
+	assert.Equal(t, uint64(diskutils.MiB), getRawBlobBlockSize(3*diskutils.MiB))
+	assert.Equal(t, uint64(512), getRawBlobBlockSize(diskutils.MiB+512))
+	assert.Equal(t, uint64(32), getRawBlobBlockSize(20000))
+	assert.Equal(t, uint64(1), getRawBlobBlockSize(20001))
+}
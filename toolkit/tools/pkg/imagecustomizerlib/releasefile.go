@@ -24,7 +24,7 @@ func addCustomizerRelease(imageChroot *safechroot.Chroot, toolVersion string, bu
 		fmt.Sprintf("%s=\"%s\"", "IMAGE_UUID", imageUuid),
 		"",
 	}
-	err = file.WriteLines(lines, customizerReleaseFilePath)
+	err = file.WriteLinesAtomic(lines, customizerReleaseFilePath)
 	if err != nil {
 		return fmt.Errorf("error writing customizer release file (%s): %w", customizerReleaseFilePath, err)
 	}
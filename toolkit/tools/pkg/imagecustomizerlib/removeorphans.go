@@ -0,0 +1,293 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+var (
+	// For example:
+	//   libfoo.so.1()(64bit) is needed by (installed) bar-1.0-1.azl3.x86_64
+	rpmNeededByRegex = regexp.MustCompile(`^\s*(.+?) is needed by \(installed\) (\S+)\s*$`)
+)
+
+// getPackageDependencies returns the installed packages that the packages require, directly or indirectly.
+//
+// This must be called before the packages are removed, since the requirements of a package can't be queried once it
+// is no longer installed.
+func getPackageDependencies(packageNames []string, imageChroot *safechroot.Chroot) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	for _, packageName := range packageNames {
+		seen[packageName] = true
+	}
+
+	dependencies := make(map[string]bool)
+	frontier := packageNames
+	for len(frontier) > 0 {
+		args := []string{"-q", "--queryformat", "[%{REQUIRENAME}\n]"}
+		args = append(args, frontier...)
+
+		var stdout string
+		err := imageChroot.UnsafeRun(func() error {
+			var err error
+			stdout, _, err = shell.Execute("rpm", args...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query requirements of packages (%v):\n%w", frontier, err)
+		}
+
+		capabilities := parseRpmRequirements(stdout)
+		if len(capabilities) <= 0 {
+			break
+		}
+
+		providers, err := getCapabilityProviders(capabilities, imageChroot)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = nil
+		for _, provider := range providers {
+			if seen[provider] {
+				continue
+			}
+
+			seen[provider] = true
+			dependencies[provider] = true
+			frontier = append(frontier, provider)
+		}
+	}
+
+	return dependencies, nil
+}
+
+// getUserInstalledPackages returns the names of the installed packages that tdnf records as installed explicitly,
+// instead of as a dependency of another package. This includes the packages the base image was built with, which must
+// not be removed as orphans even if they were only required by removed packages.
+func getUserInstalledPackages(imageChroot *safechroot.Chroot) ([]string, error) {
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		var err error
+		stdout, _, err = shell.Execute("tdnf", "repoquery", "--userinstalled", "--quiet", "--disablerepo", "*")
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the user installed packages:\n%w", err)
+	}
+
+	// tdnf prints the full NEVRA of the packages, so convert them to names.
+	userInstalledPackages, err := getInstalledPackageNames(parseRpmPackageNames(stdout), imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Debugf("User installed packages: %v", userInstalledPackages)
+
+	return userInstalledPackages, nil
+}
+
+// removeOrphanedPackages removes the candidate packages that are still installed and that are no longer required by
+// any other installed package. Packages in the keep list and protected packages are never removed.
+//
+// Returns the names of the removed packages.
+func removeOrphanedPackages(candidates map[string]bool, keepPackages []string, imageChroot *safechroot.Chroot,
+) ([]string, error) {
+	installedCandidates, err := getInstalledPackageNames(slices.Sorted(maps.Keys(candidates)), imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := filterOrphanCandidates(installedCandidates, keepPackages)
+
+	// Check if the candidates can be removed together. Any candidate that provides a capability that a remaining
+	// package needs is kept, which may in turn cause other candidates to be needed. So, repeat until the check
+	// passes.
+	for len(orphans) > 0 {
+		args := []string{"-e", "--test"}
+		args = append(args, orphans...)
+
+		var stdout, stderr string
+		testErr := imageChroot.UnsafeRun(func() error {
+			var err error
+			stdout, stderr, err = shell.Execute("rpm", args...)
+			return err
+		})
+		if testErr == nil {
+			break
+		}
+
+		neededCapabilities := parseRpmNeededBy(stdout + "\n" + stderr)
+		if len(neededCapabilities) <= 0 {
+			return nil, fmt.Errorf("failed to check orphaned packages (%v):\n%w", orphans, testErr)
+		}
+
+		neededPackages, err := getCapabilityProviders(neededCapabilities, imageChroot)
+		if err != nil {
+			return nil, err
+		}
+
+		remainingOrphans := filterOrphanCandidates(orphans, neededPackages)
+		if len(remainingOrphans) == len(orphans) {
+			return nil, fmt.Errorf("failed to find the orphaned packages that are still needed (%v):\n%w", orphans,
+				testErr)
+		}
+
+		orphans = remainingOrphans
+	}
+
+	if len(orphans) <= 0 {
+		logger.Log.Infof("No orphaned packages to remove")
+		return []string{}, nil
+	}
+
+	logger.Log.Infof("Removing orphaned packages: %v", orphans)
+
+	tdnfRemoveArgs := []string{"-v", "remove", "--assumeyes", "--disablerepo", "*"}
+	tdnfRemoveArgs = append(tdnfRemoveArgs, orphans...)
+
+	err = callTdnf(tdnfRemoveArgs, tdnfRemovePrefix, imageChroot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove orphaned packages:\n%w", err)
+	}
+
+	return orphans, nil
+}
+
+// getCapabilityProviders returns the names of the installed packages that provide the capabilities.
+func getCapabilityProviders(capabilities []string, imageChroot *safechroot.Chroot) ([]string, error) {
+	args := []string{"-q", "--whatprovides", "--queryformat", "%{NAME}\n"}
+	args = append(args, capabilities...)
+
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		// rpm returns an error if any of the capabilities aren't provided by an installed package (e.g. a file
+		// dependency satisfied by a package that has since been removed). Those are simply skipped.
+		stdout, _, _ = shell.Execute("rpm", args...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query providers of capabilities:\n%w", err)
+	}
+
+	return parseRpmPackageNames(stdout), nil
+}
+
+// getInstalledPackageNames returns the subset of the packages that are installed.
+func getInstalledPackageNames(packageNames []string, imageChroot *safechroot.Chroot) ([]string, error) {
+	if len(packageNames) <= 0 {
+		return nil, nil
+	}
+
+	args := []string{"-q", "--queryformat", "%{NAME}\n"}
+	args = append(args, packageNames...)
+
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		// rpm returns an error if any of the packages aren't installed. Those are simply skipped.
+		stdout, _, _ = shell.Execute("rpm", args...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query installed packages:\n%w", err)
+	}
+
+	return parseRpmPackageNames(stdout), nil
+}
+
+// filterOrphanCandidates removes the packages in the keep list and the protected packages from the candidates list.
+// The returned list is sorted.
+func filterOrphanCandidates(candidates []string, keepPackages []string) []string {
+	keep := make(map[string]bool)
+	for _, packageName := range keepPackages {
+		keep[packageName] = true
+	}
+	for _, packageName := range defaultProtectedPackages {
+		keep[packageName] = true
+	}
+
+	orphans := []string{}
+	for _, candidate := range candidates {
+		if !keep[candidate] {
+			orphans = append(orphans, candidate)
+		}
+	}
+
+	sort.Strings(orphans)
+	return orphans
+}
+
+// parseRpmRequirements parses the list of required capabilities printed by rpm, skipping the capabilities that are
+// provided by rpm itself instead of by packages.
+func parseRpmRequirements(output string) []string {
+	seen := make(map[string]bool)
+	capabilities := []string(nil)
+	for _, line := range strings.Split(output, "\n") {
+		capability := strings.TrimSpace(line)
+		if capability == "" || strings.HasPrefix(capability, "rpmlib(") || seen[capability] {
+			continue
+		}
+
+		seen[capability] = true
+		capabilities = append(capabilities, capability)
+	}
+
+	return capabilities
+}
+
+// parseRpmPackageNames parses a list of package names printed by rpm, skipping rpm's messages (e.g. "package foo is
+// not installed").
+func parseRpmPackageNames(output string) []string {
+	seen := make(map[string]bool)
+	packageNames := []string(nil)
+	for _, line := range strings.Split(output, "\n") {
+		packageName := strings.TrimSpace(line)
+		if packageName == "" || strings.ContainsAny(packageName, " \t") || seen[packageName] {
+			continue
+		}
+
+		seen[packageName] = true
+		packageNames = append(packageNames, packageName)
+	}
+
+	return packageNames
+}
+
+// parseRpmNeededBy parses the capabilities that an rpm erase transaction would break.
+func parseRpmNeededBy(output string) []string {
+	seen := make(map[string]bool)
+	capabilities := []string(nil)
+	for _, line := range strings.Split(output, "\n") {
+		match := rpmNeededByRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		// Drop the version constraint (e.g. "foo >= 1.2"), since 'rpm --whatprovides' only accepts the name.
+		// Rich dependencies (e.g. "(foo or bar)") are kept whole.
+		capability := match[1]
+		if !strings.HasPrefix(capability, "(") {
+			capability, _, _ = strings.Cut(capability, " ")
+		}
+
+		if seen[capability] {
+			continue
+		}
+
+		seen[capability] = true
+		capabilities = append(capabilities, capability)
+	}
+
+	return capabilities
+}
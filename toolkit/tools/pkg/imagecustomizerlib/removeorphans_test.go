@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRpmRequirements(t *testing.T) {
+	output := "/bin/sh\nlibc.so.6()(64bit)\nrpmlib(CompressedFileNames)\n/bin/sh\n\n"
+
+	capabilities := parseRpmRequirements(output)
+	assert.Equal(t, []string{"/bin/sh", "libc.so.6()(64bit)"}, capabilities)
+}
+
+func TestParseRpmPackageNames(t *testing.T) {
+	output := "libfoo\npackage bar is not installed\nno package provides /usr/bin/baz\nlibfoo\nlibqux\n"
+
+	packageNames := parseRpmPackageNames(output)
+	assert.Equal(t, []string{"libfoo", "libqux"}, packageNames)
+}
+
+func TestParseRpmNeededBy(t *testing.T) {
+	output := "error: Failed dependencies:\n" +
+		"\tlibfoo.so.1()(64bit) is needed by (installed) bar-1.0-1.azl3.x86_64\n" +
+		"\tlibfoo.so.1()(64bit) is needed by (installed) baz-2.0-1.azl3.x86_64\n" +
+		"\tqux >= 1.2 is needed by (installed) bar-1.0-1.azl3.x86_64\n" +
+		"\t(a or b) is needed by (installed) bar-1.0-1.azl3.x86_64\n"
+
+	capabilities := parseRpmNeededBy(output)
+	assert.Equal(t, []string{"libfoo.so.1()(64bit)", "qux", "(a or b)"}, capabilities)
+}
+
+func TestFilterOrphanCandidates(t *testing.T) {
+	candidates := []string{"libfoo", "systemd", "libbar", "kernel", "jq"}
+
+	orphans := filterOrphanCandidates(candidates, []string{"jq"})
+	assert.Equal(t, []string{"libbar", "libfoo"}, orphans)
+}
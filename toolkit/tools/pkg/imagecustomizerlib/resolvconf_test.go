@@ -10,6 +10,7 @@ import (
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/imageconvert"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,7 +63,7 @@ func TestCustomizeImageResolvConfRestoreFile(t *testing.T) {
 		return
 	}
 
-	err = convertImageFile(baseImage, outImageFilePath, "raw")
+	err = imageconvert.Convert(baseImage, outImageFilePath, ImageFormatRaw)
 	if !assert.NoError(t, err) {
 		return
 	}
@@ -133,7 +134,7 @@ func TestCustomizeImageResolvConfRestoreSymlink(t *testing.T) {
 		return
 	}
 
-	err = convertImageFile(baseImage, outImageFilePath, "raw")
+	err = imageconvert.Convert(baseImage, outImageFilePath, ImageFormatRaw)
 	if !assert.NoError(t, err) {
 		return
 	}
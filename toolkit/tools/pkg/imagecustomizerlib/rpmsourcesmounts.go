@@ -6,11 +6,13 @@ package imagecustomizerlib
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repomanager/rpmrepomanager"
@@ -23,6 +25,10 @@ import (
 
 const (
 	rpmsMountParentDirInChroot = "/_localrpms"
+
+	// The build directory's sub-directory that the local repos are created in.
+	localReposBuildDirName  = "localrepos"
+	localRepoGpgKeysDirName = "_gpgkeys"
 )
 
 // Used to manage (including cleanup) the mounts required by package installation/update.
@@ -31,15 +37,17 @@ type rpmSourcesMounts struct {
 	rpmsMountParentDirCreated bool
 	mounts                    []*safemount.Mount
 	allReposConfigFilePath    string
+	localReposDir             string
 }
 
-func mountRpmSources(buildDir string, imageChroot *safechroot.Chroot, rpmsSources []string,
-	useBaseImageRpmRepos bool,
+func mountRpmSources(buildDir string, baseConfigPath string, imageChroot *safechroot.Chroot, rpmsSources []string,
+	localRepos []imagecustomizerapi.LocalRepo, useBaseImageRpmRepos bool,
 ) (*rpmSourcesMounts, error) {
 	var err error
 
 	var mounts rpmSourcesMounts
-	err = mounts.mountRpmSourcesHelper(buildDir, imageChroot, rpmsSources, useBaseImageRpmRepos)
+	err = mounts.mountRpmSourcesHelper(buildDir, baseConfigPath, imageChroot, rpmsSources, localRepos,
+		useBaseImageRpmRepos)
 	if err != nil {
 		cleanupErr := mounts.close()
 		if cleanupErr != nil {
@@ -51,7 +59,8 @@ func mountRpmSources(buildDir string, imageChroot *safechroot.Chroot, rpmsSource
 	return &mounts, nil
 }
 
-func (m *rpmSourcesMounts) mountRpmSourcesHelper(buildDir string, imageChroot *safechroot.Chroot, rpmsSources []string,
+func (m *rpmSourcesMounts) mountRpmSourcesHelper(buildDir string, baseConfigPath string,
+	imageChroot *safechroot.Chroot, rpmsSources []string, localRepos []imagecustomizerapi.LocalRepo,
 	useBaseImageRpmRepos bool,
 ) error {
 	var err error
@@ -73,7 +82,7 @@ func (m *rpmSourcesMounts) mountRpmSourcesHelper(buildDir string, imageChroot *s
 
 	// Include base image's RPM sources.
 	if useBaseImageRpmRepos {
-		reposPath := filepath.Join(imageChroot.RootDir(), "/etc/yum.repos.d")
+		reposPath := filepath.Join(imageChroot.RootDir(), reposDir)
 		entries, err := os.ReadDir(reposPath)
 		if err != nil {
 			return fmt.Errorf("failed to read base image's repos directory:\n%w", err)
@@ -119,6 +128,14 @@ func (m *rpmSourcesMounts) mountRpmSourcesHelper(buildDir string, imageChroot *s
 		}
 	}
 
+	// Create repos from the config's local RPM directories.
+	for i, localRepo := range localRepos {
+		err = m.createRepoFromLocalRepo(buildDir, baseConfigPath, i, localRepo, allReposConfig, imageChroot)
+		if err != nil {
+			return fmt.Errorf("failed to create local repo (%s):\n%w", localRepo.Path, err)
+		}
+	}
+
 	// Create all-repos config file.
 	m.allReposConfigFilePath = filepath.Join(imageChroot.RootDir(), rpmsMountParentDirInChroot, "allrepos.repo")
 	logger.Log.Debugf("Writing allrepos.repo (%s)", m.allReposConfigFilePath)
@@ -156,14 +173,139 @@ func (m *rpmSourcesMounts) createRepoFromDirectory(rpmSource string, allReposCon
 	}
 
 	// Add local repo config.
-	err = appendLocalRepo(allReposConfig, mountTargetDirectoryInChroot)
+	_, err = appendLocalRepo(allReposConfig, mountTargetDirectoryInChroot)
+	if err != nil {
+		return fmt.Errorf("failed to append local repo config:\n%w", err)
+	}
+
+	return nil
+}
+
+// createRepoFromLocalRepo creates an RPM repo in the build directory from a config's local RPM directory.
+// The RPMs are linked (or copied) into the build directory, so that the source directory isn't modified.
+func (m *rpmSourcesMounts) createRepoFromLocalRepo(buildDir string, baseConfigPath string, index int,
+	localRepo imagecustomizerapi.LocalRepo, allReposConfig *ini.File, imageChroot *safechroot.Chroot,
+) error {
+	sourceDir := file.GetAbsPathWithBase(baseConfigPath, localRepo.Path)
+
+	m.localReposDir = filepath.Join(buildDir, localReposBuildDirName)
+	repoDir := filepath.Join(m.localReposDir, fmt.Sprintf("%02d%s", index, filepath.Base(sourceDir)))
+
+	err := os.RemoveAll(repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to clean local repo directory (%s):\n%w", repoDir, err)
+	}
+
+	rpmsCount, err := linkRpmFiles(sourceDir, repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to collect RPMs from (%s):\n%w", sourceDir, err)
+	}
+
+	logger.Log.Debugf("Creating local repo with %d RPMs from (%s)", rpmsCount, sourceDir)
+
+	// Copy the GPG keys into the repo, so that they are accessible from within the chroot.
+	gpgKeyFileNames := []string(nil)
+	for i, gpgKey := range localRepo.GpgKeys {
+		gpgKeyFileName := fmt.Sprintf("%02d%s", i, filepath.Base(gpgKey))
+
+		err = file.Copy(file.GetAbsPathWithBase(baseConfigPath, gpgKey),
+			filepath.Join(repoDir, localRepoGpgKeysDirName, gpgKeyFileName))
+		if err != nil {
+			return fmt.Errorf("failed to copy GPG key (%s):\n%w", gpgKey, err)
+		}
+
+		gpgKeyFileNames = append(gpgKeyFileNames, gpgKeyFileName)
+	}
+
+	err = rpmrepomanager.CreateRepo(repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to create RPMs repo in (%s):\n%w", repoDir, err)
+	}
+
+	mountTargetDirectoryInChroot, err := m.mountRpmsDirectory(filepath.Base(sourceDir), repoDir, imageChroot)
+	if err != nil {
+		return err
+	}
+
+	repoSection, err := appendLocalRepo(allReposConfig, mountTargetDirectoryInChroot)
 	if err != nil {
 		return fmt.Errorf("failed to append local repo config:\n%w", err)
 	}
 
+	if localRepo.GpgCheck {
+		repoSection.Key("gpgcheck").SetValue("1")
+
+		gpgKeyUrls := []string(nil)
+		for _, gpgKeyFileName := range gpgKeyFileNames {
+			gpgKeyUrls = append(gpgKeyUrls, fmt.Sprintf("file://%s",
+				path.Join(mountTargetDirectoryInChroot, localRepoGpgKeysDirName, gpgKeyFileName)))
+		}
+
+		if len(gpgKeyUrls) > 0 {
+			repoSection.Key("gpgkey").SetValue(strings.Join(gpgKeyUrls, " "))
+		}
+	}
+
 	return nil
 }
 
+// linkRpmFiles hard links (or, if that isn't possible, copies) the RPM files under sourceDir into targetDir, keeping
+// the same directory structure.
+func linkRpmFiles(sourceDir string, targetDir string) (int, error) {
+	rpmsCount := 0
+
+	err := filepath.WalkDir(sourceDir, func(sourcePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			// Skip any existing repo metadata.
+			if d.Name() == "repodata" || d.Name() == ".repodata" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), ".rpm") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(sourceDir, sourcePath)
+		if err != nil {
+			return err
+		}
+
+		targetPath := filepath.Join(targetDir, relPath)
+
+		err = os.MkdirAll(filepath.Dir(targetPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		err = os.Link(sourcePath, targetPath)
+		if err != nil {
+			// Hard links don't work across filesystems.
+			err = file.Copy(sourcePath, targetPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		rpmsCount++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rpmsCount == 0 {
+		return 0, fmt.Errorf("no RPM files found")
+	}
+
+	return rpmsCount, nil
+}
+
 func (m *rpmSourcesMounts) createRepoFromRepoConfig(rpmSource string, isHostConfig bool, allReposConfig *ini.File,
 	imageChroot *safechroot.Chroot,
 ) error {
@@ -207,10 +349,13 @@ func (m *rpmSourcesMounts) createRepoFromRepoConfig(rpmSource string, isHostConf
 		}
 
 		// Copy over the repo details to the all-repos config.
-		err := appendIniSection(allReposConfig, repoConfig)
+		newRepoConfig, err := appendIniSection(allReposConfig, repoConfig)
 		if err != nil {
 			return fmt.Errorf("failed to append repo config (%s):\n%w", rpmSource, err)
 		}
+
+		// GPG checks are only enabled for local repos that explicitly request it.
+		newRepoConfig.Key("gpgcheck").SetValue("0")
 	}
 
 	return nil
@@ -253,6 +398,14 @@ func (m *rpmSourcesMounts) close() error {
 		}
 	}
 
+	// Delete the local repos, but only once they are no longer mounted.
+	if len(errs) <= 0 && m.localReposDir != "" {
+		err = os.RemoveAll(m.localReposDir)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	// Join all the errors together.
 	if len(errs) > 0 {
 		err = errors.Join(errs...)
@@ -302,46 +455,51 @@ func getRpmSourceFileType(rpmSourcePath string) (string, error) {
 }
 
 // Add a local directory containing RPMs to the allrepos.repo file.
-func appendLocalRepo(iniFile *ini.File, mountTargetDirectoryInChroot string) error {
+func appendLocalRepo(iniFile *ini.File, mountTargetDirectoryInChroot string) (*ini.Section, error) {
 	repoName := filepath.Base(mountTargetDirectoryInChroot)
 	iniSection, err := iniFile.NewSection(repoName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_, err = iniSection.NewKey("name", repoName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	baseurl := fmt.Sprintf("file://%s", mountTargetDirectoryInChroot)
 
 	_, err = iniSection.NewKey("baseurl", baseurl)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	_, err = iniSection.NewKey("enabled", "1")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	_, err = iniSection.NewKey("gpgcheck", "0")
+	if err != nil {
+		return nil, err
+	}
+
+	return iniSection, nil
 }
 
 // appendIniSection copies an ini section to the end of an ini file.
-func appendIniSection(iniFile *ini.File, iniSection *ini.Section) error {
+func appendIniSection(iniFile *ini.File, iniSection *ini.Section) (*ini.Section, error) {
 	newSection, err := iniFile.NewSection(iniSection.Name())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, key := range iniSection.Keys() {
 		_, err := newSection.NewKey(key.Name(), key.Value())
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return newSection, nil
 }
@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestLinkRpmFiles(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestLinkRpmFiles")
+	sourceDir := filepath.Join(testTmpDir, "source")
+	targetDir := filepath.Join(testTmpDir, "target")
+
+	err := os.RemoveAll(testTmpDir)
+	assert.NoError(t, err)
+
+	for _, path := range []string{"a.rpm", "sub/b.rpm", "readme.txt", "repodata/c.rpm"} {
+		err = os.MkdirAll(filepath.Dir(filepath.Join(sourceDir, path)), os.ModePerm)
+		assert.NoError(t, err)
+		err = file.Write(path, filepath.Join(sourceDir, path))
+		assert.NoError(t, err)
+	}
+
+	rpmsCount, err := linkRpmFiles(sourceDir, targetDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rpmsCount)
+
+	contents, err := file.Read(filepath.Join(targetDir, "sub/b.rpm"))
+	assert.NoError(t, err)
+	assert.Equal(t, "sub/b.rpm", contents)
+
+	exists, _ := file.PathExists(filepath.Join(targetDir, "readme.txt"))
+	assert.False(t, exists)
+	exists, _ = file.PathExists(filepath.Join(targetDir, "repodata"))
+	assert.False(t, exists)
+}
+
+func TestLinkRpmFilesEmpty(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestLinkRpmFilesEmpty")
+	sourceDir := filepath.Join(testTmpDir, "source")
+
+	err := os.MkdirAll(sourceDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	_, err = linkRpmFiles(sourceDir, filepath.Join(testTmpDir, "target"))
+	assert.ErrorContains(t, err, "no RPM files found")
+}
+
+func TestAppendLocalRepo(t *testing.T) {
+	allReposConfig := ini.Empty()
+
+	section, err := appendLocalRepo(allReposConfig, "/_localrpms/00rpms")
+	assert.NoError(t, err)
+	assert.Equal(t, "00rpms", section.Name())
+	assert.Equal(t, "file:///_localrpms/00rpms", section.Key("baseurl").String())
+	assert.Equal(t, "1", section.Key("enabled").String())
+	assert.Equal(t, "0", section.Key("gpgcheck").String())
+}
+
+func TestValidateLocalRepos(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestValidateLocalRepos")
+
+	err := os.MkdirAll(filepath.Join(testTmpDir, "rpms"), os.ModePerm)
+	assert.NoError(t, err)
+	err = file.Write("key", filepath.Join(testTmpDir, "key.asc"))
+	assert.NoError(t, err)
+
+	err = validateLocalRepos(testTmpDir, []imagecustomizerapi.LocalRepo{
+		{Path: "rpms", GpgCheck: true, GpgKeys: []string{"key.asc"}},
+	})
+	assert.NoError(t, err)
+
+	err = validateLocalRepos(testTmpDir, []imagecustomizerapi.LocalRepo{{Path: "missing"}})
+	assert.ErrorContains(t, err, "couldn't read directory (missing)")
+
+	err = validateLocalRepos(testTmpDir, []imagecustomizerapi.LocalRepo{{Path: "key.asc"}})
+	assert.ErrorContains(t, err, "path (key.asc) is not a directory")
+
+	err = validateLocalRepos(testTmpDir, []imagecustomizerapi.LocalRepo{
+		{Path: "rpms", GpgCheck: true, GpgKeys: []string{"missing.asc"}},
+	})
+	assert.ErrorContains(t, err, "couldn't read GPG key file (missing.asc)")
+}
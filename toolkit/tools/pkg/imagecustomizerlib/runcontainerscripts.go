@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	// The path that the image's root directory is bind mounted to within the container.
+	containerScriptImageRootDir = "/target"
+)
+
+var (
+	// The container engines that are supported, in order of preference.
+	containerScriptEngines = []string{"podman", "docker"}
+)
+
+// runUserScriptInContainer runs a script within an OCI container on the build host, with the image's root directory
+// bind mounted at '/target'. This allows tools that are only needed at build time to be used without installing them
+// into the image.
+func runUserScriptInContainer(baseConfigPath string, scriptIndex int, script imagecustomizerapi.Script,
+	listName string, outputArtifactsDir string, imageRootDir string,
+) error {
+	scriptLogName := createScriptLogName(scriptIndex, script, listName)
+
+	logger.Log.Infof("Running script (%s) in container (%s)", scriptLogName, script.Container.Image)
+
+	engine, err := findContainerScriptEngine()
+	if err != nil {
+		return fmt.Errorf("script (%s) failed:\n%w", scriptLogName, err)
+	}
+
+	scriptPath := ""
+	if script.Path != "" {
+		scriptPath = path.Join(configDirMountPathInChroot, filepath.ToSlash(script.Path))
+	} else {
+		// Write the script to the image's temp directory, so that it is accessible through the image's bind mount.
+		tempScriptFullPath, err := createTempScriptFile(script, listName, scriptLogName,
+			filepath.Join(imageRootDir, "tmp"))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tempScriptFullPath)
+
+		scriptPath = path.Join(containerScriptImageRootDir, "tmp", filepath.Base(tempScriptFullPath))
+	}
+
+	args := createContainerScriptArgs(script, scriptPath, baseConfigPath, outputArtifactsDir, imageRootDir)
+
+	err = shell.NewExecBuilder(engine, args...).
+		ErrorStderrLines(1).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("script (%s) failed:\n%w", scriptLogName, err)
+	}
+
+	return nil
+}
+
+func findContainerScriptEngine() (string, error) {
+	for _, engine := range containerScriptEngines {
+		_, err := exec.LookPath(engine)
+		if err == nil {
+			return engine, nil
+		}
+	}
+
+	return "", fmt.Errorf("running scripts in containers requires one of (%v) to be installed on the build host",
+		containerScriptEngines)
+}
+
+// createContainerScriptArgs returns the container engine's 'run' args for a script.
+//
+// The container's environment mirrors that of scripts that run within the image: the config directory is mounted
+// read-only at '/_imageconfigs' (which is also the working directory) and the output artifacts directory is mounted at
+// '/_outputartifacts'.
+func createContainerScriptArgs(script imagecustomizerapi.Script, scriptPath string, baseConfigPath string,
+	outputArtifactsDir string, imageRootDir string,
+) []string {
+	args := []string{
+		"run", "--rm",
+		"--volume", fmt.Sprintf("%s:%s", imageRootDir, containerScriptImageRootDir),
+		"--volume", fmt.Sprintf("%s:%s:ro", baseConfigPath, configDirMountPathInChroot),
+		"--workdir", configDirMountPathInChroot,
+		"--env", fmt.Sprintf("%s=%s", scriptImageRootDirEnvVar, containerScriptImageRootDir),
+	}
+
+	if outputArtifactsDir != "" {
+		args = append(args,
+			"--volume", fmt.Sprintf("%s:%s", outputArtifactsDir, outputArtifactsDirMountPathInChroot),
+			"--env", fmt.Sprintf("%s=%s", scriptOutputArtifactsDirEnvVar, outputArtifactsDirMountPathInChroot))
+	}
+
+	// Sort the environment variables, so that the command is consistent between builds.
+	envVarNames := make([]string, 0, len(script.EnvironmentVariables))
+	for name := range script.EnvironmentVariables {
+		envVarNames = append(envVarNames, name)
+	}
+	sort.Strings(envVarNames)
+
+	for _, name := range envVarNames {
+		args = append(args, "--env", fmt.Sprintf("%s=%s", name, script.EnvironmentVariables[name]))
+	}
+
+	interpreter := script.Interpreter
+	if interpreter == "" {
+		interpreter = "/bin/sh"
+	}
+
+	// Override the image's entrypoint, so that the script is run the same way regardless of how the image was built.
+	args = append(args, "--entrypoint", interpreter, script.Container.Image, scriptPath)
+	args = append(args, script.Arguments...)
+
+	return args
+}
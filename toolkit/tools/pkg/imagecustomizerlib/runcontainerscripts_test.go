@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateContainerScriptArgs(t *testing.T) {
+	script := imagecustomizerapi.Script{
+		Path:        "scripts/build-app.py",
+		Interpreter: "python3",
+		Arguments:   []string{"--release"},
+		EnvironmentVariables: map[string]string{
+			"B": "2",
+			"A": "1",
+		},
+		Container: &imagecustomizerapi.ScriptContainer{Image: "mcr.microsoft.com/azurelinux/base/python:3"},
+	}
+
+	args := createContainerScriptArgs(script, "/_imageconfigs/scripts/build-app.py", "/config", "/artifacts",
+		"/build/imageroot")
+	assert.Equal(t, []string{
+		"run", "--rm",
+		"--volume", "/build/imageroot:/target",
+		"--volume", "/config:/_imageconfigs:ro",
+		"--workdir", "/_imageconfigs",
+		"--env", "IMAGE_ROOT_DIR=/target",
+		"--volume", "/artifacts:/_outputartifacts",
+		"--env", "OUTPUT_ARTIFACTS_DIR=/_outputartifacts",
+		"--env", "A=1",
+		"--env", "B=2",
+		"--entrypoint", "python3", "mcr.microsoft.com/azurelinux/base/python:3",
+		"/_imageconfigs/scripts/build-app.py", "--release",
+	}, args)
+}
+
+func TestCreateContainerScriptArgsDefaults(t *testing.T) {
+	script := imagecustomizerapi.Script{
+		Content:   "npm ci --prefix /target/opt/app",
+		Container: &imagecustomizerapi.ScriptContainer{Image: "node:20"},
+	}
+
+	args := createContainerScriptArgs(script, "/target/tmp/postConfig123", "/config", "", "/build/imageroot")
+	assert.Equal(t, []string{
+		"run", "--rm",
+		"--volume", "/build/imageroot:/target",
+		"--volume", "/config:/_imageconfigs:ro",
+		"--workdir", "/_imageconfigs",
+		"--env", "IMAGE_ROOT_DIR=/target",
+		"--entrypoint", "/bin/sh", "node:20",
+		"/target/tmp/postConfig123",
+	}, args)
+}
@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safemount"
@@ -17,11 +19,33 @@ import (
 )
 
 const (
-	configDirMountPathInChroot = "/_imageconfigs"
+	configDirMountPathInChroot          = "/_imageconfigs"
+	outputArtifactsDirMountPathInChroot = "/_outputartifacts"
+
+	// Environment variables that are set for scripts.
+	scriptOutputArtifactsDirEnvVar = "OUTPUT_ARTIFACTS_DIR"
+	scriptImageRootDirEnvVar       = "IMAGE_ROOT_DIR"
 )
 
+// prepareScriptsOutputArtifactsDir creates the scripts' output artifacts directory (if configured) and returns its
+// absolute path.
+func prepareScriptsOutputArtifactsDir(baseConfigPath string, scripts imagecustomizerapi.Scripts) (string, error) {
+	if scripts.OutputArtifactsDir == "" {
+		return "", nil
+	}
+
+	outputArtifactsDir := file.GetAbsPathWithBase(baseConfigPath, scripts.OutputArtifactsDir)
+
+	err := os.MkdirAll(outputArtifactsDir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create scripts output artifacts directory (%s):\n%w", outputArtifactsDir, err)
+	}
+
+	return outputArtifactsDir, nil
+}
+
 func runUserScripts(baseConfigPath string, scripts []imagecustomizerapi.Script, listName string,
-	imageChroot *safechroot.Chroot,
+	outputArtifactsDir string, imageChroot *safechroot.Chroot,
 ) error {
 	if len(scripts) <= 0 {
 		return nil
@@ -38,9 +62,39 @@ func runUserScripts(baseConfigPath string, scripts []imagecustomizerapi.Script,
 	}
 	defer mount.Close()
 
+	extraEnvVars := []string(nil)
+
+	// Bind mount the output artifacts directory so that the scripts can export files to the build host.
+	var outputArtifactsMount *safemount.Mount
+	if outputArtifactsDir != "" {
+		outputArtifactsMountPath := filepath.Join(imageChroot.RootDir(), outputArtifactsDirMountPathInChroot)
+
+		outputArtifactsMount, err = safemount.NewMount(outputArtifactsDir, outputArtifactsMountPath, "", unix.MS_BIND,
+			"", true)
+		if err != nil {
+			return err
+		}
+		defer outputArtifactsMount.Close()
+
+		extraEnvVars = append(extraEnvVars,
+			fmt.Sprintf("%s=%s", scriptOutputArtifactsDirEnvVar, outputArtifactsDirMountPathInChroot))
+	}
+
 	// Runs scripts.
-	for i, script := range scripts {
-		err := runUserScript(i, script, listName, imageChroot)
+	for _, i := range orderScripts(scripts) {
+		if scripts[i].Container != nil {
+			err = runUserScriptInContainer(baseConfigPath, i, scripts[i], listName, outputArtifactsDir,
+				imageChroot.RootDir())
+		} else {
+			err = runUserScript(i, scripts[i], listName, extraEnvVars, imageChroot)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if outputArtifactsMount != nil {
+		err = outputArtifactsMount.CleanClose()
 		if err != nil {
 			return err
 		}
@@ -54,7 +108,113 @@ func runUserScripts(baseConfigPath string, scripts []imagecustomizerapi.Script,
 	return nil
 }
 
-func runUserScript(scriptIndex int, script imagecustomizerapi.Script, listName string,
+// runUserScriptsOutsideChroot runs scripts directly on the build host, with the image's root directory available
+// through the IMAGE_ROOT_DIR environment variable.
+func runUserScriptsOutsideChroot(buildDir string, baseConfigPath string, scripts []imagecustomizerapi.Script,
+	listName string, outputArtifactsDir string, imageRootDir string,
+) error {
+	if len(scripts) <= 0 {
+		return nil
+	}
+
+	logger.Log.Infof("Running %s scripts", listName)
+
+	extraEnvVars := []string{
+		fmt.Sprintf("%s=%s", scriptImageRootDirEnvVar, imageRootDir),
+	}
+	if outputArtifactsDir != "" {
+		extraEnvVars = append(extraEnvVars, fmt.Sprintf("%s=%s", scriptOutputArtifactsDirEnvVar, outputArtifactsDir))
+	}
+
+	for _, i := range orderScripts(scripts) {
+		var err error
+		if scripts[i].Container != nil {
+			err = runUserScriptInContainer(baseConfigPath, i, scripts[i], listName, outputArtifactsDir, imageRootDir)
+		} else {
+			err = runUserScriptOutsideChroot(buildDir, baseConfigPath, i, scripts[i], listName, extraEnvVars)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runUserScriptOutsideChroot(buildDir string, baseConfigPath string, scriptIndex int,
+	script imagecustomizerapi.Script, listName string, extraEnvVars []string,
+) error {
+	var err error
+
+	scriptLogName := createScriptLogName(scriptIndex, script, listName)
+
+	logger.Log.Infof("Running script (%s) outside of chroot", scriptLogName)
+
+	scriptPath := ""
+	if script.Path != "" {
+		scriptPath = filepath.Join(baseConfigPath, script.Path)
+	} else {
+		scriptPath, err = createTempScriptFile(script, listName, scriptLogName, buildDir)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(scriptPath)
+	}
+
+	process := script.Interpreter
+	if process == "" {
+		process = "/bin/sh"
+	}
+
+	args := []string{scriptPath}
+	args = append(args, script.Arguments...)
+
+	err = shell.NewExecBuilder(process, args...).
+		EnvironmentVariables(createScriptEnvVars(script, extraEnvVars)).
+		WorkingDirectory(baseConfigPath).
+		ErrorStderrLines(1).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("script (%s) failed:\n%w", scriptLogName, err)
+	}
+
+	return nil
+}
+
+// orderScripts returns the indexes of the scripts in the order they should be run in.
+func orderScripts(scripts []imagecustomizerapi.Script) []int {
+	indexes := make([]int, len(scripts))
+	for i := range scripts {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return scripts[indexes[i]].Order < scripts[indexes[j]].Order
+	})
+
+	return indexes
+}
+
+func createScriptEnvVars(script imagecustomizerapi.Script, extraEnvVars []string) []string {
+	envVars := []string(nil)
+	for key, value := range script.EnvironmentVariables {
+		envVar := fmt.Sprintf("%s=%s", key, value)
+		envVars = append(envVars, envVar)
+	}
+
+	if len(extraEnvVars) > 0 {
+		// When a script doesn't specify any environment variables, it inherits the environment of the customizer
+		// process. Keep that behavior when adding the extra variables.
+		if envVars == nil {
+			envVars = os.Environ()
+		}
+		envVars = append(envVars, extraEnvVars...)
+	}
+
+	return envVars
+}
+
+func runUserScript(scriptIndex int, script imagecustomizerapi.Script, listName string, extraEnvVars []string,
 	imageChroot *safechroot.Chroot,
 ) error {
 	var err error
@@ -70,7 +230,8 @@ func runUserScript(scriptIndex int, script imagecustomizerapi.Script, listName s
 		scriptPath = filepath.Join(configDirMountPathInChroot, script.Path)
 	} else {
 		// Write the script to a temporary file.
-		tempScriptFullPath, err = createTempScriptFile(script, listName, scriptLogName, imageChroot)
+		tempScriptFullPath, err = createTempScriptFile(script, listName, scriptLogName,
+			filepath.Join(imageChroot.RootDir(), "tmp"))
 		if err != nil {
 			return err
 		}
@@ -96,11 +257,7 @@ func runUserScript(scriptIndex int, script imagecustomizerapi.Script, listName s
 	args := []string{scriptPath}
 	args = append(args, script.Arguments...)
 
-	envVars := []string(nil)
-	for key, value := range script.EnvironmentVariables {
-		envVar := fmt.Sprintf("%s=%s", key, value)
-		envVars = append(envVars, envVar)
-	}
+	envVars := createScriptEnvVars(script, extraEnvVars)
 
 	// Run the script.
 	err = imageChroot.UnsafeRun(func() error {
@@ -138,12 +295,10 @@ func createScriptLogName(scriptIndex int, script imagecustomizerapi.Script, list
 }
 
 func createTempScriptFile(script imagecustomizerapi.Script, listName string, scriptLogName string,
-	imageChroot *safechroot.Chroot,
+	tempDir string,
 ) (string, error) {
-	chrootTempDir := filepath.Join(imageChroot.RootDir(), "tmp")
-
 	// Create a temporary file for the script.
-	tempFile, err := os.CreateTemp(chrootTempDir, listName)
+	tempFile, err := os.CreateTemp(tempDir, listName)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file for script:\n%w", err)
 	}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,3 +68,79 @@ resolv.conf exists
 
 	verifyFileContentsSame(t, aOrigFilePath, aNewFilePath)
 }
+
+func TestOrderScripts(t *testing.T) {
+	scripts := []imagecustomizerapi.Script{
+		{Name: "a", Order: 10},
+		{Name: "b"},
+		{Name: "c", Order: -1},
+		{Name: "d"},
+	}
+
+	assert.Equal(t, []int{2, 1, 3, 0}, orderScripts(scripts))
+}
+
+func TestCreateScriptEnvVars(t *testing.T) {
+	script := imagecustomizerapi.Script{
+		EnvironmentVariables: map[string]string{
+			"ANIMAL": "lion",
+		},
+	}
+
+	envVars := createScriptEnvVars(script, []string{"OUTPUT_ARTIFACTS_DIR=/_outputartifacts"})
+	assert.Equal(t, []string{"ANIMAL=lion", "OUTPUT_ARTIFACTS_DIR=/_outputartifacts"}, envVars)
+
+	// Scripts without any environment variables inherit the customizer's environment.
+	envVars = createScriptEnvVars(imagecustomizerapi.Script{}, nil)
+	assert.Nil(t, envVars)
+
+	envVars = createScriptEnvVars(imagecustomizerapi.Script{}, []string{"IMAGE_ROOT_DIR=/root"})
+	assert.Contains(t, envVars, "IMAGE_ROOT_DIR=/root")
+	assert.Greater(t, len(envVars), 1)
+}
+
+func TestRunUserScriptsOutsideChroot(t *testing.T) {
+	testTmpDir := filepath.Join(tmpDir, "TestRunUserScriptsOutsideChroot")
+	defer os.RemoveAll(testTmpDir)
+
+	buildDir := filepath.Join(testTmpDir, "build")
+	configDir := filepath.Join(testTmpDir, "config")
+	rootDir := filepath.Join(testTmpDir, "root")
+
+	for _, dir := range []string{buildDir, configDir, rootDir} {
+		err := os.MkdirAll(dir, os.ModePerm)
+		assert.NoError(t, err)
+	}
+
+	err := file.Write("printf 'first:%s\\n' \"$1\" >> \"$IMAGE_ROOT_DIR/log.txt\"\n", filepath.Join(configDir, "a.sh"))
+	assert.NoError(t, err)
+
+	scripts := imagecustomizerapi.Scripts{
+		OutputArtifactsDir: "artifacts",
+		FinalizeOutsideChroot: []imagecustomizerapi.Script{
+			{
+				Content: "echo \"second:$PWD\" >> \"$IMAGE_ROOT_DIR/log.txt\"\n" +
+					"cp \"$IMAGE_ROOT_DIR/log.txt\" \"$OUTPUT_ARTIFACTS_DIR/log.txt\"\n",
+				Order: 1,
+			},
+			{
+				Path:      "a.sh",
+				Arguments: []string{"panda"},
+			},
+		},
+	}
+
+	outputArtifactsDir, err := prepareScriptsOutputArtifactsDir(configDir, scripts)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(configDir, "artifacts"), outputArtifactsDir)
+
+	err = runUserScriptsOutsideChroot(buildDir, configDir, scripts.FinalizeOutsideChroot, "finalizeOutsideChroot",
+		outputArtifactsDir, rootDir)
+	assert.NoError(t, err)
+
+	expectedLog := "first:panda\nsecond:" + configDir + "\n"
+
+	logContents, err := file.Read(filepath.Join(outputArtifactsDir, "log.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedLog, logContents)
+}
@@ -0,0 +1,496 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/selinuxreport"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	selinuxReportFileSuffix = ".selinux.json"
+
+	selinuxLabelXattrName = "security.selinux"
+	selinuxFileContexts   = "/etc/selinux/%s/contexts/files/file_contexts"
+	// The booleans set using `setsebool -P` or `semanage boolean`.
+	selinuxLocalBooleansFile = "/var/lib/selinux/%s/active/booleans.local"
+	// The policy store's modules, in a directory per priority (e.g. "100/base/cil").
+	selinuxModulesDir = "/var/lib/selinux/%s/active/modules"
+	// The directory of the policy store that marks disabled modules.
+	selinuxDisabledModulesDirName = "disabled"
+	selinuxModuleCilFileName      = "cil"
+)
+
+var (
+	selinuxTypeRegex = regexp.MustCompile(`(?m)^\s*SELINUXTYPE\s*=\s*(\S+)\s*$`)
+
+	// Example: Would relabel /etc/motd from system_u:object_r:etc_runtime_t:s0 to system_u:object_r:etc_t:s0
+	setfilesRelabelRegex = regexp.MustCompile(`^Would relabel (.+) from (\S+) to (\S+)$`)
+
+	// Example: (boolean httpd_can_network_connect false)
+	selinuxCilBooleanRegex = regexp.MustCompile(`\(boolean\s+(\S+)\s+(true|false)\s*\)`)
+
+	bzip2Magic = []byte("BZh")
+)
+
+// selinuxReportBuilder collects the SELinux state of the OS at the points of the customization that the SELinux report
+// is built from: the base image, just before the files are relabeled and the end of the customization.
+type selinuxReportBuilder struct {
+	excludedDirs []string
+
+	baseLabels map[string]string
+	// nil if the base image doesn't have an SELinux policy.
+	baseBooleans map[string]bool
+
+	relabels map[string]string
+}
+
+// newSELinuxReportBuilder records the SELinux state of the base image. Returns nil if the SELinux report isn't
+// enabled.
+func newSELinuxReportBuilder(selinuxReportConfig *imagecustomizerapi.SELinuxReport, imageChroot *safechroot.Chroot,
+) (*selinuxReportBuilder, error) {
+	if selinuxReportConfig == nil {
+		return nil, nil
+	}
+
+	excludedDirs := append([]string(nil), changeManifestExcludedDirs...)
+	excludedDirs = append(excludedDirs, selinuxReportConfig.ExcludePaths...)
+
+	baseLabels, err := readSELinuxLabels(imageChroot.RootDir(), excludedDirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SELinux file labels of base image:\n%w", err)
+	}
+
+	// The customization may install the SELinux policy.
+	var baseBooleans map[string]bool
+	policyType, err := getSELinuxPolicyType(imageChroot.RootDir())
+	if err == nil {
+		baseBooleans, err = readSELinuxBooleans(imageChroot.RootDir(), policyType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	builder := &selinuxReportBuilder{
+		excludedDirs: excludedDirs,
+		baseLabels:   baseLabels,
+		baseBooleans: baseBooleans,
+	}
+	return builder, nil
+}
+
+// captureRelabels records the files whose labels don't match the policy. This must be called just before the files
+// are relabeled, since relabeling fixes the labels.
+func (b *selinuxReportBuilder) captureRelabels(imageChroot *safechroot.Chroot) error {
+	if b == nil {
+		return nil
+	}
+
+	policyType, err := getSELinuxPolicyType(imageChroot.RootDir())
+	if err != nil {
+		return err
+	}
+
+	b.relabels, err = findSELinuxRelabels(policyType, b.excludedDirs, imageChroot)
+	if err != nil {
+		return fmt.Errorf("failed to check SELinux file labels against policy:\n%w", err)
+	}
+
+	return nil
+}
+
+// createReport records the final SELinux labels of the OS's files and compares them and the booleans to the base
+// image. If captureRelabels() wasn't called (e.g. for a hotfix), the labels are checked against the policy now.
+func (b *selinuxReportBuilder) createReport(imageChroot *safechroot.Chroot) (*selinuxreport.Report, error) {
+	logger.Log.Infof("Creating SELinux report")
+
+	policyType, err := getSELinuxPolicyType(imageChroot.RootDir())
+	if err != nil {
+		return nil, err
+	}
+
+	policyPackage, err := getSELinuxPolicyPackage(policyType, imageChroot)
+	if err != nil {
+		return nil, err
+	}
+
+	booleans, err := readSELinuxBooleans(imageChroot.RootDir(), policyType)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := readSELinuxLabels(imageChroot.RootDir(), b.excludedDirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SELinux file labels:\n%w", err)
+	}
+
+	relabels := b.relabels
+	if relabels == nil {
+		relabels, err = findSELinuxRelabels(policyType, b.excludedDirs, imageChroot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check SELinux file labels against policy:\n%w", err)
+		}
+	}
+
+	report := &selinuxreport.Report{
+		PolicyType:    policyType,
+		PolicyPackage: policyPackage,
+		Booleans:      booleans,
+		Files:         files,
+		Relabels:      relabels,
+		BaseLabels:    changedSELinuxLabels(b.baseLabels, files),
+		BaseBooleans:  changedSELinuxBooleans(b.baseBooleans, booleans),
+	}
+
+	logger.Log.Infof("SELinux files labeled: %d, relabeled: %d, uncovered by policy: %d, changed from base image: %d",
+		len(files), len(relabels), len(report.UncoveredFiles()), len(report.BaseLabels))
+	for _, line := range report.DiffBase() {
+		logger.Log.Debugf("SELinux change from base image: %s", line)
+	}
+
+	return report, nil
+}
+
+// changedSELinuxLabels returns the base labels of the files that exist in both images and whose label changed.
+func changedSELinuxLabels(baseLabels map[string]string, labels map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for path, baseLabel := range baseLabels {
+		label, found := labels[path]
+		if found && label != baseLabel {
+			changed[path] = baseLabel
+		}
+	}
+
+	return changed
+}
+
+// changedSELinuxBooleans returns the base values of the booleans whose value changed or which were removed.
+func changedSELinuxBooleans(baseBooleans map[string]bool, booleans map[string]bool) map[string]bool {
+	changed := make(map[string]bool)
+	for name, baseValue := range baseBooleans {
+		value, found := booleans[name]
+		if !found || value != baseValue {
+			changed[name] = baseValue
+		}
+	}
+
+	return changed
+}
+
+func getSELinuxPolicyType(rootDir string) (string, error) {
+	selinuxConfigFilePath := filepath.Join(rootDir, installutils.SELinuxConfigFile)
+
+	content, err := os.ReadFile(selinuxConfigFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read SELinux config file (%s):\n"+
+			"please ensure an SELinux policy is installed:\n%w", installutils.SELinuxConfigFile, err)
+	}
+
+	match := selinuxTypeRegex.FindStringSubmatch(string(content))
+	if match == nil {
+		return "", fmt.Errorf("failed to find SELINUXTYPE in SELinux config file (%s)", installutils.SELinuxConfigFile)
+	}
+
+	return match[1], nil
+}
+
+func getSELinuxPolicyPackage(policyType string, imageChroot *safechroot.Chroot) (string, error) {
+	policyDir := filepath.Join("/etc/selinux", policyType)
+
+	var stdout string
+	err := imageChroot.UnsafeRun(func() error {
+		var err error
+		stdout, _, err = shell.Execute("rpm", "-qf", "--queryformat", "%{NAME}-%{VERSION}-%{RELEASE}\n", policyDir)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to find package that provides SELinux policy (%s):\n%w", policyType, err)
+	}
+
+	// A directory may be owned by multiple packages. So, just use the first.
+	policyPackage, _, _ := strings.Cut(strings.TrimSpace(stdout), "\n")
+	return policyPackage, nil
+}
+
+// readSELinuxBooleans returns the value of each of the policy's booleans: the policy's default, unless it was changed
+// (e.g. using `setsebool -P`).
+func readSELinuxBooleans(rootDir string, policyType string) (map[string]bool, error) {
+	booleans, err := readSELinuxPolicyBooleans(filepath.Join(rootDir, fmt.Sprintf(selinuxModulesDir, policyType)))
+	if err != nil {
+		return nil, err
+	}
+
+	localBooleans, err := readSELinuxLocalBooleans(filepath.Join(rootDir,
+		fmt.Sprintf(selinuxLocalBooleansFile, policyType)))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range localBooleans {
+		booleans[name] = value
+	}
+
+	return booleans, nil
+}
+
+// readSELinuxPolicyBooleans reads the default values of the booleans declared by the enabled modules of the policy
+// store. When a module is installed at multiple priorities, only the highest priority one is used, like semodule does.
+func readSELinuxPolicyBooleans(modulesDir string) (map[string]bool, error) {
+	priorityDirs, err := os.ReadDir(modulesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Log.Warnf("SELinux policy store modules (%s) not found, only recording changed booleans", modulesDir)
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SELinux policy store modules (%s):\n%w", modulesDir, err)
+	}
+
+	modulePriorities := make(map[string]int)
+	moduleCilFiles := make(map[string]string)
+	for _, priorityDir := range priorityDirs {
+		priority, err := strconv.Atoi(priorityDir.Name())
+		if err != nil || !priorityDir.IsDir() {
+			// Not a priority (e.g. the disabled modules).
+			continue
+		}
+
+		modules, err := os.ReadDir(filepath.Join(modulesDir, priorityDir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SELinux policy store modules (%s):\n%w", modulesDir, err)
+		}
+
+		for _, module := range modules {
+			currentPriority, found := modulePriorities[module.Name()]
+			if found && currentPriority > priority {
+				continue
+			}
+
+			modulePriorities[module.Name()] = priority
+			moduleCilFiles[module.Name()] = filepath.Join(modulesDir, priorityDir.Name(), module.Name(),
+				selinuxModuleCilFileName)
+		}
+	}
+
+	booleans := make(map[string]bool)
+	for moduleName, cilFile := range moduleCilFiles {
+		_, err := os.Stat(filepath.Join(modulesDir, selinuxDisabledModulesDirName, moduleName))
+		if err == nil {
+			continue
+		}
+
+		cil, err := readSELinuxModuleCil(cilFile)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range selinuxCilBooleanRegex.FindAllStringSubmatch(cil, -1) {
+			booleans[match[1]] = match[2] == "true"
+		}
+	}
+
+	return booleans, nil
+}
+
+// readSELinuxModuleCil reads the CIL of a policy store module, which is usually bzip2 compressed.
+func readSELinuxModuleCil(cilFile string) (string, error) {
+	file, err := os.Open(cilFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open SELinux module (%s):\n%w", cilFile, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(len(bzip2Magic))
+
+	var cilReader io.Reader = reader
+	if bytes.Equal(magic, bzip2Magic) {
+		cilReader = bzip2.NewReader(reader)
+	}
+
+	cil, err := io.ReadAll(cilReader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read SELinux module (%s):\n%w", cilFile, err)
+	}
+
+	return string(cil), nil
+}
+
+func readSELinuxLocalBooleans(booleansFilePath string) (map[string]bool, error) {
+	content, err := os.ReadFile(booleansFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		// No booleans have been changed.
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SELinux booleans file (%s):\n%w", booleansFilePath, err)
+	}
+
+	return parseSELinuxLocalBooleans(string(content))
+}
+
+func parseSELinuxLocalBooleans(content string) (map[string]bool, error) {
+	booleans := make(map[string]bool)
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid SELinux boolean line (%s)", line)
+		}
+
+		valueInt, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SELinux boolean line (%s):\n%w", line, err)
+		}
+
+		booleans[strings.TrimSpace(name)] = valueInt != 0
+	}
+
+	return booleans, nil
+}
+
+// readSELinuxLabels reads the SELinux label of each file. Files on filesystems that don't support SELinux labels
+// (e.g. the ESP) are skipped.
+func readSELinuxLabels(rootDir string, excludedDirs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+
+		imagePath := filepath.Join("/", relPath)
+		if isSELinuxReportExcludedPath(imagePath, excludedDirs) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		label, err := getSELinuxLabel(path)
+		if err != nil {
+			return err
+		}
+
+		if label != "" {
+			labels[imagePath] = label
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+func getSELinuxLabel(path string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		size, err := unix.Lgetxattr(path, selinuxLabelXattrName, buf)
+		switch {
+		case errors.Is(err, unix.ERANGE):
+			buf = make([]byte, len(buf)*2)
+			continue
+
+		case errors.Is(err, unix.ENODATA), errors.Is(err, unix.ENOTSUP):
+			return "", nil
+
+		case err != nil:
+			return "", fmt.Errorf("failed to read SELinux label of (%s):\n%w", path, err)
+		}
+
+		return strings.TrimRight(string(buf[:size]), "\x00"), nil
+	}
+}
+
+// findSELinuxRelabels finds the files whose labels differ from the labels that the policy would give them.
+func findSELinuxRelabels(policyType string, excludedDirs []string, imageChroot *safechroot.Chroot,
+) (map[string]string, error) {
+	relabels := make(map[string]string)
+	fileContextsPath := fmt.Sprintf(selinuxFileContexts, policyType)
+
+	for _, mountPoint := range getNonSpecialChrootMountPoints(imageChroot) {
+		switch mountPoint.GetFSType() {
+		case "ext2", "ext3", "ext4", "xfs":
+
+		default:
+			// The filesystem doesn't support SELinux labels.
+			continue
+		}
+
+		var stdout string
+		err := imageChroot.UnsafeRun(func() error {
+			var err error
+			// -n: Don't change any labels.
+			// -x: Don't cross into other filesystems (e.g. /dev or /proc).
+			stdout, _, err = shell.Execute("setfiles", "-n", "-v", "-x", fileContextsPath, mountPoint.GetTarget())
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to run setfiles on (%s):\n%w", mountPoint.GetTarget(), err)
+		}
+
+		for path, label := range parseSetfilesRelabels(stdout) {
+			if !isSELinuxReportExcludedPath(path, excludedDirs) {
+				relabels[path] = label
+			}
+		}
+	}
+
+	return relabels, nil
+}
+
+func parseSetfilesRelabels(setfilesOutput string) map[string]string {
+	relabels := make(map[string]string)
+
+	for _, line := range strings.Split(setfilesOutput, "\n") {
+		match := setfilesRelabelRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		relabels[match[1]] = match[3]
+	}
+
+	return relabels
+}
+
+func isSELinuxReportExcludedPath(imagePath string, excludedDirs []string) bool {
+	for _, excludedDir := range excludedDirs {
+		if imagePath == excludedDir || strings.HasPrefix(imagePath, excludedDir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
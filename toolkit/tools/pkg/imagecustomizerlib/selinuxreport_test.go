@@ -0,0 +1,180 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSELinuxPolicyType(t *testing.T) {
+	rootDir := t.TempDir()
+	configFilePath := filepath.Join(rootDir, "etc/selinux/config")
+
+	err := os.MkdirAll(filepath.Dir(configFilePath), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(configFilePath, []byte("# comment\nSELINUX=enforcing\nSELINUXTYPE=targeted\n"), 0o644)
+	assert.NoError(t, err)
+
+	policyType, err := getSELinuxPolicyType(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "targeted", policyType)
+}
+
+func TestGetSELinuxPolicyTypeMissingConfig(t *testing.T) {
+	_, err := getSELinuxPolicyType(t.TempDir())
+	assert.ErrorContains(t, err, "please ensure an SELinux policy is installed")
+}
+
+func TestParseSELinuxLocalBooleans(t *testing.T) {
+	booleans, err := parseSELinuxLocalBooleans("httpd_can_network_connect=1\n# comment\n\nssh_sysadm_login=0\n")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"httpd_can_network_connect": true,
+		"ssh_sysadm_login":          false,
+	}, booleans)
+}
+
+func TestParseSELinuxLocalBooleansInvalid(t *testing.T) {
+	_, err := parseSELinuxLocalBooleans("httpd_can_network_connect\n")
+	assert.ErrorContains(t, err, "invalid SELinux boolean line (httpd_can_network_connect)")
+
+	_, err = parseSELinuxLocalBooleans("httpd_can_network_connect=yes\n")
+	assert.ErrorContains(t, err, "invalid SELinux boolean line (httpd_can_network_connect=yes)")
+}
+
+func TestReadSELinuxLocalBooleansMissing(t *testing.T) {
+	booleans, err := readSELinuxLocalBooleans(filepath.Join(t.TempDir(), "booleans.local"))
+	assert.NoError(t, err)
+	assert.Empty(t, booleans)
+}
+
+func TestParseSetfilesRelabels(t *testing.T) {
+	output := "Would relabel /etc/motd from system_u:object_r:etc_runtime_t:s0 to system_u:object_r:etc_t:s0\n" +
+		"Would relabel /opt/my app from system_u:object_r:default_t:s0 to system_u:object_r:usr_t:s0\n" +
+		"setfiles: some warning\n"
+
+	assert.Equal(t, map[string]string{
+		"/etc/motd":   "system_u:object_r:etc_t:s0",
+		"/opt/my app": "system_u:object_r:usr_t:s0",
+	}, parseSetfilesRelabels(output))
+}
+
+func TestIsSELinuxReportExcludedPath(t *testing.T) {
+	excludedDirs := []string{"/proc", "/var/cache"}
+
+	assert.True(t, isSELinuxReportExcludedPath("/proc", excludedDirs))
+	assert.True(t, isSELinuxReportExcludedPath("/var/cache/tdnf", excludedDirs))
+	assert.False(t, isSELinuxReportExcludedPath("/var/cache2", excludedDirs))
+	assert.False(t, isSELinuxReportExcludedPath("/etc", excludedDirs))
+}
+
+// writeSELinuxModule writes the CIL of a policy store module, optionally bzip2 compressed like semodule does.
+func writeSELinuxModule(t *testing.T, modulesDir string, priority string, moduleName string, cil string,
+	compress bool,
+) {
+	cilFile := filepath.Join(modulesDir, priority, moduleName, selinuxModuleCilFileName)
+	err := os.MkdirAll(filepath.Dir(cilFile), os.ModePerm)
+	require.NoError(t, err)
+
+	err = os.WriteFile(cilFile, []byte(cil), 0o644)
+	require.NoError(t, err)
+
+	if compress {
+		_, _, err = shell.Execute("bzip2", "-z", cilFile)
+		require.NoError(t, err)
+		err = os.Rename(cilFile+".bz2", cilFile)
+		require.NoError(t, err)
+	}
+}
+
+func TestReadSELinuxPolicyBooleans(t *testing.T) {
+	_, err := exec.LookPath("bzip2")
+	if err != nil {
+		t.Skip("bzip2 is not installed")
+	}
+
+	modulesDir := t.TempDir()
+	writeSELinuxModule(t, modulesDir, "100", "base",
+		"(boolean ssh_sysadm_login false)\n(boolean secure_mode false)\n", true /*compress*/)
+	writeSELinuxModule(t, modulesDir, "100", "apache",
+		"(boolean httpd_can_network_connect false)\n(boolean httpd_enable_cgi true)\n", true /*compress*/)
+	writeSELinuxModule(t, modulesDir, "400", "apache",
+		"(boolean httpd_can_network_connect true)\n", false /*compress*/)
+	writeSELinuxModule(t, modulesDir, "100", "ftp", "(boolean ftpd_anon_write false)\n", true /*compress*/)
+
+	err = os.MkdirAll(filepath.Join(modulesDir, selinuxDisabledModulesDirName), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(modulesDir, selinuxDisabledModulesDirName, "ftp"), nil, 0o644)
+	require.NoError(t, err)
+
+	booleans, err := readSELinuxPolicyBooleans(modulesDir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"ssh_sysadm_login":          false,
+		"secure_mode":               false,
+		"httpd_can_network_connect": true,
+	}, booleans)
+}
+
+func TestReadSELinuxBooleansLocalOverrides(t *testing.T) {
+	rootDir := t.TempDir()
+	modulesDir := filepath.Join(rootDir, fmt.Sprintf(selinuxModulesDir, "targeted"))
+	writeSELinuxModule(t, modulesDir, "100", "base",
+		"(boolean ssh_sysadm_login false)\n(boolean secure_mode false)\n", false /*compress*/)
+
+	localBooleansFile := filepath.Join(rootDir, fmt.Sprintf(selinuxLocalBooleansFile, "targeted"))
+	err := os.WriteFile(localBooleansFile, []byte("ssh_sysadm_login=1\n"), 0o644)
+	require.NoError(t, err)
+
+	booleans, err := readSELinuxBooleans(rootDir, "targeted")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"ssh_sysadm_login": true,
+		"secure_mode":      false,
+	}, booleans)
+}
+
+func TestChangedSELinuxLabels(t *testing.T) {
+	baseLabels := map[string]string{
+		"/etc/motd":        "system_u:object_r:etc_t:s0",
+		"/usr/bin/app":     "system_u:object_r:bin_t:s0",
+		"/usr/bin/removed": "system_u:object_r:bin_t:s0",
+	}
+	labels := map[string]string{
+		"/etc/motd":    "system_u:object_r:etc_t:s0",
+		"/usr/bin/app": "system_u:object_r:app_exec_t:s0",
+		"/usr/bin/new": "system_u:object_r:bin_t:s0",
+	}
+
+	assert.Equal(t, map[string]string{"/usr/bin/app": "system_u:object_r:bin_t:s0"},
+		changedSELinuxLabels(baseLabels, labels))
+}
+
+func TestChangedSELinuxBooleans(t *testing.T) {
+	baseBooleans := map[string]bool{
+		"httpd_can_network_connect": false,
+		"ssh_sysadm_login":          false,
+		"removed_boolean":           true,
+	}
+	booleans := map[string]bool{
+		"httpd_can_network_connect": true,
+		"ssh_sysadm_login":          false,
+		"new_boolean":               true,
+	}
+
+	assert.Equal(t, map[string]bool{"httpd_can_network_connect": false, "removed_boolean": true},
+		changedSELinuxBooleans(baseBooleans, booleans))
+
+	// A base image without an SELinux policy has no booleans to compare to.
+	assert.Empty(t, changedSELinuxBooleans(nil, booleans))
+}
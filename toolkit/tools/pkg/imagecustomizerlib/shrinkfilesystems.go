@@ -21,10 +21,27 @@ var (
 	//   /dev/vda2  18432 8386559 8368128   4G Linux filesystem
 	fdiskPartitionsTableHeaderRegexp = regexp.MustCompile(`(?m)^Device[\t ]+Start[\t ]+`)
 	fdiskPartitionsTableEntryRegexp  = regexp.MustCompile(`^([0-9A-Za-z-_/]+)[\t ]+(\d+)[\t ]+`)
+
+	// Parsing output of: resize2fs -P <device>
+	//
+	// Example:
+	//   Estimated minimum size of the filesystem: 21015
+	resize2fsMinSizeRegexp = regexp.MustCompile(`(?m)^Estimated minimum size of the filesystem: (\d+)$`)
+
+	// Parsing output of: dumpe2fs -h <device>
+	//
+	// Example:
+	//   Block count:              262144
+	//   Block size:               4096
+	dumpe2fsBlockCountRegexp = regexp.MustCompile(`(?m)^Block count:[\t ]+(\d+)$`)
+	dumpe2fsBlockSizeRegexp  = regexp.MustCompile(`(?m)^Block size:[\t ]+(\d+)$`)
 )
 
-func shrinkFilesystems(imageLoopDevice string, verity []imagecustomizerapi.Verity,
-	partIdToPartUuid map[string]string,
+// shrinkFilesystems shrinks the filesystems, and their partitions, to their minimum size plus headroom bytes.
+// If discardFreedSpace is true, then the space that the filesystems no longer use is discarded, so that it reads back
+// as zeros.
+func shrinkFilesystems(imageLoopDevice string, storage *imagecustomizerapi.Storage,
+	partIdToPartUuid map[string]string, headroom uint64, discardFreedSpace bool,
 ) error {
 	logger.Log.Infof("Shrinking filesystems")
 
@@ -54,8 +71,21 @@ func shrinkFilesystems(imageLoopDevice string, verity []imagecustomizerapi.Verit
 			continue
 		}
 
+		// Don't shrink partitions that will be encrypted, since the LUKS header needs space in the partition.
+		if isEncryptedPartition(storage.EncryptedVolumes, diskPartition, partIdToPartUuid) {
+			logger.Log.Infof("Shrinking partition (%s): skipping encrypted partition", partitionLoopDevice)
+			continue
+		}
+
+		// Don't shrink the A/B update slots, since both slots must have the same size.
+		if storage.AbUpdate != nil &&
+			partitionMatchesDeviceId(storage.AbUpdate.SlotAPartitionId, diskPartition, partIdToPartUuid) {
+			logger.Log.Infof("Shrinking partition (%s): skipping A/B update slot partition", partitionLoopDevice)
+			continue
+		}
+
 		// Don't try to shrink verity hash partitions.
-		for _, verityItem := range verity {
+		for _, verityItem := range storage.Verity {
 			if partitionMatchesDeviceId(verityItem.HashDeviceId, diskPartition, partIdToPartUuid) {
 				logger.Log.Infof("Shrinking partition (%s): skipping verity hash partition", partitionLoopDevice)
 				continue
@@ -80,25 +110,51 @@ func shrinkFilesystems(imageLoopDevice string, verity []imagecustomizerapi.Verit
 			return fmt.Errorf("failed to check %s with e2fsck:\n%w", partitionLoopDevice, err)
 		}
 
-		// Shrink the file system with resize2fs -M
-		stdout, stderr, err := shell.Execute("flock", "--timeout", "5", imageLoopDevice,
-			"resize2fs", "-M", partitionLoopDevice)
+		// Shrink the file system with resize2fs -M, or to the min size plus the headroom.
+		resize2fsArgs := []string{"resize2fs", "-M", partitionLoopDevice}
+		if headroom > 0 {
+			targetBlockCount, err := getShrinkTargetBlockCount(partitionLoopDevice, headroom)
+			if err != nil {
+				return err
+			}
+
+			if targetBlockCount == 0 {
+				logger.Log.Infof("Filesystem is already within its headroom (%s)", partitionLoopDevice)
+				continue
+			}
+
+			resize2fsArgs = []string{"resize2fs", partitionLoopDevice, strconv.FormatUint(targetBlockCount, 10)}
+		}
+
+		stdout, stderr, err := shell.Execute("flock", append([]string{"--timeout", "5", imageLoopDevice},
+			resize2fsArgs...)...)
 		if err != nil {
 			return fmt.Errorf("failed to resize %s with resize2fs (and flock):\n%v", partitionLoopDevice, stderr)
 		}
 
-		// Find the new partition end value
-		end, err := getNewPartitionEndInSectors(stdout, stderr, startSector, imageLoopDevice)
+		filesystemSizeInSectors, err := getFilesystemSizeInSectors(stdout, stderr, imageLoopDevice)
 		if err != nil {
-			return fmt.Errorf("failed to calculate new partition end:\n%w", err)
+			return fmt.Errorf("failed to get filesystem size:\n%w", err)
 		}
 
-		if end == "" {
+		if filesystemSizeInSectors < 0 {
 			// Filesystem wasn't resized. So, there is no need to resize the partition.
 			logger.Log.Infof("Filesystem is already at its min size (%s)", partitionLoopDevice)
 			continue
 		}
 
+		if discardFreedSpace {
+			// Discard the tail of the partition before the partition is resized, while it can still be addressed
+			// through the partition's device.
+			err = discardPartitionTail(imageLoopDevice, partitionLoopDevice, filesystemSizeInSectors)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Find the new partition end value
+		end := strconv.Itoa(startSector+filesystemSizeInSectors) + "s"
+
 		// Resize the partition with parted resizepart
 		_, stderr, err = shell.ExecuteWithStdin("yes" /*stdin*/, "flock", "--timeout", "5", imageLoopDevice,
 			"parted", "---pretend-input-tty", imageLoopDevice, "resizepart",
@@ -220,28 +276,6 @@ func getFilesystemSizeInSectors(resize2fsStdout string, resize2fsStderr string,
 	return filesystemSizeInSectors, nil
 }
 
-// Get the new partition end in sectors.
-// Returns an empty string if the resize was a no-op.
-func getNewPartitionEndInSectors(resize2fsStdout string, resize2fsStderr string, startSector int,
-	imageLoopDevice string,
-) (endInSectors string, err error) {
-	filesystemSizeInSectors, err := getFilesystemSizeInSectors(resize2fsStdout, resize2fsStderr, imageLoopDevice)
-	if err != nil {
-		return "", fmt.Errorf("failed to get filesystem size:\n%w", err)
-	}
-
-	if filesystemSizeInSectors < 0 {
-		// Resize operation was a no-op.
-		return "", nil
-	}
-
-	// Calculate the new end
-	end := startSector + filesystemSizeInSectors
-	// Convert to a string with sectors unit appended
-	endInSectors = strconv.Itoa(end) + "s"
-	return endInSectors, nil
-}
-
 // Checks if the provided fstype is supported by shrink filesystems.
 func supportedShrinkFsType(fstype string) (isSupported bool) {
 	switch fstype {
@@ -252,3 +286,93 @@ func supportedShrinkFsType(fstype string) (isSupported bool) {
 		return false
 	}
 }
+
+// getShrinkTargetBlockCount returns the block count that shrinks the filesystem to its minimum size plus the
+// headroom. Returns 0 if the filesystem is already no larger than that.
+func getShrinkTargetBlockCount(partitionDevice string, headroom uint64) (uint64, error) {
+	stdout, stderr, err := shell.Execute("resize2fs", "-P", partitionDevice)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get min size of %s with resize2fs:\n%v", partitionDevice, stderr)
+	}
+
+	minBlockCount, err := parseResize2fsMinSize(stdout)
+	if err != nil {
+		return 0, err
+	}
+
+	stdout, stderr, err = shell.Execute("dumpe2fs", "-h", partitionDevice)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read superblock of %s with dumpe2fs:\n%v", partitionDevice, stderr)
+	}
+
+	blockCount, blockSize, err := parseDumpe2fsBlocks(stdout)
+	if err != nil {
+		return 0, err
+	}
+
+	return calculateShrinkTargetBlockCount(minBlockCount, blockCount, blockSize, headroom), nil
+}
+
+func parseResize2fsMinSize(resize2fsStdout string) (uint64, error) {
+	match := resize2fsMinSizeRegexp.FindStringSubmatch(resize2fsStdout)
+	if match == nil {
+		return 0, fmt.Errorf("failed to parse min size from resize2fs output:\n%s", resize2fsStdout)
+	}
+
+	minBlockCount, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse min block count (%s):\n%w", match[1], err)
+	}
+
+	return minBlockCount, nil
+}
+
+func parseDumpe2fsBlocks(dumpe2fsStdout string) (blockCount uint64, blockSize uint64, err error) {
+	blockCountMatch := dumpe2fsBlockCountRegexp.FindStringSubmatch(dumpe2fsStdout)
+	blockSizeMatch := dumpe2fsBlockSizeRegexp.FindStringSubmatch(dumpe2fsStdout)
+	if blockCountMatch == nil || blockSizeMatch == nil {
+		return 0, 0, fmt.Errorf("failed to parse block count and size from dumpe2fs output")
+	}
+
+	blockCount, err = strconv.ParseUint(blockCountMatch[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse block count (%s):\n%w", blockCountMatch[1], err)
+	}
+
+	blockSize, err = strconv.ParseUint(blockSizeMatch[1], 10, 64)
+	if err != nil || blockSize == 0 {
+		return 0, 0, fmt.Errorf("failed to parse block size (%s)", blockSizeMatch[1])
+	}
+
+	return blockCount, blockSize, nil
+}
+
+// calculateShrinkTargetBlockCount returns the min block count plus the headroom (rounded up to whole blocks).
+// Returns 0 if that isn't smaller than the current block count.
+func calculateShrinkTargetBlockCount(minBlockCount uint64, blockCount uint64, blockSize uint64, headroom uint64,
+) uint64 {
+	targetBlockCount := minBlockCount + (headroom+blockSize-1)/blockSize
+	if targetBlockCount >= blockCount {
+		return 0
+	}
+
+	return targetBlockCount
+}
+
+// discardPartitionTail discards the part of the partition that comes after the filesystem.
+func discardPartitionTail(imageLoopDevice string, partitionDevice string, filesystemSizeInSectors int) error {
+	logicalSectorSize, _, err := diskutils.GetSectorSize(imageLoopDevice)
+	if err != nil {
+		return fmt.Errorf("failed to get sector size:\n%w", err)
+	}
+
+	offset := uint64(filesystemSizeInSectors) * logicalSectorSize
+
+	// Discarding is only an optimization. So, a device that doesn't support it isn't an error.
+	_, stderr, err := shell.Execute("blkdiscard", "--offset", strconv.FormatUint(offset, 10), partitionDevice)
+	if err != nil {
+		logger.Log.Warnf("Failed to discard freed space of partition (%s):\n%v", partitionDevice, stderr)
+	}
+
+	return nil
+}
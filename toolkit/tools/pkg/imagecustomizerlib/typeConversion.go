@@ -26,13 +26,14 @@ func bootTypeToImager(bootType imagecustomizerapi.BootType) (string, error) {
 }
 
 func diskConfigToImager(diskConfig imagecustomizerapi.Disk, fileSystems []imagecustomizerapi.FileSystem,
+	imageArch string,
 ) (configuration.Disk, error) {
 	imagerPartitionTableType, err := partitionTableTypeToImager(diskConfig.PartitionTableType)
 	if err != nil {
 		return configuration.Disk{}, err
 	}
 
-	imagerPartitions, err := partitionsToImager(diskConfig.Partitions, fileSystems)
+	imagerPartitions, err := partitionsToImager(diskConfig.Partitions, fileSystems, imageArch)
 	if err != nil {
 		return configuration.Disk{}, err
 	}
@@ -62,10 +63,11 @@ func partitionTableTypeToImager(partitionTableType imagecustomizerapi.PartitionT
 }
 
 func partitionsToImager(partitions []imagecustomizerapi.Partition, fileSystems []imagecustomizerapi.FileSystem,
+	imageArch string,
 ) ([]configuration.Partition, error) {
 	imagerPartitions := []configuration.Partition(nil)
 	for _, partition := range partitions {
-		imagerPartition, err := partitionToImager(partition, fileSystems)
+		imagerPartition, err := partitionToImager(partition, fileSystems, imageArch)
 		if err != nil {
 			return nil, err
 		}
@@ -77,6 +79,7 @@ func partitionsToImager(partitions []imagecustomizerapi.Partition, fileSystems [
 }
 
 func partitionToImager(partition imagecustomizerapi.Partition, fileSystems []imagecustomizerapi.FileSystem,
+	imageArch string,
 ) (configuration.Partition, error) {
 	fileSystem, _ := sliceutils.FindValueFunc(fileSystems,
 		func(fileSystem imagecustomizerapi.FileSystem) bool {
@@ -100,10 +103,25 @@ func partitionToImager(partition imagecustomizerapi.Partition, fileSystems []ima
 		return configuration.Partition{}, err
 	}
 
+	for _, flag := range partition.Flags {
+		imagerFlag, err := partitionFlagToImager(flag)
+		if err != nil {
+			return configuration.Partition{}, err
+		}
+
+		imagerFlags = append(imagerFlags, imagerFlag)
+	}
+
+	imagerType, err := partitionTypeToImager(partition.Type, imageArch)
+	if err != nil {
+		return configuration.Partition{}, err
+	}
+
 	imagerPartition := configuration.Partition{
 		ID:     partition.Id,
 		FsType: string(fileSystem.Type),
 		Name:   partition.Label,
+		Type:   imagerType,
 		Start:  uint64(imagerStart),
 		End:    uint64(imagerEnd),
 		Flags:  imagerFlags,
@@ -119,7 +137,10 @@ func toImagerPartitionFlags(partitionType imagecustomizerapi.PartitionType) ([]c
 	case imagecustomizerapi.PartitionTypeBiosGrub:
 		return []configuration.PartitionFlag{configuration.PartitionFlagBiosGrub}, nil
 
-	case imagecustomizerapi.PartitionTypeDefault:
+	case imagecustomizerapi.PartitionTypeDefault, imagecustomizerapi.PartitionTypeLinuxGeneric,
+		imagecustomizerapi.PartitionTypeRoot, imagecustomizerapi.PartitionTypeXbootldr,
+		imagecustomizerapi.PartitionTypeSwap, imagecustomizerapi.PartitionTypeHome, imagecustomizerapi.PartitionTypeSrv,
+		imagecustomizerapi.PartitionTypeVar, imagecustomizerapi.PartitionTypeTmp:
 		return nil, nil
 
 	default:
@@ -127,6 +148,67 @@ func toImagerPartitionFlags(partitionType imagecustomizerapi.PartitionType) ([]c
 	}
 }
 
+// partitionTypeToImager returns the name of the GPT partition type UUID to assign to the partition, of an image of the
+// given architecture (as a GOARCH value). See, configuration.PartitionTypeNameToUUID.
+func partitionTypeToImager(partitionType imagecustomizerapi.PartitionType, imageArch string) (string, error) {
+	switch partitionType {
+	case imagecustomizerapi.PartitionTypeDefault, imagecustomizerapi.PartitionTypeESP,
+		imagecustomizerapi.PartitionTypeBiosGrub:
+		// The type is either parted's default or is set by the partition's flags.
+		return "", nil
+
+	case imagecustomizerapi.PartitionTypeLinuxGeneric:
+		return "linux", nil
+
+	case imagecustomizerapi.PartitionTypeRoot:
+		switch imageArch {
+		case "amd64":
+			return "linux-root-amd64", nil
+		case "arm64":
+			return "linux-root-arm64", nil
+		default:
+			return "", fmt.Errorf("root partition type is not supported on architecture (%s)", imageArch)
+		}
+
+	case imagecustomizerapi.PartitionTypeXbootldr:
+		return "xbootldr", nil
+
+	case imagecustomizerapi.PartitionTypeSwap:
+		return "linux-swap", nil
+
+	case imagecustomizerapi.PartitionTypeHome:
+		return "linux-home", nil
+
+	case imagecustomizerapi.PartitionTypeSrv:
+		return "linux-srv", nil
+
+	case imagecustomizerapi.PartitionTypeVar:
+		return "linux-var", nil
+
+	case imagecustomizerapi.PartitionTypeTmp:
+		return "linux-tmp", nil
+
+	default:
+		return "", fmt.Errorf("unknown partition type (%s)", partitionType)
+	}
+}
+
+func partitionFlagToImager(flag imagecustomizerapi.PartitionFlag) (configuration.PartitionFlag, error) {
+	switch flag {
+	case imagecustomizerapi.PartitionFlagLegacyBoot:
+		return configuration.PartitionFlagLegacyBoot, nil
+
+	case imagecustomizerapi.PartitionFlagHidden:
+		return configuration.PartitionFlagHidden, nil
+
+	case imagecustomizerapi.PartitionFlagNoAutomount:
+		return configuration.PartitionFlagNoAutomount, nil
+
+	default:
+		return "", fmt.Errorf("unknown partition flag (%s)", flag)
+	}
+}
+
 func partitionSettingsToImager(fileSystems []imagecustomizerapi.FileSystem,
 ) ([]configuration.PartitionSetting, error) {
 	imagerPartitionSettings := []configuration.PartitionSetting(nil)
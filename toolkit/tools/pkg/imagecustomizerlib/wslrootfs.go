@@ -0,0 +1,153 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+)
+
+const (
+	wslRootfsDirName  = "wslrootfs"
+	wslRootfsFileName = "rootfs.tar.gz"
+
+	wslConfPath           = "/etc/wsl.conf"
+	wslConfUserSection    = "user"
+	wslConfDefaultUserKey = "default"
+
+	fstabPath = "/etc/fstab"
+
+	// WSL provides the root filesystem itself. So, the image's mounts would fail if they were kept.
+	wslFstabContents = "# The root filesystem is provided by WSL.\n"
+)
+
+var (
+	// Directories whose contents aren't included in the WSL rootfs. WSL provides its own kernel and doesn't use a
+	// bootloader. And the virtual filesystems are mounted by WSL.
+	wslExcludedDirContents = []string{
+		"/boot",
+		"/dev",
+		"/lib/modules",
+		"/proc",
+		"/run",
+		"/sys",
+		"/usr/lib/firmware",
+		"/usr/lib/modules",
+	}
+)
+
+func getWslRootfsFile(buildDir string) string {
+	return filepath.Join(buildDir, wslRootfsDirName, wslRootfsFileName)
+}
+
+// customizeWsl applies the WSL specific changes to the OS: writing the /etc/wsl.conf file and removing the image's
+// mounts.
+func customizeWsl(wsl *imagecustomizerapi.Wsl, rootDir string) error {
+	logger.Log.Infof("Configuring WSL")
+
+	err := file.Write(wslFstabContents, filepath.Join(rootDir, fstabPath))
+	if err != nil {
+		return fmt.Errorf("failed to write %s:\n%w", fstabPath, err)
+	}
+
+	if wsl == nil || (wsl.DefaultUser == "" && len(wsl.Conf) <= 0) {
+		return nil
+	}
+
+	conf := wsl.Conf
+	if wsl.DefaultUser != "" {
+		_, err := userutils.GetPasswdFileEntryForUser(rootDir, wsl.DefaultUser)
+		if err != nil {
+			return fmt.Errorf("invalid WSL defaultUser (%s):\n%w", wsl.DefaultUser, err)
+		}
+
+		conf = make(map[string]map[string]string, len(wsl.Conf)+1)
+		for section, keys := range wsl.Conf {
+			conf[section] = keys
+		}
+
+		userKeys := make(map[string]string, len(conf[wslConfUserSection])+1)
+		for key, value := range conf[wslConfUserSection] {
+			userKeys[key] = value
+		}
+		userKeys[wslConfDefaultUserKey] = wsl.DefaultUser
+		conf[wslConfUserSection] = userKeys
+	}
+
+	wslConfFile := filepath.Join(rootDir, wslConfPath)
+	err = file.Write(formatWslConf(conf), wslConfFile)
+	if err != nil {
+		return fmt.Errorf("failed to write %s:\n%w", wslConfPath, err)
+	}
+
+	err = os.Chmod(wslConfFile, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to set permissions of %s:\n%w", wslConfPath, err)
+	}
+
+	return nil
+}
+
+// formatWslConf formats the contents of a wsl.conf file. Sections and keys are sorted, so that the file is
+// consistent between builds.
+func formatWslConf(conf map[string]map[string]string) string {
+	sections := make([]string, 0, len(conf))
+	for section := range conf {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	builder := strings.Builder{}
+	for i, section := range sections {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+
+		fmt.Fprintf(&builder, "[%s]\n", section)
+
+		keys := make([]string, 0, len(conf[section]))
+		for key := range conf[section] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(&builder, "%s=%s\n", key, conf[section][key])
+		}
+	}
+
+	return builder.String()
+}
+
+// writeWslRootfsFile writes the OS's root filesystem, without the kernel and bootloader files, to a gzipped tarball
+// that can be imported by 'wsl --import'.
+func writeWslRootfsFile(buildDir string, rootDir string) error {
+	logger.Log.Infof("Writing WSL rootfs")
+
+	rootfsFile := getWslRootfsFile(buildDir)
+	err := os.MkdirAll(filepath.Dir(rootfsFile), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create WSL rootfs directory:\n%w", err)
+	}
+
+	paths, err := listRootfsPaths(rootDir, wslExcludedDirContents)
+	if err != nil {
+		return fmt.Errorf("failed to list root filesystem files:\n%w", err)
+	}
+
+	err = writeContainerLayer(rootDir, rootfsFile, paths, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write WSL rootfs:\n%w", err)
+	}
+
+	return nil
+}
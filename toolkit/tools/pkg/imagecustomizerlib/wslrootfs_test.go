@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagecustomizerlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatWslConf(t *testing.T) {
+	conf := map[string]map[string]string{
+		"user": {"default": "alice"},
+		"boot": {"systemd": "true", "command": "echo hi"},
+	}
+
+	assert.Equal(t, "[boot]\ncommand=echo hi\nsystemd=true\n\n[user]\ndefault=alice\n", formatWslConf(conf))
+	assert.Equal(t, "", formatWslConf(nil))
+}
+
+func TestCustomizeWsl(t *testing.T) {
+	rootDir := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(rootDir, "etc"), 0o755)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "etc/passwd"),
+		[]byte("root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\n"), 0o644)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(rootDir, "etc/fstab"), []byte("PARTUUID=1234 / ext4 defaults 0 1\n"), 0o644)
+	require.NoError(t, err)
+
+	wsl := &imagecustomizerapi.Wsl{
+		DefaultUser: "alice",
+		Conf: map[string]map[string]string{
+			"boot": {"systemd": "true"},
+		},
+	}
+
+	err = customizeWsl(wsl, rootDir)
+	assert.NoError(t, err)
+
+	wslConf, err := os.ReadFile(filepath.Join(rootDir, "etc/wsl.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[boot]\nsystemd=true\n\n[user]\ndefault=alice\n", string(wslConf))
+
+	fstab, err := os.ReadFile(filepath.Join(rootDir, "etc/fstab"))
+	assert.NoError(t, err)
+	assert.Equal(t, wslFstabContents, string(fstab))
+
+	// The config isn't modified.
+	assert.NotContains(t, wsl.Conf, "user")
+
+	err = customizeWsl(&imagecustomizerapi.Wsl{DefaultUser: "bob"}, rootDir)
+	assert.ErrorContains(t, err, "invalid WSL defaultUser (bob)")
+}
+
+func TestListWslRootfsPaths(t *testing.T) {
+	rootDir := t.TempDir()
+
+	for _, dir := range []string{"boot/efi", "etc", "usr/lib/modules/6.6", "usr/lib/systemd"} {
+		err := os.MkdirAll(filepath.Join(rootDir, dir), 0o755)
+		require.NoError(t, err)
+	}
+
+	err := os.WriteFile(filepath.Join(rootDir, "boot/vmlinuz"), []byte{}, 0o644)
+	require.NoError(t, err)
+
+	paths, err := listRootfsPaths(rootDir, wslExcludedDirContents)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/boot", "/etc", "/usr", "/usr/lib", "/usr/lib/modules", "/usr/lib/systemd"}, paths)
+}
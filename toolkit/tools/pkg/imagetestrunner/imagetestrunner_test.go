@@ -0,0 +1,145 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagetestrunner
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func TestLoadMatrix(t *testing.T) {
+	tmpDir := t.TempDir()
+	matrixFile := filepath.Join(tmpDir, "matrix.yaml")
+
+	err := file.Write(`baseImages:
+- name: core-efi-amd64
+  path: images/core-efi-amd64.vhdx
+  arch: amd64
+  firmware: /usr/share/OVMF/OVMF_CODE.fd
+- name: core-efi-arm64
+  path: /images/core-efi-arm64.vhdx
+  arch: arm64
+`, matrixFile)
+	assert.NoError(t, err)
+
+	matrix, err := LoadMatrix(matrixFile)
+	assert.NoError(t, err)
+	assert.Len(t, matrix.BaseImages, 2)
+	assert.Equal(t, filepath.Join(tmpDir, "images/core-efi-amd64.vhdx"), matrix.BaseImages[0].Path)
+	assert.Equal(t, "/usr/share/OVMF/OVMF_CODE.fd", matrix.BaseImages[0].Firmware)
+	assert.Equal(t, "/images/core-efi-arm64.vhdx", matrix.BaseImages[1].Path)
+}
+
+func TestMatrixIsValidDuplicateName(t *testing.T) {
+	matrix := Matrix{
+		BaseImages: []BaseImage{
+			{Name: "a", Path: "a.vhdx", Arch: ArchAmd64},
+			{Name: "a", Path: "b.vhdx", Arch: ArchAmd64},
+		},
+	}
+
+	err := matrix.IsValid()
+	assert.ErrorContains(t, err, "duplicate base image name (a) found at index 1")
+}
+
+func TestMatrixIsValidBadArch(t *testing.T) {
+	matrix := Matrix{
+		BaseImages: []BaseImage{
+			{Name: "a", Path: "a.vhdx", Arch: "x86_64"},
+		},
+	}
+
+	err := matrix.IsValid()
+	assert.ErrorContains(t, err, "invalid arch (x86_64)")
+}
+
+func TestMatrixIsValidEmpty(t *testing.T) {
+	matrix := Matrix{}
+
+	err := matrix.IsValid()
+	assert.ErrorContains(t, err, "at least one base image must be specified")
+}
+
+func TestFindConfigs(t *testing.T) {
+	configDir := t.TempDir()
+
+	for _, name := range []string{"b.yml", "a.yaml", "README.md"} {
+		err := file.Write("", filepath.Join(configDir, name))
+		assert.NoError(t, err)
+	}
+
+	configFiles, err := FindConfigs(configDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(configDir, "a.yaml"), filepath.Join(configDir, "b.yml")}, configFiles)
+}
+
+func TestCreateJUnitReport(t *testing.T) {
+	results := []TestResult{
+		{ConfigName: "nginx", BaseImageName: "core-amd64", Duration: 2 * time.Second},
+		{ConfigName: "k8s", BaseImageName: "core-amd64", Duration: time.Second, BuildErr: errors.New("no space")},
+		{ConfigName: "nginx", BaseImageName: "core-arm64", Duration: 1500 * time.Millisecond,
+			SmokeTestErr: errors.New("timed out")},
+	}
+
+	report := CreateJUnitReport(results)
+	assert.Equal(t, 3, report.Tests)
+	assert.Equal(t, 2, report.Failures)
+	assert.Equal(t, "4.500", report.Time)
+	assert.Len(t, report.Suites, 2)
+
+	assert.Equal(t, "core-amd64", report.Suites[0].Name)
+	assert.Equal(t, 2, report.Suites[0].Tests)
+	assert.Equal(t, 1, report.Suites[0].Failures)
+	assert.Equal(t, "3.000", report.Suites[0].Time)
+	assert.Nil(t, report.Suites[0].TestCases[0].Failure)
+	assert.Equal(t, junitFailureTypeBuild, report.Suites[0].TestCases[1].Failure.Type)
+	assert.Equal(t, "no space", report.Suites[0].TestCases[1].Failure.Contents)
+
+	assert.Equal(t, junitFailureTypeSmokeTest, report.Suites[1].TestCases[0].Failure.Type)
+
+	reportFile := filepath.Join(t.TempDir(), "report.xml")
+	err := WriteJUnitReport(report, reportFile)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(reportFile)
+	assert.NoError(t, err)
+
+	var readReport JUnitTestSuites
+	err = xml.Unmarshal(data, &readReport)
+	assert.NoError(t, err)
+	assert.Equal(t, report.Suites, readReport.Suites)
+}
+
+func TestBuildQemuCommand(t *testing.T) {
+	baseImage := BaseImage{Name: "a", Path: "a.vhdx", Arch: ArchAmd64, Firmware: "/OVMF.fd"}
+
+	command, args, err := buildQemuCommand("/out/a.vhd", "vhd", baseImage)
+	assert.NoError(t, err)
+	assert.Equal(t, "qemu-system-x86_64", command)
+	assert.Contains(t, args, "file=/out/a.vhd,format=vpc,if=virtio,snapshot=on")
+	assert.Contains(t, args, "/OVMF.fd")
+
+	_, args, err = buildQemuCommand("/out/a.iso", "iso", baseImage)
+	assert.NoError(t, err)
+	assert.Contains(t, args, "file=/out/a.iso,media=cdrom,readonly=on")
+
+	baseImage.Arch = ArchArm64
+	baseImage.Firmware = ""
+	_, _, err = buildQemuCommand("/out/a.qcow2", "qcow2", baseImage)
+	assert.ErrorContains(t, err, "firmware must be specified")
+}
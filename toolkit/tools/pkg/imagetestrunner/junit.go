@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagetestrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	junitFailureTypeBuild     = "build"
+	junitFailureTypeSmokeTest = "smoke-test"
+)
+
+// JUnitTestSuites is the root element of a JUnit XML report.
+type JUnitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+}
+
+type JUnitFailure struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// CreateJUnitReport creates a JUnit report from the test results, with one test suite per base image.
+func CreateJUnitReport(results []TestResult) *JUnitTestSuites {
+	report := &JUnitTestSuites{
+		Name: "imagetestrunner",
+	}
+
+	suiteIndexes := make(map[string]int)
+	suiteDurations := make(map[string]time.Duration)
+	totalDuration := time.Duration(0)
+
+	for _, result := range results {
+		suiteIndex, exists := suiteIndexes[result.BaseImageName]
+		if !exists {
+			suiteIndex = len(report.Suites)
+			suiteIndexes[result.BaseImageName] = suiteIndex
+			report.Suites = append(report.Suites, JUnitTestSuite{Name: result.BaseImageName})
+		}
+
+		suite := &report.Suites[suiteIndex]
+
+		testCase := JUnitTestCase{
+			Name:      result.ConfigName,
+			ClassName: result.BaseImageName,
+			Time:      formatJUnitDuration(result.Duration),
+		}
+
+		switch {
+		case result.BuildErr != nil:
+			testCase.Failure = &JUnitFailure{
+				Message:  "image build failed",
+				Type:     junitFailureTypeBuild,
+				Contents: result.BuildErr.Error(),
+			}
+
+		case result.SmokeTestErr != nil:
+			testCase.Failure = &JUnitFailure{
+				Message:  "smoke test failed",
+				Type:     junitFailureTypeSmokeTest,
+				Contents: result.SmokeTestErr.Error(),
+			}
+		}
+
+		suite.Tests++
+		report.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+			report.Failures++
+		}
+
+		suite.TestCases = append(suite.TestCases, testCase)
+		suiteDurations[result.BaseImageName] += result.Duration
+		totalDuration += result.Duration
+	}
+
+	for i := range report.Suites {
+		report.Suites[i].Time = formatJUnitDuration(suiteDurations[report.Suites[i].Name])
+	}
+	report.Time = formatJUnitDuration(totalDuration)
+
+	return report
+}
+
+// WriteJUnitReport writes a JUnit report to a file.
+func WriteJUnitReport(report *JUnitTestSuites, reportFile string) error {
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize JUnit report:\n%w", err)
+	}
+
+	data = append([]byte(xml.Header), data...)
+	data = append(data, '\n')
+
+	err = os.WriteFile(reportFile, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write JUnit report (%s):\n%w", reportFile, err)
+	}
+
+	return nil
+}
+
+func formatJUnitDuration(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
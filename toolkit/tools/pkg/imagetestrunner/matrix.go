@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagetestrunner
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/qemu"
+)
+
+const (
+	ArchAmd64 = qemu.ArchAmd64
+	ArchArm64 = qemu.ArchArm64
+)
+
+// Matrix lists the base images that each config is built against.
+type Matrix struct {
+	BaseImages []BaseImage `yaml:"baseImages"`
+}
+
+// BaseImage is a single base image in the test matrix.
+type BaseImage struct {
+	// Name identifies the base image in the test report.
+	Name string `yaml:"name"`
+	// Path is the path of the base image file. Relative paths are relative to the matrix file.
+	Path string `yaml:"path"`
+	// Arch is the architecture of the base image.
+	Arch string `yaml:"arch"`
+	// Firmware is the path of the UEFI firmware to boot the image with during the smoke test.
+	// If not specified, the image is booted using the VM's default BIOS.
+	Firmware string `yaml:"firmware"`
+}
+
+// LoadMatrix reads a test matrix file.
+func LoadMatrix(matrixFile string) (*Matrix, error) {
+	var matrix Matrix
+	err := imagecustomizerapi.UnmarshalYamlFile(matrixFile, &matrix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load test matrix file (%s):\n%w", matrixFile, err)
+	}
+
+	matrixDir := filepath.Dir(matrixFile)
+	for i := range matrix.BaseImages {
+		baseImage := &matrix.BaseImages[i]
+		baseImage.Path = file.GetAbsPathWithBase(matrixDir, baseImage.Path)
+		if baseImage.Firmware != "" {
+			baseImage.Firmware = file.GetAbsPathWithBase(matrixDir, baseImage.Firmware)
+		}
+	}
+
+	return &matrix, nil
+}
+
+func (m *Matrix) IsValid() error {
+	if len(m.BaseImages) == 0 {
+		return fmt.Errorf("at least one base image must be specified")
+	}
+
+	names := make(map[string]bool)
+	for i, baseImage := range m.BaseImages {
+		err := baseImage.IsValid()
+		if err != nil {
+			return fmt.Errorf("invalid baseImages item at index %d:\n%w", i, err)
+		}
+
+		if _, exists := names[baseImage.Name]; exists {
+			return fmt.Errorf("duplicate base image name (%s) found at index %d", baseImage.Name, i)
+		}
+		names[baseImage.Name] = true
+	}
+
+	return nil
+}
+
+func (b *BaseImage) IsValid() error {
+	if b.Name == "" || b.Name != filepath.Base(b.Name) {
+		return fmt.Errorf("invalid name (%s): must be non-empty and not contain '/'", b.Name)
+	}
+
+	if b.Path == "" {
+		return fmt.Errorf("path must be specified")
+	}
+
+	switch b.Arch {
+	case ArchAmd64, ArchArm64:
+		// All good.
+
+	default:
+		return fmt.Errorf("invalid arch (%s): must be one of (%s, %s)", b.Arch, ArchAmd64, ArchArm64)
+	}
+
+	return nil
+}
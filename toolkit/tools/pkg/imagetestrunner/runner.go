@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagetestrunner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/imagecustomizerlib"
+)
+
+// Options controls how each config in the test matrix is built and tested.
+type Options struct {
+	BuildDir             string
+	OutputDir            string
+	OutputImageFormat    string
+	RpmSources           []string
+	UseBaseImageRpmRepos bool
+	// KeepOutputImages keeps the built images instead of deleting them once they have been tested.
+	KeepOutputImages bool
+	SmokeTest        SmokeTestOptions
+}
+
+// TestResult is the result of building (and optionally smoke testing) a single config against a single base image.
+type TestResult struct {
+	ConfigName    string
+	BaseImageName string
+	Duration      time.Duration
+	BuildErr      error
+	SmokeTestErr  error
+}
+
+func (r *TestResult) Failed() bool {
+	return r.BuildErr != nil || r.SmokeTestErr != nil
+}
+
+// FindConfigs returns the image config files in a directory.
+func FindConfigs(configDir string) ([]string, error) {
+	entries, err := os.ReadDir(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory (%s):\n%w", configDir, err)
+	}
+
+	configFiles := []string(nil)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml":
+			configFiles = append(configFiles, filepath.Join(configDir, entry.Name()))
+		}
+	}
+
+	if len(configFiles) == 0 {
+		return nil, fmt.Errorf("no config files (*.yaml, *.yml) found in (%s)", configDir)
+	}
+
+	sort.Strings(configFiles)
+	return configFiles, nil
+}
+
+// Run builds each config against each base image in the matrix.
+//
+// A failure of one build does not stop the other builds from running. Instead, the failures are recorded in the
+// returned results.
+func Run(matrix *Matrix, configFiles []string, options Options) []TestResult {
+	results := []TestResult(nil)
+
+	for _, baseImage := range matrix.BaseImages {
+		for _, configFile := range configFiles {
+			result := runTest(baseImage, configFile, options)
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+func runTest(baseImage BaseImage, configFile string, options Options) (result TestResult) {
+	configName := strings.TrimSuffix(filepath.Base(configFile), filepath.Ext(configFile))
+
+	result = TestResult{
+		ConfigName:    configName,
+		BaseImageName: baseImage.Name,
+	}
+
+	logger.Log.Infof("Testing config (%s) against base image (%s)", configName, baseImage.Name)
+
+	startTime := time.Now()
+	defer func() {
+		result.Duration = time.Since(startTime)
+	}()
+
+	buildDir := filepath.Join(options.BuildDir, baseImage.Name, configName)
+	outputImageDir := filepath.Join(options.OutputDir, baseImage.Name, configName)
+	outputImageFile := filepath.Join(outputImageDir, configName+"."+options.OutputImageFormat)
+
+	defer func() {
+		err := os.RemoveAll(buildDir)
+		if err != nil {
+			logger.Log.Warnf("Failed to clean up build directory (%s): %v", buildDir, err)
+		}
+	}()
+
+	err := imagecustomizerlib.CustomizeImageWithConfigFile(buildDir, configFile, baseImage.Path,
+		options.RpmSources, outputImageFile, options.OutputImageFormat, "", /*outputSplitPartitionsFormat*/
+		"" /*outputPXEArtifactsDir*/, options.UseBaseImageRpmRepos, false /*enableShrinkFilesystems*/)
+	if err != nil {
+		logger.Log.Errorf("Build of config (%s) against base image (%s) failed:\n%v", configName, baseImage.Name, err)
+		result.BuildErr = err
+		return result
+	}
+
+	if options.SmokeTest.Enabled {
+		err = RunSmokeTest(outputImageFile, options.OutputImageFormat, baseImage, options.SmokeTest)
+		if err != nil {
+			logger.Log.Errorf("Smoke test of config (%s) against base image (%s) failed:\n%v", configName,
+				baseImage.Name, err)
+			result.SmokeTestErr = err
+		}
+	}
+
+	if !options.KeepOutputImages {
+		err = os.RemoveAll(outputImageDir)
+		if err != nil {
+			logger.Log.Warnf("Failed to clean up output image directory (%s): %v", outputImageDir, err)
+		}
+	}
+
+	return result
+}
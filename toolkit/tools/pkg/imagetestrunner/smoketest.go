@@ -0,0 +1,41 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package imagetestrunner
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/qemu"
+)
+
+const (
+	DefaultSmokeTestTimeout = qemu.DefaultSmokeTestTimeout
+	// DefaultSmokeTestPattern matches the serial console login prompt.
+	DefaultSmokeTestPattern = qemu.DefaultSmokeTestPattern
+)
+
+// SmokeTestOptions controls the QEMU boot smoke test.
+type SmokeTestOptions struct {
+	Enabled bool
+	Timeout time.Duration
+	// Pattern is the regex that the serial console output must match for the boot to be considered successful.
+	Pattern *regexp.Regexp
+}
+
+// RunSmokeTest boots the image in a QEMU VM and waits for the serial console output to match the pattern.
+func RunSmokeTest(imageFile string, imageFormat string, baseImage BaseImage, options SmokeTestOptions) error {
+	return qemu.RunSmokeTest(imageFile, imageFormat, getQemuMachine(baseImage), options.Timeout, options.Pattern)
+}
+
+func buildQemuCommand(imageFile string, imageFormat string, baseImage BaseImage) (string, []string, error) {
+	return qemu.BuildCommand(imageFile, imageFormat, getQemuMachine(baseImage))
+}
+
+func getQemuMachine(baseImage BaseImage) qemu.Machine {
+	return qemu.Machine{
+		Arch:     baseImage.Arch,
+		Firmware: baseImage.Firmware,
+	}
+}
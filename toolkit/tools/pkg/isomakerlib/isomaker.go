@@ -39,6 +39,8 @@ const (
 
 // IsoMaker builds ISO images and populates them with packages and files required by the installer.
 type IsoMaker struct {
+	enableIso          bool                    // Flag deciding whether to generate the ISO image.
+	enablePxe          bool                    // Flag deciding whether to generate the PXE artifacts.
 	enableBiosBoot     bool                    // Flag deciding whether to include BIOS bootloaders or not in the generated ISO image.
 	enableRpmRepo      bool                    // Flag deciding whether to include the contents of the Rpm repo folder in the generated ISO image.
 	unattendedInstall  bool                    // Flag deciding if the installer should run in unattended mode.
@@ -57,6 +59,7 @@ type IsoMaker struct {
 	imageNameBase      string                  // Base name of the ISO to generate (no path, and no file extension).
 	imageNameTag       string                  // Optional user-supplied tag appended to the generated ISO's name.
 	repoSnapshotTime   string                  // tdnf repo snapshot time
+	pxeBaseUrl         string                  // URL the PXE artifacts are served from. If empty, a placeholder is used.
 	osFilesPath        string
 
 	isoMakerCleanUpTasks []func() error // List of clean-up tasks to perform at the end of the ISO generation process.
@@ -80,6 +83,7 @@ func NewIsoMaker(unattendedInstall bool, baseDirPath, buildDirPath, releaseVersi
 	}
 
 	isoMaker = &IsoMaker{
+		enableIso:          true,
 		enableBiosBoot:     true,
 		enableRpmRepo:      true,
 		unattendedInstall:  unattendedInstall,
@@ -116,6 +120,7 @@ func NewIsoMakerWithConfig(unattendedInstall, enableBiosBoot, enableRpmRepo bool
 	}
 
 	isoMaker = &IsoMaker{
+		enableIso:          true,
 		enableBiosBoot:     enableBiosBoot,
 		enableRpmRepo:      enableRpmRepo,
 		unattendedInstall:  unattendedInstall,
@@ -138,7 +143,8 @@ func NewIsoMakerWithConfig(unattendedInstall, enableBiosBoot, enableRpmRepo bool
 	return isoMaker, nil
 }
 
-// Make builds the ISO image to 'buildDirPath' with the packages included in the config JSON.
+// Make builds the ISO image and, if enabled, the PXE artifacts to 'buildDirPath' with the packages included in the
+// config JSON.
 func (im *IsoMaker) Make() (err error) {
 	defer func() {
 		cleanupErr := im.isoMakerCleanUp()
@@ -171,9 +177,18 @@ func (im *IsoMaker) Make() (err error) {
 		return err
 	}
 
-	err = im.buildIsoImage()
-	if err != nil {
-		return err
+	if im.enableIso {
+		err = im.buildIsoImage()
+		if err != nil {
+			return err
+		}
+	}
+
+	if im.enablePxe {
+		err = im.buildPxeArtifacts()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -211,9 +226,12 @@ func (im *IsoMaker) buildIsoImage() error {
 
 // prepareIsoBootLoaderFilesAndFolders copies the files required by the ISO's bootloader
 func (im *IsoMaker) prepareIsoBootLoaderFilesAndFolders() (err error) {
-	err = im.setUpIsoGrub2Bootloader()
-	if err != nil {
-		return err
+	// The PXE artifacts are booted by the network's bootloader instead.
+	if im.enableIso {
+		err = im.setUpIsoGrub2Bootloader()
+		if err != nil {
+			return err
+		}
 	}
 
 	err = im.createVmlinuzImage()
@@ -778,6 +796,11 @@ func (im *IsoMaker) initializePaths() (err error) {
 	return nil
 }
 
+// IsoImageFilePath returns the path of the ISO image that Make generates.
+func (im *IsoMaker) IsoImageFilePath() string {
+	return im.buildIsoImageFilePath()
+}
+
 // buildIsoImageFilePath gets the output ISO file path from the config JSON file name
 // and the image build environment.
 func (im *IsoMaker) buildIsoImageFilePath() string {
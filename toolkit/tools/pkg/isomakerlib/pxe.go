@@ -0,0 +1,276 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package isomakerlib
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	// OutputFormatIso builds an ISO image.
+	OutputFormatIso = "iso"
+	// OutputFormatPxe builds the PXE artifacts instead of an ISO image.
+	OutputFormatPxe = "pxe"
+	// OutputFormatIsoPxe builds both an ISO image and the PXE artifacts.
+	OutputFormatIsoPxe = "iso-pxe"
+
+	pxeKernelFile     = "vmlinuz"
+	pxeInitrdFile     = "initrd.img"
+	pxeMediaFile      = "media.squashfs"
+	pxeIpxeScriptFile = "boot.ipxe"
+	pxeGrubCfgFile    = "grub.cfg"
+
+	// pxeBaseUrlPlaceholder is written to the netboot configurations when no base URL is given, to be replaced when
+	// the artifacts are deployed to the HTTP server.
+	pxeBaseUrlPlaceholder = "http://pxe-base-url-place-holder"
+
+	// pxeMediaKernelArg tells the installer's initrd to download the media squashfs and mount it in place of the
+	// ISO's file system.
+	pxeMediaKernelArg = "azl.media.url"
+	// pxeMediaSha256KernelArg is the SHA-256 digest of the media squashfs, which the installer's initrd verifies
+	// before mounting the downloaded media, since it is served over plain HTTP.
+	pxeMediaSha256KernelArg = "azl.media.sha256"
+	// isoMediaKernelArg is a workaround only needed to boot the ISO on some hardware.
+	isoMediaKernelArg = "mariner.media"
+)
+
+// OutputFormats returns the supported output formats.
+func OutputFormats() []string {
+	return []string{OutputFormatIso, OutputFormatPxe, OutputFormatIsoPxe}
+}
+
+// SetOutputFormat selects whether Make builds an ISO image, the PXE artifacts or both. The PXE artifacts are
+// served from pxeBaseUrl, or from a placeholder URL to replace in the generated configurations if it is empty.
+func (im *IsoMaker) SetOutputFormat(outputFormat, pxeBaseUrl string) (err error) {
+	switch outputFormat {
+	case OutputFormatIso:
+		im.enableIso, im.enablePxe = true, false
+	case OutputFormatPxe:
+		im.enableIso, im.enablePxe = false, true
+	case OutputFormatIsoPxe:
+		im.enableIso, im.enablePxe = true, true
+	default:
+		return fmt.Errorf("unsupported output format (%s), expected one of %v", outputFormat, OutputFormats())
+	}
+
+	if pxeBaseUrl != "" {
+		if !im.enablePxe {
+			return fmt.Errorf("a PXE base URL requires the (%s) or (%s) output format", OutputFormatPxe, OutputFormatIsoPxe)
+		}
+
+		_, _, err = parsePxeBaseUrl(pxeBaseUrl)
+		if err != nil {
+			return err
+		}
+	}
+
+	im.pxeBaseUrl = strings.TrimSuffix(pxeBaseUrl, "/")
+
+	return nil
+}
+
+// PxeOutputDirPath returns the path of the directory that Make writes the PXE artifacts to.
+func (im *IsoMaker) PxeOutputDirPath() string {
+	return strings.TrimSuffix(im.buildIsoImageFilePath(), ".iso") + "-pxe"
+}
+
+// PxeArtifactFilePaths returns the paths of the PXE artifacts that Make writes.
+func (im *IsoMaker) PxeArtifactFilePaths() []string {
+	pxeOutputDirPath := im.PxeOutputDirPath()
+
+	artifactFilePaths := []string{}
+	for _, artifactFile := range []string{pxeKernelFile, pxeInitrdFile, pxeMediaFile, pxeIpxeScriptFile, pxeGrubCfgFile} {
+		artifactFilePaths = append(artifactFilePaths, filepath.Join(pxeOutputDirPath, artifactFile))
+	}
+
+	return artifactFilePaths
+}
+
+// buildPxeArtifacts writes the PXE artifacts: the installer's kernel and initrd, a squashfs of the ISO's contents,
+// which the initrd mounts in place of the ISO, and iPXE and GRUB netboot configurations booting them.
+func (im *IsoMaker) buildPxeArtifacts() (err error) {
+	pxeOutputDirPath := im.PxeOutputDirPath()
+
+	logger.Log.Infof("Generating PXE artifacts under '%s'.", pxeOutputDirPath)
+
+	err = os.RemoveAll(pxeOutputDirPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale PXE artifacts '%s':\n%w", pxeOutputDirPath, err)
+	}
+
+	err = os.MkdirAll(pxeOutputDirPath, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create PXE output directory '%s':\n%w", pxeOutputDirPath, err)
+	}
+
+	for _, bootFile := range []string{pxeKernelFile, pxeInitrdFile} {
+		err = file.Copy(filepath.Join(im.buildDirPath, im.osFilesPath, bootFile), filepath.Join(pxeOutputDirPath, bootFile))
+		if err != nil {
+			return fmt.Errorf("failed to copy '%s' to the PXE artifacts:\n%w", bootFile, err)
+		}
+	}
+
+	mediaFilePath := filepath.Join(pxeOutputDirPath, pxeMediaFile)
+	err = im.createPxeMedia(mediaFilePath)
+	if err != nil {
+		return err
+	}
+
+	mediaSha256, err := file.GenerateSHA256(mediaFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to hash PXE media '%s':\n%w", mediaFilePath, err)
+	}
+
+	baseUrl := im.pxeBaseUrl
+	if baseUrl == "" {
+		logger.Log.Warnf("No PXE base URL set, replace '%s' in the netboot configurations with the URL serving '%s'.", pxeBaseUrlPlaceholder, pxeOutputDirPath)
+		baseUrl = pxeBaseUrlPlaceholder
+	}
+
+	isoGrubCfgPath := filepath.Join(im.buildDirPath, installutils.GrubCfgFile)
+	isoGrubCfg, err := os.ReadFile(isoGrubCfgPath)
+	if err != nil {
+		return fmt.Errorf("failed to read ISO's grub.cfg '%s':\n%w", isoGrubCfgPath, err)
+	}
+
+	kernelArgs, err := pxeKernelArgs(string(isoGrubCfg), baseUrl+"/"+pxeMediaFile, mediaSha256)
+	if err != nil {
+		return err
+	}
+
+	grubCfg, err := generatePxeGrubCfg(baseUrl, kernelArgs)
+	if err != nil {
+		return err
+	}
+
+	err = file.Write(generateIpxeScript(baseUrl, kernelArgs), filepath.Join(pxeOutputDirPath, pxeIpxeScriptFile))
+	if err != nil {
+		return fmt.Errorf("failed to write iPXE script:\n%w", err)
+	}
+
+	err = file.Write(grubCfg, filepath.Join(pxeOutputDirPath, pxeGrubCfgFile))
+	if err != nil {
+		return fmt.Errorf("failed to write PXE grub.cfg:\n%w", err)
+	}
+
+	return nil
+}
+
+// createPxeMedia packs the ISO's contents read by the installer (its config and RPMs) into a squashfs, leaving out
+// the bootloader files.
+func (im *IsoMaker) createPxeMedia(mediaFilePath string) (err error) {
+	logger.Log.Infof("Creating PXE media '%s'.", mediaFilePath)
+
+	mksquashfsArgs := []string{im.buildDirPath, mediaFilePath, "-noappend"}
+
+	// Paths excluded by mksquashfs are relative to the source directory, and must follow '-e' as the last arguments.
+	excludedPaths := []string{}
+	for _, bootPath := range []string{im.osFilesPath, "boot", "efi"} {
+		exists, err := file.PathExists(filepath.Join(im.buildDirPath, bootPath))
+		if err != nil {
+			return fmt.Errorf("failed to check if '%s' exists:\n%w", bootPath, err)
+		}
+		if exists {
+			excludedPaths = append(excludedPaths, bootPath)
+		}
+	}
+	if len(excludedPaths) > 0 {
+		mksquashfsArgs = append(mksquashfsArgs, "-e")
+		mksquashfsArgs = append(mksquashfsArgs, excludedPaths...)
+	}
+
+	err = shell.ExecuteLive(false /*squashErrors*/, "mksquashfs", mksquashfsArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to create PXE media:\n%w", err)
+	}
+
+	return nil
+}
+
+// pxeKernelArgs returns the kernel arguments of the first 'linux' command of the ISO's grub.cfg, pointing the
+// installer at the media served from mediaUrl instead of the ISO, which must have the SHA-256 digest mediaSha256.
+func pxeKernelArgs(isoGrubCfg, mediaUrl, mediaSha256 string) (kernelArgs string, err error) {
+	scanner := bufio.NewScanner(strings.NewReader(isoGrubCfg))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "linux" {
+			continue
+		}
+
+		args := []string{}
+		for _, arg := range fields[2:] {
+			if strings.HasPrefix(arg, isoMediaKernelArg+"=") || strings.HasPrefix(arg, pxeMediaKernelArg+"=") ||
+				strings.HasPrefix(arg, pxeMediaSha256KernelArg+"=") {
+				continue
+			}
+			args = append(args, arg)
+		}
+		args = append(args, fmt.Sprintf("%s=%s", pxeMediaKernelArg, mediaUrl),
+			fmt.Sprintf("%s=%s", pxeMediaSha256KernelArg, mediaSha256))
+
+		return strings.Join(args, " "), nil
+	}
+
+	return "", fmt.Errorf("failed to find the kernel command line in the ISO's grub.cfg")
+}
+
+// generateIpxeScript returns an iPXE script booting the installer from baseUrl.
+func generateIpxeScript(baseUrl, kernelArgs string) string {
+	return fmt.Sprintf(`#!ipxe
+
+dhcp
+kernel %[1]s/%[2]s initrd=%[3]s %[4]s
+initrd --name %[3]s %[1]s/%[3]s
+boot
+`, baseUrl, pxeKernelFile, pxeInitrdFile, kernelArgs)
+}
+
+// generatePxeGrubCfg returns a grub.cfg booting the installer over HTTP from baseUrl, for GRUB loaded from the
+// network.
+func generatePxeGrubCfg(baseUrl, kernelArgs string) (grubCfg string, err error) {
+	host, urlPath, err := parsePxeBaseUrl(baseUrl)
+	if err != nil {
+		return "", err
+	}
+
+	// GRUB reads files over HTTP from the '(http,<host>)' device.
+	grubPath := fmt.Sprintf("(http,%s)%s", host, strings.TrimSuffix(urlPath, "/"))
+
+	return fmt.Sprintf(`set timeout=0
+
+menuentry "Azure Linux" {
+    linux %[1]s/%[2]s %[4]s
+    initrd %[1]s/%[3]s
+}
+`, grubPath, pxeKernelFile, pxeInitrdFile, kernelArgs), nil
+}
+
+// parsePxeBaseUrl checks that the PXE artifacts can be served from baseUrl, and returns its host and path.
+func parsePxeBaseUrl(baseUrl string) (host, urlPath string, err error) {
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse PXE base URL (%s):\n%w", baseUrl, err)
+	}
+
+	// Both GRUB and the installer's initrd download the artifacts over plain HTTP.
+	if parsedUrl.Scheme != "http" || parsedUrl.Host == "" {
+		return "", "", fmt.Errorf("invalid PXE base URL (%s), expected 'http://<host>[/<path>]'", baseUrl)
+	}
+
+	if parsedUrl.RawQuery != "" || parsedUrl.Fragment != "" {
+		return "", "", fmt.Errorf("invalid PXE base URL (%s), query and fragment aren't supported", baseUrl)
+	}
+
+	return parsedUrl.Host, parsedUrl.Path, nil
+}
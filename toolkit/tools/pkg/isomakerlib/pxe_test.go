@@ -0,0 +1,99 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package isomakerlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testIsoGrubCfg = `set timeout=0
+
+menuentry "Azure Linux" {
+    search --label CDROM --set root
+    linux /isolinux/vmlinuz root=/dev/ram0 mariner.media=CDROM lockdown=integrity console=ttyS0,115200n8
+    initrd /isolinux/initrd.img
+}
+`
+
+const testMediaSha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestPxeKernelArgs(t *testing.T) {
+	kernelArgs, err := pxeKernelArgs(testIsoGrubCfg, "http://192.168.0.1/azl/media.squashfs", testMediaSha256)
+	assert.NoError(t, err)
+	assert.Equal(t, "root=/dev/ram0 lockdown=integrity console=ttyS0,115200n8 azl.media.url=http://192.168.0.1/azl/media.squashfs azl.media.sha256="+testMediaSha256, kernelArgs)
+}
+
+func TestPxeKernelArgsReplacesMediaArgs(t *testing.T) {
+	isoGrubCfg := "linux /isolinux/vmlinuz root=/dev/ram0 azl.media.url=http://old/media.squashfs azl.media.sha256=0123\n"
+
+	kernelArgs, err := pxeKernelArgs(isoGrubCfg, "http://192.168.0.1/media.squashfs", testMediaSha256)
+	assert.NoError(t, err)
+	assert.Equal(t, "root=/dev/ram0 azl.media.url=http://192.168.0.1/media.squashfs azl.media.sha256="+testMediaSha256, kernelArgs)
+}
+
+func TestPxeKernelArgsMissingLinuxCommand(t *testing.T) {
+	_, err := pxeKernelArgs("set timeout=0\n", "http://192.168.0.1/media.squashfs", testMediaSha256)
+	assert.Error(t, err)
+}
+
+func TestGenerateIpxeScript(t *testing.T) {
+	script := generateIpxeScript("http://192.168.0.1/azl", "root=/dev/ram0")
+	assert.Equal(t, `#!ipxe
+
+dhcp
+kernel http://192.168.0.1/azl/vmlinuz initrd=initrd.img root=/dev/ram0
+initrd --name initrd.img http://192.168.0.1/azl/initrd.img
+boot
+`, script)
+}
+
+func TestGeneratePxeGrubCfg(t *testing.T) {
+	grubCfg, err := generatePxeGrubCfg("http://192.168.0.1:8080/azl", "root=/dev/ram0")
+	assert.NoError(t, err)
+	assert.Contains(t, grubCfg, "linux (http,192.168.0.1:8080)/azl/vmlinuz root=/dev/ram0\n")
+	assert.Contains(t, grubCfg, "initrd (http,192.168.0.1:8080)/azl/initrd.img\n")
+}
+
+func TestGeneratePxeGrubCfgRootUrl(t *testing.T) {
+	grubCfg, err := generatePxeGrubCfg(pxeBaseUrlPlaceholder, "root=/dev/ram0")
+	assert.NoError(t, err)
+	assert.Contains(t, grubCfg, "linux (http,pxe-base-url-place-holder)/vmlinuz root=/dev/ram0\n")
+}
+
+func TestSetOutputFormat(t *testing.T) {
+	isoMaker := &IsoMaker{}
+
+	err := isoMaker.SetOutputFormat(OutputFormatIsoPxe, "http://192.168.0.1/azl/")
+	assert.NoError(t, err)
+	assert.True(t, isoMaker.enableIso)
+	assert.True(t, isoMaker.enablePxe)
+	assert.Equal(t, "http://192.168.0.1/azl", isoMaker.pxeBaseUrl)
+
+	err = isoMaker.SetOutputFormat(OutputFormatPxe, "")
+	assert.NoError(t, err)
+	assert.False(t, isoMaker.enableIso)
+	assert.True(t, isoMaker.enablePxe)
+}
+
+func TestSetOutputFormatInvalid(t *testing.T) {
+	isoMaker := &IsoMaker{}
+
+	assert.Error(t, isoMaker.SetOutputFormat("vhdx", ""))
+	assert.Error(t, isoMaker.SetOutputFormat(OutputFormatIso, "http://192.168.0.1/azl"))
+	assert.Error(t, isoMaker.SetOutputFormat(OutputFormatPxe, "https://192.168.0.1/azl"))
+	assert.Error(t, isoMaker.SetOutputFormat(OutputFormatPxe, "192.168.0.1/azl"))
+}
+
+func TestPxeOutputDirPath(t *testing.T) {
+	isoMaker := &IsoMaker{
+		outputDirPath:  "/out",
+		imageNameBase:  "full",
+		releaseVersion: "3.0",
+		imageNameTag:   "-test",
+	}
+
+	assert.Equal(t, "/out/full-3.0-test-pxe", isoMaker.PxeOutputDirPath())
+}
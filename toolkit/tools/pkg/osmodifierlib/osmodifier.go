@@ -12,27 +12,35 @@ import (
 )
 
 func ModifyOSWithConfigFile(configFile string) error {
-	var err error
+	osConfig, baseConfigPath, err := loadOSConfig(configFile)
+	if err != nil {
+		return err
+	}
 
-	var osConfig osmodifierapi.OS
-	err = imagecustomizerapi.UnmarshalYamlFile(configFile, &osConfig)
+	err = ModifyOS(baseConfigPath, osConfig)
 	if err != nil {
 		return err
 	}
 
-	baseConfigPath, _ := filepath.Split(configFile)
+	return nil
+}
 
-	absBaseConfigPath, err := filepath.Abs(baseConfigPath)
+// loadOSConfig reads an OS modification config file, returning it along with the absolute path of its directory.
+func loadOSConfig(configFile string) (*osmodifierapi.OS, string, error) {
+	var osConfig osmodifierapi.OS
+	err := imagecustomizerapi.UnmarshalYamlFile(configFile, &osConfig)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path of config file directory:\n%w", err)
+		return nil, "", err
 	}
 
-	err = ModifyOS(absBaseConfigPath, &osConfig)
+	baseConfigPath, _ := filepath.Split(configFile)
+
+	absBaseConfigPath, err := filepath.Abs(baseConfigPath)
 	if err != nil {
-		return err
+		return nil, "", fmt.Errorf("failed to get absolute path of config file directory:\n%w", err)
 	}
 
-	return nil
+	return &osConfig, absBaseConfigPath, nil
 }
 
 func ModifyOS(baseConfigPath string, osConfig *osmodifierapi.OS) error {
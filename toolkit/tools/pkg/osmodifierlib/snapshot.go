@@ -0,0 +1,658 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package osmodifierlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/userutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/osmodifierapi"
+)
+
+// SnapshotType is the mechanism that is used to snapshot the OS before it is modified, so that the modification can be
+// rolled back.
+//
+// There is no overlay snapshot type: the upper directory of a mounted overlayfs root can't be swapped safely while the
+// OS is running. A 'files' snapshot should be used for overlay roots instead.
+type SnapshotType string
+
+const (
+	// SnapshotTypeNone doesn't take a snapshot. The modification can't be rolled back.
+	SnapshotTypeNone SnapshotType = ""
+	// SnapshotTypeLvm takes an LVM snapshot of the root logical volume. Rolling back merges the snapshot into the
+	// root logical volume, which takes effect on the next boot.
+	SnapshotTypeLvm SnapshotType = "lvm"
+	// SnapshotTypeBtrfs takes a btrfs snapshot of the root subvolume. Rolling back makes the snapshot the default
+	// subvolume, which takes effect on the next boot.
+	SnapshotTypeBtrfs SnapshotType = "btrfs"
+	// SnapshotTypeFiles copies the files that the OS modifier changes. Rolling back copies the files back, which takes
+	// effect immediately.
+	SnapshotTypeFiles SnapshotType = "files"
+)
+
+// SnapshotTypes are the valid snapshot types that can be selected.
+var SnapshotTypes = []SnapshotType{SnapshotTypeLvm, SnapshotTypeBtrfs, SnapshotTypeFiles}
+
+type osTransactionState string
+
+const (
+	// The snapshot has been taken and the OS is being modified.
+	osTransactionStatePending osTransactionState = "pending"
+	// The OS was modified (and validated) successfully.
+	osTransactionStateApplied osTransactionState = "applied"
+	// The OS was rolled back to the snapshot.
+	osTransactionStateRolledBack osTransactionState = "rolledBack"
+)
+
+const (
+	// DefaultStateDir is the default directory the snapshot records are kept in. It must not be on the root
+	// filesystem for 'lvm' and 'btrfs' snapshots, since rolling back the root filesystem would also roll back the
+	// records.
+	DefaultStateDir = "/boot/osmodifier"
+
+	osTransactionFile    = "transaction.json"
+	filesSnapshotDirName = "snapshot"
+
+	btrfsSnapshotsDir = "/.osmodifier-snapshots"
+
+	lvmSnapshotSuffix = "_osmodifier"
+
+	// The default size of an LVM snapshot, as a percentage of the root logical volume.
+	DefaultLvmSnapshotSize = "20%ORIGIN"
+)
+
+// The files that the OS modifier may change, relative to the root directory.
+var osModifiedFiles = []string{
+	"etc/passwd",
+	"etc/shadow",
+	"etc/group",
+	"etc/gshadow",
+	"etc/hostname",
+	"etc/default/grub",
+	"etc/selinux/config",
+	"boot/grub2/grub.cfg",
+}
+
+// SnapshotOptions configures the snapshot that is taken before the OS is modified.
+type SnapshotOptions struct {
+	Type SnapshotType
+	// StateDir is the directory the snapshot records (and the copies of a 'files' snapshot) are kept in. Defaults to
+	// DefaultStateDir.
+	StateDir string
+	// LvmSize is the size of an LVM snapshot, as accepted by `lvcreate --extents`.
+	LvmSize string
+	// ValidationScript is run after the OS is modified. If it fails, then the OS is rolled back.
+	ValidationScript string
+}
+
+// osTransaction records a snapshot of the OS, so that the OS can be rolled back by a later invocation of the OS
+// modifier (e.g. after a reboot).
+type osTransaction struct {
+	Id           string             `json:"id"`
+	SnapshotType SnapshotType       `json:"snapshotType"`
+	State        osTransactionState `json:"state"`
+
+	LvmVolumeGroup    string `json:"lvmVolumeGroup,omitempty"`
+	LvmSnapshotVolume string `json:"lvmSnapshotVolume,omitempty"`
+
+	BtrfsSnapshotPath string `json:"btrfsSnapshotPath,omitempty"`
+
+	// The files that were snapshotted and whether or not they existed at the time.
+	Files map[string]bool `json:"files,omitempty"`
+	// The directories that may be created by the modification and whether or not they existed at the time.
+	Directories map[string]bool `json:"directories,omitempty"`
+}
+
+// ModifyOSWithConfigFileAndSnapshot is the same as ModifyOSWithConfigFile, except that a snapshot of the OS is taken
+// first. If the modification or the validation script fails, then the OS is rolled back to the snapshot.
+//
+// If updateGrub is set, then ModifyDefaultGrub is run first within the same snapshot, so that rolling back also undoes
+// it. configFile may be empty to only update the default grub config.
+func ModifyOSWithConfigFileAndSnapshot(configFile string, updateGrub bool, options SnapshotOptions) error {
+	osConfig := &osmodifierapi.OS{}
+	baseConfigPath := ""
+
+	if configFile != "" {
+		var err error
+		osConfig, baseConfigPath, err = loadOSConfig(configFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	paths, err := osModifiedPaths("/", osConfig)
+	if err != nil {
+		return err
+	}
+
+	return modifyWithSnapshot("/", options, paths, func() error {
+		if updateGrub {
+			err := ModifyDefaultGrub()
+			if err != nil {
+				return fmt.Errorf("update grub failed:\n%w", err)
+			}
+		}
+
+		if configFile == "" {
+			return nil
+		}
+
+		return ModifyOS(baseConfigPath, osConfig)
+	})
+}
+
+// RollbackOS rolls back the OS to the snapshot recorded in stateDir (DefaultStateDir if empty) that was taken before
+// the last modification.
+//
+// Returns true if the OS must be rebooted for the rollback to take effect.
+func RollbackOS(stateDir string) (bool, error) {
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+
+	transaction, err := readOsTransaction(stateDir)
+	if err != nil {
+		return false, err
+	}
+
+	return rollbackOsTransaction("/", stateDir, transaction)
+}
+
+// osSnapshotPaths are the paths, relative to the root directory, that a 'files' snapshot covers.
+type osSnapshotPaths struct {
+	Files       []string
+	Directories []string
+}
+
+// osModifiedPaths returns the paths that modifying the OS with osConfig may change: the system files and the home
+// directories and SSH keys of the configured users.
+func osModifiedPaths(rootDir string, osConfig *osmodifierapi.OS) (osSnapshotPaths, error) {
+	paths := osSnapshotPaths{
+		Files: append([]string(nil), osModifiedFiles...),
+	}
+
+	for _, user := range osConfig.Users {
+		homeDir, err := userHomeDirectory(rootDir, user)
+		if err != nil {
+			return osSnapshotPaths{}, err
+		}
+
+		sshDir := filepath.Join(homeDir, userutils.SSHDirectoryName)
+		paths.Directories = append(paths.Directories, relativeToRoot(homeDir), relativeToRoot(sshDir))
+		paths.Files = append(paths.Files, relativeToRoot(filepath.Join(sshDir, userutils.SSHAuthorizedKeysFileName)))
+	}
+
+	return paths, nil
+}
+
+// userHomeDirectory returns the home directory of an existing user, or the one that will be created for a new user.
+func userHomeDirectory(rootDir string, user imagecustomizerapi.User) (string, error) {
+	passwdExists, err := file.PathExists(filepath.Join(rootDir, userutils.PasswdFile))
+	if err != nil {
+		return "", err
+	}
+
+	if passwdExists {
+		entries, err := userutils.ReadPasswdFile(rootDir)
+		if err != nil {
+			return "", err
+		}
+
+		for _, entry := range entries {
+			if entry.Name == user.Name {
+				return entry.HomeDirectory, nil
+			}
+		}
+	}
+
+	if user.HomeDirectory != "" {
+		return user.HomeDirectory, nil
+	}
+
+	if user.Name == userutils.RootUser {
+		return userutils.RootHomeDir, nil
+	}
+
+	return filepath.Join(userutils.UserHomeDirPrefix, user.Name), nil
+}
+
+func relativeToRoot(path string) string {
+	return strings.TrimPrefix(filepath.Clean(path), "/")
+}
+
+func modifyWithSnapshot(rootDir string, options SnapshotOptions, paths osSnapshotPaths, modify func() error) error {
+	if options.StateDir == "" {
+		options.StateDir = DefaultStateDir
+	}
+
+	transaction, err := beginOsTransaction(rootDir, options, paths)
+	if err != nil {
+		return err
+	}
+
+	err = modify()
+	if err == nil && options.ValidationScript != "" {
+		logger.Log.Infof("Running validation script (%s)", options.ValidationScript)
+
+		err = shell.ExecuteLiveWithErr(1, options.ValidationScript)
+		if err != nil {
+			err = fmt.Errorf("validation script (%s) failed:\n%w", options.ValidationScript, err)
+		}
+	}
+
+	if err != nil {
+		logger.Log.Errorf("OS modification failed, rolling back to snapshot (%s)", transaction.Id)
+
+		rebootRequired, rollbackErr := rollbackOsTransaction(rootDir, options.StateDir, transaction)
+		if rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back OS:\n%w", rollbackErr))
+		}
+
+		if rebootRequired {
+			logger.Log.Warnf("The OS must be rebooted to complete the rollback")
+		}
+		return err
+	}
+
+	transaction.State = osTransactionStateApplied
+	err = writeOsTransaction(options.StateDir, transaction)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// beginOsTransaction takes a snapshot of the OS. The snapshot of the previous transaction (if any) is discarded first,
+// since only the last modification can be rolled back.
+func beginOsTransaction(rootDir string, options SnapshotOptions, paths osSnapshotPaths) (*osTransaction, error) {
+	if options.Type == SnapshotTypeLvm || options.Type == SnapshotTypeBtrfs {
+		err := checkStateDirOutsideRoot(rootDir, options.StateDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	previous, err := readOsTransaction(options.StateDir)
+	switch {
+	case err == nil:
+		if previous.State == osTransactionStatePending {
+			return nil, fmt.Errorf("a previous OS modification (%s) didn't complete (rollback first)", previous.Id)
+		}
+
+		err = discardSnapshot(options.StateDir, previous)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discard previous snapshot (%s):\n%w", previous.Id, err)
+		}
+
+	case errors.Is(err, os.ErrNotExist):
+
+	default:
+		return nil, err
+	}
+
+	transaction := &osTransaction{
+		Id:           time.Now().UTC().Format("20060102T150405Z"),
+		SnapshotType: options.Type,
+		State:        osTransactionStatePending,
+	}
+
+	logger.Log.Infof("Taking %s snapshot (%s) of OS", options.Type, transaction.Id)
+
+	switch options.Type {
+	case SnapshotTypeLvm:
+		err = createLvmSnapshot(transaction, options.LvmSize)
+
+	case SnapshotTypeBtrfs:
+		err = createBtrfsSnapshot(transaction)
+
+	case SnapshotTypeFiles:
+		err = createFilesSnapshot(rootDir, options.StateDir, transaction, paths)
+
+	default:
+		err = fmt.Errorf("unknown snapshot type (%s)", options.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take %s snapshot of OS:\n%w", options.Type, err)
+	}
+
+	// Record the snapshot before the OS is modified, so that the OS can still be rolled back if the OS modifier is
+	// interrupted.
+	err = writeOsTransaction(options.StateDir, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// checkStateDirOutsideRoot checks that the snapshot records aren't on the root filesystem, which would roll them back
+// along with the OS.
+func checkStateDirOutsideRoot(rootDir string, stateDir string) error {
+	rootInfo, err := os.Stat(rootDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat (%s):\n%w", rootDir, err)
+	}
+
+	// The state directory may not exist yet, so check its closest existing parent.
+	existingDir := stateDir
+	stateInfo, err := os.Stat(existingDir)
+	for errors.Is(err, os.ErrNotExist) && existingDir != filepath.Dir(existingDir) {
+		existingDir = filepath.Dir(existingDir)
+		stateInfo, err = os.Stat(existingDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat (%s):\n%w", existingDir, err)
+	}
+
+	rootStat, rootOk := rootInfo.Sys().(*syscall.Stat_t)
+	stateStat, stateOk := stateInfo.Sys().(*syscall.Stat_t)
+	if rootOk && stateOk && rootStat.Dev == stateStat.Dev {
+		return fmt.Errorf("snapshot state directory (%s) is on the root filesystem, which the snapshot rolls back "+
+			"(choose a state directory on another filesystem)", stateDir)
+	}
+
+	return nil
+}
+
+func rollbackOsTransaction(rootDir string, stateDir string, transaction *osTransaction) (bool, error) {
+	if transaction.State == osTransactionStateRolledBack {
+		return false, fmt.Errorf("OS modification (%s) has already been rolled back", transaction.Id)
+	}
+
+	logger.Log.Infof("Rolling back OS to %s snapshot (%s)", transaction.SnapshotType, transaction.Id)
+
+	var err error
+	rebootRequired := true
+	switch transaction.SnapshotType {
+	case SnapshotTypeLvm:
+		err = rollbackLvmSnapshot(transaction)
+
+	case SnapshotTypeBtrfs:
+		err = rollbackBtrfsSnapshot(transaction)
+
+	case SnapshotTypeFiles:
+		rebootRequired = false
+		err = rollbackFilesSnapshot(rootDir, stateDir, transaction)
+
+	default:
+		err = fmt.Errorf("unknown snapshot type (%s)", transaction.SnapshotType)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	transaction.State = osTransactionStateRolledBack
+	err = writeOsTransaction(stateDir, transaction)
+	if err != nil {
+		return false, err
+	}
+
+	return rebootRequired, nil
+}
+
+func discardSnapshot(stateDir string, transaction *osTransaction) error {
+	if transaction.State == osTransactionStateRolledBack {
+		// The snapshot was consumed by the rollback.
+		return nil
+	}
+
+	switch transaction.SnapshotType {
+	case SnapshotTypeLvm:
+		return shell.ExecuteLiveWithErr(1, "lvremove", "--yes",
+			transaction.LvmVolumeGroup+"/"+transaction.LvmSnapshotVolume)
+
+	case SnapshotTypeBtrfs:
+		return shell.ExecuteLiveWithErr(1, "btrfs", "subvolume", "delete", transaction.BtrfsSnapshotPath)
+
+	case SnapshotTypeFiles:
+		return os.RemoveAll(filepath.Join(stateDir, filesSnapshotDirName))
+
+	default:
+		return fmt.Errorf("unknown snapshot type (%s)", transaction.SnapshotType)
+	}
+}
+
+func createLvmSnapshot(transaction *osTransaction, size string) error {
+	rootSource, err := findRootMountValue("SOURCE")
+	if err != nil {
+		return err
+	}
+
+	stdout, stderr, err := shell.Execute("lvs", "--noheadings", "--options", "vg_name,lv_name", rootSource)
+	if err != nil {
+		return fmt.Errorf("root filesystem (%s) isn't on an LVM logical volume:\n%s\n%w", rootSource,
+			strings.TrimSpace(stderr), err)
+	}
+
+	volumeGroup, logicalVolume, err := parseLvsVolume(stdout)
+	if err != nil {
+		return err
+	}
+
+	if size == "" {
+		size = DefaultLvmSnapshotSize
+	}
+
+	transaction.LvmVolumeGroup = volumeGroup
+	transaction.LvmSnapshotVolume = logicalVolume + lvmSnapshotSuffix
+
+	return shell.ExecuteLiveWithErr(1, "lvcreate", "--snapshot", "--extents", size, "--name",
+		transaction.LvmSnapshotVolume, volumeGroup+"/"+logicalVolume)
+}
+
+// parseLvsVolume parses the volume group and logical volume names from the output of
+// `lvs --noheadings --options vg_name,lv_name <device>`.
+func parseLvsVolume(output string) (string, string, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected lvs output (%s)", strings.TrimSpace(output))
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// rollbackLvmSnapshot merges the snapshot back into the root logical volume. Since the root logical volume is in use,
+// LVM defers the merge until the logical volume is next activated (i.e. on the next boot).
+func rollbackLvmSnapshot(transaction *osTransaction) error {
+	return shell.ExecuteLiveWithErr(1, "lvconvert", "--merge",
+		transaction.LvmVolumeGroup+"/"+transaction.LvmSnapshotVolume)
+}
+
+func createBtrfsSnapshot(transaction *osTransaction) error {
+	fsType, err := findRootMountValue("FSTYPE")
+	if err != nil {
+		return err
+	}
+
+	if fsType != "btrfs" {
+		return fmt.Errorf("root filesystem is %s, not btrfs", fsType)
+	}
+
+	err = os.MkdirAll(btrfsSnapshotsDir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshots directory (%s):\n%w", btrfsSnapshotsDir, err)
+	}
+
+	transaction.BtrfsSnapshotPath = filepath.Join(btrfsSnapshotsDir, transaction.Id)
+
+	return shell.ExecuteLiveWithErr(1, "btrfs", "subvolume", "snapshot", "/", transaction.BtrfsSnapshotPath)
+}
+
+// rollbackBtrfsSnapshot makes the snapshot the subvolume that is mounted by default. This only takes effect if the
+// root filesystem is mounted without a 'subvol' or 'subvolid' option.
+func rollbackBtrfsSnapshot(transaction *osTransaction) error {
+	return shell.ExecuteLiveWithErr(1, "btrfs", "subvolume", "set-default", transaction.BtrfsSnapshotPath)
+}
+
+func findRootMountValue(column string) (string, error) {
+	stdout, stderr, err := shell.Execute("findmnt", "--noheadings", "--output", column, "--target", "/")
+	if err != nil {
+		return "", fmt.Errorf("failed to find root filesystem's %s:\n%s\n%w", column, strings.TrimSpace(stderr), err)
+	}
+
+	return strings.TrimSpace(stdout), nil
+}
+
+func createFilesSnapshot(rootDir string, stateDir string, transaction *osTransaction, paths osSnapshotPaths) error {
+	snapshotDir := filepath.Join(stateDir, filesSnapshotDirName)
+
+	err := os.RemoveAll(snapshotDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove old snapshot directory (%s):\n%w", snapshotDir, err)
+	}
+
+	transaction.Directories = make(map[string]bool)
+	for _, relativePath := range paths.Directories {
+		exists, err := file.DirExists(filepath.Join(rootDir, relativePath))
+		if err != nil {
+			return err
+		}
+
+		transaction.Directories[relativePath] = exists
+	}
+
+	transaction.Files = make(map[string]bool)
+	for _, relativePath := range paths.Files {
+		exists, err := file.PathExists(filepath.Join(rootDir, relativePath))
+		if err != nil {
+			return err
+		}
+
+		transaction.Files[relativePath] = exists
+		if !exists {
+			continue
+		}
+
+		err = copyFileWithOwner(filepath.Join(rootDir, relativePath), filepath.Join(snapshotDir, relativePath))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollbackFilesSnapshot copies the snapshotted files back and removes the files and directories that didn't exist
+// when the snapshot was taken.
+func rollbackFilesSnapshot(rootDir string, stateDir string, transaction *osTransaction) error {
+	snapshotDir := filepath.Join(stateDir, filesSnapshotDirName)
+
+	for relativePath, existed := range transaction.Files {
+		path := filepath.Join(rootDir, relativePath)
+
+		if !existed {
+			err := file.RemoveFileIfExists(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := copyFileWithOwner(filepath.Join(snapshotDir, relativePath), path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for relativePath, existed := range transaction.Directories {
+		if existed {
+			continue
+		}
+
+		path := filepath.Join(rootDir, relativePath)
+		err := os.RemoveAll(path)
+		if err != nil {
+			return fmt.Errorf("failed to remove directory (%s):\n%w", path, err)
+		}
+	}
+
+	err := os.RemoveAll(snapshotDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove snapshot directory (%s):\n%w", snapshotDir, err)
+	}
+
+	return nil
+}
+
+// copyFileWithOwner copies a file, keeping its permissions and owner.
+func copyFileWithOwner(src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat (%s):\n%w", src, err)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read (%s):\n%w", src, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create directory (%s):\n%w", filepath.Dir(dst), err)
+	}
+
+	err = file.WriteAtomicWithPerm(string(data), dst, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok && (int(stat.Uid) != os.Geteuid() || int(stat.Gid) != os.Getegid()) {
+		err = os.Chown(dst, int(stat.Uid), int(stat.Gid))
+		if err != nil {
+			return fmt.Errorf("failed to set owner of (%s):\n%w", dst, err)
+		}
+	}
+
+	return nil
+}
+
+func readOsTransaction(stateDir string) (*osTransaction, error) {
+	transactionFile := filepath.Join(stateDir, osTransactionFile)
+
+	transaction := &osTransaction{}
+	err := jsonutils.ReadJSONFile(transactionFile, transaction)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no OS snapshot has been taken (%s doesn't exist):\n%w", transactionFile, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OS transaction file (%s):\n%w", transactionFile, err)
+	}
+
+	return transaction, nil
+}
+
+func writeOsTransaction(stateDir string, transaction *osTransaction) error {
+	transactionFile := filepath.Join(stateDir, osTransactionFile)
+
+	data, err := json.MarshalIndent(transaction, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize OS transaction:\n%w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(transactionFile), 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create OS modifier state directory:\n%w", err)
+	}
+
+	err = file.WriteAtomicWithPerm(string(data)+"\n", transactionFile, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write OS transaction file (%s):\n%w", transactionFile, err)
+	}
+
+	return nil
+}
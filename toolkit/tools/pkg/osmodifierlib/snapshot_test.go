@@ -0,0 +1,161 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package osmodifierlib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagecustomizerapi"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/osmodifierapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func writeSnapshotTestFile(t *testing.T, rootDir string, relativePath string, contents string, perm os.FileMode) {
+	path := filepath.Join(rootDir, relativePath)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(path, []byte(contents), perm)
+	require.NoError(t, err)
+	err = os.Chmod(path, perm)
+	require.NoError(t, err)
+}
+
+func TestModifyWithFilesSnapshotRollsBackOnFailure(t *testing.T) {
+	rootDir := t.TempDir()
+	writeSnapshotTestFile(t, rootDir, "etc/hostname", "original\n", 0o644)
+	writeSnapshotTestFile(t, rootDir, "etc/shadow", "root:*:1::::::\n", 0o600)
+
+	stateDir := t.TempDir()
+	options := SnapshotOptions{Type: SnapshotTypeFiles, StateDir: stateDir}
+	err := modifyWithSnapshot(rootDir, options, osSnapshotPaths{Files: osModifiedFiles}, func() error {
+		writeSnapshotTestFile(t, rootDir, "etc/hostname", "modified\n", 0o644)
+		writeSnapshotTestFile(t, rootDir, "etc/shadow", "root:!:1::::::\n", 0o600)
+		writeSnapshotTestFile(t, rootDir, "etc/default/grub", "GRUB_TIMEOUT=0\n", 0o644)
+		return fmt.Errorf("modification failed")
+	})
+	assert.ErrorContains(t, err, "modification failed")
+
+	hostname, err := os.ReadFile(filepath.Join(rootDir, "etc/hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, "original\n", string(hostname))
+
+	shadowInfo, err := os.Stat(filepath.Join(rootDir, "etc/shadow"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), shadowInfo.Mode().Perm())
+
+	assert.NoFileExists(t, filepath.Join(rootDir, "etc/default/grub"))
+	assert.NoDirExists(t, filepath.Join(stateDir, filesSnapshotDirName))
+
+	transaction, err := readOsTransaction(stateDir)
+	require.NoError(t, err)
+	assert.Equal(t, osTransactionStateRolledBack, transaction.State)
+}
+
+func TestModifyWithFilesSnapshotThenRollback(t *testing.T) {
+	rootDir := t.TempDir()
+	writeSnapshotTestFile(t, rootDir, "etc/hostname", "original\n", 0o644)
+
+	stateDir := t.TempDir()
+	options := SnapshotOptions{Type: SnapshotTypeFiles, StateDir: stateDir}
+	err := modifyWithSnapshot(rootDir, options, osSnapshotPaths{Files: osModifiedFiles}, func() error {
+		writeSnapshotTestFile(t, rootDir, "etc/hostname", "modified\n", 0o644)
+		return nil
+	})
+	require.NoError(t, err)
+
+	transaction, err := readOsTransaction(stateDir)
+	require.NoError(t, err)
+	assert.Equal(t, osTransactionStateApplied, transaction.State)
+	assert.Equal(t, SnapshotTypeFiles, transaction.SnapshotType)
+
+	hostname, err := os.ReadFile(filepath.Join(rootDir, "etc/hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, "modified\n", string(hostname))
+
+	rebootRequired, err := rollbackOsTransaction(rootDir, stateDir, transaction)
+	require.NoError(t, err)
+	assert.False(t, rebootRequired)
+
+	hostname, err = os.ReadFile(filepath.Join(rootDir, "etc/hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, "original\n", string(hostname))
+
+	_, err = rollbackOsTransaction(rootDir, stateDir, transaction)
+	assert.ErrorContains(t, err, "has already been rolled back")
+}
+
+func TestModifyWithFilesSnapshotRollsBackUserPaths(t *testing.T) {
+	rootDir := t.TempDir()
+	writeSnapshotTestFile(t, rootDir, "etc/passwd", "root:x:0:0:root:/root:/bin/bash\n", 0o644)
+	writeSnapshotTestFile(t, rootDir, "root/.ssh/authorized_keys", "ssh-ed25519 original\n", 0o600)
+
+	paths, err := osModifiedPaths(rootDir, &osmodifierapi.OS{
+		Users: []imagecustomizerapi.User{{Name: "root"}, {Name: "test"}},
+	})
+	require.NoError(t, err)
+	assert.Subset(t, paths.Files, []string{"root/.ssh/authorized_keys", "home/test/.ssh/authorized_keys"})
+	assert.Equal(t, []string{"root", "root/.ssh", "home/test", "home/test/.ssh"}, paths.Directories)
+
+	stateDir := t.TempDir()
+	options := SnapshotOptions{Type: SnapshotTypeFiles, StateDir: stateDir}
+	err = modifyWithSnapshot(rootDir, options, paths, func() error {
+		writeSnapshotTestFile(t, rootDir, "root/.ssh/authorized_keys", "ssh-ed25519 modified\n", 0o600)
+		writeSnapshotTestFile(t, rootDir, "home/test/.ssh/authorized_keys", "ssh-ed25519 test\n", 0o600)
+		return fmt.Errorf("modification failed")
+	})
+	assert.ErrorContains(t, err, "modification failed")
+
+	authorizedKeys, err := os.ReadFile(filepath.Join(rootDir, "root/.ssh/authorized_keys"))
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-ed25519 original\n", string(authorizedKeys))
+	assert.NoDirExists(t, filepath.Join(rootDir, "home/test"))
+}
+
+func TestBeginOsTransactionStateDirOnRoot(t *testing.T) {
+	rootDir := t.TempDir()
+
+	options := SnapshotOptions{Type: SnapshotTypeLvm, StateDir: filepath.Join(rootDir, "var/lib/osmodifier")}
+	_, err := beginOsTransaction(rootDir, options, osSnapshotPaths{})
+	assert.ErrorContains(t, err, "is on the root filesystem")
+}
+
+func TestBeginOsTransactionPendingPrevious(t *testing.T) {
+	rootDir := t.TempDir()
+	stateDir := t.TempDir()
+
+	err := writeOsTransaction(stateDir, &osTransaction{
+		Id:           "20240101T000000Z",
+		SnapshotType: SnapshotTypeFiles,
+		State:        osTransactionStatePending,
+	})
+	require.NoError(t, err)
+
+	_, err = beginOsTransaction(rootDir, SnapshotOptions{Type: SnapshotTypeFiles, StateDir: stateDir}, osSnapshotPaths{})
+	assert.ErrorContains(t, err, "a previous OS modification (20240101T000000Z) didn't complete")
+}
+
+func TestReadOsTransactionMissing(t *testing.T) {
+	_, err := readOsTransaction(t.TempDir())
+	assert.ErrorContains(t, err, "no OS snapshot has been taken")
+}
+
+func TestParseLvsVolume(t *testing.T) {
+	volumeGroup, logicalVolume, err := parseLvsVolume("  rootvg root\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "rootvg", volumeGroup)
+	assert.Equal(t, "root", logicalVolume)
+
+	_, _, err = parseLvsVolume("\n")
+	assert.ErrorContains(t, err, "unexpected lvs output")
+}
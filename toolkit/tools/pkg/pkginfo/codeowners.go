@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkginfo
+
+import (
+	"path"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+)
+
+// CodeOwnersRule is a single pattern line from a CODEOWNERS file.
+type CodeOwnersRule struct {
+	Pattern string
+	Owners  []string
+}
+
+// CodeOwners holds the rules of a CODEOWNERS file, in file order.
+type CodeOwners struct {
+	Rules []CodeOwnersRule
+}
+
+// LoadCodeOwners parses a GitHub style CODEOWNERS file.
+func LoadCodeOwners(codeOwnersPath string) (codeOwners CodeOwners, err error) {
+	lines, err := file.ReadLines(codeOwnersPath)
+	if err != nil {
+		return
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		codeOwners.Rules = append(codeOwners.Rules, CodeOwnersRule{
+			Pattern: fields[0],
+			Owners:  fields[1:],
+		})
+	}
+
+	return
+}
+
+// OwnersOf returns the owners of a path relative to the repo root.
+// As with GitHub, the last matching rule takes precedence.
+func (c *CodeOwners) OwnersOf(repoRelativePath string) []string {
+	repoRelativePath = strings.TrimPrefix(path.Clean("/"+repoRelativePath), "/")
+
+	for i := len(c.Rules) - 1; i >= 0; i-- {
+		if codeOwnersPatternMatches(c.Rules[i].Pattern, repoRelativePath) {
+			return c.Rules[i].Owners
+		}
+	}
+
+	return nil
+}
+
+// codeOwnersPatternMatches implements the subset of gitignore matching rules used by CODEOWNERS files:
+//   - A leading '/' anchors the pattern to the repo root. Otherwise the pattern may match at any depth.
+//   - A trailing '/' (or a pattern that names a directory) matches everything under that directory.
+//   - '*' matches within a single path component.
+func codeOwnersPatternMatches(pattern string, repoRelativePath string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(repoRelativePath, "/")
+
+	startLimit := 0
+	if !anchored {
+		startLimit = len(pathParts) - 1
+	}
+
+	for start := 0; start <= startLimit; start++ {
+		if codeOwnersPartsMatch(patternParts, pathParts[start:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func codeOwnersPartsMatch(patternParts []string, pathParts []string) bool {
+	if len(patternParts) > len(pathParts) {
+		return false
+	}
+
+	for i, patternPart := range patternParts {
+		matched, err := path.Match(patternPart, pathParts[i])
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	// Matching a prefix of the path means the pattern named a parent directory.
+	return true
+}
@@ -0,0 +1,208 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package pkginfo builds and queries an index of package metadata (owners, license, upstream URL, build flags)
+// gathered from the spec tree.
+package pkginfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// Splits a license expression into individual license identifiers.
+var licenseSeparatorRegex = regexp.MustCompile(`(?i)\s+(?:and|or|with)\s+|[(),;/]`)
+
+// Database is an indexed collection of package metadata.
+type Database struct {
+	Packages []PackageInfo `json:"Packages"`
+
+	byName map[string][]int
+}
+
+// BuildDatabase scans the spec directories for spec files and builds a database from them.
+// If codeOwnersPath is not empty, the owners of each spec are looked up in the CODEOWNERS file. The spec paths are
+// made relative to repoRoot both for the owner lookup and when stored in the database.
+func BuildDatabase(specsDirs []string, codeOwnersPath string, repoRoot string) (db *Database, err error) {
+	var codeOwners CodeOwners
+	if codeOwnersPath != "" {
+		codeOwners, err = LoadCodeOwners(codeOwnersPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CODEOWNERS file (%s):\n%w", codeOwnersPath, err)
+		}
+	}
+
+	db = &Database{}
+	for _, specsDir := range specsDirs {
+		specPaths, err := filepath.Glob(filepath.Join(specsDir, "*", "*.spec"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, specPath := range specPaths {
+			info, err := ParseSpecMetadata(specPath)
+			if err != nil {
+				logger.Log.Warnf("Skipping spec (%s):\n%s", specPath, err)
+				continue
+			}
+
+			relativeSpecPath, err := filepath.Rel(repoRoot, specPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get path of spec (%s) relative to (%s):\n%w", specPath, repoRoot, err)
+			}
+
+			info.SpecPath = filepath.ToSlash(relativeSpecPath)
+			info.Owners = codeOwners.OwnersOf(info.SpecPath)
+			db.Packages = append(db.Packages, info)
+		}
+	}
+
+	sort.Slice(db.Packages, func(i, j int) bool {
+		if db.Packages[i].Name != db.Packages[j].Name {
+			return db.Packages[i].Name < db.Packages[j].Name
+		}
+		return db.Packages[i].SpecPath < db.Packages[j].SpecPath
+	})
+
+	db.buildIndex()
+
+	return db, nil
+}
+
+// LoadDatabase reads a database previously written by Save.
+func LoadDatabase(dbPath string) (db *Database, err error) {
+	db = &Database{}
+	err = jsonutils.ReadJSONFile(dbPath, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package info database (%s):\n%w", dbPath, err)
+	}
+
+	db.buildIndex()
+
+	return db, nil
+}
+
+// Save writes the database to a JSON file.
+func (db *Database) Save(dbPath string) (err error) {
+	err = os.MkdirAll(filepath.Dir(dbPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	return jsonutils.WriteJSONFile(dbPath, db)
+}
+
+// Get returns the metadata of the named package.
+// A package name may be defined by more than one spec (e.g. multiple versions of a toolchain), so all matching
+// entries are returned.
+func (db *Database) Get(name string) (infos []PackageInfo) {
+	for _, index := range db.byName[name] {
+		infos = append(infos, db.Packages[index])
+	}
+
+	return
+}
+
+// WhoOwns returns the owners of the named package.
+func (db *Database) WhoOwns(name string) (owners []string, err error) {
+	infos := db.Get(name)
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("package (%s) not found", name)
+	}
+
+	for _, info := range infos {
+		for _, owner := range info.Owners {
+			if !containsFold(owners, owner) {
+				owners = append(owners, owner)
+			}
+		}
+	}
+
+	return owners, nil
+}
+
+// Filter selects a subset of the database's packages. Empty fields match all packages.
+type Filter struct {
+	// License matches packages whose license expression contains the identifier (case-insensitive).
+	License string
+	// Owner matches packages owned by the given owner (case-insensitive).
+	Owner string
+	// BuildFlag matches packages declaring the named build conditional.
+	BuildFlag string
+}
+
+// List returns all packages matching the filter, sorted by name.
+func (db *Database) List(filter Filter) (packages []PackageInfo) {
+	for _, info := range db.Packages {
+		if filter.License != "" && !licenseMatches(info.License, filter.License) {
+			continue
+		}
+
+		if filter.Owner != "" && !containsFold(info.Owners, filter.Owner) {
+			continue
+		}
+
+		if filter.BuildFlag != "" && !hasBuildFlag(info.BuildFlags, filter.BuildFlag) {
+			continue
+		}
+
+		packages = append(packages, info)
+	}
+
+	return
+}
+
+func (db *Database) buildIndex() {
+	db.byName = make(map[string][]int, len(db.Packages))
+	for i, info := range db.Packages {
+		db.byName[info.Name] = append(db.byName[info.Name], i)
+	}
+}
+
+// licenseMatches checks if any identifier in the license expression is the requested license. Suffixes such as
+// "-only", "-or-later" and "+" are ignored, so "GPL-3.0" matches both "GPL-3.0-only" and "GPL-3.0+".
+func licenseMatches(licenseExpression string, license string) bool {
+	for _, identifier := range licenseSeparatorRegex.Split(licenseExpression, -1) {
+		identifier = strings.TrimSpace(identifier)
+		if strings.EqualFold(identifier, license) {
+			return true
+		}
+
+		lowerIdentifier := strings.ToLower(identifier)
+		lowerLicense := strings.ToLower(license)
+		for _, suffix := range []string{"+", "-only", "-or-later"} {
+			if lowerIdentifier == lowerLicense+suffix {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasBuildFlag(buildFlags []BuildFlag, name string) bool {
+	for _, buildFlag := range buildFlags {
+		if buildFlag.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkginfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestParseSpecMetadata(t *testing.T) {
+	info, err := ParseSpecMetadata(filepath.Join("testdata", "SPECS", "foo", "foo.spec"))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", info.Name)
+	assert.Equal(t, "2.1", info.Version)
+	assert.Equal(t, "GPL-3.0-or-later AND MIT", info.License)
+	assert.Equal(t, "https://example.com/foo", info.URL)
+	assert.Equal(t, []BuildFlag{
+		{Name: "docs", EnabledByDefault: false},
+		{Name: "tests", EnabledByDefault: true},
+	}, info.BuildFlags)
+}
+
+func TestCodeOwnersLastMatchWins(t *testing.T) {
+	codeOwners, err := LoadCodeOwners(filepath.Join("testdata", "CODEOWNERS"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"@org/foo-owners", "@alice"}, codeOwners.OwnersOf("SPECS/foo/foo.spec"))
+	assert.Equal(t, []string{"@org/default-owners"}, codeOwners.OwnersOf("SPECS/bar/bar.spec"))
+}
+
+func TestCodeOwnersPatternMatches(t *testing.T) {
+	assert.True(t, codeOwnersPatternMatches("/SPECS/", "SPECS/foo/foo.spec"))
+	assert.False(t, codeOwnersPatternMatches("/SPECS/", "SPECS-EXTENDED/foo/foo.spec"))
+	assert.True(t, codeOwnersPatternMatches("*.spec", "SPECS/foo/foo.spec"))
+	assert.True(t, codeOwnersPatternMatches("foo", "SPECS/foo/foo.spec"))
+	assert.True(t, codeOwnersPatternMatches("/SPECS/*/foo.spec", "SPECS/foo/foo.spec"))
+	assert.False(t, codeOwnersPatternMatches("/foo", "SPECS/foo/foo.spec"))
+}
+
+func TestDatabaseQueries(t *testing.T) {
+	db, err := BuildDatabase([]string{filepath.Join("testdata", "SPECS")}, filepath.Join("testdata", "CODEOWNERS"),
+		"testdata")
+	assert.NoError(t, err)
+	assert.Len(t, db.Packages, 2)
+
+	owners, err := db.WhoOwns("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@org/foo-owners", "@alice"}, owners)
+
+	_, err = db.WhoOwns("missing")
+	assert.ErrorContains(t, err, "package (missing) not found")
+
+	gplPackages := db.List(Filter{License: "GPL-3.0"})
+	if assert.Len(t, gplPackages, 1) {
+		assert.Equal(t, "foo", gplPackages[0].Name)
+	}
+
+	mitPackages := db.List(Filter{License: "mit", Owner: "@ALICE"})
+	assert.Len(t, mitPackages, 1)
+
+	testsPackages := db.List(Filter{BuildFlag: "tests"})
+	assert.Len(t, testsPackages, 1)
+
+	assert.Len(t, db.List(Filter{}), 2)
+}
+
+func TestDatabaseSaveAndLoad(t *testing.T) {
+	db, err := BuildDatabase([]string{filepath.Join("testdata", "SPECS")}, "", "testdata")
+	assert.NoError(t, err)
+
+	dbPath := filepath.Join(t.TempDir(), "pkginfo.json")
+	err = db.Save(dbPath)
+	assert.NoError(t, err)
+
+	loadedDb, err := LoadDatabase(dbPath)
+	assert.NoError(t, err)
+
+	infos := loadedDb.Get("bar")
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "SPECS/bar/bar.spec", infos[0].SpecPath)
+		assert.Equal(t, "Apache-2.0", infos[0].License)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkginfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// Matches spec header tags, e.g. "License: MIT".
+	specTagRegex = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)\s*:\s*(.*)$`)
+	// Matches simple macro definitions, e.g. "%global majorver 3".
+	specDefineRegex = regexp.MustCompile(`^%(?:global|define)\s+(\w+)\s+(.*)$`)
+	// Matches build conditionals, e.g. "%bcond_without tests" or "%bcond fips 1".
+	specBcondRegex = regexp.MustCompile(`^%(bcond_with|bcond_without|bcond)\s+(\w+)(?:\s+(\S+))?`)
+	// Matches simple macro references, e.g. "%{name}" or "%version".
+	specMacroRegex = regexp.MustCompile(`%\{\??(\w+)\}|%(\w+)`)
+)
+
+// BuildFlag describes a build conditional declared by a spec.
+type BuildFlag struct {
+	Name             string `json:"Name"`
+	EnabledByDefault bool   `json:"EnabledByDefault"`
+}
+
+// PackageInfo holds the metadata of a single spec file.
+type PackageInfo struct {
+	Name       string      `json:"Name"`
+	Version    string      `json:"Version"`
+	SpecPath   string      `json:"SpecPath"`
+	License    string      `json:"License"`
+	URL        string      `json:"URL"`
+	BuildFlags []BuildFlag `json:"BuildFlags"`
+	Owners     []string    `json:"Owners"`
+}
+
+// ParseSpecMetadata reads the header of a spec file and extracts the package's metadata.
+//
+// This is a best-effort, pure-Go parse intended for fast indexing of the spec tree. Only macros defined in the spec
+// itself are expanded, so values that depend on distro macros may be left partially unexpanded.
+func ParseSpecMetadata(specPath string) (info PackageInfo, err error) {
+	specFile, err := os.Open(specPath)
+	if err != nil {
+		return
+	}
+	defer specFile.Close()
+
+	macros := make(map[string]string)
+	info.SpecPath = specPath
+
+	scanner := bufio.NewScanner(specFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Sub-package and scriptlet sections never redefine the main package's metadata.
+		if strings.HasPrefix(line, "%description") || strings.HasPrefix(line, "%package") {
+			break
+		}
+
+		if matches := specDefineRegex.FindStringSubmatch(line); matches != nil {
+			macros[matches[1]] = expandSpecMacros(strings.TrimSpace(matches[2]), macros)
+			continue
+		}
+
+		if matches := specBcondRegex.FindStringSubmatch(line); matches != nil {
+			info.BuildFlags = append(info.BuildFlags, newBuildFlag(matches[1], matches[2], matches[3]))
+			continue
+		}
+
+		matches := specTagRegex.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		value := expandSpecMacros(strings.TrimSpace(matches[2]), macros)
+		switch strings.ToLower(matches[1]) {
+		case "name":
+			info.Name = value
+			macros["name"] = value
+		case "version":
+			info.Version = value
+			macros["version"] = value
+		case "license":
+			info.License = value
+		case "url":
+			info.URL = value
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+
+	if info.Name == "" {
+		err = fmt.Errorf("spec (%s) does not define a 'Name' tag", specPath)
+		return
+	}
+
+	sort.Slice(info.BuildFlags, func(i, j int) bool {
+		return info.BuildFlags[i].Name < info.BuildFlags[j].Name
+	})
+
+	return
+}
+
+func newBuildFlag(bcondType string, name string, defaultValue string) BuildFlag {
+	switch bcondType {
+	case "bcond_with":
+		// "%bcond_with foo" adds a "--with foo" option, so the feature is off by default.
+		return BuildFlag{Name: name, EnabledByDefault: false}
+	case "bcond_without":
+		// "%bcond_without foo" adds a "--without foo" option, so the feature is on by default.
+		return BuildFlag{Name: name, EnabledByDefault: true}
+	default:
+		return BuildFlag{Name: name, EnabledByDefault: defaultValue != "" && defaultValue != "0"}
+	}
+}
+
+func expandSpecMacros(value string, macros map[string]string) string {
+	return specMacroRegex.ReplaceAllStringFunc(value, func(macro string) string {
+		matches := specMacroRegex.FindStringSubmatch(macro)
+		name := matches[1]
+		if name == "" {
+			name = matches[2]
+		}
+
+		expanded, found := macros[name]
+		if !found {
+			if strings.HasPrefix(macro, "%{?") {
+				return ""
+			}
+			return macro
+		}
+		return expanded
+	})
+}
@@ -0,0 +1,9 @@
+Summary:        Test package bar
+Name:           bar
+Version:        1.0
+Release:        1%{?dist}
+License:        Apache-2.0
+URL:            https://example.com/bar
+
+%description
+Test package bar.
@@ -0,0 +1,22 @@
+%global majorver 2
+%bcond_without tests
+%bcond_with docs
+Summary:        Test package foo
+Name:           foo
+Version:        %{majorver}.1
+Release:        1%{?dist}
+License:        GPL-3.0-or-later AND MIT
+Vendor:         Microsoft Corporation
+Distribution:   Azure Linux
+URL:            https://example.com/%{name}
+Source0:        %{url}/%{name}-%{version}.tar.gz
+
+%description
+Test package foo.
+
+%package devel
+Summary:        Development files for foo
+License:        BSD
+
+%description devel
+Development files.
@@ -0,0 +1,186 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package provenance
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// Envelope is a DSSE envelope holding a serialized statement and its signatures.
+type Envelope struct {
+	PayloadType string `json:"payloadType"`
+	// Payload is the serialized statement. It is base64 encoded in the envelope's JSON.
+	Payload    []byte      `json:"payload"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// Signature is a signature of an envelope's payload.
+type Signature struct {
+	// KeyID is the hex encoded sha256 digest of the signing key's DER encoded public key.
+	KeyID string `json:"keyid,omitempty"`
+	Sig   []byte `json:"sig"`
+}
+
+// LoadSigningKey reads a PEM encoded private key (PKCS #8, PKCS #1 RSA or SEC 1 EC) to sign attestations with.
+// Ed25519, ECDSA and RSA keys are supported.
+func LoadSigningKey(path string) (signer crypto.Signer, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the provenance signing key (%s):\n%w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("the provenance signing key (%s) isn't PEM encoded", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the provenance signing key (%s):\n%w", path, err)
+	}
+
+	switch key := key.(type) {
+	case ed25519.PrivateKey, *ecdsa.PrivateKey, *rsa.PrivateKey:
+		return key.(crypto.Signer), nil
+	default:
+		return nil, fmt.Errorf("unsupported provenance signing key type (%T) in (%s)", key, path)
+	}
+}
+
+// KeyID returns the ID of a public key, used to find the key which verifies a signature.
+func KeyID(publicKey crypto.PublicKey) (keyID string, err error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the public key:\n%w", err)
+	}
+
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// Sign serializes the statement into an envelope signed with the given key. If the key is nil, the envelope isn't
+// signed.
+func Sign(statement *Statement, signer crypto.Signer) (envelope *Envelope, err error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize the provenance statement:\n%w", err)
+	}
+
+	return SignPayload(PayloadType, payload, signer)
+}
+
+// SignPayload wraps a payload of any type into an envelope signed with the given key. If the key is nil, the envelope
+// isn't signed.
+func SignPayload(payloadType string, payload []byte, signer crypto.Signer) (envelope *Envelope, err error) {
+	envelope = &Envelope{
+		PayloadType: payloadType,
+		Payload:     payload,
+		Signatures:  []Signature{},
+	}
+
+	if signer == nil {
+		return
+	}
+
+	keyID, err := KeyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	message := preAuthEncoding(envelope.PayloadType, envelope.Payload)
+	var sig []byte
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	default:
+		digest := sha256.Sum256(message)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the envelope's payload:\n%w", err)
+	}
+
+	envelope.Signatures = append(envelope.Signatures, Signature{KeyID: keyID, Sig: sig})
+
+	return
+}
+
+// Verify checks that the envelope has a valid signature by the given public key and returns its statement.
+func (e *Envelope) Verify(publicKey crypto.PublicKey) (statement *Statement, err error) {
+	payload, err := e.VerifyPayload(PayloadType, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	statement = &Statement{}
+	err = json.Unmarshal(payload, statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the provenance statement:\n%w", err)
+	}
+
+	if statement.Type != StatementType || statement.PredicateType != PredicateType {
+		return nil, fmt.Errorf("unexpected statement (%s) or predicate (%s) type", statement.Type, statement.PredicateType)
+	}
+
+	return
+}
+
+// VerifyPayload checks that the envelope holds a payload of the given type with a valid signature by the given public
+// key and returns the payload.
+func (e *Envelope) VerifyPayload(payloadType string, publicKey crypto.PublicKey) (payload []byte, err error) {
+	if e.PayloadType != payloadType {
+		return nil, fmt.Errorf("unexpected payload type (%s)", e.PayloadType)
+	}
+
+	message := preAuthEncoding(e.PayloadType, e.Payload)
+	digest := sha256.Sum256(message)
+
+	verified := false
+	for _, signature := range e.Signatures {
+		switch key := publicKey.(type) {
+		case ed25519.PublicKey:
+			verified = ed25519.Verify(key, message, signature.Sig)
+		case *ecdsa.PublicKey:
+			verified = ecdsa.VerifyASN1(key, digest[:], signature.Sig)
+		case *rsa.PublicKey:
+			verified = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature.Sig) == nil
+		default:
+			return nil, fmt.Errorf("unsupported public key type (%T)", publicKey)
+		}
+
+		if verified {
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("no valid signature by the given key in the envelope")
+	}
+
+	return e.Payload, nil
+}
+
+// preAuthEncoding returns the DSSE pre-authentication encoding of a payload, which is the message that is signed.
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
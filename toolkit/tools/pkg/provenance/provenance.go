@@ -0,0 +1,238 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package provenance defines the SLSA provenance attestations that pkgworker writes next to the RPMs it builds. Each
+// attestation is an in-toto statement with a SLSA v1 provenance predicate, wrapped in a DSSE envelope which is signed
+// with the build's provenance key. The attestation records which spec and sources the RPMs were built from, the
+// packages installed into the build chroot, the builder and the environment the build ran in.
+//
+// This package is deliberately free of Linux-only dependencies, so that the attestations can be verified on any
+// platform.
+package provenance
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// FileSuffix is appended to an RPM's file name to get the file name of its provenance attestation
+	// (e.g. "zlib-1.3-1.azl3.x86_64.rpm" -> "zlib-1.3-1.azl3.x86_64.rpm.intoto.jsonl").
+	FileSuffix = ".intoto.jsonl"
+
+	// StatementType is the in-toto statement type of the attestations.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the predicate type of the attestations.
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// PayloadType is the DSSE payload type of the attestations.
+	PayloadType = "application/vnd.in-toto+json"
+
+	// BuildType identifies how the external and internal parameters of the predicate are to be interpreted.
+	BuildType = "https://github.com/microsoft/azurelinux/toolkit/pkgworker/v1"
+	// DefaultBuilderID is the builder identity used if the build doesn't configure one.
+	DefaultBuilderID = "https://github.com/microsoft/azurelinux/toolkit/pkgworker"
+
+	// DigestSha256 is the digest algorithm of the files the toolkit hashes itself.
+	DigestSha256 = "sha256"
+)
+
+// Statement is an in-toto statement that the subjects (the built RPMs) were produced by the build described in the
+// predicate.
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Predicate            `json:"predicate"`
+}
+
+// ResourceDescriptor identifies a file or package by its name, digests and, for packages, its package URL.
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// Predicate is a SLSA v1 provenance predicate.
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of a package build.
+type BuildDefinition struct {
+	BuildType          string             `json:"buildType"`
+	ExternalParameters ExternalParameters `json:"externalParameters"`
+	InternalParameters InternalParameters `json:"internalParameters"`
+	// ResolvedDependencies are the SRPM, the spec and sources it contains, the worker chroot and the packages
+	// installed into the build chroot (including the toolchain).
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies"`
+}
+
+// ExternalParameters are the parameters of the build which were chosen by the user.
+type ExternalParameters struct {
+	// SRPM is the file name of the SRPM that was built.
+	SRPM string `json:"srpm"`
+	// Spec is the file name of the spec inside the SRPM.
+	Spec     string `json:"spec"`
+	OutArch  string `json:"outArch,omitempty"`
+	RunCheck bool   `json:"runCheck"`
+}
+
+// InternalParameters are the parameters of the build which were set by the toolkit.
+type InternalParameters struct {
+	// Defines are the RPM macros the build was run with.
+	Defines map[string]string `json:"defines,omitempty"`
+	// Environment describes the host the build ran on (e.g. its kernel version).
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+// RunDetails describes the builder and the build's run.
+type RunDetails struct {
+	Builder  Builder       `json:"builder"`
+	Metadata BuildMetadata `json:"metadata"`
+}
+
+// Builder identifies the builder which ran the build.
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// BuildMetadata records when the build ran.
+type BuildMetadata struct {
+	InvocationID string    `json:"invocationId,omitempty"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// NewStatement creates the statement that the given RPMs were produced by a build, including the RPMs' digests.
+func NewStatement(rpmPaths []string, predicate Predicate) (statement *Statement, err error) {
+	statement = &Statement{
+		Type:          StatementType,
+		Subject:       []ResourceDescriptor{},
+		PredicateType: PredicateType,
+		Predicate:     predicate,
+	}
+
+	if statement.Predicate.BuildDefinition.BuildType == "" {
+		statement.Predicate.BuildDefinition.BuildType = BuildType
+	}
+	if statement.Predicate.RunDetails.Builder.ID == "" {
+		statement.Predicate.RunDetails.Builder.ID = DefaultBuilderID
+	}
+
+	for _, rpmPath := range rpmPaths {
+		var subject ResourceDescriptor
+		subject, err = FileDescriptor(rpmPath)
+		if err != nil {
+			return nil, err
+		}
+		statement.Subject = append(statement.Subject, subject)
+	}
+
+	return
+}
+
+// FileDescriptor describes a file by its name and sha256 digest.
+func FileDescriptor(path string) (descriptor ResourceDescriptor, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("failed to open (%s):\n%w", path, err)
+		return
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		err = fmt.Errorf("failed to hash (%s):\n%w", path, err)
+		return
+	}
+
+	descriptor = ResourceDescriptor{
+		Name:   filepath.Base(path),
+		Digest: map[string]string{DigestSha256: hex.EncodeToString(hasher.Sum(nil))},
+	}
+
+	return
+}
+
+// HasSubject checks if the statement covers a file with the given name and sha256 digest.
+func (s *Statement) HasSubject(name, sha256Digest string) bool {
+	for _, subject := range s.Subject {
+		if subject.Name == name && subject.Digest[DigestSha256] == sha256Digest {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteSidecars writes the attestation next to each of the given RPMs.
+func WriteSidecars(envelope *Envelope, rpmPaths []string) (err error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to serialize the provenance attestation:\n%w", err)
+	}
+	// The attestation is stored in the JSON lines format, one envelope per line.
+	data = append(data, '\n')
+
+	for _, rpmPath := range rpmPaths {
+		sidecarPath := rpmPath + FileSuffix
+		err = os.WriteFile(sidecarPath, data, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write the provenance attestation (%s):\n%w", sidecarPath, err)
+		}
+	}
+
+	return
+}
+
+// ReadSidecar reads the attestation stored next to an RPM.
+func ReadSidecar(rpmPath string) (envelope *Envelope, err error) {
+	sidecarPath := rpmPath + FileSuffix
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the provenance attestation (%s):\n%w", sidecarPath, err)
+	}
+
+	envelope = &Envelope{}
+	err = json.Unmarshal(data, envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the provenance attestation (%s):\n%w", sidecarPath, err)
+	}
+
+	return
+}
+
+// VerifySidecar verifies the attestation stored next to an RPM with the given public key and checks that it covers
+// the RPM.
+func VerifySidecar(rpmPath string, publicKey crypto.PublicKey) (statement *Statement, err error) {
+	envelope, err := ReadSidecar(rpmPath)
+	if err != nil {
+		return
+	}
+
+	statement, err = envelope.Verify(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify the provenance of (%s):\n%w", rpmPath, err)
+	}
+
+	rpm, err := FileDescriptor(rpmPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !statement.HasSubject(rpm.Name, rpm.Digest[DigestSha256]) {
+		return nil, fmt.Errorf("the provenance of (%s) doesn't cover the RPM's contents", rpmPath)
+	}
+
+	return
+}
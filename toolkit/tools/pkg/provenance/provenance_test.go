@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package provenance
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestRPMs(t *testing.T) (rpmPaths []string) {
+	dir := t.TempDir()
+	for _, name := range []string{"zlib-1.3-1.azl3.x86_64.rpm", "zlib-devel-1.3-1.azl3.x86_64.rpm"} {
+		rpmPath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(rpmPath, []byte(name), 0o644))
+		rpmPaths = append(rpmPaths, rpmPath)
+	}
+
+	return
+}
+
+func testPredicate() Predicate {
+	return Predicate{
+		BuildDefinition: BuildDefinition{
+			ExternalParameters: ExternalParameters{SRPM: "zlib-1.3-1.azl3.src.rpm", Spec: "zlib.spec", OutArch: "x86_64"},
+			ResolvedDependencies: []ResourceDescriptor{
+				{Name: "zlib.spec", Digest: map[string]string{DigestSha256: "0123"}},
+				{Name: "gcc-13.2.0-7.azl3.x86_64", URI: "pkg:rpm/azurelinux/gcc@13.2.0-7.azl3?arch=x86_64"},
+			},
+		},
+		RunDetails: RunDetails{
+			Metadata: BuildMetadata{StartedOn: time.Unix(0, 0).UTC(), FinishedOn: time.Unix(60, 0).UTC()},
+		},
+	}
+}
+
+func writeTestKey(t *testing.T, key crypto.Signer) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "provenance.key")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	return keyPath
+}
+
+func TestSignAndVerifySidecars(t *testing.T) {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for name, key := range map[string]crypto.Signer{"ed25519": ed25519Key, "ecdsa": ecdsaKey, "rsa": rsaKey} {
+		t.Run(name, func(t *testing.T) {
+			signer, err := LoadSigningKey(writeTestKey(t, key))
+			require.NoError(t, err)
+
+			rpmPaths := writeTestRPMs(t)
+			statement, err := NewStatement(rpmPaths, testPredicate())
+			require.NoError(t, err)
+			envelope, err := Sign(statement, signer)
+			require.NoError(t, err)
+			require.NoError(t, WriteSidecars(envelope, rpmPaths))
+
+			for _, rpmPath := range rpmPaths {
+				verified, err := VerifySidecar(rpmPath, key.Public())
+				require.NoError(t, err)
+				assert.Equal(t, DefaultBuilderID, verified.Predicate.RunDetails.Builder.ID)
+				assert.Equal(t, BuildType, verified.Predicate.BuildDefinition.BuildType)
+				assert.Len(t, verified.Subject, 2)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rpmPaths := writeTestRPMs(t)
+	statement, err := NewStatement(rpmPaths, testPredicate())
+	require.NoError(t, err)
+	envelope, err := Sign(statement, privateKey)
+	require.NoError(t, err)
+	require.NoError(t, WriteSidecars(envelope, rpmPaths))
+
+	_, err = VerifySidecar(rpmPaths[0], otherPublicKey)
+	assert.Error(t, err)
+
+	// A modified RPM is no longer covered by its attestation.
+	require.NoError(t, os.WriteFile(rpmPaths[0], []byte("modified"), 0o644))
+	_, err = VerifySidecar(rpmPaths[0], publicKey)
+	assert.ErrorContains(t, err, "doesn't cover")
+
+	// A modified payload invalidates the signature.
+	envelope.Payload = append(envelope.Payload, ' ')
+	_, err = envelope.Verify(publicKey)
+	assert.Error(t, err)
+}
+
+func TestUnsignedEnvelopeFailsVerification(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	statement, err := NewStatement(writeTestRPMs(t), testPredicate())
+	require.NoError(t, err)
+	envelope, err := Sign(statement, nil)
+	require.NoError(t, err)
+	assert.Empty(t, envelope.Signatures)
+
+	_, err = envelope.Verify(publicKey)
+	assert.Error(t, err)
+}
+
+func TestPreAuthEncoding(t *testing.T) {
+	// Test vector from the DSSE specification.
+	assert.Equal(t, "DSSEv1 29 http://example.com/HelloWorld 11 hello world",
+		string(preAuthEncoding("http://example.com/HelloWorld", []byte("hello world"))))
+}
@@ -0,0 +1,233 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package selinuxreport defines the SELinux report written by the image customizer, which records the SELinux labels
+// of an image's files and how the image's policy would relabel them.
+//
+// This package is deliberately free of Linux-only dependencies, so that reports can be examined on any platform.
+package selinuxreport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+)
+
+var (
+	// The SELinux types that a file gets when the policy doesn't have a file context rule that covers it.
+	uncoveredTypes = []string{
+		"default_t",
+		"unlabeled_t",
+	}
+)
+
+// Report lists the SELinux state of an image.
+type Report struct {
+	// The SELinux policy type (e.g. "targeted").
+	PolicyType string `json:"policyType"`
+	// The package that provides the SELinux policy (e.g. "selinux-policy-targeted-40.13-1.azl3").
+	PolicyPackage string `json:"policyPackage"`
+	// The value of each of the policy's booleans, including the changes made to the defaults (e.g. using
+	// `setsebool -P`).
+	Booleans map[string]bool `json:"booleans"`
+	// The SELinux label of each file.
+	Files map[string]string `json:"files"`
+	// The files whose label didn't match the policy before the filesystem was relabeled, mapped to the label they
+	// were relabeled to.
+	Relabels map[string]string `json:"relabels"`
+	// The files of the base image whose label was changed by the customization, mapped to their label in the base
+	// image.
+	BaseLabels map[string]string `json:"baseLabels,omitempty"`
+	// The booleans of the base image whose value was changed or which were removed by the customization, mapped to
+	// their value in the base image.
+	BaseBooleans map[string]bool `json:"baseBooleans,omitempty"`
+}
+
+// Read reads an SELinux report file.
+func Read(reportFilePath string) (*Report, error) {
+	var report Report
+	err := jsonutils.ReadJSONFile(reportFilePath, &report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SELinux report (%s):\n%w", reportFilePath, err)
+	}
+
+	return &report, nil
+}
+
+// Write writes an SELinux report file, creating the parent directory if required.
+func Write(report *Report, reportFilePath string) error {
+	err := os.MkdirAll(filepath.Dir(reportFilePath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = jsonutils.WriteJSONFile(reportFilePath, report)
+	if err != nil {
+		return fmt.Errorf("failed to write SELinux report (%s):\n%w", reportFilePath, err)
+	}
+
+	return nil
+}
+
+// UncoveredFiles returns the files whose label, after relabeling, would be a type that indicates the policy doesn't
+// have a file context rule for the file.
+func (r *Report) UncoveredFiles() []string {
+	uncovered := []string(nil)
+	for path, label := range r.Files {
+		if relabel, found := r.Relabels[path]; found {
+			label = relabel
+		}
+
+		for _, uncoveredType := range uncoveredTypes {
+			if labelType(label) == uncoveredType {
+				uncovered = append(uncovered, path)
+				break
+			}
+		}
+	}
+
+	sort.Strings(uncovered)
+	return uncovered
+}
+
+// Diff compares two SELinux reports (e.g. from the current and next versions of an image).
+// Each returned line starts with "-" if the state is only in the first report or "+" if the state is only in the
+// second report. Labels are only compared for files that exist in both images.
+func Diff(first *Report, second *Report) []string {
+	firstStates := reportStates(first, second)
+	secondStates := reportStates(second, first)
+
+	diff := []string(nil)
+	for state := range firstStates {
+		if !secondStates[state] {
+			diff = append(diff, "- "+state)
+		}
+	}
+
+	for state := range secondStates {
+		if !firstStates[state] {
+			diff = append(diff, "+ "+state)
+		}
+	}
+
+	sortDiff(diff)
+	return diff
+}
+
+// sortDiff sorts the lines of a diff by their state, so that related lines are next to each other.
+func sortDiff(diff []string) {
+	sort.Slice(diff, func(i, j int) bool {
+		if diff[i][2:] != diff[j][2:] {
+			return diff[i][2:] < diff[j][2:]
+		}
+		return diff[i] < diff[j]
+	})
+}
+
+// Regressions returns the lines of a diff that indicate the second image may be mislabeled or may behave differently
+// under the policy. That is, files that would be relabeled, that aren't covered by the policy or whose label changed,
+// and booleans whose value changed.
+func Regressions(diff []string) []string {
+	const (
+		addedBooleanPrefix   = "+ boolean: "
+		removedBooleanPrefix = "- boolean: "
+	)
+
+	// A boolean whose value changed is both removed and added. Booleans that are only added (e.g. by a new policy
+	// module) don't change the behavior of the first image.
+	changedBooleans := make(map[string]bool)
+	for _, line := range diff {
+		if strings.HasPrefix(line, removedBooleanPrefix) {
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, removedBooleanPrefix), "=")
+			changedBooleans[name] = true
+		}
+	}
+
+	regressions := []string(nil)
+	for _, line := range diff {
+		switch {
+		case strings.HasPrefix(line, "+ relabel: "), strings.HasPrefix(line, "+ uncovered: "),
+			strings.HasPrefix(line, "+ label: "):
+			regressions = append(regressions, line)
+
+		case strings.HasPrefix(line, addedBooleanPrefix):
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, addedBooleanPrefix), "=")
+			if changedBooleans[name] {
+				regressions = append(regressions, line)
+			}
+		}
+	}
+
+	return regressions
+}
+
+// DiffBase returns the changes that the customization made to the labels and booleans of the base image, in the same
+// format as Diff().
+func (r *Report) DiffBase() []string {
+	diff := []string(nil)
+	for path, baseLabel := range r.BaseLabels {
+		diff = append(diff, fmt.Sprintf("- label: %s %s", path, baseLabel))
+		if label, found := r.Files[path]; found {
+			diff = append(diff, fmt.Sprintf("+ label: %s %s", path, label))
+		}
+	}
+
+	for name, baseValue := range r.BaseBooleans {
+		diff = append(diff, fmt.Sprintf("- boolean: %s=%s", name, booleanString(baseValue)))
+		if value, found := r.Booleans[name]; found {
+			diff = append(diff, fmt.Sprintf("+ boolean: %s=%s", name, booleanString(value)))
+		}
+	}
+
+	sortDiff(diff)
+	return diff
+}
+
+// reportStates flattens a report into a set of single line descriptions of each state. File labels are only included
+// for the files that also exist in the other report.
+func reportStates(report *Report, other *Report) map[string]bool {
+	states := make(map[string]bool)
+
+	states[fmt.Sprintf("policy: %s %s", report.PolicyType, report.PolicyPackage)] = true
+
+	for name, value := range report.Booleans {
+		states[fmt.Sprintf("boolean: %s=%s", name, booleanString(value))] = true
+	}
+
+	for path, label := range report.Files {
+		if _, found := other.Files[path]; found {
+			states[fmt.Sprintf("label: %s %s", path, label)] = true
+		}
+	}
+
+	for path, label := range report.Relabels {
+		states[fmt.Sprintf("relabel: %s -> %s", path, label)] = true
+	}
+
+	for _, path := range report.UncoveredFiles() {
+		states[fmt.Sprintf("uncovered: %s", path)] = true
+	}
+
+	return states
+}
+
+// labelType returns the type field of an SELinux label (user:role:type:level).
+func labelType(label string) string {
+	fields := strings.SplitN(label, ":", 4)
+	if len(fields) < 3 {
+		return ""
+	}
+
+	return fields[2]
+}
+
+func booleanString(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package selinuxreport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	retVal := m.Run()
+	os.Exit(retVal)
+}
+
+func TestWriteAndRead(t *testing.T) {
+	reportFilePath := filepath.Join(t.TempDir(), "out", "image.selinux.json")
+	report := &Report{
+		PolicyType:    "targeted",
+		PolicyPackage: "selinux-policy-targeted-40.13-1.azl3",
+		Booleans:      map[string]bool{"httpd_can_network_connect": true},
+		Files:         map[string]string{"/etc/motd": "system_u:object_r:etc_t:s0"},
+		Relabels:      map[string]string{},
+	}
+
+	err := Write(report, reportFilePath)
+	assert.NoError(t, err)
+
+	readReport, err := Read(reportFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, report, readReport)
+}
+
+func TestReadMissing(t *testing.T) {
+	_, err := Read(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "failed to read SELinux report")
+}
+
+func TestUncoveredFiles(t *testing.T) {
+	report := &Report{
+		Files: map[string]string{
+			"/etc/motd":     "system_u:object_r:etc_t:s0",
+			"/opt/app/data": "system_u:object_r:default_t:s0",
+			"/srv/www":      "system_u:object_r:default_t:s0",
+			"/mnt/unknown":  "system_u:object_r:unlabeled_t:s0",
+		},
+		Relabels: map[string]string{
+			"/srv/www":  "system_u:object_r:httpd_sys_content_t:s0",
+			"/etc/motd": "system_u:object_r:default_t:s0",
+		},
+	}
+
+	assert.Equal(t, []string{"/etc/motd", "/mnt/unknown", "/opt/app/data"}, report.UncoveredFiles())
+}
+
+func TestDiff(t *testing.T) {
+	first := &Report{
+		PolicyType:    "targeted",
+		PolicyPackage: "selinux-policy-targeted-40.13-1.azl3",
+		Booleans:      map[string]bool{"httpd_can_network_connect": true},
+		Files: map[string]string{
+			"/etc/motd":        "system_u:object_r:etc_t:s0",
+			"/usr/bin/app":     "system_u:object_r:bin_t:s0",
+			"/usr/bin/removed": "system_u:object_r:bin_t:s0",
+		},
+		Relabels: map[string]string{},
+	}
+	second := &Report{
+		PolicyType:    "targeted",
+		PolicyPackage: "selinux-policy-targeted-40.14-1.azl3",
+		Booleans:      map[string]bool{"httpd_can_network_connect": false},
+		Files: map[string]string{
+			"/etc/motd":      "system_u:object_r:etc_t:s0",
+			"/usr/bin/app":   "system_u:object_r:app_exec_t:s0",
+			"/usr/bin/added": "system_u:object_r:bin_t:s0",
+			"/opt/data":      "system_u:object_r:default_t:s0",
+		},
+		Relabels: map[string]string{
+			"/usr/bin/app": "system_u:object_r:bin_t:s0",
+		},
+	}
+
+	diff := Diff(first, second)
+	assert.Equal(t, []string{
+		"+ boolean: httpd_can_network_connect=off",
+		"- boolean: httpd_can_network_connect=on",
+		"+ label: /usr/bin/app system_u:object_r:app_exec_t:s0",
+		"- label: /usr/bin/app system_u:object_r:bin_t:s0",
+		"- policy: targeted selinux-policy-targeted-40.13-1.azl3",
+		"+ policy: targeted selinux-policy-targeted-40.14-1.azl3",
+		"+ relabel: /usr/bin/app -> system_u:object_r:bin_t:s0",
+		"+ uncovered: /opt/data",
+	}, diff)
+
+	assert.Equal(t, []string{
+		"+ boolean: httpd_can_network_connect=off",
+		"+ label: /usr/bin/app system_u:object_r:app_exec_t:s0",
+		"+ relabel: /usr/bin/app -> system_u:object_r:bin_t:s0",
+		"+ uncovered: /opt/data",
+	}, Regressions(diff))
+
+	assert.Empty(t, Diff(first, first))
+}
+
+func TestRegressionsIgnoresAddedBooleans(t *testing.T) {
+	first := &Report{Booleans: map[string]bool{"httpd_can_network_connect": true}}
+	second := &Report{Booleans: map[string]bool{"httpd_can_network_connect": true, "new_module_boolean": false}}
+
+	diff := Diff(first, second)
+	assert.Equal(t, []string{"+ boolean: new_module_boolean=off"}, diff)
+	assert.Empty(t, Regressions(diff))
+}
+
+func TestDiffBase(t *testing.T) {
+	report := &Report{
+		Booleans: map[string]bool{"httpd_can_network_connect": true},
+		Files: map[string]string{
+			"/etc/motd":    "system_u:object_r:etc_t:s0",
+			"/usr/bin/app": "system_u:object_r:app_exec_t:s0",
+		},
+		BaseLabels: map[string]string{
+			"/usr/bin/app": "system_u:object_r:bin_t:s0",
+		},
+		BaseBooleans: map[string]bool{
+			"httpd_can_network_connect": false,
+			"removed_boolean":           true,
+		},
+	}
+
+	assert.Equal(t, []string{
+		"- boolean: httpd_can_network_connect=off",
+		"+ boolean: httpd_can_network_connect=on",
+		"- boolean: removed_boolean=on",
+		"+ label: /usr/bin/app system_u:object_r:app_exec_t:s0",
+		"- label: /usr/bin/app system_u:object_r:bin_t:s0",
+	}, report.DiffBase())
+}
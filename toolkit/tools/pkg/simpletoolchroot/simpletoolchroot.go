@@ -50,13 +50,18 @@ func (s *SimpleToolChroot) InitializeChroot(buildDir, chrootName, workerTarPath,
 		existingDir = false
 	)
 
+	extraMountPoints, err := safechroot.NewMountPointsFromSpecs([]safechroot.MountSpec{
+		{Source: mountDirPath, Target: chrootMountDirPath, Bind: true},
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to create chroot mount points:\n%w", err)
+		return
+	}
+
 	chrootDirPath := filepath.Join(buildDir, chrootName)
 	s.chroot = safechroot.NewChroot(chrootDirPath, existingDir)
 
 	extraDirectories := []string{}
-	extraMountPoints := []*safechroot.MountPoint{
-		safechroot.NewMountPoint(mountDirPath, chrootMountDirPath, "", safechroot.BindMountPointFlags, ""),
-	}
 	err = s.chroot.Initialize(workerTarPath, extraDirectories, extraMountPoints, true, releaseVersionMacrosFile)
 	if err != nil {
 		err = fmt.Errorf("failed to initialize chroot (%s) inside (%s):\n%w", workerTarPath, chrootDirPath, err)
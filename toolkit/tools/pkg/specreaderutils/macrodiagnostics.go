@@ -0,0 +1,340 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package specreaderutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
+)
+
+var (
+	// macroReferenceRegex matches an escaped '%%' or a macro reference, e.g. "%name", "%{name}", "%{?name:...}" or
+	// "%{!?name}". Shell expansions ("%(...)") and expressions ("%[...]") aren't macro references.
+	macroReferenceRegex = regexp.MustCompile(`%%|%\{?[!?]*([A-Za-z_][A-Za-z0-9_]*)`)
+	// specMacroDefinitionRegex matches the macros a spec defines for itself.
+	specMacroDefinitionRegex = regexp.MustCompile(`(?m)^\s*%(?:global|define)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+	// tagMacroRegex matches the macros rpm defines from the tags of the spec being parsed.
+	tagMacroRegex = regexp.MustCompile(`^(name|version|release|epoch|summary|license|url|SOURCE\d+|PATCH\d+)$`)
+
+	// specDirectives are the names which look like macro references in a spec, but are built into rpm: conditionals,
+	// macro definitions, built-in macros and '%files' directives. Sections are matched with specSectionRegex.
+	specDirectives = sliceutils.SliceToSet([]string{
+		"if", "ifarch", "ifnarch", "ifos", "ifnos", "elif", "elifarch", "elifos", "else", "endif", "include",
+		"define", "global", "undefine", "bcond", "bcond_with", "bcond_without",
+		"expand", "lua", "dirname", "basename", "suffix", "quote", "shrink", "getenv", "getconfdir", "S", "P",
+		"url2path", "u2p", "uncompress", "trace", "dump", "echo", "warn", "error", "verbose", "load", "exists",
+		"gsub", "len", "lower", "upper", "rep", "reverse", "sub", "shescape", "macrobody", "expr",
+		"setup", "autosetup", "patch", "autopatch",
+		"doc", "license", "dir", "config", "attr", "defattr", "ghost", "verify", "exclude", "lang", "caps",
+		"artifact", "readme", "docdir", "missingok",
+	})
+)
+
+// MacroVariant is a change of the architecture or the macros a spec is parsed with.
+type MacroVariant struct {
+	Name    string
+	Arch    string
+	Defines map[string]string
+}
+
+// SpecMacroDiagnostics describes the macros a spec depends on, and how the spec changes when parsed with each variant.
+type SpecMacroDiagnostics struct {
+	SpecPath string `json:"SpecPath"`
+	// Macros are the macros referenced by the spec, which it doesn't define itself.
+	Macros []string `json:"Macros"`
+	// SpecMacros are the macros referenced by the spec, which it defines itself.
+	SpecMacros []string `json:"SpecMacros"`
+	// UndefinedMacros are the macros in Macros which weren't defined when the spec was parsed.
+	UndefinedMacros []string              `json:"UndefinedMacros"`
+	Compatible      bool                  `json:"Compatible"`
+	Packages        []string              `json:"Packages"`
+	Variants        []*MacroVariantChange `json:"Variants"`
+	Error           string                `json:"Error,omitempty"`
+}
+
+// MacroVariantChange is the difference between a spec parsed with the default macros and with a variant.
+type MacroVariantChange struct {
+	Variant         string   `json:"Variant"`
+	Compatible      bool     `json:"Compatible"`
+	AddedPackages   []string `json:"AddedPackages"`
+	RemovedPackages []string `json:"RemovedPackages"`
+	AddedLines      []string `json:"AddedLines"`
+	RemovedLines    []string `json:"RemovedLines"`
+	Error           string   `json:"Error,omitempty"`
+}
+
+// parsedSpecState is the result of parsing a spec with one set of macros.
+type parsedSpecState struct {
+	compatible bool
+	packages   []string
+	lines      []string
+}
+
+// NewMacroVariants returns a variant for each architecture, and for each "name=value" macro definition.
+func NewMacroVariants(arches, defines []string) (variants []*MacroVariant, err error) {
+	for _, arch := range arches {
+		variants = append(variants, &MacroVariant{Name: fmt.Sprintf("arch=%s", arch), Arch: arch})
+	}
+
+	for _, define := range defines {
+		name, value, found := strings.Cut(define, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid macro definition (%s), expected 'name=value'", define)
+		}
+		variants = append(variants, &MacroVariant{Name: define, Defines: map[string]string{name: value}})
+	}
+
+	return
+}
+
+// DiagnoseSPECMacrosWrapper wraps diagnoseSPECMacros to conditionally run it inside a chroot, the same way as
+// ParseSPECsWrapper, and writes the diagnostics of all specs to outputFile.
+func DiagnoseSPECMacrosWrapper(buildDir, specsDir, srpmsDir, distTag, outputFile, workerTar, releaseVersionMacrosFile, targetArch string, specListSet map[string]bool, variants []*MacroVariant, workers int) (err error) {
+	var diagnostics []*SpecMacroDiagnostics
+
+	arch := targetArch
+	if arch == "" {
+		arch, err = rpm.GetRpmArch(runtime.GOARCH)
+		if err != nil {
+			return
+		}
+	}
+
+	doDiagnose := func() (diagnoseErr error) {
+		diagnostics, diagnoseErr = diagnoseSPECMacros(specsDir, distTag, arch, specListSet, variants, workers)
+		return
+	}
+
+	err = runSpecParsing(buildDir, specsDir, srpmsDir, workerTar, releaseVersionMacrosFile, doDiagnose)
+	if err != nil {
+		return fmt.Errorf("failed to diagnose the macros of specs:\n%w", err)
+	}
+
+	undefinedCount := 0
+	for _, specDiagnostics := range diagnostics {
+		if len(specDiagnostics.UndefinedMacros) != 0 {
+			undefinedCount++
+		}
+	}
+	logger.Log.Infof("Diagnosed the macros of %d spec(s), %d of them use undefined macros", len(diagnostics), undefinedCount)
+
+	err = jsonutils.WriteJSONFile(outputFile, diagnostics)
+	if err != nil {
+		return fmt.Errorf("failed to write the macro diagnostics (%s):\n%w", outputFile, err)
+	}
+
+	return
+}
+
+// diagnoseSPECMacros diagnoses the macros of all specs in specsDir. A spec which fails to parse is reported in its own
+// diagnostics.
+func diagnoseSPECMacros(specsDir, distTag, arch string, specListSet map[string]bool, variants []*MacroVariant, workers int) (diagnostics []*SpecMacroDiagnostics, err error) {
+	var wg sync.WaitGroup
+
+	specFiles, err := FindSpecFiles(specsDir, specListSet)
+	if err != nil {
+		return
+	}
+
+	requests := make(chan string, len(specFiles))
+	results := make(chan *SpecMacroDiagnostics, len(specFiles))
+	for _, specFile := range specFiles {
+		requests <- specFile
+	}
+	close(requests)
+
+	defines := rpm.DefaultDistroDefines(false, distTag)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for specFile := range requests {
+				results <- diagnoseSpecMacros(specFile, arch, defines, variants)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	for result := range results {
+		diagnostics = append(diagnostics, result)
+	}
+
+	sort.Slice(diagnostics, func(i, j int) bool {
+		return diagnostics[i].SpecPath < diagnostics[j].SpecPath
+	})
+
+	return
+}
+
+// diagnoseSpecMacros diagnoses the macros of a single spec.
+func diagnoseSpecMacros(specFile, arch string, defines map[string]string, variants []*MacroVariant) (diagnostics *SpecMacroDiagnostics) {
+	diagnostics = &SpecMacroDiagnostics{
+		SpecPath:        specFile,
+		Macros:          []string{},
+		SpecMacros:      []string{},
+		UndefinedMacros: []string{},
+		Packages:        []string{},
+		Variants:        []*MacroVariantChange{},
+	}
+
+	rawSpec, err := os.ReadFile(specFile)
+	if err != nil {
+		diagnostics.Error = fmt.Sprintf("failed to read spec:\n%s", err)
+		return
+	}
+
+	diagnostics.Macros, diagnostics.SpecMacros = referencedMacros(string(rawSpec))
+
+	defined, err := rpm.DefinedMacros(arch, defines, diagnostics.Macros)
+	if err != nil {
+		diagnostics.Error = err.Error()
+		return
+	}
+	for _, macro := range diagnostics.Macros {
+		if !defined[macro] {
+			diagnostics.UndefinedMacros = append(diagnostics.UndefinedMacros, macro)
+		}
+	}
+
+	baseline, err := parseSpecState(specFile, arch, defines)
+	if err != nil {
+		diagnostics.Error = err.Error()
+		return
+	}
+	diagnostics.Compatible = baseline.compatible
+	diagnostics.Packages = baseline.packages
+
+	for _, variant := range variants {
+		change := &MacroVariantChange{Variant: variant.Name}
+		diagnostics.Variants = append(diagnostics.Variants, change)
+
+		variantArch := arch
+		if variant.Arch != "" {
+			variantArch = variant.Arch
+		}
+
+		variantDefines := make(map[string]string)
+		for name, value := range defines {
+			variantDefines[name] = value
+		}
+		for name, value := range variant.Defines {
+			variantDefines[name] = value
+		}
+
+		state, err := parseSpecState(specFile, variantArch, variantDefines)
+		if err != nil {
+			change.Error = err.Error()
+			continue
+		}
+
+		change.Compatible = state.compatible
+		change.AddedPackages, change.RemovedPackages = diffLines(baseline.packages, state.packages)
+		change.AddedLines, change.RemovedLines = diffLines(baseline.lines, state.lines)
+	}
+
+	return
+}
+
+// parseSpecState parses a spec and lists the packages it builds. A spec which can't be built for the architecture
+// builds no packages.
+func parseSpecState(specFile, arch string, defines map[string]string) (state *parsedSpecState, err error) {
+	const queryPackageNames = "%{NAME}\n"
+
+	state = &parsedSpecState{packages: []string{}}
+	sourceDir := filepath.Dir(specFile)
+
+	parsedSpec, err := rpm.ParseSPEC(specFile, sourceDir, arch, defines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse spec for (%s):\n%w", arch, err)
+	}
+	state.lines = strings.Split(parsedSpec, "\n")
+
+	state.compatible, err = rpm.SpecArchIsCompatible(specFile, sourceDir, arch, defines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the compatibility of the spec with (%s):\n%w", arch, err)
+	}
+
+	if state.compatible {
+		state.packages, err = rpm.QuerySPEC(specFile, sourceDir, queryPackageNames, arch, defines, rpm.QueryBuiltRPMHeadersArgument)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the packages of the spec for (%s):\n%w", arch, err)
+		}
+	}
+
+	return
+}
+
+// referencedMacros returns the sorted names of the macros referenced by a spec, split into the macros it depends on
+// and the macros it defines itself.
+func referencedMacros(rawSpec string) (macros, specMacros []string) {
+	definedBySpec := make(map[string]bool)
+	for _, match := range specMacroDefinitionRegex.FindAllStringSubmatch(rawSpec, -1) {
+		definedBySpec[match[1]] = true
+	}
+
+	referenced := make(map[string]bool)
+	for _, match := range macroReferenceRegex.FindAllStringSubmatch(rawSpec, -1) {
+		name := match[1]
+		if name == "" || specDirectives[name] || specSectionRegex.MatchString("%"+name) {
+			continue
+		}
+		referenced[name] = true
+	}
+
+	macros, specMacros = []string{}, []string{}
+	for name := range referenced {
+		if definedBySpec[name] || tagMacroRegex.MatchString(name) {
+			specMacros = append(specMacros, name)
+		} else {
+			macros = append(macros, name)
+		}
+	}
+
+	sort.Strings(macros)
+	sort.Strings(specMacros)
+
+	return
+}
+
+// diffLines returns the lines only found in newLines and the lines only found in oldLines, ignoring their order and
+// blank lines.
+func diffLines(oldLines, newLines []string) (added, removed []string) {
+	counts := make(map[string]int)
+	for _, line := range oldLines {
+		counts[line]++
+	}
+	for _, line := range newLines {
+		counts[line]--
+	}
+
+	added, removed = []string{}, []string{}
+	for _, line := range newLines {
+		if strings.TrimSpace(line) != "" && counts[line] < 0 {
+			added = append(added, line)
+			counts[line]++
+		}
+	}
+	for _, line := range oldLines {
+		if strings.TrimSpace(line) != "" && counts[line] > 0 {
+			removed = append(removed, line)
+			counts[line]--
+		}
+	}
+
+	return
+}
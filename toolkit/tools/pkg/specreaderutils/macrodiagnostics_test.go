@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package specreaderutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMacroSpec = `%global majorver 1
+%bcond_with docs
+Summary:        Example
+Name:           example
+Version:        %{majorver}.2
+Release:        1%{?dist}
+Source0:        https://example.com/%{name}-%{version}.tar.gz
+
+%description
+Costs 100%% of nothing.
+
+%prep
+%autosetup -p1
+
+%build
+%if %{with docs}
+%make_build docs
+%endif
+%{!?with_check:echo skipping tests}
+%cmake -DLIB=%{_lib} %(echo shell) %[1 + 1]
+
+%files
+%license LICENSE
+%{_bindir}/example
+`
+
+func TestReferencedMacros(t *testing.T) {
+	macros, specMacros := referencedMacros(testMacroSpec)
+
+	assert.Equal(t, []string{"_bindir", "_lib", "cmake", "dist", "make_build", "with", "with_check"}, macros)
+	assert.Equal(t, []string{"majorver", "name", "version"}, specMacros)
+}
+
+func TestDiffLines(t *testing.T) {
+	added, removed := diffLines(
+		[]string{"a", "b", "b", "", "c"},
+		[]string{"b", "c", "d", "", "", "d"},
+	)
+
+	assert.Equal(t, []string{"d", "d"}, added)
+	assert.Equal(t, []string{"a", "b"}, removed)
+}
+
+func TestNewMacroVariants(t *testing.T) {
+	variants, err := NewMacroVariants([]string{"aarch64"}, []string{"with_check=1", "dist=.azl4"})
+	require.NoError(t, err)
+	require.Len(t, variants, 3)
+
+	assert.Equal(t, &MacroVariant{Name: "arch=aarch64", Arch: "aarch64"}, variants[0])
+	assert.Equal(t, &MacroVariant{Name: "with_check=1", Defines: map[string]string{"with_check": "1"}}, variants[1])
+	assert.Equal(t, &MacroVariant{Name: "dist=.azl4", Defines: map[string]string{"dist": ".azl4"}}, variants[2])
+}
+
+func TestNewMacroVariantsInvalidDefine(t *testing.T) {
+	_, err := NewMacroVariants(nil, []string{"with_check"})
+	assert.Error(t, err)
+
+	_, err = NewMacroVariants(nil, []string{"=1"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package specreaderutils
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+)
+
+const (
+	packageSection   = "package"
+	filesSection     = "files"
+	patchlistSection = "patchlist"
+
+	nameTag    = "name"
+	licenseTag = "license"
+	urlTag     = "url"
+	patchTag   = "patch"
+
+	// fullNameFlag gives the full name of a subpackage in '%package', '%files' and similar sections.
+	fullNameFlag = "-n"
+	// fileListFlag reads a '%files' section from a file generated during the build, so its contents are unknown.
+	fileListFlag = "-f"
+)
+
+var (
+	// specSectionRegex matches the first line of a section in a spec.
+	specSectionRegex = regexp.MustCompile(`^%(package|description|prep|build|install|check|clean|files|changelog|pre|post|preun|postun|pretrans|posttrans|preuntrans|postuntrans|triggerprein|triggerin|triggerun|triggerpostun|filetriggerin|filetriggerun|filetriggerpostun|transfiletriggerin|transfiletriggerun|transfiletriggerpostun|verifyscript|generate_buildrequires|conf|patchlist|sourcelist)(\s.*)?$`)
+	// specTagRegex matches a tag in the preamble of a package, e.g. "Patch12: fix.patch" or "Requires(post): foo".
+	specTagRegex = regexp.MustCompile(`^([A-Za-z]+)(\d*)\s*(?:\([^)]*\))?\s*:\s*(.*?)\s*$`)
+
+	// Build conditionals are macro definitions, so they are only visible in the unexpanded spec.
+	bcondWithRegex    = regexp.MustCompile(`(?m)^\s*%bcond_with\s+(\S+)`)
+	bcondWithoutRegex = regexp.MustCompile(`(?m)^\s*%bcond_without\s+(\S+)`)
+	bcondRegex        = regexp.MustCompile(`(?m)^\s*%bcond\s+(\S+)\s+(\S+)`)
+)
+
+// readSpecMetadata reads the metadata of a spec, which isn't needed to build the package graph but by tools auditing
+// the specs (e.g. license scanning).
+func readSpecMetadata(specFile, sourceDir, arch string, defines map[string]string) (metadata *pkgjson.SpecMetadata, err error) {
+	rawSpec, err := os.ReadFile(specFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spec (%s):\n%w", specFile, err)
+	}
+
+	parsedSpec, err := rpm.ParseSPEC(specFile, sourceDir, arch, defines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse spec (%s):\n%w", specFile, err)
+	}
+
+	metadata = parseSpecMetadata(parsedSpec, string(rawSpec))
+	metadata.SpecPath = specFile
+
+	return
+}
+
+// parseSpecMetadata extracts the metadata of a spec from its parsed (macro expanded) and raw contents.
+func parseSpecMetadata(parsedSpec, rawSpec string) (metadata *pkgjson.SpecMetadata) {
+	metadata = &pkgjson.SpecMetadata{
+		Patches:           []*pkgjson.SpecPatch{},
+		BuildConditionals: parseBuildConditionals(rawSpec),
+		Subpackages:       []*pkgjson.Subpackage{},
+	}
+
+	var (
+		mainName          string
+		section           string
+		currentSubpackage *pkgjson.Subpackage
+		nextPatchNumber   int
+	)
+
+	subpackages := make(map[string]*pkgjson.Subpackage)
+	getSubpackage := func(name string) *pkgjson.Subpackage {
+		subpackage, found := subpackages[name]
+		if !found {
+			subpackage = &pkgjson.Subpackage{Name: name, Files: []string{}}
+			subpackages[name] = subpackage
+			metadata.Subpackages = append(metadata.Subpackages, subpackage)
+		}
+		return subpackage
+	}
+
+	addPatch := func(number, file string) {
+		if number != "" {
+			// A malformed number is rejected by rpm itself, so it can't be found in a parsed spec.
+			nextPatchNumber, _ = strconv.Atoi(number)
+		}
+		metadata.Patches = append(metadata.Patches, &pkgjson.SpecPatch{Number: nextPatchNumber, File: file})
+		nextPatchNumber++
+	}
+
+	for _, line := range strings.Split(parsedSpec, "\n") {
+		if match := specSectionRegex.FindStringSubmatch(line); match != nil {
+			section = match[1]
+			currentSubpackage = nil
+
+			if section == packageSection || section == filesSection {
+				currentSubpackage = getSubpackage(subpackageName(mainName, match[2]))
+			}
+			continue
+		}
+
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" || strings.HasPrefix(trimmedLine, "#") {
+			continue
+		}
+
+		switch section {
+		case "", packageSection:
+			match := specTagRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			tag, number, value := strings.ToLower(match[1]), match[2], match[3]
+			switch {
+			case tag == nameTag && section == "":
+				mainName = value
+				currentSubpackage = getSubpackage(mainName)
+			case tag == licenseTag && currentSubpackage != nil:
+				currentSubpackage.License = value
+			case tag == urlTag && section == "":
+				metadata.URL = value
+			case tag == patchTag:
+				addPatch(number, value)
+			}
+		case filesSection:
+			currentSubpackage.Files = append(currentSubpackage.Files, trimmedLine)
+		case patchlistSection:
+			addPatch("", trimmedLine)
+		}
+	}
+
+	if mainPackage, found := subpackages[mainName]; found {
+		metadata.License = mainPackage.License
+	}
+
+	for _, subpackage := range metadata.Subpackages {
+		if subpackage.License == "" {
+			subpackage.License = metadata.License
+		}
+	}
+
+	return
+}
+
+// subpackageName returns the name of the subpackage a '%package' or '%files' section with the given arguments is for.
+func subpackageName(mainName, sectionArgs string) string {
+	args := strings.Fields(sectionArgs)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case fullNameFlag:
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case fileListFlag:
+			// Skip the file list's path.
+			i++
+		default:
+			return fmt.Sprintf("%s-%s", mainName, args[i])
+		}
+	}
+
+	return mainName
+}
+
+// parseBuildConditionals returns the build conditionals of a spec, in the order they are declared.
+func parseBuildConditionals(rawSpec string) (conditionals []*pkgjson.BuildConditional) {
+	type declaration struct {
+		offset int
+		*pkgjson.BuildConditional
+	}
+
+	// Collect the declarations of all forms, then sort them by their position in the spec.
+	declarations := []declaration{}
+	addDeclarations := func(regex *regexp.Regexp, isEnabled func(match []int) bool) {
+		for _, match := range regex.FindAllStringSubmatchIndex(rawSpec, -1) {
+			conditional := &pkgjson.BuildConditional{Name: rawSpec[match[2]:match[3]], Default: isEnabled(match)}
+			declarations = append(declarations, declaration{offset: match[0], BuildConditional: conditional})
+		}
+	}
+
+	addDeclarations(bcondWithRegex, func([]int) bool { return false })
+	addDeclarations(bcondWithoutRegex, func([]int) bool { return true })
+	addDeclarations(bcondRegex, func(match []int) bool {
+		// The default of '%bcond' is an expression, which is usually a plain number.
+		return rawSpec[match[4]:match[5]] != "0"
+	})
+
+	sort.Slice(declarations, func(i, j int) bool {
+		return declarations[i].offset < declarations[j].offset
+	})
+
+	conditionals = []*pkgjson.BuildConditional{}
+	declared := make(map[string]bool)
+	for _, decl := range declarations {
+		if !declared[decl.Name] {
+			declared[decl.Name] = true
+			conditionals = append(conditionals, decl.BuildConditional)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package specreaderutils
+
+import (
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRawSpec = `%bcond_without tests
+%bcond_with docs
+%bcond bootstrap 0
+%bcond python 1
+Summary:        Compression library
+Name:           zlib
+`
+
+const testParsedSpec = `Summary:        Compression library
+Name:           zlib
+Version:        1.3.1
+Release:        1.azl3
+License:        Zlib
+URL:            https://www.zlib.net/
+Source0:        https://www.zlib.net/zlib-1.3.1.tar.xz
+Patch0:         CVE-2023-0001.patch
+Patch:          CVE-2023-0002.patch
+Patch10:        fix-build.patch
+Requires(post): /sbin/ldconfig
+
+%description
+License: not a tag of the package.
+
+%package        devel
+Summary:        Header files for zlib
+Requires:       zlib = 1.3.1-1.azl3
+
+%description    devel
+Header files for zlib.
+
+%package -n     minizip
+Summary:        Zip library
+License:        Zlib AND BSD-3-Clause
+
+%description -n minizip
+Zip library.
+
+%patchlist
+extra.patch
+
+%prep
+%autosetup -p1
+
+%files
+%license LICENSE
+%{_libdir}/libz.so.*
+
+%files devel
+# Headers
+%{_includedir}/zlib.h
+%dir %{_includedir}/zlib
+
+%files -n minizip -f minizip.lang
+%{_libdir}/libminizip.so.*
+
+%changelog
+* Mon Jan 01 2024 Azure Linux <azurelinux@microsoft.com> - 1.3.1-1
+- Patch: not a patch of the spec.
+`
+
+func TestParseSpecMetadata(t *testing.T) {
+	metadata := parseSpecMetadata(testParsedSpec, testRawSpec)
+
+	assert.Equal(t, "Zlib", metadata.License)
+	assert.Equal(t, "https://www.zlib.net/", metadata.URL)
+
+	assert.Equal(t, []*pkgjson.SpecPatch{
+		{Number: 0, File: "CVE-2023-0001.patch"},
+		{Number: 1, File: "CVE-2023-0002.patch"},
+		{Number: 10, File: "fix-build.patch"},
+		{Number: 11, File: "extra.patch"},
+	}, metadata.Patches)
+
+	assert.Equal(t, []*pkgjson.BuildConditional{
+		{Name: "tests", Default: true},
+		{Name: "docs", Default: false},
+		{Name: "bootstrap", Default: false},
+		{Name: "python", Default: true},
+	}, metadata.BuildConditionals)
+
+	require.Len(t, metadata.Subpackages, 3)
+	assert.Equal(t, &pkgjson.Subpackage{
+		Name:    "zlib",
+		License: "Zlib",
+		Files:   []string{"%license LICENSE", "%{_libdir}/libz.so.*"},
+	}, metadata.Subpackages[0])
+	assert.Equal(t, &pkgjson.Subpackage{
+		Name:    "zlib-devel",
+		License: "Zlib",
+		Files:   []string{"%{_includedir}/zlib.h", "%dir %{_includedir}/zlib"},
+	}, metadata.Subpackages[1])
+	assert.Equal(t, &pkgjson.Subpackage{
+		Name:    "minizip",
+		License: "Zlib AND BSD-3-Clause",
+		Files:   []string{"%{_libdir}/libminizip.so.*"},
+	}, metadata.Subpackages[2])
+}
+
+func TestSubpackageName(t *testing.T) {
+	assert.Equal(t, "zlib", subpackageName("zlib", ""))
+	assert.Equal(t, "zlib", subpackageName("zlib", " -f zlib.lang"))
+	assert.Equal(t, "zlib-devel", subpackageName("zlib", "  devel"))
+	assert.Equal(t, "zlib-devel", subpackageName("zlib", " -f devel.lang devel"))
+	assert.Equal(t, "minizip", subpackageName("zlib", " -n minizip -f minizip.lang"))
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/buildpipeline"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/directory"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
@@ -75,28 +76,43 @@ func WithReleaseVersionMacrosFile(file string) ChrootOption {
 
 // parseResult holds the worker results from parsing a SPEC file.
 type parseResult struct {
+	specFile string
 	packages []*pkgjson.Package
+	metadata *pkgjson.SpecMetadata
 	err      error
 }
 
+// SpecParseFailure is a SPEC file which couldn't be parsed.
+type SpecParseFailure struct {
+	SpecPath string `json:"SpecPath"`
+	Error    string `json:"Error"`
+}
+
+// ParseReport summarizes the parsing of all SPEC files.
+type ParseReport struct {
+	// ParsedSpecs is the number of SPECs which were parsed, including the ones which can't be built for the
+	// architecture.
+	ParsedSpecs int `json:"ParsedSpecs"`
+	// EmittedSpecs is the number of SPECs whose packages are in the output.
+	EmittedSpecs int                 `json:"EmittedSpecs"`
+	Failures     []*SpecParseFailure `json:"Failures"`
+}
+
+// specParser parses a single SPEC file. See readSpec.
+type specParser func(specFile string) (packages []*pkgjson.Package, metadata *pkgjson.SpecMetadata, err error)
+
 // ParseSPECsWrapper wraps parseSPECs to conditionally run it inside a chroot.
 // If workerTar is non-empty, parsing will occur inside a chroot, otherwise it will run on the host system.
 // releaseVersionMacrosFile, if non-empty, is made available inside the chroot at the same path as on the host.
-func ParseSPECsWrapper(buildDir, specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, outputFile, workerTar, releaseVersionMacrosFile, targetArch string, specListSet map[string]bool, toolchainRPMs []string, workers int, runCheck bool) (err error) {
+// A SPEC which fails to parse doesn't stop the others from being parsed. The failures are written to reportFile, if
+// non-empty. The output is only written if all SPECs were parsed, unless allowFailures is set, in which case it holds
+// the packages of all the valid SPECs.
+func ParseSPECsWrapper(buildDir, specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, outputFile, reportFile, workerTar, releaseVersionMacrosFile, targetArch string, specListSet map[string]bool, toolchainRPMs []string, workers int, runCheck, allowFailures bool) (err error) {
 	var (
-		chroot      *safechroot.Chroot
 		packageRepo *pkgjson.PackageRepo
+		report      *ParseReport
 	)
 
-	if workerTar != "" {
-		const leaveFilesOnDisk = false
-		chroot, err = CreateChroot("specparser_chroot", workerTar, buildDir, specsDir, WithSrpmsDir(srpmsDir), WithReleaseVersionMacrosFile(releaseVersionMacrosFile))
-		if err != nil {
-			return
-		}
-		defer chroot.Close(leaveFilesOnDisk)
-	}
-
 	buildArch, err := rpm.GetRpmArch(runtime.GOARCH)
 	if err != nil {
 		return
@@ -106,13 +122,13 @@ func ParseSPECsWrapper(buildDir, specsDir, rpmsDir, srpmsDir, toolchainDir, dist
 		var parseError error
 
 		if targetArch == "" {
-			packageRepo, parseError = parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, buildArch, specListSet, toolchainRPMs, workers, runCheck)
+			packageRepo, report, parseError = parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, buildArch, specListSet, toolchainRPMs, workers, runCheck)
 			if parseError != nil {
 				err := fmt.Errorf("failed to parse native specs:\n%w", parseError)
 				return err
 			}
 		} else {
-			packageRepo, parseError = parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, targetArch, specListSet, toolchainRPMs, workers, runCheck)
+			packageRepo, report, parseError = parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, targetArch, specListSet, toolchainRPMs, workers, runCheck)
 			if parseError != nil {
 				err := fmt.Errorf("failed to parse cross specs:\n%w", parseError)
 				return err
@@ -122,18 +138,31 @@ func ParseSPECsWrapper(buildDir, specsDir, rpmsDir, srpmsDir, toolchainDir, dist
 		return parseError
 	}
 
-	if chroot != nil {
-		logger.Log.Info("Parsing SPECs inside a chroot environment")
-		err = chroot.Run(doParse)
-	} else {
-		logger.Log.Info("Parsing SPECs in the host environment")
-		err = doParse()
-	}
-
+	err = runSpecParsing(buildDir, specsDir, srpmsDir, workerTar, releaseVersionMacrosFile, doParse)
 	if err != nil {
 		return
 	}
 
+	return writeParseOutput(packageRepo, report, outputFile, reportFile, allowFailures)
+}
+
+// writeParseOutput writes the report to reportFile, if non-empty, and the packages to outputFile. The packages are only
+// written if all SPECs were parsed, unless allowFailures is set.
+func writeParseOutput(packageRepo *pkgjson.PackageRepo, report *ParseReport, outputFile, reportFile string, allowFailures bool) (err error) {
+	if reportFile != "" {
+		err = jsonutils.WriteJSONFile(reportFile, report)
+		if err != nil {
+			return fmt.Errorf("failed to write the parse report (%s):\n%w", reportFile, err)
+		}
+	}
+
+	if len(report.Failures) != 0 {
+		if !allowFailures {
+			return fmt.Errorf("failed to parse %d spec(s), see the errors above", len(report.Failures))
+		}
+		logger.Log.Warnf("Failed to parse %d spec(s), their packages are missing from (%s)", len(report.Failures), outputFile)
+	}
+
 	b, err := json.MarshalIndent(packageRepo, "", "  ")
 	if err != nil {
 		logger.Log.Error("Unable to marshal package info JSON")
@@ -149,6 +178,24 @@ func ParseSPECsWrapper(buildDir, specsDir, rpmsDir, srpmsDir, toolchainDir, dist
 	return
 }
 
+// runSpecParsing runs doParse inside a chroot created from workerTar, or in the host environment if workerTar is empty.
+func runSpecParsing(buildDir, specsDir, srpmsDir, workerTar, releaseVersionMacrosFile string, doParse func() error) (err error) {
+	if workerTar == "" {
+		logger.Log.Info("Parsing SPECs in the host environment")
+		return doParse()
+	}
+
+	const leaveFilesOnDisk = false
+	chroot, err := CreateChroot("specparser_chroot", workerTar, buildDir, specsDir, WithSrpmsDir(srpmsDir), WithReleaseVersionMacrosFile(releaseVersionMacrosFile))
+	if err != nil {
+		return
+	}
+	defer chroot.Close(leaveFilesOnDisk)
+
+	logger.Log.Info("Parsing SPECs inside a chroot environment")
+	return chroot.Run(doParse)
+}
+
 // CreateChroot creates a chroot to parse SPECs inside of.
 // Required parameters are chrootName, workerTar, buildDir, and specsDir.
 // Optional configuration can be provided via ChrootOption functions:
@@ -170,12 +217,17 @@ func CreateChroot(chrootName, workerTar, buildDir, specsDir string, opts ...Chro
 	// the pathing needs to be preserved from the host system.
 	var extraDirectories []string
 
-	extraMountPoints := []*safechroot.MountPoint{
-		safechroot.NewMountPoint(specsDir, specsDir, "", safechroot.BindMountPointFlags, ""),
+	extraMountSpecs := []safechroot.MountSpec{
+		{Source: specsDir, Target: specsDir, Bind: true},
 	}
 
 	if cfg.SrpmsDir != "" {
-		extraMountPoints = append(extraMountPoints, safechroot.NewMountPoint(cfg.SrpmsDir, cfg.SrpmsDir, "", safechroot.BindMountPointFlags, ""))
+		extraMountSpecs = append(extraMountSpecs, safechroot.MountSpec{Source: cfg.SrpmsDir, Target: cfg.SrpmsDir, Bind: true})
+	}
+
+	extraMountPoints, err := safechroot.NewMountPointsFromSpecs(extraMountSpecs)
+	if err != nil {
+		return
 	}
 
 	chrootDir := filepath.Join(buildDir, chrootName)
@@ -240,20 +292,35 @@ func FindSpecFiles(specsDir string, specListSet map[string]bool) (specFiles []st
 	return
 }
 
-// parseSPECs will parse all specs in specsDir and return a summary of the SPECs.
-func parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, arch string, specListSet map[string]bool, toolchainRPMs []string, workers int, runCheck bool) (packageRepo *pkgjson.PackageRepo, err error) {
+// parseSPECs will parse all specs in specsDir and return a summary of the SPECs which could be parsed, along with a
+// report of the SPECs which couldn't be.
+func parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, arch string, specListSet map[string]bool, toolchainRPMs []string, workers int, runCheck bool) (packageRepo *pkgjson.PackageRepo, report *ParseReport, err error) {
+	specFiles, err := FindSpecFiles(specsDir, specListSet)
+	if err != nil {
+		return
+	}
+
+	parse := func(specFile string) ([]*pkgjson.Package, *pkgjson.SpecMetadata, error) {
+		noCheckDefines := rpm.DefaultDistroDefines(false, distTag)
+		checkDefines := rpm.DefaultDistroDefines(true, distTag)
+		return readSpec(specFile, rpmsDir, srpmsDir, toolchainDir, toolchainRPMs, runCheck, arch, noCheckDefines, checkDefines)
+	}
+
+	packageRepo, report = parseSpecFiles(specFiles, workers, parse)
+	return
+}
+
+// parseSpecFiles parses the spec files concurrently. A spec which fails to parse is reported, without affecting the
+// other specs.
+func parseSpecFiles(specFiles []string, workers int, parse specParser) (packageRepo *pkgjson.PackageRepo, report *ParseReport) {
 	var (
-		packageList []*pkgjson.Package
-		wg          sync.WaitGroup
-		specFiles   []string
+		packageList  []*pkgjson.Package
+		metadataList []*pkgjson.SpecMetadata
+		wg           sync.WaitGroup
 	)
 
 	packageRepo = &pkgjson.PackageRepo{}
-
-	specFiles, err = FindSpecFiles(specsDir, specListSet)
-	if err != nil {
-		return
-	}
+	report = &ParseReport{}
 
 	tsRoot, _ := timestamp.StartEvent("parse specs", nil)
 	defer timestamp.StopEvent(nil)
@@ -266,7 +333,7 @@ func parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, arch string,
 	// Start the workers now so they begin working as soon as a new job is buffered.
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go readSpecWorker(ctx, requests, results, &wg, distTag, rpmsDir, srpmsDir, toolchainDir, toolchainRPMs, runCheck, arch, tsRoot)
+		go readSpecWorker(ctx, requests, results, &wg, parse, tsRoot)
 	}
 
 	for _, specFile := range specFiles {
@@ -279,21 +346,29 @@ func parseSPECs(specsDir, rpmsDir, srpmsDir, toolchainDir, distTag, arch string,
 	for i := 0; i < len(specFiles); i++ {
 		parseResult := <-results
 		if parseResult.err != nil {
-			err = parseResult.err
-			closeCtx()
-			break
+			logger.Log.Errorf("Failed to parse (%s):\n%s", parseResult.specFile, parseResult.err)
+			report.Failures = append(report.Failures, &SpecParseFailure{SpecPath: parseResult.specFile, Error: parseResult.err.Error()})
+			continue
 		}
+
+		report.ParsedSpecs++
 		packageList = append(packageList, parseResult.packages...)
+		// Specs which can't be built for the architecture don't have any metadata.
+		if parseResult.metadata != nil {
+			metadataList = append(metadataList, parseResult.metadata)
+		}
 	}
 
 	logger.Log.Debug("Waiting for outstanding workers to finish")
 	wg.Wait()
 
-	if err != nil {
-		return
-	}
+	sort.Slice(report.Failures, func(i, j int) bool {
+		return report.Failures[i].SpecPath < report.Failures[j].SpecPath
+	})
 
 	packageRepo.Repo = packageList
+	packageRepo.Specs = metadataList
+	report.EmittedSpecs = len(metadataList)
 	sortPackages(packageRepo)
 
 	return
@@ -309,6 +384,10 @@ func sortPackages(packageRepo *pkgjson.PackageRepo) {
 		return strings.Compare(iName, jName) < 0
 	})
 
+	sort.Slice(packageRepo.Specs, func(i, j int) bool {
+		return packageRepo.Specs[i].SpecPath < packageRepo.Specs[j].SpecPath
+	})
+
 	for _, pkg := range packageRepo.Repo {
 		sort.Slice(pkg.Requires, func(i, j int) bool {
 			iName := pkg.Requires[i].Name + pkg.Requires[i].Version
@@ -326,18 +405,9 @@ func sortPackages(packageRepo *pkgjson.PackageRepo) {
 // readSpecWorker is a goroutine that takes a full filepath to a spec file and scrapes it into the Specdef structure
 // Concurrency is limited by the size of the semaphore channel passed in. Too many goroutines at once can deplete
 // available file handles.
-func readSpecWorker(ctx context.Context, requests <-chan string, results chan<- *parseResult, wg *sync.WaitGroup, distTag, rpmsDir, srpmsDir, toolchainDir string, toolchainRPMs []string, runCheck bool, arch string, tsRoot *timestamp.TimeStamp) {
-	const (
-		querySrpm             = `%{NAME}-%{VERSION}-%{RELEASE}.src.rpm`
-		queryProvidedPackages = `rpm %{ARCH}/%{nvra}.rpm\n[provides %{PROVIDENEVRS}\n][requires %{REQUIRENEVRS}\n][arch %{ARCH}\n]`
-	)
-
+func readSpecWorker(ctx context.Context, requests <-chan string, results chan<- *parseResult, wg *sync.WaitGroup, parse specParser, tsRoot *timestamp.TimeStamp) {
 	defer wg.Done()
 
-	noCheckDefines := rpm.DefaultDistroDefines(false, distTag)
-	checkDefines := rpm.DefaultDistroDefines(true, distTag)
-
-	var ts *timestamp.TimeStamp = nil
 	for specFile := range requests {
 		select {
 		case <-ctx.Done():
@@ -346,91 +416,108 @@ func readSpecWorker(ctx context.Context, requests <-chan string, results chan<-
 		default:
 		}
 
-		// Many code paths hit 'continue', finish timing those here.
-		if ts != nil {
-			timestamp.StopEvent(ts)
-			ts = nil
-		}
-		ts, _ = timestamp.StartEvent(filepath.Base(specFile), tsRoot)
+		ts, _ := timestamp.StartEvent(filepath.Base(specFile), tsRoot)
 
-		providerList := []*pkgjson.Package{}
-		sourceDir := filepath.Dir(specFile)
-		testBuildRequiresList := []*pkgjson.PackageVer{}
+		result := parseSpecFile(specFile, parse)
 
-		// Find the SRPM associated with the SPEC.
-		srpmResults, err := rpm.QuerySPEC(specFile, sourceDir, querySrpm, arch, noCheckDefines, rpm.QueryHeaderArgument)
-		if err != nil {
-			sendEmptyResult(results, err)
-			continue
-		}
+		timestamp.StopEvent(ts)
+		results <- result
+	}
+}
 
-		srpmPath := filepath.Join(srpmsDir, srpmResults[0])
+// parseSpecFile parses a single spec file. A spec which makes the parsing panic is reported as an error, so it doesn't
+// stop the other specs from being parsed.
+func parseSpecFile(specFile string, parse specParser) (result *parseResult) {
+	result = &parseResult{specFile: specFile}
 
-		isCompatible, err := rpm.SpecArchIsCompatible(specFile, sourceDir, arch, noCheckDefines)
-		if err != nil {
-			sendEmptyResult(results, err)
-			continue
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.packages, result.metadata = nil, nil
+			result.err = fmt.Errorf("unexpected error while parsing the spec: %v", recovered)
 		}
+	}()
 
-		if !isCompatible {
-			logger.Log.Debugf(`Skipping (%s) since it cannot be built on current architecture.`, specFile)
-			sendEmptyResult(results, err)
-			continue
-		}
+	result.packages, result.metadata, result.err = parse(specFile)
+	return
+}
 
-		// Find every package that the spec provides
-		queryResults, err := rpm.QuerySPEC(specFile, sourceDir, queryProvidedPackages, arch, noCheckDefines, rpm.QueryBuiltRPMHeadersArgument)
-		if err != nil {
-			sendEmptyResult(results, err)
-			continue
-		}
+// readSpec parses a single spec file. Returns no packages or metadata if the spec can't be built for the architecture.
+func readSpec(specFile, rpmsDir, srpmsDir, toolchainDir string, toolchainRPMs []string, runCheck bool, arch string, noCheckDefines, checkDefines map[string]string) (providerList []*pkgjson.Package, metadata *pkgjson.SpecMetadata, err error) {
+	const (
+		querySrpm             = `%{NAME}-%{VERSION}-%{RELEASE}.src.rpm`
+		queryProvidedPackages = `rpm %{ARCH}/%{nvra}.rpm\n[provides %{PROVIDENEVRS}\n][requires %{REQUIRENEVRS}\n][arch %{ARCH}\n]`
+	)
 
-		if len(queryResults) != 0 {
-			providerList, err = parseProvides(rpmsDir, toolchainDir, toolchainRPMs, srpmPath, queryResults)
-			if err != nil {
-				sendEmptyResult(results, err)
-				continue
-			}
-		}
+	sourceDir := filepath.Dir(specFile)
+	testBuildRequiresList := []*pkgjson.PackageVer{}
 
-		// Query the BuildRequires fields from this spec and turn them into an array of PackageVersions
-		buildRequiresList, err := readBuildRequires(specFile, sourceDir, arch, noCheckDefines)
-		if err != nil {
-			sendEmptyResult(results, err)
-			continue
-		}
+	// Find the SRPM associated with the SPEC.
+	srpmResults, err := rpm.QuerySPEC(specFile, sourceDir, querySrpm, arch, noCheckDefines, rpm.QueryHeaderArgument)
+	if err != nil {
+		return
+	}
 
-		specHasCheckSection, err := rpm.SpecHasCheckSection(specFile, sourceDir, arch, checkDefines)
+	srpmPath := filepath.Join(srpmsDir, srpmResults[0])
+
+	isCompatible, err := rpm.SpecArchIsCompatible(specFile, sourceDir, arch, noCheckDefines)
+	if err != nil {
+		return
+	}
+
+	if !isCompatible {
+		logger.Log.Debugf(`Skipping (%s) since it cannot be built on current architecture.`, specFile)
+		return
+	}
+
+	// Find every package that the spec provides
+	queryResults, err := rpm.QuerySPEC(specFile, sourceDir, queryProvidedPackages, arch, noCheckDefines, rpm.QueryBuiltRPMHeadersArgument)
+	if err != nil {
+		return
+	}
+
+	if len(queryResults) != 0 {
+		providerList, err = parseProvides(rpmsDir, toolchainDir, toolchainRPMs, srpmPath, queryResults)
 		if err != nil {
-			sendEmptyResult(results, err)
-			continue
+			return
 		}
+	}
 
-		readTestDependencies := runCheck && specHasCheckSection
-		if readTestDependencies {
-			// Query the test BuildRequires fields from this spec and turn them into an array of PackageVersions
-			testBuildRequiresList, err = readBuildRequires(specFile, sourceDir, arch, checkDefines)
-			if err != nil {
-				sendEmptyResult(results, err)
-				continue
-			}
-		}
+	// Query the BuildRequires fields from this spec and turn them into an array of PackageVersions
+	buildRequiresList, err := readBuildRequires(specFile, sourceDir, arch, noCheckDefines)
+	if err != nil {
+		return
+	}
+
+	specHasCheckSection, err := rpm.SpecHasCheckSection(specFile, sourceDir, arch, checkDefines)
+	if err != nil {
+		return
+	}
 
-		// Every package provided by a spec will have the same BuildRequires and SrpmPath
-		for _, provider := range providerList {
-			provider.BuildRequires = buildRequiresList
-			provider.SourceDir = sourceDir
-			provider.SpecPath = specFile
-			provider.TestRequires = testBuildRequiresList
-			provider.RunTests = readTestDependencies
+	readTestDependencies := runCheck && specHasCheckSection
+	if readTestDependencies {
+		// Query the test BuildRequires fields from this spec and turn them into an array of PackageVersions
+		testBuildRequiresList, err = readBuildRequires(specFile, sourceDir, arch, checkDefines)
+		if err != nil {
+			return
 		}
+	}
 
-		// Submit the result to the main thread, the deferred function will clear the semaphore.
-		results <- &parseResult{packages: providerList}
+	metadata, err = readSpecMetadata(specFile, sourceDir, arch, noCheckDefines)
+	if err != nil {
+		return
 	}
-	if ts != nil {
-		timestamp.StopEvent(ts)
+	metadata.SrpmPath = srpmPath
+
+	// Every package provided by a spec will have the same BuildRequires and SrpmPath
+	for _, provider := range providerList {
+		provider.BuildRequires = buildRequiresList
+		provider.SourceDir = sourceDir
+		provider.SpecPath = specFile
+		provider.TestRequires = testBuildRequiresList
+		provider.RunTests = readTestDependencies
 	}
+
+	return
 }
 
 // parseProvides parses a newline separated list of Provides, Requires, and Arch from a single spec file.
@@ -738,7 +825,3 @@ func readBuildRequires(specFile, sourceDir, arch string, defines map[string]stri
 
 	return
 }
-
-func sendEmptyResult(results chan<- *parseResult, err error) {
-	results <- &parseResult{err: err}
-}
@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package specreaderutils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// testSpecParser parses "good.spec" and "other-arch.spec" (which can't be built for the architecture), fails to parse
+// "broken.spec" and panics on "panic.spec".
+func testSpecParser(specFile string) ([]*pkgjson.Package, *pkgjson.SpecMetadata, error) {
+	switch filepath.Base(specFile) {
+	case "good.spec":
+		packages := []*pkgjson.Package{{Provides: &pkgjson.PackageVer{Name: "good"}, SpecPath: specFile}}
+		return packages, &pkgjson.SpecMetadata{SpecPath: specFile}, nil
+
+	case "other-arch.spec":
+		return nil, nil, nil
+
+	case "broken.spec":
+		return nil, nil, errors.New("line 12: Unknown tag")
+
+	default:
+		panic("unexpected spec")
+	}
+}
+
+func TestParseSpecFilesIsolatesFailures(t *testing.T) {
+	specFiles := []string{"/specs/panic.spec", "/specs/good.spec", "/specs/broken.spec", "/specs/other-arch.spec"}
+
+	packageRepo, report := parseSpecFiles(specFiles, 2, testSpecParser)
+
+	require.Len(t, packageRepo.Repo, 1)
+	assert.Equal(t, "good", packageRepo.Repo[0].Provides.Name)
+	assert.Equal(t, []*pkgjson.SpecMetadata{{SpecPath: "/specs/good.spec"}}, packageRepo.Specs)
+
+	assert.Equal(t, &ParseReport{
+		ParsedSpecs:  2,
+		EmittedSpecs: 1,
+		Failures: []*SpecParseFailure{
+			{SpecPath: "/specs/broken.spec", Error: "line 12: Unknown tag"},
+			{SpecPath: "/specs/panic.spec", Error: "unexpected error while parsing the spec: unexpected spec"},
+		},
+	}, report)
+}
+
+func TestWriteParseOutputFailures(t *testing.T) {
+	testDir := t.TempDir()
+	outputFile := filepath.Join(testDir, "specs.json")
+	reportFile := filepath.Join(testDir, "report.json")
+
+	packageRepo, report := parseSpecFiles([]string{"/specs/good.spec", "/specs/broken.spec"}, 1, testSpecParser)
+
+	err := writeParseOutput(packageRepo, report, outputFile, reportFile, false)
+	assert.ErrorContains(t, err, "failed to parse 1 spec(s)")
+	assert.NoFileExists(t, outputFile)
+
+	writtenReport := ParseReport{}
+	err = jsonutils.ReadJSONFile(reportFile, &writtenReport)
+	require.NoError(t, err)
+	assert.Equal(t, *report, writtenReport)
+
+	// With --allow-parse-failures, the packages of the valid specs are written.
+	err = writeParseOutput(packageRepo, report, outputFile, reportFile, true)
+	require.NoError(t, err)
+
+	writtenRepo := pkgjson.PackageRepo{}
+	err = jsonutils.ReadJSONFile(outputFile, &writtenRepo)
+	require.NoError(t, err)
+	require.Len(t, writtenRepo.Repo, 1)
+	assert.Equal(t, "good", writtenRepo.Repo[0].Provides.Name)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package tenant isolates the builds of multiple tenants (e.g. teams) that share a build machine.
+//
+// Each tenant gets its own namespace within the shared build and build state directories, and within the image
+// caches. So, tenants never see each other's intermediate files, build state, cached images, or locks. A tenant's
+// workspace can also be given a disk quota. The caches don't count against the quota.
+package tenant
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	// TenantsDirName is the name of the directory, within a shared directory, that holds the tenants' directories.
+	TenantsDirName = "tenants"
+)
+
+var (
+	nameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+)
+
+// ValidateName returns an error if the tenant name can't be used as a directory name.
+func ValidateName(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid tenant name (%s): must be 1-63 characters long, start with a letter or digit, and "+
+			"only contain letters, digits, '_', '.', or '-'", name)
+	}
+
+	return nil
+}
+
+// Dir returns the tenant's directory within a shared directory.
+func Dir(sharedDir string, name string) (string, error) {
+	err := ValidateName(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(sharedDir, TenantsDirName, name), nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestValidateName(t *testing.T) {
+	assert.NoError(t, ValidateName("team-a"))
+	assert.NoError(t, ValidateName("Team_B.1"))
+
+	assert.ErrorContains(t, ValidateName(""), "invalid tenant name ()")
+	assert.ErrorContains(t, ValidateName(".."), "invalid tenant name (..)")
+	assert.ErrorContains(t, ValidateName("a/b"), "invalid tenant name (a/b)")
+	assert.ErrorContains(t, ValidateName("-a"), "invalid tenant name (-a)")
+}
+
+func TestDir(t *testing.T) {
+	dir, err := Dir("/build", "team-a")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/build", TenantsDirName, "team-a"), dir)
+
+	_, err = Dir("/build", "../team-a")
+	assert.ErrorContains(t, err, "invalid tenant name")
+}
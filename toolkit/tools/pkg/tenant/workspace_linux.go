@@ -0,0 +1,272 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package tenant
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/leaselock"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+const (
+	lockFileSuffix = ".lock"
+
+	// DefaultQuotaCheckInterval is how often EnforceQuota checks the tenant's disk usage.
+	DefaultQuotaCheckInterval = 10 * time.Second
+)
+
+// Workspace is a tenant's namespace within the shared build directory and (optionally) the shared build state
+// directory.
+//
+// While a workspace is open, it holds an exclusive lease lock. So, only a single build of a tenant runs at a time,
+// while the builds of different tenants run in parallel. The lock of a build that crashed is broken once its lease
+// expires (or straight away, if the build ran on the same host).
+type Workspace struct {
+	Name string
+	// BuildDir is the tenant's build directory.
+	BuildDir string
+	// BuildStateDir is the tenant's build state directory. Empty if no shared build state directory was provided.
+	BuildStateDir string
+	// Quota is the maximum number of bytes that the tenant's directories and outputs may use. 0 means unlimited.
+	Quota uint64
+	// Outputs are the paths (or glob patterns) of the build's outputs, which count against the quota along with the
+	// tenant's directories.
+	Outputs []string
+
+	lock *leaselock.Lock
+}
+
+// OpenWorkspace creates (if needed) and locks a tenant's workspace.
+func OpenWorkspace(sharedBuildDir string, sharedBuildStateDir string, name string, quota uint64,
+) (*Workspace, error) {
+	buildDir, err := Dir(sharedBuildDir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	buildStateDir := ""
+	if sharedBuildStateDir != "" {
+		buildStateDir, err = Dir(sharedBuildStateDir, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.MkdirAll(buildDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tenant (%s) build directory:\n%w", name, err)
+	}
+
+	// The lock file is placed next to the build directory, so that it isn't counted against the quota and it isn't
+	// removed if the build directory is cleaned.
+	lock, holder, err := leaselock.TryAcquire(buildDir+lockFileSuffix, leaselock.DefaultLeaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock tenant (%s) workspace:\n%w", name, err)
+	}
+	if lock == nil {
+		if holder != nil {
+			return nil, fmt.Errorf("tenant (%s) workspace is in use by another build (%s)", name, holder)
+		}
+		return nil, fmt.Errorf("tenant (%s) workspace is in use by another build", name)
+	}
+
+	workspace := &Workspace{
+		Name:          name,
+		BuildDir:      buildDir,
+		BuildStateDir: buildStateDir,
+		Quota:         quota,
+		lock:          lock,
+	}
+
+	logger.Log.Debugf("Opened tenant (%s) workspace (%s)", name, buildDir)
+	return workspace, nil
+}
+
+// Close releases the workspace's lock.
+func (w *Workspace) Close() error {
+	if w.lock == nil {
+		return nil
+	}
+
+	err := w.lock.Release()
+	w.lock = nil
+	return err
+}
+
+// Usage returns the number of bytes used by the tenant's directories and the build's outputs.
+func (w *Workspace) Usage() (uint64, error) {
+	paths := []string{w.BuildDir}
+	if w.BuildStateDir != "" {
+		paths = append(paths, w.BuildStateDir)
+	}
+
+	for _, output := range w.Outputs {
+		matches, err := filepath.Glob(output)
+		if err != nil {
+			return 0, fmt.Errorf("invalid output path (%s):\n%w", output, err)
+		}
+
+		paths = append(paths, matches...)
+	}
+
+	return DiskUsage(paths...)
+}
+
+// CheckQuota returns an error if the tenant's directories and the build's outputs use more than the tenant's quota.
+func (w *Workspace) CheckQuota() error {
+	if w.Quota == 0 {
+		return nil
+	}
+
+	usage, err := w.Usage()
+	if err != nil {
+		return fmt.Errorf("failed to get tenant (%s) disk usage:\n%w", w.Name, err)
+	}
+
+	if usage > w.Quota {
+		return fmt.Errorf("tenant (%s) workspace uses %d bytes, which exceeds its quota of %d bytes", w.Name, usage,
+			w.Quota)
+	}
+
+	return nil
+}
+
+// EnforceQuota checks the quota every interval while a build runs, and calls onExceeded (once) as soon as the
+// tenant goes over it, so that the build can be stopped before it fills up the shared disk. Failing to get the disk
+// usage (e.g. due to the build removing files while they are counted) is only logged. Returns a function that stops
+// the checks.
+func (w *Workspace) EnforceQuota(interval time.Duration, onExceeded func(err error)) (stop func()) {
+	if w.Quota == 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := sync.WaitGroup{}
+	stopped.Add(1)
+
+	go func() {
+		defer stopped.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			usage, err := w.Usage()
+			if err != nil {
+				logger.Log.Warnf("Failed to get tenant (%s) disk usage:\n%v", w.Name, err)
+				continue
+			}
+
+			if usage > w.Quota {
+				onExceeded(fmt.Errorf("tenant (%s) workspace uses %d bytes, which exceeds its quota of %d bytes",
+					w.Name, usage, w.Quota))
+				return
+			}
+		}
+	}()
+
+	var stopOnce sync.Once
+	return func() {
+		stopOnce.Do(func() {
+			close(done)
+			stopped.Wait()
+		})
+	}
+}
+
+// DiskUsage returns the number of bytes allocated to the files within a set of files and directories. Sparse files
+// only count the blocks that are allocated, hard links are only counted once, and mounted filesystems are skipped.
+// Paths that don't exist are ignored.
+func DiskUsage(paths ...string) (uint64, error) {
+	seen := make(map[inode]bool)
+	usage := uint64(0)
+	for _, path := range paths {
+		pathUsage, err := diskUsage(path, seen)
+		if err != nil {
+			return 0, err
+		}
+
+		usage += pathUsage
+	}
+
+	return usage, nil
+}
+
+// inode identifies a file, so that hard links are only counted once.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+func diskUsage(dir string, seen map[inode]bool) (uint64, error) {
+	rootInfo, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rootStat, ok := rootInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("failed to stat (%s)", dir)
+	}
+
+	usage := uint64(0)
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			// The file was removed while walking.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("failed to stat (%s)", path)
+		}
+
+		if stat.Dev != rootStat.Dev {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		key := inode{uint64(stat.Dev), stat.Ino}
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		// Blocks is always in units of 512 bytes.
+		usage += uint64(stat.Blocks) * 512
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get disk usage of (%s):\n%w", dir, err)
+	}
+
+	return usage, nil
+}
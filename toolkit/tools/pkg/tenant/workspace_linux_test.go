@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenWorkspace(t *testing.T) {
+	buildDir := t.TempDir()
+	buildStateDir := t.TempDir()
+
+	workspace, err := OpenWorkspace(buildDir, buildStateDir, "team-a", 0)
+	require.NoError(t, err)
+	defer workspace.Close()
+
+	assert.Equal(t, filepath.Join(buildDir, TenantsDirName, "team-a"), workspace.BuildDir)
+	assert.Equal(t, filepath.Join(buildStateDir, TenantsDirName, "team-a"), workspace.BuildStateDir)
+	assert.DirExists(t, workspace.BuildDir)
+
+	// Only one build of a tenant may run at a time.
+	_, err = OpenWorkspace(buildDir, buildStateDir, "team-a", 0)
+	assert.ErrorContains(t, err, "tenant (team-a) workspace is in use by another build")
+
+	// But other tenants aren't blocked.
+	otherWorkspace, err := OpenWorkspace(buildDir, buildStateDir, "team-b", 0)
+	if assert.NoError(t, err) {
+		otherWorkspace.Close()
+	}
+
+	err = workspace.Close()
+	assert.NoError(t, err)
+
+	workspace, err = OpenWorkspace(buildDir, "", "team-a", 0)
+	require.NoError(t, err)
+	defer workspace.Close()
+	assert.Equal(t, "", workspace.BuildStateDir)
+}
+
+func TestWorkspaceCheckQuota(t *testing.T) {
+	buildDir := t.TempDir()
+
+	workspace, err := OpenWorkspace(buildDir, "", "team-a", 64*1024)
+	require.NoError(t, err)
+	defer workspace.Close()
+
+	err = workspace.CheckQuota()
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(workspace.BuildDir, "image.raw"), make([]byte, 1024*1024), 0o644)
+	require.NoError(t, err)
+
+	err = workspace.CheckQuota()
+	assert.ErrorContains(t, err, "tenant (team-a) workspace uses")
+	assert.ErrorContains(t, err, "which exceeds its quota of 65536 bytes")
+}
+
+func TestWorkspaceCheckQuotaCountsOutputs(t *testing.T) {
+	buildDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	workspace, err := OpenWorkspace(buildDir, "", "team-a", 64*1024)
+	require.NoError(t, err)
+	defer workspace.Close()
+
+	workspace.Outputs = []string{filepath.Join(outputDir, "image*")}
+
+	err = os.WriteFile(filepath.Join(outputDir, "other.raw"), make([]byte, 1024*1024), 0o644)
+	require.NoError(t, err)
+
+	err = workspace.CheckQuota()
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(outputDir, "image.raw"), make([]byte, 1024*1024), 0o644)
+	require.NoError(t, err)
+
+	err = workspace.CheckQuota()
+	assert.ErrorContains(t, err, "which exceeds its quota of 65536 bytes")
+}
+
+func TestWorkspaceEnforceQuota(t *testing.T) {
+	buildDir := t.TempDir()
+
+	workspace, err := OpenWorkspace(buildDir, "", "team-a", 64*1024)
+	require.NoError(t, err)
+	defer workspace.Close()
+
+	exceeded := make(chan error, 1)
+	stop := workspace.EnforceQuota(10*time.Millisecond, func(err error) {
+		exceeded <- err
+	})
+	defer stop()
+
+	select {
+	case err := <-exceeded:
+		t.Fatalf("quota exceeded too early: %s", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = os.WriteFile(filepath.Join(workspace.BuildDir, "image.raw"), make([]byte, 1024*1024), 0o644)
+	require.NoError(t, err)
+
+	select {
+	case err := <-exceeded:
+		assert.ErrorContains(t, err, "tenant (team-a) workspace uses")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "quota wasn't enforced")
+	}
+
+	// Stopping twice (e.g. explicitly and deferred) is fine.
+	stop()
+}
+
+func TestDiskUsage(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "a"), make([]byte, 1024*1024), 0o644)
+	require.NoError(t, err)
+
+	// Hard links are only counted once.
+	err = os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b"))
+	require.NoError(t, err)
+
+	// Sparse files only count their allocated blocks.
+	sparseFile, err := os.Create(filepath.Join(dir, "sparse"))
+	require.NoError(t, err)
+	err = sparseFile.Truncate(1024 * 1024 * 1024)
+	sparseFile.Close()
+	require.NoError(t, err)
+
+	usage, err := DiskUsage(dir)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, usage, uint64(1024*1024))
+	assert.Less(t, usage, uint64(2*1024*1024))
+
+	usage, err = DiskUsage(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), usage)
+
+	// Hard links are only counted once across paths too.
+	linkDir := t.TempDir()
+	err = os.Link(filepath.Join(dir, "a"), filepath.Join(linkDir, "c"))
+	if err == nil {
+		usage, err = DiskUsage(dir, linkDir)
+		assert.NoError(t, err)
+		assert.Less(t, usage, uint64(2*1024*1024))
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// A tool for building and querying an index of package metadata (owners, license, upstream URL, build flags).
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/pkginfo"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	app = kingpin.New("pkginfo", "A tool for building and querying an index of package metadata.")
+
+	logFlags = exe.SetupLogFlags(app)
+
+	buildCmd       = app.Command("build", "Build the package info database from the spec directories.")
+	specsDirs      = buildCmd.Flag("specs-dir", "Directory containing spec directories. May be specified multiple times.").Required().ExistingDirs()
+	codeOwnersFile = buildCmd.Flag("codeowners", "Path to the CODEOWNERS file used to map specs to owners.").ExistingFile()
+	repoRoot       = buildCmd.Flag("repo-root", "Root of the repo that the CODEOWNERS patterns are relative to.").Default(".").ExistingDir()
+	outputDb       = buildCmd.Flag("output", "Path to write the database to.").Required().String()
+
+	whoOwnsCmd     = app.Command("who-owns", "Print the owners of a package.")
+	whoOwnsDb      = whoOwnsCmd.Flag("db", "Path of the package info database.").Required().ExistingFile()
+	whoOwnsPackage = whoOwnsCmd.Arg("package", "Name of the package.").Required().String()
+
+	showCmd     = app.Command("show", "Print all the metadata of a package.")
+	showDb      = showCmd.Flag("db", "Path of the package info database.").Required().ExistingFile()
+	showPackage = showCmd.Arg("package", "Name of the package.").Required().String()
+
+	listCmd       = app.Command("list", "List the packages matching all of the given filters.")
+	listDb        = listCmd.Flag("db", "Path of the package info database.").Required().ExistingFile()
+	listLicense   = listCmd.Flag("license", "Only list packages whose license expression contains this license.").String()
+	listOwner     = listCmd.Flag("owner", "Only list packages owned by this owner.").String()
+	listBuildFlag = listCmd.Flag("build-flag", "Only list packages that declare this build conditional.").String()
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	var err error
+	switch command {
+	case buildCmd.FullCommand():
+		err = buildDatabase()
+	case whoOwnsCmd.FullCommand():
+		err = whoOwns()
+	case showCmd.FullCommand():
+		err = show()
+	case listCmd.FullCommand():
+		err = list()
+	}
+
+	if err != nil {
+		logger.Log.Fatalf("%s failed:\n%v", command, err)
+	}
+}
+
+func buildDatabase() error {
+	db, err := pkginfo.BuildDatabase(*specsDirs, *codeOwnersFile, *repoRoot)
+	if err != nil {
+		return err
+	}
+
+	err = db.Save(*outputDb)
+	if err != nil {
+		return fmt.Errorf("failed to write database (%s):\n%w", *outputDb, err)
+	}
+
+	logger.Log.Infof("Indexed %d packages into (%s)", len(db.Packages), *outputDb)
+	return nil
+}
+
+func whoOwns() error {
+	db, err := pkginfo.LoadDatabase(*whoOwnsDb)
+	if err != nil {
+		return err
+	}
+
+	owners, err := db.WhoOwns(*whoOwnsPackage)
+	if err != nil {
+		return err
+	}
+
+	if len(owners) == 0 {
+		fmt.Printf("%s: no owners\n", *whoOwnsPackage)
+		return nil
+	}
+
+	fmt.Printf("%s: %s\n", *whoOwnsPackage, strings.Join(owners, " "))
+	return nil
+}
+
+func show() error {
+	db, err := pkginfo.LoadDatabase(*showDb)
+	if err != nil {
+		return err
+	}
+
+	infos := db.Get(*showPackage)
+	if len(infos) == 0 {
+		return fmt.Errorf("package (%s) not found", *showPackage)
+	}
+
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("Name:       %s\n", info.Name)
+		fmt.Printf("Version:    %s\n", info.Version)
+		fmt.Printf("Spec:       %s\n", info.SpecPath)
+		fmt.Printf("License:    %s\n", info.License)
+		fmt.Printf("URL:        %s\n", info.URL)
+		fmt.Printf("Owners:     %s\n", strings.Join(info.Owners, " "))
+		for _, buildFlag := range info.BuildFlags {
+			state := "off"
+			if buildFlag.EnabledByDefault {
+				state = "on"
+			}
+			fmt.Printf("Build flag: %s (default: %s)\n", buildFlag.Name, state)
+		}
+	}
+
+	return nil
+}
+
+func list() error {
+	db, err := pkginfo.LoadDatabase(*listDb)
+	if err != nil {
+		return err
+	}
+
+	packages := db.List(pkginfo.Filter{
+		License:   *listLicense,
+		Owner:     *listOwner,
+		BuildFlag: *listBuildFlag,
+	})
+
+	for _, info := range packages {
+		fmt.Printf("%s\t%s\t%s\n", info.Name, info.License, strings.Join(info.Owners, ","))
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@
 package main
 
 import (
+	"crypto"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,15 +16,19 @@ import (
 	"time"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/ccachemanager"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/netisolation"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repomanager/rpmrepomanager"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/tdnf"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -34,13 +39,15 @@ const (
 	chrootLocalToolchainDir = "/toolchainrpms"
 	chrootLocalRpmsCacheDir = "/upstream-cached-rpms"
 	chrootCcacheDir         = "/ccache-dir"
+	chrootCcacheStatsLog    = "/ccache-stats.log"
 )
 
 var (
 	app                      = kingpin.New("pkgworker", "A worker for building packages locally")
 	srpmFile                 = exe.InputFlag(app, "Full path to the SRPM to build")
 	workDir                  = app.Flag("work-dir", "The directory to create the build folder").Required().String()
-	workerTar                = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz").Required().ExistingFile()
+	workerTar                = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz. Mutually exclusive with --worker-image.").ExistingFile()
+	workerImage              = app.Flag("worker-image", "OCI image layout reference ('<layout-dir>[:<tag>]') of the worker chroot to use instead of --worker-tar.").String()
 	repoFile                 = app.Flag("repo-file", "Full path to local.repo").Required().ExistingFile()
 	rpmsDirPath              = app.Flag("rpm-dir", "The directory to use as the local repo and to submit RPM packages to").Required().ExistingDir()
 	srpmsDirPath             = app.Flag("srpm-dir", "The output directory for source RPM packages").Required().String()
@@ -59,8 +66,18 @@ var (
 	useCcache                = app.Flag("use-ccache", "Automatically install and use ccache during package builds").Bool()
 	ccacheRootDir            = app.Flag("ccache-root-dir", "The directory used to store ccache outputs").String()
 	ccachConfig              = app.Flag("ccache-config", "The configuration file for ccache.").String()
+	networkIsolation         = app.Flag("network-isolation", "Network access of the build. 'isolated' builds the package without network access, except for the hosts in --network-allowlist. Package tests (--run-check) always have network access.").Default(string(netisolation.PolicyHost)).Enum(netisolation.ValidPolicies()...)
+	networkAllowlist         = app.Flag("network-allowlist", "Host an isolated build may download from through a proxy. Prefix with '.' to also allow the host's subdomains.").Strings()
 	maxCPU                   = app.Flag("max-cpu", "Max number of CPUs used for package building").Default("").String()
+	chrootMemoryMax          = app.Flag("chroot-memory-max", "Maximum memory that each command run in the build chroot (e.g. a scriptlet or rpmbuild), including its child processes, may use (e.g. 8GB).").Bytes()
+	chrootCPUWeight          = app.Flag("chroot-cpu-weight", "Relative share of CPU time (1 to 10000) of each command run in the build chroot.").Uint64()
+	chrootPidsMax            = app.Flag("chroot-pids-max", "Maximum number of processes and threads of each command run in the build chroot.").Uint64()
+	chrootCgroupParent       = app.Flag("chroot-cgroup-parent", "cgroup v2 directory to create the cgroups of the commands run in the build chroot in. By default, pkgworker's own cgroup is used.").String()
 	timeout                  = app.Flag("timeout", "Timeout for package building").Required().Duration()
+	writeProvenanceFiles     = app.Flag("provenance", "Write a SLSA provenance attestation next to each built RPM").Bool()
+	provenanceKey            = app.Flag("provenance-key", "PEM encoded private key to sign the provenance attestations with. The attestations are unsigned if not set.").ExistingFile()
+	provenanceBuilderID      = app.Flag("provenance-builder-id", "URI identifying the builder in the provenance attestations").Default(provenance.DefaultBuilderID).String()
+	rootless                 = app.Flag("rootless", "Whether to run the build chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 
 	logFlags = exe.SetupLogFlags(app)
 )
@@ -74,6 +91,13 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
+	logger.SetContextField(logger.PackageField, filepath.Base(*srpmFile))
+
 	rpmsDirAbsPath, err := filepath.Abs(*rpmsDirPath)
 	logger.FatalOnError(err, "Unable to find absolute path for RPMs directory '%s'", *rpmsDirPath)
 
@@ -83,6 +107,17 @@ func main() {
 	srpmsDirAbsPath, err := filepath.Abs(*srpmsDirPath)
 	logger.FatalOnError(err, "Unable to find absolute path for SRPMs directory '%s'", *srpmsDirPath)
 
+	workerSource := *workerTar
+	if *workerImage != "" {
+		if *workerTar != "" {
+			logger.Log.Fatal("--worker-tar and --worker-image are mutually exclusive")
+		}
+		workerSource = *workerImage
+	}
+	if workerSource == "" {
+		logger.Log.Fatal("One of --worker-tar or --worker-image must be provided")
+	}
+
 	chrootDir := buildChrootDirPath(*workDir, *srpmFile, *runCheck)
 
 	defines := rpm.DefaultDistroDefines(*runCheck, *distTag)
@@ -118,9 +153,52 @@ func main() {
 		defines[rpm.MaxCPUDefine] = *maxCPU
 	}
 
-	builtRPMs, err := buildSRPMInChroot(chrootDir, rpmsDirAbsPath, toolchainDirAbsPath, *workerTar, *srpmFile, *repoFile, *rpmmacrosFile, *releaseVersionMacrosFile, *outArch, defines, *noCleanup, *runCheck, *packagesToInstall, ccacheManager, *timeout)
+	err = safechroot.SetCommandLimits(safechroot.CommandLimits{
+		Resources: cgroup.Limits{
+			MemoryMax: uint64(*chrootMemoryMax),
+			CPUWeight: *chrootCPUWeight,
+			PidsMax:   *chrootPidsMax,
+		},
+		CgroupParent: *chrootCgroupParent,
+	})
+	logger.FatalOnError(err, "Failed to set limits of the build chroot's commands")
+	defer safechroot.ResetCommandLimits()
+
+	// Package tests are allowed to use the network (see copyFilesIntoChroot).
+	var sandbox *netisolation.Sandbox
+	if *networkIsolation == string(netisolation.PolicyIsolated) {
+		if *runCheck {
+			// The tests are run by a single rpmbuild, which also runs the %build and %install sections again.
+			logger.Log.Warnf("Running the tests of '%s' with network access, the network isolation doesn't apply to any of the sections of its spec during the test run.", *srpmFile)
+		} else {
+			sandbox = netisolation.New(*networkAllowlist)
+		}
+	}
+
+	// Provenance is only recorded for regular package builds, test builds don't produce RPMs.
+	var (
+		buildEnv       *buildEnvironment
+		provenanceSign crypto.Signer
+	)
+	if *writeProvenanceFiles && !*runCheck {
+		buildEnv = &buildEnvironment{}
+		if *provenanceKey != "" {
+			provenanceSign, err = provenance.LoadSigningKey(*provenanceKey)
+			logger.FatalOnError(err, "Failed to load the provenance signing key")
+		} else {
+			logger.Log.Warn("No provenance signing key set, the provenance attestations will be unsigned.")
+		}
+	}
+
+	startedOn := time.Now()
+	builtRPMs, err := buildSRPMInChroot(chrootDir, rpmsDirAbsPath, toolchainDirAbsPath, workerSource, *srpmFile, *repoFile, *rpmmacrosFile, *releaseVersionMacrosFile, *outArch, defines, *noCleanup, *runCheck, *packagesToInstall, ccacheManager, sandbox, *timeout, buildEnv)
 	logger.FatalOnError(err, "Failed to build SRPM '%s'. For details see log file: %s .", *srpmFile, *logFlags.LogFile)
 
+	if buildEnv != nil {
+		err = writeProvenance(provenanceSign, *provenanceBuilderID, *srpmFile, workerSource, *outArch, defines, *runCheck, startedOn, *buildEnv, builtRPMs)
+		logger.FatalOnError(err, "Failed to write the provenance of SRPM '%s'", *srpmFile)
+	}
+
 	// For regular (non-test) package builds:
 	// - Copy the SRPM which produced the package to the output directory.
 	// - Write a comma-separated list of RPMs built to stdout that can be parsed by the invoker.
@@ -155,7 +233,7 @@ func isCCacheEnabled(ccacheManager *ccachemanager.CCacheManager) bool {
 	return ccacheManager != nil && ccacheManager.CurrentPkgGroup.Enabled
 }
 
-func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmFile, repoFile, rpmmacrosFile, releaseVersionMacrosFile, outArch string, defines map[string]string, noCleanup, runCheck bool, packagesToInstall []string, ccacheManager *ccachemanager.CCacheManager, timeout time.Duration) (builtRPMs []string, err error) {
+func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmFile, repoFile, rpmmacrosFile, releaseVersionMacrosFile, outArch string, defines map[string]string, noCleanup, runCheck bool, packagesToInstall []string, ccacheManager *ccachemanager.CCacheManager, sandbox *netisolation.Sandbox, timeout time.Duration, buildEnv *buildEnvironment) (builtRPMs []string, err error) {
 
 	const (
 		buildHeartbeatTimeout = 30 * time.Minute
@@ -202,18 +280,25 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmF
 
 	outRpmsOverlayMount, outRpmsOverlayExtraDirs := safechroot.NewOverlayMountPoint(chroot.RootDir(), overlaySource, chrootLocalRpmsDir, rpmDirPath, chrootLocalRpmsDir, overlayWorkDirRpms)
 	toolchainRpmsOverlayMount, toolchainRpmsOverlayExtraDirs := safechroot.NewOverlayMountPoint(chroot.RootDir(), overlaySource, chrootLocalToolchainDir, toolchainDirPath, chrootLocalToolchainDir, overlayWorkDirToolchain)
-	rpmCacheMount := safechroot.NewMountPoint(*cacheDir, chrootLocalRpmsCacheDir, "", safechroot.BindMountPointFlags, "")
-	mountPoints := []*safechroot.MountPoint{outRpmsOverlayMount, toolchainRpmsOverlayMount, rpmCacheMount}
+	bindMountSpecs := []safechroot.MountSpec{
+		{Source: *cacheDir, Target: chrootLocalRpmsCacheDir, Bind: true},
+	}
 	extraDirs := append(outRpmsOverlayExtraDirs, chrootLocalRpmsCacheDir)
 	extraDirs = append(extraDirs, toolchainRpmsOverlayExtraDirs...)
 	if isCCacheEnabled(ccacheManager) {
-		ccacheMount := safechroot.NewMountPoint(ccacheManager.CurrentPkgGroup.CCacheDir, chrootCcacheDir, "", safechroot.BindMountPointFlags, "")
-		mountPoints = append(mountPoints, ccacheMount)
+		bindMountSpecs = append(bindMountSpecs, safechroot.MountSpec{Source: ccacheManager.CurrentPkgGroup.CCacheDir, Target: chrootCcacheDir, Bind: true})
 		// need to update extraDirs with ccache specific folders to be created
 		// inside the container.
 		extraDirs = append(extraDirs, chrootCcacheDir)
 	}
 
+	bindMounts, err := safechroot.NewMountPointsFromSpecs(bindMountSpecs)
+	if err != nil {
+		err = fmt.Errorf("failed to create chroot mount points:\n%w", err)
+		return
+	}
+	mountPoints := append([]*safechroot.MountPoint{outRpmsOverlayMount, toolchainRpmsOverlayMount}, bindMounts...)
+
 	err = chroot.Initialize(workerTar, extraDirs, mountPoints, true, releaseVersionMacrosFile)
 	if err != nil {
 		err = fmt.Errorf("failed to initialize chroot:\n%w", err)
@@ -222,7 +307,7 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmF
 	defer chroot.Close(noCleanup)
 
 	// Place extra files that will be needed to build into the chroot
-	srpmFileInChroot, err := copyFilesIntoChroot(chroot, srpmFile, repoFile, rpmmacrosFile, runCheck)
+	srpmFileInChroot, err := copyFilesIntoChroot(chroot, srpmFile, repoFile, rpmmacrosFile, runCheck, sandbox)
 	if err != nil {
 		err = fmt.Errorf("failed to copy files into chroot:\n%w", err)
 		return
@@ -238,15 +323,24 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmF
 	// process exiting will fail (see safechroot.go:cleanupAllChroots()). For example,
 	// `unmount /path/to/chroot/dev` will fail since our root is currently `/path/to/chroot`,
 	// and `/path/to/chroot/path/to/chroot/dev` is not a real path.
-	results := make(chan error)
+	type chrootBuildResult struct {
+		ccacheStats *ccachemanager.CCacheStats
+		err         error
+	}
+
+	var ccacheStats *ccachemanager.CCacheStats
+	results := make(chan chrootBuildResult)
 	err = chroot.Run(func() (err error) {
 		go func() {
-			results <- buildRPMFromSRPMInChroot(srpmFileInChroot, outArch, runCheck, defines, packagesToInstall, isCCacheEnabled(ccacheManager))
+			stats, buildErr := buildRPMFromSRPMInChroot(srpmFileInChroot, outArch, runCheck, defines, packagesToInstall, isCCacheEnabled(ccacheManager), sandbox, buildEnv)
+			results <- chrootBuildResult{ccacheStats: stats, err: buildErr}
 		}()
 
 		var chrootErr error = nil
 		select {
-		case chrootErr = <-results:
+		case result := <-results:
+			chrootErr = result.err
+			ccacheStats = result.ccacheStats
 			logger.Log.Debug("Build thread in chroot finished.")
 		case <-time.After(timeout):
 			logger.Log.Errorf("Timeout after %v: stopping chroot...", timeout)
@@ -256,6 +350,19 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmF
 		return chrootErr // Internal error is returned via the channel
 	})
 
+	// Record the ccache statistics even if the build failed, a cache which breaks builds should be noticed.
+	if ccacheStats != nil {
+		ccacheStats.Group = ccacheManager.CurrentPkgGroup.Name
+		ccacheStats.SRPM = srpmBaseName
+		ccacheStats.FinishedAt = time.Now()
+		logger.Log.Infof("ccache (%s): %d hits, %d misses (%.1f%%).", ccacheStats.Group, ccacheStats.Hits, ccacheStats.Misses, ccacheStats.HitRate())
+
+		statsErr := ccacheManager.SaveStats(*ccacheStats)
+		if statsErr != nil {
+			logger.Log.Warnf("Failed to save the ccache statistics:\n%s", statsErr)
+		}
+	}
+
 	if err != nil {
 		err = fmt.Errorf("failed to build RPM from SRPM in chroot:\n%w", err)
 		return
@@ -277,7 +384,11 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, toolchainDirPath, workerTar, srpmF
 	return
 }
 
-func buildRPMFromSRPMInChroot(srpmFile, outArch string, runCheck bool, defines map[string]string, packagesToInstall []string, useCcache bool) (err error) {
+// buildRPMFromSRPMInChroot builds the SRPM, it must be called from inside the chroot. If buildEnv isn't nil, the
+// packages installed for the build and the SRPM's contents are recorded in it for the build's provenance. If
+// useCcache is set, the build uses ccache and its statistics are returned. If sandbox isn't nil, rpmbuild runs
+// without network access.
+func buildRPMFromSRPMInChroot(srpmFile, outArch string, runCheck bool, defines map[string]string, packagesToInstall []string, useCcache bool, sandbox *netisolation.Sandbox, buildEnv *buildEnvironment) (ccacheStats *ccachemanager.CCacheStats, err error) {
 
 	// Convert /localrpms into a repository that a package manager can use.
 	err = rpmrepomanager.CreateRepo(chrootLocalRpmsDir)
@@ -308,6 +419,23 @@ func buildRPMFromSRPMInChroot(srpmFile, outArch string, runCheck bool, defines m
 			err = fmt.Errorf("failed to install ccache:\n%w", err)
 			return
 		}
+
+		// The statistics counters of the ccache directory are shared by the packages of the group, which may build at
+		// the same time. So, the compilations of this package are logged to a file of its own instead.
+		statsErr := os.Remove(chrootCcacheStatsLog)
+		if statsErr != nil && !os.IsNotExist(statsErr) {
+			logger.Log.Warnf("Failed to remove the previous ccache statistics log:\n%s", statsErr)
+		}
+		setChrootEnvironmentVariables(map[string]string{ccachemanager.StatsLogEnvironmentVariable: chrootCcacheStatsLog})
+
+		defer func() {
+			stats, statsErr := ccachemanager.ReadStatsLog(chrootCcacheStatsLog)
+			if statsErr != nil {
+				logger.Log.Warnf("Failed to get the ccache statistics:\n%s", statsErr)
+				return
+			}
+			ccacheStats = &stats
+		}()
 	}
 
 	// Remove all libarchive files on the system before issuing a build.
@@ -320,8 +448,17 @@ func buildRPMFromSRPMInChroot(srpmFile, outArch string, runCheck bool, defines m
 		return
 	}
 
+	if buildEnv != nil {
+		err = queryBuildEnvironment(srpmFile, buildEnv)
+		if err != nil {
+			return
+		}
+	}
+
 	// Build the SRPM
-	if runCheck {
+	if sandbox != nil {
+		err = buildRPMFromSRPMInSandbox(sandbox, srpmFile, outArch, defines)
+	} else if runCheck {
 		err = rpm.TestRPMFromSRPM(srpmFile, outArch, defines)
 	} else {
 		err = rpm.BuildRPMFromSRPM(srpmFile, outArch, defines)
@@ -334,6 +471,35 @@ func buildRPMFromSRPMInChroot(srpmFile, outArch string, runCheck bool, defines m
 	return
 }
 
+// buildRPMFromSRPMInSandbox builds the SRPM without network access, it must be called from inside the chroot. The
+// build fails if it tried to download anything through the sandbox's proxy that isn't on the allowlist, even if
+// rpmbuild itself succeeded, since the build then depends on whether the download worked.
+func buildRPMFromSRPMInSandbox(sandbox *netisolation.Sandbox, srpmFile, outArch string, defines map[string]string) (err error) {
+	logger.Log.Infof("Building without network access, allowed hosts: %v.", sandbox.Allowlist)
+
+	// The proxy only exists while the sandbox runs, so its environment variables are removed once the build is done.
+	originalEnv := shell.CurrentEnvironment()
+	defer shell.SetEnvironment(originalEnv)
+
+	denied, err := sandbox.Run(func(proxyEnv map[string]string) error {
+		setChrootEnvironmentVariables(proxyEnv)
+		return rpm.BuildRPMFromSRPM(srpmFile, outArch, defines)
+	})
+
+	if len(denied) > 0 {
+		for _, request := range denied {
+			logger.Log.Errorf("Build tried to access the network: %s", request)
+		}
+		return fmt.Errorf("build tried to download %d file(s) without network access, add them as sources of the spec instead: %v", len(denied), denied)
+	}
+
+	if err != nil {
+		logger.Log.Warn("The build ran without network access, check its log for failed downloads.")
+	}
+
+	return
+}
+
 func moveBuiltRPMs(chrootRootDir, dstDir string) (builtRPMs []string, err error) {
 	const (
 		chrootRpmBuildDir = "/usr/src/azl/RPMS"
@@ -406,7 +572,10 @@ func tdnfInstall(packages []string) (err error) {
 
 	installArgs := []string{"install", "-y", releaseverCliArg}
 	installArgs = append(installArgs, packages...)
-	stdout, stderr, err := shell.Execute("tdnf", installArgs...)
+	// Log tdnf's download and install progress as it happens, since installing the build requirements can take a while.
+	stdout, stderr, err := shell.NewExecBuilder("tdnf", installArgs...).
+		LogLevel(logrus.DebugLevel, logrus.DebugLevel).
+		ExecuteCaptureOuput()
 	foundNoMatchingPackages := false
 
 	if err != nil {
@@ -460,6 +629,24 @@ func tdnfInstall(packages []string) (err error) {
 	return
 }
 
+// setChrootEnvironmentVariables sets environment variables for the processes run inside the chroot, replacing any
+// existing values. The chroot's environment is restored once the chroot exits.
+func setChrootEnvironmentVariables(variables map[string]string) {
+	env := []string{}
+	for _, variable := range shell.CurrentEnvironment() {
+		name, _, _ := strings.Cut(variable, "=")
+		if _, found := variables[name]; !found {
+			env = append(env, variable)
+		}
+	}
+
+	for name, value := range variables {
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	shell.SetEnvironment(env)
+}
+
 // removeLibArchivesFromSystem removes all libarchive files on the system. If
 // the build environment has libtool archive files present, gnu configure could
 // detect it and create more libtool archive files which can cause build failures.
@@ -493,7 +680,7 @@ func removeLibArchivesFromSystem() (err error) {
 }
 
 // copyFilesIntoChroot copies several required build specific files into the chroot.
-func copyFilesIntoChroot(chroot *safechroot.Chroot, srpmFile, repoFile, rpmmacrosFile string, runCheck bool) (srpmFileInChroot string, err error) {
+func copyFilesIntoChroot(chroot *safechroot.Chroot, srpmFile, repoFile, rpmmacrosFile string, runCheck bool, sandbox *netisolation.Sandbox) (srpmFileInChroot string, err error) {
 	const (
 		chrootRepoDestDir = "/etc/yum.repos.d"
 		chrootSrpmDestDir = "/root/SRPMS"
@@ -523,8 +710,13 @@ func copyFilesIntoChroot(chroot *safechroot.Chroot, srpmFile, repoFile, rpmmacro
 		filesToCopy = append(filesToCopy, rpmmacrosCopy)
 	}
 
-	if runCheck {
-		logger.Log.Debug("Enabling network access because we're running package tests.")
+	// The sandbox's proxy resolves the allowed hosts from inside the chroot. The build itself still can't reach
+	// them, since its network namespace has no route.
+	hasAllowlist := sandbox != nil && len(sandbox.Allowlist) > 0
+	if runCheck || hasAllowlist {
+		if runCheck {
+			logger.Log.Debug("Enabling network access because we're running package tests.")
+		}
 
 		resolvFileCopy := safechroot.FileToCopy{
 			Src:  resolvFilePath,
@@ -0,0 +1,246 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// installedPackageQueryFormat lists the name, epoch, version-release and architecture of a package.
+	installedPackageQueryFormat = "%{NAME}\t%{EPOCHNUM}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n"
+	// srpmFilesQueryFormat lists the name and digest of each file (the spec and the sources) in an SRPM.
+	srpmFilesQueryFormat = "[%{FILENAMES}\t%{FILEDIGESTS}\n]"
+	// srpmDigestAlgoQueryFormat is the algorithm of the file digests in an SRPM.
+	srpmDigestAlgoQueryFormat = "%{FILEDIGESTALGO}"
+
+	specExtension = ".spec"
+)
+
+// rpmDigestAlgorithms maps RPM's digest algorithm IDs to their in-toto names.
+var rpmDigestAlgorithms = map[string]string{
+	"1":  "md5",
+	"2":  "sha1",
+	"8":  "sha256",
+	"9":  "sha384",
+	"10": "sha512",
+	"11": "sha224",
+}
+
+// buildEnvironment are the inputs of a build which can only be queried inside the build chroot.
+type buildEnvironment struct {
+	// installedPackages are the packages installed into the chroot before the build, as queried with
+	// installedPackageQueryFormat.
+	installedPackages []string
+	// srpmFiles are the files in the SRPM, as queried with srpmFilesQueryFormat.
+	srpmFiles      []string
+	srpmDigestAlgo string
+}
+
+// queryBuildEnvironment records the packages installed in the chroot and the contents of the SRPM. It must be called
+// from inside the chroot, after the build requirements were installed.
+func queryBuildEnvironment(srpmFileInChroot string, env *buildEnvironment) (err error) {
+	env.installedPackages, err = rpm.QueryInstalledPackages(installedPackageQueryFormat)
+	if err != nil {
+		return fmt.Errorf("failed to query the packages installed in the chroot:\n%w", err)
+	}
+
+	env.srpmFiles, err = rpm.QueryPackage(srpmFileInChroot, srpmFilesQueryFormat, nil, "-p")
+	if err != nil {
+		return fmt.Errorf("failed to query the files in (%s):\n%w", srpmFileInChroot, err)
+	}
+
+	algo, err := rpm.QueryPackage(srpmFileInChroot, srpmDigestAlgoQueryFormat, nil, "-p")
+	if err != nil {
+		return fmt.Errorf("failed to query the digest algorithm of (%s):\n%w", srpmFileInChroot, err)
+	}
+	if len(algo) > 0 {
+		env.srpmDigestAlgo = algo[0]
+	}
+
+	return
+}
+
+// writeProvenance writes a provenance attestation of the build next to each built RPM. The attestation is signed with
+// signer, if it isn't nil.
+func writeProvenance(signer crypto.Signer, builderID, srpmFile, workerSource, outArch string, defines map[string]string, runCheck bool, startedOn time.Time, env buildEnvironment, builtRPMs []string) (err error) {
+	srpm, err := provenance.FileDescriptor(srpmFile)
+	if err != nil {
+		return
+	}
+
+	spec, sources, err := srpmContents(env.srpmFiles, env.srpmDigestAlgo)
+	if err != nil {
+		return
+	}
+
+	dependencies := []provenance.ResourceDescriptor{srpm}
+	if spec.Name != "" {
+		dependencies = append(dependencies, spec)
+	}
+	dependencies = append(dependencies, sources...)
+	dependencies = append(dependencies, workerChrootDescriptor(workerSource))
+	for _, installedPackage := range env.installedPackages {
+		var descriptor provenance.ResourceDescriptor
+		descriptor, err = installedPackageDescriptor(installedPackage)
+		if err != nil {
+			return
+		}
+		dependencies = append(dependencies, descriptor)
+	}
+
+	predicate := provenance.Predicate{
+		BuildDefinition: provenance.BuildDefinition{
+			ExternalParameters: provenance.ExternalParameters{
+				SRPM:     filepath.Base(srpmFile),
+				Spec:     spec.Name,
+				OutArch:  outArch,
+				RunCheck: runCheck,
+			},
+			InternalParameters: provenance.InternalParameters{
+				Defines:     defines,
+				Environment: hostEnvironment(),
+			},
+			ResolvedDependencies: dependencies,
+		},
+		RunDetails: provenance.RunDetails{
+			Builder: provenance.Builder{
+				ID:      builderID,
+				Version: map[string]string{"azurelinux-toolkit": exe.ToolkitVersion},
+			},
+			Metadata: provenance.BuildMetadata{
+				StartedOn:  startedOn.UTC(),
+				FinishedOn: time.Now().UTC(),
+			},
+		},
+	}
+
+	statement, err := provenance.NewStatement(builtRPMs, predicate)
+	if err != nil {
+		return
+	}
+
+	envelope, err := provenance.Sign(statement, signer)
+	if err != nil {
+		return
+	}
+
+	return provenance.WriteSidecars(envelope, builtRPMs)
+}
+
+// srpmContents splits the files of an SRPM into its spec and its sources.
+func srpmContents(srpmFiles []string, digestAlgo string) (spec provenance.ResourceDescriptor, sources []provenance.ResourceDescriptor, err error) {
+	algo, found := rpmDigestAlgorithms[digestAlgo]
+	if !found {
+		// RPMs without a digest algorithm tag use MD5.
+		algo = rpmDigestAlgorithms["1"]
+	}
+
+	for _, srpmFile := range srpmFiles {
+		name, digest, found := strings.Cut(srpmFile, "\t")
+		if !found {
+			err = fmt.Errorf("unexpected SRPM file query output (%s)", srpmFile)
+			return
+		}
+
+		descriptor := provenance.ResourceDescriptor{
+			Name:   name,
+			Digest: map[string]string{algo: digest},
+		}
+
+		if strings.HasSuffix(name, specExtension) {
+			spec = descriptor
+		} else {
+			sources = append(sources, descriptor)
+		}
+	}
+
+	return
+}
+
+// installedPackageDescriptor describes an installed package, as queried with installedPackageQueryFormat, by its
+// package URL.
+func installedPackageDescriptor(installedPackage string) (descriptor provenance.ResourceDescriptor, err error) {
+	const (
+		namespace        = "azurelinux"
+		expectedFields   = 4
+		noEpoch          = "0"
+		noArch           = "(none)"
+		nameField        = 0
+		epochField       = 1
+		versionField     = 2
+		archField        = 3
+		packageURLScheme = "pkg:rpm"
+	)
+
+	fields := strings.Split(installedPackage, "\t")
+	if len(fields) != expectedFields {
+		err = fmt.Errorf("unexpected installed package query output (%s)", installedPackage)
+		return
+	}
+
+	qualifiers := url.Values{}
+	if fields[archField] != noArch {
+		qualifiers.Set("arch", fields[archField])
+	}
+	if fields[epochField] != noEpoch {
+		qualifiers.Set("epoch", fields[epochField])
+	}
+
+	descriptor.Name = fmt.Sprintf("%s-%s.%s", fields[nameField], fields[versionField], fields[archField])
+	descriptor.URI = fmt.Sprintf("%s/%s/%s@%s", packageURLScheme, namespace, url.PathEscape(fields[nameField]), url.PathEscape(fields[versionField]))
+	if len(qualifiers) > 0 {
+		descriptor.URI += "?" + qualifiers.Encode()
+	}
+
+	return
+}
+
+// workerChrootDescriptor describes the worker chroot the build chroot was created from. Worker tarballs are
+// identified by their digest.
+func workerChrootDescriptor(workerSource string) (descriptor provenance.ResourceDescriptor) {
+	info, err := os.Stat(workerSource)
+	if err == nil && info.Mode().IsRegular() {
+		descriptor, err = provenance.FileDescriptor(workerSource)
+		if err == nil {
+			return
+		}
+		logger.Log.Warnf("Failed to hash the worker chroot (%s):\n%s", workerSource, err)
+	}
+
+	return provenance.ResourceDescriptor{Name: workerSource, URI: "oci:" + workerSource}
+}
+
+// hostEnvironment describes the host the build runs on.
+func hostEnvironment() (environment map[string]string) {
+	environment = map[string]string{
+		"hostArch": runtime.GOARCH,
+	}
+
+	hostname, err := os.Hostname()
+	if err == nil {
+		environment["hostname"] = hostname
+	}
+
+	var uname unix.Utsname
+	err = unix.Uname(&uname)
+	if err == nil {
+		environment["kernel"] = unix.ByteSliceToString(uname.Release[:])
+	}
+
+	return
+}
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +21,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/network"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repocloner"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/packagerepo/repoutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 	"github.com/sirupsen/logrus"
@@ -60,6 +62,7 @@ var (
 	repoFiles         = app.Flag("repo-file", "Files containing URLs of the repos to download from.").ExistingFiles()
 	workerTar         = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz").Required().ExistingFile()
 	buildDir          = app.Flag("worker-dir", "Directory to store chroot while running repo query.").Required().String()
+	rootless          = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 
 	concurrentNetOps = app.Flag("concurrent-net-ops", "Number of concurrent network operations to perform.").Default(defaultNetOpsCount).Uint()
 	nonFatalMode     = app.Flag("non-fatal-mode", "Run in non-fatal mode, where errors are logged but do not cause the program to exit with a non-zero code.").Bool()
@@ -70,6 +73,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
@@ -106,7 +114,9 @@ func main() {
 		}
 	}
 
-	downloadedPackages, err := downloadMissingPackages(rpmSnapshot, packagesAvailableFromRepos, *outDir, *concurrentNetOps)
+	mirrorSelector := rankMirrors(packagesAvailableFromRepos)
+
+	downloadedPackages, err := downloadMissingPackages(rpmSnapshot, packagesAvailableFromRepos, mirrorSelector, *outDir, *concurrentNetOps)
 	if err != nil {
 		logger.Log.Warnf("Package download failed")
 		logger.Log.Warnf("Missing package download failed: %s", err)
@@ -141,11 +151,52 @@ func rpmSnapshotFromFile(snapshotFile string) (rpmSnapshot *repocloner.RepoConte
 	return
 }
 
+// rankMirrors probes each mirror that hosts packages and returns a selector that spreads the downloads across them. A
+// sample package is picked from each mirror to measure its latency and throughput. No probing is done if all the
+// packages come from a single mirror.
+func rankMirrors(packagesAvailableFromRepos map[string][]string) (mirrorSelector *network.MirrorSelector) {
+	timestamp.StartEvent("rank mirrors", nil)
+	defer timestamp.StopEvent(nil)
+
+	pkgNames := make([]string, 0, len(packagesAvailableFromRepos))
+	for pkgName := range packagesAvailableFromRepos {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	sort.Strings(pkgNames)
+
+	sampleURLs := make(map[string]string)
+	for _, pkgName := range pkgNames {
+		for _, url := range packagesAvailableFromRepos[pkgName] {
+			mirror := network.MirrorOf(url)
+			if _, found := sampleURLs[mirror]; !found {
+				sampleURLs[mirror] = url
+			}
+		}
+	}
+
+	if len(sampleURLs) < 2 {
+		return network.NewMirrorSelector(nil)
+	}
+
+	logger.Log.Infof("Probing %d mirrors", len(sampleURLs))
+	probeResults := network.ProbeMirrors(context.Background(), sampleURLs, nil, nil, network.DefaultMirrorProbeTimeout)
+	for i, result := range probeResults {
+		if result.Err != nil {
+			logger.Log.Infof("Mirror %d: %s (unreachable)", i+1, result.Mirror)
+			continue
+		}
+		logger.Log.Infof("Mirror %d: %s (latency: %s, throughput: %.1f KiB/s)", i+1, result.Mirror,
+			result.Latency.Round(time.Millisecond), result.BytesPerSecond/1024)
+	}
+
+	return network.NewMirrorSelector(probeResults)
+}
+
 // downloadMissingPackages will attempt to download each package listed in rpmSnapshot that is not already present in the
 // outDir. It will return a list of the packages that were downloaded. It will use concurrentNetOps to limit the number of
-// concurrent network operations used to download the missing packages. It will also monitor the results and print periodic
-// progress updates to the console.
-func downloadMissingPackages(rpmSnapshot *repocloner.RepoContents, packagesAvailableFromRepos map[string]string, outDir string, concurrentNetOps uint) (downloadedPackages []string, err error) {
+// concurrent network operations used to download the missing packages, and mirrorSelector to pick which mirror each
+// package is downloaded from. It will also monitor the results and print periodic progress updates to the console.
+func downloadMissingPackages(rpmSnapshot *repocloner.RepoContents, packagesAvailableFromRepos map[string][]string, mirrorSelector *network.MirrorSelector, outDir string, concurrentNetOps uint) (downloadedPackages []string, err error) {
 	timestamp.StartEvent("download missing packages", nil)
 	defer timestamp.StopEvent(nil)
 
@@ -161,7 +212,7 @@ func downloadMissingPackages(rpmSnapshot *repocloner.RepoContents, packagesAvail
 	// Each worker is responsible for removing itself from the wait group once done.
 	for _, pkg := range rpmSnapshot.Repo {
 		wg.Add(1)
-		go precachePackage(pkg, packagesAvailableFromRepos, outDir, wg, results, netOpsSemaphore)
+		go precachePackage(pkg, packagesAvailableFromRepos, mirrorSelector, outDir, wg, results, netOpsSemaphore)
 	}
 
 	// Wait for all the workers to finish and signal the main thread when we are done by closing the results channel.
@@ -231,7 +282,7 @@ func monitorProgress(total int, results chan downloadResult) (downloadedPackages
 // The caller is expected to have added to the provided wait group, while this function is
 // responsible for removing itself from the wait group. As much processing as possible is done before acquiring the
 // network operations semaphore to minimize the time spent holding it.
-func precachePackage(pkg *repocloner.RepoPackage, packagesAvailableFromRepos map[string]string, outDir string, wg *sync.WaitGroup, results chan<- downloadResult, netOpsSemaphore chan struct{}) {
+func precachePackage(pkg *repocloner.RepoPackage, packagesAvailableFromRepos map[string][]string, mirrorSelector *network.MirrorSelector, outDir string, wg *sync.WaitGroup, results chan<- downloadResult, netOpsSemaphore chan struct{}) {
 	// File names are of the form "<name>-<version>.<distro>.<arch>.rpm"
 	pkgName, fileName := formatName(pkg)
 	fullFilePath := path.Join(outDir, fileName)
@@ -256,8 +307,8 @@ func precachePackage(pkg *repocloner.RepoPackage, packagesAvailableFromRepos map
 		return
 	}
 
-	// Get the URL for the package, or bail out if it is not available.
-	url, ok := packagesAvailableFromRepos[pkgName]
+	// Get the URLs for the package, or bail out if it is not available.
+	urls, ok := packagesAvailableFromRepos[pkgName]
 	if !ok {
 		result.resultType = downloadResultTypeUnavailable
 		return
@@ -270,10 +321,10 @@ func precachePackage(pkg *repocloner.RepoPackage, packagesAvailableFromRepos map
 		<-netOpsSemaphore
 	}()
 
-	logger.Log.Debugf("Pre-caching '%s' from '%s'", fileName, url)
-	_, err = network.DownloadFileWithRetry(context.Background(), url, fullFilePath, nil, nil, network.DefaultTimeout)
+	logger.Log.Debugf("Pre-caching '%s' from '%s'", fileName, strings.Join(urls, "', '"))
+	_, err = mirrorSelector.DownloadFileWithRetry(context.Background(), urls, fullFilePath, nil, nil, network.DefaultTimeout)
 	if err != nil {
-		logger.Log.Warnf("Failed to download '%s' from '%s': %s", fileName, url, err)
+		logger.Log.Warnf("Failed to download '%s': %s", fileName, err)
 		return
 	}
 
@@ -0,0 +1,179 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// A worker building packages for a scheduler running on another machine
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/netisolation"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/buildagents"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/remoteworker"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	inputsDirName = "remote-inputs"
+)
+
+var (
+	app = kingpin.New("remoteworker", "A worker building packages with pkgworker for a scheduler running on another machine")
+
+	schedulerAddress = app.Flag("scheduler-address", "Address ('<host>:<port>') the scheduler accepts remote build workers on.").Required().String()
+	caCertFile       = app.Flag("ca-cert", "CA certificate to verify the scheduler's TLS certificate with.").Required().ExistingFile()
+	clientCertFile   = app.Flag("cert", "TLS client certificate authenticating the worker, if the scheduler requires one.").ExistingFile()
+	clientKeyFile    = app.Flag("key", "Private key of the TLS client certificate.").ExistingFile()
+	tokenFile        = app.Flag("token-file", "File containing the token authenticating the worker, if the scheduler requires one.").ExistingFile()
+	workerName       = app.Flag("name", "Name of the worker in the scheduler's logs. Defaults to the host name.").String()
+	pkgworkerProgram = app.Flag("pkgworker-program", "Path to the pkgworker tool used to build the packages.").Required().ExistingFile()
+
+	workDir                  = app.Flag("work-dir", "The directory to create the build folders in").Required().String()
+	workerTar                = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz. Mutually exclusive with --worker-image.").ExistingFile()
+	workerImage              = app.Flag("worker-image", "OCI image layout reference ('<layout-dir>[:<tag>]') of the worker chroot to use instead of --worker-tar.").String()
+	repoFile                 = app.Flag("repo-file", "Full path to local.repo").Required().ExistingFile()
+	rpmDir                   = app.Flag("rpm-dir", "The directory to use as the local repo and to save the built RPM packages to").Required().ExistingDir()
+	toolchainDirPath         = app.Flag("toolchain-rpms-dir", "Directory to save the toolchain RPMs needed by the builds to.").Required().ExistingDir()
+	srpmDir                  = app.Flag("srpm-dir", "The output directory for source RPM packages").Required().String()
+	cacheDir                 = app.Flag("cache-dir", "The directory to save the cached dependency RPMs needed by the builds to.").Required().ExistingDir()
+	buildLogsDir             = app.Flag("build-logs-dir", "Directory to store package build logs").Required().ExistingDir()
+	distTag                  = app.Flag("dist-tag", "The distribution tag SRPMs will be built with.").Required().String()
+	distroReleaseVersion     = app.Flag("distro-release-version", "The distro release version that the SRPM will be built with.").Required().String()
+	distroBuildNumber        = app.Flag("distro-build-number", "The distro build number that the SRPM will be built with.").Required().String()
+	rpmmacrosFile            = app.Flag("rpmmacros-file", "Optional file path to an rpmmacros file for rpmbuild to use.").ExistingFile()
+	releaseVersionMacrosFile = app.Flag("versions-macro-file", "File containing release and version macros for all SPECS to use while building.").ExistingFile()
+	noCleanup                = app.Flag("no-cleanup", "Whether or not to delete the chroot folder after the build is done").Bool()
+	useCcache                = app.Flag("use-ccache", "Automatically install and use ccache during package builds").Bool()
+	ccacheDir                = app.Flag("ccache-dir", "The directory used to store ccache outputs").String()
+	ccacheConfig             = app.Flag("ccache-config", "The ccache configuration file path.").String()
+	maxCPU                   = app.Flag("max-cpu", "Max number of CPUs used for package building").Default("").String()
+	chrootMemoryMax          = app.Flag("chroot-memory-max", "Maximum memory that each command run in a build chroot (e.g. a scriptlet or rpmbuild), including its child processes, may use (e.g. 8GB).").Bytes()
+	chrootCPUWeight          = app.Flag("chroot-cpu-weight", "Relative share of CPU time (1 to 10000) of each command run in a build chroot.").Uint64()
+	chrootPidsMax            = app.Flag("chroot-pids-max", "Maximum number of processes and threads of each command run in a build chroot.").Uint64()
+	chrootCgroupParent       = app.Flag("chroot-cgroup-parent", "cgroup v2 directory to create the cgroups of the commands run in the build chroots in. By default, the worker's own cgroup is used.").String()
+	networkIsolation         = app.Flag("network-isolation", "Network access of the package builds. 'isolated' builds packages without network access, except for the hosts in --network-allowlist.").Default(string(netisolation.PolicyHost)).Enum(netisolation.ValidPolicies()...)
+	networkAllowlist         = app.Flag("network-allowlist", "Host isolated builds may download from through a proxy. Prefix with '.' to also allow the host's subdomains.").Strings()
+	provenance               = app.Flag("provenance", "Write a SLSA provenance attestation next to each built RPM.").Bool()
+	provenanceKey            = app.Flag("provenance-key", "PEM encoded private key to sign the provenance attestations with. The attestations are unsigned if not set.").ExistingFile()
+	provenanceBuilderID      = app.Flag("provenance-builder-id", "URI identifying this worker in the provenance attestations.").String()
+
+	logFlags = exe.SetupLogFlags(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	if (*workerTar == "") == (*workerImage == "") {
+		logger.Log.Fatal("Exactly one of --worker-tar or --worker-image must be provided")
+	}
+
+	if (*clientCertFile == "") != (*clientKeyFile == "") {
+		logger.Log.Fatal("--cert and --key must be provided together")
+	}
+
+	name := *workerName
+	if name == "" {
+		hostname, err := os.Hostname()
+		logger.FatalOnError(err, "Failed to get the host name, set --name instead")
+		name = hostname
+	}
+
+	architecture, err := rpm.GetRpmArch(runtime.GOARCH)
+	logger.FatalOnError(err, "Failed to get the build architecture")
+
+	agent := buildagents.NewChrootAgent()
+	err = agent.Initialize(&buildagents.BuildAgentConfig{
+		Program:      *pkgworkerProgram,
+		CacheDir:     *cacheDir,
+		RepoFile:     *repoFile,
+		RpmDir:       *rpmDir,
+		ToolchainDir: *toolchainDirPath,
+		SrpmDir:      *srpmDir,
+		WorkDir:      *workDir,
+		WorkerTar:    *workerTar,
+		WorkerImage:  *workerImage,
+
+		DistTag:              *distTag,
+		DistroReleaseVersion: *distroReleaseVersion,
+		DistroBuildNumber:    *distroBuildNumber,
+		RPMMacrosFiles:       *rpmmacrosFile,
+		VersionsMacroFile:    *releaseVersionMacrosFile,
+
+		NoCleanup:    *noCleanup,
+		UseCcache:    *useCcache,
+		CCacheDir:    *ccacheDir,
+		CCacheConfig: *ccacheConfig,
+		MaxCpu:       *maxCPU,
+
+		ChrootLimits: cgroup.Limits{
+			MemoryMax: uint64(*chrootMemoryMax),
+			CPUWeight: *chrootCPUWeight,
+			PidsMax:   *chrootPidsMax,
+		},
+		ChrootCgroupParent: *chrootCgroupParent,
+
+		NetworkIsolation: *networkIsolation,
+		NetworkAllowlist: *networkAllowlist,
+
+		Provenance:          *provenance,
+		ProvenanceKey:       *provenanceKey,
+		ProvenanceBuilderID: *provenanceBuilderID,
+
+		LogDir:    *buildLogsDir,
+		LogLevel:  *logFlags.LogLevel,
+		LogFormat: *logFlags.LogFormat,
+	})
+	logger.FatalOnError(err, "Failed to initialize the build agent")
+	defer agent.Close()
+
+	transportCredentials, err := remoteworker.ClientCredentials(*caCertFile, *clientCertFile, *clientKeyFile)
+	logger.FatalOnError(err, "Failed to load the TLS credentials")
+
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(transportCredentials)}
+	if *tokenFile != "" {
+		token, tokenErr := remoteworker.ReadToken(*tokenFile)
+		logger.FatalOnError(tokenErr, "Failed to load the worker token")
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(remoteworker.TokenCredentials{Token: token}))
+	}
+
+	conn, err := grpc.NewClient(*schedulerAddress, dialOptions...)
+	logger.FatalOnError(err, "Failed to connect to the scheduler (%s)", *schedulerAddress)
+	defer conn.Close()
+
+	worker := remoteworker.NewWorker(conn, agent, remoteworker.WorkerConfig{
+		Name:         name,
+		Architecture: architecture,
+		RPMDir:       *rpmDir,
+		ToolchainDir: *toolchainDirPath,
+		CacheDir:     *cacheDir,
+		InputDir:     filepath.Join(*workDir, inputsDirName),
+		LogDir:       *buildLogsDir,
+	})
+
+	// Stop taking new jobs on SIGINT or SIGTERM, and stop any running build.
+	ctx, stop := signal.NotifyContext(context.Background(), unix.SIGINT, unix.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shell.StopAllChildProcesses(unix.SIGINT)
+	}()
+
+	logger.Log.Infof("Building packages for the scheduler (%s) as (%s)", *schedulerAddress, name)
+	err = worker.Run(ctx)
+	logger.FatalOnError(err, "Remote build worker failed")
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/artifactmetadata"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 	"github.com/microsoft/azurelinux/toolkit/tools/roast/formats"
 
@@ -256,7 +257,12 @@ func artifactConverterWorker(convertRequests chan *convertRequest, convertedResu
 			if err != nil {
 				logger.Log.Errorf("Failed to move (%s) to (%s). Error: %s", workingArtifactPath, finalFile, err)
 			} else {
-				result.convertedFile = finalFile
+				err = writeArtifactMetadata(req, finalFile, releaseVersion, imageTag)
+				if err != nil {
+					logger.Log.Errorf("Failed to write metadata of (%s). Error: %s", finalFile, err)
+				} else {
+					result.convertedFile = finalFile
+				}
 			}
 		}
 
@@ -265,6 +271,37 @@ func artifactConverterWorker(convertRequests chan *convertRequest, convertedResu
 	}
 }
 
+// writeArtifactMetadata writes the metadata sidecar of a converted artifact.
+func writeArtifactMetadata(req *convertRequest, artifactFile, releaseVersion, imageTag string) (err error) {
+	format := req.artifact.Type
+	if format == "" {
+		format = req.artifact.Compression
+	}
+
+	metadata, err := artifactmetadata.New("roast", exe.ToolkitVersion, artifactFile, format)
+	if err != nil {
+		return
+	}
+
+	err = metadata.AddInput(artifactmetadata.InputRoleImage, req.inputPath)
+	if err != nil {
+		return
+	}
+
+	err = metadata.AddInput(artifactmetadata.InputRoleConfig, *configFile)
+	if err != nil {
+		return
+	}
+
+	metadata.SetParameter("name", req.artifact.Name)
+	metadata.SetParameter("type", req.artifact.Type)
+	metadata.SetParameter("compression", req.artifact.Compression)
+	metadata.SetParameter("releaseVersion", releaseVersion)
+	metadata.SetParameter("imageTag", imageTag)
+
+	return artifactmetadata.WriteSidecar(metadata, artifactFile)
+}
+
 func convertArtifact(artifactName, outDir, format, imageTag, input string, isInputFile, appendExtension bool) (outputFile string, err error) {
 	typeConverter, err := converterFactory(format)
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
 	"github.com/sirupsen/logrus"
 )
@@ -19,6 +20,8 @@ const ChrootAgentFlag = "chroot-agent"
 // ChrootAgent implements the BuildAgent interface to build SRPMs using a local chroot.
 type ChrootAgent struct {
 	config *BuildAgentConfig
+	// cgroupParent is the cgroup the builds' chroot commands are limited in, prepared once for all of the builds.
+	cgroupParent *cgroup.Parent
 }
 
 // NewChrootAgent returns a new ChrootAgent.
@@ -29,6 +32,30 @@ func NewChrootAgent() *ChrootAgent {
 // Initialize initializes the chroot agent with the given configuration.
 func (c *ChrootAgent) Initialize(config *BuildAgentConfig) (err error) {
 	c.config = config
+
+	if !config.ChrootLimits.IsSet() {
+		return
+	}
+
+	err = config.ChrootLimits.IsValid()
+	if err != nil {
+		return
+	}
+
+	// Enable the cgroup controllers once, instead of letting the concurrent builds each move the processes of the
+	// cgroup around.
+	if config.ChrootCgroupParent == "" {
+		config.ChrootCgroupParent, err = cgroup.CurrentPath()
+		if err != nil {
+			return fmt.Errorf("failed to find cgroup of current process:\n%w", err)
+		}
+	}
+
+	c.cgroupParent, err = cgroup.OpenParent(config.ChrootCgroupParent, config.ChrootLimits)
+	if err != nil {
+		return fmt.Errorf("failed to prepare cgroup (%s) for the build chroots:\n%w", config.ChrootCgroupParent, err)
+	}
+
 	return
 }
 
@@ -39,7 +66,8 @@ func (c *ChrootAgent) Initialize(config *BuildAgentConfig) (err error) {
 // - outArch is the target architecture to build for.
 // - runCheck is true if the package should run the "%check" section during the build
 // - dependencies is a list of dependencies that need to be installed before building.
-func (c *ChrootAgent) BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies []string, allowableRuntime time.Duration) (builtFiles []string, logFile string, err error) {
+// - expectedFiles is a list of the RPMs the build is expected to produce.
+func (c *ChrootAgent) BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies, expectedFiles []string, allowableRuntime time.Duration) (builtFiles []string, logFile string, err error) {
 	// On success, pkgworker will print a comma-seperated list of all RPMs built to stdout.
 	// This will be the last stdout line written.
 	const delimiter = ","
@@ -72,6 +100,11 @@ func (c *ChrootAgent) Config() (config BuildAgentConfig) {
 
 // Close closes the ChrootAgent, releasing any resources.
 func (c *ChrootAgent) Close() (err error) {
+	if c.cgroupParent != nil {
+		err = c.cgroupParent.Close()
+		c.cgroupParent = nil
+	}
+
 	return
 }
 
@@ -80,7 +113,6 @@ func serializeChrootBuildAgentConfig(config *BuildAgentConfig, basePackageName,
 	serializedArgs = []string{
 		fmt.Sprintf("--input=%s", inputFile),
 		fmt.Sprintf("--work-dir=%s", config.WorkDir),
-		fmt.Sprintf("--worker-tar=%s", config.WorkerTar),
 		fmt.Sprintf("--repo-file=%s", config.RepoFile),
 		fmt.Sprintf("--rpm-dir=%s", config.RpmDir),
 		fmt.Sprintf("--toolchain-rpms-dir=%s", config.ToolchainDir),
@@ -97,6 +129,16 @@ func serializeChrootBuildAgentConfig(config *BuildAgentConfig, basePackageName,
 		fmt.Sprintf("--timeout=%s", allowableRuntime),
 	}
 
+	if config.LogFormat != "" {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--log-format=%s", config.LogFormat))
+	}
+
+	if config.WorkerImage != "" {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--worker-image=%s", config.WorkerImage))
+	} else {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--worker-tar=%s", config.WorkerTar))
+	}
+
 	if config.RPMMacrosFiles != "" {
 		serializedArgs = append(serializedArgs, fmt.Sprintf("--rpmmacros-file=%s", config.RPMMacrosFiles))
 	}
@@ -119,6 +161,40 @@ func serializeChrootBuildAgentConfig(config *BuildAgentConfig, basePackageName,
 		serializedArgs = append(serializedArgs, fmt.Sprintf("--ccache-config=%s", config.CCacheConfig))
 	}
 
+	if config.ChrootLimits.MemoryMax != 0 {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--chroot-memory-max=%dB", config.ChrootLimits.MemoryMax))
+	}
+
+	if config.ChrootLimits.CPUWeight != 0 {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--chroot-cpu-weight=%d", config.ChrootLimits.CPUWeight))
+	}
+
+	if config.ChrootLimits.PidsMax != 0 {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--chroot-pids-max=%d", config.ChrootLimits.PidsMax))
+	}
+
+	if config.ChrootCgroupParent != "" {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--chroot-cgroup-parent=%s", config.ChrootCgroupParent))
+	}
+
+	if config.NetworkIsolation != "" {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--network-isolation=%s", config.NetworkIsolation))
+	}
+
+	for _, host := range config.NetworkAllowlist {
+		serializedArgs = append(serializedArgs, fmt.Sprintf("--network-allowlist=%s", host))
+	}
+
+	if config.Provenance {
+		serializedArgs = append(serializedArgs, "--provenance")
+		if config.ProvenanceKey != "" {
+			serializedArgs = append(serializedArgs, fmt.Sprintf("--provenance-key=%s", config.ProvenanceKey))
+		}
+		if config.ProvenanceBuilderID != "" {
+			serializedArgs = append(serializedArgs, fmt.Sprintf("--provenance-builder-id=%s", config.ProvenanceBuilderID))
+		}
+	}
+
 	for _, dependency := range dependencies {
 		serializedArgs = append(serializedArgs, fmt.Sprintf("--install-package=%s", dependency))
 	}
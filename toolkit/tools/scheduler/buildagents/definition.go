@@ -6,6 +6,8 @@ package buildagents
 import (
 	"fmt"
 	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
 )
 
 // BuildAgentConfig represents configuration options a BuildAgent would need to successfully build a given package.
@@ -14,6 +16,7 @@ type BuildAgentConfig struct {
 
 	WorkDir      string
 	WorkerTar    string
+	WorkerImage  string
 	RepoFile     string
 	RpmDir       string
 	ToolchainDir string
@@ -33,8 +36,35 @@ type BuildAgentConfig struct {
 	MaxCpu    string
 	Timeout   time.Duration
 
-	LogDir   string
-	LogLevel string
+	// ChrootLimits are the resource limits of each command run in the build chroots (e.g. a scriptlet or rpmbuild).
+	// Their cgroups are created in ChrootCgroupParent, which must already have the controllers the limits need.
+	ChrootLimits       cgroup.Limits
+	ChrootCgroupParent string
+
+	// NetworkIsolation is the network policy of the builds. Isolated builds may only reach the hosts in
+	// NetworkAllowlist.
+	NetworkIsolation string
+	NetworkAllowlist []string
+
+	// Provenance enables writing a SLSA provenance attestation next to each built RPM, signed with ProvenanceKey
+	// and naming ProvenanceBuilderID as the builder.
+	Provenance          bool
+	ProvenanceKey       string
+	ProvenanceBuilderID string
+
+	LogDir    string
+	LogLevel  string
+	LogFormat string
+
+	// RemoteListenAddress is the address remote build workers connect to, used by RemoteAgent.
+	RemoteListenAddress string
+	// RemoteTLSCertFile and RemoteTLSKeyFile are the TLS certificate the remote build workers connect to.
+	RemoteTLSCertFile string
+	RemoteTLSKeyFile  string
+	// RemoteTLSClientCAFile requires the remote build workers to present a client certificate signed by it.
+	RemoteTLSClientCAFile string
+	// RemoteTokenFile requires the remote build workers to send the pre-shared token it contains.
+	RemoteTokenFile string
 }
 
 // BuildAgent provides an interface for a build agent that takes in an input package and builds it.
@@ -49,8 +79,9 @@ type BuildAgent interface {
 	// - outArch is the target architecture to build for.
 	// - runCheck is true if the package should run the "%check" section during the build
 	// - dependencies is a list of dependencies that need to be installed before building.
+	// - expectedFiles is a list of the RPMs the build is expected to produce.
 	// - allowableRuntime is how long the package build is allowed to run.
-	BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies []string, allowableRuntime time.Duration) ([]string, string, error)
+	BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies, expectedFiles []string, allowableRuntime time.Duration) ([]string, string, error)
 
 	// Config returns a copy of the agent's configuration.
 	Config() BuildAgentConfig
@@ -66,6 +97,8 @@ func BuildAgentFactory(buildAgent string) (agent BuildAgent, err error) {
 		agent = NewTestAgent()
 	case ChrootAgentFlag:
 		agent = NewChrootAgent()
+	case RemoteAgentFlag:
+		agent = NewRemoteAgent()
 	default:
 		err = fmt.Errorf("unknown build agent type (%s)", buildAgent)
 	}
@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package buildagents
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/remoteworker"
+	"google.golang.org/grpc"
+)
+
+// RemoteAgentFlag is the build-agent option for RemoteAgent.
+const RemoteAgentFlag = "remote-agent"
+
+// RemoteAgent implements the BuildAgent interface to build SRPMs on remote workers (see the 'remoteworker' tool).
+// The workers connect to the agent on the configured listen address.
+type RemoteAgent struct {
+	config      *BuildAgentConfig
+	coordinator *remoteworker.Coordinator
+}
+
+// NewRemoteAgent returns a new RemoteAgent.
+func NewRemoteAgent() *RemoteAgent {
+	return &RemoteAgent{}
+}
+
+// Initialize initializes the remote agent with the given configuration and starts accepting remote workers.
+func (r *RemoteAgent) Initialize(config *BuildAgentConfig) (err error) {
+	if config.RemoteListenAddress == "" {
+		return fmt.Errorf("the remote build agent requires a listen address")
+	}
+
+	// The workers upload RPMs straight into the RPM directory, so they must be authenticated, either by a client
+	// certificate or by a pre-shared token, and the token must not be sent in the clear.
+	if config.RemoteTLSCertFile == "" {
+		return fmt.Errorf("the remote build agent requires a TLS certificate")
+	}
+
+	if config.RemoteTLSClientCAFile == "" && config.RemoteTokenFile == "" {
+		return fmt.Errorf("the remote build agent requires a client CA certificate or a token file to authenticate the workers")
+	}
+
+	tlsCredentials, err := remoteworker.ServerCredentials(config.RemoteTLSCertFile, config.RemoteTLSKeyFile, config.RemoteTLSClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the remote build agent's TLS credentials:\n%w", err)
+	}
+
+	var token string
+	if config.RemoteTokenFile != "" {
+		token, err = remoteworker.ReadToken(config.RemoteTokenFile)
+		if err != nil {
+			return
+		}
+	}
+
+	// Remote workers must build for the same architecture as the local machine.
+	architecture, err := rpm.GetRpmArch(runtime.GOARCH)
+	if err != nil {
+		return
+	}
+
+	listener, err := net.Listen("tcp", config.RemoteListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on (%s):\n%w", config.RemoteListenAddress, err)
+	}
+
+	r.config = config
+	r.coordinator = remoteworker.NewCoordinator(remoteworker.CoordinatorConfig{
+		RPMDir:       config.RpmDir,
+		ToolchainDir: config.ToolchainDir,
+		CacheDir:     config.CacheDir,
+		LogDir:       config.LogDir,
+		Architecture: architecture,
+		Token:        token,
+	})
+
+	go func() {
+		serveErr := r.coordinator.Serve(listener, grpc.Creds(tlsCredentials))
+		if serveErr != nil {
+			logger.Log.Errorf("Remote build agent stopped:\n%s", serveErr)
+		}
+	}()
+
+	return
+}
+
+// BuildPackage builds a given file on a remote worker and returns the output files or error.
+// - basePackageName is the base package name (i.e. 'kernel').
+// - inputFile is the SRPM to build.
+// - logName is the file name to save the package build log to.
+// - outArch is the target architecture to build for.
+// - runCheck is true if the package should run the "%check" section during the build
+// - dependencies is a list of dependencies that need to be installed before building.
+// - expectedFiles is a list of the RPMs the build is expected to produce.
+func (r *RemoteAgent) BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies, expectedFiles []string, allowableRuntime time.Duration) (builtFiles []string, logFile string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), allowableRuntime)
+	defer cancel()
+
+	builtFiles, logFile, err = r.coordinator.Build(ctx, remoteworker.BuildJob{
+		BasePackageName: basePackageName,
+		SRPMPath:        inputFile,
+		LogName:         logName,
+		OutArch:         outArch,
+		RunCheck:        runCheck,
+		Dependencies:    dependencies,
+		ExpectedFiles:   expectedFiles,
+		Timeout:         allowableRuntime,
+	})
+	if err != nil || runCheck {
+		return
+	}
+
+	// Like pkgworker does for local builds, keep a copy of the SRPM which produced the packages.
+	srpmOutputFile := filepath.Join(r.config.SrpmDir, filepath.Base(inputFile))
+	err = file.Copy(inputFile, srpmOutputFile)
+	if err != nil {
+		err = fmt.Errorf("failed to copy (%s) to (%s):\n%w", inputFile, srpmOutputFile, err)
+	}
+
+	return
+}
+
+// Config returns a copy of the agent's configuration.
+func (r *RemoteAgent) Config() (config BuildAgentConfig) {
+	return *r.config
+}
+
+// Close stops accepting remote workers. Builds still waiting for a worker fail.
+func (r *RemoteAgent) Close() (err error) {
+	if r.coordinator != nil {
+		r.coordinator.Stop()
+	}
+
+	return
+}
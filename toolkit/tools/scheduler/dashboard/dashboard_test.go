@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/schedulerutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+type testSRPM struct {
+	build *pkggraph.PkgNode
+	run   *pkggraph.PkgNode
+}
+
+func addTestSRPM(t *testing.T, pkgGraph *pkggraph.PkgGraph, name string) testSRPM {
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: "1.0"}
+	srpmPath := name + ".src.rpm"
+
+	run, err := pkgGraph.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeLocalRun, srpmPath, name+".rpm", name+".spec", name, "x86_64", "")
+	require.NoError(t, err)
+
+	build, err := pkgGraph.AddPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeLocalBuild, srpmPath, name+".rpm", name+".spec", name, "x86_64", "")
+	require.NoError(t, err)
+
+	require.NoError(t, pkgGraph.AddEdge(run, build))
+
+	return testSRPM{build: build, run: run}
+}
+
+func buildRequest(srpm testSRPM) *schedulerutils.BuildRequest {
+	return &schedulerutils.BuildRequest{Node: srpm.build, AncillaryNodes: []*pkggraph.PkgNode{srpm.build}}
+}
+
+// makeTrackedBuild creates a tracker for a graph where "compiler" is needed to build "lib", which is needed to build
+// "app", while nothing depends on "tool" and "docs".
+func makeTrackedBuild(t *testing.T) (tracker *Tracker, srpms map[string]testSRPM) {
+	pkgGraph := pkggraph.NewPkgGraph()
+	srpms = make(map[string]testSRPM)
+	for _, name := range []string{"tool", "docs", "compiler", "lib", "app"} {
+		srpms[name] = addTestSRPM(t, pkgGraph, name)
+	}
+	require.NoError(t, pkgGraph.AddEdge(srpms["lib"].build, srpms["compiler"].run))
+	require.NoError(t, pkgGraph.AddEdge(srpms["app"].build, srpms["lib"].run))
+
+	tracker = NewTracker(2)
+	tracker.SetGraph(pkgGraph, &sync.RWMutex{})
+
+	return
+}
+
+func TestTrackerStatus(t *testing.T) {
+	tracker, srpms := makeTrackedBuild(t)
+
+	for _, name := range []string{"tool", "docs", "compiler"} {
+		tracker.RecordQueued(buildRequest(srpms[name]))
+	}
+	tracker.RecordDispatched(buildRequest(srpms["compiler"]))
+	tracker.RecordDispatched(buildRequest(srpms["tool"]))
+	// The build workers mark failed builds in the graph.
+	srpms["tool"].build.State = pkggraph.StateBuildError
+	tracker.RecordResult(&schedulerutils.BuildResult{Node: srpms["tool"].build, Err: fmt.Errorf("build failed"), LogFile: "tool.log"})
+
+	status := tracker.Status()
+	assert.Equal(t, 2, status.Workers)
+	assert.Equal(t, 1, status.BusyWorkers)
+	assert.Equal(t, []PackageStatus{{Name: "compiler.src.rpm", Type: PackageTypeBuild, ElapsedSeconds: status.Building[0].ElapsedSeconds}}, status.Building)
+	require.Len(t, status.Queued, 1)
+	assert.Equal(t, "docs.src.rpm", status.Queued[0].Name)
+	assert.Equal(t, []FailedPackage{{Name: "tool.src.rpm", Type: PackageTypeBuild, Error: "build failed", LogFile: "tool.log"}}, status.Failed)
+	assert.Equal(t, []string{"app.src.rpm", "lib.src.rpm"}, status.Blocked)
+	assert.Equal(t, []string{"compiler.src.rpm", "lib.src.rpm", "app.src.rpm"}, status.BlockingChain)
+}
+
+func TestNilTrackerRecordsNothing(t *testing.T) {
+	var tracker *Tracker
+	srpm := addTestSRPM(t, pkggraph.NewPkgGraph(), "tool")
+
+	tracker.RecordQueued(buildRequest(srpm))
+	tracker.RecordDispatched(buildRequest(srpm))
+	tracker.RecordResult(&schedulerutils.BuildResult{Node: srpm.build})
+}
+
+func TestHandlerServesStatus(t *testing.T) {
+	tracker, srpms := makeTrackedBuild(t)
+	tracker.RecordQueued(buildRequest(srpms["compiler"]))
+
+	server := httptest.NewServer(NewHandler(tracker))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + StatusPath)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	var status Status
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&status))
+	require.Len(t, status.Queued, 1)
+	assert.Equal(t, "compiler.src.rpm", status.Queued[0].Name)
+
+	page, err := http.Get(server.URL + "/")
+	require.NoError(t, err)
+	page.Body.Close()
+	assert.Equal(t, http.StatusOK, page.StatusCode)
+}
+
+func TestRenderText(t *testing.T) {
+	text := RenderText(Status{
+		Workers:       4,
+		BusyWorkers:   1,
+		Building:      []PackageStatus{{Name: "llvm.src.rpm", ElapsedSeconds: 90}},
+		Queued:        []PackageStatus{{Name: "a.src.rpm"}, {Name: "b.src.rpm"}, {Name: "c.src.rpm"}},
+		BlockingChain: []string{"llvm.src.rpm", "clang.src.rpm"},
+	}, 2)
+
+	assert.Contains(t, text, "Workers 1/4 busy")
+	assert.Contains(t, text, "llvm.src.rpm -> clang.src.rpm")
+	assert.Contains(t, text, "1m30s")
+	assert.Contains(t, text, "... 1 more")
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package dashboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// StatusPath is the path the build progress is served on, as JSON.
+const StatusPath = "/api/status"
+
+const readHeaderTimeout = 10 * time.Second
+
+//go:embed index.html
+var indexPage []byte
+
+// StartServer serves the web dashboard and the build progress on an address ('<host>:<port>') in the background.
+func StartServer(address string, tracker *Tracker) (err error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on (%s):\n%w", address, err)
+	}
+
+	server := &http.Server{
+		Handler:           NewHandler(tracker),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		serveErr := server.Serve(listener)
+		if serveErr != nil && serveErr != http.ErrServerClosed {
+			logger.Log.Warnf("Build dashboard stopped:\n%s", serveErr)
+		}
+	}()
+
+	logger.Log.Infof("Serving the build dashboard on http://%s", listener.Addr())
+
+	return
+}
+
+// NewHandler returns the HTTP handler serving the web dashboard and the build progress.
+func NewHandler(tracker *Tracker) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/" {
+			http.NotFound(writer, request)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Write(indexPage)
+	})
+
+	mux.HandleFunc(StatusPath, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+
+		err := json.NewEncoder(writer).Encode(tracker.Status())
+		if err != nil {
+			logger.Log.Debugf("Failed to send the build status:\n%s", err)
+		}
+	})
+
+	return mux
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package dashboard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RenderText formats the build progress for a terminal. Each list of packages shows at most maxRows packages.
+func RenderText(status Status, maxRows int) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "Elapsed %s | Built %d | Cached %d | Failed %d | Workers %d/%d busy\n",
+		formatSeconds(status.ElapsedSeconds), status.Built, status.Cached, len(status.Failed), status.BusyWorkers, status.Workers)
+
+	if len(status.BlockingChain) > 0 {
+		fmt.Fprintf(&builder, "\nBlocking chain (%d):\n  %s\n", len(status.BlockingChain), strings.Join(status.BlockingChain, " -> "))
+	}
+
+	fmt.Fprintf(&builder, "\nBuilding (%d):\n", len(status.Building))
+	for i, pkg := range status.Building {
+		if i == maxRows {
+			fmt.Fprintf(&builder, "  ... %d more\n", len(status.Building)-maxRows)
+			break
+		}
+		fmt.Fprintf(&builder, "  %-60s %s\n", pkg.Name, formatSeconds(pkg.ElapsedSeconds))
+	}
+
+	fmt.Fprintf(&builder, "\nQueued (%d):\n", len(status.Queued))
+	for i, pkg := range status.Queued {
+		if i == maxRows {
+			fmt.Fprintf(&builder, "  ... %d more\n", len(status.Queued)-maxRows)
+			break
+		}
+		fmt.Fprintf(&builder, "  %-60s waiting %s\n", pkg.Name, formatSeconds(pkg.ElapsedSeconds))
+	}
+
+	if len(status.Failed) > 0 {
+		fmt.Fprintf(&builder, "\nFailed (%d):\n", len(status.Failed))
+		for i, pkg := range status.Failed {
+			if i == maxRows {
+				fmt.Fprintf(&builder, "  ... %d more\n", len(status.Failed)-maxRows)
+				break
+			}
+			fmt.Fprintf(&builder, "  %-60s %s\n", pkg.Name, pkg.LogFile)
+		}
+	}
+
+	fmt.Fprintf(&builder, "\nBlocked: %d package(s)\n", len(status.Blocked))
+
+	return builder.String()
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
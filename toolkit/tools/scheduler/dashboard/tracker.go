@@ -0,0 +1,291 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package dashboard tracks the scheduler's build progress and serves it to the web and terminal dashboards.
+package dashboard
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/schedulerutils"
+)
+
+// Package types shown in the dashboards.
+const (
+	PackageTypeBuild = "build"
+	PackageTypeTest  = "test"
+)
+
+// PackageStatus is a queued or building package.
+type PackageStatus struct {
+	Name           string  `json:"name"`
+	Type           string  `json:"type"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+}
+
+// FailedPackage is a package which failed to build or whose tests failed.
+type FailedPackage struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Error   string `json:"error"`
+	LogFile string `json:"logFile"`
+	// RetryHistory lists the failure classes of the package's failed attempts.
+	RetryHistory string `json:"retryHistory"`
+}
+
+// Status is a snapshot of the build progress.
+type Status struct {
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	Workers        int     `json:"workers"`
+	BusyWorkers    int     `json:"busyWorkers"`
+	Built          int     `json:"built"`
+	Cached         int     `json:"cached"`
+
+	// Queued packages are ready to build and wait for a free worker, in the order they became ready.
+	Queued []PackageStatus `json:"queued"`
+	// Building packages are being built by a worker, longest running first.
+	Building []PackageStatus `json:"building"`
+	// Blocked packages wait for some of their dependencies to be built.
+	Blocked []string        `json:"blocked"`
+	Failed  []FailedPackage `json:"failed"`
+	// BlockingChain is the longest chain of pending builds, starting with a queued or building package. The build
+	// can't finish before all of them are built.
+	BlockingChain []string `json:"blockingChain"`
+}
+
+// Tracker records the progress of the build. The scheduler records its requests and results, and the dashboards read
+// snapshots of the progress. Recording to a nil tracker does nothing.
+//
+// Tracker is safe for concurrent use.
+type Tracker struct {
+	mutex sync.Mutex
+
+	startTime  time.Time
+	workers    int
+	pkgGraph   *pkggraph.PkgGraph
+	graphMutex *sync.RWMutex
+
+	queued   map[int64]trackedPackage
+	building map[int64]trackedPackage
+	failed   []FailedPackage
+	built    int
+	cached   int
+}
+
+type trackedPackage struct {
+	name      string
+	pkgType   string
+	startTime time.Time
+}
+
+// NewTracker creates a tracker for a build using a number of workers.
+func NewTracker(workers int) *Tracker {
+	return &Tracker{
+		startTime: time.Now(),
+		workers:   workers,
+		queued:    make(map[int64]trackedPackage),
+		building:  make(map[int64]trackedPackage),
+	}
+}
+
+// SetGraph sets the graph being built. Must be called again whenever the scheduler replaces its graph.
+func (t *Tracker) SetGraph(pkgGraph *pkggraph.PkgGraph, graphMutex *sync.RWMutex) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.pkgGraph = pkgGraph
+	t.graphMutex = graphMutex
+}
+
+// RecordQueued records a build or test waiting for a free worker.
+func (t *Tracker) RecordQueued(req *schedulerutils.BuildRequest) {
+	if t == nil || !schedulerutils.IsQueuedNode(req.Node) {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.queued[req.Node.ID()] = newTrackedPackage(req.Node)
+}
+
+// RecordDispatched records a queued build or test handed to a worker.
+func (t *Tracker) RecordDispatched(req *schedulerutils.BuildRequest) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.queued, req.Node.ID())
+	t.building[req.Node.ID()] = newTrackedPackage(req.Node)
+}
+
+// RecordResult records the result of a build or test.
+func (t *Tracker) RecordResult(res *schedulerutils.BuildResult) {
+	if t == nil || !schedulerutils.IsQueuedNode(res.Node) {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tracked, found := t.building[res.Node.ID()]
+	if !found {
+		tracked = newTrackedPackage(res.Node)
+	}
+	delete(t.queued, res.Node.ID())
+	delete(t.building, res.Node.ID())
+
+	switch {
+	case res.Err != nil || res.CheckFailed:
+		failure := FailedPackage{
+			Name:         tracked.name,
+			Type:         tracked.pkgType,
+			LogFile:      res.LogFile,
+			RetryHistory: schedulerutils.FormatRetryHistory(res.RetryHistory),
+		}
+		if res.Err != nil {
+			failure.Error = res.Err.Error()
+		}
+		t.failed = append(t.failed, failure)
+	case res.UsedCache:
+		t.cached++
+	default:
+		t.built++
+	}
+}
+
+// Status returns a snapshot of the build progress.
+func (t *Tracker) Status() (status Status) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	status = Status{
+		ElapsedSeconds: now.Sub(t.startTime).Seconds(),
+		Workers:        t.workers,
+		BusyWorkers:    len(t.building),
+		Built:          t.built,
+		Cached:         t.cached,
+		Queued:         packageStatuses(t.queued, now),
+		Building:       packageStatuses(t.building, now),
+		Blocked:        []string{},
+		Failed:         append([]FailedPackage{}, t.failed...),
+		BlockingChain:  []string{},
+	}
+
+	// Queued packages are listed in the order they became ready, running builds longest running first.
+	sort.SliceStable(status.Queued, func(i, j int) bool {
+		return status.Queued[i].ElapsedSeconds > status.Queued[j].ElapsedSeconds
+	})
+	sort.SliceStable(status.Building, func(i, j int) bool {
+		return status.Building[i].ElapsedSeconds > status.Building[j].ElapsedSeconds
+	})
+
+	if t.pkgGraph != nil {
+		status.Blocked, status.BlockingChain = t.graphStatus()
+	}
+
+	return
+}
+
+// graphStatus finds the blocked packages and the blocking chain in the graph.
+func (t *Tracker) graphStatus() (blocked, blockingChain []string) {
+	priorities := schedulerutils.NewBuildPriorities(t.pkgGraph, t.graphMutex)
+
+	t.graphMutex.RLock()
+	defer t.graphMutex.RUnlock()
+
+	// A SRPM has a build node for each of its packages, they are all built by the same request.
+	activeNames := make(map[string]bool)
+	for _, tracked := range t.queued {
+		activeNames[tracked.name] = true
+	}
+	for _, tracked := range t.building {
+		activeNames[tracked.name] = true
+	}
+
+	blocked = []string{}
+	blockedNames := make(map[string]bool)
+	var chainStart *pkggraph.PkgNode
+	for _, node := range t.pkgGraph.AllNodes() {
+		if !schedulerutils.IsQueuedNode(node) || priorities.CriticalPathLength(node) == 0 {
+			continue
+		}
+
+		name := packageName(node)
+		if activeNames[name] {
+			if chainStart == nil || priorities.CriticalPathLength(node) > priorities.CriticalPathLength(chainStart) {
+				chainStart = node
+			}
+			continue
+		}
+
+		// Failed builds are still pending in the graph but will not be built again.
+		if node.State == pkggraph.StateBuildError {
+			continue
+		}
+
+		if !blockedNames[name] {
+			blockedNames[name] = true
+			blocked = append(blocked, name)
+		}
+	}
+	sort.Strings(blocked)
+
+	blockingChain = []string{}
+	if chainStart != nil {
+		for _, node := range priorities.CriticalPath(t.pkgGraph, chainStart) {
+			name := packageName(node)
+			if len(blockingChain) == 0 || blockingChain[len(blockingChain)-1] != name {
+				blockingChain = append(blockingChain, name)
+			}
+		}
+	}
+
+	return
+}
+
+func newTrackedPackage(node *pkggraph.PkgNode) trackedPackage {
+	pkgType := PackageTypeBuild
+	if node.Type == pkggraph.TypeTest {
+		pkgType = PackageTypeTest
+	}
+
+	return trackedPackage{
+		name:      packageName(node),
+		pkgType:   pkgType,
+		startTime: time.Now(),
+	}
+}
+
+// packageName returns the name packages are shown with: the name of their SRPM, and the tested SRPM for tests.
+func packageName(node *pkggraph.PkgNode) string {
+	if node.Type == pkggraph.TypeTest {
+		return node.SRPMFileName() + " (test)"
+	}
+
+	return node.SRPMFileName()
+}
+
+func packageStatuses(packages map[int64]trackedPackage, now time.Time) (statuses []PackageStatus) {
+	statuses = []PackageStatus{}
+	for _, tracked := range packages {
+		statuses = append(statuses, PackageStatus{
+			Name:           tracked.name,
+			Type:           tracked.pkgType,
+			ElapsedSeconds: now.Sub(tracked.startTime).Seconds(),
+		})
+	}
+
+	return
+}
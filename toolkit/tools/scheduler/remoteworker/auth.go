@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package remoteworker
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// authorizationHeader is the metadata key of the token sent by the workers.
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+// ServerCredentials returns the TLS credentials of a coordinator. If clientCAFile is set, then the workers must
+// present a client certificate signed by it.
+func ServerCredentials(certFile, keyFile, clientCAFile string) (creds credentials.TransportCredentials, err error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate (%s):\n%w", certFile, err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		config.ClientCAs, err = loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// ClientCredentials returns the TLS credentials of a worker, verifying the coordinator's certificate with caFile. If
+// certFile is set, then the worker presents it as its client certificate.
+func ClientCredentials(caFile, certFile, keyFile string) (creds credentials.TransportCredentials, err error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	config.RootCAs, err = loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if certFile != "" {
+		var certificate tls.Certificate
+		certificate, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the TLS client certificate (%s):\n%w", certFile, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// ReadToken reads a pre-shared token from a file, ignoring surrounding white space.
+func ReadToken(tokenFile string) (token string, err error) {
+	content, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the token file (%s):\n%w", tokenFile, err)
+	}
+
+	token = strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("the token file (%s) is empty", tokenFile)
+	}
+
+	return token, nil
+}
+
+// TokenCredentials sends a pre-shared token with each call of a worker. It is only sent over TLS.
+type TokenCredentials struct {
+	Token string
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (t TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{authorizationHeader: bearerPrefix + t.Token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+func (t TokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// tokenServerOptions returns the interceptors rejecting the calls without the token.
+func tokenServerOptions(token string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			err := checkToken(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, request)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := checkToken(stream.Context(), token)
+			if err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
+
+// checkToken checks the token sent with a call.
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(authorizationHeader) {
+		received, found := strings.CutPrefix(value, bearerPrefix)
+		if found && subtle.ConstantTimeCompare([]byte(received), []byte(token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "missing or invalid worker token")
+}
+
+func loadCertPool(caFile string) (pool *x509.CertPool, err error) {
+	content, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA certificate (%s):\n%w", caFile, err)
+	}
+
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no PEM certificate found in (%s)", caFile)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,645 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package remoteworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultHeartbeatInterval is how often workers send heartbeats if the coordinator doesn't set an interval.
+	DefaultHeartbeatInterval = 10 * time.Second
+
+	// missedHeartbeats is how many heartbeats in a row a worker may miss before it's considered lost.
+	missedHeartbeats = 3
+
+	// defaultPollTimeout is how long a worker's request for a job waits for a job to become available.
+	defaultPollTimeout = 30 * time.Second
+)
+
+// CoordinatorConfig configures a Coordinator.
+type CoordinatorConfig struct {
+	// RPMDir is the directory of the built RPMs. Uploaded RPMs are saved here.
+	RPMDir       string
+	ToolchainDir string
+	CacheDir     string
+	// LogDir is the directory the streamed build logs are saved to.
+	LogDir string
+	// Architecture is the only architecture of the workers allowed to register. Any architecture is allowed if empty.
+	Architecture      string
+	HeartbeatInterval time.Duration
+	// PollTimeout is how long a worker's request for a job waits for a job to become available.
+	PollTimeout time.Duration
+	// Token is the pre-shared token the workers must send with every call. Any worker is allowed if empty, so the
+	// listener must then authenticate the workers through their TLS client certificates.
+	Token string
+}
+
+// BuildJob is a package build to run on a remote worker. All paths are on the coordinator's machine.
+type BuildJob struct {
+	BasePackageName string
+	SRPMPath        string
+	LogName         string
+	OutArch         string
+	RunCheck        bool
+	Dependencies    []string
+	// ExpectedFiles are the RPMs the build is expected to produce. The worker may upload no other file.
+	ExpectedFiles []string
+	Timeout       time.Duration
+}
+
+// Coordinator hands package builds to the remote workers registered with it. It is the scheduler's side of the
+// protocol.
+type Coordinator struct {
+	config CoordinatorConfig
+	server *grpc.Server
+
+	mutex   sync.Mutex
+	workers map[string]*workerState
+	jobs    map[string]*coordinatorJob
+	// pending are the IDs of the jobs waiting for a worker, in the order they will be handed out.
+	pending []string
+	// jobsChanged is closed and replaced every time a job is added to pending.
+	jobsChanged chan struct{}
+	lastID      uint64
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+type workerState struct {
+	lastSeen time.Time
+}
+
+type coordinatorJob struct {
+	job Job
+	// files maps the keys of the job's files to their paths on the coordinator's machine.
+	files map[string]string
+	// expectedFiles are the paths, relative to the RPM directory, of the RPMs the worker may upload.
+	expectedFiles map[string]bool
+	// workerID is the worker building the job, empty while the job is pending.
+	workerID string
+	done     chan jobOutcome
+}
+
+type jobOutcome struct {
+	builtFiles []string
+	err        error
+}
+
+// NewCoordinator creates a new coordinator. Serve must be called for workers to be able to connect to it.
+func NewCoordinator(config CoordinatorConfig) *Coordinator {
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if config.PollTimeout <= 0 {
+		config.PollTimeout = defaultPollTimeout
+	}
+
+	return &Coordinator{
+		config:      config,
+		workers:     make(map[string]*workerState),
+		jobs:        make(map[string]*coordinatorJob),
+		jobsChanged: make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Serve accepts worker connections on a listener until Stop is called.
+func (c *Coordinator) Serve(listener net.Listener, options ...grpc.ServerOption) (err error) {
+	options = append(options, grpc.ForceServerCodec(jsonCodec{}))
+	if c.config.Token != "" {
+		options = append(options, tokenServerOptions(c.config.Token)...)
+	}
+
+	c.mutex.Lock()
+	c.server = grpc.NewServer(options...)
+	c.server.RegisterService(&serviceDesc, c)
+	c.mutex.Unlock()
+
+	go c.removeLostWorkers()
+
+	logger.Log.Infof("Waiting for remote build workers on (%s)", listener.Addr())
+	err = c.server.Serve(listener)
+	if err != nil {
+		return fmt.Errorf("failed to serve remote build workers:\n%w", err)
+	}
+
+	return
+}
+
+// Stop stops the coordinator. Any builds still waiting for a worker fail.
+func (c *Coordinator) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+
+		c.mutex.Lock()
+		server := c.server
+		c.mutex.Unlock()
+
+		if server != nil {
+			server.Stop()
+		}
+	})
+}
+
+// Build runs a package build on a remote worker and waits for it to finish. Returns the paths of the built RPMs and of
+// the build log.
+func (c *Coordinator) Build(ctx context.Context, buildJob BuildJob) (builtFiles []string, logFile string, err error) {
+	logFile = filepath.Join(c.config.LogDir, buildJob.LogName)
+
+	job, err := c.newJob(buildJob)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	c.jobs[job.job.ID] = job
+	c.queueJobLocked(job.job.ID, false)
+	c.mutex.Unlock()
+
+	select {
+	case outcome := <-job.done:
+		return outcome.builtFiles, logFile, outcome.err
+	case <-ctx.Done():
+		c.dropJob(job.job.ID)
+		err = fmt.Errorf("remote build of (%s) did not finish:\n%w", filepath.Base(buildJob.SRPMPath), ctx.Err())
+	case <-c.stopped:
+		c.dropJob(job.job.ID)
+		err = fmt.Errorf("remote build of (%s) did not finish, the coordinator stopped", filepath.Base(buildJob.SRPMPath))
+	}
+
+	return
+}
+
+// Register adds a worker to the build.
+func (c *Coordinator) Register(ctx context.Context, request *RegisterRequest) (response *RegisterResponse, err error) {
+	if c.config.Architecture != "" && request.Architecture != c.config.Architecture {
+		return nil, status.Errorf(codes.InvalidArgument, "worker architecture (%s) doesn't match the build architecture (%s)",
+			request.Architecture, c.config.Architecture)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastID++
+	workerID := fmt.Sprintf("%s-%d", request.Name, c.lastID)
+	c.workers[workerID] = &workerState{
+		lastSeen: time.Now(),
+	}
+
+	logger.Log.Infof("Remote build worker (%s) registered", workerID)
+
+	return &RegisterResponse{
+		WorkerID:          workerID,
+		HeartbeatInterval: c.config.HeartbeatInterval,
+	}, nil
+}
+
+// Heartbeat records that a worker is still alive.
+func (c *Coordinator) Heartbeat(ctx context.Context, request *HeartbeatRequest) (response *HeartbeatResponse, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	worker := c.workers[request.WorkerID]
+	if worker != nil {
+		worker.lastSeen = time.Now()
+	}
+
+	return &HeartbeatResponse{Registered: worker != nil}, nil
+}
+
+// NextJob assigns the next pending job to a worker. If no job becomes available before the poll timeout, an empty
+// job is returned.
+func (c *Coordinator) NextJob(ctx context.Context, request *JobRequest) (response *Job, err error) {
+	timeout := time.NewTimer(c.config.PollTimeout)
+	defer timeout.Stop()
+
+	// Workers build one job at a time, so a job still assigned to the worker never reached it and must be re-queued.
+	c.mutex.Lock()
+	c.requeueWorkerJobsLocked(request.WorkerID)
+	c.mutex.Unlock()
+
+	for {
+		c.mutex.Lock()
+		worker := c.workers[request.WorkerID]
+		if worker == nil {
+			c.mutex.Unlock()
+			return nil, status.Errorf(codes.NotFound, "worker (%s) isn't registered", request.WorkerID)
+		}
+		worker.lastSeen = time.Now()
+
+		if len(c.pending) > 0 {
+			job := c.jobs[c.pending[0]]
+			c.pending = c.pending[1:]
+			job.workerID = request.WorkerID
+			response = &job.job
+			c.mutex.Unlock()
+
+			logger.Log.Debugf("Assigned (%s) to remote build worker (%s)", job.job.SRPM.RelativePath, request.WorkerID)
+			return response, nil
+		}
+
+		jobsChanged := c.jobsChanged
+		c.mutex.Unlock()
+
+		select {
+		case <-jobsChanged:
+		case <-timeout.C:
+			return &Job{}, nil
+		case <-c.stopped:
+			return &Job{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// CompleteJob records the outcome of a job.
+func (c *Coordinator) CompleteJob(ctx context.Context, result *JobResult) (response *Empty, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	job, err := c.assignedJobLocked(result.WorkerID, result.JobID)
+	if err != nil {
+		return
+	}
+
+	outcome := jobOutcome{}
+	if result.Error != "" {
+		outcome.err = fmt.Errorf("remote build worker (%s) failed to build (%s):\n%w", result.WorkerID,
+			job.job.SRPM.RelativePath, errors.New(result.Error))
+	}
+
+	for _, builtFile := range result.BuiltFiles {
+		if !job.expectedFiles[filepath.Clean(builtFile)] {
+			outcome.err = errors.Join(outcome.err, fmt.Errorf("remote build worker (%s) reported an unexpected RPM (%s)",
+				result.WorkerID, builtFile))
+			continue
+		}
+		outcome.builtFiles = append(outcome.builtFiles, filepath.Join(c.config.RPMDir, builtFile))
+	}
+
+	delete(c.jobs, result.JobID)
+	job.done <- outcome
+
+	return &Empty{}, nil
+}
+
+// DownloadFile sends one of a job's files to the worker assigned to the job.
+func (c *Coordinator) DownloadFile(request *DownloadRequest, stream grpc.ServerStream) (err error) {
+	c.mutex.Lock()
+	job, err := c.assignedJobLocked(request.WorkerID, request.JobID)
+	var path string
+	if err == nil {
+		path = job.files[fileKey(request.File)]
+	}
+	c.mutex.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	if path == "" {
+		return status.Errorf(codes.NotFound, "file (%s) isn't part of job (%s)", request.File.RelativePath, request.JobID)
+	}
+
+	source, err := os.Open(path)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to open (%s): %s", path, err)
+	}
+	defer source.Close()
+
+	buffer := make([]byte, fileChunkSize)
+	for {
+		read, readErr := source.Read(buffer)
+		if read > 0 {
+			err = stream.SendMsg(&FileChunk{Data: buffer[:read]})
+			if err != nil {
+				return
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return status.Errorf(codes.Internal, "failed to read (%s): %s", path, readErr)
+		}
+	}
+}
+
+// UploadFile saves an RPM built by a worker, or its provenance attestation, into the RPM directory.
+func (c *Coordinator) UploadFile(stream grpc.ServerStream) (err error) {
+	chunk := &FileChunk{}
+	err = stream.RecvMsg(chunk)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	job, err := c.assignedJobLocked(chunk.WorkerID, chunk.JobID)
+	c.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	// Only accept the RPMs the job is expected to produce, and their provenance attestations.
+	rpmPath := strings.TrimSuffix(chunk.RelativePath, provenance.FileSuffix)
+	if !filepath.IsLocal(chunk.RelativePath) || !job.expectedFiles[filepath.Clean(rpmPath)] {
+		return status.Errorf(codes.PermissionDenied, "(%s) isn't an RPM expected from job (%s)", chunk.RelativePath, chunk.JobID)
+	}
+
+	destination := filepath.Join(c.config.RPMDir, chunk.RelativePath)
+	err = receiveFile(stream, chunk, destination)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to save (%s): %s", destination, err)
+	}
+
+	return stream.SendMsg(&Empty{})
+}
+
+// StreamLog saves the build log of a job, overwriting the log of any earlier attempt of the same build.
+func (c *Coordinator) StreamLog(stream grpc.ServerStream) (err error) {
+	chunk := &LogChunk{}
+	err = stream.RecvMsg(chunk)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	job, err := c.assignedJobLocked(chunk.WorkerID, chunk.JobID)
+	var logFile string
+	if err == nil {
+		logFile = filepath.Join(c.config.LogDir, job.job.LogName)
+	}
+	c.mutex.Unlock()
+	if err != nil {
+		return
+	}
+
+	destination, err := os.Create(logFile)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create (%s): %s", logFile, err)
+	}
+	defer destination.Close()
+
+	for {
+		_, err = destination.Write(chunk.Data)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to write (%s): %s", logFile, err)
+		}
+
+		chunk = &LogChunk{}
+		err = stream.RecvMsg(chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return stream.SendMsg(&Empty{})
+}
+
+// newJob creates a job for a build, referencing its files by their root.
+func (c *Coordinator) newJob(buildJob BuildJob) (job *coordinatorJob, err error) {
+	c.mutex.Lock()
+	c.lastID++
+	jobID := fmt.Sprintf("job-%d", c.lastID)
+	c.mutex.Unlock()
+
+	job = &coordinatorJob{
+		job: Job{
+			ID:              jobID,
+			BasePackageName: buildJob.BasePackageName,
+			LogName:         buildJob.LogName,
+			OutArch:         buildJob.OutArch,
+			RunCheck:        buildJob.RunCheck,
+			Timeout:         buildJob.Timeout,
+		},
+		files:         make(map[string]string),
+		expectedFiles: make(map[string]bool),
+		done:          make(chan jobOutcome, 1),
+	}
+
+	for _, expectedFile := range buildJob.ExpectedFiles {
+		var relativePath string
+		relativePath, err = filepath.Rel(c.config.RPMDir, expectedFile)
+		if err != nil || !filepath.IsLocal(relativePath) {
+			return nil, fmt.Errorf("expected RPM (%s) isn't in the RPM directory (%s)", expectedFile, c.config.RPMDir)
+		}
+		job.expectedFiles[relativePath] = true
+	}
+
+	job.job.SRPM, err = c.fileRef(buildJob.SRPMPath)
+	if err != nil {
+		return
+	}
+	job.files[fileKey(job.job.SRPM)] = buildJob.SRPMPath
+
+	for _, dependency := range buildJob.Dependencies {
+		var ref FileRef
+		ref, err = c.fileRef(dependency)
+		if err != nil {
+			return
+		}
+
+		job.job.Dependencies = append(job.job.Dependencies, ref)
+		job.files[fileKey(ref)] = dependency
+	}
+
+	return
+}
+
+// fileRef references a file by its path relative to the root directory containing it.
+func (c *Coordinator) fileRef(path string) (ref FileRef, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ref, fmt.Errorf("failed to stat (%s):\n%w", path, err)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return ref, fmt.Errorf("failed to get absolute path of (%s):\n%w", path, err)
+	}
+
+	roots := []struct {
+		name string
+		dir  string
+	}{
+		{FileRootRPMs, c.config.RPMDir},
+		{FileRootToolchain, c.config.ToolchainDir},
+		{FileRootCache, c.config.CacheDir},
+	}
+
+	for _, root := range roots {
+		if root.dir == "" {
+			continue
+		}
+
+		absRoot, absErr := filepath.Abs(root.dir)
+		if absErr != nil {
+			continue
+		}
+
+		relativePath, relErr := filepath.Rel(absRoot, absPath)
+		if relErr == nil && filepath.IsLocal(relativePath) {
+			return FileRef{Root: root.name, RelativePath: relativePath, Size: info.Size()}, nil
+		}
+	}
+
+	return FileRef{Root: FileRootOther, RelativePath: filepath.Base(path), Size: info.Size()}, nil
+}
+
+// queueJobLocked adds a job to the pending jobs and wakes up the workers waiting for one. Re-queued jobs go first.
+func (c *Coordinator) queueJobLocked(jobID string, requeue bool) {
+	if requeue {
+		c.pending = append([]string{jobID}, c.pending...)
+	} else {
+		c.pending = append(c.pending, jobID)
+	}
+
+	close(c.jobsChanged)
+	c.jobsChanged = make(chan struct{})
+}
+
+// dropJob forgets a job, so a worker still building it can't complete it.
+func (c *Coordinator) dropJob(jobID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.jobs, jobID)
+	for i, pendingID := range c.pending {
+		if pendingID == jobID {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *Coordinator) assignedJobLocked(workerID, jobID string) (job *coordinatorJob, err error) {
+	job = c.jobs[jobID]
+	if job == nil || job.workerID != workerID || c.workers[workerID] == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "job (%s) isn't assigned to worker (%s)", jobID, workerID)
+	}
+
+	return job, nil
+}
+
+// removeLostWorkers periodically removes the workers that stopped sending heartbeats, and re-queues their jobs.
+func (c *Coordinator) removeLostWorkers() {
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.removeWorkersLastSeenBefore(time.Now().Add(-missedHeartbeats * c.config.HeartbeatInterval))
+		case <-c.stopped:
+			return
+		}
+	}
+}
+
+func (c *Coordinator) removeWorkersLastSeenBefore(deadline time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for workerID, worker := range c.workers {
+		if !worker.lastSeen.Before(deadline) {
+			continue
+		}
+
+		logger.Log.Warnf("Lost remote build worker (%s), last seen at %s", workerID, worker.lastSeen.Format(time.RFC3339))
+		delete(c.workers, workerID)
+		c.requeueWorkerJobsLocked(workerID)
+	}
+}
+
+// requeueWorkerJobsLocked puts the jobs assigned to a worker back at the front of the pending jobs.
+func (c *Coordinator) requeueWorkerJobsLocked(workerID string) {
+	for jobID, job := range c.jobs {
+		if job.workerID != workerID {
+			continue
+		}
+
+		logger.Log.Warnf("Re-queuing (%s) taken by remote build worker (%s)", job.job.SRPM.RelativePath, workerID)
+		job.workerID = ""
+		c.queueJobLocked(jobID, true)
+	}
+}
+
+func fileKey(ref FileRef) string {
+	return ref.Root + "/" + ref.RelativePath
+}
+
+// messageReceiver is the receiving side of a gRPC stream, on either the client or the server.
+type messageReceiver interface {
+	RecvMsg(m interface{}) error
+}
+
+// receiveFile writes the data of a stream of file chunks to a file, starting with an already received chunk. The file
+// is only created once all of the data has been received.
+func receiveFile(stream messageReceiver, firstChunk *FileChunk, destination string) (err error) {
+	err = os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	temporaryFile, err := os.CreateTemp(filepath.Dir(destination), "."+filepath.Base(destination)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	chunk := firstChunk
+	for {
+		_, err = temporaryFile.Write(chunk.Data)
+		if err != nil {
+			temporaryFile.Close()
+			return
+		}
+
+		chunk = &FileChunk{}
+		err = stream.RecvMsg(chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			temporaryFile.Close()
+			return
+		}
+	}
+
+	err = temporaryFile.Close()
+	if err != nil {
+		return
+	}
+
+	// Temporary files are only readable by their owner.
+	err = os.Chmod(temporaryFile.Name(), 0o644)
+	if err != nil {
+		return
+	}
+
+	return os.Rename(temporaryFile.Name(), destination)
+}
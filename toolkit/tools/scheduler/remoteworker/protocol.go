@@ -0,0 +1,288 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Package remoteworker implements the protocol used by the scheduler to hand package builds to workers running on
+// other machines.
+//
+// Workers register with the scheduler's coordinator, send heartbeats and poll it for jobs. For each job they download
+// the SRPM and the build dependencies, build the package with their own pkgworker, stream the build log back while
+// building and upload the built RPMs. The jobs of workers that stop sending heartbeats are re-queued for other workers.
+//
+// The protocol runs over gRPC. The messages are plain Go structures encoded as JSON, so no generated code is needed.
+package remoteworker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	serviceName = "azurelinux.remoteworker.BuildCoordinator"
+	codecName   = "json"
+
+	// fileChunkSize is the maximum number of bytes sent in a single file or log chunk.
+	fileChunkSize = 1024 * 1024
+)
+
+// Roots of the files transferred between the scheduler and the workers. Files under the RPM, toolchain and cache roots
+// are placed under the same relative path in the worker's corresponding directory, so the worker's build chroot can
+// use them as repositories.
+const (
+	FileRootRPMs      = "rpms"
+	FileRootToolchain = "toolchain"
+	FileRootCache     = "cache"
+	// FileRootOther is used for files outside of the other roots, like the SRPMs to build.
+	FileRootOther = "other"
+)
+
+// RegisterRequest is sent by a worker to join the build.
+type RegisterRequest struct {
+	Name         string `json:"name"`
+	Architecture string `json:"architecture"`
+}
+
+// RegisterResponse assigns an ID to a newly registered worker.
+type RegisterResponse struct {
+	WorkerID string `json:"workerId"`
+	// HeartbeatInterval is how often the worker must send heartbeats. Workers that miss several heartbeats in a row
+	// are considered lost.
+	HeartbeatInterval time.Duration `json:"heartbeatInterval"`
+}
+
+// HeartbeatRequest tells the coordinator a worker is still alive.
+type HeartbeatRequest struct {
+	WorkerID string `json:"workerId"`
+}
+
+// HeartbeatResponse acknowledges a heartbeat.
+type HeartbeatResponse struct {
+	// Registered is false if the coordinator doesn't know the worker (e.g. it was considered lost), in which case the
+	// worker must register again.
+	Registered bool `json:"registered"`
+}
+
+// JobRequest asks the coordinator for the next job.
+type JobRequest struct {
+	WorkerID string `json:"workerId"`
+}
+
+// FileRef references a file on the scheduler's machine.
+type FileRef struct {
+	Root         string `json:"root"`
+	RelativePath string `json:"relativePath"`
+	Size         int64  `json:"size"`
+}
+
+// Job is a package build assigned to a worker. A job with an empty ID means no job was available.
+type Job struct {
+	ID              string        `json:"id"`
+	BasePackageName string        `json:"basePackageName"`
+	SRPM            FileRef       `json:"srpm"`
+	Dependencies    []FileRef     `json:"dependencies"`
+	LogName         string        `json:"logName"`
+	OutArch         string        `json:"outArch"`
+	RunCheck        bool          `json:"runCheck"`
+	Timeout         time.Duration `json:"timeout"`
+}
+
+// DownloadRequest asks for the content of one of a job's files.
+type DownloadRequest struct {
+	WorkerID string  `json:"workerId"`
+	JobID    string  `json:"jobId"`
+	File     FileRef `json:"file"`
+}
+
+// FileChunk is a part of a transferred file.
+type FileChunk struct {
+	WorkerID string `json:"workerId,omitempty"`
+	JobID    string `json:"jobId,omitempty"`
+	// RelativePath is the path of an uploaded RPM, relative to the RPM root. Only set in the first chunk.
+	RelativePath string `json:"relativePath,omitempty"`
+	Data         []byte `json:"data"`
+}
+
+// LogChunk is a part of a job's build log.
+type LogChunk struct {
+	WorkerID string `json:"workerId"`
+	JobID    string `json:"jobId"`
+	Data     []byte `json:"data"`
+}
+
+// JobResult reports the outcome of a job.
+type JobResult struct {
+	WorkerID string `json:"workerId"`
+	JobID    string `json:"jobId"`
+	// BuiltFiles are the paths of the uploaded RPMs, relative to the RPM root.
+	BuiltFiles []string `json:"builtFiles"`
+	// Error is the build error, empty on success.
+	Error string `json:"error,omitempty"`
+}
+
+// Empty is returned by calls without a result.
+type Empty struct{}
+
+// jsonCodec encodes the protocol's messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// coordinatorServer is implemented by the Coordinator.
+type coordinatorServer interface {
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	NextJob(context.Context, *JobRequest) (*Job, error)
+	CompleteJob(context.Context, *JobResult) (*Empty, error)
+	DownloadFile(*DownloadRequest, grpc.ServerStream) error
+	UploadFile(grpc.ServerStream) error
+	StreamLog(grpc.ServerStream) error
+}
+
+func unaryHandler[Request any, Response any](method string, call func(coordinatorServer, context.Context, *Request) (*Response, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, decode func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		request := new(Request)
+		if err := decode(request); err != nil {
+			return nil, err
+		}
+
+		if interceptor == nil {
+			return call(srv.(coordinatorServer), ctx, request)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/" + serviceName + "/" + method,
+		}
+		handler := func(ctx context.Context, request interface{}) (interface{}, error) {
+			return call(srv.(coordinatorServer), ctx, request.(*Request))
+		}
+		return interceptor(ctx, request, info, handler)
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*coordinatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Register", Handler: unaryHandler("Register", coordinatorServer.Register)},
+		{MethodName: "Heartbeat", Handler: unaryHandler("Heartbeat", coordinatorServer.Heartbeat)},
+		{MethodName: "NextJob", Handler: unaryHandler("NextJob", coordinatorServer.NextJob)},
+		{MethodName: "CompleteJob", Handler: unaryHandler("CompleteJob", coordinatorServer.CompleteJob)},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "DownloadFile",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				request := &DownloadRequest{}
+				if err := stream.RecvMsg(request); err != nil {
+					return err
+				}
+				return srv.(coordinatorServer).DownloadFile(request, stream)
+			},
+			ServerStreams: true,
+		},
+		{
+			StreamName: "UploadFile",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(coordinatorServer).UploadFile(stream)
+			},
+			ClientStreams: true,
+		},
+		{
+			StreamName: "StreamLog",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(coordinatorServer).StreamLog(stream)
+			},
+			ClientStreams: true,
+		},
+	},
+}
+
+// coordinatorClient calls the coordinator's methods.
+type coordinatorClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *coordinatorClient) invoke(ctx context.Context, method string, request, response interface{}) error {
+	return c.conn.Invoke(ctx, "/"+serviceName+"/"+method, request, response, grpc.ForceCodec(jsonCodec{}))
+}
+
+func (c *coordinatorClient) newStream(ctx context.Context, streamIndex int) (grpc.ClientStream, error) {
+	desc := &serviceDesc.Streams[streamIndex]
+	return c.conn.NewStream(ctx, desc, "/"+serviceName+"/"+desc.StreamName, grpc.ForceCodec(jsonCodec{}))
+}
+
+func (c *coordinatorClient) Register(ctx context.Context, request *RegisterRequest) (response *RegisterResponse, err error) {
+	response = &RegisterResponse{}
+	err = c.invoke(ctx, "Register", request, response)
+	return
+}
+
+func (c *coordinatorClient) Heartbeat(ctx context.Context, request *HeartbeatRequest) (response *HeartbeatResponse, err error) {
+	response = &HeartbeatResponse{}
+	err = c.invoke(ctx, "Heartbeat", request, response)
+	return
+}
+
+func (c *coordinatorClient) NextJob(ctx context.Context, request *JobRequest) (response *Job, err error) {
+	response = &Job{}
+	err = c.invoke(ctx, "NextJob", request, response)
+	return
+}
+
+func (c *coordinatorClient) CompleteJob(ctx context.Context, request *JobResult) (err error) {
+	return c.invoke(ctx, "CompleteJob", request, &Empty{})
+}
+
+// DownloadFile starts downloading a file, the chunks are read with RecvMsg.
+func (c *coordinatorClient) DownloadFile(ctx context.Context, request *DownloadRequest) (stream grpc.ClientStream, err error) {
+	stream, err = c.newStream(ctx, 0)
+	if err != nil {
+		return
+	}
+
+	err = stream.SendMsg(request)
+	if err != nil {
+		return
+	}
+
+	err = stream.CloseSend()
+	return
+}
+
+// UploadFile starts uploading a file, the chunks are sent with SendMsg.
+func (c *coordinatorClient) UploadFile(ctx context.Context) (grpc.ClientStream, error) {
+	return c.newStream(ctx, 1)
+}
+
+// StreamLog starts streaming a build log, the chunks are sent with SendMsg.
+func (c *coordinatorClient) StreamLog(ctx context.Context) (grpc.ClientStream, error) {
+	return c.newStream(ctx, 2)
+}
+
+// closeUpload finishes a client stream and waits for the coordinator to acknowledge it.
+func closeUpload(stream grpc.ClientStream) (err error) {
+	err = stream.CloseSend()
+	if err != nil {
+		return
+	}
+
+	return stream.RecvMsg(&Empty{})
+}
@@ -0,0 +1,273 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package remoteworker
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// fakeBuilder "builds" a package by writing a log and an RPM containing the SRPM's content.
+type fakeBuilder struct {
+	rpmDir       string
+	logDir       string
+	dependencies []string
+}
+
+func (b *fakeBuilder) BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies, expectedFiles []string, allowableRuntime time.Duration) (builtFiles []string, logFile string, err error) {
+	b.dependencies = dependencies
+
+	srpmContent, err := os.ReadFile(inputFile)
+	if err != nil {
+		return
+	}
+
+	logFile = filepath.Join(b.logDir, logName)
+	err = os.WriteFile(logFile, []byte("building "+basePackageName+"\n"), 0o644)
+	if err != nil {
+		return
+	}
+
+	builtFile := filepath.Join(b.rpmDir, outArch, basePackageName+"-1.0.rpm")
+	err = os.MkdirAll(filepath.Dir(builtFile), 0o755)
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(builtFile, srpmContent, 0o644)
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(builtFile+provenance.FileSuffix, []byte("attestation\n"), 0o644)
+	return []string{builtFile}, logFile, err
+}
+
+type testSetup struct {
+	coordinator    *Coordinator
+	coordinatorDir string
+	listener       *bufconn.Listener
+}
+
+func newTestSetup(t *testing.T, heartbeatInterval time.Duration) *testSetup {
+	coordinatorDir := t.TempDir()
+	for _, dir := range []string{"rpms", "toolchain", "logs", "srpms"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(coordinatorDir, dir), 0o755))
+	}
+
+	coordinator := NewCoordinator(CoordinatorConfig{
+		RPMDir:            filepath.Join(coordinatorDir, "rpms"),
+		ToolchainDir:      filepath.Join(coordinatorDir, "toolchain"),
+		LogDir:            filepath.Join(coordinatorDir, "logs"),
+		HeartbeatInterval: heartbeatInterval,
+		PollTimeout:       100 * time.Millisecond,
+	})
+
+	listener := bufconn.Listen(1024 * 1024)
+	go coordinator.Serve(listener)
+	t.Cleanup(coordinator.Stop)
+
+	return &testSetup{
+		coordinator:    coordinator,
+		coordinatorDir: coordinatorDir,
+		listener:       listener,
+	}
+}
+
+func (s *testSetup) dial(t *testing.T) *grpc.ClientConn {
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func (s *testSetup) startWorker(t *testing.T) *fakeBuilder {
+	workerDir := t.TempDir()
+	builder := &fakeBuilder{
+		rpmDir: filepath.Join(workerDir, "rpms"),
+		logDir: filepath.Join(workerDir, "logs"),
+	}
+	require.NoError(t, os.MkdirAll(builder.logDir, 0o755))
+
+	worker := NewWorker(s.dial(t), builder, WorkerConfig{
+		Name:          "test",
+		RPMDir:        builder.rpmDir,
+		ToolchainDir:  filepath.Join(workerDir, "toolchain"),
+		CacheDir:      filepath.Join(workerDir, "cache"),
+		InputDir:      filepath.Join(workerDir, "inputs"),
+		LogDir:        builder.logDir,
+		RetryInterval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go worker.Run(ctx)
+
+	return builder
+}
+
+func (s *testSetup) writeFile(t *testing.T, relativePath, content string) string {
+	path := filepath.Join(s.coordinatorDir, relativePath)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func (s *testSetup) build(t *testing.T) (builtFiles []string, logFile string, err error) {
+	return s.buildExpecting(t, filepath.Join(s.coordinatorDir, "rpms", "x86_64", "foo-1.0.rpm"))
+}
+
+func (s *testSetup) buildExpecting(t *testing.T, expectedFiles ...string) (builtFiles []string, logFile string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.coordinator.Build(ctx, BuildJob{
+		BasePackageName: "foo",
+		SRPMPath:        s.writeFile(t, "srpms/foo-1.0.src.rpm", "foo sources"),
+		LogName:         "foo-1.0.src.rpm.log",
+		OutArch:         "x86_64",
+		Dependencies:    []string{s.writeFile(t, "toolchain/x86_64/gcc.rpm", "gcc")},
+		ExpectedFiles:   expectedFiles,
+		Timeout:         time.Minute,
+	})
+}
+
+func TestRemoteBuild(t *testing.T) {
+	setup := newTestSetup(t, time.Second)
+	builder := setup.startWorker(t)
+
+	builtFiles, logFile, err := setup.build(t)
+	require.NoError(t, err)
+
+	expectedRPM := filepath.Join(setup.coordinatorDir, "rpms", "x86_64", "foo-1.0.rpm")
+	assert.Equal(t, []string{expectedRPM}, builtFiles)
+	assert.FileExists(t, expectedRPM)
+	assert.FileExists(t, expectedRPM+provenance.FileSuffix)
+
+	content, err := os.ReadFile(expectedRPM)
+	require.NoError(t, err)
+	assert.Equal(t, "foo sources", string(content))
+
+	logContent, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, "building foo\n", string(logContent))
+
+	// Dependencies keep their path relative to their root directory.
+	require.Len(t, builder.dependencies, 1)
+	assert.Equal(t, filepath.Join(filepath.Dir(builder.rpmDir), "toolchain", "x86_64", "gcc.rpm"), builder.dependencies[0])
+}
+
+func TestRemoteBuildRequeuedOnWorkerLoss(t *testing.T) {
+	setup := newTestSetup(t, 50*time.Millisecond)
+
+	// A worker that takes the job and then disappears without sending heartbeats.
+	client := &coordinatorClient{conn: setup.dial(t)}
+	registration, err := client.Register(context.Background(), &RegisterRequest{Name: "lost"})
+	require.NoError(t, err)
+
+	jobTaken := make(chan string, 1)
+	go func() {
+		for {
+			job, err := client.NextJob(context.Background(), &JobRequest{WorkerID: registration.WorkerID})
+			if err != nil {
+				jobTaken <- ""
+				return
+			}
+
+			if job.ID != "" {
+				jobTaken <- job.ID
+				return
+			}
+		}
+	}()
+
+	type buildOutcome struct {
+		builtFiles []string
+		err        error
+	}
+	outcome := make(chan buildOutcome, 1)
+	go func() {
+		builtFiles, _, err := setup.build(t)
+		outcome <- buildOutcome{builtFiles, err}
+	}()
+
+	lostJobID := <-jobTaken
+	require.NotEmpty(t, lostJobID)
+
+	// The job is handed to a working worker once the lost one misses its heartbeats.
+	setup.startWorker(t)
+
+	result := <-outcome
+	require.NoError(t, result.err)
+	assert.Len(t, result.builtFiles, 1)
+
+	// The lost worker can no longer report results.
+	err = client.CompleteJob(context.Background(), &JobResult{WorkerID: registration.WorkerID, JobID: lostJobID})
+	assert.Error(t, err)
+}
+
+func TestRemoteBuildUnexpectedRPM(t *testing.T) {
+	setup := newTestSetup(t, time.Second)
+	setup.startWorker(t)
+
+	_, _, err := setup.buildExpecting(t, filepath.Join(setup.coordinatorDir, "rpms", "x86_64", "bar-1.0.rpm"))
+	assert.ErrorContains(t, err, "isn't an RPM expected")
+	assert.NoFileExists(t, filepath.Join(setup.coordinatorDir, "rpms", "x86_64", "foo-1.0.rpm"))
+}
+
+func TestCoordinatorRejectsMissingToken(t *testing.T) {
+	coordinator := NewCoordinator(CoordinatorConfig{Token: "secret"})
+	listener := bufconn.Listen(1024 * 1024)
+	go coordinator.Serve(listener)
+	t.Cleanup(coordinator.Stop)
+
+	setup := &testSetup{coordinator: coordinator, listener: listener}
+	client := &coordinatorClient{conn: setup.dial(t)}
+
+	_, err := client.Register(context.Background(), &RegisterRequest{Name: "intruder"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestCheckToken(t *testing.T) {
+	validContext := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer secret"))
+	assert.NoError(t, checkToken(validContext, "secret"))
+
+	invalidContext := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer guess"))
+	assert.Error(t, checkToken(invalidContext, "secret"))
+
+	assert.Error(t, checkToken(context.Background(), "secret"))
+}
+
+func TestRegisterArchitectureMismatch(t *testing.T) {
+	coordinator := NewCoordinator(CoordinatorConfig{Architecture: "x86_64"})
+
+	_, err := coordinator.Register(context.Background(), &RegisterRequest{Name: "arm", Architecture: "aarch64"})
+	assert.ErrorContains(t, err, "doesn't match the build architecture")
+}
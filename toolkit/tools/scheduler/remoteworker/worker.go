@@ -0,0 +1,445 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package remoteworker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/pkg/provenance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultRetryInterval is how long a worker waits before trying to reach the coordinator again.
+	DefaultRetryInterval = 10 * time.Second
+
+	// logStreamInterval is how often new build log lines are sent to the coordinator.
+	logStreamInterval = time.Second
+)
+
+// PackageBuilder builds packages on the worker's machine. The scheduler's build agents implement it.
+type PackageBuilder interface {
+	BuildPackage(basePackageName, inputFile, logName, outArch string, runCheck bool, dependencies, expectedFiles []string, allowableRuntime time.Duration) ([]string, string, error)
+}
+
+// WorkerConfig configures a Worker. All directories are on the worker's machine.
+type WorkerConfig struct {
+	Name         string
+	Architecture string
+
+	// RPMDir, ToolchainDir and CacheDir receive the build dependencies from the coordinator's matching directories.
+	// The builder must save the built RPMs under RPMDir.
+	RPMDir       string
+	ToolchainDir string
+	CacheDir     string
+	// InputDir receives the job's other files, like the SRPM to build.
+	InputDir string
+	// LogDir is the directory the builder writes the build logs to.
+	LogDir string
+
+	RetryInterval time.Duration
+}
+
+// Worker builds the jobs handed out by a coordinator. It is the remote machine's side of the protocol.
+type Worker struct {
+	config  WorkerConfig
+	builder PackageBuilder
+	client  *coordinatorClient
+}
+
+// NewWorker creates a worker using a connection to the coordinator.
+func NewWorker(conn *grpc.ClientConn, builder PackageBuilder, config WorkerConfig) *Worker {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultRetryInterval
+	}
+
+	return &Worker{
+		config:  config,
+		builder: builder,
+		client:  &coordinatorClient{conn: conn},
+	}
+}
+
+// Run registers with the coordinator and builds the jobs it hands out until the context is cancelled. Whenever the
+// coordinator can't be reached or no longer knows the worker, the worker registers again.
+func (w *Worker) Run(ctx context.Context) (err error) {
+	for {
+		err = w.runSession(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		logger.Log.Warnf("Lost the build coordinator, registering again in %s:\n%s", w.config.RetryInterval, err)
+
+		select {
+		case <-time.After(w.config.RetryInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// runSession registers the worker and builds jobs until the registration is lost.
+func (w *Worker) runSession(ctx context.Context) (err error) {
+	registration, err := w.client.Register(ctx, &RegisterRequest{
+		Name:         w.config.Name,
+		Architecture: w.config.Architecture,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register with the build coordinator:\n%w", err)
+	}
+
+	workerID := registration.WorkerID
+	logger.Log.Infof("Registered with the build coordinator as (%s)", workerID)
+
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+
+	heartbeatResult := make(chan error, 1)
+	go func() {
+		heartbeatResult <- w.sendHeartbeats(sessionCtx, workerID, registration.HeartbeatInterval)
+		cancelSession()
+	}()
+
+	for sessionCtx.Err() == nil {
+		var job *Job
+		job, err = w.client.NextJob(sessionCtx, &JobRequest{WorkerID: workerID})
+		if err != nil {
+			break
+		}
+
+		if job.ID == "" {
+			continue
+		}
+
+		err = w.runJob(sessionCtx, workerID, job)
+		if err != nil {
+			break
+		}
+	}
+
+	cancelSession()
+	if heartbeatErr := <-heartbeatResult; heartbeatErr != nil {
+		err = heartbeatErr
+	}
+
+	return
+}
+
+// sendHeartbeats sends heartbeats until the context is cancelled or the coordinator no longer knows the worker.
+func (w *Worker) sendHeartbeats(ctx context.Context, workerID string, interval time.Duration) (err error) {
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+
+		response, heartbeatErr := w.client.Heartbeat(ctx, &HeartbeatRequest{WorkerID: workerID})
+		if heartbeatErr != nil {
+			// The coordinator may be temporarily unreachable, it will tell the worker to register again if needed.
+			logger.Log.Warnf("Failed to send heartbeat to the build coordinator:\n%s", heartbeatErr)
+			continue
+		}
+
+		if !response.Registered {
+			return fmt.Errorf("the build coordinator no longer knows worker (%s)", workerID)
+		}
+	}
+}
+
+// runJob builds a job and reports its result. Build failures are reported to the coordinator, only failures to talk
+// to the coordinator are returned.
+func (w *Worker) runJob(ctx context.Context, workerID string, job *Job) (err error) {
+	logger.Log.Infof("Building (%s)", job.SRPM.RelativePath)
+
+	result := &JobResult{
+		WorkerID: workerID,
+		JobID:    job.ID,
+	}
+
+	builtFiles, buildErr := w.buildJob(ctx, workerID, job)
+	if buildErr != nil {
+		result.Error = buildErr.Error()
+	}
+
+	// The RPMs of a test build are thrown away, the coordinator only needs its log.
+	if job.RunCheck {
+		builtFiles = nil
+	}
+
+	for _, builtFile := range builtFiles {
+		relativePath, relErr := filepath.Rel(w.config.RPMDir, builtFile)
+		if relErr != nil || !filepath.IsLocal(relativePath) {
+			result.Error = fmt.Sprintf("built RPM (%s) isn't in the RPM directory (%s)", builtFile, w.config.RPMDir)
+			result.BuiltFiles = nil
+			break
+		}
+
+		err = w.upload(ctx, workerID, job.ID, builtFile, relativePath)
+		if status.Code(err) == codes.PermissionDenied {
+			// The coordinator refuses RPMs it doesn't expect from the job, which fails the build instead of retrying it.
+			result.Error = fmt.Sprintf("the build coordinator rejected (%s): %s", relativePath, status.Convert(err).Message())
+			result.BuiltFiles = nil
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upload (%s):\n%w", builtFile, err)
+		}
+		result.BuiltFiles = append(result.BuiltFiles, relativePath)
+
+		// Send the RPM's provenance attestation along with it, if the build wrote one.
+		sidecar := builtFile + provenance.FileSuffix
+		if _, statErr := os.Stat(sidecar); statErr == nil {
+			err = w.upload(ctx, workerID, job.ID, sidecar, relativePath+provenance.FileSuffix)
+			if err != nil {
+				return fmt.Errorf("failed to upload (%s):\n%w", sidecar, err)
+			}
+		}
+	}
+
+	err = w.client.CompleteJob(ctx, result)
+	if err != nil {
+		return fmt.Errorf("failed to report the result of (%s):\n%w", job.SRPM.RelativePath, err)
+	}
+
+	if result.Error != "" {
+		logger.Log.Warnf("Failed to build (%s):\n%s", job.SRPM.RelativePath, result.Error)
+	} else {
+		logger.Log.Infof("Built (%s)", job.SRPM.RelativePath)
+	}
+
+	return
+}
+
+// buildJob downloads a job's files and builds it, streaming the build log to the coordinator.
+func (w *Worker) buildJob(ctx context.Context, workerID string, job *Job) (builtFiles []string, err error) {
+	srpmPath, err := w.download(ctx, workerID, job.ID, job.SRPM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download (%s):\n%w", job.SRPM.RelativePath, err)
+	}
+
+	dependencies := []string{}
+	for _, dependency := range job.Dependencies {
+		var dependencyPath string
+		dependencyPath, err = w.download(ctx, workerID, job.ID, dependency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download (%s):\n%w", dependency.RelativePath, err)
+		}
+		dependencies = append(dependencies, dependencyPath)
+	}
+
+	logFile := filepath.Join(w.config.LogDir, job.LogName)
+	err = os.RemoveAll(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove old build log (%s):\n%w", logFile, err)
+	}
+
+	stopLogStream := make(chan struct{})
+	logStreamResult := make(chan error, 1)
+	go func() {
+		logStreamResult <- w.streamLog(ctx, workerID, job.ID, logFile, stopLogStream)
+	}()
+
+	builtFiles, _, err = w.builder.BuildPackage(job.BasePackageName, srpmPath, job.LogName, job.OutArch, job.RunCheck,
+		dependencies, nil, job.Timeout)
+
+	close(stopLogStream)
+	if logErr := <-logStreamResult; logErr != nil {
+		logger.Log.Warnf("Failed to stream the build log of (%s):\n%s", job.SRPM.RelativePath, logErr)
+	}
+
+	return
+}
+
+// localPath returns where a file from the coordinator is stored on the worker.
+func (w *Worker) localPath(ref FileRef) (path string, err error) {
+	if !filepath.IsLocal(ref.RelativePath) {
+		return "", fmt.Errorf("invalid file path (%s)", ref.RelativePath)
+	}
+
+	var dir string
+	switch ref.Root {
+	case FileRootRPMs:
+		dir = w.config.RPMDir
+	case FileRootToolchain:
+		dir = w.config.ToolchainDir
+	case FileRootCache:
+		dir = w.config.CacheDir
+	case FileRootOther:
+		dir = w.config.InputDir
+	default:
+		return "", fmt.Errorf("unknown file root (%s)", ref.Root)
+	}
+
+	return filepath.Join(dir, ref.RelativePath), nil
+}
+
+// download fetches one of a job's files, unless an RPM of the same size is already present.
+func (w *Worker) download(ctx context.Context, workerID, jobID string, ref FileRef) (path string, err error) {
+	path, err = w.localPath(ref)
+	if err != nil {
+		return
+	}
+
+	if ref.Root != FileRootOther {
+		info, statErr := os.Stat(path)
+		if statErr == nil && info.Size() == ref.Size {
+			return
+		}
+	}
+
+	stream, err := w.client.DownloadFile(ctx, &DownloadRequest{
+		WorkerID: workerID,
+		JobID:    jobID,
+		File:     ref,
+	})
+	if err != nil {
+		return
+	}
+
+	err = receiveFile(stream, &FileChunk{}, path)
+	return
+}
+
+// upload sends a built RPM to the coordinator.
+func (w *Worker) upload(ctx context.Context, workerID, jobID, path, relativePath string) (err error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	stream, err := w.client.UploadFile(ctx)
+	if err != nil {
+		return
+	}
+
+	chunk := &FileChunk{
+		WorkerID:     workerID,
+		JobID:        jobID,
+		RelativePath: relativePath,
+	}
+
+	buffer := make([]byte, fileChunkSize)
+	for {
+		read, readErr := source.Read(buffer)
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+
+		// Always send the first chunk, even for empty files, as it identifies the upload.
+		if read > 0 || chunk != nil {
+			if chunk == nil {
+				chunk = &FileChunk{}
+			}
+			chunk.Data = buffer[:read]
+
+			err = stream.SendMsg(chunk)
+			if err == io.EOF {
+				// The coordinator ended the upload early, its status tells why.
+				return closeUpload(stream)
+			}
+			if err != nil {
+				return
+			}
+			chunk = nil
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	return closeUpload(stream)
+}
+
+// streamLog sends the build log to the coordinator as it grows, until stop is closed.
+func (w *Worker) streamLog(ctx context.Context, workerID, jobID, logFile string, stop <-chan struct{}) (err error) {
+	stream, err := w.client.StreamLog(ctx)
+	if err != nil {
+		return
+	}
+
+	// The first chunk identifies the log, even if the build didn't write anything yet.
+	err = stream.SendMsg(&LogChunk{WorkerID: workerID, JobID: jobID})
+	if err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(logStreamInterval)
+	defer ticker.Stop()
+
+	var offset int64
+	for {
+		stopping := false
+		select {
+		case <-ticker.C:
+		case <-stop:
+			stopping = true
+		}
+
+		offset, err = sendNewLogData(stream, workerID, jobID, logFile, offset)
+		if err != nil {
+			return
+		}
+
+		if stopping {
+			return closeUpload(stream)
+		}
+	}
+}
+
+// sendNewLogData sends the part of the log file past offset. Returns the new offset.
+func sendNewLogData(stream grpc.ClientStream, workerID, jobID, logFile string, offset int64) (newOffset int64, err error) {
+	newOffset = offset
+
+	source, err := os.Open(logFile)
+	if os.IsNotExist(err) {
+		return newOffset, nil
+	}
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	_, err = source.Seek(offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	buffer := make([]byte, fileChunkSize)
+	for {
+		read, readErr := source.Read(buffer)
+		if read > 0 {
+			err = stream.SendMsg(&LogChunk{WorkerID: workerID, JobID: jobID, Data: buffer[:read]})
+			if err != nil {
+				return
+			}
+			newOffset += int64(read)
+		}
+
+		if readErr == io.EOF {
+			return newOffset, nil
+		}
+		if readErr != nil {
+			return newOffset, readErr
+		}
+	}
+}
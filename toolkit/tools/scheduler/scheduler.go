@@ -14,14 +14,19 @@ import (
 	"time"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/ccachemanager"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/cgroup"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/netisolation"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ociimage"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/licensecheck"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/buildagents"
+	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/dashboard"
 	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/schedulerutils"
 	"github.com/sirupsen/logrus"
 
@@ -61,7 +66,8 @@ var (
 
 	outputCSVFile    = app.Flag("output-build-state-csv-file", "Path to save the CSV file.").Required().String()
 	workDir          = app.Flag("work-dir", "The directory to create the build folder").Required().String()
-	workerTar        = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz").Required().ExistingFile()
+	workerTar        = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz. Mutually exclusive with --worker-image.").ExistingFile()
+	workerImage      = app.Flag("worker-image", "OCI image layout reference ('<layout-dir>[:<tag>]') of the worker chroot to use instead of --worker-tar.").String()
 	repoFile         = app.Flag("repo-file", "Full path to local.repo").Required().ExistingFile()
 	rpmDir           = app.Flag("rpm-dir", "The directory to use as the local repo and to submit RPM packages to").Required().ExistingDir()
 	toolchainDirPath = app.Flag("toolchain-rpms-dir", "Directory that contains already built toolchain RPMs. Should contain top level directories for architecture.").Required().ExistingDir()
@@ -79,6 +85,8 @@ var (
 	releaseVersionMacrosFile   = app.Flag("versions-macro-file", "File containing release and version macros for all SPECS to use while building.").ExistingFile()
 	buildAttempts              = app.Flag("build-attempts", "Sets the number of times to try building a package.").Default(defaultBuildAttempts).Int()
 	checkAttempts              = app.Flag("check-attempts", "Sets the minimum number of times to test a package if the tests fail.").Default(defaultCheckAttempts).Int()
+	transientRetries           = app.Flag("transient-retries", "Extra attempts given to builds and tests failing with a transient error (network fetch or chroot setup failures, classified from the build log).").Default("0").Int()
+	transientRetryBackoff      = app.Flag("transient-retry-backoff", "Delay before the first retry of a transient failure, doubled for each further retry.").Default("30s").Duration()
 	extraLayers                = app.Flag("extra-layers", "Sets the number of additional layers in the graph beyond the goal packages to buid.").Default(defaultExtraLayers).Int()
 	maxCascadingRebuilds       = app.Flag("max-cascading-rebuilds", "Sets the maximum number of cascading dependency rebuilds caused by package being rebuilt (leave unset for unbounded).").Default(defaultFreshness).Uint()
 	noCleanup                  = app.Flag("no-cleanup", "Whether or not to delete the chroot folder after the build is done").Bool()
@@ -91,12 +99,32 @@ var (
 	ccacheConfig               = app.Flag("ccache-config", "The ccache configuration file path.").String()
 	allowToolchainRebuilds     = app.Flag("allow-toolchain-rebuilds", "Allow toolchain packages to rebuild without causing an error.").Bool()
 	maxCPU                     = app.Flag("max-cpu", "Max number of CPUs used for package building").Default("").String()
+	chrootMemoryMax            = app.Flag("chroot-memory-max", "Maximum memory that each command run in a build chroot (e.g. a scriptlet or rpmbuild), including its child processes, may use (e.g. 8GB).").Bytes()
+	chrootCPUWeight            = app.Flag("chroot-cpu-weight", "Relative share of CPU time (1 to 10000) of each command run in a build chroot.").Uint64()
+	chrootPidsMax              = app.Flag("chroot-pids-max", "Maximum number of processes and threads of each command run in a build chroot.").Uint64()
+	chrootCgroupParent         = app.Flag("chroot-cgroup-parent", "cgroup v2 directory to create the cgroups of the commands run in the build chroots in. By default, the scheduler's own cgroup is used.").String()
 	timeout                    = app.Flag("timeout", "Max duration for any individual package build/test").Default(defaultTimeout).Duration()
-
-	validBuildAgentFlags = []string{buildagents.TestAgentFlag, buildagents.ChrootAgentFlag}
+	networkIsolation           = app.Flag("network-isolation", "Network access of the package builds. 'isolated' builds packages without network access, except for the hosts in --network-allowlist.").Default(string(netisolation.PolicyHost)).Enum(netisolation.ValidPolicies()...)
+	networkAllowlist           = app.Flag("network-allowlist", "Host isolated builds may download from through a proxy. Prefix with '.' to also allow the host's subdomains.").Strings()
+	provenance                 = app.Flag("provenance", "Write a SLSA provenance attestation next to each built RPM.").Bool()
+	provenanceKey              = app.Flag("provenance-key", "PEM encoded private key to sign the provenance attestations with. The attestations are unsigned if not set.").ExistingFile()
+	provenanceBuilderID        = app.Flag("provenance-builder-id", "URI identifying the builder in the provenance attestations.").String()
+	rootless                   = app.Flag("rootless", "Whether to run the scheduler, and so the build agents and their chroots, within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
+
+	validBuildAgentFlags = []string{buildagents.TestAgentFlag, buildagents.ChrootAgentFlag, buildagents.RemoteAgentFlag}
 	buildAgent           = app.Flag("build-agent", "Type of build agent to build packages with.").PlaceHolder(exe.PlaceHolderize(validBuildAgentFlags)).Required().Enum(validBuildAgentFlags...)
 	buildAgentProgram    = app.Flag("build-agent-program", "Path to the build agent that will be invoked to build packages.").String()
+	remoteListenAddress  = app.Flag("remote-listen-address", "Address ('<host>:<port>') to accept remote build workers on when using the 'remote-agent' build agent.").String()
+	remoteTLSCert        = app.Flag("remote-tls-cert", "TLS certificate for the remote build workers' connections, required by the 'remote-agent' build agent.").ExistingFile()
+	remoteTLSKey         = app.Flag("remote-tls-key", "TLS key for the remote build workers' connections, required with --remote-tls-cert.").ExistingFile()
+	remoteTLSClientCA    = app.Flag("remote-tls-client-ca", "CA certificate the remote build workers' client certificates must be signed by. The 'remote-agent' build agent requires this flag, --remote-token-file, or both.").ExistingFile()
+	remoteTokenFile      = app.Flag("remote-token-file", "File containing the token the remote build workers must send.").ExistingFile()
 	workers              = app.Flag("workers", "Number of concurrent build agents to spawn. If set to 0, will automatically set to the logical CPU count.").Default(defaultWorkerCount).Int()
+	schedulingPolicy     = app.Flag("scheduling-policy", "Order in which ready packages are built: 'critical-path' builds the packages blocking the longest chains of builds first, 'fifo' builds them in the order they became ready.").Default(schedulerutils.SchedulingPolicyCriticalPath).Enum(schedulerutils.ValidSchedulingPolicies()...)
+	resourceClassesFile  = app.Flag("resource-classes-file", "Optional JSON file assigning memory and CPU weights to packages. Concurrent builds are then limited by the memory and CPU budgets in addition to the number of workers.").ExistingFile()
+	memoryBudget         = app.Flag("memory-budget", "Memory (in MB) the concurrent builds may use together when using --resource-classes-file. If set to 0, will automatically set to the host's memory.").Default("0").Int()
+	cpuBudget            = app.Flag("cpu-budget", "CPUs the concurrent builds may use together when using --resource-classes-file. If set to 0, will automatically set to the logical CPU count.").Default("0").Int()
+	statusAddress        = app.Flag("status-address", "Optional address ('<host>:<port>') to serve the live build dashboard on. Use the 'buildstatus' tool for a terminal view.").String()
 
 	licenseCheckMode     = app.Flag("license-check-mode", "Do additional validation of licenses after the build").Default(string(licensecheck.LicenseCheckModeDefault)).Enum(licensecheck.ValidLicenseCheckModeStrings()...)
 	licenseNameFile      = app.Flag("license-check-name-file", "File containing license names to check for.").ExistingFile()
@@ -123,6 +151,12 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	// The build agents are started within the scheduler's user namespace, where they already run as root.
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
@@ -138,6 +172,29 @@ func main() {
 		logger.Log.Fatalf("Value in --build-attempts must be greater than zero. Found %d.", *buildAttempts)
 	}
 
+	if *transientRetries < 0 {
+		logger.Log.Fatalf("Value in --transient-retries must not be negative. Found %d.", *transientRetries)
+	}
+
+	resourceAllocator, err := newResourceAllocator(*resourceClassesFile, *memoryBudget, *cpuBudget)
+	if err != nil {
+		logger.Log.Fatalf("Failed to set up the build resource classes:\n%s", err)
+	}
+
+	var statusTracker *dashboard.Tracker
+	if *statusAddress != "" {
+		statusTracker = dashboard.NewTracker(*workers)
+		err = dashboard.StartServer(*statusAddress, statusTracker)
+		if err != nil {
+			logger.Log.Fatalf("Failed to start the build dashboard:\n%s", err)
+		}
+	}
+
+	workerSource, err := resolveWorkerSource(*workerTar, *workerImage)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+
 	var licenseCheckerConfig = schedulerutils.PackageLicenseCheckerConfig{Mode: licensecheck.LicenseCheckModeNone}
 	if *licenseCheckMode != string(licensecheck.LicenseCheckModeNone) {
 		if *licenseNameFile == "" {
@@ -150,7 +207,7 @@ func main() {
 		licenseCheckerWorkDir := filepath.Join(*workDir, "license-checker")
 		licenseCheckerConfig = schedulerutils.PackageLicenseCheckerConfig{
 			BuildDirPath:      licenseCheckerWorkDir,
-			WorkerTarPath:     *workerTar,
+			WorkerTarPath:     workerSource,
 			NameFilePath:      *licenseNameFile,
 			ExceptionFilePath: *licenseExceptionFile,
 			DistTag:           *distTag,
@@ -190,6 +247,7 @@ func main() {
 		SrpmDir:      *srpmDir,
 		WorkDir:      *workDir,
 		WorkerTar:    *workerTar,
+		WorkerImage:  *workerImage,
 
 		DistTag:              *distTag,
 		DistroReleaseVersion: *distroReleaseVersion,
@@ -204,8 +262,30 @@ func main() {
 		MaxCpu:       *maxCPU,
 		Timeout:      *timeout,
 
-		LogDir:   *buildLogsDir,
-		LogLevel: *logFlags.LogLevel,
+		ChrootLimits: cgroup.Limits{
+			MemoryMax: uint64(*chrootMemoryMax),
+			CPUWeight: *chrootCPUWeight,
+			PidsMax:   *chrootPidsMax,
+		},
+		ChrootCgroupParent: *chrootCgroupParent,
+
+		NetworkIsolation: *networkIsolation,
+		NetworkAllowlist: *networkAllowlist,
+
+		Provenance:          *provenance,
+		ProvenanceKey:       *provenanceKey,
+		ProvenanceBuilderID: *provenanceBuilderID,
+
+		LogDir:    *buildLogsDir,
+		LogLevel:  *logFlags.LogLevel,
+		LogFormat: *logFlags.LogFormat,
+
+		RemoteListenAddress: *remoteListenAddress,
+		RemoteTLSCertFile:   *remoteTLSCert,
+		RemoteTLSKeyFile:    *remoteTLSKey,
+
+		RemoteTLSClientCAFile: *remoteTLSClientCA,
+		RemoteTokenFile:       *remoteTokenFile,
 	}
 
 	agent, err := buildagents.BuildAgentFactory(*buildAgent)
@@ -226,7 +306,13 @@ func main() {
 	signal.Notify(signals, unix.SIGINT, unix.SIGTERM)
 	go cancelBuildsOnSignal(signals, agent)
 
-	err = buildGraph(*inputGraphFile, *outputGraphFile, agent, licenseCheckerConfig, *workers, *buildAttempts, *checkAttempts, *extraLayers, *maxCascadingRebuilds, *stopOnFailure, !*noCache, finalPackagesToBuild, packagesToRebuild, packagesToIgnore, finalTestsToRun, testsToRerun, ignoredTests, toolchainPackages, *optimizeWithCachedImplicit, *allowToolchainRebuilds)
+	buildStartTime := time.Now()
+	err = buildGraph(*inputGraphFile, *outputGraphFile, agent, licenseCheckerConfig, *workers, *schedulingPolicy, resourceAllocator, statusTracker, *buildAttempts, *checkAttempts, schedulerutils.RetryPolicy{TransientRetries: *transientRetries, TransientBackoff: *transientRetryBackoff}, *extraLayers, *maxCascadingRebuilds, *stopOnFailure, !*noCache, finalPackagesToBuild, packagesToRebuild, packagesToIgnore, finalTestsToRun, testsToRerun, ignoredTests, toolchainPackages, *optimizeWithCachedImplicit, *allowToolchainRebuilds)
+	// Remote workers keep the statistics of their ccache folders on their own machines.
+	if *useCcache && *buildAgent != buildagents.RemoteAgentFlag {
+		printCCacheSummary(buildStartTime)
+	}
+
 	if err != nil {
 		logger.Log.Fatalf("Unable to build package graph.\nFor details see the build summary section above and the build log '%s'.\nError: %s.", *logFlags.LogFile, err)
 	}
@@ -259,6 +345,24 @@ func main() {
 	}
 }
 
+// printCCacheSummary prints the ccache statistics of the packages built since the given time.
+func printCCacheSummary(since time.Time) {
+	const maxPackages = 10
+
+	ccacheManager, err := ccachemanager.CreateManager(*ccacheDir, *ccacheConfig)
+	if err == nil {
+		var allStats []ccachemanager.CCacheStats
+		allStats, err = ccacheManager.LoadStats(since)
+		if err == nil {
+			ccachemanager.PrintStatsSummary(allStats, maxPackages)
+		}
+	}
+
+	if err != nil {
+		logger.Log.Warnf("Failed to summarize the ccache statistics:\n%s", err)
+	}
+}
+
 // cancelOutstandingBuilds stops any builds that are currently running.
 func cancelOutstandingBuilds(agent buildagents.BuildAgent) {
 	err := agent.Close()
@@ -281,7 +385,7 @@ func cancelBuildsOnSignal(signals chan os.Signal, agent buildagents.BuildAgent)
 
 // buildGraph builds all packages in the dependency graph requested.
 // It will save the resulting graph to outputFile.
-func buildGraph(inputFile, outputFile string, agent buildagents.BuildAgent, licenseCheckerConfig schedulerutils.PackageLicenseCheckerConfig, workers, buildAttempts, checkAttempts, extraLayers int, maxCascadingRebuilds uint, stopOnFailure, canUseCache bool, packagesToBuild, packagesToRebuild, ignoredPackages, testsToRun, testsToRerun, ignoredTests []*pkgjson.PackageVer, toolchainPackages []string, optimizeWithCachedImplicit bool, allowToolchainRebuilds bool) (err error) {
+func buildGraph(inputFile, outputFile string, agent buildagents.BuildAgent, licenseCheckerConfig schedulerutils.PackageLicenseCheckerConfig, workers int, schedulingPolicy string, resourceAllocator *schedulerutils.ResourceAllocator, statusTracker *dashboard.Tracker, buildAttempts, checkAttempts int, retryPolicy schedulerutils.RetryPolicy, extraLayers int, maxCascadingRebuilds uint, stopOnFailure, canUseCache bool, packagesToBuild, packagesToRebuild, ignoredPackages, testsToRun, testsToRerun, ignoredTests []*pkgjson.PackageVer, toolchainPackages []string, optimizeWithCachedImplicit bool, allowToolchainRebuilds bool) (err error) {
 	// graphMutex guards pkgGraph from concurrent reads and writes during build.
 	var graphMutex sync.RWMutex
 
@@ -297,11 +401,11 @@ func buildGraph(inputFile, outputFile string, agent buildagents.BuildAgent, lice
 	// Setup and start the worker pool and scheduler routine.
 	numberOfNodes := pkgGraph.Nodes().Len()
 
-	channels := startWorkerPool(agent, workers, buildAttempts, checkAttempts, numberOfNodes, &graphMutex, ignoredPackages, ignoredTests)
-	logger.Log.Infof("Building %d nodes with %d workers", numberOfNodes, workers)
+	channels := startWorkerPool(agent, workers, buildAttempts, checkAttempts, retryPolicy, numberOfNodes, &graphMutex, ignoredPackages, ignoredTests)
+	logger.Log.Infof("Building %d nodes with %d workers using the '%s' scheduling policy", numberOfNodes, workers, schedulingPolicy)
 
 	// After this call pkgGraph will be given to multiple routines and accessing it requires acquiring the mutex.
-	builtGraph, err := buildAllNodes(stopOnFailure, canUseCache, packagesToRebuild, testsToRerun, pkgGraph, &graphMutex, goalNode, channels, licenseCheckerConfig, maxCascadingRebuilds, toolchainPackages, allowToolchainRebuilds)
+	builtGraph, err := buildAllNodes(stopOnFailure, canUseCache, packagesToRebuild, testsToRerun, pkgGraph, &graphMutex, goalNode, channels, workers, schedulingPolicy, resourceAllocator, statusTracker, licenseCheckerConfig, maxCascadingRebuilds, toolchainPackages, allowToolchainRebuilds)
 
 	if builtGraph != nil {
 		graphMutex.RLock()
@@ -318,7 +422,7 @@ func buildGraph(inputFile, outputFile string, agent buildagents.BuildAgent, lice
 
 // startWorkerPool starts the worker pool and returns the communication channels between the workers and the scheduler.
 // channelBufferSize controls how many entries in the channels can be buffered before blocking writes to them.
-func startWorkerPool(agent buildagents.BuildAgent, workers, buildAttempts, checkAttempts, channelBufferSize int, graphMutex *sync.RWMutex, ignoredPackages, ignoredTests []*pkgjson.PackageVer) (channels *schedulerChannels) {
+func startWorkerPool(agent buildagents.BuildAgent, workers, buildAttempts, checkAttempts int, retryPolicy schedulerutils.RetryPolicy, channelBufferSize int, graphMutex *sync.RWMutex, ignoredPackages, ignoredTests []*pkgjson.PackageVer) (channels *schedulerChannels) {
 	channels = &schedulerChannels{
 		Requests:         make(chan *schedulerutils.BuildRequest, channelBufferSize),
 		PriorityRequests: make(chan *schedulerutils.BuildRequest, channelBufferSize),
@@ -339,7 +443,7 @@ func startWorkerPool(agent buildagents.BuildAgent, workers, buildAttempts, check
 	// Start the workers now so they begin working as soon as a new job is queued.
 	for i := 0; i < workers; i++ {
 		logger.Log.Debugf("Starting worker #%d", i)
-		go schedulerutils.BuildNodeWorker(directionalChannels, agent, graphMutex, buildAttempts, checkAttempts, ignoredPackages, ignoredTests)
+		go schedulerutils.BuildNodeWorker(directionalChannels, agent, graphMutex, buildAttempts, checkAttempts, retryPolicy, ignoredPackages, ignoredTests)
 	}
 
 	return
@@ -349,12 +453,12 @@ func startWorkerPool(agent buildagents.BuildAgent, workers, buildAttempts, check
 // This routine only contains control flow logic for build scheduling.
 // It iteratively:
 // - Calculates any unblocked nodes.
-// - Submits these nodes to the worker pool to be processed.
+// - Submits these nodes to the worker pool to be processed, builds and tests in the scheduling policy's order.
 // - Grabs a single build result from the worker pool.
 // - Attempts to satisfy any unresolved dynamic dependencies with new implicit provides from the build result.
 // - Attempts to subgraph the graph to only contain the requested packages if possible.
 // - Repeat.
-func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRerun []*pkgjson.PackageVer, pkgGraph *pkggraph.PkgGraph, graphMutex *sync.RWMutex, goalNode *pkggraph.PkgNode, channels *schedulerChannels, licenseCheckerConfig schedulerutils.PackageLicenseCheckerConfig, maxCascadingRebuilds uint, reservedFiles []string, allowToolchainRebuilds bool) (builtGraph *pkggraph.PkgGraph, err error) {
+func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRerun []*pkgjson.PackageVer, pkgGraph *pkggraph.PkgGraph, graphMutex *sync.RWMutex, goalNode *pkggraph.PkgNode, channels *schedulerChannels, workers int, schedulingPolicy string, resourceAllocator *schedulerutils.ResourceAllocator, statusTracker *dashboard.Tracker, licenseCheckerConfig schedulerutils.PackageLicenseCheckerConfig, maxCascadingRebuilds uint, reservedFiles []string, allowToolchainRebuilds bool) (builtGraph *pkggraph.PkgGraph, err error) {
 	var (
 		// stopBuilding tracks if the build has entered a failed state and this routine should stop as soon as possible.
 		stopBuilding bool
@@ -371,8 +475,21 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 		isGraphOptimized  bool
 
 		licenseChecker *schedulerutils.PackageLicenseChecker
+
+		// buildQueue holds the ready builds and tests until a worker is free to take them.
+		buildQueue *schedulerutils.BuildQueue
+		// dispatchedBuilds tracks how many builds and tests have been handed to the workers and haven't returned a result yet.
+		dispatchedBuilds int
 	)
 
+	buildQueue, err = schedulerutils.NewBuildQueue(schedulingPolicy, schedulerutils.NewBuildPriorities(pkgGraph, graphMutex))
+	if err != nil {
+		err = fmt.Errorf("failed to create build queue:\n%w", err)
+		return
+	}
+
+	statusTracker.SetGraph(pkgGraph, graphMutex)
+
 	// Start the build at the leaf nodes.
 	// The build will bubble up through the graph as it processes nodes.
 	buildState := schedulerutils.NewGraphBuildState(reservedFiles, maxCascadingRebuilds)
@@ -411,11 +528,15 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 			// way as quickly as possible since they may help us optimize the graph early.
 			// Meta nodes may also be blocking something we want to examine and give higher priority (priority inheritance from
 			// the hypothetical high priority node hidden further into the tree)
+			// Builds and tests are held in the build queue, which orders them by the scheduling policy.
 			switch req.Node.Type {
 			case pkggraph.TypePreBuilt:
 				channels.PriorityRequests <- req
 
-				// For now all build nodes are of equal priority
+			case pkggraph.TypeLocalBuild, pkggraph.TypeTest:
+				buildQueue.Push(req)
+				statusTracker.RecordQueued(req)
+
 			case pkggraph.TypeGoal:
 				fallthrough
 			case pkggraph.TypePureMeta:
@@ -424,14 +545,14 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 				fallthrough
 			case pkggraph.TypeRemoteRun:
 				fallthrough
-			case pkggraph.TypeLocalBuild:
-				fallthrough
 			default:
 				channels.Requests <- req
 			}
 		}
 		nodesToBuild = nil
 
+		dispatchedBuilds = dispatchQueuedBuilds(channels, buildQueue, resourceAllocator, statusTracker, dispatchedBuilds, workers)
+
 		// If there are no active builds running or results waiting to check try enabling cached packages for unresolved
 		// dynamic dependencies to unblock more nodes. Otherwise, there is nothing left that can be built.
 		if len(buildState.ActiveBuilds()) == 0 && len(channels.Results) == 0 {
@@ -448,6 +569,11 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 
 		// Process the the next build result
 		res := <-channels.Results
+		if schedulerutils.IsQueuedNode(res.Node) {
+			dispatchedBuilds--
+			resourceAllocator.Release(res.Node)
+		}
+		statusTracker.RecordResult(res)
 
 		// Pass the paths to the built RPMs to the license checker if it is enabled.
 		if licenseChecker != nil && res.Err == nil {
@@ -501,6 +627,10 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 						// When querying their edges, the graph library will return an empty iterator (graph.Empty).
 						pkgGraph = newGraph
 						goalNode = newGoalNode
+						statusTracker.SetGraph(pkgGraph, graphMutex)
+
+						// The optimized graph may have dropped some of the work, so the critical paths must be recalculated.
+						buildQueue.UpdatePriorities(schedulerutils.NewBuildPriorities(pkgGraph, graphMutex))
 					}
 				}
 
@@ -515,6 +645,7 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 		// but only after this check is made. In that case we will call doneBuild() instead.
 		if stopBuilding {
 			// If the build has failed, stop all outstanding builds.
+			dropQueuedBuilds(buildQueue, buildState)
 			stopBuild(channels, buildState)
 			err = fmt.Errorf("fatal error building package graph:\n%w", err)
 			// Save out the current graph state for debugging
@@ -549,6 +680,7 @@ func buildAllNodes(stopOnFailure, canUseCache bool, packagesToRebuild, testsToRe
 	}
 
 	// Let the workers know they are done
+	dropQueuedBuilds(buildQueue, buildState)
 	doneBuild(channels, buildState)
 	// Give the workers time to finish so they don't mess up the summary we want to print.
 	// Some nodes may still be busy with long running builds we don't care about anymore, so we don't
@@ -650,6 +782,68 @@ func setAssociatedDeltaPaths(res *schedulerutils.BuildResult, pkgGraph *pkggraph
 	return
 }
 
+// dispatchQueuedBuilds hands the highest priority builds in the build queue to the workers until either all workers are
+// busy, the next build doesn't fit in the resource budget, or the queue is empty. Builds are always handed out in
+// order, so a large build waiting for resources isn't overtaken by smaller ones indefinitely.
+// Returns the updated number of dispatched builds.
+func dispatchQueuedBuilds(channels *schedulerChannels, buildQueue *schedulerutils.BuildQueue, resourceAllocator *schedulerutils.ResourceAllocator, statusTracker *dashboard.Tracker, dispatchedBuilds, workers int) int {
+	for dispatchedBuilds < workers && buildQueue.Len() > 0 {
+		if !resourceAllocator.TryAllocate(buildQueue.Peek()) {
+			break
+		}
+
+		req := buildQueue.Pop()
+		statusTracker.RecordDispatched(req)
+		channels.Requests <- req
+		dispatchedBuilds++
+	}
+
+	return dispatchedBuilds
+}
+
+// newResourceAllocator creates the allocator limiting the concurrent builds to the resource budget. Returns nil, which
+// places no limits on the builds, if no resource classes file is given.
+func newResourceAllocator(resourceClassesFile string, memoryBudget, cpuBudget int) (allocator *schedulerutils.ResourceAllocator, err error) {
+	if resourceClassesFile == "" {
+		return
+	}
+
+	if *buildAgent == buildagents.RemoteAgentFlag {
+		logger.Log.Warnf("Resource classes only apply to builds on the local machine, ignoring (%s)", resourceClassesFile)
+		return
+	}
+
+	config, err := schedulerutils.ReadResourceClassesConfig(resourceClassesFile)
+	if err != nil {
+		return
+	}
+
+	hostBudget, err := schedulerutils.HostResourceBudget()
+	if err != nil {
+		return
+	}
+
+	budget := schedulerutils.ResourceBudget{MemoryMB: memoryBudget, CPUs: cpuBudget}
+	if budget.MemoryMB <= 0 {
+		budget.MemoryMB = hostBudget.MemoryMB
+	}
+	if budget.CPUs <= 0 {
+		budget.CPUs = hostBudget.CPUs
+	}
+
+	logger.Log.Infof("Limiting concurrent builds to %d MB of memory and %d CPUs", budget.MemoryMB, budget.CPUs)
+	allocator = schedulerutils.NewResourceAllocator(config, budget)
+
+	return
+}
+
+// dropQueuedBuilds removes the builds that were never handed to the workers from the build state.
+func dropQueuedBuilds(buildQueue *schedulerutils.BuildQueue, buildState *schedulerutils.GraphBuildState) {
+	for buildQueue.Len() > 0 {
+		buildState.RemoveBuildRequest(buildQueue.Pop())
+	}
+}
+
 func drainChannels(channels *schedulerChannels, buildState *schedulerutils.GraphBuildState) {
 	// For any workers that are current parked with no buffered requests, close the
 	// requests channel to wake up any build workers waiting on a request to be buffered.
@@ -686,3 +880,21 @@ func stopBuild(channels *schedulerChannels, buildState *schedulerutils.GraphBuil
 
 	drainChannels(channels, buildState)
 }
+
+// resolveWorkerSource validates the worker chroot flags and returns the tarball or image to create the build chroots
+// from.
+func resolveWorkerSource(workerTar, workerImage string) (workerSource string, err error) {
+	switch {
+	case workerTar != "" && workerImage != "":
+		return "", fmt.Errorf("--worker-tar and --worker-image are mutually exclusive")
+	case workerImage != "":
+		if !ociimage.IsImageReference(workerImage) {
+			return "", fmt.Errorf("--worker-image (%s) does not reference an OCI image layout", workerImage)
+		}
+		return workerImage, nil
+	case workerTar != "":
+		return workerTar, nil
+	default:
+		return "", fmt.Errorf("one of --worker-tar or --worker-image must be provided")
+	}
+}
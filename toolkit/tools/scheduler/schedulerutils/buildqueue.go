@@ -0,0 +1,287 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package schedulerutils
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+)
+
+// Supported scheduling policies, which decide the order in which ready builds are handed to the workers.
+const (
+	// SchedulingPolicyCriticalPath builds the ready SRPMs with the longest chain of pending builds depending on them first.
+	SchedulingPolicyCriticalPath = "critical-path"
+	// SchedulingPolicyFIFO builds the ready SRPMs in the order they became ready.
+	SchedulingPolicyFIFO = "fifo"
+)
+
+// ValidSchedulingPolicies returns the supported scheduling policies.
+func ValidSchedulingPolicies() []string {
+	return []string{SchedulingPolicyCriticalPath, SchedulingPolicyFIFO}
+}
+
+// BuildPriorities holds the critical path length of every node in a graph: the number of pending builds on the longest
+// chain of nodes depending on it, including the node itself. Building the nodes with the longest critical paths first
+// unblocks the most downstream work and shortens the overall build.
+type BuildPriorities struct {
+	criticalPaths map[int64]int
+	dependents    map[int64]int
+}
+
+// NewBuildPriorities calculates the critical path length of every node in a graph.
+func NewBuildPriorities(pkgGraph *pkggraph.PkgGraph, graphMutex *sync.RWMutex) (priorities *BuildPriorities) {
+	graphMutex.RLock()
+	defer graphMutex.RUnlock()
+
+	priorities = &BuildPriorities{
+		criticalPaths: make(map[int64]int),
+		dependents:    make(map[int64]int),
+	}
+
+	visiting := make(map[int64]bool)
+	for _, node := range pkgGraph.AllNodes() {
+		priorities.calculateCriticalPath(pkgGraph, node, visiting)
+	}
+
+	return
+}
+
+// CriticalPathLength returns the critical path length of a node, or 0 if the node is unknown.
+func (b *BuildPriorities) CriticalPathLength(node *pkggraph.PkgNode) int {
+	return b.criticalPaths[node.ID()]
+}
+
+// RequestPriority returns the priority of a build request, which is the longest critical path of its nodes.
+func (b *BuildPriorities) RequestPriority(req *BuildRequest) (priority int) {
+	priority = b.CriticalPathLength(req.Node)
+	for _, node := range req.AncillaryNodes {
+		if length := b.CriticalPathLength(node); length > priority {
+			priority = length
+		}
+	}
+
+	return
+}
+
+// CriticalPath returns the pending builds on the longest chain of nodes depending on a node, starting with the node
+// itself if it is pending. The caller must hold the graph's read lock.
+func (b *BuildPriorities) CriticalPath(pkgGraph *pkggraph.PkgGraph, node *pkggraph.PkgNode) (path []*pkggraph.PkgNode) {
+	visited := make(map[int64]bool)
+	for node != nil && !visited[node.ID()] {
+		visited[node.ID()] = true
+		if isPendingBuild(node) {
+			path = append(path, node)
+		}
+
+		var next *pkggraph.PkgNode
+		dependents := pkgGraph.To(node.ID())
+		for dependents.Next() {
+			dependent := dependents.Node().(*pkggraph.PkgNode).This
+			if b.CriticalPathLength(dependent) == 0 {
+				continue
+			}
+
+			if next == nil || b.CriticalPathLength(dependent) > b.CriticalPathLength(next) {
+				next = dependent
+			}
+		}
+		node = next
+	}
+
+	return
+}
+
+// directDependents returns the number of direct dependents of a request's nodes, used to break ties between requests
+// with the same priority.
+func (b *BuildPriorities) directDependents(req *BuildRequest) (dependents int) {
+	dependents = b.dependents[req.Node.ID()]
+	for _, node := range req.AncillaryNodes {
+		if node.ID() != req.Node.ID() {
+			dependents += b.dependents[node.ID()]
+		}
+	}
+
+	return
+}
+
+func (b *BuildPriorities) calculateCriticalPath(pkgGraph *pkggraph.PkgGraph, node *pkggraph.PkgNode, visiting map[int64]bool) (length int) {
+	if length, found := b.criticalPaths[node.ID()]; found {
+		return length
+	}
+
+	// The scheduler's graph is acyclic, but don't recurse forever if it isn't.
+	if visiting[node.ID()] {
+		logger.Log.Warnf("Dependency cycle through (%s) found while calculating build priorities", node.FriendlyName())
+		return 0
+	}
+	visiting[node.ID()] = true
+	defer delete(visiting, node.ID())
+
+	dependents := pkgGraph.To(node.ID())
+	b.dependents[node.ID()] = dependents.Len()
+	for dependents.Next() {
+		dependent := dependents.Node().(*pkggraph.PkgNode).This
+
+		dependentLength := b.calculateCriticalPath(pkgGraph, dependent, visiting)
+		if dependentLength > length {
+			length = dependentLength
+		}
+	}
+
+	if isPendingBuild(node) {
+		length++
+	}
+
+	b.criticalPaths[node.ID()] = length
+	return length
+}
+
+// isPendingBuild checks if a node is a build or a test that may still have to run.
+func isPendingBuild(node *pkggraph.PkgNode) bool {
+	if node.Type != pkggraph.TypeLocalBuild && node.Type != pkggraph.TypeTest {
+		return false
+	}
+
+	return node.State == pkggraph.StateBuild || node.State == pkggraph.StateDelta || node.State == pkggraph.StateBuildError
+}
+
+// IsQueuedNode checks if the build requests of a node wait in a BuildQueue for a free worker. Only builds and tests
+// are queued, all other requests are processed instantly by the workers.
+func IsQueuedNode(node *pkggraph.PkgNode) bool {
+	return node.Type == pkggraph.TypeLocalBuild || node.Type == pkggraph.TypeTest
+}
+
+// BuildQueue holds the build requests that are ready to be built until a worker is free to take them. Requests are
+// returned highest priority first, and in the order they were added when their priorities are equal.
+//
+// BuildQueue is not safe for concurrent use.
+type BuildQueue struct {
+	items      buildQueueItems
+	priorities *BuildPriorities
+	sequence   uint64
+}
+
+// NewBuildQueue creates an empty build queue for a scheduling policy. The critical path policy requires priorities.
+func NewBuildQueue(policy string, priorities *BuildPriorities) (queue *BuildQueue, err error) {
+	switch policy {
+	case SchedulingPolicyCriticalPath:
+		if priorities == nil {
+			return nil, fmt.Errorf("scheduling policy (%s) requires build priorities", policy)
+		}
+	case SchedulingPolicyFIFO:
+		priorities = nil
+	default:
+		return nil, fmt.Errorf("unsupported scheduling policy (%s)", policy)
+	}
+
+	return &BuildQueue{priorities: priorities}, nil
+}
+
+// Len returns the number of queued requests.
+func (q *BuildQueue) Len() int {
+	return len(q.items)
+}
+
+// Push adds a request to the queue.
+func (q *BuildQueue) Push(req *BuildRequest) {
+	item := &buildQueueItem{
+		request:  req,
+		sequence: q.sequence,
+	}
+	q.sequence++
+
+	q.prioritize(item)
+	heap.Push(&q.items, item)
+}
+
+// Pop removes and returns the highest priority request, or nil if the queue is empty.
+func (q *BuildQueue) Pop() *BuildRequest {
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	item := heap.Pop(&q.items).(*buildQueueItem)
+	logger.Log.Debugf("Dequeued (%s) with critical path length %d", item.request.Node.FriendlyName(), item.priority)
+
+	return item.request
+}
+
+// Peek returns the highest priority request without removing it, or nil if the queue is empty.
+func (q *BuildQueue) Peek() *BuildRequest {
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	return q.items[0].request
+}
+
+// UpdatePriorities replaces the priorities used by the queue (e.g. after the graph changed) and reorders the queued
+// requests. It has no effect on FIFO queues.
+func (q *BuildQueue) UpdatePriorities(priorities *BuildPriorities) {
+	if q.priorities == nil {
+		return
+	}
+
+	q.priorities = priorities
+	for _, item := range q.items {
+		q.prioritize(item)
+	}
+	heap.Init(&q.items)
+}
+
+func (q *BuildQueue) prioritize(item *buildQueueItem) {
+	if q.priorities == nil {
+		return
+	}
+
+	item.priority = q.priorities.RequestPriority(item.request)
+	item.dependents = q.priorities.directDependents(item.request)
+}
+
+type buildQueueItem struct {
+	request    *BuildRequest
+	priority   int
+	dependents int
+	sequence   uint64
+}
+
+// buildQueueItems implements heap.Interface.
+type buildQueueItems []*buildQueueItem
+
+func (h buildQueueItems) Len() int {
+	return len(h)
+}
+
+func (h buildQueueItems) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+
+	if h[i].dependents != h[j].dependents {
+		return h[i].dependents > h[j].dependents
+	}
+
+	return h[i].sequence < h[j].sequence
+}
+
+func (h buildQueueItems) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *buildQueueItems) Push(x interface{}) {
+	*h = append(*h, x.(*buildQueueItem))
+}
+
+func (h *buildQueueItems) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
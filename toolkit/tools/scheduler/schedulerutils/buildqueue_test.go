@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package schedulerutils
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+type testSRPM struct {
+	build *pkggraph.PkgNode
+	run   *pkggraph.PkgNode
+}
+
+func addTestSRPM(t *testing.T, pkgGraph *pkggraph.PkgGraph, name string) testSRPM {
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: "1.0"}
+	srpmPath := name + ".src.rpm"
+
+	run, err := pkgGraph.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeLocalRun, srpmPath, name+".rpm", name+".spec", name, "x86_64", "")
+	require.NoError(t, err)
+
+	build, err := pkgGraph.AddPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeLocalBuild, srpmPath, name+".rpm", name+".spec", name, "x86_64", "")
+	require.NoError(t, err)
+
+	require.NoError(t, pkgGraph.AddEdge(run, build))
+
+	return testSRPM{build: build, run: run}
+}
+
+// makePriorityGraph creates a graph where "compiler" is needed to build "lib", which is needed to build "app", while
+// nothing depends on "tool" and "docs".
+func makePriorityGraph(t *testing.T) (pkgGraph *pkggraph.PkgGraph, srpms map[string]testSRPM) {
+	pkgGraph = pkggraph.NewPkgGraph()
+	srpms = make(map[string]testSRPM)
+
+	for _, name := range []string{"tool", "docs", "compiler", "lib", "app"} {
+		srpms[name] = addTestSRPM(t, pkgGraph, name)
+	}
+
+	require.NoError(t, pkgGraph.AddEdge(srpms["lib"].build, srpms["compiler"].run))
+	require.NoError(t, pkgGraph.AddEdge(srpms["app"].build, srpms["lib"].run))
+
+	return
+}
+
+func TestBuildPrioritiesCriticalPathLength(t *testing.T) {
+	pkgGraph, srpms := makePriorityGraph(t)
+	priorities := NewBuildPriorities(pkgGraph, &sync.RWMutex{})
+
+	assert.Equal(t, 3, priorities.CriticalPathLength(srpms["compiler"].build))
+	assert.Equal(t, 2, priorities.CriticalPathLength(srpms["compiler"].run))
+	assert.Equal(t, 2, priorities.CriticalPathLength(srpms["lib"].build))
+	assert.Equal(t, 1, priorities.CriticalPathLength(srpms["app"].build))
+	assert.Equal(t, 1, priorities.CriticalPathLength(srpms["tool"].build))
+	assert.Equal(t, 0, priorities.CriticalPathLength(srpms["tool"].run))
+}
+
+func TestBuildQueueCriticalPathOrder(t *testing.T) {
+	pkgGraph, srpms := makePriorityGraph(t)
+
+	queue, err := NewBuildQueue(SchedulingPolicyCriticalPath, NewBuildPriorities(pkgGraph, &sync.RWMutex{}))
+	require.NoError(t, err)
+
+	for _, name := range []string{"tool", "docs", "compiler"} {
+		queue.Push(&BuildRequest{Node: srpms[name].build, AncillaryNodes: []*pkggraph.PkgNode{srpms[name].build}})
+	}
+
+	// "compiler" unblocks the most work, "tool" and "docs" keep the order they were queued in.
+	assert.Equal(t, srpms["compiler"].build, queue.Pop().Node)
+	assert.Equal(t, srpms["tool"].build, queue.Pop().Node)
+	assert.Equal(t, srpms["docs"].build, queue.Pop().Node)
+	assert.Nil(t, queue.Pop())
+}
+
+func TestBuildQueueFIFOOrder(t *testing.T) {
+	pkgGraph, srpms := makePriorityGraph(t)
+
+	queue, err := NewBuildQueue(SchedulingPolicyFIFO, NewBuildPriorities(pkgGraph, &sync.RWMutex{}))
+	require.NoError(t, err)
+
+	for _, name := range []string{"tool", "docs", "compiler"} {
+		queue.Push(&BuildRequest{Node: srpms[name].build})
+	}
+
+	// Updating the priorities mustn't reorder a FIFO queue.
+	queue.UpdatePriorities(NewBuildPriorities(pkgGraph, &sync.RWMutex{}))
+
+	assert.Equal(t, 3, queue.Len())
+	assert.Equal(t, srpms["tool"].build, queue.Pop().Node)
+	assert.Equal(t, srpms["docs"].build, queue.Pop().Node)
+	assert.Equal(t, srpms["compiler"].build, queue.Pop().Node)
+}
+
+func TestNewBuildQueueInvalidPolicy(t *testing.T) {
+	_, err := NewBuildQueue("random", nil)
+	assert.ErrorContains(t, err, "unsupported scheduling policy")
+
+	_, err = NewBuildQueue(SchedulingPolicyCriticalPath, nil)
+	assert.ErrorContains(t, err, "requires build priorities")
+}
@@ -0,0 +1,218 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package schedulerutils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+)
+
+// FailureClass is the cause of a failed build attempt, as classified from its build log.
+type FailureClass string
+
+const (
+	// FailureClassNetwork is a failure to fetch packages or sources over the network.
+	FailureClassNetwork FailureClass = "network"
+	// FailureClassNetworkIsolation is a build which tried to download files while isolated from the network. It's
+	// not transient: the spec must be fixed to not download anything.
+	FailureClassNetworkIsolation FailureClass = "network-isolation"
+	// FailureClassChroot is a failure to set up or tear down the build environment, like a busy mount.
+	FailureClassChroot FailureClass = "chroot"
+	// FailureClassCompilation is a genuine failure of the package build.
+	FailureClassCompilation FailureClass = "compilation"
+	// FailureClassTest is a failure of the package tests.
+	FailureClassTest FailureClass = "test"
+	// FailureClassUnknown is a failure which doesn't match any known pattern.
+	FailureClassUnknown FailureClass = "unknown"
+)
+
+const (
+	// genericRetryDelay is the delay before retrying a failure which isn't transient.
+	genericRetryDelay = time.Second
+	// maxLogLineSize is the longest build log line the classifier reads.
+	maxLogLineSize = 1024 * 1024
+	// failureContextLines is the number of lines at the end of a build log that describe the failure. Earlier lines
+	// are ignored, so that the output of a build step (e.g. a test printing "connection refused") doesn't change
+	// the classification of a failure which happens later.
+	failureContextLines = 30
+)
+
+// failurePatterns are the patterns of build log lines identifying each failure class. They are only matched against
+// the end of the build log (see failureContextLines). Transient classes are matched first: a network failure during
+// a build usually also shows up as a failed build step. Downloads denied by the network isolation come before them,
+// since they also show up as network failures.
+var failurePatterns = []struct {
+	class    FailureClass
+	patterns []*regexp.Regexp
+}{
+	{
+		class: FailureClassNetworkIsolation,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`Build tried to access the network`),
+		},
+	},
+	{
+		class: FailureClassNetwork,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)could not resolve host`),
+			regexp.MustCompile(`(?i)temporary failure in name resolution`),
+			regexp.MustCompile(`(?i)connection (timed out|refused|reset by peer)`),
+			regexp.MustCompile(`(?i)failed to download`),
+			regexp.MustCompile(`(?i)curl( error|#)\s*\(?\d+`),
+			regexp.MustCompile(`(?i)network is unreachable`),
+			regexp.MustCompile(`(?i)tls handshake timeout`),
+		},
+	},
+	{
+		class: FailureClassChroot,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)failed to (initialize chroot|mount|unmount)`),
+			regexp.MustCompile(`(?i)device or resource busy`),
+			regexp.MustCompile(`(?i)failed to mount chroot overlay`),
+		},
+	},
+	{
+		class: FailureClassCompilation,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`error: Bad exit status from`),
+			regexp.MustCompile(`make(\[\d+\])?: \*\*\*`),
+			regexp.MustCompile(`(?i)error: .*(failed|not found)`),
+			regexp.MustCompile(`RPM build errors`),
+		},
+	},
+}
+
+// IsTransient checks if a failure may succeed when retried.
+func (f FailureClass) IsTransient() bool {
+	return f == FailureClassNetwork || f == FailureClassChroot
+}
+
+// ClassifyBuildFailure classifies a failed build attempt from its error and the end of its build log.
+func ClassifyBuildFailure(logFile string, buildErr error) (class FailureClass) {
+	lines := []string{}
+	if buildErr != nil {
+		lines = append(lines, strings.Split(buildErr.Error(), "\n")...)
+	}
+
+	logLines, err := readLogTail(logFile, failureContextLines)
+	if err != nil {
+		logger.Log.Debugf("Failed to read build log (%s) to classify the failure:\n%s", logFile, err)
+	}
+	lines = append(lines, logLines...)
+
+	for _, classPatterns := range failurePatterns {
+		for _, line := range lines {
+			for _, pattern := range classPatterns.patterns {
+				if pattern.MatchString(line) {
+					return classPatterns.class
+				}
+			}
+		}
+	}
+
+	return FailureClassUnknown
+}
+
+// readLogTail reads the last maxLines lines of a build log.
+func readLogTail(logFile string, maxLines int) (lines []string, err error) {
+	if logFile == "" {
+		return
+	}
+
+	file, err := os.Open(logFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLogLineSize)
+	for scanner.Scan() {
+		if len(lines) >= maxLines {
+			lines = lines[1:]
+		}
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, scanner.Err()
+}
+
+// RetryPolicy decides which failed build attempts are retried.
+type RetryPolicy struct {
+	// TransientRetries is the number of extra attempts given to failures classified as transient, on top of the
+	// regular attempts.
+	TransientRetries int
+	// TransientBackoff is the delay before the first transient retry. It doubles with each transient retry.
+	TransientBackoff time.Duration
+}
+
+// BuildAttempt is a failed attempt to build or test a package.
+type BuildAttempt struct {
+	Class FailureClass
+	Err   error
+}
+
+// FormatRetryHistory describes the failed attempts of a build for the build reports, e.g. "network, compilation".
+func FormatRetryHistory(history []BuildAttempt) string {
+	classes := make([]string, 0, len(history))
+	for _, attempt := range history {
+		classes = append(classes, string(attempt.Class))
+	}
+
+	return strings.Join(classes, ", ")
+}
+
+// buildAttemptFunc runs a single build attempt. If class is empty, a failure is classified from its build log.
+type buildAttemptFunc func() (logFile string, class FailureClass, err error)
+
+// runBuildAttempts runs a build until it succeeds or the retry policy gives up. Any failure is retried until the
+// given number of attempts is used, failures classified as transient get extra attempts with exponential backoff.
+// Returns the failed attempts and the error of the last one.
+func runBuildAttempts(ctx context.Context, policy RetryPolicy, attempts int, description string, attempt buildAttemptFunc) (history []BuildAttempt, wasCancelled bool, err error) {
+	transientRetries := 0
+	for {
+		var (
+			logFile string
+			class   FailureClass
+		)
+		logFile, class, err = attempt()
+		if err == nil {
+			return
+		}
+
+		if class == "" {
+			class = ClassifyBuildFailure(logFile, err)
+		}
+		history = append(history, BuildAttempt{Class: class, Err: err})
+
+		var delay time.Duration
+		regularAttempts := len(history) - transientRetries
+		switch {
+		case class.IsTransient() && transientRetries < policy.TransientRetries:
+			delay = policy.TransientBackoff << transientRetries
+			transientRetries++
+			logger.Log.Warnf("%s failed with a transient (%s) error, retrying in %s (transient retry %d/%d).", description, class, delay, transientRetries, policy.TransientRetries)
+		case regularAttempts < attempts:
+			delay = genericRetryDelay * time.Duration(regularAttempts)
+			logger.Log.Warnf("%s failed (%s) %d times, retrying up to %d times.", description, class, regularAttempts, attempts)
+		default:
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			wasCancelled = true
+			err = fmt.Errorf("%w\n%w", err, ctx.Err())
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package schedulerutils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestLog(t *testing.T, content string) string {
+	logFile := filepath.Join(t.TempDir(), "build.log")
+	require.NoError(t, os.WriteFile(logFile, []byte(content), 0o644))
+	return logFile
+}
+
+func TestClassifyBuildFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      string
+		expected FailureClass
+	}{
+		{
+			name:     "network",
+			log:      "Installing build requirements\ncurl#6: Couldn't resolve host name\nError(1200) : Could not resolve host: packages.microsoft.com\n",
+			expected: FailureClassNetwork,
+		},
+		{
+			name:     "network failure in build step",
+			log:      "pip install foo\nConnection timed out\nerror: Bad exit status from /var/tmp/rpm-tmp.1234 (%build)\n",
+			expected: FailureClassNetwork,
+		},
+		{
+			name:     "network isolation",
+			log:      "curl: (56) CONNECT tunnel failed, response 403\nlevel=error msg=\"Build tried to access the network: CONNECT github.com:443\"\n",
+			expected: FailureClassNetworkIsolation,
+		},
+		{
+			name:     "chroot",
+			log:      "level=error msg=\"failed to initialize chroot:\nmount: /proc: Device or resource busy\"\n",
+			expected: FailureClassChroot,
+		},
+		{
+			name:     "compilation",
+			log:      "foo.c:10:1: error: expected ';' before '}' token\nmake: *** [Makefile:10: foo.o] Error 1\nerror: Bad exit status from /var/tmp/rpm-tmp.1234 (%build)\n",
+			expected: FailureClassCompilation,
+		},
+		{
+			name: "compilation after network error in earlier output",
+			log: "Running test_client\nconnect: Connection refused\n" + strings.Repeat("ok\n", failureContextLines) +
+				"foo.c:10:1: error: expected ';' before '}' token\nmake: *** [Makefile:10: foo.o] Error 1\n" +
+				"error: Bad exit status from /var/tmp/rpm-tmp.1234 (%build)\n",
+			expected: FailureClassCompilation,
+		},
+		{
+			name:     "unknown",
+			log:      "something went wrong\n",
+			expected: FailureClassUnknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ClassifyBuildFailure(writeTestLog(t, test.log), fmt.Errorf("build failed")))
+		})
+	}
+}
+
+func TestClassifyBuildFailureWithoutLog(t *testing.T) {
+	assert.Equal(t, FailureClassNetwork, ClassifyBuildFailure("", fmt.Errorf("failed to download:\nconnection refused")))
+	assert.Equal(t, FailureClassUnknown, ClassifyBuildFailure(filepath.Join(t.TempDir(), "missing.log"), fmt.Errorf("build failed")))
+}
+
+// scriptedAttempts returns a build attempt function failing with the given classes, then succeeding.
+func scriptedAttempts(classes ...FailureClass) (attempt buildAttemptFunc, calls *int) {
+	calls = new(int)
+	attempt = func() (logFile string, class FailureClass, err error) {
+		defer func() { *calls++ }()
+		if *calls < len(classes) {
+			return "", classes[*calls], fmt.Errorf("attempt %d failed", *calls)
+		}
+		return "", "", nil
+	}
+
+	return
+}
+
+func TestRunBuildAttemptsRetriesTransientFailures(t *testing.T) {
+	policy := RetryPolicy{TransientRetries: 2, TransientBackoff: time.Millisecond}
+	attempt, calls := scriptedAttempts(FailureClassNetwork, FailureClassChroot)
+
+	history, wasCancelled, err := runBuildAttempts(context.Background(), policy, 1, "Build for 'test'", attempt)
+	require.NoError(t, err)
+	assert.False(t, wasCancelled)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, "network, chroot", FormatRetryHistory(history))
+}
+
+func TestRunBuildAttemptsDoesNotRetryCompilationFailures(t *testing.T) {
+	policy := RetryPolicy{TransientRetries: 2, TransientBackoff: time.Millisecond}
+	attempt, calls := scriptedAttempts(FailureClassCompilation)
+
+	history, _, err := runBuildAttempts(context.Background(), policy, 1, "Build for 'test'", attempt)
+	assert.Error(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, "compilation", FormatRetryHistory(history))
+}
+
+func TestRunBuildAttemptsTransientRetriesExhausted(t *testing.T) {
+	policy := RetryPolicy{TransientRetries: 1, TransientBackoff: time.Millisecond}
+	attempt, calls := scriptedAttempts(FailureClassNetwork, FailureClassNetwork, FailureClassNetwork)
+
+	history, _, err := runBuildAttempts(context.Background(), policy, 1, "Build for 'test'", attempt)
+	assert.Error(t, err)
+	assert.Equal(t, 2, *calls)
+	assert.Len(t, history, 2)
+}
+
+func TestRunBuildAttemptsRegularAttemptsRetryAnyFailure(t *testing.T) {
+	attempt, calls := scriptedAttempts(FailureClassCompilation)
+
+	history, _, err := runBuildAttempts(context.Background(), RetryPolicy{}, 2, "Build for 'test'", attempt)
+	require.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	assert.Len(t, history, 1)
+}
+
+func TestRunBuildAttemptsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	policy := RetryPolicy{TransientRetries: 1, TransientBackoff: time.Hour}
+	attempt, _ := scriptedAttempts(FailureClassNetwork)
+
+	_, wasCancelled, err := runBuildAttempts(ctx, policy, 1, "Build for 'test'", attempt)
+	assert.Error(t, err)
+	assert.True(t, wasCancelled)
+}
@@ -17,7 +17,6 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
-	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/sliceutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/scheduler/buildagents"
@@ -58,6 +57,7 @@ type BuildResult struct {
 	Freshness          uint                // The freshness of the node (used to determine if we can skip building future nodes).
 	HasLicenseWarnings bool                // Package has at least one license check warning
 	HasLicenseErrors   bool                // Package has at least one license check error
+	RetryHistory       []BuildAttempt      // The failed attempts to build or test the node, oldest first.
 }
 
 // selectNextBuildRequest selects a job based on priority:
@@ -101,7 +101,7 @@ func selectNextBuildRequest(channels *BuildChannels) (req *BuildRequest, finish
 }
 
 // BuildNodeWorker process all build requests, can be run concurrently with multiple instances.
-func BuildNodeWorker(channels *BuildChannels, agent buildagents.BuildAgent, graphMutex *sync.RWMutex, buildAttempts int, checkAttempts int, ignoredPackages, ignoredTests []*pkgjson.PackageVer) {
+func BuildNodeWorker(channels *BuildChannels, agent buildagents.BuildAgent, graphMutex *sync.RWMutex, buildAttempts int, checkAttempts int, retryPolicy RetryPolicy, ignoredPackages, ignoredTests []*pkgjson.PackageVer) {
 	// Track the time a worker spends waiting on a task. We will add a timing node each time we finish processing a request, and stop
 	// it when we pick up the next request
 	for req, cancelled := selectNextBuildRequest(channels); !cancelled && req != nil; req, cancelled = selectNextBuildRequest(channels) {
@@ -115,7 +115,7 @@ func BuildNodeWorker(channels *BuildChannels, agent buildagents.BuildAgent, grap
 
 		switch req.Node.Type {
 		case pkggraph.TypeLocalBuild:
-			res.Ignored, res.BuiltFiles, res.LogFile, res.Err = buildNode(req, graphMutex, agent, buildAttempts, ignoredPackages)
+			res.Ignored, res.BuiltFiles, res.LogFile, res.RetryHistory, res.Err = buildNode(req, graphMutex, agent, buildAttempts, retryPolicy, ignoredPackages)
 			if res.Err == nil {
 				setAncillaryBuildNodesStatus(req, graphMutex, pkggraph.StateUpToDate)
 			} else {
@@ -123,7 +123,7 @@ func BuildNodeWorker(channels *BuildChannels, agent buildagents.BuildAgent, grap
 			}
 
 		case pkggraph.TypeTest:
-			res.CheckFailed, res.Ignored, res.LogFile, res.Err = testNode(req, graphMutex, agent, checkAttempts, ignoredTests)
+			res.CheckFailed, res.Ignored, res.LogFile, res.RetryHistory, res.Err = testNode(req, graphMutex, agent, checkAttempts, retryPolicy, ignoredTests)
 			if res.Err == nil {
 				setAncillaryBuildNodesStatus(req, graphMutex, pkggraph.StateUpToDate)
 			} else {
@@ -147,7 +147,7 @@ func BuildNodeWorker(channels *BuildChannels, agent buildagents.BuildAgent, grap
 }
 
 // buildNode builds a TypeLocalBuild node, either used a cached copy if possible or building the corresponding SRPM.
-func buildNode(request *BuildRequest, graphMutex *sync.RWMutex, agent buildagents.BuildAgent, buildAttempts int, ignoredPackages []*pkgjson.PackageVer) (ignored bool, builtFiles []string, logFile string, err error) {
+func buildNode(request *BuildRequest, graphMutex *sync.RWMutex, agent buildagents.BuildAgent, buildAttempts int, retryPolicy RetryPolicy, ignoredPackages []*pkgjson.PackageVer) (ignored bool, builtFiles []string, logFile string, retryHistory []BuildAttempt, err error) {
 	node := request.Node
 	baseSrpmName := node.SRPMFileName()
 
@@ -171,14 +171,15 @@ func buildNode(request *BuildRequest, graphMutex *sync.RWMutex, agent buildagent
 	}
 
 	dependencies := getBuildDependencies(node, request.PkgGraph, graphMutex)
+	expectedFiles, _ := pkggraph.FindRPMFiles(node.SrpmPath, request.PkgGraph, graphMutex)
 
 	logger.Log.Infof("Building: %s", baseSrpmName)
-	builtFiles, logFile, err = buildSRPMFile(agent, buildAttempts, basePackageName, node.SrpmPath, node.Architecture, dependencies)
+	builtFiles, logFile, retryHistory, err = buildSRPMFile(agent, buildAttempts, retryPolicy, basePackageName, node.SrpmPath, node.Architecture, dependencies, expectedFiles)
 	return
 }
 
 // testNode tests a TypeTest node.
-func testNode(request *BuildRequest, graphMutex *sync.RWMutex, agent buildagents.BuildAgent, checkAttempts int, ignoredTests []*pkgjson.PackageVer) (checkFailed, ignored bool, logFile string, err error) {
+func testNode(request *BuildRequest, graphMutex *sync.RWMutex, agent buildagents.BuildAgent, checkAttempts int, retryPolicy RetryPolicy, ignoredTests []*pkgjson.PackageVer) (checkFailed, ignored bool, logFile string, retryHistory []BuildAttempt, err error) {
 	node := request.Node
 	baseSrpmName := node.SRPMFileName()
 
@@ -203,7 +204,7 @@ func testNode(request *BuildRequest, graphMutex *sync.RWMutex, agent buildagents
 	dependencies := getBuildDependencies(node, request.PkgGraph, graphMutex)
 
 	logger.Log.Infof("Testing: %s", baseSrpmName)
-	logFile, checkFailed, err = testSRPMFile(agent, checkAttempts, basePackageName, node.SrpmPath, node.Architecture, dependencies)
+	logFile, checkFailed, retryHistory, err = testSRPMFile(agent, checkAttempts, retryPolicy, basePackageName, node.SrpmPath, node.Architecture, dependencies)
 	return
 }
 
@@ -272,32 +273,24 @@ func parseCheckSection(logFile string) (checkFailed bool, err error) {
 }
 
 // buildSRPMFile sends an SRPM to a build agent to build.
-func buildSRPMFile(agent buildagents.BuildAgent, buildAttempts int, basePackageName, srpmFile, outArch string, dependencies []string) (builtFiles []string, logFile string, err error) {
-	const (
-		retryDuration = time.Second
-		runCheck      = false
-	)
+func buildSRPMFile(agent buildagents.BuildAgent, buildAttempts int, retryPolicy RetryPolicy, basePackageName, srpmFile, outArch string, dependencies, expectedFiles []string) (builtFiles []string, logFile string, retryHistory []BuildAttempt, err error) {
+	const runCheck = false
 
 	logBaseName := filepath.Base(srpmFile) + ".log"
 
 	// Track the time the build may take, and ensure we don't exceed the maximum limit.
-	attemptNumber := 0
 	totalExecutionTimeout := agent.Config().Timeout
 	deadline := time.Now().Add(totalExecutionTimeout)
 	ctx, cancelFunc := context.WithDeadline(context.Background(), deadline)
 	defer cancelFunc()
 
-	wasCancelled, err := retry.RunWithLinearBackoff(ctx, func() (buildErr error) {
-		if attemptNumber > 0 {
-			logger.Log.Warnf("Build for '%s' failed %d times, retrying up to %d times.", srpmFile, attemptNumber, buildAttempts)
-		}
-		attemptNumber++
-
-		builtFiles, logFile, buildErr = agent.BuildPackage(basePackageName, srpmFile, logBaseName, outArch, runCheck, dependencies, time.Until(deadline))
-		return
-	}, buildAttempts, retryDuration)
+	description := fmt.Sprintf("Build for '%s'", srpmFile)
+	retryHistory, wasCancelled, err := runBuildAttempts(ctx, retryPolicy, buildAttempts, description, func() (attemptLogFile string, class FailureClass, buildErr error) {
+		builtFiles, logFile, buildErr = agent.BuildPackage(basePackageName, srpmFile, logBaseName, outArch, runCheck, dependencies, expectedFiles, time.Until(deadline))
+		return logFile, "", buildErr
+	})
 	if wasCancelled {
-		err = fmt.Errorf("after %d/%d attempts, the build exceeded the maximum time of %s", attemptNumber, buildAttempts, totalExecutionTimeout)
+		err = fmt.Errorf("after %d attempts, the build exceeded the maximum time of %s", len(retryHistory), totalExecutionTimeout)
 		return
 	}
 
@@ -307,49 +300,41 @@ func buildSRPMFile(agent buildagents.BuildAgent, buildAttempts int, basePackageN
 // testSRPMFile sends an SRPM to a build agent to test.
 // The 'checkFailed' flag says if the package test failed as opposed
 // to the build failing for another reason, which is reflected by a non-nil 'err'.
-func testSRPMFile(agent buildagents.BuildAgent, checkAttempts int, basePackageName string, srpmFile string, outArch string, dependencies []string) (logFile string, checkFailed bool, err error) {
-	const (
-		retryDuration = time.Second
-		runCheck      = true
-	)
+func testSRPMFile(agent buildagents.BuildAgent, checkAttempts int, retryPolicy RetryPolicy, basePackageName string, srpmFile string, outArch string, dependencies []string) (logFile string, checkFailed bool, retryHistory []BuildAttempt, err error) {
+	const runCheck = true
 
 	logBaseName := filepath.Base(srpmFile) + ".test.log"
 
 	// Track the time the build may take, and ensure we don't exceed the maximum limit.
-	attemptNumber := 0
 	totalExecutionTimeout := agent.Config().Timeout
 	deadline := time.Now().Add(totalExecutionTimeout)
 	ctx, cancelFunc := context.WithDeadline(context.Background(), deadline)
 	defer cancelFunc()
 
-	wasCancelled, err := retry.RunWithLinearBackoff(ctx, func() (buildErr error) {
-		if attemptNumber > 0 {
-			logger.Log.Warnf("Test for '%s' failed %d times, retrying up to %d times.", srpmFile, attemptNumber, checkAttempts)
-		}
-		attemptNumber++
-
+	description := fmt.Sprintf("Test for '%s'", srpmFile)
+	retryHistory, wasCancelled, err := runBuildAttempts(ctx, retryPolicy, checkAttempts, description, func() (attemptLogFile string, class FailureClass, buildErr error) {
 		checkFailed = false
 
-		_, logFile, buildErr = agent.BuildPackage(basePackageName, srpmFile, logBaseName, outArch, runCheck, dependencies, time.Until(deadline))
+		_, logFile, buildErr = agent.BuildPackage(basePackageName, srpmFile, logBaseName, outArch, runCheck, dependencies, nil, time.Until(deadline))
 		if buildErr != nil {
 			logger.Log.Warnf("Test build for '%s' failed on a non-test build issue. Error: %s", srpmFile, buildErr)
-			return
+			return logFile, "", buildErr
 		}
 
 		checkFailed, buildErr = parseCheckSection(logFile)
 		// If the build succeeded but tests failed, we still want to retry.
 		if buildErr == nil && checkFailed {
-			buildErr = fmt.Errorf("package test for (%s) failed", basePackageName)
+			return logFile, FailureClassTest, fmt.Errorf("package test for (%s) failed", basePackageName)
 		}
-		return
-	}, checkAttempts, retryDuration)
+		return logFile, "", buildErr
+	})
 	if wasCancelled {
-		err = fmt.Errorf("after %d/%d attempts, the check exceeded the maximum time of %s", attemptNumber, checkAttempts, totalExecutionTimeout)
+		err = fmt.Errorf("after %d attempts, the check exceeded the maximum time of %s", len(retryHistory), totalExecutionTimeout)
 		return
 	}
 
 	if checkFailed {
-		logger.Log.Debugf("Tests failed for '%s' after %d attempt(s).", basePackageName, checkAttempts)
+		logger.Log.Debugf("Tests failed for '%s' after %d attempt(s).", basePackageName, len(retryHistory))
 		err = nil
 	}
 	return
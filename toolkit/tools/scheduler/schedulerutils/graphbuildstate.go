@@ -33,6 +33,7 @@ type GraphBuildState struct {
 	nodeToState         map[*pkggraph.PkgNode]*nodeState
 	maxFreshness        uint
 	failures            []*BuildResult
+	retriedSuccesses    []*BuildResult
 	reservedFiles       map[string]bool
 	conflictingRPMs     map[string]bool
 	conflictingSRPMs    map[string]bool
@@ -195,6 +196,11 @@ func (g *GraphBuildState) BuildFailures() []*BuildResult {
 	return g.failures
 }
 
+// RetriedSuccesses returns the results of the builds and tests which succeeded after failed attempts.
+func (g *GraphBuildState) RetriedSuccesses() []*BuildResult {
+	return g.retriedSuccesses
+}
+
 // ConflictingRPMs will return a list of *.rpm files which should not have been rebuilt.
 // This list is based on the manifest of pre-built toolchain rpms.
 func (g *GraphBuildState) ConflictingRPMs() (rpms []string) {
@@ -241,6 +247,8 @@ func (g *GraphBuildState) RecordBuildResult(res *BuildResult, allowToolchainRebu
 	available := res.Err == nil
 	if !available || res.CheckFailed {
 		g.failures = append(g.failures, res)
+	} else if len(res.RetryHistory) > 0 {
+		g.retriedSuccesses = append(g.retriedSuccesses, res)
 	}
 
 	// 'NodeFreshnessRebuildRequired' is a special value that indicates that the node was rebuilt due to  missing files
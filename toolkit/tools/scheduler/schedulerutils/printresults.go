@@ -44,9 +44,16 @@ func PrintBuildResult(res *BuildResult) {
 
 	if res.Err != nil {
 		logger.Log.Errorf("Failed to build %s, error: %s, for details see: %s", baseSRPMName, res.Err, res.LogFile)
+		if len(res.RetryHistory) > 1 {
+			logger.Log.Errorf("%s failed %d attempt(s): %s", baseSRPMName, len(res.RetryHistory), FormatRetryHistory(res.RetryHistory))
+		}
 		return
 	}
 
+	if len(res.RetryHistory) > 0 && !res.CheckFailed {
+		logger.Log.Warnf("%s succeeded after %d failed attempt(s): %s", baseSRPMName, len(res.RetryHistory), FormatRetryHistory(res.RetryHistory))
+	}
+
 	if res.HasLicenseErrors {
 		logger.Log.Errorf("'%s' has fatal license issues", baseSRPMName)
 	} else if res.HasLicenseWarnings {
@@ -293,6 +300,9 @@ func PrintBuildSummary(pkgGraph *pkggraph.PkgGraph, graphMutex *sync.RWMutex, bu
 		for _, key := range keys {
 			failure := srpmBuildData.failedSRPMs[key]
 			logger.Log.Infof("--> %s , error: %s, for details see: %s", failure.Node.SRPMFileName(), failure.Err, failure.LogFile)
+			if len(failure.RetryHistory) > 0 {
+				logger.Log.Infof("    failed attempts: %s", FormatRetryHistory(failure.RetryHistory))
+			}
 		}
 	}
 
@@ -302,6 +312,20 @@ func PrintBuildSummary(pkgGraph *pkggraph.PkgGraph, graphMutex *sync.RWMutex, bu
 		for _, key := range keys {
 			failure := srpmTestData.failedSRPMsTests[key]
 			logger.Log.Infof("--> %s , for details see: %s", failure.Node.SRPMFileName(), failure.LogFile)
+			if len(failure.RetryHistory) > 0 {
+				logger.Log.Infof("    failed attempts: %s", FormatRetryHistory(failure.RetryHistory))
+			}
+		}
+	}
+
+	if retried := buildState.RetriedSuccesses(); len(retried) != 0 {
+		logger.Log.Info(color.YellowString("SRPMs which succeeded after retries:"))
+		for _, success := range retried {
+			kind := "build"
+			if success.Node.Type == pkggraph.TypeTest {
+				kind = "test"
+			}
+			logger.Log.Infof("--> %s (%s), failed attempts: %s", success.Node.SRPMFileName(), kind, FormatRetryHistory(success.RetryHistory))
 		}
 	}
 
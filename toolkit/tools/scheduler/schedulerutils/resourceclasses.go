@@ -0,0 +1,247 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package schedulerutils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"golang.org/x/sys/unix"
+)
+
+// ResourceClassMacro is the spec macro a package can set to declare its resource class, for example:
+//
+//	%global azl_resource_class large
+const ResourceClassMacro = "azl_resource_class"
+
+var resourceClassMacroRegex = regexp.MustCompile(`^\s*%(?:global|define)\s+` + ResourceClassMacro + `\s+(\S+)\s*$`)
+
+// ResourceClass describes how much of the build host a package build uses.
+type ResourceClass struct {
+	Name         string   `json:"name"`
+	Comment      string   `json:"comment"`
+	MemoryMB     int      `json:"memoryMB"`
+	CPUs         int      `json:"cpus"`
+	PackageNames []string `json:"packageNames"`
+}
+
+// ResourceClassesConfig assigns resource classes to packages. Packages which aren't listed in any class and don't
+// declare a class in their spec use the default class.
+type ResourceClassesConfig struct {
+	DefaultClass string          `json:"defaultClass"`
+	Classes      []ResourceClass `json:"classes"`
+}
+
+// ResourceBudget is the amount of resources the concurrent builds may use together.
+type ResourceBudget struct {
+	MemoryMB int
+	CPUs     int
+}
+
+// ReadResourceClassesConfig reads and validates a resource classes configuration file.
+func ReadResourceClassesConfig(path string) (config *ResourceClassesConfig, err error) {
+	config = &ResourceClassesConfig{}
+	err = jsonutils.ReadJSONFile(path, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource classes file (%s):\n%w", path, err)
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource classes file (%s):\n%w", path, err)
+	}
+
+	return
+}
+
+func (c *ResourceClassesConfig) validate() (err error) {
+	classNames := make(map[string]bool)
+	packageClasses := make(map[string]string)
+	for _, class := range c.Classes {
+		if class.Name == "" {
+			return fmt.Errorf("resource class is missing a name")
+		}
+		if classNames[class.Name] {
+			return fmt.Errorf("resource class (%s) is defined more than once", class.Name)
+		}
+		classNames[class.Name] = true
+
+		if class.MemoryMB < 0 || class.CPUs < 0 {
+			return fmt.Errorf("resource class (%s) has a negative weight", class.Name)
+		}
+
+		for _, packageName := range class.PackageNames {
+			if otherClass, found := packageClasses[packageName]; found {
+				return fmt.Errorf("package (%s) is assigned to both the (%s) and (%s) resource classes", packageName, otherClass, class.Name)
+			}
+			packageClasses[packageName] = class.Name
+		}
+	}
+
+	if !classNames[c.DefaultClass] {
+		return fmt.Errorf("default resource class (%s) is not defined", c.DefaultClass)
+	}
+
+	return
+}
+
+// HostResourceBudget returns the memory and logical CPUs of the build host.
+func HostResourceBudget() (budget ResourceBudget, err error) {
+	var info unix.Sysinfo_t
+	err = unix.Sysinfo(&info)
+	if err != nil {
+		return budget, fmt.Errorf("failed to query the host's memory:\n%w", err)
+	}
+
+	const bytesPerMB = 1024 * 1024
+	budget.MemoryMB = int(uint64(info.Totalram) * uint64(info.Unit) / bytesPerMB)
+	budget.CPUs = runtime.NumCPU()
+
+	return
+}
+
+// ResourceAllocator tracks the resources used by the builds handed to the workers, and decides if another build fits
+// in the budget. A build which exceeds the budget on its own is still allowed when nothing else is running, so it can
+// never block the build forever. A nil allocator places no limits on the builds.
+//
+// ResourceAllocator is not safe for concurrent use.
+type ResourceAllocator struct {
+	budget       ResourceBudget
+	used         ResourceBudget
+	defaultClass ResourceClass
+	// packageClasses maps base package names to the classes assigned by the configuration.
+	packageClasses map[string]ResourceClass
+	classes        map[string]ResourceClass
+	// specClasses caches the classes declared by spec files, by spec path.
+	specClasses map[string]string
+	// allocations holds the class of every allocated build, by node ID.
+	allocations map[int64]ResourceClass
+}
+
+// NewResourceAllocator creates an allocator packing the builds assigned to the configured resource classes into
+// a budget.
+func NewResourceAllocator(config *ResourceClassesConfig, budget ResourceBudget) (allocator *ResourceAllocator) {
+	allocator = &ResourceAllocator{
+		budget:         budget,
+		packageClasses: make(map[string]ResourceClass),
+		classes:        make(map[string]ResourceClass),
+		specClasses:    make(map[string]string),
+		allocations:    make(map[int64]ResourceClass),
+	}
+
+	for _, class := range config.Classes {
+		allocator.classes[class.Name] = class
+		for _, packageName := range class.PackageNames {
+			allocator.packageClasses[packageName] = class
+		}
+	}
+	allocator.defaultClass = allocator.classes[config.DefaultClass]
+
+	return
+}
+
+// TryAllocate reserves the resources of a build request if they fit in the remaining budget. Returns true if the
+// request may be handed to a worker.
+func (r *ResourceAllocator) TryAllocate(req *BuildRequest) bool {
+	if r == nil {
+		return true
+	}
+
+	class := r.RequestClass(req)
+	fits := r.used.MemoryMB+class.MemoryMB <= r.budget.MemoryMB && r.used.CPUs+class.CPUs <= r.budget.CPUs
+	if !fits && len(r.allocations) > 0 {
+		return false
+	}
+
+	if !fits {
+		logger.Log.Warnf("(%s) needs more resources (%d MB, %d CPUs) than the budget (%d MB, %d CPUs), building it alone",
+			req.Node.FriendlyName(), class.MemoryMB, class.CPUs, r.budget.MemoryMB, r.budget.CPUs)
+	}
+
+	r.allocations[req.Node.ID()] = class
+	r.used.MemoryMB += class.MemoryMB
+	r.used.CPUs += class.CPUs
+	logger.Log.Debugf("Allocated resource class (%s) to (%s), %d/%d MB and %d/%d CPUs in use", class.Name,
+		req.Node.FriendlyName(), r.used.MemoryMB, r.budget.MemoryMB, r.used.CPUs, r.budget.CPUs)
+
+	return true
+}
+
+// Release returns the resources reserved for a node's build to the budget.
+func (r *ResourceAllocator) Release(node *pkggraph.PkgNode) {
+	if r == nil {
+		return
+	}
+
+	class, found := r.allocations[node.ID()]
+	if !found {
+		return
+	}
+
+	delete(r.allocations, node.ID())
+	r.used.MemoryMB -= class.MemoryMB
+	r.used.CPUs -= class.CPUs
+}
+
+// RequestClass returns the resource class of a build request. Classes assigned by the configuration take precedence
+// over the ones declared in the specs.
+func (r *ResourceAllocator) RequestClass(req *BuildRequest) ResourceClass {
+	node := req.Node
+	if class, found := r.packageClasses[node.SpecName()]; found {
+		return class
+	}
+
+	className := r.specClass(node.SpecPath)
+	if className == "" {
+		return r.defaultClass
+	}
+
+	class, found := r.classes[className]
+	if !found {
+		logger.Log.Warnf("Spec (%s) declares unknown resource class (%s), using the default class (%s)", node.SpecPath, className, r.defaultClass.Name)
+		return r.defaultClass
+	}
+
+	return class
+}
+
+// specClass returns the resource class declared by a spec file, or an empty string if it doesn't declare one.
+func (r *ResourceAllocator) specClass(specPath string) (className string) {
+	className, found := r.specClasses[specPath]
+	if found {
+		return
+	}
+
+	className, err := readSpecResourceClass(specPath)
+	if err != nil {
+		logger.Log.Debugf("Failed to read the resource class of (%s):\n%s", specPath, err)
+	}
+	r.specClasses[specPath] = className
+
+	return
+}
+
+func readSpecResourceClass(specPath string) (className string, err error) {
+	specFile, err := os.Open(specPath)
+	if err != nil {
+		return
+	}
+	defer specFile.Close()
+
+	scanner := bufio.NewScanner(specFile)
+	for scanner.Scan() {
+		matches := resourceClassMacroRegex.FindStringSubmatch(scanner.Text())
+		if matches != nil {
+			return matches[1], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package schedulerutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkggraph"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/pkgjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResourceClassesConfig() *ResourceClassesConfig {
+	return &ResourceClassesConfig{
+		DefaultClass: "small",
+		Classes: []ResourceClass{
+			{Name: "small", MemoryMB: 1024, CPUs: 1},
+			{Name: "huge", MemoryMB: 16384, CPUs: 8, PackageNames: []string{"llvm"}},
+		},
+	}
+}
+
+func makeResourceRequest(t *testing.T, pkgGraph *pkggraph.PkgGraph, name, specPath string) *BuildRequest {
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: "1.0"}
+	_, err := pkgGraph.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeLocalRun, name+".src.rpm", name+".rpm", specPath, name, "x86_64", "")
+	require.NoError(t, err)
+
+	node, err := pkgGraph.AddPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeLocalBuild, name+".src.rpm", name+".rpm", specPath, name, "x86_64", "")
+	require.NoError(t, err)
+
+	return &BuildRequest{Node: node, AncillaryNodes: []*pkggraph.PkgNode{node}}
+}
+
+func TestResourceAllocatorPacksBuildsIntoBudget(t *testing.T) {
+	pkgGraph := pkggraph.NewPkgGraph()
+	allocator := NewResourceAllocator(testResourceClassesConfig(), ResourceBudget{MemoryMB: 18432, CPUs: 10})
+
+	llvm := makeResourceRequest(t, pkgGraph, "llvm", "llvm.spec")
+	bash := makeResourceRequest(t, pkgGraph, "bash", "bash.spec")
+	zlib := makeResourceRequest(t, pkgGraph, "zlib", "zlib.spec")
+	curl := makeResourceRequest(t, pkgGraph, "curl", "curl.spec")
+
+	assert.True(t, allocator.TryAllocate(llvm))
+	assert.True(t, allocator.TryAllocate(bash))
+	assert.True(t, allocator.TryAllocate(zlib))
+	// Out of memory with llvm, bash and zlib building.
+	assert.False(t, allocator.TryAllocate(curl))
+
+	allocator.Release(bash.Node)
+	assert.True(t, allocator.TryAllocate(curl))
+}
+
+func TestResourceAllocatorAllowsOversizedBuildAlone(t *testing.T) {
+	pkgGraph := pkggraph.NewPkgGraph()
+	allocator := NewResourceAllocator(testResourceClassesConfig(), ResourceBudget{MemoryMB: 4096, CPUs: 4})
+
+	bash := makeResourceRequest(t, pkgGraph, "bash", "bash.spec")
+	llvm := makeResourceRequest(t, pkgGraph, "llvm", "llvm.spec")
+
+	assert.True(t, allocator.TryAllocate(bash))
+	assert.False(t, allocator.TryAllocate(llvm))
+
+	allocator.Release(bash.Node)
+	assert.True(t, allocator.TryAllocate(llvm))
+}
+
+func TestResourceAllocatorNilHasNoLimits(t *testing.T) {
+	var allocator *ResourceAllocator
+	request := makeResourceRequest(t, pkggraph.NewPkgGraph(), "llvm", "llvm.spec")
+
+	assert.True(t, allocator.TryAllocate(request))
+	allocator.Release(request.Node)
+}
+
+func TestResourceAllocatorSpecDeclaredClass(t *testing.T) {
+	specPath := filepath.Join(t.TempDir(), "rust.spec")
+	require.NoError(t, os.WriteFile(specPath, []byte("Name: rust\n%global azl_resource_class huge\nVersion: 1.0\n"), 0o644))
+
+	pkgGraph := pkggraph.NewPkgGraph()
+	allocator := NewResourceAllocator(testResourceClassesConfig(), ResourceBudget{MemoryMB: 65536, CPUs: 64})
+
+	assert.Equal(t, "huge", allocator.RequestClass(makeResourceRequest(t, pkgGraph, "rust", specPath)).Name)
+	assert.Equal(t, "small", allocator.RequestClass(makeResourceRequest(t, pkgGraph, "bash", "missing/bash.spec")).Name)
+}
+
+func TestReadResourceClassesConfigRejectsDuplicatePackages(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "resource-classes.json")
+	content := `{
+  "defaultClass": "small",
+  "classes": [
+    { "name": "small", "memoryMB": 1024, "cpus": 1, "packageNames": [ "llvm" ] },
+    { "name": "huge", "memoryMB": 16384, "cpus": 8, "packageNames": [ "llvm" ] }
+  ]
+}`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0o644))
+
+	_, err := ReadResourceClassesConfig(configPath)
+	assert.ErrorContains(t, err, "package (llvm) is assigned to both")
+}
+
+func TestReadResourceClassesConfigShippedFile(t *testing.T) {
+	config, err := ReadResourceClassesConfig("../../../resources/manifests/package/resource-classes.json")
+	require.NoError(t, err)
+	assert.NotEmpty(t, config.Classes)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/specarchchecker"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -32,6 +33,7 @@ var (
 	releaseVersionMacrosFile = app.Flag("versions-macro-file", "File containing release and version macros for all SPECS to use while parsing specs.").ExistingFile()
 
 	testOnly = app.Flag("test-only", "Whether or not to run the filter out specs which don't run tests.").Bool()
+	rootless = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 
 	logFlags = exe.SetupLogFlags(app)
 )
@@ -41,6 +43,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	packagesToBuild := exe.ParseListArgument(*pkgsToBuild)
 	packagesToRebuild := exe.ParseListArgument(*pkgsToRebuild)
 
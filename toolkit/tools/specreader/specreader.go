@@ -12,6 +12,7 @@ import (
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
 	packagelist "github.com/microsoft/azurelinux/toolkit/tools/internal/packlist"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/timestamp"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/profile"
 	"github.com/microsoft/azurelinux/toolkit/tools/pkg/specreaderutils"
@@ -29,6 +30,11 @@ var (
 	specsDir                 = exe.InputDirFlag(app, "Directory to scan for SPECS")
 	specList                 = app.Flag("spec-list", "List of SPECs to parse. If empty will parse all SPECs.").Default("").String()
 	output                   = exe.OutputFlag(app, "Output file to export the JSON")
+	parseReport              = app.Flag("parse-report", "Output file to export a JSON report of the SPECs which failed to parse.").String()
+	allowParseFailures       = app.Flag("allow-parse-failures", "Write the output for all the valid SPECs even if some SPECs failed to parse.").Bool()
+	macroDiagnostics         = app.Flag("macro-diagnostics", "Instead of the dependencies of the SPECs, export the macros each SPEC depends on, which of them are undefined, and how the variants change the parsed SPECs.").Bool()
+	diagnosticsArches        = app.Flag("diagnostics-arch", "Architecture to compare the parsed SPECs with in --macro-diagnostics mode. May be repeated.").Strings()
+	diagnosticsDefines       = app.Flag("diagnostics-define", "Macro definition ('name=value') to compare the parsed SPECs with in --macro-diagnostics mode. May be repeated.").Strings()
 	releaseVersionMacrosFile = app.Flag("versions-macro-file", "File containing release and version macros for all SPECS to use while parsing specs.").ExistingFile()
 	workers                  = app.Flag("workers", "Number of concurrent goroutines to parse with").Default(defaultWorkerCount).Int()
 	buildDir                 = app.Flag("build-dir", "Directory to store temporary files while parsing.").String()
@@ -40,6 +46,7 @@ var (
 	workerTar                = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz.  If this argument is empty, specs will be parsed in the host environment.").ExistingFile()
 	targetArch               = app.Flag("target-arch", "The architecture of the machine the RPM binaries run on").String()
 	runCheck                 = app.Flag("run-check", "Whether or not to run the spec file's check section during package build.").Bool()
+	rootless                 = app.Flag("rootless", "Whether to run the worker chroots within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 	logFlags                 = exe.SetupLogFlags(app)
 	profFlags                = exe.SetupProfileFlags(app)
 	timestampFile            = app.Flag("timestamp-file", "File that stores timestamps for this program.").String()
@@ -50,6 +57,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
@@ -75,6 +87,15 @@ func main() {
 	specsAbsDir, err := filepath.Abs(*specsDir)
 	logger.PanicOnError(err, "Unable to get absolute path for specs directory '%s': %s", *specsDir, err)
 
-	err = specreaderutils.ParseSPECsWrapper(*buildDir, specsAbsDir, *rpmsDir, *srpmsDir, *existingToolchainRpmDir, *distTag, *output, *workerTar, *releaseVersionMacrosFile, *targetArch, specListSet, toolchainRPMs, *workers, *runCheck)
+	if *macroDiagnostics {
+		variants, err := specreaderutils.NewMacroVariants(*diagnosticsArches, *diagnosticsDefines)
+		logger.PanicOnError(err)
+
+		err = specreaderutils.DiagnoseSPECMacrosWrapper(*buildDir, specsAbsDir, *srpmsDir, *distTag, *output, *workerTar, *releaseVersionMacrosFile, *targetArch, specListSet, variants, *workers)
+		logger.PanicOnError(err)
+		return
+	}
+
+	err = specreaderutils.ParseSPECsWrapper(*buildDir, specsAbsDir, *rpmsDir, *srpmsDir, *existingToolchainRpmDir, *distTag, *output, *parseReport, *workerTar, *releaseVersionMacrosFile, *targetArch, specListSet, toolchainRPMs, *workers, *runCheck, *allowParseFailures)
 	logger.PanicOnError(err)
 }
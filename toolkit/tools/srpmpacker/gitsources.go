@@ -0,0 +1,484 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	gitProgram = "git"
+	gitPackage = "git"
+
+	// gitSourcePrefix marks a SPEC source which is generated from a git repository, e.g.
+	// "Source0: git+https://github.com/org/project.git?commit=<full commit hash>#/project-1.0.tar.gz".
+	gitSourcePrefix = "git+"
+	// gitCommitQueryKey is the query parameter of a git source which pins its commit.
+	gitCommitQueryKey = "commit"
+	// gitFileNameFragmentPrefix precedes the name of the generated tarball, which is also how rpm names the source.
+	gitFileNameFragmentPrefix = "/"
+)
+
+var (
+	// specSourceRegex matches the "SourceN:" tags of a parsed SPEC.
+	specSourceRegex = regexp.MustCompile(`(?mi)^Source\d*\s*:\s*(\S+)\s*$`)
+	// gitCommitRegex only matches full SHA-1 or SHA-256 commit hashes, since branches, tags and abbreviated hashes
+	// may point at different commits over time.
+	gitCommitRegex = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+	installGitOnce sync.Once
+	installGitErr  error
+)
+
+// gitSource is a SPEC source which is a tarball of a git repository at a pinned commit.
+type gitSource struct {
+	fileName string
+	repoURL  string
+	commit   string
+}
+
+// readSPECGitSources returns the sources of a SPEC which are generated from git repositories, keyed by file name.
+func readSPECGitSources(specFile, sourceDir string, defines map[string]string) (gitSources map[string]gitSource, err error) {
+	// Avoid parsing SPECs which can't have git sources.
+	contents, err := os.ReadFile(specFile)
+	if err != nil {
+		return
+	}
+	if !strings.Contains(string(contents), gitSourcePrefix) {
+		return
+	}
+
+	arch, err := rpm.GetRpmArch(runtime.GOARCH)
+	if err != nil {
+		return
+	}
+
+	parsedSpec, err := rpm.ParseSPEC(specFile, sourceDir, arch, defines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SPEC (%s):\n%w", specFile, err)
+	}
+
+	gitSources = make(map[string]gitSource)
+	for _, match := range specSourceRegex.FindAllStringSubmatch(parsedSpec, -1) {
+		if !strings.HasPrefix(match[1], gitSourcePrefix) {
+			continue
+		}
+
+		source, parseErr := parseGitSource(match[1])
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid git source in SPEC (%s):\n%w", specFile, parseErr)
+		}
+		gitSources[source.fileName] = source
+	}
+
+	return
+}
+
+// parseGitSource parses a source of the form "git+<repository URL>?commit=<commit>#/<tarball name>".
+func parseGitSource(sourceURL string) (source gitSource, err error) {
+	parsedURL, err := url.Parse(strings.TrimPrefix(sourceURL, gitSourcePrefix))
+	if err != nil {
+		return source, fmt.Errorf("failed to parse git source (%s):\n%w", sourceURL, err)
+	}
+
+	source.commit = strings.ToLower(parsedURL.Query().Get(gitCommitQueryKey))
+	if !gitCommitRegex.MatchString(source.commit) {
+		return source, fmt.Errorf("git source (%s) must be pinned to a full commit hash with '?%s=<hash>'", sourceURL, gitCommitQueryKey)
+	}
+
+	source.fileName = strings.TrimPrefix(parsedURL.Fragment, gitFileNameFragmentPrefix)
+	if !strings.HasPrefix(parsedURL.Fragment, gitFileNameFragmentPrefix) || source.fileName == "" || strings.Contains(source.fileName, "/") {
+		return source, fmt.Errorf("git source (%s) must end with the name of its tarball, e.g. '#/project-1.0.tar.gz'", sourceURL)
+	}
+
+	_, err = tarballPrefix(source.fileName)
+	if err != nil {
+		return source, err
+	}
+
+	parsedURL.RawQuery = ""
+	parsedURL.Fragment = ""
+	source.repoURL = parsedURL.String()
+
+	return
+}
+
+// hydrateFromGitSources generates the missing git sources, and will update fileHydrationState.
+// Will alter `currentSignatures`.
+func hydrateFromGitSources(ctx context.Context, fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration, skipSignatureHandling bool, currentSignatures map[string]string, gitSources map[string]gitSource, downloader *sourceDownloader) (err error) {
+	for fileName, alreadyHydrated := range fileHydrationState {
+		source, isGitSource := gitSources[fileName]
+		if alreadyHydrated || !isGitSource {
+			continue
+		}
+
+		err = ensureGitInstalled(srcConfig.packingInChroot)
+		if err != nil {
+			return
+		}
+
+		destinationFile := filepath.Join(newSourceDir, fileName)
+		internalErr := downloader.withNetOp(ctx, func() error {
+			return createGitSourceTarball(ctx, source, filepath.Dir(newSourceDir), destinationFile)
+		})
+		if internalErr != nil {
+			logger.Log.Errorf("Failed to generate (%s) from (%s) at commit (%s). Error: %s.", fileName, source.repoURL, source.commit, internalErr)
+			continue
+		}
+
+		if !skipSignatureHandling {
+			internalErr = validateSignature(destinationFile, srcConfig, currentSignatures)
+			if internalErr != nil {
+				logger.Log.Errorf("Signature validation for (%s) failed. Error: %s.", destinationFile, internalErr)
+
+				// If the delete fails, just warn as there will be another cleanup
+				// attempt when exiting the program.
+				internalErr = os.Remove(destinationFile)
+				if internalErr != nil {
+					logger.Log.Warnf("Failed to delete file (%s) after signature validation failure. Error: %s.", destinationFile, internalErr)
+				}
+				continue
+			}
+		}
+
+		fileHydrationState[fileName] = true
+		logger.Log.Debugf("Hydrated (%s) from (%s) at commit (%s)", fileName, source.repoURL, source.commit)
+	}
+
+	return
+}
+
+// ensureGitInstalled installs git into the chroot the first time it's needed. Packing on the host requires git to
+// already be installed.
+func ensureGitInstalled(canInstall bool) error {
+	_, err := exec.LookPath(gitProgram)
+	if err == nil {
+		return nil
+	}
+
+	if !canInstall {
+		return fmt.Errorf("%s is required to generate sources from git repositories:\n%w", gitProgram, err)
+	}
+
+	installGitOnce.Do(func() {
+		const rootDir = "/"
+
+		logger.Log.Infof("Installing (%s) to generate sources from git repositories", gitPackage)
+		_, installGitErr = installutils.TdnfInstall(gitPackage, rootDir)
+		if installGitErr != nil {
+			installGitErr = fmt.Errorf("failed to install '%s':\n%w", gitPackage, installGitErr)
+		}
+	})
+
+	return installGitErr
+}
+
+// createGitSourceTarball clones the git source at its pinned commit into a temporary directory under workingDir, and
+// archives it into a deterministic tarball at dstFile.
+func createGitSourceTarball(ctx context.Context, source gitSource, workingDir, dstFile string) (err error) {
+	cloneDir, err := os.MkdirTemp(workingDir, "git-")
+	if err != nil {
+		return fmt.Errorf("failed to create a directory to clone (%s) into:\n%w", source.repoURL, err)
+	}
+	defer os.RemoveAll(cloneDir)
+
+	commitTime, err := cloneGitSource(ctx, source, cloneDir)
+	if err != nil {
+		return
+	}
+
+	prefix, err := tarballPrefix(source.fileName)
+	if err != nil {
+		return
+	}
+
+	return writeDeterministicTarball(cloneDir, prefix, commitTime, dstFile)
+}
+
+// cloneGitSource checks out the pinned commit of the source, with its submodules, into cloneDir. Returns the time of
+// the commit.
+func cloneGitSource(ctx context.Context, source gitSource, cloneDir string) (commitTime time.Time, err error) {
+	runGit := func(args ...string) (stdout string, gitErr error) {
+		args = append([]string{"-C", cloneDir}, args...)
+		stdout, stderr, gitErr := shell.ExecuteContext(ctx, gitProgram, args...)
+		if gitErr != nil {
+			gitErr = fmt.Errorf("'%s %s' failed:\n%v\n%w", gitProgram, strings.Join(args, " "), stderr, gitErr)
+		}
+		return strings.TrimSpace(stdout), gitErr
+	}
+
+	_, err = runGit("init", "-q")
+	if err != nil {
+		return
+	}
+
+	_, err = runGit("remote", "add", "origin", source.repoURL)
+	if err != nil {
+		return
+	}
+
+	// Most servers allow fetching a single commit, which is much faster than fetching the whole history.
+	_, err = runGit("fetch", "-q", "--depth", "1", "origin", source.commit)
+	if err != nil {
+		logger.Log.Debugf("Failed to fetch commit (%s) alone, fetching the whole repository:\n%s", source.commit, err)
+		_, err = runGit("fetch", "-q", "origin")
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = runGit("checkout", "-q", "--detach", source.commit)
+	if err != nil {
+		return
+	}
+
+	head, err := runGit("rev-parse", "HEAD")
+	if err != nil {
+		return
+	}
+	if head != source.commit {
+		err = fmt.Errorf("checked out commit (%s) of (%s) instead of the pinned commit (%s)", head, source.repoURL, source.commit)
+		return
+	}
+
+	_, err = runGit("submodule", "update", "-q", "--init", "--recursive")
+	if err != nil {
+		return
+	}
+
+	timestampString, err := runGit("show", "-s", "--format=%ct", "HEAD")
+	if err != nil {
+		return
+	}
+
+	timestamp, err := strconv.ParseInt(timestampString, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("invalid commit time (%s) of (%s):\n%w", timestampString, source.commit, err)
+		return
+	}
+
+	return time.Unix(timestamp, 0), nil
+}
+
+// tarballPrefix returns the top-level directory of a generated tarball, which is the name of the tarball without its
+// extension (e.g. "project-1.0" for "project-1.0.tar.gz"), as expected by '%autosetup'.
+func tarballPrefix(fileName string) (prefix string, err error) {
+	for _, extension := range []string{".tar.gz", ".tgz", ".tar.xz", ".tar"} {
+		if strings.HasSuffix(fileName, extension) && len(fileName) > len(extension) {
+			return strings.TrimSuffix(fileName, extension), nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported git source tarball (%s), must be a '.tar.gz', '.tgz', '.tar.xz' or '.tar' file", fileName)
+}
+
+// writeDeterministicTarball archives srcDir under prefix, without its git metadata. The tarball only depends on the
+// contents of srcDir: entries are sorted, all of them have the given modification time, are owned by root, and only
+// keep the executable bit of their permissions. The compression doesn't record a name or time either.
+func writeDeterministicTarball(srcDir, prefix string, modTime time.Time, dstFile string) (err error) {
+	dst, err := os.Create(dstFile)
+	if err != nil {
+		return fmt.Errorf("failed to create tarball (%s):\n%w", dstFile, err)
+	}
+	defer func() {
+		closeErr := dst.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			file.RemoveFileIfExists(dstFile)
+		}
+	}()
+
+	var compressor io.WriteCloser
+	switch {
+	case strings.HasSuffix(dstFile, ".tar.gz"), strings.HasSuffix(dstFile, ".tgz"):
+		compressor, err = gzip.NewWriterLevel(dst, gzip.BestCompression)
+	case strings.HasSuffix(dstFile, ".tar.xz"):
+		compressor, err = xz.NewWriter(dst)
+	default:
+		compressor = nopWriteCloser{dst}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create compressor for (%s):\n%w", dstFile, err)
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+
+	// WalkDir visits the entries in lexical order.
+	err = filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		// Skip the repository's and the submodules' metadata.
+		if entry.Name() == ".git" {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := deterministicTarHeader(path, entry, filepath.ToSlash(filepath.Join(prefix, relPath)), modTime)
+		if err != nil {
+			return err
+		}
+
+		err = tarWriter.WriteHeader(header)
+		if err != nil || header.Typeflag != tar.TypeReg {
+			return err
+		}
+
+		return copyFileInto(tarWriter, path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive (%s):\n%w", srcDir, err)
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return
+	}
+
+	return compressor.Close()
+}
+
+// deterministicTarHeader creates the tar header of an entry of writeDeterministicTarball().
+func deterministicTarHeader(path string, entry fs.DirEntry, name string, modTime time.Time) (header *tar.Header, err error) {
+	const (
+		rootName           = "root"
+		dirMode            = 0o755
+		executableFileMode = 0o755
+		fileMode           = 0o644
+		symlinkMode        = 0o777
+	)
+
+	info, err := entry.Info()
+	if err != nil {
+		return
+	}
+
+	header = &tar.Header{
+		Name:    name,
+		ModTime: modTime,
+		Uname:   rootName,
+		Gname:   rootName,
+	}
+
+	switch {
+	case info.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Mode = dirMode
+	case info.Mode()&fs.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		header.Mode = symlinkMode
+		header.Linkname, err = os.Readlink(path)
+	case info.Mode().IsRegular():
+		header.Typeflag = tar.TypeReg
+		header.Size = info.Size()
+		header.Mode = fileMode
+		if info.Mode()&0o111 != 0 {
+			header.Mode = executableFileMode
+		}
+	default:
+		err = fmt.Errorf("unsupported file type of (%s)", path)
+	}
+
+	return
+}
+
+func copyFileInto(writer io.Writer, path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	_, err = io.Copy(writer, src)
+	return
+}
+
+// recordGitSourcesInChangelog adds the commits of the git sources to the latest changelog entry of the SPEC, so the
+// SRPM records what it was generated from.
+func recordGitSourcesInChangelog(specFile string, gitSources map[string]gitSource) (err error) {
+	const changelogSection = "%changelog"
+
+	contents, err := os.ReadFile(specFile)
+	if err != nil {
+		return
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	latestEntry := -1
+	inChangelog := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == changelogSection {
+			inChangelog = true
+			continue
+		}
+
+		if inChangelog && strings.HasPrefix(line, "*") {
+			latestEntry = i
+			break
+		}
+	}
+
+	if latestEntry == -1 {
+		logger.Log.Warnf("SPEC (%s) has no changelog entry to record its git sources in", specFile)
+		return
+	}
+
+	fileNames := make([]string, 0, len(gitSources))
+	for fileName := range gitSources {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	newLines := append([]string{}, lines[:latestEntry+1]...)
+	for _, fileName := range fileNames {
+		source := gitSources[fileName]
+		newLines = append(newLines, fmt.Sprintf("- Generated %s from %s at commit %s", fileName, source.repoURL, source.commit))
+	}
+	newLines = append(newLines, lines[latestEntry+1:]...)
+
+	return file.Write(strings.Join(newLines, "\n"), specFile)
+}
+
+// nopWriteCloser adds a no-op Close() to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,275 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGitCommit     = "0123456789abcdef0123456789abcdef01234567"
+	testGitRepoURL    = "https://github.com/org/project.git"
+	testGitSourceName = "project-1.0.tar.gz"
+)
+
+func TestParseGitSource(t *testing.T) {
+	source, err := parseGitSource("git+" + testGitRepoURL + "?commit=" + testGitCommit + "#/" + testGitSourceName)
+	require.NoError(t, err)
+	assert.Equal(t, gitSource{fileName: testGitSourceName, repoURL: testGitRepoURL, commit: testGitCommit}, source)
+}
+
+func TestParseGitSourceSHA256Commit(t *testing.T) {
+	const sha256Commit = testGitCommit + "0123456789abcdef01234567"
+
+	source, err := parseGitSource("git+" + testGitRepoURL + "?commit=" + sha256Commit + "#/" + testGitSourceName)
+	require.NoError(t, err)
+	assert.Equal(t, sha256Commit, source.commit)
+}
+
+func TestParseGitSourceUppercaseCommit(t *testing.T) {
+	source, err := parseGitSource("git+" + testGitRepoURL + "?commit=0123456789ABCDEF0123456789ABCDEF01234567#/" + testGitSourceName)
+	require.NoError(t, err)
+	assert.Equal(t, testGitCommit, source.commit)
+}
+
+func TestParseGitSourceDropsOtherQueryParameters(t *testing.T) {
+	source, err := parseGitSource("git+" + testGitRepoURL + "?ref=main&commit=" + testGitCommit + "#/" + testGitSourceName)
+	require.NoError(t, err)
+	assert.Equal(t, testGitRepoURL, source.repoURL)
+}
+
+func TestParseGitSourceInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		sourceURL   string
+		errContains string
+	}{
+		{"no commit", "git+" + testGitRepoURL + "#/" + testGitSourceName, "must be pinned to a full commit hash"},
+		{"branch", "git+" + testGitRepoURL + "?commit=main#/" + testGitSourceName, "must be pinned to a full commit hash"},
+		{"abbreviated commit", "git+" + testGitRepoURL + "?commit=0123456#/" + testGitSourceName, "must be pinned to a full commit hash"},
+		{"no tarball name", "git+" + testGitRepoURL + "?commit=" + testGitCommit, "must end with the name of its tarball"},
+		{"tarball name without slash", "git+" + testGitRepoURL + "?commit=" + testGitCommit + "#" + testGitSourceName, "must end with the name of its tarball"},
+		{"empty tarball name", "git+" + testGitRepoURL + "?commit=" + testGitCommit + "#/", "must end with the name of its tarball"},
+		{"tarball in directory", "git+" + testGitRepoURL + "?commit=" + testGitCommit + "#/dir/" + testGitSourceName, "must end with the name of its tarball"},
+		{"unsupported tarball", "git+" + testGitRepoURL + "?commit=" + testGitCommit + "#/project-1.0.zip", "unsupported git source tarball"},
+		{"invalid URL", "git+https://[invalid?commit=" + testGitCommit + "#/" + testGitSourceName, "failed to parse git source"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseGitSource(test.sourceURL)
+			assert.ErrorContains(t, err, test.errContains)
+		})
+	}
+}
+
+func TestTarballPrefix(t *testing.T) {
+	tests := []struct {
+		fileName string
+		prefix   string
+	}{
+		{"project-1.0.tar.gz", "project-1.0"},
+		{"project-1.0.tgz", "project-1.0"},
+		{"project-1.0.tar.xz", "project-1.0"},
+		{"project-1.0.tar", "project-1.0"},
+		{"project.tar.gz.tar", "project.tar.gz"},
+	}
+
+	for _, test := range tests {
+		prefix, err := tarballPrefix(test.fileName)
+		assert.NoError(t, err, test.fileName)
+		assert.Equal(t, test.prefix, prefix, test.fileName)
+	}
+
+	for _, fileName := range []string{".tar.gz", ".tar", "project-1.0.zip", "project-1.0.tar.bz2", "project"} {
+		_, err := tarballPrefix(fileName)
+		assert.ErrorContains(t, err, "unsupported git source tarball", fileName)
+	}
+}
+
+// createTestGitCheckout creates a directory looking like a git checkout, with the given modification time and umask
+// dependent permissions on its files.
+func createTestGitCheckout(t *testing.T, fileMode os.FileMode, modTime time.Time) string {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"README.md":         "readme",
+		"src/main.c":        "int main() { return 0; }",
+		"src/lib/lib.c":     "void lib() {}",
+		".git/HEAD":         testGitCommit,
+		"sub/.git":          "gitdir: ../.git/modules/sub",
+		"sub/submodule.txt": "submodule",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), fileMode))
+	}
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "configure"), []byte("#!/bin/sh"), fileMode|0o111))
+	require.NoError(t, os.Symlink("src/main.c", filepath.Join(dir, "main.c")))
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		return os.Chtimes(path, modTime, modTime)
+	})
+	require.NoError(t, err)
+
+	return dir
+}
+
+func TestWriteDeterministicTarballIsReproducible(t *testing.T) {
+	commitTime := time.Unix(1700000000, 0)
+
+	for _, fileName := range []string{"project-1.0.tar.gz", "project-1.0.tar.xz", "project-1.0.tar"} {
+		t.Run(fileName, func(t *testing.T) {
+			firstDir := createTestGitCheckout(t, 0o644, time.Now())
+			secondDir := createTestGitCheckout(t, 0o600, time.Now().Add(-time.Hour))
+
+			firstTarball := filepath.Join(t.TempDir(), fileName)
+			err := writeDeterministicTarball(firstDir, "project-1.0", commitTime, firstTarball)
+			require.NoError(t, err)
+
+			secondTarball := filepath.Join(t.TempDir(), fileName)
+			err = writeDeterministicTarball(secondDir, "project-1.0", commitTime, secondTarball)
+			require.NoError(t, err)
+
+			firstContents, err := os.ReadFile(firstTarball)
+			require.NoError(t, err)
+			secondContents, err := os.ReadFile(secondTarball)
+			require.NoError(t, err)
+			assert.Equal(t, firstContents, secondContents)
+		})
+	}
+}
+
+func TestWriteDeterministicTarballContents(t *testing.T) {
+	commitTime := time.Unix(1700000000, 0)
+	srcDir := createTestGitCheckout(t, 0o600, time.Now())
+
+	tarball := filepath.Join(t.TempDir(), testGitSourceName)
+	err := writeDeterministicTarball(srcDir, "project-1.0", commitTime, tarball)
+	require.NoError(t, err)
+
+	tarballFile, err := os.Open(tarball)
+	require.NoError(t, err)
+	defer tarballFile.Close()
+
+	gzipReader, err := gzip.NewReader(tarballFile)
+	require.NoError(t, err)
+	assert.Empty(t, gzipReader.Name)
+	assert.True(t, gzipReader.ModTime.IsZero())
+
+	var names []string
+	headers := map[string]*tar.Header{}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		names = append(names, header.Name)
+		headers[header.Name] = header
+		assert.True(t, commitTime.Equal(header.ModTime), header.Name)
+		assert.Equal(t, "root", header.Uname, header.Name)
+		assert.Equal(t, "root", header.Gname, header.Name)
+		assert.Equal(t, 0, header.Uid, header.Name)
+		assert.Equal(t, 0, header.Gid, header.Name)
+	}
+
+	assert.Equal(t, []string{
+		"project-1.0/",
+		"project-1.0/README.md",
+		"project-1.0/configure",
+		"project-1.0/main.c",
+		"project-1.0/src/",
+		"project-1.0/src/lib/",
+		"project-1.0/src/lib/lib.c",
+		"project-1.0/src/main.c",
+		"project-1.0/sub/",
+		"project-1.0/sub/submodule.txt",
+	}, names)
+
+	assert.Equal(t, int64(0o755), headers["project-1.0/src/"].Mode)
+	assert.Equal(t, int64(0o644), headers["project-1.0/README.md"].Mode)
+	assert.Equal(t, int64(0o755), headers["project-1.0/configure"].Mode)
+	assert.Equal(t, byte(tar.TypeSymlink), headers["project-1.0/main.c"].Typeflag)
+	assert.Equal(t, "src/main.c", headers["project-1.0/main.c"].Linkname)
+}
+
+func TestRecordGitSourcesInChangelog(t *testing.T) {
+	const spec = `Name: project
+Version: 1.0
+Source0: git+https://github.com/org/project.git?commit=0123456789abcdef0123456789abcdef01234567#/project-1.0.tar.gz
+
+%description
+* Not a changelog entry.
+
+%changelog
+* Mon Jan 01 2024 Someone <someone@example.com> - 1.0-2
+- Latest change.
+
+* Sun Dec 31 2023 Someone <someone@example.com> - 1.0-1
+- Initial version.
+`
+
+	specFile := filepath.Join(t.TempDir(), "project.spec")
+	require.NoError(t, os.WriteFile(specFile, []byte(spec), 0o644))
+
+	gitSources := map[string]gitSource{
+		"tests-1.0.tar.gz": {fileName: "tests-1.0.tar.gz", repoURL: "https://github.com/org/tests.git", commit: "fedcba9876543210fedcba9876543210fedcba98"},
+		testGitSourceName:  {fileName: testGitSourceName, repoURL: testGitRepoURL, commit: testGitCommit},
+	}
+
+	err := recordGitSourcesInChangelog(specFile, gitSources)
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(specFile)
+	require.NoError(t, err)
+	assert.Equal(t, `Name: project
+Version: 1.0
+Source0: git+https://github.com/org/project.git?commit=0123456789abcdef0123456789abcdef01234567#/project-1.0.tar.gz
+
+%description
+* Not a changelog entry.
+
+%changelog
+* Mon Jan 01 2024 Someone <someone@example.com> - 1.0-2
+- Generated project-1.0.tar.gz from https://github.com/org/project.git at commit 0123456789abcdef0123456789abcdef01234567
+- Generated tests-1.0.tar.gz from https://github.com/org/tests.git at commit fedcba9876543210fedcba9876543210fedcba98
+- Latest change.
+
+* Sun Dec 31 2023 Someone <someone@example.com> - 1.0-1
+- Initial version.
+`, string(contents))
+}
+
+func TestRecordGitSourcesInChangelogWithoutEntry(t *testing.T) {
+	const spec = "Name: project\n\n%changelog\n"
+
+	specFile := filepath.Join(t.TempDir(), "project.spec")
+	require.NoError(t, os.WriteFile(specFile, []byte(spec), 0o644))
+
+	err := recordGitSourcesInChangelog(specFile, map[string]gitSource{
+		testGitSourceName: {fileName: testGitSourceName, repoURL: testGitRepoURL, commit: testGitCommit},
+	})
+	require.NoError(t, err)
+
+	contents, err := os.ReadFile(specFile)
+	require.NoError(t, err)
+	assert.Equal(t, spec, string(contents))
+}
@@ -0,0 +1,219 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/azureblobstorage"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/network"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/retry"
+)
+
+const (
+	// sourceRetryDelay is the delay before the first retry of a source download, doubled for each further retry.
+	sourceRetryDelay = 2 * time.Second
+	// maxSourceRetryDelay caps the delay between two retries of a source download.
+	maxSourceRetryDelay = time.Minute
+)
+
+var (
+	errPackerCancelReceived    = errors.New("packer cancel signal received")
+	errSourceRetryBudget       = errors.New("source download retry budget exhausted")
+	errSourceDownloadTimeLimit = errors.New("source download time budget exhausted")
+)
+
+// sourceDownloader downloads the missing sources of all the SPECs being packed. The downloads of all the SPECs share
+// a limited number of concurrent network operations, a list of mirrors tried in order, a retry budget and a deadline,
+// so a flaky URL is retried instead of failing the whole pack. Failed HTTP downloads are resumed on the next attempt.
+type sourceDownloader struct {
+	// ctx is done once the time budget of the downloads is used up.
+	ctx      context.Context
+	mirrors  []string
+	selector *network.MirrorSelector
+	caCerts  *x509.CertPool
+	tlsCerts []tls.Certificate
+	// azureClients are the clients of each mirror (see network.MirrorOf()), when downloading with the Azure CLI's
+	// credentials.
+	azureClients    map[string]*azureblobstorage.AzureBlobStorage
+	netOpsSemaphore chan struct{}
+	retryBudget     *retry.Budget
+}
+
+// newSourceDownloader creates a downloader for the sources of all the SPECs. The downloads share the retry budget of
+// srcConfig, and fail once its time budget is used up (zero for no limit). The returned cancel function releases the
+// downloader's context.
+func newSourceDownloader(ctx context.Context, srcConfig sourceRetrievalConfiguration, concurrentNetOps uint) (downloader *sourceDownloader, cancel context.CancelFunc, err error) {
+	if srcConfig.downloadTimeBudget > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, srcConfig.downloadTimeBudget, errSourceDownloadTimeLimit)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	downloader = &sourceDownloader{
+		ctx:             ctx,
+		mirrors:         srcConfig.sourceURLs,
+		selector:        network.NewOrderedMirrorSelector(),
+		caCerts:         srcConfig.caCerts,
+		tlsCerts:        srcConfig.tlsCerts,
+		netOpsSemaphore: make(chan struct{}, concurrentNetOps),
+		retryBudget:     retry.NewBudget(srcConfig.downloadRetryBudget),
+	}
+
+	if srcConfig.sourceAuthMode == sourceAuthModeAzureCli {
+		// Create the Azure Blob Storage clients once for all downloads
+		downloader.azureClients = make(map[string]*azureblobstorage.AzureBlobStorage)
+		for _, mirror := range downloader.mirrors {
+			downloader.azureClients[network.MirrorOf(mirror)], err = azureblobstorage.CreateFromURL(mirror)
+			if err != nil {
+				cancel()
+				return nil, nil, fmt.Errorf("failed to create Azure Blob Storage client for (%s):\n%w", mirror, err)
+			}
+		}
+	}
+
+	return
+}
+
+// Download concurrently downloads the files into dstDir. Returns the error of each file which couldn't be downloaded.
+// Stops early if ctx is done, e.g. because the packer is cancelled.
+func (d *sourceDownloader) Download(ctx context.Context, fileNames []string, dstDir string) (failures map[string]error) {
+	failures = make(map[string]error)
+	failuresMutex := sync.Mutex{}
+
+	wg := sync.WaitGroup{}
+	for _, fileName := range fileNames {
+		wg.Add(1)
+		go func(fileName string) {
+			defer wg.Done()
+
+			err := d.downloadWithRetry(ctx, fileName, filepath.Join(dstDir, fileName))
+			if err != nil {
+				failuresMutex.Lock()
+				defer failuresMutex.Unlock()
+				failures[fileName] = err
+			}
+		}(fileName)
+	}
+	wg.Wait()
+
+	return
+}
+
+// downloadWithRetry downloads a file from the first mirror that has it. A failed attempt is retried with an
+// exponential backoff while the retry budget lasts, unless the file is missing from every mirror.
+func (d *sourceDownloader) downloadWithRetry(ctx context.Context, fileName, dstFile string) (err error) {
+	ctx, cancel := mergeContexts(ctx, d.ctx)
+	defer cancel()
+
+	urls := make([]string, 0, len(d.mirrors))
+	for _, mirror := range d.mirrors {
+		urls = append(urls, network.JoinURL(mirror, fileName))
+	}
+
+	for attempt := 1; ; attempt++ {
+		// Limit the number of concurrent network operations by pushing a struct{} into the channel. This will block
+		// until another operation completes and removes the struct{} from the channel.
+		select {
+		case d.netOpsSemaphore <- struct{}{}:
+		case <-ctx.Done():
+			return d.contextError(err)
+		}
+
+		var all404 bool
+		all404, err = d.selector.TryMirrors(ctx, urls, func(srcUrl string) error {
+			return d.downloadFromMirror(ctx, srcUrl, dstFile)
+		})
+
+		// Clear the channel to allow another operation to start
+		<-d.netOpsSemaphore
+
+		switch {
+		case err == nil:
+			logger.Log.Debugf("Downloaded (%s) after %d attempt(s)", fileName, attempt)
+			return nil
+		case all404:
+			return fmt.Errorf("(%s) is missing from all %d source server(s):\n%w", fileName, len(urls), err)
+		case ctx.Err() != nil:
+			return d.contextError(err)
+		case !d.retryBudget.Take():
+			return fmt.Errorf("%w, giving up on (%s) after %d attempt(s):\n%w", errSourceRetryBudget, fileName, attempt, err)
+		}
+
+		delay := min(sourceRetryDelay<<(attempt-1), maxSourceRetryDelay)
+		logger.Log.Infof("Attempt %d: failed to download (%s), retrying in %s (%d retries left):\n%s", attempt, fileName, delay, d.retryBudget.Remaining(), err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return d.contextError(err)
+		}
+	}
+}
+
+// withNetOp runs a network operation, once fewer than the maximum number of concurrent network operations are running.
+func (d *sourceDownloader) withNetOp(ctx context.Context, op func() error) error {
+	select {
+	case d.netOpsSemaphore <- struct{}{}:
+	case <-ctx.Done():
+		return errPackerCancelReceived
+	}
+	defer func() { <-d.netOpsSemaphore }()
+
+	return op()
+}
+
+// downloadFromMirror makes a single attempt to download a file from a mirror.
+func (d *sourceDownloader) downloadFromMirror(ctx context.Context, srcUrl, dstFile string) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, network.DefaultTimeout)
+	defer cancel()
+
+	if d.azureClients == nil {
+		return network.DownloadFileResume(ctx, srcUrl, dstFile, d.caCerts, d.tlsCerts)
+	}
+
+	_, containerName, blobName, err := azureblobstorage.ParseAzureBlobStorageURL(srcUrl)
+	if err != nil {
+		return fmt.Errorf("failed to parse source URL:\n%w", err)
+	}
+
+	err = d.azureClients[network.MirrorOf(srcUrl)].Download(ctx, containerName, blobName, dstFile)
+	if azureblobstorage.IsNotFoundError(err) {
+		// A missing file isn't held against the mirror, and isn't retried.
+		return fmt.Errorf("%w:\n%w", network.ErrDownloadFileInvalidResponse404, err)
+	}
+
+	return
+}
+
+// contextError explains why the download of a file stopped early.
+func (d *sourceDownloader) contextError(lastErr error) error {
+	if errors.Is(context.Cause(d.ctx), errSourceDownloadTimeLimit) {
+		if lastErr != nil {
+			return fmt.Errorf("%w:\n%w", errSourceDownloadTimeLimit, lastErr)
+		}
+		return errSourceDownloadTimeLimit
+	}
+
+	return errPackerCancelReceived
+}
+
+// mergeContexts returns a context which is done once either of the contexts is done.
+func mergeContexts(ctx, other context.Context) (merged context.Context, cancel context.CancelFunc) {
+	merged, cancel = context.WithCancel(ctx)
+	stop := context.AfterFunc(other, cancel)
+
+	return merged, func() {
+		stop()
+		cancel()
+	}
+}
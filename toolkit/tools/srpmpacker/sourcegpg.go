@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+)
+
+const (
+	gpgProgram  = "gpg"
+	gpgvProgram = "gpgv"
+
+	// sourceKeyringFileName is the keyring built from the configured keys, which gpgv verifies the sources against.
+	sourceKeyringFileName = "source-keyring.gpg"
+	// sourceKeyringInChroot is where the keyring is made available when packing inside a chroot.
+	sourceKeyringInChroot = "/etc/pki/srpmpacker/" + sourceKeyringFileName
+)
+
+// createSourceKeyring imports the keys (armored or binary) into a new keyring in buildDir, since gpgv only accepts
+// binary keyrings. Returns the path to the keyring.
+func createSourceKeyring(keyFiles []string, buildDir string) (keyringPath string, err error) {
+	err = os.MkdirAll(buildDir, os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("failed to create build directory (%s):\n%w", buildDir, err)
+	}
+
+	// Use a throw-away home directory, so the user's own keys and trust database are neither used nor modified.
+	gpgHomeDir, err := os.MkdirTemp(buildDir, "gpg-home-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary GPG home directory:\n%w", err)
+	}
+	defer os.RemoveAll(gpgHomeDir)
+
+	keyringPath = filepath.Join(buildDir, sourceKeyringFileName)
+	err = file.RemoveFileIfExists(keyringPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove stale keyring (%s):\n%w", keyringPath, err)
+	}
+
+	args := []string{"--batch", "--homedir", gpgHomeDir, "--no-default-keyring", "--keyring", keyringPath, "--import"}
+	args = append(args, keyFiles...)
+
+	_, stderr, err := shell.Execute(gpgProgram, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to import source signing keys %v:\n%v\n%w", keyFiles, stderr, err)
+	}
+
+	logger.Log.Infof("Verifying detached source signatures against %d key file(s)", len(keyFiles))
+	return
+}
+
+// verifyDetachedSignatures checks the detached GPG signature of each source declared in srcConfig.gpgSignatureLookup.
+// Both the source and its signature must have been hydrated into sourceDir. Failures, including a missing keyring, are
+// fatal unless the signature handling is set to warn.
+func verifyDetachedSignatures(sourceDir string, srcConfig sourceRetrievalConfiguration) (err error) {
+	if srcConfig.signatureHandling == signatureSkipCheck || len(srcConfig.gpgSignatureLookup) == 0 {
+		return
+	}
+
+	// Declared signatures that can't be verified are treated like bad signatures, so that a missing keyring can't
+	// silently disable the verification.
+	if srcConfig.sourceKeyring == "" {
+		if srcConfig.signatureHandling == signatureWarn {
+			logger.Log.Warnf("No source keyring configured, not verifying the GPG signatures of %d source(s) in (%s)", len(srcConfig.gpgSignatureLookup), srcConfig.localSourceDir)
+			return
+		}
+
+		err = fmt.Errorf("no source keyring configured to verify the GPG signatures of %d source(s) in (%s)", len(srcConfig.gpgSignatureLookup), srcConfig.localSourceDir)
+		return
+	}
+
+	// Sort for a stable order of the log messages.
+	sourceNames := make([]string, 0, len(srcConfig.gpgSignatureLookup))
+	for sourceName := range srcConfig.gpgSignatureLookup {
+		sourceNames = append(sourceNames, sourceName)
+	}
+	sort.Strings(sourceNames)
+
+	failedSources := []string{}
+	for _, sourceName := range sourceNames {
+		signatureName := srcConfig.gpgSignatureLookup[sourceName]
+		verifyErr := verifyDetachedSignature(srcConfig.sourceKeyring, filepath.Join(sourceDir, sourceName), filepath.Join(sourceDir, signatureName))
+		if verifyErr == nil {
+			logger.Log.Debugf("Verified GPG signature (%s) of (%s)", signatureName, sourceName)
+			continue
+		}
+
+		if srcConfig.signatureHandling == signatureWarn {
+			logger.Log.Warnf("GPG signature verification of (%s) failed:\n%s", sourceName, verifyErr)
+			continue
+		}
+
+		logger.Log.Errorf("GPG signature verification of (%s) failed:\n%s", sourceName, verifyErr)
+		failedSources = append(failedSources, sourceName)
+	}
+
+	if len(failedSources) != 0 {
+		err = fmt.Errorf("GPG signature verification failed for sources: %v", failedSources)
+	}
+
+	return
+}
+
+// verifyDetachedSignature checks the detached (armored or binary) signature of a file with gpgv.
+func verifyDetachedSignature(keyring, signedFile, signatureFile string) (err error) {
+	for _, path := range []string{signedFile, signatureFile} {
+		exists, err := file.PathExists(path)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("(%s) is not a source of the SPEC, or it couldn't be hydrated", filepath.Base(path))
+		}
+	}
+
+	_, stderr, err := shell.Execute(gpgvProgram, "--keyring", keyring, signatureFile, signedFile)
+	if err != nil {
+		return fmt.Errorf("bad signature (%s):\n%v\n%w", filepath.Base(signatureFile), stderr, err)
+	}
+
+	return
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSourceName    = "source.tar.gz"
+	testSignatureName = "source.tar.gz.asc"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// createSignedSource generates a signing key and a source signed with it in a temporary directory. Returns the source
+// directory and the exported public key file.
+func createSignedSource(t *testing.T) (sourceDir, keyFile string) {
+	if _, err := exec.LookPath(gpgProgram); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	if _, err := exec.LookPath(gpgvProgram); err != nil {
+		t.Skip("gpgv is not installed")
+	}
+
+	dir := t.TempDir()
+	gpgHomeDir := filepath.Join(dir, "gpg-home")
+	err := os.Mkdir(gpgHomeDir, 0o700)
+	require.NoError(t, err)
+	defer shell.Execute("gpgconf", "--homedir", gpgHomeDir, "--kill", "gpg-agent")
+
+	sourceDir = filepath.Join(dir, "sources")
+	err = os.Mkdir(sourceDir, os.ModePerm)
+	require.NoError(t, err)
+
+	sourcePath := filepath.Join(sourceDir, testSourceName)
+	err = os.WriteFile(sourcePath, []byte("source contents"), 0o644)
+	require.NoError(t, err)
+
+	_, stderr, err := shell.Execute(gpgProgram, "--batch", "--homedir", gpgHomeDir, "--passphrase", "",
+		"--quick-gen-key", "srpmpacker-test@example.com", "ed25519", "sign", "never")
+	require.NoError(t, err, stderr)
+
+	_, stderr, err = shell.Execute(gpgProgram, "--batch", "--homedir", gpgHomeDir, "--armor", "--detach-sign",
+		"--output", filepath.Join(sourceDir, testSignatureName), sourcePath)
+	require.NoError(t, err, stderr)
+
+	keyFile = filepath.Join(dir, "key.asc")
+	_, stderr, err = shell.Execute(gpgProgram, "--batch", "--homedir", gpgHomeDir, "--armor", "--output", keyFile,
+		"--export", "srpmpacker-test@example.com")
+	require.NoError(t, err, stderr)
+
+	return sourceDir, keyFile
+}
+
+func gpgSourceConfig(signatureHandling signatureHandlingType, keyring string) sourceRetrievalConfiguration {
+	return sourceRetrievalConfiguration{
+		signatureHandling:  signatureHandling,
+		gpgSignatureLookup: map[string]string{testSourceName: testSignatureName},
+		sourceKeyring:      keyring,
+	}
+}
+
+func TestVerifyDetachedSignaturesEnforce(t *testing.T) {
+	sourceDir, keyFile := createSignedSource(t)
+
+	keyring, err := createSourceKeyring([]string{keyFile}, t.TempDir())
+	require.NoError(t, err)
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureEnforce, keyring))
+	assert.NoError(t, err)
+}
+
+func TestVerifyDetachedSignaturesBadSignature(t *testing.T) {
+	sourceDir, keyFile := createSignedSource(t)
+
+	keyring, err := createSourceKeyring([]string{keyFile}, t.TempDir())
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(sourceDir, testSourceName), []byte("tampered contents"), 0o644)
+	require.NoError(t, err)
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureEnforce, keyring))
+	assert.ErrorContains(t, err, "GPG signature verification failed for sources: ["+testSourceName+"]")
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureUpdate, keyring))
+	assert.ErrorContains(t, err, "GPG signature verification failed for sources")
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureWarn, keyring))
+	assert.NoError(t, err)
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureSkipCheck, keyring))
+	assert.NoError(t, err)
+}
+
+func TestVerifyDetachedSignaturesMissingSignature(t *testing.T) {
+	sourceDir, keyFile := createSignedSource(t)
+
+	keyring, err := createSourceKeyring([]string{keyFile}, t.TempDir())
+	require.NoError(t, err)
+
+	err = os.Remove(filepath.Join(sourceDir, testSignatureName))
+	require.NoError(t, err)
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureEnforce, keyring))
+	assert.ErrorContains(t, err, "GPG signature verification failed for sources")
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureWarn, keyring))
+	assert.NoError(t, err)
+}
+
+func TestVerifyDetachedSignaturesMissingKeyring(t *testing.T) {
+	sourceDir := t.TempDir()
+
+	err := verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureEnforce, ""))
+	assert.ErrorContains(t, err, "no source keyring configured to verify the GPG signatures of 1 source(s)")
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureUpdate, ""))
+	assert.ErrorContains(t, err, "no source keyring configured")
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureWarn, ""))
+	assert.NoError(t, err)
+
+	err = verifyDetachedSignatures(sourceDir, gpgSourceConfig(signatureSkipCheck, ""))
+	assert.NoError(t, err)
+
+	// SPECs without detached signatures don't need a keyring.
+	srcConfig := gpgSourceConfig(signatureEnforce, "")
+	srcConfig.gpgSignatureLookup = nil
+	err = verifyDetachedSignatures(sourceDir, srcConfig)
+	assert.NoError(t, err)
+}
@@ -7,24 +7,25 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/microsoft/azurelinux/toolkit/tools/imagegen/installutils"
-	"github.com/microsoft/azurelinux/toolkit/tools/internal/azureblobstorage"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/buildpipeline"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/directory"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/file"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/jsonutils"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
-	"github.com/microsoft/azurelinux/toolkit/tools/internal/network"
 	packagelist "github.com/microsoft/azurelinux/toolkit/tools/internal/packlist"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/rpm"
 	"github.com/microsoft/azurelinux/toolkit/tools/internal/safechroot"
@@ -36,6 +37,8 @@ import (
 
 type fileSignaturesWrapper struct {
 	FileSignatures map[string]string `json:"Signatures"`
+	// GPGSignatures maps a source to its detached GPG signature, which must also be a source of the SPEC.
+	GPGSignatures map[string]string `json:"GPGSignatures,omitempty"`
 }
 
 const (
@@ -57,12 +60,14 @@ const (
 	signatureEnforce   signatureHandlingType = iota
 	signatureSkipCheck signatureHandlingType = iota
 	signatureUpdate    signatureHandlingType = iota
+	signatureWarn      signatureHandlingType = iota
 )
 
 const (
 	signatureEnforceString   = "enforce"
 	signatureSkipCheckString = "skip"
 	signatureUpdateString    = "update"
+	signatureWarnString      = "warn"
 )
 
 type sourceAuthModeType int
@@ -81,17 +86,31 @@ const (
 	defaultBuildDir    = "./build/SRPMS"
 	defaultWorkerCount = "80"
 	defaultNetOpsCount = "10"
+	// defaultSourceRetryBudget is the number of retries shared by all source downloads.
+	defaultSourceRetryBudget = "100"
 )
 
 // sourceRetrievalConfiguration holds information on where to hydrate files from.
 type sourceRetrievalConfiguration struct {
 	localSourceDir string
-	sourceURL      string
-	caCerts        *x509.CertPool
-	tlsCerts       []tls.Certificate
+	// sourceURLs are the source servers, tried in order.
+	sourceURLs []string
+	caCerts    *x509.CertPool
+	tlsCerts   []tls.Certificate
 
-	signatureHandling signatureHandlingType
-	signatureLookup   map[string]string
+	// downloadRetryBudget is the number of retries shared by all source downloads.
+	downloadRetryBudget int
+	// downloadTimeBudget limits the total time spent downloading sources, zero for no limit.
+	downloadTimeBudget time.Duration
+
+	signatureHandling  signatureHandlingType
+	signatureLookup    map[string]string
+	gpgSignatureLookup map[string]string
+	// sourceKeyring is the keyring detached GPG signatures are verified against, empty to not verify them.
+	sourceKeyring string
+
+	// packingInChroot is set when packing inside a chroot, where missing tools may be installed.
+	packingInChroot bool
 
 	sourceAuthMode sourceAuthModeType
 }
@@ -116,6 +135,7 @@ var (
 
 	specsDir      = exe.InputDirFlag(app, "Path to the SPEC directory to create SRPMs from.")
 	outDir        = exe.OutputDirFlag(app, "Directory to place the output SRPM.")
+	rootless      = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 	logFlags      = exe.SetupLogFlags(app)
 	profFlags     = exe.SetupProfileFlags(app)
 	timestampFile = app.Flag("timestamp-file", "File that stores timestamps for this program.").String()
@@ -127,20 +147,24 @@ var (
 
 	workers                  = app.Flag("workers", "Number of concurrent goroutines to parse with.").Default(defaultWorkerCount).Uint()
 	concurrentNetOps         = app.Flag("concurrent-net-ops", "Number of concurrent network operations to perform.").Default(defaultNetOpsCount).Uint()
+	sourceRetryBudget        = app.Flag("source-retry-budget", "Number of retries shared by all source downloads, before failing the SPECs whose sources are still missing.").Default(defaultSourceRetryBudget).Int()
+	sourceDownloadTimeout    = app.Flag("source-download-timeout", "Total time allowed for downloading sources, 0 for no limit.").Default("0").Duration()
 	repackAll                = app.Flag("repack", "Rebuild all SRPMs, even if already built.").Bool()
 	nestedSourcesDir         = app.Flag("nested-sources", "Set if for a given SPEC, its sources are contained in a SOURCES directory next to the SPEC file.").Bool()
 	releaseVersionMacrosFile = app.Flag("versions-macro-file", "File containing release and version macros for all SPECS to use while packing SRPMs.").ExistingFile()
 
 	// Use String() and not ExistingFile() as the Makefile may pass an empty string if the user did not specify any of these options
-	sourceURL     = app.Flag("source-url", "URL to a source server to download SPEC sources from.").String()
+	sourceURLs    = app.Flag("source-url", "URL to a source server to download SPEC sources from. May be repeated, the servers are tried in order.").Strings()
 	caCertFile    = app.Flag("ca-cert", "Root certificate authority to use when downloading files.").String()
 	tlsClientCert = app.Flag("tls-cert", "TLS client certificate to use when downloading files.").String()
 	tlsClientKey  = app.Flag("tls-key", "TLS client key to use when downloading files.").String()
 
+	sourceKeyFiles = app.Flag("source-keyring", "GPG public key file (armored or binary) to verify the detached signatures of sources against. May be repeated. Required to pack SPECs that declare detached signatures, unless --signature-handling is warn or skip.").ExistingFiles()
+
 	workerTar = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz. If this argument is empty, SRPMs will be packed in the host environment.").ExistingFile()
 
-	validSignatureLevels = []string{signatureEnforceString, signatureSkipCheckString, signatureUpdateString}
-	signatureHandling    = app.Flag("signature-handling", "Specifies how to handle signature mismatches for source files.").Default(signatureEnforceString).PlaceHolder(exe.PlaceHolderize(validSignatureLevels)).Enum(validSignatureLevels...)
+	validSignatureLevels = []string{signatureEnforceString, signatureSkipCheckString, signatureUpdateString, signatureWarnString}
+	signatureHandling    = app.Flag("signature-handling", "Specifies how to handle checksum mismatches and bad GPG signatures of source files.").Default(signatureEnforceString).PlaceHolder(exe.PlaceHolderize(validSignatureLevels)).Enum(validSignatureLevels...)
 
 	validSourceAuthModes = []string{sourceAuthModeAnonymousString, sourceAuthModeAzureCliString}
 	sourceAuthMode       = app.Flag("source-auth-mode", "Authentication mode for source download: anonymous or azurecli.").Default(sourceAuthModeAnonymousString).PlaceHolder(exe.PlaceHolderize(validSourceAuthModes)).Enum(validSourceAuthModes...)
@@ -151,6 +175,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
@@ -174,12 +203,22 @@ func main() {
 	case signatureUpdateString:
 		logger.Log.Warn("Will update signature files as needed")
 		templateSrcConfig.signatureHandling = signatureUpdate
+	case signatureWarnString:
+		logger.Log.Warn("Source signature mismatches will only be reported as warnings")
+		templateSrcConfig.signatureHandling = signatureWarn
 	default:
 		logger.Log.Fatalf("Invalid signature handling encountered: %s. Allowed: %s", *signatureHandling, validSignatureLevels)
 	}
 
 	// Setup remote source configuration
-	templateSrcConfig.sourceURL = *sourceURL
+	// The Makefile may pass empty URLs if the user did not specify a source server.
+	for _, url := range *sourceURLs {
+		if url != "" {
+			templateSrcConfig.sourceURLs = append(templateSrcConfig.sourceURLs, url)
+		}
+	}
+	templateSrcConfig.downloadRetryBudget = *sourceRetryBudget
+	templateSrcConfig.downloadTimeBudget = *sourceDownloadTimeout
 	templateSrcConfig.caCerts, err = x509.SystemCertPool()
 	logger.PanicOnError(err, "Received error calling x509.SystemCertPool(). Error: %v", err)
 	if *caCertFile != "" {
@@ -209,6 +248,11 @@ func main() {
 		logger.Log.Fatalf("Invalid download mode encountered: %s. Allowed: %s", *sourceAuthMode, validSourceAuthModes)
 	}
 
+	if len(*sourceKeyFiles) != 0 && templateSrcConfig.signatureHandling != signatureSkipCheck {
+		templateSrcConfig.sourceKeyring, err = createSourceKeyring(*sourceKeyFiles, *buildDir)
+		logger.PanicOnError(err)
+	}
+
 	timestamp.StopEvent(nil)
 
 	// A pack list may be provided, if so only pack this subset.
@@ -234,6 +278,15 @@ func createAllSRPMsWrapper(specsDir, distTag, buildDir, outDir, workerTar, relea
 			return
 		}
 		defer chroot.Close(leaveFilesOnDisk)
+
+		if templateSrcConfig.sourceKeyring != "" {
+			err = chroot.AddFiles(safechroot.FileToCopy{Src: templateSrcConfig.sourceKeyring, Dest: sourceKeyringInChroot})
+			if err != nil {
+				return fmt.Errorf("failed to add the source keyring to the chroot:\n%w", err)
+			}
+			templateSrcConfig.sourceKeyring = sourceKeyringInChroot
+		}
+		templateSrcConfig.packingInChroot = true
 	}
 
 	doCreateAll := func() error {
@@ -332,19 +385,25 @@ func createChroot(workerTar, buildDir, outDir, specsDir, releaseVersionMacrosFil
 	timestamp.StartEvent("create chroot", nil)
 	defer timestamp.StopEvent(nil)
 
-	extraMountPoints := []*safechroot.MountPoint{
-		safechroot.NewMountPoint(outDir, outMountPoint, "", safechroot.BindMountPointFlags, ""),
-		safechroot.NewMountPoint(specsDir, specsMountPoint, "", safechroot.BindMountPointFlags, ""),
+	extraMountSpecs := []safechroot.MountSpec{
+		{Source: outDir, Target: outMountPoint, Bind: true},
+		{Source: specsDir, Target: specsMountPoint, Bind: true},
 	}
 
 	// Adding the .azure mount ensures the chroot environment can access CLI credentials
 	if useAzureCliAuth {
-		extraMountPoints, err = addAzureConfigMountPoint(extraMountPoints)
+		extraMountSpecs, err = addAzureConfigMountSpec(extraMountSpecs)
 		if err != nil {
 			return
 		}
 	}
 
+	extraMountPoints, err := safechroot.NewMountPointsFromSpecs(extraMountSpecs)
+	if err != nil {
+		err = fmt.Errorf("failed to create chroot mount points:\n%w", err)
+		return
+	}
+
 	extraDirectories := []string{
 		buildDirInChroot,
 	}
@@ -405,8 +464,8 @@ func createChroot(workerTar, buildDir, outDir, specsDir, releaseVersionMacrosFil
 	return
 }
 
-// addAzureConfigMountPoint appends a mount point for the Azure CLI config directory.
-func addAzureConfigMountPoint(extraMountPoints []*safechroot.MountPoint) ([]*safechroot.MountPoint, error) {
+// addAzureConfigMountSpec appends a mount spec for the Azure CLI config directory.
+func addAzureConfigMountSpec(extraMountSpecs []safechroot.MountSpec) ([]safechroot.MountSpec, error) {
 	const (
 		chrootAzureConfigMountPoint = "/root/.azure"
 	)
@@ -424,8 +483,8 @@ func addAzureConfigMountPoint(extraMountPoints []*safechroot.MountPoint) ([]*saf
 		mountPoint = chrootAzureConfigMountPoint
 	}
 
-	extraMountPoints = append(extraMountPoints, safechroot.NewMountPoint(azureConfigDir, mountPoint, "", safechroot.BindMountPointFlags, ""))
-	return extraMountPoints, nil
+	extraMountSpecs = append(extraMountSpecs, safechroot.MountSpec{Source: azureConfigDir, Target: mountPoint, Bind: true})
+	return extraMountSpecs, nil
 }
 
 func installAzureCliPackage(chroot *safechroot.Chroot) (err error) {
@@ -628,6 +687,7 @@ func specsToPackWorker(ctx context.Context, requests <-chan string, results chan
 }
 
 // packSRPMs will pack any SPEC files that have been marked as `toPack`.
+// A SPEC which fails to pack doesn't stop the others, all failures are reported once every SPEC has been processed.
 func packSRPMs(specStates []*specState, distTag, buildDir string, templateSrcConfig sourceRetrievalConfiguration, workers, concurrentNetOps uint) (err error) {
 	tsRoot, _ := timestamp.StartEvent("packing SRPMs", nil)
 	defer timestamp.StopEvent(nil)
@@ -635,14 +695,20 @@ func packSRPMs(specStates []*specState, distTag, buildDir string, templateSrcCon
 
 	allSpecStates := make(chan *specState, len(specStates))
 	results := make(chan *packResult, len(specStates))
-	netOpsSemaphore := make(chan struct{}, concurrentNetOps)
 	ctx, closeCtx := context.WithCancel(context.Background())
 	defer closeCtx()
 
+	// The sources of all SPECs are downloaded with the same mirrors and budgets.
+	downloader, closeDownloader, err := newSourceDownloader(ctx, templateSrcConfig, concurrentNetOps)
+	if err != nil {
+		return
+	}
+	defer closeDownloader()
+
 	// Start the workers now so they begin working as soon as a new job is buffered.
 	for i := 0; uint(i) < workers; i++ {
 		wg.Add(1)
-		go packSRPMWorker(ctx, allSpecStates, results, netOpsSemaphore, &wg, distTag, buildDir, templateSrcConfig, tsRoot)
+		go packSRPMWorker(ctx, allSpecStates, results, downloader, &wg, distTag, buildDir, templateSrcConfig, tsRoot)
 	}
 
 	for _, state := range specStates {
@@ -652,14 +718,14 @@ func packSRPMs(specStates []*specState, distTag, buildDir string, templateSrcCon
 	// Signal to the workers that there are no more new spec files
 	close(allSpecStates)
 
+	failedSpecs := []string{}
 	for i := 0; i < len(specStates); i++ {
 		result := <-results
 
 		if result.err != nil {
-			logger.Log.Errorf("Failed to pack (%s). Cancelling outstanding workers. Error: %s", result.specFile, result.err)
-			err = result.err
-			closeCtx()
-			break
+			logger.Log.Errorf("Failed to pack (%s). Error: %s", result.specFile, result.err)
+			failedSpecs = append(failedSpecs, filepath.Base(result.specFile))
+			continue
 		}
 
 		// Skip results for states that were not packed by request
@@ -673,11 +739,16 @@ func packSRPMs(specStates []*specState, distTag, buildDir string, templateSrcCon
 	logger.Log.Debug("Waiting for outstanding workers to finish")
 	wg.Wait()
 
+	if len(failedSpecs) != 0 {
+		sort.Strings(failedSpecs)
+		err = fmt.Errorf("failed to pack %d SPEC(s): %v", len(failedSpecs), failedSpecs)
+	}
+
 	return
 }
 
 // packSRPMWorker will process a channel of SPECs and pack any that are marked as toPack.
-func packSRPMWorker(ctx context.Context, allSpecStates <-chan *specState, results chan<- *packResult, netOpsSemaphore chan struct{}, wg *sync.WaitGroup, distTag, buildDir string, templateSrcConfig sourceRetrievalConfiguration, tsRoot *timestamp.TimeStamp) {
+func packSRPMWorker(ctx context.Context, allSpecStates <-chan *specState, results chan<- *packResult, downloader *sourceDownloader, wg *sync.WaitGroup, distTag, buildDir string, templateSrcConfig sourceRetrievalConfiguration, tsRoot *timestamp.TimeStamp) {
 	defer wg.Done()
 
 	for specState := range allSpecStates {
@@ -718,7 +789,7 @@ func packSRPMWorker(ctx context.Context, allSpecStates <-chan *specState, result
 			continue
 		}
 
-		outputPath, err := packSingleSPEC(ctx, specState.specFile, specState.srpmFile, signaturesFilePath, buildDir, fullOutDirPath, distTag, srcConfig, netOpsSemaphore)
+		outputPath, err := packSingleSPEC(ctx, specState.specFile, specState.srpmFile, signaturesFilePath, buildDir, fullOutDirPath, distTag, srcConfig, downloader)
 		if err != nil {
 			result.err = err
 			results <- result
@@ -751,13 +822,13 @@ func initializeSourceConfig(templateSrcConfig sourceRetrievalConfiguration, sign
 
 	// Read the signatures file for the SPEC sources if applicable
 	if srcConfig.signatureHandling != signatureSkipCheck {
-		srcConfig.signatureLookup, err = readSignatures(signaturesFilePath)
+		srcConfig.signatureLookup, srcConfig.gpgSignatureLookup, err = readSignatures(signaturesFilePath)
 	}
 
 	return srcConfig, err
 }
 
-func readSignatures(signaturesFilePath string) (readSignatures map[string]string, err error) {
+func readSignatures(signaturesFilePath string) (readSignatures, readGPGSignatures map[string]string, err error) {
 	var signaturesWrapper fileSignaturesWrapper
 	signaturesWrapper.FileSignatures = make(map[string]string)
 
@@ -772,11 +843,11 @@ func readSignatures(signaturesFilePath string) (readSignatures map[string]string
 		}
 	}
 
-	return signaturesWrapper.FileSignatures, err
+	return signaturesWrapper.FileSignatures, signaturesWrapper.GPGSignatures, err
 }
 
 // packSingleSPEC will pack a given SPEC file into an SRPM.
-func packSingleSPEC(ctx context.Context, specFile, srpmFile, signaturesFile, buildDir, outDir, distTag string, srcConfig sourceRetrievalConfiguration, netOpsSemaphore chan struct{}) (outputPath string, err error) {
+func packSingleSPEC(ctx context.Context, specFile, srpmFile, signaturesFile, buildDir, outDir, distTag string, srcConfig sourceRetrievalConfiguration, downloader *sourceDownloader) (outputPath string, err error) {
 	srpmName := filepath.Base(srpmFile)
 	workingDir := filepath.Join(buildDir, srpmName)
 
@@ -808,21 +879,36 @@ func packSingleSPEC(ctx context.Context, specFile, srpmFile, signaturesFile, bui
 	defines := rpm.DefaultDistroDefines(*runCheck, distTag)
 
 	// Hydrate all patches. Exclusively using `sourceDir`
-	err = hydrateFiles(ctx, fileTypePatch, specFile, workingDir, srcConfig, currentSignatures, defines, nil)
+	err = hydrateFiles(ctx, fileTypePatch, specFile, workingDir, srcConfig, currentSignatures, defines, nil, nil)
+	if err != nil {
+		return
+	}
+
+	gitSources, err := readSPECGitSources(specFile, srcConfig.localSourceDir, defines)
 	if err != nil {
 		return
 	}
 
-	// Hydrate all sources. Download any missing ones not in `sourceDir`
-	err = hydrateFiles(ctx, fileTypeSource, specFile, workingDir, srcConfig, currentSignatures, defines, netOpsSemaphore)
+	// Hydrate all sources. Download any missing ones not in `sourceDir`, or generate them from their git repositories
+	err = hydrateFiles(ctx, fileTypeSource, specFile, workingDir, srcConfig, currentSignatures, defines, downloader, gitSources)
 	if err != nil {
 		return
 	}
 
 	err = updateSignaturesIfApplicable(signaturesFile, srcConfig, currentSignatures)
 
+	// Pack the copy of the SPEC if the commits of its git sources must be recorded in it.
+	specToPack := specFile
+	if len(gitSources) != 0 {
+		err = recordGitSourcesInChangelog(srpmSpecFile, gitSources)
+		if err != nil {
+			return
+		}
+		specToPack = srpmSpecFile
+	}
+
 	// Build the SRPM itself, using `workingDir` as the topdir
-	err = rpm.GenerateSRPMFromSPEC(specFile, workingDir, defines)
+	err = rpm.GenerateSRPMFromSPEC(specToPack, workingDir, defines)
 	if err != nil {
 		return
 	}
@@ -838,6 +924,7 @@ func updateSignaturesIfApplicable(signaturesFile string, srcConfig sourceRetriev
 
 		outputSignatures := fileSignaturesWrapper{
 			FileSignatures: currentSignatures,
+			GPGSignatures:  srcConfig.gpgSignatureLookup,
 		}
 
 		err = jsonutils.WriteJSONFile(signaturesFile, outputSignatures)
@@ -876,7 +963,7 @@ func readSPECTagArray(specFile, sourceDir, tag string, arch string, defines map[
 
 // hydrateFiles will attempt to retrieve all sources needed to build an SRPM from a SPEC.
 // Will alter `currentSignatures`,
-func hydrateFiles(ctx context.Context, fileTypeToHydrate fileType, specFile, workingDir string, srcConfig sourceRetrievalConfiguration, currentSignatures, defines map[string]string, netOpsSemaphore chan struct{}) (err error) {
+func hydrateFiles(ctx context.Context, fileTypeToHydrate fileType, specFile, workingDir string, srcConfig sourceRetrievalConfiguration, currentSignatures, defines map[string]string, downloader *sourceDownloader, gitSources map[string]gitSource) (err error) {
 	const (
 		downloadMissingPatchFiles = false
 		skipPatchSignatures       = true
@@ -935,8 +1022,16 @@ func hydrateFiles(ctx context.Context, fileTypeToHydrate fileType, specFile, wor
 		}
 	}
 
-	if hydrateRemotely && srcConfig.sourceURL != "" {
-		err = hydrateFromRemoteSource(ctx, fileHydrationState, newSourceDir, srcConfig, skipSignatureHandling, currentSignatures, netOpsSemaphore)
+	if hydrateRemotely && len(srcConfig.sourceURLs) != 0 {
+		err = hydrateFromRemoteSource(ctx, fileHydrationState, newSourceDir, srcConfig, skipSignatureHandling, currentSignatures, downloader)
+		if err != nil {
+			return
+		}
+	}
+
+	// Sources the source servers don't have yet are generated from their git repositories.
+	if len(gitSources) != 0 {
+		err = hydrateFromGitSources(ctx, fileHydrationState, newSourceDir, srcConfig, skipSignatureHandling, currentSignatures, gitSources, downloader)
 		if err != nil {
 			return
 		}
@@ -952,6 +1047,11 @@ func hydrateFiles(ctx context.Context, fileTypeToHydrate fileType, specFile, wor
 
 	if len(missingFiles) != 0 {
 		err = fmt.Errorf("unable to hydrate files: %v", missingFiles)
+		return
+	}
+
+	if fileTypeToHydrate == fileTypeSource {
+		err = verifyDetachedSignatures(newSourceDir, srcConfig)
 	}
 
 	return
@@ -1003,70 +1103,33 @@ func tryToHydrateFromLocalSource(fileHydrationState map[string]bool, newSourceDi
 	})
 }
 
-// hydrateFromRemoteSource will update fileHydrationState.
+// hydrateFromRemoteSource downloads all missing files concurrently, and will update fileHydrationState.
 // Will alter `currentSignatures`.
-func hydrateFromRemoteSource(ctx context.Context, fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration, skipSignatureHandling bool, currentSignatures map[string]string, netOpsSemaphore chan struct{}) (err error) {
-	var (
-		azureBlobStorageClient *azureblobstorage.AzureBlobStorage
-		cancelled              bool
-		internalErr            error
-	)
-
-	errPackerCancelReceived := fmt.Errorf("packer cancel signal received")
-
-	if srcConfig.sourceAuthMode == sourceAuthModeAzureCli {
-		// Create the Azure Blob Storage client once for all downloads
-		azureBlobStorageClient, err = azureblobstorage.CreateFromURL(srcConfig.sourceURL)
-		if err != nil {
-			return fmt.Errorf("failed to create Azure Blob Storage client:\n%w", err)
-		}
-	}
-
+func hydrateFromRemoteSource(ctx context.Context, fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration, skipSignatureHandling bool, currentSignatures map[string]string, downloader *sourceDownloader) (err error) {
+	filesToDownload := []string{}
 	for fileName, alreadyHydrated := range fileHydrationState {
-		if alreadyHydrated {
-			continue
+		if !alreadyHydrated {
+			filesToDownload = append(filesToDownload, fileName)
 		}
+	}
 
-		destinationFile := filepath.Join(newSourceDir, fileName)
-
-		url := network.JoinURL(srcConfig.sourceURL, fileName)
+	failures := downloader.Download(ctx, filesToDownload, newSourceDir)
 
-		// Limit the number of concurrent network operations by pushing a struct{} into the channel. This will block until
-		// another operation completes and removes the struct{} from the channel.
-		if netOpsSemaphore != nil {
-			select {
-			case netOpsSemaphore <- struct{}{}:
-			case <-ctx.Done():
-				logger.Log.Debug("Cancellation signal received at network operation semaphore")
-				err = errPackerCancelReceived
-				return
+	for _, fileName := range filesToDownload {
+		downloadErr, failed := failures[fileName]
+		if failed {
+			// We may intentionally fail early due to a cancellation signal, stop immediately if that is the case.
+			if errors.Is(downloadErr, errPackerCancelReceived) {
+				return downloadErr
 			}
-		}
-
-		if srcConfig.sourceAuthMode == sourceAuthModeAzureCli {
-			cancelled, internalErr = azureblobstorage.DownloadFileWithRetry(ctx, azureBlobStorageClient, url, destinationFile, network.DefaultTimeout)
-		} else {
-			cancelled, internalErr = network.DownloadFileWithRetry(ctx, url, destinationFile, srcConfig.caCerts, srcConfig.tlsCerts, network.DefaultTimeout)
-		}
-
-		if netOpsSemaphore != nil {
-			// Clear the channel to allow another operation to start
-			<-netOpsSemaphore
-		}
 
-		// We may intentionally fail early due to a cancellation signal, stop immediately if that is the case.
-		if cancelled {
-			err = errPackerCancelReceived
-			return
-		}
-
-		if internalErr != nil {
-			logger.Log.Errorf("Failed to download (%s). Error: %s.", url, internalErr)
+			logger.Log.Errorf("Failed to download (%s). Error: %s.", fileName, downloadErr)
 			continue
 		}
 
+		destinationFile := filepath.Join(newSourceDir, fileName)
 		if !skipSignatureHandling {
-			internalErr = validateSignature(destinationFile, srcConfig, currentSignatures)
+			internalErr := validateSignature(destinationFile, srcConfig, currentSignatures)
 			if internalErr != nil {
 				logger.Log.Errorf("Signature validation for (%s) failed. Error: %s.", destinationFile, internalErr)
 
@@ -1081,14 +1144,14 @@ func hydrateFromRemoteSource(ctx context.Context, fileHydrationState map[string]
 		}
 
 		fileHydrationState[fileName] = true
-		logger.Log.Debugf("Hydrated (%s) from (%s)", fileName, url)
+		logger.Log.Debugf("Hydrated (%s)", fileName)
 	}
 
 	return nil
 }
 
 // validateSignature will compare the SHA256 of the file at path against the signature for it in srcConfig.signatureLookup
-// Will skip if signature handling is set to skip.
+// Will skip if signature handling is set to skip, and only warn about missing or mismatching signatures if it is set to warn.
 // Will alter `currentSignatures`.
 func validateSignature(path string, srcConfig sourceRetrievalConfiguration, currentSignatures map[string]string) (err error) {
 	if srcConfig.signatureHandling == signatureSkipCheck {
@@ -1097,6 +1160,10 @@ func validateSignature(path string, srcConfig sourceRetrievalConfiguration, curr
 
 	fileName := filepath.Base(path)
 	expectedSignature, found := srcConfig.signatureLookup[fileName]
+	if !found && srcConfig.signatureHandling == signatureWarn {
+		logger.Log.Warnf("No signature for file (%s) found. full path is (%s)", fileName, path)
+		return
+	}
 	if !found && srcConfig.signatureHandling != signatureUpdate {
 		err = fmt.Errorf("no signature for file (%s) found. full path is (%s)", fileName, path)
 		return
@@ -1110,10 +1177,13 @@ func validateSignature(path string, srcConfig sourceRetrievalConfiguration, curr
 	if strings.EqualFold(expectedSignature, newSignature) {
 		currentSignatures[fileName] = newSignature
 	} else {
-		if srcConfig.signatureHandling == signatureUpdate {
+		switch srcConfig.signatureHandling {
+		case signatureUpdate:
 			logger.Log.Warnf("Updating signature for (%s) from (%s) to (%s)", fileName, expectedSignature, newSignature)
 			currentSignatures[fileName] = newSignature
-		} else {
+		case signatureWarn:
+			logger.Log.Warnf("File (%s) has mismatching signature: expected (%s) - actual (%s)", path, expectedSignature, newSignature)
+		default:
 			return fmt.Errorf("file (%s) has mismatching signature: expected (%s) - actual (%s)", path, expectedSignature, newSignature)
 		}
 	}
@@ -31,6 +31,7 @@ var (
 
 	workerTar      = app.Flag("worker-chroot", "Full path to worker_chroot.tar.gz").Required().ExistingFile()
 	workerManifest = app.Flag("worker-manifest", "Full path to the worker manifest file").Required().ExistingFile()
+	rootless       = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 
 	logFlags = exe.SetupLogFlags(app)
 )
@@ -40,7 +41,12 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
-	err := validateWorker(*toolchainRpmsDir, *tmpDir, *workerTar, *workerManifest)
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
+	err = validateWorker(*toolchainRpmsDir, *tmpDir, *workerTar, *workerManifest)
 
 	if err != nil {
 		logger.Log.Fatalf("Failed to validate worker. Error: %s", err)
@@ -71,10 +77,15 @@ func validateWorker(rpmsDir, chrootDir, workerTarPath, manifestPath string) (err
 
 	logger.Log.Infof("Creating chroot environment to validate '%s' against '%s'", workerTarPath, manifestPath)
 
+	rpmMounts, err := safechroot.NewMountPointsFromSpecs([]safechroot.MountSpec{
+		{Source: rpmsDir, Target: chrootToolchainRpmsDir, Bind: true},
+	})
+	if err != nil {
+		return
+	}
+
 	chroot = safechroot.NewChroot(chrootDir, isExistingDir)
-	rpmMount := safechroot.NewMountPoint(rpmsDir, chrootToolchainRpmsDir, "", safechroot.BindMountPointFlags, "")
 	extraDirectories := []string{chrootToolchainRpmsDir}
-	rpmMounts := []*safechroot.MountPoint{rpmMount}
 	err = chroot.Initialize(workerTarPath, extraDirectories, rpmMounts, true)
 	if err != nil {
 		chroot = nil
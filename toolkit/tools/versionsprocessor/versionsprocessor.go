@@ -37,6 +37,7 @@ var (
 	distTag       = app.Flag("dist-tag", "The distribution tag the SPEC will be built with.").Required().String()
 	targetArch    = app.Flag("target-arch", "The architecture of the machine the RPM binaries run on").String()
 	buildDir      = app.Flag("build-dir", "Directory to store temporary files while parsing.").String()
+	rootless      = app.Flag("rootless", "Whether to run the worker chroot within a user namespace, so the tool doesn't need to be run as root.").Default(string(safechroot.RootlessModeNever)).Enum(safechroot.RootlessModes()...)
 	logFlags      = exe.SetupLogFlags(app)
 	profFlags     = exe.SetupProfileFlags(app)
 	workerTar     = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz.  If this argument is empty, specs will be parsed in the host environment.").ExistingFile()
@@ -54,6 +55,11 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(logFlags)
 
+	err := safechroot.EnterRootlessMode(safechroot.RootlessMode(*rootless))
+	if err != nil {
+		logger.Log.Fatalf("Failed to enter rootless mode:\n%s", err)
+	}
+
 	prof, err := profile.StartProfiling(profFlags)
 	if err != nil {
 		logger.Log.Warnf("Could not start profiling: %s", err)
@@ -228,7 +234,7 @@ func writeExtraFilesToOutput(extraFiles []string, macrosOutput []string, output
 		logger.Log.Infof("Appended contents of provided extra macros file (%s) to %s", extraPath, output)
 	}
 
-	err = file.WriteLines(macrosOutput, output)
+	err = file.WriteLinesAtomic(macrosOutput, output)
 	if err != nil {
 		logger.Log.Errorf("Failed to write file (%s)", output)
 		return err
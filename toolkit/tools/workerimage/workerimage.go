@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// A tool for publishing the worker chroot as a versioned OCI image.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/exe"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/logger"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/ociimage"
+	"github.com/microsoft/azurelinux/toolkit/tools/internal/shell"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	labelToolkitVersion = "com.microsoft.azurelinux.toolkit.version"
+	labelImageKind      = "com.microsoft.azurelinux.image.kind"
+	imageKindWorker     = "worker-chroot"
+)
+
+var (
+	app = kingpin.New("workerimage", "A tool for publishing the worker chroot as a versioned OCI image.")
+
+	workerTar = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz").Required().ExistingFile()
+	outputDir = app.Flag("output-dir", "OCI image layout directory to add the image to. Created if it doesn't exist.").Required().String()
+	tag       = app.Flag("tag", "Version tag of the image.").Required().String()
+	pushTo    = app.Flag("push", "Optional skopeo destination to also publish the image to (e.g. 'docker://<registry>/<repo>:<tag>').").String()
+
+	logFlags = exe.SetupLogFlags(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(logFlags)
+
+	err := publishWorkerImage(*workerTar, *outputDir, *tag, *pushTo)
+	if err != nil {
+		logger.Log.Fatalf("Failed to publish worker image:\n%v", err)
+	}
+}
+
+func publishWorkerImage(workerTar, outputDir, tag, pushTo string) (err error) {
+	labels := map[string]string{
+		labelToolkitVersion: exe.ToolkitVersion,
+		labelImageKind:      imageKindWorker,
+	}
+
+	digest, err := ociimage.WriteRootfsImage(workerTar, outputDir, tag, labels)
+	if err != nil {
+		return err
+	}
+
+	reference := ociimage.Reference{LayoutDir: outputDir, Tag: tag}
+	logger.Log.Infof("Wrote worker image (%s) with digest (%s)", reference, digest)
+
+	if pushTo == "" {
+		return nil
+	}
+
+	logger.Log.Infof("Pushing worker image to (%s)", pushTo)
+	err = shell.ExecuteLiveWithErr(1, "skopeo", "copy", "oci:"+reference.String(), pushTo)
+	if err != nil {
+		return fmt.Errorf("failed to push image to (%s):\n%w", pushTo, err)
+	}
+
+	return nil
+}